@@ -18,36 +18,54 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sort"
 	"syscall"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
-	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
 
+	"github.com/cectc/dbpack/pkg/advisor"
+	"github.com/cectc/dbpack/pkg/bootstrap"
 	"github.com/cectc/dbpack/pkg/config"
 	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/discovery"
 	"github.com/cectc/dbpack/pkg/driver"
+	_ "github.com/cectc/dbpack/pkg/driver/memory"
 	"github.com/cectc/dbpack/pkg/dt"
 	"github.com/cectc/dbpack/pkg/executor"
 	"github.com/cectc/dbpack/pkg/filter"
 	_ "github.com/cectc/dbpack/pkg/filter/audit_log"
 	_ "github.com/cectc/dbpack/pkg/filter/breaker"
+	_ "github.com/cectc/dbpack/pkg/filter/chaos"
 	_ "github.com/cectc/dbpack/pkg/filter/crypto"
 	_ "github.com/cectc/dbpack/pkg/filter/dt"
+	_ "github.com/cectc/dbpack/pkg/filter/dualwrite"
 	_ "github.com/cectc/dbpack/pkg/filter/metrics"
+	"github.com/cectc/dbpack/pkg/filter/plugin"
+	_ "github.com/cectc/dbpack/pkg/filter/quota"
 	_ "github.com/cectc/dbpack/pkg/filter/rate"
+	_ "github.com/cectc/dbpack/pkg/filter/script"
 	dbpackHttp "github.com/cectc/dbpack/pkg/http"
+	"github.com/cectc/dbpack/pkg/kubernetes"
 	"github.com/cectc/dbpack/pkg/listener"
 	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/meta"
+	"github.com/cectc/dbpack/pkg/preflight"
 	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/replay"
 	"github.com/cectc/dbpack/pkg/resource"
 	"github.com/cectc/dbpack/pkg/server"
+	"github.com/cectc/dbpack/pkg/standby"
 	"github.com/cectc/dbpack/pkg/tracing"
 	"github.com/cectc/dbpack/third_party/pools"
 	_ "github.com/cectc/dbpack/third_party/types/parser_driver"
@@ -82,66 +100,79 @@ var (
 				log.Fatal(err)
 			}
 
+			if conf.TableMetaCacheTTL > 0 {
+				meta.GetTableMetaCache().SetTTL(conf.TableMetaCacheTTL)
+			}
+
+			for _, path := range conf.Plugins {
+				if err := plugin.Load(path); err != nil {
+					log.Fatal(err)
+				}
+			}
+
+			dbpackHttp.SetReadiness(&conf.Readiness)
+
 			dbpack := server.NewServer()
+			var controllers []*kubernetes.Controller
 			for appid, dbpackConf := range conf.AppConfig {
 				for _, filterConf := range dbpackConf.Filters {
-					factory := filter.GetFilterFactory(filterConf.Kind)
-					if factory == nil {
-						log.Fatalf("there is no filter factory for filter: %s", filterConf.Kind)
-					}
-					f, err := factory.NewFilter(appid, filterConf.Config)
+					f, err := filter.NewFilter(appid, filterConf)
 					if err != nil {
-						log.Fatal(errors.Wrapf(err, "failed to create filter: %s", filterConf.Name))
+						log.Fatal(err)
 					}
 					filter.RegisterFilter(appid, filterConf.Name, f)
 				}
 
-				resource.RegisterDBManager(appid, dbpackConf.DataSources, func(dbName, dsn string) pools.Factory {
-					collector, err := driver.NewConnector(dbName, dsn)
-					if err != nil {
-						log.Fatal(err)
+				resource.RegisterDBManager(appid, dbpackConf.DataSources, backendConnectionFactory)
+				if conf.AutoMigrate.Enabled {
+					if err := bootstrap.MigrateApplication(context.Background(), &conf.AutoMigrate, dbpackConf); err != nil {
+						log.Fatalf("failed to migrate the tables of application %s: %v", appid, err)
 					}
-					return collector.NewBackendConnection
-				})
+				}
+				if conf.Preflight.Enabled {
+					results := preflight.Check(&conf.Preflight, dbpackConf)
+					if preflight.Failed(results) {
+						preflight.WriteReport(os.Stderr, results)
+						log.Fatalf("preflight checks of application %s failed", appid)
+					}
+				}
 
 				executors := make(map[string]proto.Executor)
 				for _, executorConf := range dbpackConf.Executors {
-					if executorConf.Mode == config.SDB {
-						executor, err := executor.NewSingleDBExecutor(executorConf)
-						if err != nil {
-							log.Fatal(err)
-						}
-						executors[executorConf.Name] = executor
+					executor, err := executor.NewExecutor(executorConf)
+					if err != nil {
+						log.Fatal(err)
 					}
-					if executorConf.Mode == config.RWS {
-						executor, err := executor.NewReadWriteSplittingExecutor(executorConf)
-						if err != nil {
-							log.Fatal(err)
-						}
-						executors[executorConf.Name] = executor
+					executors[executorConf.Name] = executor
+				}
+
+				var controller *kubernetes.Controller
+				if dbpackConf.Kubernetes != nil {
+					controller, err = kubernetes.NewController(dbpackConf, executors)
+					if err != nil {
+						log.Fatal(err)
 					}
-					if executorConf.Mode == config.SHD {
-						executor, err := executor.NewShardingExecutor(executorConf)
-						if err != nil {
-							log.Fatal(err)
-						}
-						executors[executorConf.Name] = executor
+					if err = controller.Sync(context.Background()); err != nil {
+						log.Fatal(err)
 					}
+					executors = controller.Executors()
+					controllers = append(controllers, controller)
 				}
 
 				for _, listenerConf := range dbpackConf.Listeners {
 					switch listenerConf.ProtocolType {
 					case config.Mysql:
-						listener, err := listener.NewMysqlListener(listenerConf)
+						mysqlListener, err := listener.NewMysqlListener(listenerConf)
 						if err != nil {
 							log.Fatalf("create mysql listener failed %v", err)
 						}
-						dbListener := listener.(proto.DBListener)
-						executor := executors[listenerConf.Executor]
-						if executor == nil {
-							log.Fatalf("executor: %s is not exists for mysql listener", listenerConf.Executor)
+						dbListener := mysqlListener.(proto.DBListener)
+						if err := listener.SetExecutors(dbListener, listenerConf, executors); err != nil {
+							log.Fatal(err)
+						}
+						if controller != nil {
+							controller.AddListener(listenerConf, dbListener)
 						}
-						dbListener.SetExecutor(executor)
 						dbpack.AddListener(dbListener)
 					case config.Http:
 						listener, err := listener.NewHttpListener(listenerConf)
@@ -149,6 +180,38 @@ var (
 							log.Fatalf("create http listener failed %v", err)
 						}
 						dbpack.AddListener(listener)
+					case config.Redis:
+						listener, err := listener.NewRedisListener(listenerConf)
+						if err != nil {
+							log.Fatalf("create redis listener failed %v", err)
+						}
+						dbpack.AddListener(listener)
+					case config.Grpc:
+						grpcListener, err := listener.NewGrpcListener(listenerConf)
+						if err != nil {
+							log.Fatalf("create grpc listener failed %v", err)
+						}
+						dbListener := grpcListener.(proto.DBListener)
+						if err := listener.SetExecutors(dbListener, listenerConf, executors); err != nil {
+							log.Fatal(err)
+						}
+						if controller != nil {
+							controller.AddListener(listenerConf, dbListener)
+						}
+						dbpack.AddListener(dbListener)
+					case config.Mysqlx:
+						mysqlxListener, err := listener.NewMysqlxListener(listenerConf)
+						if err != nil {
+							log.Fatalf("create mysqlx listener failed %v", err)
+						}
+						dbListener := mysqlxListener.(proto.DBListener)
+						if err := listener.SetExecutors(dbListener, listenerConf, executors); err != nil {
+							log.Fatal(err)
+						}
+						if controller != nil {
+							controller.AddListener(listenerConf, dbListener)
+						}
+						dbpack.AddListener(dbListener)
 					default:
 						log.Fatalf("unsupported %v listener protocol type", listenerConf.ProtocolType)
 					}
@@ -158,13 +221,29 @@ var (
 					dbpackHttp.AppendApplicationID(dbpackConf.AppID)
 					dt.RegisterTransactionManager(dbpackConf.DistributedTransaction)
 				}
+
+				if dbpackConf.ActiveStandby != nil {
+					standby.RegisterCoordinator(context.Background(), dbpackConf.ActiveStandby, dbpackConf.DataSources)
+				}
 			}
 
 			ctx, cancel := context.WithCancel(context.Background())
+			if conf.TableMetaRefreshInterval > 0 {
+				go meta.GetTableMetaCache().AutoRefresh(ctx, conf.TableMetaRefreshInterval)
+			}
+			for _, controller := range controllers {
+				go controller.Run(ctx)
+			}
 			c := make(chan os.Signal, 2)
 			signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 			go func() {
 				<-c
+				go func() {
+					// migrate the idle sessions before they are dropped
+					if _, err := listener.MigrateSessions(context.Background()); err != nil {
+						log.Errorf("migrate sessions failed: %v", err)
+					}
+				}()
 				go func() {
 					// cancel server after sleeping `TerminationDrainDuration`
 					// cancel asynchronously to avoid blocking the second term signal
@@ -198,12 +277,268 @@ var (
 			dbpack.Start(ctx)
 		},
 	}
+
+	replaySpeed float64
+	replayDSN   string
+
+	replayCommand = &cobra.Command{
+		Use:   "replay [capture files]",
+		Short: "replay the statements captured by a mysql listener against a target",
+		Args:  cobra.MinimumNArgs(1),
+
+		Run: func(cmd *cobra.Command, args []string) {
+			readers := make([]io.Reader, 0, len(args))
+			for _, path := range args {
+				file, err := os.Open(path)
+				if err != nil {
+					log.Fatalf("open capture file %s failed, %v", path, err)
+				}
+				defer file.Close()
+				readers = append(readers, file)
+			}
+
+			db, err := sql.Open("mysql", replayDSN)
+			if err != nil {
+				log.Fatalf("invalid replay target dsn, %v", err)
+			}
+			defer db.Close()
+
+			replayer, err := replay.NewReplayer(db, replaySpeed)
+			if err != nil {
+				log.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			c := make(chan os.Signal, 1)
+			signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+			go func() {
+				<-c
+				cancel()
+			}()
+
+			stats, err := replayer.Replay(ctx, replay.NewReader(io.MultiReader(readers...)))
+			if err != nil {
+				log.Errorf("replay stopped, %v", err)
+			}
+			fmt.Printf("statements: %d, errors: %d, diverged: %d\n", stats.Statements, stats.Errors, stats.Diverged)
+			fmt.Printf("target duration: %s, captured duration: %s, elapsed: %s\n",
+				stats.Duration, stats.CapturedDuration, stats.Elapsed)
+		},
+	}
+)
+
+var (
+	discoverDSN        string
+	discoverSchemas    []string
+	discoverSampleSize int
+	discoverThreshold  float64
+	discoverFormat     string
+
+	discoverCommand = &cobra.Command{
+		Use:   "discover",
+		Short: "report the columns likely holding personal data and suggest the crypto filter config of them",
+
+		Run: func(cmd *cobra.Command, args []string) {
+			db, err := sql.Open("mysql", discoverDSN)
+			if err != nil {
+				log.Fatalf("invalid discover target dsn, %v", err)
+			}
+			defer db.Close()
+
+			scanner, err := discovery.NewScanner(db, discoverSampleSize, discoverThreshold)
+			if err != nil {
+				log.Fatal(err)
+			}
+			findings, err := scanner.Scan(context.Background(), discoverSchemas)
+			if err != nil {
+				log.Fatal(err)
+			}
+			switch discoverFormat {
+			case "json":
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				err = encoder.Encode(findings)
+			default:
+				if err = discovery.WriteReport(os.Stdout, findings); err == nil && len(findings) > 0 {
+					fmt.Println()
+					err = discovery.WriteCryptoFilterStub(os.Stdout, findings)
+				}
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+)
+
+var (
+	adviseFormat string
+	adviseTop    int
+
+	adviseCommand = &cobra.Command{
+		Use:   "advise-sharding [capture files]",
+		Short: "recommend the sharding keys of the tables from the statements captured by a mysql listener",
+		Args:  cobra.MinimumNArgs(1),
+
+		Run: func(cmd *cobra.Command, args []string) {
+			store := advisor.NewStore()
+			for _, path := range args {
+				file, err := os.Open(path)
+				if err != nil {
+					log.Fatalf("open capture file %s failed, %v", path, err)
+				}
+				err = store.AddEvents(replay.NewReader(file))
+				file.Close()
+				if err != nil {
+					log.Fatalf("read capture file %s failed, %v", path, err)
+				}
+			}
+
+			report := advisor.Recommend(store.Digests())
+			var err error
+			switch adviseFormat {
+			case "json":
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				err = encoder.Encode(report)
+			default:
+				if err = advisor.WriteReport(os.Stdout, report, adviseTop); err == nil && len(report.Tables) > 0 {
+					fmt.Println()
+					err = advisor.WriteShardingRuleStub(os.Stdout, report, adviseTop)
+				}
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+)
+
+var (
+	preflightFormat string
+
+	preflightCommand = &cobra.Command{
+		Use:   "preflight",
+		Short: "check the connectivity, the privileges, the tables and the clocks of the data sources",
+
+		Run: func(cmd *cobra.Command, args []string) {
+			conf, err := config.Load(configPath)
+			if err != nil {
+				log.Fatal(err)
+			}
+			appids := make([]string, 0, len(conf.AppConfig))
+			for appid := range conf.AppConfig {
+				appids = append(appids, appid)
+			}
+			sort.Strings(appids)
+			var results []*preflight.Result
+			for _, appid := range appids {
+				dbpackConf := conf.AppConfig[appid]
+				resource.RegisterDBManager(appid, dbpackConf.DataSources, backendConnectionFactory)
+				results = append(results, preflight.Check(&conf.Preflight, dbpackConf)...)
+			}
+			switch preflightFormat {
+			case "json":
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				err = encoder.Encode(results)
+			default:
+				err = preflight.WriteReport(os.Stdout, results)
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+			if preflight.Failed(results) {
+				os.Exit(1)
+			}
+		},
+	}
+)
+
+var (
+	configCommand = &cobra.Command{
+		Use:   "config",
+		Short: "inspect the configuration of dbpack",
+	}
+
+	printEffectiveCommand = &cobra.Command{
+		Use: "print-effective",
+		Short: "print the configuration composed of the config file and its fragments, with the default values of the absent fields resolved, " +
+			"including the secrets of the configuration file, such as the passwords of the dsns",
+
+		Run: func(cmd *cobra.Command, args []string) {
+			conf, warnings, err := config.ParseFile(configPath)
+			if err != nil {
+				log.Fatal(err)
+			}
+			for _, warning := range warnings {
+				fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+			}
+			encoder := yaml.NewEncoder(os.Stdout)
+			encoder.SetIndent(2)
+			if err = encoder.Encode(conf); err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+
+	schemaCommand = &cobra.Command{
+		Use:   "schema",
+		Short: "print the json schema of the configuration file",
+
+		Run: func(cmd *cobra.Command, args []string) {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(config.Schema()); err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
 )
 
 // init Init startCmd
 func init() {
 	startCommand.PersistentFlags().StringVarP(&configPath, constant.ConfigPathKey, "c", os.Getenv(constant.EnvDBPackConfig), "Load configuration from `FILE`")
 	rootCommand.AddCommand(startCommand)
+
+	replayCommand.Flags().StringVar(&replayDSN, "dsn", "", "data source name of the target, a dbpack listener or a mysql server")
+	replayCommand.Flags().Float64Var(&replaySpeed, "speed", 1,
+		"speed relative to the captured one, 0 replays the statements as fast as possible")
+	replayCommand.MarkFlagRequired("dsn")
+	rootCommand.AddCommand(replayCommand)
+
+	discoverCommand.Flags().StringVar(&discoverDSN, "dsn", "", "data source name of a dbpack listener or a mysql server")
+	discoverCommand.Flags().StringSliceVar(&discoverSchemas, "schemas", nil, "schemas to scan, separated by commas")
+	discoverCommand.Flags().IntVar(&discoverSampleSize, "sample-size", discovery.DefaultSampleSize,
+		"number of rows sampled of each table, 0 detects the columns by their names only")
+	discoverCommand.Flags().Float64Var(&discoverThreshold, "threshold", discovery.DefaultThreshold,
+		"minimal ratio of the sampled values of a category for a column to be reported as it")
+	discoverCommand.Flags().StringVar(&discoverFormat, "format", "text", "output format, text or json")
+	discoverCommand.MarkFlagRequired("dsn")
+	discoverCommand.MarkFlagRequired("schemas")
+	rootCommand.AddCommand(discoverCommand)
+
+	adviseCommand.Flags().StringVar(&adviseFormat, "format", "text", "output format, text or json")
+	adviseCommand.Flags().IntVar(&adviseTop, "top", 3, "number of candidates reported of each table, 0 reports all of them")
+	rootCommand.AddCommand(adviseCommand)
+
+	preflightCommand.Flags().StringVarP(&configPath, constant.ConfigPathKey, "c", os.Getenv(constant.EnvDBPackConfig), "Load configuration from `FILE`")
+	preflightCommand.Flags().StringVar(&preflightFormat, "format", "text", "output format, text or json")
+	rootCommand.AddCommand(preflightCommand)
+
+	printEffectiveCommand.Flags().StringVarP(&configPath, constant.ConfigPathKey, "c", os.Getenv(constant.EnvDBPackConfig), "Load configuration from `FILE`")
+	configCommand.AddCommand(printEffectiveCommand)
+	configCommand.AddCommand(schemaCommand)
+	rootCommand.AddCommand(configCommand)
+}
+
+// backendConnectionFactory creates the connections of the connection pool of a data source.
+func backendConnectionFactory(dataSource *config.DataSource) pools.Factory {
+	collector, err := driver.NewConnector(dataSource.Name, dataSource.DSN, dataSource.TCP)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return collector.NewBackendConnection
 }
 
 func initServer(ctx context.Context, lis net.Listener) {
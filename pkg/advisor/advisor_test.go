@@ -0,0 +1,103 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package advisor
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/replay"
+)
+
+const capture = `
+{"time":"2022-08-01T10:00:00Z","connection_id":1,"schema":"employees","command":"query","sql":"select * from employees where emp_no = 1"}
+{"time":"2022-08-01T10:00:01Z","connection_id":1,"schema":"employees","command":"query","sql":"select * from employees where emp_no = 2"}
+{"time":"2022-08-01T10:00:02Z","connection_id":1,"schema":"employees","command":"execute","sql":"update employees set last_name = ? where emp_no in (?, ?)","args":["a",1,2]}
+{"time":"2022-08-01T10:00:03Z","connection_id":1,"schema":"employees","command":"query","sql":"select * from employees where hire_date > '2000-01-01' or emp_no = 1"}
+{"time":"2022-08-01T10:00:04Z","connection_id":1,"schema":"employees","command":"query","sql":"select s.salary from employees e join salaries s on e.emp_no = s.emp_no where e.emp_no = 3"}
+{"time":"2022-08-01T10:00:05Z","connection_id":1,"schema":"employees","command":"query","sql":"select * from salaries where from_date between '2000-01-01' and '2001-01-01'"}
+{"time":"2022-08-01T10:00:06Z","connection_id":1,"schema":"employees","command":"query","sql":"select * from employees where emp_no = 4","error":"Lost connection"}
+{"time":"2022-08-01T10:00:07Z","connection_id":1,"schema":"employees","command":"query","sql":"select * from employees where"}
+{"time":"2022-08-01T10:00:08Z","connection_id":1,"command":"quit"}
+`
+
+func TestStore(t *testing.T) {
+	store := NewStore()
+	assert.NoError(t, store.AddEvents(replay.NewReader(strings.NewReader(capture))))
+	digests := store.Digests()
+	assert.Len(t, digests, 6)
+	assert.Equal(t, uint64(2), digests[0].Count)
+	assert.Equal(t, "select * from employees where emp_no = 1", digests[0].Sample)
+	assert.Equal(t, "employees", digests[0].Schema)
+}
+
+func TestRecommend(t *testing.T) {
+	store := NewStore()
+	assert.NoError(t, store.AddEvents(replay.NewReader(strings.NewReader(capture))))
+	report := Recommend(store.Digests())
+	assert.Equal(t, uint64(7), report.Statements)
+	assert.Equal(t, uint64(1), report.Skipped)
+	assert.Len(t, report.Tables, 2)
+
+	employees := report.Tables[0]
+	assert.Equal(t, "employees", employees.Table)
+	assert.Equal(t, uint64(5), employees.Statements)
+	assert.Len(t, employees.Candidates, 1)
+	assert.Equal(t, &Candidate{
+		Column:     "emp_no",
+		Equality:   4,
+		Join:       1,
+		Score:      (4 + 0.5) / 5,
+		JoinedWith: []string{"employees.salaries.emp_no"},
+	}, employees.Candidates[0])
+
+	salaries := report.Tables[1]
+	assert.Equal(t, "salaries", salaries.Table)
+	assert.Equal(t, uint64(2), salaries.Statements)
+	assert.Len(t, salaries.Candidates, 2)
+	assert.Equal(t, "from_date", salaries.Candidates[1].Column)
+	assert.Equal(t, uint64(1), salaries.Candidates[1].Range)
+	assert.Equal(t, "emp_no", salaries.Candidates[0].Column)
+	assert.Equal(t, 0.25, salaries.Candidates[0].Score)
+}
+
+func TestRecommendCorrelatedSubquery(t *testing.T) {
+	store := NewStore()
+	store.Add("employees", "select * from employees e where exists "+
+		"(select 1 from salaries s where s.emp_no = e.emp_no and s.salary > 100)")
+	report := Recommend(store.Digests())
+	assert.Len(t, report.Tables, 2)
+	for _, table := range report.Tables {
+		assert.Equal(t, "emp_no", table.Candidates[0].Column)
+		assert.Equal(t, uint64(1), table.Candidates[0].Join)
+	}
+}
+
+func TestWriteShardingRuleStub(t *testing.T) {
+	store := NewStore()
+	store.Add("employees", "select * from employees where emp_no = 1")
+	store.Add("employees", "select * from dept_emp, departments where dept_emp.dept_no > 'd001' or departments.dept_name = 'a'")
+	var buf bytes.Buffer
+	assert.NoError(t, WriteShardingRuleStub(&buf, Recommend(store.Digests()), 3))
+	stub := buf.String()
+	assert.Contains(t, stub, "  - db_name: employees\n    table_name: employees\n")
+	assert.Contains(t, stub, "      column: emp_no\n")
+	assert.Contains(t, stub, "  # - db_name: employees\n  #   table_name: dept_emp\n")
+}
@@ -0,0 +1,403 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package advisor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cectc/dbpack/third_party/parser"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+	"github.com/cectc/dbpack/third_party/parser/opcode"
+	_ "github.com/cectc/dbpack/third_party/types/parser_driver"
+)
+
+// The weights of the predicates in the score of a column: an equality routes a statement to a
+// single shard, a join on the sharding keys of both tables keeps the join in each shard, and a
+// range only prunes the shards with the range algorithms.
+const (
+	equalityWeight = 1
+	joinWeight     = 0.5
+	rangeWeight    = 0.25
+)
+
+// Report is the sharding keys recommended for the tables.
+type Report struct {
+	// Statements is the count of the statements analyzed, Skipped the ones failing to parse
+	Statements uint64                 `json:"statements"`
+	Skipped    uint64                 `json:"skipped"`
+	Tables     []*TableRecommendation `json:"tables"`
+}
+
+// TableRecommendation is the candidate sharding keys of a table, the best first.
+type TableRecommendation struct {
+	Schema string `json:"schema"`
+	Table  string `json:"table"`
+	// Statements is the count of the queries, updates and deletes reading the table, the inserts
+	// are routed by the sharding key they carry whichever column it is
+	Statements uint64       `json:"statements"`
+	Candidates []*Candidate `json:"candidates"`
+}
+
+// Candidate is a column of a table filtered or joined by the statements.
+type Candidate struct {
+	Column string `json:"column"`
+	// Equality, Range and Join are the counts of the statements filtering the column by an
+	// equality or an in list, by a range, and joining the table on it
+	Equality uint64 `json:"equality"`
+	Range    uint64 `json:"range"`
+	Join     uint64 `json:"join"`
+	// Score is the weighted ratio of the statements of the table using the column
+	Score float64 `json:"score"`
+	// JoinedWith are the columns of the other tables joined on the column
+	JoinedWith []string `json:"joined_with,omitempty"`
+}
+
+type tableKey struct {
+	schema string
+	table  string
+}
+
+func (k tableKey) String() string {
+	return k.schema + "." + k.table
+}
+
+type columnKey struct {
+	tableKey
+	column string
+}
+
+// Recommend analyzes the digests and recommends the sharding keys of the tables they read.
+func Recommend(digests []*Digest) *Report {
+	a := &analyzer{
+		statements: make(map[tableKey]uint64),
+		candidates: make(map[columnKey]*Candidate),
+		joined:     make(map[columnKey]map[string]bool),
+	}
+	p := parser.New()
+	report := &Report{}
+	for _, d := range digests {
+		report.Statements += d.Count
+		stmts, _, err := p.Parse(d.Sample, "", "")
+		if err != nil {
+			report.Skipped += d.Count
+			continue
+		}
+		for _, stmt := range stmts {
+			a.analyze(d.Schema, stmt, d.Count)
+		}
+	}
+	report.Tables = a.recommendations()
+	return report
+}
+
+type analyzer struct {
+	statements map[tableKey]uint64
+	candidates map[columnKey]*Candidate
+	joined     map[columnKey]map[string]bool
+}
+
+// usage is the columns of the tables a statement uses, each counted once.
+type usage struct {
+	tables   map[tableKey]bool
+	equality map[columnKey]bool
+	ranges   map[columnKey]bool
+	joins    map[columnKey]bool
+}
+
+func (a *analyzer) analyze(schema string, stmt ast.StmtNode, count uint64) {
+	switch stmt.(type) {
+	case *ast.SelectStmt, *ast.SetOprStmt, *ast.InsertStmt, *ast.UpdateStmt, *ast.DeleteStmt:
+	default:
+		return
+	}
+	v := &blockVisitor{
+		analyzer: a,
+		schema:   schema,
+		usage: &usage{
+			tables:   make(map[tableKey]bool),
+			equality: make(map[columnKey]bool),
+			ranges:   make(map[columnKey]bool),
+			joins:    make(map[columnKey]bool),
+		},
+	}
+	stmt.Accept(v)
+
+	for table := range v.usage.tables {
+		a.statements[table] += count
+	}
+	for column := range v.usage.equality {
+		a.candidate(column).Equality += count
+	}
+	for column := range v.usage.ranges {
+		a.candidate(column).Range += count
+	}
+	for column := range v.usage.joins {
+		a.candidate(column).Join += count
+	}
+}
+
+func (a *analyzer) candidate(column columnKey) *Candidate {
+	c, ok := a.candidates[column]
+	if !ok {
+		c = &Candidate{Column: column.column}
+		a.candidates[column] = c
+	}
+	return c
+}
+
+func (a *analyzer) recommendations() []*TableRecommendation {
+	tables := make(map[tableKey]*TableRecommendation, len(a.statements))
+	for key, statements := range a.statements {
+		tables[key] = &TableRecommendation{Schema: key.schema, Table: key.table, Statements: statements}
+	}
+	for key, c := range a.candidates {
+		t, ok := tables[key.tableKey]
+		if !ok || t.Statements == 0 {
+			continue
+		}
+		c.Score = (equalityWeight*float64(c.Equality) + joinWeight*float64(c.Join) +
+			rangeWeight*float64(c.Range)) / float64(t.Statements)
+		for column := range a.joined[key] {
+			c.JoinedWith = append(c.JoinedWith, column)
+		}
+		sort.Strings(c.JoinedWith)
+		t.Candidates = append(t.Candidates, c)
+	}
+
+	result := make([]*TableRecommendation, 0, len(tables))
+	for _, t := range tables {
+		sort.Slice(t.Candidates, func(i, j int) bool {
+			if t.Candidates[i].Score != t.Candidates[j].Score {
+				return t.Candidates[i].Score > t.Candidates[j].Score
+			}
+			return t.Candidates[i].Column < t.Candidates[j].Column
+		})
+		result = append(result, t)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Statements != result[j].Statements {
+			return result[i].Statements > result[j].Statements
+		}
+		if result[i].Schema != result[j].Schema {
+			return result[i].Schema < result[j].Schema
+		}
+		return result[i].Table < result[j].Table
+	})
+	return result
+}
+
+// block is a query block, with the tables of its from clause by their names and aliases.
+type block struct {
+	tables []tableKey
+	names  map[string]tableKey
+}
+
+// blockVisitor analyzes the where and the join conditions of each query block of a statement,
+// the columns of the outer blocks are resolved for the correlated subqueries.
+type blockVisitor struct {
+	analyzer *analyzer
+	schema   string
+	usage    *usage
+	blocks   []*block
+}
+
+func (v *blockVisitor) Enter(in ast.Node) (ast.Node, bool) {
+	var (
+		refs  *ast.TableRefsClause
+		where ast.ExprNode
+	)
+	switch node := in.(type) {
+	case *ast.SelectStmt:
+		refs, where = node.From, node.Where
+	case *ast.UpdateStmt:
+		refs, where = node.TableRefs, node.Where
+	case *ast.DeleteStmt:
+		refs, where = node.TableRefs, node.Where
+	default:
+		return in, false
+	}
+
+	b := &block{names: make(map[string]tableKey)}
+	v.blocks = append(v.blocks, b)
+	var conditions []ast.ExprNode
+	if refs != nil && refs.TableRefs != nil {
+		conditions = v.collectTables(b, refs.TableRefs, conditions)
+	}
+	if where != nil {
+		conditions = append(conditions, where)
+	}
+	for _, condition := range conditions {
+		v.analyzeCondition(condition)
+	}
+	return in, false
+}
+
+func (v *blockVisitor) Leave(in ast.Node) (ast.Node, bool) {
+	switch in.(type) {
+	case *ast.SelectStmt, *ast.UpdateStmt, *ast.DeleteStmt:
+		v.blocks = v.blocks[:len(v.blocks)-1]
+	}
+	return in, true
+}
+
+// collectTables adds the tables of a from clause to b, and returns the join conditions of it
+// appended to conditions, the derived tables are blocks of their own.
+func (v *blockVisitor) collectTables(b *block, node ast.ResultSetNode, conditions []ast.ExprNode) []ast.ExprNode {
+	switch n := node.(type) {
+	case *ast.Join:
+		conditions = v.collectTables(b, n.Left, conditions)
+		if n.Right != nil {
+			conditions = v.collectTables(b, n.Right, conditions)
+		}
+		if n.On != nil {
+			conditions = append(conditions, n.On.Expr)
+		}
+	case *ast.TableSource:
+		t, ok := n.Source.(*ast.TableName)
+		if !ok {
+			break
+		}
+		key := tableKey{schema: v.schema, table: t.Name.L}
+		if t.Schema.L != "" {
+			key.schema = t.Schema.L
+		}
+		if isSystemSchema(key.schema) || key.table == "dual" {
+			break
+		}
+		b.tables = append(b.tables, key)
+		b.names[t.Name.L] = key
+		if n.AsName.L != "" {
+			b.names[n.AsName.L] = key
+		}
+		v.usage.tables[key] = true
+	}
+	return conditions
+}
+
+// analyzeCondition analyzes the conjuncts of a condition, the disjunctions route a statement
+// to as many shards as they have terms and are not counted.
+func (v *blockVisitor) analyzeCondition(expr ast.ExprNode) {
+	switch e := expr.(type) {
+	case *ast.ParenthesesExpr:
+		v.analyzeCondition(e.Expr)
+	case *ast.BinaryOperationExpr:
+		switch e.Op {
+		case opcode.LogicAnd:
+			v.analyzeCondition(e.L)
+			v.analyzeCondition(e.R)
+		case opcode.EQ, opcode.NullEQ:
+			left, leftOk := v.column(e.L)
+			right, rightOk := v.column(e.R)
+			switch {
+			case leftOk && rightOk:
+				if left.tableKey != right.tableKey {
+					v.usage.joins[left] = true
+					v.usage.joins[right] = true
+					v.join(left, right)
+				}
+			case leftOk && isConstant(e.R):
+				v.usage.equality[left] = true
+			case rightOk && isConstant(e.L):
+				v.usage.equality[right] = true
+			}
+		case opcode.LT, opcode.LE, opcode.GT, opcode.GE:
+			if column, ok := v.column(e.L); ok && isConstant(e.R) {
+				v.usage.ranges[column] = true
+			} else if column, ok := v.column(e.R); ok && isConstant(e.L) {
+				v.usage.ranges[column] = true
+			}
+		}
+	case *ast.PatternInExpr:
+		if e.Not || e.Sel != nil {
+			return
+		}
+		column, ok := v.column(e.Expr)
+		if !ok {
+			return
+		}
+		for _, item := range e.List {
+			if !isConstant(item) {
+				return
+			}
+		}
+		v.usage.equality[column] = true
+	case *ast.BetweenExpr:
+		if column, ok := v.column(e.Expr); ok && !e.Not && isConstant(e.Left) && isConstant(e.Right) {
+			v.usage.ranges[column] = true
+		}
+	}
+}
+
+func (v *blockVisitor) join(left, right columnKey) {
+	for _, pair := range [][2]columnKey{{left, right}, {right, left}} {
+		joined, ok := v.analyzer.joined[pair[0]]
+		if !ok {
+			joined = make(map[string]bool)
+			v.analyzer.joined[pair[0]] = joined
+		}
+		joined[fmt.Sprintf("%s.%s", pair[1].tableKey, pair[1].column)] = true
+	}
+}
+
+// column resolves a column to the table of the innermost block it references, the unqualified
+// columns are only resolved in the blocks of a single table, or skipping the blocks without
+// tables.
+func (v *blockVisitor) column(expr ast.ExprNode) (columnKey, bool) {
+	for {
+		parentheses, ok := expr.(*ast.ParenthesesExpr)
+		if !ok {
+			break
+		}
+		expr = parentheses.Expr
+	}
+	c, ok := expr.(*ast.ColumnNameExpr)
+	if !ok {
+		return columnKey{}, false
+	}
+	name := c.Name
+	for i := len(v.blocks) - 1; i >= 0; i-- {
+		b := v.blocks[i]
+		if name.Table.L == "" {
+			switch len(b.tables) {
+			case 0:
+				continue
+			case 1:
+				return columnKey{tableKey: b.tables[0], column: name.Name.L}, true
+			}
+			return columnKey{}, false
+		}
+		if key, ok := b.names[name.Table.L]; ok && (name.Schema.L == "" || name.Schema.L == key.schema) {
+			return columnKey{tableKey: key, column: name.Name.L}, true
+		}
+	}
+	return columnKey{}, false
+}
+
+func isConstant(expr ast.ExprNode) bool {
+	_, ok := expr.(ast.ValueExpr)
+	return ok
+}
+
+func isSystemSchema(schema string) bool {
+	switch strings.ToLower(schema) {
+	case "information_schema", "mysql", "performance_schema", "sys":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package advisor
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// WriteReport writes the top candidates of each table as a table.
+func WriteReport(w io.Writer, report *Report, top int) error {
+	fmt.Fprintf(w, "statements: %d, skipped: %d\n\n", report.Statements, report.Skipped)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SCHEMA\tTABLE\tSTATEMENTS\tCOLUMN\tEQUALITY\tRANGE\tJOIN\tSCORE\tJOINED WITH")
+	for _, t := range report.Tables {
+		if len(t.Candidates) == 0 {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t-\t-\t-\t-\t-\t-\n", t.Schema, t.Table, t.Statements)
+			continue
+		}
+		for i, c := range topCandidates(t, top) {
+			schema, table, statements := t.Schema, t.Table, fmt.Sprint(t.Statements)
+			if i > 0 {
+				schema, table, statements = "", "", ""
+			}
+			joined := "-"
+			if len(c.JoinedWith) > 0 {
+				joined = strings.Join(c.JoinedWith, ",")
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\t%d\t%.2f\t%s\n",
+				schema, table, statements, c.Column, c.Equality, c.Range, c.Join, c.Score, joined)
+		}
+	}
+	return tw.Flush()
+}
+
+// WriteShardingRuleStub writes the logic tables of a sharding config sharded by the best
+// candidate of each table, the tables without a candidate are commented out to be reviewed.
+func WriteShardingRuleStub(w io.Writer, report *Report, top int) error {
+	var sb strings.Builder
+	sb.WriteString("logic_tables:\n")
+	for _, t := range report.Tables {
+		sb.WriteString(fmt.Sprintf("  # %d statements\n", t.Statements))
+		if len(t.Candidates) == 0 {
+			sb.WriteString("  # no candidate, all the statements of the table scan all the shards\n")
+			sb.WriteString(fmt.Sprintf("  # - db_name: %s\n", t.Schema))
+			sb.WriteString(fmt.Sprintf("  #   table_name: %s\n", t.Table))
+			continue
+		}
+		best := t.Candidates[0]
+		if others := topCandidates(t, top)[1:]; len(others) > 0 {
+			scores := make([]string, 0, len(others))
+			for _, c := range others {
+				scores = append(scores, fmt.Sprintf("%s (%.2f)", c.Column, c.Score))
+			}
+			sb.WriteString(fmt.Sprintf("  # other candidates: %s\n", strings.Join(scores, ", ")))
+		}
+		if len(best.JoinedWith) > 0 {
+			sb.WriteString(fmt.Sprintf("  # joined with %s, shard them the same way to keep the joins in each shard\n",
+				strings.Join(best.JoinedWith, ", ")))
+		}
+		sb.WriteString(fmt.Sprintf("  - db_name: %s\n", t.Schema))
+		sb.WriteString(fmt.Sprintf("    table_name: %s\n", t.Table))
+		sb.WriteString("    allow_full_scan: false\n")
+		sb.WriteString("    sharding_rule:\n")
+		sb.WriteString(fmt.Sprintf("      # score %.2f: %d equality, %d range, %d join of %d statements\n",
+			best.Score, best.Equality, best.Range, best.Join, t.Statements))
+		sb.WriteString(fmt.Sprintf("      column: %s\n", best.Column))
+		sb.WriteString("      # NumberMod, NumberRange or DateRange, depending on the type and the distribution of the column\n")
+		sb.WriteString("      sharding_algorithm: NumberMod\n")
+		sb.WriteString("    # review the shards of the data sources\n")
+		sb.WriteString("    topology:\n")
+		sb.WriteString("      \"0\": 0-4\n")
+		sb.WriteString("      \"1\": 5-9\n")
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func topCandidates(t *TableRecommendation, top int) []*Candidate {
+	if top > 0 && len(t.Candidates) > top {
+		return t.Candidates[:top]
+	}
+	return t.Candidates
+}
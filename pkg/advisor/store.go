@@ -0,0 +1,91 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package advisor recommends the sharding keys of the tables from the statements the clients
+// sent, aggregated by digest from the captures of the mysql listeners.
+package advisor
+
+import (
+	"io"
+	"sort"
+
+	"github.com/cectc/dbpack/pkg/replay"
+	"github.com/cectc/dbpack/third_party/parser"
+)
+
+// Digest is the statements of a schema sharing the same normalized form.
+type Digest struct {
+	Digest     string `json:"digest"`
+	Schema     string `json:"schema"`
+	Normalized string `json:"normalized"`
+	// Sample is the first statement of the digest, it is analyzed on behalf of all of them
+	Sample string `json:"sample"`
+	Count  uint64 `json:"count"`
+}
+
+// Store aggregates the statements by digest.
+type Store struct {
+	digests map[string]*Digest
+}
+
+func NewStore() *Store {
+	return &Store{digests: make(map[string]*Digest)}
+}
+
+// Add aggregates a statement executed in schema.
+func (s *Store) Add(schema, sql string) {
+	normalized, digest := parser.NormalizeDigest(sql)
+	key := schema + "." + digest.String()
+	d, ok := s.digests[key]
+	if !ok {
+		d = &Digest{Digest: digest.String(), Schema: schema, Normalized: normalized, Sample: sql}
+		s.digests[key] = d
+	}
+	d.Count++
+}
+
+// AddEvents aggregates the statements read from a capture, the statements which failed are
+// skipped.
+func (s *Store) AddEvents(reader *replay.Reader) error {
+	for {
+		event, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if event.Command == replay.CommandQuit || event.Error != "" || event.SQL == "" {
+			continue
+		}
+		s.Add(event.Schema, event.SQL)
+	}
+}
+
+// Digests returns the digests, the most frequent first.
+func (s *Store) Digests() []*Digest {
+	result := make([]*Digest, 0, len(s.digests))
+	for _, d := range s.digests {
+		result = append(result, d)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Digest < result[j].Digest
+	})
+	return result
+}
@@ -0,0 +1,355 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package bootstrap creates and migrates the auxiliary tables of dbpack, the undo_log table of the
+// distributed transactions and the segment table of the segment sharding key generators. The version
+// of the tables of each database is kept in the dbpack_schema_version table, the migrations of a
+// database run under a named lock, so that the dbpack instances starting together migrate it once.
+package bootstrap
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/constant"
+	err2 "github.com/cectc/dbpack/pkg/errors"
+	"github.com/cectc/dbpack/pkg/log"
+	mysql2 "github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/resource"
+)
+
+const (
+	lockName = "dbpack_schema_migration"
+
+	createVersionTable = "CREATE TABLE IF NOT EXISTS `dbpack_schema_version` (" +
+		"`component` varchar(64) NOT NULL, " +
+		"`version` int NOT NULL, " +
+		"`description` varchar(255) NOT NULL DEFAULT '', " +
+		"`applied_at` datetime NOT NULL, " +
+		"PRIMARY KEY (`component`))"
+	selectVersion = "SELECT version FROM dbpack_schema_version WHERE component = '%s'"
+	upsertVersion = "INSERT INTO dbpack_schema_version (component, version, description, applied_at) " +
+		"VALUES ('%s', %d, '%s', NOW()) " +
+		"ON DUPLICATE KEY UPDATE version = VALUES(version), description = VALUES(description), applied_at = VALUES(applied_at)"
+
+	// CreateUndoLogTableSql is the ddl of the undo_log table, the same as docker/scripts/init.sql
+	CreateUndoLogTableSql = "CREATE TABLE IF NOT EXISTS `undo_log` (" +
+		"`id` bigint NOT NULL AUTO_INCREMENT, " +
+		"`branch_id` bigint NOT NULL, " +
+		"`xid` varchar(100) NOT NULL, " +
+		"`context` varchar(128) NOT NULL, " +
+		"`rollback_info` longblob NOT NULL, " +
+		"`log_status` int NOT NULL, " +
+		"`log_created` datetime NOT NULL, " +
+		"`log_modified` datetime NOT NULL, " +
+		"`ext` varchar(100) DEFAULT NULL, " +
+		"PRIMARY KEY (`id`))"
+	// CreateSegmentTableSql is the ddl of the segment table, the same as pkg/misc/uuid
+	CreateSegmentTableSql = "CREATE TABLE IF NOT EXISTS `segment` (" +
+		"`max_id` int NOT NULL DEFAULT '0', " +
+		"`step` int NOT NULL DEFAULT '1000', " +
+		"`business_id` varchar(50) NOT NULL DEFAULT '', " +
+		"PRIMARY KEY (`business_id`))"
+)
+
+// Migration changes the tables of a component from the previous version to Version.
+type Migration struct {
+	Version     int
+	Description string
+	// Statements are executed in order, they must be safe to execute again, since mysql commits ddl
+	// implicitly, a migration which fails halfway is executed again from its first statement.
+	Statements []string
+}
+
+// Component is a set of tables of dbpack migrated together.
+type Component struct {
+	Name string
+	// Migrations are ordered by their versions, which start from 1
+	Migrations []*Migration
+}
+
+// Latest returns the version of the last migration of the component.
+func (c *Component) Latest() int {
+	if len(c.Migrations) == 0 {
+		return 0
+	}
+	return c.Migrations[len(c.Migrations)-1].Version
+}
+
+var (
+	// UndoLog is the undo_log table the distributed transactions log the before and after images to
+	UndoLog = &Component{
+		Name: "undo_log",
+		Migrations: []*Migration{
+			{Version: 1, Description: "create undo_log", Statements: []string{CreateUndoLogTableSql}},
+			{Version: 2, Description: "index undo_log by xid and log_created", Statements: []string{
+				"ALTER TABLE `undo_log` ADD INDEX `idx_xid` (`xid`)",
+				"ALTER TABLE `undo_log` ADD INDEX `idx_log_created` (`log_created`)",
+			}},
+		},
+	}
+	// Segment is the segment table the segment sharding key generators allocate the ids from
+	Segment = &Component{
+		Name: "segment",
+		Migrations: []*Migration{
+			{Version: 1, Description: "create segment", Statements: []string{CreateSegmentTableSql}},
+			{Version: 2, Description: "widen segment max_id to bigint", Statements: []string{
+				"ALTER TABLE `segment` MODIFY `max_id` bigint NOT NULL DEFAULT '0'",
+			}},
+		},
+	}
+)
+
+// Conn is a connection to a database the migrations run on. The named lock is held by the session,
+// so all the statements must be executed on the same connection.
+type Conn interface {
+	Exec(ctx context.Context, query string) error
+	// QueryInt returns the integer in the first column of the first row, ok is false if there is
+	// no row or the value is NULL.
+	QueryInt(ctx context.Context, query string) (value int64, ok bool, err error)
+}
+
+// Migrate migrates the components on a database, it waits for lockTimeout if another instance is
+// migrating the database. A component whose tables are newer than this dbpack knows is left untouched.
+func Migrate(ctx context.Context, conn Conn, lockTimeout time.Duration, components ...*Component) error {
+	locked, ok, err := conn.QueryInt(ctx, fmt.Sprintf("SELECT GET_LOCK('%s', %d)", lockName, int64(lockTimeout.Seconds())))
+	if err != nil {
+		return errors.Wrap(err, "failed to get the migration lock")
+	}
+	if !ok || locked != 1 {
+		return errors.Errorf("failed to get the migration lock in %s, another instance may be migrating the database", lockTimeout)
+	}
+	defer func() {
+		if err := conn.Exec(ctx, fmt.Sprintf("DO RELEASE_LOCK('%s')", lockName)); err != nil {
+			log.Warnf("failed to release the migration lock, err: %v", err)
+		}
+	}()
+
+	if err = conn.Exec(ctx, createVersionTable); err != nil {
+		return errors.Wrap(err, "failed to create dbpack_schema_version")
+	}
+	for _, component := range components {
+		if err = migrate(ctx, conn, component); err != nil {
+			return errors.Wrapf(err, "failed to migrate %s", component.Name)
+		}
+	}
+	return nil
+}
+
+func migrate(ctx context.Context, conn Conn, component *Component) error {
+	current, _, err := conn.QueryInt(ctx, fmt.Sprintf(selectVersion, component.Name))
+	if err != nil {
+		return err
+	}
+	if int(current) > component.Latest() {
+		log.Warnf("%s is at version %d, newer than version %d of this dbpack, skip migrating it",
+			component.Name, current, component.Latest())
+		return nil
+	}
+	for _, migration := range component.Migrations {
+		if migration.Version <= int(current) {
+			continue
+		}
+		for _, statement := range migration.Statements {
+			if err = conn.Exec(ctx, statement); err != nil && !applied(err) {
+				return errors.Wrapf(err, "version %d", migration.Version)
+			}
+		}
+		if err = conn.Exec(ctx, fmt.Sprintf(upsertVersion, component.Name, migration.Version,
+			strings.ReplaceAll(migration.Description, "'", "''"))); err != nil {
+			return errors.Wrapf(err, "failed to record version %d", migration.Version)
+		}
+		log.Infof("%s migrated to version %d: %s", component.Name, migration.Version, migration.Description)
+	}
+	return nil
+}
+
+// applied reports whether a statement failed because its change is already there.
+func applied(err error) bool {
+	sqlErr, ok := errors.Cause(err).(*err2.SQLError)
+	return ok && (sqlErr.Num == constant.ERDupFieldName || sqlErr.Num == constant.ERDupKeyName)
+}
+
+// MigrateApplication migrates the undo_log table of the masters of an application enabling distributed
+// transactions, and the segment table of the segment sharding key generators of its executors. The data
+// sources must be registered to the db manager of the application.
+func MigrateApplication(ctx context.Context, conf *config.AutoMigrateConfig, appConf *config.DBPackConfig) error {
+	if appConf.DistributedTransaction != nil {
+		manager := resource.GetDBManager(appConf.AppID)
+		for _, dataSource := range appConf.DataSources {
+			if dataSource.MasterName != "" {
+				continue
+			}
+			db := manager.GetDB(dataSource.Name)
+			if db == nil {
+				return errors.Errorf("data source %s is not registered", dataSource.Name)
+			}
+			if err := migrateDB(ctx, conf, db); err != nil {
+				return errors.Wrapf(err, "data source %s", dataSource.Name)
+			}
+		}
+	}
+
+	dsns, err := segmentDSNs(appConf)
+	if err != nil {
+		return err
+	}
+	for _, dsn := range dsns {
+		if err = migrateDSN(ctx, conf, dsn); err != nil {
+			return errors.Wrap(err, "segment sharding key generator")
+		}
+	}
+	return nil
+}
+
+func migrateDB(ctx context.Context, conf *config.AutoMigrateConfig, db proto.DB) error {
+	tx, err := db.Pin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if _, err := tx.Commit(ctx); err != nil {
+			log.Warnf("failed to release the connection of data source %s, err: %v", db.Name(), err)
+		}
+	}()
+	return Migrate(ctx, &txConn{tx: tx}, conf.LockTimeout, UndoLog)
+}
+
+func migrateDSN(ctx context.Context, conf *config.AutoMigrateConfig, dsn string) error {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return Migrate(ctx, &sqlConn{conn: conn}, conf.LockTimeout, Segment)
+}
+
+// segmentDSNs returns the distinct dsns of the segment sharding key generators of the sharding executors.
+func segmentDSNs(appConf *config.DBPackConfig) ([]string, error) {
+	var dsns []string
+	seen := make(map[string]bool)
+	for _, executor := range appConf.Executors {
+		if executor.Mode != config.SHD {
+			continue
+		}
+		content, err := json.Marshal(executor.Config)
+		if err != nil {
+			return nil, err
+		}
+		shardingConfig := &config.ShardingConfig{}
+		if err = json.Unmarshal(content, shardingConfig); err != nil {
+			return nil, errors.Wrapf(err, "executor %s", executor.Name)
+		}
+		for _, table := range shardingConfig.LogicTables {
+			generator := table.ShardingKeyGenerator
+			if generator == nil || generator.Type != "segment" || seen[generator.DSN] {
+				continue
+			}
+			seen[generator.DSN] = true
+			dsns = append(dsns, generator.DSN)
+		}
+	}
+	return dsns, nil
+}
+
+// txConn runs the migrations on a pinned connection of a data source.
+type txConn struct {
+	tx proto.Tx
+}
+
+func (c *txConn) Exec(ctx context.Context, query string) error {
+	_, _, err := c.tx.ExecuteSqlDirectly(query)
+	return err
+}
+
+func (c *txConn) QueryInt(ctx context.Context, query string) (int64, bool, error) {
+	result, _, err := c.tx.QueryDirectly(query)
+	if err != nil {
+		return 0, false, err
+	}
+	rlt, ok := result.(*mysql2.Result)
+	if !ok {
+		return 0, false, errors.Errorf("unexpected result %T", result)
+	}
+	defer rlt.Release()
+	if len(rlt.Rows) == 0 {
+		return 0, false, nil
+	}
+	values, err := rlt.Rows[0].Decode()
+	if err != nil {
+		return 0, false, err
+	}
+	if len(values) == 0 || values[0] == nil || values[0].Val == nil {
+		return 0, false, nil
+	}
+	var text string
+	switch v := values[0].Val.(type) {
+	case []byte:
+		text = string(v)
+	default:
+		text = fmt.Sprint(v)
+	}
+	value, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	return value, true, nil
+}
+
+// sqlConn runs the migrations on a connection of database/sql, the errors of the driver are converted
+// to the sql errors of dbpack.
+type sqlConn struct {
+	conn *sql.Conn
+}
+
+func (c *sqlConn) Exec(ctx context.Context, query string) error {
+	_, err := c.conn.ExecContext(ctx, query)
+	return convertError(err)
+}
+
+func (c *sqlConn) QueryInt(ctx context.Context, query string) (int64, bool, error) {
+	var value sql.NullInt64
+	err := c.conn.QueryRowContext(ctx, query).Scan(&value)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, convertError(err)
+	}
+	return value.Int64, value.Valid, nil
+}
+
+func convertError(err error) error {
+	if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+		return err2.NewSQLError(int(mysqlErr.Number), "", "%s", mysqlErr.Message)
+	}
+	return err
+}
@@ -0,0 +1,140 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/constant"
+	err2 "github.com/cectc/dbpack/pkg/errors"
+)
+
+type testConn struct {
+	versions map[string]int64
+	errors   map[string]error
+	locked   bool
+	executed []string
+}
+
+func (c *testConn) Exec(ctx context.Context, query string) error {
+	if err, ok := c.errors[query]; ok {
+		return err
+	}
+	c.executed = append(c.executed, query)
+	for _, component := range []*Component{UndoLog, Segment} {
+		for _, migration := range component.Migrations {
+			if strings.Contains(query, fmt.Sprintf("VALUES ('%s', %d,", component.Name, migration.Version)) {
+				c.versions[component.Name] = int64(migration.Version)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *testConn) QueryInt(ctx context.Context, query string) (int64, bool, error) {
+	if query == "SELECT GET_LOCK('dbpack_schema_migration', 60)" || query == "SELECT GET_LOCK('dbpack_schema_migration', 1)" {
+		if c.locked {
+			return 0, true, nil
+		}
+		return 1, true, nil
+	}
+	for component, version := range c.versions {
+		if query == fmt.Sprintf(selectVersion, component) {
+			return version, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+func TestMigrate(t *testing.T) {
+	conn := &testConn{versions: map[string]int64{}}
+	assert.NoError(t, Migrate(context.Background(), conn, time.Minute, UndoLog, Segment))
+	assert.Equal(t, map[string]int64{"undo_log": 2, "segment": 2}, conn.versions)
+	assert.Equal(t, createVersionTable, conn.executed[0])
+	assert.Equal(t, CreateUndoLogTableSql, conn.executed[1])
+	assert.Equal(t, "DO RELEASE_LOCK('dbpack_schema_migration')", conn.executed[len(conn.executed)-1])
+
+	// migrated again, only the version table is created
+	conn.executed = nil
+	assert.NoError(t, Migrate(context.Background(), conn, time.Minute, UndoLog, Segment))
+	assert.Equal(t, []string{createVersionTable, "DO RELEASE_LOCK('dbpack_schema_migration')"}, conn.executed)
+}
+
+func TestMigrateExistingTable(t *testing.T) {
+	// the index was added by hand, the migration is recorded anyway
+	conn := &testConn{
+		versions: map[string]int64{"undo_log": 1},
+		errors: map[string]error{
+			UndoLog.Migrations[1].Statements[0]: err2.NewSQLError(constant.ERDupKeyName, "", "Duplicate key name 'idx_xid'"),
+		},
+	}
+	assert.NoError(t, Migrate(context.Background(), conn, time.Minute, UndoLog))
+	assert.Equal(t, int64(2), conn.versions["undo_log"])
+	assert.NotContains(t, conn.executed, CreateUndoLogTableSql)
+
+	conn = &testConn{
+		versions: map[string]int64{},
+		errors: map[string]error{
+			CreateUndoLogTableSql: err2.NewSQLError(constant.ERSpecifiedAccessDenied, "", "Access denied"),
+		},
+	}
+	assert.Error(t, Migrate(context.Background(), conn, time.Minute, UndoLog))
+	assert.NotContains(t, conn.versions, "undo_log")
+	assert.Equal(t, "DO RELEASE_LOCK('dbpack_schema_migration')", conn.executed[len(conn.executed)-1])
+}
+
+func TestMigrateNewerVersion(t *testing.T) {
+	conn := &testConn{versions: map[string]int64{"segment": 3}}
+	assert.NoError(t, Migrate(context.Background(), conn, time.Minute, Segment))
+	assert.Equal(t, int64(3), conn.versions["segment"])
+	assert.Equal(t, []string{createVersionTable, "DO RELEASE_LOCK('dbpack_schema_migration')"}, conn.executed)
+}
+
+func TestMigrateLocked(t *testing.T) {
+	conn := &testConn{versions: map[string]int64{}, locked: true}
+	assert.Error(t, Migrate(context.Background(), conn, time.Second, UndoLog))
+	assert.Empty(t, conn.executed)
+}
+
+func TestSegmentDSNs(t *testing.T) {
+	generator := func(dsn string) map[string]interface{} {
+		return map[string]interface{}{"type": "segment", "dsn": dsn}
+	}
+	appConf := &config.DBPackConfig{
+		Executors: []*config.Executor{
+			{Name: "redirect", Mode: config.SDB, Config: config.Parameters{"data_source_ref": "employees"}},
+			{Name: "shd", Mode: config.SHD, Config: config.Parameters{
+				"logic_tables": []interface{}{
+					map[string]interface{}{"table_name": "a", "sharding_key_generator": generator("root@tcp(db1)/seq")},
+					map[string]interface{}{"table_name": "b", "sharding_key_generator": generator("root@tcp(db1)/seq")},
+					map[string]interface{}{"table_name": "c", "sharding_key_generator": map[string]interface{}{"type": "snowflake"}},
+					map[string]interface{}{"table_name": "d", "sharding_key_generator": generator("root@tcp(db2)/seq")},
+				},
+			}},
+		},
+	}
+	dsns, err := segmentDSNs(appConf)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"root@tcp(db1)/seq", "root@tcp(db2)/seq"}, dsns)
+}
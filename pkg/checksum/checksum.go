@@ -0,0 +1,613 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package checksum verifies that the tables of two data sources have the same rows, such as the
+// old and the new data source of a migration or a master and its replica, the way
+// pt-table-checksum does: each table is walked in chunks of its primary key, and the row count
+// and the BIT_XOR of the CRC32 of the rows of a chunk are compared between the data sources.
+//
+// The chunks are checked on the data sources one after another rather than in a snapshot, so a
+// chunk written during the check, or not yet replicated to a replica, may be reported mismatched,
+// the mismatched chunks should be checked again before they are repaired.
+package checksum
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/atomic"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+const (
+	StatePending  = "pending"
+	StateRunning  = "running"
+	StateDone     = "done"
+	StateFailed   = "failed"
+	StateCanceled = "canceled"
+
+	defaultChunkSize = 1000
+)
+
+var (
+	checksLock sync.Mutex
+	checks     = make(map[string]*Check)
+	sequence   = atomic.NewInt64(0)
+)
+
+// DataSource is a data source the tables are checked on, proto.DB implements it.
+type DataSource interface {
+	Name() string
+	Query(ctx context.Context, query string) (proto.Result, uint16, error)
+}
+
+// Config is the config of a check.
+type Config struct {
+	// Tables are the names of the tables checked, they have the same name on both data sources
+	Tables []string
+	// ChunkSize is the count of rows of the source checked by a statement, 1000 by default
+	ChunkSize int
+	// ChunkInterval is the pause between the chunks, it throttles the check
+	ChunkInterval time.Duration
+	// Repair generates the statements making the rows of the mismatched chunks of the target
+	// the same as the source, they are reported but not executed
+	Repair bool
+}
+
+// Check is a verification of the tables of a target data source against a source data source.
+type Check struct {
+	id        string
+	source    DataSource
+	target    DataSource
+	conf      Config
+	tables    []*table
+	startedAt time.Time
+	cancel    context.CancelFunc
+	done      chan struct{}
+
+	lock       sync.Mutex
+	state      string
+	finishedAt time.Time
+	err        error
+	mismatches []*Mismatch
+}
+
+// Status is the progress and the mismatched chunks of a check.
+type Status struct {
+	ID         string         `json:"id"`
+	Source     string         `json:"source"`
+	Target     string         `json:"target"`
+	State      string         `json:"state"`
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	Tables     []*TableStatus `json:"tables"`
+	Mismatches []*Mismatch    `json:"mismatches"`
+}
+
+// TableStatus is the progress of a table of a check.
+type TableStatus struct {
+	Table             string `json:"table"`
+	State             string `json:"state"`
+	Error             string `json:"error,omitempty"`
+	ChunksChecked     int64  `json:"chunks_checked"`
+	ChunksMismatched  int64  `json:"chunks_mismatched"`
+	RowsCheckedSource int64  `json:"rows_checked_source"`
+}
+
+// Mismatch is a chunk having different rows on the data sources, the chunk is the rows of the
+// primary key greater than Lower and not greater than Upper, an empty bound is unbounded.
+type Mismatch struct {
+	Table          string `json:"table"`
+	Lower          string `json:"lower,omitempty"`
+	Upper          string `json:"upper,omitempty"`
+	SourceRows     int64  `json:"source_rows"`
+	TargetRows     int64  `json:"target_rows"`
+	SourceChecksum string `json:"source_checksum"`
+	TargetChecksum string `json:"target_checksum"`
+	// Repairs are the statements making the chunk of the target the same as the source
+	Repairs []string `json:"repairs,omitempty"`
+}
+
+// Start starts checking the tables of target against source.
+func Start(source, target DataSource, conf *Config) (*Check, error) {
+	if source == nil || target == nil {
+		return nil, errors.New("checksum needs a source and a target data source")
+	}
+	if conf == nil || len(conf.Tables) == 0 {
+		return nil, errors.New("checksum needs tables to check")
+	}
+	c := &Check{
+		source:    source,
+		target:    target,
+		conf:      *conf,
+		startedAt: time.Now(),
+		done:      make(chan struct{}),
+		state:     StatePending,
+	}
+	if c.conf.ChunkSize <= 0 {
+		c.conf.ChunkSize = defaultChunkSize
+	}
+	for _, name := range conf.Tables {
+		c.tables = append(c.tables, &table{
+			name:          name,
+			state:         StatePending,
+			chunksChecked: atomic.NewInt64(0),
+			mismatched:    atomic.NewInt64(0),
+			rowsChecked:   atomic.NewInt64(0),
+		})
+	}
+
+	checksLock.Lock()
+	c.id = fmt.Sprintf("%s-%s-%d", source.Name(), target.Name(), sequence.Inc())
+	checks[c.id] = c
+	checksLock.Unlock()
+
+	var ctx context.Context
+	ctx, c.cancel = context.WithCancel(context.Background())
+	go c.run(ctx)
+	return c, nil
+}
+
+// Get returns the check of id.
+func Get(id string) (*Check, bool) {
+	checksLock.Lock()
+	defer checksLock.Unlock()
+	c, ok := checks[id]
+	return c, ok
+}
+
+// List returns the status of the checks, ordered by the start time.
+func List() []*Status {
+	checksLock.Lock()
+	all := make([]*Check, 0, len(checks))
+	for _, c := range checks {
+		all = append(all, c)
+	}
+	checksLock.Unlock()
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].startedAt.Before(all[j].startedAt)
+	})
+	result := make([]*Status, 0, len(all))
+	for _, c := range all {
+		result = append(result, c.Status())
+	}
+	return result
+}
+
+// ID returns the id of the check.
+func (c *Check) ID() string {
+	return c.id
+}
+
+// Wait waits for the check to finish and returns the error of it, the check goes on if ctx is
+// done first.
+func (c *Check) Wait(ctx context.Context) error {
+	select {
+	case <-c.done:
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		return c.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Cancel stops the check, the chunks checked are still reported.
+func (c *Check) Cancel() {
+	c.cancel()
+}
+
+// Status returns the progress and the mismatched chunks of the check.
+func (c *Check) Status() *Status {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	status := &Status{
+		ID:         c.id,
+		Source:     c.source.Name(),
+		Target:     c.target.Name(),
+		State:      c.state,
+		StartedAt:  c.startedAt,
+		Mismatches: append([]*Mismatch{}, c.mismatches...),
+	}
+	if !c.finishedAt.IsZero() {
+		finishedAt := c.finishedAt
+		status.FinishedAt = &finishedAt
+	}
+	if c.err != nil {
+		status.Error = c.err.Error()
+	}
+	for _, t := range c.tables {
+		tableStatus := &TableStatus{
+			Table:             t.name,
+			State:             t.state,
+			ChunksChecked:     t.chunksChecked.Load(),
+			ChunksMismatched:  t.mismatched.Load(),
+			RowsCheckedSource: t.rowsChecked.Load(),
+		}
+		if t.err != nil {
+			tableStatus.Error = t.err.Error()
+		}
+		status.Tables = append(status.Tables, tableStatus)
+	}
+	return status
+}
+
+func (c *Check) setState(t *table, state string, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t.state, t.err = state, err
+}
+
+func (c *Check) addMismatch(m *Mismatch) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.mismatches = append(c.mismatches, m)
+}
+
+func (c *Check) run(ctx context.Context) {
+	defer close(c.done)
+	c.lock.Lock()
+	c.state = StateRunning
+	c.lock.Unlock()
+
+	var failed int
+	for _, t := range c.tables {
+		if ctx.Err() != nil {
+			break
+		}
+		c.setState(t, StateRunning, nil)
+		if err := c.check(ctx, t); err != nil {
+			if ctx.Err() != nil {
+				c.setState(t, StateCanceled, nil)
+				break
+			}
+			failed++
+			c.setState(t, StateFailed, err)
+			log.Errorf("checksum %s of table %s failed, %v", c.id, t.name, err)
+			continue
+		}
+		c.setState(t, StateDone, nil)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.finishedAt = time.Now()
+	switch {
+	case ctx.Err() != nil:
+		c.state, c.err = StateCanceled, errors.Errorf("checksum %s is canceled", c.id)
+		log.Warnf("checksum %s is canceled", c.id)
+	case failed > 0:
+		c.state, c.err = StateFailed, errors.Errorf("checksum of %d tables failed", failed)
+	default:
+		c.state = StateDone
+		log.Infof("checksum %s is done, %d chunks mismatched", c.id, len(c.mismatches))
+	}
+}
+
+type table struct {
+	name string
+	// state and err are guarded by the lock of the check
+	state string
+	err   error
+
+	chunksChecked *atomic.Int64
+	mismatched    *atomic.Int64
+	rowsChecked   *atomic.Int64
+}
+
+// chunk is the rows of the primary key greater than lower and not greater than upper, a nil
+// bound is unbounded.
+type chunk struct {
+	lower []byte
+	upper []byte
+}
+
+func (ch *chunk) where(primaryKey string) string {
+	var conditions []string
+	if ch.lower != nil {
+		conditions = append(conditions, fmt.Sprintf("%s > %s", primaryKey, literal(ch.lower)))
+	}
+	if ch.upper != nil {
+		conditions = append(conditions, fmt.Sprintf("%s <= %s", primaryKey, literal(ch.upper)))
+	}
+	if len(conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
+
+// check walks the primary key of the source in chunks and compares each chunk, the first chunk
+// is unbounded below and the last one unbounded above, so the rows only on the target are in a
+// chunk as well.
+func (c *Check) check(ctx context.Context, t *table) error {
+	ctx = proto.WithCommandType(ctx, constant.ComQuery)
+	columns, primaryKey, err := columnsOf(ctx, c.source, t.name)
+	if err != nil {
+		return err
+	}
+	name, pk := quote(t.name), quote(primaryKey)
+	var lower []byte
+	for {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		query := fmt.Sprintf("SELECT %s FROM %s", pk, name)
+		if lower != nil {
+			query += fmt.Sprintf(" WHERE %s > %s", pk, literal(lower))
+		}
+		query += fmt.Sprintf(" ORDER BY %s LIMIT 1 OFFSET %d", pk, c.conf.ChunkSize-1)
+		rows, err := queryRows(ctx, c.source, query)
+		if err != nil {
+			return err
+		}
+		ch := &chunk{lower: lower}
+		if len(rows) > 0 {
+			ch.upper = toBytes(rows[0][0])
+		}
+		if err = c.compare(ctx, t, ch, columns, primaryKey); err != nil {
+			return err
+		}
+		if ch.upper == nil {
+			return nil
+		}
+		lower = ch.upper
+		if c.conf.ChunkInterval > 0 {
+			select {
+			case <-time.After(c.conf.ChunkInterval):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+func (c *Check) compare(ctx context.Context, t *table, ch *chunk, columns []string, primaryKey string) error {
+	quoted := make([]string, 0, len(columns))
+	isNull := make([]string, 0, len(columns))
+	for _, column := range columns {
+		quoted = append(quoted, quote(column))
+		isNull = append(isNull, fmt.Sprintf("ISNULL(%s)", quote(column)))
+	}
+	// the NULL flags tell NULL from the empty string, which CONCAT_WS skips and keeps respectively
+	query := fmt.Sprintf("SELECT COUNT(*), COALESCE(BIT_XOR(CRC32(CONCAT_WS('#', %s, CONCAT(%s)))), 0) FROM %s%s",
+		strings.Join(quoted, ", "), strings.Join(isNull, ", "), quote(t.name), ch.where(quote(primaryKey)))
+	sourceRows, sourceChecksum, err := checksumOf(ctx, c.source, query)
+	if err != nil {
+		return err
+	}
+	targetRows, targetChecksum, err := checksumOf(ctx, c.target, query)
+	if err != nil {
+		return errors.Wrapf(err, "checksum table %s of %s failed", t.name, c.target.Name())
+	}
+	t.chunksChecked.Inc()
+	t.rowsChecked.Add(sourceRows)
+	if sourceRows == targetRows && sourceChecksum == targetChecksum {
+		return nil
+	}
+	t.mismatched.Inc()
+	m := &Mismatch{
+		Table:          t.name,
+		Lower:          string(ch.lower),
+		Upper:          string(ch.upper),
+		SourceRows:     sourceRows,
+		TargetRows:     targetRows,
+		SourceChecksum: sourceChecksum,
+		TargetChecksum: targetChecksum,
+	}
+	log.Warnf("checksum %s of table %s mismatched, chunk (%s, %s], %d rows on %s and %d rows on %s",
+		c.id, t.name, m.Lower, m.Upper, sourceRows, c.source.Name(), targetRows, c.target.Name())
+	if c.conf.Repair {
+		if m.Repairs, err = c.repairs(ctx, t, ch, columns, primaryKey); err != nil {
+			return err
+		}
+	}
+	c.addMismatch(m)
+	return nil
+}
+
+// repairs compares the rows of a mismatched chunk and returns the statements making the target
+// the same as the source, the rows only on the target are deleted before the rows missing or
+// different on the target are replaced.
+func (c *Check) repairs(ctx context.Context, t *table, ch *chunk, columns []string, primaryKey string) ([]string, error) {
+	quoted := make([]string, 0, len(columns))
+	pkIndex := 0
+	for i, column := range columns {
+		quoted = append(quoted, quote(column))
+		if column == primaryKey {
+			pkIndex = i
+		}
+	}
+	name, pk := quote(t.name), quote(primaryKey)
+	query := fmt.Sprintf("SELECT %s FROM %s%s ORDER BY %s", strings.Join(quoted, ", "), name, ch.where(pk), pk)
+	sourceRows, err := queryRows(ctx, c.source, query)
+	if err != nil {
+		return nil, err
+	}
+	targetRows, err := queryRows(ctx, c.target, query)
+	if err != nil {
+		return nil, err
+	}
+	sourceKeys := make(map[string]bool, len(sourceRows))
+	for _, row := range sourceRows {
+		sourceKeys[string(toBytes(row[pkIndex]))] = true
+	}
+	targetByKey := make(map[string][]interface{}, len(targetRows))
+	var repairs []string
+	for _, row := range targetRows {
+		key := toBytes(row[pkIndex])
+		targetByKey[string(key)] = row
+		if !sourceKeys[string(key)] {
+			repairs = append(repairs, fmt.Sprintf("DELETE FROM %s WHERE %s = %s", name, pk, literal(key)))
+		}
+	}
+	for _, row := range sourceRows {
+		if target, ok := targetByKey[string(toBytes(row[pkIndex]))]; ok && sameRow(row, target) {
+			continue
+		}
+		values := make([]string, 0, len(row))
+		for _, value := range row {
+			if value == nil {
+				values = append(values, "NULL")
+			} else {
+				values = append(values, literal(toBytes(value)))
+			}
+		}
+		repairs = append(repairs, fmt.Sprintf("REPLACE INTO %s (%s) VALUES (%s)", name,
+			strings.Join(quoted, ", "), strings.Join(values, ", ")))
+	}
+	return repairs, nil
+}
+
+func sameRow(a, b []interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if (a[i] == nil) != (b[i] == nil) || string(toBytes(a[i])) != string(toBytes(b[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+// columnsOf returns the columns of table on ds and the primary key of it, which must be a single column.
+func columnsOf(ctx context.Context, ds DataSource, table string) ([]string, string, error) {
+	rows, err := queryRows(ctx, ds, fmt.Sprintf("SELECT COLUMN_NAME, COLUMN_KEY FROM information_schema.COLUMNS "+
+		"WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = %s ORDER BY ORDINAL_POSITION", literal([]byte(table))))
+	if err != nil {
+		return nil, "", err
+	}
+	var (
+		columns     []string
+		primaryKeys []string
+	)
+	for _, row := range rows {
+		column := string(toBytes(row[0]))
+		columns = append(columns, column)
+		if string(toBytes(row[1])) == "PRI" {
+			primaryKeys = append(primaryKeys, column)
+		}
+	}
+	if len(columns) == 0 {
+		return nil, "", errors.Errorf("table %s does not exist on %s", table, ds.Name())
+	}
+	if len(primaryKeys) != 1 {
+		return nil, "", errors.Errorf("checksum needs a single column primary key, table %s has %d", table, len(primaryKeys))
+	}
+	return columns, primaryKeys[0], nil
+}
+
+func checksumOf(ctx context.Context, ds DataSource, query string) (int64, string, error) {
+	rows, err := queryRows(ctx, ds, query)
+	if err != nil {
+		return 0, "", err
+	}
+	if len(rows) == 0 {
+		return 0, "", errors.Errorf("checksum query on %s returned no rows", ds.Name())
+	}
+	var count int64
+	if _, err = fmt.Sscan(string(toBytes(rows[0][0])), &count); err != nil {
+		return 0, "", errors.Wrapf(err, "invalid row count %s", toBytes(rows[0][0]))
+	}
+	return count, string(toBytes(rows[0][1])), nil
+}
+
+// queryRows queries ds by the text protocol, so all the values are nil or byte slices, which are
+// copied as the result may be released.
+func queryRows(ctx context.Context, ds DataSource, query string) ([][]interface{}, error) {
+	result, _, err := ds.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	rlt, ok := result.(*mysql.Result)
+	if !ok {
+		return nil, errors.Errorf("unexpected result %T", result)
+	}
+	var rows [][]interface{}
+	for _, row := range rlt.Rows {
+		values, err := row.Decode()
+		if err != nil {
+			return nil, err
+		}
+		decoded := make([]interface{}, len(values))
+		for i, value := range values {
+			if value == nil || value.Val == nil {
+				continue
+			}
+			decoded[i] = append([]byte(nil), toBytes(value.Val)...)
+		}
+		rows = append(rows, decoded)
+	}
+	return rows, nil
+}
+
+func toBytes(value interface{}) []byte {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	default:
+		return []byte(fmt.Sprint(v))
+	}
+}
+
+// literal quotes a value as a string literal, or as a hex literal if it is not valid utf8.
+func literal(value []byte) string {
+	if !utf8.Valid(value) {
+		return "X'" + hex.EncodeToString(value) + "'"
+	}
+	var sb strings.Builder
+	sb.WriteByte('\'')
+	// the bytes of the multibyte utf8 characters are never ascii, so the value is escaped byte by byte
+	for _, b := range value {
+		switch b {
+		case 0:
+			sb.WriteString(`\0`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case 26:
+			sb.WriteString(`\Z`)
+		case '\'', '"', '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(b)
+		default:
+			sb.WriteByte(b)
+		}
+	}
+	sb.WriteByte('\'')
+	return sb.String()
+}
+
+func quote(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
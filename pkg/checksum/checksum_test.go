@@ -0,0 +1,183 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package checksum
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+// fakeDataSource answers the queries by its handler.
+type fakeDataSource struct {
+	name    string
+	handler func(sql string) (*mysql.Result, error)
+
+	lock    sync.Mutex
+	queries []string
+}
+
+func (ds *fakeDataSource) Name() string {
+	return ds.name
+}
+
+func (ds *fakeDataSource) Query(ctx context.Context, query string) (proto.Result, uint16, error) {
+	ds.lock.Lock()
+	ds.queries = append(ds.queries, query)
+	ds.lock.Unlock()
+	result, err := ds.handler(query)
+	if err != nil {
+		return nil, 0, err
+	}
+	return result, 0, nil
+}
+
+func (ds *fakeDataSource) Queries() []string {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	return append([]string(nil), ds.queries...)
+}
+
+func rows(columns []string, values ...[]interface{}) *mysql.Result {
+	fields := make([]*mysql.Field, 0, len(columns))
+	for _, column := range columns {
+		fields = append(fields, &mysql.Field{Name: column, FieldType: constant.FieldTypeVarString})
+	}
+	result := &mysql.Result{Fields: fields}
+	for _, row := range values {
+		rowValues := make([]*proto.Value, 0, len(row))
+		for _, value := range row {
+			if value == nil {
+				rowValues = append(rowValues, nil)
+				continue
+			}
+			rowValues = append(rowValues, &proto.Value{Typ: constant.FieldTypeVarString, Val: []byte(value.(string))})
+		}
+		result.Rows = append(result.Rows, mysql.NewTextRow(fields, rowValues))
+	}
+	return result
+}
+
+// tableHandler answers the queries of checking table t of 3 rows by chunks of 2 rows, chunks
+// are answered by their where clause.
+func tableHandler(chunks map[string]*mysql.Result, rowsOf map[string]*mysql.Result) func(sql string) (*mysql.Result, error) {
+	return func(sql string) (*mysql.Result, error) {
+		switch {
+		case strings.Contains(sql, "information_schema.COLUMNS"):
+			return rows([]string{"COLUMN_NAME", "COLUMN_KEY"}, []interface{}{"id", "PRI"}, []interface{}{"name", ""}), nil
+		case sql == "SELECT `id` FROM `t` ORDER BY `id` LIMIT 1 OFFSET 1":
+			return rows([]string{"id"}, []interface{}{"2"}), nil
+		case strings.Contains(sql, "LIMIT 1 OFFSET 1"):
+			return rows([]string{"id"}), nil
+		case strings.HasPrefix(sql, "SELECT COUNT(*)"):
+			return chunks[sql[strings.Index(sql, " FROM `t`"):]], nil
+		case strings.HasPrefix(sql, "SELECT `id`, `name` FROM"):
+			return rowsOf[sql[strings.Index(sql, " FROM `t`"):]], nil
+		}
+		return nil, errors.Errorf("unexpected query %s", sql)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	first, last := " FROM `t` WHERE `id` <= '2'", " FROM `t` WHERE `id` > '2'"
+	source := &fakeDataSource{name: "employees", handler: tableHandler(map[string]*mysql.Result{
+		first: rows([]string{"count", "checksum"}, []interface{}{"2", "1001"}),
+		last:  rows([]string{"count", "checksum"}, []interface{}{"1", "3003"}),
+	}, map[string]*mysql.Result{
+		last + " ORDER BY `id`": rows([]string{"id", "name"}, []interface{}{"3", "o'neil"}),
+	})}
+	target := &fakeDataSource{name: "employees_new", handler: tableHandler(map[string]*mysql.Result{
+		first: rows([]string{"count", "checksum"}, []interface{}{"2", "1001"}),
+		last:  rows([]string{"count", "checksum"}, []interface{}{"2", "4004"}),
+	}, map[string]*mysql.Result{
+		last + " ORDER BY `id`": rows([]string{"id", "name"}, []interface{}{"3", nil}, []interface{}{"4", "scott"}),
+	})}
+
+	c, err := Start(source, target, &Config{Tables: []string{"t"}, ChunkSize: 2, Repair: true})
+	assert.NoError(t, err)
+	assert.NoError(t, c.Wait(context.Background()))
+	assert.Equal(t, []string{
+		"SELECT COLUMN_NAME, COLUMN_KEY FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 't' ORDER BY ORDINAL_POSITION",
+		"SELECT `id` FROM `t` ORDER BY `id` LIMIT 1 OFFSET 1",
+		"SELECT COUNT(*), COALESCE(BIT_XOR(CRC32(CONCAT_WS('#', `id`, `name`, CONCAT(ISNULL(`id`), ISNULL(`name`))))), 0) FROM `t` WHERE `id` <= '2'",
+		"SELECT `id` FROM `t` WHERE `id` > '2' ORDER BY `id` LIMIT 1 OFFSET 1",
+		"SELECT COUNT(*), COALESCE(BIT_XOR(CRC32(CONCAT_WS('#', `id`, `name`, CONCAT(ISNULL(`id`), ISNULL(`name`))))), 0) FROM `t` WHERE `id` > '2'",
+		"SELECT `id`, `name` FROM `t` WHERE `id` > '2' ORDER BY `id`",
+	}, source.Queries())
+
+	status := c.Status()
+	assert.Equal(t, StateDone, status.State)
+	assert.Equal(t, []*TableStatus{
+		{Table: "t", State: StateDone, ChunksChecked: 2, ChunksMismatched: 1, RowsCheckedSource: 3},
+	}, status.Tables)
+	assert.Equal(t, []*Mismatch{{
+		Table:          "t",
+		Lower:          "2",
+		SourceRows:     1,
+		TargetRows:     2,
+		SourceChecksum: "3003",
+		TargetChecksum: "4004",
+		Repairs: []string{
+			"DELETE FROM `t` WHERE `id` = '4'",
+			"REPLACE INTO `t` (`id`, `name`) VALUES ('3', 'o\\'neil')",
+		},
+	}}, status.Mismatches)
+	check, ok := Get(c.ID())
+	assert.True(t, ok)
+	assert.Equal(t, c, check)
+}
+
+func TestCheckFailed(t *testing.T) {
+	source := &fakeDataSource{name: "master", handler: func(sql string) (*mysql.Result, error) {
+		return rows([]string{"COLUMN_NAME", "COLUMN_KEY"}, []interface{}{"id", "PRI"}, []interface{}{"dept", "PRI"}), nil
+	}}
+	target := &fakeDataSource{name: "replica", handler: func(sql string) (*mysql.Result, error) {
+		return nil, errors.New("unexpected query")
+	}}
+	c, err := Start(source, target, &Config{Tables: []string{"dept_emp"}})
+	assert.NoError(t, err)
+	assert.ErrorContains(t, c.Wait(context.Background()), "checksum of 1 tables failed")
+	status := c.Status()
+	assert.Equal(t, StateFailed, status.Tables[0].State)
+	assert.Contains(t, status.Tables[0].Error, "single column primary key")
+	assert.Empty(t, target.Queries())
+}
+
+func TestCheckCanceled(t *testing.T) {
+	chunks := map[string]*mysql.Result{
+		" FROM `t` WHERE `id` <= '2'": rows([]string{"count", "checksum"}, []interface{}{"2", "1001"}),
+	}
+	source := &fakeDataSource{name: "employees", handler: tableHandler(chunks, nil)}
+	target := &fakeDataSource{name: "employees_new", handler: tableHandler(chunks, nil)}
+	c, err := Start(source, target, &Config{Tables: []string{"t"}, ChunkSize: 2, ChunkInterval: time.Hour})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return c.Status().Tables[0].ChunksChecked == 1
+	}, time.Second, 10*time.Millisecond)
+	c.Cancel()
+	assert.ErrorContains(t, c.Wait(context.Background()), "is canceled")
+	assert.Equal(t, StateCanceled, c.Status().State)
+}
@@ -0,0 +1,189 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cond
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/misc"
+	"github.com/cectc/dbpack/pkg/misc/uuid"
+	"github.com/cectc/dbpack/pkg/topo"
+)
+
+const (
+	dateRangeRegex = `^([\d\-: ]+)~([\d\-: ]+)$`
+)
+
+var (
+	dateRangeRegexp = regexp.MustCompile(dateRangeRegex)
+)
+
+// DateRange shards by a datetime column, every table owns a half-open time range [From, To).
+// Range boundaries and sharding key values without explicit zone are interpreted in the
+// configured location, so clients in different time zones are routed to the same shard.
+type DateRange struct {
+	*NumberRange
+	location *time.Location
+}
+
+func NewDateRange(shardingKey string,
+	allowFullScan bool,
+	topology *topo.Topology,
+	config map[string]interface{},
+	location *time.Location,
+	generator uuid.Generator) (*DateRange, error) {
+	if location == nil {
+		location = time.Local
+	}
+	ranges, err := parseDateRangeConfig(config, location)
+	if err != nil {
+		return nil, err
+	}
+	return &DateRange{
+		NumberRange: &NumberRange{
+			shardingKey:   shardingKey,
+			allowFullScan: allowFullScan,
+			topology:      topology,
+			ranges:        ranges,
+			idGnerator:    generator,
+		},
+		location: location,
+	}, nil
+}
+
+func (shard *DateRange) Shard(condition *KeyCondition) (Condition, error) {
+	if !strings.EqualFold(shard.shardingKey, condition.Key) {
+		return TrueCondition{}, nil
+	}
+	unix, err := shard.normalize(condition)
+	if err != nil {
+		return nil, err
+	}
+	return shard.NumberRange.Shard(unix)
+}
+
+func (shard *DateRange) ShardRange(cond1, cond2 *KeyCondition) (Condition, error) {
+	if !strings.EqualFold(shard.shardingKey, cond1.Key) {
+		return TrueCondition{}, nil
+	}
+	unix1, err := shard.normalize(cond1)
+	if err != nil {
+		return nil, err
+	}
+	unix2, err := shard.normalize(cond2)
+	if err != nil {
+		return nil, err
+	}
+	return shard.NumberRange.ShardRange(unix1, unix2)
+}
+
+// Location returns the time zone used to compute the target shard.
+func (shard *DateRange) Location() *time.Location {
+	return shard.location
+}
+
+// normalize converts the condition value to a unix timestamp condition in the configured location
+func (shard *DateRange) normalize(condition *KeyCondition) (*KeyCondition, error) {
+	t, err := ParseTimeInLocation(condition.Value, shard.location)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyCondition{
+		Key:   condition.Key,
+		Op:    condition.Op,
+		Value: t.Unix(),
+	}, nil
+}
+
+// ParseTimeInLocation converts a sharding key value to time.Time, values carry no zone information
+// are interpreted in location, time.Time values are converted to location.
+func ParseTimeInLocation(value interface{}, location *time.Location) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v.In(location), nil
+	case *time.Time:
+		return v.In(location), nil
+	case []byte:
+		return parseTimeString(string(v), location)
+	case string:
+		return parseTimeString(v, location)
+	case int64:
+		return time.Unix(v, 0).In(location), nil
+	case int:
+		return time.Unix(int64(v), 0).In(location), nil
+	case uint64:
+		return time.Unix(int64(v), 0).In(location), nil
+	}
+	return time.Time{}, errors.Errorf("unsupported datetime sharding value %v of type %T", value, value)
+}
+
+func parseTimeString(value string, location *time.Location) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{misc.TimeFormat, "2006-01-02 15:04:05.999999", misc.DateFormat} {
+		if t, err := time.ParseInLocation(layout, value, location); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.Errorf("incorrect datetime value: %s", value)
+}
+
+func parseDateRangeConfig(config map[string]interface{}, location *time.Location) (map[int]*Range, error) {
+	result := make(map[int]*Range)
+	for key, value := range config {
+		rangeString, ok := value.(string)
+		if !ok {
+			return nil, errors.New("incorrect date range format")
+		}
+		dbIdx, err := strconv.Atoi(key)
+		if err != nil {
+			return nil, err
+		}
+		dateRange, err := parseDateRange(rangeString, location)
+		if err != nil {
+			return nil, err
+		}
+		result[dbIdx] = dateRange
+	}
+	return result, nil
+}
+
+func parseDateRange(rangeString string, location *time.Location) (*Range, error) {
+	params := dateRangeRegexp.FindStringSubmatch(rangeString)
+	if len(params) != 3 {
+		return nil, errors.Errorf("incorrect date range format")
+	}
+	begin, err := parseTimeString(params[1], location)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseTimeString(params[2], location)
+	if err != nil {
+		return nil, err
+	}
+	if !end.After(begin) {
+		return nil, errors.Errorf("incorrect date range format")
+	}
+	return &Range{
+		From: begin.Unix(),
+		To:   end.Unix(),
+	}, nil
+}
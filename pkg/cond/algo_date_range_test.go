@@ -0,0 +1,117 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cond
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/third_party/parser/opcode"
+)
+
+func TestParseDateRange(t *testing.T) {
+	shanghai := time.FixedZone("CST", 8*3600)
+	testcases := []struct {
+		rangeString string
+		location    *time.Location
+		expectRange *Range
+	}{
+		{
+			rangeString: "2022-01-01~2022-02-01",
+			location:    time.UTC,
+			expectRange: &Range{
+				From: 1640995200,
+				To:   1643673600,
+			},
+		},
+		{
+			rangeString: "2022-01-01~2022-02-01",
+			location:    shanghai,
+			expectRange: &Range{
+				From: 1640995200 - 8*3600,
+				To:   1643673600 - 8*3600,
+			},
+		},
+		{
+			rangeString: "2022-01-01 12:00:00~2022-02-01 12:00:00",
+			location:    time.UTC,
+			expectRange: &Range{
+				From: 1640995200 + 12*3600,
+				To:   1643673600 + 12*3600,
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.rangeString, func(t *testing.T) {
+			dateRange, err := parseDateRange(tc.rangeString, tc.location)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expectRange, dateRange)
+		})
+	}
+}
+
+func TestDateRangeShard(t *testing.T) {
+	shanghai := time.FixedZone("CST", 8*3600)
+	alg, err := NewDateRange("gmt_create", false, mockTopology(), map[string]interface{}{
+		"0": "2022-01-01~2022-02-01",
+		"1": "2022-02-01~2022-03-01",
+	}, shanghai, nil)
+	assert.Nil(t, err)
+
+	testcases := []struct {
+		name   string
+		value  interface{}
+		expect Condition
+	}{
+		{
+			name:   "string in sharding time zone",
+			value:  "2022-02-01 00:30:00",
+			expect: TableIndexSliceCondition{1},
+		},
+		{
+			// 2022-01-31 16:30:00 UTC is 2022-02-01 00:30:00 in Shanghai
+			name:   "time with other time zone",
+			value:  time.Date(2022, 1, 31, 16, 30, 0, 0, time.UTC),
+			expect: TableIndexSliceCondition{1},
+		},
+		{
+			name:   "date",
+			value:  "2022-01-15",
+			expect: TableIndexSliceCondition{0},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			condition, err := alg.Shard(&KeyCondition{
+				Key:   "gmt_create",
+				Op:    opcode.EQ,
+				Value: tc.value,
+			})
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expect, condition)
+		})
+	}
+
+	_, err = alg.Shard(&KeyCondition{
+		Key:   "gmt_create",
+		Op:    opcode.EQ,
+		Value: "not a date",
+	})
+	assert.NotNil(t, err)
+}
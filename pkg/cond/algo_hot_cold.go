@@ -0,0 +1,160 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cond
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/misc/uuid"
+	"github.com/cectc/dbpack/pkg/topo"
+	"github.com/cectc/dbpack/third_party/parser/opcode"
+)
+
+const (
+	hotTable  = 0
+	coldTable = 1
+)
+
+// HotCold tiers a table by the age of a datetime column: the rows from the cutoff on are in the
+// hot table, table 0 of the topology on the primary cluster, and the older ones in the cold
+// table, table 1 on an archive cluster. The queries spanning the cutoff are sent to both tables
+// and their results are merged, which requires allow_full_scan.
+//
+// The cutoff is moved forward by copying the rows older than the new cutoff to the cold table,
+// updating the config, then deleting them from the hot table.
+type HotCold struct {
+	shardingKey   string
+	allowFullScan bool
+	topology      *topo.Topology
+	cutoff        time.Time
+	location      *time.Location
+	idGenerator   uuid.Generator
+}
+
+func NewHotCold(shardingKey string,
+	allowFullScan bool,
+	topology *topo.Topology,
+	config map[string]interface{},
+	location *time.Location,
+	generator uuid.Generator) (*HotCold, error) {
+	if location == nil {
+		location = time.Local
+	}
+	if topology.TableSliceLen != 2 {
+		return nil, errors.Errorf("topology of %s must have a hot table and a cold table", topology.TableName)
+	}
+	value, ok := config["cutoff"].(string)
+	if !ok {
+		return nil, errors.New("cutoff of HotCold should be a datetime string")
+	}
+	cutoff, err := parseTimeString(value, location)
+	if err != nil {
+		return nil, errors.Wrap(err, "incorrect cutoff")
+	}
+	return &HotCold{
+		shardingKey:   shardingKey,
+		allowFullScan: allowFullScan,
+		topology:      topology,
+		cutoff:        cutoff,
+		location:      location,
+		idGenerator:   generator,
+	}, nil
+}
+
+func (shard *HotCold) HasShardingKey(key string) bool {
+	return strings.EqualFold(shard.shardingKey, key)
+}
+
+func (shard *HotCold) Shard(condition *KeyCondition) (Condition, error) {
+	if !strings.EqualFold(shard.shardingKey, condition.Key) {
+		return TrueCondition{}, nil
+	}
+	hot, cold, err := shard.tiers(condition)
+	if err != nil {
+		return nil, err
+	}
+	return tableIndexes(hot, cold), nil
+}
+
+func (shard *HotCold) ShardRange(cond1, cond2 *KeyCondition) (Condition, error) {
+	if !strings.EqualFold(shard.shardingKey, cond1.Key) {
+		return TrueCondition{}, nil
+	}
+	hot1, cold1, err := shard.tiers(cond1)
+	if err != nil {
+		return nil, err
+	}
+	hot2, cold2, err := shard.tiers(cond2)
+	if err != nil {
+		return nil, err
+	}
+	return tableIndexes(hot1 && hot2, cold1 && cold2), nil
+}
+
+// tiers returns whether the rows matching condition may be in the hot and the cold table.
+func (shard *HotCold) tiers(condition *KeyCondition) (hot, cold bool, err error) {
+	if condition.Op == opcode.NE {
+		return true, true, nil
+	}
+	t, err := ParseTimeInLocation(condition.Value, shard.location)
+	if err != nil {
+		return false, false, err
+	}
+	switch condition.Op {
+	case opcode.EQ:
+		return !t.Before(shard.cutoff), t.Before(shard.cutoff), nil
+	case opcode.LT:
+		return t.After(shard.cutoff), true, nil
+	case opcode.LE:
+		return !t.Before(shard.cutoff), true, nil
+	case opcode.GT, opcode.GE:
+		return true, t.Before(shard.cutoff), nil
+	}
+	return true, true, nil
+}
+
+func (shard *HotCold) AllShards() Condition {
+	return TableIndexSliceCondition(shard.topology.TableSlice)
+}
+
+func (shard *HotCold) AllowFullScan() bool {
+	return shard.allowFullScan
+}
+
+func (shard *HotCold) NextID() (int64, error) {
+	return shard.idGenerator.NextID()
+}
+
+// Cutoff returns the time the rows are in the hot table from.
+func (shard *HotCold) Cutoff() time.Time {
+	return shard.cutoff
+}
+
+func tableIndexes(hot, cold bool) Condition {
+	switch {
+	case hot && cold:
+		return TableIndexSliceCondition{hotTable, coldTable}
+	case hot:
+		return TableIndexSliceCondition{hotTable}
+	case cold:
+		return TableIndexSliceCondition{coldTable}
+	}
+	return FalseCondition{}
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cond
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/topo"
+	"github.com/cectc/dbpack/pkg/visitor"
+	"github.com/cectc/dbpack/third_party/parser"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+	"github.com/cectc/dbpack/third_party/parser/opcode"
+)
+
+func mockHotCold(t *testing.T) *HotCold {
+	tp, err := topo.ParseTopology("orders", "orders", map[int]string{0: "0", 1: "1"})
+	assert.NoError(t, err)
+	alg, err := NewHotCold("created_at", false, tp, map[string]interface{}{
+		"cutoff": "2022-01-01",
+	}, time.UTC, nil)
+	assert.NoError(t, err)
+	return alg
+}
+
+func TestNewHotCold(t *testing.T) {
+	tp, _ := topo.ParseTopology("orders", "orders", map[int]string{0: "0", 1: "1"})
+	_, err := NewHotCold("created_at", false, tp, map[string]interface{}{}, time.UTC, nil)
+	assert.Error(t, err)
+	_, err = NewHotCold("created_at", false, tp, map[string]interface{}{"cutoff": "90d"}, time.UTC, nil)
+	assert.Error(t, err)
+	_, err = NewHotCold("created_at", false, mockTopology(), map[string]interface{}{"cutoff": "2022-01-01"},
+		time.UTC, nil)
+	assert.Error(t, err)
+
+	alg, err := NewShardingAlgorithm("HotCold", "created_at", false, tp,
+		map[string]interface{}{"cutoff": "2022-01-01 08:00:00"}, time.FixedZone("CST", 8*3600), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), alg.(*HotCold).Cutoff().UTC())
+}
+
+func TestHotColdShard(t *testing.T) {
+	alg := mockHotCold(t)
+	hot, cold, both := TableIndexSliceCondition{0}, TableIndexSliceCondition{1}, TableIndexSliceCondition{0, 1}
+	testCases := []struct {
+		op       opcode.Op
+		value    interface{}
+		expected Condition
+	}{
+		{op: opcode.EQ, value: "2022-01-01 00:00:00", expected: hot},
+		{op: opcode.EQ, value: "2021-12-31 23:59:59", expected: cold},
+		{op: opcode.EQ, value: time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC), expected: hot},
+		{op: opcode.NE, value: "2022-01-01", expected: both},
+		{op: opcode.LT, value: "2022-01-01", expected: cold},
+		{op: opcode.LT, value: "2022-01-01 00:00:00.5", expected: both},
+		{op: opcode.LE, value: "2022-01-01", expected: both},
+		{op: opcode.GT, value: "2022-01-01", expected: hot},
+		{op: opcode.GE, value: "2021-06-01", expected: both},
+	}
+	for _, c := range testCases {
+		condition, err := alg.Shard(&KeyCondition{Key: "created_at", Op: c.op, Value: c.value})
+		assert.NoError(t, err)
+		assert.Equal(t, c.expected, condition, "%s %v", c.op, c.value)
+	}
+
+	condition, err := alg.Shard(&KeyCondition{Key: "id", Op: opcode.EQ, Value: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, TrueCondition{}, condition)
+	_, err = alg.Shard(&KeyCondition{Key: "created_at", Op: opcode.EQ, Value: "yesterday"})
+	assert.Error(t, err)
+}
+
+func TestHotColdShardCondition(t *testing.T) {
+	alg := mockHotCold(t)
+	testCases := []struct {
+		sql      string
+		expected Condition
+	}{
+		{
+			sql:      "select * from orders where created_at >= '2022-02-01' and created_at < '2022-03-01'",
+			expected: TableIndexSliceCondition{0},
+		},
+		{
+			sql:      "select * from orders where created_at between '2021-01-01' and '2021-02-01'",
+			expected: TableIndexSliceCondition{1},
+		},
+		{
+			sql:      "select * from orders where created_at >= '2021-12-01' and created_at < '2022-02-01'",
+			expected: TableIndexSliceCondition{0, 1},
+		},
+		{
+			sql:      "select * from orders where created_at > '2022-02-01' and created_at < '2021-02-01'",
+			expected: TableIndexSliceCondition(nil),
+		},
+	}
+	p := parser.New()
+	for _, c := range testCases {
+		t.Run(c.sql, func(t *testing.T) {
+			stmt, err := p.ParseOneStmt(c.sql, "", "")
+			assert.NoError(t, err)
+			stmt.Accept(&visitor.ParamVisitor{})
+			condition, err := ParseCondition(stmt.(*ast.SelectStmt).Where)
+			assert.NoError(t, err)
+			shards, err := condition.(ConditionShard).Shard(alg)
+			assert.NoError(t, err)
+			assert.Equal(t, c.expected, shards)
+		})
+	}
+}
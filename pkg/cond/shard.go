@@ -219,14 +219,14 @@ func And(alg ShardingAlgorithm, k1, k2 *KeyCondition) (Condition, error) {
 		switch k2.Op {
 		case opcode.EQ:
 			// a = 1 and a = 1 => a = 1
-			if k1.Value == k2.Value {
+			if misc.Compare(k1.Value, k2.Value) == 0 {
 				return k1.Shard(alg)
 			}
 			// a = 1 and a = 2 => always false
 			return FalseCondition{}, nil
 		case opcode.NE:
 			// a = 1 and a <> 1 => always false
-			if k1.Value == k2.Value {
+			if misc.Compare(k1.Value, k2.Value) == 0 {
 				return FalseCondition{}, nil
 			}
 			// a = 1 and a <> 2 => a = 1
@@ -271,7 +271,7 @@ func And(alg ShardingAlgorithm, k1, k2 *KeyCondition) (Condition, error) {
 	case opcode.NE:
 		switch k2.Op {
 		case opcode.EQ:
-			if k1.Value == k2.Value {
+			if misc.Compare(k1.Value, k2.Value) == 0 {
 				// a <> 1 and a = 1 -> always false
 				return FalseCondition{}, nil
 			}
@@ -26,6 +26,7 @@ import (
 	"github.com/cectc/dbpack/pkg/visitor"
 	"github.com/cectc/dbpack/third_party/parser"
 	"github.com/cectc/dbpack/third_party/parser/ast"
+	"github.com/cectc/dbpack/third_party/parser/opcode"
 )
 
 func TestNumberModShard(t *testing.T) {
@@ -87,6 +88,25 @@ func TestNumberModShard(t *testing.T) {
 	}
 }
 
+func TestAndEqualConditions(t *testing.T) {
+	var shardAlg = &NumberMod{
+		shardingKey: "uid",
+		topology:    mockTopology(),
+	}
+	// values bound by a prepared statement and parsed from sql text may be of different integer types
+	slice, err := And(shardAlg,
+		&KeyCondition{Key: "uid", Op: opcode.EQ, Value: 15},
+		&KeyCondition{Key: "uid", Op: opcode.EQ, Value: int64(15)})
+	assert.Nil(t, err)
+	assert.Equal(t, TableIndexSliceCondition{15}, slice)
+
+	slice, err = And(shardAlg,
+		&KeyCondition{Key: "uid", Op: opcode.EQ, Value: 15},
+		&KeyCondition{Key: "uid", Op: opcode.EQ, Value: int64(16)})
+	assert.Nil(t, err)
+	assert.Equal(t, FalseCondition{}, slice)
+}
+
 func TestNumberRangeShard(t *testing.T) {
 	var shardAlg = mockNumberRangeAlgorithm(t)
 
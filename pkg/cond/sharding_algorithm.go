@@ -17,6 +17,8 @@
 package cond
 
 import (
+	"time"
+
 	"github.com/pkg/errors"
 
 	"github.com/cectc/dbpack/pkg/misc/uuid"
@@ -33,12 +35,17 @@ type ShardingAlgorithm interface {
 }
 
 func NewShardingAlgorithm(algorithm, shardingKey string,
-	allowFullScan bool, topology *topo.Topology, config map[string]interface{}, generator uuid.Generator) (ShardingAlgorithm, error) {
+	allowFullScan bool, topology *topo.Topology, config map[string]interface{},
+	location *time.Location, generator uuid.Generator) (ShardingAlgorithm, error) {
 	switch algorithm {
 	case "NumberMod":
 		return NewNumberMod(shardingKey, allowFullScan, topology, generator), nil
 	case "NumberRange":
 		return NewNumberRange(shardingKey, allowFullScan, topology, config, generator)
+	case "DateRange":
+		return NewDateRange(shardingKey, allowFullScan, topology, config, location, generator)
+	case "HotCold":
+		return NewHotCold(shardingKey, allowFullScan, topology, config, location, generator)
 	}
 	return nil, errors.Errorf("unsupported sharding algorithm: %s", algorithm)
 }
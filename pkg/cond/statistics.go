@@ -0,0 +1,140 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cond
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cectc/dbpack/third_party/parser/opcode"
+)
+
+// ShardStatistics is the statistics of a physical table, MinKey and MaxKey are only valid when HasKeyRange is true.
+type ShardStatistics struct {
+	RowCount    int64
+	MinKey      int64
+	MaxKey      int64
+	HasKeyRange bool
+}
+
+// Observe widens the statistics with a new row, so that the row will not be pruned before next collection,
+// returns whether the statistics are widened.
+func (stats *ShardStatistics) Observe(key interface{}) bool {
+	defer func() {
+		stats.RowCount++
+	}()
+	val, err := ParseInt64(key)
+	if err != nil {
+		widened := stats.RowCount == 0 || stats.HasKeyRange
+		stats.HasKeyRange = false
+		return widened
+	}
+	if stats.RowCount == 0 {
+		stats.MinKey, stats.MaxKey, stats.HasKeyRange = val, val, true
+		return true
+	}
+	if !stats.HasKeyRange {
+		return false
+	}
+	if val < stats.MinKey {
+		stats.MinKey = val
+		return true
+	}
+	if val > stats.MaxKey {
+		stats.MaxKey = val
+		return true
+	}
+	return false
+}
+
+// MayMatch reports whether the shard may contain rows matching the condition on the sharding key.
+func (stats *ShardStatistics) MayMatch(shardingKey string, condition Condition) bool {
+	if stats.RowCount == 0 {
+		return false
+	}
+	switch c := condition.(type) {
+	case FalseCondition:
+		return false
+	case *KeyCondition:
+		if !stats.HasKeyRange || !strings.EqualFold(c.Key, shardingKey) {
+			return true
+		}
+		val, err := ParseInt64(c.Value)
+		if err != nil {
+			return true
+		}
+		switch c.Op {
+		case opcode.EQ:
+			return stats.MinKey <= val && val <= stats.MaxKey
+		case opcode.LT:
+			return stats.MinKey < val
+		case opcode.LE:
+			return stats.MinKey <= val
+		case opcode.GT:
+			return stats.MaxKey > val
+		case opcode.GE:
+			return stats.MaxKey >= val
+		}
+		return true
+	case *ComplexCondition:
+		if c.Op == opcode.And {
+			for _, cd := range c.Conditions {
+				if !stats.MayMatch(shardingKey, cd) {
+					return false
+				}
+			}
+			return true
+		}
+		for _, cd := range c.Conditions {
+			if stats.MayMatch(shardingKey, cd) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// PruneShards removes the shards which can not contain any rows matching the condition according to the statistics,
+// shards without statistics are kept.
+func PruneShards(shards TableIndexSliceCondition, shardingKey string, condition Condition,
+	statistics map[int]*ShardStatistics) TableIndexSliceCondition {
+	if len(statistics) == 0 {
+		return shards
+	}
+	result := make(TableIndexSliceCondition, 0, len(shards))
+	for _, index := range shards {
+		stats, ok := statistics[index]
+		if !ok || stats.MayMatch(shardingKey, condition) {
+			result = append(result, index)
+		}
+	}
+	return result
+}
+
+// ParseInt64 converts a sharding key value to int64.
+func ParseInt64(value interface{}) (int64, error) {
+	switch val := value.(type) {
+	case int64:
+		return val, nil
+	case []byte:
+		return strconv.ParseInt(string(val), 10, 64)
+	default:
+		return strconv.ParseInt(fmt.Sprintf("%v", val), 10, 64)
+	}
+}
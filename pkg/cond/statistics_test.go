@@ -0,0 +1,91 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cond
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/visitor"
+	"github.com/cectc/dbpack/third_party/parser"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+	"github.com/cectc/dbpack/third_party/parser/opcode"
+)
+
+func TestPruneShards(t *testing.T) {
+	statistics := map[int]*ShardStatistics{
+		0: {RowCount: 10, MinKey: 1, MaxKey: 100, HasKeyRange: true},
+		1: {RowCount: 10, MinKey: 101, MaxKey: 200, HasKeyRange: true},
+		2: {RowCount: 0},
+		3: {RowCount: 10},
+	}
+	shards := TableIndexSliceCondition{0, 1, 2, 3, 4}
+
+	testCases := []struct {
+		sql      string
+		args     []interface{}
+		expected TableIndexSliceCondition
+	}{
+		{
+			sql:      "select * from student where uid > ?",
+			args:     []interface{}{150},
+			expected: TableIndexSliceCondition{1, 3, 4},
+		},
+		{
+			sql:      "select * from student where uid between ? and ?",
+			args:     []interface{}{50, 80},
+			expected: TableIndexSliceCondition{0, 3, 4},
+		},
+		{
+			sql:      "select * from student where uid = ? or uid = ?",
+			args:     []interface{}{300, 0},
+			expected: TableIndexSliceCondition{3, 4},
+		},
+		{
+			sql:      "select * from student where age > ?",
+			args:     []interface{}{300},
+			expected: TableIndexSliceCondition{0, 1, 3, 4},
+		},
+		{
+			sql:      "select * from student where uid in (?, ?) and age = ?",
+			args:     []interface{}{1, 200, 18},
+			expected: TableIndexSliceCondition{0, 1, 3, 4},
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.sql, func(t *testing.T) {
+			p := parser.New()
+			stmt, err := p.ParseOneStmt(c.sql, "", "")
+			assert.Nil(t, err)
+			stmt.Accept(&visitor.ParamVisitor{})
+			condition, err := ParseCondition(stmt.(*ast.SelectStmt).Where, c.args...)
+			assert.Nil(t, err)
+			assert.Equal(t, c.expected, PruneShards(shards, "uid", condition, statistics))
+		})
+	}
+}
+
+func TestShardStatisticsObserve(t *testing.T) {
+	stats := &ShardStatistics{}
+	assert.False(t, stats.MayMatch("uid", &KeyCondition{Key: "uid", Op: opcode.EQ, Value: 10}))
+	assert.True(t, stats.Observe(int64(20)))
+	assert.True(t, stats.Observe("10"))
+	assert.False(t, stats.Observe(15))
+	assert.Equal(t, &ShardStatistics{RowCount: 3, MinKey: 10, MaxKey: 20, HasKeyRange: true}, stats)
+}
@@ -0,0 +1,250 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// fragmentDirs are the directories next to the config file holding the fragments of the config,
+// in the order they are merged, with the field of the application config their items belong to.
+// A fragment maps application ids to items, such as
+//
+//	svc:
+//	  - name: employees
+//	    dsn: root:123456@tcp(127.0.0.1:3306)/employees
+//
+// An item whose name is new is appended to the items of the application, otherwise it is merged
+// into the item of the same name: the fields it adds are added, the lists are concatenated, such
+// as the logic tables of a sharding executor managed by several teams, and a field having another
+// value fails the config.
+var fragmentDirs = []struct {
+	dir   string
+	field string
+}{
+	{"datasources.d", "data_source_cluster"},
+	{"filters.d", "filters"},
+	{"executors.d", "executors"},
+}
+
+// fragmentExtensions are the extensions of the fragment files, the other files are ignored
+var fragmentExtensions = []string{".yaml", ".yml"}
+
+// ParseFile parses the config file of path as Parse does, after merging the fragments of the
+// fragment directories next to it. The directories are merged in a fixed order, and the files of
+// a directory in the order of their names, so that the config composed is the same every time.
+func ParseFile(path string) (*Configuration, []*Warning, error) {
+	files := make(map[*yaml.Node]string)
+	node, err := readFile(path, files)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, fragmentDir := range fragmentDirs {
+		dir := filepath.Join(filepath.Dir(path), fragmentDir.dir)
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "[config] read fragment directory %s failed", dir)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !isFragment(entry.Name()) {
+				continue
+			}
+			fragmentPath := filepath.Join(dir, entry.Name())
+			fragment, err := readFile(fragmentPath, files)
+			if err != nil {
+				return nil, nil, err
+			}
+			if fragment == nil {
+				continue
+			}
+			if node == nil {
+				node = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			}
+			m := &merger{positions: &positions{files: files}}
+			if err := m.mergeFragment(node, fragment, fragmentDir.field); err != nil {
+				return nil, nil, errors.Wrapf(err, "[config] merge fragment %s failed", fragmentPath)
+			}
+		}
+	}
+	return parseNode(node, files)
+}
+
+func isFragment(name string) bool {
+	for _, extension := range fragmentExtensions {
+		if strings.HasSuffix(name, extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// readFile returns the root node of the file of path, nil if it is empty, the nodes are recorded
+// in files.
+func readFile(path string, files map[*yaml.Node]string) (*yaml.Node, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "[config] load config failed")
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return nil, errors.Wrapf(err, "[config] yaml unmarshal %s failed", path)
+	}
+	if len(root.Content) == 0 {
+		return nil, nil
+	}
+	var record func(node *yaml.Node)
+	record = func(node *yaml.Node) {
+		files[node] = path
+		for _, child := range node.Content {
+			record(child)
+		}
+	}
+	record(root.Content[0])
+	return root.Content[0], nil
+}
+
+// positions locates the nodes of the config in the files they were read from.
+type positions struct {
+	files map[*yaml.Node]string
+}
+
+func (p *positions) file(node *yaml.Node) string {
+	if p == nil {
+		return ""
+	}
+	return p.files[node]
+}
+
+func (p *positions) position(node *yaml.Node) string {
+	return position(p.file(node), node.Line)
+}
+
+func position(file string, line int) string {
+	if file == "" {
+		return fmt.Sprintf("line %d", line)
+	}
+	return fmt.Sprintf("%s:%d", file, line)
+}
+
+type merger struct {
+	*positions
+}
+
+// mergeFragment merges the items of fragment into field of the applications of config.
+func (m *merger) mergeFragment(config, fragment *yaml.Node, field string) error {
+	if config.Kind != yaml.MappingNode {
+		return errors.Errorf("%s: the config is not a mapping", m.position(config))
+	}
+	if fragment.Kind != yaml.MappingNode {
+		return errors.Errorf("%s: a fragment maps application ids to %s", m.position(fragment), field)
+	}
+	apps := mappingValue(config, "app_config")
+	for i := 0; i+1 < len(fragment.Content); i += 2 {
+		appID, items := fragment.Content[i], fragment.Content[i+1]
+		var app *yaml.Node
+		if apps != nil {
+			app = mappingValue(apps, appID.Value)
+		}
+		if app == nil || app.Kind != yaml.MappingNode {
+			return errors.Errorf("%s: application %s is not in the config file", m.position(appID), appID.Value)
+		}
+		if items.Kind != yaml.SequenceNode {
+			return errors.Errorf("%s: the %s of application %s are not a list", m.position(items), field, appID.Value)
+		}
+		target := mappingValue(app, field)
+		if target == nil {
+			target = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+			app.Content = append(app.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: field}, target)
+		}
+		if target.Kind != yaml.SequenceNode {
+			return errors.Errorf("%s: the %s of application %s are not a list", m.position(target), field, appID.Value)
+		}
+		for _, item := range items.Content {
+			name := mappingValue(item, "name")
+			if item.Kind != yaml.MappingNode || name == nil || name.Value == "" {
+				return errors.Errorf("%s: an item of %s has no name", m.position(item), field)
+			}
+			existing := findNamed(target, name.Value)
+			if existing == nil {
+				target.Content = append(target.Content, item)
+				continue
+			}
+			path := fmt.Sprintf("app_config.%s.%s[%s]", appID.Value, field, name.Value)
+			if err := m.merge(existing, item, path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// merge merges node into target, the mappings are merged, the lists are concatenated, and the
+// scalars must be the same.
+func (m *merger) merge(target, node *yaml.Node, path string) error {
+	switch {
+	case target.Kind == yaml.MappingNode && node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			existing := mappingValue(target, key.Value)
+			if existing == nil {
+				target.Content = append(target.Content, key, value)
+				continue
+			}
+			if err := m.merge(existing, value, joinPath(path, key.Value)); err != nil {
+				return err
+			}
+		}
+	case target.Kind == yaml.SequenceNode && node.Kind == yaml.SequenceNode:
+		target.Content = append(target.Content, node.Content...)
+	case target.Kind == yaml.ScalarNode && node.Kind == yaml.ScalarNode && target.Value == node.Value:
+	default:
+		return errors.Errorf("%s conflicts, it is set at %s and %s", path, m.position(target), m.position(node))
+	}
+	return nil
+}
+
+// mappingValue returns the value of key of the mapping node, nil if it is absent.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// findNamed returns the item of the sequence node whose name is name, nil if none is.
+func findNamed(node *yaml.Node, name string) *yaml.Node {
+	for _, item := range node.Content {
+		if value := mappingValue(item, "name"); value != nil && value.Value == name {
+			return item
+		}
+	}
+	return nil
+}
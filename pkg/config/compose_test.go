@@ -0,0 +1,111 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"config.yaml": `
+app_config:
+  svc:
+    executors:
+      - name: sharding
+        mode: shd
+        config:
+          transaction_timeout: 60000
+          logic_tables:
+            - db_name: school
+              table_name: student
+    data_source_cluster:
+      - name: school_0
+        dsn: root:123456@tcp(127.0.0.1:3306)/school_0
+`,
+		"datasources.d/school.yaml": `
+svc:
+  - name: school_1
+    dsn: root:123456@tcp(127.0.0.1:3306)/school_1
+`,
+		"executors.d/20-team-b.yaml": `
+svc:
+  - name: sharding
+    config:
+      logic_tables:
+        - db_name: school
+          table_name: course
+`,
+		"executors.d/10-team-a.yaml": `
+svc:
+  - name: sharding
+    config:
+      transaction_timeout: 60000
+      logic_tables:
+        - db_name: school
+          table_name: teacher
+          sharding_rul: {}
+`,
+		"executors.d/README.md": "not a fragment",
+	})
+
+	conf, warnings, err := ParseFile(filepath.Join(dir, "config.yaml"))
+	assert.NoError(t, err)
+	app := conf.AppConfig["svc"]
+	assert.Len(t, app.DataSources, 2)
+	assert.Equal(t, "school_1", app.DataSources[1].Name)
+	assert.Len(t, app.Executors, 1)
+	tables := app.Executors[0].Config["logic_tables"].([]interface{})
+	names := make([]interface{}, 0, len(tables))
+	for _, table := range tables {
+		names = append(names, table.(Parameters)["table_name"])
+	}
+	assert.Equal(t, []interface{}{"student", "teacher", "course"}, names)
+	assert.Equal(t, []*Warning{{
+		Path:    "app_config.svc.executors[0].config.logic_tables[1].sharding_rul",
+		File:    filepath.Join(dir, "executors.d/10-team-a.yaml"),
+		Line:    9,
+		Message: "is an unknown field",
+	}}, warnings)
+
+	writeFiles(t, dir, map[string]string{"executors.d/30-team-c.yaml": `
+svc:
+  - name: sharding
+    config:
+      transaction_timeout: 30000
+`})
+	_, _, err = ParseFile(filepath.Join(dir, "config.yaml"))
+	assert.ErrorContains(t, err, "app_config.svc.executors[sharding].config.transaction_timeout conflicts")
+	assert.NoError(t, os.Remove(filepath.Join(dir, "executors.d/30-team-c.yaml")))
+
+	writeFiles(t, dir, map[string]string{"filters.d/metrics.yaml": "app:\n  - name: metricFilter\n    kind: MetricFilter\n"})
+	_, _, err = ParseFile(filepath.Join(dir, "config.yaml"))
+	assert.ErrorContains(t, err, "application app is not in the config file")
+}
@@ -19,13 +19,15 @@ package config
 import (
 	"bytes"
 	"fmt"
-	"os"
+	"math"
+	"net"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 	clientv3 "go.etcd.io/etcd/client/v3"
-	"gopkg.in/yaml.v3"
 
 	"github.com/cectc/dbpack/pkg/log"
 )
@@ -36,8 +38,28 @@ type ProtocolType int32
 const (
 	Http ProtocolType = iota
 	Mysql
+	Redis
+	Grpc
+	Mysqlx
 )
 
+func (t ProtocolType) MarshalText() ([]byte, error) {
+	switch t {
+	case Http:
+		return []byte("http"), nil
+	case Mysql:
+		return []byte("mysql"), nil
+	case Redis:
+		return []byte("redis"), nil
+	case Grpc:
+		return []byte("grpc"), nil
+	case Mysqlx:
+		return []byte("mysqlx"), nil
+	default:
+		return nil, fmt.Errorf("unrecognized protocol type: %d", t)
+	}
+}
+
 func (t *ProtocolType) UnmarshalText(text []byte) error {
 	if t == nil {
 		return errors.New("can't unmarshal a nil *ProtocolType")
@@ -54,6 +76,12 @@ func (t *ProtocolType) unmarshalText(text []byte) bool {
 		*t = Mysql
 	case "http":
 		*t = Http
+	case "redis":
+		*t = Redis
+	case "grpc":
+		*t = Grpc
+	case "mysqlx":
+		*t = Mysqlx
 	default:
 		return false
 	}
@@ -64,10 +92,103 @@ type Configuration struct {
 	ProbePort                int           `default:"18888" yaml:"probe_port" json:"probe_port"`
 	Tracer                   *TracerConfig `yaml:"tracer" json:"tracer"`
 	TerminationDrainDuration time.Duration `default:"3s" yaml:"termination_drain_duration" json:"termination_drain_duration"`
+	// TableMetaCacheTTL is how long a table meta fetched from information_schema is cached
+	TableMetaCacheTTL time.Duration `default:"15m" yaml:"table_meta_cache_ttl" json:"table_meta_cache_ttl"`
+	// TableMetaRefreshInterval is the interval of refreshing cached table metas, non-positive value disables it
+	TableMetaRefreshInterval time.Duration `default:"1m" yaml:"table_meta_refresh_interval" json:"table_meta_refresh_interval"`
+	// Plugins are the paths of the Go plugins providing filter kinds, they are loaded before the filters are created
+	Plugins []string `yaml:"plugins" json:"plugins"`
+	// Readiness controls when the readiness probe reports dbpack ready
+	Readiness ReadinessConfig `yaml:"readiness" json:"readiness"`
+	// Preflight checks the data sources before dbpack starts serving
+	Preflight PreflightConfig `yaml:"preflight" json:"preflight"`
+	// AutoMigrate creates and migrates the auxiliary tables of dbpack when it starts
+	AutoMigrate AutoMigrateConfig `yaml:"auto_migrate" json:"auto_migrate"`
 
 	AppConfig AppConfig `yaml:"app_config" json:"app_config"`
 }
 
+// ReadinessPolicy is how the readiness of dbpack depends on the reachability of its data sources.
+type ReadinessPolicy string
+
+const (
+	// ReadinessWait reports dbpack not ready while fewer than the quorum of the data sources of an
+	// application are reachable
+	ReadinessWait ReadinessPolicy = "wait"
+	// ReadinessDegraded reports dbpack ready even if its data sources are unreachable, so that it
+	// starts serving the clients as soon as it is started
+	ReadinessDegraded ReadinessPolicy = "degraded"
+)
+
+// ReadinessConfig controls the readiness probe, which gates the traffic of the kubernetes services
+// and of the load balancers probing dbpack. The probe reports the policy and the states of the data
+// sources whatever the policy is.
+type ReadinessConfig struct {
+	Policy ReadinessPolicy `default:"wait" yaml:"policy" json:"policy"`
+	// Quorum is the number, or the percentage such as 50%, of the data sources of each application
+	// reachable for dbpack to be ready under the wait policy
+	Quorum string `default:"100%" yaml:"quorum" json:"quorum"`
+}
+
+// Validate checks the policy and the quorum.
+func (r *ReadinessConfig) Validate() error {
+	switch r.Policy {
+	case "", ReadinessWait, ReadinessDegraded:
+	default:
+		return errors.Errorf("unsupported readiness policy %s, it must be wait or degraded", r.Policy)
+	}
+	_, err := r.RequiredDataSources(0)
+	return err
+}
+
+// RequiredDataSources returns the number of the data sources among total which must be reachable for
+// dbpack to be ready under the wait policy, all of them if the quorum is empty.
+func (r *ReadinessConfig) RequiredDataSources(total int) (int, error) {
+	quorum := strings.TrimSpace(r.Quorum)
+	if quorum == "" {
+		return total, nil
+	}
+	if strings.HasSuffix(quorum, "%") {
+		percentage, err := strconv.ParseFloat(strings.TrimSuffix(quorum, "%"), 64)
+		if err != nil || percentage < 0 || percentage > 100 {
+			return 0, errors.Errorf("invalid readiness quorum %s, it must be a number or a percentage", r.Quorum)
+		}
+		// rounded up, so that 50% of 3 data sources is 2 of them
+		return int(math.Ceil(percentage * float64(total) / 100)), nil
+	}
+	n, err := strconv.Atoi(quorum)
+	if err != nil || n < 0 {
+		return 0, errors.Errorf("invalid readiness quorum %s, it must be a number or a percentage", r.Quorum)
+	}
+	if n > total {
+		return total, nil
+	}
+	return n, nil
+}
+
+// PreflightConfig is the config of the checks of the connectivity, the privileges, the tables and
+// the clocks of the data sources, which are run by dbpack preflight anyway.
+type PreflightConfig struct {
+	// Enabled runs the checks when dbpack starts, which fails to start if one of them fails
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// CreateUndoLogTable creates the undo_log table of the masters missing it, if distributed
+	// transactions are enabled
+	CreateUndoLogTable bool `yaml:"create_undo_log_table" json:"create_undo_log_table"`
+	// MaxClockSkew is the max difference between the clocks of dbpack and of the data sources, the
+	// undo logs are cleaned by comparing their creation time with the clock of dbpack
+	MaxClockSkew time.Duration `default:"1s" yaml:"max_clock_skew" json:"max_clock_skew"`
+}
+
+// AutoMigrateConfig is the config of the versioned migrations of the auxiliary tables of dbpack, the
+// undo_log table of the masters of the applications enabling distributed transactions, and the segment
+// table of the segment sharding key generators.
+type AutoMigrateConfig struct {
+	// Enabled migrates the tables when dbpack starts, which fails to start if a migration fails
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// LockTimeout is how long dbpack waits for the other instances migrating the same database
+	LockTimeout time.Duration `default:"1m" yaml:"lock_timeout" json:"lock_timeout"`
+}
+
 type AppConfig map[string]*DBPackConfig
 
 type DBPackConfig struct {
@@ -78,6 +199,12 @@ type DBPackConfig struct {
 	Executors   []*Executor   `yaml:"executors" json:"executors"`
 	DataSources []*DataSource `yaml:"data_source_cluster" json:"data_source_cluster"`
 	Filters     []*Filter     `yaml:"filters" json:"filters"`
+	// ActiveStandby coordinates the deployments of several datacenters so that one of them accepts
+	// writes at a time, disabled if nil
+	ActiveStandby *ActiveStandby `yaml:"active_standby" json:"active_standby"`
+	// Kubernetes reconciles data sources, executors and filters from the custom resources of a
+	// namespace besides those of the config file, disabled if nil
+	Kubernetes *KubernetesConfig `yaml:"kubernetes" json:"kubernetes"`
 }
 
 type TracerConfig struct {
@@ -89,17 +216,109 @@ type DistributedTransaction struct {
 	AppID                            string `yaml:"appid" json:"appid"`
 	RetryDeadThreshold               int64  `yaml:"retry_dead_threshold" json:"retry_dead_threshold"`
 	RollbackRetryTimeoutUnlockEnable bool   `yaml:"rollback_retry_timeout_unlock_enable" json:"rollback_retry_timeout_unlock_enable"`
+	// BranchCommitWorkers is the number of workers committing branch sessions in phase two
+	BranchCommitWorkers int `yaml:"branch_commit_workers" json:"branch_commit_workers"`
+	// BranchCommitBatchSize is the max number of branch sessions of a data source committed together
+	BranchCommitBatchSize int `yaml:"branch_commit_batch_size" json:"branch_commit_batch_size"`
+	// UndoLogCleanup removes undo logs left behind by finished global transactions, disabled if nil
+	UndoLogCleanup *UndoLogCleanup `yaml:"undo_log_cleanup" json:"undo_log_cleanup"`
+	// BranchRetry controls how branch sessions failed in phase two are retried
+	BranchRetry BranchRetry `yaml:"branch_retry" json:"branch_retry"`
+	// LeaderLeaseTTL is the ttl in seconds of the etcd lease held by the coordinator leader, another
+	// instance takes over the retry and cleanup loops when the leader fails to renew it in time
+	LeaderLeaseTTL int `yaml:"leader_lease_ttl" json:"leader_lease_ttl"`
 
 	EtcdConfig *clientv3.Config `yaml:"etcd_config" json:"etcd_config"`
 }
 
+// ActiveStandby is the config of a deployment coordinated with the deployments of the other
+// datacenters through etcd, the deployments of the active datacenter accept writes, the others
+// reject them and keep their data sources read only.
+type ActiveStandby struct {
+	AppID string `yaml:"-" json:"-"`
+	// Datacenter is the name of the datacenter of this deployment
+	Datacenter string `yaml:"datacenter" json:"datacenter"`
+	// InitialActive is the datacenter which is active before any switchover, it must be the same
+	// in all the datacenters
+	InitialActive string `yaml:"initial_active" json:"initial_active"`
+	// DataSources are the data sources set read only while the datacenter is standby, defaults
+	// to the masters
+	DataSources []string `yaml:"data_sources" json:"data_sources"`
+	// SuperReadOnly sets super_read_only as well as read_only, so that the users having the
+	// SUPER privilege can not write either
+	SuperReadOnly bool `yaml:"super_read_only" json:"super_read_only"`
+	// LeaseTTL is the ttl in seconds of the etcd lease bound to the role this instance reports,
+	// an instance which can not renew it in time rejects writes, and is not waited for by a switchover
+	LeaseTTL int `default:"10" yaml:"lease_ttl" json:"lease_ttl"`
+	// EtcdConfig is the etcd shared by the datacenters, defaults to the one of distributed_transaction
+	EtcdConfig *clientv3.Config `yaml:"etcd_config" json:"etcd_config"`
+}
+
+// KubernetesConfig is the config of the controller reconciling the DataSource, Executor and FilterChain
+// resources of the dbpack.cectc.com group, the listeners are still declared in the config file, and
+// may be served by the executors of the resources.
+type KubernetesConfig struct {
+	// Namespace is the namespace of the resources, defaults to the namespace of the pod
+	Namespace string `yaml:"namespace" json:"namespace"`
+	// LabelSelector selects the resources of the application, all the resources of the namespace if empty
+	LabelSelector string `yaml:"label_selector" json:"label_selector"`
+	// Kubeconfig is the kubeconfig file used to run out of the cluster, the service account of the
+	// pod is used if empty
+	Kubeconfig string `yaml:"kubeconfig" json:"kubeconfig"`
+	// ResyncInterval is the interval of reconciling the resources even if none of them changed
+	ResyncInterval time.Duration `default:"5m" yaml:"resync_interval" json:"resync_interval"`
+}
+
+// FilterChain is the spec of a FilterChain resource, it declares filters and the executors running
+// them in order.
+type FilterChain struct {
+	// Executors are the names of the executors running the filters
+	Executors []string `yaml:"executors" json:"executors"`
+	// Filters are the filters in the order they run, their names are unique in the application
+	Filters []*Filter `yaml:"filters" json:"filters"`
+}
+
+type UndoLogCleanup struct {
+	// DataSources are the data sources whose undo_log table is cleaned, defaults to all data sources
+	DataSources []string `yaml:"data_sources" json:"data_sources"`
+	// Retention is how long undo logs are kept, it must be longer than any global transaction may last,
+	// including its phase two retries
+	Retention time.Duration `default:"168h" yaml:"retention" json:"retention"`
+	// Interval is the interval between two cleanups
+	Interval time.Duration `default:"1h" yaml:"interval" json:"interval"`
+	// BatchSize is the max number of undo logs removed by one statement
+	BatchSize int `default:"1000" yaml:"batch_size" json:"batch_size"`
+	// BatchesPerSecond limits the number of batches removed per second on each data source
+	BatchesPerSecond int `default:"10" yaml:"batches_per_second" json:"batches_per_second"`
+	// ArchiveDir is the directory undo logs are appended to before being deleted,
+	// undo logs are deleted without being archived if it is empty
+	ArchiveDir string `yaml:"archive_dir" json:"archive_dir"`
+}
+
+type BranchRetry struct {
+	// InitialInterval is the backoff before the first retry, it doubles on every retry
+	InitialInterval time.Duration `default:"100ms" yaml:"initial_interval" json:"initial_interval"`
+	// MaxInterval caps the backoff between two retries
+	MaxInterval time.Duration `default:"30s" yaml:"max_interval" json:"max_interval"`
+	// MaxAttempts is the max number of retries of a branch session, the branch session is
+	// moved to the dead branch sessions when exceeded
+	MaxAttempts int `default:"20" yaml:"max_attempts" json:"max_attempts"`
+	// Concurrency is the max number of branch sessions of a data source retried at the same time
+	Concurrency int `default:"2" yaml:"concurrency" json:"concurrency"`
+	// Workers is the number of workers retrying branch sessions
+	Workers int `default:"4" yaml:"workers" json:"workers"`
+}
+
 type Listener struct {
 	AppID         string        `yaml:"-" json:"-"`
 	ProtocolType  ProtocolType  `yaml:"protocol_type" json:"protocol_type"`
 	SocketAddress SocketAddress `yaml:"socket_address" json:"socket_address"`
 	Config        Parameters    `yaml:"config" json:"config"`
 	Executor      string        `yaml:"executor" json:"executor"`
-	Filters       []string      `yaml:"filters" json:"filters"`
+	// SchemaExecutors maps schemas to the executors serving the connections using them,
+	// connections using a schema not mapped are served by Executor
+	SchemaExecutors map[string]string `yaml:"schema_executors" json:"schema_executors"`
+	Filters         []string          `yaml:"filters" json:"filters"`
 }
 
 type Executor struct {
@@ -158,12 +377,26 @@ func (conf *DBPackConfig) Normalize() error {
 	if conf.DistributedTransaction != nil {
 		conf.DistributedTransaction.AppID = conf.AppID
 	}
+	if conf.ActiveStandby != nil {
+		if err := conf._validateActiveStandby(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 func (conf *DBPackConfig) _validateListeners() error {
+	endpoints := make(map[string]bool)
 	for _, listener := range conf.Listeners {
-		if listener.Executor != "" {
+		if listener.SocketAddress.Port != 0 {
+			endpoint := listener.SocketAddress.String()
+			if endpoints[endpoint] {
+				return errors.Errorf("Listener %s is declared more than once", endpoint)
+			}
+			endpoints[endpoint] = true
+		}
+		// the executors of the kubernetes resources are checked once the resources are reconciled
+		if listener.Executor != "" && conf.Kubernetes == nil {
 			var _executor *Executor
 			for _, executor := range conf.Executors {
 				if executor.Name == listener.Executor {
@@ -174,6 +407,21 @@ func (conf *DBPackConfig) _validateListeners() error {
 				return errors.Errorf("Listener %s doesn't have a valid executor", listener.SocketAddress)
 			}
 		}
+		for schema, executorName := range listener.SchemaExecutors {
+			if conf.Kubernetes != nil {
+				break
+			}
+			var _executor *Executor
+			for _, executor := range conf.Executors {
+				if executor.Name == executorName {
+					_executor = executor
+				}
+			}
+			if _executor == nil {
+				return errors.Errorf("Listener %s doesn't have a valid executor %s for schema %s",
+					listener.SocketAddress, executorName, schema)
+			}
+		}
 		for _, filterName := range listener.Filters {
 			var _filter *Filter
 			for _, filter := range conf.Filters {
@@ -221,45 +469,70 @@ func (conf *DBPackConfig) _validateDataSources() error {
 				return errors.Errorf("DataSource %s doesn't have a valid filter %s", dataSource.Name, filterName)
 			}
 		}
+		if dataSource.Concurrency != nil {
+			if _, err := dataSource.Concurrency.Validate(); err != nil {
+				return errors.Wrapf(err, "DataSource %s", dataSource.Name)
+			}
+		}
+		if dataSource.Annotation != nil {
+			if _, err := dataSource.Annotation.Validate(); err != nil {
+				return errors.Wrapf(err, "DataSource %s", dataSource.Name)
+			}
+		}
 	}
 	return nil
 }
 
+func (conf *DBPackConfig) _validateActiveStandby() error {
+	activeStandby := conf.ActiveStandby
+	if activeStandby.Datacenter == "" || activeStandby.InitialActive == "" {
+		return errors.New("active_standby must have a datacenter and an initial_active datacenter")
+	}
+	if activeStandby.EtcdConfig == nil {
+		activeStandby.EtcdConfig = conf.GetEtcdConfig()
+	}
+	if activeStandby.EtcdConfig == nil {
+		return errors.New("active_standby must have an etcd_config")
+	}
+	for _, name := range activeStandby.DataSources {
+		var _dataSource *DataSource
+		for _, dataSource := range conf.DataSources {
+			if dataSource.Name == name {
+				_dataSource = dataSource
+			}
+		}
+		if _dataSource == nil {
+			return errors.Errorf("active_standby doesn't have a valid data source %s", name)
+		}
+	}
+	activeStandby.AppID = conf.AppID
+	return nil
+}
+
+// String returns the host:port form of the socket address, IPv6 literals such as
+// "::" or "[::]" are bracketed so that it can be passed to net.Listen and net.Dial
 func (sa SocketAddress) String() string {
-	return fmt.Sprintf("%s:%d", sa.Address, sa.Port)
+	host := strings.TrimSuffix(strings.TrimPrefix(sa.Address, "["), "]")
+	return net.JoinHostPort(host, strconv.Itoa(sa.Port))
 }
 
 var _configuration = new(Configuration)
 
-// Load config file and parse
+// Load config file and the fragments next to it, and parse
 func Load(path string) (*Configuration, error) {
 	configPath, _ := filepath.Abs(path)
 	log.Infof("load config from :  %s", configPath)
-	content, err := os.ReadFile(configPath)
+	configuration, warnings, err := ParseFile(configPath)
 	if err != nil {
-		return nil, errors.Wrap(err, "[config] load config failed")
+		return nil, err
 	}
-	configuration, err := _parse(content)
-	if err == nil {
-		for appID, config := range configuration.AppConfig {
-			config.AppID = appID
-			if err := config.Normalize(); err != nil {
-				return nil, err
-			}
-		}
-		_configuration = configuration
+	for _, warning := range warnings {
+		log.Warnf("[config] %s", warning)
 	}
-	return configuration, err
+	_configuration = configuration
+	return configuration, nil
 }
 
 func GetDBPackConfig(appID string) *DBPackConfig {
 	return _configuration.DBPackConfig(appID)
 }
-
-func _parse(content []byte) (*Configuration, error) {
-	var configuration Configuration
-	if err := yaml.Unmarshal(content, &configuration); err != nil {
-		return nil, errors.Wrap(err, "[config] yaml unmarshal config failed")
-	}
-	return &configuration, nil
-}
@@ -0,0 +1,61 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadinessConfig(t *testing.T) {
+	testCases := []struct {
+		quorum   string
+		total    int
+		required int
+		valid    bool
+	}{
+		{"", 3, 3, true},
+		{"100%", 3, 3, true},
+		{"50%", 3, 2, true},
+		{"0%", 3, 0, true},
+		{"2", 3, 2, true},
+		{"5", 3, 3, true},
+		{"150%", 3, 0, false},
+		{"-1", 3, 0, false},
+		{"half", 3, 0, false},
+	}
+	for _, c := range testCases {
+		t.Run(c.quorum, func(t *testing.T) {
+			r := &ReadinessConfig{Quorum: c.quorum}
+			required, err := r.RequiredDataSources(c.total)
+			if !c.valid {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, c.required, required)
+		})
+	}
+
+	conf, _, err := Parse([]byte("readiness:\n  quorum: 1\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, ReadinessConfig{Policy: ReadinessWait, Quorum: "1"}, conf.Readiness)
+
+	_, _, err = Parse([]byte("readiness:\n  policy: eventually\n"))
+	assert.Error(t, err)
+}
@@ -29,6 +29,13 @@ import (
 
 const (
 	weightRegex = `^r([\d]+)w([\d]+)$`
+
+	DefaultAnnotationTemplate = "session={session} user={user} route={route} trace={trace} xid={xid}"
+
+	QueueingFIFO     = "fifo"
+	QueueingPriority = "priority"
+
+	defaultQueueTimeout = time.Second
 )
 
 type (
@@ -50,19 +57,167 @@ type (
 		Capacity                 int           `yaml:"capacity" json:"capacity"`         // connection pool capacity
 		MaxCapacity              int           `yaml:"max_capacity" json:"max_capacity"` // max connection pool capacity
 		IdleTimeout              time.Duration `yaml:"idle_timeout" json:"idle_timeout"` // close backend direct connection after idle_timeout,unit: seconds
-		PingInterval             time.Duration `yaml:"ping_interval" json:"ping_interval"`
-		PingTimesForChangeStatus int           `yaml:"ping_times_for_change_status" json:"ping_times_for_change_status"`
+		PingInterval             time.Duration `default:"20s" yaml:"ping_interval" json:"ping_interval"`
+		PingTimesForChangeStatus int           `default:"3" yaml:"ping_times_for_change_status" json:"ping_times_for_change_status"`
 		Filters                  []string      `yaml:"filters" json:"filters"`
+		// TCP tunes the tcp connections dialed to the data source
+		TCP *TCPConfig `yaml:"tcp,omitempty" json:"tcp,omitempty"`
+		// Passthrough marks the data source as a proxy, such as vtgate or another dbpack, which routes
+		// and rewrites the statements itself, so they are sent as the client sent them
+		Passthrough bool `yaml:"passthrough,omitempty" json:"passthrough,omitempty"`
+		// Concurrency caps the statements running on the data source at once, unlimited if nil
+		Concurrency *ConcurrencyConfig `yaml:"concurrency,omitempty" json:"concurrency,omitempty"`
+		// Annotation prepends a comment to the statements sent to the data source, disabled if nil
+		Annotation *AnnotationConfig `yaml:"annotation,omitempty" json:"annotation,omitempty"`
+	}
+
+	// AnnotationConfig is the comment prepended to the statements sent to a data source, so that the
+	// general and slow query logs of the backend show where each statement comes from.
+	AnnotationConfig struct {
+		// Template is the text of the comment, {session}, {user}, {route}, {trace} and {xid} are replaced
+		// by the client connection id, the user, the data source the statement is routed to, the trace id
+		// and the xid of the global transaction, DefaultAnnotationTemplate if empty
+		Template string `yaml:"template,omitempty" json:"template,omitempty"`
+	}
+
+	// ConcurrencyConfig caps the statements running on a data source outside of transactions, the
+	// statements over the cap wait in a queue, so that a hot shard is not overwhelmed when the
+	// statements are scattered to all the shards.
+	ConcurrencyConfig struct {
+		// MaxConcurrency is the max number of statements running at once
+		MaxConcurrency int `yaml:"max_concurrency" json:"max_concurrency"`
+		// MaxQueueSize is the max number of statements waiting, the statements over it fail at once,
+		// zero means the queue is unbounded
+		MaxQueueSize int `yaml:"max_queue_size,omitempty" json:"max_queue_size,omitempty"`
+		// QueueTimeout is how long a statement waits before it fails, e.g. 500ms, 1s by default
+		QueueTimeout string `yaml:"queue_timeout,omitempty" json:"queue_timeout,omitempty"`
+		// Queueing is fifo, the default, or priority, which runs the statements of a single shard
+		// before the statements scattered to all the shards, in the order they are queued
+		Queueing string `yaml:"queueing,omitempty" json:"queueing,omitempty"`
+	}
+
+	TCPConfig struct {
+		// KeepAlivePeriod is the idle time before the first keepalive probe and the interval between
+		// probes, zero means the system default, negative disables keepalive
+		KeepAlivePeriod time.Duration `yaml:"keepalive_period" json:"keepalive_period"`
+		// UserTimeout is the max time transmitted data may remain unacknowledged before the
+		// connection is closed, it is only supported on linux
+		UserTimeout time.Duration `yaml:"user_timeout" json:"user_timeout"`
+		// NoDelay disables the Nagle's algorithm, defaults to true
+		NoDelay *bool `yaml:"no_delay" json:"no_delay"`
+		// ReadBufferSize and WriteBufferSize are the socket buffer sizes in bytes, zero means the system default
+		ReadBufferSize  int `yaml:"read_buffer_size" json:"read_buffer_size"`
+		WriteBufferSize int `yaml:"write_buffer_size" json:"write_buffer_size"`
+	}
+
+	// SingleDBConfig is the config of a SDB executor.
+	SingleDBConfig struct {
+		DataSourceRef string `yaml:"data_source_ref" json:"data_source_ref"`
 	}
 
 	DataSourceRef struct {
 		Name   string `yaml:"name" json:"name"`
 		Weight string `yaml:"weight,omitempty" json:"weight,omitempty"`
+		// MaintenanceWindows are the periods the data source is backed up or maintained, its read weight
+		// is zero and it is not read from during them
+		MaintenanceWindows []*MaintenanceWindow `yaml:"maintenance_windows,omitempty" json:"maintenance_windows,omitempty"`
+	}
+
+	// MaintenanceWindow is a recurring period, which starts at the times matching a cron schedule and
+	// lasts for a duration.
+	MaintenanceWindow struct {
+		// Schedule is a cron expression of minute, hour, day of month, month and day of week, e.g.
+		// 0 2 * * * starts the window at 2:00 every day, 30 1 * * 1-5 at 1:30 on weekdays
+		Schedule string `yaml:"schedule" json:"schedule"`
+		// Duration is how long the window lasts, e.g. 2h
+		Duration string `yaml:"duration" json:"duration"`
+		// TimeZone of the schedule, e.g. Asia/Shanghai, default Local
+		TimeZone string `yaml:"time_zone,omitempty" json:"time_zone,omitempty"`
 	}
 
 	ReadWriteSplittingConfig struct {
 		LoadBalanceAlgorithm LoadBalanceAlgorithm `yaml:"load_balance_algorithm" json:"load_balance_algorithm"`
 		DataSources          []*DataSourceRef     `yaml:"data_sources" json:"data_sources"`
+		// Hedging sends a read to a second slave if the first one has not responded in time, disabled if nil
+		Hedging *HedgingConfig `yaml:"hedging,omitempty" json:"hedging,omitempty"`
+		// Galera routes the data sources as the nodes of a Galera or Percona XtraDB Cluster, disabled if nil
+		Galera *GaleraConfig `yaml:"galera,omitempty" json:"galera,omitempty"`
+		// Aurora discovers the reader instances of an Aurora MySQL cluster, disabled if nil
+		Aurora *AuroraConfig `yaml:"aurora,omitempty" json:"aurora,omitempty"`
+		// Rejoin brings the data sources recovering from a failure back gradually, they serve at full
+		// weight as soon as they are healthy again if nil
+		Rejoin *RejoinConfig `yaml:"rejoin,omitempty" json:"rejoin,omitempty"`
+		// StatementRoutes override the read or write classification of the statements outside of transactions,
+		// CALL is a write unless its procedure is declared a read
+		StatementRoutes []*StatementRoute `yaml:"statement_routes,omitempty" json:"statement_routes,omitempty"`
+	}
+
+	// StatementRoute declares the statements of a fingerprint or the calls of a stored procedure as reads,
+	// which are sent to the slaves, or writes, which are sent to the masters.
+	StatementRoute struct {
+		// Fingerprint is a statement, whose literals are replaced by '?' before matching, so either a sample
+		// or a normalized statement fits, e.g. select * from employees where emp_no = ?
+		Fingerprint string `yaml:"fingerprint,omitempty" json:"fingerprint,omitempty"`
+		// Procedure is the name of a stored procedure, optionally qualified by its schema, e.g. get_salary
+		Procedure string `yaml:"procedure,omitempty" json:"procedure,omitempty"`
+		// Route is either read or write
+		Route string `yaml:"route" json:"route"`
+	}
+
+	// GaleraConfig is the topology of a Galera or Percona XtraDB Cluster. The writes are sent to a single
+	// node to avoid certification conflicts, the reads to the other nodes. The nodes which are not synced,
+	// such as the donors and the desynced nodes, are avoided.
+	GaleraConfig struct {
+		// CheckInterval is the interval of checking the wsrep status of the nodes, e.g. 1s, 1s by default
+		CheckInterval string `yaml:"check_interval,omitempty" json:"check_interval,omitempty"`
+		// Writer is the data source the writes are sent to while it is synced, the first one by default.
+		// The writes fail over to the first synced node in the order of the data sources otherwise.
+		Writer string `yaml:"writer,omitempty" json:"writer,omitempty"`
+		// Failback sends the writes back to Writer once it is synced again, otherwise they stay on the
+		// node they failed over to until it is not synced
+		Failback bool `yaml:"failback,omitempty" json:"failback,omitempty"`
+	}
+
+	// AuroraConfig discovers the instances of an Aurora MySQL cluster from
+	// information_schema.replica_host_status, queried through the master data source, which should be
+	// the cluster endpoint. The readers are added to and removed from the group as the cluster scales.
+	AuroraConfig struct {
+		// RefreshInterval is the interval of querying the instances of the cluster, e.g. 5s, 5s by default
+		RefreshInterval string `yaml:"refresh_interval,omitempty" json:"refresh_interval,omitempty"`
+		// InstanceHostPattern is the host of the instances with the instance id replaced by '?', e.g.
+		// ?.abcdefghijkl.us-east-1.rds.amazonaws.com, derived from the cluster endpoint by default
+		InstanceHostPattern string `yaml:"instance_host_pattern,omitempty" json:"instance_host_pattern,omitempty"`
+		// MaxReplicaLag is the max replica lag of the readers serving reads, e.g. 1s, unlimited by default
+		MaxReplicaLag string `yaml:"max_replica_lag,omitempty" json:"max_replica_lag,omitempty"`
+		// ReadWeight is the read weight of the readers, used by RandomWeight, 10 by default
+		ReadWeight int `yaml:"read_weight,omitempty" json:"read_weight,omitempty"`
+	}
+
+	// RejoinConfig is the rejoin of a data source which recovered from a failure. It serves nothing
+	// during a probation period, then a slave optionally waits until it has executed the transactions
+	// its master had executed, and at last its weights are raised step by step to the configured ones.
+	RejoinConfig struct {
+		// ProbationPeriod is how long the data source must stay healthy before it serves again, e.g. 30s,
+		// 30s by default
+		ProbationPeriod string `yaml:"probation_period,omitempty" json:"probation_period,omitempty"`
+		// VerifyGTID waits until a slave has executed the gtid set its master had executed at the end of
+		// the probation period, so that the reads do not miss the writes made while it was down
+		VerifyGTID bool `yaml:"verify_gtid,omitempty" json:"verify_gtid,omitempty"`
+		// RampSteps is the number of steps the weights are raised in, 4 by default
+		RampSteps int `yaml:"ramp_steps,omitempty" json:"ramp_steps,omitempty"`
+		// RampInterval is the time between the steps, e.g. 10s, 10s by default
+		RampInterval string `yaml:"ramp_interval,omitempty" json:"ramp_interval,omitempty"`
+	}
+
+	HedgingConfig struct {
+		// Percentile of the recent read latencies used as the hedging delay, e.g. 0.95
+		Percentile float64 `yaml:"percentile" json:"percentile"`
+		// MinDelay and MaxDelay bound the hedging delay, e.g. 5ms and 1s, MaxDelay is also
+		// used before enough latencies are collected
+		MinDelay string `yaml:"min_delay,omitempty" json:"min_delay,omitempty"`
+		MaxDelay string `yaml:"max_delay,omitempty" json:"max_delay,omitempty"`
+		// Budget is the max ratio of reads hedged, e.g. 0.1 means at most 10% of the reads are sent twice
+		Budget float64 `yaml:"budget" json:"budget"`
 	}
 
 	DataSourceRefGroup struct {
@@ -72,9 +227,11 @@ type (
 	}
 
 	ShardingRule struct {
-		Column            string     `yaml:"column" json:"column"`
-		ShardingAlgorithm string     `yaml:"sharding_algorithm" json:"sharding_algorithm"`
-		Config            Parameters `yaml:"config,omitempty" json:"config,omitempty"`
+		Column            string `yaml:"column" json:"column"`
+		ShardingAlgorithm string `yaml:"sharding_algorithm" json:"sharding_algorithm"`
+		// TimeZone used to compute the target shard of datetime sharding keys, e.g. Asia/Shanghai, default Local
+		TimeZone string     `yaml:"time_zone,omitempty" json:"time_zone,omitempty"`
+		Config   Parameters `yaml:"config,omitempty" json:"config,omitempty"`
 	}
 
 	ShardingKeyGenerator struct {
@@ -90,6 +247,9 @@ type (
 		ShardingRule         *ShardingRule         `yaml:"sharding_rule" json:"sharding_rule"`
 		ShardingKeyGenerator *ShardingKeyGenerator `yaml:"sharding_key_generator" json:"sharding_key_generator"`
 		Topology             map[int]string        `yaml:"topology" json:"topology"`
+		// StatisticsInterval is the interval of collecting shard statistics used to prune shards, e.g. 10m,
+		// empty means statistics are disabled
+		StatisticsInterval string `yaml:"statistics_interval,omitempty" json:"statistics_interval,omitempty"`
 	}
 
 	ShardingConfig struct {
@@ -97,6 +257,38 @@ type (
 		GlobalTables       []string              `yaml:"global_tables" json:"global_tables"`
 		LogicTables        []*LogicTable         `yaml:"logic_tables" json:"logic_tables"`
 		TransactionTimeout int32                 `yaml:"transaction_timeout" json:"transaction_timeout"`
+		// ReplicatedTables are fully copied to several db groups, as a simpler alternative to the
+		// binlog replication for the small hot tables
+		ReplicatedTables []*ReplicatedTable `yaml:"replicated_tables,omitempty" json:"replicated_tables,omitempty"`
+		// PlanCacheSize is the max count of cached routing plans, zero means plan cache is disabled
+		PlanCacheSize int64 `yaml:"plan_cache_size,omitempty" json:"plan_cache_size,omitempty"`
+		// OnlineDDL alters the sharded tables through ghost tables instead of running ALTER TABLE
+		// on the shards, nil means ALTER TABLE is run on the shards
+		OnlineDDL *OnlineDDLConfig `yaml:"online_ddl,omitempty" json:"online_ddl,omitempty"`
+		// Fallback is the db group of the statements referencing none of the logic, global and replicated
+		// tables, which are sent to it as the client sent them. It is usually a proxy sharding the other
+		// tables itself, such as vtgate or another dbpack, while the transactions are still local
+		// transactions of dbpack spanning it and the db groups.
+		Fallback *DataSourceRefGroup `yaml:"fallback,omitempty" json:"fallback,omitempty"`
+	}
+
+	// ReplicatedTable is a table copied to several db groups, the reads are spread over the copies
+	// and the writes are sent to all of them. The inserts must carry the values of the auto
+	// increment columns, which are generated by each db group otherwise.
+	ReplicatedTable struct {
+		TableName string `yaml:"table_name" json:"table_name"`
+		// DBGroups are the db groups holding a copy of the table, all of them by default
+		DBGroups []string `yaml:"db_groups,omitempty" json:"db_groups,omitempty"`
+	}
+
+	// OnlineDDLConfig is the config of the online ALTER TABLE of the sharded tables.
+	OnlineDDLConfig struct {
+		// ChunkSize is the count of rows copied to a ghost table by a statement, 1000 by default
+		ChunkSize int `yaml:"chunk_size" json:"chunk_size"`
+		// ChunkInterval is the pause between the chunks copied, such as 10ms, it throttles copying
+		ChunkInterval string `yaml:"chunk_interval" json:"chunk_interval"`
+		// KeepOldTable keeps the original tables after the cut-over, renamed to _<table>_del
+		KeepOldTable bool `yaml:"keep_old_table" json:"keep_old_table"`
 	}
 )
 
@@ -182,6 +374,10 @@ func (m ExecuteMode) String() string {
 	}
 }
 
+func (m ExecuteMode) MarshalText() ([]byte, error) {
+	return []byte(strings.ToLower(m.String())), nil
+}
+
 func (m *ExecuteMode) UnmarshalText(text []byte) error {
 	if m == nil {
 		return errors.New("can't unmarshal a nil *ExecuteMode")
@@ -206,6 +402,19 @@ func (m *ExecuteMode) unmarshalText(text []byte) bool {
 	return true
 }
 
+func (l LoadBalanceAlgorithm) MarshalText() ([]byte, error) {
+	switch l {
+	case Random:
+		return []byte("Random"), nil
+	case RoundRobin:
+		return []byte("RoundRobin"), nil
+	case RandomWeight:
+		return []byte("RandomWeight"), nil
+	default:
+		return nil, errors.Errorf("unrecognized load balance algorithm: %d", l)
+	}
+}
+
 func (l *LoadBalanceAlgorithm) UnmarshalText(text []byte) error {
 	if l == nil {
 		return errors.New("can't unmarshal a nil *ProtocolType")
@@ -233,6 +442,204 @@ func (l *LoadBalanceAlgorithm) unmarshalText(text []byte) bool {
 	return false
 }
 
+// Location returns the time zone configured for the sharding rule
+func (rule *ShardingRule) Location() (*time.Location, error) {
+	if rule.TimeZone == "" {
+		return time.Local, nil
+	}
+	location, err := time.LoadLocation(rule.TimeZone)
+	if err != nil {
+		return nil, errors.Wrapf(err, "sharding rule of column '%s' has invalid time zone %s", rule.Column, rule.TimeZone)
+	}
+	return location, nil
+}
+
+// StatisticsRefreshInterval returns the interval of collecting shard statistics, zero means disabled
+func (table *LogicTable) StatisticsRefreshInterval() (time.Duration, error) {
+	if table.StatisticsInterval == "" {
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(table.StatisticsInterval)
+	if err != nil {
+		return 0, errors.Wrapf(err, "logic table '%s' has invalid statistics interval %s",
+			table.TableName, table.StatisticsInterval)
+	}
+	return interval, nil
+}
+
+// Interval returns the pause between the chunks copied
+func (ddl *OnlineDDLConfig) Interval() (time.Duration, error) {
+	if ddl.ChunkInterval == "" {
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(ddl.ChunkInterval)
+	if err != nil {
+		return 0, errors.Wrapf(err, "online ddl has invalid chunk interval %s", ddl.ChunkInterval)
+	}
+	return interval, nil
+}
+
+// Delays returns the bounds of the hedging delay
+func (hedging *HedgingConfig) Delays() (minDelay time.Duration, maxDelay time.Duration, err error) {
+	if hedging.MinDelay != "" {
+		if minDelay, err = time.ParseDuration(hedging.MinDelay); err != nil {
+			return 0, 0, errors.Wrapf(err, "hedging has invalid min delay %s", hedging.MinDelay)
+		}
+	}
+	if hedging.MaxDelay != "" {
+		if maxDelay, err = time.ParseDuration(hedging.MaxDelay); err != nil {
+			return 0, 0, errors.Wrapf(err, "hedging has invalid max delay %s", hedging.MaxDelay)
+		}
+	}
+	if maxDelay != 0 && maxDelay < minDelay {
+		return 0, 0, errors.Errorf("hedging max delay %s is less than min delay %s", hedging.MaxDelay, hedging.MinDelay)
+	}
+	return minDelay, maxDelay, nil
+}
+
+// Length returns how long the window lasts
+func (window *MaintenanceWindow) Length() (time.Duration, error) {
+	length, err := time.ParseDuration(window.Duration)
+	if err != nil {
+		return 0, errors.Wrapf(err, "maintenance window '%s' has invalid duration %s", window.Schedule, window.Duration)
+	}
+	if length <= 0 {
+		return 0, errors.Errorf("maintenance window '%s' duration %s must be positive", window.Schedule, window.Duration)
+	}
+	return length, nil
+}
+
+// Location returns the time zone of the schedule of the window
+func (window *MaintenanceWindow) Location() (*time.Location, error) {
+	if window.TimeZone == "" {
+		return time.Local, nil
+	}
+	location, err := time.LoadLocation(window.TimeZone)
+	if err != nil {
+		return nil, errors.Wrapf(err, "maintenance window '%s' has invalid time zone %s", window.Schedule, window.TimeZone)
+	}
+	return location, nil
+}
+
+// Validate checks the concurrency config, and returns the queue timeout
+func (concurrency *ConcurrencyConfig) Validate() (time.Duration, error) {
+	if concurrency.MaxConcurrency <= 0 {
+		return 0, errors.Errorf("concurrency max_concurrency must be positive, got %d", concurrency.MaxConcurrency)
+	}
+	if concurrency.MaxQueueSize < 0 {
+		return 0, errors.Errorf("concurrency max_queue_size must not be negative, got %d", concurrency.MaxQueueSize)
+	}
+	switch concurrency.Queueing {
+	case "", QueueingFIFO, QueueingPriority:
+	default:
+		return 0, errors.Errorf("unsupported concurrency queueing '%s'", concurrency.Queueing)
+	}
+	if concurrency.QueueTimeout == "" {
+		return defaultQueueTimeout, nil
+	}
+	timeout, err := time.ParseDuration(concurrency.QueueTimeout)
+	if err != nil {
+		return 0, errors.Wrapf(err, "concurrency has invalid queue timeout %s", concurrency.QueueTimeout)
+	}
+	if timeout <= 0 {
+		return 0, errors.Errorf("concurrency queue timeout %s must be positive", concurrency.QueueTimeout)
+	}
+	return timeout, nil
+}
+
+// AnnotationFields are the placeholders of an annotation template.
+var AnnotationFields = []string{"session", "user", "route", "trace", "xid"}
+
+var annotationFieldRegex = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// Validate checks the annotation config, and returns its template
+func (annotation *AnnotationConfig) Validate() (string, error) {
+	if annotation.Template == "" {
+		return DefaultAnnotationTemplate, nil
+	}
+	if strings.Contains(annotation.Template, "*/") {
+		return "", errors.New("annotation template must not close the comment")
+	}
+	for _, match := range annotationFieldRegex.FindAllStringSubmatch(annotation.Template, -1) {
+		known := false
+		for _, field := range AnnotationFields {
+			if match[1] == field {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return "", errors.Errorf("annotation template has unknown field %s", match[0])
+		}
+	}
+	return annotation.Template, nil
+}
+
+// Interval returns the check interval, zero if it is not configured.
+func (galera *GaleraConfig) Interval() (time.Duration, error) {
+	if galera.CheckInterval == "" {
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(galera.CheckInterval)
+	if err != nil {
+		return 0, errors.Wrapf(err, "galera has invalid check interval %s", galera.CheckInterval)
+	}
+	if interval <= 0 {
+		return 0, errors.Errorf("galera check interval %s must be positive", galera.CheckInterval)
+	}
+	return interval, nil
+}
+
+func (aurora *AuroraConfig) Interval() (time.Duration, error) {
+	if aurora.RefreshInterval == "" {
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(aurora.RefreshInterval)
+	if err != nil {
+		return 0, errors.Wrapf(err, "aurora has invalid refresh interval %s", aurora.RefreshInterval)
+	}
+	if interval <= 0 {
+		return 0, errors.Errorf("aurora refresh interval %s must be positive", aurora.RefreshInterval)
+	}
+	return interval, nil
+}
+
+func (aurora *AuroraConfig) MaxLag() (time.Duration, error) {
+	if aurora.MaxReplicaLag == "" {
+		return 0, nil
+	}
+	lag, err := time.ParseDuration(aurora.MaxReplicaLag)
+	if err != nil {
+		return 0, errors.Wrapf(err, "aurora has invalid max replica lag %s", aurora.MaxReplicaLag)
+	}
+	if lag < 0 {
+		return 0, errors.Errorf("aurora max replica lag %s must not be negative", aurora.MaxReplicaLag)
+	}
+	return lag, nil
+}
+
+// Durations returns the probation period and the ramp interval, zero if they are not configured.
+func (rejoin *RejoinConfig) Durations() (probation time.Duration, rampInterval time.Duration, err error) {
+	if rejoin.ProbationPeriod != "" {
+		if probation, err = time.ParseDuration(rejoin.ProbationPeriod); err != nil {
+			return 0, 0, errors.Wrapf(err, "rejoin has invalid probation period %s", rejoin.ProbationPeriod)
+		}
+	}
+	if rejoin.RampInterval != "" {
+		if rampInterval, err = time.ParseDuration(rejoin.RampInterval); err != nil {
+			return 0, 0, errors.Wrapf(err, "rejoin has invalid ramp interval %s", rejoin.RampInterval)
+		}
+	}
+	if probation < 0 || rampInterval < 0 {
+		return 0, 0, errors.Errorf("rejoin probation period %s and ramp interval %s must not be negative",
+			rejoin.ProbationPeriod, rejoin.RampInterval)
+	}
+	if rejoin.RampSteps < 0 {
+		return 0, 0, errors.Errorf("rejoin ramp steps must not be negative, got %d", rejoin.RampSteps)
+	}
+	return probation, rampInterval, nil
+}
+
 func (dataSource *DataSourceRef) ParseWeight() (readWeight int, writeWeight int, err error) {
 	weightRegexp := regexp.MustCompile(weightRegex)
 	params := weightRegexp.FindStringSubmatch(dataSource.Weight)
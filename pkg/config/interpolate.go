@@ -0,0 +1,149 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// interpolate replaces the references to the environment variables in the values of node, so
+// that the same config file serves several environments. A reference is one of
+//   - ${NAME}, the value of the variable, which must be set
+//   - ${NAME:-default}, the value of the variable, or default if it is unset or empty
+//   - ${NAME:?message}, the value of the variable, the config fails to load with message if it is
+//     unset or empty
+//
+// The names are uppercase letters, digits and underscores, so that the lowercase variables of
+// the access log formats, such as ${remote_ip}, are kept. $${ is kept as ${.
+func interpolate(node *yaml.Node, lookup func(string) (string, bool), p *positions) error {
+	var missing []string
+	var walk func(node *yaml.Node)
+	walk = func(node *yaml.Node) {
+		switch node.Kind {
+		case yaml.ScalarNode:
+			value, errs := expand(node.Value, lookup)
+			for _, err := range errs {
+				missing = append(missing, fmt.Sprintf("%s: %s", p.position(node), err))
+			}
+			if value != node.Value {
+				node.Value = value
+				if node.Style&(yaml.SingleQuotedStyle|yaml.DoubleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+					// resolve the tag of the plain value again, e.g. a port is an int
+					node.Tag = ""
+				}
+			}
+		case yaml.MappingNode:
+			// the keys are not interpolated
+			for i := 1; i < len(node.Content); i += 2 {
+				walk(node.Content[i])
+			}
+		default:
+			for _, child := range node.Content {
+				walk(child)
+			}
+		}
+	}
+	walk(node)
+	if len(missing) > 0 {
+		return errors.Errorf("[config] interpolate environment variables failed, %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// expand returns value with the references to the environment variables replaced, and the
+// errors of the variables missing.
+func expand(value string, lookup func(string) (string, bool)) (string, []string) {
+	if !strings.Contains(value, "${") {
+		return value, nil
+	}
+	var (
+		sb   strings.Builder
+		errs []string
+	)
+	for {
+		i := strings.Index(value, "${")
+		if i < 0 {
+			sb.WriteString(value)
+			break
+		}
+		if i > 0 && value[i-1] == '$' {
+			// $${ is an escaped ${
+			sb.WriteString(value[:i-1])
+			sb.WriteString("${")
+			value = value[i+2:]
+			continue
+		}
+		sb.WriteString(value[:i])
+		end := strings.IndexByte(value[i:], '}')
+		if end < 0 {
+			sb.WriteString(value[i:])
+			break
+		}
+		reference := value[i+2 : i+end]
+		name, operator, argument := parseReference(reference)
+		if name == "" {
+			// not a reference to an environment variable, such as ${remote_ip}
+			sb.WriteString(value[i : i+end+1])
+			value = value[i+end+1:]
+			continue
+		}
+		v, ok := lookup(name)
+		switch operator {
+		case ":-":
+			if !ok || v == "" {
+				v = argument
+			}
+		case ":?":
+			if !ok || v == "" {
+				if argument == "" {
+					argument = "it is unset or empty"
+				}
+				errs = append(errs, fmt.Sprintf("%s: %s", name, argument))
+			}
+		default:
+			if !ok {
+				errs = append(errs, fmt.Sprintf("%s is not set", name))
+			}
+		}
+		sb.WriteString(v)
+		value = value[i+end+1:]
+	}
+	return sb.String(), errs
+}
+
+// parseReference splits the reference between ${ and } into the name of the variable, the
+// operator and its argument. The name is empty if the reference is not to an environment variable.
+func parseReference(reference string) (name, operator, argument string) {
+	name = reference
+	if i := strings.Index(reference, ":"); i >= 0 && i+1 < len(reference) &&
+		(reference[i+1] == '-' || reference[i+1] == '?') {
+		name, operator, argument = reference[:i], reference[i:i+2], reference[i+2:]
+	}
+	if name == "" {
+		return "", "", ""
+	}
+	for i, c := range name {
+		if !(c >= 'A' && c <= 'Z' || c == '_' || i > 0 && c >= '0' && c <= '9') {
+			return "", "", ""
+		}
+	}
+	return name, operator, argument
+}
@@ -0,0 +1,83 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpand(t *testing.T) {
+	env := map[string]string{"DB_HOST": "10.0.0.1", "DB_PASSWORD": "s3cr$t", "EMPTY": ""}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	testCases := []struct {
+		value    string
+		expanded string
+		errs     []string
+	}{
+		{value: "root:${DB_PASSWORD}@tcp(${DB_HOST}:3306)/employees", expanded: "root:s3cr$t@tcp(10.0.0.1:3306)/employees"},
+		{value: "${DB_PORT:-3306}", expanded: "3306"},
+		{value: "${EMPTY:-default}", expanded: "default"},
+		{value: "${EMPTY}", expanded: ""},
+		{value: "${DB_PORT}", expanded: "", errs: []string{"DB_PORT is not set"}},
+		{value: "${DB_PORT:?the port of the database}", expanded: "", errs: []string{"DB_PORT: the port of the database"}},
+		{value: "${remote_ip} ${user}", expanded: "${remote_ip} ${user}"},
+		{value: "$${DB_HOST} ${DB_HOST}", expanded: "${DB_HOST} 10.0.0.1"},
+		{value: "${DB_HOST", expanded: "${DB_HOST"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.value, func(t *testing.T) {
+			expanded, errs := expand(tc.value, lookup)
+			assert.Equal(t, tc.expanded, expanded)
+			assert.Equal(t, tc.errs, errs)
+		})
+	}
+}
+
+func TestParseInterpolated(t *testing.T) {
+	t.Setenv("DBPACK_PORT", "13307")
+	t.Setenv("DBPACK_DSN", "root:123456@tcp(127.0.0.1:3306)/employees")
+	content := `
+app_config:
+  svc:
+    listeners:
+      - protocol_type: mysql
+        socket_address:
+          port: ${DBPACK_PORT}
+        config:
+          access_log:
+            format: "${remote_ip} ${user}"
+    data_source_cluster:
+      - name: employees
+        dsn: ${DBPACK_DSN}
+`
+	conf, _, err := Parse([]byte(content))
+	assert.NoError(t, err)
+	app := conf.AppConfig["svc"]
+	assert.Equal(t, 13307, app.Listeners[0].SocketAddress.Port)
+	assert.Equal(t, "root:123456@tcp(127.0.0.1:3306)/employees", app.DataSources[0].DSN)
+	assert.Equal(t, Parameters{"format": "${remote_ip} ${user}"}, app.Listeners[0].Config["access_log"])
+
+	_, _, err = Parse([]byte("probe_port: ${DBPACK_PROBE_PORT}\ntracer:\n  exporter_type: ${DBPACK_EXPORTER:?set the exporter}\n"))
+	assert.EqualError(t, err, "[config] interpolate environment variables failed, line 1: DBPACK_PROBE_PORT is not set, "+
+		"line 3: DBPACK_EXPORTER: set the exporter")
+}
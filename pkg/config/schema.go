@@ -0,0 +1,440 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"encoding"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// The struct tags of the config fields besides yaml and json:
+//   - default is the value of a field absent from the config file, such as `default:"3s"`
+//   - deprecated marks a field still accepted but to be removed, its value tells what to use
+//     instead, such as `deprecated:"use foo instead"`
+const (
+	defaultTag    = "default"
+	deprecatedTag = "deprecated"
+)
+
+var (
+	durationType   = reflect.TypeOf(time.Duration(0))
+	parametersType = reflect.TypeOf(Parameters{})
+	executorType   = reflect.TypeOf(Executor{})
+
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+	// executorConfigTypes are the types of the configs of the executors by their modes
+	executorConfigTypes = map[ExecuteMode]reflect.Type{
+		SDB: reflect.TypeOf(SingleDBConfig{}),
+		RWS: reflect.TypeOf(ReadWriteSplittingConfig{}),
+		SHD: reflect.TypeOf(ShardingConfig{}),
+	}
+)
+
+// Warning is a problem of a config file which does not prevent it from being loaded, such as an
+// unknown field, which is usually a misspelled one, or a deprecated field.
+type Warning struct {
+	// Path is the path of the field, e.g. app_config.svc.listeners[0].socket_address
+	Path string `json:"path"`
+	// File is the file of the field if the config is composed of several files
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+func (w *Warning) String() string {
+	return fmt.Sprintf("%s: %s %s", position(w.File, w.Line), w.Path, w.Message)
+}
+
+// Parse parses the content of a config file and normalizes the config of each application. The
+// references to the environment variables are replaced by their values, the fields absent from
+// the content are set to their default values, and the unknown and deprecated fields are reported
+// as warnings.
+func Parse(content []byte) (*Configuration, []*Warning, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return nil, nil, errors.Wrap(err, "[config] yaml unmarshal config failed")
+	}
+	var node *yaml.Node
+	if len(root.Content) > 0 {
+		node = root.Content[0]
+	}
+	return parseNode(node, nil)
+}
+
+// Decode decodes content, a config item in yaml or json such as the spec of a kubernetes resource,
+// into out, a pointer to a config type. The fields absent from content are set to their default
+// values, and the unknown and deprecated fields are reported as warnings.
+func Decode(content []byte, out interface{}) ([]*Warning, error) {
+	v := reflect.ValueOf(out)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return nil, errors.Errorf("[config] decode into non-pointer %T", out)
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return nil, errors.Wrap(err, "[config] yaml unmarshal config failed")
+	}
+	var node *yaml.Node
+	if len(root.Content) > 0 {
+		node = root.Content[0]
+		if err := node.Decode(out); err != nil {
+			return nil, errors.Wrap(err, "[config] yaml unmarshal config failed")
+		}
+	}
+	r := &resolver{positions: &positions{}}
+	r.resolve(node, v.Type().Elem(), v.Elem(), "")
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.warnings, nil
+}
+
+// parseNode parses the config file node, files are the files the nodes were read from if the
+// config is composed of several files.
+func parseNode(node *yaml.Node, files map[*yaml.Node]string) (*Configuration, []*Warning, error) {
+	configuration := &Configuration{}
+	p := &positions{files: files}
+	if node != nil {
+		if err := interpolate(node, os.LookupEnv, p); err != nil {
+			return nil, nil, err
+		}
+		if err := node.Decode(configuration); err != nil {
+			return nil, nil, errors.Wrap(err, "[config] yaml unmarshal config failed")
+		}
+	}
+	r := &resolver{positions: p}
+	r.resolve(node, reflect.TypeOf(configuration).Elem(), reflect.ValueOf(configuration).Elem(), "")
+	if r.err != nil {
+		return nil, nil, r.err
+	}
+	if err := configuration.Readiness.Validate(); err != nil {
+		return nil, nil, errors.Wrap(err, "[config]")
+	}
+	for appID, config := range configuration.AppConfig {
+		config.AppID = appID
+		if err := config.Normalize(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return configuration, r.warnings, nil
+}
+
+// resolver walks a config file along the config types, it sets the default values of the absent
+// fields and collects the warnings.
+type resolver struct {
+	*positions
+	warnings []*Warning
+	err      error
+}
+
+// resolve walks node, which is nil if it is absent, as a value of type t. v is the value decoded
+// from node, an invalid value if it is not settable, in which case node is only checked.
+func (r *resolver) resolve(node *yaml.Node, t reflect.Type, v reflect.Value, path string) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		if v.IsValid() {
+			if v.IsNil() {
+				v = reflect.Value{}
+			} else {
+				v = v.Elem()
+			}
+		}
+	}
+	if v.IsValid() && !v.CanSet() {
+		v = reflect.Value{}
+	}
+	if node != nil && node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if isConfigType(t) {
+			r.resolveStruct(node, t, v, path)
+		}
+	case reflect.Slice:
+		if node == nil || node.Kind != yaml.SequenceNode {
+			return
+		}
+		for i, item := range node.Content {
+			var elem reflect.Value
+			if v.IsValid() && i < v.Len() {
+				elem = v.Index(i)
+			}
+			r.resolve(item, t.Elem(), elem, fmt.Sprintf("%s[%d]", path, i))
+		}
+	case reflect.Map:
+		if node == nil || node.Kind != yaml.MappingNode || t.Key().Kind() != reflect.String {
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			var elem reflect.Value
+			if v.IsValid() {
+				// the values of a map are settable only through pointers
+				elem = v.MapIndex(reflect.ValueOf(key).Convert(t.Key()))
+			}
+			r.resolve(node.Content[i+1], t.Elem(), elem, joinPath(path, key))
+		}
+	}
+}
+
+func (r *resolver) resolveStruct(node *yaml.Node, t reflect.Type, v reflect.Value, path string) {
+	if node != nil && node.Kind != yaml.MappingNode {
+		return
+	}
+	fields := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if name, ok := fieldName(t.Field(i)); ok {
+			fields[name] = i
+		}
+	}
+	values := make(map[string]*yaml.Node)
+	if node != nil {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			index, ok := fields[key.Value]
+			if !ok {
+				r.warn(key, joinPath(path, key.Value), "is an unknown field")
+				continue
+			}
+			if hint, ok := t.Field(index).Tag.Lookup(deprecatedTag); ok {
+				r.warn(key, joinPath(path, key.Value), "is deprecated, "+hint)
+			}
+			values[key.Value] = node.Content[i+1]
+		}
+	}
+	// the fields are walked in their order, so that the warnings are reported in a stable order
+	for index := 0; index < t.NumField(); index++ {
+		field := t.Field(index)
+		name, ok := fieldName(field)
+		if !ok {
+			continue
+		}
+		var value reflect.Value
+		if v.IsValid() {
+			value = v.Field(index)
+		}
+		child, present := values[name]
+		if !present && value.IsValid() && value.IsZero() {
+			if defaultValue, ok := field.Tag.Lookup(defaultTag); ok {
+				if err := setDefault(value, defaultValue); err != nil && r.err == nil {
+					r.err = errors.Wrapf(err, "[config] invalid default value of %s", joinPath(path, name))
+				}
+			}
+		}
+		if t == executorType && name == "config" {
+			r.resolveExecutorConfig(child, v, joinPath(path, name))
+			continue
+		}
+		r.resolve(child, field.Type, value, joinPath(path, name))
+	}
+}
+
+// resolveExecutorConfig checks the config of an executor as the config of its mode, the config is
+// decoded by the executor itself, so that its absent fields are not set.
+func (r *resolver) resolveExecutorConfig(node *yaml.Node, executor reflect.Value, path string) {
+	if !executor.IsValid() {
+		return
+	}
+	t, ok := executorConfigTypes[executor.Interface().(Executor).Mode]
+	if !ok {
+		return
+	}
+	r.resolve(node, t, reflect.Value{}, path)
+}
+
+func (r *resolver) warn(node *yaml.Node, path, message string) {
+	r.warnings = append(r.warnings, &Warning{Path: path, File: r.file(node), Line: node.Line, Message: message})
+}
+
+// isConfigType reports whether t is a type of this package, the types of the other packages, such
+// as the etcd client config, are decoded as they are.
+func isConfigType(t reflect.Type) bool {
+	return t.PkgPath() == executorType.PkgPath()
+}
+
+// fieldName returns the name of field in the config file.
+func fieldName(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+	tag := field.Tag.Get("yaml")
+	if tag == "-" {
+		return "", false
+	}
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name, true
+	}
+	return strings.ToLower(field.Name), true
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func setDefault(v reflect.Value, value string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return errors.Errorf("default value of %s is not supported", v.Type())
+	}
+	return nil
+}
+
+// Schema returns the json schema of the config file.
+func Schema() map[string]interface{} {
+	schema := typeSchema(reflect.TypeOf(Configuration{}))
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "dbpack configuration"
+	return schema
+}
+
+func typeSchema(t reflect.Type) map[string]interface{} {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == durationType {
+		return map[string]interface{}{"type": "string", "format": "duration"}
+	}
+	if t.Implements(textUnmarshalerType) || reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return map[string]interface{}{"type": "string"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		if t == parametersType || t.Elem().Kind() == reflect.Interface {
+			return map[string]interface{}{"type": "object"}
+		}
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		if !isConfigType(t) {
+			return map[string]interface{}{"type": "object"}
+		}
+		return structSchema(t)
+	}
+	return map[string]interface{}{}
+}
+
+func structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, ok := fieldName(field)
+		if !ok {
+			continue
+		}
+		property := typeSchema(field.Type)
+		if value, ok := field.Tag.Lookup(defaultTag); ok {
+			property["default"] = defaultSchemaValue(field.Type, value)
+		}
+		if hint, ok := field.Tag.Lookup(deprecatedTag); ok {
+			property["deprecated"] = true
+			property["description"] = "deprecated, " + hint
+		}
+		properties[name] = property
+	}
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if t == executorType {
+		// the config of an executor depends on its mode
+		conditions := make([]interface{}, 0, len(executorConfigTypes))
+		for _, mode := range []ExecuteMode{SDB, RWS, SHD} {
+			conditions = append(conditions, map[string]interface{}{
+				"if": map[string]interface{}{"properties": map[string]interface{}{
+					"mode": map[string]interface{}{"enum": []string{strings.ToLower(mode.String()), mode.String()}},
+				}},
+				"then": map[string]interface{}{"properties": map[string]interface{}{
+					"config": typeSchema(executorConfigTypes[mode]),
+				}},
+			})
+		}
+		schema["allOf"] = conditions
+	}
+	return schema
+}
+
+// defaultSchemaValue returns the default value of a field of type t as a json value.
+func defaultSchemaValue(t reflect.Type, value string) interface{} {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == durationType {
+		return value
+	}
+	v := reflect.New(t).Elem()
+	if err := setDefault(v, value); err != nil {
+		return value
+	}
+	return v.Interface()
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestParse(t *testing.T) {
+	content := `
+termination_drain_duration: 0s
+app_config:
+  svc:
+    listeners:
+      - protocol_type: mysql
+        socket_adress:
+          port: 13306
+        executor: redirect
+    executors:
+      - name: redirect
+        mode: rws
+        config:
+          load_balance_algorithm: RandomWeight
+          data_sources:
+            - name: employees-master
+              wieght: r0w10
+    data_source_cluster:
+      - name: employees-master
+        dsn: root:123456@tcp(127.0.0.1:3306)/employees
+        ping_times_for_change_status: 5
+`
+	conf, warnings, err := Parse([]byte(content))
+	assert.NoError(t, err)
+	// the fields set to zero keep their values, the absent ones are set to their defaults
+	assert.Equal(t, time.Duration(0), conf.TerminationDrainDuration)
+	assert.Equal(t, 18888, conf.ProbePort)
+	assert.Equal(t, 15*time.Minute, conf.TableMetaCacheTTL)
+
+	app := conf.AppConfig["svc"]
+	assert.Equal(t, "svc", app.AppID)
+	assert.Equal(t, SocketAddress{Address: "0.0.0.0", Port: 8881}, app.Listeners[0].SocketAddress)
+	assert.Equal(t, 20*time.Second, app.DataSources[0].PingInterval)
+	assert.Equal(t, 5, app.DataSources[0].PingTimesForChangeStatus)
+	assert.Nil(t, app.DistributedTransaction)
+
+	assert.Equal(t, []*Warning{
+		{Path: "app_config.svc.listeners[0].socket_adress", Line: 7, Message: "is an unknown field"},
+		{Path: "app_config.svc.executors[0].config.data_sources[0].wieght", Line: 17, Message: "is an unknown field"},
+	}, warnings)
+
+	_, _, err = Parse([]byte("app_config:\n  svc:\n    listeners:\n      - executor: missing\n"))
+	assert.Error(t, err)
+}
+
+type deprecatedTestConfig struct {
+	Interval time.Duration `default:"1m" yaml:"interval"`
+	Period   time.Duration `deprecated:"use interval instead" yaml:"period"`
+}
+
+func TestResolveDeprecated(t *testing.T) {
+	var node yaml.Node
+	assert.NoError(t, yaml.Unmarshal([]byte("period: 10s\n"), &node))
+	conf := &deprecatedTestConfig{}
+	assert.NoError(t, node.Content[0].Decode(conf))
+
+	r := &resolver{}
+	r.resolve(node.Content[0], reflect.TypeOf(conf), reflect.ValueOf(conf), "")
+	assert.NoError(t, r.err)
+	assert.Equal(t, time.Minute, conf.Interval)
+	assert.Equal(t, []*Warning{{Path: "period", Line: 1, Message: "is deprecated, use interval instead"}}, r.warnings)
+}
+
+func TestDecode(t *testing.T) {
+	dataSource := &DataSource{}
+	warnings, err := Decode([]byte(`{"dsn": "root:123456@tcp(127.0.0.1:3306)/employees", "idle_timeout": "1m", "wieght": 1}`), dataSource)
+	assert.NoError(t, err)
+	assert.Equal(t, "root:123456@tcp(127.0.0.1:3306)/employees", dataSource.DSN)
+	assert.Equal(t, time.Minute, dataSource.IdleTimeout)
+	assert.Equal(t, 20*time.Second, dataSource.PingInterval)
+	assert.Equal(t, []*Warning{{Path: "wieght", Line: 1, Message: "is an unknown field"}}, warnings)
+
+	executor := &Executor{}
+	warnings, err = Decode([]byte(`{"mode": "sdb", "config": {"data_source_ref": "employees", "data_source": "x"}}`), executor)
+	assert.NoError(t, err)
+	assert.Equal(t, SDB, executor.Mode)
+	assert.Equal(t, []*Warning{{Path: "config.data_source", Line: 1, Message: "is an unknown field"}}, warnings)
+
+	_, err = Decode([]byte(`{}`), Executor{})
+	assert.Error(t, err)
+}
+
+func TestSchema(t *testing.T) {
+	schema := Schema()
+	properties := schema["properties"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"type": "string", "format": "duration", "default": "3s"},
+		properties["termination_drain_duration"])
+	assert.Equal(t, map[string]interface{}{"type": "integer", "default": 18888}, properties["probe_port"])
+
+	app := properties["app_config"].(map[string]interface{})["additionalProperties"].(map[string]interface{})
+	executors := app["properties"].(map[string]interface{})["executors"].(map[string]interface{})
+	executor := executors["items"].(map[string]interface{})
+	assert.Len(t, executor["allOf"], 3)
+
+	deprecated := typeSchema(reflect.TypeOf(deprecatedTestConfig{}))["properties"].(map[string]interface{})
+	assert.Equal(t, true, deprecated["period"].(map[string]interface{})["deprecated"])
+}
@@ -21,6 +21,9 @@ import "github.com/cectc/dbpack/third_party/parser/format"
 const (
 	ConfigPathKey      = "config"
 	TransactionTimeout = "transaction-timeout"
+	// DataSourceRoute is the variable a pre filter sets to the name of the data source a statement
+	// outside transactions is sent to by a single db executor, instead of the data source of the executor
+	DataSourceRoute = "data-source-route"
 
 	DBPackRestoreFormat = format.DefaultRestoreFlags | format.RestoreStringWithoutDefaultCharset
 )
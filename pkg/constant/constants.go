@@ -35,6 +35,9 @@ package constant
 const (
 	DefaultMaxAllowedPacket = 4 << 20 // 4 MiB
 
+	// MaxMaxAllowedPacket is the largest max_allowed_packet mysql accepts.
+	MaxMaxAllowedPacket = 1 << 30 // 1 GiB
+
 	// MaxPacketSize is the maximum payload length of a packet
 	// the server supports.
 	MaxPacketSize = (1 << 24) - 1
@@ -57,6 +60,10 @@ const (
 	// MysqlDialog uses the dialog plugin on the client side.
 	// It transmits data in the clear.
 	MysqlDialog = "dialog"
+
+	// MariaDBClientEd25519 signs the salt with an Ed25519 key derived from the password,
+	// the client side of the ed25519 plugin of MariaDB.
+	MariaDBClientEd25519 = "client_ed25519"
 )
 
 // Capability flags.
@@ -82,8 +89,9 @@ const (
 	// CLIENT_NO_SCHEMA 1 << 4
 	// Do not permit database.table.column. We do permit it.
 
-	// CLIENT_COMPRESS 1 << 5
-	// We do not support compression. CPU is usually our bottleneck.
+	// CapabilityClientCompress is CLIENT_COMPRESS.
+	// Use the zlib compressed protocol after the handshake.
+	CapabilityClientCompress = 1 << 5
 
 	// CLIENT_ODBC 1 << 6
 	// No special behavior since 3.22.
@@ -130,6 +138,10 @@ const (
 	// Can send multiple resultsets for COM_QUERY.
 	CapabilityClientMultiResults = 1 << 17
 
+	// CapabilityClientPSMultiResults is CLIENT_PS_MULTI_RESULTS
+	// Can send multiple resultsets for COM_STMT_EXECUTE.
+	CapabilityClientPSMultiResults = 1 << 18
+
 	// CapabilityClientPluginAuth is CLIENT_PLUGIN_AUTH.
 	// Client supports plugin authentication.
 	CapabilityClientPluginAuth = 1 << 19
@@ -145,16 +157,54 @@ const (
 	// Announces support for expired password extension.
 	// Not yet supported.
 
-	// CLIENT_SESSION_TRACK 1 << 23
+	// CapabilityClientSessionTrack is CLIENT_SESSION_TRACK
 	// Can set SERVER_SESSION_STATE_CHANGED in the Status flags
 	// and send session-state change data after a OK packet.
-	// Not yet supported.
+	CapabilityClientSessionTrack = 1 << 23
 
 	// CapabilityClientDeprecateEOF is CLIENT_DEPRECATE_EOF
 	// Expects an OK (instead of EOF) after the resultset rows of a Text Resultset.
 	CapabilityClientDeprecateEOF = 1 << 24
+
+	// CLIENT_OPTIONAL_RESULTSET_METADATA 1 << 25
+	// Not yet supported.
+
+	// CapabilityClientZstdCompressionAlgorithm is CLIENT_ZSTD_COMPRESSION_ALGORITHM
+	// Use the zstd compressed protocol after the handshake, the compression
+	// level follows the connection attributes in Protocol::HandshakeResponse41.
+	CapabilityClientZstdCompressionAlgorithm = 1 << 26
+)
+
+// MariaDB extended capability flags, the upper 32 bits of the capabilities of MariaDB.
+// The servers clear CapabilityClientLongPassword (CLIENT_MYSQL) to tell they send them
+// in the last 4 reserved bytes of the initial handshake packet.
+// Originally found in include/mysql_com.h of MariaDB
+const (
+	// MariaDBCapabilityClientProgress is MARIADB_CLIENT_PROGRESS.
+	// Not yet supported.
+	MariaDBCapabilityClientProgress = 1 << 0
+
+	// MariaDBCapabilityClientComMulti is MARIADB_CLIENT_COM_MULTI.
+	// Not yet supported.
+	MariaDBCapabilityClientComMulti = 1 << 1
+
+	// MariaDBCapabilityClientStmtBulkOperations is MARIADB_CLIENT_STMT_BULK_OPERATIONS.
+	// Not yet supported.
+	MariaDBCapabilityClientStmtBulkOperations = 1 << 2
+
+	// MariaDBCapabilityClientExtendedTypeInfo is MARIADB_CLIENT_EXTENDED_TYPE_INFO.
+	// Not yet supported.
+	MariaDBCapabilityClientExtendedTypeInfo = 1 << 3
+
+	// MariaDBCapabilityClientCacheMetadata is MARIADB_CLIENT_CACHE_METADATA.
+	// Not yet supported.
+	MariaDBCapabilityClientCacheMetadata = 1 << 4
 )
 
+// MariaDBVersionPrefix prefixes the versions sent by MariaDB 10 and later, so that the
+// replication of the MySQL servers which only know versions 5.x keeps working.
+const MariaDBVersionPrefix = "5.5.5-"
+
 // Packet types.
 // Originally found in include/mysql/mysql_com.h
 const (
@@ -308,6 +358,14 @@ const (
 const (
 	// ERMaxRowsExceeded is when a user tries to select more rows than the max rows as enforced by vitess.
 	ERMaxRowsExceeded = 10001
+	// ERPoolTimeout is when a statement times out waiting for a backend connection.
+	ERPoolTimeout = 10002
+	// ERBackendWriteTimeout is when a statement times out sending it to the backend.
+	ERBackendWriteTimeout = 10003
+	// ERBackendReadTimeout is when a statement times out reading its result from the backend.
+	ERBackendReadTimeout = 10004
+	// ERRoutingFailed is when a statement can not be routed to the data sources.
+	ERRoutingFailed = 10005
 )
 
 // Error codes for server-side errors.
@@ -354,6 +412,8 @@ const (
 	ERNoSuchTable           = 1146
 	ERNonExistingTableGrant = 1147
 	ERKeyDoesNotExist       = 1176
+	// ERXAERNota is XAER_NOTA, an XA statement naming an unknown xid
+	ERXAERNota = 1397
 
 	// permissions
 	ERDBAccessDenied            = 1044
@@ -498,6 +558,8 @@ const (
 	ERDerivedMustHaveAlias         = 1248
 	ERTableNameNotAllowedHere      = 1250
 	ERQueryInterrupted             = 1317
+	ERQueryTimeout                 = 3024
+	ERClientInteractionTimeout     = 4031
 	ERTruncatedWrongValueForField  = 1366
 	ERDataTooLong                  = 1406
 	ERDataOutOfRange               = 1690
@@ -518,9 +580,18 @@ const (
 	// SSHandshakeError is ER_HANDSHAKE_ERROR
 	SSHandshakeError = "08S01"
 
+	// SSConCount is ER_CON_COUNT_ERROR
+	SSConCount = "08004"
+
 	// SSServerShutdown is ER_SERVER_SHUTDOWN
 	SSServerShutdown = "08S01"
 
+	// SSQueryInterrupted is ER_QUERY_INTERRUPTED
+	SSQueryInterrupted = "70100"
+
+	// SSNetPacketTooLarge is ER_NET_PACKET_TOO_LARGE
+	SSNetPacketTooLarge = "08S01"
+
 	// SSDataTooLong is ER_DATA_TOO_LONG
 	SSDataTooLong = "22001"
 
@@ -533,6 +604,15 @@ const (
 	// SSBadFieldError is ER_BAD_FIELD_ERROR
 	SSBadFieldError = "42S22"
 
+	// SSBadDbError is ER_BAD_DB_ERROR
+	SSBadDbError = "42000"
+
+	// SSParseError is ER_PARSE_ERROR
+	SSParseError = "42000"
+
+	// SSNoDb is ER_NO_DB_ERROR
+	SSNoDb = "3D000"
+
 	// SSDupKey is ER_DUP_KEY
 	SSDupKey = "23000"
 
@@ -558,6 +638,36 @@ const (
 
 	// ServerMoreResultsExists is SERVER_MORE_RESULTS_EXISTS
 	ServerMoreResultsExists = 0x0008
+
+	// ServerPSOutParams is SERVER_PS_OUT_PARAMS
+	// The resultset holds the output parameters of a stored procedure called by COM_STMT_EXECUTE.
+	ServerPSOutParams = 0x1000
+
+	// ServerSessionStateChanged is SERVER_SESSION_STATE_CHANGED
+	// The session state changes follow the info in the OK packet.
+	ServerSessionStateChanged = 0x4000
+)
+
+// Session state change types, sent in OK packets if CapabilityClientSessionTrack is set.
+// Originally found in include/mysql_com.h
+const (
+	// SessionTrackSystemVariables is SESSION_TRACK_SYSTEM_VARIABLES
+	SessionTrackSystemVariables = 0x00
+
+	// SessionTrackSchema is SESSION_TRACK_SCHEMA
+	SessionTrackSchema = 0x01
+
+	// SessionTrackStateChange is SESSION_TRACK_STATE_CHANGE
+	SessionTrackStateChange = 0x02
+
+	// SessionTrackGtids is SESSION_TRACK_GTIDS
+	SessionTrackGtids = 0x03
+
+	// SessionTrackTransactionCharacteristics is SESSION_TRACK_TRANSACTION_CHARACTERISTICS
+	SessionTrackTransactionCharacteristics = 0x04
+
+	// SessionTrackTransactionState is SESSION_TRACK_TRANSACTION_STATE
+	SessionTrackTransactionState = 0x05
 )
 
 // A few interesting character set values.
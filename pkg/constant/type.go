@@ -185,8 +185,13 @@ var typeToMySQL = map[FieldType]struct {
 }
 
 // TypeToMySQL returns the equivalent mysql type and flag for a vitess type.
+// Types that have no vitess equivalent (e.g. NEWDECIMAL, VARCHAR) are wire
+// types already, they are returned as is.
 func TypeToMySQL(typ FieldType) (mysqlType, flags int64) {
-	val := typeToMySQL[typ]
+	val, ok := typeToMySQL[typ]
+	if !ok {
+		return int64(typ), 0
+	}
 	return val.typ, val.flags
 }
 
@@ -220,6 +225,10 @@ const (
 	PreventNullInsertFlag uint = 1 << 20 /* Prevent this Field from inserting NULL values */
 )
 
+// ParamUnsignedFlag is the flag of the unsigned parameters of COM_STMT_EXECUTE, which is not
+// UnsignedFlag as that of the columns.
+const ParamUnsignedFlag = 0x80
+
 // TypeInt24 bounds.
 const (
 	MaxUint24 = 1<<24 - 1
@@ -0,0 +1,220 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package discovery finds the columns likely holding personal data, by their names and by
+// samples of their values, to help configuring the encryption of them.
+package discovery
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	CategoryEmail      = "email"
+	CategoryPhone      = "phone"
+	CategoryCardNumber = "card_number"
+	CategoryIDNumber   = "id_number"
+	CategoryAddress    = "address"
+	CategoryBirthday   = "birthday"
+)
+
+var (
+	emailRegex = regexp.MustCompile(`^[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}$`)
+	phoneRegex = regexp.MustCompile(`^\+?[0-9][0-9 ().\-]{5,20}[0-9]$`)
+	ssnRegex   = regexp.MustCompile(`^[0-9]{3}-[0-9]{2}-[0-9]{4}$`)
+
+	// valueDetectors detect the categories of the values, in the order they are tried
+	valueDetectors = []struct {
+		category string
+		detect   func(value string) bool
+	}{
+		{CategoryEmail, isEmail},
+		{CategoryCardNumber, isCardNumber},
+		{CategoryIDNumber, isIDNumber},
+		{CategoryPhone, isPhone},
+	}
+
+	// nameHints are the words of the column names hinting the categories of the columns
+	nameHints = map[string]string{
+		"email":       CategoryEmail,
+		"mail":        CategoryEmail,
+		"phone":       CategoryPhone,
+		"mobile":      CategoryPhone,
+		"tel":         CategoryPhone,
+		"telephone":   CategoryPhone,
+		"cellphone":   CategoryPhone,
+		"card":        CategoryCardNumber,
+		"pan":         CategoryCardNumber,
+		"cardno":      CategoryCardNumber,
+		"ssn":         CategoryIDNumber,
+		"idcard":      CategoryIDNumber,
+		"passport":    CategoryIDNumber,
+		"identity":    CategoryIDNumber,
+		"address":     CategoryAddress,
+		"addr":        CategoryAddress,
+		"street":      CategoryAddress,
+		"birthday":    CategoryBirthday,
+		"birth":       CategoryBirthday,
+		"dob":         CategoryBirthday,
+		"birthdate":   CategoryBirthday,
+		"dateofbirth": CategoryBirthday,
+	}
+)
+
+// DetectValue returns the category of the value, or an empty string if the value does not
+// look like personal data.
+func DetectValue(value string) string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return ""
+	}
+	for _, detector := range valueDetectors {
+		if detector.detect(value) {
+			return detector.category
+		}
+	}
+	return ""
+}
+
+// DetectName returns the category hinted by the column name, the name is split into words
+// by underscores and case changes, like card_no, cardNo or CardNo.
+func DetectName(name string) string {
+	words := splitName(name)
+	// joined words match the hints written as one word, like id_card
+	for i := 0; i+1 < len(words); i++ {
+		if category, ok := nameHints[words[i]+words[i+1]]; ok {
+			return category
+		}
+	}
+	for _, word := range words {
+		if category, ok := nameHints[word]; ok {
+			return category
+		}
+	}
+	return ""
+}
+
+func splitName(name string) []string {
+	var (
+		words []string
+		word  strings.Builder
+	)
+	flush := func() {
+		if word.Len() > 0 {
+			words = append(words, strings.ToLower(word.String()))
+			word.Reset()
+		}
+	}
+	runes := []rune(name)
+	for i, r := range runes {
+		switch {
+		case r == '_' || r == '-' || r == ' ' || r == '.':
+			flush()
+			continue
+		case r >= 'A' && r <= 'Z' && i > 0 && runes[i-1] >= 'a' && runes[i-1] <= 'z':
+			flush()
+		}
+		word.WriteRune(r)
+	}
+	flush()
+	return words
+}
+
+func isEmail(value string) bool {
+	return len(value) <= 254 && emailRegex.MatchString(value)
+}
+
+// isPhone matches the formatted phone numbers, and the 11 digits mobile numbers of china,
+// plain numbers of other lengths are too likely to be identifiers.
+func isPhone(value string) bool {
+	if !phoneRegex.MatchString(value) || ssnRegex.MatchString(value) {
+		return false
+	}
+	digits := onlyDigits(value)
+	if len(digits) < 7 || len(digits) > 15 {
+		return false
+	}
+	if len(digits) != len(value) {
+		return true
+	}
+	return len(digits) == 11 && digits[0] == '1'
+}
+
+// isCardNumber matches the payment card numbers of 13 to 19 digits passing the luhn check.
+func isCardNumber(value string) bool {
+	for _, r := range value {
+		if (r < '0' || r > '9') && r != ' ' && r != '-' {
+			return false
+		}
+	}
+	digits := onlyDigits(value)
+	if len(digits) < 13 || len(digits) > 19 || strings.Count(digits, digits[:1]) == len(digits) {
+		return false
+	}
+	return luhn(digits)
+}
+
+// isIDNumber matches the social security numbers of the us and the resident identity card
+// numbers of china.
+func isIDNumber(value string) bool {
+	if ssnRegex.MatchString(value) {
+		return value[:3] != "000" && value[:3] != "666" && value[4:6] != "00" && value[7:] != "0000"
+	}
+	return isResidentIDNumber(value)
+}
+
+func isResidentIDNumber(value string) bool {
+	if len(value) != 18 {
+		return false
+	}
+	weights := []int{7, 9, 10, 5, 8, 4, 2, 1, 6, 3, 7, 9, 10, 5, 8, 4, 2}
+	sum := 0
+	for i := 0; i < 17; i++ {
+		if value[i] < '0' || value[i] > '9' {
+			return false
+		}
+		sum += int(value[i]-'0') * weights[i]
+	}
+	return "10X98765432"[sum%11] == value[17] || (value[17] == 'x' && sum%11 == 2)
+}
+
+func luhn(digits string) bool {
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+func onlyDigits(value string) string {
+	var sb strings.Builder
+	for _, r := range value {
+		if r >= '0' && r <= '9' {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package discovery
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectValue(t *testing.T) {
+	testCases := []struct {
+		value  string
+		expect string
+	}{
+		{value: "scott@example.com", expect: CategoryEmail},
+		{value: "scott@localhost", expect: ""},
+		{value: "4111 1111 1111 1111", expect: CategoryCardNumber},
+		{value: "5500-0000-0000-0004", expect: CategoryCardNumber},
+		{value: "4111111111111112", expect: ""},
+		{value: "0000000000000000", expect: ""},
+		{value: "123-45-6789", expect: CategoryIDNumber},
+		{value: "000-45-6789", expect: ""},
+		{value: "11010519491231002X", expect: CategoryIDNumber},
+		{value: "110105194912310021", expect: ""},
+		{value: "+1 (415) 555-0132", expect: CategoryPhone},
+		{value: "13800138000", expect: CategoryPhone},
+		{value: "1000234", expect: ""},
+		{value: "d001", expect: ""},
+		{value: "  ", expect: ""},
+	}
+	for _, c := range testCases {
+		t.Run(c.value, func(t *testing.T) {
+			assert.Equal(t, c.expect, DetectValue(c.value))
+		})
+	}
+}
+
+func TestDetectName(t *testing.T) {
+	testCases := []struct {
+		name   string
+		expect string
+	}{
+		{name: "email", expect: CategoryEmail},
+		{name: "contact_mail", expect: CategoryEmail},
+		{name: "mobilePhone", expect: CategoryPhone},
+		{name: "CardNo", expect: CategoryCardNumber},
+		{name: "id_card", expect: CategoryIDNumber},
+		{name: "home_address", expect: CategoryAddress},
+		{name: "birth_date", expect: CategoryBirthday},
+		{name: "hotel_name", expect: ""},
+		{name: "dept_no", expect: ""},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expect, DetectName(c.name))
+		})
+	}
+}
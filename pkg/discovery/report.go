@@ -0,0 +1,77 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package discovery
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// WriteReport writes the findings as a table.
+func WriteReport(w io.Writer, findings []*Finding) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SCHEMA\tTABLE\tCOLUMN\tTYPE\tCATEGORY\tCONFIDENCE\tMATCHED")
+	for _, f := range findings {
+		matched := "-"
+		if f.Sampled > 0 {
+			matched = fmt.Sprintf("%d/%d", f.Matched, f.Sampled)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			f.Schema, f.Table, f.Column, f.DataType, f.Category, f.Confidence, matched)
+	}
+	return tw.Flush()
+}
+
+// WriteCryptoFilterStub writes a CryptoFilter config encrypting the columns of the findings,
+// the columns detected by their names only are commented out to be reviewed.
+func WriteCryptoFilterStub(w io.Writer, findings []*Finding) error {
+	var sb strings.Builder
+	sb.WriteString("- name: cryptoFilter\n")
+	sb.WriteString("  kind: CryptoFilter\n")
+	sb.WriteString("  conf:\n")
+	sb.WriteString("    # the encrypted values are hex encoded, the columns must hold more than twice as many\n")
+	sb.WriteString("    # characters as the plain values\n")
+	sb.WriteString("    column_crypto_list:\n")
+	for i := 0; i < len(findings); {
+		j := i
+		var columns, reviews []string
+		for ; j < len(findings) && findings[j].Schema == findings[i].Schema && findings[j].Table == findings[i].Table; j++ {
+			entry := fmt.Sprintf("%q", findings[j].Column)
+			if findings[j].Confidence == ConfidenceLow {
+				reviews = append(reviews, fmt.Sprintf("%s (%s)", entry, findings[j].Category))
+			} else {
+				columns = append(columns, entry)
+			}
+		}
+		prefix := ""
+		if len(columns) == 0 {
+			prefix = "# "
+		}
+		sb.WriteString(fmt.Sprintf("      # schema %s\n", findings[i].Schema))
+		sb.WriteString(fmt.Sprintf("      %s- table: %s\n", prefix, findings[i].Table))
+		sb.WriteString(fmt.Sprintf("      %s  columns: [ %s ]\n", prefix, strings.Join(columns, ", ")))
+		if len(reviews) > 0 {
+			sb.WriteString(fmt.Sprintf("      #   review, detected by the column names only: %s\n", strings.Join(reviews, ", ")))
+		}
+		sb.WriteString(fmt.Sprintf("      %s  aeskey: <16, 24 or 32 bytes key>\n", prefix))
+		i = j
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
@@ -0,0 +1,238 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package discovery
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// ConfidenceHigh is reported for the columns detected by both their names and values.
+	ConfidenceHigh = "high"
+	// ConfidenceMedium is reported for the columns detected by their values.
+	ConfidenceMedium = "medium"
+	// ConfidenceLow is reported for the columns detected by their names only, the values of
+	// them are not sampled or do not look like the category.
+	ConfidenceLow = "low"
+
+	DefaultSampleSize = 100
+	DefaultThreshold  = 0.5
+)
+
+// textTypes are the data types of the columns whose values are sampled.
+var textTypes = map[string]bool{
+	"char":       true,
+	"varchar":    true,
+	"tinytext":   true,
+	"text":       true,
+	"mediumtext": true,
+	"longtext":   true,
+}
+
+// Finding is a column likely holding personal data.
+type Finding struct {
+	Schema     string `json:"schema"`
+	Table      string `json:"table"`
+	Column     string `json:"column"`
+	DataType   string `json:"data_type"`
+	Category   string `json:"category"`
+	Confidence string `json:"confidence"`
+	// Sampled is the number of the non empty values sampled
+	Sampled int `json:"sampled"`
+	// Matched is the number of the sampled values of the category
+	Matched int `json:"matched"`
+}
+
+type column struct {
+	schema   string
+	table    string
+	name     string
+	dataType string
+}
+
+// Scanner scans the columns of schemas for personal data.
+type Scanner struct {
+	db *sql.DB
+	// sampleSize is the number of the rows sampled of each table
+	sampleSize int
+	// threshold is the minimal ratio of the sampled values of a category, for the column to
+	// be reported as the category
+	threshold float64
+}
+
+func NewScanner(db *sql.DB, sampleSize int, threshold float64) (*Scanner, error) {
+	if sampleSize < 0 {
+		return nil, errors.Errorf("sample size must not be negative, got %d", sampleSize)
+	}
+	if threshold <= 0 || threshold > 1 {
+		return nil, errors.Errorf("threshold must be in (0, 1], got %v", threshold)
+	}
+	return &Scanner{db: db, sampleSize: sampleSize, threshold: threshold}, nil
+}
+
+// Scan reports the columns of the schemas likely holding personal data, the values of the
+// text columns are sampled unless the sample size is 0.
+func (s *Scanner) Scan(ctx context.Context, schemas []string) ([]*Finding, error) {
+	if len(schemas) == 0 {
+		return nil, errors.New("no schema to scan")
+	}
+	columns, err := s.columns(ctx, schemas)
+	if err != nil {
+		return nil, err
+	}
+	var findings []*Finding
+	for i := 0; i < len(columns); {
+		j := i
+		for j < len(columns) && columns[j].schema == columns[i].schema && columns[j].table == columns[i].table {
+			j++
+		}
+		tableFindings, err := s.scanTable(ctx, columns[i:j])
+		if err != nil {
+			return nil, err
+		}
+		findings = append(findings, tableFindings...)
+		i = j
+	}
+	return findings, nil
+}
+
+func (s *Scanner) columns(ctx context.Context, schemas []string) ([]*column, error) {
+	args := make([]interface{}, 0, len(schemas))
+	for _, schema := range schemas {
+		args = append(args, schema)
+	}
+	rows, err := s.db.QueryContext(ctx, "SELECT TABLE_SCHEMA, TABLE_NAME, COLUMN_NAME, DATA_TYPE "+
+		"FROM information_schema.COLUMNS WHERE TABLE_SCHEMA IN (?"+strings.Repeat(", ?", len(schemas)-1)+") "+
+		"ORDER BY TABLE_SCHEMA, TABLE_NAME, ORDINAL_POSITION", args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query columns")
+	}
+	defer rows.Close()
+	var columns []*column
+	for rows.Next() {
+		c := &column{}
+		if err = rows.Scan(&c.schema, &c.table, &c.name, &c.dataType); err != nil {
+			return nil, errors.Wrap(err, "failed to read columns")
+		}
+		c.dataType = strings.ToLower(c.dataType)
+		columns = append(columns, c)
+	}
+	return columns, errors.Wrap(rows.Err(), "failed to read columns")
+}
+
+func (s *Scanner) scanTable(ctx context.Context, columns []*column) ([]*Finding, error) {
+	var sampledColumns []*column
+	for _, c := range columns {
+		if textTypes[c.dataType] {
+			sampledColumns = append(sampledColumns, c)
+		}
+	}
+	// counts are the numbers of the sampled values of each category, by column
+	counts := make([]map[string]int, len(sampledColumns))
+	sampled := make([]int, len(sampledColumns))
+	if s.sampleSize > 0 && len(sampledColumns) > 0 {
+		if err := s.sample(ctx, sampledColumns, counts, sampled); err != nil {
+			return nil, err
+		}
+	}
+
+	var findings []*Finding
+	for _, c := range columns {
+		finding := &Finding{Schema: c.schema, Table: c.table, Column: c.name, DataType: c.dataType}
+		nameCategory := DetectName(c.name)
+		for i, sampledColumn := range sampledColumns {
+			if sampledColumn != c || sampled[i] == 0 {
+				continue
+			}
+			finding.Sampled = sampled[i]
+			category, matched := majority(counts[i])
+			if float64(matched) >= s.threshold*float64(sampled[i]) {
+				finding.Category, finding.Matched = category, matched
+			} else if nameCategory != "" {
+				finding.Matched = counts[i][nameCategory]
+			}
+		}
+		switch {
+		case finding.Category != "" && finding.Category == nameCategory:
+			finding.Confidence = ConfidenceHigh
+		case finding.Category != "":
+			finding.Confidence = ConfidenceMedium
+		case nameCategory != "":
+			finding.Category, finding.Confidence = nameCategory, ConfidenceLow
+		default:
+			continue
+		}
+		findings = append(findings, finding)
+	}
+	return findings, nil
+}
+
+func (s *Scanner) sample(ctx context.Context, columns []*column, counts []map[string]int, sampled []int) error {
+	names := make([]string, 0, len(columns))
+	for _, c := range columns {
+		names = append(names, quote(c.name))
+	}
+	table := quote(columns[0].schema) + "." + quote(columns[0].table)
+	rows, err := s.db.QueryContext(ctx, "SELECT "+strings.Join(names, ", ")+" FROM "+table+" LIMIT ?", s.sampleSize)
+	if err != nil {
+		return errors.Wrapf(err, "failed to sample table %s", table)
+	}
+	defer rows.Close()
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+		counts[i] = make(map[string]int)
+	}
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return errors.Wrapf(err, "failed to read samples of table %s", table)
+		}
+		for i, value := range values {
+			if !value.Valid || strings.TrimSpace(value.String) == "" {
+				continue
+			}
+			sampled[i]++
+			if category := DetectValue(value.String); category != "" {
+				counts[i][category]++
+			}
+		}
+	}
+	return errors.Wrapf(rows.Err(), "failed to read samples of table %s", table)
+}
+
+// majority returns the category of the most values.
+func majority(counts map[string]int) (string, int) {
+	var (
+		category string
+		max      int
+	)
+	for _, detector := range valueDetectors {
+		if counts[detector.category] > max {
+			category, max = detector.category, counts[detector.category]
+		}
+	}
+	return category, max
+}
+
+func quote(identifier string) string {
+	return "`" + strings.ReplaceAll(identifier, "`", "``") + "`"
+}
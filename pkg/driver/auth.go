@@ -298,6 +298,14 @@ func (conn *BackendConnection) auth(authData []byte, plugin string) ([]byte, err
 		authResp := scramblePassword(authData[:20], conn.conf.Passwd)
 		return authResp, nil
 
+	case constant.MariaDBClientEd25519:
+		// https://mariadb.com/kb/en/authentication-plugin-ed25519/
+		// The server sends a 32 bytes scramble, after the plugin name in the auth switch request.
+		if len(authData) < 32 {
+			return nil, err2.ErrMalformedPkt
+		}
+		return scrambleEd25519Password(authData[:32], conn.conf.Passwd), nil
+
 	case "sha256_password":
 		//if len(mc.cfg.Passwd) == 0 {
 		//	return []byte{0}, nil
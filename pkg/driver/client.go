@@ -22,22 +22,28 @@ import (
 	"io"
 	"net"
 	"strings"
+	"time"
 
+	"github.com/cectc/dbpack/pkg/config"
 	"github.com/cectc/dbpack/pkg/constant"
 	err2 "github.com/cectc/dbpack/pkg/errors"
+	"github.com/cectc/dbpack/pkg/log"
 	"github.com/cectc/dbpack/pkg/misc"
 	"github.com/cectc/dbpack/pkg/mysql"
 	"github.com/cectc/dbpack/pkg/packet"
 	"github.com/cectc/dbpack/pkg/tracing"
+	"github.com/cectc/dbpack/pkg/usage"
 	"github.com/cectc/dbpack/third_party/pools"
 )
 
 type Connector struct {
 	dataSourceName string
 	conf           *Config
+	tcp            *config.TCPConfig
 }
 
-func NewConnector(dataSourceName, dsn string) (*Connector, error) {
+// NewConnector creates a connector of the data source, tcp may be nil.
+func NewConnector(dataSourceName, dsn string, tcp *config.TCPConfig) (*Connector, error) {
 	cfg, err := ParseDSN(dsn)
 	if err != nil {
 		return nil, err
@@ -45,11 +51,12 @@ func NewConnector(dataSourceName, dsn string) (*Connector, error) {
 	return &Connector{
 		dataSourceName: dataSourceName,
 		conf:           cfg,
+		tcp:            tcp,
 	}, nil
 }
 
 func (c *Connector) NewBackendConnection(ctx context.Context) (pools.Resource, error) {
-	conn := &BackendConnection{dataSourceName: c.dataSourceName, conf: c.conf}
+	conn := &BackendConnection{dataSourceName: c.dataSourceName, conf: c.conf, tcp: c.tcp}
 	err := conn.Connect(ctx)
 	return conn, err
 }
@@ -60,19 +67,29 @@ type BackendConnection struct {
 	dataSourceName string
 
 	conf *Config
+	tcp  *config.TCPConfig
 
 	// capabilities is the current set of features this connection
 	// is using.  It is the features that are both supported by
 	// the client and the server, and currently in use.
 	// It is set during the initial handshake.
 	//
-	// It is only used for CapabilityClientDeprecateEOF
-	// and CapabilityClientFoundRows.
+	// It is only used for CapabilityClientDeprecateEOF,
+	// CapabilityClientFoundRows and CapabilityClientSessionTrack.
 	capabilities uint32
 
 	serverVersion string
 
+	// mariadbCapabilities is the extended capabilities of a MariaDB server, none of which is used.
+	mariadbCapabilities uint32
+
 	characterSet uint8
+
+	// compress is the compression algorithm negotiated in the handshake, empty if not compressed.
+	compress string
+
+	// sessionState is the session state changes of the last OK packet read by ReadComQueryResponse.
+	sessionState []byte
 }
 
 func (conn *BackendConnection) DataSourceName() string {
@@ -93,7 +110,15 @@ func (conn *BackendConnection) Connect(ctx context.Context) error {
 		netConn net.Conn
 		err     error
 	)
-	if conn.conf.Timeout > 0 {
+	if dial, ok := getDialContext(typ); ok {
+		dialCtx := ctx
+		if conn.conf.Timeout > 0 {
+			var cancel context.CancelFunc
+			dialCtx, cancel = context.WithTimeout(ctx, conn.conf.Timeout)
+			defer cancel()
+		}
+		netConn, err = dial(dialCtx, conn.conf.Addr)
+	} else if conn.conf.Timeout > 0 {
 		netConn, err = net.DialTimeout(typ, conn.conf.Addr, conn.conf.Timeout)
 	} else {
 		netConn, err = net.Dial(typ, conn.conf.Addr)
@@ -101,19 +126,14 @@ func (conn *BackendConnection) Connect(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	tcpConn := netConn.(*net.TCPConn)
-	// SetNoDelay controls whether the operating system should delay packet transmission
-	// in hopes of sending fewer packets (Nagle's algorithm).
-	// The default is true (no delay),
-	// meaning that Content is sent as soon as possible after a Write.
-	if err := tcpConn.SetNoDelay(true); err != nil {
-		return err
-	}
-	if err := tcpConn.SetKeepAlive(true); err != nil {
-		return err
+	if tcpConn, ok := netConn.(*net.TCPConn); ok {
+		if err := setTCPOptions(tcpConn, conn.tcp); err != nil {
+			netConn.Close()
+			return err
+		}
 	}
 
-	conn.Conn = mysql.NewConn(tcpConn)
+	conn.Conn = mysql.NewConn(netConn)
 
 	return conn.clientHandshake()
 }
@@ -133,6 +153,12 @@ func (conn *BackendConnection) clientHandshake() error {
 	if !conn.conf.DisableClientDeprecateEOF {
 		conn.capabilities = capabilities & (constant.CapabilityClientDeprecateEOF)
 	}
+	conn.capabilities |= capabilities & constant.CapabilityClientSessionTrack
+	conn.compress = negotiateCompression(conn.conf.Compress, capabilities)
+	if conn.compress != conn.conf.Compress {
+		log.Warnf("data source %s does not support %s compression, fall back to %q", conn.dataSourceName,
+			conn.conf.Compress, conn.compress)
+	}
 
 	//// Password encryption.
 	//scrambledPassword := ScramblePassword(salt, []byte(conn.Passwd))
@@ -157,6 +183,14 @@ func (conn *BackendConnection) clientHandshake() error {
 		return err
 	}
 
+	// Both sides switch to the compressed protocol after the auth result.
+	if conn.compress != "" {
+		if err := conn.EnableCompression(conn.compress, conn.conf.ZstdLevel); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+
 	// If the server didn't support DbName in its handshake, set
 	// it now. This is what the 'mysql' client does.
 	if capabilities&constant.CapabilityClientConnectWithDB == 0 && conn.conf.DBName != "" {
@@ -186,6 +220,23 @@ func (conn *BackendConnection) clientHandshake() error {
 	return nil
 }
 
+// negotiateCompression returns the compression algorithm to use given the one configured
+// and the capabilities of the server, it falls back from zstd to zlib and then to none.
+func negotiateCompression(compress string, capabilities uint32) string {
+	switch compress {
+	case mysql.CompressionZstd:
+		if capabilities&constant.CapabilityClientZstdCompressionAlgorithm != 0 {
+			return mysql.CompressionZstd
+		}
+		fallthrough
+	case mysql.CompressionZlib:
+		if capabilities&constant.CapabilityClientCompress != 0 {
+			return mysql.CompressionZlib
+		}
+	}
+	return ""
+}
+
 // parseInitialHandshakePacket parses the initial handshake from the server.
 // It returns a SQLError with the right code.
 func (conn *BackendConnection) parseInitialHandshakePacket(data []byte) (uint32, []byte, string, error) {
@@ -215,6 +266,9 @@ func (conn *BackendConnection) parseInitialHandshakePacket(data []byte) (uint32,
 	if !ok {
 		return 0, nil, "", err2.NewSQLError(constant.CRMalformedPacket, constant.SSUnknownSQLState, "parseInitialHandshakePacket: packet has no server version")
 	}
+	if strings.HasPrefix(conn.serverVersion, constant.MariaDBVersionPrefix) && strings.Contains(conn.serverVersion, "MariaDB") {
+		conn.serverVersion = conn.serverVersion[len(constant.MariaDBVersionPrefix):]
+	}
 
 	// Read the connection id.
 	connectionID, pos, ok := misc.ReadUint32(data, pos)
@@ -283,7 +337,13 @@ func (conn *BackendConnection) parseInitialHandshakePacket(data []byte) (uint32,
 		}
 	}
 
-	// 10 reserved 0 bytes.
+	// 10 reserved 0 bytes, but for the MariaDB servers, whose extended capabilities are the last 4 bytes.
+	if capabilities&constant.CapabilityClientLongPassword == 0 {
+		conn.mariadbCapabilities, _, ok = misc.ReadUint32(data, pos+6)
+		if !ok {
+			return 0, nil, "", err2.NewSQLError(constant.CRMalformedPacket, constant.SSUnknownSQLState, "parseInitialHandshakePacket: packet has no extended capability flags")
+		}
+	}
 	pos += 10
 
 	if capabilities&constant.CapabilityClientSecureConnection != 0 {
@@ -332,17 +392,27 @@ func (conn *BackendConnection) writeHandshakeResponse41(capabilities uint32, scr
 		constant.CapabilityClientSecureConnection |
 		constant.CapabilityClientMultiStatements |
 		constant.CapabilityClientMultiResults |
+		constant.CapabilityClientPSMultiResults |
 		constant.CapabilityClientPluginAuth |
 		constant.CapabilityClientPluginAuthLenencClientData |
 		// If the server supported
 		// CapabilityClientDeprecateEOF, we also support it.
-		conn.capabilities&constant.CapabilityClientDeprecateEOF
+		conn.capabilities&constant.CapabilityClientDeprecateEOF |
+		// Same for CapabilityClientSessionTrack.
+		conn.capabilities&constant.CapabilityClientSessionTrack
 
 	if conn.conf.ClientFoundRows {
 		// Pass-through ClientFoundRows flag.
 		flags |= constant.CapabilityClientFoundRows
 	}
 
+	switch conn.compress {
+	case mysql.CompressionZlib:
+		flags |= constant.CapabilityClientCompress
+	case mysql.CompressionZstd:
+		flags |= constant.CapabilityClientZstdCompressionAlgorithm
+	}
+
 	// FIXME(alainjobart) add multi statement.
 
 	length :=
@@ -353,8 +423,11 @@ func (conn *BackendConnection) writeHandshakeResponse41(capabilities uint32, scr
 			misc.LenNullString(conn.conf.User) +
 			// length of scrambled password is handled below.
 			len(scrambledPassword) +
-			21 + // "mysql_native_password" string.
-			1 // terminating zero.
+			misc.LenNullString(plugin)
+
+	if conn.compress == mysql.CompressionZstd {
+		length++ // zstd compression level.
+	}
 
 	// Add the DB name if the server supports it.
 	if conn.conf.DBName != "" && (capabilities&constant.CapabilityClientConnectWithDB != 0) {
@@ -380,7 +453,8 @@ func (conn *BackendConnection) writeHandshakeResponse41(capabilities uint32, scr
 	// Character set.
 	pos = misc.WriteByte(data, pos, byte(constant.Collations[conn.conf.Collation]))
 
-	// 23 reserved bytes, all 0.
+	// 23 reserved bytes, all 0. The last 4 are the extended capabilities for MariaDB servers
+	// unless CapabilityClientLongPassword is set, which tells them the client does not use any.
 	pos = misc.WriteZeroes(data, pos, 23)
 
 	// Username
@@ -404,6 +478,15 @@ func (conn *BackendConnection) writeHandshakeResponse41(capabilities uint32, scr
 	// Assume native client during response
 	pos = misc.WriteNullString(data, pos, plugin)
 
+	// zstd compression level, there are no connection attributes before it.
+	if conn.compress == mysql.CompressionZstd {
+		level := conn.conf.ZstdLevel
+		if level == 0 {
+			level = mysql.DefaultZstdCompressionLevel
+		}
+		pos = misc.WriteByte(data, pos, byte(level))
+	}
+
 	// Sanity-check the length.
 	if pos != len(data) {
 		return err2.NewSQLError(constant.CRMalformedPacket, constant.SSUnknownSQLState, "writeHandshakeResponse41: only packed %v bytes, out of %v allocated", pos, len(data))
@@ -526,6 +609,7 @@ func (conn *BackendConnection) ReadQueryResult(ctx context.Context, wantFields b
 		return &mysql.Result{
 			AffectedRows: affectedRows,
 			InsertId:     lastInsertID,
+			SessionState: conn.sessionState,
 		}, more, warnings, nil
 	}
 
@@ -576,12 +660,14 @@ func (conn *BackendConnection) ReadQueryResult(ctx context.Context, wantFields b
 
 	// read each row until EOF or OK packet.
 	for {
-		data, err := conn.ReadPacket()
+		data, err := conn.ReadEphemeralPacket()
 		if err != nil {
+			result.Release()
 			return nil, false, 0, err
 		}
 
 		if packet.IsEOFPacket(data) {
+			defer conn.RecycleReadPacket()
 			// Strip the partial Fields before returning.
 			if !wantFields {
 				result.Fields = nil
@@ -590,23 +676,24 @@ func (conn *BackendConnection) ReadQueryResult(ctx context.Context, wantFields b
 
 			// The deprecated EOF packets change means that this is either an
 			// EOF packet or an OK packet with the EOF type code.
+			var statusFlags uint16
 			if conn.capabilities&constant.CapabilityClientDeprecateEOF == 0 {
-				warnings, more, err = packet.ParseEOFPacket(data)
-				if err != nil {
-					return nil, false, 0, err
-				}
+				warnings, statusFlags, err = packet.ParseEOFPacketStatus(data)
 			} else {
-				var statusFlags uint16
 				_, _, statusFlags, warnings, err = packet.ParseOKPacket(data)
-				if err != nil {
-					return nil, false, 0, err
-				}
-				more = (statusFlags & constant.ServerMoreResultsExists) != 0
 			}
+			if err != nil {
+				result.Release()
+				return nil, false, 0, err
+			}
+			more = (statusFlags & constant.ServerMoreResultsExists) != 0
+			result.OutParams = (statusFlags & constant.ServerPSOutParams) != 0
 			return result, more, warnings, nil
 
 		} else if packet.IsErrorPacket(data) {
 			// Error packet.
+			defer conn.RecycleReadPacket()
+			result.Release()
 			return nil, false, 0, packet.ParseErrorPacket(data)
 		}
 
@@ -618,16 +705,38 @@ func (conn *BackendConnection) ReadQueryResult(ctx context.Context, wantFields b
 		//	return nil, false, 0, err2.NewSQLError(constant.ERMaxRowsExceeded, constant.SSUnknownSQLState, "Row count exceeded %d")
 		//}
 
-		// Regular row.
-		row, err := conn.ParseRow(ctx, data, result.Fields)
+		// Regular row, copied into the pooled buffers of the result.
+		err = result.AppendRow(ctx, data)
+		conn.RecycleReadPacket()
 		if err != nil {
+			result.Release()
 			return nil, false, 0, err
 		}
-		result.Rows = append(result.Rows, row)
 	}
 }
 
+// ReadQueryResults gets all the results of the last written statement, a CALL returns its result sets
+// followed by the OK packet of the procedure, which are chained by mysql.Result.Next. The connection
+// is not free until all of them are read. The warnings are the ones of the last result.
+func (conn *BackendConnection) ReadQueryResults(ctx context.Context, wantFields bool) (result *mysql.Result, warnings uint16, err error) {
+	result, more, warnings, err := conn.ReadQueryResult(ctx, wantFields)
+	if err != nil {
+		return nil, 0, err
+	}
+	last := result
+	for more {
+		last.Next, more, warnings, err = conn.ReadQueryResult(ctx, wantFields)
+		if err != nil {
+			result.Release()
+			return nil, 0, err
+		}
+		last = last.Next
+	}
+	return result, warnings, nil
+}
+
 func (conn *BackendConnection) ReadComQueryResponse() (affectedRows uint64, lastInsertID uint64, status int, more bool, warnings uint16, err error) {
+	conn.sessionState = nil
 	data, err := conn.ReadEphemeralPacket()
 	if err != nil {
 		return 0, 0, 0, false, 0, err2.NewSQLError(constant.CRServerLost, constant.SSUnknownSQLState, "%v", err)
@@ -639,6 +748,11 @@ func (conn *BackendConnection) ReadComQueryResponse() (affectedRows uint64, last
 
 	switch data[0] {
 	case constant.OKPacket:
+		if conn.capabilities&constant.CapabilityClientSessionTrack != 0 {
+			affectedRows, lastInsertID, status, warnings, sessionState, err := packet.ParseOKPacketWithSessionState(data)
+			conn.sessionState = sessionState
+			return affectedRows, lastInsertID, 0, (status & constant.ServerMoreResultsExists) != 0, warnings, err
+		}
 		affectedRows, lastInsertID, status, warnings, err := packet.ParseOKPacket(data)
 		return affectedRows, lastInsertID, 0, (status & constant.ServerMoreResultsExists) != 0, warnings, err
 	case constant.ErrPacket:
@@ -918,6 +1032,8 @@ func (conn *BackendConnection) ExecuteMulti(ctx context.Context, query string, w
 // Execute API.
 func (conn *BackendConnection) ExecuteWithWarningCount(ctx context.Context, query string, wantFields bool) (result *mysql.Result, warnings uint16, err error) {
 	_, span := tracing.GetTraceSpan(ctx, tracing.ConnQuery)
+	defer accountBackend(ctx, time.Now(), &result)
+	defer conn.bindDeadline(ctx)(&err)
 	defer func() {
 		if err != nil {
 			if sqlerr, ok := err.(*err2.SQLError); ok {
@@ -933,7 +1049,7 @@ func (conn *BackendConnection) ExecuteWithWarningCount(ctx context.Context, quer
 		return nil, 0, err
 	}
 
-	result, _, warnings, err = conn.ReadQueryResult(ctx, wantFields)
+	result, warnings, err = conn.ReadQueryResults(ctx, wantFields)
 	return
 }
 
@@ -948,6 +1064,8 @@ func (conn *BackendConnection) PrepareExecuteArgs(ctx context.Context, query str
 func (conn *BackendConnection) PrepareQueryArgs(ctx context.Context, query string, args []interface{}) (Result *mysql.Result, warnings uint16, err error) {
 	_, span := tracing.GetTraceSpan(ctx, tracing.ConnStmtExecute)
 	defer span.End()
+	defer accountBackend(ctx, time.Now(), &Result)
+	defer conn.bindDeadline(ctx)(&err)
 
 	stmt, err := conn.prepare(query)
 	if err != nil {
@@ -957,6 +1075,53 @@ func (conn *BackendConnection) PrepareQueryArgs(ctx context.Context, query strin
 	return stmt.queryArgs(ctx, args)
 }
 
+// bindDeadline bounds the statement by the deadline of ctx, the returned function clears the deadline
+// once the statement is done, and replaces err by the timeout error of the phase the statement timed
+// out in, sending it or reading its result. The connection timed out is closed, as the rest of the
+// result is left unread.
+func (conn *BackendConnection) bindDeadline(ctx context.Context) func(err *error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return func(*error) {}
+	}
+	if err := conn.SetStatementDeadline(deadline); err != nil {
+		log.Warnf("%s: failed to set the statement deadline: %v", conn.dataSourceName, err)
+	}
+	return func(err *error) {
+		if clearErr := conn.SetStatementDeadline(time.Time{}); clearErr != nil {
+			log.Warnf("%s: failed to clear the statement deadline: %v", conn.dataSourceName, clearErr)
+		}
+		if *err == nil || time.Now().Before(deadline) {
+			return
+		}
+		// the errors of the server are returned as they are, the packets are written before they are
+		// wrapped as CRServerGone
+		code, phase := constant.ERBackendWriteTimeout, "sending it"
+		if sqlErr, ok := (*err).(*err2.SQLError); ok {
+			switch sqlErr.Num {
+			case constant.CRServerGone:
+			case constant.CRServerLost:
+				code, phase = constant.ERBackendReadTimeout, "reading its result"
+			default:
+				return
+			}
+		}
+		conn.Close()
+		*err = err2.NewSQLError(code, constant.SSUnknownSQLState,
+			"statement timed out %s on data source %s: %v", phase, conn.dataSourceName, *err)
+	}
+}
+
+// accountBackend accounts the time since start and the rows of the result to the statement
+// of ctx, result points to the named result of the caller.
+func accountBackend(ctx context.Context, start time.Time, result **mysql.Result) {
+	rows := 0
+	if *result != nil {
+		rows = len((*result).Rows)
+	}
+	usage.AddBackend(ctx, time.Since(start), rows)
+}
+
 func (conn *BackendConnection) PrepareExecute(ctx context.Context, query string, data []byte) (result *mysql.Result, warnings uint16, err error) {
 	stmt, err := conn.prepare(query)
 	if err != nil {
@@ -0,0 +1,241 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package driver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	err2 "github.com/cectc/dbpack/pkg/errors"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+func TestNegotiateCompression(t *testing.T) {
+	testCases := []struct {
+		compress     string
+		capabilities uint32
+		expected     string
+	}{
+		{"", constant.CapabilityClientCompress | constant.CapabilityClientZstdCompressionAlgorithm, ""},
+		{mysql.CompressionZlib, constant.CapabilityClientCompress, mysql.CompressionZlib},
+		{mysql.CompressionZlib, constant.CapabilityClientZstdCompressionAlgorithm, ""},
+		{mysql.CompressionZstd, constant.CapabilityClientCompress | constant.CapabilityClientZstdCompressionAlgorithm, mysql.CompressionZstd},
+		{mysql.CompressionZstd, constant.CapabilityClientCompress, mysql.CompressionZlib},
+		{mysql.CompressionZstd, 0, ""},
+	}
+	for _, c := range testCases {
+		assert.Equal(t, c.expected, negotiateCompression(c.compress, c.capabilities))
+	}
+}
+
+func TestParseMariaDBInitialHandshakePacket(t *testing.T) {
+	data := []byte{constant.ProtocolVersion}
+	data = append(data, "5.5.5-10.6.12-MariaDB-log\x00"...)
+	data = append(data, 7, 0, 0, 0)
+	data = append(data, "12345678"...)
+	// filler, then the lower capabilities without CLIENT_MYSQL, the charset and the status
+	data = append(data, 0, 0xfe, 0xf7, 0x21, 0x02, 0x00)
+	// the upper capabilities with CLIENT_PLUGIN_AUTH and the length of the auth plugin data
+	data = append(data, 0xff, 0x81, 21)
+	data = append(data, 0, 0, 0, 0, 0, 0, 0x1d, 0, 0, 0)
+	data = append(data, "abcdefghijkl\x00"...)
+	data = append(data, "client_ed25519\x00"...)
+
+	conn := &BackendConnection{Conn: mysql.NewConn(nil)}
+	capabilities, salt, plugin, err := conn.parseInitialHandshakePacket(data)
+	assert.Nil(t, err)
+	assert.Zero(t, capabilities&constant.CapabilityClientLongPassword)
+	assert.NotZero(t, capabilities&constant.CapabilityClientPluginAuth)
+	assert.Equal(t, "10.6.12-MariaDB-log", conn.serverVersion)
+	assert.Equal(t, uint32(constant.MariaDBCapabilityClientProgress|constant.MariaDBCapabilityClientStmtBulkOperations|
+		constant.MariaDBCapabilityClientExtendedTypeInfo|constant.MariaDBCapabilityClientCacheMetadata), conn.mariadbCapabilities)
+	assert.Equal(t, []byte("12345678abcdefghijkl"), salt)
+	assert.Equal(t, constant.MariaDBClientEd25519, plugin)
+}
+
+func TestWriteHandshakeResponse41WithPlugin(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	conn := &BackendConnection{
+		Conn: mysql.NewConn(client),
+		conf: &Config{User: "dksl", Collation: constant.DefaultCollation},
+	}
+	go func() {
+		assert.Nil(t, conn.writeHandshakeResponse41(constant.CapabilityClientPluginAuth, make([]byte, 64), constant.MariaDBClientEd25519))
+	}()
+	data, err := mysql.NewConn(server).ReadPacket()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte(constant.MariaDBClientEd25519+"\x00"), data[len(data)-len(constant.MariaDBClientEd25519)-1:])
+}
+
+func TestReadQueryResults(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	conn := &BackendConnection{Conn: mysql.NewConn(client)}
+	go func() {
+		// the result set of a CALL followed by the OK packet of the procedure
+		c := mysql.NewConn(server)
+		assert.Nil(t, c.WriteFields(0, []*mysql.Field{{Name: "id", FieldType: constant.FieldTypeLongLong}}))
+		assert.Nil(t, c.WritePacket([]byte{1, '1'}))
+		assert.Nil(t, c.WriteEndResultWithFlags(0, constant.ServerMoreResultsExists, 0, 0, 0))
+		assert.Nil(t, c.WriteOKPacket(0, 0, 0, 1))
+	}()
+	ctx := proto.WithCommandType(context.Background(), constant.ComQuery)
+	result, warnings, err := conn.ReadQueryResults(ctx, true)
+	assert.Nil(t, err)
+	defer result.Release()
+	assert.Equal(t, uint16(1), warnings)
+	assert.Equal(t, "id", result.Fields[0].Name)
+	assert.Len(t, result.Rows, 1)
+	assert.NotNil(t, result.Next)
+	assert.Nil(t, result.Next.Fields)
+	assert.Nil(t, result.Next.Next)
+}
+
+func TestStatementDeadline(t *testing.T) {
+	testCases := []struct {
+		name   string
+		read   bool
+		errNum int
+	}{
+		{
+			name:   "the backend does not read the statement",
+			errNum: constant.ERBackendWriteTimeout,
+		},
+		{
+			name:   "the backend does not answer the statement",
+			read:   true,
+			errNum: constant.ERBackendReadTimeout,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer server.Close()
+			conn := &BackendConnection{Conn: mysql.NewConn(client), dataSourceName: "employees"}
+			if c.read {
+				go func() {
+					_, _ = mysql.NewConn(server).ReadPacket()
+				}()
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			ctx = proto.WithCommandType(ctx, constant.ComQuery)
+			_, _, err := conn.ExecuteWithWarningCount(ctx, "SELECT 1", true)
+			sqlErr, ok := err.(*err2.SQLError)
+			if assert.True(t, ok, "%v", err) {
+				assert.Equal(t, c.errNum, sqlErr.Num)
+			}
+			assert.True(t, conn.IsClosed())
+			assert.True(t, conn.StatementDeadline().IsZero())
+		})
+	}
+}
+
+func TestPrepareQueryArgsRelaysServerErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		// respond answers the COM_STMT_PREPARE and the COM_STMT_EXECUTE
+		respond func(c *mysql.Conn) error
+		errNum  int
+		state   string
+		message string
+	}{
+		{
+			name: "the statement fails to prepare",
+			respond: func(c *mysql.Conn) error {
+				return c.WriteErrorPacket(constant.ERParseError, constant.SSParseError,
+					"You have an error in your SQL syntax; near 'INSER' at line 1")
+			},
+			errNum:  constant.ERParseError,
+			state:   constant.SSParseError,
+			message: "You have an error in your SQL syntax; near 'INSER' at line 1",
+		},
+		{
+			name: "the statement fails to execute",
+			respond: func(c *mysql.Conn) error {
+				if err := c.WritePacket([]byte{constant.OKPacket, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}); err != nil {
+					return err
+				}
+				c.ResetSequence()
+				if _, err := c.ReadPacket(); err != nil {
+					return err
+				}
+				return c.WriteErrorPacket(constant.ERDupEntry, constant.SSDupKey,
+					"Duplicate entry '1' for key 'PRIMARY'")
+			},
+			errNum:  constant.ERDupEntry,
+			state:   constant.SSDupKey,
+			message: "Duplicate entry '1' for key 'PRIMARY'",
+		},
+		{
+			name: "the statement fails after its columns are sent",
+			respond: func(c *mysql.Conn) error {
+				if err := c.WritePacket([]byte{constant.OKPacket, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}); err != nil {
+					return err
+				}
+				c.ResetSequence()
+				if _, err := c.ReadPacket(); err != nil {
+					return err
+				}
+				if err := c.WriteFields(0, []*mysql.Field{{Name: "id", FieldType: constant.FieldTypeLongLong}}); err != nil {
+					return err
+				}
+				return c.WriteErrorPacket(constant.ERLockWaitTimeout, constant.SSUnknownSQLState,
+					"Lock wait timeout exceeded; try restarting transaction")
+			},
+			errNum:  constant.ERLockWaitTimeout,
+			state:   constant.SSUnknownSQLState,
+			message: "Lock wait timeout exceeded; try restarting transaction",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+			conn := &BackendConnection{Conn: mysql.NewConn(client), conf: &Config{MaxAllowedPacket: 1 << 20},
+				dataSourceName: "employees"}
+			go func() {
+				sc := mysql.NewConn(server)
+				if _, err := sc.ReadPacket(); err != nil {
+					return
+				}
+				assert.NoError(t, c.respond(sc))
+			}()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			ctx = proto.WithCommandType(ctx, constant.ComStmtExecute)
+			_, _, err := conn.PrepareQueryArgs(ctx, "INSERT INTO employees(id) VALUES (1)", nil)
+			sqlErr, ok := err.(*err2.SQLError)
+			if assert.True(t, ok, "%v", err) {
+				assert.Equal(t, c.errNum, sqlErr.Num)
+				assert.Equal(t, c.state, sqlErr.State)
+				assert.Equal(t, c.message, sqlErr.Message)
+			}
+			assert.False(t, conn.IsClosed())
+		})
+	}
+}
@@ -32,6 +32,7 @@ import (
 	"github.com/cectc/dbpack/pkg/constant"
 	err2 "github.com/cectc/dbpack/pkg/errors"
 	"github.com/cectc/dbpack/pkg/misc"
+	"github.com/cectc/dbpack/pkg/mysql"
 )
 
 type Config struct {
@@ -51,6 +52,8 @@ type Config struct {
 	Timeout          time.Duration     // Dial timeout
 	ReadTimeout      time.Duration     // I/O read timeout
 	WriteTimeout     time.Duration     // I/O write timeout
+	Compress         string            // Compression algorithm, zlib or zstd, empty if not compressed
+	ZstdLevel        int               // Compression level of zstd
 
 	AllowAllFiles             bool // Allow all files to be used with LOAD DATA LOCAL INFILE
 	AllowCleartextPasswords   bool // Allows the cleartext client side plugin
@@ -230,6 +233,23 @@ func ParseDSN(dsn string) (cfg *Config, err error) {
 	return
 }
 
+// ReplaceDSNAddr returns dsn with its network address replaced by addr, the other parts of the
+// dsn are kept as they are. dsn must have a network address, e.g. user:password@tcp(host:3306)/db.
+func ReplaceDSNAddr(dsn, addr string) (string, error) {
+	// the network address is between the last '@' and the last '/', as parsed by ParseDSN
+	i := strings.LastIndexByte(dsn, '/')
+	if i < 0 {
+		return "", err2.ErrInvalidDSNNoSlash
+	}
+	j := strings.LastIndexByte(dsn[:i], '@')
+	k := strings.IndexByte(dsn[j+1:i], '(')
+	if k < 0 || i == 0 || dsn[i-1] != ')' {
+		return "", err2.ErrInvalidDSNAddr
+	}
+	k += j + 1
+	return dsn[:k+1] + addr + dsn[i-1:], nil
+}
+
 // parseDSNParams parses the DSN "query string"
 // Values must be url.QueryEscape'ed
 func parseDSNParams(cfg *Config, params string) (err error) {
@@ -303,7 +323,29 @@ func parseDSNParams(cfg *Config, params string) (err error) {
 
 		// Compression
 		case "compress":
-			return errors.New("compression not implemented yet")
+			switch strings.ToLower(value) {
+			case mysql.CompressionZlib, mysql.CompressionZstd:
+				cfg.Compress = strings.ToLower(value)
+			default:
+				var isBool, compress bool
+				compress, isBool = misc.ReadBool(value)
+				if !isBool {
+					return errors.New("invalid compression algorithm: " + value)
+				}
+				cfg.Compress = ""
+				if compress {
+					cfg.Compress = mysql.CompressionZlib
+				}
+			}
+
+		case "zstdCompressionLevel":
+			cfg.ZstdLevel, err = strconv.Atoi(value)
+			if err != nil {
+				return
+			}
+			if cfg.ZstdLevel < 1 || cfg.ZstdLevel > 22 {
+				return errors.New("invalid zstd compression level: " + value)
+			}
 
 		// Enable client side placeholder substitution
 		case "interpolateParams":
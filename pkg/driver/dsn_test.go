@@ -111,6 +111,12 @@ var testDSNs = []struct {
 }, {
 	"tcp(127.0.0.1)/dbname",
 	&Config{Net: "tcp", Addr: "127.0.0.1:3306", DBName: "dbname", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: constant.DefaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, DisableClientDeprecateEOF: true},
+}, {
+	"user:password@tcp(localhost:5555)/dbname?compress=true",
+	&Config{User: "user", Passwd: "password", Net: "tcp", Addr: "localhost:5555", DBName: "dbname", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: constant.DefaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, DisableClientDeprecateEOF: true, Compress: "zlib"},
+}, {
+	"user:password@tcp(localhost:5555)/dbname?compress=zstd&zstdCompressionLevel=7",
+	&Config{User: "user", Passwd: "password", Net: "tcp", Addr: "localhost:5555", DBName: "dbname", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: constant.DefaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, DisableClientDeprecateEOF: true, Compress: "zstd", ZstdLevel: 7},
 }, {
 	"tcp(de:ad:be:ef::ca:fe)/dbname",
 	&Config{Net: "tcp", Addr: "[de:ad:be:ef::ca:fe]:3306", DBName: "dbname", Collation: "utf8mb4_general_ci", Loc: time.UTC, MaxAllowedPacket: constant.DefaultMaxAllowedPacket, AllowNativePasswords: true, CheckConnLiveness: true, DisableClientDeprecateEOF: true},
@@ -143,6 +149,8 @@ func TestDSNParserInvalid(t *testing.T) {
 		"User:pass@tcp(1.2.3.4:3306)",           // no trailing slash
 		"net()/",                                // unknown default addr
 		"user:pass@tcp(127.0.0.1:3306)/db/name", // invalid dbname
+		"/dbname?compress=lz4",                  // unsupported compression algorithm
+		"/dbname?zstdCompressionLevel=23",       // invalid zstd compression level
 		//"/dbname?arg=/some/unescaped/path",
 	}
 
@@ -153,6 +161,31 @@ func TestDSNParserInvalid(t *testing.T) {
 	}
 }
 
+func TestReplaceDSNAddr(t *testing.T) {
+	var dsns = []struct {
+		in  string
+		out string
+	}{
+		{"user:pass@tcp(db:3306)/dbname?timeout=1s", "user:pass@tcp(10.0.0.1:3307)/dbname?timeout=1s"},
+		{"user:p@ss(w)rd/@tcp(db)/dbname", "user:p@ss(w)rd/@tcp(10.0.0.1:3307)/dbname"},
+		{"tcp(db:3306)/", "tcp(10.0.0.1:3307)/"},
+	}
+	for i, tst := range dsns {
+		dsn, err := ReplaceDSNAddr(tst.in, "10.0.0.1:3307")
+		if err != nil {
+			t.Errorf("%d. ReplaceDSNAddr(%q) failed: %v", i, tst.in, err)
+		} else if dsn != tst.out {
+			t.Errorf("%d. ReplaceDSNAddr(%q) = %q, want %q", i, tst.in, dsn, tst.out)
+		}
+	}
+
+	for i, tst := range []string{"user:pass@tcp/dbname", "user:pass@tcp(db:3306)", "/dbname"} {
+		if _, err := ReplaceDSNAddr(tst, "10.0.0.1:3307"); err == nil {
+			t.Errorf("%d. ReplaceDSNAddr(%q) didn't error!", i, tst)
+		}
+	}
+}
+
 func TestDSNServerPubKey(t *testing.T) {
 	baseDSN := "User:password@tcp(localhost:5555)/dbname?serverPubKey="
 
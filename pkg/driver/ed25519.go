@@ -0,0 +1,135 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package driver
+
+import (
+	"crypto/sha512"
+	"math/big"
+)
+
+// The curve of Ed25519, -x^2 + y^2 = 1 + d*x^2*y^2 over the field of p, whose base point B
+// generates a group of order l.
+var (
+	ed25519P, _  = new(big.Int).SetString("57896044618658097711785492504343953926634992332820282019728792003956564819949", 10)
+	ed25519D, _  = new(big.Int).SetString("37095705934669439343138083508754565189542113879843219016388785533085940283555", 10)
+	ed25519L, _  = new(big.Int).SetString("7237005577332262213973186563042994240857116359379907606001950938285454250989", 10)
+	ed25519Bx, _ = new(big.Int).SetString("15112221349535400772501151409588531511454012693041857206046113283949847762202", 10)
+	ed25519By, _ = new(big.Int).SetString("46316835694926478169428394003475163141307993866256225615783033603165251855960", 10)
+)
+
+// edPoint is a point of the curve in extended coordinates, x = X/Z, y = Y/Z and x*y = T/Z.
+type edPoint struct {
+	X, Y, Z, T *big.Int
+}
+
+func edBasePoint() *edPoint {
+	return &edPoint{
+		X: new(big.Int).Set(ed25519Bx),
+		Y: new(big.Int).Set(ed25519By),
+		Z: big.NewInt(1),
+		T: edMod(new(big.Int).Mul(ed25519Bx, ed25519By)),
+	}
+}
+
+func edMod(x *big.Int) *big.Int {
+	return x.Mod(x, ed25519P)
+}
+
+// add adds q to p, the formulas are complete so that they double as well.
+func (p *edPoint) add(q *edPoint) *edPoint {
+	a := edMod(new(big.Int).Mul(new(big.Int).Sub(p.Y, p.X), new(big.Int).Sub(q.Y, q.X)))
+	b := edMod(new(big.Int).Mul(new(big.Int).Add(p.Y, p.X), new(big.Int).Add(q.Y, q.X)))
+	c := edMod(new(big.Int).Mul(new(big.Int).Mul(p.T, q.T), new(big.Int).Lsh(ed25519D, 1)))
+	d := edMod(new(big.Int).Lsh(new(big.Int).Mul(p.Z, q.Z), 1))
+	e := new(big.Int).Sub(b, a)
+	f := new(big.Int).Sub(d, c)
+	g := new(big.Int).Add(d, c)
+	h := new(big.Int).Add(b, a)
+	return &edPoint{
+		X: edMod(new(big.Int).Mul(e, f)),
+		Y: edMod(new(big.Int).Mul(g, h)),
+		Z: edMod(new(big.Int).Mul(f, g)),
+		T: edMod(new(big.Int).Mul(e, h)),
+	}
+}
+
+// edScalarBaseMult returns s*B.
+func edScalarBaseMult(s *big.Int) *edPoint {
+	result := &edPoint{X: big.NewInt(0), Y: big.NewInt(1), Z: big.NewInt(1), T: big.NewInt(0)}
+	base := edBasePoint()
+	for i := 0; i < s.BitLen(); i++ {
+		if s.Bit(i) == 1 {
+			result = result.add(base)
+		}
+		base = base.add(base)
+	}
+	return result
+}
+
+// bytes encodes the point as y in little endian, with the sign of x in the top bit.
+func (p *edPoint) bytes() []byte {
+	zInv := new(big.Int).ModInverse(p.Z, ed25519P)
+	x := edMod(new(big.Int).Mul(p.X, zInv))
+	y := edMod(new(big.Int).Mul(p.Y, zInv))
+	b := edLittleEndian(y)
+	b[31] |= byte(x.Bit(0) << 7)
+	return b
+}
+
+func edLittleEndian(x *big.Int) []byte {
+	b := make([]byte, 32)
+	x.FillBytes(b)
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
+
+func edScalar(b []byte) *big.Int {
+	reversed := make([]byte, len(b))
+	for i := range b {
+		reversed[len(b)-1-i] = b[i]
+	}
+	return new(big.Int).SetBytes(reversed)
+}
+
+// scrambleEd25519Password signs the scramble of the client_ed25519 plugin of MariaDB, which is Ed25519 with
+// the SHA-512 of the password, of any length, in place of the hash of a 32 bytes seed. The signature is not
+// computed in constant time, which only the password of the client itself is exposed to, on the client.
+func scrambleEd25519Password(scramble []byte, password string) []byte {
+	h := sha512.Sum512([]byte(password))
+	h[0] &= 248
+	h[31] &= 127
+	h[31] |= 64
+	a := edScalar(h[:32])
+	publicKey := edScalarBaseMult(a).bytes()
+
+	digest := sha512.New()
+	digest.Write(h[32:])
+	digest.Write(scramble)
+	r := new(big.Int).Mod(edScalar(digest.Sum(nil)), ed25519L)
+	R := edScalarBaseMult(r).bytes()
+
+	digest.Reset()
+	digest.Write(R)
+	digest.Write(publicKey)
+	digest.Write(scramble)
+	k := new(big.Int).Mod(edScalar(digest.Sum(nil)), ed25519L)
+	s := new(big.Int).Mul(k, a)
+	s.Add(s, r).Mod(s, ed25519L)
+	return append(R, edLittleEndian(s)...)
+}
@@ -0,0 +1,32 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package driver
+
+import (
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScrambleEd25519Password(t *testing.T) {
+	scramble := []byte("0123456789abcdef0123456789abcdef")
+	// a password of 32 bytes is a seed of Ed25519, whose signatures are deterministic
+	password := "a password of exactly 32 bytes.."
+	expected := ed25519.Sign(ed25519.NewKeyFromSeed([]byte(password)), scramble)
+	assert.Equal(t, []byte(expected), scrambleEd25519Password(scramble, password))
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package memory provides the memory network of data sources, the data sources whose DSN use it,
+// such as root:123456@memory(world_0)/world, are served by an in-memory database running in the
+// dbpack process instead of a mysql server. It lets the sharding and the read write splitting of
+// an application be demoed and integration tested locally without mysql servers:
+//
+//	data_source_cluster:
+//	  - name: world_0
+//	    dsn: root:123456@memory(world_0)/world
+//
+// The data sources sharing an address share the database, so that a replica is given the
+// address of its master. The username and the password are not checked.
+package memory
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/cectc/dbpack/pkg/driver"
+)
+
+// Network is the network of the DSNs of the memory data sources.
+const Network = "memory"
+
+var (
+	storesLock sync.Mutex
+	stores     = make(map[string]*Store)
+)
+
+func init() {
+	driver.RegisterDialContext(Network, Dial)
+}
+
+// Open returns the store of the memory data sources of address addr, the store is created the
+// first time it is opened.
+func Open(addr string) *Store {
+	storesLock.Lock()
+	defer storesLock.Unlock()
+	store, ok := stores[addr]
+	if !ok {
+		store = NewStore()
+		stores[addr] = store
+	}
+	return store
+}
+
+// Dial connects to the store of address addr, the other end of the returned connection is
+// served as a mysql server would.
+func Dial(ctx context.Context, addr string) (net.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	client, server := net.Pipe()
+	go newConn(server, Open(addr)).serve()
+	return client, nil
+}
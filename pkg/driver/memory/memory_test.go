@@ -0,0 +1,103 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package memory
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/driver"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+func connect(t *testing.T, dsn string) *driver.BackendConnection {
+	connector, err := driver.NewConnector("memory", dsn, nil)
+	assert.NoError(t, err)
+	conn, err := connector.NewBackendConnection(context.Background())
+	assert.NoError(t, err)
+	return conn.(*driver.BackendConnection)
+}
+
+func decode(t *testing.T, result *mysql.Result) [][]interface{} {
+	var rows [][]interface{}
+	for _, row := range result.Rows {
+		values, err := row.Decode()
+		assert.NoError(t, err)
+		var vals []interface{}
+		for _, value := range values {
+			if value == nil {
+				vals = append(vals, nil)
+				continue
+			}
+			vals = append(vals, value.Val)
+		}
+		rows = append(rows, vals)
+	}
+	return rows
+}
+
+func TestDial(t *testing.T) {
+	queryCtx := proto.WithCommandType(context.Background(), constant.ComQuery)
+	executeCtx := proto.WithCommandType(context.Background(), constant.ComStmtExecute)
+	conn := connect(t, "root:123456@memory(test_dial)/world")
+	defer conn.Close()
+
+	_, err := conn.Execute(queryCtx, "CREATE TABLE city (id BIGINT PRIMARY KEY AUTO_INCREMENT, name VARCHAR(35), population DOUBLE)", false)
+	assert.NoError(t, err)
+	result, _, err := conn.PrepareExecuteArgs(executeCtx, "INSERT INTO city (name, population) VALUES (?, ?), (?, ?)",
+		[]interface{}{"Kabul", 1780000.0, "Herat", nil})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), result.AffectedRows)
+	assert.Equal(t, uint64(1), result.InsertId)
+
+	// the text protocol
+	result, err = conn.Execute(queryCtx, "SELECT id, name, population FROM city ORDER BY id", true)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]interface{}{
+		{[]byte("1"), []byte("Kabul"), []byte("1.78e+06")},
+		{[]byte("2"), []byte("Herat"), nil},
+	}, decode(t, result))
+
+	// the binary protocol, the connections to an address share the store
+	other := connect(t, "root:123456@memory(test_dial)/world")
+	defer other.Close()
+	result, _, err = other.PrepareQueryArgs(executeCtx, "SELECT id, name FROM city WHERE id = ?", []interface{}{int64(2)})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]interface{}{{int64(2), []byte("Herat")}}, decode(t, result))
+
+	result, _, err = other.PrepareQueryArgs(executeCtx, "SELECT `COLUMN_NAME`, `ORDINAL_POSITION`, `COLUMN_KEY` "+
+		"FROM `INFORMATION_SCHEMA`.`COLUMNS` WHERE `TABLE_SCHEMA` = ? AND `TABLE_NAME` = ? ORDER BY ORDINAL_POSITION ASC",
+		[]interface{}{"world", "city"})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]interface{}{
+		{[]byte("id"), int64(1), []byte("PRI")},
+		{[]byte("name"), int64(2), []byte("")},
+		{[]byte("population"), int64(3), []byte("")},
+	}, decode(t, result))
+
+	_, err = conn.Execute(queryCtx, "SELECT id FROM country", true)
+	assert.ErrorContains(t, err, "table 'country' doesn't exist")
+	assert.NoError(t, conn.Ping(queryCtx))
+
+	count, err := Open("test_dial").Exec("SELECT COUNT(*) FROM city")
+	assert.NoError(t, err)
+	assert.Equal(t, [][]interface{}{{int64(2)}}, count.Rows)
+}
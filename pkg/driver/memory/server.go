@@ -0,0 +1,266 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package memory
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	err2 "github.com/cectc/dbpack/pkg/errors"
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/misc"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/packet"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+const serverVersion = "5.7.0-dbpack-memory"
+
+// serverCapabilities are the capabilities of the server.
+const serverCapabilities = constant.CapabilityClientLongPassword |
+	constant.CapabilityClientLongFlag |
+	constant.CapabilityClientConnectWithDB |
+	constant.CapabilityClientProtocol41 |
+	constant.CapabilityClientTransactions |
+	constant.CapabilityClientSecureConnection |
+	constant.CapabilityClientPluginAuth |
+	constant.CapabilityClientPluginAuthLenencClientData |
+	constant.CapabilityClientDeprecateEOF
+
+var connectionID uint32
+
+// conn is the server side of a connection to a store.
+type conn struct {
+	*mysql.Conn
+	store        *Store
+	session      session
+	capabilities uint32
+	statementID  uint32
+	// stmts holds the prepared statements of the connection, as packet.ParseComStmtExecute expects.
+	stmts *sync.Map
+}
+
+func newConn(netConn net.Conn, store *Store) *conn {
+	c := &conn{
+		Conn:  mysql.NewConn(netConn),
+		store: store,
+		stmts: &sync.Map{},
+	}
+	c.SetConnectionID(atomic.AddUint32(&connectionID, 1))
+	return c
+}
+
+func (c *conn) serve() {
+	defer c.Close()
+
+	if err := c.handshake(); err != nil {
+		log.Debugf("memory connection %d handshake failed: %v", c.ID(), err)
+		return
+	}
+	for {
+		c.ResetSequence()
+		data, err := c.ReadPacket()
+		if err != nil {
+			return
+		}
+		quit, err := c.dispatch(data)
+		if err != nil {
+			log.Debugf("memory connection %d failed: %v", c.ID(), err)
+			return
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// handshake sends the initial handshake and accepts any handshake response.
+func (c *conn) handshake() error {
+	capabilities := uint32(serverCapabilities)
+	salt := make([]byte, 20)
+	for i := range salt {
+		// the salt is not used, it only has to be made of non zero bytes
+		salt[i] = byte('a' + i)
+	}
+
+	length := 1 + // protocol version
+		misc.LenNullString(serverVersion) +
+		4 + // connection ID
+		8 + // first part of the salt
+		1 + // filler byte
+		2 + // capability flags (lower 2 bytes)
+		1 + // character set
+		2 + // status flag
+		2 + // capability flags (upper 2 bytes)
+		1 + // length of the auth plugin data
+		10 + // reserved
+		13 + // second part of the salt, 0 terminated
+		misc.LenNullString(constant.MysqlNativePassword)
+	data := c.StartEphemeralPacket(length)
+	pos := misc.WriteByte(data, 0, constant.ProtocolVersion)
+	pos = misc.WriteNullString(data, pos, serverVersion)
+	pos = misc.WriteUint32(data, pos, c.ID())
+	pos += copy(data[pos:], salt[:8])
+	pos = misc.WriteByte(data, pos, 0)
+	pos = misc.WriteUint16(data, pos, uint16(capabilities))
+	pos = misc.WriteByte(data, pos, constant.CharacterSetUtf8)
+	pos = misc.WriteUint16(data, pos, c.StatusFlags())
+	pos = misc.WriteUint16(data, pos, uint16(capabilities>>16))
+	pos = misc.WriteByte(data, pos, 21)
+	pos = misc.WriteZeroes(data, pos, 10)
+	pos += copy(data[pos:], salt[8:])
+	pos = misc.WriteByte(data, pos, 0)
+	misc.WriteNullString(data, pos, constant.MysqlNativePassword)
+	if err := c.WriteEphemeralPacket(); err != nil {
+		return err
+	}
+
+	response, err := c.ReadPacket()
+	if err != nil {
+		return err
+	}
+	if err := c.parseHandshakeResponse(response); err != nil {
+		return err
+	}
+	return c.WriteOKPacket(0, 0, c.StatusFlags(), 0)
+}
+
+// parseHandshakeResponse reads the capabilities and the database of the handshake response,
+// the username and the auth response are skipped.
+func (c *conn) parseHandshakeResponse(data []byte) error {
+	clientCapabilities, pos, ok := misc.ReadUint32(data, 0)
+	if !ok {
+		return err2.ErrMalformedPkt
+	}
+	c.capabilities = clientCapabilities & serverCapabilities
+
+	// max packet size, character set and reserved bytes
+	pos += 4 + 1 + 23
+	if _, pos, ok = misc.ReadNullString(data, pos); !ok {
+		return err2.ErrMalformedPkt
+	}
+	switch {
+	case clientCapabilities&constant.CapabilityClientPluginAuthLenencClientData != 0:
+		var l uint64
+		if l, pos, ok = misc.ReadLenEncInt(data, pos); ok {
+			_, pos, ok = misc.ReadBytes(data, pos, int(l))
+		}
+	case clientCapabilities&constant.CapabilityClientSecureConnection != 0:
+		var l byte
+		if l, pos, ok = misc.ReadByte(data, pos); ok {
+			_, pos, ok = misc.ReadBytes(data, pos, int(l))
+		}
+	default:
+		_, pos, ok = misc.ReadNullString(data, pos)
+	}
+	if !ok {
+		return err2.ErrMalformedPkt
+	}
+	if clientCapabilities&constant.CapabilityClientConnectWithDB != 0 {
+		if c.session.schema, _, ok = misc.ReadNullString(data, pos); !ok {
+			return err2.ErrMalformedPkt
+		}
+	}
+	return nil
+}
+
+// dispatch answers the command of data, quit is true if the client quits.
+func (c *conn) dispatch(data []byte) (quit bool, err error) {
+	switch data[0] {
+	case constant.ComQuit:
+		return true, nil
+	case constant.ComInitDB:
+		c.session.schema = string(data[1:])
+		return false, c.WriteOKPacket(0, 0, c.StatusFlags(), 0)
+	case constant.ComPing:
+		return false, c.WriteOKPacket(0, 0, c.StatusFlags(), 0)
+	case constant.ComQuery:
+		return false, c.execute(string(data[1:]), nil, false)
+	case constant.ComPrepare:
+		c.statementID++
+		stmt := &proto.Stmt{
+			StatementID: c.statementID,
+			SqlText:     string(data[1:]),
+		}
+		paramsCount := uint16(strings.Count(stmt.SqlText, "?"))
+		if paramsCount > 0 {
+			stmt.ParamsCount = paramsCount
+			stmt.ParamsType = make([]int32, paramsCount)
+			stmt.BindVars = make(map[string]interface{}, paramsCount)
+		}
+		c.stmts.Store(stmt.StatementID, stmt)
+		return false, c.WritePrepare(c.capabilities, stmt)
+	case constant.ComStmtExecute:
+		stmtID, _, err := packet.ParseComStmtExecute(c.stmts, data)
+		if err != nil {
+			return false, c.WriteErrorPacketFromError(err)
+		}
+		si, _ := c.stmts.Load(stmtID)
+		stmt := si.(*proto.Stmt)
+		args := make([]interface{}, 0, stmt.ParamsCount)
+		for i := 0; i < int(stmt.ParamsCount); i++ {
+			args = append(args, stmt.BindVars[fmt.Sprintf("v%d", i+1)])
+		}
+		// the bind variables of the next execution are parsed again
+		stmt.BindVars = make(map[string]interface{}, stmt.ParamsCount)
+		return false, c.execute(stmt.SqlText, args, true)
+	case constant.ComStmtClose:
+		if stmtID, _, ok := misc.ReadUint32(data, 1); ok {
+			c.stmts.Delete(stmtID)
+		}
+		return false, nil
+	case constant.ComStmtReset:
+		if stmtID, _, ok := misc.ReadUint32(data, 1); ok {
+			if si, ok := c.stmts.Load(stmtID); ok {
+				stmt := si.(*proto.Stmt)
+				stmt.BindVars = make(map[string]interface{}, stmt.ParamsCount)
+			}
+		}
+		return false, c.WriteOKPacket(0, 0, c.StatusFlags(), 0)
+	default:
+		return false, c.WriteErrorPacket(constant.ERUnknownComError, constant.SSUnknownComError,
+			"command handling not implemented yet: %v", data[0])
+	}
+}
+
+// execute executes sql on the store and writes its result, in the binary protocol if binary is true.
+func (c *conn) execute(sql string, args []interface{}, binary bool) error {
+	result, err := c.store.execute(&c.session, sql, args)
+	if err != nil {
+		return c.WriteErrorPacketFromError(err)
+	}
+	if result == nil || len(result.Columns) == 0 {
+		var affectedRows, lastInsertID uint64
+		if result != nil {
+			affectedRows, lastInsertID = result.AffectedRows, result.LastInsertID
+		}
+		return c.WriteOKPacket(affectedRows, lastInsertID, c.StatusFlags(), 0)
+	}
+
+	rlt := result.toMysqlResult(binary)
+	if err := c.WriteFields(c.capabilities, rlt.Fields); err != nil {
+		return err
+	}
+	if err := c.WriteRows(rlt); err != nil {
+		return err
+	}
+	return c.WriteEndResult(c.capabilities, false, 0, 0, 0)
+}
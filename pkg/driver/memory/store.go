@@ -0,0 +1,1041 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/visitor"
+	"github.com/cectc/dbpack/third_party/parser"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+	"github.com/cectc/dbpack/third_party/parser/format"
+	"github.com/cectc/dbpack/third_party/parser/opcode"
+	parsertypes "github.com/cectc/dbpack/third_party/parser/types"
+	"github.com/cectc/dbpack/third_party/types"
+	driver "github.com/cectc/dbpack/third_party/types/parser_driver"
+)
+
+// Store is the in-memory database of a memory data source, it keeps the rows of the tables
+// created on it for as long as the process runs.
+//
+// Only a subset of mysql is supported: CREATE, DROP and TRUNCATE TABLE, INSERT, REPLACE, UPDATE
+// and DELETE of a single table, and SELECT of a table, a derived table or a UNION ALL, with
+// WHERE, ORDER BY, LIMIT and aggregate functions without GROUP BY. The database names of the
+// statements are ignored, the COLUMNS and STATISTICS tables of information_schema describe the
+// tables as belonging to the database of the connection, as the table meta cache reads them.
+// Transactions are not isolated and their statements take effect at once, other statements are
+// answered as OK packets.
+type Store struct {
+	mu     sync.Mutex
+	parser *parser.Parser
+	tables map[string]*table
+}
+
+type table struct {
+	name      string
+	columns   []string
+	dataTypes []string
+	// primaryKey and autoIncrement are the indexes of the primary key and the auto increment
+	// columns, -1 if the table has none
+	primaryKey    int
+	autoIncrement int
+	lastInsertID  int64
+	rows          [][]interface{}
+}
+
+// relation is the rows a query reads or returns, columns are lower cased for the lookups.
+type relation struct {
+	names   []string
+	columns map[string]int
+	rows    [][]interface{}
+}
+
+// NewStore creates an empty store.
+func NewStore() *Store {
+	return &Store{
+		parser: parser.New(),
+		tables: make(map[string]*table),
+	}
+}
+
+// Exec executes sql on the store, it may be used to create and fill the tables of a data source
+// before dbpack starts.
+func (s *Store) Exec(sql string, args ...interface{}) (*Result, error) {
+	return s.execute(&session{}, sql, args)
+}
+
+// session is the state of a connection to the store.
+type session struct {
+	schema string
+}
+
+func (s *Store) execute(session *session, sql string, args []interface{}) (*Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stmt, err := s.parser.ParseOneStmt(sql, "", "")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	stmt.Accept(&visitor.ParamVisitor{})
+
+	e := &evaluator{schema: session.schema, args: args}
+	switch stmt := stmt.(type) {
+	case *ast.UseStmt:
+		session.schema = stmt.DBName
+		return nil, nil
+	case *ast.CreateTableStmt:
+		return nil, s.createTable(stmt)
+	case *ast.DropTableStmt:
+		return nil, s.dropTable(stmt)
+	case *ast.TruncateTableStmt:
+		t, err := s.table(stmt.Table)
+		if err != nil {
+			return nil, err
+		}
+		t.rows, t.lastInsertID = nil, 0
+		return nil, nil
+	case *ast.InsertStmt:
+		return s.insert(e, stmt)
+	case *ast.UpdateStmt:
+		return s.update(e, stmt)
+	case *ast.DeleteStmt:
+		return s.delete(e, stmt)
+	case *ast.SelectStmt:
+		r, err := s.query(e, stmt)
+		if err != nil {
+			return nil, err
+		}
+		return r.result(), nil
+	case *ast.SetOprStmt:
+		r, err := s.queryUnion(e, stmt)
+		if err != nil {
+			return nil, err
+		}
+		return r.result(), nil
+	default:
+		return nil, nil
+	}
+}
+
+func (s *Store) table(name *ast.TableName) (*table, error) {
+	t, ok := s.tables[name.Name.L]
+	if !ok {
+		return nil, errors.Errorf("table '%s' doesn't exist", name.Name.O)
+	}
+	return t, nil
+}
+
+// singleTable returns the table of a statement reading or writing a single table.
+func (s *Store) singleTable(refs *ast.TableRefsClause) (*table, error) {
+	if refs == nil || refs.TableRefs == nil || refs.TableRefs.Right != nil {
+		return nil, errors.New("only statements on a single table are supported")
+	}
+	source, ok := refs.TableRefs.Left.(*ast.TableSource)
+	if !ok {
+		return nil, errors.New("only statements on a single table are supported")
+	}
+	name, ok := source.Source.(*ast.TableName)
+	if !ok {
+		return nil, errors.New("only statements on a single table are supported")
+	}
+	return s.table(name)
+}
+
+func (s *Store) createTable(stmt *ast.CreateTableStmt) error {
+	if _, ok := s.tables[stmt.Table.Name.L]; ok {
+		if stmt.IfNotExists {
+			return nil
+		}
+		return errors.Errorf("table '%s' already exists", stmt.Table.Name.O)
+	}
+	t := &table{name: stmt.Table.Name.O, primaryKey: -1, autoIncrement: -1}
+	if stmt.ReferTable != nil {
+		refer, err := s.table(stmt.ReferTable)
+		if err != nil {
+			return err
+		}
+		t.columns, t.dataTypes = refer.columns, refer.dataTypes
+		t.primaryKey, t.autoIncrement = refer.primaryKey, refer.autoIncrement
+		s.tables[stmt.Table.Name.L] = t
+		return nil
+	}
+	if stmt.Select != nil {
+		return errors.New("CREATE TABLE ... SELECT is not supported")
+	}
+	for i, col := range stmt.Cols {
+		t.columns = append(t.columns, col.Name.Name.O)
+		t.dataTypes = append(t.dataTypes, parsertypes.TypeStr(col.Tp.Tp))
+		for _, option := range col.Options {
+			switch option.Tp {
+			case ast.ColumnOptionPrimaryKey:
+				t.primaryKey = i
+			case ast.ColumnOptionAutoIncrement:
+				t.autoIncrement = i
+			}
+		}
+	}
+	for _, constraint := range stmt.Constraints {
+		if constraint.Tp != ast.ConstraintPrimaryKey {
+			continue
+		}
+		if len(constraint.Keys) != 1 || constraint.Keys[0].Column == nil {
+			return errors.New("only primary keys of a single column are supported")
+		}
+		for i, column := range t.columns {
+			if strings.EqualFold(column, constraint.Keys[0].Column.Name.O) {
+				t.primaryKey = i
+			}
+		}
+	}
+	s.tables[stmt.Table.Name.L] = t
+	return nil
+}
+
+func (s *Store) dropTable(stmt *ast.DropTableStmt) error {
+	for _, name := range stmt.Tables {
+		if _, ok := s.tables[name.Name.L]; !ok {
+			if stmt.IfExists {
+				continue
+			}
+			return errors.Errorf("unknown table '%s'", name.Name.O)
+		}
+		delete(s.tables, name.Name.L)
+	}
+	return nil
+}
+
+func (s *Store) insert(e *evaluator, stmt *ast.InsertStmt) (*Result, error) {
+	if stmt.Select != nil || len(stmt.OnDuplicate) != 0 {
+		return nil, errors.New("INSERT ... SELECT and ON DUPLICATE KEY UPDATE are not supported")
+	}
+	t, err := s.singleTable(stmt.Table)
+	if err != nil {
+		return nil, err
+	}
+	columns, lists := stmt.Columns, stmt.Lists
+	if len(stmt.Setlist) != 0 {
+		values := make([]ast.ExprNode, 0, len(stmt.Setlist))
+		for _, assignment := range stmt.Setlist {
+			columns = append(columns, assignment.Column)
+			values = append(values, assignment.Expr)
+		}
+		lists = [][]ast.ExprNode{values}
+	}
+	indexes := make([]int, 0, len(t.columns))
+	if len(columns) == 0 {
+		for i := range t.columns {
+			indexes = append(indexes, i)
+		}
+	}
+	for _, column := range columns {
+		i, err := t.relation().index(column)
+		if err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, i)
+	}
+
+	var affectedRows, lastInsertID uint64
+	for _, list := range lists {
+		if len(list) != len(indexes) {
+			return nil, errors.New("column count doesn't match value count")
+		}
+		row := make([]interface{}, len(t.columns))
+		for i, expr := range list {
+			if row[indexes[i]], err = e.eval(expr, nil, nil); err != nil {
+				return nil, err
+			}
+		}
+		if t.autoIncrement >= 0 {
+			if row[t.autoIncrement] == nil {
+				t.lastInsertID++
+				row[t.autoIncrement] = t.lastInsertID
+				if lastInsertID == 0 {
+					lastInsertID = uint64(t.lastInsertID)
+				}
+			} else if id, ok := row[t.autoIncrement].(int64); ok && id > t.lastInsertID {
+				t.lastInsertID = id
+			}
+		}
+		if t.primaryKey >= 0 {
+			if duplicate := t.find(row[t.primaryKey]); duplicate >= 0 {
+				if !stmt.IsReplace {
+					if stmt.IgnoreErr {
+						continue
+					}
+					return nil, errors.Errorf("duplicate entry '%v' for key 'PRIMARY'", row[t.primaryKey])
+				}
+				t.rows = append(t.rows[:duplicate], t.rows[duplicate+1:]...)
+				affectedRows++
+			}
+		}
+		t.rows = append(t.rows, row)
+		affectedRows++
+	}
+	return affected(affectedRows, lastInsertID), nil
+}
+
+func (s *Store) update(e *evaluator, stmt *ast.UpdateStmt) (*Result, error) {
+	if stmt.Order != nil || stmt.Limit != nil {
+		return nil, errors.New("UPDATE ... ORDER BY and UPDATE ... LIMIT are not supported")
+	}
+	t, err := s.singleTable(stmt.TableRefs)
+	if err != nil {
+		return nil, err
+	}
+	r := t.relation()
+	indexes := make([]int, 0, len(stmt.List))
+	for _, assignment := range stmt.List {
+		i, err := r.index(assignment.Column)
+		if err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, i)
+	}
+
+	var affectedRows uint64
+	for _, row := range t.rows {
+		ok, err := e.match(stmt.Where, r, row)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		values := make([]interface{}, len(indexes))
+		for i, assignment := range stmt.List {
+			if values[i], err = e.eval(assignment.Expr, r, row); err != nil {
+				return nil, err
+			}
+		}
+		changed := false
+		for i, index := range indexes {
+			if c, ok := compare(row[index], values[i]); !ok || c != 0 {
+				row[index] = values[i]
+				changed = true
+			}
+		}
+		if changed {
+			affectedRows++
+		}
+	}
+	return affected(affectedRows, 0), nil
+}
+
+func (s *Store) delete(e *evaluator, stmt *ast.DeleteStmt) (*Result, error) {
+	if stmt.IsMultiTable || stmt.Order != nil || stmt.Limit != nil {
+		return nil, errors.New("multiple table DELETE, DELETE ... ORDER BY and DELETE ... LIMIT are not supported")
+	}
+	t, err := s.singleTable(stmt.TableRefs)
+	if err != nil {
+		return nil, err
+	}
+	r := t.relation()
+	rows := t.rows[:0]
+	for _, row := range t.rows {
+		ok, err := e.match(stmt.Where, r, row)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			rows = append(rows, row)
+		}
+	}
+	affectedRows := uint64(len(t.rows) - len(rows))
+	t.rows = rows
+	return affected(affectedRows, 0), nil
+}
+
+// source returns the relation a query reads.
+func (s *Store) source(e *evaluator, from *ast.TableRefsClause) (*relation, error) {
+	if from == nil {
+		return &relation{columns: map[string]int{}, rows: [][]interface{}{nil}}, nil
+	}
+	if from.TableRefs == nil || from.TableRefs.Right != nil {
+		return nil, errors.New("joins are not supported")
+	}
+	source, ok := from.TableRefs.Left.(*ast.TableSource)
+	if !ok {
+		return nil, errors.New("joins are not supported")
+	}
+	switch source := source.Source.(type) {
+	case *ast.TableName:
+		if source.Schema.L == "information_schema" {
+			return s.informationSchema(e.schema, source)
+		}
+		t, err := s.table(source)
+		if err != nil {
+			return nil, err
+		}
+		return t.relation(), nil
+	case *ast.SelectStmt:
+		return s.query(e, source)
+	case *ast.SetOprStmt:
+		return s.queryUnion(e, source)
+	default:
+		return nil, errors.Errorf("unsupported table source %T", source)
+	}
+}
+
+func (s *Store) query(e *evaluator, stmt *ast.SelectStmt) (*relation, error) {
+	if stmt.GroupBy != nil || stmt.Having != nil {
+		return nil, errors.New("GROUP BY and HAVING are not supported")
+	}
+	source, err := s.source(e, stmt.From)
+	if err != nil {
+		return nil, err
+	}
+	var rows [][]interface{}
+	for _, row := range source.rows {
+		ok, err := e.match(stmt.Where, source, row)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			rows = append(rows, row)
+		}
+	}
+	source = &relation{names: source.names, columns: source.columns, rows: rows}
+	if err := e.sort(stmt.OrderBy, source); err != nil {
+		return nil, err
+	}
+
+	result := &relation{columns: make(map[string]int)}
+	aggregate := false
+	for _, field := range stmt.Fields.Fields {
+		if field.WildCard != nil {
+			for _, name := range source.names {
+				result.add(name)
+			}
+			continue
+		}
+		if _, ok := field.Expr.(*ast.AggregateFuncExpr); ok {
+			aggregate = true
+		}
+		name, err := fieldName(field)
+		if err != nil {
+			return nil, err
+		}
+		result.add(name)
+	}
+	if aggregate {
+		row := make([]interface{}, 0, len(stmt.Fields.Fields))
+		for _, field := range stmt.Fields.Fields {
+			f, ok := field.Expr.(*ast.AggregateFuncExpr)
+			if !ok {
+				return nil, errors.New("only aggregate functions are supported in a query without GROUP BY")
+			}
+			value, err := e.aggregate(f, source)
+			if err != nil {
+				return nil, err
+			}
+			row = append(row, value)
+		}
+		result.rows = [][]interface{}{row}
+	} else {
+		for _, row := range source.rows {
+			values := make([]interface{}, 0, len(result.names))
+			for _, field := range stmt.Fields.Fields {
+				if field.WildCard != nil {
+					values = append(values, row...)
+					continue
+				}
+				value, err := e.eval(field.Expr, source, row)
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, value)
+			}
+			result.rows = append(result.rows, values)
+		}
+	}
+	return result, e.limit(stmt.Limit, result)
+}
+
+func (s *Store) queryUnion(e *evaluator, stmt *ast.SetOprStmt) (*relation, error) {
+	var result *relation
+	var union func(list *ast.SetOprSelectList) error
+	union = func(list *ast.SetOprSelectList) error {
+		for _, node := range list.Selects {
+			var (
+				r   *relation
+				err error
+			)
+			switch node := node.(type) {
+			case *ast.SelectStmt:
+				if node.AfterSetOperator != nil && *node.AfterSetOperator != ast.UnionAll {
+					return errors.New("only UNION ALL is supported")
+				}
+				r, err = s.query(e, node)
+			case *ast.SetOprSelectList:
+				if node.AfterSetOperator != nil && *node.AfterSetOperator != ast.UnionAll {
+					return errors.New("only UNION ALL is supported")
+				}
+				err = union(node)
+			default:
+				err = errors.Errorf("unsupported union of %T", node)
+			}
+			if err != nil {
+				return err
+			}
+			if r == nil {
+				continue
+			}
+			if result == nil {
+				result = r
+				continue
+			}
+			if len(r.names) != len(result.names) {
+				return errors.New("the used SELECT statements have a different number of columns")
+			}
+			result.rows = append(result.rows, r.rows...)
+		}
+		return nil
+	}
+	if err := union(stmt.SelectList); err != nil {
+		return nil, err
+	}
+	if err := e.sort(stmt.OrderBy, result); err != nil {
+		return nil, err
+	}
+	return result, e.limit(stmt.Limit, result)
+}
+
+// informationSchema returns the rows of the tables of information_schema describing the tables
+// of the store as the tables of schema.
+func (s *Store) informationSchema(schema string, name *ast.TableName) (*relation, error) {
+	var columns []string
+	var rows [][]interface{}
+	names := make([]string, 0, len(s.tables))
+	for name := range s.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	switch name.Name.L {
+	case "columns":
+		columns = []string{"TABLE_CATALOG", "TABLE_SCHEMA", "TABLE_NAME", "COLUMN_NAME", "DATA_TYPE",
+			"CHARACTER_MAXIMUM_LENGTH", "NUMERIC_PRECISION", "NUMERIC_SCALE", "IS_NULLABLE", "COLUMN_COMMENT",
+			"COLUMN_DEFAULT", "CHARACTER_OCTET_LENGTH", "ORDINAL_POSITION", "COLUMN_KEY", "EXTRA",
+			"GENERATION_EXPRESSION"}
+		for _, name := range names {
+			t := s.tables[name]
+			for i, column := range t.columns {
+				nullable, key, extra := "YES", "", ""
+				if i == t.primaryKey {
+					nullable, key = "NO", "PRI"
+				}
+				if i == t.autoIncrement {
+					extra = "auto_increment"
+				}
+				rows = append(rows, []interface{}{"def", schema, t.name, column, t.dataTypes[i],
+					nil, nil, nil, nullable, "", nil, nil, int64(i + 1), key, extra, ""})
+			}
+		}
+	case "statistics":
+		columns = []string{"TABLE_SCHEMA", "TABLE_NAME", "INDEX_NAME", "COLUMN_NAME", "NON_UNIQUE",
+			"INDEX_TYPE", "SEQ_IN_INDEX", "COLLATION", "CARDINALITY"}
+		for _, name := range names {
+			t := s.tables[name]
+			if t.primaryKey >= 0 {
+				rows = append(rows, []interface{}{schema, t.name, "PRIMARY", t.columns[t.primaryKey],
+					int64(0), "BTREE", int64(1), "A", int64(len(t.rows))})
+			}
+		}
+	default:
+		return nil, errors.Errorf("table 'information_schema.%s' is not supported", name.Name.O)
+	}
+	r := &relation{columns: make(map[string]int, len(columns)), rows: rows}
+	for _, column := range columns {
+		r.add(column)
+	}
+	return r, nil
+}
+
+// fieldName returns the name of the column of field, the text of its expression if it has no alias.
+func fieldName(field *ast.SelectField) (string, error) {
+	if field.AsName.O != "" {
+		return field.AsName.O, nil
+	}
+	if column, ok := field.Expr.(*ast.ColumnNameExpr); ok {
+		return column.Name.Name.O, nil
+	}
+	if text := strings.TrimSpace(field.Text()); text != "" {
+		return text, nil
+	}
+	var sb strings.Builder
+	if err := field.Expr.Restore(format.NewRestoreCtx(format.DefaultRestoreFlags, &sb)); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return sb.String(), nil
+}
+
+func (t *table) relation() *relation {
+	r := &relation{columns: make(map[string]int, len(t.columns)), rows: t.rows}
+	for _, column := range t.columns {
+		r.add(column)
+	}
+	return r
+}
+
+// find returns the index of the row whose primary key is key, -1 if there is none.
+func (t *table) find(key interface{}) int {
+	for i, row := range t.rows {
+		if c, ok := compare(row[t.primaryKey], key); ok && c == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *relation) add(name string) {
+	if _, ok := r.columns[strings.ToLower(name)]; !ok {
+		r.columns[strings.ToLower(name)] = len(r.names)
+	}
+	r.names = append(r.names, name)
+}
+
+func (r *relation) index(column *ast.ColumnName) (int, error) {
+	if r != nil {
+		if i, ok := r.columns[column.Name.L]; ok {
+			return i, nil
+		}
+	}
+	return 0, errors.Errorf("unknown column '%s'", column.Name.O)
+}
+
+func (r *relation) result() *Result {
+	return &Result{Columns: r.names, Rows: r.rows}
+}
+
+// evaluator evaluates the expressions of a statement on the rows of a relation.
+type evaluator struct {
+	schema string
+	args   []interface{}
+}
+
+func (e *evaluator) match(where ast.ExprNode, r *relation, row []interface{}) (bool, error) {
+	if where == nil {
+		return true, nil
+	}
+	value, err := e.eval(where, r, row)
+	if err != nil {
+		return false, err
+	}
+	return isTrue(value), nil
+}
+
+func (e *evaluator) sort(orderBy *ast.OrderByClause, r *relation) error {
+	if orderBy == nil {
+		return nil
+	}
+	keys := make(map[int][]interface{}, len(r.rows))
+	for i, row := range r.rows {
+		for _, item := range orderBy.Items {
+			value, err := e.eval(item.Expr, r, row)
+			if err != nil {
+				return err
+			}
+			keys[i] = append(keys[i], value)
+		}
+	}
+	indexes := make([]int, len(r.rows))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(i, j int) bool {
+		for k, item := range orderBy.Items {
+			a, b := keys[indexes[i]][k], keys[indexes[j]][k]
+			c, ok := compare(a, b)
+			if !ok {
+				// nulls sort first, as mysql does
+				c = compareNull(a, b)
+			}
+			if c == 0 {
+				continue
+			}
+			if item.Desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+	rows := make([][]interface{}, 0, len(r.rows))
+	for _, i := range indexes {
+		rows = append(rows, r.rows[i])
+	}
+	r.rows = rows
+	return nil
+}
+
+func (e *evaluator) limit(limit *ast.Limit, r *relation) error {
+	if limit == nil {
+		return nil
+	}
+	var offset, count int64
+	if limit.Offset != nil {
+		value, err := e.eval(limit.Offset, nil, nil)
+		if err != nil {
+			return err
+		}
+		offset = toInt64(value)
+	}
+	value, err := e.eval(limit.Count, nil, nil)
+	if err != nil {
+		return err
+	}
+	count = toInt64(value)
+	if offset > int64(len(r.rows)) {
+		offset = int64(len(r.rows))
+	}
+	if offset+count > int64(len(r.rows)) {
+		count = int64(len(r.rows)) - offset
+	}
+	r.rows = r.rows[offset : offset+count]
+	return nil
+}
+
+func (e *evaluator) aggregate(f *ast.AggregateFuncExpr, r *relation) (interface{}, error) {
+	if f.Distinct || len(f.Args) != 1 {
+		return nil, errors.Errorf("unsupported aggregate function %s", f.F)
+	}
+	var (
+		count  int64
+		result interface{}
+	)
+	for _, row := range r.rows {
+		value, err := e.eval(f.Args[0], r, row)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			continue
+		}
+		count++
+		switch strings.ToLower(f.F) {
+		case ast.AggFuncCount:
+		case ast.AggFuncSum:
+			if result == nil {
+				result = int64(0)
+			}
+			if result, err = arithmetic(opcode.Plus, result, value); err != nil {
+				return nil, err
+			}
+		case ast.AggFuncMax:
+			if c, ok := compare(value, result); result == nil || ok && c > 0 {
+				result = value
+			}
+		case ast.AggFuncMin:
+			if c, ok := compare(value, result); result == nil || ok && c < 0 {
+				result = value
+			}
+		default:
+			return nil, errors.Errorf("unsupported aggregate function %s", f.F)
+		}
+	}
+	if strings.ToLower(f.F) == ast.AggFuncCount {
+		return count, nil
+	}
+	return result, nil
+}
+
+// eval evaluates expr on row of r, the result is nil, an int64, a float64, a string or a time.
+func (e *evaluator) eval(expr ast.ExprNode, r *relation, row []interface{}) (interface{}, error) {
+	switch expr := expr.(type) {
+	case *driver.ValueExpr:
+		return normalize(expr.GetValue()), nil
+	case *driver.ParamMarkerExpr:
+		if expr.Order >= len(e.args) {
+			return nil, errors.New("too few arguments for the placeholders")
+		}
+		return normalize(e.args[expr.Order]), nil
+	case *ast.ColumnNameExpr:
+		i, err := r.index(expr.Name)
+		if err != nil {
+			return nil, err
+		}
+		return row[i], nil
+	case *ast.ParenthesesExpr:
+		return e.eval(expr.Expr, r, row)
+	case *ast.UnaryOperationExpr:
+		value, err := e.eval(expr.V, r, row)
+		if err != nil || value == nil {
+			return nil, err
+		}
+		switch expr.Op {
+		case opcode.Minus:
+			return arithmetic(opcode.Minus, int64(0), value)
+		case opcode.Plus:
+			return value, nil
+		case opcode.Not, opcode.Not2:
+			return boolValue(!isTrue(value)), nil
+		default:
+			return nil, errors.Errorf("unsupported operator %s", expr.Op)
+		}
+	case *ast.BinaryOperationExpr:
+		return e.evalBinary(expr, r, row)
+	case *ast.IsNullExpr:
+		value, err := e.eval(expr.Expr, r, row)
+		if err != nil {
+			return nil, err
+		}
+		return boolValue((value == nil) != expr.Not), nil
+	case *ast.PatternInExpr:
+		if expr.Sel != nil {
+			return nil, errors.New("subqueries are not supported")
+		}
+		value, err := e.eval(expr.Expr, r, row)
+		if err != nil || value == nil {
+			return nil, err
+		}
+		found := false
+		for _, item := range expr.List {
+			v, err := e.eval(item, r, row)
+			if err != nil {
+				return nil, err
+			}
+			if c, ok := compare(value, v); ok && c == 0 {
+				found = true
+				break
+			}
+		}
+		return boolValue(found != expr.Not), nil
+	case *ast.BetweenExpr:
+		value, err := e.eval(expr.Expr, r, row)
+		if err != nil {
+			return nil, err
+		}
+		left, err := e.eval(expr.Left, r, row)
+		if err != nil {
+			return nil, err
+		}
+		right, err := e.eval(expr.Right, r, row)
+		if err != nil {
+			return nil, err
+		}
+		l, ok := compare(value, left)
+		if !ok {
+			return nil, nil
+		}
+		h, ok := compare(value, right)
+		if !ok {
+			return nil, nil
+		}
+		return boolValue((l >= 0 && h <= 0) != expr.Not), nil
+	default:
+		return nil, errors.Errorf("unsupported expression %T", expr)
+	}
+}
+
+func (e *evaluator) evalBinary(expr *ast.BinaryOperationExpr, r *relation, row []interface{}) (interface{}, error) {
+	left, err := e.eval(expr.L, r, row)
+	if err != nil {
+		return nil, err
+	}
+	right, err := e.eval(expr.R, r, row)
+	if err != nil {
+		return nil, err
+	}
+	switch expr.Op {
+	case opcode.LogicAnd:
+		if left != nil && !isTrue(left) || right != nil && !isTrue(right) {
+			return boolValue(false), nil
+		}
+		if left == nil || right == nil {
+			return nil, nil
+		}
+		return boolValue(true), nil
+	case opcode.LogicOr:
+		if left != nil && isTrue(left) || right != nil && isTrue(right) {
+			return boolValue(true), nil
+		}
+		if left == nil || right == nil {
+			return nil, nil
+		}
+		return boolValue(false), nil
+	case opcode.NullEQ:
+		if left == nil || right == nil {
+			return boolValue(left == nil && right == nil), nil
+		}
+		c, _ := compare(left, right)
+		return boolValue(c == 0), nil
+	case opcode.EQ, opcode.NE, opcode.LT, opcode.LE, opcode.GT, opcode.GE:
+		c, ok := compare(left, right)
+		if !ok {
+			return nil, nil
+		}
+		switch expr.Op {
+		case opcode.EQ:
+			return boolValue(c == 0), nil
+		case opcode.NE:
+			return boolValue(c != 0), nil
+		case opcode.LT:
+			return boolValue(c < 0), nil
+		case opcode.LE:
+			return boolValue(c <= 0), nil
+		case opcode.GT:
+			return boolValue(c > 0), nil
+		default:
+			return boolValue(c >= 0), nil
+		}
+	case opcode.Plus, opcode.Minus, opcode.Mul, opcode.Div:
+		return arithmetic(expr.Op, left, right)
+	default:
+		return nil, errors.Errorf("unsupported operator %s", expr.Op)
+	}
+}
+
+// normalize converts a value of a statement to a value of the store.
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, bool:
+		return toInt64(v)
+	case float32, float64:
+		return toFloat64(v)
+	case *types.MyDecimal:
+		f, _ := v.ToFloat64()
+		return f
+	case []byte:
+		return string(v)
+	case string, time.Time:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// number returns value as an int64 or a float64, strings are converted as mysql does.
+func number(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int64, float64:
+		return v
+	case time.Time:
+		n, _ := strconv.ParseInt(v.Format("20060102150405"), 10, 64)
+		return n
+	default:
+		s := strings.TrimSpace(fmt.Sprint(v))
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n
+		}
+		f, _ := strconv.ParseFloat(s, 64)
+		return f
+	}
+}
+
+// compare compares two values, ok is false if either of them is null.
+func compare(a, b interface{}) (c int, ok bool) {
+	if a == nil || b == nil {
+		return 0, false
+	}
+	if t, ok := a.(time.Time); ok {
+		a = t.Format("2006-01-02 15:04:05")
+	}
+	if t, ok := b.(time.Time); ok {
+		b = t.Format("2006-01-02 15:04:05")
+	}
+	sa, aIsString := a.(string)
+	sb, bIsString := b.(string)
+	if aIsString && bIsString {
+		return strings.Compare(sa, sb), true
+	}
+	na, nb := number(a), number(b)
+	ia, aIsInt := na.(int64)
+	ib, bIsInt := nb.(int64)
+	if aIsInt && bIsInt {
+		switch {
+		case ia < ib:
+			return -1, true
+		case ia > ib:
+			return 1, true
+		}
+		return 0, true
+	}
+	fa, fb := toFloat64(na), toFloat64(nb)
+	switch {
+	case fa < fb:
+		return -1, true
+	case fa > fb:
+		return 1, true
+	}
+	return 0, true
+}
+
+func compareNull(a, b interface{}) int {
+	switch {
+	case a == nil && b != nil:
+		return -1
+	case a != nil && b == nil:
+		return 1
+	}
+	return 0
+}
+
+func arithmetic(op opcode.Op, a, b interface{}) (interface{}, error) {
+	if a == nil || b == nil {
+		return nil, nil
+	}
+	na, nb := number(a), number(b)
+	ia, aIsInt := na.(int64)
+	ib, bIsInt := nb.(int64)
+	if aIsInt && bIsInt && op != opcode.Div {
+		switch op {
+		case opcode.Plus:
+			return ia + ib, nil
+		case opcode.Minus:
+			return ia - ib, nil
+		default:
+			return ia * ib, nil
+		}
+	}
+	fa, fb := toFloat64(na), toFloat64(nb)
+	switch op {
+	case opcode.Plus:
+		return fa + fb, nil
+	case opcode.Minus:
+		return fa - fb, nil
+	case opcode.Mul:
+		return fa * fb, nil
+	default:
+		if fb == 0 {
+			return nil, nil
+		}
+		return fa / fb, nil
+	}
+}
+
+func isTrue(value interface{}) bool {
+	if value == nil {
+		return false
+	}
+	return toFloat64(number(value)) != 0
+}
+
+func boolValue(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package memory
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	store := NewStore()
+	_, err := store.Exec("CREATE TABLE employee (id BIGINT PRIMARY KEY AUTO_INCREMENT, name VARCHAR(32), salary DECIMAL(10,2))")
+	assert.NoError(t, err)
+
+	result, err := store.Exec("INSERT INTO employee (name, salary) VALUES ('scott', 1250.5), (?, ?)", []byte("tiger"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, affected(2, 1), result)
+	_, err = store.Exec("INSERT INTO employee VALUES (2, 'king', 5000)")
+	assert.ErrorContains(t, err, "duplicate entry '2' for key 'PRIMARY'")
+	result, err = store.Exec("REPLACE INTO employee VALUES (2, 'tiger', 800)")
+	assert.NoError(t, err)
+	assert.Equal(t, affected(2, 0), result)
+
+	result, err = store.Exec("UPDATE employee SET salary = salary + 100 WHERE id IN (1, 3) OR name IS NULL")
+	assert.NoError(t, err)
+	assert.Equal(t, affected(1, 0), result)
+	result, err = store.Exec("SELECT id, name AS employee, salary FROM employee WHERE salary BETWEEN ? AND 2000 ORDER BY id DESC", 500)
+	assert.NoError(t, err)
+	assert.Equal(t, &Result{Columns: []string{"id", "employee", "salary"},
+		Rows: [][]interface{}{{int64(2), "tiger", int64(800)}, {int64(1), "scott", 1350.5}}}, result)
+
+	result, err = store.Exec("SELECT COUNT(*), MAX(salary) FROM employee")
+	assert.NoError(t, err)
+	assert.Equal(t, &Result{Columns: []string{"COUNT(*)", "MAX(salary)"}, Rows: [][]interface{}{{int64(2), 1350.5}}}, result)
+
+	_, err = store.Exec("CREATE TABLE manager LIKE employee")
+	assert.NoError(t, err)
+	_, err = store.Exec("INSERT INTO manager SET name = 'king'")
+	assert.NoError(t, err)
+	result, err = store.Exec("SELECT * FROM ((SELECT id, name FROM employee) UNION ALL (SELECT id, name FROM manager)) t ORDER BY name LIMIT 1, 2")
+	assert.NoError(t, err)
+	assert.Equal(t, &Result{Columns: []string{"id", "name"}, Rows: [][]interface{}{{int64(1), "scott"}, {int64(2), "tiger"}}}, result)
+
+	result, err = store.Exec("DELETE FROM employee WHERE salary < 1000")
+	assert.NoError(t, err)
+	assert.Equal(t, affected(1, 0), result)
+	_, err = store.Exec("SELECT e.id FROM employee e JOIN manager m ON e.id = m.id")
+	assert.ErrorContains(t, err, "joins are not supported")
+	_, err = store.Exec("DROP TABLE employee")
+	assert.NoError(t, err)
+	_, err = store.Exec("SELECT id FROM employee")
+	assert.ErrorContains(t, err, "table 'employee' doesn't exist")
+}
@@ -72,15 +72,18 @@ func (stmt *BackendStatement) readPrepareResultPacket() (uint16, error) {
 
 // http://dev.mysql.com/doc/internals/en/com-stmt-send-long-data.html
 func (stmt *BackendStatement) writeCommandLongData(paramID int, arg []byte) error {
-	maxLen := stmt.conn.conf.MaxAllowedPacket - 1
-	pktLen := maxLen
-
 	// After the header (bytes 0-3) follows before the data:
 	// 1 byte command
 	// 4 bytes stmtID
 	// 2 bytes paramID
 	const dataOffset = 1 + 4 + 2
 
+	maxLen := stmt.conn.conf.MaxAllowedPacket - 1
+	if maxLen <= dataOffset {
+		maxLen = constant.MaxPacketSize
+	}
+	pktLen := maxLen
+
 	// Cannot use the write buffer since
 	// a) the buffer is too small
 	// b) it is in use
@@ -107,8 +110,8 @@ func (stmt *BackendStatement) writeCommandLongData(paramID int, arg []byte) erro
 		data[9] = byte(paramID)
 		data[10] = byte(paramID >> 8)
 
-		// Send CMD packet
-		err := stmt.conn.WritePacket(data[:4+pktLen])
+		// Send CMD packet, the header is written by WritePacket.
+		err := stmt.conn.WritePacket(data[4 : 4+pktLen])
 		if err == nil {
 			data = data[pktLen-dataOffset:]
 			continue
@@ -393,6 +396,7 @@ func (stmt *BackendStatement) execArgs(ctx context.Context, args []interface{})
 	return &mysql.Result{
 		AffectedRows: affectedRows,
 		InsertId:     lastInsertID,
+		SessionState: stmt.conn.sessionState,
 	}, warnings, nil
 }
 
@@ -411,7 +415,7 @@ func (stmt *BackendStatement) queryArgs(ctx context.Context, args []interface{})
 		return nil, 0, err
 	}
 
-	result, _, warnings, err := stmt.conn.ReadQueryResult(ctx, true)
+	result, warnings, err := stmt.conn.ReadQueryResults(ctx, true)
 	return result, warnings, err
 }
 
@@ -455,6 +459,7 @@ func (stmt *BackendStatement) exec(args []byte) (*mysql.Result, uint16, error) {
 	return &mysql.Result{
 		AffectedRows: affectedRows,
 		InsertId:     lastInsertID,
+		SessionState: stmt.conn.sessionState,
 	}, warnings, nil
 }
 
@@ -472,6 +477,6 @@ func (stmt *BackendStatement) query(ctx context.Context, args []byte) (*mysql.Re
 		return nil, 0, err
 	}
 
-	result, _, warnings, err := stmt.conn.ReadQueryResult(ctx, true)
+	result, warnings, err := stmt.conn.ReadQueryResults(ctx, true)
 	return result, warnings, err
 }
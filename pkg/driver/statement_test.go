@@ -0,0 +1,61 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package driver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/mysql"
+)
+
+func TestWriteCommandLongData(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	client, server := mysql.NewConn(clientConn), mysql.NewConn(serverConn)
+	defer client.Close()
+	defer server.Close()
+
+	stmt := &BackendStatement{
+		conn: &BackendConnection{Conn: client, conf: &Config{MaxAllowedPacket: 32}},
+		id:   7,
+	}
+	arg := bytes.Repeat([]byte("0123456789"), 10)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- stmt.writeCommandLongData(2, arg)
+	}()
+
+	var received []byte
+	for len(received) < len(arg) {
+		// Every chunk is a new command.
+		server.ResetSequence()
+		data, err := server.ReadPacket()
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, len(data), 31)
+		assert.Equal(t, byte(constant.ComStmtSendLongData), data[0])
+		assert.Equal(t, uint32(7), binary.LittleEndian.Uint32(data[1:5]))
+		assert.Equal(t, uint16(2), binary.LittleEndian.Uint16(data[5:7]))
+		received = append(received, data[7:]...)
+	}
+	assert.NoError(t, <-errCh)
+	assert.Equal(t, arg, received)
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package driver
+
+import (
+	"net"
+
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/config"
+)
+
+// setTCPOptions applies the tcp tuning of the data source to conn, nodelay and keepalive
+// are enabled with the system defaults when tcp is nil.
+func setTCPOptions(conn *net.TCPConn, tcp *config.TCPConfig) error {
+	if tcp == nil {
+		tcp = &config.TCPConfig{}
+	}
+	// SetNoDelay controls whether the operating system should delay packet transmission
+	// in hopes of sending fewer packets (Nagle's algorithm).
+	// The default is true (no delay),
+	// meaning that Content is sent as soon as possible after a Write.
+	noDelay := tcp.NoDelay == nil || *tcp.NoDelay
+	if err := conn.SetNoDelay(noDelay); err != nil {
+		return errors.Wrap(err, "set tcp nodelay")
+	}
+	if err := conn.SetKeepAlive(tcp.KeepAlivePeriod >= 0); err != nil {
+		return errors.Wrap(err, "set tcp keepalive")
+	}
+	if tcp.KeepAlivePeriod > 0 {
+		if err := conn.SetKeepAlivePeriod(tcp.KeepAlivePeriod); err != nil {
+			return errors.Wrap(err, "set tcp keepalive period")
+		}
+	}
+	if tcp.ReadBufferSize > 0 {
+		if err := conn.SetReadBuffer(tcp.ReadBufferSize); err != nil {
+			return errors.Wrap(err, "set tcp read buffer")
+		}
+	}
+	if tcp.WriteBufferSize > 0 {
+		if err := conn.SetWriteBuffer(tcp.WriteBufferSize); err != nil {
+			return errors.Wrap(err, "set tcp write buffer")
+		}
+	}
+	if tcp.UserTimeout > 0 {
+		if err := setUserTimeout(conn, tcp.UserTimeout); err != nil {
+			return errors.Wrap(err, "set tcp user timeout")
+		}
+	}
+	return nil
+}
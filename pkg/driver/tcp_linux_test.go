@@ -0,0 +1,114 @@
+//go:build linux
+
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package driver
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+
+	"github.com/cectc/dbpack/pkg/config"
+)
+
+func TestSetTCPOptions(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	getsockopt := func(conn *net.TCPConn, level, opt int) int {
+		rawConn, err := conn.SyscallConn()
+		assert.NoError(t, err)
+		var value int
+		assert.NoError(t, rawConn.Control(func(fd uintptr) {
+			value, err = unix.GetsockoptInt(int(fd), level, opt)
+		}))
+		assert.NoError(t, err)
+		return value
+	}
+
+	noDelay := false
+	testCases := []struct {
+		name          string
+		tcp           *config.TCPConfig
+		noDelay       int
+		keepAlive     int
+		keepAliveIdle int
+		userTimeout   int
+		readBuffer    int
+	}{
+		{
+			name:      "default",
+			tcp:       nil,
+			noDelay:   1,
+			keepAlive: 1,
+		},
+		{
+			name: "tuned",
+			tcp: &config.TCPConfig{
+				KeepAlivePeriod: 30 * time.Second,
+				UserTimeout:     10 * time.Second,
+				NoDelay:         &noDelay,
+				ReadBufferSize:  64 * 1024,
+			},
+			noDelay:       0,
+			keepAlive:     1,
+			keepAliveIdle: 30,
+			userTimeout:   10000,
+			readBuffer:    64 * 1024,
+		},
+		{
+			name:      "keepalive disabled",
+			tcp:       &config.TCPConfig{KeepAlivePeriod: -1},
+			noDelay:   1,
+			keepAlive: 0,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", listener.Addr().String())
+			assert.NoError(t, err)
+			defer conn.Close()
+			tcpConn := conn.(*net.TCPConn)
+
+			assert.NoError(t, setTCPOptions(tcpConn, c.tcp))
+			assert.Equal(t, c.noDelay, getsockopt(tcpConn, unix.IPPROTO_TCP, unix.TCP_NODELAY))
+			assert.Equal(t, c.keepAlive, getsockopt(tcpConn, unix.SOL_SOCKET, unix.SO_KEEPALIVE))
+			if c.keepAliveIdle > 0 {
+				assert.Equal(t, c.keepAliveIdle, getsockopt(tcpConn, unix.IPPROTO_TCP, unix.TCP_KEEPIDLE))
+			}
+			assert.Equal(t, c.userTimeout, getsockopt(tcpConn, unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT))
+			if c.readBuffer > 0 {
+				// the kernel doubles the value set to allow space for bookkeeping overhead
+				assert.GreaterOrEqual(t, getsockopt(tcpConn, unix.SOL_SOCKET, unix.SO_RCVBUF), c.readBuffer)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+//go:build !linux
+
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package driver
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/cectc/dbpack/pkg/log"
+)
+
+var warnUserTimeout sync.Once
+
+// setUserTimeout is a no-op, TCP_USER_TIMEOUT is only supported on linux.
+func setUserTimeout(conn *net.TCPConn, timeout time.Duration) error {
+	warnUserTimeout.Do(func() {
+		log.Warnf("tcp user timeout is only supported on linux, ignored")
+	})
+	return nil
+}
@@ -0,0 +1,194 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dt
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/cectc/dbpack/pkg/dt/api"
+	"github.com/cectc/dbpack/pkg/dt/metrics"
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/resource"
+	"github.com/cectc/dbpack/pkg/tracing"
+)
+
+// branchCommitter commits branch sessions in phase two asynchronously. Branch sessions are
+// grouped by resource: a resource is committed by one worker at a time, and the undo logs
+// of all its pending AT branch sessions are deleted in batches, while different resources
+// are committed in parallel by the worker pool.
+type branchCommitter struct {
+	manager   *DistributedTransactionManager
+	batchSize int
+
+	mu sync.Mutex
+	// map[resourceID][]*api.BranchSession
+	pending map[string][]*api.BranchSession
+	// branch ids of the pending branch sessions, a branch session may be
+	// reported again by the storage watcher before it is committed
+	pendingIDs map[string]bool
+	// queue of resource ids having pending branch sessions
+	queue workqueue.Interface
+}
+
+func newBranchCommitter(manager *DistributedTransactionManager, batchSize int) *branchCommitter {
+	return &branchCommitter{
+		manager:    manager,
+		batchSize:  batchSize,
+		pending:    make(map[string][]*api.BranchSession),
+		pendingIDs: make(map[string]bool),
+		queue:      workqueue.New(),
+	}
+}
+
+// add schedules bs to be committed.
+func (committer *branchCommitter) add(bs *api.BranchSession) {
+	committer.mu.Lock()
+	if committer.pendingIDs[bs.BranchID] {
+		committer.mu.Unlock()
+		return
+	}
+	committer.pendingIDs[bs.BranchID] = true
+	committer.pending[bs.ResourceID] = append(committer.pending[bs.ResourceID], bs)
+	committer.mu.Unlock()
+	committer.queue.Add(bs.ResourceID)
+}
+
+// run starts workers goroutines committing the pending branch sessions until ctx is done.
+func (committer *branchCommitter) run(ctx context.Context, workers int) {
+	for i := 0; i < workers; i++ {
+		go func() {
+			for committer.processNextResource(ctx) {
+			}
+		}()
+	}
+}
+
+func (committer *branchCommitter) processNextResource(ctx context.Context) bool {
+	obj, shutdown := committer.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer committer.queue.Done(obj)
+	if ctx.Err() != nil {
+		// the resource still has pending branch sessions, leave them to the workers of the next term
+		committer.queue.Add(obj)
+		return false
+	}
+
+	resourceID := obj.(string)
+	batch := committer.nextBatch(resourceID)
+	if len(batch) > 0 {
+		committer.commit(resourceID, batch)
+	}
+	return true
+}
+
+// nextBatch takes at most batchSize pending branch sessions of the resource, the resource
+// is queued again if more are left.
+func (committer *branchCommitter) nextBatch(resourceID string) []*api.BranchSession {
+	committer.mu.Lock()
+	defer committer.mu.Unlock()
+
+	batch := committer.pending[resourceID]
+	if len(batch) <= committer.batchSize {
+		delete(committer.pending, resourceID)
+	} else {
+		committer.pending[resourceID] = batch[committer.batchSize:]
+		batch = batch[:committer.batchSize]
+		// the resource is being processed, so it is handed out again after Done
+		committer.queue.Add(resourceID)
+	}
+	for _, bs := range batch {
+		delete(committer.pendingIDs, bs.BranchID)
+	}
+	return batch
+}
+
+func (committer *branchCommitter) commit(resourceID string, batch []*api.BranchSession) {
+	manager := committer.manager
+	var atBranches []*api.BranchSession
+	for _, bs := range batch {
+		if bs.Type != api.AT {
+			// tcc and xa branches are committed one by one
+			status, err := manager.traceBranchPhaseTwo(context.Background(), bs, tracing.BranchTransactionCommit, manager.branchCommit)
+			if err != nil {
+				log.Error(err)
+			}
+			if err != nil || status != api.Complete {
+				manager.retryFailedBranchSession(bs, metrics.TransactionStatusCommitted, err)
+				continue
+			}
+			manager.completeBranchSession(bs, metrics.TransactionStatusCommitted)
+			continue
+		}
+		atBranches = append(atBranches, bs)
+	}
+	if len(atBranches) == 0 {
+		return
+	}
+
+	if err := committer.deleteUndoLogs(resourceID, atBranches); err != nil {
+		log.Errorf("batch commit %d branch sessions of %s failed: %v", len(atBranches), resourceID, err)
+		for _, bs := range atBranches {
+			manager.retryBranchSession(bs, metrics.TransactionStatusCommitted)
+		}
+		return
+	}
+	for _, bs := range atBranches {
+		if err := manager.storageDriver.DeleteBranchSession(context.Background(), bs.BranchID); err != nil {
+			log.Error(err)
+		}
+		log.Debugf("branch session committed, branch id: %s, lock key: %s", bs.BranchID, bs.LockKey)
+		manager.completeBranchSession(bs, metrics.TransactionStatusCommitted)
+	}
+}
+
+func (committer *branchCommitter) deleteUndoLogs(resourceID string, batch []*api.BranchSession) error {
+	seen := make(map[string]bool, len(batch))
+	xids := make([]string, 0, len(batch))
+	links := make([]trace.SpanContext, 0, len(batch))
+	for _, bs := range batch {
+		if !seen[bs.XID] {
+			seen[bs.XID] = true
+			xids = append(xids, bs.XID)
+			if sc, ok := committer.manager.spanContexts.Load(bs.XID); ok {
+				links = append(links, sc.(trace.SpanContext))
+			}
+		}
+	}
+	_, span := tracing.GetLinkedTraceSpan(context.Background(), tracing.BranchTransactionBatchCommit, links...)
+	defer span.End()
+	span.SetAttributes(attribute.String("resource_id", resourceID), attribute.Int("branch_count", len(batch)))
+
+	db := resource.GetDBManager(committer.manager.applicationID).GetDB(resourceID)
+	if db == nil {
+		err := errors.Errorf("DB resource is not exist, db name: %s", resourceID)
+		tracing.RecordErrorSpan(span, err)
+		return err
+	}
+	if err := GetUndoLogManager().DeleteUndoLogByXIDs(db, xids); err != nil {
+		tracing.RecordErrorSpan(span, err)
+		return err
+	}
+	return nil
+}
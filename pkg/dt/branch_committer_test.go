@@ -0,0 +1,107 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dt
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/dt/api"
+	"github.com/cectc/dbpack/pkg/dt/storage"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/resource"
+	"github.com/cectc/dbpack/testdata"
+)
+
+type fakeStorageDriver struct {
+	storage.Driver
+	deleted []string
+}
+
+func (driver *fakeStorageDriver) DeleteBranchSession(ctx context.Context, branchID string) error {
+	driver.deleted = append(driver.deleted, branchID)
+	return nil
+}
+
+func TestBranchCommitterNextBatch(t *testing.T) {
+	committer := newBranchCommitter(&DistributedTransactionManager{}, 2)
+	defer committer.queue.ShutDown()
+
+	for _, id := range []string{"bs/1", "bs/2", "bs/3", "bs/2"} {
+		committer.add(&api.BranchSession{BranchID: id, ResourceID: "employees"})
+	}
+	committer.add(&api.BranchSession{BranchID: "bs/4", ResourceID: "orders"})
+	assert.Equal(t, 2, committer.queue.Len())
+
+	obj, _ := committer.queue.Get()
+	assert.Equal(t, "employees", obj)
+	batch := committer.nextBatch("employees")
+	assert.Equal(t, []string{"bs/1", "bs/2"}, branchIDs(batch))
+	committer.queue.Done(obj)
+
+	obj, _ = committer.queue.Get()
+	assert.Equal(t, "orders", obj)
+	committer.queue.Done(obj)
+	obj, _ = committer.queue.Get()
+	assert.Equal(t, "employees", obj)
+	batch = committer.nextBatch("employees")
+	assert.Equal(t, []string{"bs/3"}, branchIDs(batch))
+	committer.queue.Done(obj)
+
+	// a committed branch session can be scheduled again
+	committer.add(&api.BranchSession{BranchID: "bs/1", ResourceID: "employees"})
+	assert.Equal(t, []string{"bs/1"}, branchIDs(committer.nextBatch("employees")))
+}
+
+func TestBranchCommitterCommit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	result := &mysql.Result{AffectedRows: 3}
+	db := testdata.NewMockDB(ctrl)
+	db.EXPECT().ExecuteSqlDirectly("DELETE FROM undo_log WHERE xid IN (?,?)", "gs/1", "gs/2").
+		Return(result, uint16(0), nil)
+	manager := testdata.NewMockDBManager(ctrl)
+	manager.EXPECT().GetDB("employees").Return(db)
+	resource.SetDBManager("committer_app", manager)
+
+	driver := &fakeStorageDriver{}
+	committer := newBranchCommitter(&DistributedTransactionManager{
+		applicationID:    "committer_app",
+		storageDriver:    driver,
+		branchRetryQueue: newBranchRetryQueue(config.BranchRetry{}),
+	}, 10)
+	committer.commit("employees", []*api.BranchSession{
+		{BranchID: "bs/1", XID: "gs/1", ResourceID: "employees", Type: api.AT},
+		{BranchID: "bs/2", XID: "gs/1", ResourceID: "employees", Type: api.AT},
+		{BranchID: "bs/3", XID: "gs/2", ResourceID: "employees", Type: api.AT},
+	})
+	assert.Equal(t, []string{"bs/1", "bs/2", "bs/3"}, driver.deleted)
+	assert.Equal(t, 0, committer.manager.branchRetryQueue.len())
+}
+
+func branchIDs(batch []*api.BranchSession) []string {
+	ids := make([]string, 0, len(batch))
+	for _, bs := range batch {
+		ids = append(ids, bs.BranchID)
+	}
+	return ids
+}
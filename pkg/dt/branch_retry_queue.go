@@ -0,0 +1,272 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dt
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/dt/api"
+)
+
+const (
+	DefaultBranchRetryInitialInterval = 100 * time.Millisecond
+	DefaultBranchRetryMaxInterval     = 30 * time.Second
+	DefaultBranchRetryMaxAttempts     = 20
+	DefaultBranchRetryConcurrency     = 2
+	DefaultBranchRetryWorkers         = 4
+)
+
+// branchRetryQueue holds the branch sessions failed in phase two until their backoff expires.
+// The backoff doubles on every attempt, branch sessions ready to retry are handed out younger
+// transaction first, and at most concurrency branch sessions of a resource are retried at the
+// same time, so that a failing backend does not occupy all the retry workers.
+type branchRetryQueue struct {
+	initialInterval time.Duration
+	maxInterval     time.Duration
+	maxAttempts     int
+	concurrency     int
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// map[branchID]attempts
+	attempts map[string]int
+	// branch ids of the branch sessions waiting or ready
+	queued map[string]bool
+	// map[resourceID]count of the branch sessions being retried
+	inFlight map[string]int
+	waiting  waitingBranches
+	ready    readyBranches
+
+	shuttingDown bool
+}
+
+func newBranchRetryQueue(conf config.BranchRetry) *branchRetryQueue {
+	queue := &branchRetryQueue{
+		initialInterval: conf.InitialInterval,
+		maxInterval:     conf.MaxInterval,
+		maxAttempts:     conf.MaxAttempts,
+		concurrency:     conf.Concurrency,
+		attempts:        make(map[string]int),
+		queued:          make(map[string]bool),
+		inFlight:        make(map[string]int),
+	}
+	if queue.initialInterval <= 0 {
+		queue.initialInterval = DefaultBranchRetryInitialInterval
+	}
+	if queue.maxInterval <= 0 {
+		queue.maxInterval = DefaultBranchRetryMaxInterval
+	}
+	if queue.maxAttempts <= 0 {
+		queue.maxAttempts = DefaultBranchRetryMaxAttempts
+	}
+	if queue.concurrency <= 0 {
+		queue.concurrency = DefaultBranchRetryConcurrency
+	}
+	queue.cond = sync.NewCond(&queue.mu)
+	return queue
+}
+
+// add schedules bs to be retried after its backoff, it returns false if bs has exceeded
+// the max attempts, the attempts of bs are forgotten then.
+func (queue *branchRetryQueue) add(bs *api.BranchSession) bool {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	queue.attempts[bs.BranchID]++
+	attempts := queue.attempts[bs.BranchID]
+	if attempts > queue.maxAttempts {
+		delete(queue.attempts, bs.BranchID)
+		return false
+	}
+	if queue.queued[bs.BranchID] {
+		return true
+	}
+	queue.queued[bs.BranchID] = true
+	heap.Push(&queue.waiting, &retryItem{bs: bs, readyAt: time.Now().Add(queue.backoff(attempts))})
+	queue.cond.Broadcast()
+	return true
+}
+
+// run starts workers goroutines retrying branch sessions with retry until ctx is done.
+func (queue *branchRetryQueue) run(ctx context.Context, workers int, retry func(ctx context.Context, bs *api.BranchSession)) {
+	go func() {
+		<-ctx.Done()
+		queue.wakeUp()
+	}()
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				bs, ok := queue.get(ctx)
+				if !ok {
+					return
+				}
+				retry(ctx, bs)
+				queue.done(bs)
+			}
+		}()
+	}
+}
+
+func (queue *branchRetryQueue) backoff(attempts int) time.Duration {
+	delay := queue.initialInterval
+	for i := 1; i < attempts && delay < queue.maxInterval; i++ {
+		delay *= 2
+	}
+	if delay > queue.maxInterval {
+		delay = queue.maxInterval
+	}
+	return delay
+}
+
+// get blocks until a branch session is ready to retry and its resource has spare concurrency,
+// ok is false if the queue is shut down or ctx is done. done must be called after bs is retried.
+func (queue *branchRetryQueue) get(ctx context.Context) (bs *api.BranchSession, ok bool) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	for {
+		if queue.shuttingDown || ctx.Err() != nil {
+			return nil, false
+		}
+		now := time.Now()
+		for queue.waiting.Len() > 0 && !queue.waiting[0].readyAt.After(now) {
+			heap.Push(&queue.ready, heap.Pop(&queue.waiting))
+		}
+		if bs = queue.popReady(); bs != nil {
+			delete(queue.queued, bs.BranchID)
+			queue.inFlight[bs.ResourceID]++
+			return bs, true
+		}
+
+		var timer *time.Timer
+		if queue.waiting.Len() > 0 {
+			timer = time.AfterFunc(queue.waiting[0].readyAt.Sub(now), queue.wakeUp)
+		}
+		queue.cond.Wait()
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// popReady pops the youngest ready branch session whose resource has spare concurrency.
+func (queue *branchRetryQueue) popReady() *api.BranchSession {
+	var (
+		skipped []*retryItem
+		bs      *api.BranchSession
+	)
+	for queue.ready.Len() > 0 {
+		item := heap.Pop(&queue.ready).(*retryItem)
+		if queue.inFlight[item.bs.ResourceID] < queue.concurrency {
+			bs = item.bs
+			break
+		}
+		skipped = append(skipped, item)
+	}
+	for _, item := range skipped {
+		heap.Push(&queue.ready, item)
+	}
+	return bs
+}
+
+// done releases the concurrency of the resource of bs taken by get.
+func (queue *branchRetryQueue) done(bs *api.BranchSession) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	queue.inFlight[bs.ResourceID]--
+	if queue.inFlight[bs.ResourceID] <= 0 {
+		delete(queue.inFlight, bs.ResourceID)
+	}
+	queue.cond.Broadcast()
+}
+
+// forget clears the attempts of a branch session finished in phase two.
+func (queue *branchRetryQueue) forget(branchID string) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	delete(queue.attempts, branchID)
+}
+
+func (queue *branchRetryQueue) wakeUp() {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	queue.cond.Broadcast()
+}
+
+func (queue *branchRetryQueue) shutDown() {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	queue.shuttingDown = true
+	queue.cond.Broadcast()
+}
+
+func (queue *branchRetryQueue) len() int {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	return queue.waiting.Len() + queue.ready.Len()
+}
+
+type retryItem struct {
+	bs      *api.BranchSession
+	readyAt time.Time
+}
+
+// waitingBranches is a min heap of retry items ordered by the time they are ready.
+type waitingBranches []*retryItem
+
+func (h waitingBranches) Len() int           { return len(h) }
+func (h waitingBranches) Less(i, j int) bool { return h[i].readyAt.Before(h[j].readyAt) }
+func (h waitingBranches) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *waitingBranches) Push(x interface{}) { *h = append(*h, x.(*retryItem)) }
+
+func (h *waitingBranches) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
+
+// readyBranches is a heap of retry items ordered by the begin time of the branch sessions,
+// the youngest first.
+type readyBranches []*retryItem
+
+func (h readyBranches) Len() int           { return len(h) }
+func (h readyBranches) Less(i, j int) bool { return h[i].bs.BeginTime > h[j].bs.BeginTime }
+func (h readyBranches) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *readyBranches) Push(x interface{}) { *h = append(*h, x.(*retryItem)) }
+
+func (h *readyBranches) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dt
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/dt/api"
+)
+
+func TestBranchRetryQueueBackoff(t *testing.T) {
+	queue := newBranchRetryQueue(config.BranchRetry{
+		InitialInterval: 100 * time.Millisecond,
+		MaxInterval:     time.Second,
+	})
+	testCases := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{attempts: 1, expected: 100 * time.Millisecond},
+		{attempts: 2, expected: 200 * time.Millisecond},
+		{attempts: 4, expected: 800 * time.Millisecond},
+		{attempts: 5, expected: time.Second},
+		{attempts: 100, expected: time.Second},
+	}
+	for _, c := range testCases {
+		assert.Equal(t, c.expected, queue.backoff(c.attempts))
+	}
+}
+
+func TestBranchRetryQueueGet(t *testing.T) {
+	queue := newBranchRetryQueue(config.BranchRetry{
+		InitialInterval: time.Millisecond,
+		MaxAttempts:     2,
+		Concurrency:     1,
+	})
+	defer queue.shutDown()
+
+	older := &api.BranchSession{BranchID: "bs/1", ResourceID: "employees", BeginTime: 1}
+	younger := &api.BranchSession{BranchID: "bs/2", ResourceID: "employees", BeginTime: 2}
+	other := &api.BranchSession{BranchID: "bs/3", ResourceID: "orders", BeginTime: 0}
+	assert.True(t, queue.add(older))
+	assert.True(t, queue.add(younger))
+	assert.True(t, queue.add(other))
+	// a branch session queued already is not queued twice
+	assert.True(t, queue.add(older))
+	assert.Equal(t, 3, queue.len())
+	time.Sleep(5 * time.Millisecond)
+
+	ctx := context.Background()
+	bs, ok := queue.get(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, younger, bs)
+	// employees is retrying younger, so orders goes first
+	bs, ok = queue.get(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, other, bs)
+	queue.done(younger)
+	bs, ok = queue.get(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, older, bs)
+
+	// older has been retried twice
+	assert.False(t, queue.add(older))
+	queue.forget(younger.BranchID)
+	assert.True(t, queue.add(younger))
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, ok = queue.get(ctx)
+	assert.False(t, ok)
+}
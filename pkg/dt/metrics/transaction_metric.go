@@ -23,6 +23,17 @@ const (
 	TransactionStatusCommitted  = "committed"
 	TransactionStatusRollbacked = "rollbacked"
 	TransactionStatusTimeout    = "timeout"
+	// TransactionStatusRetryExhausted means the branch transaction exceeded the max retry attempts
+	TransactionStatusRetryExhausted = "retry_exhausted"
+	// TransactionStatusFailed means the branch transaction failed with an error retrying can not fix
+	TransactionStatusFailed = "failed"
+
+	UndoLogDeleted  = "deleted"
+	UndoLogArchived = "archived"
+	UndoLogFailed   = "failed"
+
+	LeaderElected = "elected"
+	LeaderLost    = "lost"
 )
 
 var (
@@ -46,10 +57,67 @@ var (
 		Name:      "timer",
 		Help:      "global transaction timer",
 	}, []string{"appid", "resourceid", "status"})
+
+	GlobalTransactionGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dbpack",
+		Subsystem: "global_transaction",
+		Name:      "active",
+		Help:      "active global transaction count",
+	}, []string{"appid", "transactionname"})
+
+	BranchTransactionGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dbpack",
+		Subsystem: "branch_transaction",
+		Name:      "active",
+		Help:      "active branch transaction count",
+	}, []string{"appid", "resourceid"})
+
+	GlobalTransactionTimer = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "dbpack",
+		Subsystem: "global_transaction",
+		Name:      "timer",
+		Help:      "global transaction duration in milliseconds, from begin to commit or rollback finished",
+		Buckets:   prometheus.ExponentialBuckets(10, 2, 12),
+	}, []string{"appid", "transactionname", "status"})
+
+	BranchTransactionRetryCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "dbpack",
+		Subsystem: "branch_transaction",
+		Name:      "retry_count",
+		Help:      "branch transaction phase two retry count",
+	}, []string{"appid", "resourceid", "status"})
+
+	UndoLogCleanupCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "dbpack",
+		Subsystem: "undo_log",
+		Name:      "cleanup_count",
+		Help:      "undo log deleted or archived count, and failed cleanup batch count",
+	}, []string{"appid", "resourceid", "action"})
+
+	LeaderGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dbpack",
+		Subsystem: "coordinator",
+		Name:      "leader",
+		Help:      "1 if this instance is the leader of the distributed transaction coordinator, otherwise 0",
+	}, []string{"appid"})
+
+	LeaderChangeCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "dbpack",
+		Subsystem: "coordinator",
+		Name:      "leader_change_count",
+		Help:      "count of leadership elected and lost by this instance",
+	}, []string{"appid", "event"})
 )
 
 func init() {
 	prometheus.MustRegister(GlobalTransactionCounter)
 	prometheus.MustRegister(BranchTransactionCounter)
 	prometheus.MustRegister(BranchTransactionTimer)
+	prometheus.MustRegister(GlobalTransactionGauge)
+	prometheus.MustRegister(BranchTransactionGauge)
+	prometheus.MustRegister(GlobalTransactionTimer)
+	prometheus.MustRegister(BranchTransactionRetryCounter)
+	prometheus.MustRegister(UndoLogCleanupCounter)
+	prometheus.MustRegister(LeaderGauge)
+	prometheus.MustRegister(LeaderChangeCounter)
 }
@@ -109,6 +109,19 @@ func BuildUpdateUndoSql(undoLog *undolog.SqlUndoLog) string {
 	return fmt.Sprintf(UpdateSqlTemplate, undoLog.TableName, updateColumns, pkField.Name)
 }
 
+// DirtyWriteError is returned when the rows to be undone were changed outside the
+// distributed transaction, the undo image can only be applied after manual confirmation.
+type DirtyWriteError struct {
+	TableName    string
+	BeforeImage  *schema.TableRecords
+	AfterImage   *schema.TableRecords
+	CurrentImage *schema.TableRecords
+}
+
+func (e *DirtyWriteError) Error() string {
+	return fmt.Sprintf("Has dirty records when undo, table: %s", e.TableName)
+}
+
 type MysqlUndoExecutor struct {
 	sqlUndoLog *undolog.SqlUndoLog
 }
@@ -125,9 +138,21 @@ func (executor MysqlUndoExecutor) Execute(tx proto.Tx) error {
 	if !goOn {
 		return nil
 	}
+	return executor.undo(tx)
+}
+
+// ForceExecute applies the undo image without checking whether the rows were changed
+// after the branch transaction, it is used to manually compensate a dirty write.
+func (executor MysqlUndoExecutor) ForceExecute(tx proto.Tx) error {
+	return executor.undo(tx)
+}
 
-	var undoSql string
-	var undoRows schema.TableRecords
+func (executor MysqlUndoExecutor) undo(tx proto.Tx) error {
+	var (
+		undoSql  string
+		undoRows schema.TableRecords
+		err      error
+	)
 
 	// PK is at last one.
 	// INSERT INTO a (x, y, z, pk) VALUES (?, ?, ?, ?)
@@ -213,7 +238,12 @@ func (executor MysqlUndoExecutor) dataValidationAndGoOn(tx proto.Tx) (bool, erro
 			}
 			log.Errorf("check dirty datas failed, old and new data are not equal, tableName:[%s], oldRows:[%s], newRows:[%s].",
 				executor.sqlUndoLog.TableName, string(oldRows), string(newRows))
-			return false, errors.New("Has dirty records when undo.")
+			return false, &DirtyWriteError{
+				TableName:    executor.sqlUndoLog.TableName,
+				BeforeImage:  executor.sqlUndoLog.BeforeImage,
+				AfterImage:   executor.sqlUndoLog.AfterImage,
+				CurrentImage: currentRecords,
+			}
 		}
 	}
 	return true, nil
@@ -21,11 +21,15 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
+
 	"github.com/cectc/dbpack/pkg/constant"
 	"github.com/cectc/dbpack/pkg/driver"
+	"github.com/cectc/dbpack/pkg/dt/schema"
 	"github.com/cectc/dbpack/pkg/dt/undolog"
 	"github.com/cectc/dbpack/pkg/log"
 	"github.com/cectc/dbpack/pkg/meta"
+	"github.com/cectc/dbpack/pkg/misc"
 	"github.com/cectc/dbpack/pkg/mysql"
 	"github.com/cectc/dbpack/pkg/proto"
 )
@@ -33,13 +37,28 @@ import (
 const (
 	DeleteUndoLogByIDSql     = "DELETE FROM undo_log WHERE id = ?"
 	DeleteUndoLogByXIDSql    = "DELETE FROM undo_log WHERE xid = ?"
+	DeleteUndoLogByXIDsSql   = "DELETE FROM undo_log WHERE xid IN "
 	DeleteUndoLogByCreateSql = "DELETE FROM undo_log WHERE log_created <= ? LIMIT ?"
+	DeleteUndoLogByIDsSql    = "DELETE FROM undo_log WHERE id IN "
 	InsertUndoLogSql         = `INSERT INTO undo_log (xid, branch_id, context, rollback_info, log_status, log_created,
 		log_modified) VALUES (?, ?, ?, ?, ?, now(), now())`
 	SelectUndoLogSql = `SELECT branch_id, context, rollback_info, log_status FROM undo_log
        WHERE xid = ? ORDER BY id DESC FOR UPDATE`
+	SelectUndoLogByCreateSql = `SELECT id, xid, branch_id, context, rollback_info, log_status, log_created
+       FROM undo_log WHERE log_created <= ? ORDER BY id LIMIT ?`
 )
 
+// UndoLogRecord is a row of the undo_log table.
+type UndoLogRecord struct {
+	ID           int64     `json:"id"`
+	XID          string    `json:"xid"`
+	BranchID     int64     `json:"branch_id"`
+	Context      []byte    `json:"context"`
+	RollbackInfo []byte    `json:"rollback_info"`
+	LogStatus    int64     `json:"log_status"`
+	LogCreated   time.Time `json:"log_created"`
+}
+
 type State byte
 
 const (
@@ -65,11 +84,31 @@ func GetUndoLogManager() MysqlUndoLogManager {
 	return MysqlUndoLogManager{}
 }
 
+// UndoConflict shows the images recorded by an undo log together with the rows currently
+// stored in the table, Dirty reports whether the rows were changed outside the transaction.
+type UndoConflict struct {
+	TableName    string               `json:"table_name"`
+	SqlType      string               `json:"sql_type"`
+	Dirty        bool                 `json:"dirty"`
+	BeforeImage  *schema.TableRecords `json:"before_image"`
+	AfterImage   *schema.TableRecords `json:"after_image"`
+	CurrentImage *schema.TableRecords `json:"current_image"`
+}
+
 func (manager MysqlUndoLogManager) Undo(db proto.DB, xid string) ([]string, error) {
+	return manager.undo(db, xid, false)
+}
+
+// ForceUndo applies the undo logs of xid even if the rows have been changed outside the
+// transaction, the dirty data will be overwritten by the before image.
+func (manager MysqlUndoLogManager) ForceUndo(db proto.DB, xid string) ([]string, error) {
+	return manager.undo(db, xid, true)
+}
+
+func (manager MysqlUndoLogManager) undo(db proto.DB, xid string, force bool) ([]string, error) {
 	var (
 		tx       proto.Tx
 		lockKeys []string
-		result   proto.Result
 		err      error
 	)
 
@@ -77,33 +116,12 @@ func (manager MysqlUndoLogManager) Undo(db proto.DB, xid string) ([]string, erro
 		return lockKeys, err
 	}
 
-	if result, _, err = tx.ExecuteSqlDirectly(SelectUndoLogSql, xid); err != nil {
+	undoLogs, exists, err := manager.selectUndoLogs(tx, xid)
+	if err != nil {
 		return lockKeys, err
 	}
-
-	exists := false
-	undoLogs := make([]*undolog.SqlUndoLog, 0)
-	rlt := result.(*mysql.Result)
-	for _, row := range rlt.Rows {
-		values, err := row.Decode()
-		if err != nil {
-			break
-		}
-
-		branchID := values[0].Val.(int64)
-		rollbackInfo := values[2].Val.([]byte)
-		state := values[3].Val.(int64)
-		exists = true
-
-		if State(state) != Normal {
-			log.Debugf("xid %s branch %d, ignore %s undo_log", xid, branchID, State(state).String())
-			return lockKeys, nil
-		}
-
-		//serializer := getSerializer(context)
-		parser := undolog.GetUndoLogParser()
-		undoLog := parser.DecodeSqlUndoLog(rollbackInfo)
-		undoLogs = append(undoLogs, undoLog)
+	if undoLogs == nil {
+		return lockKeys, nil
 	}
 
 	for _, sqlUndoLog := range undoLogs {
@@ -117,7 +135,12 @@ func (manager MysqlUndoLogManager) Undo(db proto.DB, xid string) ([]string, erro
 		}
 
 		sqlUndoLog.SetTableMeta(tableMeta)
-		err = NewMysqlUndoExecutor(sqlUndoLog).Execute(tx)
+		executor := NewMysqlUndoExecutor(sqlUndoLog)
+		if force {
+			err = executor.ForceExecute(tx)
+		} else {
+			err = executor.Execute(tx)
+		}
 		if err != nil {
 			if _, err := tx.Rollback(context.Background(), nil); err != nil {
 				return lockKeys, err
@@ -148,6 +171,84 @@ func (manager MysqlUndoLogManager) Undo(db proto.DB, xid string) ([]string, erro
 	return lockKeys, nil
 }
 
+// InspectUndo compares the undo logs of xid with the rows currently stored in the tables,
+// nothing is changed, the undo logs are only locked until the inspection finished.
+func (manager MysqlUndoLogManager) InspectUndo(db proto.DB, xid string) ([]*UndoConflict, error) {
+	tx, _, err := db.Begin(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if _, err := tx.Rollback(context.Background(), nil); err != nil {
+			log.Error(err)
+		}
+	}()
+
+	undoLogs, _, err := manager.selectUndoLogs(tx, xid)
+	if err != nil {
+		return nil, err
+	}
+
+	conflicts := make([]*UndoConflict, 0, len(undoLogs))
+	for _, sqlUndoLog := range undoLogs {
+		tableMeta, err := meta.GetTableMetaCache().GetTableMeta(
+			proto.WithSchema(context.Background(), sqlUndoLog.SchemaName), db, sqlUndoLog.TableName)
+		if err != nil {
+			return nil, err
+		}
+		sqlUndoLog.SetTableMeta(tableMeta)
+		currentRecords, err := NewMysqlUndoExecutor(sqlUndoLog).queryCurrentRecords(tx)
+		if err != nil {
+			return nil, err
+		}
+		conflicts = append(conflicts, &UndoConflict{
+			TableName: sqlUndoLog.TableName,
+			SqlType:   sqlUndoLog.SqlType.String(),
+			Dirty: !cmp.Equal(sqlUndoLog.AfterImage, currentRecords) &&
+				!cmp.Equal(sqlUndoLog.BeforeImage, currentRecords),
+			BeforeImage:  sqlUndoLog.BeforeImage,
+			AfterImage:   sqlUndoLog.AfterImage,
+			CurrentImage: currentRecords,
+		})
+	}
+	return conflicts, nil
+}
+
+// selectUndoLogs locks and decodes the undo logs of xid, undo logs is nil when the
+// global transaction has been finished.
+func (manager MysqlUndoLogManager) selectUndoLogs(tx proto.Tx, xid string) ([]*undolog.SqlUndoLog, bool, error) {
+	result, _, err := tx.ExecuteSqlDirectly(SelectUndoLogSql, xid)
+	if err != nil {
+		return nil, false, err
+	}
+
+	exists := false
+	undoLogs := make([]*undolog.SqlUndoLog, 0)
+	rlt := result.(*mysql.Result)
+	for _, row := range rlt.Rows {
+		values, err := row.Decode()
+		if err != nil {
+			break
+		}
+
+		branchID := values[0].Val.(int64)
+		rollbackInfo := values[2].Val.([]byte)
+		state := values[3].Val.(int64)
+		exists = true
+
+		if State(state) != Normal {
+			log.Debugf("xid %s branch %d, ignore %s undo_log", xid, branchID, State(state).String())
+			return nil, exists, nil
+		}
+
+		//serializer := getSerializer(context)
+		parser := undolog.GetUndoLogParser()
+		undoLog := parser.DecodeSqlUndoLog(rollbackInfo)
+		undoLogs = append(undoLogs, undoLog)
+	}
+	return undoLogs, exists, nil
+}
+
 func (manager MysqlUndoLogManager) DeleteUndoLogByID(db proto.DB, id int64) error {
 	result, _, err := db.ExecuteSqlDirectly(DeleteUndoLogByIDSql, id)
 	if err != nil {
@@ -168,15 +269,83 @@ func (manager MysqlUndoLogManager) DeleteUndoLogByXID(db proto.DB, xid string) e
 	return nil
 }
 
-func (manager MysqlUndoLogManager) DeleteUndoLogByLogCreated(db proto.DB, logCreated time.Time, limitRows int) error {
+// DeleteUndoLogByXIDs deletes the undo logs of several global transactions in one statement.
+func (manager MysqlUndoLogManager) DeleteUndoLogByXIDs(db proto.DB, xids []string) error {
+	args := make([]interface{}, 0, len(xids))
+	for _, xid := range xids {
+		args = append(args, xid)
+	}
+	result, _, err := db.ExecuteSqlDirectly(DeleteUndoLogByXIDsSql+misc.MysqlAppendInParam(len(xids)), args...)
+	if err != nil {
+		return err
+	}
+	affectCount, _ := result.RowsAffected()
+	log.Infof("%d undo log deleted by %d xids", affectCount, len(xids))
+	return nil
+}
+
+func (manager MysqlUndoLogManager) DeleteUndoLogByLogCreated(db proto.DB, logCreated time.Time, limitRows int) (int64, error) {
 	// TODO pass ctx.
 	result, _, err := db.ExecuteSqlDirectly(DeleteUndoLogByCreateSql, logCreated, limitRows)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	affectCount, _ := result.RowsAffected()
 	log.Infof("%d undo log deleted created before %v", affectCount, logCreated)
-	return nil
+	return int64(affectCount), nil
+}
+
+// DeleteUndoLogByIDs deletes the undo logs of the given ids in one statement.
+func (manager MysqlUndoLogManager) DeleteUndoLogByIDs(db proto.DB, ids []int64) (int64, error) {
+	args := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	result, _, err := db.ExecuteSqlDirectly(DeleteUndoLogByIDsSql+misc.MysqlAppendInParam(len(ids)), args...)
+	if err != nil {
+		return 0, err
+	}
+	affectCount, _ := result.RowsAffected()
+	log.Infof("%d undo log deleted by %d ids", affectCount, len(ids))
+	return int64(affectCount), nil
+}
+
+// SelectUndoLogByLogCreated returns at most limitRows undo logs created before logCreated, oldest first.
+func (manager MysqlUndoLogManager) SelectUndoLogByLogCreated(db proto.DB, logCreated time.Time, limitRows int) ([]*UndoLogRecord, error) {
+	result, _, err := db.ExecuteSqlDirectly(SelectUndoLogByCreateSql, logCreated, limitRows)
+	if err != nil {
+		return nil, err
+	}
+	rlt := result.(*mysql.Result)
+	records := make([]*UndoLogRecord, 0, len(rlt.Rows))
+	for _, row := range rlt.Rows {
+		values, err := row.Decode()
+		if err != nil {
+			return nil, err
+		}
+		record := &UndoLogRecord{
+			ID:           values[0].Val.(int64),
+			XID:          string(values[1].Val.([]byte)),
+			BranchID:     values[2].Val.(int64),
+			Context:      bytesValue(values[3]),
+			RollbackInfo: bytesValue(values[4]),
+			LogStatus:    values[5].Val.(int64),
+		}
+		if values[6] != nil {
+			if created, ok := values[6].Val.(time.Time); ok {
+				record.LogCreated = created
+			}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func bytesValue(value *proto.Value) []byte {
+	if value == nil || value.Val == nil {
+		return nil
+	}
+	return value.Val.([]byte)
 }
 
 func (manager MysqlUndoLogManager) InsertUndoLogWithNormal(conn proto.Connection, xid string, branchID int64, undoLog *undolog.SqlUndoLog) error {
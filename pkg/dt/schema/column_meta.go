@@ -16,6 +16,8 @@
 
 package schema
 
+import "strings"
+
 type ColumnMeta struct {
 	TableCat        string
 	TableSchemeName string
@@ -29,10 +31,25 @@ type ColumnMeta struct {
 	Nullable        int32
 	Remarks         string
 	ColumnDef       string
+	HasDefault      bool
 	SqlDataType     int32
 	SqlDatetimeSub  int32
 	CharOctetLength int64
 	OrdinalPosition int64
 	IsNullable      string
 	IsAutoIncrement string
+	// GenerationExpression is the expression of a generated column, empty for ordinary columns.
+	GenerationExpression string
+}
+
+// IsGenerated reports whether the column is a VIRTUAL or STORED generated column,
+// which does not accept any value but DEFAULT on insert.
+func (meta ColumnMeta) IsGenerated() bool {
+	extra := strings.ToUpper(meta.IsAutoIncrement)
+	return strings.Contains(extra, "VIRTUAL GENERATED") || strings.Contains(extra, "STORED GENERATED")
+}
+
+// IsAutoIncremental reports whether the column value is generated by AUTO_INCREMENT.
+func (meta ColumnMeta) IsAutoIncremental() bool {
+	return strings.Contains(strings.ToLower(meta.IsAutoIncrement), "auto_increment")
 }
@@ -51,13 +51,14 @@ const (
 )
 
 type store struct {
-	client                    *clientv3.Client
-	session                   *concurrency.Session
+	client *clientv3.Client
+	// leaderLeaseTTL is the ttl in seconds of the lease kept alive by the leader
+	leaderLeaseTTL            int
 	initGlobalSessionRevision int64
 	initBranchSessionRevision int64
 }
 
-func NewEtcdStore(config clientv3.Config) storage.Driver {
+func NewEtcdStore(config clientv3.Config, leaderLeaseTTL int) storage.Driver {
 	if config.DialTimeout == 0 {
 		config.DialTimeout = 5 * time.Second
 	}
@@ -66,13 +67,9 @@ func NewEtcdStore(config clientv3.Config) storage.Driver {
 	if err != nil {
 		log.Fatal(err)
 	}
-	session, err := concurrency.NewSession(client)
-	if err != nil {
-		log.Fatal(err)
-	}
 	return &store{
 		client:                    client,
-		session:                   session,
+		leaderLeaseTTL:            leaderLeaseTTL,
 		initGlobalSessionRevision: 0,
 		initBranchSessionRevision: 0,
 	}
@@ -91,14 +88,23 @@ type watchChan struct {
 	isGlobalSession   bool
 }
 
-func (s *store) LeaderElection(applicationID string) bool {
-	e := concurrency.NewElection(s.session, fmt.Sprintf("%s/leader-election/", applicationID))
-	ctx := context.Background()
+// LeaderElection blocks until this instance is elected as the leader of applicationID or ctx is done.
+// The leadership is bound to a lease, the returned channel is closed when the lease is lost, for
+// example the instance could not reach etcd before the lease expired, and another instance may
+// have been elected by then.
+func (s *store) LeaderElection(ctx context.Context, applicationID string) (<-chan struct{}, error) {
+	// a session can not be reused once its lease expired, so every campaign starts a new one
+	session, err := concurrency.NewSession(s.client, concurrency.WithTTL(s.leaderLeaseTTL))
+	if err != nil {
+		return nil, err
+	}
+	e := concurrency.NewElection(session, fmt.Sprintf("%s/leader-election/", applicationID))
 	// Elect a leader (or wait that the leader resign)
 	if err := e.Campaign(ctx, applicationID); err != nil {
-		log.Fatal(err)
+		session.Close()
+		return nil, err
 	}
-	return true
+	return session.Done(), nil
 }
 
 func (s *store) AddGlobalSession(ctx context.Context, globalSession *api.GlobalSession) error {
@@ -573,6 +579,43 @@ func (s *store) ListDeadBranchSession(ctx context.Context, applicationID string)
 	return result, nil
 }
 
+func (s *store) GetDeadBranchSession(ctx context.Context, branchID string) (*api.BranchSession, error) {
+	deadBranchKey := fmt.Sprintf(DeadBranchKeyFormat, branchID)
+	resp, err := s.client.Get(ctx, deadBranchKey, clientv3.WithSerializable())
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, err2.CouldNotFoundBranchTransaction
+	}
+	branchSession := &api.BranchSession{}
+	err = branchSession.Unmarshal(resp.Kvs[0].Value)
+	if err != nil {
+		return nil, err
+	}
+	return branchSession, nil
+}
+
+func (s *store) DeleteDeadBranchSession(ctx context.Context, branchID string) error {
+	deadBranchKey := fmt.Sprintf(DeadBranchKeyFormat, branchID)
+	resp, err := s.client.Delete(ctx, deadBranchKey, clientv3.WithPrevKV())
+	if err != nil {
+		return err
+	}
+	for _, kv := range resp.PrevKvs {
+		branchSession := &api.BranchSession{}
+		err = branchSession.Unmarshal(kv.Value)
+		if err != nil {
+			return err
+		}
+		globalBranchKey := fmt.Sprintf(BranchKeyFormat, branchSession.XID, branchSession.BranchSessionID)
+		if _, err := s.client.Delete(ctx, globalBranchKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func notFound(key string) clientv3.Cmp {
 	return clientv3.Compare(clientv3.ModRevision(key), "=", 0)
 }
@@ -23,7 +23,7 @@ import (
 )
 
 type Driver interface {
-	LeaderElection(applicationID string) bool
+	LeaderElection(ctx context.Context, applicationID string) (<-chan struct{}, error)
 	AddGlobalSession(ctx context.Context, globalSession *api.GlobalSession) error
 	AddBranchSession(ctx context.Context, branchSession *api.BranchSession) error
 	GlobalCommit(ctx context.Context, xid string) (api.GlobalSession_GlobalStatus, error)
@@ -41,6 +41,8 @@ type Driver interface {
 	ReleaseLockKeys(ctx context.Context, resourceID string, lockKeys []string) (bool, error)
 	SetBranchSessionDead(ctx context.Context, branchSession *api.BranchSession) error
 	ListDeadBranchSession(ctx context.Context, applicationID string) ([]*api.BranchSession, error)
+	GetDeadBranchSession(ctx context.Context, branchID string) (*api.BranchSession, error)
+	DeleteDeadBranchSession(ctx context.Context, branchID string) error
 	WatchGlobalSessions(ctx context.Context, applicationID string) Watcher
 	WatchBranchSessions(ctx context.Context, applicationID string) Watcher
 }
@@ -0,0 +1,227 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dt
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/dt/api"
+	"github.com/cectc/dbpack/pkg/dt/metrics"
+	"github.com/cectc/dbpack/pkg/dt/storage"
+	"github.com/cectc/dbpack/pkg/misc"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/resource"
+	"github.com/cectc/dbpack/testdata"
+)
+
+type deadBranchStorageDriver struct {
+	storage.Driver
+	deadBranchSessions map[string]*api.BranchSession
+	releasedLockKeys   []string
+}
+
+func (driver *deadBranchStorageDriver) GetDeadBranchSession(ctx context.Context, branchID string) (*api.BranchSession, error) {
+	return driver.deadBranchSessions[branchID], nil
+}
+
+func (driver *deadBranchStorageDriver) SetBranchSessionDead(ctx context.Context, branchSession *api.BranchSession) error {
+	driver.deadBranchSessions[branchSession.BranchID] = branchSession
+	return nil
+}
+
+func (driver *deadBranchStorageDriver) DeleteDeadBranchSession(ctx context.Context, branchID string) error {
+	delete(driver.deadBranchSessions, branchID)
+	return nil
+}
+
+func (driver *deadBranchStorageDriver) ReleaseLockKeys(ctx context.Context, resourceID string, lockKeys []string) (bool, error) {
+	driver.releasedLockKeys = append(driver.releasedLockKeys, lockKeys...)
+	return true, nil
+}
+
+func TestRecordGlobalTransactionMetric(t *testing.T) {
+	manager := &DistributedTransactionManager{applicationID: "metric_app"}
+	gs := &api.GlobalSession{
+		XID:             "gs/metric_app/1",
+		TransactionName: "create_order",
+		BeginTime:       int64(misc.CurrentTimeMillis()),
+	}
+	metrics.GlobalTransactionGauge.WithLabelValues("metric_app", "create_order").Inc()
+	manager.spanContexts.Store(gs.XID, trace.SpanContext{})
+
+	manager.recordGlobalTransactionMetric(gs, metrics.TransactionStatusTimeout)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.GlobalTransactionGauge.WithLabelValues("metric_app", "create_order")))
+	_, ok := manager.spanContexts.Load(gs.XID)
+	assert.True(t, ok)
+
+	manager.recordGlobalTransactionMetric(gs, metrics.TransactionStatusCommitted)
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.GlobalTransactionGauge.WithLabelValues("metric_app", "create_order")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.GlobalTransactionCounter.WithLabelValues(
+		"metric_app", "create_order", metrics.TransactionStatusCommitted)))
+	_, ok = manager.spanContexts.Load(gs.XID)
+	assert.False(t, ok)
+}
+
+func TestRetryBranchSession(t *testing.T) {
+	driver := &deadBranchStorageDriver{deadBranchSessions: make(map[string]*api.BranchSession)}
+	manager := &DistributedTransactionManager{
+		applicationID:    "metric_app",
+		storageDriver:    driver,
+		branchRetryQueue: newBranchRetryQueue(config.BranchRetry{MaxAttempts: 1}),
+	}
+
+	bs := &api.BranchSession{BranchID: "bs/metric_app/1", ApplicationID: "metric_app", ResourceID: "employees"}
+	manager.retryBranchSession(bs, metrics.TransactionStatusRollbacked)
+	assert.Equal(t, 1, manager.branchRetryQueue.len())
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.BranchTransactionRetryCounter.WithLabelValues(
+		"metric_app", "employees", metrics.TransactionStatusRollbacked)))
+
+	// exceeded max attempts
+	manager.retryBranchSession(bs, metrics.TransactionStatusRollbacked)
+	assert.Equal(t, bs, driver.deadBranchSessions[bs.BranchID])
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.BranchTransactionCounter.WithLabelValues(
+		"metric_app", "employees", metrics.TransactionStatusRetryExhausted)))
+}
+
+func TestResolveDeadBranchSession(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db := testdata.NewMockDB(ctrl)
+	db.EXPECT().ExecuteSqlDirectly(DeleteUndoLogByXIDSql, "gs/compensate_app/1").
+		Return(&mysql.Result{AffectedRows: 1}, uint16(0), nil)
+	dbManager := testdata.NewMockDBManager(ctrl)
+	dbManager.EXPECT().GetDB("employees").Return(db)
+	resource.SetDBManager("compensate_app", dbManager)
+
+	driver := &deadBranchStorageDriver{
+		deadBranchSessions: map[string]*api.BranchSession{
+			"bs/compensate_app/1": {
+				BranchID:   "bs/compensate_app/1",
+				XID:        "gs/compensate_app/1",
+				ResourceID: "employees",
+				LockKey:    "employees:1",
+				Type:       api.AT,
+			},
+			"bs/compensate_app/2": {
+				BranchID:   "bs/compensate_app/2",
+				XID:        "gs/compensate_app/2",
+				ResourceID: "employees",
+				Type:       api.TCC,
+			},
+		},
+	}
+	manager := &DistributedTransactionManager{
+		applicationID: "compensate_app",
+		storageDriver: driver,
+	}
+
+	err := manager.ResolveDeadBranchSession(context.Background(), "bs/compensate_app/2")
+	assert.EqualError(t, err, "branch session bs/compensate_app/2 is not an AT branch session")
+
+	err = manager.ResolveDeadBranchSession(context.Background(), "bs/compensate_app/1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"employees:1"}, driver.releasedLockKeys)
+	assert.NotContains(t, driver.deadBranchSessions, "bs/compensate_app/1")
+	assert.Contains(t, driver.deadBranchSessions, "bs/compensate_app/2")
+}
+
+type leaderStorageDriver struct {
+	storage.Driver
+	// campaigns hands out the channel closed when the leadership of the campaign is lost
+	campaigns chan chan struct{}
+}
+
+func (driver *leaderStorageDriver) LeaderElection(ctx context.Context, applicationID string) (<-chan struct{}, error) {
+	select {
+	case lost := <-driver.campaigns:
+		return lost, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (driver *leaderStorageDriver) ListGlobalSession(ctx context.Context, applicationID string) ([]*api.GlobalSession, error) {
+	return nil, nil
+}
+
+func (driver *leaderStorageDriver) ListBranchSession(ctx context.Context, applicationID string) ([]*api.BranchSession, error) {
+	return nil, nil
+}
+
+func (driver *leaderStorageDriver) WatchBranchSessions(ctx context.Context, applicationID string) storage.Watcher {
+	return &idleWatcher{}
+}
+
+type idleWatcher struct{}
+
+func (watcher *idleWatcher) Stop() {}
+
+func (watcher *idleWatcher) ResultChan() <-chan storage.TransactionSession {
+	return nil
+}
+
+func TestRunLeaderElection(t *testing.T) {
+	driver := &leaderStorageDriver{campaigns: make(chan chan struct{})}
+	manager := &DistributedTransactionManager{
+		applicationID:      "leader_app",
+		storageDriver:      driver,
+		globalSessionQueue: workqueue.NewDelayingQueue(),
+		branchSessionQueue: workqueue.New(),
+	}
+	manager.branchCommitter = newBranchCommitter(manager, 10)
+	manager.branchRetryQueue = newBranchRetryQueue(config.BranchRetry{})
+	defer manager.globalSessionQueue.ShutDown()
+	defer manager.branchSessionQueue.ShutDown()
+	defer manager.branchCommitter.queue.ShutDown()
+
+	elected := testutil.ToFloat64(metrics.LeaderChangeCounter.WithLabelValues("leader_app", metrics.LeaderElected))
+	lostCount := testutil.ToFloat64(metrics.LeaderChangeCounter.WithLabelValues("leader_app", metrics.LeaderLost))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		manager.runLeaderElection(ctx, &config.DistributedTransaction{BranchCommitWorkers: 1})
+		close(done)
+	}()
+	assert.False(t, manager.IsMaster())
+
+	lost := make(chan struct{})
+	driver.campaigns <- lost
+	assert.Eventually(t, manager.IsMaster, time.Second, 10*time.Millisecond)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.LeaderGauge.WithLabelValues("leader_app")))
+
+	// the lease expired, campaign again
+	close(lost)
+	driver.campaigns <- make(chan struct{})
+	assert.Eventually(t, manager.IsMaster, time.Second, 10*time.Millisecond)
+	assert.Equal(t, elected+2, testutil.ToFloat64(metrics.LeaderChangeCounter.WithLabelValues("leader_app", metrics.LeaderElected)))
+	assert.Equal(t, lostCount+1, testutil.ToFloat64(metrics.LeaderChangeCounter.WithLabelValues("leader_app", metrics.LeaderLost)))
+
+	cancel()
+	<-done
+	assert.False(t, manager.IsMaster())
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.LeaderGauge.WithLabelValues("leader_app")))
+}
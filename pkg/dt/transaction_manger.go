@@ -21,10 +21,14 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"k8s.io/client-go/util/workqueue"
 
 	"github.com/cectc/dbpack/pkg/config"
@@ -37,6 +41,7 @@ import (
 	"github.com/cectc/dbpack/pkg/misc/uuid"
 	"github.com/cectc/dbpack/pkg/proto"
 	"github.com/cectc/dbpack/pkg/resource"
+	"github.com/cectc/dbpack/pkg/tracing"
 )
 
 const (
@@ -48,6 +53,18 @@ const (
 
 	// DefaultRetryDeadThreshold is max retry milliseconds
 	DefaultRetryDeadThreshold = 130 * 1000
+
+	// DefaultBranchCommitWorkers is the default number of phase two commit workers
+	DefaultBranchCommitWorkers = 4
+
+	// DefaultBranchCommitBatchSize is the default number of branch sessions committed in a batch
+	DefaultBranchCommitBatchSize = 100
+
+	// DefaultLeaderLeaseTTL is the default ttl in seconds of the leader lease
+	DefaultLeaderLeaseTTL = 10
+
+	// leaderElectionRetryInterval is the interval between two campaigns when the campaign failed
+	leaderElectionRetryInterval = time.Second
 )
 
 var (
@@ -60,7 +77,19 @@ func RegisterTransactionManager(conf *config.DistributedTransaction) {
 	if conf.RetryDeadThreshold == 0 {
 		conf.RetryDeadThreshold = DefaultRetryDeadThreshold
 	}
-	driver := etcd.NewEtcdStore(*conf.EtcdConfig)
+	if conf.BranchCommitWorkers <= 0 {
+		conf.BranchCommitWorkers = DefaultBranchCommitWorkers
+	}
+	if conf.BranchCommitBatchSize <= 0 {
+		conf.BranchCommitBatchSize = DefaultBranchCommitBatchSize
+	}
+	if conf.BranchRetry.Workers <= 0 {
+		conf.BranchRetry.Workers = DefaultBranchRetryWorkers
+	}
+	if conf.LeaderLeaseTTL <= 0 {
+		conf.LeaderLeaseTTL = DefaultLeaderLeaseTTL
+	}
+	driver := etcd.NewEtcdStore(*conf.EtcdConfig, conf.LeaderLeaseTTL)
 	manager := &DistributedTransactionManager{
 		applicationID:                    conf.AppID,
 		storageDriver:                    driver,
@@ -70,20 +99,9 @@ func RegisterTransactionManager(conf *config.DistributedTransaction) {
 		globalSessionQueue: workqueue.NewDelayingQueue(),
 		branchSessionQueue: workqueue.New(),
 	}
-	go func() {
-		if driver.LeaderElection(manager.applicationID) {
-			manager.isMaster = true
-			if err := manager.processGlobalSessions(); err != nil {
-				log.Fatal(err)
-			}
-			if err := manager.processBranchSessions(); err != nil {
-				log.Fatal(err)
-			}
-			go manager.processGlobalSessionQueue()
-			go manager.processBranchSessionQueue()
-			go manager.watchBranchSession()
-		}
-	}()
+	manager.branchCommitter = newBranchCommitter(manager, conf.BranchCommitBatchSize)
+	manager.branchRetryQueue = newBranchRetryQueue(conf.BranchRetry)
+	go manager.runLeaderElection(context.Background(), conf)
 	managers[conf.AppID] = manager
 }
 
@@ -91,8 +109,28 @@ func GetTransactionManager(appID string) proto.DistributedTransactionManager {
 	return managers[appID]
 }
 
+// ManualCompensator handles the dead AT branch sessions whose rollback failed because the
+// rows were changed outside the distributed transaction.
+type ManualCompensator interface {
+	// InspectDeadBranchSession returns the undo images of the branch and the rows currently stored.
+	InspectDeadBranchSession(ctx context.Context, branchID string) ([]*UndoConflict, error)
+	// ResolveDeadBranchSession marks the branch as manually resolved, the undo logs are dropped
+	// and the global locks are released without touching the business data.
+	ResolveDeadBranchSession(ctx context.Context, branchID string) error
+	// ForceRollbackDeadBranchSession applies the undo images regardless of the dirty rows.
+	ForceRollbackDeadBranchSession(ctx context.Context, branchID string) error
+}
+
+func GetManualCompensator(appID string) ManualCompensator {
+	if compensator, ok := managers[appID].(ManualCompensator); ok {
+		return compensator
+	}
+	return nil
+}
+
 type DistributedTransactionManager struct {
-	isMaster bool
+	// isMaster is 1 during the leadership of this instance
+	isMaster int32
 
 	applicationID                    string
 	storageDriver                    storage.Driver
@@ -101,9 +139,19 @@ type DistributedTransactionManager struct {
 
 	globalSessionQueue workqueue.DelayingInterface
 	branchSessionQueue workqueue.Interface
+	branchCommitter    *branchCommitter
+	branchRetryQueue   *branchRetryQueue
+	xaBranchOutcomes   xaBranchOutcomes
+
+	// spanContexts maps xid to the span that committed or rolled back the global transaction,
+	// so that the asynchronous phase two spans can be linked to it, map[string]trace.SpanContext
+	spanContexts sync.Map
 }
 
 func (manager *DistributedTransactionManager) Begin(ctx context.Context, transactionName string, timeout int32) (string, error) {
+	spanCtx, span := tracing.GetTraceSpan(ctx, tracing.CoordinatorBegin)
+	defer span.End()
+
 	transactionID := uuid.NextID()
 	xid := fmt.Sprintf("gs/%s/%d", manager.applicationID, transactionID)
 	gt := &api.GlobalSession{
@@ -115,24 +163,49 @@ func (manager *DistributedTransactionManager) Begin(ctx context.Context, transac
 		BeginTime:       int64(misc.CurrentTimeMillis()),
 		Status:          api.Begin,
 	}
-	if err := manager.storageDriver.AddGlobalSession(ctx, gt); err != nil {
+	span.SetAttributes(attribute.String("xid", xid))
+	if err := manager.storageDriver.AddGlobalSession(spanCtx, gt); err != nil {
+		tracing.RecordErrorSpan(span, err)
 		return "", err
 	}
 	metrics.GlobalTransactionCounter.WithLabelValues(manager.applicationID, transactionName, metrics.TransactionStatusActive).Inc()
+	metrics.GlobalTransactionGauge.WithLabelValues(manager.applicationID, transactionName).Inc()
 	manager.globalSessionQueue.AddAfter(gt, time.Duration(timeout)*time.Millisecond)
 	log.Infof("successfully begin global transaction xid = {%s}", gt.XID)
 	return xid, nil
 }
 
 func (manager *DistributedTransactionManager) Commit(ctx context.Context, xid string) (api.GlobalSession_GlobalStatus, error) {
-	return manager.storageDriver.GlobalCommit(ctx, xid)
+	spanCtx, span := tracing.GetTraceSpan(ctx, tracing.CoordinatorCommit)
+	defer span.End()
+	span.SetAttributes(attribute.String("xid", xid))
+	manager.spanContexts.Store(xid, span.SpanContext())
+
+	status, err := manager.storageDriver.GlobalCommit(spanCtx, xid)
+	if err != nil {
+		tracing.RecordErrorSpan(span, err)
+	}
+	return status, err
 }
 
 func (manager *DistributedTransactionManager) Rollback(ctx context.Context, xid string) (api.GlobalSession_GlobalStatus, error) {
-	return manager.storageDriver.GlobalRollback(ctx, xid)
+	spanCtx, span := tracing.GetTraceSpan(ctx, tracing.CoordinatorRollback)
+	defer span.End()
+	span.SetAttributes(attribute.String("xid", xid))
+	manager.spanContexts.Store(xid, span.SpanContext())
+
+	status, err := manager.storageDriver.GlobalRollback(spanCtx, xid)
+	if err != nil {
+		tracing.RecordErrorSpan(span, err)
+	}
+	return status, err
 }
 
 func (manager *DistributedTransactionManager) BranchRegister(ctx context.Context, in *api.BranchRegisterRequest) (string, int64, error) {
+	spanCtx, span := tracing.GetTraceSpan(ctx, tracing.CoordinatorBranchRegister)
+	defer span.End()
+	span.SetAttributes(attribute.String("xid", in.XID), attribute.String("resource_id", in.ResourceID))
+
 	branchSessionID := uuid.NextID()
 	branchID := fmt.Sprintf("bs/%s/%d", manager.applicationID, branchSessionID)
 	transactionID := misc.GetTransactionID(in.XID)
@@ -150,35 +223,188 @@ func (manager *DistributedTransactionManager) BranchRegister(ctx context.Context
 		BeginTime:       int64(misc.CurrentTimeMillis()),
 	}
 
-	if err := manager.storageDriver.AddBranchSession(ctx, bs); err != nil {
+	if err := manager.storageDriver.AddBranchSession(spanCtx, bs); err != nil {
+		tracing.RecordErrorSpan(span, err)
 		return "", 0, err
 	}
 	metrics.BranchTransactionCounter.WithLabelValues(manager.applicationID, in.ResourceID, metrics.TransactionStatusActive).Inc()
+	metrics.BranchTransactionGauge.WithLabelValues(manager.applicationID, in.ResourceID).Inc()
 	return branchID, branchSessionID, nil
 }
 
 func (manager *DistributedTransactionManager) BranchReport(ctx context.Context, branchID string, status api.BranchSession_BranchStatus) error {
-	return manager.storageDriver.BranchReport(ctx, branchID, status)
+	spanCtx, span := tracing.GetTraceSpan(ctx, tracing.CoordinatorBranchReport)
+	defer span.End()
+	span.SetAttributes(attribute.String("branch_id", branchID), attribute.String("status", status.String()))
+
+	err := manager.storageDriver.BranchReport(spanCtx, branchID, status)
+	if err != nil {
+		tracing.RecordErrorSpan(span, err)
+	}
+	return err
 }
 
 func (manager *DistributedTransactionManager) ReleaseLockKeys(ctx context.Context, resourceID string, lockKeys []string) (bool, error) {
-	return manager.storageDriver.ReleaseLockKeys(ctx, resourceID, lockKeys)
+	spanCtx, span := tracing.GetTraceSpan(ctx, tracing.CoordinatorReleaseLockKeys)
+	defer span.End()
+	span.SetAttributes(attribute.String("resource_id", resourceID))
+
+	released, err := manager.storageDriver.ReleaseLockKeys(spanCtx, resourceID, lockKeys)
+	if err != nil {
+		tracing.RecordErrorSpan(span, err)
+	}
+	return released, err
 }
 
 func (manager *DistributedTransactionManager) IsLockable(ctx context.Context, resourceID, lockKey string) (bool, error) {
-	return manager.storageDriver.IsLockable(ctx, resourceID, lockKey)
+	spanCtx, span := tracing.GetTraceSpan(ctx, tracing.CoordinatorIsLockable)
+	defer span.End()
+	span.SetAttributes(attribute.String("resource_id", resourceID))
+
+	lockable, err := manager.storageDriver.IsLockable(spanCtx, resourceID, lockKey)
+	if err != nil {
+		tracing.RecordErrorSpan(span, err)
+	}
+	return lockable, err
 }
 
 func (manager *DistributedTransactionManager) IsLockableWithXID(ctx context.Context, resourceID, lockKey, xid string) (bool, error) {
-	return manager.storageDriver.IsLockableWithXID(ctx, resourceID, lockKey, xid)
+	spanCtx, span := tracing.GetTraceSpan(ctx, tracing.CoordinatorIsLockable)
+	defer span.End()
+	span.SetAttributes(attribute.String("xid", xid), attribute.String("resource_id", resourceID))
+
+	lockable, err := manager.storageDriver.IsLockableWithXID(spanCtx, resourceID, lockKey, xid)
+	if err != nil {
+		tracing.RecordErrorSpan(span, err)
+	}
+	return lockable, err
 }
 
 func (manager *DistributedTransactionManager) ListDeadBranchSessions(ctx context.Context) ([]*api.BranchSession, error) {
 	return manager.storageDriver.ListDeadBranchSession(ctx, manager.applicationID)
 }
 
+func (manager *DistributedTransactionManager) InspectDeadBranchSession(ctx context.Context, branchID string) ([]*UndoConflict, error) {
+	bs, db, err := manager.getDeadATBranchSession(ctx, branchID)
+	if err != nil {
+		return nil, err
+	}
+	return GetUndoLogManager().InspectUndo(db, bs.XID)
+}
+
+func (manager *DistributedTransactionManager) ResolveDeadBranchSession(ctx context.Context, branchID string) error {
+	bs, db, err := manager.getDeadATBranchSession(ctx, branchID)
+	if err != nil {
+		return err
+	}
+	if err := GetUndoLogManager().DeleteUndoLogByXID(db, bs.XID); err != nil {
+		return err
+	}
+	log.Infof("dead branch session manually resolved, branch id: %s, lock key: %s", bs.BranchID, bs.LockKey)
+	return manager.removeDeadBranchSession(ctx, bs, []string{bs.LockKey})
+}
+
+func (manager *DistributedTransactionManager) ForceRollbackDeadBranchSession(ctx context.Context, branchID string) error {
+	bs, db, err := manager.getDeadATBranchSession(ctx, branchID)
+	if err != nil {
+		return err
+	}
+	if bs.Status == api.PhaseTwoCommitting {
+		return errors.Errorf("branch session %s is committing, it can not be rolled back", branchID)
+	}
+	lockKeys, err := GetUndoLogManager().ForceUndo(db, bs.XID)
+	if err != nil {
+		return err
+	}
+	if len(lockKeys) == 0 {
+		lockKeys = []string{bs.LockKey}
+	}
+	log.Infof("dead branch session force rollbacked, branch id: %s, lock key: %s", bs.BranchID, bs.LockKey)
+	return manager.removeDeadBranchSession(ctx, bs, lockKeys)
+}
+
+func (manager *DistributedTransactionManager) getDeadATBranchSession(ctx context.Context, branchID string) (*api.BranchSession, proto.DB, error) {
+	bs, err := manager.storageDriver.GetDeadBranchSession(ctx, branchID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if bs.Type != api.AT {
+		return nil, nil, errors.Errorf("branch session %s is not an AT branch session", branchID)
+	}
+	db := resource.GetDBManager(manager.applicationID).GetDB(bs.ResourceID)
+	if db == nil {
+		return nil, nil, fmt.Errorf("DB resource is not exist, db name: %s", bs.ResourceID)
+	}
+	return bs, db, nil
+}
+
+func (manager *DistributedTransactionManager) removeDeadBranchSession(ctx context.Context, bs *api.BranchSession, lockKeys []string) error {
+	if _, err := manager.storageDriver.ReleaseLockKeys(ctx, bs.ResourceID, lockKeys); err != nil {
+		return err
+	}
+	return manager.storageDriver.DeleteDeadBranchSession(ctx, bs.BranchID)
+}
+
 func (manager *DistributedTransactionManager) IsMaster() bool {
-	return manager.isMaster
+	return atomic.LoadInt32(&manager.isMaster) == 1
+}
+
+// runLeaderElection campaigns for the leadership until ctx is done. Only the leader runs the
+// commit and rollback retry loops and the undo log cleanup, they are started when this instance
+// is elected and stopped when the leadership is lost, then the instance campaigns again.
+func (manager *DistributedTransactionManager) runLeaderElection(ctx context.Context, conf *config.DistributedTransaction) {
+	for {
+		lost, err := manager.storageDriver.LeaderElection(ctx, manager.applicationID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Errorf("leader election of %s failed: %v", manager.applicationID, err)
+			time.Sleep(leaderElectionRetryInterval)
+			continue
+		}
+
+		leaderCtx, cancel := context.WithCancel(ctx)
+		manager.startLeading(leaderCtx, conf)
+		select {
+		case <-lost:
+		case <-ctx.Done():
+		}
+		cancel()
+		manager.stopLeading()
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (manager *DistributedTransactionManager) startLeading(ctx context.Context, conf *config.DistributedTransaction) {
+	log.Infof("%s elected as the leader of distributed transaction coordinator", manager.applicationID)
+	atomic.StoreInt32(&manager.isMaster, 1)
+	metrics.LeaderGauge.WithLabelValues(manager.applicationID).Set(1)
+	metrics.LeaderChangeCounter.WithLabelValues(manager.applicationID, metrics.LeaderElected).Inc()
+
+	manager.branchCommitter.run(ctx, conf.BranchCommitWorkers)
+	manager.branchRetryQueue.run(ctx, conf.BranchRetry.Workers, manager.processBranchSession)
+	if err := manager.processGlobalSessions(); err != nil {
+		log.Fatal(err)
+	}
+	if err := manager.processBranchSessions(); err != nil {
+		log.Fatal(err)
+	}
+	go manager.processGlobalSessionQueue(ctx)
+	go manager.processBranchSessionQueue(ctx)
+	go manager.watchBranchSession(ctx)
+	if conf.UndoLogCleanup != nil {
+		go NewUndoLogReaper(manager.applicationID, conf.UndoLogCleanup).Run(ctx)
+	}
+}
+
+func (manager *DistributedTransactionManager) stopLeading() {
+	log.Warnf("%s lost the leadership of distributed transaction coordinator", manager.applicationID)
+	atomic.StoreInt32(&manager.isMaster, 0)
+	metrics.LeaderGauge.WithLabelValues(manager.applicationID).Set(0)
+	metrics.LeaderChangeCounter.WithLabelValues(manager.applicationID, metrics.LeaderLost).Inc()
 }
 
 func (manager *DistributedTransactionManager) branchCommit(bs *api.BranchSession) (api.BranchSession_BranchStatus, error) {
@@ -191,6 +417,8 @@ func (manager *DistributedTransactionManager) branchCommit(bs *api.BranchSession
 		status, err = manager.tccBranchCommit(bs)
 	case api.AT:
 		status, err = manager._branchCommit(bs)
+	case api.XA:
+		status, err = manager.xaBranchPhaseTwo(bs, true)
 	default:
 		return bs.Status, errors.New("should never happen!")
 	}
@@ -226,6 +454,8 @@ func (manager *DistributedTransactionManager) branchRollback(bs *api.BranchSessi
 		status, err = manager.tccBranchRollback(bs)
 	case api.AT:
 		status, lockKeys, err = manager._branchRollback(bs)
+	case api.XA:
+		status, err = manager.xaBranchPhaseTwo(bs, false)
 	default:
 		return bs.Status, errors.New("should never happen!")
 	}
@@ -299,22 +529,22 @@ func (manager *DistributedTransactionManager) processGlobalSessions() error {
 				switch gs.Status {
 				case api.Committing:
 					log.Debugf("global session commit finished, key: %s", gs.XID)
-					manager.recordGlobalTransactionMetric(gs.TransactionName, metrics.TransactionStatusCommitted)
+					manager.recordGlobalTransactionMetric(gs, metrics.TransactionStatusCommitted)
 				case api.Rollbacking:
 					log.Debugf("global session rollback finished, key: %s", gs.XID)
-					manager.recordGlobalTransactionMetric(gs.TransactionName, metrics.TransactionStatusRollbacked)
+					manager.recordGlobalTransactionMetric(gs, metrics.TransactionStatusRollbacked)
 				}
 			} else {
 				// global transaction timeout
-				manager.recordGlobalTransactionMetric(gs.TransactionName, metrics.TransactionStatusTimeout)
+				manager.recordGlobalTransactionMetric(gs, metrics.TransactionStatusTimeout)
 			}
 		}
 	}
 	return nil
 }
 
-func (manager *DistributedTransactionManager) processGlobalSessionQueue() {
-	for manager.processNextGlobalSession(context.Background()) {
+func (manager *DistributedTransactionManager) processGlobalSessionQueue(ctx context.Context) {
+	for manager.processNextGlobalSession(ctx) {
 	}
 }
 
@@ -332,6 +562,11 @@ func (manager *DistributedTransactionManager) processNextGlobalSession(ctx conte
 	// put back on the workqueue and attempted again after a back-off
 	// period.
 	defer manager.globalSessionQueue.Done(obj)
+	if ctx.Err() != nil {
+		// the leadership is lost, leave the global session to the next term
+		manager.globalSessionQueue.Add(obj)
+		return false
+	}
 
 	gs := obj.(*api.GlobalSession)
 	newGlobalSession, err := manager.storageDriver.GetGlobalSession(ctx, gs.XID)
@@ -358,14 +593,14 @@ func (manager *DistributedTransactionManager) processNextGlobalSession(ctx conte
 			switch newGlobalSession.Status {
 			case api.Committing:
 				log.Debugf("global session commit finished, key: %s", newGlobalSession.XID)
-				manager.recordGlobalTransactionMetric(gs.TransactionName, metrics.TransactionStatusCommitted)
+				manager.recordGlobalTransactionMetric(gs, metrics.TransactionStatusCommitted)
 			case api.Rollbacking:
 				log.Debugf("global session rollback finished, key: %s", newGlobalSession.XID)
-				manager.recordGlobalTransactionMetric(gs.TransactionName, metrics.TransactionStatusRollbacked)
+				manager.recordGlobalTransactionMetric(gs, metrics.TransactionStatusRollbacked)
 			}
 		} else {
 			// global transaction timeout.
-			manager.recordGlobalTransactionMetric(gs.TransactionName, metrics.TransactionStatusTimeout)
+			manager.recordGlobalTransactionMetric(gs, metrics.TransactionStatusTimeout)
 		}
 	}
 	return true
@@ -387,15 +622,8 @@ func (manager *DistributedTransactionManager) processBranchSessions() error {
 			manager.branchSessionQueue.Add(bs)
 		case api.PhaseTwoRollbacking:
 			if manager.IsRollingBackDead(bs) {
-				metrics.BranchTransactionCounter.WithLabelValues(bs.ApplicationID, bs.ResourceID, metrics.TransactionStatusTimeout)
 				log.Debugf("branch session rollback dead, key: %s, lock key: %s", bs.BranchID, bs.LockKey)
-				if manager.rollbackRetryTimeoutUnlockEnable {
-					log.Debugf("branch id: %s, lock key: %s released", bs.BranchID, bs.LockKey)
-					if _, err := manager.storageDriver.ReleaseLockKeys(context.Background(), bs.ResourceID, []string{bs.LockKey}); err != nil {
-						return err
-					}
-				}
-				if err := manager.storageDriver.SetBranchSessionDead(context.Background(), bs); err != nil {
+				if err := manager.setBranchSessionDead(context.Background(), bs, metrics.TransactionStatusTimeout); err != nil {
 					return err
 				}
 			} else {
@@ -406,8 +634,8 @@ func (manager *DistributedTransactionManager) processBranchSessions() error {
 	return nil
 }
 
-func (manager *DistributedTransactionManager) processBranchSessionQueue() {
-	for manager.processNextBranchSession(context.Background()) {
+func (manager *DistributedTransactionManager) processBranchSessionQueue(ctx context.Context) {
+	for manager.processNextBranchSession(ctx) {
 	}
 }
 
@@ -425,68 +653,149 @@ func (manager *DistributedTransactionManager) processNextBranchSession(ctx conte
 	// put back on the workqueue and attempted again after a back-off
 	// period.
 	defer manager.branchSessionQueue.Done(obj)
+	if ctx.Err() != nil {
+		// the leadership is lost, leave the branch session to the next term
+		manager.branchSessionQueue.Add(obj)
+		return false
+	}
+
+	manager.processBranchSession(ctx, obj.(*api.BranchSession))
+	return true
+}
 
-	bs := obj.(*api.BranchSession)
+func (manager *DistributedTransactionManager) processBranchSession(ctx context.Context, bs *api.BranchSession) {
 	var (
 		status            api.BranchSession_BranchStatus
 		transactionStatus string
 		err               error
 	)
 	if bs.Status == api.PhaseTwoCommitting {
-		transactionStatus = metrics.TransactionStatusCommitted
-		status, err = manager.branchCommit(bs)
-		if err != nil {
-			log.Error(err)
-			manager.branchSessionQueue.Add(obj)
-		}
-		if status != api.Complete {
-			manager.branchSessionQueue.Add(obj)
-		}
+		manager.branchCommitter.add(bs)
+		return
 	}
 	if bs.Status == api.PhaseTwoRollbacking {
 		transactionStatus = metrics.TransactionStatusRollbacked
 		if manager.IsRollingBackDead(bs) {
-			metrics.BranchTransactionCounter.WithLabelValues(bs.ApplicationID, bs.ResourceID, metrics.TransactionStatusTimeout)
-			if manager.rollbackRetryTimeoutUnlockEnable {
-				if _, err := manager.storageDriver.ReleaseLockKeys(ctx, bs.ResourceID, []string{bs.LockKey}); err != nil {
-					log.Error(err)
-				}
-			}
-			if err := manager.storageDriver.SetBranchSessionDead(context.Background(), bs); err != nil {
+			if err := manager.setBranchSessionDead(ctx, bs, metrics.TransactionStatusTimeout); err != nil {
 				log.Error(err)
 			}
 		} else {
-			status, err = manager.branchRollback(bs)
+			status, err = manager.traceBranchPhaseTwo(ctx, bs, tracing.BranchTransactionRollback, manager.branchRollback)
 			if err != nil {
 				log.Error(err)
-				manager.branchSessionQueue.Add(obj)
 			}
-			if status != api.Complete {
-				manager.branchSessionQueue.Add(obj)
+			if err != nil || status != api.Complete {
+				manager.retryFailedBranchSession(bs, transactionStatus, err)
 			}
 		}
 	}
 
 	if status == api.Complete {
-		metrics.BranchTransactionTimer.WithLabelValues(manager.applicationID, bs.ResourceID, transactionStatus).Observe(
-			float64(int64(misc.CurrentTimeMillis()) - bs.BeginTime))
-		metrics.BranchTransactionCounter.WithLabelValues(manager.applicationID, bs.ResourceID, metrics.TransactionStatusActive).Desc()
-		metrics.BranchTransactionCounter.WithLabelValues(manager.applicationID, bs.ResourceID, transactionStatus).Inc()
+		manager.completeBranchSession(bs, transactionStatus)
 	}
-	return true
 }
 
-func (manager *DistributedTransactionManager) watchBranchSession() {
-	watcher := manager.storageDriver.WatchBranchSessions(context.Background(), manager.applicationID)
+// setBranchSessionDead stops retrying bs and moves it to the dead branch sessions, so that
+// it can be compensated by the operator.
+func (manager *DistributedTransactionManager) setBranchSessionDead(ctx context.Context, bs *api.BranchSession, transactionStatus string) error {
+	metrics.BranchTransactionCounter.WithLabelValues(bs.ApplicationID, bs.ResourceID, transactionStatus).Inc()
+	if manager.rollbackRetryTimeoutUnlockEnable {
+		log.Debugf("branch id: %s, lock key: %s released", bs.BranchID, bs.LockKey)
+		if _, err := manager.storageDriver.ReleaseLockKeys(ctx, bs.ResourceID, []string{bs.LockKey}); err != nil {
+			return err
+		}
+	}
+	return manager.storageDriver.SetBranchSessionDead(ctx, bs)
+}
+
+// completeBranchSession records bs finished in phase two.
+func (manager *DistributedTransactionManager) completeBranchSession(bs *api.BranchSession, transactionStatus string) {
+	manager.branchRetryQueue.forget(bs.BranchID)
+	manager.recordBranchTransactionMetric(bs, transactionStatus)
+}
+
+func (manager *DistributedTransactionManager) recordBranchTransactionMetric(bs *api.BranchSession, transactionStatus string) {
+	metrics.BranchTransactionTimer.WithLabelValues(manager.applicationID, bs.ResourceID, transactionStatus).Observe(
+		float64(int64(misc.CurrentTimeMillis()) - bs.BeginTime))
+	metrics.BranchTransactionGauge.WithLabelValues(manager.applicationID, bs.ResourceID).Dec()
+	metrics.BranchTransactionCounter.WithLabelValues(manager.applicationID, bs.ResourceID, transactionStatus).Inc()
+}
+
+// traceBranchPhaseTwo commits or rolls back a branch session in a span linked to the span
+// which committed or rolled back its global transaction, when it was done by this node.
+func (manager *DistributedTransactionManager) traceBranchPhaseTwo(ctx context.Context, bs *api.BranchSession, spanName string,
+	phaseTwo func(bs *api.BranchSession) (api.BranchSession_BranchStatus, error)) (api.BranchSession_BranchStatus, error) {
+	var link trace.SpanContext
+	if sc, ok := manager.spanContexts.Load(bs.XID); ok {
+		link = sc.(trace.SpanContext)
+	}
+	_, span := tracing.GetLinkedTraceSpan(ctx, spanName, link)
+	defer span.End()
+	span.SetAttributes(attribute.String("xid", bs.XID),
+		attribute.String("branch_id", bs.BranchID),
+		attribute.String("resource_id", bs.ResourceID))
+
+	status, err := phaseTwo(bs)
+	if err != nil {
+		tracing.RecordErrorSpan(span, err)
+	}
+	return status, err
+}
+
+// retryBranchSession retries bs after a backoff, bs is moved to the dead branch sessions once it
+// exceeded the max retry attempts.
+func (manager *DistributedTransactionManager) retryBranchSession(bs *api.BranchSession, transactionStatus string) {
+	if manager.branchRetryQueue.add(bs) {
+		metrics.BranchTransactionRetryCounter.WithLabelValues(manager.applicationID, bs.ResourceID, transactionStatus).Inc()
+		return
+	}
+	log.Warnf("branch session exceeded max retry attempts, branch id: %s, lock key: %s", bs.BranchID, bs.LockKey)
+	if err := manager.setBranchSessionDead(context.Background(), bs, metrics.TransactionStatusRetryExhausted); err != nil {
+		log.Error(err)
+	}
+}
+
+// retryFailedBranchSession retries bs failed in phase two with err, unless retrying can not fix
+// err, bs is moved to the dead branch sessions at once then.
+func (manager *DistributedTransactionManager) retryFailedBranchSession(bs *api.BranchSession, transactionStatus string, err error) {
+	var permanent *permanentError
+	if !errors.As(err, &permanent) {
+		manager.retryBranchSession(bs, transactionStatus)
+		return
+	}
+	log.Warnf("branch session failed, branch id: %s, lock key: %s, error: %v", bs.BranchID, bs.LockKey, err)
+	manager.branchRetryQueue.forget(bs.BranchID)
+	if err := manager.setBranchSessionDead(context.Background(), bs, metrics.TransactionStatusFailed); err != nil {
+		log.Error(err)
+	}
+}
+
+func (manager *DistributedTransactionManager) watchBranchSession(ctx context.Context) {
+	watcher := manager.storageDriver.WatchBranchSessions(ctx, manager.applicationID)
+	defer watcher.Stop()
 	for {
-		bs := <-watcher.ResultChan()
-		manager.branchSessionQueue.Add(bs)
+		select {
+		case <-ctx.Done():
+			return
+		case bs, ok := <-watcher.ResultChan():
+			if !ok {
+				return
+			}
+			manager.branchSessionQueue.Add(bs)
+		}
 	}
 }
 
-func (manager *DistributedTransactionManager) recordGlobalTransactionMetric(transactionName string, transactionStatus string) {
-	metrics.GlobalTransactionCounter.WithLabelValues(manager.applicationID, transactionName, metrics.TransactionStatusActive).Desc()
-	metrics.GlobalTransactionCounter.WithLabelValues(manager.applicationID, transactionName, transactionStatus).Inc()
+func (manager *DistributedTransactionManager) recordGlobalTransactionMetric(gs *api.GlobalSession, transactionStatus string) {
+	metrics.GlobalTransactionCounter.WithLabelValues(manager.applicationID, gs.TransactionName, transactionStatus).Inc()
+	if transactionStatus == metrics.TransactionStatusTimeout {
+		// the global session is still retried after timeout
+		return
+	}
+	metrics.GlobalTransactionGauge.WithLabelValues(manager.applicationID, gs.TransactionName).Dec()
+	metrics.GlobalTransactionTimer.WithLabelValues(manager.applicationID, gs.TransactionName, transactionStatus).Observe(
+		float64(int64(misc.CurrentTimeMillis()) - gs.BeginTime))
+	manager.spanContexts.Delete(gs.XID)
 }
 
 func isGlobalSessionTimeout(gs *api.GlobalSession) bool {
@@ -0,0 +1,210 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/ratelimit"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/dt/metrics"
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/resource"
+)
+
+const (
+	DefaultUndoLogRetention        = 7 * 24 * time.Hour
+	DefaultUndoLogCleanupInterval  = time.Hour
+	DefaultUndoLogCleanupBatchSize = 1000
+	DefaultUndoLogBatchesPerSecond = 10
+)
+
+// UndoLogArchiver stores undo logs before the reaper deletes them.
+type UndoLogArchiver interface {
+	Archive(ctx context.Context, resourceID string, records []*UndoLogRecord) error
+}
+
+// FileUndoLogArchiver appends undo logs as json lines to a file per data source and day,
+// the directory may be a mounted object storage bucket.
+type FileUndoLogArchiver struct {
+	Dir string
+}
+
+func (archiver *FileUndoLogArchiver) Archive(ctx context.Context, resourceID string, records []*UndoLogRecord) error {
+	if err := os.MkdirAll(archiver.Dir, 0755); err != nil {
+		return errors.WithStack(err)
+	}
+	name := filepath.Join(archiver.Dir, fmt.Sprintf("%s-%s.jsonl", resourceID, time.Now().Format("20060102")))
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	encoder := json.NewEncoder(file)
+	for _, record := range records {
+		if err = encoder.Encode(record); err != nil {
+			file.Close()
+			return errors.WithStack(err)
+		}
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(file.Close())
+}
+
+// UndoLogReaper periodically removes the undo logs older than the retention, which were
+// left behind by global transactions whose phase two did not delete them.
+type UndoLogReaper struct {
+	appid       string
+	dataSources []string
+	retention   time.Duration
+	interval    time.Duration
+	batchSize   int
+	limiter     ratelimit.Limiter
+	archiver    UndoLogArchiver
+}
+
+func NewUndoLogReaper(appid string, conf *config.UndoLogCleanup) *UndoLogReaper {
+	reaper := &UndoLogReaper{
+		appid:       appid,
+		dataSources: conf.DataSources,
+		retention:   conf.Retention,
+		interval:    conf.Interval,
+		batchSize:   conf.BatchSize,
+	}
+	if reaper.retention <= 0 {
+		reaper.retention = DefaultUndoLogRetention
+	}
+	if reaper.interval <= 0 {
+		reaper.interval = DefaultUndoLogCleanupInterval
+	}
+	if reaper.batchSize <= 0 {
+		reaper.batchSize = DefaultUndoLogCleanupBatchSize
+	}
+	batchesPerSecond := conf.BatchesPerSecond
+	if batchesPerSecond <= 0 {
+		batchesPerSecond = DefaultUndoLogBatchesPerSecond
+	}
+	reaper.limiter = ratelimit.New(batchesPerSecond)
+	if conf.ArchiveDir != "" {
+		reaper.archiver = &FileUndoLogArchiver{Dir: conf.ArchiveDir}
+	}
+	if len(reaper.dataSources) == 0 {
+		if dbpackConf := config.GetDBPackConfig(appid); dbpackConf != nil {
+			for _, dataSource := range dbpackConf.DataSources {
+				reaper.dataSources = append(reaper.dataSources, dataSource.Name)
+			}
+		}
+	}
+	return reaper
+}
+
+// Run cleans up undo logs every interval until ctx is done.
+func (reaper *UndoLogReaper) Run(ctx context.Context) {
+	ticker := time.NewTicker(reaper.interval)
+	defer ticker.Stop()
+	for {
+		reaper.Cleanup(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Cleanup removes the expired undo logs of every data source.
+func (reaper *UndoLogReaper) Cleanup(ctx context.Context) {
+	before := time.Now().Add(-reaper.retention)
+	for _, resourceID := range reaper.dataSources {
+		count, err := reaper.cleanupDataSource(ctx, resourceID, before)
+		if err != nil {
+			metrics.UndoLogCleanupCounter.WithLabelValues(reaper.appid, resourceID, metrics.UndoLogFailed).Inc()
+			log.Errorf("clean up undo log of %s failed: %v", resourceID, err)
+		}
+		if count > 0 {
+			log.Infof("%d undo log of %s created before %v cleaned up", count, resourceID, before)
+		}
+	}
+}
+
+func (reaper *UndoLogReaper) cleanupDataSource(ctx context.Context, resourceID string, before time.Time) (int64, error) {
+	db := resource.GetDBManager(reaper.appid).GetDB(resourceID)
+	if db == nil {
+		return 0, errors.Errorf("DB resource is not exist, db name: %s", resourceID)
+	}
+	var total int64
+	for {
+		select {
+		case <-ctx.Done():
+			return total, ctx.Err()
+		default:
+		}
+		reaper.limiter.Take()
+
+		var (
+			selected, deleted int64
+			err               error
+		)
+		if reaper.archiver == nil {
+			deleted, err = GetUndoLogManager().DeleteUndoLogByLogCreated(db, before, reaper.batchSize)
+			selected = deleted
+		} else {
+			selected, deleted, err = reaper.archiveBatch(ctx, db, resourceID, before)
+		}
+		if err != nil {
+			return total, err
+		}
+		metrics.UndoLogCleanupCounter.WithLabelValues(reaper.appid, resourceID, metrics.UndoLogDeleted).Add(float64(deleted))
+		total += deleted
+		if selected < int64(reaper.batchSize) {
+			return total, nil
+		}
+	}
+}
+
+// archiveBatch archives a batch of expired undo logs then deletes them, it returns the number
+// of undo logs selected and deleted.
+func (reaper *UndoLogReaper) archiveBatch(ctx context.Context, db proto.DB, resourceID string, before time.Time) (int64, int64, error) {
+	records, err := GetUndoLogManager().SelectUndoLogByLogCreated(db, before, reaper.batchSize)
+	if err != nil || len(records) == 0 {
+		return 0, 0, err
+	}
+	if err = reaper.archiver.Archive(ctx, resourceID, records); err != nil {
+		return 0, 0, err
+	}
+	metrics.UndoLogCleanupCounter.WithLabelValues(reaper.appid, resourceID, metrics.UndoLogArchived).Add(float64(len(records)))
+
+	ids := make([]int64, 0, len(records))
+	for _, record := range records {
+		ids = append(ids, record.ID)
+	}
+	deleted, err := GetUndoLogManager().DeleteUndoLogByIDs(db, ids)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int64(len(records)), deleted, nil
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dt
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/resource"
+	"github.com/cectc/dbpack/testdata"
+)
+
+func TestUndoLogReaperCleanup(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db := testdata.NewMockDB(ctrl)
+	gomock.InOrder(
+		db.EXPECT().ExecuteSqlDirectly(DeleteUndoLogByCreateSql, gomock.Any(), 2).
+			Return(&mysql.Result{AffectedRows: 2}, uint16(0), nil),
+		db.EXPECT().ExecuteSqlDirectly(DeleteUndoLogByCreateSql, gomock.Any(), 2).
+			Return(&mysql.Result{AffectedRows: 1}, uint16(0), nil),
+	)
+	manager := testdata.NewMockDBManager(ctrl)
+	manager.EXPECT().GetDB("employees").Return(db)
+	resource.SetDBManager("reaper_app", manager)
+
+	reaper := NewUndoLogReaper("reaper_app", &config.UndoLogCleanup{
+		DataSources:      []string{"employees"},
+		Retention:        time.Hour,
+		BatchSize:        2,
+		BatchesPerSecond: 1000,
+	})
+	count, err := reaper.cleanupDataSource(context.Background(), "employees", time.Now().Add(-time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), count)
+}
+
+func TestFileUndoLogArchiver(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "archive")
+	archiver := &FileUndoLogArchiver{Dir: dir}
+	records := []*UndoLogRecord{
+		{ID: 1, XID: "gs/app/1", BranchID: 10, RollbackInfo: []byte("info1"), LogStatus: 0},
+		{ID: 2, XID: "gs/app/2", BranchID: 20, RollbackInfo: []byte("info2"), LogStatus: 1},
+	}
+	assert.Nil(t, archiver.Archive(context.Background(), "employees", records[:1]))
+	assert.Nil(t, archiver.Archive(context.Background(), "employees", records[1:]))
+
+	files, err := filepath.Glob(filepath.Join(dir, "employees-*.jsonl"))
+	assert.Nil(t, err)
+	assert.Len(t, files, 1)
+	file, err := os.Open(files[0])
+	assert.Nil(t, err)
+	defer file.Close()
+
+	var archived []*UndoLogRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		record := &UndoLogRecord{}
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), record))
+		archived = append(archived, record)
+	}
+	assert.Equal(t, records, archived)
+}
@@ -96,6 +96,10 @@ func convertField(field *schema.Field) *PbField {
 		w.WriteByte(byte(constant.FieldTypeLongLong))
 		w.WriteInt64(v)
 		break
+	case uint64:
+		w.WriteByte(byte(constant.FieldTypeUint64))
+		w.WriteUint64(v)
+		break
 	case float32:
 		w.WriteByte(byte(constant.FieldTypeFloat))
 		w.WriteFloat32(v)
@@ -147,6 +151,9 @@ func convertPbField(pbField *PbField) *schema.Field {
 	case constant.FieldTypeLongLong:
 		value, _, _ := r.ReadInt64()
 		field.Value = value
+	case constant.FieldTypeUint64:
+		value, _, _ := r.ReadUint64()
+		field.Value = value
 	case constant.FieldTypeFloat:
 		value, _, _ := r.ReadFloat32()
 		field.Value = value
@@ -18,6 +18,7 @@ package undolog
 
 import (
 	"fmt"
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -60,6 +61,12 @@ func getBranchUndoLog() *BranchUndoLog {
 									Type:    constant.INTEGER,
 									Value:   int64(28),
 								},
+								{
+									Name:    "balance",
+									KeyType: schema.Null,
+									Type:    constant.BIGINT,
+									Value:   uint64(math.MaxUint64),
+								},
 								{
 									Name:    "avatar",
 									KeyType: schema.Null,
@@ -107,4 +114,7 @@ func TestJsonUndoLogParser_Decode(t *testing.T) {
 	data := ProtoBufUndoLogParser{}.Encode(branchUndoLog)
 	undoLog := ProtoBufUndoLogParser{}.Decode(data)
 	assert.Equal(t, undoLog.BranchID, int64(2000042936))
+	// BIGINT UNSIGNED values above math.MaxInt64 are kept
+	fields := undoLog.SqlUndoLogs[0].AfterImage.Rows[0].Fields
+	assert.Equal(t, uint64(math.MaxUint64), fields[3].Value)
 }
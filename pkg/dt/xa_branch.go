@@ -0,0 +1,243 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dt
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/dt/api"
+	err2 "github.com/cectc/dbpack/pkg/errors"
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/resource"
+)
+
+const (
+	// XABranchCompleted means the XA transaction of the branch was committed or rolled back
+	XABranchCompleted = "completed"
+	// XABranchFenced means the attempt found the branch completed already, by a previous attempt
+	// whose response was lost or by another node, so that it was not committed or rolled back again
+	XABranchFenced = "fenced"
+	// XABranchRetrying means the attempt failed with an error retrying may fix
+	XABranchRetrying = "retrying"
+	// XABranchFailed means the attempt failed with an error retrying can not fix, the branch
+	// session is dead
+	XABranchFailed = "failed"
+
+	// maxXABranchOutcomes is the number of the XA branch sessions whose outcome is kept
+	maxXABranchOutcomes = 1000
+)
+
+// XABranchOutcome is the outcome of the last phase two attempt of an XA branch session.
+type XABranchOutcome struct {
+	BranchID   string `json:"branch_id"`
+	XID        string `json:"xid"`
+	ResourceID string `json:"resource_id"`
+	// Commit is true if the branch is committed, false if it is rolled back
+	Commit   bool      `json:"commit"`
+	Attempts int       `json:"attempts"`
+	Outcome  string    `json:"outcome"`
+	Error    string    `json:"error,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// XABranchInspector reports how the phase two of the XA branch sessions went.
+type XABranchInspector interface {
+	// ListXABranchOutcomes returns the outcomes of the XA branch sessions recently committed or
+	// rolled back by this node, oldest first.
+	ListXABranchOutcomes() []*XABranchOutcome
+}
+
+func GetXABranchInspector(appID string) XABranchInspector {
+	if inspector, ok := managers[appID].(XABranchInspector); ok {
+		return inspector
+	}
+	return nil
+}
+
+// permanentError is a phase two error retrying can not fix.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// xaBranchOutcomes keeps the outcomes of the last maxXABranchOutcomes XA branch sessions.
+type xaBranchOutcomes struct {
+	mu       sync.Mutex
+	outcomes map[string]*XABranchOutcome
+	// order is the branch ids in the order they are first recorded
+	order []string
+}
+
+func (outcomes *xaBranchOutcomes) record(bs *api.BranchSession, commit bool, outcome string, err error) {
+	outcomes.mu.Lock()
+	defer outcomes.mu.Unlock()
+
+	if outcomes.outcomes == nil {
+		outcomes.outcomes = make(map[string]*XABranchOutcome)
+	}
+	o, ok := outcomes.outcomes[bs.BranchID]
+	if !ok {
+		o = &XABranchOutcome{BranchID: bs.BranchID, XID: bs.XID, ResourceID: bs.ResourceID}
+		outcomes.outcomes[bs.BranchID] = o
+		outcomes.order = append(outcomes.order, bs.BranchID)
+		if len(outcomes.order) > maxXABranchOutcomes {
+			delete(outcomes.outcomes, outcomes.order[0])
+			outcomes.order = outcomes.order[1:]
+		}
+	}
+	o.Commit = commit
+	o.Attempts++
+	o.Outcome = outcome
+	o.Error = ""
+	if err != nil {
+		o.Error = err.Error()
+	}
+	o.Time = time.Now()
+}
+
+func (outcomes *xaBranchOutcomes) list() []*XABranchOutcome {
+	outcomes.mu.Lock()
+	defer outcomes.mu.Unlock()
+
+	result := make([]*XABranchOutcome, 0, len(outcomes.order))
+	for _, branchID := range outcomes.order {
+		o := *outcomes.outcomes[branchID]
+		result = append(result, &o)
+	}
+	return result
+}
+
+func (manager *DistributedTransactionManager) ListXABranchOutcomes() []*XABranchOutcome {
+	return manager.xaBranchOutcomes.list()
+}
+
+// xaBranchPhaseTwo commits or rolls back the prepared XA transaction of an XA branch session.
+// The participant starts the XA transaction of a branch as XA START '<xid>', '<branch id>' once
+// the branch is registered, and reports the branch after XA PREPARE.
+//
+// An attempt is fenced before XA COMMIT or XA ROLLBACK is sent: the branch session must still be
+// stored, and the XA transaction must still be prepared on the resource, otherwise it was
+// completed already, by a previous attempt whose response was lost or by another node. A lock
+// wait timeout or a lost connection is retried, the other errors move the branch session to the
+// dead branch sessions.
+func (manager *DistributedTransactionManager) xaBranchPhaseTwo(bs *api.BranchSession, commit bool) (api.BranchSession_BranchStatus, error) {
+	status, outcome, err := manager._xaBranchPhaseTwo(bs, commit)
+	manager.xaBranchOutcomes.record(bs, commit, outcome, err)
+	return status, err
+}
+
+func (manager *DistributedTransactionManager) _xaBranchPhaseTwo(bs *api.BranchSession, commit bool) (
+	api.BranchSession_BranchStatus, string, error) {
+	db := resource.GetDBManager(manager.applicationID).GetDB(bs.ResourceID)
+	if db == nil {
+		return bs.Status, XABranchRetrying, errors.Errorf("DB resource is not exist, db name: %s", bs.ResourceID)
+	}
+	if _, err := manager.storageDriver.GetBranchSession(context.Background(), bs.BranchID); err != nil {
+		if err == err2.CouldNotFoundBranchTransaction {
+			log.Warnf("xa branch session completed already, branch id: %s", bs.BranchID)
+			return api.Complete, XABranchFenced, nil
+		}
+		return bs.Status, XABranchRetrying, err
+	}
+	prepared, err := xaPrepared(db, bs)
+	if err != nil {
+		return xaBranchError(bs, err)
+	}
+	if !prepared {
+		log.Warnf("xa transaction of branch session is not prepared anymore, branch id: %s", bs.BranchID)
+		return api.Complete, XABranchFenced, nil
+	}
+
+	statement := "XA ROLLBACK "
+	if commit {
+		statement = "XA COMMIT "
+	}
+	if _, _, err = db.QueryDirectly(statement + xaBranchXID(bs)); err != nil {
+		if sqlErr, ok := errors.Cause(err).(*err2.SQLError); ok && sqlErr.Num == constant.ERXAERNota {
+			// completed between XA RECOVER and now
+			return api.Complete, XABranchFenced, nil
+		}
+		return xaBranchError(bs, err)
+	}
+	return api.Complete, XABranchCompleted, nil
+}
+
+// xaBranchError returns the outcome of an attempt failed with err, a lock wait timeout or an error
+// of the connection is retried.
+func xaBranchError(bs *api.BranchSession, err error) (api.BranchSession_BranchStatus, string, error) {
+	if sqlErr, ok := errors.Cause(err).(*err2.SQLError); ok &&
+		sqlErr.Num != constant.ERLockWaitTimeout && !err2.IsConnErr(sqlErr) {
+		return bs.Status, XABranchFailed, &permanentError{err: err}
+	}
+	return bs.Status, XABranchRetrying, err
+}
+
+// xaPrepared reports whether the XA transaction of the branch session is listed by XA RECOVER.
+func xaPrepared(db proto.DB, bs *api.BranchSession) (bool, error) {
+	result, _, err := db.QueryDirectly("XA RECOVER")
+	if err != nil {
+		return false, err
+	}
+	rlt, ok := result.(*mysql.Result)
+	if !ok {
+		return false, nil
+	}
+	defer rlt.Release()
+	xid := bs.XID + bs.BranchID
+	for _, row := range rlt.Rows {
+		values, err := row.Decode()
+		if err != nil {
+			return false, err
+		}
+		// formatID, gtrid_length, bqual_length, data
+		if len(values) < 4 || values[3] == nil {
+			continue
+		}
+		var data string
+		switch val := values[3].Val.(type) {
+		case []byte:
+			data = string(val)
+		case string:
+			data = val
+		}
+		if data == xid && fmt.Sprint(values[1].Val) == fmt.Sprint(len(bs.XID)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// xaBranchXID returns the xid of the XA transaction of the branch session, as XA statements
+// name it.
+func xaBranchXID(bs *api.BranchSession) string {
+	quote := func(s string) string {
+		return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+	}
+	return quote(bs.XID) + "," + quote(bs.BranchID)
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dt
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/dt/api"
+	"github.com/cectc/dbpack/pkg/dt/metrics"
+	err2 "github.com/cectc/dbpack/pkg/errors"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/resource"
+	"github.com/cectc/dbpack/testdata"
+)
+
+type xaBranchStorageDriver struct {
+	deadBranchStorageDriver
+	branchSessions map[string]*api.BranchSession
+}
+
+func (driver *xaBranchStorageDriver) GetBranchSession(ctx context.Context, branchID string) (*api.BranchSession, error) {
+	if bs, ok := driver.branchSessions[branchID]; ok {
+		return bs, nil
+	}
+	return nil, err2.CouldNotFoundBranchTransaction
+}
+
+func xaRecoverResult(xids ...string) *mysql.Result {
+	fields := []*mysql.Field{{Name: "formatID"}, {Name: "gtrid_length"}, {Name: "bqual_length"}, {Name: "data"}}
+	result := &mysql.Result{Fields: fields}
+	for _, xid := range xids {
+		result.Rows = append(result.Rows, mysql.NewTextRow(fields, []*proto.Value{
+			{Val: int64(1)}, {Val: int64(len("gs/xa_app/1"))}, {Val: int64(len(xid) - len("gs/xa_app/1"))}, {Val: []byte(xid)},
+		}))
+	}
+	return result
+}
+
+func TestXABranchPhaseTwo(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db := testdata.NewMockDB(ctrl)
+	dbManager := testdata.NewMockDBManager(ctrl)
+	dbManager.EXPECT().GetDB("employees").Return(db).AnyTimes()
+	resource.SetDBManager("xa_app", dbManager)
+
+	newBranchSession := func(branchID string) *api.BranchSession {
+		return &api.BranchSession{
+			BranchID:      branchID,
+			XID:           "gs/xa_app/1",
+			ApplicationID: "xa_app",
+			ResourceID:    "employees",
+			Type:          api.XA,
+			Status:        api.PhaseTwoCommitting,
+		}
+	}
+	bs1, bs2, bs3, bs4 := newBranchSession("bs/xa_app/1"), newBranchSession("bs/xa_app/2"),
+		newBranchSession("bs/xa_app/3"), newBranchSession("bs/xa_app/4")
+	driver := &xaBranchStorageDriver{
+		deadBranchStorageDriver: deadBranchStorageDriver{deadBranchSessions: make(map[string]*api.BranchSession)},
+		branchSessions:          map[string]*api.BranchSession{bs1.BranchID: bs1, bs2.BranchID: bs2, bs3.BranchID: bs3},
+	}
+	manager := &DistributedTransactionManager{
+		applicationID:    "xa_app",
+		storageDriver:    driver,
+		branchRetryQueue: newBranchRetryQueue(config.BranchRetry{MaxAttempts: 3}),
+	}
+
+	// prepared, the lock wait timeout is retried
+	db.EXPECT().QueryDirectly("XA RECOVER").Return(xaRecoverResult("gs/xa_app/1bs/xa_app/1"), uint16(0), nil)
+	db.EXPECT().QueryDirectly("XA COMMIT 'gs/xa_app/1','bs/xa_app/1'").
+		Return(nil, uint16(0), err2.NewSQLError(constant.ERLockWaitTimeout, "HY000", "Lock wait timeout exceeded"))
+	status, err := manager.xaBranchPhaseTwo(bs1, true)
+	assert.Error(t, err)
+	assert.Equal(t, api.PhaseTwoCommitting, status)
+
+	db.EXPECT().QueryDirectly("XA RECOVER").Return(xaRecoverResult("gs/xa_app/1bs/xa_app/1"), uint16(0), nil)
+	db.EXPECT().QueryDirectly("XA COMMIT 'gs/xa_app/1','bs/xa_app/1'").Return(&mysql.Result{}, uint16(0), nil)
+	status, err = manager.xaBranchPhaseTwo(bs1, true)
+	assert.NoError(t, err)
+	assert.Equal(t, api.Complete, status)
+
+	// committed by the lost attempt, not listed by XA RECOVER anymore
+	db.EXPECT().QueryDirectly("XA RECOVER").Return(xaRecoverResult("gs/xa_app/1bs/xa_app/1"), uint16(0), nil)
+	status, err = manager.xaBranchPhaseTwo(bs2, true)
+	assert.NoError(t, err)
+	assert.Equal(t, api.Complete, status)
+
+	// not stored anymore, XA RECOVER is not even sent
+	status, err = manager.xaBranchPhaseTwo(bs4, false)
+	assert.NoError(t, err)
+	assert.Equal(t, api.Complete, status)
+
+	// the other errors are not retried
+	db.EXPECT().QueryDirectly("XA RECOVER").Return(xaRecoverResult("gs/xa_app/1bs/xa_app/3"), uint16(0), nil)
+	db.EXPECT().QueryDirectly("XA ROLLBACK 'gs/xa_app/1','bs/xa_app/3'").
+		Return(nil, uint16(0), err2.NewSQLError(constant.ERUnknownError, "HY000", "unknown error"))
+	status, err = manager.xaBranchPhaseTwo(bs3, false)
+	assert.Equal(t, api.PhaseTwoCommitting, status)
+	var permanent *permanentError
+	assert.True(t, errors.As(err, &permanent))
+	manager.retryFailedBranchSession(bs3, metrics.TransactionStatusRollbacked, err)
+	assert.Equal(t, bs3, driver.deadBranchSessions[bs3.BranchID])
+	assert.Equal(t, 0, manager.branchRetryQueue.len())
+
+	outcomes := manager.ListXABranchOutcomes()
+	assert.Len(t, outcomes, 4)
+	assert.Equal(t, 2, outcomes[0].Attempts)
+	assert.Equal(t, XABranchCompleted, outcomes[0].Outcome)
+	assert.Equal(t, XABranchFenced, outcomes[1].Outcome)
+	assert.Equal(t, XABranchFenced, outcomes[2].Outcome)
+	assert.False(t, outcomes[2].Commit)
+	assert.Equal(t, XABranchFailed, outcomes[3].Outcome)
+	assert.NotEmpty(t, outcomes[3].Error)
+}
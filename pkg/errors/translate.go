@@ -0,0 +1,119 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package errors
+
+import (
+	"context"
+	"errors"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/third_party/parser/mysql"
+	"github.com/cectc/dbpack/third_party/parser/terror"
+	"github.com/cectc/dbpack/third_party/pools"
+)
+
+// RoutingError is the error of routing a statement to the data sources, such as a sharded
+// statement without the sharding key, or a join across the shards.
+type RoutingError struct {
+	err error
+}
+
+// NewRoutingError marks err as an error routing a statement, the SQLErrors are kept as they are.
+func NewRoutingError(err error) error {
+	var sqlErr *SQLError
+	if err == nil || errors.As(err, &sqlErr) {
+		return err
+	}
+	return &RoutingError{err: err}
+}
+
+func (e *RoutingError) Error() string {
+	return e.err.Error()
+}
+
+func (e *RoutingError) Unwrap() error {
+	return e.err
+}
+
+// Cause returns the routing error, the same as the errors wrapped by github.com/pkg/errors.
+func (e *RoutingError) Cause() error {
+	return e.err
+}
+
+// ParseError is the error of parsing a statement, the parser reports most of the syntax
+// errors as plain errors.
+type ParseError struct {
+	err error
+}
+
+// NewParseError marks err as an error parsing a statement, the SQLErrors are kept as they are.
+func NewParseError(err error) error {
+	var sqlErr *SQLError
+	if err == nil || errors.As(err, &sqlErr) {
+		return err
+	}
+	return &ParseError{err: err}
+}
+
+func (e *ParseError) Error() string {
+	return e.err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.err
+}
+
+// Cause returns the parse error, the same as the errors wrapped by github.com/pkg/errors.
+func (e *ParseError) Cause() error {
+	return e.err
+}
+
+// Translate converts an error returned to a client into a SQLError, so that the client gets a
+// stable error code and SQLSTATE of the kind of the error rather than ERUnknownError.
+func Translate(err error) *SQLError {
+	var (
+		sqlErr     *SQLError
+		routingErr *RoutingError
+		syntaxErr  *ParseError
+		parseErr   *terror.Error
+	)
+	switch {
+	case errors.As(err, &sqlErr):
+		return sqlErr
+	case errors.Is(err, pools.ErrTimeout), errors.Is(err, pools.ErrCtxTimeout):
+		return NewSQLError(constant.ERPoolTimeout, constant.SSUnknownSQLState,
+			"statement timed out waiting for a backend connection")
+	case errors.Is(err, pools.ErrClosed):
+		return NewSQLError(constant.ERServerShutdown, constant.SSServerShutdown,
+			"backend connection pool is closed")
+	case errors.Is(err, context.DeadlineExceeded):
+		return NewSQLError(constant.ERQueryTimeout, constant.SSUnknownSQLState,
+			"Query execution was interrupted, maximum statement execution time exceeded")
+	case errors.Is(err, context.Canceled):
+		return NewSQLError(constant.ERQueryInterrupted, constant.SSQueryInterrupted,
+			"Query execution was interrupted")
+	case errors.As(err, &routingErr):
+		return NewSQLError(constant.ERRoutingFailed, constant.SSUnknownSQLState, "%v", routingErr.err)
+	case errors.As(err, &syntaxErr):
+		return NewSQLError(constant.ERParseError, constant.SSParseError, "%v", syntaxErr.err)
+	case errors.As(err, &parseErr):
+		// the parser errors carry the mysql error codes, their classes are not mapped to the codes
+		mysqlErr := mysql.NewErrf(uint16(parseErr.Code()), "%s", nil, parseErr.GetMsg())
+		return NewSQLError(int(mysqlErr.Code), mysqlErr.State, "%s", mysqlErr.Message)
+	}
+	return NewSQLError(constant.ERUnknownError, constant.SSUnknownSQLState, "unknown error: %v", err)
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package events keeps the recent changes of the state of the data sources, such as a data source
+// going down and rejoining its group, and of the client sessions holding their connections, such as
+// a session terminated for being idle in a transaction, so that the operators can follow them
+// through the admin api.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// maxEvents is the number of the recent events kept, the older ones are dropped
+const maxEvents = 1000
+
+// Event is a change of the state of a data source.
+type Event struct {
+	ID            int64     `json:"id"`
+	Time          time.Time `json:"time"`
+	Kind          string    `json:"kind"`
+	ApplicationID string    `json:"application_id,omitempty"`
+	Group         string    `json:"group,omitempty"`
+	DataSource    string    `json:"data_source,omitempty"`
+	Message       string    `json:"message"`
+}
+
+var (
+	mu     sync.RWMutex
+	events = make([]*Event, 0, maxEvents)
+	lastID int64
+)
+
+// Record appends event to the recent events, its id and time are set.
+func Record(event *Event) {
+	mu.Lock()
+	defer mu.Unlock()
+	lastID++
+	event.ID = lastID
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if len(events) == maxEvents {
+		copy(events, events[1:])
+		events = events[:maxEvents-1]
+	}
+	events = append(events, event)
+}
+
+// List returns the recent events whose id is greater than since, in the order they were recorded.
+func List(since int64) []*Event {
+	mu.RLock()
+	defer mu.RUnlock()
+	result := make([]*Event, 0)
+	for _, event := range events {
+		if event.ID > since {
+			result = append(result, event)
+		}
+	}
+	return result
+}
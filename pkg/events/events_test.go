@@ -0,0 +1,39 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecord(t *testing.T) {
+	last := List(0)
+	var since int64
+	if len(last) > 0 {
+		since = last[len(last)-1].ID
+	}
+	for i := 0; i < maxEvents+1; i++ {
+		Record(&Event{Kind: "test", DataSource: "employees"})
+	}
+	recorded := List(since)
+	assert.Len(t, recorded, maxEvents)
+	assert.Equal(t, since+2, recorded[0].ID)
+	assert.False(t, recorded[0].Time.IsZero())
+	assert.Len(t, List(recorded[maxEvents-2].ID), 1)
+}
@@ -0,0 +1,53 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package executor
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+// NewExecutor creates the executor of the execute mode of conf.
+func NewExecutor(conf *config.Executor) (proto.Executor, error) {
+	switch conf.Mode {
+	case config.SDB:
+		return NewSingleDBExecutor(conf)
+	case config.RWS:
+		return NewReadWriteSplittingExecutor(conf)
+	case config.SHD:
+		return NewShardingExecutor(conf)
+	default:
+		return nil, errors.Errorf("unsupported execute mode %v of executor %s", conf.Mode, conf.Name)
+	}
+}
+
+// CloseExecutor stops the monitors of the db groups of an executor which is replaced, the data
+// sources are left open.
+func CloseExecutor(executor proto.Executor) {
+	if closer, ok := executor.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+// closeGroup stops the monitors of a db group.
+func closeGroup(dbGroup proto.DBGroupExecutor) {
+	if closer, ok := dbGroup.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
@@ -0,0 +1,210 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package executor
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/atomic"
+
+	"github.com/cectc/dbpack/pkg/filter"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+var (
+	filterChainsLock sync.RWMutex
+	// filterChains maps application ids to the filter chains of their executors.
+	filterChains = make(map[string]map[string]*FilterChain)
+)
+
+// GetFilterChain returns the filter chain of an executor, nil if there is no such executor.
+func GetFilterChain(appid, executor string) *FilterChain {
+	filterChainsLock.RLock()
+	defer filterChainsLock.RUnlock()
+	return filterChains[appid][executor]
+}
+
+// FilterChain is the ordered filters of an executor, which can be changed at runtime, a statement
+// runs the filters the chain had when the statement started.
+type FilterChain struct {
+	appid string
+	// lock serializes the changes of the chain
+	lock    sync.Mutex
+	filters atomic.Value
+}
+
+// filters is a snapshot of a filter chain.
+type filters struct {
+	names       []string
+	preFilters  []proto.DBPreFilter
+	postFilters []proto.DBPostFilter
+	// closeFilters are notified when a client connection is closed
+	closeFilters []proto.DBConnectionCloseFilter
+	// decodeRows is set if one of the post filters reads the values of the result rows,
+	// the rows are forwarded to the client undecoded otherwise.
+	decodeRows bool
+}
+
+// newFilterChain creates the filter chain of an executor and registers it, the names of unknown
+// filters are skipped.
+func newFilterChain(appid, executor string, names []string) *FilterChain {
+	chain := &FilterChain{appid: appid}
+	var known []string
+	for _, name := range names {
+		if filter.GetFilter(appid, name) != nil {
+			known = append(known, name)
+		}
+	}
+	chain.filters.Store(newFilters(appid, known))
+
+	filterChainsLock.Lock()
+	defer filterChainsLock.Unlock()
+	if filterChains[appid] == nil {
+		filterChains[appid] = make(map[string]*FilterChain)
+	}
+	filterChains[appid][executor] = chain
+	return chain
+}
+
+func newFilters(appid string, names []string) *filters {
+	fs := &filters{
+		names:       names,
+		preFilters:  make([]proto.DBPreFilter, 0),
+		postFilters: make([]proto.DBPostFilter, 0),
+	}
+	for _, name := range names {
+		f := filter.GetFilter(appid, name)
+		if preFilter, ok := f.(proto.DBPreFilter); ok {
+			fs.preFilters = append(fs.preFilters, preFilter)
+		}
+		if postFilter, ok := f.(proto.DBPostFilter); ok {
+			fs.postFilters = append(fs.postFilters, postFilter)
+		}
+		if closeFilter, ok := f.(proto.DBConnectionCloseFilter); ok {
+			fs.closeFilters = append(fs.closeFilters, closeFilter)
+		}
+	}
+	fs.decodeRows = shouldDecodeResult(fs.postFilters)
+	return fs
+}
+
+func (chain *FilterChain) load() *filters {
+	return chain.filters.Load().(*filters)
+}
+
+// Names returns the names of the filters in order.
+func (chain *FilterChain) Names() []string {
+	return append([]string(nil), chain.load().names...)
+}
+
+// Set replaces the filters of the chain with the named ones in order, which enables, disables
+// and reorders filters.
+func (chain *FilterChain) Set(names []string) error {
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if seen[name] {
+			return errors.Errorf("filter %s is duplicated", name)
+		}
+		seen[name] = true
+		if err := chain.checkFilter(name); err != nil {
+			return err
+		}
+	}
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+	chain.filters.Store(newFilters(chain.appid, append([]string(nil), names...)))
+	return nil
+}
+
+// Enable inserts the named filter at position, or appends it if position is out of the chain,
+// an enabled filter is moved to position.
+func (chain *FilterChain) Enable(name string, position int) error {
+	if err := chain.checkFilter(name); err != nil {
+		return err
+	}
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+	names := removeName(chain.load().names, name)
+	if position < 0 || position > len(names) {
+		position = len(names)
+	}
+	names = append(names[:position], append([]string{name}, names[position:]...)...)
+	chain.filters.Store(newFilters(chain.appid, names))
+	return nil
+}
+
+// Disable removes the named filter from the chain.
+func (chain *FilterChain) Disable(name string) {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+	chain.filters.Store(newFilters(chain.appid, removeName(chain.load().names, name)))
+}
+
+// checkFilter checks that the named filter exists and runs on executors.
+func (chain *FilterChain) checkFilter(name string) error {
+	f := filter.GetFilter(chain.appid, name)
+	if f == nil {
+		return errors.Errorf("there is no filter %s", name)
+	}
+	_, isPreFilter := f.(proto.DBPreFilter)
+	_, isPostFilter := f.(proto.DBPostFilter)
+	if !isPreFilter && !isPostFilter {
+		return errors.Errorf("filter %s of kind %s does not run on executors", name, f.GetKind())
+	}
+	return nil
+}
+
+// removeName returns a copy of names without name.
+func removeName(names []string, name string) []string {
+	result := make([]string, 0, len(names))
+	for _, n := range names {
+		if n != name {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+func (fs *filters) doPreFilter(ctx context.Context) error {
+	for i := 0; i < len(fs.preFilters); i++ {
+		f := fs.preFilters[i]
+		err := f.PreHandle(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *filters) doPostFilter(ctx context.Context, result proto.Result, err error) error {
+	for i := 0; i < len(fs.postFilters); i++ {
+		f := fs.postFilters[i]
+		err := f.PostHandle(ctx, result, err)
+		if err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func (fs *filters) doConnectionClose(ctx context.Context) {
+	for _, f := range fs.closeFilters {
+		f.ConnectionClose(ctx)
+	}
+}
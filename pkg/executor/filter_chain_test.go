@@ -0,0 +1,102 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package executor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/filter"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+type chainFilter struct {
+	kind string
+	// calls records the filters called in order
+	calls *[]string
+}
+
+func (f *chainFilter) GetKind() string {
+	return f.kind
+}
+
+func (f *chainFilter) PreHandle(ctx context.Context) error {
+	*f.calls = append(*f.calls, f.kind)
+	return nil
+}
+
+type rowsFilter struct {
+	chainFilter
+}
+
+func (f *rowsFilter) PostHandle(ctx context.Context, result proto.Result, err error) error {
+	return err
+}
+
+func (f *rowsFilter) DecodeResultRows() bool {
+	return true
+}
+
+type connectionFilter struct{}
+
+func (f *connectionFilter) GetKind() string {
+	return "connection"
+}
+
+func (f *connectionFilter) PreHandle(ctx context.Context, conn proto.Connection) error {
+	return nil
+}
+
+func TestFilterChain(t *testing.T) {
+	var calls []string
+	filter.RegisterFilter("chain", "audit", &chainFilter{kind: "audit", calls: &calls})
+	filter.RegisterFilter("chain", "chaos", &chainFilter{kind: "chaos", calls: &calls})
+	filter.RegisterFilter("chain", "crypto", &rowsFilter{chainFilter{kind: "crypto", calls: &calls}})
+	filter.RegisterFilter("chain", "connection", &connectionFilter{})
+
+	chain := newFilterChain("chain", "sdb", []string{"audit", "missing"})
+	assert.Same(t, chain, GetFilterChain("chain", "sdb"))
+	assert.Equal(t, []string{"audit"}, chain.Names())
+	running := chain.load()
+
+	assert.NoError(t, chain.Enable("chaos", 0))
+	assert.NoError(t, chain.Enable("crypto", -1))
+	assert.Equal(t, []string{"chaos", "audit", "crypto"}, chain.Names())
+	assert.True(t, chain.load().decodeRows)
+	// enabling an enabled filter moves it
+	assert.NoError(t, chain.Enable("chaos", 2))
+	assert.Equal(t, []string{"audit", "crypto", "chaos"}, chain.Names())
+
+	assert.NoError(t, chain.load().doPreFilter(context.Background()))
+	assert.Equal(t, []string{"audit", "crypto", "chaos"}, calls)
+	// a running statement keeps the filters it started with
+	assert.NoError(t, running.doPreFilter(context.Background()))
+	assert.Equal(t, []string{"audit", "crypto", "chaos", "audit"}, calls)
+
+	chain.Disable("crypto")
+	assert.Equal(t, []string{"audit", "chaos"}, chain.Names())
+	assert.False(t, chain.load().decodeRows)
+
+	assert.NoError(t, chain.Set([]string{"chaos"}))
+	assert.Equal(t, []string{"chaos"}, chain.Names())
+	assert.ErrorContains(t, chain.Set([]string{"chaos", "chaos"}), "duplicated")
+	assert.ErrorContains(t, chain.Set([]string{"missing"}), "there is no filter missing")
+	assert.ErrorContains(t, chain.Enable("connection", 0), "does not run on executors")
+	assert.Equal(t, []string{"chaos"}, chain.Names())
+}
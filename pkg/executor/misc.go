@@ -19,8 +19,12 @@ package executor
 import (
 	"strings"
 
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/config"
 	"github.com/cectc/dbpack/pkg/mysql"
 	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/resource"
 	"github.com/cectc/dbpack/third_party/parser/ast"
 	driver "github.com/cectc/dbpack/third_party/types/parser_driver"
 )
@@ -46,6 +50,16 @@ func shouldStartTransaction(stmt *ast.SetStmt) (shouldStartTransaction bool) {
 	return
 }
 
+// shouldDecodeResult reports whether one of the post filters reads the values of the result rows.
+func shouldDecodeResult(filters []proto.DBPostFilter) bool {
+	for _, f := range filters {
+		if rowsFilter, ok := f.(proto.DBResultRowsFilter); ok && rowsFilter.DecodeResultRows() {
+			return true
+		}
+	}
+	return false
+}
+
 func decodeResult(result proto.Result) (proto.Result, error) {
 	if result != nil {
 		if mysqlResult, ok := result.(*mysql.Result); ok {
@@ -62,3 +76,17 @@ func decodeResult(result proto.Result) (proto.Result, error) {
 	}
 	return result, nil
 }
+
+// groupPassthrough reports whether the data sources of a group are proxies, either all or none of them are.
+func groupPassthrough(appid string, dataSources []*config.DataSourceRef) (bool, error) {
+	proxies := 0
+	for _, dataSource := range dataSources {
+		if resource.GetDBManager(appid).GetDB(dataSource.Name).IsPassthrough() {
+			proxies++
+		}
+	}
+	if proxies > 0 && proxies < len(dataSources) {
+		return false, errors.New("the data sources of a group must be either all passthrough or none of them")
+	}
+	return proxies > 0, nil
+}
@@ -0,0 +1,514 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package executor
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+	"github.com/cectc/dbpack/third_party/parser/format"
+	"github.com/cectc/dbpack/third_party/parser/model"
+	driver "github.com/cectc/dbpack/third_party/types/parser_driver"
+)
+
+// pinnedSessions tracks the client connections whose statements depend on the state of a single
+// backend session, such a client connection is served by a backend connection pinned to it:
+//   - a temporary table only exists in the session that created it, the connection is pinned
+//     until the temporary tables are dropped;
+//   - a user lock taken by GET_LOCK belongs to the session, the connection is pinned until the
+//     locks are released, the lock functions always run on the master;
+//   - FOUND_ROWS() reads the rows found by the previous SELECT SQL_CALC_FOUND_ROWS of the session,
+//     the connection is pinned until the next SELECT.
+//
+// The pinned connection is kept with the transactions, so that it serves every statement of the
+// client connection. LAST_INSERT_ID() and FOUND_ROWS() are answered from the last insert id and the
+// found rows the results of the client connection reported, unless its connection knows them.
+type pinnedSessions struct {
+	// map[uint32]proto.Tx
+	transactions *sync.Map
+	// map[uint32]*pinnedSession
+	sessions *sync.Map
+	// map[uint32]uint64
+	lastInsertIDs *sync.Map
+	// map[uint32]uint64
+	foundRows *sync.Map
+}
+
+type pinnedSession struct {
+	// mu guards the state changed by the client connection against the reads of PinnedConnections
+	mu sync.Mutex
+	// tables maps the names of the temporary tables to the identifiers to drop them with
+	tables map[string]string
+	// locks are the names of the user locks taken by GET_LOCK, the empty name stands for the
+	// locks named by expressions, which are only known to be released by RELEASE_ALL_LOCKS()
+	locks map[string]struct{}
+	// foundRows is true after a SELECT SQL_CALC_FOUND_ROWS
+	foundRows bool
+	// transaction is true while a transaction is open on the pinned connection
+	transaction bool
+}
+
+func newPinnedSessions(transactions *sync.Map) *pinnedSessions {
+	return &pinnedSessions{
+		transactions:  transactions,
+		sessions:      &sync.Map{},
+		lastInsertIDs: &sync.Map{},
+		foundRows:     &sync.Map{},
+	}
+}
+
+func (session *pinnedSession) idle() bool {
+	return len(session.tables) == 0 && len(session.locks) == 0 && !session.foundRows
+}
+
+// update records the session state the statement changed.
+func (session *pinnedSession) update(stmt ast.StmtNode) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	switch st := stmt.(type) {
+	case *ast.CreateTableStmt:
+		if st.TemporaryKeyword != ast.TemporaryNone {
+			session.tables[st.Table.Name.O] = tableIdentifier(st.Table)
+		}
+	case *ast.DropTableStmt:
+		for _, table := range st.Tables {
+			delete(session.tables, table.Name.O)
+		}
+	case *ast.SelectStmt:
+		session.foundRows = st.SelectStmtOpts != nil && st.SelectStmtOpts.CalcFoundRows
+	}
+	for _, call := range sessionFunctionCalls(stmt) {
+		switch call.FnName.L {
+		case ast.GetLock:
+			name, _ := lockName(call)
+			session.locks[name] = struct{}{}
+		case ast.ReleaseLock:
+			if name, ok := lockName(call); ok {
+				delete(session.locks, name)
+			}
+		case ast.ReleaseAllLocks:
+			session.locks = make(map[string]struct{})
+		}
+	}
+}
+
+// reasons returns the reasons the connection of the session is pinned for.
+func (session *pinnedSession) reasons() []string {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	var reasons []string
+	if session.transaction {
+		reasons = append(reasons, proto.PinReasonTransaction)
+	}
+	if len(session.tables) != 0 {
+		reasons = append(reasons, proto.PinReasonTemporaryTable)
+	}
+	if len(session.locks) != 0 {
+		reasons = append(reasons, proto.PinReasonUserLock)
+	}
+	if session.foundRows {
+		reasons = append(reasons, proto.PinReasonFoundRows)
+	}
+	return reasons
+}
+
+func (session *pinnedSession) setTransaction(transaction bool) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.transaction = transaction
+}
+
+func (t *pinnedSessions) session(connectionID uint32) (*pinnedSession, bool) {
+	si, ok := t.sessions.Load(connectionID)
+	if !ok {
+		return nil, false
+	}
+	if _, ok := t.transactions.Load(connectionID); !ok {
+		// the connection was released by an XA transaction
+		t.sessions.Delete(connectionID)
+		return nil, false
+	}
+	return si.(*pinnedSession), true
+}
+
+// inTransaction reports whether the client connection is in a transaction, a client connection
+// which is only pinned is not.
+func (t *pinnedSessions) inTransaction(connectionID uint32) bool {
+	if _, ok := t.transactions.Load(connectionID); !ok {
+		return false
+	}
+	session, ok := t.session(connectionID)
+	return !ok || session.transaction
+}
+
+// pinned reports whether the client connection is pinned for the state of its session.
+func (t *pinnedSessions) pinned(connectionID uint32) bool {
+	_, ok := t.session(connectionID)
+	return ok
+}
+
+// begin starts a transaction on the pinned connection of the client connection.
+func (t *pinnedSessions) begin(ctx context.Context, connectionID uint32) (proto.Result, error) {
+	session, _ := t.session(connectionID)
+	txi, _ := t.transactions.Load(connectionID)
+	result, _, err := txi.(proto.Tx).Query(ctx, "START TRANSACTION")
+	if err != nil {
+		return nil, err
+	}
+	session.setTransaction(true)
+	return result, nil
+}
+
+// end commits or rolls back the transaction on the pinned connection, which stays pinned.
+func (t *pinnedSessions) end(ctx context.Context, connectionID uint32, sql string) (proto.Result, error) {
+	session, _ := t.session(connectionID)
+	txi, _ := t.transactions.Load(connectionID)
+	session.setTransaction(false)
+	result, _, err := txi.(proto.Tx).Query(ctx, sql)
+	return result, err
+}
+
+// connections returns the backend connection held for the client connection, if any, with the
+// reasons it is held for. A prepared statement never holds one, it is prepared on the connection
+// executing it and closed once executed.
+func (t *pinnedSessions) connections(connectionID uint32) []*proto.PinnedConnection {
+	txi, ok := t.transactions.Load(connectionID)
+	if !ok {
+		return nil
+	}
+	tx := txi.(proto.Tx)
+	var reasons []string
+	if si, ok := t.sessions.Load(connectionID); ok {
+		reasons = si.(*pinnedSession).reasons()
+	} else if pinned, ok := tx.(proto.PinnedTx); ok && pinned.XA() {
+		reasons = []string{proto.PinReasonXATransaction}
+	} else {
+		reasons = []string{proto.PinReasonTransaction}
+	}
+	return []*proto.PinnedConnection{pinnedConnection(tx, reasons)}
+}
+
+// pinnedConnection describes the connection of tx, held for reasons.
+func pinnedConnection(tx proto.Tx, reasons []string) *proto.PinnedConnection {
+	connection := &proto.PinnedConnection{Reasons: reasons}
+	if pinned, ok := tx.(proto.PinnedTx); ok {
+		connection.DataSource = pinned.DataSourceName()
+		connection.ThreadID = pinned.ThreadID()
+		connection.Since = pinned.PinnedSince()
+	}
+	return connection
+}
+
+// shouldPin reports whether the statement depends on or changes the state of the session
+// the client connection is pinned for.
+func (t *pinnedSessions) shouldPin(connectionID uint32, stmt ast.StmtNode) bool {
+	session, pinned := t.session(connectionID)
+	switch st := stmt.(type) {
+	case *ast.CreateTableStmt:
+		if st.TemporaryKeyword != ast.TemporaryNone {
+			return true
+		}
+	case *ast.DropTableStmt:
+		if !st.IsView && pinned && len(session.tables) != 0 {
+			return true
+		}
+	case *ast.SelectStmt:
+		if (st.SelectStmtOpts != nil && st.SelectStmtOpts.CalcFoundRows) || (pinned && session.foundRows) {
+			return true
+		}
+	}
+	for _, call := range sessionFunctionCalls(stmt) {
+		if call.FnName.L != ast.LastInsertId && call.FnName.L != ast.FoundRows {
+			return true
+		}
+	}
+	return false
+}
+
+// execute runs a statement shouldPin reports on the connection the client connection is pinned
+// to, pin pins one when there is none. The connection goes back to the pool once the session
+// state is gone outside a transaction.
+func (t *pinnedSessions) execute(ctx context.Context, connectionID uint32, stmt ast.StmtNode,
+	pin func(ctx context.Context) (proto.Tx, error),
+	run func(ctx context.Context, tx proto.Tx) (proto.Result, uint16, error)) (proto.Result, uint16, error) {
+	var (
+		tx  proto.Tx
+		err error
+	)
+	txi, inTransaction := t.transactions.Load(connectionID)
+	if inTransaction {
+		tx = txi.(proto.Tx)
+	} else if tx, err = pin(ctx); err != nil {
+		return nil, 0, err
+	}
+
+	result, warns, err := run(ctx, tx)
+	session, pinned := t.session(connectionID)
+	if !pinned {
+		session = &pinnedSession{
+			tables:      make(map[string]string),
+			locks:       make(map[string]struct{}),
+			transaction: inTransaction,
+		}
+	}
+	if err == nil {
+		session.update(stmt)
+	}
+	switch {
+	case !session.idle():
+		t.sessions.Store(connectionID, session)
+		t.transactions.Store(connectionID, tx)
+	case session.transaction:
+		t.sessions.Delete(connectionID)
+	default:
+		t.sessions.Delete(connectionID)
+		t.transactions.Delete(connectionID)
+		if _, err := tx.Commit(ctx); err != nil {
+			log.Error(err)
+		}
+	}
+	return result, warns, err
+}
+
+// close releases the session state left by a closing client connection, so that it does not
+// outlive the client connection on the pooled connection.
+func (t *pinnedSessions) close(connectionID uint32) {
+	t.lastInsertIDs.Delete(connectionID)
+	t.foundRows.Delete(connectionID)
+	si, ok := t.sessions.LoadAndDelete(connectionID)
+	if !ok {
+		return
+	}
+	session := si.(*pinnedSession)
+	txi, _ := t.transactions.Load(connectionID)
+	tx := txi.(proto.Tx)
+	if len(session.tables) != 0 {
+		tables := make([]string, 0, len(session.tables))
+		for _, table := range session.tables {
+			tables = append(tables, table)
+		}
+		sql := fmt.Sprintf("DROP TEMPORARY TABLE IF EXISTS %s", strings.Join(tables, ", "))
+		if _, _, err := tx.QueryDirectly(sql); err != nil {
+			log.Error(err)
+		}
+	}
+	if len(session.locks) != 0 {
+		if _, _, err := tx.QueryDirectly("DO RELEASE_ALL_LOCKS()"); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// record remembers the last insert id the result of the statement reported, and the rows a
+// SELECT found, as FOUND_ROWS() counts them.
+func (t *pinnedSessions) record(ctx context.Context, stmt ast.StmtNode, result proto.Result) {
+	if result == nil {
+		return
+	}
+	connectionID := proto.ConnectionID(ctx)
+	if id, err := result.LastInsertId(); err == nil && id != 0 {
+		t.lastInsertIDs.Store(connectionID, id)
+	}
+	if _, ok := stmt.(*ast.SelectStmt); ok {
+		if mysqlResult, ok := result.(*mysql.Result); ok {
+			t.foundRows.Store(connectionID, uint64(len(mysqlResult.Rows)))
+		}
+	}
+}
+
+// sessionValue returns the value of a LAST_INSERT_ID() or FOUND_ROWS() call from the state the
+// client connection recorded, unless the connection serving the client connection answers it.
+func (t *pinnedSessions) sessionValue(connectionID uint32, expr ast.ExprNode) (uint64, bool) {
+	call, ok := expr.(*ast.FuncCallExpr)
+	if !ok || len(call.Args) != 0 {
+		return 0, false
+	}
+	var values *sync.Map
+	switch call.FnName.L {
+	case ast.LastInsertId:
+		// the inserts of a transaction are on its connection
+		if _, ok := t.transactions.Load(connectionID); ok {
+			return 0, false
+		}
+		values = t.lastInsertIDs
+	case ast.FoundRows:
+		// the rows found by SELECT SQL_CALC_FOUND_ROWS are only known to its connection
+		if session, ok := t.session(connectionID); ok && session.foundRows {
+			return 0, false
+		}
+		values = t.foundRows
+	default:
+		return 0, false
+	}
+	if vi, ok := values.Load(connectionID); ok {
+		return vi.(uint64), true
+	}
+	return 0, true
+}
+
+// answer answers SELECT LAST_INSERT_ID() and SELECT FOUND_ROWS() from the state the client
+// connection recorded, it returns nil for the other statements.
+func (t *pinnedSessions) answer(connectionID uint32, stmt ast.StmtNode, binary bool) *mysql.Result {
+	st, ok := stmt.(*ast.SelectStmt)
+	if !ok || st.From != nil || st.Where != nil || st.Limit != nil || st.Fields == nil || len(st.Fields.Fields) == 0 {
+		return nil
+	}
+	fields := make([]*mysql.Field, 0, len(st.Fields.Fields))
+	values := make([]*proto.Value, 0, len(st.Fields.Fields))
+	for _, field := range st.Fields.Fields {
+		if field.Expr == nil {
+			return nil
+		}
+		value, ok := t.sessionValue(connectionID, field.Expr)
+		if !ok {
+			return nil
+		}
+		fields = append(fields, &mysql.Field{
+			Name:      columnName(field),
+			FieldType: constant.FieldTypeUint64,
+			CharSet:   constant.CharacterSetBinary,
+			Flags:     constant.NotNullFlag | constant.BinaryFlag | constant.UnsignedFlag,
+		})
+		raw := strconv.AppendUint(nil, value, 10)
+		v := &proto.Value{Typ: constant.FieldTypeUint64, Len: len(raw), Val: raw, Raw: raw}
+		if binary {
+			v.Val = value
+		}
+		values = append(values, v)
+	}
+	result := &mysql.Result{Fields: fields, AffectedRows: 1}
+	if binary {
+		result.Rows = []proto.Row{mysql.NewBinaryRow(fields, values)}
+	} else {
+		result.Rows = []proto.Row{mysql.NewTextRow(fields, values)}
+	}
+	return result
+}
+
+// rewrite replaces the LAST_INSERT_ID() and FOUND_ROWS() calls of a statement which the connection
+// serving the client connection could not answer with their values.
+func (t *pinnedSessions) rewrite(connectionID uint32, stmt ast.StmtNode) {
+	stmt.Accept(&sessionValueRewriter{sessions: t, connectionID: connectionID})
+}
+
+type sessionValueRewriter struct {
+	sessions     *pinnedSessions
+	connectionID uint32
+}
+
+func (v *sessionValueRewriter) Enter(n ast.Node) (ast.Node, bool) {
+	if field, ok := n.(*ast.SelectField); ok && field.AsName.L == "" && field.Expr != nil {
+		if _, ok := v.sessions.sessionValue(v.connectionID, field.Expr); ok {
+			// keep the column name of the client
+			field.AsName = model.NewCIStr(columnName(field))
+		}
+	}
+	return n, false
+}
+
+func (v *sessionValueRewriter) Leave(n ast.Node) (ast.Node, bool) {
+	if expr, ok := n.(ast.ExprNode); ok {
+		if value, ok := v.sessions.sessionValue(v.connectionID, expr); ok {
+			return ast.NewValueExpr(value, "", ""), true
+		}
+	}
+	return n, true
+}
+
+// usesLastInsertID reports whether the statement calls LAST_INSERT_ID().
+func usesLastInsertID(stmt ast.StmtNode) bool {
+	for _, call := range sessionFunctionCalls(stmt) {
+		if call.FnName.L == ast.LastInsertId {
+			return true
+		}
+	}
+	return false
+}
+
+// columnName returns the name of the column of a select field as MySQL names it.
+func columnName(field *ast.SelectField) string {
+	if field.AsName.O != "" {
+		return field.AsName.O
+	}
+	if text := field.Text(); text != "" {
+		return text
+	}
+	var sb strings.Builder
+	if err := field.Expr.Restore(format.NewRestoreCtx(format.DefaultRestoreFlags, &sb)); err != nil {
+		return ""
+	}
+	return sb.String()
+}
+
+type sessionFunctionVisitor struct {
+	calls []*ast.FuncCallExpr
+}
+
+func (v *sessionFunctionVisitor) Enter(n ast.Node) (ast.Node, bool) {
+	if call, ok := n.(*ast.FuncCallExpr); ok {
+		switch call.FnName.L {
+		case ast.GetLock, ast.ReleaseLock, ast.ReleaseAllLocks, ast.IsFreeLock, ast.IsUsedLock,
+			ast.FoundRows, ast.LastInsertId:
+			v.calls = append(v.calls, call)
+		}
+	}
+	return n, false
+}
+
+func (v *sessionFunctionVisitor) Leave(n ast.Node) (ast.Node, bool) {
+	return n, true
+}
+
+// sessionFunctionCalls returns the calls of the functions reading or changing the state of the
+// session in the statement.
+func sessionFunctionCalls(stmt ast.StmtNode) []*ast.FuncCallExpr {
+	v := &sessionFunctionVisitor{}
+	stmt.Accept(v)
+	return v.calls
+}
+
+// lockName returns the name of the user lock a lock function call names by a literal, lock
+// names are not case-sensitive.
+func lockName(call *ast.FuncCallExpr) (string, bool) {
+	if len(call.Args) == 0 {
+		return "", false
+	}
+	if v, ok := call.Args[0].(*driver.ValueExpr); ok {
+		if name, ok := v.GetValue().(string); ok {
+			return strings.ToLower(name), true
+		}
+	}
+	return "", false
+}
+
+func tableIdentifier(table *ast.TableName) string {
+	quote := func(name string) string {
+		return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+	}
+	if table.Schema.O != "" {
+		return quote(table.Schema.O) + "." + quote(table.Name.O)
+	}
+	return quote(table.Name.O)
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package executor
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/third_party/parser"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+)
+
+func TestPinnedSessionLocks(t *testing.T) {
+	p := parser.New()
+	session := &pinnedSession{tables: make(map[string]string), locks: make(map[string]struct{})}
+	update := func(sql string) {
+		stmt, err := p.ParseOneStmt(sql, "", "")
+		assert.NoError(t, err)
+		session.update(stmt)
+	}
+
+	update("select get_lock('Dbpack', 10), get_lock(@name, 10)")
+	assert.Equal(t, map[string]struct{}{"dbpack": {}, "": {}}, session.locks)
+	update("select release_lock('DBPACK')")
+	// the lock named by an expression is only known to be released by RELEASE_ALL_LOCKS()
+	update("select release_lock(@name)")
+	assert.False(t, session.idle())
+	update("do release_all_locks()")
+	assert.True(t, session.idle())
+
+	update("select sql_calc_found_rows id from employee limit 1")
+	assert.False(t, session.idle())
+	update("select found_rows()")
+	assert.True(t, session.idle())
+}
+
+func TestPinnedSessionDeferredRecord(t *testing.T) {
+	stmt, err := parser.New().ParseOneStmt("select found_rows()", "", "")
+	assert.NoError(t, err)
+	call := stmt.(*ast.SelectStmt).Fields.Fields[0].Expr
+	executor := &ReadWriteSplittingExecutor{pinnedSessions: newPinnedSessions(&sync.Map{})}
+	ctx := proto.WithConnectionID(context.Background(), 1)
+	read := &ast.SelectStmt{}
+
+	// the pipelined reads complete out of order, their caller records them in statement order
+	first, second := proto.WithDeferredRecord(ctx), proto.WithDeferredRecord(ctx)
+	executor.recordSession(second, read, &mysql.Result{Rows: make([]proto.Row, 2)})
+	executor.recordSession(first, read, &mysql.Result{Rows: make([]proto.Row, 1)})
+	value, ok := executor.pinnedSessions.sessionValue(1, call)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(0), value)
+	proto.RecordDeferred(first)
+	proto.RecordDeferred(second)
+	value, _ = executor.pinnedSessions.sessionValue(1, call)
+	assert.Equal(t, uint64(2), value)
+
+	executor.recordSession(ctx, read, &mysql.Result{Rows: make([]proto.Row, 3)})
+	value, _ = executor.pinnedSessions.sessionValue(1, call)
+	assert.Equal(t, uint64(3), value)
+}
+
+type pinnedTestTx struct {
+	proto.Tx
+	since time.Time
+	xa    bool
+}
+
+func (tx *pinnedTestTx) DataSourceName() string {
+	return "employees"
+}
+
+func (tx *pinnedTestTx) ThreadID() uint32 {
+	return 42
+}
+
+func (tx *pinnedTestTx) PinnedSince() time.Time {
+	return tx.since
+}
+
+func (tx *pinnedTestTx) XA() bool {
+	return tx.xa
+}
+
+func TestPinnedSessionConnections(t *testing.T) {
+	transactions := &sync.Map{}
+	sessions := newPinnedSessions(transactions)
+	since := time.Now().Add(-time.Minute)
+	assert.Nil(t, sessions.connections(1))
+
+	transactions.Store(uint32(1), &pinnedTestTx{since: since})
+	assert.Equal(t, []*proto.PinnedConnection{
+		{DataSource: "employees", ThreadID: 42, Reasons: []string{proto.PinReasonTransaction}, Since: since},
+	}, sessions.connections(1))
+
+	transactions.Store(uint32(2), &pinnedTestTx{since: since, xa: true})
+	assert.Equal(t, []string{proto.PinReasonXATransaction}, sessions.connections(2)[0].Reasons)
+
+	sessions.sessions.Store(uint32(1), &pinnedSession{
+		tables:      map[string]string{"tmp": "`tmp`"},
+		locks:       map[string]struct{}{"dbpack": {}},
+		transaction: true,
+	})
+	assert.Equal(t, []string{proto.PinReasonTransaction, proto.PinReasonTemporaryTable, proto.PinReasonUserLock},
+		sessions.connections(1)[0].Reasons)
+}
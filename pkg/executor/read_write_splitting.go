@@ -26,7 +26,6 @@ import (
 
 	"github.com/cectc/dbpack/pkg/config"
 	"github.com/cectc/dbpack/pkg/constant"
-	"github.com/cectc/dbpack/pkg/filter"
 	"github.com/cectc/dbpack/pkg/group"
 	"github.com/cectc/dbpack/pkg/log"
 	"github.com/cectc/dbpack/pkg/misc"
@@ -41,12 +40,16 @@ type ReadWriteSplittingExecutor struct {
 	conf *config.Executor
 
 	dbGroup proto.DBGroupExecutor
+	// passthrough sends the statements as the client sent them, the data sources are proxies
+	passthrough bool
+	// routes override the read or write classification of the statements
+	routes *statementRoutes
 
-	PreFilters  []proto.DBPreFilter
-	PostFilters []proto.DBPostFilter
+	filters *FilterChain
 
 	// map[uint32]proto.Tx
 	localTransactionMap *sync.Map
+	pinnedSessions      *pinnedSessions
 }
 
 func NewReadWriteSplittingExecutor(conf *config.Executor) (proto.Executor, error) {
@@ -66,43 +69,59 @@ func NewReadWriteSplittingExecutor(conf *config.Executor) (proto.Executor, error
 		return nil, err
 	}
 
-	dbGroup, err = group.NewDBGroup(conf.AppID, "read-write-splitting", rwConfig.LoadBalanceAlgorithm, rwConfig.DataSources)
+	var opts []group.Option
+	if rwConfig.Hedging != nil {
+		opts = append(opts, group.WithHedging(rwConfig.Hedging))
+	}
+	if rwConfig.Galera != nil {
+		opts = append(opts, group.WithGalera(rwConfig.Galera, rwConfig.DataSources))
+	}
+	if rwConfig.Aurora != nil {
+		opts = append(opts, group.WithAurora(conf.AppID, rwConfig.Aurora))
+	}
+	if rwConfig.Rejoin != nil {
+		opts = append(opts, group.WithRejoin(conf.AppID, rwConfig.Rejoin))
+	}
+	dbGroup, err = group.NewDBGroup(conf.AppID, "read-write-splitting", rwConfig.LoadBalanceAlgorithm, rwConfig.DataSources, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	passthrough, err := groupPassthrough(conf.AppID, rwConfig.DataSources)
+	if err != nil {
+		closeGroup(dbGroup)
+		return nil, err
+	}
+	routes, err := newStatementRoutes(rwConfig.StatementRoutes)
 	if err != nil {
+		closeGroup(dbGroup)
 		return nil, err
 	}
 
 	executor := &ReadWriteSplittingExecutor{
 		conf:                conf,
 		dbGroup:             dbGroup,
-		PreFilters:          make([]proto.DBPreFilter, 0),
-		PostFilters:         make([]proto.DBPostFilter, 0),
+		passthrough:         passthrough,
+		routes:              routes,
+		filters:             newFilterChain(conf.AppID, conf.Name, conf.Filters),
 		localTransactionMap: &sync.Map{},
 	}
-
-	for i := 0; i < len(conf.Filters); i++ {
-		filterName := conf.Filters[i]
-		f := filter.GetFilter(conf.AppID, filterName)
-		if f != nil {
-			preFilter, ok := f.(proto.DBPreFilter)
-			if ok {
-				executor.PreFilters = append(executor.PreFilters, preFilter)
-			}
-			postFilter, ok := f.(proto.DBPostFilter)
-			if ok {
-				executor.PostFilters = append(executor.PostFilters, postFilter)
-			}
-		}
-	}
+	executor.pinnedSessions = newPinnedSessions(executor.localTransactionMap)
 
 	return executor, nil
 }
 
+// Close stops the monitors of the db group.
+func (executor *ReadWriteSplittingExecutor) Close() {
+	closeGroup(executor.dbGroup)
+}
+
 func (executor *ReadWriteSplittingExecutor) GetPreFilters() []proto.DBPreFilter {
-	return executor.PreFilters
+	return executor.filters.load().preFilters
 }
 
 func (executor *ReadWriteSplittingExecutor) GetPostFilters() []proto.DBPostFilter {
-	return executor.PostFilters
+	return executor.filters.load().postFilters
 }
 
 func (executor *ReadWriteSplittingExecutor) ExecuteMode() config.ExecuteMode {
@@ -115,14 +134,18 @@ func (executor *ReadWriteSplittingExecutor) ProcessDistributedTransaction() bool
 
 func (executor *ReadWriteSplittingExecutor) InLocalTransaction(ctx context.Context) bool {
 	connectionID := proto.ConnectionID(ctx)
-	_, ok := executor.localTransactionMap.Load(connectionID)
-	return ok
+	return executor.pinnedSessions.inTransaction(connectionID)
 }
 
 func (executor *ReadWriteSplittingExecutor) InGlobalTransaction(ctx context.Context) bool {
 	return false
 }
 
+// PinnedConnections returns the backend connection held for the client connection.
+func (executor *ReadWriteSplittingExecutor) PinnedConnections(connectionID uint32) []*proto.PinnedConnection {
+	return executor.pinnedSessions.connections(connectionID)
+}
+
 func (executor *ReadWriteSplittingExecutor) ExecuteUseDB(ctx context.Context, db string) error {
 	return errors.New("unimplemented COM_INIT_DB in read write splitting mode")
 }
@@ -140,14 +163,18 @@ func (executor *ReadWriteSplittingExecutor) ExecutorComQuery(
 	spanCtx, span := tracing.GetTraceSpan(ctx, tracing.RWSComQuery)
 	defer span.End()
 
-	if err = executor.doPreFilter(spanCtx); err != nil {
+	filters := executor.filters.load()
+	if err = filters.doPreFilter(spanCtx); err != nil {
 		return nil, 0, err
 	}
 	defer func() {
 		if err == nil {
+			executor.recordSession(ctx, proto.QueryStmt(ctx), result)
+		}
+		if err == nil && filters.decodeRows {
 			result, err = decodeResult(result)
 		}
-		err = executor.doPostFilter(spanCtx, result, err)
+		err = filters.doPostFilter(spanCtx, result, err)
 		if err != nil {
 			span.RecordError(err)
 		}
@@ -160,16 +187,34 @@ func (executor *ReadWriteSplittingExecutor) ExecutorComQuery(
 
 	connectionID := proto.ConnectionID(spanCtx)
 	queryStmt := proto.QueryStmt(spanCtx)
+	if answer := executor.pinnedSessions.answer(connectionID, queryStmt, false); answer != nil {
+		return answer, 0, nil
+	}
+	executor.pinnedSessions.rewrite(connectionID, queryStmt)
 	if err := queryStmt.Restore(format.NewRestoreCtx(constant.DBPackRestoreFormat, &sb)); err != nil {
 		return nil, 0, err
 	}
 	newSql := sb.String()
 	spanCtx = proto.WithSqlText(spanCtx, newSql)
+	if executor.passthrough {
+		newSql = sqlText
+	}
 
 	log.Debugf("connectionID: %d, query: %s", connectionID, newSql)
+	if executor.pinnedSessions.shouldPin(connectionID, queryStmt) {
+		// the session state lives on the master, which serves the connection as long as it is needed
+		return executor.pinnedSessions.execute(proto.WithMaster(spanCtx), connectionID, queryStmt, executor.dbGroup.Pin,
+			func(ctx context.Context, tx proto.Tx) (proto.Result, uint16, error) {
+				return tx.Query(ctx, newSql)
+			})
+	}
 	switch stmt := queryStmt.(type) {
 	case *ast.SetStmt:
 		if shouldStartTransaction(stmt) {
+			if executor.pinnedSessions.pinned(connectionID) {
+				result, err = executor.pinnedSessions.begin(spanCtx, connectionID)
+				return result, 0, err
+			}
 			// TODO add metrics
 			tx, result, err = executor.dbGroup.Begin(spanCtx)
 			if err != nil {
@@ -187,6 +232,10 @@ func (executor *ReadWriteSplittingExecutor) ExecutorComQuery(
 			return executor.dbGroup.QueryAll(ctx, sqlText)
 		}
 	case *ast.BeginStmt:
+		if executor.pinnedSessions.pinned(connectionID) {
+			result, err = executor.pinnedSessions.begin(spanCtx, connectionID)
+			return result, 0, err
+		}
 		// TODO add metrics
 		tx, result, err = executor.dbGroup.Begin(spanCtx)
 		if err != nil {
@@ -199,6 +248,10 @@ func (executor *ReadWriteSplittingExecutor) ExecutorComQuery(
 		if !ok {
 			return nil, 0, errors.New("there is no transaction")
 		}
+		if executor.pinnedSessions.pinned(connectionID) {
+			result, err = executor.pinnedSessions.end(spanCtx, connectionID, "COMMIT")
+			return result, 0, err
+		}
 		defer executor.localTransactionMap.Delete(connectionID)
 		tx = txi.(proto.Tx)
 		// TODO add metrics
@@ -212,6 +265,10 @@ func (executor *ReadWriteSplittingExecutor) ExecutorComQuery(
 			return nil, 0, errors.New("there is no transaction")
 		}
 		if stmt.SavepointName == "" {
+			if executor.pinnedSessions.pinned(connectionID) {
+				result, err = executor.pinnedSessions.end(spanCtx, connectionID, "ROLLBACK")
+				return result, 0, err
+			}
 			defer executor.localTransactionMap.Delete(connectionID)
 		}
 		tx = txi.(proto.Tx)
@@ -231,6 +288,9 @@ func (executor *ReadWriteSplittingExecutor) ExecutorComQuery(
 		}
 		return result, 0, err
 	case *ast.XAStartStmt:
+		if executor.pinnedSessions.pinned(connectionID) {
+			return nil, 0, errors.New("can not start an XA transaction on a connection pinned for its session state")
+		}
 		tx, result, err = executor.dbGroup.XAStart(spanCtx, sqlText)
 		if err != nil {
 			return nil, 0, err
@@ -264,6 +324,11 @@ func (executor *ReadWriteSplittingExecutor) ExecutorComQuery(
 			tx = txi.(proto.Tx)
 			return tx.Query(spanCtx, newSql)
 		}
+		if misc.IsLockingRead(stmt) || executor.routes.route(sqlText, stmt) == routeWrite {
+			// locking reads must see and lock the latest rows, so they never go to a slave, nor do the
+			// reads declared writes
+			return executor.dbGroup.Query(proto.WithMaster(spanCtx), newSql)
+		}
 		withSlaveCtx := proto.WithSlave(spanCtx)
 		if has, dsName := misc.HasUseDBHint(stmt.TableHints); has {
 			protoDB := resource.GetDBManager(executor.conf.AppID).GetDB(dsName)
@@ -275,6 +340,18 @@ func (executor *ReadWriteSplittingExecutor) ExecutorComQuery(
 			}
 		}
 		return executor.dbGroup.Query(withSlaveCtx, newSql)
+	case *ast.CallStmt:
+		txi, ok := executor.localTransactionMap.Load(connectionID)
+		if ok {
+			// in local transaction
+			tx = txi.(proto.Tx)
+			return tx.Query(spanCtx, newSql)
+		}
+		// a procedure may write, so it is sent to a slave only if it is declared a read
+		if executor.routes.route(sqlText, stmt) == routeRead {
+			return executor.dbGroup.Query(proto.WithSlave(spanCtx), newSql)
+		}
+		return executor.dbGroup.Query(proto.WithMaster(spanCtx), newSql)
 	default:
 		txi, ok := executor.localTransactionMap.Load(connectionID)
 		if ok {
@@ -282,24 +359,106 @@ func (executor *ReadWriteSplittingExecutor) ExecutorComQuery(
 			tx = txi.(proto.Tx)
 			return tx.Query(spanCtx, newSql)
 		}
+		if executor.routes.route(sqlText, stmt) == routeWrite {
+			return executor.dbGroup.Query(proto.WithMaster(spanCtx), newSql)
+		}
 		withSlaveCtx := proto.WithSlave(spanCtx)
 		return executor.dbGroup.Query(withSlaveCtx, newSql)
 	}
 }
 
+// ExecutorPassthrough sends a statement the proxy could not parse to the master as is,
+// bypassing the filters since they rely on the parsed statement.
+func (executor *ReadWriteSplittingExecutor) ExecutorPassthrough(
+	ctx context.Context, sql string) (result proto.Result, warns uint16, err error) {
+	connectionID := proto.ConnectionID(ctx)
+	log.Debugf("connectionID: %d, passthrough: %s", connectionID, sql)
+	txi, ok := executor.localTransactionMap.Load(connectionID)
+	if ok {
+		tx := txi.(proto.Tx)
+		return tx.Query(ctx, sql)
+	}
+	return executor.dbGroup.Query(proto.WithMaster(ctx), sql)
+}
+
+// ExecutorXA forwards a client-managed XA statement as is. XA START pins a backend
+// connection, which serves every statement of the connection until XA COMMIT or
+// XA ROLLBACK releases it, so that the XA transaction is never split across sessions.
+func (executor *ReadWriteSplittingExecutor) ExecutorXA(
+	ctx context.Context, sql string) (result proto.Result, warns uint16, err error) {
+	connectionID := proto.ConnectionID(ctx)
+	log.Debugf("connectionID: %d, xa: %s", connectionID, sql)
+	txi, ok := executor.localTransactionMap.Load(connectionID)
+	switch misc.XACommand(sql) {
+	case "START", "BEGIN":
+		if ok {
+			return nil, 0, errors.New("can not start an XA transaction inside a local transaction")
+		}
+		tx, result, err := executor.dbGroup.XAStart(ctx, sql)
+		if err != nil {
+			return nil, 0, err
+		}
+		executor.localTransactionMap.Store(connectionID, tx)
+		return result, 0, nil
+	case "COMMIT", "ROLLBACK":
+		if ok {
+			defer executor.localTransactionMap.Delete(connectionID)
+			result, err = txi.(proto.Tx).XAComplete(ctx, sql)
+			return result, 0, err
+		}
+	}
+	if ok {
+		return txi.(proto.Tx).Query(ctx, sql)
+	}
+	return executor.dbGroup.Query(proto.WithMaster(ctx), sql)
+}
+
+// recordSession records the session state the result of the statement changed, unless the caller
+// defers it, as the reads of a pipeline running concurrently are recorded in statement order.
+func (executor *ReadWriteSplittingExecutor) recordSession(ctx context.Context, stmt ast.StmtNode, result proto.Result) {
+	record := func() {
+		executor.pinnedSessions.record(ctx, stmt, result)
+	}
+	if !proto.DeferRecord(ctx, record) {
+		record()
+	}
+}
+
+// IndependentRead reports whether the query statement is a read sent to a slave outside of a
+// transaction and of a pinned session, which reads no session function either, such reads of
+// a pipeline may run concurrently.
+func (executor *ReadWriteSplittingExecutor) IndependentRead(ctx context.Context) bool {
+	stmt, ok := proto.QueryStmt(ctx).(*ast.SelectStmt)
+	if !ok {
+		return false
+	}
+	if _, ok := executor.localTransactionMap.Load(proto.ConnectionID(ctx)); ok {
+		return false
+	}
+	if misc.IsLockingRead(stmt) || executor.routes.route(proto.SqlText(ctx), stmt) == routeWrite {
+		return false
+	}
+	return len(sessionFunctionCalls(stmt)) == 0 &&
+		!executor.pinnedSessions.shouldPin(proto.ConnectionID(ctx), stmt)
+}
+
 func (executor *ReadWriteSplittingExecutor) ExecutorComStmtExecute(
 	ctx context.Context, stmt *proto.Stmt) (result proto.Result, warns uint16, err error) {
 	spanCtx, span := tracing.GetTraceSpan(ctx, tracing.RWSComStmtExecute)
 	defer span.End()
 
-	if err = executor.doPreFilter(spanCtx); err != nil {
+	filters := executor.filters.load()
+	if err = filters.doPreFilter(spanCtx); err != nil {
 		return nil, 0, err
 	}
 	defer func() {
 		if err == nil {
+			executor.recordSession(ctx, stmt.StmtNode, result)
+		}
+		if err == nil && filters.decodeRows {
 			result, err = decodeResult(result)
 		}
-		err = executor.doPostFilter(spanCtx, result, err)
+		err = filters.doPostFilter(spanCtx, result, err)
 		if err != nil {
 			span.RecordError(err)
 		}
@@ -307,6 +466,15 @@ func (executor *ReadWriteSplittingExecutor) ExecutorComStmtExecute(
 
 	connectionID := proto.ConnectionID(spanCtx)
 	log.Debugf("connectionID: %d, prepare: %s", connectionID, stmt.SqlText)
+	if answer := executor.pinnedSessions.answer(connectionID, stmt.StmtNode, true); answer != nil {
+		return answer, 0, nil
+	}
+	if executor.pinnedSessions.shouldPin(connectionID, stmt.StmtNode) {
+		return executor.pinnedSessions.execute(proto.WithMaster(spanCtx), connectionID, stmt.StmtNode, executor.dbGroup.Pin,
+			func(ctx context.Context, tx proto.Tx) (proto.Result, uint16, error) {
+				return tx.ExecuteStmt(ctx, stmt)
+			})
+	}
 	txi, ok := executor.localTransactionMap.Load(connectionID)
 	if ok {
 		// in local transaction
@@ -317,6 +485,10 @@ func (executor *ReadWriteSplittingExecutor) ExecutorComStmtExecute(
 	case *ast.InsertStmt, *ast.DeleteStmt, *ast.UpdateStmt:
 		return executor.dbGroup.PrepareExecuteStmt(proto.WithMaster(spanCtx), stmt)
 	case *ast.SelectStmt:
+		// the prepared statements are not rewritten, LAST_INSERT_ID() is answered by the master at least
+		if misc.IsLockingRead(st) || usesLastInsertID(st) || executor.routes.route(stmt.SqlText, st) == routeWrite {
+			return executor.dbGroup.PrepareExecuteStmt(proto.WithMaster(spanCtx), stmt)
+		}
 		if has, dsName := misc.HasUseDBHint(st.TableHints); has {
 			protoDB := resource.GetDBManager(executor.conf.AppID).GetDB(dsName)
 			if protoDB == nil {
@@ -327,6 +499,11 @@ func (executor *ReadWriteSplittingExecutor) ExecutorComStmtExecute(
 			}
 		}
 		return executor.dbGroup.PrepareExecuteStmt(proto.WithSlave(spanCtx), stmt)
+	case *ast.CallStmt:
+		if executor.routes.route(stmt.SqlText, st) == routeRead {
+			return executor.dbGroup.PrepareExecuteStmt(proto.WithSlave(spanCtx), stmt)
+		}
+		return executor.dbGroup.PrepareExecuteStmt(proto.WithMaster(spanCtx), stmt)
 	default:
 		return nil, 0, errors.Errorf("unsupported %t statement", stmt.StmtNode)
 	}
@@ -334,6 +511,8 @@ func (executor *ReadWriteSplittingExecutor) ExecutorComStmtExecute(
 
 func (executor *ReadWriteSplittingExecutor) ConnectionClose(ctx context.Context) {
 	connectionID := proto.ConnectionID(ctx)
+	executor.pinnedSessions.close(connectionID)
+	executor.filters.load().doConnectionClose(ctx)
 	txi, ok := executor.localTransactionMap.Load(connectionID)
 	if !ok {
 		return
@@ -344,25 +523,3 @@ func (executor *ReadWriteSplittingExecutor) ConnectionClose(ctx context.Context)
 	}
 	executor.localTransactionMap.Delete(connectionID)
 }
-
-func (executor *ReadWriteSplittingExecutor) doPreFilter(ctx context.Context) error {
-	for i := 0; i < len(executor.PreFilters); i++ {
-		f := executor.PreFilters[i]
-		err := f.PreHandle(ctx)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func (executor *ReadWriteSplittingExecutor) doPostFilter(ctx context.Context, result proto.Result, err error) error {
-	for i := 0; i < len(executor.PostFilters); i++ {
-		f := executor.PostFilters[i]
-		err := f.PostHandle(ctx, result, err)
-		if err != nil {
-			return err
-		}
-	}
-	return err
-}
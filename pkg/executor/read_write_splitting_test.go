@@ -88,6 +88,18 @@ func TestReadWriteSplittingExecutor(t *testing.T) {
 			"update employee set name = ? and age = ? and id = ?",
 			false,
 		},
+		{
+			true,
+			1,
+			"select id, name, age from employee where id = 1 for update",
+			false,
+		},
+		{
+			false,
+			1,
+			"select id, name, age from employee where id = ? lock in share mode",
+			false,
+		},
 		{
 			true,
 			1,
@@ -120,6 +132,7 @@ func TestReadWriteSplittingExecutor(t *testing.T) {
 	db := testdata.NewMockDB(ctrl)
 	tx := testdata.NewMockTx(ctrl)
 	db.EXPECT().IsMaster().Return(true).MaxTimes(100)
+	db.EXPECT().IsPassthrough().Return(false).MaxTimes(100)
 	db.EXPECT().SetWriteWeight(gomock.Any()).MaxTimes(100)
 	db.EXPECT().SetReadWeight(gomock.Any()).MaxTimes(100)
 	db.EXPECT().Query(gomock.Any(), gomock.Any()).Return(&mysql.Result{}, uint16(0), nil).MaxTimes(100)
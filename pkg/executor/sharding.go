@@ -28,12 +28,13 @@ import (
 	"github.com/cectc/dbpack/pkg/cond"
 	"github.com/cectc/dbpack/pkg/config"
 	"github.com/cectc/dbpack/pkg/constant"
-	"github.com/cectc/dbpack/pkg/filter"
 	"github.com/cectc/dbpack/pkg/group"
 	"github.com/cectc/dbpack/pkg/log"
 	"github.com/cectc/dbpack/pkg/misc/uuid"
 	"github.com/cectc/dbpack/pkg/mysql"
 	"github.com/cectc/dbpack/pkg/optimize"
+	"github.com/cectc/dbpack/pkg/osc"
+	"github.com/cectc/dbpack/pkg/plan"
 	"github.com/cectc/dbpack/pkg/proto"
 	"github.com/cectc/dbpack/pkg/topo"
 	"github.com/cectc/dbpack/pkg/tracing"
@@ -41,14 +42,18 @@ import (
 )
 
 type ShardingExecutor struct {
-	PreFilters  []proto.DBPreFilter
-	PostFilters []proto.DBPostFilter
+	filters *FilterChain
 
 	config    *config.ShardingConfig
 	executors []proto.DBGroupExecutor
 	optimizer proto.Optimizer
+	// fallback is the db group of the statements referencing no routed table, nil if there is none
+	fallback proto.DBGroupExecutor
+	// routedTables are the lower case names of the logic, global and replicated tables
+	routedTables map[string]bool
 	// map[uint32]proto.DBGroupTx
 	localTransactionMap *sync.Map
+	warnings            shardWarnings
 }
 
 func NewShardingExecutor(conf *config.Executor) (proto.Executor, error) {
@@ -90,32 +95,115 @@ func NewShardingExecutor(conf *config.Executor) (proto.Executor, error) {
 		return nil, errors.WithStack(err)
 	}
 
+	replicas, err := convertReplicas(shardingConfig.ReplicatedTables, globalTables, topologies,
+		executorSlice, executorMap)
+	if err != nil {
+		return nil, err
+	}
+
+	statistics := optimize.NewStatistics(conf.AppID)
+	for _, table := range shardingConfig.LogicTables {
+		interval, err := table.StatisticsRefreshInterval()
+		if err != nil {
+			return nil, err
+		}
+		if interval > 0 {
+			go statistics.AutoCollect(context.Background(), interval,
+				table.ShardingRule.Column, topologies[table.TableName])
+		}
+	}
+
+	var planCache *optimize.PlanCache
+	if shardingConfig.PlanCacheSize > 0 {
+		planCache = optimize.NewPlanCache(conf.AppID, shardingConfig.PlanCacheSize)
+		optimize.RegisterPlanCache(conf.AppID, conf.Name, planCache)
+	}
+
+	var onlineDDL *osc.Config
+	if shardingConfig.OnlineDDL != nil {
+		interval, err := shardingConfig.OnlineDDL.Interval()
+		if err != nil {
+			return nil, err
+		}
+		onlineDDL = &osc.Config{
+			ChunkSize:     shardingConfig.OnlineDDL.ChunkSize,
+			ChunkInterval: interval,
+			KeepOldTable:  shardingConfig.OnlineDDL.KeepOldTable,
+		}
+	}
+
+	var fallback proto.DBGroupExecutor
+	if shardingConfig.Fallback != nil {
+		if _, ok := executorMap[shardingConfig.Fallback.Name]; ok {
+			return nil, errors.Errorf("fallback db group %s is also a sharding db group", shardingConfig.Fallback.Name)
+		}
+		fallback, err = group.NewDBGroup(conf.AppID, shardingConfig.Fallback.Name,
+			shardingConfig.Fallback.LBAlgorithm, shardingConfig.Fallback.DataSources)
+		if err != nil {
+			return nil, err
+		}
+	}
+	routedTables := make(map[string]bool, len(globalTables)+len(topologies)+len(replicas))
+	for table := range globalTables {
+		routedTables[table] = true
+	}
+	for table := range topologies {
+		routedTables[strings.ToLower(table)] = true
+	}
+	for table := range replicas {
+		routedTables[table] = true
+	}
+
 	executor := &ShardingExecutor{
-		PreFilters:  make([]proto.DBPreFilter, 0),
-		PostFilters: make([]proto.DBPostFilter, 0),
-		config:      shardingConfig,
-		executors:   executorSlice,
+		filters:   newFilterChain(conf.AppID, conf.Name, conf.Filters),
+		config:    shardingConfig,
+		executors: executorSlice,
 		optimizer: optimize.NewOptimizer(conf.AppID,
-			globalTables, executorSlice, executorMap, algorithms, topologies),
+			globalTables, replicas, executorSlice, executorMap, algorithms, topologies, statistics, planCache, onlineDDL),
+		fallback:            fallback,
+		routedTables:        routedTables,
 		localTransactionMap: &sync.Map{},
 	}
 
-	for i := 0; i < len(conf.Filters); i++ {
-		filterName := conf.Filters[i]
-		f := filter.GetFilter(conf.AppID, filterName)
-		if f != nil {
-			preFilter, ok := f.(proto.DBPreFilter)
-			if ok {
-				executor.PreFilters = append(executor.PreFilters, preFilter)
-			}
-			postFilter, ok := f.(proto.DBPostFilter)
-			if ok {
-				executor.PostFilters = append(executor.PostFilters, postFilter)
+	return executor, nil
+}
+
+// convertReplicas maps the replicated tables by their lower case names to their replicas.
+func convertReplicas(replicatedTables []*config.ReplicatedTable,
+	globalTables map[string]bool,
+	topologies map[string]*topo.Topology,
+	executorSlice []proto.DBGroupExecutor,
+	executorMap map[string]proto.DBGroupExecutor) (map[string]*plan.Replicas, error) {
+	result := make(map[string]*plan.Replicas, len(replicatedTables))
+	logicTables := make(map[string]bool, len(topologies))
+	for table := range topologies {
+		logicTables[strings.ToLower(table)] = true
+	}
+	for _, table := range replicatedTables {
+		name := strings.ToLower(table.TableName)
+		if _, ok := result[name]; ok {
+			return nil, errors.Errorf("replicated table %s is declared more than once", table.TableName)
+		}
+		if globalTables[name] || logicTables[name] {
+			return nil, errors.Errorf("replicated table %s is also a global or a logic table", table.TableName)
+		}
+		executors := executorSlice
+		if len(table.DBGroups) > 0 {
+			executors = make([]proto.DBGroupExecutor, 0, len(table.DBGroups))
+			for _, group := range table.DBGroups {
+				executor, ok := executorMap[group]
+				if !ok {
+					return nil, errors.Errorf("db group %s of replicated table %s not found", group, table.TableName)
+				}
+				executors = append(executors, executor)
 			}
 		}
+		if len(executors) == 0 {
+			return nil, errors.Errorf("replicated table %s has no db group", table.TableName)
+		}
+		result[name] = plan.NewReplicas(executors)
 	}
-
-	return executor, nil
+	return result, nil
 }
 
 func convertShardingAlgorithmsAndTopologies(logicTables []*config.LogicTable) (
@@ -147,8 +235,12 @@ func convertShardingAlgorithmsAndTopologies(logicTables []*config.LogicTable) (
 				return nil, nil, err
 			}
 		}
+		location, err := table.ShardingRule.Location()
+		if err != nil {
+			return nil, nil, err
+		}
 		alg, err := cond.NewShardingAlgorithm(table.ShardingRule.ShardingAlgorithm,
-			table.ShardingRule.Column, table.AllowFullScan, topology, table.ShardingRule.Config, generator)
+			table.ShardingRule.Column, table.AllowFullScan, topology, table.ShardingRule.Config, location, generator)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -157,12 +249,22 @@ func convertShardingAlgorithmsAndTopologies(logicTables []*config.LogicTable) (
 	return algs, topos, nil
 }
 
+// Close stops the monitors of the db groups.
+func (executor *ShardingExecutor) Close() {
+	for _, dbGroup := range executor.executors {
+		closeGroup(dbGroup)
+	}
+	if executor.fallback != nil {
+		closeGroup(executor.fallback)
+	}
+}
+
 func (executor *ShardingExecutor) GetPreFilters() []proto.DBPreFilter {
-	return executor.PreFilters
+	return executor.filters.load().preFilters
 }
 
 func (executor *ShardingExecutor) GetPostFilters() []proto.DBPostFilter {
-	return executor.PostFilters
+	return executor.filters.load().postFilters
 }
 
 func (executor *ShardingExecutor) ExecuteMode() config.ExecuteMode {
@@ -183,6 +285,24 @@ func (executor *ShardingExecutor) InGlobalTransaction(ctx context.Context) bool
 	return false
 }
 
+// PinnedConnections returns the backend connections the transaction of the client connection holds,
+// one for each db group it has run statements on.
+func (executor *ShardingExecutor) PinnedConnections(connectionID uint32) []*proto.PinnedConnection {
+	txi, ok := executor.localTransactionMap.Load(connectionID)
+	if !ok {
+		return nil
+	}
+	tx, ok := txi.(*group.ComplexTx)
+	if !ok {
+		return nil
+	}
+	var connections []*proto.PinnedConnection
+	for _, child := range tx.Transactions() {
+		connections = append(connections, pinnedConnection(child, []string{proto.PinReasonTransaction}))
+	}
+	return connections
+}
+
 func (executor *ShardingExecutor) ExecuteUseDB(ctx context.Context, db string) error {
 	return errors.New("unimplemented COM_INIT_DB in read write splitting mode")
 }
@@ -200,14 +320,15 @@ func (executor *ShardingExecutor) ExecutorComQuery(ctx context.Context, sql stri
 	spanCtx, span := tracing.GetTraceSpan(ctx, tracing.SHDComQuery)
 	defer span.End()
 
-	if err = executor.doPreFilter(spanCtx); err != nil {
+	filters := executor.filters.load()
+	if err = filters.doPreFilter(spanCtx); err != nil {
 		return nil, 0, err
 	}
 	defer func() {
-		if err == nil {
+		if err == nil && filters.decodeRows {
 			result, err = decodeResult(result)
 		}
-		err = executor.doPostFilter(spanCtx, result, err)
+		err = filters.doPostFilter(spanCtx, result, err)
 		if err != nil {
 			span.RecordError(err)
 		}
@@ -221,6 +342,23 @@ func (executor *ShardingExecutor) ExecutorComQuery(ctx context.Context, sql stri
 	if queryStmt == nil {
 		return nil, 0, errors.New("query stmt should not be nil")
 	}
+	if isShowWarnings(queryStmt) {
+		return executor.warnings.show(connectionID, false), 0, nil
+	}
+	spanCtx, keepWarnings := executor.warnings.collect(spanCtx)
+	defer keepWarnings()
+
+	if executor.shouldFallback(queryStmt) {
+		txi, ok := executor.localTransactionMap.Load(connectionID)
+		if ok {
+			tx, err := txi.(proto.DBGroupTx).Begin(spanCtx, executor.fallback)
+			if err != nil {
+				return nil, 0, err
+			}
+			return tx.Query(spanCtx, sql)
+		}
+		return executor.fallback.Query(spanCtx, sql)
+	}
 
 	switch stmt := queryStmt.(type) {
 	case *ast.SetStmt:
@@ -281,6 +419,15 @@ func (executor *ShardingExecutor) ExecutorComQuery(ctx context.Context, sql stri
 			return nil, 0, err
 		}
 		return result, 0, err
+	case *ast.ExplainStmt:
+		if strings.EqualFold(stmt.Format, "route") {
+			plan, err = executor.optimizer.Optimize(spanCtx, stmt.Stmt)
+			if err != nil {
+				return nil, 0, err
+			}
+			return explainRoute(plan)
+		}
+		return nil, 0, errors.Errorf("unsupported explain format %s in sharding mode, sql: %s", stmt.Format, sql)
 	case *ast.SelectStmt:
 		if stmt.Fields != nil && len(stmt.Fields.Fields) > 0 {
 			if _, ok := stmt.Fields.Fields[0].Expr.(*ast.VariableExpr); ok {
@@ -317,14 +464,15 @@ func (executor *ShardingExecutor) ExecutorComStmtExecute(
 	spanCtx, span := tracing.GetTraceSpan(ctx, tracing.SHDComStmtExecute)
 	defer span.End()
 
-	if err = executor.doPreFilter(ctx); err != nil {
+	filters := executor.filters.load()
+	if err = filters.doPreFilter(ctx); err != nil {
 		return nil, 0, err
 	}
 	defer func() {
-		if err == nil {
+		if err == nil && filters.decodeRows {
 			result, err = decodeResult(result)
 		}
-		err = executor.doPostFilter(spanCtx, result, err)
+		err = filters.doPostFilter(spanCtx, result, err)
 		if err != nil {
 			span.RecordError(err)
 		}
@@ -337,12 +485,27 @@ func (executor *ShardingExecutor) ExecutorComStmtExecute(
 
 	connectionID := proto.ConnectionID(ctx)
 	log.Debugf("connectionID: %d, prepare: %s", connectionID, stmt.SqlText)
+	if isShowWarnings(stmt.StmtNode) {
+		return executor.warnings.show(connectionID, true), 0, nil
+	}
+	spanCtx, keepWarnings := executor.warnings.collect(spanCtx)
+	defer keepWarnings()
 	for i := 0; i < len(stmt.BindVars); i++ {
 		parameterID := fmt.Sprintf("v%d", i+1)
 		args = append(args, stmt.BindVars[parameterID])
 	}
 
 	txi, ok := executor.localTransactionMap.Load(connectionID)
+	if executor.shouldFallback(stmt.StmtNode) {
+		if ok {
+			tx, err := txi.(proto.DBGroupTx).Begin(spanCtx, executor.fallback)
+			if err != nil {
+				return nil, 0, err
+			}
+			return tx.ExecuteStmt(spanCtx, stmt)
+		}
+		return executor.fallback.PrepareExecuteStmt(spanCtx, stmt)
+	}
 	if ok {
 		tx := txi.(proto.DBGroupTx)
 		return tx.Execute(spanCtx, stmt.StmtNode, args...)
@@ -355,8 +518,53 @@ func (executor *ShardingExecutor) ExecutorComStmtExecute(
 	return plan.Execute(spanCtx)
 }
 
+// shouldFallback reports whether stmt is sent to the fallback db group, which it is if it references
+// tables but none of the logic, global and replicated tables, the tables of information_schema aside.
+func (executor *ShardingExecutor) shouldFallback(stmt ast.StmtNode) bool {
+	if executor.fallback == nil || stmt == nil {
+		return false
+	}
+	v := &tableNameVisitor{}
+	stmt.Accept(v)
+	if len(v.names) == 0 {
+		return false
+	}
+	for _, name := range v.names {
+		if executor.routedTables[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// tableNameVisitor collects the lower case names of the tables referenced by a statement.
+type tableNameVisitor struct {
+	names []string
+}
+
+func (v *tableNameVisitor) Enter(in ast.Node) (out ast.Node, skipChildren bool) {
+	if table, ok := in.(*ast.TableName); ok && table.Schema.L != "information_schema" {
+		v.names = append(v.names, table.Name.L)
+	}
+	return in, false
+}
+
+func (v *tableNameVisitor) Leave(in ast.Node) (out ast.Node, ok bool) {
+	return in, true
+}
+
+func explainRoute(p proto.Plan) (proto.Result, uint16, error) {
+	result, err := plan.ExplainRoute(p)
+	if err != nil {
+		return nil, 0, err
+	}
+	return result, 0, nil
+}
+
 func (executor *ShardingExecutor) ConnectionClose(ctx context.Context) {
 	connectionID := proto.ConnectionID(ctx)
+	executor.warnings.close(connectionID)
+	executor.filters.load().doConnectionClose(ctx)
 	txi, ok := executor.localTransactionMap.Load(connectionID)
 	if !ok {
 		return
@@ -368,25 +576,3 @@ func (executor *ShardingExecutor) ConnectionClose(ctx context.Context) {
 	}
 	executor.localTransactionMap.Delete(connectionID)
 }
-
-func (executor *ShardingExecutor) doPreFilter(ctx context.Context) error {
-	for i := 0; i < len(executor.PreFilters); i++ {
-		f := executor.PreFilters[i]
-		err := f.PreHandle(ctx)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func (executor *ShardingExecutor) doPostFilter(ctx context.Context, result proto.Result, err error) error {
-	for i := 0; i < len(executor.PostFilters); i++ {
-		f := executor.PostFilters[i]
-		err := f.PostHandle(ctx, result, err)
-		if err != nil {
-			return err
-		}
-	}
-	return err
-}
@@ -28,6 +28,7 @@ import (
 	"github.com/cectc/dbpack/pkg/constant"
 	"github.com/cectc/dbpack/pkg/mysql"
 	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/topo"
 	"github.com/cectc/dbpack/pkg/visitor"
 	"github.com/cectc/dbpack/testdata"
 	"github.com/cectc/dbpack/third_party/parser"
@@ -169,3 +170,37 @@ func (suite *_ShardingExecutorTestSuite) unmarshalExecutorConfig() *config.Execu
 func (suite *_ShardingExecutorTestSuite) TearDownSuite() {
 	suite.environment.Shutdown(suite.T())
 }
+
+type namedExecutor struct {
+	proto.DBGroupExecutor
+	name string
+}
+
+func (e *namedExecutor) GroupName() string {
+	return e.name
+}
+
+func TestConvertReplicas(t *testing.T) {
+	world0, world1 := &namedExecutor{name: "world_0"}, &namedExecutor{name: "world_1"}
+	executorSlice := []proto.DBGroupExecutor{world0, world1}
+	executorMap := map[string]proto.DBGroupExecutor{"world_0": world0, "world_1": world1}
+	globalTables := map[string]bool{"country": true}
+	topologies := map[string]*topo.Topology{"city": nil}
+
+	replicas, err := convertReplicas([]*config.ReplicatedTable{
+		{TableName: "Language"},
+		{TableName: "currency", DBGroups: []string{"world_1"}},
+	}, globalTables, topologies, executorSlice, executorMap)
+	assert.NoError(t, err)
+	assert.Equal(t, executorSlice, replicas["language"].Executors)
+	assert.Equal(t, []proto.DBGroupExecutor{world1}, replicas["currency"].Executors)
+
+	for _, table := range []*config.ReplicatedTable{
+		{TableName: "country"},
+		{TableName: "City"},
+		{TableName: "currency", DBGroups: []string{"world_2"}},
+	} {
+		_, err = convertReplicas([]*config.ReplicatedTable{table}, globalTables, topologies, executorSlice, executorMap)
+		assert.Error(t, err, table.TableName)
+	}
+}
@@ -26,8 +26,8 @@ import (
 
 	"github.com/cectc/dbpack/pkg/config"
 	"github.com/cectc/dbpack/pkg/constant"
-	"github.com/cectc/dbpack/pkg/filter"
 	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/misc"
 	"github.com/cectc/dbpack/pkg/proto"
 	"github.com/cectc/dbpack/pkg/resource"
 	"github.com/cectc/dbpack/pkg/tracing"
@@ -36,13 +36,13 @@ import (
 )
 
 type SingleDBExecutor struct {
-	conf        *config.Executor
-	PreFilters  []proto.DBPreFilter
-	PostFilters []proto.DBPostFilter
+	conf    *config.Executor
+	filters *FilterChain
 
 	dataSource string
 	// map[uint32]proto.Tx
 	localTransactionMap *sync.Map
+	pinnedSessions      *pinnedSessions
 }
 
 func NewSingleDBExecutor(conf *config.Executor) (proto.Executor, error) {
@@ -55,9 +55,7 @@ func NewSingleDBExecutor(conf *config.Executor) (proto.Executor, error) {
 		return nil, errors.Wrap(err, "marshal single db executor datasource config failed.")
 	}
 
-	v := &struct {
-		DataSource string `yaml:"data_source_ref" json:"data_source_ref"`
-	}{}
+	v := &config.SingleDBConfig{}
 
 	if err = json.Unmarshal(content, v); err != nil {
 		log.Errorf("unmarshal single db executor datasource config failed, %s", err)
@@ -66,36 +64,21 @@ func NewSingleDBExecutor(conf *config.Executor) (proto.Executor, error) {
 
 	executor := &SingleDBExecutor{
 		conf:                conf,
-		PreFilters:          make([]proto.DBPreFilter, 0),
-		PostFilters:         make([]proto.DBPostFilter, 0),
-		dataSource:          v.DataSource,
+		filters:             newFilterChain(conf.AppID, conf.Name, conf.Filters),
+		dataSource:          v.DataSourceRef,
 		localTransactionMap: &sync.Map{},
 	}
-
-	for i := 0; i < len(conf.Filters); i++ {
-		filterName := conf.Filters[i]
-		f := filter.GetFilter(conf.AppID, filterName)
-		if f != nil {
-			preFilter, ok := f.(proto.DBPreFilter)
-			if ok {
-				executor.PreFilters = append(executor.PreFilters, preFilter)
-			}
-			postFilter, ok := f.(proto.DBPostFilter)
-			if ok {
-				executor.PostFilters = append(executor.PostFilters, postFilter)
-			}
-		}
-	}
+	executor.pinnedSessions = newPinnedSessions(executor.localTransactionMap)
 
 	return executor, nil
 }
 
 func (executor *SingleDBExecutor) GetPreFilters() []proto.DBPreFilter {
-	return executor.PreFilters
+	return executor.filters.load().preFilters
 }
 
 func (executor *SingleDBExecutor) GetPostFilters() []proto.DBPostFilter {
-	return executor.PostFilters
+	return executor.filters.load().postFilters
 }
 
 func (executor *SingleDBExecutor) ExecuteMode() config.ExecuteMode {
@@ -108,14 +91,18 @@ func (executor *SingleDBExecutor) ProcessDistributedTransaction() bool {
 
 func (executor *SingleDBExecutor) InLocalTransaction(ctx context.Context) bool {
 	connectionID := proto.ConnectionID(ctx)
-	_, ok := executor.localTransactionMap.Load(connectionID)
-	return ok
+	return executor.pinnedSessions.inTransaction(connectionID)
 }
 
 func (executor *SingleDBExecutor) InGlobalTransaction(ctx context.Context) bool {
 	return false
 }
 
+// PinnedConnections returns the backend connection held for the client connection.
+func (executor *SingleDBExecutor) PinnedConnections(connectionID uint32) []*proto.PinnedConnection {
+	return executor.pinnedSessions.connections(connectionID)
+}
+
 func (executor *SingleDBExecutor) ExecuteUseDB(ctx context.Context, schema string) error {
 	db := resource.GetDBManager(executor.conf.AppID).GetDB(executor.dataSource)
 	return db.UseDB(ctx, schema)
@@ -131,14 +118,18 @@ func (executor *SingleDBExecutor) ExecutorComQuery(
 	spanCtx, span := tracing.GetTraceSpan(ctx, tracing.SDBComQuery)
 	defer span.End()
 
-	if err = executor.doPreFilter(spanCtx); err != nil {
+	filters := executor.filters.load()
+	if err = filters.doPreFilter(spanCtx); err != nil {
 		return nil, 0, err
 	}
 	defer func() {
 		if err == nil {
+			executor.pinnedSessions.record(ctx, proto.QueryStmt(ctx), result)
+		}
+		if err == nil && filters.decodeRows {
 			result, err = decodeResult(result)
 		}
-		err = executor.doPostFilter(spanCtx, result, err)
+		err = filters.doPostFilter(spanCtx, result, err)
 		if err != nil {
 			span.RecordError(err)
 		}
@@ -155,6 +146,10 @@ func (executor *SingleDBExecutor) ExecutorComQuery(
 	if queryStmt == nil {
 		return nil, 0, errors.New("query stmt should not be nil")
 	}
+	if answer := executor.pinnedSessions.answer(connectionID, queryStmt, false); answer != nil {
+		return answer, 0, nil
+	}
+	executor.pinnedSessions.rewrite(connectionID, queryStmt)
 	if err := queryStmt.Restore(format.NewRestoreCtx(constant.DBPackRestoreFormat, &sb)); err != nil {
 		return nil, 0, err
 	}
@@ -163,9 +158,22 @@ func (executor *SingleDBExecutor) ExecutorComQuery(
 
 	log.Debugf("connectionID: %d, query: %s", connectionID, sql)
 	db = resource.GetDBManager(executor.conf.AppID).GetDB(executor.dataSource)
+	if executor.pinnedSessions.shouldPin(connectionID, queryStmt) {
+		if db.IsPassthrough() {
+			sql = sqlText
+		}
+		return executor.pinnedSessions.execute(spanCtx, connectionID, queryStmt, db.Pin,
+			func(ctx context.Context, tx proto.Tx) (proto.Result, uint16, error) {
+				return tx.Query(ctx, sql)
+			})
+	}
 	switch stmt := queryStmt.(type) {
 	case *ast.SetStmt:
 		if shouldStartTransaction(stmt) {
+			if executor.pinnedSessions.pinned(connectionID) {
+				result, err = executor.pinnedSessions.begin(spanCtx, connectionID)
+				return result, 0, err
+			}
 			// TODO add metrics
 			tx, result, err = db.Begin(spanCtx)
 			if err != nil {
@@ -182,6 +190,10 @@ func (executor *SingleDBExecutor) ExecutorComQuery(
 			return db.Query(spanCtx, sqlText)
 		}
 	case *ast.BeginStmt:
+		if executor.pinnedSessions.pinned(connectionID) {
+			result, err = executor.pinnedSessions.begin(spanCtx, connectionID)
+			return result, 0, err
+		}
 		// TODO add metrics
 		tx, result, err = db.Begin(spanCtx)
 		if err != nil {
@@ -194,6 +206,10 @@ func (executor *SingleDBExecutor) ExecutorComQuery(
 		if !ok {
 			return nil, 0, errors.New("there is no transaction")
 		}
+		if executor.pinnedSessions.pinned(connectionID) {
+			result, err = executor.pinnedSessions.end(spanCtx, connectionID, "COMMIT")
+			return result, 0, err
+		}
 		defer executor.localTransactionMap.Delete(connectionID)
 		tx = txi.(proto.Tx)
 		// TODO add metrics
@@ -207,6 +223,10 @@ func (executor *SingleDBExecutor) ExecutorComQuery(
 			return nil, 0, errors.New("there is no transaction")
 		}
 		if stmt.SavepointName == "" {
+			if executor.pinnedSessions.pinned(connectionID) {
+				result, err = executor.pinnedSessions.end(spanCtx, connectionID, "ROLLBACK")
+				return result, 0, err
+			}
 			defer executor.localTransactionMap.Delete(connectionID)
 		}
 		tx = txi.(proto.Tx)
@@ -226,6 +246,9 @@ func (executor *SingleDBExecutor) ExecutorComQuery(
 		}
 		return result, 0, err
 	case *ast.XAStartStmt:
+		if executor.pinnedSessions.pinned(connectionID) {
+			return nil, 0, errors.New("can not start an XA transaction on a connection pinned for its session state")
+		}
 		tx, result, err = db.XAStart(spanCtx, sqlText)
 		if err != nil {
 			return nil, 0, err
@@ -244,28 +267,101 @@ func (executor *SingleDBExecutor) ExecutorComQuery(
 		}
 		return result, 0, err
 	default:
+		if db.IsPassthrough() {
+			// the proxy behind rewrites the statement itself
+			sql = sqlText
+		}
 		txi, ok := executor.localTransactionMap.Load(connectionID)
 		if ok {
 			tx = txi.(proto.Tx)
 			return tx.Query(spanCtx, sql)
 		}
+		if db, err = executor.routeDB(spanCtx, db); err != nil {
+			return nil, 0, err
+		}
 		return db.Query(spanCtx, sql)
 	}
 }
 
+// routeDB returns the data source a statement outside transactions is sent to, which is db unless
+// a pre filter routes the statement to another data source, see constant.DataSourceRoute.
+func (executor *SingleDBExecutor) routeDB(ctx context.Context, db proto.DB) (proto.DB, error) {
+	route, ok := proto.Variable(ctx, constant.DataSourceRoute).(string)
+	if !ok || route == "" || route == executor.dataSource {
+		return db, nil
+	}
+	routed := resource.GetDBManager(executor.conf.AppID).GetDB(route)
+	if routed == nil {
+		return nil, errors.Errorf("there is no data source %s", route)
+	}
+	return routed, nil
+}
+
+// ExecutorPassthrough sends a statement the proxy could not parse to the data source as is,
+// bypassing the filters since they rely on the parsed statement.
+func (executor *SingleDBExecutor) ExecutorPassthrough(
+	ctx context.Context, sql string) (result proto.Result, warns uint16, err error) {
+	connectionID := proto.ConnectionID(ctx)
+	log.Debugf("connectionID: %d, passthrough: %s", connectionID, sql)
+	txi, ok := executor.localTransactionMap.Load(connectionID)
+	if ok {
+		tx := txi.(proto.Tx)
+		return tx.Query(ctx, sql)
+	}
+	db := resource.GetDBManager(executor.conf.AppID).GetDB(executor.dataSource)
+	return db.Query(ctx, sql)
+}
+
+// ExecutorXA forwards a client-managed XA statement as is. XA START pins a backend
+// connection, which serves every statement of the connection until XA COMMIT or
+// XA ROLLBACK releases it, so that the XA transaction is never split across sessions.
+func (executor *SingleDBExecutor) ExecutorXA(
+	ctx context.Context, sql string) (result proto.Result, warns uint16, err error) {
+	connectionID := proto.ConnectionID(ctx)
+	log.Debugf("connectionID: %d, xa: %s", connectionID, sql)
+	db := resource.GetDBManager(executor.conf.AppID).GetDB(executor.dataSource)
+	txi, ok := executor.localTransactionMap.Load(connectionID)
+	switch misc.XACommand(sql) {
+	case "START", "BEGIN":
+		if ok {
+			return nil, 0, errors.New("can not start an XA transaction inside a local transaction")
+		}
+		tx, result, err := db.XAStart(ctx, sql)
+		if err != nil {
+			return nil, 0, err
+		}
+		executor.localTransactionMap.Store(connectionID, tx)
+		return result, 0, nil
+	case "COMMIT", "ROLLBACK":
+		if ok {
+			defer executor.localTransactionMap.Delete(connectionID)
+			result, err = txi.(proto.Tx).XAComplete(ctx, sql)
+			return result, 0, err
+		}
+	}
+	if ok {
+		return txi.(proto.Tx).Query(ctx, sql)
+	}
+	return db.Query(ctx, sql)
+}
+
 func (executor *SingleDBExecutor) ExecutorComStmtExecute(
 	ctx context.Context, stmt *proto.Stmt) (result proto.Result, warns uint16, err error) {
 	spanCtx, span := tracing.GetTraceSpan(ctx, tracing.SDBComStmtExecute)
 	defer span.End()
 
-	if err = executor.doPreFilter(spanCtx); err != nil {
+	filters := executor.filters.load()
+	if err = filters.doPreFilter(spanCtx); err != nil {
 		return nil, 0, err
 	}
 	defer func() {
 		if err == nil {
+			executor.pinnedSessions.record(ctx, stmt.StmtNode, result)
+		}
+		if err == nil && filters.decodeRows {
 			result, err = decodeResult(result)
 		}
-		err = executor.doPostFilter(spanCtx, result, err)
+		err = filters.doPostFilter(spanCtx, result, err)
 		if err != nil {
 			span.RecordError(err)
 		}
@@ -273,17 +369,31 @@ func (executor *SingleDBExecutor) ExecutorComStmtExecute(
 
 	connectionID := proto.ConnectionID(ctx)
 	log.Debugf("connectionID: %d, prepare: %s", connectionID, stmt.SqlText)
+	if answer := executor.pinnedSessions.answer(connectionID, stmt.StmtNode, true); answer != nil {
+		return answer, 0, nil
+	}
+	db := resource.GetDBManager(executor.conf.AppID).GetDB(executor.dataSource)
+	if executor.pinnedSessions.shouldPin(connectionID, stmt.StmtNode) {
+		return executor.pinnedSessions.execute(spanCtx, connectionID, stmt.StmtNode, db.Pin,
+			func(ctx context.Context, tx proto.Tx) (proto.Result, uint16, error) {
+				return tx.ExecuteStmt(ctx, stmt)
+			})
+	}
 	txi, ok := executor.localTransactionMap.Load(connectionID)
 	if ok {
 		tx := txi.(proto.Tx)
 		return tx.ExecuteStmt(spanCtx, stmt)
 	}
-	db := resource.GetDBManager(executor.conf.AppID).GetDB(executor.dataSource)
+	if db, err = executor.routeDB(spanCtx, db); err != nil {
+		return nil, 0, err
+	}
 	return db.ExecuteStmt(spanCtx, stmt)
 }
 
 func (executor *SingleDBExecutor) ConnectionClose(ctx context.Context) {
 	connectionID := proto.ConnectionID(ctx)
+	executor.pinnedSessions.close(connectionID)
+	executor.filters.load().doConnectionClose(ctx)
 	txi, ok := executor.localTransactionMap.Load(connectionID)
 	if !ok {
 		return
@@ -294,25 +404,3 @@ func (executor *SingleDBExecutor) ConnectionClose(ctx context.Context) {
 	}
 	executor.localTransactionMap.Delete(connectionID)
 }
-
-func (executor *SingleDBExecutor) doPreFilter(ctx context.Context) error {
-	for i := 0; i < len(executor.PreFilters); i++ {
-		f := executor.PreFilters[i]
-		err := f.PreHandle(ctx)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func (executor *SingleDBExecutor) doPostFilter(ctx context.Context, result proto.Result, err error) error {
-	for i := 0; i < len(executor.PostFilters); i++ {
-		f := executor.PostFilters[i]
-		err := f.PostHandle(ctx, result, err)
-		if err != nil {
-			return err
-		}
-	}
-	return err
-}
@@ -113,6 +113,7 @@ func TestSingleDBExecutor(t *testing.T) {
 
 	db := testdata.NewMockDB(ctrl)
 	tx := testdata.NewMockTx(ctrl)
+	db.EXPECT().IsPassthrough().Return(false).MaxTimes(100)
 	db.EXPECT().Query(gomock.Any(), gomock.Any()).Return(&mysql.Result{}, uint16(0), nil).MaxTimes(100)
 	db.EXPECT().ExecuteStmt(gomock.Any(), gomock.Any()).Return(&mysql.Result{}, uint16(0), nil).MaxTimes(100)
 	db.EXPECT().Begin(gomock.Any()).Return(tx, &mysql.Result{}, nil).MaxTimes(100)
@@ -177,3 +178,58 @@ func TestSingleDBExecutor(t *testing.T) {
 		})
 	}
 }
+
+func TestSingleDBExecutorXA(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db := testdata.NewMockDB(ctrl)
+	tx := testdata.NewMockTx(ctrl)
+	gomock.InOrder(
+		db.EXPECT().XAStart(gomock.Any(), "XA START 'xid1', 'b1'").Return(tx, &mysql.Result{}, nil),
+		tx.EXPECT().Query(gomock.Any(), "update employee set age = 20 where id = 1").Return(&mysql.Result{}, uint16(0), nil),
+		tx.EXPECT().Query(gomock.Any(), "XA END 'xid1', 'b1'").Return(&mysql.Result{}, uint16(0), nil),
+		tx.EXPECT().Query(gomock.Any(), "XA PREPARE 'xid1', 'b1'").Return(&mysql.Result{}, uint16(0), nil),
+		tx.EXPECT().XAComplete(gomock.Any(), "XA COMMIT 'xid1', 'b1'").Return(&mysql.Result{}, nil),
+		db.EXPECT().Query(gomock.Any(), "XA RECOVER").Return(&mysql.Result{}, uint16(0), nil),
+	)
+
+	manager := testdata.NewMockDBManager(ctrl)
+	manager.EXPECT().GetDB(gomock.Any()).AnyTimes().Return(db)
+	resource.SetDBManager("app1", manager)
+
+	executor, err := NewSingleDBExecutor(&config.Executor{
+		AppID: "app1",
+		Name:  "sdb",
+		Mode:  config.SDB,
+		Config: map[string]interface{}{
+			"data_source_ref": "employee",
+		},
+	})
+	assert.Nil(t, err)
+	sdb := executor.(*SingleDBExecutor)
+
+	ctx := proto.WithConnectionID(context.Background(), 1)
+	_, _, err = sdb.ExecutorXA(ctx, "XA START 'xid1', 'b1'")
+	assert.Nil(t, err)
+	assert.True(t, sdb.InLocalTransaction(ctx))
+
+	_, _, err = sdb.ExecutorXA(ctx, "XA START 'xid2'")
+	assert.NotNil(t, err)
+
+	_, _, err = sdb.ExecutorPassthrough(ctx, "update employee set age = 20 where id = 1")
+	assert.Nil(t, err)
+
+	for _, sql := range []string{"XA END 'xid1', 'b1'", "XA PREPARE 'xid1', 'b1'"} {
+		_, _, err = sdb.ExecutorXA(ctx, sql)
+		assert.Nil(t, err)
+		assert.True(t, sdb.InLocalTransaction(ctx))
+	}
+
+	_, _, err = sdb.ExecutorXA(ctx, "XA COMMIT 'xid1', 'b1'")
+	assert.Nil(t, err)
+	assert.False(t, sdb.InLocalTransaction(ctx))
+
+	_, _, err = sdb.ExecutorXA(ctx, "XA RECOVER")
+	assert.Nil(t, err)
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package executor
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/third_party/parser"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+)
+
+type statementRoute int
+
+const (
+	// routeDefault leaves the statement to the classification by its type
+	routeDefault statementRoute = iota
+	routeRead
+	routeWrite
+)
+
+// statementRoutes classifies the statements declared by config.StatementRoute as reads or writes.
+type statementRoutes struct {
+	// procedures are keyed by the lower case names, qualified by the schemas if they are configured so
+	procedures map[string]statementRoute
+	// fingerprints are keyed by the digests of the normalized statements
+	fingerprints map[string]statementRoute
+}
+
+func newStatementRoutes(routes []*config.StatementRoute) (*statementRoutes, error) {
+	result := &statementRoutes{
+		procedures:   make(map[string]statementRoute),
+		fingerprints: make(map[string]statementRoute),
+	}
+	for _, route := range routes {
+		var r statementRoute
+		switch strings.ToLower(route.Route) {
+		case "read":
+			r = routeRead
+		case "write":
+			r = routeWrite
+		default:
+			return nil, errors.Errorf("statement route must be read or write, got '%s'", route.Route)
+		}
+		if (route.Procedure == "") == (route.Fingerprint == "") {
+			return nil, errors.New("statement route must have either a procedure or a fingerprint")
+		}
+		if route.Procedure != "" {
+			result.procedures[strings.ToLower(route.Procedure)] = r
+		} else {
+			result.fingerprints[parser.DigestHash(route.Fingerprint).String()] = r
+		}
+	}
+	return result, nil
+}
+
+// route returns the declared route of stmt, whose text is sql.
+func (routes *statementRoutes) route(sql string, stmt ast.StmtNode) statementRoute {
+	if call, ok := stmt.(*ast.CallStmt); ok && len(routes.procedures) > 0 {
+		name := call.Procedure.FnName.L
+		if call.Procedure.Schema.L != "" {
+			if r, ok := routes.procedures[call.Procedure.Schema.L+"."+name]; ok {
+				return r
+			}
+		}
+		if r, ok := routes.procedures[name]; ok {
+			return r
+		}
+	}
+	if len(routes.fingerprints) > 0 {
+		if r, ok := routes.fingerprints[parser.DigestHash(sql).String()]; ok {
+			return r
+		}
+	}
+	return routeDefault
+}
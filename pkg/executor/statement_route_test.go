@@ -0,0 +1,70 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package executor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/third_party/parser"
+)
+
+func TestStatementRoutes(t *testing.T) {
+	routes, err := newStatementRoutes([]*config.StatementRoute{
+		{Procedure: "get_salary", Route: "read"},
+		{Procedure: "employees.raise_salary", Route: "Read"},
+		{Fingerprint: "select * from employees where emp_no = ?", Route: "write"},
+		{Fingerprint: "show processlist", Route: "write"},
+	})
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		sql   string
+		route statementRoute
+	}{
+		{sql: "call get_salary(10001)", route: routeRead},
+		{sql: "CALL employees.GET_SALARY(10001)", route: routeRead},
+		{sql: "call employees.raise_salary(10001)", route: routeRead},
+		{sql: "call raise_salary(10001)", route: routeDefault},
+		{sql: "call set_salary(10001)", route: routeDefault},
+		{sql: "SELECT * FROM employees WHERE emp_no = 10001", route: routeWrite},
+		{sql: "select * from employees where emp_no = ?", route: routeWrite},
+		{sql: "select * from employees where first_name = 'scott'", route: routeDefault},
+		{sql: "show processlist", route: routeWrite},
+	}
+	p := parser.New()
+	for _, c := range testCases {
+		t.Run(c.sql, func(t *testing.T) {
+			stmt, err := p.ParseOneStmt(c.sql, "", "")
+			assert.NoError(t, err)
+			assert.Equal(t, c.route, routes.route(c.sql, stmt))
+		})
+	}
+}
+
+func TestStatementRoutesInvalid(t *testing.T) {
+	for _, route := range []*config.StatementRoute{
+		{Procedure: "get_salary", Route: "replica"},
+		{Route: "read"},
+		{Procedure: "get_salary", Fingerprint: "call get_salary(?)", Route: "read"},
+	} {
+		_, err := newStatementRoutes([]*config.StatementRoute{route})
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package executor
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+)
+
+var warningColumns = []string{"Level", "Code", "Message", "Shard"}
+
+// shardWarnings keeps the warnings of the last statement of each client connection, merged from
+// the data sources the statement was sent to. The backend connections the statement ran on are
+// released once it is done, so SHOW WARNINGS is answered from the warnings kept here, each
+// annotated with the data source which returned it.
+type shardWarnings struct {
+	// map[uint32][]*proto.Warning
+	warnings sync.Map
+}
+
+// isShowWarnings reports whether stmt is SHOW WARNINGS.
+func isShowWarnings(stmt ast.StmtNode) bool {
+	show, ok := stmt.(*ast.ShowStmt)
+	return ok && show.Tp == ast.ShowWarnings
+}
+
+// collect binds the collection of the warnings of the statement to ctx, the returned function
+// keeps them once the statement is done, replacing the warnings of the previous statement.
+func (w *shardWarnings) collect(ctx context.Context) (context.Context, func()) {
+	ctx = proto.WithShardWarnings(ctx)
+	return ctx, func() {
+		connectionID := proto.ConnectionID(ctx)
+		warnings := proto.ShardWarnings(ctx)
+		if len(warnings) == 0 {
+			w.warnings.Delete(connectionID)
+			return
+		}
+		// the data sources run the statement concurrently
+		sort.SliceStable(warnings, func(i, j int) bool {
+			return warnings[i].DataSource < warnings[j].DataSource
+		})
+		w.warnings.Store(connectionID, warnings)
+	}
+}
+
+// show answers SHOW WARNINGS with the warnings of the previous statement of the connection.
+func (w *shardWarnings) show(connectionID uint32, binary bool) *mysql.Result {
+	fields := make([]*mysql.Field, 0, len(warningColumns))
+	for _, column := range warningColumns {
+		field := &mysql.Field{Name: column, FieldType: constant.FieldTypeVarString, CharSet: constant.CharacterSetUtf8}
+		if column == "Code" {
+			field.FieldType = constant.FieldTypeLong
+			field.CharSet = constant.CharacterSetBinary
+			field.Flags = constant.NotNullFlag | constant.UnsignedFlag
+		}
+		fields = append(fields, field)
+	}
+	result := &mysql.Result{Fields: fields}
+	wi, ok := w.warnings.Load(connectionID)
+	if !ok {
+		return result
+	}
+	for _, warning := range wi.([]*proto.Warning) {
+		code := []byte(strconv.FormatUint(uint64(warning.Code), 10))
+		values := []*proto.Value{
+			stringValue(warning.Level),
+			{Typ: constant.FieldTypeLong, Len: len(code), Val: code, Raw: code},
+			stringValue(warning.Message),
+			stringValue(warning.DataSource),
+		}
+		if binary {
+			values[1].Val = int64(warning.Code)
+			result.Rows = append(result.Rows, mysql.NewBinaryRow(fields, values))
+		} else {
+			result.Rows = append(result.Rows, mysql.NewTextRow(fields, values))
+		}
+	}
+	result.AffectedRows = uint64(len(result.Rows))
+	return result
+}
+
+func (w *shardWarnings) close(connectionID uint32) {
+	w.warnings.Delete(connectionID)
+}
+
+func stringValue(s string) *proto.Value {
+	return &proto.Value{Typ: constant.FieldTypeVarString, Len: len(s), Val: []byte(s), Raw: []byte(s)}
+}
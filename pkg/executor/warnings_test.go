@@ -0,0 +1,73 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package executor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/third_party/parser"
+)
+
+func TestShardWarnings(t *testing.T) {
+	stmt, err := parser.New().ParseOneStmt("SHOW WARNINGS", "", "")
+	assert.NoError(t, err)
+	assert.True(t, isShowWarnings(stmt))
+
+	w := &shardWarnings{}
+	ctx, keep := w.collect(proto.WithConnectionID(context.Background(), 1))
+	// the data sources return their warnings concurrently
+	proto.AddShardWarnings(ctx, &proto.Warning{DataSource: "employees_1", Level: "Warning", Code: 1265,
+		Message: "Data truncated for column 'name' at row 1"})
+	proto.AddShardWarnings(ctx, &proto.Warning{DataSource: "employees_0", Level: "Note", Code: 1051,
+		Message: "Unknown table 'employees.tmp'"})
+	keep()
+
+	result := w.show(1, false)
+	assert.Equal(t, []string{"Level", "Code", "Message", "Shard"},
+		[]string{result.Fields[0].Name, result.Fields[1].Name, result.Fields[2].Name, result.Fields[3].Name})
+	assert.Equal(t, uint64(2), result.AffectedRows)
+	var rows [][]string
+	for _, row := range result.Rows {
+		values, err := row.Decode()
+		assert.NoError(t, err)
+		var columns []string
+		for _, value := range values {
+			columns = append(columns, string(value.Val.([]byte)))
+		}
+		rows = append(rows, columns)
+	}
+	assert.Equal(t, [][]string{
+		{"Note", "1051", "Unknown table 'employees.tmp'", "employees_0"},
+		{"Warning", "1265", "Data truncated for column 'name' at row 1", "employees_1"},
+	}, rows)
+
+	result = w.show(1, true)
+	values, err := result.Rows[0].(*mysql.BinaryRow).Decode()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1051), values[1].Val)
+
+	// a statement without warnings clears the warnings of the previous one
+	_, keep = w.collect(proto.WithConnectionID(context.Background(), 1))
+	keep()
+	assert.Empty(t, w.show(1, false).Rows)
+	assert.Empty(t, w.show(2, false).Rows)
+}
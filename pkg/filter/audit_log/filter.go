@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/golang-module/carbon"
 	"github.com/pkg/errors"
@@ -68,6 +69,17 @@ func (factory *_factory) NewFilter(_ string, config map[string]interface{}) (pro
 	if filterConfig.MaxAge == 0 {
 		filterConfig.MaxAge = defaultMaxAge
 	}
+	sinks := make([]*asyncSink, 0, len(filterConfig.Sinks))
+	for _, sinkConfig := range filterConfig.Sinks {
+		sink, err := newAsyncSink(sinkConfig)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	for _, sink := range sinks {
+		go sink.run()
+	}
 	logger := &lumberjack.Logger{
 		Filename:   auditLogFile(filterConfig.AuditLogDir),
 		MaxSize:    filterConfig.MaxSize,
@@ -75,7 +87,7 @@ func (factory *_factory) NewFilter(_ string, config map[string]interface{}) (pro
 		MaxAge:     filterConfig.MaxAge,
 		Compress:   filterConfig.Compress,
 	}
-	return &_filter{recordBefore: filterConfig.RecordBefore, log: logger}, nil
+	return &_filter{recordBefore: filterConfig.RecordBefore, log: logger, sinks: sinks}, nil
 }
 
 type AuditLogFilterConfig struct {
@@ -90,11 +102,14 @@ type AuditLogFilterConfig struct {
 	Compress bool `json:"compress" yaml:"compress"`
 	// RecordBefore define whether to log before or after sql execution
 	RecordBefore bool `json:"record_before" yaml:"record_before"`
+	// Sinks export the audit events besides the log file
+	Sinks []*SinkConfig `json:"sinks" yaml:"sinks"`
 }
 
 type _filter struct {
 	recordBefore bool
 	log          *lumberjack.Logger
+	sinks        []*asyncSink
 }
 
 func (f *_filter) GetKind() string {
@@ -105,73 +120,66 @@ func (f *_filter) PreHandle(ctx context.Context, conn proto.Connection) error {
 	if !f.recordBefore {
 		return nil
 	}
-	userName := proto.UserName(ctx)
-	remoteAddr := proto.RemoteAddr(ctx)
-	connectionID := proto.ConnectionID(ctx)
-	commandType := proto.CommandType(ctx)
-	sqlText := proto.SqlText(ctx)
-
-	var (
-		commandTypeStr string
-		args           strings.Builder
-		stmtNode       ast.StmtNode
-	)
-	args.WriteByte('[')
-	switch commandType {
-	case constant.ComQuery:
-		commandTypeStr = "COM_QUERY"
-		stmtNode = proto.QueryStmt(ctx)
-	case constant.ComStmtExecute:
-		commandTypeStr = "COM_STMT_EXECUTE"
-		statement := proto.PrepareStmt(ctx)
-		stmtNode = statement.StmtNode
-		for i := 0; i < len(statement.BindVars); i++ {
-			parameterID := fmt.Sprintf("v%d", i+1)
-			param := statement.BindVars[parameterID]
-			switch arg := param.(type) {
-			case []byte, string:
-				args.WriteString(fmt.Sprintf("'%s'", arg))
-			case nil:
-				args.WriteString("NULL")
-			default:
-				args.WriteString(fmt.Sprintf("'%v'", arg))
-			}
-			if i < len(statement.BindVars)-1 {
-				args.WriteByte(' ')
-			}
-		}
-	default:
+	event := newEvent(ctx)
+	if event == nil {
 		return nil
 	}
-	args.WriteByte(']')
-
-	command := misc.GetStmtLabel(stmtNode)
-	command = strings.ToUpper(command)
+	return f.record(event)
+}
 
-	if _, err := f.log.Write([]byte(fmt.Sprintf("%s,%s,%s,%v,%s,%s,%s,%s,0\n", carbon.Now(), userName, remoteAddr, connectionID,
-		commandTypeStr, command, sqlText, args.String()))); err != nil {
+func (f *_filter) PostHandle(ctx context.Context, result proto.Result, conn proto.Connection) error {
+	if f.recordBefore {
+		return nil
+	}
+	event := newEvent(ctx)
+	if event == nil {
+		return nil
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
 		return err
 	}
-	return nil
+	event.AffectedRows = affected
+	return f.record(event)
 }
 
-func (f *_filter) PostHandle(ctx context.Context, result proto.Result, conn proto.Connection) error {
+func (f *_filter) PreHandleRedis(ctx context.Context, command *proto.RedisCommand) error {
+	if !f.recordBefore {
+		return nil
+	}
+	return f.record(newRedisEvent(ctx, command))
+}
+
+func (f *_filter) PostHandleRedis(ctx context.Context, command *proto.RedisCommand, _ *proto.RedisReply) error {
 	if f.recordBefore {
 		return nil
 	}
-	userName := proto.UserName(ctx)
-	remoteAddr := proto.RemoteAddr(ctx)
-	connectionID := proto.ConnectionID(ctx)
-	commandType := proto.CommandType(ctx)
-	sqlText := proto.SqlText(ctx)
+	return f.record(newRedisEvent(ctx, command))
+}
+
+// record writes the event to the log file and hands it to the sinks.
+func (f *_filter) record(event *Event) error {
+	if _, err := f.log.Write([]byte(fmt.Sprintf("%s,%s,%s,%v,%s,%s,%s,%s,%v\n", carbon.Time2Carbon(event.Time),
+		event.User, event.RemoteIP, event.ConnectionID, event.CommandType, event.Command, event.SQL, event.Args,
+		event.AffectedRows))); err != nil {
+		return err
+	}
+	for _, sink := range f.sinks {
+		sink.write(event)
+	}
+	return nil
+}
 
+// newEvent builds the audit event of the statement, it returns nil for the commands which
+// are not audited.
+func newEvent(ctx context.Context) *Event {
 	var (
 		commandTypeStr string
 		args           strings.Builder
 		stmtNode       ast.StmtNode
 	)
 	args.WriteByte('[')
-	switch commandType {
+	switch proto.CommandType(ctx) {
 	case constant.ComQuery:
 		commandTypeStr = "COM_QUERY"
 		stmtNode = proto.QueryStmt(ctx)
@@ -199,18 +207,30 @@ func (f *_filter) PostHandle(ctx context.Context, result proto.Result, conn prot
 	}
 	args.WriteByte(']')
 
-	command := misc.GetStmtLabel(stmtNode)
-	command = strings.ToUpper(command)
-
-	affected, err := result.RowsAffected()
-	if err != nil {
-		return err
+	return &Event{
+		Time:         time.Now(),
+		User:         proto.UserName(ctx),
+		RemoteIP:     proto.RemoteIP(ctx),
+		ConnectionID: proto.ConnectionID(ctx),
+		CommandType:  commandTypeStr,
+		Command:      strings.ToUpper(misc.GetStmtLabel(stmtNode)),
+		SQL:          proto.SqlText(ctx),
+		Args:         args.String(),
 	}
-	if _, err := f.log.Write([]byte(fmt.Sprintf("%s,%s,%s,%v,%s,%s,%s,%s,%v\n", carbon.Now(), userName, remoteAddr, connectionID,
-		commandTypeStr, command, sqlText, args.String(), affected))); err != nil {
-		return err
+}
+
+// newRedisEvent builds the audit event of a redis command, the command line is recorded as the SQL.
+func newRedisEvent(ctx context.Context, command *proto.RedisCommand) *Event {
+	return &Event{
+		Time:         time.Now(),
+		User:         proto.UserName(ctx),
+		RemoteIP:     proto.RemoteIP(ctx),
+		ConnectionID: proto.ConnectionID(ctx),
+		CommandType:  "REDIS",
+		Command:      command.Name(),
+		SQL:          command.String(),
+		Args:         "[]",
 	}
-	return nil
 }
 
 func auditLogFile(dir string) string {
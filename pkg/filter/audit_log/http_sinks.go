@@ -0,0 +1,203 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package audit_log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/pkg/errors"
+)
+
+// httpSink posts the batches of events to an http endpoint.
+type httpSink struct {
+	conf   *SinkConfig
+	client *resty.Client
+}
+
+func newHttpSink(conf *SinkConfig) *httpSink {
+	return &httpSink{
+		conf:   conf,
+		client: resty.New().SetHeaders(conf.Headers),
+	}
+}
+
+// post posts the body, the responses with status codes other than 2xx are errors.
+func (s *httpSink) post(ctx context.Context, url, contentType string, body []byte) (*resty.Response, error) {
+	resp, err := s.client.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", contentType).
+		SetBody(body).
+		Post(url)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode() < 200 || resp.StatusCode() >= 300 {
+		return nil, errors.Errorf("%s responded %d: %s", url, resp.StatusCode(), resp.Body())
+	}
+	return resp, nil
+}
+
+// otlpSink exports the events as log records to an opentelemetry collector by otlp/http
+// with json encoding.
+type otlpSink struct {
+	*httpSink
+}
+
+func newOTLPSink(conf *SinkConfig) Sink {
+	return &otlpSink{httpSink: newHttpSink(conf)}
+}
+
+type otlpAttribute struct {
+	Key   string            `json:"key"`
+	Value map[string]string `json:"value"`
+}
+
+func otlpString(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: map[string]string{"stringValue": value}}
+}
+
+// otlpInt encodes an int attribute, the 64 bits integers are strings in otlp json.
+func otlpInt(key string, value uint64) otlpAttribute {
+	return otlpAttribute{Key: key, Value: map[string]string{"intValue": strconv.FormatUint(value, 10)}}
+}
+
+func (s *otlpSink) Write(ctx context.Context, events []*Event) error {
+	records := make([]interface{}, 0, len(events))
+	for _, event := range events {
+		records = append(records, map[string]interface{}{
+			"timeUnixNano":   strconv.FormatInt(event.Time.UnixNano(), 10),
+			"severityNumber": 9,
+			"severityText":   "INFO",
+			"body":           map[string]string{"stringValue": event.SQL},
+			"attributes": []otlpAttribute{
+				otlpString("db.user", event.User),
+				otlpString("net.peer.ip", event.RemoteIP),
+				otlpInt("db.connection_id", uint64(event.ConnectionID)),
+				otlpString("db.command_type", event.CommandType),
+				otlpString("db.operation", event.Command),
+				otlpString("db.statement", event.SQL),
+				otlpString("db.args", event.Args),
+				otlpInt("db.affected_rows", event.AffectedRows),
+			},
+		})
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceLogs": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []otlpAttribute{otlpString("service.name", "dbpack")},
+				},
+				"scopeLogs": []interface{}{
+					map[string]interface{}{
+						"scope":      map[string]string{"name": "dbpack/audit_log"},
+						"logRecords": records,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.post(ctx, strings.TrimSuffix(s.conf.Endpoint, "/")+"/v1/logs", "application/json", body)
+	return err
+}
+
+// kafkaSink produces the events to a kafka topic through the kafka rest proxy, the events
+// are keyed by connection id, so that the events of a connection keep their order.
+type kafkaSink struct {
+	*httpSink
+}
+
+func newKafkaSink(conf *SinkConfig) Sink {
+	return &kafkaSink{httpSink: newHttpSink(conf)}
+}
+
+func (s *kafkaSink) Write(ctx context.Context, events []*Event) error {
+	type record struct {
+		Key   string `json:"key"`
+		Value *Event `json:"value"`
+	}
+	records := make([]record, 0, len(events))
+	for _, event := range events {
+		records = append(records, record{Key: strconv.FormatUint(uint64(event.ConnectionID), 10), Value: event})
+	}
+	body, err := json.Marshal(map[string]interface{}{"records": records})
+	if err != nil {
+		return err
+	}
+	_, err = s.post(ctx, strings.TrimSuffix(s.conf.Endpoint, "/")+"/topics/"+s.conf.Topic,
+		"application/vnd.kafka.json.v2+json", body)
+	return err
+}
+
+// elasticsearchSink indexes the events by the bulk api of elasticsearch.
+type elasticsearchSink struct {
+	*httpSink
+}
+
+func newElasticsearchSink(conf *SinkConfig) Sink {
+	return &elasticsearchSink{httpSink: newHttpSink(conf)}
+}
+
+func (s *elasticsearchSink) Write(ctx context.Context, events []*Event) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	action := map[string]interface{}{"index": map[string]string{"_index": s.conf.Index}}
+	for _, event := range events {
+		if err := encoder.Encode(action); err != nil {
+			return err
+		}
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+	resp, err := s.post(ctx, strings.TrimSuffix(s.conf.Endpoint, "/")+"/_bulk", "application/x-ndjson", buf.Bytes())
+	if err != nil {
+		return err
+	}
+	result := &struct {
+		Errors bool `json:"errors"`
+		Items  []struct {
+			Index struct {
+				Status int `json:"status"`
+			} `json:"index"`
+		} `json:"items"`
+	}{}
+	if err = json.Unmarshal(resp.Body(), result); err != nil {
+		return errors.Wrap(err, "invalid bulk response")
+	}
+	if !result.Errors {
+		return nil
+	}
+	// only the failed events are retried, the indexed ones would be duplicated otherwise
+	failed := &partialError{}
+	for i, item := range result.Items {
+		if i < len(events) && item.Index.Status >= 300 {
+			failed.events = append(failed.events, events[i])
+		}
+	}
+	if len(failed.events) == 0 {
+		return errors.New("bulk response reports errors without failed items")
+	}
+	return failed
+}
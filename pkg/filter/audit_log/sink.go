@@ -0,0 +1,278 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package audit_log
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/atomic"
+
+	"github.com/cectc/dbpack/pkg/log"
+)
+
+const (
+	SinkOTLP          = "otlp"
+	SinkKafka         = "kafka"
+	SinkElasticsearch = "elasticsearch"
+
+	OverflowDrop  = "drop"
+	OverflowBlock = "block"
+
+	defaultBatchSize     = 100
+	defaultFlushInterval = time.Second
+	defaultQueueSize     = 10000
+	defaultMaxRetries    = 3
+	defaultRetryBackoff  = 100 * time.Millisecond
+	defaultSinkTimeout   = 10 * time.Second
+)
+
+// Event is an audited statement.
+type Event struct {
+	Time         time.Time `json:"time"`
+	User         string    `json:"user"`
+	RemoteIP     string    `json:"remote_ip"`
+	ConnectionID uint32    `json:"connection_id"`
+	CommandType  string    `json:"command_type"`
+	Command      string    `json:"command"`
+	SQL          string    `json:"sql"`
+	// Args are the bind args of a prepared statement, formatted as in the log file
+	Args string `json:"args"`
+	// AffectedRows is 0 if the event is recorded before the execution
+	AffectedRows uint64 `json:"affected_rows"`
+}
+
+// Sink exports batches of audit events.
+type Sink interface {
+	Write(ctx context.Context, events []*Event) error
+}
+
+// SinkConfig is the config of a sink exporting audit events.
+type SinkConfig struct {
+	// Kind is otlp, kafka or elasticsearch
+	Kind string `json:"kind" yaml:"kind"`
+	// Endpoint is the url of the otlp/http collector, the kafka rest proxy or elasticsearch
+	Endpoint string `json:"endpoint" yaml:"endpoint"`
+	// Topic is the kafka topic
+	Topic string `json:"topic" yaml:"topic"`
+	// Index is the elasticsearch index
+	Index string `json:"index" yaml:"index"`
+	// Headers are added to the requests, for example for authorization
+	Headers map[string]string `json:"headers" yaml:"headers"`
+	// Timeout is the timeout of a request, 10s by default
+	Timeout string `json:"timeout" yaml:"timeout"`
+
+	// BatchSize is the maximum number of the events sent by one request, 100 by default
+	BatchSize int `json:"batch_size" yaml:"batch_size"`
+	// FlushInterval is the maximum time an event waits for its batch, 1s by default
+	FlushInterval string `json:"flush_interval" yaml:"flush_interval"`
+	// MaxRetries is the number of retries of a failed batch, 3 by default
+	MaxRetries int `json:"max_retries" yaml:"max_retries"`
+	// RetryBackoff is the backoff before the first retry, doubled by each retry, 100ms by default
+	RetryBackoff string `json:"retry_backoff" yaml:"retry_backoff"`
+	// QueueSize is the maximum number of the events waiting to be sent, 10000 by default
+	QueueSize int `json:"queue_size" yaml:"queue_size"`
+	// Overflow decides what happens to the events once the queue is full, drop discards
+	// them, block makes the statements wait until the sink catches up. drop by default.
+	Overflow string `json:"overflow" yaml:"overflow"`
+
+	timeout       time.Duration
+	flushInterval time.Duration
+	retryBackoff  time.Duration
+}
+
+func (conf *SinkConfig) validate() error {
+	if conf.Endpoint == "" {
+		return errors.Errorf("audit log sink %s must have an endpoint", conf.Kind)
+	}
+	durations := []struct {
+		name     string
+		value    string
+		target   *time.Duration
+		fallback time.Duration
+	}{
+		{"timeout", conf.Timeout, &conf.timeout, defaultSinkTimeout},
+		{"flush_interval", conf.FlushInterval, &conf.flushInterval, defaultFlushInterval},
+		{"retry_backoff", conf.RetryBackoff, &conf.retryBackoff, defaultRetryBackoff},
+	}
+	for _, d := range durations {
+		*d.target = d.fallback
+		if d.value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(d.value)
+		if err != nil {
+			return errors.Wrapf(err, "invalid %s of audit log sink %s", d.name, conf.Kind)
+		}
+		if duration <= 0 {
+			return errors.Errorf("%s of audit log sink %s must be positive, got '%s'", d.name, conf.Kind, d.value)
+		}
+		*d.target = duration
+	}
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = defaultBatchSize
+	}
+	if conf.QueueSize <= 0 {
+		conf.QueueSize = defaultQueueSize
+	}
+	if conf.MaxRetries < 0 {
+		return errors.Errorf("max_retries of audit log sink %s must not be negative", conf.Kind)
+	}
+	if conf.MaxRetries == 0 {
+		conf.MaxRetries = defaultMaxRetries
+	}
+	switch conf.Overflow {
+	case "":
+		conf.Overflow = OverflowDrop
+	case OverflowDrop, OverflowBlock:
+	default:
+		return errors.Errorf("overflow of audit log sink %s should be drop or block, got '%s'", conf.Kind, conf.Overflow)
+	}
+	return nil
+}
+
+func newSink(conf *SinkConfig) (Sink, error) {
+	switch conf.Kind {
+	case SinkOTLP:
+		return newOTLPSink(conf), nil
+	case SinkKafka:
+		if conf.Topic == "" {
+			return nil, errors.New("kafka audit log sink must have a topic")
+		}
+		return newKafkaSink(conf), nil
+	case SinkElasticsearch:
+		if conf.Index == "" {
+			return nil, errors.New("elasticsearch audit log sink must have an index")
+		}
+		return newElasticsearchSink(conf), nil
+	default:
+		return nil, errors.Errorf("unsupported audit log sink %s", conf.Kind)
+	}
+}
+
+// partialError is returned by a sink which failed to send some of the events of a batch,
+// only these events are retried.
+type partialError struct {
+	events []*Event
+}
+
+func (e *partialError) Error() string {
+	return fmt.Sprintf("%d events failed to be sent", len(e.events))
+}
+
+// asyncSink queues the events and sends them to the sink in batches, the failed batches are
+// retried with exponential backoff.
+type asyncSink struct {
+	conf    *SinkConfig
+	sink    Sink
+	events  chan *Event
+	dropped *atomic.Int64
+	// done is closed once run returns
+	done chan struct{}
+}
+
+func newAsyncSink(conf *SinkConfig) (*asyncSink, error) {
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+	sink, err := newSink(conf)
+	if err != nil {
+		return nil, err
+	}
+	return &asyncSink{
+		conf:    conf,
+		sink:    sink,
+		events:  make(chan *Event, conf.QueueSize),
+		dropped: atomic.NewInt64(0),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+func (s *asyncSink) write(event *Event) {
+	if s.conf.Overflow == OverflowBlock {
+		s.events <- event
+		return
+	}
+	select {
+	case s.events <- event:
+	default:
+		s.dropped.Inc()
+	}
+}
+
+// run sends the queued events until the queue is closed.
+func (s *asyncSink) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.conf.flushInterval)
+	defer ticker.Stop()
+	batch := make([]*Event, 0, s.conf.BatchSize)
+	for {
+		select {
+		case event, ok := <-s.events:
+			if !ok {
+				s.flush(batch)
+				return
+			}
+			batch = append(batch, event)
+			if len(batch) >= s.conf.BatchSize {
+				s.flush(batch)
+				batch = make([]*Event, 0, s.conf.BatchSize)
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				s.flush(batch)
+				batch = make([]*Event, 0, s.conf.BatchSize)
+			}
+		}
+	}
+}
+
+// close stops the sink after the queued events are sent.
+func (s *asyncSink) close() {
+	close(s.events)
+	<-s.done
+}
+
+func (s *asyncSink) flush(batch []*Event) {
+	if dropped := s.dropped.Swap(0); dropped > 0 {
+		log.Warnf("audit log sink %s dropped %d events, the queue is full", s.conf.Kind, dropped)
+	}
+	if len(batch) == 0 {
+		return
+	}
+	backoff := s.conf.retryBackoff
+	for retries := 0; ; retries++ {
+		ctx, cancel := context.WithTimeout(context.Background(), s.conf.timeout)
+		err := s.sink.Write(ctx, batch)
+		cancel()
+		if err == nil {
+			return
+		}
+		if partial, ok := err.(*partialError); ok {
+			batch = partial.events
+		}
+		if retries >= s.conf.MaxRetries {
+			log.Errorf("audit log sink %s failed to send %d events: %v", s.conf.Kind, len(batch), err)
+			return
+		}
+		log.Warnf("audit log sink %s failed to send %d events, retry in %s: %v", s.conf.Kind, len(batch), backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
@@ -0,0 +1,222 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package audit_log
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func newEvents(n int) []*Event {
+	events := make([]*Event, 0, n)
+	for i := 0; i < n; i++ {
+		events = append(events, &Event{
+			Time:         time.Unix(1660000000, 0),
+			User:         "dksl",
+			RemoteIP:     "127.0.0.1",
+			ConnectionID: uint32(i + 1),
+			CommandType:  "COM_QUERY",
+			Command:      "SELECT",
+			SQL:          "select * from employees",
+			Args:         "[]",
+		})
+	}
+	return events
+}
+
+func TestHttpSinks(t *testing.T) {
+	type request struct {
+		path        string
+		contentType string
+		auth        string
+		body        string
+	}
+	var requests []request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, request{
+			path:        r.URL.Path,
+			contentType: r.Header.Get("Content-Type"),
+			auth:        r.Header.Get("Authorization"),
+			body:        string(body),
+		})
+		if r.URL.Path == "/_bulk" {
+			_, _ = w.Write([]byte(`{"errors":false,"items":[{"index":{"status":201}},{"index":{"status":201}}]}`))
+		}
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		conf   *SinkConfig
+		expect func(t *testing.T, r request)
+	}{
+		{
+			conf: &SinkConfig{Kind: SinkOTLP, Endpoint: server.URL},
+			expect: func(t *testing.T, r request) {
+				assert.Equal(t, "/v1/logs", r.path)
+				assert.Equal(t, "application/json", r.contentType)
+				var body map[string]interface{}
+				assert.Nil(t, json.Unmarshal([]byte(r.body), &body))
+				records := body["resourceLogs"].([]interface{})[0].(map[string]interface{})["scopeLogs"].([]interface{})[0].(map[string]interface{})["logRecords"].([]interface{})
+				assert.Len(t, records, 2)
+				assert.Equal(t, "1660000000000000000", records[0].(map[string]interface{})["timeUnixNano"])
+			},
+		},
+		{
+			conf: &SinkConfig{Kind: SinkKafka, Endpoint: server.URL, Topic: "audit",
+				Headers: map[string]string{"Authorization": "Basic ZGJwYWNr"}},
+			expect: func(t *testing.T, r request) {
+				assert.Equal(t, "/topics/audit", r.path)
+				assert.Equal(t, "application/vnd.kafka.json.v2+json", r.contentType)
+				assert.Equal(t, "Basic ZGJwYWNr", r.auth)
+				var body struct {
+					Records []struct {
+						Key   string `json:"key"`
+						Value *Event `json:"value"`
+					} `json:"records"`
+				}
+				assert.Nil(t, json.Unmarshal([]byte(r.body), &body))
+				assert.Len(t, body.Records, 2)
+				assert.Equal(t, "2", body.Records[1].Key)
+				assert.Equal(t, "select * from employees", body.Records[1].Value.SQL)
+			},
+		},
+		{
+			conf: &SinkConfig{Kind: SinkElasticsearch, Endpoint: server.URL, Index: "dbpack-audit"},
+			expect: func(t *testing.T, r request) {
+				assert.Equal(t, "/_bulk", r.path)
+				assert.Equal(t, "application/x-ndjson", r.contentType)
+				lines := strings.Split(strings.TrimSpace(r.body), "\n")
+				assert.Len(t, lines, 4)
+				assert.Equal(t, `{"index":{"_index":"dbpack-audit"}}`, lines[0])
+			},
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.conf.Kind, func(t *testing.T) {
+			requests = nil
+			assert.Nil(t, c.conf.validate())
+			sink, err := newSink(c.conf)
+			assert.Nil(t, err)
+			assert.Nil(t, sink.Write(context.Background(), newEvents(2)))
+			assert.Len(t, requests, 1)
+			c.expect(t, requests[0])
+		})
+	}
+}
+
+type mockSink struct {
+	lock    sync.Mutex
+	batches [][]*Event
+	// failures is the number of the writes failing before the first successful one
+	failures int
+	err      error
+}
+
+func (s *mockSink) Write(ctx context.Context, events []*Event) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return s.err
+	}
+	s.batches = append(s.batches, events)
+	return nil
+}
+
+func TestAsyncSink(t *testing.T) {
+	events := newEvents(5)
+	testCases := []struct {
+		name    string
+		conf    *SinkConfig
+		sink    *mockSink
+		expect  [][]*Event
+		dropped int64
+	}{
+		{
+			name:   "batch",
+			conf:   &SinkConfig{Kind: SinkOTLP, Endpoint: "http://collector", BatchSize: 2},
+			sink:   &mockSink{},
+			expect: [][]*Event{events[:2], events[2:4], events[4:]},
+		},
+		{
+			name:   "retry",
+			conf:   &SinkConfig{Kind: SinkOTLP, Endpoint: "http://collector", BatchSize: 5, RetryBackoff: "1ms"},
+			sink:   &mockSink{failures: 2, err: errors.New("unavailable")},
+			expect: [][]*Event{events},
+		},
+		{
+			name:   "retry failed events",
+			conf:   &SinkConfig{Kind: SinkOTLP, Endpoint: "http://collector", BatchSize: 5, RetryBackoff: "1ms"},
+			sink:   &mockSink{failures: 1, err: &partialError{events: events[3:]}},
+			expect: [][]*Event{events[3:]},
+		},
+		{
+			name:   "give up",
+			conf:   &SinkConfig{Kind: SinkOTLP, Endpoint: "http://collector", BatchSize: 5, MaxRetries: 1, RetryBackoff: "1ms"},
+			sink:   &mockSink{failures: 2, err: errors.New("unavailable")},
+			expect: nil,
+		},
+		{
+			name:    "drop",
+			conf:    &SinkConfig{Kind: SinkOTLP, Endpoint: "http://collector", BatchSize: 5, QueueSize: 3},
+			sink:    &mockSink{},
+			expect:  [][]*Event{events[:3]},
+			dropped: 2,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			s, err := newAsyncSink(c.conf)
+			assert.Nil(t, err)
+			s.sink = c.sink
+			// the events are queued before the sink runs, so that the queue overflows
+			for _, event := range events {
+				s.write(event)
+			}
+			assert.Equal(t, c.dropped, s.dropped.Load())
+			go s.run()
+			s.close()
+			assert.Equal(t, c.expect, c.sink.batches)
+		})
+	}
+}
+
+func TestSinkConfig(t *testing.T) {
+	testCases := []*SinkConfig{
+		{Kind: SinkOTLP},
+		{Kind: SinkOTLP, Endpoint: "http://collector", FlushInterval: "1"},
+		{Kind: SinkOTLP, Endpoint: "http://collector", Overflow: "wait"},
+		{Kind: SinkKafka, Endpoint: "http://rest-proxy"},
+		{Kind: SinkElasticsearch, Endpoint: "http://elasticsearch"},
+		{Kind: "syslog", Endpoint: "udp://127.0.0.1:514"},
+	}
+	for _, conf := range testCases {
+		_, err := newAsyncSink(conf)
+		assert.Error(t, err)
+	}
+}
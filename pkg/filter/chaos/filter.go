@@ -0,0 +1,223 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package chaos
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	err2 "github.com/cectc/dbpack/pkg/errors"
+	"github.com/cectc/dbpack/pkg/filter"
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+const chaosFilter = "ChaosFilter"
+
+// Injector is implemented by the chaos filter, the admin api uses it to
+// inspect and change the injected faults at runtime.
+type Injector interface {
+	proto.Filter
+	Config() *Config
+	Update(conf *Config) error
+}
+
+// Config describes the faults injected into backend interactions. Every fault
+// fires with its own percentage, a fault without percentage never fires.
+type Config struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// DataSources limits the faults to the named data sources, empty means all.
+	DataSources []string `yaml:"data_sources" json:"data_sources,omitempty"`
+
+	Latency   *LatencyFault `yaml:"latency" json:"latency,omitempty"`
+	Error     *ErrorFault   `yaml:"error" json:"error,omitempty"`
+	Reset     *Fault        `yaml:"reset" json:"reset,omitempty"`
+	Duplicate *Fault        `yaml:"duplicate" json:"duplicate,omitempty"`
+}
+
+type Fault struct {
+	Percentage float64 `yaml:"percentage" json:"percentage"`
+}
+
+// LatencyFault delays the backend interaction.
+type LatencyFault struct {
+	Fault `yaml:",inline"`
+	Delay string `yaml:"delay" json:"delay"`
+
+	delay time.Duration
+}
+
+// ErrorFault fails the backend interaction with the configured mysql error.
+type ErrorFault struct {
+	Fault    `yaml:",inline"`
+	Code     int    `yaml:"code" json:"code"`
+	SQLState string `yaml:"sql_state" json:"sql_state,omitempty"`
+	Message  string `yaml:"message" json:"message,omitempty"`
+}
+
+func (conf *Config) validate() error {
+	faults := []*Fault{conf.Reset, conf.Duplicate}
+	if conf.Latency != nil {
+		delay, err := time.ParseDuration(conf.Latency.Delay)
+		if err != nil {
+			return errors.Wrapf(err, "invalid latency delay '%s'", conf.Latency.Delay)
+		}
+		if delay < 0 {
+			return errors.Errorf("latency delay must not be negative, got '%s'", conf.Latency.Delay)
+		}
+		conf.Latency.delay = delay
+		faults = append(faults, &conf.Latency.Fault)
+	}
+	if conf.Error != nil {
+		if conf.Error.Code <= 0 {
+			return errors.Errorf("invalid error code %d", conf.Error.Code)
+		}
+		if conf.Error.SQLState == "" {
+			conf.Error.SQLState = constant.SSUnknownSQLState
+		}
+		if conf.Error.Message == "" {
+			conf.Error.Message = "error injected by chaos filter"
+		}
+		faults = append(faults, &conf.Error.Fault)
+	}
+	for _, fault := range faults {
+		if fault != nil && (fault.Percentage < 0 || fault.Percentage > 100) {
+			return errors.Errorf("fault percentage must be between 0 and 100, got %v", fault.Percentage)
+		}
+	}
+	return nil
+}
+
+type _factory struct{}
+
+func (factory *_factory) NewFilter(_ string, config map[string]interface{}) (proto.Filter, error) {
+	var (
+		err     error
+		content []byte
+		conf    *Config
+	)
+	if content, err = json.Marshal(config); err != nil {
+		return nil, errors.Wrap(err, "marshal chaos filter config failed.")
+	}
+	if err = json.Unmarshal(content, &conf); err != nil {
+		log.Errorf("unmarshal chaos filter failed, %v", err)
+		return nil, err
+	}
+	if conf == nil {
+		conf = &Config{}
+	}
+	if err = conf.validate(); err != nil {
+		return nil, err
+	}
+	return &_filter{conf: conf, random: rand.Float64}, nil
+}
+
+type _filter struct {
+	lock sync.RWMutex
+	conf *Config
+	// random returns a number in [0.0, 1.0)
+	random func() float64
+}
+
+// executor replays statements on backend connections.
+type executor interface {
+	Execute(ctx context.Context, query string, wantFields bool) (*mysql.Result, error)
+}
+
+func (f *_filter) GetKind() string {
+	return chaosFilter
+}
+
+func (f *_filter) Config() *Config {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.conf
+}
+
+func (f *_filter) Update(conf *Config) error {
+	if err := conf.validate(); err != nil {
+		return err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.conf = conf
+	return nil
+}
+
+// PreHandle injects the configured faults before a statement is sent to the backend connection.
+func (f *_filter) PreHandle(ctx context.Context, conn proto.Connection) error {
+	conf := f.Config()
+	if !conf.Enabled || !conf.matches(conn.DataSourceName()) {
+		return nil
+	}
+
+	if conf.Latency != nil && f.fire(&conf.Latency.Fault) {
+		timer := time.NewTimer(conf.Latency.delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+	if conf.Reset != nil && f.fire(conf.Reset) {
+		conn.Close()
+		return err2.NewSQLError(constant.CRServerLost, constant.SSUnknownSQLState,
+			"Lost connection to MySQL server during query, connection reset by chaos filter")
+	}
+	if conf.Error != nil && f.fire(&conf.Error.Fault) {
+		return err2.NewSQLError(conf.Error.Code, conf.Error.SQLState, conf.Error.Message)
+	}
+	if conf.Duplicate != nil && f.fire(conf.Duplicate) {
+		// only text queries carry their statement on the context, so they are the
+		// only ones the backend can receive twice
+		query := proto.SqlText(ctx)
+		if e, ok := conn.(executor); ok && query != "" && proto.CommandType(ctx) == constant.ComQuery {
+			if _, err := e.Execute(ctx, query, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (f *_filter) fire(fault *Fault) bool {
+	return fault.Percentage > 0 && f.random()*100 < fault.Percentage
+}
+
+func (conf *Config) matches(dataSourceName string) bool {
+	if len(conf.DataSources) == 0 {
+		return true
+	}
+	for _, name := range conf.DataSources {
+		if name == dataSourceName {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	filter.RegistryFilterFactory(chaosFilter, &_factory{})
+}
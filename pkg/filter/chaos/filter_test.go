@@ -0,0 +1,186 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package chaos
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	err2 "github.com/cectc/dbpack/pkg/errors"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+type mockConnection struct {
+	closed  bool
+	queries []string
+}
+
+func (conn *mockConnection) DataSourceName() string {
+	return "employees"
+}
+
+func (conn *mockConnection) Connect(ctx context.Context) error {
+	return nil
+}
+
+func (conn *mockConnection) Close() {
+	conn.closed = true
+}
+
+func (conn *mockConnection) Execute(ctx context.Context, query string, wantFields bool) (*mysql.Result, error) {
+	conn.queries = append(conn.queries, query)
+	return &mysql.Result{}, nil
+}
+
+func TestNewFilter(t *testing.T) {
+	testCases := []struct {
+		config    map[string]interface{}
+		expectErr bool
+	}{
+		{
+			config: map[string]interface{}{
+				"enabled": true,
+				"latency": map[string]interface{}{"percentage": 50, "delay": "100ms"},
+				"error":   map[string]interface{}{"percentage": 10, "code": 1213},
+			},
+		},
+		{
+			config: map[string]interface{}{
+				"latency": map[string]interface{}{"percentage": 50, "delay": "soon"},
+			},
+			expectErr: true,
+		},
+		{
+			config: map[string]interface{}{
+				"error": map[string]interface{}{"percentage": 50},
+			},
+			expectErr: true,
+		},
+		{
+			config: map[string]interface{}{
+				"reset": map[string]interface{}{"percentage": 120},
+			},
+			expectErr: true,
+		},
+	}
+	for _, c := range testCases {
+		f, err := (&_factory{}).NewFilter("svc", c.config)
+		if c.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		conf := f.(Injector).Config()
+		assert.Equal(t, 100*time.Millisecond, conf.Latency.delay)
+		assert.Equal(t, constant.SSUnknownSQLState, conf.Error.SQLState)
+	}
+}
+
+func TestPreHandle(t *testing.T) {
+	ctx := proto.WithCommandType(context.Background(), constant.ComQuery)
+	ctx = proto.WithSqlText(ctx, "INSERT INTO employees (id) VALUES (1)")
+
+	testCases := []struct {
+		name       string
+		conf       *Config
+		expectErr  error
+		expectConn *mockConnection
+	}{
+		{
+			name: "disabled",
+			conf: &Config{
+				Error: &ErrorFault{Fault: Fault{Percentage: 100}, Code: constant.ERLockDeadlock},
+			},
+			expectConn: &mockConnection{},
+		},
+		{
+			name: "other data source",
+			conf: &Config{
+				Enabled:     true,
+				DataSources: []string{"departments"},
+				Error:       &ErrorFault{Fault: Fault{Percentage: 100}, Code: constant.ERLockDeadlock},
+			},
+			expectConn: &mockConnection{},
+		},
+		{
+			name: "error",
+			conf: &Config{
+				Enabled: true,
+				Error: &ErrorFault{Fault: Fault{Percentage: 100}, Code: constant.ERLockDeadlock,
+					SQLState: "40001", Message: "deadlock"},
+			},
+			expectErr:  err2.NewSQLError(constant.ERLockDeadlock, "40001", "deadlock"),
+			expectConn: &mockConnection{},
+		},
+		{
+			name: "zero percentage",
+			conf: &Config{
+				Enabled: true,
+				Error:   &ErrorFault{Fault: Fault{Percentage: 0}, Code: constant.ERLockDeadlock},
+			},
+			expectConn: &mockConnection{},
+		},
+		{
+			name: "reset",
+			conf: &Config{
+				Enabled: true,
+				Reset:   &Fault{Percentage: 100},
+			},
+			expectErr: err2.NewSQLError(constant.CRServerLost, constant.SSUnknownSQLState,
+				"Lost connection to MySQL server during query, connection reset by chaos filter"),
+			expectConn: &mockConnection{closed: true},
+		},
+		{
+			name: "duplicate",
+			conf: &Config{
+				Enabled:   true,
+				Duplicate: &Fault{Percentage: 100},
+			},
+			expectConn: &mockConnection{queries: []string{"INSERT INTO employees (id) VALUES (1)"}},
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			f := &_filter{random: func() float64 { return 0.5 }}
+			assert.NoError(t, f.Update(c.conf))
+			conn := &mockConnection{}
+			err := f.PreHandle(ctx, conn)
+			assert.Equal(t, c.expectErr, err)
+			assert.Equal(t, c.expectConn, conn)
+		})
+	}
+}
+
+func TestPreHandleLatency(t *testing.T) {
+	f := &_filter{random: func() float64 { return 0.5 }}
+	assert.NoError(t, f.Update(&Config{
+		Enabled: true,
+		Latency: &LatencyFault{Fault: Fault{Percentage: 60}, Delay: "50ms"},
+	}))
+	start := time.Now()
+	assert.NoError(t, f.PreHandle(context.Background(), &mockConnection{}))
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, f.PreHandle(ctx, &mockConnection{}))
+}
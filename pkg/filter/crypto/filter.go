@@ -18,7 +18,6 @@ package crypto
 
 import (
 	"context"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"strings"
@@ -28,7 +27,6 @@ import (
 	"github.com/cectc/dbpack/pkg/constant"
 	"github.com/cectc/dbpack/pkg/filter"
 	"github.com/cectc/dbpack/pkg/log"
-	"github.com/cectc/dbpack/pkg/misc"
 	"github.com/cectc/dbpack/pkg/mysql"
 	"github.com/cectc/dbpack/pkg/proto"
 	"github.com/cectc/dbpack/third_party/parser/ast"
@@ -53,12 +51,25 @@ func (factory *_factory) NewFilter(_ string, config map[string]interface{}) (pro
 	}
 	v := &struct {
 		ColumnCryptoList []*ColumnCrypto `yaml:"column_crypto_list" json:"column_crypto_list"`
+		KMS              *KMSConfig      `yaml:"kms" json:"kms"`
 	}{}
 	if err = json.Unmarshal(content, &v); err != nil {
 		log.Errorf("unmarshal crypto filter failed, %v", err)
 		return nil, err
 	}
-
+	var keyManager KeyManager
+	if v.KMS != nil {
+		if keyManager, err = newKeyManager(v.KMS); err != nil {
+			return nil, err
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultKMSTimeout)
+	defer cancel()
+	for _, config := range v.ColumnCryptoList {
+		if err = config.init(ctx, keyManager); err != nil {
+			return nil, err
+		}
+	}
 	return &_filter{ColumnConfigs: v.ColumnCryptoList}, nil
 }
 
@@ -69,7 +80,15 @@ type _filter struct {
 type ColumnCrypto struct {
 	Table   string
 	Columns []string
-	AesKey  string
+	// AesKey is the legacy key without version, the values encrypted by it are still
+	// decrypted after Keys are configured
+	AesKey string
+	// Keys are the versions of the data key, new values are encrypted by the latest version
+	Keys []*Key `yaml:"keys" json:"keys"`
+
+	keys map[int][]byte
+	// versions are the key versions, from the latest to the oldest
+	versions []int
 }
 
 type columnIndex struct {
@@ -153,6 +172,12 @@ func (f *_filter) PreHandle(ctx context.Context) error {
 	return nil
 }
 
+// DecodeResultRows implements proto.DBResultRowsFilter, the encrypted columns are decrypted
+// in the decoded rows.
+func (f *_filter) DecodeResultRows() bool {
+	return true
+}
+
 func (f *_filter) PostHandle(ctx context.Context, result proto.Result, err error) error {
 	if err != nil {
 		return err
@@ -304,12 +329,11 @@ func encryptInsertValues(columns []*columnIndex, config *ColumnCrypto, valueList
 			if param, ok := arg.(*driver.ValueExpr); ok {
 				value := param.GetBytes()
 				if len(value) != 0 {
-					encoded, err := misc.AesEncryptGCM(value, []byte(config.AesKey), []byte(aesIV))
+					encoded, err := config.encrypt(value)
 					if err != nil {
 						return errors.Wrapf(err, "Encryption of %s failed", column.Column)
 					}
-					val := hex.EncodeToString(encoded)
-					param.SetBytes([]byte(val))
+					param.SetBytes(encoded)
 				}
 			}
 		}
@@ -326,12 +350,11 @@ func encryptUpdateValues(updateStmt *ast.UpdateStmt, config *ColumnCrypto) error
 			if param, ok := arg.(*driver.ValueExpr); ok {
 				value := param.GetBytes()
 				if len(value) != 0 {
-					encoded, err := misc.AesEncryptGCM(value, []byte(config.AesKey), []byte(aesIV))
+					encoded, err := config.encrypt(value)
 					if err != nil {
 						return errors.Wrapf(err, "Encryption of %s failed", column.Column)
 					}
-					val := hex.EncodeToString(encoded)
-					param.SetBytes([]byte(val))
+					param.SetBytes(encoded)
 				}
 			}
 		}
@@ -345,19 +368,17 @@ func encryptBindVars(columns []*columnIndex, config *ColumnCrypto, args *map[str
 		parameterID := fmt.Sprintf("v%d", column.Index+1)
 		param := (*args)[parameterID]
 		if arg, ok := param.(string); ok {
-			encoded, err := misc.AesEncryptGCM([]byte(arg), []byte(config.AesKey), []byte(aesIV))
+			encoded, err := config.encrypt([]byte(arg))
 			if err != nil {
 				return errors.Errorf("Encryption of %s failed: %v", column.Column, err)
 			}
-			val := hex.EncodeToString(encoded)
-			(*args)[parameterID] = val
+			(*args)[parameterID] = string(encoded)
 		} else if arg, ok := param.([]byte); ok {
-			encoded, err := misc.AesEncryptGCM(arg, []byte(config.AesKey), []byte(aesIV))
+			encoded, err := config.encrypt(arg)
 			if err != nil {
 				return errors.Errorf("Encryption of %s failed: %v", column.Column, err)
 			}
-			val := hex.EncodeToString(encoded)
-			(*args)[parameterID] = []byte(val)
+			(*args)[parameterID] = encoded
 		}
 	}
 	return nil
@@ -371,10 +392,8 @@ func decryptDecodedResult(decodedResult *mysql.Result, config *ColumnCrypto, col
 				protoValue := r.Values[column.Index]
 				if protoValue != nil {
 					if originalVal, ok := protoValue.Val.([]byte); ok {
-						if n, err := hex.Decode(originalVal, originalVal); err == nil {
-							if decodedVal, err := misc.AesDecryptGCM(originalVal[:n], []byte(config.AesKey), []byte(aesIV)); err == nil {
-								r.Values[column.Index].Val = decodedVal
-							}
+						if decodedVal, err := config.decrypt(originalVal); err == nil {
+							r.Values[column.Index].Val = decodedVal
 						}
 					}
 				}
@@ -384,10 +403,8 @@ func decryptDecodedResult(decodedResult *mysql.Result, config *ColumnCrypto, col
 				protoValue := r.Values[column.Index]
 				if protoValue != nil {
 					if originalVal, ok := protoValue.Val.([]byte); ok {
-						if n, err := hex.Decode(originalVal, originalVal); err == nil {
-							if decodedVal, err := misc.AesDecryptGCM(originalVal[:n], []byte(config.AesKey), []byte(aesIV)); err == nil {
-								r.Values[column.Index].Val = decodedVal
-							}
+						if decodedVal, err := config.decrypt(originalVal); err == nil {
+							r.Values[column.Index].Val = decodedVal
 						}
 					}
 				}
@@ -0,0 +1,165 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/misc"
+)
+
+// versionSeparator separates the key version from the hex encoded cipher text, the values
+// encrypted by a versioned key are `v<version>$<hex>`, the values encrypted by the legacy
+// AesKey are plain hex, so that they can never be mistaken for each other.
+const versionSeparator = '$'
+
+// Key is a version of the data key of the encrypted columns.
+type Key struct {
+	Version int `yaml:"version" json:"version"`
+	// AesKey is the plain data key
+	AesKey string `yaml:"aeskey" json:"aeskey"`
+	// EncryptedKey is the data key encrypted by the kms of the filter, it is decrypted by the
+	// kms when the filter is created, see KMSConfig
+	EncryptedKey string `yaml:"encrypted_key" json:"encrypted_key"`
+}
+
+// init resolves the data keys of the column config, the values are encrypted by the key of
+// the latest version, and decrypted by any of the keys.
+func (config *ColumnCrypto) init(ctx context.Context, keyManager KeyManager) error {
+	config.keys = make(map[int][]byte, len(config.Keys))
+	for _, key := range config.Keys {
+		if key.Version <= 0 {
+			return errors.Errorf("key version of table %s must be positive, got %d", config.Table, key.Version)
+		}
+		if _, ok := config.keys[key.Version]; ok {
+			return errors.Errorf("duplicate key version %d of table %s", key.Version, config.Table)
+		}
+		var data []byte
+		switch {
+		case key.AesKey != "" && key.EncryptedKey != "":
+			return errors.Errorf("key version %d of table %s should have either aeskey or encrypted_key",
+				key.Version, config.Table)
+		case key.AesKey != "":
+			data = []byte(key.AesKey)
+		case key.EncryptedKey != "":
+			if keyManager == nil {
+				return errors.Errorf("key version %d of table %s is encrypted, but there is no kms configured",
+					key.Version, config.Table)
+			}
+			var err error
+			if data, err = keyManager.Decrypt(ctx, key.EncryptedKey); err != nil {
+				return errors.Wrapf(err, "failed to decrypt key version %d of table %s", key.Version, config.Table)
+			}
+		default:
+			return errors.Errorf("key version %d of table %s has no key", key.Version, config.Table)
+		}
+		if err := checkKey(data); err != nil {
+			return errors.Wrapf(err, "invalid key version %d of table %s", key.Version, config.Table)
+		}
+		config.keys[key.Version] = data
+		config.versions = append(config.versions, key.Version)
+	}
+	// the latest version goes first
+	sort.Sort(sort.Reverse(sort.IntSlice(config.versions)))
+	if len(config.versions) == 0 && config.AesKey == "" {
+		return errors.Errorf("table %s has no key", config.Table)
+	}
+	if config.AesKey != "" {
+		if err := checkKey([]byte(config.AesKey)); err != nil {
+			return errors.Wrapf(err, "invalid aeskey of table %s", config.Table)
+		}
+	}
+	return nil
+}
+
+func checkKey(key []byte) error {
+	switch len(key) {
+	case 16, 24, 32:
+		return nil
+	default:
+		return errors.Errorf("aes key should be 16, 24 or 32 bytes, got %d bytes", len(key))
+	}
+}
+
+// encrypt encrypts the value by the key of the latest version, or by the legacy AesKey if
+// there is no versioned key.
+func (config *ColumnCrypto) encrypt(value []byte) ([]byte, error) {
+	if len(config.versions) == 0 {
+		encrypted, err := misc.AesEncryptGCM(value, []byte(config.AesKey), []byte(aesIV))
+		if err != nil {
+			return nil, err
+		}
+		return []byte(hex.EncodeToString(encrypted)), nil
+	}
+	version := config.versions[0]
+	encrypted, err := misc.AesEncryptGCM(value, config.keys[version], []byte(aesIV))
+	if err != nil {
+		return nil, err
+	}
+	result := make([]byte, 0, len(encrypted)*2+8)
+	result = append(result, 'v')
+	result = strconv.AppendInt(result, int64(version), 10)
+	result = append(result, versionSeparator)
+	return append(result, hex.EncodeToString(encrypted)...), nil
+}
+
+// decrypt decrypts the value by the key of its version, the values without version are
+// decrypted by the legacy AesKey, then by the keys of all versions in turn.
+func (config *ColumnCrypto) decrypt(value []byte) ([]byte, error) {
+	if len(value) > 0 && value[0] == 'v' {
+		if i := bytes.IndexByte(value, versionSeparator); i > 0 {
+			version, err := strconv.Atoi(string(value[1:i]))
+			if err != nil {
+				return nil, errors.Errorf("invalid key version %s", value[1:i])
+			}
+			key, ok := config.keys[version]
+			if !ok {
+				return nil, errors.Errorf("unknown key version %d", version)
+			}
+			return decrypt(value[i+1:], key)
+		}
+	}
+	var err error
+	if config.AesKey != "" {
+		var decrypted []byte
+		if decrypted, err = decrypt(value, []byte(config.AesKey)); err == nil {
+			return decrypted, nil
+		}
+	}
+	for _, version := range config.versions {
+		var decrypted []byte
+		if decrypted, err = decrypt(value, config.keys[version]); err == nil {
+			return decrypted, nil
+		}
+	}
+	return nil, err
+}
+
+func decrypt(value, key []byte) ([]byte, error) {
+	encrypted := make([]byte, hex.DecodedLen(len(value)))
+	n, err := hex.Decode(encrypted, value)
+	if err != nil {
+		return nil, err
+	}
+	return misc.AesDecryptGCM(encrypted[:n], key, []byte(aesIV))
+}
@@ -0,0 +1,199 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crypto
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyRotation(t *testing.T) {
+	legacy := &ColumnCrypto{Table: "departments", Columns: []string{"dept_name"}, AesKey: "123456789abcdefg"}
+	assert.Nil(t, legacy.init(context.Background(), nil))
+	legacyValue, err := legacy.encrypt([]byte("Marketing"))
+	assert.Nil(t, err)
+
+	v1 := &ColumnCrypto{
+		Table:   "departments",
+		Columns: []string{"dept_name"},
+		AesKey:  "123456789abcdefg",
+		Keys:    []*Key{{Version: 1, AesKey: "abcdefghijklmnop"}},
+	}
+	assert.Nil(t, v1.init(context.Background(), nil))
+	v1Value, err := v1.encrypt([]byte("Finance"))
+	assert.Nil(t, err)
+	assert.Equal(t, "v1$", string(v1Value[:3]))
+
+	v2 := &ColumnCrypto{
+		Table:   "departments",
+		Columns: []string{"dept_name"},
+		AesKey:  "123456789abcdefg",
+		Keys: []*Key{
+			{Version: 2, AesKey: "0123456789abcdef0123456789abcdef"},
+			{Version: 1, AesKey: "abcdefghijklmnop"},
+		},
+	}
+	assert.Nil(t, v2.init(context.Background(), nil))
+	v2Value, err := v2.encrypt([]byte("Sales"))
+	assert.Nil(t, err)
+	assert.Equal(t, "v2$", string(v2Value[:3]))
+
+	testCases := []struct {
+		value  []byte
+		expect string
+	}{
+		{value: legacyValue, expect: "Marketing"},
+		{value: v1Value, expect: "Finance"},
+		{value: v2Value, expect: "Sales"},
+	}
+	for _, c := range testCases {
+		decrypted, err := v2.decrypt(c.value)
+		assert.Nil(t, err)
+		assert.Equal(t, c.expect, string(decrypted))
+	}
+
+	// the values of a retired key version can not be decrypted
+	_, err = legacy.decrypt(v1Value)
+	assert.Error(t, err)
+}
+
+func TestInitKeys(t *testing.T) {
+	testCases := []struct {
+		name   string
+		config *ColumnCrypto
+	}{
+		{name: "no key", config: &ColumnCrypto{Table: "departments"}},
+		{name: "invalid key size", config: &ColumnCrypto{Table: "departments", AesKey: "123"}},
+		{name: "invalid version", config: &ColumnCrypto{Table: "departments", Keys: []*Key{{AesKey: "abcdefghijklmnop"}}}},
+		{name: "duplicate version", config: &ColumnCrypto{Table: "departments", Keys: []*Key{
+			{Version: 1, AesKey: "abcdefghijklmnop"}, {Version: 1, AesKey: "123456789abcdefg"}}}},
+		{name: "no kms", config: &ColumnCrypto{Table: "departments", Keys: []*Key{{Version: 1, EncryptedKey: "vault:v1:abc"}}}},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Error(t, c.config.init(context.Background(), nil))
+		})
+	}
+}
+
+func TestKMS(t *testing.T) {
+	dataKey := []byte("0123456789abcdef0123456789abcdef")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&body))
+		switch r.URL.Path {
+		case "/v1/transit/decrypt/dbpack":
+			if r.Header.Get("X-Vault-Token") != "s.token" || body["ciphertext"] != "vault:v1:wrapped" {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"data":{"plaintext":"` + base64.StdEncoding.EncodeToString(dataKey) + `"}}`))
+		case "/v1/projects/p/locations/global/keyRings/r/cryptoKeys/dbpack:decrypt":
+			if r.Header.Get("Authorization") != "Bearer ya29.token" || body["ciphertext"] != "d3JhcHBlZA==" {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(`{"error":{"message":"permission denied"}}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"plaintext":"` + base64.StdEncoding.EncodeToString(dataKey) + `"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		name         string
+		config       map[string]interface{}
+		encryptedKey string
+		expectErr    bool
+	}{
+		{
+			name: "vault",
+			config: map[string]interface{}{
+				"kms": map[string]interface{}{
+					"kind": KMSVault,
+					"conf": map[string]interface{}{"address": server.URL, "token": "s.token", "key_name": "dbpack"},
+				},
+			},
+			encryptedKey: "vault:v1:wrapped",
+		},
+		{
+			name: "vault denied",
+			config: map[string]interface{}{
+				"kms": map[string]interface{}{
+					"kind": KMSVault,
+					"conf": map[string]interface{}{"address": server.URL, "token": "s.other", "key_name": "dbpack"},
+				},
+			},
+			encryptedKey: "vault:v1:wrapped",
+			expectErr:    true,
+		},
+		{
+			name: "gcp",
+			config: map[string]interface{}{
+				"kms": map[string]interface{}{
+					"kind": KMSGCP,
+					"conf": map[string]interface{}{
+						"endpoint":     server.URL,
+						"access_token": "ya29.token",
+						"key_name":     "projects/p/locations/global/keyRings/r/cryptoKeys/dbpack",
+					},
+				},
+			},
+			encryptedKey: "d3JhcHBlZA==",
+		},
+		{
+			name: "unsupported kms",
+			config: map[string]interface{}{
+				"kms": map[string]interface{}{"kind": "hsm"},
+			},
+			encryptedKey: "wrapped",
+			expectErr:    true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			c.config["column_crypto_list"] = []interface{}{
+				map[string]interface{}{
+					"table":   "departments",
+					"columns": []string{"dept_name"},
+					"keys":    []interface{}{map[string]interface{}{"version": 1, "encrypted_key": c.encryptedKey}},
+				},
+			}
+			f, err := (&_factory{}).NewFilter("svc", c.config)
+			if c.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			config := f.(*_filter).ColumnConfigs[0]
+			assert.Equal(t, dataKey, config.keys[1])
+			encrypted, err := config.encrypt([]byte("Sales"))
+			assert.Nil(t, err)
+			decrypted, err := config.decrypt(encrypted)
+			assert.Nil(t, err)
+			assert.Equal(t, "Sales", string(decrypted))
+		})
+	}
+}
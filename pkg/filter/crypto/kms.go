@@ -0,0 +1,208 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crypto
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/pkg/errors"
+)
+
+const (
+	KMSVault = "vault"
+	KMSGCP   = "gcp"
+
+	defaultKMSTimeout  = 10 * time.Second
+	defaultVaultMount  = "transit"
+	defaultGCPEndpoint = "https://cloudkms.googleapis.com"
+)
+
+// KeyManager decrypts the data keys encrypted by a key management service, the data keys
+// of the crypto filter are envelope encrypted by the master key kept in the kms.
+type KeyManager interface {
+	Decrypt(ctx context.Context, encryptedKey string) ([]byte, error)
+}
+
+// KeyManagerFactory creates a KeyManager from the config of the kms.
+type KeyManagerFactory func(config map[string]interface{}) (KeyManager, error)
+
+var keyManagerFactories = map[string]KeyManagerFactory{
+	KMSVault: newVaultKeyManager,
+	KMSGCP:   newGCPKeyManager,
+}
+
+// RegisterKeyManagerFactory registers the factory of the kms of kind, it must be called
+// before the filters are created.
+func RegisterKeyManagerFactory(kind string, factory KeyManagerFactory) {
+	keyManagerFactories[kind] = factory
+}
+
+// KMSConfig is the config of the kms decrypting the data keys of the crypto filter.
+type KMSConfig struct {
+	Kind   string                 `yaml:"kind" json:"kind"`
+	Config map[string]interface{} `yaml:"conf" json:"conf"`
+}
+
+func newKeyManager(conf *KMSConfig) (KeyManager, error) {
+	factory, ok := keyManagerFactories[conf.Kind]
+	if !ok {
+		return nil, errors.Errorf("unsupported kms kind %s", conf.Kind)
+	}
+	return factory(conf.Config)
+}
+
+// VaultConfig is the config of the transit secrets engine of vault, the encrypted keys are
+// the cipher texts returned by the encrypt api of it, like `vault:v1:...`.
+type VaultConfig struct {
+	Address string `json:"address"`
+	// Token is the vault token, the environment variable VAULT_TOKEN is used if it is empty
+	Token string `json:"token"`
+	// Mount is the mount path of the transit engine, transit by default
+	Mount   string `json:"mount"`
+	KeyName string `json:"key_name"`
+}
+
+type vaultKeyManager struct {
+	conf   *VaultConfig
+	client *resty.Client
+}
+
+func newVaultKeyManager(config map[string]interface{}) (KeyManager, error) {
+	conf := &VaultConfig{}
+	if err := unmarshalKMSConfig(config, conf); err != nil {
+		return nil, err
+	}
+	if conf.Address == "" {
+		conf.Address = os.Getenv("VAULT_ADDR")
+	}
+	if conf.Token == "" {
+		conf.Token = os.Getenv("VAULT_TOKEN")
+	}
+	if conf.Mount == "" {
+		conf.Mount = defaultVaultMount
+	}
+	if conf.Address == "" || conf.KeyName == "" {
+		return nil, errors.New("vault kms must have address and key_name")
+	}
+	return &vaultKeyManager{
+		conf:   conf,
+		client: resty.New().SetTimeout(defaultKMSTimeout),
+	}, nil
+}
+
+func (manager *vaultKeyManager) Decrypt(ctx context.Context, encryptedKey string) ([]byte, error) {
+	result := &struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+		Errors []string `json:"errors"`
+	}{}
+	resp, err := manager.client.R().
+		SetContext(ctx).
+		SetHeader("X-Vault-Token", manager.conf.Token).
+		SetBody(map[string]string{"ciphertext": encryptedKey}).
+		Post(fmt.Sprintf("%s/v1/%s/decrypt/%s", strings.TrimSuffix(manager.conf.Address, "/"),
+			strings.Trim(manager.conf.Mount, "/"), manager.conf.KeyName))
+	if err != nil {
+		return nil, errors.Wrap(err, "vault decrypt request failed")
+	}
+	// the body is decoded whatever the content type of the response is
+	if err = json.Unmarshal(resp.Body(), result); err != nil && resp.StatusCode() == http.StatusOK {
+		return nil, errors.Wrap(err, "vault decrypt response is invalid")
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return nil, errors.Errorf("vault decrypt failed, status: %d, errors: %v", resp.StatusCode(), result.Errors)
+	}
+	return base64.StdEncoding.DecodeString(result.Data.Plaintext)
+}
+
+// GCPConfig is the config of google cloud kms, the encrypted keys are the base64 encoded
+// cipher texts returned by the encrypt api of it.
+type GCPConfig struct {
+	// KeyName is the resource name of the crypto key, like
+	// projects/<project>/locations/<location>/keyRings/<ring>/cryptoKeys/<key>
+	KeyName string `json:"key_name"`
+	// AccessToken is the oauth2 access token, the environment variable
+	// GOOGLE_OAUTH_ACCESS_TOKEN is used if it is empty
+	AccessToken string `json:"access_token"`
+	Endpoint    string `json:"endpoint"`
+}
+
+type gcpKeyManager struct {
+	conf   *GCPConfig
+	client *resty.Client
+}
+
+func newGCPKeyManager(config map[string]interface{}) (KeyManager, error) {
+	conf := &GCPConfig{}
+	if err := unmarshalKMSConfig(config, conf); err != nil {
+		return nil, err
+	}
+	if conf.AccessToken == "" {
+		conf.AccessToken = os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN")
+	}
+	if conf.Endpoint == "" {
+		conf.Endpoint = defaultGCPEndpoint
+	}
+	if conf.KeyName == "" {
+		return nil, errors.New("gcp kms must have key_name")
+	}
+	return &gcpKeyManager{
+		conf:   conf,
+		client: resty.New().SetTimeout(defaultKMSTimeout),
+	}, nil
+}
+
+func (manager *gcpKeyManager) Decrypt(ctx context.Context, encryptedKey string) ([]byte, error) {
+	result := &struct {
+		Plaintext string `json:"plaintext"`
+		Error     struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}{}
+	resp, err := manager.client.R().
+		SetContext(ctx).
+		SetAuthToken(manager.conf.AccessToken).
+		SetBody(map[string]string{"ciphertext": encryptedKey}).
+		Post(fmt.Sprintf("%s/v1/%s:decrypt", strings.TrimSuffix(manager.conf.Endpoint, "/"), manager.conf.KeyName))
+	if err != nil {
+		return nil, errors.Wrap(err, "gcp kms decrypt request failed")
+	}
+	if err = json.Unmarshal(resp.Body(), result); err != nil && resp.StatusCode() == http.StatusOK {
+		return nil, errors.Wrap(err, "gcp kms decrypt response is invalid")
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return nil, errors.Errorf("gcp kms decrypt failed, status: %d, error: %s", resp.StatusCode(), result.Error.Message)
+	}
+	return base64.StdEncoding.DecodeString(result.Plaintext)
+}
+
+func unmarshalKMSConfig(config map[string]interface{}, v interface{}) error {
+	content, err := json.Marshal(config)
+	if err != nil {
+		return errors.Wrap(err, "marshal kms config failed.")
+	}
+	return errors.Wrap(json.Unmarshal(content, v), "unmarshal kms config failed.")
+}
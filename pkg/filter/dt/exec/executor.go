@@ -44,6 +44,11 @@ type Executable interface {
 	GetTableName() string
 }
 
+type SelectForUpdateExecutor interface {
+	Executable
+	BeforeImage(ctx context.Context) (*schema.TableRecords, error)
+}
+
 func BuildUndoItem(
 	isBinary bool,
 	sqlType constant.SQLType,
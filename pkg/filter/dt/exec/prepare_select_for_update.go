@@ -49,7 +49,7 @@ func NewPrepareSelectForUpdateExecutor(
 	conn *driver.BackendConnection,
 	stmt *ast.SelectStmt,
 	args map[string]interface{},
-	result proto.Result) Executable {
+	result proto.Result) SelectForUpdateExecutor {
 	return &prepareSelectForUpdateExecutor{
 		appid:  appid,
 		conn:   conn,
@@ -63,34 +63,38 @@ func (executor *prepareSelectForUpdateExecutor) Executable(ctx context.Context,
 	spanCtx, span := tracing.GetTraceSpan(ctx, tracing.Executable)
 	defer span.End()
 
-	tableMeta, err := executor.GetTableMeta(spanCtx)
+	selectPKRows, err := executor.BeforeImage(spanCtx)
 	if err != nil {
 		return false, err
 	}
-
-	rlt := executor.result.(*mysql.Result)
-	selectPKRows := schema.BuildTableRecords(tableMeta, rlt)
 	lockKeys := schema.BuildLockKey(selectPKRows)
 	if lockKeys == "" {
 		return true, nil
-	} else {
-		var (
-			lockable bool
-			err      error
-		)
-		for i := 0; i < lockRetryTimes; i++ {
-			lockable, err = dt.GetTransactionManager(executor.appid).IsLockableWithXID(spanCtx,
-				executor.conn.DataSourceName(), lockKeys, xid)
-			if lockable && err == nil {
-				break
-			}
-			time.Sleep(lockRetryInterval)
-		}
-		if err != nil {
-			return false, err
+	}
+	var lockable bool
+	for i := 0; i < lockRetryTimes; i++ {
+		lockable, err = dt.GetTransactionManager(executor.appid).IsLockableWithXID(spanCtx,
+			executor.conn.DataSourceName(), lockKeys, xid)
+		if lockable && err == nil {
+			break
 		}
+		time.Sleep(lockRetryInterval)
+	}
+	if err != nil {
+		tracing.RecordErrorSpan(span, err)
+		return false, err
+	}
+	return lockable, nil
+}
+
+// BeforeImage returns the rows selected for update, which are the rows the global
+// transaction has to hold locks on.
+func (executor *prepareSelectForUpdateExecutor) BeforeImage(ctx context.Context) (*schema.TableRecords, error) {
+	tableMeta, err := executor.GetTableMeta(ctx)
+	if err != nil {
+		return nil, err
 	}
-	return true, err
+	return schema.BuildTableRecords(tableMeta, executor.result.(*mysql.Result)), nil
 }
 
 func (executor *prepareSelectForUpdateExecutor) GetTableMeta(ctx context.Context) (schema.TableMeta, error) {
@@ -99,6 +99,9 @@ func TestQuerySelectForUpdate(t *testing.T) {
 			assert.Equal(t, c.expectedTableName, tableName)
 			_, executeErr := executor.Executable(ctx, c.xid, c.lockInterval, c.lockTimes)
 			assert.Equal(t, c.expectedErr, executeErr)
+			beforeImage, err := executor.BeforeImage(ctx)
+			assert.Nil(t, err)
+			assert.Equal(t, "t:10", schema.BuildLockKey(beforeImage))
 		})
 	}
 }
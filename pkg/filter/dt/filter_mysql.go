@@ -29,10 +29,14 @@ import (
 	"github.com/cectc/dbpack/pkg/driver"
 	"github.com/cectc/dbpack/pkg/dt"
 	"github.com/cectc/dbpack/pkg/dt/api"
+	"github.com/cectc/dbpack/pkg/dt/schema"
 	err2 "github.com/cectc/dbpack/pkg/errors"
 	"github.com/cectc/dbpack/pkg/filter"
+	"github.com/cectc/dbpack/pkg/filter/dt/exec"
 	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/misc"
 	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/sql"
 	"github.com/cectc/dbpack/third_party/parser/ast"
 )
 
@@ -148,7 +152,7 @@ func (f *_mysqlFilter) PostHandle(ctx context.Context, result proto.Result, conn
 		case *ast.UpdateStmt:
 			err = f.processAfterQueryUpdate(spanCtx, bc, stmtNode)
 		case *ast.SelectStmt:
-			if stmtNode.LockInfo != nil && stmtNode.LockInfo.LockType == ast.SelectLockForUpdate {
+			if misc.IsLockingRead(stmtNode) {
 				err = f.processQuerySelectForUpdate(ctx, bc, result, stmtNode)
 			}
 		default:
@@ -167,7 +171,7 @@ func (f *_mysqlFilter) PostHandle(ctx context.Context, result proto.Result, conn
 		case *ast.UpdateStmt:
 			err = f.processAfterPrepareUpdate(spanCtx, bc, stmt, stmtNode)
 		case *ast.SelectStmt:
-			if stmtNode.LockInfo != nil && stmtNode.LockInfo.LockType == ast.SelectLockForUpdate {
+			if misc.IsLockingRead(stmtNode) {
 				err = f.processPrepareSelectForUpdate(spanCtx, bc, result, stmt, stmtNode)
 			}
 		default:
@@ -179,7 +183,39 @@ func (f *_mysqlFilter) PostHandle(ctx context.Context, result proto.Result, conn
 	return err
 }
 
-func (f *_mysqlFilter) registerBranchTransaction(ctx context.Context, xid, resourceID, lockKey string) (int64, error) {
+// lockSelectedRows checks the global lock of the rows returned by a locking read and
+// registers a branch transaction holding them, so that no other global transaction
+// can modify them before xid completes.
+func (f *_mysqlFilter) lockSelectedRows(ctx context.Context, conn *driver.BackendConnection,
+	xid string, executor exec.SelectForUpdateExecutor) error {
+	lockable, err := executor.Executable(ctx, xid, f.lockRetryInterval, f.lockRetryTimes)
+	if err != nil {
+		return err
+	}
+	if !lockable {
+		return errors.New("resource locked by distributed transaction global lock!")
+	}
+	beforeImage, err := executor.BeforeImage(ctx)
+	if err != nil {
+		return err
+	}
+	lockKeys := schema.BuildLockKey(beforeImage)
+	if lockKeys == "" {
+		return nil
+	}
+	log.Debugf("select for update, lockKey: %s", lockKeys)
+	branchID, err := f.registerBranchTransaction(ctx, xid, conn, lockKeys)
+	if err != nil {
+		return err
+	}
+	log.Debugf("select for update, branch id: %d", branchID)
+	return nil
+}
+
+// registerBranchTransaction registers the branch of xid run on conn, and records that conn serves the
+// branch, to trace its statements of the backend logs back to the global transaction.
+func (f *_mysqlFilter) registerBranchTransaction(ctx context.Context, xid string, conn *driver.BackendConnection,
+	lockKey string) (int64, error) {
 	var (
 		branchID int64
 		err      error
@@ -189,7 +225,7 @@ func (f *_mysqlFilter) registerBranchTransaction(ctx context.Context, xid, resou
 
 	br := &api.BranchRegisterRequest{
 		XID:             xid,
-		ResourceID:      resourceID,
+		ResourceID:      conn.DataSourceName(),
 		LockKey:         lockKey,
 		BranchType:      api.AT,
 		ApplicationData: nil,
@@ -207,6 +243,9 @@ func (f *_mysqlFilter) registerBranchTransaction(ctx context.Context, xid, resou
 			break
 		}
 	}
+	if err == nil {
+		sql.BindXID(conn.DataSourceName(), conn.ID(), xid)
+	}
 	return branchID, err
 }
 
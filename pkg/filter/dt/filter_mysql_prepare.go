@@ -106,7 +106,7 @@ func (f *_mysqlFilter) processAfterPrepareDelete(ctx context.Context, conn *driv
 	log.Debugf("delete, lockKey: %s", lockKeys)
 	undoLog := exec.BuildUndoItem(true, constant.SQLType_DELETE, schemaName, executor.GetTableName(), lockKeys, biValue, nil)
 
-	branchID, err := f.registerBranchTransaction(ctx, xid, conn.DataSourceName(), lockKeys)
+	branchID, err := f.registerBranchTransaction(ctx, xid, conn, lockKeys)
 	if err != nil {
 		return err
 	}
@@ -135,7 +135,7 @@ func (f *_mysqlFilter) processAfterPrepareInsert(ctx context.Context, conn *driv
 	log.Debugf("insert, lockKey: %s", lockKeys)
 	undoLog := exec.BuildUndoItem(true, constant.SQLType_INSERT, schemaName, executor.GetTableName(), lockKeys, nil, afterImage)
 
-	branchID, err := f.registerBranchTransaction(ctx, xid, conn.DataSourceName(), lockKeys)
+	branchID, err := f.registerBranchTransaction(ctx, xid, conn, lockKeys)
 	if err != nil {
 		return err
 	}
@@ -168,7 +168,7 @@ func (f *_mysqlFilter) processAfterPrepareUpdate(ctx context.Context, conn *driv
 	log.Debugf("update, lockKey: %s", lockKeys)
 	undoLog := exec.BuildUndoItem(true, constant.SQLType_UPDATE, schemaName, executor.GetTableName(), lockKeys, beforeImage, afterImage)
 
-	branchID, err := f.registerBranchTransaction(ctx, xid, conn.DataSourceName(), lockKeys)
+	branchID, err := f.registerBranchTransaction(ctx, xid, conn, lockKeys)
 	if err != nil {
 		return err
 	}
@@ -184,6 +184,5 @@ func (f *_mysqlFilter) processPrepareSelectForUpdate(ctx context.Context, conn *
 	}
 
 	executor := exec.NewPrepareSelectForUpdateExecutor(f.applicationID, conn, selectStmt, stmt.BindVars, result)
-	_, err := executor.Executable(ctx, xid, f.lockRetryInterval, f.lockRetryTimes)
-	return err
+	return f.lockSelectedRows(ctx, conn, xid, executor)
 }
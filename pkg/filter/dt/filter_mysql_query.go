@@ -105,7 +105,7 @@ func (f *_mysqlFilter) processAfterQueryDelete(ctx context.Context, conn *driver
 	log.Debugf("delete, lockKey: %s", lockKeys)
 	undoLog := exec.BuildUndoItem(false, constant.SQLType_DELETE, schemaName, executor.GetTableName(), lockKeys, biValue, nil)
 
-	branchID, err := f.registerBranchTransaction(ctx, xid, conn.DataSourceName(), lockKeys)
+	branchID, err := f.registerBranchTransaction(ctx, xid, conn, lockKeys)
 	if err != nil {
 		return err
 	}
@@ -133,7 +133,7 @@ func (f *_mysqlFilter) processAfterQueryInsert(ctx context.Context, conn *driver
 	log.Debugf("insert, lockKey: %s", lockKeys)
 	undoLog := exec.BuildUndoItem(false, constant.SQLType_INSERT, schemaName, executor.GetTableName(), lockKeys, nil, afterImage)
 
-	branchID, err := f.registerBranchTransaction(ctx, xid, conn.DataSourceName(), lockKeys)
+	branchID, err := f.registerBranchTransaction(ctx, xid, conn, lockKeys)
 	if err != nil {
 		return err
 	}
@@ -165,7 +165,7 @@ func (f *_mysqlFilter) processAfterQueryUpdate(ctx context.Context, conn *driver
 	log.Debugf("update, lockKey: %s", lockKeys)
 	undoLog := exec.BuildUndoItem(false, constant.SQLType_UPDATE, schemaName, executor.GetTableName(), lockKeys, beforeImage, afterImage)
 
-	branchID, err := f.registerBranchTransaction(ctx, xid, conn.DataSourceName(), lockKeys)
+	branchID, err := f.registerBranchTransaction(ctx, xid, conn, lockKeys)
 	if err != nil {
 		return err
 	}
@@ -180,6 +180,5 @@ func (f *_mysqlFilter) processQuerySelectForUpdate(ctx context.Context, conn *dr
 		return nil
 	}
 	executor := exec.NewQuerySelectForUpdateExecutor(f.applicationID, conn, selectStmt, result)
-	_, err := executor.Executable(ctx, xid, f.lockRetryInterval, f.lockRetryTimes)
-	return err
+	return f.lockSelectedRows(ctx, conn, xid, executor)
 }
@@ -0,0 +1,520 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package dualwrite migrates tables from the data source of an executor to a new data source
+// through the proxy without downtime. The writes to the migrated tables are mirrored to the new
+// data source, and the reads of them are switched to it one table at a time, once the data of a
+// table is copied and verified.
+package dualwrite
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/filter"
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/resource"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+	driver "github.com/cectc/dbpack/third_party/types/parser_driver"
+)
+
+const (
+	dualWriteFilter = "DualWriteFilter"
+
+	reasonError        = "error"
+	reasonRowsAffected = "rows_affected"
+	reasonLastInsertID = "last_insert_id"
+
+	// mirrorQueueSize is the max number of mirrors waiting for the worker
+	mirrorQueueSize = 1024
+)
+
+var divergences = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "dbpack",
+	Subsystem: "dual_write",
+	Name:      "divergences_total",
+	Help:      "count of the writes mirrored to the target data source with an outcome different from the source, by the reason",
+}, []string{"target", "reason"})
+
+// Switcher is implemented by the dual write filter, the admin api uses it to inspect the
+// migrated tables and to switch their reads at runtime.
+type Switcher interface {
+	proto.Filter
+	Config() *Config
+	Update(conf *Config) error
+}
+
+// Config is the config of the dual write filter.
+type Config struct {
+	// Target is the name of the data source the tables are migrated to, it can't be updated
+	Target string `yaml:"target" json:"target"`
+	// Tables are the migrated tables, the INSERT, REPLACE, UPDATE and DELETE statements on them
+	// are mirrored to the target
+	Tables []*TableConfig `yaml:"tables" json:"tables"`
+}
+
+// TableConfig is a migrated table.
+type TableConfig struct {
+	Name string `yaml:"name" json:"name"`
+	// ReadFromTarget sends the reads of the table outside transactions to the target, a read
+	// joining tables is only sent to the target if all of them are read from it. Only single db
+	// executors route reads.
+	ReadFromTarget bool `yaml:"read_from_target" json:"read_from_target"`
+}
+
+func (conf *Config) validate() error {
+	if conf.Target == "" {
+		return errors.New("dual write filter must have a target data source")
+	}
+	if len(conf.Tables) == 0 {
+		return errors.New("dual write filter must have tables")
+	}
+	seen := make(map[string]bool, len(conf.Tables))
+	for _, table := range conf.Tables {
+		if table == nil || table.Name == "" {
+			return errors.New("dual write filter table must have a name")
+		}
+		name := strings.ToLower(table.Name)
+		if seen[name] {
+			return errors.Errorf("dual write filter table %s is duplicated", table.Name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// readFromTarget maps the lower case names of the migrated tables to whether they are read from the target.
+func (conf *Config) readFromTarget() map[string]bool {
+	tables := make(map[string]bool, len(conf.Tables))
+	for _, table := range conf.Tables {
+		tables[strings.ToLower(table.Name)] = table.ReadFromTarget
+	}
+	return tables
+}
+
+type _factory struct{}
+
+func (factory *_factory) NewFilter(appid string, config map[string]interface{}) (proto.Filter, error) {
+	var (
+		err     error
+		content []byte
+		conf    *Config
+	)
+	if content, err = json.Marshal(config); err != nil {
+		return nil, errors.Wrap(err, "marshal dual write filter config failed.")
+	}
+	if err = json.Unmarshal(content, &conf); err != nil {
+		log.Errorf("unmarshal dual write filter failed, %v", err)
+		return nil, err
+	}
+	if conf == nil {
+		return nil, errors.New("dual write filter must have a config")
+	}
+	if err = conf.validate(); err != nil {
+		return nil, err
+	}
+	f := &_filter{
+		appid:        appid,
+		conf:         conf,
+		tables:       conf.readFromTarget(),
+		transactions: make(map[uint32]*transaction),
+		mirrors:      make(chan *mirror, mirrorQueueSize),
+	}
+	go f.run()
+	return f, nil
+}
+
+// write is a statement mirrored to the target.
+type write struct {
+	sql  string
+	args []interface{}
+	// prepared is set for the executions of prepared statements, which are mirrored with their args
+	prepared bool
+	// rowsAffected and lastInsertID are the outcome of the statement on the source, they are not
+	// checked for the statements other than INSERT, REPLACE, UPDATE and DELETE
+	rowsAffected uint64
+	lastInsertID uint64
+	dml          bool
+}
+
+// transaction buffers the writes of a connection in a transaction, they are mirrored when the
+// transaction commits and discarded when it rolls back.
+type transaction struct {
+	writes []*write
+	// dml is set if one of the writes is on a migrated table
+	dml bool
+}
+
+// mirror is the writes mirrored to the target together, a statement outside transactions or a
+// committed transaction, which is mirrored in a transaction.
+type mirror struct {
+	writes      []*write
+	transaction bool
+	done        chan struct{}
+}
+
+// _filter mirrors the writes to the migrated tables after they succeeded on the source. The
+// mirrors are applied to the target by a single worker in the order the writes completed on the
+// source, and a statement returns after it is mirrored, so the writes of a connection are
+// mirrored in order and a connection reads its writes once the reads are switched. The mirror
+// failing or affecting a different number of rows than the source does not fail the statement,
+// the divergence is logged instead.
+type _filter struct {
+	appid string
+
+	lock   sync.RWMutex
+	conf   *Config
+	tables map[string]bool
+
+	transactionsLock sync.Mutex
+	// transactions are the transactions of the connections by connection id
+	transactions map[uint32]*transaction
+
+	mirrors chan *mirror
+}
+
+func (f *_filter) GetKind() string {
+	return dualWriteFilter
+}
+
+func (f *_filter) Config() *Config {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.conf
+}
+
+func (f *_filter) Update(conf *Config) error {
+	if err := conf.validate(); err != nil {
+		return err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if conf.Target != f.conf.Target {
+		return errors.Errorf("the target of dual write filter can't be changed from %s to %s", f.conf.Target, conf.Target)
+	}
+	f.conf = conf
+	f.tables = conf.readFromTarget()
+	return nil
+}
+
+// PreHandle routes the reads of the tables switched to the target.
+func (f *_filter) PreHandle(ctx context.Context) error {
+	stmt := statementNode(ctx)
+	if stmt == nil || !isRead(stmt) {
+		return nil
+	}
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	names := tableNames(stmt)
+	if len(names) == 0 {
+		return nil
+	}
+	for _, name := range names {
+		if !f.tables[name] {
+			return nil
+		}
+	}
+	proto.WithVariable(ctx, constant.DataSourceRoute, f.conf.Target)
+	return nil
+}
+
+// PostHandle mirrors the writes succeeded on the source.
+func (f *_filter) PostHandle(ctx context.Context, result proto.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	connectionID := proto.ConnectionID(ctx)
+	switch stmt := statementNode(ctx).(type) {
+	case *ast.BeginStmt:
+		f.begin(connectionID)
+	case *ast.SetStmt:
+		if startsTransaction(stmt) {
+			f.begin(connectionID)
+		}
+	case *ast.CommitStmt:
+		if tx := f.end(connectionID); tx != nil && tx.dml {
+			f.mirror(ctx, &mirror{writes: tx.writes, transaction: true})
+		}
+	case *ast.RollbackStmt:
+		if stmt.SavepointName == "" {
+			f.end(connectionID)
+		} else {
+			f.record(connectionID, newWrite(ctx, result, false))
+		}
+	case *ast.SavepointStmt, *ast.ReleaseSavepointStmt:
+		f.record(connectionID, newWrite(ctx, result, false))
+	case *ast.InsertStmt, *ast.UpdateStmt, *ast.DeleteStmt:
+		if !f.migrated(stmt) {
+			return nil
+		}
+		w := newWrite(ctx, result, true)
+		if !f.record(connectionID, w) {
+			f.mirror(ctx, &mirror{writes: []*write{w}})
+		}
+	}
+	return nil
+}
+
+// ConnectionClose discards the transaction of a closed connection, which is rolled back.
+func (f *_filter) ConnectionClose(ctx context.Context) {
+	f.end(proto.ConnectionID(ctx))
+}
+
+func (f *_filter) begin(connectionID uint32) {
+	f.transactionsLock.Lock()
+	defer f.transactionsLock.Unlock()
+	f.transactions[connectionID] = &transaction{}
+}
+
+// end removes the transaction of a connection and returns it, nil if the connection is not in a transaction.
+func (f *_filter) end(connectionID uint32) *transaction {
+	f.transactionsLock.Lock()
+	defer f.transactionsLock.Unlock()
+	tx := f.transactions[connectionID]
+	delete(f.transactions, connectionID)
+	return tx
+}
+
+// record appends w to the transaction of a connection, it returns false if the connection is not
+// in a transaction.
+func (f *_filter) record(connectionID uint32, w *write) bool {
+	f.transactionsLock.Lock()
+	defer f.transactionsLock.Unlock()
+	tx, ok := f.transactions[connectionID]
+	if !ok {
+		return false
+	}
+	tx.writes = append(tx.writes, w)
+	tx.dml = tx.dml || w.dml
+	return true
+}
+
+// migrated reports whether a write changes one of the migrated tables.
+func (f *_filter) migrated(stmt ast.StmtNode) bool {
+	var target ast.Node
+	switch s := stmt.(type) {
+	case *ast.InsertStmt:
+		target = s.Table
+	case *ast.UpdateStmt:
+		target = s.TableRefs
+	case *ast.DeleteStmt:
+		target = s.TableRefs
+		if s.IsMultiTable {
+			target = s.Tables
+		}
+	}
+	if target == nil {
+		return false
+	}
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	for _, name := range tableNames(target) {
+		if _, ok := f.tables[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// mirror queues m and waits for it to be applied, the statement returns without waiting if its
+// context is done.
+func (f *_filter) mirror(ctx context.Context, m *mirror) {
+	m.done = make(chan struct{})
+	select {
+	case f.mirrors <- m:
+	case <-ctx.Done():
+		log.Warnf("dual write of %s to %s is not mirrored, %v", m.writes[0].sql, f.Config().Target, ctx.Err())
+		return
+	}
+	select {
+	case <-m.done:
+	case <-ctx.Done():
+	}
+}
+
+func (f *_filter) run() {
+	for m := range f.mirrors {
+		f.apply(m)
+		close(m.done)
+	}
+}
+
+// session is a data source or a transaction of it.
+type session interface {
+	Query(ctx context.Context, query string) (proto.Result, uint16, error)
+	ExecuteSql(ctx context.Context, sql string, args ...interface{}) (proto.Result, uint16, error)
+}
+
+func (f *_filter) apply(m *mirror) {
+	target := f.Config().Target
+	var db proto.DB
+	if manager := resource.GetDBManager(f.appid); manager != nil {
+		db = manager.GetDB(target)
+	}
+	if db == nil {
+		f.diverge(target, reasonError, m.writes[0], fmt.Sprintf("there is no data source %s", target))
+		return
+	}
+	ctx := proto.WithCommandType(context.Background(), constant.ComQuery)
+	if !m.transaction {
+		f.execute(ctx, target, db, m.writes[0])
+		return
+	}
+	tx, _, err := db.Begin(ctx)
+	if err != nil {
+		f.diverge(target, reasonError, m.writes[0], fmt.Sprintf("begin transaction failed, %v", err))
+		return
+	}
+	for _, w := range m.writes {
+		if !f.execute(ctx, target, tx, w) {
+			if _, err = tx.Rollback(ctx, nil); err != nil {
+				log.Error(err)
+			}
+			return
+		}
+	}
+	if _, err = tx.Commit(ctx); err != nil {
+		f.diverge(target, reasonError, m.writes[len(m.writes)-1], fmt.Sprintf("commit failed, %v", err))
+	}
+}
+
+// execute mirrors w to target and checks its outcome, it returns false if w failed.
+func (f *_filter) execute(ctx context.Context, target string, s session, w *write) bool {
+	var (
+		result proto.Result
+		err    error
+	)
+	if w.prepared {
+		result, _, err = s.ExecuteSql(proto.WithCommandType(ctx, constant.ComStmtExecute), w.sql, w.args...)
+	} else {
+		result, _, err = s.Query(ctx, w.sql)
+	}
+	if err != nil {
+		f.diverge(target, reasonError, w, err.Error())
+		return false
+	}
+	if !w.dml {
+		return true
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected != w.rowsAffected {
+		f.diverge(target, reasonRowsAffected, w, fmt.Sprintf("%d rows affected, %d on the source", rowsAffected, w.rowsAffected))
+	}
+	if w.lastInsertID != 0 {
+		if lastInsertID, _ := result.LastInsertId(); lastInsertID != w.lastInsertID {
+			f.diverge(target, reasonLastInsertID, w, fmt.Sprintf("last insert id %d, %d on the source", lastInsertID, w.lastInsertID))
+		}
+	}
+	return true
+}
+
+func (f *_filter) diverge(target, reason string, w *write, detail string) {
+	divergences.WithLabelValues(target, reason).Inc()
+	log.Warnf("dual write to %s diverged, %s, sql: %s", target, detail, w.sql)
+}
+
+func newWrite(ctx context.Context, result proto.Result, dml bool) *write {
+	w := &write{dml: dml}
+	if proto.CommandType(ctx) == constant.ComStmtExecute {
+		stmt := proto.PrepareStmt(ctx)
+		w.sql, w.prepared = stmt.SqlText, true
+		w.args = make([]interface{}, 0, len(stmt.BindVars))
+		for i := 0; i < len(stmt.BindVars); i++ {
+			w.args = append(w.args, stmt.BindVars[fmt.Sprintf("v%d", i+1)])
+		}
+	} else {
+		w.sql = proto.SqlText(ctx)
+	}
+	if result != nil {
+		w.rowsAffected, _ = result.RowsAffected()
+		w.lastInsertID, _ = result.LastInsertId()
+	}
+	return w
+}
+
+// statementNode returns the statement of a query or an execution of a prepared statement.
+func statementNode(ctx context.Context) ast.StmtNode {
+	switch proto.CommandType(ctx) {
+	case constant.ComQuery:
+		return proto.QueryStmt(ctx)
+	case constant.ComStmtExecute:
+		if stmt := proto.PrepareStmt(ctx); stmt != nil {
+			return stmt.StmtNode
+		}
+	}
+	return nil
+}
+
+// isRead reports whether stmt is a SELECT or a set operation of SELECTs without locking reads.
+func isRead(stmt ast.StmtNode) bool {
+	switch s := stmt.(type) {
+	case *ast.SelectStmt:
+		return s.LockInfo == nil || s.LockInfo.LockType == ast.SelectLockNone
+	case *ast.SetOprStmt:
+		return true
+	}
+	return false
+}
+
+// startsTransaction reports whether stmt is `SET autocommit = 0`, which the executors take as
+// the start of a transaction.
+func startsTransaction(stmt *ast.SetStmt) bool {
+	if len(stmt.Variables) != 1 || !strings.EqualFold(stmt.Variables[0].Name, "autocommit") {
+		return false
+	}
+	switch value := stmt.Variables[0].Value.(type) {
+	case *driver.ValueExpr:
+		return value.GetValue() == int64(0)
+	case *ast.ColumnNameExpr:
+		return strings.EqualFold(value.Name.String(), "off")
+	}
+	return false
+}
+
+// tableNameVisitor collects the lower case names of the tables referenced by a statement.
+type tableNameVisitor struct {
+	names []string
+}
+
+func (v *tableNameVisitor) Enter(in ast.Node) (out ast.Node, skipChildren bool) {
+	if table, ok := in.(*ast.TableName); ok && table.Schema.L != "information_schema" {
+		v.names = append(v.names, table.Name.L)
+	}
+	return in, false
+}
+
+func (v *tableNameVisitor) Leave(in ast.Node) (out ast.Node, ok bool) {
+	return in, true
+}
+
+func tableNames(node ast.Node) []string {
+	v := &tableNameVisitor{}
+	node.Accept(v)
+	return v.names
+}
+
+func init() {
+	prometheus.MustRegister(divergences)
+	filter.RegistryFilterFactory(dualWriteFilter, &_factory{})
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dualwrite
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/third_party/parser"
+	_ "github.com/cectc/dbpack/third_party/types/parser_driver"
+)
+
+func TestNewFilter(t *testing.T) {
+	testCases := []struct {
+		config    map[string]interface{}
+		expectErr bool
+	}{
+		{
+			config: map[string]interface{}{
+				"target": "employees_new",
+				"tables": []interface{}{
+					map[string]interface{}{"name": "employees", "read_from_target": true},
+					map[string]interface{}{"name": "salaries"},
+				},
+			},
+		},
+		{
+			config: map[string]interface{}{
+				"tables": []interface{}{map[string]interface{}{"name": "employees"}},
+			},
+			expectErr: true,
+		},
+		{
+			config:    map[string]interface{}{"target": "employees_new"},
+			expectErr: true,
+		},
+		{
+			config: map[string]interface{}{
+				"target": "employees_new",
+				"tables": []interface{}{
+					map[string]interface{}{"name": "employees"},
+					map[string]interface{}{"name": "EMPLOYEES"},
+				},
+			},
+			expectErr: true,
+		},
+	}
+	for _, c := range testCases {
+		f, err := (&_factory{}).NewFilter("svc", c.config)
+		if c.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]bool{"employees": true, "salaries": false}, f.(*_filter).tables)
+	}
+}
+
+func TestPreHandle(t *testing.T) {
+	f, err := (&_factory{}).NewFilter("svc", map[string]interface{}{
+		"target": "employees_new",
+		"tables": []interface{}{
+			map[string]interface{}{"name": "employees", "read_from_target": true},
+			map[string]interface{}{"name": "salaries"},
+		},
+	})
+	assert.NoError(t, err)
+	switcher := f.(Switcher)
+
+	testCases := []struct {
+		sql    string
+		routed bool
+	}{
+		{sql: "select * from employees where emp_no = 1", routed: true},
+		{sql: "select * from Employees e join employees m on e.manager = m.emp_no", routed: true},
+		{sql: "select * from employees where emp_no = 1 for update"},
+		{sql: "select * from employees e join salaries s on e.emp_no = s.emp_no"},
+		{sql: "select * from salaries"},
+		{sql: "select 1"},
+		{sql: "update employees set name = 'scott' where emp_no = 1"},
+	}
+	for _, c := range testCases {
+		stmt, err := parser.New().ParseOneStmt(c.sql, "", "")
+		assert.NoError(t, err)
+		ctx := proto.WithVariableMap(context.Background())
+		ctx = proto.WithCommandType(ctx, constant.ComQuery)
+		ctx = proto.WithQueryStmt(ctx, stmt)
+		assert.NoError(t, switcher.(*_filter).PreHandle(ctx))
+		if c.routed {
+			assert.Equal(t, "employees_new", proto.Variable(ctx, constant.DataSourceRoute), c.sql)
+		} else {
+			assert.Nil(t, proto.Variable(ctx, constant.DataSourceRoute), c.sql)
+		}
+	}
+
+	// switch the reads of salaries, the target can't be changed
+	assert.Error(t, switcher.Update(&Config{Target: "employees_v2", Tables: []*TableConfig{{Name: "employees"}}}))
+	assert.NoError(t, switcher.Update(&Config{
+		Target: "employees_new",
+		Tables: []*TableConfig{{Name: "employees", ReadFromTarget: true}, {Name: "salaries", ReadFromTarget: true}},
+	}))
+	stmt, err := parser.New().ParseOneStmt("select * from employees e join salaries s on e.emp_no = s.emp_no", "", "")
+	assert.NoError(t, err)
+	ctx := proto.WithVariableMap(context.Background())
+	ctx = proto.WithCommandType(ctx, constant.ComQuery)
+	ctx = proto.WithQueryStmt(ctx, stmt)
+	assert.NoError(t, switcher.(*_filter).PreHandle(ctx))
+	assert.Equal(t, "employees_new", proto.Variable(ctx, constant.DataSourceRoute))
+}
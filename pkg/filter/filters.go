@@ -17,14 +17,20 @@
 package filter
 
 import (
-	"strings"
+	"sync"
 
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/config"
 	"github.com/cectc/dbpack/pkg/proto"
 )
 
 var (
 	filterFactories = make(map[string]proto.FilterFactory)
-	filters         = make(map[string]proto.Filter)
+
+	filtersLock sync.RWMutex
+	// filters maps application ids to their filters keyed by filter name.
+	filters = make(map[string]map[string]proto.Filter)
 )
 
 func RegistryFilterFactory(kind string, factory proto.FilterFactory) {
@@ -36,11 +42,40 @@ func GetFilterFactory(kind string) proto.FilterFactory {
 }
 
 func RegisterFilter(appid, name string, filter proto.Filter) {
-	key := strings.Join([]string{appid, name}, "-")
-	filters[key] = filter
+	filtersLock.Lock()
+	defer filtersLock.Unlock()
+	if filters[appid] == nil {
+		filters[appid] = make(map[string]proto.Filter)
+	}
+	filters[appid][name] = filter
 }
 
 func GetFilter(appid, name string) proto.Filter {
-	key := strings.Join([]string{appid, name}, "-")
-	return filters[key]
+	filtersLock.RLock()
+	defer filtersLock.RUnlock()
+	return filters[appid][name]
+}
+
+// GetFilters returns the filters of an application keyed by filter name.
+func GetFilters(appid string) map[string]proto.Filter {
+	filtersLock.RLock()
+	defer filtersLock.RUnlock()
+	result := make(map[string]proto.Filter, len(filters[appid]))
+	for name, f := range filters[appid] {
+		result[name] = f
+	}
+	return result
+}
+
+// NewFilter creates a filter from its config by the factory of its kind.
+func NewFilter(appid string, conf *config.Filter) (proto.Filter, error) {
+	factory := GetFilterFactory(conf.Kind)
+	if factory == nil {
+		return nil, errors.Errorf("there is no filter factory for filter: %s", conf.Kind)
+	}
+	f, err := factory.NewFilter(appid, conf.Config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create filter: %s", conf.Name)
+	}
+	return f, nil
 }
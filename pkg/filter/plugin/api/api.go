@@ -0,0 +1,107 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package api is the ABI between dbpack and the filters shipped as Go plugins. It only depends
+// on the standard library, so that a plugin only has to be built against this package and the
+// Go toolchain dbpack was built with.
+//
+// A plugin is a `main` package built with `go build -buildmode=plugin`, it exports a function
+// named RegisterFilters registering the factories of its filter kinds:
+//
+//	func RegisterFilters(registry api.Registry) error {
+//		return registry.RegisterFilter("MaskingFilter", newMaskingFilter)
+//	}
+//
+// The filters created by the factories implement PreFilter, PostFilter or both. RegisterFilters
+// may also register the Mergers of the aggregate functions dbpack does not merge itself.
+package api
+
+import "context"
+
+// RegisterSymbol is the name of the function a plugin exports, its type is
+// `func(api.Registry) error`.
+const RegisterSymbol = "RegisterFilters"
+
+const (
+	// CommandQuery is the command of the statements sent by COM_QUERY.
+	CommandQuery = "query"
+	// CommandExecute is the command of the prepared statements executed by COM_STMT_EXECUTE.
+	CommandExecute = "execute"
+)
+
+// Statement is the statement of a client handed to the plugin filters.
+type Statement struct {
+	ApplicationID string
+	ConnectionID  uint32
+	User          string
+	Schema        string
+	RemoteAddr    string
+	// Command is CommandQuery or CommandExecute
+	Command string
+	SQL     string
+	// Args are the bind args of a prepared statement in their order
+	Args []interface{}
+}
+
+// Result is the result of a statement handed to PostFilter.
+type Result struct {
+	AffectedRows uint64
+	LastInsertID uint64
+	Columns      []string
+	// Rows are the values of the result rows, they are only set for the filters implementing
+	// RowsFilter. The values changed by the filter are written back to the result sent to the
+	// client, NULL values are nil and stay NULL.
+	Rows [][]interface{}
+}
+
+// Factory creates a filter of an application from the config of the filter in the dbpack
+// config file.
+type Factory func(appid string, config map[string]interface{}) (interface{}, error)
+
+// Registry registers the filter kinds and the mergers of a plugin.
+type Registry interface {
+	RegisterFilter(kind string, factory Factory) error
+	// RegisterMerger registers the merger of an aggregate function, e.g. "approx_count_distinct",
+	// function names are case-insensitive.
+	RegisterMerger(function string, merger Merger) error
+}
+
+// Merger merges the values of an aggregate function column returned by the shards of a
+// scatter-gather query into the value returned to the client. Values are the column values of
+// the shards, NULL values are nil, the values of text protocol results are []byte. The merged
+// value is an int64, uint64, float64, string, []byte or nil for NULL.
+type Merger interface {
+	Merge(ctx context.Context, values []interface{}) (interface{}, error)
+}
+
+// PreFilter runs before a statement is executed, the statement is rejected with the
+// returned error.
+type PreFilter interface {
+	PreHandle(ctx context.Context, stmt *Statement) error
+}
+
+// PostFilter runs after a statement is executed, err is the error of the execution and the
+// returned error is sent to the client instead of the result. The result is nil if the
+// execution failed.
+type PostFilter interface {
+	PostHandle(ctx context.Context, stmt *Statement, result *Result, err error) error
+}
+
+// RowsFilter is a PostFilter reading the values of the result rows.
+type RowsFilter interface {
+	PostFilter
+	DecodeRows() bool
+}
@@ -0,0 +1,272 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package plugin loads the filters and mergers shipped as Go plugins, see package api for the ABI of them.
+package plugin
+
+import (
+	"context"
+	"fmt"
+	goplugin "plugin"
+
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/filter"
+	"github.com/cectc/dbpack/pkg/filter/plugin/api"
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/merger"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+// Load opens the plugin at path and registers the filter factories and the mergers of it, it must be called
+// before the filters are created.
+func Load(path string) error {
+	p, err := goplugin.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open filter plugin %s", path)
+	}
+	symbol, err := p.Lookup(api.RegisterSymbol)
+	if err != nil {
+		return errors.Wrapf(err, "failed to load filter plugin %s", path)
+	}
+	register, ok := symbol.(func(api.Registry) error)
+	if !ok {
+		return errors.Errorf("filter plugin %s: %s should be a func(api.Registry) error, but is %T",
+			path, api.RegisterSymbol, symbol)
+	}
+	if err = register(&registry{path: path}); err != nil {
+		return errors.Wrapf(err, "failed to register the filters of plugin %s", path)
+	}
+	return nil
+}
+
+type registry struct {
+	path string
+}
+
+func (r *registry) RegisterFilter(kind string, factory api.Factory) error {
+	if factory == nil {
+		return errors.Errorf("the factory of filter kind %s is nil", kind)
+	}
+	if filter.GetFilterFactory(kind) != nil {
+		return errors.Errorf("filter kind %s has been registered", kind)
+	}
+	filter.RegistryFilterFactory(kind, &_factory{kind: kind, factory: factory})
+	log.Infof("registered filter kind %s of plugin %s", kind, r.path)
+	return nil
+}
+
+func (r *registry) RegisterMerger(function string, m api.Merger) error {
+	if m == nil {
+		return errors.Errorf("the merger of aggregate function %s is nil", function)
+	}
+	if merger.GetMerger(function) != nil {
+		return errors.Errorf("merger of aggregate function %s has been registered", function)
+	}
+	merger.RegisterMerger(function, &_merger{merger: m})
+	log.Infof("registered merger of aggregate function %s of plugin %s", function, r.path)
+	return nil
+}
+
+type _merger struct {
+	merger api.Merger
+}
+
+func (m *_merger) Merge(ctx context.Context, values []*proto.Value) (interface{}, error) {
+	vals := make([]interface{}, len(values))
+	for i, value := range values {
+		if value != nil {
+			vals[i] = value.Val
+		}
+	}
+	return m.merger.Merge(ctx, vals)
+}
+
+type _factory struct {
+	kind    string
+	factory api.Factory
+}
+
+func (factory *_factory) NewFilter(appid string, config map[string]interface{}) (proto.Filter, error) {
+	f, err := factory.factory(appid, config)
+	if err != nil {
+		return nil, err
+	}
+	base := &_filter{kind: factory.kind, appid: appid}
+	preFilter, isPreFilter := f.(api.PreFilter)
+	postFilter, isPostFilter := f.(api.PostFilter)
+	var pre *_preFilter
+	if isPreFilter {
+		pre = &_preFilter{_filter: base, filter: preFilter}
+	}
+	var post *_postFilter
+	if isPostFilter {
+		post = &_postFilter{_filter: base, filter: postFilter}
+		if rowsFilter, ok := f.(api.RowsFilter); ok {
+			post.decodeRows = rowsFilter.DecodeRows()
+		}
+	}
+	switch {
+	case isPreFilter && isPostFilter:
+		return &_prePostFilter{_preFilter: pre, _postFilter: post}, nil
+	case isPreFilter:
+		return pre, nil
+	case isPostFilter:
+		return post, nil
+	default:
+		return nil, errors.Errorf("filter of kind %s implements neither api.PreFilter nor api.PostFilter", factory.kind)
+	}
+}
+
+type _filter struct {
+	kind  string
+	appid string
+}
+
+func (f *_filter) GetKind() string {
+	return f.kind
+}
+
+type _preFilter struct {
+	*_filter
+	filter api.PreFilter
+}
+
+func (f *_preFilter) PreHandle(ctx context.Context) error {
+	stmt, ok := f.statement(ctx)
+	if !ok {
+		return nil
+	}
+	return f.filter.PreHandle(ctx, stmt)
+}
+
+type _postFilter struct {
+	*_filter
+	filter     api.PostFilter
+	decodeRows bool
+}
+
+// DecodeResultRows implements proto.DBResultRowsFilter.
+func (f *_postFilter) DecodeResultRows() bool {
+	return f.decodeRows
+}
+
+func (f *_postFilter) PostHandle(ctx context.Context, result proto.Result, err error) error {
+	stmt, ok := f.statement(ctx)
+	if !ok {
+		return err
+	}
+	var rows []proto.Row
+	var res *api.Result
+	if err == nil && result != nil {
+		res, rows = f.result(result)
+	}
+	if postErr := f.filter.PostHandle(ctx, stmt, res, err); postErr != nil {
+		return postErr
+	}
+	if res != nil {
+		writeRows(rows, res.Rows)
+	}
+	return err
+}
+
+type _prePostFilter struct {
+	*_preFilter
+	*_postFilter
+}
+
+func (f *_prePostFilter) GetKind() string {
+	return f._preFilter.GetKind()
+}
+
+// statement builds the statement handed to the plugin filter, only the queries and the
+// executions of prepared statements are handed to it.
+func (f *_filter) statement(ctx context.Context) (*api.Statement, bool) {
+	stmt := &api.Statement{
+		ApplicationID: f.appid,
+		ConnectionID:  proto.ConnectionID(ctx),
+		User:          proto.UserName(ctx),
+		Schema:        proto.Schema(ctx),
+		RemoteAddr:    proto.RemoteAddr(ctx),
+		SQL:           proto.SqlText(ctx),
+	}
+	switch proto.CommandType(ctx) {
+	case constant.ComQuery:
+		stmt.Command = api.CommandQuery
+	case constant.ComStmtExecute:
+		stmt.Command = api.CommandExecute
+		if prepareStmt := proto.PrepareStmt(ctx); prepareStmt != nil {
+			stmt.Args = make([]interface{}, 0, len(prepareStmt.BindVars))
+			for i := 0; i < len(prepareStmt.BindVars); i++ {
+				stmt.Args = append(stmt.Args, prepareStmt.BindVars[fmt.Sprintf("v%d", i+1)])
+			}
+		}
+	default:
+		return nil, false
+	}
+	return stmt, true
+}
+
+// result builds the result handed to the plugin filter, the rows are only decoded for the
+// filters reading them.
+func (f *_postFilter) result(result proto.Result) (*api.Result, []proto.Row) {
+	res := &api.Result{}
+	res.AffectedRows, _ = result.RowsAffected()
+	res.LastInsertID, _ = result.LastInsertId()
+	mysqlResult, ok := result.(*mysql.Result)
+	if !ok {
+		return res, nil
+	}
+	for _, field := range mysqlResult.Fields {
+		res.Columns = append(res.Columns, field.Name)
+	}
+	if !f.decodeRows {
+		return res, nil
+	}
+	res.Rows = make([][]interface{}, 0, len(mysqlResult.Rows))
+	for _, row := range mysqlResult.Rows {
+		values, err := row.Decode()
+		if err != nil {
+			log.Errorf("failed to decode result row for filter kind %s: %v", f.kind, err)
+			return res, nil
+		}
+		rowValues := make([]interface{}, len(values))
+		for i, value := range values {
+			if value != nil {
+				rowValues[i] = value.Val
+			}
+		}
+		res.Rows = append(res.Rows, rowValues)
+	}
+	return res, mysqlResult.Rows
+}
+
+// writeRows writes the values changed by the plugin filter back to the result rows.
+func writeRows(rows []proto.Row, values [][]interface{}) {
+	for i := 0; i < len(rows) && i < len(values); i++ {
+		decoded, err := rows[i].Decode()
+		if err != nil {
+			continue
+		}
+		for j := 0; j < len(decoded) && j < len(values[i]); j++ {
+			if decoded[j] != nil && values[i][j] != nil {
+				decoded[j].Val = values[i][j]
+			}
+		}
+	}
+}
@@ -0,0 +1,165 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plugin
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/filter"
+	"github.com/cectc/dbpack/pkg/filter/plugin/api"
+	"github.com/cectc/dbpack/pkg/merger"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+// maskingFilter rejects the statements on the salaries table and masks the values of the
+// first column of the results.
+type maskingFilter struct {
+	statements []*api.Statement
+}
+
+func (f *maskingFilter) PreHandle(ctx context.Context, stmt *api.Statement) error {
+	f.statements = append(f.statements, stmt)
+	if strings.Contains(stmt.SQL, "salaries") {
+		return errors.New("access to salaries is denied")
+	}
+	return nil
+}
+
+func (f *maskingFilter) PostHandle(ctx context.Context, stmt *api.Statement, result *api.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	for _, row := range result.Rows {
+		row[0] = []byte("***")
+	}
+	return nil
+}
+
+func (f *maskingFilter) DecodeRows() bool {
+	return true
+}
+
+type noopFilter struct{}
+
+func TestRegisterFilter(t *testing.T) {
+	masking := &maskingFilter{}
+	r := &registry{path: "masking.so"}
+	err := r.RegisterFilter("PluginMaskingFilter", func(appid string, config map[string]interface{}) (interface{}, error) {
+		return masking, nil
+	})
+	assert.Nil(t, err)
+	err = r.RegisterFilter("PluginMaskingFilter", func(appid string, config map[string]interface{}) (interface{}, error) {
+		return masking, nil
+	})
+	assert.Error(t, err)
+	err = r.RegisterFilter("PluginNoopFilter", func(appid string, config map[string]interface{}) (interface{}, error) {
+		return &noopFilter{}, nil
+	})
+	assert.Nil(t, err)
+
+	_, err = filter.NewFilter("svc", &config.Filter{Name: "noop", Kind: "PluginNoopFilter"})
+	assert.Error(t, err)
+
+	f, err := filter.NewFilter("svc", &config.Filter{Name: "masking", Kind: "PluginMaskingFilter"})
+	assert.Nil(t, err)
+	assert.Equal(t, "PluginMaskingFilter", f.GetKind())
+	preFilter, ok := f.(proto.DBPreFilter)
+	assert.True(t, ok)
+	postFilter, ok := f.(proto.DBResultRowsFilter)
+	assert.True(t, ok)
+	assert.True(t, postFilter.DecodeResultRows())
+
+	ctx := proto.WithConnectionID(context.Background(), 1)
+	ctx = proto.WithUserName(ctx, "dksl")
+	ctx = proto.WithCommandType(ctx, constant.ComStmtExecute)
+	ctx = proto.WithSqlText(ctx, "select name from employees where emp_no = ? and dept_no = ?")
+	ctx = proto.WithPrepareStmt(ctx, &proto.Stmt{
+		BindVars: map[string]interface{}{"v1": int64(100001), "v2": []byte("d001")},
+	})
+	assert.Nil(t, preFilter.PreHandle(ctx))
+	assert.Len(t, masking.statements, 1)
+	assert.Equal(t, &api.Statement{
+		ApplicationID: "svc",
+		ConnectionID:  1,
+		User:          "dksl",
+		Command:       api.CommandExecute,
+		SQL:           "select name from employees where emp_no = ? and dept_no = ?",
+		Args:          []interface{}{int64(100001), []byte("d001")},
+	}, masking.statements[0])
+
+	fields := []*mysql.Field{{Name: "name", FieldType: constant.FieldTypeVarString}}
+	result := &mysql.Result{
+		Fields: fields,
+		Rows: []proto.Row{
+			mysql.NewBinaryRow(fields, []*proto.Value{{Typ: constant.FieldTypeVarString, Val: []byte("scott")}}),
+			mysql.NewBinaryRow(fields, []*proto.Value{nil}),
+		},
+	}
+	assert.Nil(t, postFilter.PostHandle(ctx, result, nil))
+	values, err := result.Rows[0].Decode()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("***"), values[0].Val)
+	values, err = result.Rows[1].Decode()
+	assert.Nil(t, err)
+	assert.Nil(t, values[0])
+
+	ctx = proto.WithCommandType(context.Background(), constant.ComQuery)
+	ctx = proto.WithSqlText(ctx, "select * from salaries")
+	assert.EqualError(t, preFilter.PreHandle(ctx), "access to salaries is denied")
+}
+
+func TestLoad(t *testing.T) {
+	err := Load("testdata/not_exist.so")
+	assert.Error(t, err)
+}
+
+type countMerger struct{}
+
+func (m *countMerger) Merge(ctx context.Context, values []interface{}) (interface{}, error) {
+	var count int64
+	for _, value := range values {
+		if value != nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func TestRegisterMerger(t *testing.T) {
+	r := &registry{path: "merger.so"}
+	assert.Error(t, r.RegisterMerger("approx_percentile", nil))
+	assert.Nil(t, r.RegisterMerger("APPROX_PERCENTILE", &countMerger{}))
+	assert.Error(t, r.RegisterMerger("approx_percentile", &countMerger{}))
+
+	m := merger.GetMerger("approx_percentile")
+	assert.NotNil(t, m)
+	merged, err := m.Merge(context.Background(), []*proto.Value{
+		{Typ: constant.FieldTypeDouble, Val: []byte("1.5")},
+		nil,
+		{Typ: constant.FieldTypeDouble, Val: []byte("2.5")},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), merged)
+}
@@ -0,0 +1,268 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package quota limits the rows and bytes each frontend user may use in a time window, on the
+// cumulative usage accounted by package usage, so that batch jobs can't monopolize the
+// backends.
+package quota
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	err2 "github.com/cectc/dbpack/pkg/errors"
+	"github.com/cectc/dbpack/pkg/filter"
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/usage"
+)
+
+const (
+	quotaFilter = "QuotaFilter"
+
+	ActionLog      = "log"
+	ActionThrottle = "throttle"
+	ActionBlock    = "block"
+
+	ResourceRowsRead      = "rows_read"
+	ResourceRowsSent      = "rows_sent"
+	ResourceBytesReceived = "bytes_received"
+	ResourceBytesSent     = "bytes_sent"
+
+	defaultThrottleDelay = 100 * time.Millisecond
+)
+
+var quotaExceeded = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "dbpack",
+	Subsystem: "quota",
+	Name:      "exceeded_total",
+	Help:      "count of the statements of the users over a quota, by the resource and the action",
+}, []string{"user", "resource", "action"})
+
+// Config is the config of the quota filter, a statement is checked against all the quotas.
+type Config struct {
+	Quotas []*QuotaConfig `yaml:"quotas" json:"quotas"`
+}
+
+// QuotaConfig limits the usage of each of the users in fixed time windows, such as at most 10M
+// rows read per hour. The limits of zero are not enforced.
+type QuotaConfig struct {
+	// Users the quota applies to each of, all the users if empty
+	Users []string `yaml:"users" json:"users"`
+	// Window is the length of the windows, such as 1h, the windows are aligned to it
+	Window        string `yaml:"window" json:"window"`
+	RowsRead      uint64 `yaml:"rows_read" json:"rows_read"`
+	RowsSent      uint64 `yaml:"rows_sent" json:"rows_sent"`
+	BytesReceived uint64 `yaml:"bytes_received" json:"bytes_received"`
+	BytesSent     uint64 `yaml:"bytes_sent" json:"bytes_sent"`
+	// Action taken on the statements of a user over the quota, log (the default) logs once a
+	// window, throttle delays each statement and block rejects the statements
+	Action string `yaml:"action" json:"action"`
+	// ThrottleDelay is the delay of the throttled statements, 100ms by default
+	ThrottleDelay string `yaml:"throttle_delay" json:"throttle_delay"`
+}
+
+type _factory struct{}
+
+func (factory *_factory) NewFilter(_ string, config map[string]interface{}) (proto.Filter, error) {
+	var (
+		err     error
+		content []byte
+		conf    *Config
+	)
+	if content, err = json.Marshal(config); err != nil {
+		return nil, errors.Wrap(err, "marshal quota filter config failed.")
+	}
+	if err = json.Unmarshal(content, &conf); err != nil {
+		log.Errorf("unmarshal quota filter failed, %v", err)
+		return nil, err
+	}
+	if conf == nil || len(conf.Quotas) == 0 {
+		return nil, errors.New("quota filter must have quotas")
+	}
+	f := &_filter{now: time.Now}
+	for i, qc := range conf.Quotas {
+		q, err := newQuota(qc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid quota %d", i)
+		}
+		f.quotas = append(f.quotas, q)
+	}
+	return f, nil
+}
+
+type quota struct {
+	users         map[string]struct{}
+	window        time.Duration
+	limits        usage.Usage
+	action        string
+	throttleDelay time.Duration
+
+	lock    sync.Mutex
+	windows map[string]*window
+}
+
+// window is the usage of a user at the start of the current window of a quota.
+type window struct {
+	start  time.Time
+	base   usage.Usage
+	logged bool
+}
+
+func newQuota(conf *QuotaConfig) (*quota, error) {
+	q := &quota{
+		limits: usage.Usage{
+			RowsRead:      conf.RowsRead,
+			RowsSent:      conf.RowsSent,
+			BytesReceived: conf.BytesReceived,
+			BytesSent:     conf.BytesSent,
+		},
+		action:        conf.Action,
+		throttleDelay: defaultThrottleDelay,
+		windows:       make(map[string]*window),
+	}
+	if q.limits == (usage.Usage{}) {
+		return nil, errors.New("quota should limit rows_read, rows_sent, bytes_received or bytes_sent")
+	}
+	var err error
+	if q.window, err = time.ParseDuration(conf.Window); err != nil {
+		return nil, errors.Wrapf(err, "invalid quota window '%s'", conf.Window)
+	}
+	if q.window <= 0 {
+		return nil, errors.Errorf("quota window must be positive, got '%s'", conf.Window)
+	}
+	switch q.action {
+	case "":
+		q.action = ActionLog
+	case ActionLog, ActionThrottle, ActionBlock:
+	default:
+		return nil, errors.Errorf("unsupported quota action '%s'", conf.Action)
+	}
+	if conf.ThrottleDelay != "" {
+		if q.throttleDelay, err = time.ParseDuration(conf.ThrottleDelay); err != nil {
+			return nil, errors.Wrapf(err, "invalid quota throttle delay '%s'", conf.ThrottleDelay)
+		}
+	}
+	if len(conf.Users) > 0 {
+		q.users = make(map[string]struct{}, len(conf.Users))
+		for _, user := range conf.Users {
+			q.users[user] = struct{}{}
+		}
+	}
+	return q, nil
+}
+
+func (q *quota) appliesTo(user string) bool {
+	if q.users == nil {
+		return true
+	}
+	_, ok := q.users[user]
+	return ok
+}
+
+// check returns the resource of the quota the user is over in the current window, with the
+// usage of it in the window. first reports whether the user is first found over the quota in
+// the window.
+func (q *quota) check(user string, current usage.Usage, now time.Time) (resource string, used uint64, first bool) {
+	start := now.Truncate(q.window)
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	w, ok := q.windows[user]
+	if !ok || !w.start.Equal(start) {
+		w = &window{start: start, base: current}
+		q.windows[user] = w
+	}
+	switch {
+	case q.limits.RowsRead > 0 && current.RowsRead-w.base.RowsRead >= q.limits.RowsRead:
+		resource, used = ResourceRowsRead, current.RowsRead-w.base.RowsRead
+	case q.limits.RowsSent > 0 && current.RowsSent-w.base.RowsSent >= q.limits.RowsSent:
+		resource, used = ResourceRowsSent, current.RowsSent-w.base.RowsSent
+	case q.limits.BytesReceived > 0 && current.BytesReceived-w.base.BytesReceived >= q.limits.BytesReceived:
+		resource, used = ResourceBytesReceived, current.BytesReceived-w.base.BytesReceived
+	case q.limits.BytesSent > 0 && current.BytesSent-w.base.BytesSent >= q.limits.BytesSent:
+		resource, used = ResourceBytesSent, current.BytesSent-w.base.BytesSent
+	default:
+		return "", 0, false
+	}
+	first = !w.logged
+	w.logged = true
+	return resource, used, first
+}
+
+type _filter struct {
+	quotas []*quota
+	now    func() time.Time
+}
+
+func (f *_filter) GetKind() string {
+	return quotaFilter
+}
+
+// PreHandle checks the usage of the user before the statement, so the statement taking a user
+// over a quota runs in full, and the quota is enforced from the next statement on.
+func (f *_filter) PreHandle(ctx context.Context) error {
+	user := proto.UserName(ctx)
+	current, ok := usage.Cumulative(user)
+	if !ok {
+		return nil
+	}
+	now := f.now()
+	var delay time.Duration
+	for _, q := range f.quotas {
+		if !q.appliesTo(user) {
+			continue
+		}
+		resource, used, first := q.check(user, current, now)
+		if resource == "" {
+			continue
+		}
+		quotaExceeded.WithLabelValues(user, resource, q.action).Inc()
+		if first {
+			log.Warnf("user %s is over the %s quota of %s, used %d, action: %s",
+				user, resource, q.window, used, q.action)
+		}
+		switch q.action {
+		case ActionBlock:
+			return err2.NewSQLError(constant.ERUserLimitReached, constant.SSUnknownSQLState,
+				"User '%s' has exceeded the '%s' resource (current value: %d)", user, resource, used)
+		case ActionThrottle:
+			if q.throttleDelay > delay {
+				delay = q.throttleDelay
+			}
+		}
+	}
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func init() {
+	prometheus.MustRegister(quotaExceeded)
+	filter.RegistryFilterFactory(quotaFilter, &_factory{})
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package quota
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	err2 "github.com/cectc/dbpack/pkg/errors"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/usage"
+)
+
+func TestNewFilter(t *testing.T) {
+	testCases := []struct {
+		name   string
+		config map[string]interface{}
+		err    string
+	}{
+		{name: "no quotas", config: map[string]interface{}{}, err: "quota filter must have quotas"},
+		{
+			name:   "no limits",
+			config: map[string]interface{}{"quotas": []interface{}{map[string]interface{}{"window": "1h"}}},
+			err:    "should limit",
+		},
+		{
+			name: "invalid window",
+			config: map[string]interface{}{"quotas": []interface{}{
+				map[string]interface{}{"window": "hour", "rows_read": 10},
+			}},
+			err: "invalid quota window",
+		},
+		{
+			name: "invalid action",
+			config: map[string]interface{}{"quotas": []interface{}{
+				map[string]interface{}{"window": "1h", "rows_read": 10, "action": "kill"},
+			}},
+			err: "unsupported quota action",
+		},
+		{
+			name: "valid",
+			config: map[string]interface{}{"quotas": []interface{}{
+				map[string]interface{}{"window": "1h", "bytes_sent": 1024, "action": "throttle", "throttle_delay": "1s"},
+			}},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := (&_factory{}).NewFilter("test", tc.config)
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestPreHandle(t *testing.T) {
+	f, err := (&_factory{}).NewFilter("test", map[string]interface{}{"quotas": []interface{}{
+		map[string]interface{}{"users": []string{"quota_block"}, "window": "1h", "rows_read": 10, "action": "block"},
+		map[string]interface{}{"users": []string{"quota_throttle"}, "window": "1h", "bytes_sent": 100,
+			"action": "throttle", "throttle_delay": "20ms"},
+		map[string]interface{}{"window": "1h", "rows_sent": 1000},
+	}})
+	assert.NoError(t, err)
+	qf := f.(*_filter)
+	now := time.Date(2022, 6, 1, 10, 30, 0, 0, time.UTC)
+	qf.now = func() time.Time { return now }
+
+	block := usage.OpenSession(1, "quota_block", "127.0.0.1:3306")
+	defer block.Close()
+	blockCtx := proto.WithUserName(context.Background(), "quota_block")
+	assert.NoError(t, qf.PreHandle(blockCtx))
+
+	stmtCtx := usage.WithSession(blockCtx, block)
+	usage.AddBackend(stmtCtx, time.Millisecond, 10)
+	usage.Record(stmtCtx, 10, 0, nil)
+	err = qf.PreHandle(blockCtx)
+	sqlErr, ok := err.(*err2.SQLError)
+	assert.True(t, ok)
+	assert.Equal(t, constant.ERUserLimitReached, sqlErr.Num)
+	assert.Contains(t, sqlErr.Message, "rows_read")
+
+	// the usage of the user is counted from zero in the next window
+	now = now.Add(30 * time.Minute)
+	assert.NoError(t, qf.PreHandle(blockCtx))
+
+	throttle := usage.OpenSession(2, "quota_throttle", "127.0.0.1:3307")
+	defer throttle.Close()
+	throttleCtx := proto.WithUserName(context.Background(), "quota_throttle")
+	assert.NoError(t, qf.PreHandle(throttleCtx))
+	throttle.AddBytes(10, 100)
+	start := time.Now()
+	assert.NoError(t, qf.PreHandle(throttleCtx))
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	// the throttling stops when the statement is canceled
+	canceled, cancel := context.WithCancel(throttleCtx)
+	cancel()
+	assert.ErrorIs(t, qf.PreHandle(canceled), context.Canceled)
+
+	// the users without accounted usage are not checked
+	assert.NoError(t, qf.PreHandle(proto.WithUserName(context.Background(), "quota_unknown")))
+}
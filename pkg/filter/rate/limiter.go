@@ -19,6 +19,7 @@ package rate
 import (
 	"context"
 	"encoding/json"
+	"strings"
 
 	"github.com/pkg/errors"
 	"go.uber.org/ratelimit"
@@ -65,11 +66,18 @@ func (factory *_factory) NewFilter(_ string, config map[string]interface{}) (pro
 	if conf.SelectLimit != 0 {
 		selectLimiter = ratelimit.New(conf.SelectLimit)
 	}
+	redisLimiters := make(map[string]ratelimit.Limiter, len(conf.RedisCommandLimits))
+	for command, limit := range conf.RedisCommandLimits {
+		if limit != 0 {
+			redisLimiters[strings.ToUpper(command)] = ratelimit.New(limit)
+		}
+	}
 	return &_filter{
 		insertLimiter: insertLimiter,
 		updateLimiter: updateLimiter,
 		deleteLimiter: deleteLimiter,
 		selectLimiter: selectLimiter,
+		redisLimiters: redisLimiters,
 	}, nil
 }
 
@@ -78,6 +86,8 @@ type LimiterFilterConfig struct {
 	UpdateLimit int `yaml:"update_limit" json:"update_limit"`
 	DeleteLimit int `yaml:"delete_limit" json:"delete_limit"`
 	SelectLimit int `yaml:"select_limit" json:"select_limit"`
+	// RedisCommandLimits limits the redis commands per second by command name, such as GET or SET
+	RedisCommandLimits map[string]int `yaml:"redis_command_limits" json:"redis_command_limits"`
 }
 
 type _filter struct {
@@ -85,6 +95,7 @@ type _filter struct {
 	updateLimiter ratelimit.Limiter
 	deleteLimiter ratelimit.Limiter
 	selectLimiter ratelimit.Limiter
+	redisLimiters map[string]ratelimit.Limiter
 }
 
 func (f *_filter) GetKind() string {
@@ -146,6 +157,13 @@ func (f *_filter) PreHandle(ctx context.Context) error {
 	return nil
 }
 
+func (f *_filter) PreHandleRedis(ctx context.Context, command *proto.RedisCommand) error {
+	if limiter, ok := f.redisLimiters[command.Name()]; ok {
+		limiter.Take()
+	}
+	return nil
+}
+
 func init() {
 	filter.RegistryFilterFactory(rateLimiterFilter, &_factory{})
 }
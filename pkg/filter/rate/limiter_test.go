@@ -102,3 +102,27 @@ func TestRateLimiter(t *testing.T) {
 		}
 	}
 }
+
+func TestRedisRateLimiter(t *testing.T) {
+	filter, err := (&_factory{}).NewFilter("test", map[string]interface{}{
+		"redis_command_limits": map[string]interface{}{
+			"set": 10,
+		},
+	})
+	assert.Nil(t, err)
+	f := filter.(proto.RedisPreFilter)
+
+	set := &proto.RedisCommand{Args: [][]byte{[]byte("set"), []byte("k"), []byte("v")}}
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, f.PreHandleRedis(context.Background(), set))
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+
+	get := &proto.RedisCommand{Args: [][]byte{[]byte("GET"), []byte("k")}}
+	start = time.Now()
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, f.PreHandleRedis(context.Background(), get))
+	}
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+}
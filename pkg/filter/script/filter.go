@@ -0,0 +1,526 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package script
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	err2 "github.com/cectc/dbpack/pkg/errors"
+	"github.com/cectc/dbpack/pkg/filter"
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/visitor"
+	"github.com/cectc/dbpack/third_party/parser"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+)
+
+const (
+	scriptFilter = "ScriptFilter"
+
+	defaultTimeout       = 10 * time.Millisecond
+	defaultMaxCallStack  = 256
+	defaultRuntimesCount = 16
+
+	preHandleFunc  = "preHandle"
+	postHandleFunc = "postHandle"
+)
+
+// Config is the config of the script filter. The script is lua run by the embedded
+// interpreter, it defines the functions preHandle(stmt) and/or postHandle(stmt, result):
+//
+//	function preHandle(stmt)
+//	  if stmt.type == "delete" and not string.find(string.lower(stmt.sql), "where") then
+//	    reject("delete without where is not allowed")
+//	  end
+//	  annotate("tenant", stmt.user)
+//	  if stmt.user == "report" then
+//	    route("employees_replica")
+//	  end
+//	end
+//
+// stmt has the fields applicationId, connectionId, user, schema, remoteAddr, command
+// ("query" or "execute"), type ("select", "insert", "update", "delete" or "other"), sql and
+// args, result has the fields affectedRows, lastInsertId and error. The scripts are
+// sandboxed, only the base, string, table and math libraries are opened, without the
+// functions loading files or modules, and they have no access to the host but the functions
+// reject(message), annotate(key, value) adding an attribute to the trace span of the
+// statement, log(message), and in preHandle:
+//
+//   - rewrite(sql) replacing the statement of a query with sql, a statement of the same type.
+//     Prepared statements are shared by their executions and can't be rewritten, and the plan
+//     cache of sharding executors keys plans by the sql text sent by the client, so rewrites
+//     must only depend on the statement for queries sent to sharding executors
+//   - route(dataSource) sending the statement to the data source named dataSource, which single
+//     db executors do for the statements outside transactions, see constant.DataSourceRoute
+//
+// A rewrite or a route takes effect once preHandle returns, unless the script rejects the
+// statement or fails.
+type Config struct {
+	Script string `yaml:"script" json:"script"`
+	// ScriptFile is the path of the script, used if Script is empty
+	ScriptFile string `yaml:"script_file" json:"script_file"`
+	// Timeout is the time limit of each run of the script, 10ms by default
+	Timeout string `yaml:"timeout" json:"timeout"`
+	// FailClosed rejects the statement if the script raises an error or times out, otherwise
+	// the failure is logged and the statement goes on
+	FailClosed bool `yaml:"fail_closed" json:"fail_closed"`
+
+	timeout time.Duration
+}
+
+type _factory struct{}
+
+func (factory *_factory) NewFilter(appid string, config map[string]interface{}) (proto.Filter, error) {
+	var (
+		err     error
+		content []byte
+		conf    *Config
+	)
+	if content, err = json.Marshal(config); err != nil {
+		return nil, errors.Wrap(err, "marshal script filter config failed.")
+	}
+	if err = json.Unmarshal(content, &conf); err != nil {
+		log.Errorf("unmarshal script filter failed, %v", err)
+		return nil, err
+	}
+	if conf == nil {
+		conf = &Config{}
+	}
+	if conf.Script == "" && conf.ScriptFile != "" {
+		script, err := os.ReadFile(conf.ScriptFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read script file %s", conf.ScriptFile)
+		}
+		conf.Script = string(script)
+	}
+	if conf.Script == "" {
+		return nil, errors.New("script filter must have a script")
+	}
+	conf.timeout = defaultTimeout
+	if conf.Timeout != "" {
+		if conf.timeout, err = time.ParseDuration(conf.Timeout); err != nil {
+			return nil, errors.Wrapf(err, "invalid script timeout '%s'", conf.Timeout)
+		}
+		if conf.timeout <= 0 {
+			return nil, errors.Errorf("script timeout must be positive, got '%s'", conf.Timeout)
+		}
+	}
+	chunk, err := parse.Parse(strings.NewReader(conf.Script), scriptFilter)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse script")
+	}
+	program, err := lua.Compile(chunk, scriptFilter)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to compile script")
+	}
+	f := &_filter{
+		appid:    appid,
+		conf:     conf,
+		program:  program,
+		runtimes: make(chan *runtime, defaultRuntimesCount),
+	}
+	// run the script once to report the errors of it on creating the filter
+	rt, err := f.newRuntime()
+	if err != nil {
+		return nil, err
+	}
+	f.hasPreHandle, f.hasPostHandle = rt.preHandle != nil, rt.postHandle != nil
+	if !f.hasPreHandle && !f.hasPostHandle {
+		rt.state.Close()
+		return nil, errors.Errorf("script should define function %s or %s", preHandleFunc, postHandleFunc)
+	}
+	f.returnRuntime(rt)
+	return f, nil
+}
+
+type _filter struct {
+	appid   string
+	conf    *Config
+	program *lua.FunctionProto
+	// runtimes is the free list of the runtimes, a runtime is not safe for concurrent use
+	runtimes chan *runtime
+
+	hasPreHandle  bool
+	hasPostHandle bool
+}
+
+// runtime is a sandboxed interpreter having run the script.
+type runtime struct {
+	state      *lua.LState
+	preHandle  *lua.LFunction
+	postHandle *lua.LFunction
+
+	// call is the state of the running call
+	call *call
+}
+
+type call struct {
+	ctx context.Context
+	// pre reports whether the call is a call of preHandle
+	pre      bool
+	rejected bool
+	message  string
+	rewrite  ast.StmtNode
+	route    string
+}
+
+// sandboxLibs are the libraries opened for the scripts, sandboxRemoved are the functions of
+// them removed as they reach the host.
+var (
+	sandboxLibs = []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	}
+	sandboxRemoved = []string{"dofile", "loadfile", "print", "module", "require"}
+)
+
+func (f *_filter) GetKind() string {
+	return scriptFilter
+}
+
+func (f *_filter) newRuntime() (*runtime, error) {
+	rt := &runtime{state: lua.NewState(lua.Options{
+		CallStackSize: defaultMaxCallStack,
+		SkipOpenLibs:  true,
+	})}
+	for _, lib := range sandboxLibs {
+		rt.state.Push(rt.state.NewFunction(lib.open))
+		rt.state.Push(lua.LString(lib.name))
+		rt.state.Call(1, 0)
+	}
+	for _, name := range sandboxRemoved {
+		rt.state.SetGlobal(name, lua.LNil)
+	}
+	rt.state.SetGlobal("reject", rt.state.NewFunction(rt.reject))
+	rt.state.SetGlobal("annotate", rt.state.NewFunction(rt.annotate))
+	rt.state.SetGlobal("log", rt.state.NewFunction(rt.log))
+	rt.state.SetGlobal("rewrite", rt.state.NewFunction(rt.rewrite))
+	rt.state.SetGlobal("route", rt.state.NewFunction(rt.route))
+	if err := f.run(rt, func() error {
+		rt.state.Push(rt.state.NewFunctionFromProto(f.program))
+		return rt.state.PCall(0, 0, nil)
+	}); err != nil {
+		rt.state.Close()
+		return nil, errors.Wrap(err, "failed to run script")
+	}
+	rt.preHandle, _ = rt.state.GetGlobal(preHandleFunc).(*lua.LFunction)
+	rt.postHandle, _ = rt.state.GetGlobal(postHandleFunc).(*lua.LFunction)
+	return rt, nil
+}
+
+func (f *_filter) borrowRuntime() (*runtime, error) {
+	select {
+	case rt := <-f.runtimes:
+		return rt, nil
+	default:
+		return f.newRuntime()
+	}
+}
+
+func (f *_filter) returnRuntime(rt *runtime) {
+	select {
+	case f.runtimes <- rt:
+	default:
+		rt.state.Close()
+	}
+}
+
+// run runs fn under the time limit of the script.
+func (f *_filter) run(rt *runtime, fn func() error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), f.conf.timeout)
+	defer cancel()
+	rt.state.SetContext(ctx)
+	defer rt.state.RemoveContext()
+	if err := fn(); err != nil {
+		if ctx.Err() != nil {
+			return errors.Errorf("script timed out after %s", f.conf.timeout)
+		}
+		return err
+	}
+	return nil
+}
+
+func (f *_filter) PreHandle(ctx context.Context) error {
+	if !f.hasPreHandle {
+		return nil
+	}
+	stmt, ok := statement(ctx, f.appid)
+	if !ok {
+		return nil
+	}
+	return f.invoke(ctx, true, func(rt *runtime) error {
+		return rt.state.CallByParam(lua.P{Fn: rt.preHandle, Protect: true}, stmt.table(rt.state))
+	})
+}
+
+func (f *_filter) PostHandle(ctx context.Context, result proto.Result, err error) error {
+	if !f.hasPostHandle {
+		return err
+	}
+	stmt, ok := statement(ctx, f.appid)
+	if !ok {
+		return err
+	}
+	res := &Result{}
+	if err != nil {
+		res.Error = err.Error()
+	} else if result != nil {
+		res.AffectedRows, _ = result.RowsAffected()
+		res.LastInsertID, _ = result.LastInsertId()
+	}
+	if scriptErr := f.invoke(ctx, false, func(rt *runtime) error {
+		return rt.state.CallByParam(lua.P{Fn: rt.postHandle, Protect: true},
+			stmt.table(rt.state), res.table(rt.state))
+	}); scriptErr != nil {
+		return scriptErr
+	}
+	return err
+}
+
+// invoke calls a function of the script, the statement is rejected if the script calls
+// reject, or if it fails and the filter fails closed. The rewrite and the route of the
+// script are applied if it succeeds.
+func (f *_filter) invoke(ctx context.Context, pre bool, fn func(rt *runtime) error) error {
+	rt, err := f.borrowRuntime()
+	if err != nil {
+		return f.failure(err)
+	}
+	c := &call{ctx: ctx, pre: pre}
+	rt.call = c
+	err = f.run(rt, func() error {
+		return fn(rt)
+	})
+	rt.call = nil
+	f.returnRuntime(rt)
+	if c.rejected {
+		return err2.NewSQLError(constant.ERUnknownError, constant.SSUnknownSQLState, "%s", c.message)
+	}
+	if err != nil {
+		return f.failure(err)
+	}
+	if c.rewrite != nil {
+		// the statement is replaced in place, as the executors read it from the context
+		stmt := proto.QueryStmt(ctx)
+		reflect.ValueOf(stmt).Elem().Set(reflect.ValueOf(c.rewrite).Elem())
+		stmt.Accept(&visitor.ParamVisitor{})
+	}
+	if c.route != "" {
+		proto.WithVariable(ctx, constant.DataSourceRoute, c.route)
+	}
+	return nil
+}
+
+func (f *_filter) failure(err error) error {
+	log.Errorf("script filter failed: %v", err)
+	if f.conf.FailClosed {
+		return err2.NewSQLError(constant.ERUnknownError, constant.SSUnknownSQLState, "script filter failed: %v", err)
+	}
+	return nil
+}
+
+func (rt *runtime) reject(L *lua.LState) int {
+	if rt.call == nil {
+		L.RaiseError("reject can only be called in %s or %s", preHandleFunc, postHandleFunc)
+	}
+	rt.call.rejected = true
+	rt.call.message = L.OptString(1, "statement rejected by script filter")
+	return 0
+}
+
+func (rt *runtime) annotate(L *lua.LState) int {
+	key, value := L.CheckString(1), L.CheckAny(2)
+	if rt.call == nil {
+		return 0
+	}
+	trace.SpanFromContext(rt.call.ctx).SetAttributes(
+		attribute.String("script."+key, value.String()))
+	return 0
+}
+
+func (rt *runtime) rewrite(L *lua.LState) int {
+	sql := L.CheckString(1)
+	if rt.call == nil || !rt.call.pre {
+		L.RaiseError("rewrite can only be called in %s", preHandleFunc)
+	}
+	stmt := proto.QueryStmt(rt.call.ctx)
+	if proto.CommandType(rt.call.ctx) != constant.ComQuery || stmt == nil {
+		L.RaiseError("only the statements of queries can be rewritten")
+	}
+	rewritten, err := parser.New().ParseOneStmt(sql, "", "")
+	if err != nil {
+		L.RaiseError("failed to parse '%s': %v", sql, err)
+	}
+	if reflect.TypeOf(rewritten) != reflect.TypeOf(stmt) {
+		L.RaiseError("'%s' is not a statement of the type of the rewritten statement", sql)
+	}
+	rt.call.rewrite = rewritten
+	return 0
+}
+
+func (rt *runtime) route(L *lua.LState) int {
+	dataSource := L.CheckString(1)
+	if rt.call == nil || !rt.call.pre {
+		L.RaiseError("route can only be called in %s", preHandleFunc)
+	}
+	rt.call.route = dataSource
+	return 0
+}
+
+func (rt *runtime) log(L *lua.LState) int {
+	log.Infof("script filter: %s", L.CheckString(1))
+	return 0
+}
+
+// Statement is the statement handed to the script.
+type Statement struct {
+	ApplicationID string        `json:"applicationId"`
+	ConnectionID  uint32        `json:"connectionId"`
+	User          string        `json:"user"`
+	Schema        string        `json:"schema"`
+	RemoteAddr    string        `json:"remoteAddr"`
+	Command       string        `json:"command"`
+	Type          string        `json:"type"`
+	SQL           string        `json:"sql"`
+	Args          []interface{} `json:"args"`
+}
+
+// Result is the result of a statement handed to postHandle of the script.
+type Result struct {
+	AffectedRows uint64 `json:"affectedRows"`
+	LastInsertID uint64 `json:"lastInsertId"`
+	Error        string `json:"error"`
+}
+
+// table returns the statement as the table handed to the script, the args are indexed from 1.
+func (stmt *Statement) table(L *lua.LState) *lua.LTable {
+	args := L.CreateTable(len(stmt.Args), 0)
+	for i, arg := range stmt.Args {
+		args.RawSetInt(i+1, luaValue(arg))
+	}
+	tbl := L.CreateTable(0, 9)
+	tbl.RawSetString("applicationId", lua.LString(stmt.ApplicationID))
+	tbl.RawSetString("connectionId", lua.LNumber(stmt.ConnectionID))
+	tbl.RawSetString("user", lua.LString(stmt.User))
+	tbl.RawSetString("schema", lua.LString(stmt.Schema))
+	tbl.RawSetString("remoteAddr", lua.LString(stmt.RemoteAddr))
+	tbl.RawSetString("command", lua.LString(stmt.Command))
+	tbl.RawSetString("type", lua.LString(stmt.Type))
+	tbl.RawSetString("sql", lua.LString(stmt.SQL))
+	tbl.RawSetString("args", args)
+	return tbl
+}
+
+// table returns the result as the table handed to the script.
+func (res *Result) table(L *lua.LState) *lua.LTable {
+	tbl := L.CreateTable(0, 3)
+	tbl.RawSetString("affectedRows", lua.LNumber(res.AffectedRows))
+	tbl.RawSetString("lastInsertId", lua.LNumber(res.LastInsertID))
+	tbl.RawSetString("error", lua.LString(res.Error))
+	return tbl
+}
+
+// luaValue converts an argument of a statement to a lua value, the values lua has no type for
+// are handed as strings.
+func luaValue(v interface{}) lua.LValue {
+	switch val := v.(type) {
+	case nil:
+		return lua.LNil
+	case bool:
+		return lua.LBool(val)
+	case string:
+		return lua.LString(val)
+	case int64:
+		return lua.LNumber(val)
+	case uint64:
+		return lua.LNumber(val)
+	case float32:
+		return lua.LNumber(val)
+	case float64:
+		return lua.LNumber(val)
+	default:
+		return lua.LString(fmt.Sprint(val))
+	}
+}
+
+func statement(ctx context.Context, appid string) (*Statement, bool) {
+	stmt := &Statement{
+		ApplicationID: appid,
+		ConnectionID:  proto.ConnectionID(ctx),
+		User:          proto.UserName(ctx),
+		Schema:        proto.Schema(ctx),
+		RemoteAddr:    proto.RemoteAddr(ctx),
+		SQL:           proto.SqlText(ctx),
+		Args:          []interface{}{},
+	}
+	var stmtNode ast.StmtNode
+	switch proto.CommandType(ctx) {
+	case constant.ComQuery:
+		stmt.Command = "query"
+		stmtNode = proto.QueryStmt(ctx)
+	case constant.ComStmtExecute:
+		stmt.Command = "execute"
+		prepareStmt := proto.PrepareStmt(ctx)
+		if prepareStmt == nil {
+			return nil, false
+		}
+		stmtNode = prepareStmt.StmtNode
+		for i := 0; i < len(prepareStmt.BindVars); i++ {
+			arg := prepareStmt.BindVars[fmt.Sprintf("v%d", i+1)]
+			if bytes, ok := arg.([]byte); ok {
+				arg = string(bytes)
+			}
+			stmt.Args = append(stmt.Args, arg)
+		}
+	default:
+		return nil, false
+	}
+	switch stmtNode.(type) {
+	case *ast.SelectStmt:
+		stmt.Type = "select"
+	case *ast.InsertStmt:
+		stmt.Type = "insert"
+	case *ast.UpdateStmt:
+		stmt.Type = "update"
+	case *ast.DeleteStmt:
+		stmt.Type = "delete"
+	default:
+		stmt.Type = "other"
+	}
+	return stmt, true
+}
+
+func init() {
+	filter.RegistryFilterFactory(scriptFilter, &_factory{})
+}
@@ -0,0 +1,217 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package script
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	err2 "github.com/cectc/dbpack/pkg/errors"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/third_party/parser"
+	"github.com/cectc/dbpack/third_party/parser/format"
+	_ "github.com/cectc/dbpack/third_party/types/parser_driver"
+)
+
+func TestNewFilter(t *testing.T) {
+	testCases := []struct {
+		config    map[string]interface{}
+		expectErr bool
+	}{
+		{
+			config:    map[string]interface{}{},
+			expectErr: true,
+		},
+		{
+			config:    map[string]interface{}{"script": "function preHandle(stmt)"},
+			expectErr: true,
+		},
+		{
+			config:    map[string]interface{}{"script": "a = 1"},
+			expectErr: true,
+		},
+		{
+			config:    map[string]interface{}{"script": "function preHandle(stmt) end", "timeout": "ms"},
+			expectErr: true,
+		},
+		{
+			config:    map[string]interface{}{"script_file": "not_exist.lua"},
+			expectErr: true,
+		},
+		{
+			config:    map[string]interface{}{"script": "dofile('/etc/passwd')"},
+			expectErr: true,
+		},
+		{
+			config:    map[string]interface{}{"script": "os.exit(1)"},
+			expectErr: true,
+		},
+		{
+			config:    map[string]interface{}{"script": "function postHandle(stmt, result) end", "timeout": "5ms"},
+			expectErr: false,
+		},
+	}
+	for _, c := range testCases {
+		_, err := (&_factory{}).NewFilter("svc", c.config)
+		assert.Equal(t, c.expectErr, err != nil)
+	}
+}
+
+func TestPreHandle(t *testing.T) {
+	script := `
+function preHandle(stmt)
+  annotate("user", stmt.user)
+  if stmt.type == "delete" and not string.find(string.lower(stmt.sql), "where") then
+    reject("delete without where is not allowed")
+  end
+  if stmt.command == "execute" and stmt.args[2] == "d001" then
+    reject("department d001 is read only")
+  end
+  if string.find(stmt.sql, "sleep") then
+    while true do end
+  end
+  if string.find(stmt.sql, "throw") then
+    error("unexpected statement")
+  end
+end`
+	newContext := func(sql string) context.Context {
+		stmtNode, err := parser.New().ParseOneStmt(sql, "", "")
+		assert.Nil(t, err)
+		ctx := proto.WithUserName(context.Background(), "dksl")
+		ctx = proto.WithCommandType(ctx, constant.ComQuery)
+		ctx = proto.WithQueryStmt(ctx, stmtNode)
+		return proto.WithSqlText(ctx, sql)
+	}
+
+	f, err := (&_factory{}).NewFilter("svc", map[string]interface{}{"script": script, "timeout": "20ms"})
+	assert.Nil(t, err)
+	preFilter := f.(*_filter)
+
+	assert.Nil(t, preFilter.PreHandle(newContext("delete from employees where emp_no = 1")))
+	err = preFilter.PreHandle(newContext("delete from employees"))
+	assert.Error(t, err)
+	sqlErr, ok := err.(*err2.SQLError)
+	assert.True(t, ok)
+	assert.Equal(t, "delete without where is not allowed", sqlErr.Message)
+
+	ctx := proto.WithCommandType(context.Background(), constant.ComStmtExecute)
+	ctx = proto.WithSqlText(ctx, "update employees set dept_no = ? where emp_no = ?")
+	ctx = proto.WithPrepareStmt(ctx, &proto.Stmt{
+		BindVars: map[string]interface{}{"v1": []byte("d001"), "v2": int64(1)},
+	})
+	assert.Nil(t, preFilter.PreHandle(ctx))
+	ctx = proto.WithPrepareStmt(ctx, &proto.Stmt{
+		BindVars: map[string]interface{}{"v1": int64(1), "v2": []byte("d001")},
+	})
+	assert.Error(t, preFilter.PreHandle(ctx))
+
+	// the failures of the script are ignored unless the filter fails closed
+	assert.Nil(t, preFilter.PreHandle(newContext("select sleep(1)")))
+	assert.Nil(t, preFilter.PreHandle(newContext("select 'throw'")))
+	preFilter.conf.FailClosed = true
+	assert.Error(t, preFilter.PreHandle(newContext("select sleep(1)")))
+	assert.Error(t, preFilter.PreHandle(newContext("select 'throw'")))
+	// the runtime is still usable after the timeout
+	assert.Nil(t, preFilter.PreHandle(newContext("select 1")))
+}
+
+func TestPostHandle(t *testing.T) {
+	script := `
+function postHandle(stmt, result)
+  if result.error == "" and result.affectedRows > 100 then
+    reject("too many rows affected: " .. result.affectedRows)
+  end
+end`
+	f, err := (&_factory{}).NewFilter("svc", map[string]interface{}{"script": script})
+	assert.Nil(t, err)
+	postFilter := f.(*_filter)
+	ctx := proto.WithCommandType(context.Background(), constant.ComQuery)
+	ctx = proto.WithSqlText(ctx, "update employees set hire_date = now()")
+
+	assert.Nil(t, postFilter.PreHandle(ctx))
+	assert.Nil(t, postFilter.PostHandle(ctx, &mysql.Result{AffectedRows: 10}, nil))
+	err = postFilter.PostHandle(ctx, &mysql.Result{AffectedRows: 1000}, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "too many rows affected: 1000")
+	executeErr := errors.New("lost connection")
+	assert.Equal(t, executeErr, postFilter.PostHandle(ctx, nil, executeErr))
+}
+
+func TestRewriteAndRoute(t *testing.T) {
+	script := `
+function preHandle(stmt)
+  if stmt.user == "report" then
+    route("employees_replica")
+  end
+  if stmt.sql == "select * from employees" then
+    rewrite("select emp_no, first_name from employees limit 100")
+  end
+  if string.find(stmt.sql, "titles") then
+    rewrite("delete from titles")
+  end
+end`
+	newContext := func(user, sql string) context.Context {
+		stmtNode, err := parser.New().ParseOneStmt(sql, "", "")
+		assert.Nil(t, err)
+		ctx := proto.WithVariableMap(context.Background())
+		ctx = proto.WithUserName(ctx, user)
+		ctx = proto.WithCommandType(ctx, constant.ComQuery)
+		ctx = proto.WithQueryStmt(ctx, stmtNode)
+		return proto.WithSqlText(ctx, sql)
+	}
+	restore := func(ctx context.Context) string {
+		var sb strings.Builder
+		assert.Nil(t, proto.QueryStmt(ctx).Restore(format.NewRestoreCtx(constant.DBPackRestoreFormat, &sb)))
+		return sb.String()
+	}
+
+	f, err := (&_factory{}).NewFilter("svc", map[string]interface{}{"script": script, "fail_closed": true})
+	assert.Nil(t, err)
+	preFilter := f.(*_filter)
+
+	ctx := newContext("report", "select * from employees")
+	assert.Nil(t, preFilter.PreHandle(ctx))
+	assert.Equal(t, "SELECT `emp_no`,`first_name` FROM `employees` LIMIT 100", restore(ctx))
+	assert.Equal(t, "employees_replica", proto.Variable(ctx, constant.DataSourceRoute))
+
+	ctx = newContext("dksl", "select * from employees where emp_no = 1")
+	assert.Nil(t, preFilter.PreHandle(ctx))
+	assert.Equal(t, "SELECT * FROM `employees` WHERE `emp_no`=1", restore(ctx))
+	assert.Nil(t, proto.Variable(ctx, constant.DataSourceRoute))
+
+	// a rewrite can't change the type of the statement
+	ctx = newContext("report", "select * from titles")
+	err = preFilter.PreHandle(ctx)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "is not a statement of the type of the rewritten statement")
+	assert.Equal(t, "SELECT * FROM `titles`", restore(ctx))
+	assert.Nil(t, proto.Variable(ctx, constant.DataSourceRoute))
+
+	// prepared statements can't be rewritten
+	ctx = proto.WithCommandType(proto.WithVariableMap(context.Background()), constant.ComStmtExecute)
+	ctx = proto.WithSqlText(ctx, "select * from employees")
+	ctx = proto.WithPrepareStmt(ctx, &proto.Stmt{})
+	err = preFilter.PreHandle(ctx)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "only the statements of queries can be rewritten")
+}
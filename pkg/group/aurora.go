@@ -0,0 +1,330 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package group
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/driver"
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/resource"
+)
+
+const (
+	DefaultAuroraRefreshInterval = 5 * time.Second
+	DefaultAuroraReadWeight      = 10
+
+	// auroraTopologyQuery lists the instances of the cluster, the rows of the deleted instances are
+	// kept for a while, so the ones not updated recently are skipped
+	auroraTopologyQuery = "SELECT SERVER_ID, SESSION_ID, REPLICA_LAG_IN_MILLISECONDS FROM information_schema.replica_host_status " +
+		"WHERE LAST_UPDATE_TIMESTAMP >= UTC_TIMESTAMP() - INTERVAL 3 MINUTE"
+	// auroraWriterSession is the SESSION_ID of the writer instance
+	auroraWriterSession = "MASTER_SESSION_ID"
+)
+
+// auroraClusterEndpoint matches the cluster endpoints, reader endpoints and custom endpoints of a cluster,
+// whose instance endpoints share the suffix, e.g. mycluster.cluster-abcdefghijkl.us-east-1.rds.amazonaws.com
+// and mydbinstance.abcdefghijkl.us-east-1.rds.amazonaws.com
+var auroraClusterEndpoint = regexp.MustCompile(`^[^.]+\.cluster-(?:ro-|custom-)?([^.]+\..+)$`)
+
+var (
+	auroraReplicaLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dbpack",
+		Subsystem: "aurora",
+		Name:      "replica_lag_seconds",
+		Help:      "replica lag of the reader instances in seconds",
+	}, []string{"group", "instance"})
+	auroraReaders = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dbpack",
+		Subsystem: "aurora",
+		Name:      "readers",
+		Help:      "count of the reader instances serving the reads of the group",
+	}, []string{"group"})
+)
+
+func init() {
+	prometheus.MustRegister(auroraReplicaLag, auroraReaders)
+}
+
+// auroraDBManager registers the data sources of the instances discovered at runtime.
+type auroraDBManager interface {
+	DataSource(name string) *config.DataSource
+	AddDataSource(dataSource *config.DataSource) (proto.DB, error)
+	RemoveDataSource(name string)
+}
+
+// auroraStatus is a row of information_schema.replica_host_status.
+type auroraStatus struct {
+	serverID string
+	writer   bool
+	lag      time.Duration
+}
+
+type auroraInstance struct {
+	db     proto.DB
+	writer bool
+	lag    time.Duration
+}
+
+// aurora discovers the instances of an Aurora MySQL cluster through its cluster endpoint, which is the master
+// of the group. The writes keep going to the cluster endpoint, which follows the writer as it fails over, and
+// the reads are spread over the reader instances, whose data sources are registered as they join the cluster
+// and unregistered as they leave it.
+type aurora struct {
+	groupName string
+	cluster   proto.DB
+	// dataSource is the data source of the cluster endpoint, the data sources of the instances copy it
+	dataSource  *config.DataSource
+	hostPattern string
+	port        string
+	maxLag      time.Duration
+	readWeight  int
+	interval    time.Duration
+	manager     auroraDBManager
+
+	mu        sync.RWMutex
+	instances map[string]*auroraInstance
+}
+
+// WithAurora discovers the reader instances of the Aurora MySQL cluster the master of the group points to.
+func WithAurora(appid string, conf *config.AuroraConfig) Option {
+	return func(group *DBGroup) error {
+		if group.galera != nil {
+			return errors.Errorf("group %s can not be both a galera cluster and an aurora cluster", group.groupName)
+		}
+		if len(group.masters) != 1 {
+			return errors.Errorf("aurora group %s must have exactly one master, the cluster endpoint", group.groupName)
+		}
+		manager, ok := resource.GetDBManager(appid).(auroraDBManager)
+		if !ok {
+			return errors.Errorf("aurora group %s does not support data sources discovered at runtime", group.groupName)
+		}
+		a, err := newAurora(group.groupName, group.masters[0], manager, conf)
+		if err != nil {
+			return err
+		}
+		group.aurora = a
+		go group.aurora.monitor(group.done)
+		return nil
+	}
+}
+
+func newAurora(groupName string, cluster proto.DB, manager auroraDBManager, conf *config.AuroraConfig) (*aurora, error) {
+	interval, err := conf.Interval()
+	if err != nil {
+		return nil, err
+	}
+	if interval == 0 {
+		interval = DefaultAuroraRefreshInterval
+	}
+	maxLag, err := conf.MaxLag()
+	if err != nil {
+		return nil, err
+	}
+	readWeight := conf.ReadWeight
+	if readWeight <= 0 {
+		readWeight = DefaultAuroraReadWeight
+	}
+	dataSource := manager.DataSource(cluster.Name())
+	if dataSource == nil {
+		return nil, errors.Errorf("aurora group %s has no data source %s", groupName, cluster.Name())
+	}
+	dsn, err := driver.ParseDSN(dataSource.DSN)
+	if err != nil {
+		return nil, errors.Wrapf(err, "aurora group %s has invalid dsn", groupName)
+	}
+	host, port, err := net.SplitHostPort(dsn.Addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "aurora group %s has invalid address %s", groupName, dsn.Addr)
+	}
+	hostPattern := conf.InstanceHostPattern
+	if hostPattern == "" {
+		matches := auroraClusterEndpoint.FindStringSubmatch(host)
+		if matches == nil {
+			return nil, errors.Errorf("aurora group %s address %s is not a cluster endpoint, instance_host_pattern is required",
+				groupName, host)
+		}
+		hostPattern = "?." + matches[1]
+	}
+	if !strings.Contains(hostPattern, "?") {
+		return nil, errors.Errorf("aurora instance host pattern %s must contain '?'", hostPattern)
+	}
+	auroraReaders.WithLabelValues(groupName).Set(0)
+	return &aurora{
+		groupName:   groupName,
+		cluster:     cluster,
+		dataSource:  dataSource,
+		hostPattern: hostPattern,
+		port:        port,
+		maxLag:      maxLag,
+		readWeight:  readWeight,
+		interval:    interval,
+		manager:     manager,
+		instances:   make(map[string]*auroraInstance),
+	}, nil
+}
+
+func (a *aurora) monitor(done <-chan struct{}) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-done:
+			return
+		}
+		a.refresh()
+		timer.Reset(a.interval)
+	}
+}
+
+// refresh queries the instances of the cluster, registers the data sources of the new instances and
+// unregisters the ones of the instances which left. The instances are kept if the query fails.
+func (a *aurora) refresh() {
+	statuses, err := queryAuroraTopology(a.cluster)
+	if err != nil {
+		log.Warnf("aurora group %s query topology failed, err: %v", a.groupName, err)
+		return
+	}
+
+	a.mu.RLock()
+	current := a.instances
+	a.mu.RUnlock()
+
+	instances := make(map[string]*auroraInstance, len(statuses))
+	for _, status := range statuses {
+		instance, ok := current[status.serverID]
+		if !ok {
+			db, err := a.addInstance(status.serverID)
+			if err != nil {
+				log.Warnf("aurora group %s add instance %s failed, err: %v", a.groupName, status.serverID, err)
+				continue
+			}
+			log.Infof("aurora group %s discovered instance %s", a.groupName, status.serverID)
+			instance = &auroraInstance{db: db}
+		}
+		instances[status.serverID] = &auroraInstance{db: instance.db, writer: status.writer, lag: status.lag}
+		auroraReplicaLag.WithLabelValues(a.groupName, status.serverID).Set(status.lag.Seconds())
+	}
+
+	a.mu.Lock()
+	a.instances = instances
+	a.mu.Unlock()
+	auroraReaders.WithLabelValues(a.groupName).Set(float64(len(a.getReaders())))
+
+	for serverID, instance := range current {
+		if _, ok := instances[serverID]; !ok {
+			log.Infof("aurora group %s instance %s left the cluster", a.groupName, serverID)
+			auroraReplicaLag.DeleteLabelValues(a.groupName, serverID)
+			// closing the pool waits for the connections in use, which must not block the refresh
+			go a.manager.RemoveDataSource(instance.db.Name())
+		}
+	}
+}
+
+// addInstance registers the data source of an instance, which copies the data source of the cluster endpoint.
+func (a *aurora) addInstance(serverID string) (proto.DB, error) {
+	host := strings.Replace(a.hostPattern, "?", serverID, 1)
+	dsn, err := driver.ReplaceDSNAddr(a.dataSource.DSN, net.JoinHostPort(host, a.port))
+	if err != nil {
+		return nil, err
+	}
+	dataSource := *a.dataSource
+	dataSource.Name = fmt.Sprintf("%s-%s", a.dataSource.Name, serverID)
+	dataSource.MasterName = a.dataSource.Name
+	dataSource.DSN = dsn
+	db, err := a.manager.AddDataSource(&dataSource)
+	if err != nil {
+		return nil, err
+	}
+	db.SetReadWeight(a.readWeight)
+	db.SetWriteWeight(0)
+	return db, nil
+}
+
+// getReaders returns the running reader instances whose replica lag is within the max replica lag,
+// ordered by name.
+func (a *aurora) getReaders() []proto.DB {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	readers := make([]proto.DB, 0, len(a.instances))
+	for _, instance := range a.instances {
+		if instance.writer || instance.db.Status() != proto.Running {
+			continue
+		}
+		if a.maxLag > 0 && instance.lag > a.maxLag {
+			continue
+		}
+		readers = append(readers, instance.db)
+	}
+	sort.Slice(readers, func(i, j int) bool {
+		return readers[i].Name() < readers[j].Name()
+	})
+	return readers
+}
+
+// queryAuroraTopology reads information_schema.replica_host_status through db.
+func queryAuroraTopology(db proto.DB) ([]auroraStatus, error) {
+	result, _, err := db.QueryDirectly(auroraTopologyQuery)
+	if err != nil {
+		return nil, err
+	}
+	rlt, ok := result.(*mysql.Result)
+	if !ok {
+		return nil, errors.Errorf("unexpected result %T", result)
+	}
+	defer rlt.Release()
+	statuses := make([]auroraStatus, 0, len(rlt.Rows))
+	for _, row := range rlt.Rows {
+		values, err := row.Decode()
+		if err != nil {
+			return nil, err
+		}
+		if len(values) < 3 || values[0] == nil || values[1] == nil {
+			continue
+		}
+		status := auroraStatus{
+			serverID: toString(values[0].Val),
+			writer:   strings.EqualFold(toString(values[1].Val), auroraWriterSession),
+		}
+		if values[2] != nil && values[2].Val != nil {
+			millis, err := strconv.ParseFloat(toString(values[2].Val), 64)
+			if err != nil {
+				return nil, errors.Wrapf(err, "instance %s has invalid replica lag", status.serverID)
+			}
+			status.lag = time.Duration(millis * float64(time.Millisecond))
+		}
+		statuses = append(statuses, status)
+	}
+	if len(statuses) == 0 {
+		return nil, errors.New("no instance found, the data source is not an aurora cluster")
+	}
+	return statuses, nil
+}
@@ -0,0 +1,222 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package group
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/atomic"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/testdata"
+)
+
+// auroraTopology is the rows of replica_host_status as server id, session id and replica lag in milliseconds.
+type auroraTopology [][3]string
+
+type fakeAuroraDBManager struct {
+	ctrl        *gomock.Controller
+	mu          sync.Mutex
+	dataSources map[string]*config.DataSource
+	removed     chan string
+}
+
+func (manager *fakeAuroraDBManager) DataSource(name string) *config.DataSource {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	return manager.dataSources[name]
+}
+
+func (manager *fakeAuroraDBManager) AddDataSource(dataSource *config.DataSource) (proto.DB, error) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	manager.dataSources[dataSource.Name] = dataSource
+	db := testdata.NewMockDB(manager.ctrl)
+	db.EXPECT().Name().Return(dataSource.Name).AnyTimes()
+	db.EXPECT().Status().Return(proto.Running).AnyTimes()
+	db.EXPECT().SetReadWeight(gomock.Any()).AnyTimes()
+	db.EXPECT().SetWriteWeight(gomock.Any()).AnyTimes()
+	return db, nil
+}
+
+func (manager *fakeAuroraDBManager) RemoveDataSource(name string) {
+	manager.mu.Lock()
+	delete(manager.dataSources, name)
+	manager.mu.Unlock()
+	manager.removed <- name
+}
+
+func newAuroraTestCluster(ctrl *gomock.Controller, topology *auroraTopology) proto.DB {
+	db := testdata.NewMockDB(ctrl)
+	db.EXPECT().Name().Return("cluster").AnyTimes()
+	db.EXPECT().QueryDirectly(auroraTopologyQuery).DoAndReturn(func(query string) (proto.Result, uint16, error) {
+		if *topology == nil {
+			return nil, 0, errors.New("connection refused")
+		}
+		result := &mysql.Result{Fields: []*mysql.Field{
+			{Name: "SERVER_ID", FieldType: constant.FieldTypeVarString},
+			{Name: "SESSION_ID", FieldType: constant.FieldTypeVarString},
+			{Name: "REPLICA_LAG_IN_MILLISECONDS", FieldType: constant.FieldTypeDouble},
+		}}
+		ctx := proto.WithCommandType(context.Background(), constant.ComQuery)
+		for _, row := range *topology {
+			data := make([]byte, 0)
+			for _, value := range row {
+				data = append(append(data, byte(len(value))), value...)
+			}
+			if err := result.AppendRow(ctx, data); err != nil {
+				return nil, 0, err
+			}
+		}
+		return result, 0, nil
+	}).AnyTimes()
+	return db
+}
+
+func TestNewAurora(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	testCases := []struct {
+		name        string
+		dsn         string
+		conf        *config.AuroraConfig
+		hostPattern string
+		expectErr   bool
+	}{
+		{
+			name:        "cluster endpoint",
+			dsn:         "dksl:123456@tcp(mycluster.cluster-abcdefghijkl.us-east-1.rds.amazonaws.com:3306)/employees",
+			conf:        &config.AuroraConfig{},
+			hostPattern: "?.abcdefghijkl.us-east-1.rds.amazonaws.com",
+		},
+		{
+			name:        "custom host pattern",
+			dsn:         "dksl:123456@tcp(10.0.0.1:3306)/employees",
+			conf:        &config.AuroraConfig{InstanceHostPattern: "?.internal"},
+			hostPattern: "?.internal",
+		},
+		{
+			name:      "not a cluster endpoint",
+			dsn:       "dksl:123456@tcp(10.0.0.1:3306)/employees",
+			conf:      &config.AuroraConfig{},
+			expectErr: true,
+		},
+		{
+			name:      "invalid max replica lag",
+			dsn:       "dksl:123456@tcp(mycluster.cluster-abcdefghijkl.us-east-1.rds.amazonaws.com:3306)/employees",
+			conf:      &config.AuroraConfig{MaxReplicaLag: "1"},
+			expectErr: true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			manager := &fakeAuroraDBManager{ctrl: ctrl, dataSources: map[string]*config.DataSource{
+				"cluster": {Name: "cluster", DSN: c.dsn},
+			}}
+			a, err := newAurora("test", newAuroraTestCluster(ctrl, &auroraTopology{}), manager, c.conf)
+			if c.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, c.hostPattern, a.hostPattern)
+			assert.Equal(t, "3306", a.port)
+			assert.Equal(t, DefaultAuroraRefreshInterval, a.interval)
+		})
+	}
+}
+
+func TestAuroraRefresh(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	topology := auroraTopology{
+		{"db-1", auroraWriterSession, "0"},
+		{"db-2", "8c5d1d1e-1b2a-4f3e", "12.5"},
+		{"db-3", "9d6e2e2f-2c3b-5a4f", "2500"},
+	}
+	cluster := newAuroraTestCluster(ctrl, &topology)
+	manager := &fakeAuroraDBManager{
+		ctrl: ctrl,
+		dataSources: map[string]*config.DataSource{
+			"cluster": {Name: "cluster", DSN: "dksl:123456@tcp(mycluster.cluster-abcdefghijkl.us-east-1.rds.amazonaws.com:3306)/employees"},
+		},
+		removed: make(chan string, 1),
+	}
+	a, err := newAurora("test", cluster, manager, &config.AuroraConfig{MaxReplicaLag: "1s"})
+	assert.NoError(t, err)
+
+	a.refresh()
+	// the lagging reader is discovered but does not serve reads
+	assert.Equal(t, []string{"cluster-db-2"}, names(a.getReaders()))
+	dataSource := manager.DataSource("cluster-db-3")
+	assert.NotNil(t, dataSource)
+	assert.Equal(t, "cluster", dataSource.MasterName)
+	assert.Equal(t, "dksl:123456@tcp(db-3.abcdefghijkl.us-east-1.rds.amazonaws.com:3306)/employees", dataSource.DSN)
+
+	// the instances are kept while the topology is unknown
+	topology = nil
+	a.refresh()
+	assert.Equal(t, []string{"cluster-db-2"}, names(a.getReaders()))
+
+	// db-2 fails over to be the writer, db-3 catches up, db-1 leaves the cluster
+	topology = auroraTopology{
+		{"db-2", auroraWriterSession, "0"},
+		{"db-3", "9d6e2e2f-2c3b-5a4f", "10"},
+		{"db-4", "ae7f3f30-3d4c-6b5a", "8"},
+	}
+	a.refresh()
+	assert.Equal(t, []string{"cluster-db-3", "cluster-db-4"}, names(a.getReaders()))
+	assert.Equal(t, "cluster-db-1", <-manager.removed)
+	assert.Nil(t, manager.DataSource("cluster-db-1"))
+}
+
+func TestAuroraGroupPick(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	topology := auroraTopology{{"db-1", auroraWriterSession, "0"}, {"db-2", "8c5d1d1e-1b2a-4f3e", "5"}}
+	cluster := newAuroraTestCluster(ctrl, &topology)
+	cluster.(*testdata.MockDB).EXPECT().Status().Return(proto.Running).AnyTimes()
+	manager := &fakeAuroraDBManager{ctrl: ctrl, dataSources: map[string]*config.DataSource{
+		"cluster": {Name: "cluster", DSN: "dksl:123456@tcp(mycluster.cluster-abcdefghijkl.us-east-1.rds.amazonaws.com:3306)/employees"},
+	}}
+	a, err := newAurora("test", cluster, manager, &config.AuroraConfig{})
+	assert.NoError(t, err)
+	group := &DBGroup{
+		groupName:    "test",
+		masters:      []proto.DB{cluster},
+		algorithm:    config.RoundRobin,
+		writeCounter: atomic.NewInt64(0),
+		readCounter:  atomic.NewInt64(0),
+		aurora:       a,
+	}
+	// the reads go to the cluster endpoint until the readers are discovered
+	assert.Equal(t, "cluster", group.pick(proto.WithSlave(context.Background())).Name())
+	a.refresh()
+	assert.Equal(t, "cluster-db-2", group.pick(proto.WithSlave(context.Background())).Name())
+	assert.Equal(t, "cluster", group.pick(proto.WithMaster(context.Background())).Name())
+}
@@ -0,0 +1,268 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package group
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+const (
+	DefaultGaleraCheckInterval = time.Second
+
+	// galeraStatusQuery reads the wsrep status telling whether a node is synced with the primary component
+	galeraStatusQuery = "SHOW GLOBAL STATUS WHERE Variable_name IN ('wsrep_ready', 'wsrep_cluster_status', 'wsrep_local_state')"
+	// galeraSynced is the wsrep_local_state of the synced nodes, the donors and the desynced nodes are 2
+	galeraSynced = "4"
+)
+
+var (
+	galeraNodeSynced = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dbpack",
+		Subsystem: "galera",
+		Name:      "node_synced",
+		Help:      "1 if the node is synced with the primary component and serves queries, 0 otherwise",
+	}, []string{"group", "node"})
+	galeraWriter = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dbpack",
+		Subsystem: "galera",
+		Name:      "writer",
+		Help:      "1 if the node is the one the writes of the group are sent to, 0 otherwise",
+	}, []string{"group", "node"})
+	galeraFailovers = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "dbpack",
+		Subsystem: "galera",
+		Name:      "failover_count",
+		Help:      "count of the changes of the node the writes of the group are sent to",
+	}, []string{"group"})
+)
+
+func init() {
+	prometheus.MustRegister(galeraNodeSynced, galeraWriter, galeraFailovers)
+}
+
+// galera routes a group as a Galera or Percona XtraDB Cluster, whose nodes all accept writes. The writes
+// are sent to a single node, since concurrent writes to several nodes fail the certification of each other,
+// and the reads to the other synced nodes. The wsrep status of the nodes is checked periodically, the writes
+// fail over to the first synced node in the order of the data sources once the writer is not synced.
+type galera struct {
+	groupName string
+	// nodes are in the order of the data sources, the preferred writer first
+	nodes    []proto.DB
+	failback bool
+	interval time.Duration
+
+	mu     sync.RWMutex
+	synced []bool
+	writer int
+}
+
+// WithGalera routes the group as a Galera or Percona XtraDB Cluster.
+func WithGalera(conf *config.GaleraConfig, dataSources []*config.DataSourceRef) Option {
+	return func(group *DBGroup) error {
+		interval, err := conf.Interval()
+		if err != nil {
+			return err
+		}
+		dbs := make([]proto.DB, 0, len(group.masters)+len(group.slaves))
+		dbs = append(append(dbs, group.masters...), group.slaves...)
+		nodes, err := galeraNodes(group.groupName, dbs, dataSources, conf.Writer)
+		if err != nil {
+			return err
+		}
+		group.galera = newGalera(group.groupName, nodes, interval, conf.Failback)
+		go group.galera.monitor(group.done)
+		return nil
+	}
+}
+
+// galeraNodes orders dbs as dataSources, with writer first if it is configured.
+func galeraNodes(groupName string, dbs []proto.DB, dataSources []*config.DataSourceRef, writer string) ([]proto.DB, error) {
+	nodes := make([]proto.DB, 0, len(dataSources))
+	for _, dataSource := range dataSources {
+		for _, db := range dbs {
+			if strings.EqualFold(db.Name(), dataSource.Name) {
+				nodes = append(nodes, db)
+			}
+		}
+	}
+	if len(nodes) == 0 {
+		return nil, errors.Errorf("galera group %s has no node", groupName)
+	}
+	if writer == "" {
+		return nodes, nil
+	}
+	for i, node := range nodes {
+		if strings.EqualFold(node.Name(), writer) {
+			return append([]proto.DB{node}, append(nodes[:i:i], nodes[i+1:]...)...), nil
+		}
+	}
+	return nil, errors.Errorf("galera writer %s is not a data source of group %s", writer, groupName)
+}
+
+func newGalera(groupName string, nodes []proto.DB, interval time.Duration, failback bool) *galera {
+	if interval <= 0 {
+		interval = DefaultGaleraCheckInterval
+	}
+	g := &galera{
+		groupName: groupName,
+		nodes:     nodes,
+		failback:  failback,
+		interval:  interval,
+		synced:    make([]bool, len(nodes)),
+	}
+	// the nodes are assumed to be synced until they are checked
+	for i, node := range nodes {
+		g.synced[i] = true
+		galeraNodeSynced.WithLabelValues(groupName, node.Name()).Set(1)
+		galeraWriter.WithLabelValues(groupName, node.Name()).Set(0)
+	}
+	galeraWriter.WithLabelValues(groupName, nodes[0].Name()).Set(1)
+	return g
+}
+
+func (g *galera) monitor(done <-chan struct{}) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-done:
+			return
+		}
+		g.refresh()
+		timer.Reset(g.interval)
+	}
+}
+
+// refresh checks the nodes and elects the writer.
+func (g *galera) refresh() {
+	synced := make([]bool, len(g.nodes))
+	for i, node := range g.nodes {
+		ok, err := checkGaleraNode(node)
+		if err != nil {
+			log.Warnf("galera group %s check node %s failed, err: %v", g.groupName, node.Name(), err)
+		}
+		synced[i] = ok
+		value := 0.0
+		if ok {
+			value = 1
+		}
+		galeraNodeSynced.WithLabelValues(g.groupName, node.Name()).Set(value)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.synced = synced
+	writer := g.elect()
+	if writer == g.writer {
+		return
+	}
+	log.Warnf("galera group %s fails over writes from %s to %s", g.groupName,
+		g.nodes[g.writer].Name(), g.nodes[writer].Name())
+	galeraWriter.WithLabelValues(g.groupName, g.nodes[g.writer].Name()).Set(0)
+	galeraWriter.WithLabelValues(g.groupName, g.nodes[writer].Name()).Set(1)
+	galeraFailovers.WithLabelValues(g.groupName).Inc()
+	g.writer = writer
+}
+
+// elect returns the writer, which stays the same while it is synced unless the writes fail back to the
+// preferred writer. The writer is kept if no node is synced, since the writes fail anyway.
+func (g *galera) elect() int {
+	if g.synced[g.writer] && !(g.failback && g.synced[0]) {
+		return g.writer
+	}
+	for i := range g.nodes {
+		if g.synced[i] {
+			return i
+		}
+	}
+	return g.writer
+}
+
+// checkGaleraNode returns whether node is synced with the primary component and ready to serve queries.
+func checkGaleraNode(node proto.DB) (bool, error) {
+	if node.Status() != proto.Running {
+		return false, nil
+	}
+	result, _, err := node.QueryDirectly(galeraStatusQuery)
+	if err != nil {
+		return false, err
+	}
+	rlt, ok := result.(*mysql.Result)
+	if !ok {
+		return false, errors.Errorf("unexpected result %T", result)
+	}
+	defer rlt.Release()
+	status := make(map[string]string, len(rlt.Rows))
+	for _, row := range rlt.Rows {
+		values, err := row.Decode()
+		if err != nil {
+			return false, err
+		}
+		if len(values) < 2 || values[0] == nil || values[1] == nil {
+			continue
+		}
+		status[strings.ToLower(toString(values[0].Val))] = toString(values[1].Val)
+	}
+	if len(status) == 0 {
+		return false, errors.New("wsrep status not found, the node is not a galera node")
+	}
+	return strings.EqualFold(status["wsrep_ready"], "ON") &&
+		strings.EqualFold(status["wsrep_cluster_status"], "Primary") &&
+		status["wsrep_local_state"] == galeraSynced, nil
+}
+
+func toString(val interface{}) string {
+	switch v := val.(type) {
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	default:
+		return ""
+	}
+}
+
+// getWriter returns the node the writes are sent to.
+func (g *galera) getWriter() proto.DB {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.nodes[g.writer]
+}
+
+// getReaders returns the synced nodes but the writer.
+func (g *galera) getReaders() []proto.DB {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	readers := make([]proto.DB, 0, len(g.nodes))
+	for i, node := range g.nodes {
+		if i != g.writer && g.synced[i] {
+			readers = append(readers, node)
+		}
+	}
+	return readers
+}
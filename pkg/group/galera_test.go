@@ -0,0 +1,178 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package group
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/atomic"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/testdata"
+)
+
+// galeraStatus is the wsrep_local_state of a node, empty if the node is down.
+type galeraStatus map[string]string
+
+func newGaleraTestNode(ctrl *gomock.Controller, name string, states galeraStatus) proto.DB {
+	db := testdata.NewMockDB(ctrl)
+	db.EXPECT().Name().Return(name).AnyTimes()
+	db.EXPECT().Status().DoAndReturn(func() proto.DBStatus {
+		if states[name] == "" {
+			return proto.Unknown
+		}
+		return proto.Running
+	}).AnyTimes()
+	db.EXPECT().QueryDirectly(galeraStatusQuery).DoAndReturn(func(query string) (proto.Result, uint16, error) {
+		if states[name] == "error" {
+			return nil, 0, errors.New("connection refused")
+		}
+		result := &mysql.Result{Fields: []*mysql.Field{
+			{Name: "Variable_name", FieldType: constant.FieldTypeVarString},
+			{Name: "Value", FieldType: constant.FieldTypeVarString},
+		}}
+		ctx := proto.WithCommandType(context.Background(), constant.ComQuery)
+		for _, row := range [][2]string{{"wsrep_cluster_status", "Primary"}, {"wsrep_local_state", states[name]}, {"wsrep_ready", "ON"}} {
+			data := append([]byte{byte(len(row[0]))}, row[0]...)
+			data = append(append(data, byte(len(row[1]))), row[1]...)
+			if err := result.AppendRow(ctx, data); err != nil {
+				return nil, 0, err
+			}
+		}
+		return result, 0, nil
+	}).AnyTimes()
+	return db
+}
+
+func names(dbs []proto.DB) []string {
+	result := make([]string, 0, len(dbs))
+	for _, db := range dbs {
+		result = append(result, db.Name())
+	}
+	return result
+}
+
+func TestGaleraNodes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	states := galeraStatus{}
+	dbs := []proto.DB{newGaleraTestNode(ctrl, "n3", states), newGaleraTestNode(ctrl, "n1", states), newGaleraTestNode(ctrl, "n2", states)}
+	dataSources := []*config.DataSourceRef{{Name: "n1"}, {Name: "n2"}, {Name: "n3"}}
+	nodes, err := galeraNodes("test", dbs, dataSources, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"n1", "n2", "n3"}, names(nodes))
+	nodes, err = galeraNodes("test", dbs, dataSources, "n2")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"n2", "n1", "n3"}, names(nodes))
+	_, err = galeraNodes("test", dbs, dataSources, "n4")
+	assert.Error(t, err)
+}
+
+func TestGaleraRefresh(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	states := galeraStatus{"n1": "4", "n2": "4", "n3": "4"}
+	nodes := []proto.DB{newGaleraTestNode(ctrl, "n1", states), newGaleraTestNode(ctrl, "n2", states), newGaleraTestNode(ctrl, "n3", states)}
+
+	testCases := []struct {
+		name     string
+		failback bool
+		states   []galeraStatus
+		writer   string
+		readers  []string
+	}{
+		{
+			name:    "all synced",
+			states:  []galeraStatus{{"n1": "4", "n2": "4", "n3": "4"}},
+			writer:  "n1",
+			readers: []string{"n2", "n3"},
+		},
+		{
+			name:    "writer becomes a donor",
+			states:  []galeraStatus{{"n1": "2", "n2": "4", "n3": "4"}},
+			writer:  "n2",
+			readers: []string{"n3"},
+		},
+		{
+			name:    "writer stays after the preferred writer is synced again",
+			states:  []galeraStatus{{"n1": "2", "n2": "4", "n3": "4"}, {"n1": "4", "n2": "4", "n3": "4"}},
+			writer:  "n2",
+			readers: []string{"n1", "n3"},
+		},
+		{
+			name:     "writes fail back to the preferred writer",
+			failback: true,
+			states:   []galeraStatus{{"n1": "2", "n2": "4", "n3": "4"}, {"n1": "4", "n2": "4", "n3": "4"}},
+			writer:   "n1",
+			readers:  []string{"n2", "n3"},
+		},
+		{
+			name:    "nodes down or failing the check",
+			states:  []galeraStatus{{"n1": "", "n2": "error", "n3": "4"}},
+			writer:  "n3",
+			readers: []string{},
+		},
+		{
+			name:    "writer kept without synced nodes",
+			states:  []galeraStatus{{"n1": "1", "n2": "", "n3": "error"}},
+			writer:  "n1",
+			readers: []string{},
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			g := newGalera("test", nodes, 0, c.failback)
+			for _, s := range c.states {
+				for name, state := range s {
+					states[name] = state
+				}
+				g.refresh()
+			}
+			assert.Equal(t, c.writer, g.getWriter().Name())
+			assert.Equal(t, c.readers, names(g.getReaders()))
+		})
+	}
+}
+
+func TestGaleraGroupPick(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	states := galeraStatus{"n1": "4", "n2": "2"}
+	nodes := []proto.DB{newGaleraTestNode(ctrl, "n1", states), newGaleraTestNode(ctrl, "n2", states)}
+	group := &DBGroup{
+		groupName:    "test",
+		masters:      nodes,
+		algorithm:    config.RoundRobin,
+		writeCounter: atomic.NewInt64(0),
+		readCounter:  atomic.NewInt64(0),
+		galera:       newGalera("test", nodes, 0, false),
+	}
+	group.galera.refresh()
+	// the only synced node serves the reads as well
+	assert.Equal(t, "n1", group.pick(proto.WithSlave(context.Background())).Name())
+	assert.Equal(t, "n1", group.pick(proto.WithMaster(context.Background())).Name())
+}
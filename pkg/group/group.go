@@ -20,6 +20,7 @@ import (
 	"context"
 	"math/rand"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/uber-go/atomic"
@@ -39,14 +40,35 @@ type DBGroup struct {
 	algorithm    config.LoadBalanceAlgorithm
 	writeCounter *atomic.Int64
 	readCounter  *atomic.Int64
+
+	// hedger hedges the reads sent to slaves, nil if hedging is disabled
+	hedger *hedger
+	// galera chooses the master and the slaves among the nodes of a galera cluster, nil if the group is not one
+	galera *galera
+	// aurora discovers the reader instances of an aurora cluster, nil if the group is not one
+	aurora *aurora
+	// maintenance takes the data sources out of the reads during their maintenance windows, nil if
+	// none of them has one
+	maintenance *maintenance
+	// rejoin brings the data sources back gradually once they recover from a failure, nil if they
+	// serve as soon as they are healthy
+	rejoin *rejoin
+
+	// done is closed once the group is closed, it stops the monitors of the group
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
+// Option configures a DBGroup.
+type Option func(group *DBGroup) error
+
 func NewDBGroup(appid, name string,
 	algorithm config.LoadBalanceAlgorithm,
-	dataSources []*config.DataSourceRef) (proto.DBGroupExecutor, error) {
+	dataSources []*config.DataSourceRef, opts ...Option) (_ proto.DBGroupExecutor, err error) {
 	var (
 		masters = make([]proto.DB, 0)
 		slaves  = make([]proto.DB, 0)
+		dbs     = make([]proto.DB, 0, len(dataSources))
 	)
 	for _, dataSource := range dataSources {
 		readWeight, writeWeight, err := dataSource.ParseWeight()
@@ -56,20 +78,56 @@ func NewDBGroup(appid, name string,
 		db := resource.GetDBManager(appid).GetDB(dataSource.Name)
 		db.SetWriteWeight(writeWeight)
 		db.SetReadWeight(readWeight)
+		dbs = append(dbs, db)
 		if db.IsMaster() {
 			masters = append(masters, db)
 		} else {
 			slaves = append(slaves, db)
 		}
 	}
-	return &DBGroup{
+	group := &DBGroup{
 		groupName:    name,
 		masters:      masters,
 		slaves:       slaves,
 		algorithm:    algorithm,
 		writeCounter: atomic.NewInt64(0),
 		readCounter:  atomic.NewInt64(0),
-	}, nil
+		done:         make(chan struct{}),
+	}
+	defer func() {
+		// stops the monitors the options started
+		if err != nil {
+			group.Close()
+		}
+	}()
+	for _, opt := range opts {
+		if err := opt(group); err != nil {
+			return nil, err
+		}
+	}
+	maintenance, err := newMaintenance(name, dbs, dataSources)
+	if err != nil {
+		return nil, err
+	}
+	if maintenance != nil {
+		group.maintenance = maintenance
+		go group.maintenance.monitor(group.done)
+	}
+	if group.rejoin != nil {
+		group.rejoin.maintenance = group.maintenance
+		go group.rejoin.monitor(group.done)
+	}
+	return group, nil
+}
+
+// Close stops the monitors of the group, the data sources are left open, as the groups of other
+// executors may use them.
+func (group *DBGroup) Close() {
+	group.closeOnce.Do(func() {
+		if group.done != nil {
+			close(group.done)
+		}
+	})
 }
 
 func (group *DBGroup) GroupName() string {
@@ -86,8 +144,19 @@ func (group *DBGroup) XAStart(ctx context.Context, sql string) (proto.Tx, proto.
 	return dbs[0].XAStart(ctx, sql)
 }
 
+func (group *DBGroup) Pin(ctx context.Context) (proto.Tx, error) {
+	dbs := group.getAvailableMasters()
+	return dbs[0].Pin(ctx)
+}
+
 func (group *DBGroup) Query(ctx context.Context, query string) (proto.Result, uint16, error) {
 	db := group.pick(ctx)
+	if group.hedger != nil && proto.IsSlave(ctx) {
+		return group.hedger.query(ctx, db, group.getAvailableSlaves(),
+			func(ctx context.Context, db proto.DB) (proto.Result, uint16, error) {
+				return db.Query(ctx, query)
+			})
+	}
 	return db.Query(ctx, query)
 }
 
@@ -103,6 +172,11 @@ func (group *DBGroup) QueryAll(ctx context.Context, query string) (proto.Result,
 	for _, slave := range group.slaves {
 		go queryFunc(slave)
 	}
+	if group.aurora != nil {
+		for _, reader := range group.aurora.getReaders() {
+			go queryFunc(reader)
+		}
+	}
 	return &mysql.Result{
 		AffectedRows: 0,
 		InsertId:     0,
@@ -126,6 +200,12 @@ func (group *DBGroup) PrepareExecute(ctx context.Context, query string, args ...
 
 func (group *DBGroup) PrepareExecuteStmt(ctx context.Context, stmt *proto.Stmt) (proto.Result, uint16, error) {
 	db := group.pick(ctx)
+	if group.hedger != nil && proto.IsSlave(ctx) {
+		return group.hedger.query(ctx, db, group.getAvailableSlaves(),
+			func(ctx context.Context, db proto.DB) (proto.Result, uint16, error) {
+				return db.ExecuteStmt(ctx, stmt)
+			})
+	}
 	return db.ExecuteStmt(ctx, stmt)
 }
 
@@ -195,7 +275,7 @@ func (group *DBGroup) roundRobin(ctx context.Context) proto.DB {
 		} else if len(slaves) == 1 {
 			return slaves[0]
 		} else {
-			index := group.readCounter.Load() % int64(len(group.masters))
+			index := group.readCounter.Load() % int64(len(slaves))
 			group.readCounter.Inc()
 			return slaves[index]
 		}
@@ -221,7 +301,10 @@ func (group *DBGroup) randomWeight(ctx context.Context) proto.DB {
 			totalWeight = totalWeight + db.ReadWeight()
 		}
 		if len(dbs) == 1 {
-			return slaves[0]
+			return dbs[0]
+		} else if totalWeight == 0 {
+			// the read weights are all zero, e.g. the slaves are in their maintenance windows
+			return group._randomMaster()
 		} else {
 			weightSum := 0
 			index := rand.New(rand.NewSource(time.Now().UnixNano())).Intn(totalWeight)
@@ -261,15 +344,16 @@ func (group *DBGroup) _randomWeightMaster() proto.DB {
 	dbs := make([]proto.DB, 0)
 	weights := make([]int, 0)
 	totalWeight := 0
-	for _, db := range group.masters {
-		if db.Status() == proto.Running {
-			dbs = append(dbs, db)
-			weights = append(weights, db.WriteWeight())
-			totalWeight = totalWeight + db.WriteWeight()
-		}
+	for _, db := range group.getAvailableMasters() {
+		dbs = append(dbs, db)
+		weights = append(weights, db.WriteWeight())
+		totalWeight = totalWeight + db.WriteWeight()
 	}
 	if len(dbs) == 1 {
 		return dbs[0]
+	} else if totalWeight == 0 {
+		// the write weights are all zero, e.g. the masters are rejoining the group
+		return group._randomMaster()
 	} else {
 		weightSum := 0
 		index := rand.New(rand.NewSource(time.Now().UnixNano())).Intn(totalWeight)
@@ -284,21 +368,50 @@ func (group *DBGroup) _randomWeightMaster() proto.DB {
 }
 
 func (group *DBGroup) getAvailableMasters() []proto.DB {
+	if group.galera != nil {
+		return []proto.DB{group.galera.getWriter()}
+	}
 	dbs := make([]proto.DB, 0)
 	for _, db := range group.masters {
 		if db.Status() == proto.Running {
 			dbs = append(dbs, db)
 		}
 	}
+	if group.rejoin != nil {
+		// the writes still go to the rejoining masters if none of the masters is serving
+		if admitted := group.rejoin.admit(dbs); len(admitted) > 0 {
+			dbs = admitted
+		}
+	}
 	return dbs
 }
 
 func (group *DBGroup) getAvailableSlaves() []proto.DB {
-	slaves := make([]proto.DB, 0)
-	for _, slave := range group.slaves {
-		if slave.Status() == proto.Running {
-			slaves = append(slaves, slave)
+	var slaves []proto.DB
+	if group.galera != nil {
+		slaves = group.galera.getReaders()
+	} else {
+		slaves = make([]proto.DB, 0)
+		for _, slave := range group.slaves {
+			if slave.Status() == proto.Running {
+				slaves = append(slaves, slave)
+			}
+		}
+		if group.rejoin != nil {
+			slaves = group.rejoin.admit(slaves)
+		}
+		if group.aurora != nil {
+			slaves = append(slaves, group.aurora.getReaders()...)
+		}
+	}
+	if group.maintenance != nil {
+		available := make([]proto.DB, 0, len(slaves))
+		for _, slave := range slaves {
+			if !group.maintenance.isOpen(slave) {
+				available = append(available, slave)
+			}
 		}
+		slaves = available
 	}
 	return slaves
 }
@@ -0,0 +1,228 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package group
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uber-go/atomic"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+const (
+	DefaultHedgingPercentile = 0.95
+	DefaultHedgingMaxDelay   = time.Second
+	DefaultHedgingBudget     = 0.05
+
+	// latencyWindowSize is the number of recent read latencies the hedging delay is computed from
+	latencyWindowSize = 1000
+	// maxHedgingTokens bounds the burst of hedged reads after a long quiet period
+	maxHedgingTokens = 10
+
+	hedgingSent = "sent"
+	hedgingWon  = "won"
+)
+
+var hedgingCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "dbpack",
+	Subsystem: "hedging",
+	Name:      "count",
+	Help:      "hedged read count, and count of hedged reads answered before the original read",
+}, []string{"group", "result"})
+
+func init() {
+	prometheus.MustRegister(hedgingCounter)
+}
+
+type queryFunc func(ctx context.Context, db proto.DB) (proto.Result, uint16, error)
+
+type queryResult struct {
+	result proto.Result
+	warns  uint16
+	err    error
+	hedged bool
+}
+
+// hedger sends a read to a second slave if the first slave has not responded within a delay,
+// the delay is a percentile of the recent read latencies. Every read earns budget tokens and
+// every hedged read spends a token, so at most budget of the reads are sent twice.
+type hedger struct {
+	groupName  string
+	percentile float64
+	minDelay   time.Duration
+	maxDelay   time.Duration
+	budget     float64
+
+	mu        sync.Mutex
+	tokens    float64
+	latencies []time.Duration
+	next      int
+	observed  int
+	// delay in nanoseconds, it is recomputed every time the latency window is refilled
+	delay *atomic.Int64
+}
+
+// WithHedging enables hedged reads of the group.
+func WithHedging(conf *config.HedgingConfig) Option {
+	return func(group *DBGroup) error {
+		minDelay, maxDelay, err := conf.Delays()
+		if err != nil {
+			return err
+		}
+		group.hedger = newHedger(group.groupName, conf.Percentile, minDelay, maxDelay, conf.Budget)
+		return nil
+	}
+}
+
+func newHedger(groupName string, percentile float64, minDelay, maxDelay time.Duration, budget float64) *hedger {
+	if percentile <= 0 || percentile >= 1 {
+		percentile = DefaultHedgingPercentile
+	}
+	if maxDelay <= 0 {
+		maxDelay = DefaultHedgingMaxDelay
+	}
+	if budget <= 0 {
+		budget = DefaultHedgingBudget
+	}
+	return &hedger{
+		groupName:  groupName,
+		percentile: percentile,
+		minDelay:   minDelay,
+		maxDelay:   maxDelay,
+		budget:     budget,
+		latencies:  make([]time.Duration, latencyWindowSize),
+		delay:      atomic.NewInt64(int64(maxDelay)),
+	}
+}
+
+// query runs the read on db, and on another slave of slaves if db is slow and the budget allows,
+// the first successful result is returned and the other read is cancelled.
+func (h *hedger) query(ctx context.Context, db proto.DB, slaves []proto.DB, run queryFunc) (proto.Result, uint16, error) {
+	h.earn()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan queryResult, 2)
+	start := time.Now()
+	go func() {
+		result, warns, err := run(ctx, db)
+		results <- queryResult{result: result, warns: warns, err: err}
+	}()
+
+	timer := time.NewTimer(h.hedgingDelay())
+	defer timer.Stop()
+	select {
+	case res := <-results:
+		h.observe(time.Since(start))
+		return res.result, res.warns, res.err
+	case <-timer.C:
+	}
+
+	other := pickOther(db, slaves)
+	if other == nil || !h.spend() {
+		res := <-results
+		h.observe(time.Since(start))
+		return res.result, res.warns, res.err
+	}
+	hedgingCounter.WithLabelValues(h.groupName, hedgingSent).Inc()
+	go func() {
+		result, warns, err := run(ctx, other)
+		results <- queryResult{result: result, warns: warns, err: err, hedged: true}
+	}()
+
+	res := <-results
+	if res.err != nil {
+		// the other read may still succeed
+		res = <-results
+	}
+	if !res.hedged {
+		h.observe(time.Since(start))
+	} else if res.err == nil {
+		hedgingCounter.WithLabelValues(h.groupName, hedgingWon).Inc()
+	}
+	return res.result, res.warns, res.err
+}
+
+func (h *hedger) hedgingDelay() time.Duration {
+	return time.Duration(h.delay.Load())
+}
+
+func (h *hedger) earn() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.tokens += h.budget
+	if h.tokens > maxHedgingTokens {
+		h.tokens = maxHedgingTokens
+	}
+}
+
+func (h *hedger) spend() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.tokens < 1 {
+		return false
+	}
+	h.tokens--
+	return true
+}
+
+// observe records the latency of a read answered by the first slave.
+func (h *hedger) observe(latency time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.latencies[h.next] = latency
+	h.next = (h.next + 1) % len(h.latencies)
+	if h.observed < len(h.latencies) {
+		h.observed++
+	}
+	if h.next != 0 {
+		return
+	}
+
+	sorted := make([]time.Duration, h.observed)
+	copy(sorted, h.latencies[:h.observed])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	delay := sorted[int(float64(len(sorted)-1)*h.percentile)]
+	if delay < h.minDelay {
+		delay = h.minDelay
+	}
+	if delay > h.maxDelay {
+		delay = h.maxDelay
+	}
+	h.delay.Store(int64(delay))
+}
+
+// pickOther picks a random slave other than db.
+func pickOther(db proto.DB, slaves []proto.DB) proto.DB {
+	others := make([]proto.DB, 0, len(slaves))
+	for _, slave := range slaves {
+		if slave != db {
+			others = append(others, slave)
+		}
+	}
+	if len(others) == 0 {
+		return nil
+	}
+	return others[rand.Intn(len(others))]
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package group
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/testdata"
+)
+
+func TestHedgerQuery(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	fastResult := &mysql.Result{AffectedRows: 1}
+	slowResult := &mysql.Result{AffectedRows: 2}
+	slow := testdata.NewMockDB(ctrl)
+	fast := testdata.NewMockDB(ctrl)
+	slaves := []proto.DB{slow, fast}
+	run := func(ctx context.Context, db proto.DB) (proto.Result, uint16, error) {
+		return db.Query(ctx, "SELECT 1")
+	}
+
+	testCases := []struct {
+		name     string
+		first    proto.DB
+		tokens   float64
+		prepare  func()
+		expected proto.Result
+	}{
+		{
+			name:   "first slave responded in time",
+			first:  fast,
+			tokens: maxHedgingTokens,
+			prepare: func() {
+				fast.EXPECT().Query(gomock.Any(), "SELECT 1").Return(fastResult, uint16(0), nil)
+			},
+			expected: fastResult,
+		},
+		{
+			name:   "hedged read won and the slow read is cancelled",
+			first:  slow,
+			tokens: maxHedgingTokens,
+			prepare: func() {
+				slow.EXPECT().Query(gomock.Any(), "SELECT 1").DoAndReturn(
+					func(ctx context.Context, query string) (proto.Result, uint16, error) {
+						<-ctx.Done()
+						return nil, 0, ctx.Err()
+					})
+				fast.EXPECT().Query(gomock.Any(), "SELECT 1").Return(fastResult, uint16(0), nil)
+			},
+			expected: fastResult,
+		},
+		{
+			name:   "hedging budget exhausted",
+			first:  slow,
+			tokens: 0,
+			prepare: func() {
+				slow.EXPECT().Query(gomock.Any(), "SELECT 1").DoAndReturn(
+					func(ctx context.Context, query string) (proto.Result, uint16, error) {
+						time.Sleep(20 * time.Millisecond)
+						return slowResult, 0, nil
+					})
+			},
+			expected: slowResult,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			h := newHedger("test", 0.95, 0, 5*time.Millisecond, 0.01)
+			h.tokens = c.tokens
+			c.prepare()
+			result, _, err := h.query(context.Background(), c.first, slaves, run)
+			assert.NoError(t, err)
+			assert.Equal(t, c.expected, result)
+		})
+	}
+}
+
+func TestHedgerObserve(t *testing.T) {
+	h := newHedger("test", 0.9, 20*time.Millisecond, 500*time.Millisecond, 0.1)
+	assert.Equal(t, 500*time.Millisecond, h.hedgingDelay())
+	for i := 1; i <= latencyWindowSize; i++ {
+		h.observe(time.Duration(i) * 100 * time.Microsecond)
+	}
+	// 90th percentile of 0.1ms ~ 100ms
+	assert.Equal(t, 90*time.Millisecond, h.hedgingDelay())
+
+	for i := 1; i <= latencyWindowSize; i++ {
+		h.observe(time.Millisecond)
+	}
+	assert.Equal(t, 20*time.Millisecond, h.hedgingDelay())
+}
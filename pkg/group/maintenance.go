@@ -0,0 +1,279 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package group
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+// maintenanceCheckInterval is the interval of checking whether the maintenance windows are open,
+// the schedules are precise to the minute
+const maintenanceCheckInterval = 15 * time.Second
+
+var maintenanceWindowOpen = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "dbpack",
+	Subsystem: "group",
+	Name:      "maintenance_window_open",
+	Help:      "1 if a maintenance window of the data source is open and it is not read from, 0 otherwise",
+}, []string{"group", "data_source"})
+
+func init() {
+	prometheus.MustRegister(maintenanceWindowOpen)
+}
+
+// maintenance takes the data sources of a group out of the reads during their maintenance windows,
+// such as the backups of the replicas. Their read weight is zero during a window, it is restored
+// once the window is closed.
+type maintenance struct {
+	groupName string
+	dbs       []proto.DB
+	windows   [][]*maintenanceWindow
+
+	mu sync.RWMutex
+	// open are the names of the data sources whose window is open, with their read weight
+	open map[string]int
+}
+
+type maintenanceWindow struct {
+	schedule *cronSchedule
+	length   time.Duration
+	location *time.Location
+}
+
+// newMaintenance returns the maintenance of the data sources having maintenance windows, nil if
+// none of them has one.
+func newMaintenance(groupName string, dbs []proto.DB, dataSources []*config.DataSourceRef) (*maintenance, error) {
+	m := &maintenance{groupName: groupName, open: make(map[string]int)}
+	for i, dataSource := range dataSources {
+		if len(dataSource.MaintenanceWindows) == 0 {
+			continue
+		}
+		windows := make([]*maintenanceWindow, 0, len(dataSource.MaintenanceWindows))
+		for _, conf := range dataSource.MaintenanceWindows {
+			window, err := newMaintenanceWindow(conf)
+			if err != nil {
+				return nil, errors.Wrapf(err, "data source %s of group %s", dataSource.Name, groupName)
+			}
+			windows = append(windows, window)
+		}
+		m.dbs = append(m.dbs, dbs[i])
+		m.windows = append(m.windows, windows)
+		maintenanceWindowOpen.WithLabelValues(groupName, dataSource.Name).Set(0)
+	}
+	if len(m.dbs) == 0 {
+		return nil, nil
+	}
+	return m, nil
+}
+
+func newMaintenanceWindow(conf *config.MaintenanceWindow) (*maintenanceWindow, error) {
+	schedule, err := parseCronSchedule(conf.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	length, err := conf.Length()
+	if err != nil {
+		return nil, err
+	}
+	location, err := conf.Location()
+	if err != nil {
+		return nil, err
+	}
+	return &maintenanceWindow{schedule: schedule, length: length, location: location}, nil
+}
+
+func (m *maintenance) monitor(done <-chan struct{}) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-done:
+			return
+		}
+		m.refresh(time.Now())
+		timer.Reset(maintenanceCheckInterval)
+	}
+}
+
+// refresh opens and closes the windows of the data sources at now.
+func (m *maintenance) refresh(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, db := range m.dbs {
+		open := false
+		for _, window := range m.windows[i] {
+			if window.isOpen(now) {
+				open = true
+				break
+			}
+		}
+		readWeight, wasOpen := m.open[db.Name()]
+		switch {
+		case open && !wasOpen:
+			m.open[db.Name()] = db.ReadWeight()
+			db.SetReadWeight(0)
+			maintenanceWindowOpen.WithLabelValues(m.groupName, db.Name()).Set(1)
+			log.Infof("group %s: maintenance window of %s opened, it is not read from", m.groupName, db.Name())
+		case !open && wasOpen:
+			delete(m.open, db.Name())
+			db.SetReadWeight(readWeight)
+			maintenanceWindowOpen.WithLabelValues(m.groupName, db.Name()).Set(0)
+			log.Infof("group %s: maintenance window of %s closed, read weight %d restored",
+				m.groupName, db.Name(), readWeight)
+		}
+	}
+}
+
+// isOpen reports whether a maintenance window of the data source is open.
+func (m *maintenance) isOpen(db proto.DB) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.open[db.Name()]
+	return ok
+}
+
+// isOpen reports whether the window started less than its length before t.
+func (w *maintenanceWindow) isOpen(t time.Time) bool {
+	t = t.In(w.location)
+	earliest := t.Add(-w.length)
+	for start := t.Truncate(time.Minute); start.After(earliest); start = start.Add(-time.Minute) {
+		if w.schedule.matches(start) {
+			return true
+		}
+	}
+	return false
+}
+
+// cronSchedule is a parsed cron expression, each field is the bit set of the values it matches.
+type cronSchedule struct {
+	minutes, hours, days, months, weekdays uint64
+	// a time matches either the days of month or the days of week if both are restricted, as cron
+	// does, otherwise the restricted one
+	anyDay, anyWeekday bool
+}
+
+type cronBounds struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronBounds{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	// both 0 and 7 are sunday
+	{"day of week", 0, 7},
+}
+
+// parseCronSchedule parses the five fields of a cron expression, a field is *, a value, a range
+// such as 1-5, or a list of them, each optionally followed by a step such as */15.
+func parseCronSchedule(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, errors.Errorf("cron schedule '%s' should have %d fields", expr, len(cronFields))
+	}
+	bits := make([]uint64, len(fields))
+	for i, field := range fields {
+		var err error
+		if bits[i], err = parseCronField(field, cronFields[i]); err != nil {
+			return nil, errors.Wrapf(err, "invalid cron schedule '%s'", expr)
+		}
+	}
+	weekdays := bits[4]
+	if weekdays&(1<<7) != 0 {
+		weekdays = weekdays&^(1<<7) | 1
+	}
+	return &cronSchedule{
+		minutes:    bits[0],
+		hours:      bits[1],
+		days:       bits[2],
+		months:     bits[3],
+		weekdays:   weekdays,
+		anyDay:     strings.HasPrefix(fields[2], "*"),
+		anyWeekday: strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+func parseCronField(field string, bounds cronBounds) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		values, step := part, 1
+		i := strings.IndexByte(part, '/')
+		if i >= 0 {
+			var err error
+			values = part[:i]
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, errors.Errorf("%s has invalid step '%s'", bounds.name, part)
+			}
+		}
+		low, high := bounds.min, bounds.max
+		if values != "*" {
+			var err error
+			bound := values
+			if i := strings.IndexByte(values, '-'); i >= 0 {
+				bound = values[:i]
+				if high, err = strconv.Atoi(values[i+1:]); err != nil {
+					return 0, errors.Errorf("%s has invalid range '%s'", bounds.name, part)
+				}
+			}
+			if low, err = strconv.Atoi(bound); err != nil {
+				return 0, errors.Errorf("%s has invalid value '%s'", bounds.name, part)
+			}
+			if bound == values && i < 0 {
+				// a single value, a value with a step ranges up to the max
+				high = low
+			}
+			if low < bounds.min || high > bounds.max || low > high {
+				return 0, errors.Errorf("%s '%s' is out of range %d-%d", bounds.name, part, bounds.min, bounds.max)
+			}
+		}
+		for value := low; value <= high; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, nil
+}
+
+func (s *cronSchedule) matches(t time.Time) bool {
+	if s.minutes&(1<<uint(t.Minute())) == 0 || s.hours&(1<<uint(t.Hour())) == 0 ||
+		s.months&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	day := s.days&(1<<uint(t.Day())) != 0
+	weekday := s.weekdays&(1<<uint(t.Weekday())) != 0
+	switch {
+	case s.anyDay && s.anyWeekday:
+		return true
+	case s.anyDay:
+		return weekday
+	case s.anyWeekday:
+		return day
+	}
+	return day || weekday
+}
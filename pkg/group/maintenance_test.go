@@ -0,0 +1,133 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package group
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/testdata"
+)
+
+func TestParseCronSchedule(t *testing.T) {
+	cases := map[string]struct {
+		expr    string
+		matches []string
+		misses  []string
+	}{
+		"daily": {
+			expr:    "0 2 * * *",
+			matches: []string{"2022-06-01 02:00"},
+			misses:  []string{"2022-06-01 02:01", "2022-06-01 03:00"},
+		},
+		"weekdays": {
+			expr:    "30 1 * * 1-5",
+			matches: []string{"2022-06-03 01:30"},
+			misses:  []string{"2022-06-04 01:30"},
+		},
+		"steps and lists": {
+			expr:    "*/15 0,12 * * *",
+			matches: []string{"2022-06-01 00:45", "2022-06-01 12:15"},
+			misses:  []string{"2022-06-01 00:20", "2022-06-01 06:15"},
+		},
+		"sunday as 7": {
+			expr:    "0 0 * * 7",
+			matches: []string{"2022-06-05 00:00"},
+			misses:  []string{"2022-06-06 00:00"},
+		},
+		"day of month or day of week": {
+			expr:    "0 0 1 * 0",
+			matches: []string{"2022-06-01 00:00", "2022-06-05 00:00"},
+			misses:  []string{"2022-06-02 00:00"},
+		},
+	}
+
+	for caseTitle, tc := range cases {
+		t.Run(caseTitle, func(t *testing.T) {
+			schedule, err := parseCronSchedule(tc.expr)
+			assert.NoError(t, err)
+			for _, value := range tc.matches {
+				tm, _ := time.ParseInLocation("2006-01-02 15:04", value, time.Local)
+				assert.True(t, schedule.matches(tm), value)
+			}
+			for _, value := range tc.misses {
+				tm, _ := time.ParseInLocation("2006-01-02 15:04", value, time.Local)
+				assert.False(t, schedule.matches(tm), value)
+			}
+		})
+	}
+
+	for _, expr := range []string{"0 2 * *", "60 * * * *", "0 5-1 * * *", "*/0 * * * *", "a * * * *"} {
+		_, err := parseCronSchedule(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestMaintenanceWindow(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	newDB := func(name string, readWeight int) *testdata.MockDB {
+		db := testdata.NewMockDB(ctrl)
+		db.EXPECT().Name().Return(name).AnyTimes()
+		db.EXPECT().Status().Return(proto.Running).AnyTimes()
+		db.EXPECT().ReadWeight().Return(readWeight).AnyTimes()
+		return db
+	}
+	master, slave1, slave2 := newDB("master", 0), newDB("slave1", 10), newDB("slave2", 10)
+	m, err := newMaintenance("test", []proto.DB{master, slave1, slave2}, []*config.DataSourceRef{
+		{Name: "master"},
+		{Name: "slave1", MaintenanceWindows: []*config.MaintenanceWindow{
+			{Schedule: "0 2 * * *", Duration: "2h", TimeZone: "UTC"},
+		}},
+		{Name: "slave2"},
+	})
+	assert.NoError(t, err)
+	group := &DBGroup{
+		groupName:   "test",
+		masters:     []proto.DB{master},
+		slaves:      []proto.DB{slave1, slave2},
+		maintenance: m,
+	}
+
+	m.refresh(time.Date(2022, 6, 1, 1, 59, 0, 0, time.UTC))
+	assert.Equal(t, []string{"slave1", "slave2"}, names(group.getAvailableSlaves()))
+
+	slave1.EXPECT().SetReadWeight(0)
+	m.refresh(time.Date(2022, 6, 1, 2, 0, 30, 0, time.UTC))
+	assert.Equal(t, []string{"slave2"}, names(group.getAvailableSlaves()))
+	// still open, the read weight is not set again
+	m.refresh(time.Date(2022, 6, 1, 3, 59, 0, 0, time.UTC))
+	assert.Equal(t, []string{"slave2"}, names(group.getAvailableSlaves()))
+
+	slave1.EXPECT().SetReadWeight(10)
+	m.refresh(time.Date(2022, 6, 1, 4, 0, 0, 0, time.UTC))
+	assert.Equal(t, []string{"slave1", "slave2"}, names(group.getAvailableSlaves()))
+
+	_, err = newMaintenance("test", []proto.DB{slave1}, []*config.DataSourceRef{
+		{Name: "slave1", MaintenanceWindows: []*config.MaintenanceWindow{{Schedule: "0 2 * * *", Duration: "0s"}}},
+	})
+	assert.Error(t, err)
+	m, err = newMaintenance("test", []proto.DB{slave2}, []*config.DataSourceRef{{Name: "slave2"}})
+	assert.NoError(t, err)
+	assert.Nil(t, m)
+}
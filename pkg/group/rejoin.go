@@ -0,0 +1,340 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package group
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/events"
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+const (
+	DefaultRejoinProbationPeriod = 30 * time.Second
+	DefaultRejoinRampSteps       = 4
+	DefaultRejoinRampInterval    = 10 * time.Second
+
+	// rejoinCheckInterval is the interval of checking the status of the data sources
+	rejoinCheckInterval = time.Second
+
+	gtidExecutedQuery = "SELECT @@GLOBAL.gtid_executed"
+	// gtidSubsetQuery returns 1 if the data source has executed the gtid set
+	gtidSubsetQuery = "SELECT GTID_SUBSET('%s', @@GLOBAL.gtid_executed)"
+)
+
+// The kinds of the events of the rejoin of a data source.
+const (
+	EventDataSourceDown   = "data_source_down"
+	EventRejoinProbation  = "rejoin_probation"
+	EventRejoinCatchingUp = "rejoin_catching_up"
+	EventRejoinRamp       = "rejoin_ramp"
+	EventRejoinCompleted  = "rejoin_completed"
+)
+
+type rejoinPhase int
+
+const (
+	rejoinServing rejoinPhase = iota
+	rejoinDown
+	rejoinProbation
+	rejoinCatchingUp
+	rejoinRamping
+)
+
+// rejoin brings the data sources of a group which recovered from a failure back gradually, rather
+// than sending them their full share of the traffic as soon as they are healthy. A data source serves
+// nothing during the probation period, so that a flapping one is not used, then a slave optionally
+// waits until it has caught up with its master, at last its weights are raised step by step. The
+// changes are recorded as events.
+type rejoin struct {
+	appid        string
+	groupName    string
+	probation    time.Duration
+	verifyGTID   bool
+	rampSteps    int
+	rampInterval time.Duration
+	// weighted tells that the group picks the data sources by their weights, otherwise a ramping data
+	// source is picked with the probability of the step
+	weighted bool
+	// maintenance pauses the ramp of the data sources whose maintenance window is open, nil if none
+	// of them has one
+	maintenance *maintenance
+
+	mu      sync.RWMutex
+	members map[string]*rejoinMember
+	// order is the names of the members, in the order they are checked
+	order []string
+}
+
+type rejoinMember struct {
+	db proto.DB
+	// master is the master of a slave, nil for the masters
+	master      proto.DB
+	readWeight  int
+	writeWeight int
+
+	phase rejoinPhase
+	// since is when the phase or the current step of the ramp began
+	since time.Time
+	step  int
+	// gtidExecuted is the gtid set of the master a catching up slave waits for
+	gtidExecuted string
+}
+
+// WithRejoin brings the data sources of the group back gradually once they recover from a failure.
+func WithRejoin(appid string, conf *config.RejoinConfig) Option {
+	return func(group *DBGroup) error {
+		if group.galera != nil {
+			return errors.Errorf("group %s routes a galera cluster, whose nodes rejoin once synced", group.groupName)
+		}
+		probation, rampInterval, err := conf.Durations()
+		if err != nil {
+			return err
+		}
+		group.rejoin = newRejoin(appid, group, probation, conf.VerifyGTID, conf.RampSteps, rampInterval)
+		return nil
+	}
+}
+
+func newRejoin(appid string, group *DBGroup, probation time.Duration, verifyGTID bool,
+	rampSteps int, rampInterval time.Duration) *rejoin {
+	if probation <= 0 {
+		probation = DefaultRejoinProbationPeriod
+	}
+	if rampSteps <= 0 {
+		rampSteps = DefaultRejoinRampSteps
+	}
+	if rampInterval <= 0 {
+		rampInterval = DefaultRejoinRampInterval
+	}
+	r := &rejoin{
+		appid:        appid,
+		groupName:    group.groupName,
+		probation:    probation,
+		verifyGTID:   verifyGTID,
+		rampSteps:    rampSteps,
+		rampInterval: rampInterval,
+		weighted:     group.algorithm == config.RandomWeight,
+		members:      make(map[string]*rejoinMember),
+	}
+	for _, db := range group.masters {
+		r.add(db, nil)
+	}
+	for _, db := range group.slaves {
+		var master proto.DB
+		for _, m := range group.masters {
+			if strings.EqualFold(m.Name(), db.MasterName()) {
+				master = m
+			}
+		}
+		r.add(db, master)
+	}
+	return r
+}
+
+func (r *rejoin) add(db proto.DB, master proto.DB) {
+	r.members[db.Name()] = &rejoinMember{
+		db:          db,
+		master:      master,
+		readWeight:  db.ReadWeight(),
+		writeWeight: db.WriteWeight(),
+	}
+	r.order = append(r.order, db.Name())
+}
+
+func (r *rejoin) monitor(done <-chan struct{}) {
+	timer := time.NewTimer(rejoinCheckInterval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-done:
+			return
+		}
+		r.refresh(time.Now())
+		timer.Reset(rejoinCheckInterval)
+	}
+}
+
+// refresh moves the data sources to their next phase at now.
+func (r *rejoin) refresh(now time.Time) {
+	for _, name := range r.order {
+		member := r.members[name]
+		r.mu.RLock()
+		phase := member.phase
+		r.mu.RUnlock()
+		if member.db.Status() != proto.Running {
+			if phase != rejoinDown {
+				r.transit(member, rejoinDown, now)
+				r.record(member, EventDataSourceDown, "%s is down, it serves nothing until it rejoins", name)
+			}
+			continue
+		}
+		switch phase {
+		case rejoinDown:
+			r.transit(member, rejoinProbation, now)
+			r.setWeights(member, 0)
+			r.record(member, EventRejoinProbation, "%s recovered, it serves nothing during a probation of %s",
+				name, r.probation)
+		case rejoinProbation:
+			if now.Sub(member.since) < r.probation {
+				continue
+			}
+			if r.verifyGTID && member.master != nil {
+				gtidExecuted, err := queryString(member.master, gtidExecutedQuery)
+				if err != nil {
+					log.Warnf("group %s read the gtid set of %s failed, err: %v", r.groupName, member.master.Name(), err)
+					continue
+				}
+				r.transit(member, rejoinCatchingUp, now)
+				member.gtidExecuted = gtidExecuted
+				r.record(member, EventRejoinCatchingUp, "%s passed the probation, waiting for it to execute gtid set '%s' of %s",
+					name, gtidExecuted, member.master.Name())
+				continue
+			}
+			r.ramp(member, now)
+		case rejoinCatchingUp:
+			caughtUp, err := queryString(member.db, fmt.Sprintf(gtidSubsetQuery, member.gtidExecuted))
+			if err != nil {
+				log.Warnf("group %s check the gtid set of %s failed, err: %v", r.groupName, name, err)
+				continue
+			}
+			if caughtUp == "1" {
+				r.ramp(member, now)
+			}
+		case rejoinRamping:
+			if now.Sub(member.since) >= r.rampInterval && !r.maintenanceOpen(member.db) {
+				r.ramp(member, now)
+			}
+		}
+	}
+}
+
+// ramp raises the weights of member to the next step, it serves at full weight after the last one.
+func (r *rejoin) ramp(member *rejoinMember, now time.Time) {
+	r.mu.Lock()
+	member.step++
+	step := member.step
+	r.mu.Unlock()
+	if step >= r.rampSteps {
+		r.transit(member, rejoinServing, now)
+		r.setWeights(member, r.rampSteps)
+		r.record(member, EventRejoinCompleted, "%s rejoined, read weight %d, write weight %d",
+			member.db.Name(), member.readWeight, member.writeWeight)
+		return
+	}
+	r.transit(member, rejoinRamping, now)
+	r.setWeights(member, step)
+	r.record(member, EventRejoinRamp, "%s ramps up, step %d of %d, read weight %d, write weight %d",
+		member.db.Name(), step, r.rampSteps, member.db.ReadWeight(), member.db.WriteWeight())
+}
+
+func (r *rejoin) transit(member *rejoinMember, phase rejoinPhase, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	member.phase = phase
+	member.since = now
+	if phase != rejoinRamping {
+		member.step = 0
+	}
+}
+
+// setWeights sets the weights of member to the share of its configured weights at step, rounded up
+// so that the data source serves from the first step.
+func (r *rejoin) setWeights(member *rejoinMember, step int) {
+	share := func(weight int) int {
+		return (weight*step + r.rampSteps - 1) / r.rampSteps
+	}
+	if !r.maintenanceOpen(member.db) {
+		member.db.SetReadWeight(share(member.readWeight))
+	}
+	member.db.SetWriteWeight(share(member.writeWeight))
+}
+
+func (r *rejoin) maintenanceOpen(db proto.DB) bool {
+	return r.maintenance != nil && r.maintenance.isOpen(db)
+}
+
+func (r *rejoin) record(member *rejoinMember, kind string, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	log.Infof("group %s: %s", r.groupName, message)
+	events.Record(&events.Event{
+		Kind:          kind,
+		ApplicationID: r.appid,
+		Group:         r.groupName,
+		DataSource:    member.db.Name(),
+		Message:       message,
+	})
+}
+
+// admit returns the data sources of dbs which serve, a ramping one is admitted with the probability
+// of its step unless the group picks the data sources by their weights.
+func (r *rejoin) admit(dbs []proto.DB) []proto.DB {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	admitted := make([]proto.DB, 0, len(dbs))
+	for _, db := range dbs {
+		member, ok := r.members[db.Name()]
+		if !ok || member.phase == rejoinServing {
+			admitted = append(admitted, db)
+			continue
+		}
+		if member.phase == rejoinRamping && (r.weighted || rand.Intn(r.rampSteps) < member.step) {
+			admitted = append(admitted, db)
+		}
+	}
+	return admitted
+}
+
+// queryString returns the first column of the first row the query returns.
+func queryString(db proto.DB, query string) (string, error) {
+	result, _, err := db.QueryDirectly(query)
+	if err != nil {
+		return "", err
+	}
+	rlt, ok := result.(*mysql.Result)
+	if !ok {
+		return "", errors.Errorf("unexpected result %T", result)
+	}
+	defer rlt.Release()
+	if len(rlt.Rows) == 0 {
+		return "", errors.Errorf("%s returns no row", query)
+	}
+	values, err := rlt.Rows[0].Decode()
+	if err != nil {
+		return "", err
+	}
+	if len(values) == 0 || values[0] == nil {
+		return "", nil
+	}
+	switch v := values[0].Val.(type) {
+	case int64:
+		return fmt.Sprint(v), nil
+	case uint64:
+		return fmt.Sprint(v), nil
+	}
+	return toString(values[0].Val), nil
+}
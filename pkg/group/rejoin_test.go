@@ -0,0 +1,178 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package group
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/events"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/testdata"
+)
+
+type rejoinTestNode struct {
+	running      bool
+	readWeight   int
+	writeWeight  int
+	gtidExecuted string
+}
+
+func newRejoinTestDB(ctrl *gomock.Controller, name, masterName string, node *rejoinTestNode) proto.DB {
+	db := testdata.NewMockDB(ctrl)
+	db.EXPECT().Name().Return(name).AnyTimes()
+	db.EXPECT().MasterName().Return(masterName).AnyTimes()
+	db.EXPECT().Status().DoAndReturn(func() proto.DBStatus {
+		if node.running {
+			return proto.Running
+		}
+		return proto.Unknown
+	}).AnyTimes()
+	db.EXPECT().ReadWeight().DoAndReturn(func() int { return node.readWeight }).AnyTimes()
+	db.EXPECT().WriteWeight().DoAndReturn(func() int { return node.writeWeight }).AnyTimes()
+	db.EXPECT().SetReadWeight(gomock.Any()).Do(func(weight int) { node.readWeight = weight }).AnyTimes()
+	db.EXPECT().SetWriteWeight(gomock.Any()).Do(func(weight int) { node.writeWeight = weight }).AnyTimes()
+	db.EXPECT().QueryDirectly(gomock.Any()).DoAndReturn(func(query string) (proto.Result, uint16, error) {
+		value := node.gtidExecuted
+		if strings.HasPrefix(query, "SELECT GTID_SUBSET") {
+			value = "0"
+			if strings.Contains(query, "'"+node.gtidExecuted+"'") {
+				value = "1"
+			}
+		}
+		result := &mysql.Result{Fields: []*mysql.Field{{Name: "value", FieldType: constant.FieldTypeVarString}}}
+		ctx := proto.WithCommandType(context.Background(), constant.ComQuery)
+		data := append([]byte{byte(len(value))}, value...)
+		if err := result.AppendRow(ctx, data); err != nil {
+			return nil, 0, err
+		}
+		return result, 0, nil
+	}).AnyTimes()
+	return db
+}
+
+func TestRejoin(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	master := &rejoinTestNode{running: true, writeWeight: 10, gtidExecuted: "3e11fa47:1-10"}
+	slave := &rejoinTestNode{running: true, readWeight: 10, gtidExecuted: "3e11fa47:1-5"}
+	group := &DBGroup{
+		groupName: "test",
+		algorithm: config.RandomWeight,
+		masters:   []proto.DB{newRejoinTestDB(ctrl, "master", "", master)},
+		slaves:    []proto.DB{newRejoinTestDB(ctrl, "slave", "master", slave)},
+	}
+	group.rejoin = newRejoin("app", group, 30*time.Second, true, 4, 10*time.Second)
+	var since int64
+	if recorded := events.List(0); len(recorded) > 0 {
+		since = recorded[len(recorded)-1].ID
+	}
+
+	now := time.Now()
+	group.rejoin.refresh(now)
+	assert.Equal(t, []string{"slave"}, names(group.getAvailableSlaves()))
+
+	slave.running = false
+	group.rejoin.refresh(now.Add(time.Second))
+	slave.running = true
+	now = now.Add(2 * time.Second)
+	group.rejoin.refresh(now)
+	assert.Empty(t, group.getAvailableSlaves())
+	assert.Equal(t, 0, slave.readWeight)
+
+	// the slave waits for the gtid set of the master after the probation
+	group.rejoin.refresh(now.Add(29 * time.Second))
+	group.rejoin.refresh(now.Add(30 * time.Second))
+	group.rejoin.refresh(now.Add(31 * time.Second))
+	assert.Empty(t, group.getAvailableSlaves())
+
+	slave.gtidExecuted = master.gtidExecuted
+	now = now.Add(32 * time.Second)
+	group.rejoin.refresh(now)
+	assert.Equal(t, []string{"slave"}, names(group.getAvailableSlaves()))
+	assert.Equal(t, 3, slave.readWeight)
+
+	for i, weight := range []int{5, 8, 10} {
+		group.rejoin.refresh(now.Add(time.Duration(i)*10*time.Second + 5*time.Second))
+		group.rejoin.refresh(now.Add(time.Duration(i+1) * 10 * time.Second))
+		assert.Equal(t, weight, slave.readWeight)
+	}
+	assert.Equal(t, 10, master.writeWeight)
+
+	kinds := make([]string, 0)
+	for _, event := range events.List(since) {
+		assert.Equal(t, "app", event.ApplicationID)
+		assert.Equal(t, "slave", event.DataSource)
+		kinds = append(kinds, event.Kind)
+	}
+	assert.Equal(t, []string{EventDataSourceDown, EventRejoinProbation, EventRejoinCatchingUp,
+		EventRejoinRamp, EventRejoinRamp, EventRejoinRamp, EventRejoinCompleted}, kinds)
+}
+
+func TestRejoinMasters(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m1 := &rejoinTestNode{running: true, writeWeight: 10}
+	m2 := &rejoinTestNode{running: true, writeWeight: 10}
+	group := &DBGroup{
+		groupName: "test",
+		algorithm: config.RandomWeight,
+		masters:   []proto.DB{newRejoinTestDB(ctrl, "m1", "", m1), newRejoinTestDB(ctrl, "m2", "", m2)},
+	}
+	group.rejoin = newRejoin("app", group, time.Second, false, 2, time.Second)
+
+	now := time.Now()
+	m1.running, m2.running = false, false
+	group.rejoin.refresh(now)
+	m1.running, m2.running = true, true
+	group.rejoin.refresh(now.Add(time.Second))
+	// the writes go to the rejoining masters since none is serving
+	assert.Equal(t, []string{"m1", "m2"}, names(group.getAvailableMasters()))
+	assert.NotNil(t, group._randomWeightMaster())
+
+	group.rejoin.refresh(now.Add(2 * time.Second))
+	assert.Equal(t, 5, m1.writeWeight)
+	group.rejoin.refresh(now.Add(3 * time.Second))
+	assert.Equal(t, 10, m2.writeWeight)
+}
+
+func TestMonitorStopsOnClose(t *testing.T) {
+	group := &DBGroup{done: make(chan struct{})}
+	stopped := make(chan struct{})
+	go func() {
+		(&rejoin{}).monitor(group.done)
+		close(stopped)
+	}()
+	group.Close()
+	// closing again is a no-op
+	group.Close()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("the monitor did not stop")
+	}
+}
@@ -18,6 +18,8 @@ package group
 
 import (
 	"context"
+	"sort"
+	"sync"
 
 	"github.com/pkg/errors"
 	"github.com/uber-go/atomic"
@@ -37,8 +39,10 @@ var (
 )
 
 type ComplexTx struct {
-	closed    *atomic.Bool
-	id        uint32
+	closed *atomic.Bool
+	id     uint32
+	// mu guards txs against the reads of Transactions
+	mu        sync.Mutex
 	txs       map[string]proto.Tx
 	optimizer proto.Optimizer
 }
@@ -95,10 +99,28 @@ func (tx *ComplexTx) Begin(ctx context.Context, executor proto.DBGroupExecutor)
 		return nil, err
 	}
 	log.Debugf("DBGroup %s has begun local transaction!", executor.GroupName())
+	tx.mu.Lock()
 	tx.txs[executor.GroupName()] = childTx
+	tx.mu.Unlock()
 	return childTx, nil
 }
 
+// Transactions returns the transactions begun on the db groups, ordered by the names of the groups.
+func (tx *ComplexTx) Transactions() []proto.Tx {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	groups := make([]string, 0, len(tx.txs))
+	for group := range tx.txs {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	txs := make([]proto.Tx, 0, len(groups))
+	for _, group := range groups {
+		txs = append(txs, tx.txs[group])
+	}
+	return txs
+}
+
 func (tx *ComplexTx) Commit(ctx context.Context) (result proto.Result, err error) {
 	spanCtx, span := tracing.GetTraceSpan(ctx, tracing.GroupTxCommit)
 	defer span.End()
@@ -166,5 +188,7 @@ func (tx *ComplexTx) Rollback(ctx context.Context) (result proto.Result, err err
 
 func (tx *ComplexTx) Close() {
 	tx.closed.Swap(true)
+	tx.mu.Lock()
 	tx.txs = nil
+	tx.mu.Unlock()
 }
@@ -19,7 +19,9 @@ package http
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/gorilla/mux"
 
@@ -29,11 +31,19 @@ import (
 )
 
 const (
-	deadBranchSessionsPath = "/deadBranchSessions"
+	deadBranchSessionsPath             = "/deadBranchSessions"
+	deadBranchSessionConflictsPath     = "/deadBranchSessions/{applicationID}/{branchSessionID}/conflicts"
+	deadBranchSessionResolvePath       = "/deadBranchSessions/{applicationID}/{branchSessionID}/resolve"
+	deadBranchSessionForceRollbackPath = "/deadBranchSessions/{applicationID}/{branchSessionID}/forceRollback"
+	xaBranchSessionOutcomesPath        = "/xaBranchSessions/outcomes"
 )
 
 func registerBranchSessionsRouter(router *mux.Router) {
 	router.Methods(http.MethodGet).Path(deadBranchSessionsPath).HandlerFunc(deadBranchSessionHandler)
+	router.Methods(http.MethodGet).Path(deadBranchSessionConflictsPath).HandlerFunc(deadBranchSessionConflictsHandler)
+	router.Methods(http.MethodPost).Path(deadBranchSessionResolvePath).HandlerFunc(deadBranchSessionResolveHandler)
+	router.Methods(http.MethodPost).Path(deadBranchSessionForceRollbackPath).HandlerFunc(deadBranchSessionForceRollbackHandler)
+	router.Methods(http.MethodGet).Path(xaBranchSessionOutcomesPath).HandlerFunc(xaBranchSessionOutcomesHandler)
 }
 
 func deadBranchSessionHandler(w http.ResponseWriter, r *http.Request) {
@@ -59,3 +69,90 @@ func deadBranchSessionHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(b)
 	w.WriteHeader(http.StatusOK)
 }
+
+// deadBranchSessionConflictsHandler shows the before image, after image and current rows of
+// a dead AT branch session, so that the operator can decide how to compensate it.
+func deadBranchSessionConflictsHandler(w http.ResponseWriter, r *http.Request) {
+	compensator, branchID, ok := parseDeadBranchSession(w, r)
+	if !ok {
+		return
+	}
+	conflicts, err := compensator.InspectDeadBranchSession(r.Context(), branchID)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	b, err := json.Marshal(conflicts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+func deadBranchSessionResolveHandler(w http.ResponseWriter, r *http.Request) {
+	compensator, branchID, ok := parseDeadBranchSession(w, r)
+	if !ok {
+		return
+	}
+	if err := compensator.ResolveDeadBranchSession(r.Context(), branchID); err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func deadBranchSessionForceRollbackHandler(w http.ResponseWriter, r *http.Request) {
+	compensator, branchID, ok := parseDeadBranchSession(w, r)
+	if !ok {
+		return
+	}
+	if err := compensator.ForceRollbackDeadBranchSession(r.Context(), branchID); err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// xaBranchSessionOutcomesHandler shows the outcomes of the last phase two attempts of the XA
+// branch sessions recently committed or rolled back by this node, by application.
+func xaBranchSessionOutcomesHandler(w http.ResponseWriter, r *http.Request) {
+	result := make(map[string][]*dt.XABranchOutcome)
+	for _, applicationID := range applicationIDs {
+		if inspector := dt.GetXABranchInspector(applicationID); inspector != nil {
+			if outcomes := inspector.ListXABranchOutcomes(); len(outcomes) != 0 {
+				result[applicationID] = outcomes
+			}
+		}
+	}
+	b, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+func parseDeadBranchSession(w http.ResponseWriter, r *http.Request) (dt.ManualCompensator, string, bool) {
+	vars := mux.Vars(r)
+	applicationID := vars["applicationID"]
+	branchSessionID, err := strconv.ParseInt(vars["branchSessionID"], 10, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid branch session id: %s", vars["branchSessionID"]), http.StatusBadRequest)
+		return nil, "", false
+	}
+	compensator := dt.GetManualCompensator(applicationID)
+	if compensator == nil {
+		http.Error(w, fmt.Sprintf("distributed transaction is not enabled for application: %s", applicationID), http.StatusNotFound)
+		return nil, "", false
+	}
+	// branch id is in the format of bs/${ApplicationID}/${BranchSessionID}
+	return compensator, fmt.Sprintf("bs/%s/%d", applicationID, branchSessionID), true
+}
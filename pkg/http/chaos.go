@@ -0,0 +1,96 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/cectc/dbpack/pkg/filter"
+	"github.com/cectc/dbpack/pkg/filter/chaos"
+)
+
+const (
+	chaosFilterPath        = "/chaos/{applicationID}/{filterName}"
+	chaosFilterEnablePath  = "/chaos/{applicationID}/{filterName}/enable"
+	chaosFilterDisablePath = "/chaos/{applicationID}/{filterName}/disable"
+)
+
+func registerChaosRouter(router *mux.Router) {
+	router.Methods(http.MethodGet).Path(chaosFilterPath).HandlerFunc(chaosFilterHandler)
+	router.Methods(http.MethodPut).Path(chaosFilterPath).HandlerFunc(chaosFilterUpdateHandler)
+	router.Methods(http.MethodPost).Path(chaosFilterEnablePath).HandlerFunc(chaosFilterToggleHandler(true))
+	router.Methods(http.MethodPost).Path(chaosFilterDisablePath).HandlerFunc(chaosFilterToggleHandler(false))
+}
+
+// chaosFilterHandler shows the faults currently configured on a chaos filter.
+func chaosFilterHandler(w http.ResponseWriter, r *http.Request) {
+	injector, ok := lookupChaosFilter(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, injector.Config())
+}
+
+// chaosFilterUpdateHandler replaces the faults of a chaos filter with the config in the request body.
+func chaosFilterUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	injector, ok := lookupChaosFilter(w, r)
+	if !ok {
+		return
+	}
+	conf := &chaos.Config{}
+	if err := json.NewDecoder(r.Body).Decode(conf); err != nil {
+		http.Error(w, fmt.Sprintf("invalid chaos filter config: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := injector.Update(conf); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, injector.Config())
+}
+
+// chaosFilterToggleHandler switches fault injection on or off, keeping the configured faults.
+func chaosFilterToggleHandler(enabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		injector, ok := lookupChaosFilter(w, r)
+		if !ok {
+			return
+		}
+		conf := *injector.Config()
+		conf.Enabled = enabled
+		if err := injector.Update(&conf); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, injector.Config())
+	}
+}
+
+func lookupChaosFilter(w http.ResponseWriter, r *http.Request) (chaos.Injector, bool) {
+	vars := mux.Vars(r)
+	applicationID, filterName := vars["applicationID"], vars["filterName"]
+	injector, ok := filter.GetFilter(applicationID, filterName).(chaos.Injector)
+	if !ok {
+		http.Error(w, fmt.Sprintf("there is no chaos filter %s for application: %s", filterName, applicationID), http.StatusNotFound)
+		return nil, false
+	}
+	return injector, true
+}
@@ -0,0 +1,116 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/cectc/dbpack/pkg/checksum"
+	"github.com/cectc/dbpack/pkg/resource"
+)
+
+// checksumsPath serves the checks of the tables of a data source against another data source.
+const checksumsPath = "/checksums"
+
+func registerChecksumsRouter(router *mux.Router) {
+	router.Methods(http.MethodGet).Path(checksumsPath).HandlerFunc(checksumsHandler)
+	router.Methods(http.MethodPost).Path(checksumsPath).HandlerFunc(checksumStartHandler)
+	router.Methods(http.MethodGet).Path(checksumsPath + "/{id}").HandlerFunc(checksumHandler)
+	router.Methods(http.MethodDelete).Path(checksumsPath + "/{id}").HandlerFunc(checksumCancelHandler)
+}
+
+// checksumRequest starts a check of the tables of target against source, both are data sources
+// of the application.
+type checksumRequest struct {
+	ApplicationID string   `json:"application_id"`
+	Source        string   `json:"source"`
+	Target        string   `json:"target"`
+	Tables        []string `json:"tables"`
+	ChunkSize     int      `json:"chunk_size"`
+	ChunkInterval string   `json:"chunk_interval"`
+	Repair        bool     `json:"repair"`
+}
+
+// checksumsHandler lists the checks with their mismatched chunks.
+func checksumsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, checksum.List())
+}
+
+func checksumStartHandler(w http.ResponseWriter, r *http.Request) {
+	req := &checksumRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid checksum request: %v", err), http.StatusBadRequest)
+		return
+	}
+	manager := resource.GetDBManager(req.ApplicationID)
+	if manager == nil {
+		http.Error(w, fmt.Sprintf("application %s not found", req.ApplicationID), http.StatusNotFound)
+		return
+	}
+	source, target := manager.GetDB(req.Source), manager.GetDB(req.Target)
+	if source == nil || target == nil {
+		http.Error(w, fmt.Sprintf("data source %s or %s not found", req.Source, req.Target), http.StatusNotFound)
+		return
+	}
+	conf := &checksum.Config{Tables: req.Tables, ChunkSize: req.ChunkSize, Repair: req.Repair}
+	if req.ChunkInterval != "" {
+		interval, err := time.ParseDuration(req.ChunkInterval)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid chunk interval %s", req.ChunkInterval), http.StatusBadRequest)
+			return
+		}
+		conf.ChunkInterval = interval
+	}
+	c, err := checksum.Start(source, target, conf)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, c.Status())
+}
+
+func checksumHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := getChecksum(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, c.Status())
+}
+
+// checksumCancelHandler stops a check, the chunks checked are still reported.
+func checksumCancelHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := getChecksum(w, r)
+	if !ok {
+		return
+	}
+	c.Cancel()
+	writeJSON(w, c.Status())
+}
+
+func getChecksum(w http.ResponseWriter, r *http.Request) (*checksum.Check, bool) {
+	id := mux.Vars(r)["id"]
+	c, ok := checksum.Get(id)
+	if !ok {
+		http.Error(w, fmt.Sprintf("checksum %s not found", id), http.StatusNotFound)
+	}
+	return c, ok
+}
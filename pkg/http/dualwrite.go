@@ -0,0 +1,74 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/cectc/dbpack/pkg/filter"
+	"github.com/cectc/dbpack/pkg/filter/dualwrite"
+)
+
+const dualWriteFilterPath = "/dual_write/{applicationID}/{filterName}"
+
+func registerDualWriteRouter(router *mux.Router) {
+	router.Methods(http.MethodGet).Path(dualWriteFilterPath).HandlerFunc(dualWriteFilterHandler)
+	router.Methods(http.MethodPut).Path(dualWriteFilterPath).HandlerFunc(dualWriteFilterUpdateHandler)
+}
+
+// dualWriteFilterHandler shows the migrated tables of a dual write filter and where they are read from.
+func dualWriteFilterHandler(w http.ResponseWriter, r *http.Request) {
+	switcher, ok := lookupDualWriteFilter(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, switcher.Config())
+}
+
+// dualWriteFilterUpdateHandler replaces the migrated tables of a dual write filter with the config
+// in the request body, which switches the reads of the tables.
+func dualWriteFilterUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	switcher, ok := lookupDualWriteFilter(w, r)
+	if !ok {
+		return
+	}
+	conf := &dualwrite.Config{}
+	if err := json.NewDecoder(r.Body).Decode(conf); err != nil {
+		http.Error(w, fmt.Sprintf("invalid dual write filter config: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := switcher.Update(conf); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, switcher.Config())
+}
+
+func lookupDualWriteFilter(w http.ResponseWriter, r *http.Request) (dualwrite.Switcher, bool) {
+	vars := mux.Vars(r)
+	applicationID, filterName := vars["applicationID"], vars["filterName"]
+	switcher, ok := filter.GetFilter(applicationID, filterName).(dualwrite.Switcher)
+	if !ok {
+		http.Error(w, fmt.Sprintf("there is no dual write filter %s for application: %s", filterName, applicationID), http.StatusNotFound)
+		return nil, false
+	}
+	return switcher, true
+}
@@ -0,0 +1,56 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/cectc/dbpack/pkg/events"
+)
+
+// eventsPath serves the recent changes of the state of the data sources, such as their rejoins.
+const eventsPath = "/events"
+
+func registerEventsRouter(router *mux.Router) {
+	router.Methods(http.MethodGet).Path(eventsPath).HandlerFunc(eventsHandler)
+}
+
+// eventsHandler lists the events recorded after the id of the since parameter, optionally of an
+// application_id and of a kind, so that a client can poll the events with the id of the last one.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var since int64
+	if value := query.Get("since"); value != "" {
+		var err error
+		if since, err = strconv.ParseInt(value, 10, 64); err != nil {
+			http.Error(w, fmt.Sprintf("invalid since %s", value), http.StatusBadRequest)
+			return
+		}
+	}
+	applicationID, kind := query.Get("application_id"), query.Get("kind")
+	result := make([]*events.Event, 0)
+	for _, event := range events.List(since) {
+		if (applicationID == "" || event.ApplicationID == applicationID) && (kind == "" || event.Kind == kind) {
+			result = append(result, event)
+		}
+	}
+	writeJSON(w, result)
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/executor"
+	"github.com/cectc/dbpack/pkg/filter"
+)
+
+const (
+	filtersPath               = "/filters/{applicationID}"
+	executorFiltersPath       = "/executors/{applicationID}/{executor}/filters"
+	executorFilterEnablePath  = "/executors/{applicationID}/{executor}/filters/{filterName}/enable"
+	executorFilterDisablePath = "/executors/{applicationID}/{executor}/filters/{filterName}/disable"
+)
+
+func registerFiltersRouter(router *mux.Router) {
+	router.Methods(http.MethodGet).Path(filtersPath).HandlerFunc(filtersHandler)
+	router.Methods(http.MethodPost).Path(filtersPath).HandlerFunc(createFilterHandler)
+	router.Methods(http.MethodGet).Path(executorFiltersPath).HandlerFunc(executorFiltersHandler)
+	router.Methods(http.MethodPut).Path(executorFiltersPath).HandlerFunc(setExecutorFiltersHandler)
+	router.Methods(http.MethodPost).Path(executorFilterEnablePath).HandlerFunc(enableExecutorFilterHandler)
+	router.Methods(http.MethodPost).Path(executorFilterDisablePath).HandlerFunc(disableExecutorFilterHandler)
+}
+
+// filtersHandler lists the filters of an application with their kinds.
+func filtersHandler(w http.ResponseWriter, r *http.Request) {
+	result := make(map[string]string)
+	for name, f := range filter.GetFilters(mux.Vars(r)["applicationID"]) {
+		result[name] = f.GetKind()
+	}
+	writeJSON(w, result)
+}
+
+// createFilterHandler creates a filter from the config in the request body, so that it can be enabled
+// on executors without restarting.
+func createFilterHandler(w http.ResponseWriter, r *http.Request) {
+	applicationID := mux.Vars(r)["applicationID"]
+	conf := &config.Filter{}
+	if err := json.NewDecoder(r.Body).Decode(conf); err != nil {
+		http.Error(w, fmt.Sprintf("invalid filter config: %v", err), http.StatusBadRequest)
+		return
+	}
+	if conf.Name == "" {
+		http.Error(w, "filter name is required", http.StatusBadRequest)
+		return
+	}
+	if filter.GetFilter(applicationID, conf.Name) != nil {
+		http.Error(w, fmt.Sprintf("filter %s already exists", conf.Name), http.StatusConflict)
+		return
+	}
+	f, err := filter.NewFilter(applicationID, conf)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.RegisterFilter(applicationID, conf.Name, f)
+	writeJSON(w, map[string]string{conf.Name: f.GetKind()})
+}
+
+// executorFiltersHandler shows the filters of an executor in order.
+func executorFiltersHandler(w http.ResponseWriter, r *http.Request) {
+	chain, ok := lookupFilterChain(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, chain.Names())
+}
+
+// setExecutorFiltersHandler replaces the filters of an executor with the names in the request body in order.
+func setExecutorFiltersHandler(w http.ResponseWriter, r *http.Request) {
+	chain, ok := lookupFilterChain(w, r)
+	if !ok {
+		return
+	}
+	var names []string
+	if err := json.NewDecoder(r.Body).Decode(&names); err != nil {
+		http.Error(w, fmt.Sprintf("invalid filter names: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := chain.Set(names); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, chain.Names())
+}
+
+// enableExecutorFilterHandler inserts a filter into the filters of an executor at the position query
+// parameter, or appends it if the position is not given.
+func enableExecutorFilterHandler(w http.ResponseWriter, r *http.Request) {
+	chain, ok := lookupFilterChain(w, r)
+	if !ok {
+		return
+	}
+	position := -1
+	if value := r.URL.Query().Get("position"); value != "" {
+		var err error
+		if position, err = strconv.Atoi(value); err != nil {
+			http.Error(w, fmt.Sprintf("invalid position: %s", value), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := chain.Enable(mux.Vars(r)["filterName"], position); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, chain.Names())
+}
+
+func disableExecutorFilterHandler(w http.ResponseWriter, r *http.Request) {
+	chain, ok := lookupFilterChain(w, r)
+	if !ok {
+		return
+	}
+	chain.Disable(mux.Vars(r)["filterName"])
+	writeJSON(w, chain.Names())
+}
+
+func lookupFilterChain(w http.ResponseWriter, r *http.Request) (*executor.FilterChain, bool) {
+	vars := mux.Vars(r)
+	applicationID, executorName := vars["applicationID"], vars["executor"]
+	chain := executor.GetFilterChain(applicationID, executorName)
+	if chain == nil {
+		http.Error(w, fmt.Sprintf("there is no executor %s for application: %s", executorName, applicationID), http.StatusNotFound)
+		return nil, false
+	}
+	return chain, true
+}
@@ -17,10 +17,12 @@
 package http
 
 import (
+	"encoding/json"
 	"net/http"
 
 	"github.com/gorilla/mux"
 
+	"github.com/cectc/dbpack/pkg/config"
 	"github.com/cectc/dbpack/pkg/resource"
 )
 
@@ -29,18 +31,35 @@ const (
 	healthCheckReadinessPath = "/ready"
 )
 
+// readiness is the readiness policy of the readiness probe
+var readiness = &config.ReadinessConfig{}
+
+// SetReadiness sets the readiness policy of the readiness probe.
+func SetReadiness(conf *config.ReadinessConfig) {
+	readiness = conf
+}
+
 func registerHealthCheckRouter(router *mux.Router) {
 	router.Methods(http.MethodGet).Path(healthCheckReadinessPath).HandlerFunc(readinessHandler)
 	router.Methods(http.MethodGet).Path(healthCheckLivenessPath).HandlerFunc(livenessHandler)
 }
 
+// readinessHandler reports the readiness policy and the states of the data sources, the status is
+// 503 if dbpack is not ready.
 func readinessHandler(w http.ResponseWriter, r *http.Request) {
-	err := resource.DetectDBs()
+	result := resource.CheckReadiness(readiness)
+	b, err := json.Marshal(result)
 	if err != nil {
-		w.WriteHeader(http.StatusServiceUnavailable)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	if result.Ready {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(b)
 }
 
 func livenessHandler(w http.ResponseWriter, r *http.Request) {
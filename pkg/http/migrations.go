@@ -0,0 +1,68 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/cectc/dbpack/pkg/osc"
+)
+
+// migrationsPath serves the progress of the online ALTER TABLE of the sharded tables.
+const migrationsPath = "/debug/migrations"
+
+func registerMigrationsRouter(router *mux.Router) {
+	router.Methods(http.MethodGet).Path(migrationsPath).HandlerFunc(migrationsHandler)
+	router.Methods(http.MethodGet).Path(migrationsPath + "/{id}").HandlerFunc(migrationHandler)
+	router.Methods(http.MethodDelete).Path(migrationsPath + "/{id}").HandlerFunc(migrationCancelHandler)
+}
+
+// migrationsHandler lists the migrations with the progress of their shards.
+func migrationsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, osc.List())
+}
+
+func migrationHandler(w http.ResponseWriter, r *http.Request) {
+	migration, ok := getMigration(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, migration.Status())
+}
+
+// migrationCancelHandler cancels a migration before its cut-over, the ghost tables and the
+// triggers of it are removed.
+func migrationCancelHandler(w http.ResponseWriter, r *http.Request) {
+	migration, ok := getMigration(w, r)
+	if !ok {
+		return
+	}
+	migration.Cancel()
+	writeJSON(w, migration.Status())
+}
+
+func getMigration(w http.ResponseWriter, r *http.Request) (*osc.Migration, bool) {
+	id := mux.Vars(r)["id"]
+	migration, ok := osc.Get(id)
+	if !ok {
+		http.Error(w, fmt.Sprintf("migration %s not found", id), http.StatusNotFound)
+	}
+	return migration, ok
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/cectc/dbpack/pkg/optimize"
+)
+
+// planCachePath serves the plan caches of sharding executors, the query parameters application_id
+// and executor narrow the caches, and sql narrows the flushed plans to those of a sql text.
+const planCachePath = "/debug/plancache"
+
+type planCacheStatus struct {
+	Capacity  int64                      `json:"capacity"`
+	Evictions int64                      `json:"evictions"`
+	Entries   []*optimize.PlanCacheEntry `json:"entries"`
+}
+
+func registerPlanCacheRouter(router *mux.Router) {
+	router.Methods(http.MethodGet).Path(planCachePath).HandlerFunc(planCacheHandler)
+	router.Methods(http.MethodDelete).Path(planCachePath).HandlerFunc(planCacheFlushHandler)
+}
+
+// planCacheHandler lists the cached plans with their hits and the physical statements they expand to.
+func planCacheHandler(w http.ResponseWriter, r *http.Request) {
+	result := make(map[string]map[string]*planCacheStatus)
+	forEachPlanCache(r, func(applicationID, executor string, planCache *optimize.PlanCache) {
+		if result[applicationID] == nil {
+			result[applicationID] = make(map[string]*planCacheStatus)
+		}
+		result[applicationID][executor] = &planCacheStatus{
+			Capacity:  planCache.Capacity(),
+			Evictions: planCache.Evictions(),
+			Entries:   planCache.Entries(),
+		}
+	})
+	writeJSON(w, result)
+}
+
+// planCacheFlushHandler removes cached plans, so that stale plans are optimized again after rule changes.
+func planCacheFlushHandler(w http.ResponseWriter, r *http.Request) {
+	sqlText := r.URL.Query().Get("sql")
+	var flushed int
+	forEachPlanCache(r, func(_, _ string, planCache *optimize.PlanCache) {
+		flushed += planCache.Flush(sqlText)
+	})
+	writeJSON(w, map[string]int{"flushed": flushed})
+}
+
+func forEachPlanCache(r *http.Request, f func(applicationID, executor string, planCache *optimize.PlanCache)) {
+	query := r.URL.Query()
+	applicationID, executor := query.Get("application_id"), query.Get("executor")
+	for _, appid := range optimize.PlanCacheApplicationIDs() {
+		if applicationID != "" && appid != applicationID {
+			continue
+		}
+		for name, planCache := range optimize.GetPlanCaches(appid) {
+			if executor != "" && name != executor {
+				continue
+			}
+			f(appid, name, planCache)
+		}
+	}
+}
@@ -17,6 +17,7 @@
 package http
 
 import (
+	"encoding/json"
 	"net/http"
 
 	"github.com/gorilla/mux"
@@ -38,9 +39,57 @@ func RegisterRoutes() (http.Handler, error) {
 	// Add branch session router
 	registerBranchSessionsRouter(router)
 
+	// Add chaos filter router
+	registerChaosRouter(router)
+
+	// Add plan cache router
+	registerPlanCacheRouter(router)
+
+	// Add filters router
+	registerFiltersRouter(router)
+
+	// Add users usage router
+	registerUsageRouter(router)
+
+	// Add online ddl migrations router
+	registerMigrationsRouter(router)
+
+	// Add table statistics router
+	registerTableStatsRouter(router)
+
+	// Add active standby router
+	registerActiveStandbyRouter(router)
+
+	// Add session migration router
+	registerSessionsRouter(router)
+
+	// Add dual write filter router
+	registerDualWriteRouter(router)
+
+	// Add table checksums router
+	registerChecksumsRouter(router)
+
+	// Add data source events router
+	registerEventsRouter(router)
+
+	// Add backend threads router
+	registerBackendThreadsRouter(router)
+
 	return router, nil
 }
 
 func AppendApplicationID(applicationID string) {
 	applicationIDs = append(applicationIDs, applicationID)
 }
+
+// writeJSON writes v as the json body of a successful response.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
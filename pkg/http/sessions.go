@@ -0,0 +1,92 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/listener"
+)
+
+const (
+	sessionMigratePath = "/sessions/migrate"
+	sessionPath        = "/sessions/{id:[0-9]+}"
+)
+
+func registerSessionsRouter(router *mux.Router) {
+	router.Methods(http.MethodPost).Path(sessionMigratePath).HandlerFunc(sessionMigrateHandler)
+	router.Methods(http.MethodPost).Path(listener.SessionImportPath).HandlerFunc(sessionImportHandler)
+	router.Methods(http.MethodGet).Path(sessionPath).HandlerFunc(sessionHandler)
+}
+
+// sessionHandler shows a client session by its connection id, with the backend connections held for
+// it, how long and why, to find the sessions exhausting the connection pools.
+func sessionHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	connectionID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid session id: %s", id), http.StatusBadRequest)
+		return
+	}
+	session, ok := listener.GetClientSession(uint32(connectionID))
+	if !ok {
+		http.Error(w, fmt.Sprintf("session %d not found", connectionID), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, session)
+}
+
+// sessionMigrateHandler migrates the sessions of the idle client connections to the peers, before
+// the instance is stopped, it shows the number of sessions migrated of each listener.
+func sessionMigrateHandler(w http.ResponseWriter, r *http.Request) {
+	migrated, err := listener.MigrateSessions(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, migrated)
+}
+
+// sessionImportHandler imports the sessions a peer migrates, until their clients reconnect, the peer
+// authenticates with the secret of the listener as a bearer token.
+func sessionImportHandler(w http.ResponseWriter, r *http.Request) {
+	request := &listener.SessionImport{}
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	secret := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if err := listener.ImportSessions(request, secret); err != nil {
+		switch errors.Cause(err) {
+		case listener.ErrSessionImportUnauthorized:
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		case listener.ErrSessionListenerNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
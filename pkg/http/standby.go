@@ -0,0 +1,97 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/standby"
+)
+
+const (
+	activeStandbyPath           = "/activeStandby/{applicationID}"
+	activeStandbySwitchoverPath = "/activeStandby/{applicationID}/switchover"
+)
+
+func registerActiveStandbyRouter(router *mux.Router) {
+	router.Methods(http.MethodGet).Path(activeStandbyPath).HandlerFunc(activeStandbyHandler)
+	router.Methods(http.MethodPost).Path(activeStandbySwitchoverPath).HandlerFunc(activeStandbySwitchoverHandler)
+}
+
+// activeStandbyHandler shows whether this instance accepts writes, the active datacenter, and the
+// roles reported by the instances of all the datacenters.
+func activeStandbyHandler(w http.ResponseWriter, r *http.Request) {
+	coordinator, ok := getCoordinator(w, r)
+	if !ok {
+		return
+	}
+	status, err := coordinator.Status(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, status)
+}
+
+// activeStandbySwitchoverHandler makes the datacenter of the query active, e.g.
+// POST /activeStandby/{applicationID}/switchover?datacenter=dc2. The instances apply the switchover
+// asynchronously, the new active datacenter accepts writes once the former one is standby, unless
+// force=true, which is for the drills where the former active datacenter is lost.
+func activeStandbySwitchoverHandler(w http.ResponseWriter, r *http.Request) {
+	coordinator, ok := getCoordinator(w, r)
+	if !ok {
+		return
+	}
+	datacenter := r.URL.Query().Get("datacenter")
+	if datacenter == "" {
+		http.Error(w, "datacenter is required", http.StatusBadRequest)
+		return
+	}
+	var force bool
+	if value := r.URL.Query().Get("force"); value != "" {
+		var err error
+		if force, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, fmt.Sprintf("invalid force %s", value), http.StatusBadRequest)
+			return
+		}
+	}
+	state, err := coordinator.Switchover(r.Context(), datacenter, force)
+	if err != nil {
+		if errors.Is(err, standby.ErrSameDatacenter) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, state)
+}
+
+func getCoordinator(w http.ResponseWriter, r *http.Request) (*standby.Coordinator, bool) {
+	applicationID := mux.Vars(r)["applicationID"]
+	coordinator := standby.GetCoordinator(applicationID)
+	if coordinator == nil {
+		http.Error(w, fmt.Sprintf("application %s is not active standby", applicationID), http.StatusNotFound)
+		return nil, false
+	}
+	return coordinator, true
+}
@@ -18,7 +18,6 @@ package http
 
 import (
 	"encoding/json"
-	"fmt"
 	"net"
 	"net/http"
 	"time"
@@ -56,7 +55,7 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 		applicationConf := config.GetDBPackConfig(applicationID)
 		for _, listener := range applicationConf.Listeners {
 			active := false
-			lisAddr := fmt.Sprintf("%s:%d", listener.SocketAddress.Address, listener.SocketAddress.Port)
+			lisAddr := listener.SocketAddress.String()
 			conn, err := net.DialTimeout("tcp", lisAddr, 5*time.Second)
 			if err == nil && conn != nil {
 				active = true
@@ -64,8 +63,15 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 			}
 
 			protocolType := "http"
-			if listener.ProtocolType == config.Mysql {
+			switch listener.ProtocolType {
+			case config.Mysql:
 				protocolType = "mysql"
+			case config.Redis:
+				protocolType = "redis"
+			case config.Grpc:
+				protocolType = "grpc"
+			case config.Mysqlx:
+				protocolType = "mysqlx"
 			}
 
 			status := ListenerStatus{
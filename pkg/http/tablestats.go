@@ -0,0 +1,84 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/resource"
+	"github.com/cectc/dbpack/pkg/tablestats"
+)
+
+// tablesPath serves the tables, the columns and the indexes touched by the statements of the clients.
+const tablesPath = "/stats/tables"
+
+func registerTableStatsRouter(router *mux.Router) {
+	router.Methods(http.MethodGet).Path(tablesPath).HandlerFunc(tableStatsHandler)
+	router.Methods(http.MethodDelete).Path(tablesPath).HandlerFunc(tableStatsResetHandler)
+	router.Methods(http.MethodGet).Path(tablesPath + "/unused").HandlerFunc(unusedTablesHandler)
+}
+
+// tableStatsHandler lists the tables, the hottest first, the query parameter limit keeps the
+// hottest tables only.
+func tableStatsHandler(w http.ResponseWriter, r *http.Request) {
+	var limit int
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil {
+			http.Error(w, fmt.Sprintf("invalid limit: %s", value), http.StatusBadRequest)
+			return
+		}
+	}
+	writeJSON(w, map[string]interface{}{
+		"since":  tablestats.Since(),
+		"tables": tablestats.Tables(limit),
+	})
+}
+
+func tableStatsResetHandler(w http.ResponseWriter, r *http.Request) {
+	tablestats.Reset()
+	w.WriteHeader(http.StatusOK)
+}
+
+// unusedTablesHandler reports the tables and the indexes of the database of a data source not
+// used, the query parameters application_id and data_source name the data source.
+func unusedTablesHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	applicationID, dataSource := query.Get("application_id"), query.Get("data_source")
+	manager := resource.GetDBManager(applicationID)
+	if manager == nil {
+		http.Error(w, fmt.Sprintf("application %s not found", applicationID), http.StatusNotFound)
+		return
+	}
+	db := manager.GetDB(dataSource)
+	if db == nil {
+		http.Error(w, fmt.Sprintf("data source %s not found", dataSource), http.StatusNotFound)
+		return
+	}
+	report, err := tablestats.Unused(db)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, report)
+}
@@ -0,0 +1,69 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/cectc/dbpack/pkg/sql"
+)
+
+// backendThreadsPath serves the client sessions and the global transactions the backend connections
+// serve, by their thread ids, to trace the backend slow query log and processlist entries back.
+const backendThreadsPath = "/backend_threads"
+
+type backendThreads struct {
+	Active []*sql.ThreadAssignment `json:"active"`
+	Recent []*sql.ThreadAssignment `json:"recent"`
+}
+
+func registerBackendThreadsRouter(router *mux.Router) {
+	router.Methods(http.MethodGet).Path(backendThreadsPath).HandlerFunc(backendThreadsHandler)
+}
+
+// backendThreadsHandler lists the backend connections in use and the last ones put back to the
+// pools, optionally of a data_source and of a thread_id.
+func backendThreadsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var threadID uint64
+	if value := query.Get("thread_id"); value != "" {
+		var err error
+		if threadID, err = strconv.ParseUint(value, 10, 32); err != nil {
+			http.Error(w, fmt.Sprintf("invalid thread_id %s", value), http.StatusBadRequest)
+			return
+		}
+	}
+	dataSource := query.Get("data_source")
+	filter := func(assignments []*sql.ThreadAssignment) []*sql.ThreadAssignment {
+		result := make([]*sql.ThreadAssignment, 0)
+		for _, assignment := range assignments {
+			if (dataSource == "" || assignment.DataSource == dataSource) &&
+				(threadID == 0 || assignment.ThreadID == uint32(threadID)) {
+				result = append(result, assignment)
+			}
+		}
+		return result
+	}
+	writeJSON(w, &backendThreads{
+		Active: filter(sql.ActiveThreads()),
+		Recent: filter(sql.RecentThreads()),
+	})
+}
@@ -0,0 +1,55 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/mux"
+
+	"github.com/cectc/dbpack/pkg/usage"
+)
+
+// usersPath serves the cumulative resource usage of the frontend users.
+const usersPath = "/stats/users"
+
+func registerUsageRouter(router *mux.Router) {
+	router.Methods(http.MethodGet).Path(usersPath).HandlerFunc(usersHandler)
+	router.Methods(http.MethodGet).Path(usersPath + "/{user}").HandlerFunc(userHandler)
+}
+
+// usersHandler lists the usage of all the users that connected since dbpack started.
+func usersHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, usage.Users())
+}
+
+// userHandler shows the usage of a user with its open sessions.
+func userHandler(w http.ResponseWriter, r *http.Request) {
+	name, err := url.PathUnescape(mux.Vars(r)["user"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid user: %s", mux.Vars(r)["user"]), http.StatusBadRequest)
+		return
+	}
+	result, ok := usage.GetUser(name)
+	if !ok {
+		http.Error(w, fmt.Sprintf("no usage of user: %s", name), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, result)
+}
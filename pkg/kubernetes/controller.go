@@ -0,0 +1,710 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package kubernetes reconciles the runtime config of an application from custom resources, so
+// that the data sources, executors and filters can be managed as kubernetes objects, e.g. by
+// GitOps, and changed without restarting dbpack.
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/executor"
+	"github.com/cectc/dbpack/pkg/filter"
+	"github.com/cectc/dbpack/pkg/listener"
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/resource"
+)
+
+const (
+	Group   = "dbpack.cectc.com"
+	Version = "v1alpha1"
+
+	// StateReady and StateFailed are the states reported in the status of the resources
+	StateReady  = "Ready"
+	StateFailed = "Failed"
+
+	namespaceFile      = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+	watchRetryInterval = 5 * time.Second
+)
+
+var (
+	DataSourceResource  = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "datasources"}
+	ExecutorResource    = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "executors"}
+	FilterChainResource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "filterchains"}
+
+	// resources are in the order they are reconciled, the executors depend on the data sources,
+	// and the filter chains on the executors
+	resources = []schema.GroupVersionResource{DataSourceResource, ExecutorResource, FilterChainResource}
+)
+
+// dbManager registers the data sources of the resources.
+type dbManager interface {
+	AddDataSource(dataSource *config.DataSource) (proto.DB, error)
+	ReplaceDataSource(dataSource *config.DataSource) (proto.DB, error)
+	RemoveDataSource(name string)
+}
+
+type boundListener struct {
+	conf     *config.Listener
+	listener proto.DBListener
+}
+
+// Controller reconciles the DataSource, Executor and FilterChain resources of a namespace with the
+// runtime config of an application. The spec of a DataSource or an Executor is the config of a data
+// source or an executor of the config file, named after the resource. The spec of a FilterChain
+// declares filters and the executors running them in order.
+//
+// The resources are composed with the config file and validated as it is, nothing is applied if
+// the composed config is invalid, e.g. if a listener refers to an executor no longer declared. Then
+// the filters, data sources and executors which changed are recreated, the executors referring to
+// a recreated data source are recreated as well, those of the config file included, and the
+// listeners are bound to the executors. A data source replaces the previous one once it is built,
+// a resource failing to be applied keeps serving its previous config, if any, and the outcome of
+// each resource is reported in its status.
+//
+// The connections keep the executor they are served by until they are closed or change their
+// schema, the monitors of the groups of a recreated executor are stopped.
+type Controller struct {
+	conf      *config.DBPackConfig
+	client    dynamic.Interface
+	manager   dbManager
+	namespace string
+	selector  string
+	resync    time.Duration
+
+	// mu serializes the reconciles
+	mu sync.Mutex
+	// staticExecutors are the executors of the config file
+	staticExecutors map[string]proto.Executor
+	listeners       []*boundListener
+
+	// filters, dataSources, executorConfs and executors are those of the resources applied
+	filters       map[string]*config.Filter
+	dataSources   map[string]*config.DataSource
+	executorConfs map[string]*config.Executor
+	executors     map[string]proto.Executor
+}
+
+// NewController creates the controller of the kubernetes config of conf, executors are the executors
+// of the config file.
+func NewController(conf *config.DBPackConfig, executors map[string]proto.Executor) (*Controller, error) {
+	var (
+		restConfig *rest.Config
+		err        error
+	)
+	if conf.Kubernetes.Kubeconfig != "" {
+		restConfig, err = clientcmd.BuildConfigFromFlags("", conf.Kubernetes.Kubeconfig)
+	} else {
+		restConfig, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "kubernetes client config failed")
+	}
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "create kubernetes client failed")
+	}
+	manager, ok := resource.GetDBManager(conf.AppID).(dbManager)
+	if !ok {
+		return nil, errors.Errorf("application %s does not support data sources added at runtime", conf.AppID)
+	}
+	namespace := conf.Kubernetes.Namespace
+	if namespace == "" {
+		content, err := os.ReadFile(namespaceFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "the namespace of the kubernetes resources is not configured")
+		}
+		namespace = strings.TrimSpace(string(content))
+	}
+	return newController(conf, client, manager, namespace, executors), nil
+}
+
+func newController(conf *config.DBPackConfig, client dynamic.Interface, manager dbManager,
+	namespace string, executors map[string]proto.Executor) *Controller {
+	c := &Controller{
+		conf:            conf,
+		client:          client,
+		manager:         manager,
+		namespace:       namespace,
+		selector:        conf.Kubernetes.LabelSelector,
+		resync:          conf.Kubernetes.ResyncInterval,
+		staticExecutors: make(map[string]proto.Executor, len(executors)),
+		filters:         make(map[string]*config.Filter),
+		dataSources:     make(map[string]*config.DataSource),
+		executorConfs:   make(map[string]*config.Executor),
+		executors:       make(map[string]proto.Executor),
+	}
+	// the executors of the config file are recreated with the data sources they refer to
+	for name, e := range executors {
+		c.staticExecutors[name] = e
+	}
+	return c
+}
+
+// Sync reconciles the resources once, it is called before the listeners are created, so that they
+// can be bound to the executors of the resources.
+func (c *Controller) Sync(ctx context.Context) error {
+	return c.reconcile(ctx)
+}
+
+// Executors returns the executors of the config file and those of the resources keyed by name.
+func (c *Controller) Executors() map[string]proto.Executor {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c._executors()
+}
+
+func (c *Controller) _executors() map[string]proto.Executor {
+	executors := make(map[string]proto.Executor, len(c.staticExecutors)+len(c.executors))
+	for name, e := range c.staticExecutors {
+		executors[name] = e
+	}
+	for name, e := range c.executors {
+		executors[name] = e
+	}
+	return executors
+}
+
+// AddListener binds l to the executors of conf whenever they are recreated.
+func (c *Controller) AddListener(conf *config.Listener, l proto.DBListener) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listeners = append(c.listeners, &boundListener{conf: conf, listener: l})
+}
+
+// Run watches the resources and reconciles them as they change, and every resync interval in case
+// a change was missed, until ctx is done.
+func (c *Controller) Run(ctx context.Context) {
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	for _, gvr := range resources {
+		go c.watch(ctx, gvr, notify)
+	}
+	resync := c.resync
+	if resync <= 0 {
+		resync = 5 * time.Minute
+	}
+	ticker := time.NewTicker(resync)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		case <-ticker.C:
+		}
+		if err := c.reconcile(ctx); err != nil {
+			log.Errorf("[kubernetes] reconcile application %s failed: %v", c.conf.AppID, err)
+		}
+	}
+}
+
+// watch notifies the changes of the resources of gvr, the watch is restarted when it expires.
+func (c *Controller) watch(ctx context.Context, gvr schema.GroupVersionResource, notify func()) {
+	for {
+		w, err := c.client.Resource(gvr).Namespace(c.namespace).Watch(ctx, metav1.ListOptions{LabelSelector: c.selector})
+		if err != nil {
+			log.Errorf("[kubernetes] watch %s failed: %v", gvr.Resource, err)
+		} else {
+			// the changes made while the resources were not watched are picked up by reconciling them all
+			notify()
+			for range w.ResultChan() {
+				notify()
+			}
+			w.Stop()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(watchRetryInterval):
+		}
+	}
+}
+
+// object is a resource listed by a reconcile, with the outcome of applying it.
+type object struct {
+	gvr      schema.GroupVersionResource
+	obj      *unstructured.Unstructured
+	warnings []*config.Warning
+	err      error
+}
+
+func (o *object) fail(err error) {
+	if o.err == nil {
+		o.err = err
+	}
+}
+
+// desiredState is the config of the resources, with the resources declaring each item.
+type desiredState struct {
+	// filterNames, dataSourceNames and executorNames are the names of the items in the order of
+	// the resources declaring them
+	filterNames     []string
+	dataSourceNames []string
+	executorNames   []string
+
+	filters     map[string]*config.Filter
+	dataSources map[string]*config.DataSource
+	executors   map[string]*config.Executor
+	// chains maps executors to the names of the filters a filter chain declares for them
+	chains map[string][]string
+
+	filterOwners     map[string]*object
+	dataSourceOwners map[string]*object
+	executorOwners   map[string]*object
+	chainOwners      map[string]*object
+}
+
+func (c *Controller) reconcile(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	objects, err := c.list(ctx)
+	if err != nil {
+		return err
+	}
+	state := c.compose(objects)
+	if err = c.validate(state); err != nil {
+		for _, o := range objects {
+			o.fail(err)
+		}
+	} else {
+		c.apply(state)
+	}
+	for _, o := range objects {
+		if statusErr := c.updateStatus(ctx, o); statusErr != nil {
+			log.Errorf("[kubernetes] update status of %s %s failed: %v", o.gvr.Resource, o.obj.GetName(), statusErr)
+		}
+	}
+	return err
+}
+
+// list lists the resources in the order they are reconciled, then by name.
+func (c *Controller) list(ctx context.Context) ([]*object, error) {
+	var objects []*object
+	for _, gvr := range resources {
+		list, err := c.client.Resource(gvr).Namespace(c.namespace).List(ctx, metav1.ListOptions{LabelSelector: c.selector})
+		if err != nil {
+			return nil, errors.Wrapf(err, "list %s failed", gvr.Resource)
+		}
+		items := list.Items
+		sort.Slice(items, func(i, j int) bool {
+			return items[i].GetName() < items[j].GetName()
+		})
+		for i := range items {
+			objects = append(objects, &object{gvr: gvr, obj: &items[i]})
+		}
+	}
+	return objects, nil
+}
+
+// compose decodes the resources, a resource conflicting with the config file or with another
+// resource fails and is left out.
+func (c *Controller) compose(objects []*object) *desiredState {
+	state := &desiredState{
+		filters:          make(map[string]*config.Filter),
+		dataSources:      make(map[string]*config.DataSource),
+		executors:        make(map[string]*config.Executor),
+		chains:           make(map[string][]string),
+		filterOwners:     make(map[string]*object),
+		dataSourceOwners: make(map[string]*object),
+		executorOwners:   make(map[string]*object),
+		chainOwners:      make(map[string]*object),
+	}
+	for _, o := range objects {
+		name := o.obj.GetName()
+		switch o.gvr {
+		case DataSourceResource:
+			dataSource := &config.DataSource{}
+			if o.warnings, o.err = decodeSpec(o.obj, dataSource); o.err != nil {
+				continue
+			}
+			if c.staticDataSource(name) != nil {
+				o.fail(errors.Errorf("data source %s is declared in the config file", name))
+				continue
+			}
+			dataSource.Name = name
+			state.dataSourceNames = append(state.dataSourceNames, name)
+			state.dataSources[name] = dataSource
+			state.dataSourceOwners[name] = o
+		case ExecutorResource:
+			conf := &config.Executor{}
+			if o.warnings, o.err = decodeSpec(o.obj, conf); o.err != nil {
+				continue
+			}
+			if c.staticExecutor(name) != nil {
+				o.fail(errors.Errorf("executor %s is declared in the config file", name))
+				continue
+			}
+			conf.Name = name
+			state.executorNames = append(state.executorNames, name)
+			state.executors[name] = conf
+			state.executorOwners[name] = o
+		case FilterChainResource:
+			chain := &config.FilterChain{}
+			if o.warnings, o.err = decodeSpec(o.obj, chain); o.err != nil {
+				continue
+			}
+			names := make([]string, 0, len(chain.Filters))
+			for _, f := range chain.Filters {
+				switch {
+				case f.Name == "":
+					o.fail(errors.New("filter has no name"))
+				case c.staticFilter(f.Name) != nil:
+					o.fail(errors.Errorf("filter %s is declared in the config file", f.Name))
+				case state.filterOwners[f.Name] != nil || contains(names, f.Name):
+					o.fail(errors.Errorf("filter %s is declared more than once", f.Name))
+				}
+				names = append(names, f.Name)
+			}
+			for _, executorName := range chain.Executors {
+				if owner := state.chainOwners[executorName]; owner != nil {
+					o.fail(errors.Errorf("executor %s runs the filter chain %s", executorName, owner.obj.GetName()))
+				} else if c.staticExecutor(executorName) == nil && state.executors[executorName] == nil {
+					o.fail(errors.Errorf("executor %s does not exist", executorName))
+				}
+			}
+			if o.err != nil {
+				continue
+			}
+			for _, f := range chain.Filters {
+				state.filterNames = append(state.filterNames, f.Name)
+				state.filters[f.Name] = f
+				state.filterOwners[f.Name] = o
+			}
+			for _, executorName := range chain.Executors {
+				state.chains[executorName] = names
+				state.chainOwners[executorName] = o
+			}
+		}
+	}
+	return state
+}
+
+// validate validates the config file composed with the resources.
+func (c *Controller) validate(state *desiredState) error {
+	conf := &config.DBPackConfig{
+		AppID:       c.conf.AppID,
+		Listeners:   c.conf.Listeners,
+		DataSources: append([]*config.DataSource(nil), c.conf.DataSources...),
+		Filters:     append([]*config.Filter(nil), c.conf.Filters...),
+	}
+	for _, e := range c.conf.Executors {
+		copied := *e
+		copied.Filters = c.executorFilters(state, e)
+		conf.Executors = append(conf.Executors, &copied)
+	}
+	for _, name := range state.dataSourceNames {
+		conf.DataSources = append(conf.DataSources, state.dataSources[name])
+	}
+	for _, name := range state.filterNames {
+		conf.Filters = append(conf.Filters, state.filters[name])
+	}
+	for _, name := range state.executorNames {
+		e := state.executors[name]
+		if names, ok := state.chains[name]; ok {
+			e.Filters = names
+		}
+		conf.Executors = append(conf.Executors, e)
+	}
+	return conf.Normalize()
+}
+
+// apply applies the changes of the desired state, in the order the items depend on each other.
+func (c *Controller) apply(state *desiredState) {
+	appid := c.conf.AppID
+
+	recreatedFilters := make(map[string]bool)
+	for _, name := range state.filterNames {
+		conf := state.filters[name]
+		if reflect.DeepEqual(c.filters[name], conf) {
+			continue
+		}
+		f, err := filter.NewFilter(appid, conf)
+		if err != nil {
+			state.filterOwners[name].fail(err)
+			continue
+		}
+		filter.RegisterFilter(appid, name, f)
+		c.filters[name] = conf
+		recreatedFilters[name] = true
+	}
+	for name := range c.filters {
+		// a filter can not be unregistered, it is left unused
+		if _, ok := state.filters[name]; !ok {
+			delete(c.filters, name)
+		}
+	}
+
+	recreatedDataSources := make(map[string]bool)
+	for _, name := range state.dataSourceNames {
+		conf := state.dataSources[name]
+		applied, ok := c.dataSources[name]
+		if ok && reflect.DeepEqual(applied, conf) && !containsAny(conf.Filters, recreatedFilters) {
+			continue
+		}
+		var err error
+		if ok {
+			_, err = c.manager.ReplaceDataSource(conf)
+		} else {
+			_, err = c.manager.AddDataSource(conf)
+		}
+		if err != nil {
+			state.dataSourceOwners[name].fail(err)
+			continue
+		}
+		c.dataSources[name] = conf
+		recreatedDataSources[name] = true
+	}
+
+	executorsChanged := false
+	for _, name := range state.executorNames {
+		conf := state.executors[name]
+		applied, ok := c.executorConfs[name]
+		if ok && sameExecutor(applied, conf) && !references(map[string]interface{}(conf.Config), recreatedDataSources) {
+			continue
+		}
+		e, err := executor.NewExecutor(conf)
+		if err != nil {
+			state.executorOwners[name].fail(err)
+			continue
+		}
+		if replaced, ok := c.executors[name]; ok {
+			executor.CloseExecutor(replaced)
+		}
+		c.executorConfs[name] = conf
+		c.executors[name] = e
+		executorsChanged = true
+	}
+	for name, e := range c.executors {
+		if _, ok := state.executors[name]; !ok {
+			executor.CloseExecutor(e)
+			delete(c.executorConfs, name)
+			delete(c.executors, name)
+			executorsChanged = true
+		}
+	}
+	for _, conf := range c.conf.Executors {
+		if !references(map[string]interface{}(conf.Config), recreatedDataSources) {
+			continue
+		}
+		copied := *conf
+		copied.Filters = c.executorFilters(state, conf)
+		e, err := executor.NewExecutor(&copied)
+		if err != nil {
+			log.Errorf("[kubernetes] recreate executor %s failed: %v", conf.Name, err)
+			continue
+		}
+		executor.CloseExecutor(c.staticExecutors[conf.Name])
+		c.staticExecutors[conf.Name] = e
+		executorsChanged = true
+	}
+
+	for name := range c._executors() {
+		chain := executor.GetFilterChain(appid, name)
+		if chain == nil {
+			continue
+		}
+		var names []string
+		if conf, ok := state.executors[name]; ok {
+			names = conf.Filters
+		} else {
+			names = c.executorFilters(state, c.staticExecutor(name))
+		}
+		if reflect.DeepEqual(names, chain.Names()) && !containsAny(names, recreatedFilters) {
+			continue
+		}
+		if err := chain.Set(names); err != nil {
+			if owner := state.chainOwners[name]; owner != nil {
+				owner.fail(err)
+			} else if owner = state.executorOwners[name]; owner != nil {
+				owner.fail(err)
+			} else {
+				log.Errorf("[kubernetes] set filters of executor %s failed: %v", name, err)
+			}
+		}
+	}
+
+	if executorsChanged {
+		executors := c._executors()
+		for _, bound := range c.listeners {
+			if err := listener.SetExecutors(bound.listener, bound.conf, executors); err != nil {
+				log.Errorf("[kubernetes] bind listener %s failed: %v", bound.conf.SocketAddress, err)
+			}
+		}
+	}
+
+	// the data sources are removed once no executor refers to them
+	for name := range c.dataSources {
+		if _, ok := state.dataSources[name]; !ok {
+			c.manager.RemoveDataSource(name)
+			delete(c.dataSources, name)
+		}
+	}
+}
+
+// executorFilters returns the filters of an executor of the config file, those of its filter chain
+// resource if there is one.
+func (c *Controller) executorFilters(state *desiredState, conf *config.Executor) []string {
+	if names, ok := state.chains[conf.Name]; ok {
+		return names
+	}
+	return conf.Filters
+}
+
+// updateStatus reports the outcome of applying a resource in its status, unless it is unchanged.
+func (c *Controller) updateStatus(ctx context.Context, o *object) error {
+	status := map[string]interface{}{
+		"observedGeneration": o.obj.GetGeneration(),
+		"state":              StateReady,
+		"message":            warningsMessage(o.warnings),
+	}
+	if o.err != nil {
+		status["state"] = StateFailed
+		status["message"] = o.err.Error()
+	}
+	current, _, _ := unstructured.NestedMap(o.obj.Object, "status")
+	if reflect.DeepEqual(current, status) {
+		return nil
+	}
+	obj := o.obj.DeepCopy()
+	if err := unstructured.SetNestedMap(obj.Object, status, "status"); err != nil {
+		return err
+	}
+	_, err := c.client.Resource(o.gvr).Namespace(c.namespace).UpdateStatus(ctx, obj, metav1.UpdateOptions{})
+	return err
+}
+
+func (c *Controller) staticDataSource(name string) *config.DataSource {
+	for _, dataSource := range c.conf.DataSources {
+		if dataSource.Name == name {
+			return dataSource
+		}
+	}
+	return nil
+}
+
+func (c *Controller) staticExecutor(name string) *config.Executor {
+	for _, e := range c.conf.Executors {
+		if e.Name == name {
+			return e
+		}
+	}
+	return nil
+}
+
+func (c *Controller) staticFilter(name string) *config.Filter {
+	for _, f := range c.conf.Filters {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// decodeSpec decodes the spec of a resource into out, a pointer to a config type.
+func decodeSpec(obj *unstructured.Unstructured, out interface{}) ([]*config.Warning, error) {
+	spec, ok := obj.Object["spec"]
+	if !ok || spec == nil {
+		spec = map[string]interface{}{}
+	}
+	content, err := json.Marshal(spec)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal spec failed")
+	}
+	return config.Decode(content, out)
+}
+
+func warningsMessage(warnings []*config.Warning) string {
+	messages := make([]string, 0, len(warnings))
+	for _, warning := range warnings {
+		messages = append(messages, "spec."+warning.Path+" "+warning.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// sameExecutor reports whether the executors have the same config, the filters of an executor
+// are changed without recreating it.
+func sameExecutor(a, b *config.Executor) bool {
+	x, y := *a, *b
+	x.Filters, y.Filters = nil, nil
+	return reflect.DeepEqual(x, y)
+}
+
+// references reports whether one of the strings of an executor config is one of names, which
+// are the names of data sources.
+func references(value interface{}, names map[string]bool) bool {
+	switch v := value.(type) {
+	case string:
+		return names[v]
+	case map[string]interface{}:
+		for _, item := range v {
+			if references(item, names) {
+				return true
+			}
+		}
+	case config.Parameters:
+		return references(map[string]interface{}(v), names)
+	case []interface{}:
+		for _, item := range v {
+			if references(item, names) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(names []string, set map[string]bool) bool {
+	for _, name := range names {
+		if set[name] {
+			return true
+		}
+	}
+	return false
+}
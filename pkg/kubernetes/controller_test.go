@@ -0,0 +1,232 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/executor"
+	"github.com/cectc/dbpack/pkg/filter"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+const (
+	testAppID      = "kubernetes-test"
+	testNamespace  = "dbpack"
+	testFilterKind = "kubernetes-test"
+)
+
+type testDBManager struct {
+	added    []string
+	replaced []string
+	removed  []string
+	// replaceErr fails the replacements
+	replaceErr error
+}
+
+func (manager *testDBManager) AddDataSource(dataSource *config.DataSource) (proto.DB, error) {
+	manager.added = append(manager.added, dataSource.Name)
+	return nil, nil
+}
+
+func (manager *testDBManager) ReplaceDataSource(dataSource *config.DataSource) (proto.DB, error) {
+	if manager.replaceErr != nil {
+		return nil, manager.replaceErr
+	}
+	manager.replaced = append(manager.replaced, dataSource.Name)
+	return nil, nil
+}
+
+func (manager *testDBManager) RemoveDataSource(name string) {
+	manager.removed = append(manager.removed, name)
+}
+
+type testListener struct {
+	proto.DBListener
+	executor proto.Executor
+}
+
+func (l *testListener) SetExecutor(executor proto.Executor) {
+	l.executor = executor
+}
+
+type testFilter struct{}
+
+func (f *testFilter) GetKind() string {
+	return testFilterKind
+}
+
+func (f *testFilter) PreHandle(ctx context.Context) error {
+	return nil
+}
+
+type testFilterFactory struct{}
+
+func (factory *testFilterFactory) NewFilter(appid string, config map[string]interface{}) (proto.Filter, error) {
+	return &testFilter{}, nil
+}
+
+func newObject(kind, name string, generation int64, spec map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": Group + "/" + Version,
+		"kind":       kind,
+		"metadata": map[string]interface{}{
+			"name":       name,
+			"namespace":  testNamespace,
+			"generation": generation,
+		},
+		"spec": spec,
+	}}
+}
+
+func getStatus(t *testing.T, client *fake.FakeDynamicClient, gvr schema.GroupVersionResource, name string) map[string]interface{} {
+	obj, err := client.Resource(gvr).Namespace(testNamespace).Get(context.Background(), name, metav1.GetOptions{})
+	assert.NoError(t, err)
+	status, _, _ := unstructured.NestedMap(obj.Object, "status")
+	return status
+}
+
+func TestController(t *testing.T) {
+	filter.RegistryFilterFactory(testFilterKind, &testFilterFactory{})
+	ctx := context.Background()
+
+	conf := &config.DBPackConfig{
+		AppID:      testAppID,
+		Kubernetes: &config.KubernetesConfig{},
+		Listeners: []*config.Listener{{
+			ProtocolType:  config.Mysql,
+			SocketAddress: config.SocketAddress{Port: 13306},
+			Executor:      "orders",
+		}},
+		Executors: []*config.Executor{{
+			Name:   "static",
+			Mode:   config.SDB,
+			Config: config.Parameters{"data_source_ref": "static"},
+		}, {
+			Name:   "static-orders",
+			Mode:   config.SDB,
+			Config: config.Parameters{"data_source_ref": "orders-db"},
+		}},
+		DataSources: []*config.DataSource{{Name: "static"}},
+	}
+	assert.NoError(t, conf.Normalize())
+	static, err := executor.NewExecutor(conf.Executors[0])
+	assert.NoError(t, err)
+	staticOrders, err := executor.NewExecutor(conf.Executors[1])
+	assert.NoError(t, err)
+
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			DataSourceResource:  "DataSourceList",
+			ExecutorResource:    "ExecutorList",
+			FilterChainResource: "FilterChainList",
+		},
+		newObject("DataSource", "orders-db", 1, map[string]interface{}{
+			"dsn":          "root:123456@tcp(127.0.0.1:3306)/orders",
+			"idle_timeout": "1m",
+			"wieght":       "1",
+		}),
+		newObject("Executor", "orders", 1, map[string]interface{}{
+			"mode":   "sdb",
+			"config": map[string]interface{}{"data_source_ref": "orders-db"},
+		}),
+		newObject("FilterChain", "audit", 1, map[string]interface{}{
+			"executors": []interface{}{"orders", "static"},
+			"filters": []interface{}{
+				map[string]interface{}{"name": "audit", "kind": testFilterKind},
+			},
+		}),
+	)
+	manager := &testDBManager{}
+	controller := newController(conf, client, manager, testNamespace, map[string]proto.Executor{"static": static, "static-orders": staticOrders})
+
+	assert.NoError(t, controller.Sync(ctx))
+	l := &testListener{}
+	controller.AddListener(conf.Listeners[0], l)
+	assert.Equal(t, []string{"orders-db"}, manager.added)
+	executors := controller.Executors()
+	assert.Len(t, executors, 3)
+	assert.NotNil(t, executors["orders"])
+	assert.Equal(t, []string{"audit"}, executor.GetFilterChain(testAppID, "orders").Names())
+	assert.Equal(t, []string{"audit"}, executor.GetFilterChain(testAppID, "static").Names())
+	assert.Equal(t, map[string]interface{}{
+		"observedGeneration": int64(1),
+		"state":              StateReady,
+		"message":            "spec.wieght is an unknown field",
+	}, getStatus(t, client, DataSourceResource, "orders-db"))
+	assert.Equal(t, StateReady, getStatus(t, client, FilterChainResource, "audit")["state"])
+
+	// a changed data source is recreated, and so are the executors referring to it
+	_, err = client.Resource(DataSourceResource).Namespace(testNamespace).Update(ctx,
+		newObject("DataSource", "orders-db", 2, map[string]interface{}{
+			"dsn": "root:123456@tcp(127.0.0.2:3306)/orders",
+		}), metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	_, err = client.Resource(ExecutorResource).Namespace(testNamespace).Create(ctx,
+		newObject("Executor", "static", 1, map[string]interface{}{"mode": "sdb"}), metav1.CreateOptions{})
+	assert.NoError(t, err)
+	assert.NoError(t, controller.reconcile(ctx))
+	assert.Empty(t, manager.removed)
+	assert.Equal(t, []string{"orders-db"}, manager.added)
+	assert.Equal(t, []string{"orders-db"}, manager.replaced)
+	recreated := controller.Executors()["orders"]
+	assert.NotSame(t, executors["orders"], recreated)
+	assert.Same(t, recreated, l.executor)
+	// the executors of the config file referring to it too
+	assert.NotSame(t, staticOrders, controller.Executors()["static-orders"])
+	assert.Same(t, static, controller.Executors()["static"])
+	assert.Equal(t, int64(2), getStatus(t, client, DataSourceResource, "orders-db")["observedGeneration"])
+	assert.Equal(t, map[string]interface{}{
+		"observedGeneration": int64(1),
+		"state":              StateFailed,
+		"message":            "executor static is declared in the config file",
+	}, getStatus(t, client, ExecutorResource, "static"))
+
+	// the data source keeps serving if the new one fails to be built
+	manager.replaceErr = errors.New("connect failed")
+	_, err = client.Resource(DataSourceResource).Namespace(testNamespace).Update(ctx,
+		newObject("DataSource", "orders-db", 3, map[string]interface{}{
+			"dsn": "root:123456@tcp(127.0.0.3:3306)/orders",
+		}), metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	assert.NoError(t, controller.reconcile(ctx))
+	assert.Equal(t, []string{"orders-db"}, manager.replaced)
+	assert.Same(t, recreated, controller.Executors()["orders"])
+	assert.Equal(t, map[string]interface{}{
+		"observedGeneration": int64(3),
+		"state":              StateFailed,
+		"message":            "connect failed",
+	}, getStatus(t, client, DataSourceResource, "orders-db"))
+	manager.replaceErr = nil
+
+	// nothing is applied if the listener would lose its executor
+	assert.NoError(t, client.Resource(ExecutorResource).Namespace(testNamespace).Delete(ctx, "orders", metav1.DeleteOptions{}))
+	assert.Error(t, controller.reconcile(ctx))
+	assert.Same(t, recreated, controller.Executors()["orders"])
+	assert.Equal(t, StateFailed, getStatus(t, client, DataSourceResource, "orders-db")["state"])
+	assert.Equal(t, StateFailed, getStatus(t, client, FilterChainResource, "audit")["state"])
+}
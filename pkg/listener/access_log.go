@@ -0,0 +1,286 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-module/carbon"
+	"github.com/pkg/errors"
+	"github.com/uber-go/atomic"
+	"gopkg.in/natefinch/lumberjack.v2"
+
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/misc"
+	"github.com/cectc/dbpack/pkg/mysql"
+)
+
+const (
+	accessLogFile = "access.log"
+
+	// DefaultAccessLogFormat logs a line per connection when it is closed
+	DefaultAccessLogFormat = "$connect_time $disconnect_time $connection_id $user $remote_addr " +
+		"$tls_version $bytes_in $bytes_out $statements"
+
+	defaultAccessLogMaxSize    = 500
+	defaultAccessLogMaxBackups = 1
+	defaultAccessLogMaxAge     = 30
+)
+
+// AccessLogConfig configures the access log of a mysql listener, which records a line per
+// client connection, unlike the audit log which records a line per statement
+type AccessLogConfig struct {
+	AccessLogDir string `yaml:"access_log_dir" json:"access_log_dir"`
+	// Format is the template of a line, variables are written as $name, the supported ones are
+	// connect_time, disconnect_time, duration, connection_id, user, remote_addr, remote_ip,
+	// tls_version, tls_cipher, bytes_in, bytes_out and statements, defaults to DefaultAccessLogFormat
+	Format string `yaml:"format" json:"format"`
+	// MaxSize is the maximum size in megabytes of the log file before it gets rotated
+	MaxSize int `yaml:"max_size" json:"max_size"`
+	// MaxAge is the maximum number of days to retain old log files
+	MaxAge int `yaml:"max_age" json:"max_age"`
+	// MaxBackups maximum number of old log files to retain
+	MaxBackups int `yaml:"max_backups" json:"max_backups"`
+	// Compress determines if the rotated log files should be compressed using gzip
+	Compress bool `yaml:"compress" json:"compress"`
+}
+
+// accessLogEntry is what is known of a connection when it is closed.
+type accessLogEntry struct {
+	connectTime    time.Time
+	disconnectTime time.Time
+	connectionID   uint32
+	user           string
+	remoteAddr     string
+	tls            *tls.ConnectionState
+	bytesIn        uint64
+	bytesOut       uint64
+	statements     uint64
+}
+
+// accessLogVariables appends the value of each format variable of an entry.
+var accessLogVariables = map[string]func(buf []byte, e *accessLogEntry) []byte{
+	"connect_time": func(buf []byte, e *accessLogEntry) []byte {
+		return append(buf, carbon.Time2Carbon(e.connectTime).ToDateTimeString()...)
+	},
+	"disconnect_time": func(buf []byte, e *accessLogEntry) []byte {
+		return append(buf, carbon.Time2Carbon(e.disconnectTime).ToDateTimeString()...)
+	},
+	"duration": func(buf []byte, e *accessLogEntry) []byte {
+		return append(buf, e.disconnectTime.Sub(e.connectTime).String()...)
+	},
+	"connection_id": func(buf []byte, e *accessLogEntry) []byte {
+		return strconv.AppendUint(buf, uint64(e.connectionID), 10)
+	},
+	"user": func(buf []byte, e *accessLogEntry) []byte {
+		return appendOrDash(buf, e.user)
+	},
+	"remote_addr": func(buf []byte, e *accessLogEntry) []byte {
+		return appendOrDash(buf, e.remoteAddr)
+	},
+	"remote_ip": func(buf []byte, e *accessLogEntry) []byte {
+		return appendOrDash(buf, misc.HostIP(e.remoteAddr))
+	},
+	"tls_version": func(buf []byte, e *accessLogEntry) []byte {
+		if e.tls == nil {
+			return append(buf, '-')
+		}
+		return append(buf, tlsVersionName(e.tls.Version)...)
+	},
+	"tls_cipher": func(buf []byte, e *accessLogEntry) []byte {
+		if e.tls == nil {
+			return append(buf, '-')
+		}
+		return append(buf, tls.CipherSuiteName(e.tls.CipherSuite)...)
+	},
+	"bytes_in": func(buf []byte, e *accessLogEntry) []byte {
+		return strconv.AppendUint(buf, e.bytesIn, 10)
+	},
+	"bytes_out": func(buf []byte, e *accessLogEntry) []byte {
+		return strconv.AppendUint(buf, e.bytesOut, 10)
+	},
+	"statements": func(buf []byte, e *accessLogEntry) []byte {
+		return strconv.AppendUint(buf, e.statements, 10)
+	},
+}
+
+func appendOrDash(buf []byte, value string) []byte {
+	if value == "" {
+		return append(buf, '-')
+	}
+	return append(buf, value...)
+}
+
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLSv1"
+	case tls.VersionTLS11:
+		return "TLSv1.1"
+	case tls.VersionTLS12:
+		return "TLSv1.2"
+	case tls.VersionTLS13:
+		return "TLSv1.3"
+	default:
+		return "0x" + strconv.FormatUint(uint64(version), 16)
+	}
+}
+
+// accessLogSegment is either a literal text or a variable of the format.
+type accessLogSegment struct {
+	text     string
+	variable func(buf []byte, e *accessLogEntry) []byte
+}
+
+type accessLog struct {
+	segments []accessLogSegment
+	out      io.WriteCloser
+}
+
+func newAccessLog(conf *AccessLogConfig) (*accessLog, error) {
+	format := conf.Format
+	if format == "" {
+		format = DefaultAccessLogFormat
+	}
+	segments, err := parseAccessLogFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	if conf.MaxSize == 0 {
+		conf.MaxSize = defaultAccessLogMaxSize
+	}
+	if conf.MaxBackups == 0 {
+		conf.MaxBackups = defaultAccessLogMaxBackups
+	}
+	if conf.MaxAge == 0 {
+		conf.MaxAge = defaultAccessLogMaxAge
+	}
+	return &accessLog{
+		segments: segments,
+		out: &lumberjack.Logger{
+			Filename:   filepath.Join(conf.AccessLogDir, accessLogFile),
+			MaxSize:    conf.MaxSize,
+			MaxBackups: conf.MaxBackups,
+			MaxAge:     conf.MaxAge,
+			Compress:   conf.Compress,
+		},
+	}, nil
+}
+
+// parseAccessLogFormat splits format into literal texts and variables, a variable is a $ followed
+// by a name of lowercase letters and underscores, and may be enclosed in braces as ${name}.
+func parseAccessLogFormat(format string) ([]accessLogSegment, error) {
+	var segments []accessLogSegment
+	for len(format) > 0 {
+		i := strings.IndexByte(format, '$')
+		if i < 0 {
+			segments = append(segments, accessLogSegment{text: format})
+			break
+		}
+		if i > 0 {
+			segments = append(segments, accessLogSegment{text: format[:i]})
+		}
+		format = format[i+1:]
+
+		var name string
+		if strings.HasPrefix(format, "{") {
+			end := strings.IndexByte(format, '}')
+			if end < 0 {
+				return nil, errors.New("access log format has an unclosed ${")
+			}
+			name, format = format[1:end], format[end+1:]
+		} else {
+			end := strings.IndexFunc(format, func(r rune) bool {
+				return (r < 'a' || r > 'z') && r != '_'
+			})
+			if end < 0 {
+				end = len(format)
+			}
+			name, format = format[:end], format[end:]
+		}
+		variable, ok := accessLogVariables[name]
+		if !ok {
+			return nil, errors.Errorf("access log format has an unknown variable $%s", name)
+		}
+		segments = append(segments, accessLogSegment{variable: variable})
+	}
+	return segments, nil
+}
+
+func (l *accessLog) format(e *accessLogEntry) []byte {
+	buf := make([]byte, 0, 256)
+	for _, segment := range l.segments {
+		if segment.variable != nil {
+			buf = segment.variable(buf, e)
+		} else {
+			buf = append(buf, segment.text...)
+		}
+	}
+	return append(buf, '\n')
+}
+
+func (l *accessLog) write(e *accessLogEntry) error {
+	_, err := l.out.Write(l.format(e))
+	return err
+}
+
+func (l *accessLog) close() error {
+	return l.out.Close()
+}
+
+// writeAccessLog records the closed connection c, whose socket is conn.
+func (l *MysqlListener) writeAccessLog(c *mysql.Conn, conn *countingConn, connectTime time.Time, statements uint64) {
+	entry := &accessLogEntry{
+		connectTime:    connectTime,
+		disconnectTime: time.Now(),
+		connectionID:   c.ID(),
+		user:           c.UserName(),
+		remoteAddr:     c.RemoteAddr().String(),
+		tls:            c.TLSConnectionState(),
+		bytesIn:        conn.bytesIn.Load(),
+		bytesOut:       conn.bytesOut.Load(),
+		statements:     statements,
+	}
+	if err := l.accessLog.write(entry); err != nil {
+		log.Warnf("write access log of %s failed, %v", c, err)
+	}
+}
+
+// countingConn counts the bytes read from and written to a client connection.
+type countingConn struct {
+	net.Conn
+	bytesIn  atomic.Uint64
+	bytesOut atomic.Uint64
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.bytesIn.Add(uint64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.bytesOut.Add(uint64(n))
+	return n, err
+}
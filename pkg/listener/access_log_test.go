@@ -0,0 +1,121 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"crypto/tls"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessLogFormat(t *testing.T) {
+	connectTime := time.Date(2022, 6, 1, 10, 0, 0, 0, time.Local)
+	entry := &accessLogEntry{
+		connectTime:    connectTime,
+		disconnectTime: connectTime.Add(90 * time.Second),
+		connectionID:   7,
+		user:           "dksl",
+		remoteAddr:     "[::ffff:10.0.0.8]:52314",
+		bytesIn:        1024,
+		bytesOut:       4096,
+		statements:     12,
+	}
+	testCases := []struct {
+		name   string
+		format string
+		tls    *tls.ConnectionState
+		line   string
+		hasErr bool
+	}{
+		{
+			name: "default",
+			line: "2022-06-01 10:00:00 2022-06-01 10:01:30 7 dksl [::ffff:10.0.0.8]:52314 - 1024 4096 12\n",
+		},
+		{
+			name:   "braces",
+			format: `${remote_ip}:${user} "${duration}" ${tls_version}/${tls_cipher}`,
+			tls:    &tls.ConnectionState{Version: tls.VersionTLS12, CipherSuite: tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+			line:   `10.0.0.8:dksl "1m30s" TLSv1.2/TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256` + "\n",
+		},
+		{
+			name:   "literal only",
+			format: "connection closed",
+			line:   "connection closed\n",
+		},
+		{
+			name:   "unknown variable",
+			format: "$user $database",
+			hasErr: true,
+		},
+		{
+			name:   "unclosed brace",
+			format: "${user",
+			hasErr: true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			l, err := newAccessLog(&AccessLogConfig{AccessLogDir: t.TempDir(), Format: c.format})
+			if c.hasErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			entry.tls = c.tls
+			assert.Equal(t, c.line, string(l.format(entry)))
+		})
+	}
+}
+
+func TestAccessLogWrite(t *testing.T) {
+	dir := t.TempDir()
+	l, err := newAccessLog(&AccessLogConfig{AccessLogDir: dir, Format: "$connection_id $user $statements"})
+	assert.NoError(t, err)
+	assert.NoError(t, l.write(&accessLogEntry{connectionID: 1, statements: 3}))
+	assert.NoError(t, l.write(&accessLogEntry{connectionID: 2, user: "dksl"}))
+	assert.NoError(t, l.close())
+
+	content, err := os.ReadFile(filepath.Join(dir, accessLogFile))
+	assert.NoError(t, err)
+	assert.Equal(t, "1 - 3\n2 dksl 0\n", string(content))
+}
+
+func TestCountingConn(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	conn := &countingConn{Conn: server}
+	defer conn.Close()
+
+	go func() {
+		_, _ = client.Write([]byte("select 1"))
+		buf := make([]byte, 3)
+		_, _ = client.Read(buf)
+	}()
+	buf := make([]byte, 8)
+	n, err := conn.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 8, n)
+	_, err = conn.Write([]byte("one"))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(8), conn.bytesIn.Load())
+	assert.Equal(t, uint64(3), conn.bytesOut.Load())
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+func TestSocketAddressString(t *testing.T) {
+	testCases := []struct {
+		address string
+		port    int
+		joined  string
+	}{
+		{"0.0.0.0", 13306, "0.0.0.0:13306"},
+		{"::", 13306, "[::]:13306"},
+		{"[::]", 13306, "[::]:13306"},
+		{"::1", 3306, "[::1]:3306"},
+		{"fe80::1%eth0", 3306, "[fe80::1%eth0]:3306"},
+		{"localhost", 8080, "localhost:8080"},
+	}
+	for _, c := range testCases {
+		t.Run(c.address, func(t *testing.T) {
+			address := config.SocketAddress{Address: c.address, Port: c.port}
+			assert.Equal(t, c.joined, address.String())
+		})
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	testCases := []struct {
+		remoteAddr string
+		ip         string
+	}{
+		{"127.0.0.1:52314", "127.0.0.1"},
+		{"[::1]:52314", "::1"},
+		{"[::ffff:10.0.0.8]:52314", "10.0.0.8"},
+		{"[fe80::1%eth0]:52314", "fe80::1%eth0"},
+		{"", ""},
+	}
+	for _, c := range testCases {
+		t.Run(c.remoteAddr, func(t *testing.T) {
+			ctx := proto.WithRemoteAddr(context.Background(), c.remoteAddr)
+			assert.Equal(t, c.ip, proto.RemoteIP(ctx))
+		})
+	}
+}
+
+func TestListenIPv6(t *testing.T) {
+	probe, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skip("ipv6 is not available")
+	}
+	probe.Close()
+
+	l, err := NewMysqlListener(&config.Listener{
+		ProtocolType:  config.Mysql,
+		SocketAddress: config.SocketAddress{Address: "[::1]"},
+		Config:        map[string]interface{}{"server_version": "8.0.27"},
+	})
+	assert.NoError(t, err)
+	defer l.Close()
+	listener := l.(*MysqlListener)
+	addr := listener.listeners[0].Addr().(*net.TCPAddr)
+	assert.Equal(t, "::1", addr.IP.String())
+
+	conn, err := net.Dial("tcp", addr.String())
+	assert.NoError(t, err)
+	conn.Close()
+}
@@ -0,0 +1,153 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+// innodbLockWaits lists the lock waits of a backend, the columns are those sys.innodb_lock_waits
+// has in both mysql 5.7 and 8.0.
+const innodbLockWaits = "SELECT wait_started, wait_age_secs, locked_table, locked_index, locked_type, " +
+	"waiting_trx_id, waiting_pid, waiting_query, waiting_lock_mode, " +
+	"blocking_trx_id, blocking_pid, blocking_query, blocking_lock_mode FROM sys.innodb_lock_waits"
+
+// lockWaitColumns are the columns of innodbLockWaits, waitingPid and blockingPid the positions of
+// the ids of the backend sessions waiting and blocking.
+var lockWaitColumns = []string{"wait_started", "wait_age_secs", "locked_table", "locked_index", "locked_type",
+	"waiting_trx_id", "waiting_pid", "waiting_query", "waiting_lock_mode",
+	"blocking_trx_id", "blocking_pid", "blocking_query", "blocking_lock_mode"}
+
+const (
+	waitingPid  = 6
+	blockingPid = 10
+)
+
+// backendSession is a session of a data source, identified by its CONNECTION_ID().
+type backendSession struct {
+	dataSource string
+	threadID   uint32
+}
+
+// showBlockers answers SHOW DBPACK BLOCKERS with the lock waits of the data sources of the listener,
+// each row followed by the ids of the client sessions the waiting and the blocking backend sessions
+// are pinned to, and the name of the data source. A backend session pinned to no client session, such
+// as one running a statement outside a transaction or one of another client of the data source, has a
+// NULL client session.
+// The data sources failing to list their lock waits are left out.
+func (l *MysqlListener) showBlockers() (proto.Result, uint16, error) {
+	dbs, results := l.queryBackends(innodbLockWaits)
+	sessions := l.backendSessions()
+
+	result := &mysql.Result{}
+	for i, rlt := range results {
+		if rlt == nil {
+			continue
+		}
+		if result.Fields == nil {
+			if len(rlt.Fields) != len(lockWaitColumns) {
+				log.Warnf("db %s: unexpected columns of the lock waits", dbs[i].Name())
+				rlt.Release()
+				continue
+			}
+			result.Fields = append(rlt.Fields[:len(rlt.Fields):len(rlt.Fields)], blockersFields()[len(lockWaitColumns):]...)
+		}
+		for _, row := range rlt.Rows {
+			values, err := row.Decode()
+			if err != nil {
+				log.Warnf("db %s: failed to decode the lock waits: %v", dbs[i].Name(), err)
+				break
+			}
+			// the values are backed by the buffers of the result, which are released below
+			copied := make([]*proto.Value, 0, len(values)+3)
+			for _, value := range values {
+				copied = append(copied, copyValue(value))
+			}
+			copied = append(copied,
+				clientSessionValue(sessions, dbs[i].Name(), values[waitingPid]),
+				clientSessionValue(sessions, dbs[i].Name(), values[blockingPid]),
+				stringValue(dbs[i].Name()))
+			result.Rows = append(result.Rows, mysql.NewTextRow(result.Fields, copied))
+		}
+		rlt.Release()
+	}
+	if result.Fields == nil {
+		result.Fields = blockersFields()
+	}
+	result.AffectedRows = uint64(len(result.Rows))
+	return result, 0, nil
+}
+
+// backendSessions maps the backend sessions pinned to the client sessions of the listener to the ids
+// of the client sessions.
+func (l *MysqlListener) backendSessions() map[backendSession]uint32 {
+	sessions := make(map[backendSession]uint32)
+	l.processes.Range(func(id, _ interface{}) bool {
+		connectionID := id.(uint32)
+		executor, ok := l.connectionExecutor(connectionID).(proto.PinnedConnectionsExecutor)
+		if !ok {
+			return true
+		}
+		for _, connection := range executor.PinnedConnections(connectionID) {
+			sessions[backendSession{dataSource: connection.DataSource, threadID: connection.ThreadID}] = connectionID
+		}
+		return true
+	})
+	return sessions
+}
+
+// clientSessionValue is the id of the client session the backend session of pid is pinned to, NULL if
+// there is none.
+func clientSessionValue(sessions map[backendSession]uint32, dataSource string, pid *proto.Value) *proto.Value {
+	null := &proto.Value{Typ: constant.FieldTypeLongLong}
+	if pid == nil || pid.Val == nil {
+		return null
+	}
+	text, ok := pid.Val.([]byte)
+	if !ok {
+		text = []byte(fmt.Sprint(pid.Val))
+	}
+	threadID, err := strconv.ParseUint(string(text), 10, 32)
+	if err != nil {
+		return null
+	}
+	id, ok := sessions[backendSession{dataSource: dataSource, threadID: uint32(threadID)}]
+	if !ok {
+		return null
+	}
+	return intValue(constant.FieldTypeLongLong, int64(id), false)
+}
+
+// blockersFields are the columns of SHOW DBPACK BLOCKERS, used when no data source lists its lock waits.
+func blockersFields() []*mysql.Field {
+	fields := make([]*mysql.Field, 0, len(lockWaitColumns)+3)
+	for _, column := range lockWaitColumns {
+		fields = append(fields, &mysql.Field{Name: column, FieldType: constant.FieldTypeVarString, CharSet: constant.CharacterSetUtf8})
+	}
+	return append(fields,
+		&mysql.Field{Name: "waiting_session", FieldType: constant.FieldTypeLongLong, CharSet: constant.CharacterSetBinary,
+			Flags: constant.UnsignedFlag},
+		&mysql.Field{Name: "blocking_session", FieldType: constant.FieldTypeLongLong, CharSet: constant.CharacterSetBinary,
+			Flags: constant.UnsignedFlag},
+		backendField())
+}
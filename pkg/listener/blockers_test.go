@@ -0,0 +1,112 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/resource"
+)
+
+type blockersTestDB struct {
+	proto.DB
+	name      string
+	lockWaits [][]string
+}
+
+func (db *blockersTestDB) Name() string {
+	return db.name
+}
+
+func (db *blockersTestDB) QueryDirectly(query string) (proto.Result, uint16, error) {
+	fields := blockersFields()[:len(lockWaitColumns)]
+	result := &mysql.Result{Fields: fields}
+	for _, lockWait := range db.lockWaits {
+		values := make([]*proto.Value, 0, len(lockWait))
+		for _, value := range lockWait {
+			values = append(values, stringValue(value))
+		}
+		result.Rows = append(result.Rows, mysql.NewTextRow(fields, values))
+	}
+	return result, 0, nil
+}
+
+type blockersTestDBManager struct {
+	proto.DBManager
+	dbs []proto.DB
+}
+
+func (manager *blockersTestDBManager) DBs() []proto.DB {
+	return manager.dbs
+}
+
+func TestShowBlockers(t *testing.T) {
+	lockWait := func(waiting, blocking string) []string {
+		return []string{"2022-06-01 10:00:00", "12", "`employees`.`salaries`", "PRIMARY", "RECORD",
+			"1001", waiting, "UPDATE salaries SET salary = 1 WHERE emp_no = 10001", "X",
+			"1000", blocking, "", "X"}
+	}
+	resource.SetDBManager("blockers", &blockersTestDBManager{dbs: []proto.DB{
+		&blockersTestDB{name: "employees", lockWaits: [][]string{lockWait("31", "30"), lockWait("32", "33")}},
+		&blockersTestDB{name: "employees-slave"},
+	}})
+	defer resource.SetDBManager("blockers", nil)
+
+	// client session 7 holds backend session 30 of employees in its transaction, 8 holds 31
+	executor := &pinningTestExecutor{connections: map[uint32][]*proto.PinnedConnection{
+		7: {{DataSource: "employees", ThreadID: 30, Reasons: []string{proto.PinReasonTransaction}}},
+		8: {{DataSource: "employees", ThreadID: 31, Reasons: []string{proto.PinReasonTransaction}}},
+		9: {{DataSource: "employees-slave", ThreadID: 33, Reasons: []string{proto.PinReasonTransaction}}},
+	}}
+	l := &MysqlListener{appID: "blockers", listenerExecutors: listenerExecutors{executor: executor}, connectionExecutors: &sync.Map{}}
+	for _, id := range []uint32{7, 8, 9} {
+		l.processes.Store(id, &process{id: id})
+	}
+
+	result, _, err := l.showBlockers()
+	assert.NoError(t, err)
+	rlt := result.(*mysql.Result)
+	names := mysqlFieldNames(rlt)
+	assert.Equal(t, []string{"waiting_session", "blocking_session", "Backend"}, names[len(names)-3:])
+	var sessions [][]interface{}
+	for _, row := range rlt.Rows {
+		values, err := row.Decode()
+		assert.NoError(t, err)
+		assert.Equal(t, "UPDATE salaries SET salary = 1 WHERE emp_no = 10001", string(values[7].Val.([]byte)))
+		var decoded []interface{}
+		for _, value := range values[len(values)-3:] {
+			if b, ok := value.Val.([]byte); ok {
+				decoded = append(decoded, string(b))
+			} else {
+				decoded = append(decoded, value.Val)
+			}
+		}
+		sessions = append(sessions, decoded)
+	}
+	// backend session 33 of employees is not the one pinned by session 9 on employees-slave
+	assert.Equal(t, [][]interface{}{{"8", "7", "employees"}, {nil, nil, "employees"}}, sessions)
+
+	resource.SetDBManager("blockers", &blockersTestDBManager{})
+	result, _, err = l.showBlockers()
+	assert.NoError(t, err)
+	assert.Len(t, result.(*mysql.Result).Fields, len(lockWaitColumns)+3)
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/replay"
+)
+
+const (
+	captureFile = "capture.jsonl"
+
+	defaultCaptureMaxSize    = 500
+	defaultCaptureMaxBackups = 10
+	defaultCaptureMaxAge     = 7
+)
+
+// CaptureConfig configures the capture of a mysql listener, which records the statements of
+// clients with their timing and session, so that `dbpack replay` can execute them again
+type CaptureConfig struct {
+	CaptureDir string `yaml:"capture_dir" json:"capture_dir"`
+	// MaxSize is the maximum size in megabytes of the capture file before it gets rotated
+	MaxSize int `yaml:"max_size" json:"max_size"`
+	// MaxAge is the maximum number of days to retain old capture files
+	MaxAge int `yaml:"max_age" json:"max_age"`
+	// MaxBackups maximum number of old capture files to retain
+	MaxBackups int `yaml:"max_backups" json:"max_backups"`
+	// Compress determines if the rotated capture files should be compressed using gzip
+	Compress bool `yaml:"compress" json:"compress"`
+}
+
+func newCapture(conf *CaptureConfig) *replay.Writer {
+	if conf.MaxSize == 0 {
+		conf.MaxSize = defaultCaptureMaxSize
+	}
+	if conf.MaxBackups == 0 {
+		conf.MaxBackups = defaultCaptureMaxBackups
+	}
+	if conf.MaxAge == 0 {
+		conf.MaxAge = defaultCaptureMaxAge
+	}
+	return replay.NewWriter(&lumberjack.Logger{
+		Filename:   filepath.Join(conf.CaptureDir, captureFile),
+		MaxSize:    conf.MaxSize,
+		MaxBackups: conf.MaxBackups,
+		MaxAge:     conf.MaxAge,
+		Compress:   conf.Compress,
+	})
+}
+
+// captureEvent builds the capture event of the statement on ctx sent by c, the parameters of
+// a prepared statement are copied since executing it mutates its bind variables.
+func captureEvent(ctx context.Context, c *mysql.Conn) *replay.Event {
+	event := &replay.Event{
+		Time:         time.Now(),
+		ConnectionID: c.ID(),
+		User:         c.UserName(),
+		Schema:       c.Schema(),
+		SQL:          proto.SqlText(ctx),
+	}
+	switch proto.CommandType(ctx) {
+	case constant.ComQuery:
+		event.Command = replay.CommandQuery
+	case constant.ComStmtExecute:
+		event.Command = replay.CommandExecute
+		stmt := proto.PrepareStmt(ctx)
+		event.Args = make([]interface{}, 0, stmt.ParamsCount)
+		for i := 1; i <= int(stmt.ParamsCount); i++ {
+			event.Args = append(event.Args, stmt.BindVars["v"+strconv.Itoa(i)])
+		}
+	}
+	return event
+}
+
+func (l *MysqlListener) writeCapture(event *replay.Event) {
+	if err := l.capture.Write(event); err != nil {
+		log.Warnf("write capture of connection %d failed, %v", event.ConnectionID, err)
+	}
+}
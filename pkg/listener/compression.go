@@ -0,0 +1,69 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/cectc/dbpack/pkg/mysql"
+)
+
+const (
+	directionSent     = "sent"
+	directionReceived = "received"
+)
+
+// the ratio of the compressed protocol is the rate of the compressed bytes over the rate of the
+// uncompressed bytes, e.g. of the results sent to the clients of a remote region.
+var (
+	compressionUncompressedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "dbpack",
+		Subsystem: "listener",
+		Name:      "compression_uncompressed_bytes_total",
+		Help:      "bytes of the packets of the client connections using the compressed protocol, before compression",
+	}, []string{"listener", "algorithm", "direction"})
+	compressionCompressedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "dbpack",
+		Subsystem: "listener",
+		Name:      "compression_compressed_bytes_total",
+		Help:      "bytes of the packets of the client connections using the compressed protocol, after compression",
+	}, []string{"listener", "algorithm", "direction"})
+)
+
+func init() {
+	prometheus.MustRegister(compressionUncompressedBytes, compressionCompressedBytes)
+}
+
+// accountCompression adds the bytes c compressed and decompressed since last to the metrics of the
+// listener, and updates last.
+func (l *MysqlListener) accountCompression(c *mysql.Conn, last *mysql.CompressionStats) {
+	algorithm := c.CompressionAlgorithm()
+	if algorithm == "" {
+		return
+	}
+	stats := c.CompressionStats()
+	listener := l.listeners[0].Addr().String()
+	compressionUncompressedBytes.WithLabelValues(listener, algorithm, directionSent).
+		Add(float64(stats.Sent - last.Sent))
+	compressionCompressedBytes.WithLabelValues(listener, algorithm, directionSent).
+		Add(float64(stats.SentCompressed - last.SentCompressed))
+	compressionUncompressedBytes.WithLabelValues(listener, algorithm, directionReceived).
+		Add(float64(stats.Received - last.Received))
+	compressionCompressedBytes.WithLabelValues(listener, algorithm, directionReceived).
+		Add(float64(stats.ReceivedCompressed - last.ReceivedCompressed))
+	*last = stats
+}
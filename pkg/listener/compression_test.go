@@ -0,0 +1,69 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/mysql"
+)
+
+func TestAccountCompression(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	l := &MysqlListener{listeners: []net.Listener{ln}}
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	s, c := mysql.NewConn(server), mysql.NewConn(client)
+	var last mysql.CompressionStats
+
+	// an uncompressed connection is not accounted
+	l.accountCompression(s, &last)
+	assert.Equal(t, mysql.CompressionStats{}, last)
+
+	assert.NoError(t, s.EnableCompression(mysql.CompressionZstd, 0))
+	assert.NoError(t, c.EnableCompression(mysql.CompressionZstd, 0))
+	sent := func() float64 {
+		return testutil.ToFloat64(compressionUncompressedBytes.WithLabelValues(ln.Addr().String(), mysql.CompressionZstd, directionSent))
+	}
+	sentCompressed := func() float64 {
+		return testutil.ToFloat64(compressionCompressedBytes.WithLabelValues(ln.Addr().String(), mysql.CompressionZstd, directionSent))
+	}
+	before, beforeCompressed := sent(), sentCompressed()
+	for i := 0; i < 2; i++ {
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- s.WritePacket(bytes.Repeat([]byte("report row "), 1000))
+		}()
+		_, err = c.ReadPacket()
+		assert.NoError(t, err)
+		assert.NoError(t, <-errCh)
+		l.accountCompression(s, &last)
+	}
+
+	// a report compresses well, each packet is accounted once
+	assert.Equal(t, float64(2*(11000+4)), sent()-before)
+	assert.Less(t, sentCompressed()-beforeCompressed, (sent()-before)/10)
+	assert.Equal(t, s.CompressionStats(), last)
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/misc"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+)
+
+// StatementTimeoutAttribute is the connection attribute a client declares the timeout of its
+// statements with, a duration such as 5s, or a number of milliseconds.
+const StatementTimeoutAttribute = "statement_timeout"
+
+// parseStatementTimeout parses the timeout a client declares in the connection attributes.
+func parseStatementTimeout(value string) (time.Duration, error) {
+	if ms, err := strconv.ParseUint(value, 10, 64); err == nil {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid %s %s", StatementTimeoutAttribute, value)
+	}
+	if timeout < 0 {
+		return 0, errors.Errorf("%s %s must not be negative", StatementTimeoutAttribute, value)
+	}
+	return timeout, nil
+}
+
+// setStatementTimeout keeps the statement timeout the client of c declares in attrs.
+func (l *MysqlListener) setStatementTimeout(c *mysql.Conn, attrs map[string]string) {
+	value, ok := attrs[StatementTimeoutAttribute]
+	if !ok {
+		return
+	}
+	timeout, err := parseStatementTimeout(value)
+	if err != nil {
+		log.Warnf("conn %d: ignore the statement timeout: %v", c.ID(), err)
+		return
+	}
+	l.statementTimeouts.Store(c.ID(), timeout)
+}
+
+// statementTimeout returns the timeout of a statement, which is the MAX_EXECUTION_TIME hint of a
+// SELECT, the timeout declared by the client, or the statement timeout of the listener, in that
+// order, zero if there is none.
+func (l *MysqlListener) statementTimeout(connectionID uint32, stmt ast.StmtNode) time.Duration {
+	if sel, ok := stmt.(*ast.SelectStmt); ok {
+		if has, timeout := misc.HasMaxExecutionTimeHint(sel.TableHints); has {
+			return timeout
+		}
+	}
+	if timeout, ok := l.statementTimeouts.Load(connectionID); ok {
+		return timeout.(time.Duration)
+	}
+	return l.conf.StatementTimeout
+}
+
+// withStatementDeadline bounds ctx by the timeout of the statement, which the backends are waited
+// for connections, sent the statement and read the result within.
+func (l *MysqlListener) withStatementDeadline(ctx context.Context, connectionID uint32,
+	stmt ast.StmtNode) (context.Context, context.CancelFunc) {
+	timeout := l.statementTimeout(connectionID, stmt)
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/mysql"
+)
+
+func TestStatementTimeout(t *testing.T) {
+	l := &MysqlListener{conf: MysqlConfig{StatementTimeout: 30 * time.Second}}
+	c := mysql.NewConn(nil)
+	c.SetConnectionID(1)
+
+	stmt, err := l.parse("SELECT * FROM employees")
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Second, l.statementTimeout(c.ID(), stmt))
+
+	l.setStatementTimeout(c, map[string]string{StatementTimeoutAttribute: "invalid"})
+	assert.Equal(t, 30*time.Second, l.statementTimeout(c.ID(), stmt))
+	l.setStatementTimeout(c, map[string]string{StatementTimeoutAttribute: "5000"})
+	assert.Equal(t, 5*time.Second, l.statementTimeout(c.ID(), stmt))
+	l.setStatementTimeout(c, map[string]string{StatementTimeoutAttribute: "2s"})
+	assert.Equal(t, 2*time.Second, l.statementTimeout(c.ID(), stmt))
+
+	hinted, err := l.parse("SELECT /*+ MAX_EXECUTION_TIME(100) */ * FROM employees")
+	assert.NoError(t, err)
+	assert.Equal(t, 100*time.Millisecond, l.statementTimeout(c.ID(), hinted))
+
+	ctx, cancel := l.withStatementDeadline(context.Background(), c.ID(), hinted)
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(100*time.Millisecond), deadline, 50*time.Millisecond)
+
+	l = &MysqlListener{}
+	ctx, cancel = l.withStatementDeadline(context.Background(), c.ID(), stmt)
+	defer cancel()
+	_, ok = ctx.Deadline()
+	assert.False(t, ok)
+}
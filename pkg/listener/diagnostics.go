@@ -0,0 +1,63 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+
+	err2 "github.com/cectc/dbpack/pkg/errors"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+// maxDiagnosticTargets is the number of routing targets listed in the diagnostic suffix, a
+// scatter statement may be routed to many data sources.
+const maxDiagnosticTargets = 3
+
+// diagnose translates the error of a statement into the error returned to the client, with
+// the data sources the statement was routed to and its trace id appended to the message, so
+// that the client logs can be matched with the data sources and the traces. The errors which
+// carry a mysql error already, such as the ones of the backends, are relayed as they are, the
+// clients key their handling of them on the codes and messages. A statement which failed to
+// parse has no context.
+func diagnose(ctx context.Context, err error) error {
+	var relayed *err2.SQLError
+	if ctx == nil || errors.As(err, &relayed) {
+		return err2.Translate(err)
+	}
+	sqlErr := *err2.Translate(err)
+	var diagnostics []string
+	if targets := proto.RoutingTargets(ctx); len(targets) > 0 {
+		listed := strings.Join(targets, ",")
+		if len(targets) > maxDiagnosticTargets {
+			listed = fmt.Sprintf("%s and %d more", strings.Join(targets[:maxDiagnosticTargets], ","),
+				len(targets)-maxDiagnosticTargets)
+		}
+		diagnostics = append(diagnostics, "target: "+listed)
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.HasTraceID() {
+		diagnostics = append(diagnostics, "trace: "+spanContext.TraceID().String())
+	}
+	if len(diagnostics) > 0 {
+		sqlErr.Message = fmt.Sprintf("%s [%s]", sqlErr.Message, strings.Join(diagnostics, "; "))
+	}
+	return &sqlErr
+}
@@ -0,0 +1,136 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	err2 "github.com/cectc/dbpack/pkg/errors"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/packet"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/third_party/pools"
+)
+
+func TestDiagnose(t *testing.T) {
+	l := &MysqlListener{}
+	_, parseErr := l.parse("SELEC * FROM employees")
+	assert.Error(t, parseErr)
+
+	testCases := []struct {
+		err    error
+		errNum int
+		state  string
+	}{
+		{err: parseErr, errNum: constant.ERParseError, state: "42000"},
+		{err: errors.Wrap(pools.ErrTimeout, "get connection"), errNum: constant.ERPoolTimeout, state: constant.SSUnknownSQLState},
+		{err: pools.ErrClosed, errNum: constant.ERServerShutdown, state: constant.SSServerShutdown},
+		{err: context.DeadlineExceeded, errNum: constant.ERQueryTimeout, state: constant.SSUnknownSQLState},
+		{err: context.Canceled, errNum: constant.ERQueryInterrupted, state: constant.SSQueryInterrupted},
+		{err: err2.NewRoutingError(errors.New("sharding key not found")), errNum: constant.ERRoutingFailed, state: constant.SSUnknownSQLState},
+		{err: err2.NewSQLError(constant.ERNoSuchTable, constant.SSUnknownSQLState, "Table 'employees' doesn't exist"), errNum: constant.ERNoSuchTable, state: constant.SSUnknownSQLState},
+		{err: errors.New("unexpected"), errNum: constant.ERUnknownError, state: constant.SSUnknownSQLState},
+	}
+	for _, c := range testCases {
+		t.Run(c.err.Error(), func(t *testing.T) {
+			sqlErr, ok := diagnose(context.Background(), c.err).(*err2.SQLError)
+			assert.True(t, ok)
+			assert.Equal(t, c.errNum, sqlErr.Num)
+			assert.Equal(t, c.state, sqlErr.State)
+			assert.NotContains(t, sqlErr.Message, "[")
+		})
+	}
+
+	ctx := proto.WithRoutingTargets(context.Background())
+	for i := 0; i < 5; i++ {
+		proto.AddRoutingTarget(ctx, fmt.Sprintf("ds%d", i))
+	}
+	proto.AddRoutingTarget(ctx, "ds0")
+	traceID := trace.TraceID{0x01}
+	ctx = trace.ContextWithSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID}))
+	sqlErr := diagnose(ctx, pools.ErrTimeout).(*err2.SQLError)
+	assert.Equal(t, "statement timed out waiting for a backend connection "+
+		"[target: ds0,ds1,ds2 and 2 more; trace: "+traceID.String()+"]", sqlErr.Message)
+
+	sqlErr = diagnose(nil, parseErr).(*err2.SQLError)
+	assert.Equal(t, constant.ERParseError, sqlErr.Num)
+}
+
+type stmtErrorTestExecutor struct {
+	schemaTestExecutor
+	err error
+}
+
+func (executor *stmtErrorTestExecutor) ExecutorComStmtExecute(ctx context.Context, stmt *proto.Stmt) (proto.Result, uint16, error) {
+	proto.AddRoutingTarget(ctx, "employees")
+	return nil, 0, errors.Wrap(executor.err, "execute statement failed")
+}
+
+func TestComStmtExecuteRelaysBackendErrors(t *testing.T) {
+	testCases := []*err2.SQLError{
+		err2.NewSQLError(constant.ERDupEntry, constant.SSDupKey, "Duplicate entry '1' for key 'PRIMARY'"),
+		err2.NewSQLError(constant.ERLockWaitTimeout, constant.SSUnknownSQLState,
+			"Lock wait timeout exceeded; try restarting transaction"),
+		err2.NewSQLError(constant.ERLockDeadlock, "40001",
+			"Deadlock found when trying to get lock; try restarting transaction"),
+	}
+	for _, backendErr := range testCases {
+		t.Run(backendErr.Message, func(t *testing.T) {
+			executor := &stmtErrorTestExecutor{err: backendErr}
+			l := &MysqlListener{listenerExecutors: listenerExecutors{executor: executor}, connectionExecutors: &sync.Map{}, stmts: &sync.Map{}}
+			stmt, err := l.parse("INSERT INTO employees(id) VALUES (1)")
+			assert.NoError(t, err)
+			l.stmts.Store(uint32(1), &proto.Stmt{StatementID: 1, SqlText: stmt.Text(), StmtNode: stmt})
+
+			server, client := net.Pipe()
+			defer client.Close()
+			conn := mysql.NewConn(server)
+			defer conn.Close()
+			conn.SetConnectionID(1)
+
+			response := make(chan []byte)
+			go func() {
+				clientConn := mysql.NewConn(client)
+				_ = clientConn.WritePacket([]byte{constant.ComStmtExecute, 1, 0, 0, 0, 0, 1, 0, 0, 0})
+				data, _ := clientConn.ReadPacket()
+				response <- data
+			}()
+			data, err := conn.ReadEphemeralPacket()
+			assert.NoError(t, err)
+			ctx := proto.WithRoutingTargets(proto.WithConnectionID(context.Background(), 1))
+			assert.NoError(t, l.ExecuteCommand(ctx, conn, data))
+
+			data = <-response
+			assert.Equal(t, byte(constant.ErrPacket), data[0])
+			sqlErr, ok := packet.ParseErrorPacket(data).(*err2.SQLError)
+			if assert.True(t, ok) {
+				assert.Equal(t, backendErr.Num, sqlErr.Num)
+				assert.Equal(t, backendErr.State, sqlErr.State)
+				assert.Equal(t, backendErr.Message, sqlErr.Message)
+			}
+		})
+	}
+}
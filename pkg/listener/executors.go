@@ -0,0 +1,65 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"sync"
+
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+// listenerExecutors are the executors of a listener, chosen by the schema of the connections.
+type listenerExecutors struct {
+	// executorMu guards executor and schemaExecutors, which may be replaced while serving.
+	executorMu sync.RWMutex
+	// executor serves the connections using a schema not in schemaExecutors.
+	executor proto.Executor
+	// schemaExecutors maps schemas to the executors serving the connections using them.
+	schemaExecutors map[string]proto.Executor
+}
+
+func (e *listenerExecutors) SetExecutor(executor proto.Executor) {
+	e.executorMu.Lock()
+	defer e.executorMu.Unlock()
+	e.executor = executor
+}
+
+func (e *listenerExecutors) SetSchemaExecutor(schema string, executor proto.Executor) {
+	e.executorMu.Lock()
+	defer e.executorMu.Unlock()
+	if e.schemaExecutors == nil {
+		e.schemaExecutors = make(map[string]proto.Executor)
+	}
+	e.schemaExecutors[schema] = executor
+}
+
+// schemaExecutor returns the executor serving the connections using schema, nil if there is none.
+func (e *listenerExecutors) schemaExecutor(schema string) proto.Executor {
+	e.executorMu.RLock()
+	defer e.executorMu.RUnlock()
+	if executor, ok := e.schemaExecutors[schema]; ok {
+		return executor
+	}
+	return e.executor
+}
+
+// defaultExecutor returns the executor serving the connections using a schema no other executor serves.
+func (e *listenerExecutors) defaultExecutor() proto.Executor {
+	e.executorMu.RLock()
+	defer e.executorMu.RUnlock()
+	return e.executor
+}
@@ -0,0 +1,441 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/dataapi"
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/tracing"
+	"github.com/cectc/dbpack/pkg/visitor"
+)
+
+const (
+	defaultStreamBatchSize = 100
+	// binaryCharset is the charset of the binary columns, whose values are returned as bytes
+	binaryCharset = 63
+
+	grpcUserMetadata     = "user"
+	grpcPasswordMetadata = "password"
+)
+
+type GrpcConfig struct {
+	// Users maps user names to passwords, the clients send them as the user and password metadata
+	// of each call. No authentication is required if it is empty.
+	Users map[string]string `yaml:"users" json:"users"`
+	// ServerVersion and Dialect choose the syntax the statements are parsed with, as those of the mysql listener
+	ServerVersion string `yaml:"server_version" json:"server_version"`
+	Dialect       string `yaml:"dialect" json:"dialect"`
+	// StreamBatchSize is the max number of rows of a message of a streamed result, defaults to 100
+	StreamBatchSize int `yaml:"stream_batch_size" json:"stream_batch_size"`
+}
+
+// GrpcListener serves the data api over grpc, the statements go through the filters of the listener
+// and the executors as those of the mysql listener do. Each call is a connection of its own for the
+// executors, except that the statements of a BeginTx stream share the connection of the transaction.
+type GrpcListener struct {
+	dataapi.UnimplementedDataServiceServer
+
+	conf     GrpcConfig
+	listener net.Listener
+	server   *grpc.Server
+
+	listenerExecutors
+
+	runner *statementRunner
+}
+
+func NewGrpcListener(conf *config.Listener) (proto.Listener, error) {
+	var (
+		err     error
+		content []byte
+		cfg     GrpcConfig
+	)
+
+	if content, err = json.Marshal(conf.Config); err != nil {
+		return nil, errors.Wrap(err, "marshal grpc listener config failed.")
+	}
+	if err = json.Unmarshal(content, &cfg); err != nil {
+		log.Errorf("unmarshal grpc listener config failed, %s", err)
+		return nil, err
+	}
+	if cfg.Dialect, err = visitor.ResolveDialect(cfg.Dialect, cfg.ServerVersion); err != nil {
+		return nil, err
+	}
+	if cfg.StreamBatchSize < 0 {
+		return nil, errors.Errorf("stream_batch_size must not be negative, got %d", cfg.StreamBatchSize)
+	}
+	if cfg.StreamBatchSize == 0 {
+		cfg.StreamBatchSize = defaultStreamBatchSize
+	}
+
+	runner, err := newStatementRunner(conf, cfg.Dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := net.Listen("tcp", conf.SocketAddress.String())
+	if err != nil {
+		log.Errorf("listen %s error, %s", conf.SocketAddress.String(), err)
+		return nil, err
+	}
+	listener := &GrpcListener{
+		conf:     cfg,
+		listener: l,
+		server:   grpc.NewServer(),
+		runner:   runner,
+	}
+	dataapi.RegisterDataServiceServer(listener.server, listener)
+	return listener, nil
+}
+
+// Addr returns the address the listener accepts connections on.
+func (l *GrpcListener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+func (l *GrpcListener) Listen() {
+	log.Infof("start grpc listener %s", l.listener.Addr())
+	if err := l.server.Serve(l.listener); err != nil {
+		log.Error(err)
+	}
+}
+
+// Close stops the listener, the transactions of the BeginTx streams are rolled back.
+func (l *GrpcListener) Close() {
+	l.server.Stop()
+}
+
+func (l *GrpcListener) Query(ctx context.Context, request *dataapi.QueryRequest) (*dataapi.Result, error) {
+	ctx, executor, err := l.session(ctx, request.Database)
+	if err != nil {
+		return nil, err
+	}
+	defer l.closeSession(ctx, executor)
+
+	result, err := l.execute(ctx, executor, request)
+	if err != nil {
+		return nil, err
+	}
+	var response *dataapi.Result
+	err = l.send(result, func(r *dataapi.Result) error {
+		response = r
+		return nil
+	}, 0)
+	return response, err
+}
+
+func (l *GrpcListener) Execute(ctx context.Context, request *dataapi.QueryRequest) (*dataapi.Result, error) {
+	ctx, executor, err := l.session(ctx, request.Database)
+	if err != nil {
+		return nil, err
+	}
+	defer l.closeSession(ctx, executor)
+
+	result, err := l.execute(ctx, executor, request)
+	if err != nil {
+		return nil, err
+	}
+	// the rows of a statement returning rows are dropped
+	defer releaseResult(result)
+	return executeResult(result), nil
+}
+
+func (l *GrpcListener) Stream(request *dataapi.QueryRequest, stream dataapi.DataService_StreamServer) error {
+	ctx, executor, err := l.session(stream.Context(), request.Database)
+	if err != nil {
+		return err
+	}
+	defer l.closeSession(ctx, executor)
+
+	result, err := l.execute(ctx, executor, request)
+	if err != nil {
+		return err
+	}
+	return l.send(result, stream.Send, l.conf.StreamBatchSize)
+}
+
+// BeginTx starts a transaction on the database of the first statement, each statement is replied with its
+// result. The transaction ends with the stream, it is rolled back if a statement fails or if the stream
+// ends before the transaction is committed.
+func (l *GrpcListener) BeginTx(stream dataapi.DataService_BeginTxServer) error {
+	request, err := stream.Recv()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var database string
+	if request.Query != nil {
+		database = request.Query.Database
+	}
+	ctx, executor, err := l.session(stream.Context(), database)
+	if err != nil {
+		return err
+	}
+	defer l.closeSession(ctx, executor)
+
+	result, err := l.executeSQL(ctx, executor, "START TRANSACTION")
+	if err != nil {
+		return err
+	}
+	releaseResult(result)
+	for {
+		switch request.Action {
+		case dataapi.TxCommit, dataapi.TxRollback:
+			sql := "COMMIT"
+			if request.Action == dataapi.TxRollback {
+				sql = "ROLLBACK"
+			}
+			result, err := l.executeSQL(ctx, executor, sql)
+			if err != nil {
+				return err
+			}
+			releaseResult(result)
+			return stream.Send(executeResult(result))
+		default:
+			if request.Query == nil {
+				return status.Error(codes.InvalidArgument, "the statement of the transaction is missing")
+			}
+			if request.Query.Database != "" && request.Query.Database != database {
+				return status.Errorf(codes.FailedPrecondition,
+					"the transaction on database '%s' can not run statements on database '%s'", database, request.Query.Database)
+			}
+			result, err := l.execute(ctx, executor, request.Query)
+			if err != nil {
+				return err
+			}
+			if err = l.send(result, stream.Send, 0); err != nil {
+				return err
+			}
+		}
+
+		if request, err = stream.Recv(); err != nil {
+			if err == io.EOF {
+				return status.Error(codes.Aborted, "the transaction is rolled back since it is not committed")
+			}
+			return err
+		}
+	}
+}
+
+// session authenticates the call and returns the context of the connection serving it, with the executor of database.
+func (l *GrpcListener) session(ctx context.Context, database string) (context.Context, proto.Executor, error) {
+	user, err := l.authenticate(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	executor := l.schemaExecutor(database)
+	if executor == nil {
+		return nil, nil, status.Error(codes.NotFound, noSchemaExecutorError(database).Error())
+	}
+	ctx = proto.WithVariableMap(ctx)
+	ctx = proto.WithConnectionID(ctx, connectionIDs.Inc())
+	ctx = proto.WithUserName(ctx, user)
+	if p, ok := peer.FromContext(ctx); ok {
+		ctx = proto.WithRemoteAddr(ctx, p.Addr.String())
+	}
+	ctx = proto.WithSchema(ctx, database)
+	return ctx, executor, nil
+}
+
+// closeSession closes the connection of the call, which rolls back the transaction left by it.
+func (l *GrpcListener) closeSession(ctx context.Context, executor proto.Executor) {
+	// the context of the call may be canceled already
+	executor.ConnectionClose(proto.WithConnectionID(context.Background(), proto.ConnectionID(ctx)))
+}
+
+func (l *GrpcListener) authenticate(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var user, password string
+	if values := md.Get(grpcUserMetadata); len(values) > 0 {
+		user = values[0]
+	}
+	if len(l.conf.Users) == 0 {
+		return user, nil
+	}
+	if values := md.Get(grpcPasswordMetadata); len(values) > 0 {
+		password = values[0]
+	}
+	expected, ok := l.conf.Users[user]
+	if !ok || subtle.ConstantTimeCompare([]byte(expected), []byte(password)) != 1 {
+		return "", status.Errorf(codes.Unauthenticated, "access denied for user '%s'", user)
+	}
+	return user, nil
+}
+
+func (l *GrpcListener) executeSQL(ctx context.Context, executor proto.Executor, sql string) (proto.Result, error) {
+	return l.execute(ctx, executor, &dataapi.QueryRequest{SQL: sql})
+}
+
+// execute runs a statement through the filters and the executor, it is prepared if it has args.
+func (l *GrpcListener) execute(ctx context.Context, executor proto.Executor,
+	request *dataapi.QueryRequest) (proto.Result, error) {
+	stmt, err := l.runner.parse(request.SQL)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	args := make([]interface{}, 0, len(request.Args))
+	for _, arg := range request.Args {
+		args = append(args, bindVar(arg))
+	}
+	return l.runner.execute(ctx, executor, tracing.GrpcListenerExecute, request.SQL, stmt, args)
+}
+
+// send converts result into messages of at most batchSize rows, all the rows are sent in one message if
+// batchSize is 0. The first message has the columns, the affected rows and the last insert id. The result
+// is released once sent.
+func (l *GrpcListener) send(result proto.Result, send func(*dataapi.Result) error, batchSize int) error {
+	response := executeResult(result)
+	rlt, ok := result.(*mysql.Result)
+	if !ok {
+		return send(response)
+	}
+	defer rlt.Release()
+
+	response.Columns = make([]*dataapi.Column, 0, len(rlt.Fields))
+	for _, field := range rlt.Fields {
+		response.Columns = append(response.Columns, &dataapi.Column{
+			Name:     field.Name,
+			Table:    field.Table,
+			Database: field.Database,
+			Type:     int32(field.FieldType),
+		})
+	}
+	if batchSize == 0 {
+		batchSize = len(rlt.Rows)
+	}
+	for i, row := range rlt.Rows {
+		values, err := row.Decode()
+		if err != nil {
+			return err
+		}
+		response.Rows = append(response.Rows, newRow(rlt.Fields, values))
+		if len(response.Rows) == batchSize && i < len(rlt.Rows)-1 {
+			if err = send(response); err != nil {
+				return err
+			}
+			response = &dataapi.Result{}
+		}
+	}
+	return send(response)
+}
+
+// executeResult returns the message of the affected rows and the last insert id of result.
+func executeResult(result proto.Result) *dataapi.Result {
+	response := &dataapi.Result{}
+	if result == nil {
+		return response
+	}
+	response.AffectedRows, _ = result.RowsAffected()
+	response.LastInsertID, _ = result.LastInsertId()
+	return response
+}
+
+func newRow(fields []*mysql.Field, values []*proto.Value) *dataapi.Row {
+	row := &dataapi.Row{Values: make([]*dataapi.Value, 0, len(values))}
+	for i, value := range values {
+		row.Values = append(row.Values, newValue(fields[i], value))
+	}
+	return row
+}
+
+// newValue converts a value of a row, the bytes are copied since the rows are released once sent.
+func newValue(field *mysql.Field, value *proto.Value) *dataapi.Value {
+	if value == nil || value.Val == nil {
+		return &dataapi.Value{}
+	}
+	switch val := value.Val.(type) {
+	case int64:
+		return &dataapi.Value{Kind: &dataapi.Value_IntValue{IntValue: val}}
+	case uint64:
+		return &dataapi.Value{Kind: &dataapi.Value_UintValue{UintValue: val}}
+	case float32:
+		return &dataapi.Value{Kind: &dataapi.Value_DoubleValue{DoubleValue: float64(val)}}
+	case float64:
+		return &dataapi.Value{Kind: &dataapi.Value_DoubleValue{DoubleValue: val}}
+	case []byte:
+		return newTextValue(field, val)
+	case string:
+		return &dataapi.Value{Kind: &dataapi.Value_StringValue{StringValue: val}}
+	case time.Time:
+		return &dataapi.Value{Kind: &dataapi.Value_StringValue{StringValue: val.Format("2006-01-02 15:04:05.999999")}}
+	default:
+		return &dataapi.Value{Kind: &dataapi.Value_StringValue{StringValue: fmt.Sprint(val)}}
+	}
+}
+
+// newTextValue converts a value of the text protocol, the numbers are typed by the type of the column.
+func newTextValue(field *mysql.Field, val []byte) *dataapi.Value {
+	switch field.FieldType {
+	case constant.FieldTypeTiny, constant.FieldTypeShort, constant.FieldTypeInt24, constant.FieldTypeLong,
+		constant.FieldTypeLongLong, constant.FieldTypeYear:
+		if field.Flags&constant.UnsignedFlag != 0 {
+			if v, err := strconv.ParseUint(string(val), 10, 64); err == nil {
+				return &dataapi.Value{Kind: &dataapi.Value_UintValue{UintValue: v}}
+			}
+		} else if v, err := strconv.ParseInt(string(val), 10, 64); err == nil {
+			return &dataapi.Value{Kind: &dataapi.Value_IntValue{IntValue: v}}
+		}
+	case constant.FieldTypeFloat, constant.FieldTypeDouble:
+		if v, err := strconv.ParseFloat(string(val), 64); err == nil {
+			return &dataapi.Value{Kind: &dataapi.Value_DoubleValue{DoubleValue: v}}
+		}
+	}
+	if field.CharSet == binaryCharset {
+		return &dataapi.Value{Kind: &dataapi.Value_BytesValue{BytesValue: append([]byte(nil), val...)}}
+	}
+	return &dataapi.Value{Kind: &dataapi.Value_StringValue{StringValue: string(val)}}
+}
+
+// bindVar converts an arg into a bind var, typed as those of the prepared statements of the mysql protocol.
+func bindVar(value *dataapi.Value) interface{} {
+	switch kind := value.GetKind().(type) {
+	case *dataapi.Value_IntValue:
+		return kind.IntValue
+	case *dataapi.Value_UintValue:
+		return kind.UintValue
+	case *dataapi.Value_DoubleValue:
+		return kind.DoubleValue
+	case *dataapi.Value_StringValue:
+		return []byte(kind.StringValue)
+	case *dataapi.Value_BytesValue:
+		return kind.BytesValue
+	default:
+		return nil
+	}
+}
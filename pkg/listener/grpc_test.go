@@ -0,0 +1,230 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/dataapi"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+// grpcTestExecutor returns two rows for the queries and an affected row for the other statements,
+// it records the statements by connection.
+type grpcTestExecutor struct {
+	proto.Executor
+	mu         sync.Mutex
+	statements map[uint32][]string
+	bindVars   []interface{}
+	closed     []uint32
+}
+
+func newGrpcTestExecutor() *grpcTestExecutor {
+	return &grpcTestExecutor{statements: make(map[uint32][]string)}
+}
+
+func (executor *grpcTestExecutor) record(ctx context.Context) {
+	executor.mu.Lock()
+	defer executor.mu.Unlock()
+	connectionID := proto.ConnectionID(ctx)
+	executor.statements[connectionID] = append(executor.statements[connectionID], proto.SqlText(ctx))
+}
+
+func (executor *grpcTestExecutor) result(ctx context.Context, sql string) (proto.Result, uint16, error) {
+	if len(sql) < 6 || sql[:6] != "SELECT" {
+		return &mysql.Result{AffectedRows: 1, InsertId: 7}, 0, nil
+	}
+	result := &mysql.Result{Fields: []*mysql.Field{
+		{Name: "id", Table: "student", FieldType: constant.FieldTypeLong},
+		{Name: "name", Table: "student", FieldType: constant.FieldTypeVarString},
+	}}
+	textCtx := proto.WithCommandType(context.Background(), constant.ComQuery)
+	if err := result.AppendRow(textCtx, []byte{0x01, '1', 0x05, 's', 'c', 'o', 't', 't'}); err != nil {
+		return nil, 0, err
+	}
+	if err := result.AppendRow(textCtx, []byte{0x01, '2', 0xfb}); err != nil {
+		return nil, 0, err
+	}
+	return result, 0, nil
+}
+
+func (executor *grpcTestExecutor) ExecutorComQuery(ctx context.Context, sql string) (proto.Result, uint16, error) {
+	executor.record(ctx)
+	return executor.result(ctx, sql)
+}
+
+func (executor *grpcTestExecutor) ExecutorComStmtExecute(ctx context.Context, stmt *proto.Stmt) (proto.Result, uint16, error) {
+	executor.record(ctx)
+	executor.mu.Lock()
+	for i := 1; i <= int(stmt.ParamsCount); i++ {
+		executor.bindVars = append(executor.bindVars, stmt.BindVars["v"+string(rune('0'+i))])
+	}
+	executor.mu.Unlock()
+	return executor.result(ctx, stmt.SqlText)
+}
+
+func (executor *grpcTestExecutor) ConnectionClose(ctx context.Context) {
+	executor.mu.Lock()
+	defer executor.mu.Unlock()
+	executor.closed = append(executor.closed, proto.ConnectionID(ctx))
+}
+
+func (executor *grpcTestExecutor) connections() map[uint32][]string {
+	executor.mu.Lock()
+	defer executor.mu.Unlock()
+	connections := make(map[uint32][]string, len(executor.statements))
+	for id, statements := range executor.statements {
+		connections[id] = statements
+	}
+	return connections
+}
+
+func TestGrpcListener(t *testing.T) {
+	l, err := NewGrpcListener(&config.Listener{
+		ProtocolType:  config.Grpc,
+		SocketAddress: config.SocketAddress{Address: "127.0.0.1"},
+		Config: map[string]interface{}{
+			"users":             map[string]string{"dksl": "123456"},
+			"stream_batch_size": 1,
+		},
+	})
+	assert.Nil(t, err)
+	grpcListener := l.(*GrpcListener)
+	executor, ordersExecutor := newGrpcTestExecutor(), newGrpcTestExecutor()
+	grpcListener.SetExecutor(executor)
+	grpcListener.SetSchemaExecutor("orders", ordersExecutor)
+	go grpcListener.Listen()
+	defer grpcListener.Close()
+
+	conn, err := grpc.Dial(grpcListener.Addr().String(), grpc.WithInsecure())
+	assert.Nil(t, err)
+	defer conn.Close()
+	client := dataapi.NewDataServiceClient(conn)
+
+	_, err = client.Query(context.Background(), &dataapi.QueryRequest{SQL: "SELECT 1"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "user", "dksl", "password", "123456")
+	_, err = client.Query(ctx, &dataapi.QueryRequest{SQL: "SELEC 1"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	result, err := client.Query(ctx, &dataapi.QueryRequest{
+		SQL:  "SELECT id, name FROM student WHERE id > ? AND name <> ?",
+		Args: []*dataapi.Value{{Kind: &dataapi.Value_IntValue{IntValue: 0}}, {Kind: &dataapi.Value_StringValue{StringValue: "a"}}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{int64(0), []byte("a")}, executor.bindVars)
+	assert.Equal(t, []*dataapi.Column{
+		{Name: "id", Table: "student", Type: int32(constant.FieldTypeLong)},
+		{Name: "name", Table: "student", Type: int32(constant.FieldTypeVarString)},
+	}, result.Columns)
+	assert.Equal(t, []*dataapi.Row{
+		{Values: []*dataapi.Value{{Kind: &dataapi.Value_IntValue{IntValue: 1}}, {Kind: &dataapi.Value_StringValue{StringValue: "scott"}}}},
+		{Values: []*dataapi.Value{{Kind: &dataapi.Value_IntValue{IntValue: 2}}, {}}},
+	}, result.Rows)
+
+	result, err = client.Execute(ctx, &dataapi.QueryRequest{Database: "orders", SQL: "DELETE FROM orders WHERE id = 1"})
+	assert.Nil(t, err)
+	assert.Equal(t, &dataapi.Result{AffectedRows: 1, LastInsertID: 7}, result)
+	assert.Len(t, ordersExecutor.connections(), 1)
+
+	stream, err := client.Stream(ctx, &dataapi.QueryRequest{SQL: "SELECT id, name FROM student"})
+	assert.Nil(t, err)
+	var batches []*dataapi.Result
+	for {
+		batch, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		batches = append(batches, batch)
+	}
+	assert.Len(t, batches, 2)
+	assert.Len(t, batches[0].Columns, 2)
+	assert.Len(t, batches[0].Rows, 1)
+	assert.Len(t, batches[1].Columns, 0)
+	assert.Len(t, batches[1].Rows, 1)
+	assert.Len(t, executor.connections(), 2)
+}
+
+func TestGrpcListenerBeginTx(t *testing.T) {
+	l, err := NewGrpcListener(&config.Listener{
+		ProtocolType:  config.Grpc,
+		SocketAddress: config.SocketAddress{Address: "127.0.0.1"},
+		Config:        map[string]interface{}{},
+	})
+	assert.Nil(t, err)
+	grpcListener := l.(*GrpcListener)
+	executor := newGrpcTestExecutor()
+	grpcListener.SetExecutor(executor)
+	go grpcListener.Listen()
+	defer grpcListener.Close()
+
+	conn, err := grpc.Dial(grpcListener.Addr().String(), grpc.WithInsecure())
+	assert.Nil(t, err)
+	defer conn.Close()
+	client := dataapi.NewDataServiceClient(conn)
+
+	tx, err := client.BeginTx(context.Background())
+	assert.Nil(t, err)
+	assert.Nil(t, tx.Send(&dataapi.TxRequest{Query: &dataapi.QueryRequest{SQL: "UPDATE student SET age = 1"}}))
+	result, err := tx.Recv()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), result.AffectedRows)
+	assert.Nil(t, tx.Send(&dataapi.TxRequest{Query: &dataapi.QueryRequest{SQL: "SELECT id, name FROM student"}}))
+	result, err = tx.Recv()
+	assert.Nil(t, err)
+	assert.Len(t, result.Rows, 2)
+	assert.Nil(t, tx.Send(&dataapi.TxRequest{Action: dataapi.TxCommit}))
+	_, err = tx.Recv()
+	assert.Nil(t, err)
+	_, err = tx.Recv()
+	assert.Equal(t, io.EOF, err)
+
+	// the transaction not committed is rolled back by closing the connection
+	tx, err = client.BeginTx(context.Background())
+	assert.Nil(t, err)
+	assert.Nil(t, tx.Send(&dataapi.TxRequest{Query: &dataapi.QueryRequest{SQL: "DELETE FROM student"}}))
+	_, err = tx.Recv()
+	assert.Nil(t, err)
+	assert.Nil(t, tx.CloseSend())
+	_, err = tx.Recv()
+	assert.Equal(t, codes.Aborted, status.Code(err))
+
+	connections := executor.connections()
+	assert.Len(t, connections, 2)
+	var statements [][]string
+	for _, id := range executor.closed {
+		statements = append(statements, connections[id])
+	}
+	assert.Equal(t, [][]string{
+		{"START TRANSACTION", "UPDATE student SET age = 1", "SELECT id, name FROM student", "COMMIT"},
+		{"START TRANSACTION", "DELETE FROM student"},
+	}, statements)
+}
@@ -71,9 +71,9 @@ func NewHttpListener(conf *config.Listener) (proto.Listener, error) {
 		return nil, err
 	}
 
-	l, err := net.Listen("tcp", fmt.Sprintf("%s:%d", conf.SocketAddress.Address, conf.SocketAddress.Port))
+	l, err := net.Listen("tcp", conf.SocketAddress.String())
 	if err != nil {
-		log.Errorf("listen %s:%d error, %s", conf.SocketAddress.Address, conf.SocketAddress.Port, err)
+		log.Errorf("listen %s error, %s", conf.SocketAddress.String(), err)
 		return nil, err
 	}
 
@@ -0,0 +1,102 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/events"
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+// EventIdleInTransactionTerminated is the kind of the events of the client sessions terminated for
+// being idle in a transaction longer than the idle in transaction timeout.
+const EventIdleInTransactionTerminated = "idle_in_transaction_terminated"
+
+var idleInTransactionTerminated = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "dbpack",
+	Subsystem: "listener",
+	Name:      "idle_in_transaction_terminated_total",
+	Help:      "count of the client sessions terminated for being idle in a transaction longer than the idle in transaction timeout",
+}, []string{"listener"})
+
+func init() {
+	prometheus.MustRegister(idleInTransactionTerminated)
+}
+
+// watchIdleInTransaction bounds the wait for the next command of a client session in a transaction
+// by the idle in transaction timeout, and clears the bound once the transaction is over. watching
+// tells whether the wait was bounded for the previous command, the result whether it is bounded now.
+func (l *MysqlListener) watchIdleInTransaction(conn net.Conn, connectionID uint32, watching bool) (bool, error) {
+	if l.conf.IdleInTransactionTimeout <= 0 {
+		return false, nil
+	}
+	executor := l.connectionExecutor(connectionID)
+	if executor != nil && executor.InLocalTransaction(proto.WithConnectionID(context.Background(), connectionID)) {
+		return true, conn.SetReadDeadline(time.Now().Add(l.conf.IdleInTransactionTimeout))
+	}
+	if watching {
+		return false, conn.SetReadDeadline(time.Time{})
+	}
+	return false, nil
+}
+
+// terminateIdleInTransaction tells the client of a session idle in a transaction for too long that
+// it is disconnected, the transaction is rolled back and its backend connections released once the
+// connection is closed.
+func (l *MysqlListener) terminateIdleInTransaction(c *mysql.Conn) {
+	var dataSources []string
+	if executor, ok := l.connectionExecutor(c.ID()).(proto.PinnedConnectionsExecutor); ok {
+		for _, connection := range executor.PinnedConnections(c.ID()) {
+			dataSources = append(dataSources, connection.DataSource)
+		}
+	}
+	event := &events.Event{
+		Kind:          EventIdleInTransactionTerminated,
+		ApplicationID: l.appID,
+		Message: fmt.Sprintf("session %d of %s@%s terminated, idle in a transaction for %s holding the connections of [%s]",
+			c.ID(), c.UserName(), c.RemoteAddr(), l.conf.IdleInTransactionTimeout, strings.Join(dataSources, ", ")),
+	}
+	if len(dataSources) == 1 {
+		event.DataSource = dataSources[0]
+	}
+	log.Warn(event.Message)
+	events.Record(event)
+	idleInTransactionTerminated.WithLabelValues(l.listeners[0].Addr().String()).Inc()
+
+	// the client reads the error with the response of its next command
+	if err := c.WriteErrorPacket(constant.ERClientInteractionTimeout, constant.SSUnknownSQLState,
+		"The client was disconnected by the server because of inactivity in a transaction."); err != nil {
+		log.Warnf("Cannot write error packet to %s: %v", c, err)
+	}
+}
+
+// isTimeout reports whether err is a read timing out.
+func isTimeout(err error) bool {
+	netErr, ok := errors.Cause(err).(net.Error)
+	return ok && netErr.Timeout()
+}
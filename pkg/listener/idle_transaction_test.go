@@ -0,0 +1,109 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/events"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+type idleTestExecutor struct {
+	proto.Executor
+	inTransaction bool
+}
+
+func (executor *idleTestExecutor) InLocalTransaction(ctx context.Context) bool {
+	return executor.inTransaction
+}
+
+func (executor *idleTestExecutor) PinnedConnections(connectionID uint32) []*proto.PinnedConnection {
+	return []*proto.PinnedConnection{{DataSource: "employees", Reasons: []string{proto.PinReasonTransaction}}}
+}
+
+func TestIdleInTransaction(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	executor := &idleTestExecutor{}
+	l := &MysqlListener{
+		conf:                MysqlConfig{IdleInTransactionTimeout: 50 * time.Millisecond},
+		listeners:           []net.Listener{ln},
+		appID:               "svc",
+		listenerExecutors:   listenerExecutors{executor: executor},
+		connectionExecutors: &sync.Map{},
+	}
+	server, client := net.Pipe()
+	defer client.Close()
+	c := mysql.NewConn(server)
+	c.SetConnectionID(1)
+	c.SetUserName("dksl")
+
+	// outside a transaction the wait for the next command is not bounded
+	watching, err := l.watchIdleInTransaction(server, 1, false)
+	assert.NoError(t, err)
+	assert.False(t, watching)
+
+	executor.inTransaction = true
+	watching, err = l.watchIdleInTransaction(server, 1, watching)
+	assert.NoError(t, err)
+	assert.True(t, watching)
+	_, err = c.ReadEphemeralPacket()
+	assert.True(t, isTimeout(err))
+	c.DiscardReadPacket()
+
+	received := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(client)
+		received <- b
+	}()
+	terminated := testutil.ToFloat64(idleInTransactionTerminated.WithLabelValues(ln.Addr().String()))
+	l.terminateIdleInTransaction(c)
+	server.Close()
+	// the error packet carries ER_CLIENT_INTERACTION_TIMEOUT, 4031
+	assert.Equal(t, []byte{0xff, 0xbf, 0x0f}, (<-received)[4:7])
+	assert.Equal(t, terminated+1, testutil.ToFloat64(idleInTransactionTerminated.WithLabelValues(ln.Addr().String())))
+
+	recorded := events.List(0)
+	event := recorded[len(recorded)-1]
+	assert.Equal(t, EventIdleInTransactionTerminated, event.Kind)
+	assert.Equal(t, "svc", event.ApplicationID)
+	assert.Equal(t, "employees", event.DataSource)
+
+	// the bound is cleared once the transaction is over
+	server, client = net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	assert.NoError(t, server.SetReadDeadline(time.Now()))
+	executor.inTransaction = false
+	watching, err = l.watchIdleInTransaction(server, 1, true)
+	assert.NoError(t, err)
+	assert.False(t, watching)
+	go client.Write([]byte{1})
+	_, err = server.Read(make([]byte, 1))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,153 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	DefaultMaxConcurrentHandshakes = 64
+	DefaultHandshakeTimeout        = 10 * time.Second
+
+	// the stages of the connection goroutines
+	stageHandshakeWaiting = "handshake_waiting"
+	stageHandshake        = "handshake"
+	stageCommand          = "command"
+)
+
+var (
+	listenerGoroutines = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dbpack",
+		Subsystem: "listener",
+		Name:      "goroutines",
+		Help:      "connection goroutine count per stage, waiting for a handshake slot, handshaking or serving commands",
+	}, []string{"listener", "stage"})
+	listenerAcceptWaiting = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dbpack",
+		Subsystem: "listener",
+		Name:      "accept_waiting",
+		Help:      "count of the accept loops which stopped accepting connections since max_connections is reached",
+	}, []string{"listener"})
+	listenerRejectedConnections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "dbpack",
+		Subsystem: "listener",
+		Name:      "rejected_connections",
+		Help:      "count of the connections closed since no handshake slot was free within the handshake timeout",
+	}, []string{"listener"})
+)
+
+func init() {
+	prometheus.MustRegister(listenerGoroutines, listenerAcceptWaiting, listenerRejectedConnections)
+}
+
+// connectionLimiter caps the client connections and the handshakes in progress of a listener.
+// Once max connections are open the listener stops accepting, so that further connections
+// wait in the accept queue of the socket rather than each holding a goroutine and buffers.
+type connectionLimiter struct {
+	listener string
+	// connections and handshakes are semaphores, connections is nil if not capped
+	connections      chan struct{}
+	handshakes       chan struct{}
+	handshakeTimeout time.Duration
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newConnectionLimiter(listener string, conf MysqlConfig) *connectionLimiter {
+	limiter := &connectionLimiter{
+		listener:         listener,
+		handshakes:       make(chan struct{}, conf.MaxConcurrentHandshakes),
+		handshakeTimeout: conf.HandshakeTimeout,
+		done:             make(chan struct{}),
+	}
+	if conf.MaxConnections > 0 {
+		limiter.connections = make(chan struct{}, conf.MaxConnections)
+	}
+	return limiter
+}
+
+// acquireConnection blocks until a connection slot is free, it returns false if the limiter is closed.
+func (limiter *connectionLimiter) acquireConnection() bool {
+	if limiter.connections == nil {
+		return true
+	}
+	select {
+	case limiter.connections <- struct{}{}:
+		return true
+	default:
+	}
+
+	waiting := listenerAcceptWaiting.WithLabelValues(limiter.listener)
+	waiting.Inc()
+	defer waiting.Dec()
+	select {
+	case limiter.connections <- struct{}{}:
+		return true
+	case <-limiter.done:
+		return false
+	}
+}
+
+func (limiter *connectionLimiter) releaseConnection() {
+	if limiter.connections != nil {
+		<-limiter.connections
+	}
+}
+
+// acquireHandshake waits for a handshake slot within the handshake timeout.
+func (limiter *connectionLimiter) acquireHandshake() bool {
+	defer limiter.enter(stageHandshakeWaiting)()
+
+	select {
+	case limiter.handshakes <- struct{}{}:
+		return true
+	default:
+	}
+
+	timer := time.NewTimer(limiter.handshakeTimeout)
+	defer timer.Stop()
+	select {
+	case limiter.handshakes <- struct{}{}:
+		return true
+	case <-timer.C:
+	case <-limiter.done:
+	}
+	listenerRejectedConnections.WithLabelValues(limiter.listener).Inc()
+	return false
+}
+
+func (limiter *connectionLimiter) releaseHandshake() {
+	<-limiter.handshakes
+}
+
+// enter counts a connection goroutine in stage until the returned function is called.
+func (limiter *connectionLimiter) enter(stage string) (leave func()) {
+	gauge := listenerGoroutines.WithLabelValues(limiter.listener, stage)
+	gauge.Inc()
+	return gauge.Dec
+}
+
+func (limiter *connectionLimiter) close() {
+	limiter.closeOnce.Do(func() {
+		close(limiter.done)
+	})
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/config"
+)
+
+func TestConnectionLimiter(t *testing.T) {
+	limiter := newConnectionLimiter("limiter-test", MysqlConfig{
+		MaxConnections:          1,
+		MaxConcurrentHandshakes: 1,
+		HandshakeTimeout:        50 * time.Millisecond,
+	})
+
+	assert.True(t, limiter.acquireConnection())
+	acquired := make(chan bool)
+	go func() {
+		acquired <- limiter.acquireConnection()
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired a connection slot beyond max connections")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Equal(t, float64(1), testutil.ToFloat64(listenerAcceptWaiting.WithLabelValues("limiter-test")))
+	limiter.releaseConnection()
+	assert.True(t, <-acquired)
+
+	assert.True(t, limiter.acquireHandshake())
+	start := time.Now()
+	assert.False(t, limiter.acquireHandshake())
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	assert.Equal(t, float64(1), testutil.ToFloat64(listenerRejectedConnections.WithLabelValues("limiter-test")))
+	limiter.releaseHandshake()
+	assert.True(t, limiter.acquireHandshake())
+	limiter.releaseHandshake()
+
+	leave := limiter.enter(stageCommand)
+	assert.Equal(t, float64(1), testutil.ToFloat64(listenerGoroutines.WithLabelValues("limiter-test", stageCommand)))
+	leave()
+	assert.Equal(t, float64(0), testutil.ToFloat64(listenerGoroutines.WithLabelValues("limiter-test", stageCommand)))
+
+	go func() {
+		acquired <- limiter.acquireConnection()
+	}()
+	limiter.close()
+	assert.False(t, <-acquired)
+}
+
+func TestConnectionLimitsConfig(t *testing.T) {
+	testCases := []struct {
+		name             string
+		config           map[string]interface{}
+		handshakes       int
+		handshakeTimeout time.Duration
+		acceptors        int
+		hasErr           bool
+	}{
+		{
+			name:             "defaults",
+			config:           map[string]interface{}{},
+			handshakes:       DefaultMaxConcurrentHandshakes,
+			handshakeTimeout: DefaultHandshakeTimeout,
+			acceptors:        1,
+		},
+		{
+			name: "configured",
+			config: map[string]interface{}{
+				"max_connections":           100,
+				"max_concurrent_handshakes": 8,
+				"handshake_timeout":         "3s",
+				"acceptors":                 2,
+			},
+			handshakes:       8,
+			handshakeTimeout: 3 * time.Second,
+			acceptors:        2,
+		},
+		{
+			name:   "negative max connections",
+			config: map[string]interface{}{"max_connections": -1},
+			hasErr: true,
+		},
+		{
+			name:   "negative acceptors",
+			config: map[string]interface{}{"acceptors": -1},
+			hasErr: true,
+		},
+		{
+			name:   "invalid handshake timeout",
+			config: map[string]interface{}{"handshake_timeout": "3"},
+			hasErr: true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			c.config["server_version"] = "8.0.27"
+			l, err := NewMysqlListener(&config.Listener{
+				ProtocolType:  config.Mysql,
+				SocketAddress: config.SocketAddress{Address: "127.0.0.1"},
+				Config:        c.config,
+			})
+			if c.hasErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			defer l.Close()
+			listener := l.(*MysqlListener)
+			assert.Equal(t, c.handshakes, cap(listener.limiter.handshakes))
+			assert.Equal(t, c.handshakeTimeout, listener.limiter.handshakeTimeout)
+			if reusePortSupported {
+				assert.Len(t, listener.listeners, c.acceptors)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+)
+
+const (
+	// defaultMaxAllowedPacket is the default max_allowed_packet of mysql 8.0.
+	defaultMaxAllowedPacket = 64 << 20 // 64 MiB
+
+	maxAllowedPacketVariable = "max_allowed_packet"
+)
+
+// advertiseMaxAllowedPacket replaces the max_allowed_packet of the backend in the result of
+// SELECT @@max_allowed_packet and SHOW VARIABLES with the one enforced by the listener, so
+// that clients do not send packets larger than the listener accepts.
+func advertiseMaxAllowedPacket(stmt ast.StmtNode, result *mysql.Result, maxAllowedPacket int) error {
+	var replace func(values []*proto.Value)
+	value := []byte(strconv.Itoa(maxAllowedPacket))
+	switch stmt := stmt.(type) {
+	case *ast.SelectStmt:
+		if stmt.Fields == nil || len(stmt.Fields.Fields) != len(result.Fields) {
+			return nil
+		}
+		var columns []int
+		for i, field := range stmt.Fields.Fields {
+			if variable, ok := field.Expr.(*ast.VariableExpr); ok && variable.IsSystem &&
+				strings.EqualFold(variable.Name, maxAllowedPacketVariable) {
+				columns = append(columns, i)
+			}
+		}
+		if len(columns) == 0 {
+			return nil
+		}
+		replace = func(values []*proto.Value) {
+			for _, i := range columns {
+				if i < len(values) && values[i] != nil {
+					values[i].Val, values[i].Raw = value, value
+				}
+			}
+		}
+	case *ast.ShowStmt:
+		if stmt.Tp != ast.ShowVariables || len(result.Fields) != 2 {
+			return nil
+		}
+		replace = func(values []*proto.Value) {
+			if len(values) != 2 || values[0] == nil || values[1] == nil {
+				return
+			}
+			if name, ok := values[0].Val.([]byte); ok && strings.EqualFold(string(name), maxAllowedPacketVariable) {
+				values[1].Val, values[1].Raw = value, value
+			}
+		}
+	default:
+		return nil
+	}
+	for _, row := range result.Rows {
+		textRow, ok := row.(*mysql.TextRow)
+		if !ok {
+			continue
+		}
+		values, err := textRow.Decode()
+		if err != nil {
+			return err
+		}
+		replace(values)
+	}
+	return nil
+}
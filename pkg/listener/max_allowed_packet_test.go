@@ -0,0 +1,131 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/third_party/parser"
+	_ "github.com/cectc/dbpack/third_party/types/parser_driver"
+)
+
+func TestAdvertiseMaxAllowedPacket(t *testing.T) {
+	newResult := func(columns []string, rows ...[]string) *mysql.Result {
+		fields := make([]*mysql.Field, 0, len(columns))
+		for _, column := range columns {
+			fields = append(fields, &mysql.Field{Name: column, FieldType: constant.FieldTypeVarString})
+		}
+		result := &mysql.Result{Fields: fields}
+		for _, row := range rows {
+			values := make([]*proto.Value, 0, len(row))
+			for _, value := range row {
+				values = append(values, &proto.Value{Typ: constant.FieldTypeVarString, Val: []byte(value), Raw: []byte(value)})
+			}
+			result.Rows = append(result.Rows, mysql.NewTextRow(fields, values))
+		}
+		return result
+	}
+
+	testCases := []struct {
+		sql      string
+		result   *mysql.Result
+		expected [][]string
+	}{
+		{
+			sql:      "select @@max_allowed_packet",
+			result:   newResult([]string{"@@max_allowed_packet"}, []string{"4194304"}),
+			expected: [][]string{{"1024"}},
+		},
+		{
+			sql:      "select @@session.auto_increment_increment, @@session.max_allowed_packet",
+			result:   newResult([]string{"auto_increment_increment", "max_allowed_packet"}, []string{"1", "4194304"}),
+			expected: [][]string{{"1", "1024"}},
+		},
+		{
+			sql: "show variables like 'max%'",
+			result: newResult([]string{"Variable_name", "Value"},
+				[]string{"max_allowed_packet", "4194304"}, []string{"max_connections", "151"}),
+			expected: [][]string{{"max_allowed_packet", "1024"}, {"max_connections", "151"}},
+		},
+		{
+			sql:      "select max_allowed_packet from t",
+			result:   newResult([]string{"max_allowed_packet"}, []string{"4194304"}),
+			expected: [][]string{{"4194304"}},
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.sql, func(t *testing.T) {
+			stmt, err := parser.New().ParseOneStmt(c.sql, "", "")
+			assert.NoError(t, err)
+			assert.NoError(t, advertiseMaxAllowedPacket(stmt, c.result, 1024))
+			for i, row := range c.result.Rows {
+				values, err := row.Decode()
+				assert.NoError(t, err)
+				for j, value := range values {
+					assert.Equal(t, c.expected[i][j], string(value.Val.([]byte)))
+					assert.Equal(t, c.expected[i][j], string(value.Raw))
+				}
+			}
+		})
+	}
+}
+
+func TestSendLongDataKeepsChunks(t *testing.T) {
+	executor := &schemaTestExecutor{}
+	l := &MysqlListener{listenerExecutors: listenerExecutors{executor: executor}, connectionExecutors: &sync.Map{}, stmts: &sync.Map{}}
+	stmt := &proto.Stmt{StatementID: 1, BindVars: make(map[string]interface{})}
+	l.stmts.Store(uint32(1), stmt)
+
+	server, client := net.Pipe()
+	defer client.Close()
+	conn := mysql.NewConn(server)
+	defer conn.Close()
+	conn.SetConnectionID(1)
+
+	longData := func(chunk byte) []byte {
+		return append([]byte{constant.ComStmtSendLongData, 1, 0, 0, 0, 0, 0}, bytes.Repeat([]byte{chunk}, 100)...)
+	}
+	go func() {
+		clientConn := mysql.NewConn(client)
+		_ = clientConn.WritePacket(longData('a'))
+		_ = clientConn.WritePacket(longData('b'))
+		// the packet read after the long data reuses the buffer of its packet
+		_ = clientConn.WritePacket(bytes.Repeat([]byte{constant.ComPing}, 107))
+	}()
+	ctx := proto.WithConnectionID(context.Background(), 1)
+	for i := 0; i < 2; i++ {
+		data, err := conn.ReadEphemeralPacket()
+		assert.NoError(t, err)
+		assert.NoError(t, l.ExecuteCommand(ctx, conn, data))
+	}
+	data, err := conn.ReadEphemeralPacket()
+	assert.NoError(t, err)
+	assert.Equal(t, byte(constant.ComPing), data[0])
+	conn.RecycleReadPacket()
+
+	assert.True(t, stmt.HasLongDataParam)
+	assert.Equal(t, append(bytes.Repeat([]byte{'a'}, 100), bytes.Repeat([]byte{'b'}, 100)...), stmt.BindVars["v1"])
+}
@@ -0,0 +1,550 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/misc"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/packet"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/visitor"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+)
+
+const (
+	// SessionImportPath is the http path the peers import the migrated sessions on.
+	SessionImportPath = "/sessions/import"
+
+	// SessionTokenAttribute is the system variable the token of a session is handed to its client in,
+	// in the session state of the OK packet of the handshake, and the connection attribute the client
+	// presents the token in when it reconnects, to get its session back.
+	SessionTokenAttribute = "dbpack_session_token"
+
+	DefaultSessionMigrationExpiration = time.Minute
+
+	sessionMigrationPushTimeout = 5 * time.Second
+
+	sessionExported = "exported"
+	sessionImported = "imported"
+	sessionRestored = "restored"
+)
+
+var sessionMigrations = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "dbpack",
+	Subsystem: "listener",
+	Name:      "session_migrations_total",
+	Help:      "count of the client sessions exported to a peer, imported from a peer and restored on a reconnected client connection",
+}, []string{"listener", "stage"})
+
+func init() {
+	prometheus.MustRegister(sessionMigrations)
+}
+
+var (
+	// ErrSessionImportUnauthorized is returned when a session import does not carry the secret of
+	// the listener.
+	ErrSessionImportUnauthorized = errors.New("session import is not authorized")
+	// ErrSessionListenerNotFound is returned when no listener of the socket address migrates sessions.
+	ErrSessionListenerNotFound = errors.New("listener does not migrate sessions")
+)
+
+// migrationListeners maps the socket addresses of the mysql listeners migrating sessions to them.
+var migrationListeners sync.Map
+
+// SessionMigrationConfig migrates the sessions of the idle client connections to a peer instance,
+// such as when the instance is stopped for a rolling upgrade behind a L4 load balancer. The client
+// connections are closed once their sessions are exported, the sessions are restored when the
+// clients reconnect to the peer presenting the token of their session, which is handed to the
+// clients tracking the session state when they connect. The sessions of the other clients are
+// not migrated.
+type SessionMigrationConfig struct {
+	// Peers are the http addresses of the instances the sessions are migrated to, such as
+	// 10.0.0.2:18888, the first one accepting the sessions gets them. The peers must configure
+	// session migration on a listener of the same socket address.
+	Peers []string `yaml:"peers" json:"peers"`
+	// Secret authenticates the sessions pushed to the peers, the peers must configure the same one
+	Secret string `yaml:"secret" json:"-"`
+	// Expiration is how long an imported session waits for its client to reconnect, 1m by default
+	Expiration    time.Duration `yaml:"-" json:"-"`
+	ExpirationStr string        `yaml:"expiration" json:"expiration"`
+}
+
+// SessionState is the state of a client session, which is restored on the connection the client
+// opens to a peer instance.
+type SessionState struct {
+	// Token is the token handed to the client, which it presents to get the session back
+	Token string `json:"token"`
+	User  string `json:"user"`
+	// Host is the client host, without the port, it is only logged
+	Host   string `json:"host"`
+	Schema string `json:"schema"`
+	// Variables are the SET statements run by the session, in order, a peer rejects any other statement
+	Variables []string `json:"variables"`
+	// Statements are the prepared statements of the session
+	Statements []*PreparedStatement `json:"statements"`
+}
+
+// PreparedStatement is a prepared statement of a session, its id is kept by the peer if it is not used.
+type PreparedStatement struct {
+	ID  uint32 `json:"id"`
+	SQL string `json:"sql"`
+}
+
+// SessionImport is the request of a peer importing the sessions of a listener.
+type SessionImport struct {
+	// Listener is the socket address of the listener the sessions are migrated from
+	Listener string          `json:"listener"`
+	Sessions []*SessionState `json:"sessions"`
+}
+
+// sessionMigration tracks the sessions of the client connections of a listener, and the sessions
+// imported from the peers waiting for their clients to reconnect.
+type sessionMigration struct {
+	listener   string
+	peers      []string
+	secret     string
+	expiration time.Duration
+	client     *http.Client
+
+	// sessions maps connection ids to their tracked sessions.
+	sessions sync.Map
+	// tokens maps the ids of the connections handshaking to the tokens handed to their clients, until
+	// their sessions are tracked, and reclaims to the tokens their clients present.
+	tokens   sync.Map
+	reclaims sync.Map
+
+	mu sync.Mutex
+	// pending maps the tokens of the imported sessions to them.
+	pending map[string]*pendingSession
+}
+
+type pendingSession struct {
+	state    *SessionState
+	deadline time.Time
+}
+
+// trackedSession is the session of a client connection, it is locked while the connection runs
+// a command, so that it is not migrated in the middle of one.
+type trackedSession struct {
+	mu        sync.Mutex
+	conn      net.Conn
+	c         *mysql.Conn
+	token     string
+	variables []string
+	// statements are the ids of the statements prepared by the session
+	statements map[uint32]struct{}
+	migrated   bool
+}
+
+func newSessionMigration(listener string, conf *SessionMigrationConfig) (*sessionMigration, error) {
+	if len(conf.Peers) == 0 {
+		return nil, errors.New("session_migration should have at least one peer")
+	}
+	if conf.Secret == "" {
+		return nil, errors.New("session_migration should have a secret")
+	}
+	expiration := DefaultSessionMigrationExpiration
+	if conf.ExpirationStr != "" {
+		var err error
+		if expiration, err = time.ParseDuration(conf.ExpirationStr); err != nil {
+			return nil, errors.Wrap(err, "parse session_migration expiration failed")
+		}
+		if expiration <= 0 {
+			return nil, errors.Errorf("session_migration expiration must be positive, got %s", conf.ExpirationStr)
+		}
+	}
+	conf.Expiration = expiration
+	return &sessionMigration{
+		listener:   listener,
+		peers:      conf.Peers,
+		secret:     conf.Secret,
+		expiration: expiration,
+		client:     &http.Client{Timeout: sessionMigrationPushTimeout},
+		pending:    make(map[string]*pendingSession),
+	}, nil
+}
+
+// MigrateSessions exports the sessions of the idle client connections of the listeners migrating
+// sessions to their peers, and closes the connections, it returns the number of sessions migrated
+// of each listener.
+func MigrateSessions(ctx context.Context) (map[string]int, error) {
+	migrated := make(map[string]int)
+	var err error
+	migrationListeners.Range(func(key, value interface{}) bool {
+		var n int
+		n, err = value.(*MysqlListener).migrateSessions(ctx)
+		migrated[key.(string)] = n
+		return err == nil
+	})
+	return migrated, err
+}
+
+// ImportSessions imports the sessions migrated from the listener of a peer to the listener of the
+// same socket address, secret must be the secret of the listener.
+func ImportSessions(request *SessionImport, secret string) error {
+	value, ok := migrationListeners.Load(request.Listener)
+	if !ok {
+		return errors.Wrapf(ErrSessionListenerNotFound, "listener %s", request.Listener)
+	}
+	l := value.(*MysqlListener)
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(l.migration.secret)) != 1 {
+		return ErrSessionImportUnauthorized
+	}
+	return l.importSessions(request.Sessions)
+}
+
+// migrateSessions pushes the sessions of the idle connections not in a transaction to a peer,
+// then closes the connections, the connections running a command are left for the drain.
+func (l *MysqlListener) migrateSessions(ctx context.Context) (int, error) {
+	m := l.migration
+	var (
+		states   []*SessionState
+		sessions []*trackedSession
+	)
+	m.sessions.Range(func(key, value interface{}) bool {
+		s := value.(*trackedSession)
+		if !s.mu.TryLock() {
+			return true
+		}
+		connectionID := key.(uint32)
+		executor := l.connectionExecutor(connectionID)
+		idCtx := proto.WithConnectionID(context.Background(), connectionID)
+		// a client without the token of its session could not get it back
+		if s.migrated || s.token == "" || executor.InLocalTransaction(idCtx) || executor.InGlobalTransaction(idCtx) {
+			s.mu.Unlock()
+			return true
+		}
+		states = append(states, l.sessionState(s))
+		sessions = append(sessions, s)
+		return true
+	})
+	if len(sessions) == 0 {
+		return 0, nil
+	}
+
+	err := m.push(ctx, states)
+	for _, s := range sessions {
+		if err == nil {
+			s.migrated = true
+			if closeErr := s.conn.Close(); closeErr != nil {
+				log.Warnf("close migrated connection %d error: %v", s.c.ID(), closeErr)
+			}
+		}
+		s.mu.Unlock()
+	}
+	if err != nil {
+		return 0, err
+	}
+	sessionMigrations.WithLabelValues(m.listener, sessionExported).Add(float64(len(sessions)))
+	log.Infof("listener %s migrated %d sessions", m.listener, len(sessions))
+	return len(sessions), nil
+}
+
+// sessionState returns the state of a tracked session.
+func (l *MysqlListener) sessionState(s *trackedSession) *SessionState {
+	host, _, err := net.SplitHostPort(s.c.RemoteAddr().String())
+	if err != nil {
+		host = s.c.RemoteAddr().String()
+	}
+	state := &SessionState{
+		Token:     s.token,
+		User:      s.c.UserName(),
+		Host:      host,
+		Schema:    s.c.Schema(),
+		Variables: append([]string(nil), s.variables...),
+	}
+	for id := range s.statements {
+		if stmt, ok := l.stmts.Load(id); ok {
+			state.Statements = append(state.Statements, &PreparedStatement{ID: id, SQL: stmt.(*proto.Stmt).SqlText})
+		}
+	}
+	return state
+}
+
+// push sends the sessions to the first peer accepting them.
+func (m *sessionMigration) push(ctx context.Context, states []*SessionState) error {
+	body, err := json.Marshal(&SessionImport{Listener: m.listener, Sessions: states})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, peer := range m.peers {
+		if err = m.pushTo(ctx, peer, body); err == nil {
+			return nil
+		}
+		log.Warnf("listener %s failed to migrate sessions to peer %s: %v", m.listener, peer, err)
+	}
+	return errors.Wrap(err, "no peer accepted the sessions")
+}
+
+func (m *sessionMigration) pushTo(ctx context.Context, peer string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf("http://%s%s", peer, SessionImportPath), bytes.NewReader(body))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+m.secret)
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// importSessions keeps the sessions until their clients reconnect, their prepared statements are
+// prepared right away under the same ids, the statements whose ids are used are dropped. The sessions
+// are rejected if any has no token or a variable statement that is not a SET, as they are run on
+// the connection of the client.
+func (l *MysqlListener) importSessions(states []*SessionState) error {
+	for _, state := range states {
+		if err := l.checkSessionState(state); err != nil {
+			return err
+		}
+	}
+	m := l.migration
+	now := time.Now()
+	deadline := now.Add(m.expiration)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for token, pending := range m.pending {
+		if now.After(pending.deadline) {
+			delete(m.pending, token)
+		}
+	}
+	for _, state := range states {
+		for _, prepared := range state.Statements {
+			if err := l.importStatement(prepared); err != nil {
+				log.Warnf("listener %s dropped the prepared statement %d of the session of %s@%s: %v",
+					m.listener, prepared.ID, state.User, state.Host, err)
+			}
+		}
+		m.pending[state.Token] = &pendingSession{state: state, deadline: deadline}
+	}
+	sessionMigrations.WithLabelValues(m.listener, sessionImported).Add(float64(len(states)))
+	return nil
+}
+
+func (l *MysqlListener) checkSessionState(state *SessionState) error {
+	if state.Token == "" {
+		return errors.Errorf("the session of %s@%s has no token", state.User, state.Host)
+	}
+	for _, sql := range state.Variables {
+		stmt, err := l.parse(sql)
+		if err != nil {
+			return errors.Wrapf(err, "the session of %s@%s has an invalid variable statement", state.User, state.Host)
+		}
+		if _, ok := stmt.(*ast.SetStmt); !ok {
+			return errors.Errorf("the session of %s@%s has a variable statement which is not a SET: %s",
+				state.User, state.Host, sql)
+		}
+	}
+	return nil
+}
+
+func (l *MysqlListener) importStatement(prepared *PreparedStatement) error {
+	act, err := l.parse(prepared.SQL)
+	if err != nil {
+		return err
+	}
+	act.Accept(&visitor.ParamVisitor{})
+	stmt := &proto.Stmt{
+		StatementID: prepared.ID,
+		SqlText:     prepared.SQL,
+		StmtNode:    act,
+	}
+	if paramsCount := uint16(strings.Count(prepared.SQL, "?")); paramsCount > 0 {
+		stmt.ParamsCount = paramsCount
+		stmt.ParamsType = make([]int32, paramsCount)
+		stmt.BindVars = make(map[string]interface{}, paramsCount)
+	}
+	if _, loaded := l.stmts.LoadOrStore(stmt.StatementID, stmt); loaded {
+		return errors.New("the statement id is used")
+	}
+	// the statements prepared later do not take the imported ids
+	for {
+		current := l.statementID.Load()
+		if current >= prepared.ID || l.statementID.CAS(current, prepared.ID) {
+			return nil
+		}
+	}
+}
+
+// take removes the session imported with token, nil if there is none, it has expired or it is the
+// session of another user.
+func (m *sessionMigration) take(token, user string) *SessionState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pending, ok := m.pending[token]
+	if !ok {
+		return nil
+	}
+	delete(m.pending, token)
+	if time.Now().After(pending.deadline) || pending.state.User != user {
+		return nil
+	}
+	return pending.state
+}
+
+// issueToken makes the token of the session of a connection, it returns the session state handing
+// it to the client in the OK packet of the handshake, nil if the client does not track the session
+// state, so its session is not migrated.
+func (m *sessionMigration) issueToken(connectionID uint32, capabilities uint32) []byte {
+	if capabilities&constant.CapabilityClientSessionTrack == 0 {
+		return nil
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Warnf("conn %d: failed to make the token of the session: %v", connectionID, err)
+		return nil
+	}
+	token := hex.EncodeToString(b)
+	m.tokens.Store(connectionID, token)
+	data := misc.AppendLengthEncodedInteger(nil, uint64(len(SessionTokenAttribute)))
+	data = append(data, SessionTokenAttribute...)
+	data = misc.AppendLengthEncodedInteger(data, uint64(len(token)))
+	data = append(data, token...)
+	return packet.BuildSessionStateChanges(&packet.SessionStateChange{
+		Type: constant.SessionTrackSystemVariables,
+		Data: data,
+	})
+}
+
+// reclaim keeps the token the client of a connection presents in its connection attributes.
+func (m *sessionMigration) reclaim(connectionID uint32, attrs map[string]string) {
+	if token, ok := attrs[SessionTokenAttribute]; ok && token != "" {
+		m.reclaims.Store(connectionID, token)
+	}
+}
+
+// track starts tracking the session of a client connection.
+func (m *sessionMigration) track(conn net.Conn, c *mysql.Conn) *trackedSession {
+	s := &trackedSession{conn: conn, c: c, statements: make(map[uint32]struct{})}
+	if token, ok := m.tokens.LoadAndDelete(c.ID()); ok {
+		s.token = token.(string)
+	}
+	m.sessions.Store(c.ID(), s)
+	return s
+}
+
+func (m *sessionMigration) untrack(connectionID uint32) {
+	m.sessions.Delete(connectionID)
+	m.tokens.Delete(connectionID)
+	m.reclaims.Delete(connectionID)
+}
+
+// session returns the tracked session of a connection, nil if the sessions are not migrated.
+func (l *MysqlListener) session(connectionID uint32) *trackedSession {
+	if l.migration == nil {
+		return nil
+	}
+	if s, ok := l.migration.sessions.Load(connectionID); ok {
+		return s.(*trackedSession)
+	}
+	return nil
+}
+
+// restoreSession restores the session imported with the token the client of c presents, if there
+// is one, the connection goes on with a new session if it fails.
+func (l *MysqlListener) restoreSession(ctx context.Context, c *mysql.Conn) {
+	token, ok := l.migration.reclaims.LoadAndDelete(c.ID())
+	if !ok {
+		return
+	}
+	state := l.migration.take(token.(string), c.UserName())
+	if state == nil {
+		return
+	}
+	if err := l.restoreState(ctx, c, state); err != nil {
+		log.Warnf("conn %d: failed to restore the session migrated from a peer: %v", c.ID(), err)
+		return
+	}
+	if s := l.session(c.ID()); s != nil {
+		for _, prepared := range state.Statements {
+			s.statements[prepared.ID] = struct{}{}
+		}
+	}
+	sessionMigrations.WithLabelValues(l.migration.listener, sessionRestored).Inc()
+}
+
+func (l *MysqlListener) restoreState(ctx context.Context, c *mysql.Conn, state *SessionState) error {
+	if state.Schema != "" && state.Schema != c.Schema() {
+		executor, next := l.connectionExecutor(c.ID()), l.schemaExecutor(state.Schema)
+		if next == nil {
+			return noSchemaExecutorError(state.Schema)
+		}
+		if next != executor {
+			executor.ConnectionClose(ctx)
+			l.connectionExecutors.Store(c.ID(), next)
+		}
+		l.schemaName = state.Schema
+		c.SetSchema(state.Schema)
+		if err := next.ExecuteUseDB(ctx, state.Schema); err != nil {
+			return err
+		}
+	}
+	for _, sql := range state.Variables {
+		executor := l.connectionExecutor(c.ID())
+		q := l.parseQuery(ctx, c, executor, sql)
+		l.executeQuery(c, executor, q)
+		if q.span != nil {
+			q.span.End()
+		}
+		if rlt, ok := q.result.(*mysql.Result); ok {
+			rlt.Release()
+		}
+		if q.err != nil {
+			return q.err
+		}
+	}
+	return nil
+}
+
+// begin locks the session for a command, it returns false if the session is migrated.
+func (s *trackedSession) begin() bool {
+	s.mu.Lock()
+	if s.migrated {
+		s.mu.Unlock()
+		return false
+	}
+	return true
+}
+
+func (s *trackedSession) end() {
+	s.mu.Unlock()
+}
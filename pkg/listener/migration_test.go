@@ -0,0 +1,186 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/atomic"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+func newMigrationTestListener(t *testing.T, peer string, executor proto.Executor) *MysqlListener {
+	migration, err := newSessionMigration("0.0.0.0:13306", &SessionMigrationConfig{
+		Peers:  []string{peer},
+		Secret: "migration",
+	})
+	assert.NoError(t, err)
+	return &MysqlListener{
+		listenerExecutors:   listenerExecutors{executor: executor},
+		connectionExecutors: &sync.Map{},
+		statementID:         atomic.NewUint32(0),
+		stmts:               &sync.Map{},
+		migration:           migration,
+	}
+}
+
+func TestMigrateSessions(t *testing.T) {
+	executor := &schemaTestExecutor{}
+	var to *MysqlListener
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, SessionImportPath, r.URL.Path)
+		request := &SessionImport{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(request))
+		assert.Equal(t, "0.0.0.0:13306", request.Listener)
+		assert.Equal(t, "Bearer migration", r.Header.Get("Authorization"))
+		assert.NoError(t, to.importSessions(request.Sessions))
+	}))
+	defer peer.Close()
+	address := strings.TrimPrefix(peer.URL, "http://")
+	from := newMigrationTestListener(t, address, executor)
+	to = newMigrationTestListener(t, address, executor)
+	// the statement id is used on the peer
+	to.stmts.Store(uint32(3), &proto.Stmt{StatementID: 3})
+
+	server, client := net.Pipe()
+	defer client.Close()
+	c := mysql.NewConn(server)
+	c.SetConnectionID(1)
+	c.SetUserName("dksl")
+	c.SetSchema("employees")
+	assert.NotEmpty(t, from.migration.issueToken(c.ID(), constant.CapabilityClientSessionTrack))
+	s := from.migration.track(server, c)
+	assert.NotEmpty(t, s.token)
+	s.variables = append(s.variables, "SET NAMES utf8mb4")
+	for id, sql := range map[uint32]string{5: "SELECT * FROM employees WHERE emp_no = ?", 3: "SELECT 1"} {
+		from.stmts.Store(id, &proto.Stmt{StatementID: id, SqlText: sql})
+		s.statements[id] = struct{}{}
+	}
+
+	// the sessions in a transaction are not migrated
+	executor.inTransaction = true
+	migrated, err := from.migrateSessions(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, migrated)
+
+	executor.inTransaction = false
+	migrated, err = from.migrateSessions(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, migrated)
+	assert.False(t, s.begin())
+	_, err = client.Write([]byte{0})
+	assert.Error(t, err)
+
+	stmt, ok := to.stmts.Load(uint32(5))
+	assert.True(t, ok)
+	assert.Equal(t, uint16(1), stmt.(*proto.Stmt).ParamsCount)
+	assert.Equal(t, uint32(5), to.statementID.Load())
+	stmt, _ = to.stmts.Load(uint32(3))
+	assert.Empty(t, stmt.(*proto.Stmt).SqlText)
+
+	assert.Nil(t, to.migration.take("unknown", "dksl"))
+	state := to.migration.take(s.token, "dksl")
+	if assert.NotNil(t, state) {
+		assert.Equal(t, "employees", state.Schema)
+		assert.Equal(t, []string{"SET NAMES utf8mb4"}, state.Variables)
+		assert.Len(t, state.Statements, 2)
+	}
+	assert.Nil(t, to.migration.take(s.token, "dksl"))
+}
+
+func TestMigrateSessionsWithoutToken(t *testing.T) {
+	executor := &schemaTestExecutor{}
+	from := newMigrationTestListener(t, "127.0.0.1:18888", executor)
+	server, client := net.Pipe()
+	defer client.Close()
+	c := mysql.NewConn(server)
+	c.SetConnectionID(1)
+	// the client does not track the session state, it could not get its session back
+	assert.Nil(t, from.migration.issueToken(c.ID(), 0))
+	from.migration.track(server, c)
+	migrated, err := from.migrateSessions(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, migrated)
+}
+
+func TestImportSessions(t *testing.T) {
+	l := newMigrationTestListener(t, "127.0.0.1:18888", &schemaTestExecutor{})
+	migrationListeners.Store("0.0.0.0:13306", l)
+	defer migrationListeners.Delete("0.0.0.0:13306")
+
+	request := &SessionImport{
+		Listener: "0.0.0.0:13306",
+		Sessions: []*SessionState{{Token: "token", User: "dksl", Variables: []string{"SET NAMES utf8mb4"}}},
+	}
+	err := ImportSessions(request, "")
+	assert.Equal(t, ErrSessionImportUnauthorized, err)
+	err = ImportSessions(&SessionImport{Listener: "0.0.0.0:3306"}, "migration")
+	assert.Equal(t, ErrSessionListenerNotFound, errors.Cause(err))
+
+	// the variables are run on the connection of the client, only SET statements are accepted
+	for _, sql := range []string{"DROP TABLE employees", "SET NAMES utf8mb4; DROP TABLE employees", "SET NAMES"} {
+		err = ImportSessions(&SessionImport{
+			Listener: "0.0.0.0:13306",
+			Sessions: []*SessionState{{Token: "token", User: "dksl", Variables: []string{sql}}},
+		}, "migration")
+		assert.Error(t, err, sql)
+	}
+	err = ImportSessions(&SessionImport{
+		Listener: "0.0.0.0:13306",
+		Sessions: []*SessionState{{User: "dksl"}},
+	}, "migration")
+	assert.Error(t, err)
+	assert.Empty(t, l.migration.pending)
+
+	assert.NoError(t, ImportSessions(request, "migration"))
+	// the session is only given back to its user
+	assert.Nil(t, l.migration.take("token", "root"))
+	assert.Nil(t, l.migration.take("token", "dksl"))
+	assert.NoError(t, ImportSessions(request, "migration"))
+	assert.NotNil(t, l.migration.take("token", "dksl"))
+}
+
+func TestTakeExpiredSession(t *testing.T) {
+	migration, err := newSessionMigration("0.0.0.0:13306", &SessionMigrationConfig{
+		Peers:         []string{"127.0.0.1:18888"},
+		Secret:        "migration",
+		ExpirationStr: "1ms",
+	})
+	assert.NoError(t, err)
+	l := &MysqlListener{statementID: atomic.NewUint32(0), stmts: &sync.Map{}, migration: migration}
+	assert.NoError(t, l.importSessions([]*SessionState{{Token: "token", User: "dksl", Host: "10.0.0.1"}}))
+	time.Sleep(5 * time.Millisecond)
+	assert.Nil(t, migration.take("token", "dksl"))
+
+	_, err = newSessionMigration("0.0.0.0:13306", &SessionMigrationConfig{})
+	assert.Error(t, err)
+	_, err = newSessionMigration("0.0.0.0:13306", &SessionMigrationConfig{Peers: []string{"127.0.0.1:18888"}})
+	assert.Error(t, err)
+}
@@ -28,54 +28,123 @@ import (
 	"net"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/uber-go/atomic"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/cectc/dbpack/pkg/config"
 	"github.com/cectc/dbpack/pkg/constant"
 	err2 "github.com/cectc/dbpack/pkg/errors"
+	"github.com/cectc/dbpack/pkg/filter"
 	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/meta"
 	"github.com/cectc/dbpack/pkg/misc"
 	"github.com/cectc/dbpack/pkg/mysql"
 	"github.com/cectc/dbpack/pkg/packet"
 	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/replay"
+	"github.com/cectc/dbpack/pkg/standby"
+	"github.com/cectc/dbpack/pkg/tablestats"
 	"github.com/cectc/dbpack/pkg/tracing"
+	"github.com/cectc/dbpack/pkg/usage"
 	"github.com/cectc/dbpack/pkg/visitor"
 	"github.com/cectc/dbpack/third_party/parser"
+	"github.com/cectc/dbpack/third_party/parser/ast"
 )
 
 const initClientConnStatus = constant.ServerStatusAutocommit
 
+// connectionIDs generates the connection ids of all mysql listeners, the executors and filters
+// keep the state of connections by id, so that it must be unique within the process even if
+// several listeners share an executor.
+var connectionIDs = atomic.NewUint32(0)
+
 type MysqlConfig struct {
 	Users         map[string]string `yaml:"users" json:"users"`
 	ServerVersion string            `yaml:"server_version" json:"server_version"`
+	// Dialect is the mysql version whose syntax is accepted, 5.7 or 8.0,
+	// defaults to the major version of ServerVersion
+	Dialect string `yaml:"dialect" json:"dialect"`
+	// PassthroughUnparsed sends read and ddl statements the parser does not
+	// support to the master instead of failing them
+	PassthroughUnparsed bool `yaml:"passthrough_unparsed" json:"passthrough_unparsed"`
+	// XAPassthrough forwards client-managed XA statements verbatim, keeping the
+	// backend connection pinned from XA START to XA COMMIT or XA ROLLBACK
+	XAPassthrough bool `yaml:"xa_passthrough" json:"xa_passthrough"`
+	// Compression advertises the zlib and zstd compressed protocol to clients
+	Compression bool `yaml:"compression" json:"compression"`
+	// PipelinedReads is the max number of the independent reads a client pipelines which run
+	// concurrently, the responses are written in the order of the statements anyway, 0 runs
+	// the pipelined statements one after another
+	PipelinedReads int `yaml:"pipelined_reads" json:"pipelined_reads"`
+	// MaxAllowedPacket is the max length of a statement or a parameter sent by clients,
+	// reported to clients as max_allowed_packet, defaults to 64MiB
+	MaxAllowedPacket int `yaml:"max_allowed_packet" json:"max_allowed_packet"`
+	// MaxConnections caps the client connections, the listener stops accepting while
+	// it is reached so that new connections wait in the accept queue, 0 means no cap
+	MaxConnections int `yaml:"max_connections" json:"max_connections"`
+	// MaxConcurrentHandshakes caps the handshakes in progress, defaults to 64
+	MaxConcurrentHandshakes int `yaml:"max_concurrent_handshakes" json:"max_concurrent_handshakes"`
+	// HandshakeTimeout bounds both waiting for a handshake slot and the handshake itself,
+	// a connection getting no handshake slot in time is closed, defaults to 10s
+	HandshakeTimeout    time.Duration `yaml:"-" json:"-"`
+	HandshakeTimeoutStr string        `yaml:"handshake_timeout" json:"handshake_timeout"`
+	// StatementTimeout bounds the statements of the clients declaring no timeout, the statements
+	// time out waiting for backend connections, sending the statements or reading the results,
+	// e.g. 30s, unlimited if it is not set
+	StatementTimeout    time.Duration `yaml:"-" json:"-"`
+	StatementTimeoutStr string        `yaml:"statement_timeout" json:"statement_timeout"`
+	// IdleInTransactionTimeout terminates the client sessions idle in a transaction longer than it,
+	// which rolls back the transaction and releases its backend connections, e.g. 5m, the sessions
+	// are not terminated if it is not set
+	IdleInTransactionTimeout    time.Duration `yaml:"-" json:"-"`
+	IdleInTransactionTimeoutStr string        `yaml:"idle_in_transaction_timeout" json:"idle_in_transaction_timeout"`
+	// Acceptors is the number of accept loops, each accepting on a socket of its own bound
+	// to the listener address with SO_REUSEPORT, defaults to 1
+	Acceptors int `yaml:"acceptors" json:"acceptors"`
+	// AccessLog records a line per client connection when it is closed, disabled if it is not set
+	AccessLog *AccessLogConfig `yaml:"access_log" json:"access_log"`
+	// Capture records the statements of clients for `dbpack replay`, disabled if it is not set
+	Capture *CaptureConfig `yaml:"capture" json:"capture"`
+	// ResultLimit warns of or fails the statements returning large results, disabled if it is not set
+	ResultLimit *ResultLimitConfig `yaml:"result_limit" json:"result_limit"`
+	// SessionMigration migrates the client sessions to a peer instance, disabled if it is not set
+	SessionMigration *SessionMigrationConfig `yaml:"session_migration" json:"session_migration"`
+}
+
+// compression is the compressed protocol requested by the client in the handshake.
+type compression struct {
+	algorithm string
+	level     int
 }
 
 type MysqlListener struct {
 	// conf
 	conf MysqlConfig
 
-	// This is the main listener socket.
-	listener net.Listener
-
-	executor proto.Executor
+	// These are the listener sockets, one per acceptor.
+	listeners []net.Listener
+
+	listenerExecutors
+	// connectionExecutors maps connection ids to the executors serving them.
+	connectionExecutors *sync.Map
+	// statementTimeouts maps connection ids to the statement timeouts their clients declare.
+	statementTimeouts sync.Map
+	// processes maps connection ids to the client sessions listed by SHOW PROCESSLIST.
+	processes sync.Map
+	// started is when the listener was created and questions the statements its clients sent,
+	// both are reported by COM_STATISTICS.
+	started   time.Time
+	questions atomic.Uint64
 
 	// Incrementing ID for connection id.
-	connectionID uint32
+	connectionID *atomic.Uint32
 	// connReadBufferSize is size of buffer for reads from underlying connection.
 	// Reads are unbuffered if it's <=0.
 	connReadBufferSize int
 
-	// capabilities is the current set of features this connection
-	// is using.  It is the features that are both supported by
-	// the client and the server, and currently in use.
-	// It is set during the initial handshake.
-	//
-	// It is only used for CapabilityClientDeprecateEOF
-	// and CapabilityClientFoundRows.
-	capabilities uint32
-
 	// characterSet is the character set used by the other side of the
 	// connection.
 	// It is set during the initial handshake.
@@ -94,6 +163,18 @@ type MysqlListener struct {
 
 	// stmts is the map to use a prepared statement.
 	stmts *sync.Map
+
+	limiter *connectionLimiter
+
+	preFilters  []proto.DBPreFilter
+	postFilters []proto.DBPostFilter
+
+	accessLog   *accessLog
+	capture     *replay.Writer
+	resultLimit *resultLimit
+	migration   *sessionMigration
+
+	appID string
 }
 
 func NewMysqlListener(conf *config.Listener) (proto.Listener, error) {
@@ -110,47 +191,239 @@ func NewMysqlListener(conf *config.Listener) (proto.Listener, error) {
 		log.Errorf("unmarshal mysql listener config failed, %s", err)
 		return nil, err
 	}
+	if cfg.Dialect, err = visitor.ResolveDialect(cfg.Dialect, cfg.ServerVersion); err != nil {
+		return nil, err
+	}
+	if cfg.MaxAllowedPacket == 0 {
+		cfg.MaxAllowedPacket = defaultMaxAllowedPacket
+	}
+	if cfg.MaxAllowedPacket < 1024 || cfg.MaxAllowedPacket > constant.MaxMaxAllowedPacket {
+		return nil, errors.Errorf("max_allowed_packet must be between 1024 and %d, got %d",
+			constant.MaxMaxAllowedPacket, cfg.MaxAllowedPacket)
+	}
+	if cfg.Acceptors < 0 {
+		return nil, errors.Errorf("acceptors must not be negative, got %d", cfg.Acceptors)
+	}
+	if cfg.MaxConnections < 0 {
+		return nil, errors.Errorf("max_connections must not be negative, got %d", cfg.MaxConnections)
+	}
+	if cfg.MaxConcurrentHandshakes <= 0 {
+		cfg.MaxConcurrentHandshakes = DefaultMaxConcurrentHandshakes
+	}
+	cfg.HandshakeTimeout = DefaultHandshakeTimeout
+	if cfg.HandshakeTimeoutStr != "" {
+		if cfg.HandshakeTimeout, err = time.ParseDuration(cfg.HandshakeTimeoutStr); err != nil {
+			return nil, errors.Wrap(err, "parse mysql listener handshake_timeout failed")
+		}
+		if cfg.HandshakeTimeout <= 0 {
+			return nil, errors.Errorf("handshake_timeout must be positive, got %s", cfg.HandshakeTimeoutStr)
+		}
+	}
+
+	if cfg.StatementTimeoutStr != "" {
+		if cfg.StatementTimeout, err = time.ParseDuration(cfg.StatementTimeoutStr); err != nil {
+			return nil, errors.Wrap(err, "parse mysql listener statement_timeout failed")
+		}
+		if cfg.StatementTimeout < 0 {
+			return nil, errors.Errorf("statement_timeout must not be negative, got %s", cfg.StatementTimeoutStr)
+		}
+	}
+	if cfg.IdleInTransactionTimeoutStr != "" {
+		if cfg.IdleInTransactionTimeout, err = time.ParseDuration(cfg.IdleInTransactionTimeoutStr); err != nil {
+			return nil, errors.Wrap(err, "parse mysql listener idle_in_transaction_timeout failed")
+		}
+		if cfg.IdleInTransactionTimeout < 0 {
+			return nil, errors.Errorf("idle_in_transaction_timeout must not be negative, got %s",
+				cfg.IdleInTransactionTimeoutStr)
+		}
+	}
+
+	preFilters := make([]proto.DBPreFilter, 0)
+	postFilters := make([]proto.DBPostFilter, 0)
+	for i := 0; i < len(conf.Filters); i++ {
+		filterName := conf.Filters[i]
+		f := filter.GetFilter(conf.AppID, filterName)
+		if f != nil {
+			if _, ok := f.(proto.DBResultRowsFilter); ok {
+				return nil, errors.Errorf("filter %s reads the result rows, it must be configured on an executor", filterName)
+			}
+			preFilter, ok := f.(proto.DBPreFilter)
+			if ok {
+				preFilters = append(preFilters, preFilter)
+			}
+			postFilter, ok := f.(proto.DBPostFilter)
+			if ok {
+				postFilters = append(postFilters, postFilter)
+			}
+		}
+	}
+
+	var accessLog *accessLog
+	if cfg.AccessLog != nil {
+		if accessLog, err = newAccessLog(cfg.AccessLog); err != nil {
+			return nil, err
+		}
+	}
+
+	var capture *replay.Writer
+	if cfg.Capture != nil {
+		capture = newCapture(cfg.Capture)
+	}
+
+	var limit *resultLimit
+	if cfg.ResultLimit != nil {
+		if limit, err = newResultLimit(conf.SocketAddress.String(), cfg.ResultLimit); err != nil {
+			return nil, err
+		}
+	}
+
+	var migration *sessionMigration
+	if cfg.SessionMigration != nil {
+		if migration, err = newSessionMigration(conf.SocketAddress.String(), cfg.SessionMigration); err != nil {
+			return nil, err
+		}
+	}
 
-	l, err := net.Listen("tcp", fmt.Sprintf("%s:%d", conf.SocketAddress.Address, conf.SocketAddress.Port))
+	listeners, err := listen(conf.SocketAddress.String(), cfg.Acceptors)
 	if err != nil {
-		log.Errorf("listen %s:%d error, %s", conf.SocketAddress.Address, conf.SocketAddress.Port, err)
+		log.Errorf("listen %s error, %s", conf.SocketAddress.String(), err)
 		return nil, err
 	}
 
 	listener := &MysqlListener{
-		conf:        cfg,
-		listener:    l,
-		statementID: atomic.NewUint32(0),
-		stmts:       &sync.Map{},
+		conf:         cfg,
+		listeners:    listeners,
+		connectionID: connectionIDs,
+		statementID:  atomic.NewUint32(0),
+		stmts:        &sync.Map{},
+		limiter:      newConnectionLimiter(listeners[0].Addr().String(), cfg),
+		accessLog:    accessLog,
+		capture:      capture,
+		resultLimit:  limit,
+		migration:    migration,
+		appID:        conf.AppID,
+		started:      time.Now(),
+
+		connectionExecutors: &sync.Map{},
+		preFilters:          preFilters,
+		postFilters:         postFilters,
 	}
+	if migration != nil {
+		migrationListeners.Store(migration.listener, listener)
+	}
+
 	return listener, nil
 }
 
-func (l *MysqlListener) SetExecutor(executor proto.Executor) {
-	l.executor = executor
+// Addr returns the address the listener accepts connections on.
+func (l *MysqlListener) Addr() net.Addr {
+	return l.listeners[0].Addr()
+}
+
+// SetExecutors sets the default executor and the schema executors of conf to l, the executors are looked up by name.
+func SetExecutors(l proto.DBListener, conf *config.Listener, executors map[string]proto.Executor) error {
+	if conf.Executor != "" {
+		executor := executors[conf.Executor]
+		if executor == nil {
+			return errors.Errorf("executor: %s is not exists for listener %s", conf.Executor, conf.SocketAddress)
+		}
+		l.SetExecutor(executor)
+	} else if len(conf.SchemaExecutors) == 0 {
+		return errors.Errorf("listener %s has no executor", conf.SocketAddress)
+	}
+	for schema, executorName := range conf.SchemaExecutors {
+		executor := executors[executorName]
+		if executor == nil {
+			return errors.Errorf("executor: %s is not exists for schema %s of listener %s", executorName, schema, conf.SocketAddress)
+		}
+		l.SetSchemaExecutor(schema, executor)
+	}
+	return nil
+}
+
+// noSchemaExecutorError is the error of a connection using a schema no executor serves.
+func noSchemaExecutorError(schema string) error {
+	if schema == "" {
+		return err2.NewSQLError(constant.ERNoDb, constant.SSNoDb, "No database selected")
+	}
+	return err2.NewSQLError(constant.ERBadDb, constant.SSBadDbError, "Unknown database '%s'", schema)
+}
+
+// connectionExecutor returns the executor serving the connection, which is chosen by the schema
+// of the connection once it is established.
+func (l *MysqlListener) connectionExecutor(connectionID uint32) proto.Executor {
+	if executor, ok := l.connectionExecutors.Load(connectionID); ok {
+		return executor.(proto.Executor)
+	}
+	return l.defaultExecutor()
 }
 
 func (l *MysqlListener) Listen() {
-	log.Infof("start mysql listener %s", l.listener.Addr())
+	log.Infof("start mysql listener %s with %d acceptors", l.listeners[0].Addr(), len(l.listeners))
+	var wg sync.WaitGroup
+	for _, listener := range l.listeners[1:] {
+		wg.Add(1)
+		go func(listener net.Listener) {
+			defer wg.Done()
+			l.accept(listener)
+		}(listener)
+	}
+	l.accept(l.listeners[0])
+	wg.Wait()
+}
+
+// accept accepts connections on listener until it is closed.
+func (l *MysqlListener) accept(listener net.Listener) {
 	for {
-		conn, err := l.listener.Accept()
+		if !l.limiter.acquireConnection() {
+			return
+		}
+		conn, err := listener.Accept()
 		if err != nil {
+			l.limiter.releaseConnection()
 			return
 		}
 
-		l.connectionID++
-		connectionID := l.connectionID
-		go l.handle(conn, connectionID)
+		go l.handle(conn, l.connectionID.Inc())
 	}
 }
 
 func (l *MysqlListener) Close() {
-	if err := l.listener.Close(); err != nil {
-		log.Error(err)
+	l.limiter.close()
+	for _, listener := range l.listeners {
+		if err := listener.Close(); err != nil {
+			log.Error(err)
+		}
+	}
+	if l.accessLog != nil {
+		if err := l.accessLog.close(); err != nil {
+			log.Error(err)
+		}
+	}
+	if l.capture != nil {
+		if err := l.capture.Close(); err != nil {
+			log.Error(err)
+		}
 	}
 }
 
 func (l *MysqlListener) handle(conn net.Conn, connectionID uint32) {
+	var (
+		counter     = &countingConn{Conn: conn}
+		connectTime = time.Now()
+		statements  uint64
+		session     *usage.Session
+		// bytesIn and bytesOut are the bytes of the connection accounted to the session
+		bytesIn, bytesOut uint64
+		// compressed are the bytes of the compressed protocol accounted to the metrics
+		compressed mysql.CompressionStats
+	)
+	conn = counter
+	accountBytes := func() {
+		in, out := counter.bytesIn.Load(), counter.bytesOut.Load()
+		session.AddBytes(in-bytesIn, out-bytesOut)
+		bytesIn, bytesOut = in, out
+	}
 	c := mysql.NewConn(conn)
 	c.SetConnectionID(connectionID)
 
@@ -161,55 +434,173 @@ func (l *MysqlListener) handle(conn net.Conn, connectionID uint32) {
 		}
 
 		if err := conn.Close(); err != nil {
-			log.Errorf("connection close error, connection id: %v, error: %s", l.connectionID, err)
+			log.Errorf("connection close error, connection id: %v, error: %s", connectionID, err)
+		}
+		l.statementTimeouts.Delete(connectionID)
+		l.untrackProcess(connectionID)
+		if executor, ok := l.connectionExecutors.LoadAndDelete(connectionID); ok {
+			executor.(proto.Executor).ConnectionClose(proto.WithConnectionID(context.Background(), connectionID))
+		}
+		l.limiter.releaseConnection()
+		if session != nil {
+			accountBytes()
+			l.accountCompression(c, &compressed)
+			session.Close()
+		}
+		if l.accessLog != nil {
+			l.writeAccessLog(c, counter, connectTime, statements)
+		}
+		if l.capture != nil {
+			l.writeCapture(&replay.Event{Time: time.Now(), ConnectionID: connectionID, Command: replay.CommandQuit})
 		}
-		l.executor.ConnectionClose(proto.WithConnectionID(context.Background(), l.connectionID))
 	}()
 
-	err := l.handshake(c)
-	if err != nil {
-		writeErr := c.WriteErrorPacketFromError(err)
-		if writeErr != nil {
-			log.Warnf("Cannot write error packet to %s: %v", c, writeErr)
-			return
-		}
-		return
+	if l.migration != nil {
+		// also drops the tokens of a connection whose handshake fails
+		defer l.migration.untrack(connectionID)
 	}
-
-	// Negotiation worked, send OK packet.
-	if err := c.WriteOKPacket(0, 0, c.StatusFlags(), 0); err != nil {
-		log.Errorf("Cannot write OK packet to %s: %v", c, err)
+	if !l.establish(conn, c) {
 		return
 	}
 	log.Debugf("connection established, id: %d", connectionID)
+	session = usage.OpenSession(connectionID, c.UserName(), c.RemoteAddr().String())
+	process := l.trackProcess(c)
+	c.SetMaxAllowedPacket(l.conf.MaxAllowedPacket)
+	defer l.limiter.enter(stageCommand)()
 
+	newContext := func(sequence uint64) context.Context {
+		ctx := proto.WithVariableMap(context.Background())
+		ctx = proto.WithConnectionID(ctx, connectionID)
+		ctx = proto.WithUserName(ctx, c.UserName())
+		ctx = proto.WithRemoteAddr(ctx, c.RemoteAddr().String())
+		ctx = proto.WithSchema(ctx, l.schemaName)
+		ctx = proto.WithStatementSequence(ctx, sequence)
+		ctx = proto.WithRoutingTargets(ctx)
+		return usage.WithSession(ctx, session)
+	}
+	var tracked *trackedSession
+	if l.migration != nil {
+		tracked = l.migration.track(conn, c)
+		tracked.begin()
+		l.restoreSession(newContext(statements), c)
+		tracked.end()
+	}
+	var idleInTransaction bool
 	for {
 		c.ResetSequence()
-		var data []byte
-		data, err = c.ReadEphemeralPacket()
+		watching, err := l.watchIdleInTransaction(conn, connectionID, idleInTransaction)
+		if err != nil {
+			log.Errorf("Cannot set idle in transaction deadline of %s: %v", c, err)
+			return
+		}
+		idleInTransaction = watching
+		data, err := c.ReadEphemeralPacket()
 		if err != nil {
+			if idleInTransaction && isTimeout(err) {
+				c.DiscardReadPacket()
+				l.terminateIdleInTransaction(c)
+				return
+			}
+			// The rest of the packet is not read, so the connection is closed after the error.
+			if sqlErr, ok := err.(*err2.SQLError); ok && sqlErr.Num == constant.ERNetPacketTooLarge {
+				if writeErr := c.WriteErrorPacketFromError(err); writeErr != nil {
+					log.Warnf("Cannot write error packet to %s: %v", c, writeErr)
+				}
+			}
 			c.RecycleReadPacket()
 			return
 		}
 
 		content := make([]byte, len(data))
 		copy(content, data)
-		ctx := proto.WithVariableMap(context.Background())
-		ctx = proto.WithConnectionID(ctx, connectionID)
-		ctx = proto.WithUserName(ctx, c.UserName())
-		ctx = proto.WithRemoteAddr(ctx, c.RemoteAddr().String())
-		ctx = proto.WithSchema(ctx, l.schemaName)
-		err = l.ExecuteCommand(ctx, c, content)
+		if content[0] == constant.ComQuery || content[0] == constant.ComStmtExecute {
+			statements++
+			l.questions.Inc()
+		}
+		// the connection is closed once its session is migrated
+		if tracked != nil && !tracked.begin() {
+			c.RecycleReadPacket()
+			return
+		}
+		l.beginCommand(process, c, content)
+		if content[0] == constant.ComQuery && l.conf.PipelinedReads > 0 && c.BufferedQuery() {
+			c.RecycleReadPacket()
+			var queries []string
+			if queries, err = readPipelinedQueries(c); err != nil {
+				return
+			}
+			queries = append([]string{string(content[1:])}, queries...)
+			sequence := statements
+			statements += uint64(len(queries) - 1)
+			l.questions.Add(uint64(len(queries) - 1))
+			err = l.executePipeline(c, queries, sequence, newContext)
+		} else {
+			err = l.ExecuteCommand(newContext(statements), c, content)
+		}
+		process.end(c)
+		if tracked != nil {
+			tracked.end()
+		}
+		accountBytes()
+		l.accountCompression(c, &compressed)
 		if err != nil {
 			return
 		}
 	}
 }
 
-func (l *MysqlListener) handshake(c *mysql.Conn) error {
+// establish handshakes c once a handshake slot is free, waiting for the slot and the handshake
+// are each bounded by the handshake timeout, and enables the compression requested.
+func (l *MysqlListener) establish(conn net.Conn, c *mysql.Conn) bool {
+	if !l.limiter.acquireHandshake() {
+		log.Warnf("no handshake slot for connection %d within %s, close it", c.ID(), l.conf.HandshakeTimeout)
+		if err := c.WriteErrorPacket(constant.ERConCount, constant.SSConCount, "Too many connections"); err != nil {
+			log.Warnf("Cannot write error packet to %s: %v", c, err)
+		}
+		return false
+	}
+	defer l.limiter.releaseHandshake()
+	defer l.limiter.enter(stageHandshake)()
+
+	if err := conn.SetDeadline(time.Now().Add(l.conf.HandshakeTimeout)); err != nil {
+		log.Errorf("Cannot set handshake deadline of %s: %v", c, err)
+		return false
+	}
+	compress, err := l.handshake(c)
+	if err != nil {
+		writeErr := c.WriteErrorPacketFromError(err)
+		if writeErr != nil {
+			log.Warnf("Cannot write error packet to %s: %v", c, writeErr)
+		}
+		return false
+	}
+
+	// Negotiation worked, send OK packet, with the token of the session if it may be migrated.
+	var sessionState []byte
+	if l.migration != nil {
+		sessionState = l.migration.issueToken(c.ID(), c.Capabilities())
+	}
+	if err := c.WriteOKPacketWithSessionState(0, 0, c.StatusFlags(), 0, sessionState); err != nil {
+		log.Errorf("Cannot write OK packet to %s: %v", c, err)
+		return false
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		log.Errorf("Cannot clear handshake deadline of %s: %v", c, err)
+		return false
+	}
+	if compress.algorithm != "" {
+		if err := c.EnableCompression(compress.algorithm, compress.level); err != nil {
+			log.Errorf("Cannot enable %s compression for %s: %v", compress.algorithm, c, err)
+			return false
+		}
+	}
+	return true
+}
+
+func (l *MysqlListener) handshake(c *mysql.Conn) (compression, error) {
 	salt, err := newSalt()
 	if err != nil {
-		return err
+		return compression{}, err
 	}
 	// First build and send the server handshake packet.
 	err = l.writeHandshakeV10(c, false, salt)
@@ -217,7 +608,7 @@ func (l *MysqlListener) handshake(c *mysql.Conn) error {
 		if err != io.EOF {
 			log.Errorf("Cannot send HandshakeV10 packet to %s: %v", c, err)
 		}
-		return err
+		return compression{}, err
 	}
 
 	// Wait for the client response. This has to be a direct read,
@@ -228,24 +619,30 @@ func (l *MysqlListener) handshake(c *mysql.Conn) error {
 		if err != io.EOF {
 			log.Infof("Cannot read client handshake response from %s: %v, it may not be a valid MySQL client", c, err)
 		}
-		return err
+		return compression{}, err
 	}
 
 	c.RecycleReadPacket()
 
-	user, _, authResponse, err := l.parseClientHandshakePacket(true, response)
+	user, _, authResponse, compress, err := l.parseClientHandshakePacket(c, true, response)
 	if err != nil {
 		log.Errorf("Cannot parse client handshake response from %s: %v", c, err)
-		return err
+		return compression{}, err
 	}
 
 	err = l.ValidateHash(user, salt, authResponse)
 	if err != nil {
 		log.Errorf("Error authenticating user using MySQL native password: %v", err)
-		return err
+		return compression{}, err
 	}
 	c.SetUserName(user)
-	return nil
+
+	executor := l.schemaExecutor(c.Schema())
+	if executor == nil {
+		return compression{}, noSchemaExecutorError(c.Schema())
+	}
+	l.connectionExecutors.Store(c.ID(), executor)
+	return compress, nil
 }
 
 // writeHandshakeV10 writes the Initial Handshake Packet, server side.
@@ -260,10 +657,15 @@ func (l *MysqlListener) writeHandshakeV10(c *mysql.Conn, enableTLS bool, salt []
 		constant.CapabilityClientSecureConnection |
 		constant.CapabilityClientMultiStatements |
 		constant.CapabilityClientMultiResults |
+		constant.CapabilityClientPSMultiResults |
 		constant.CapabilityClientPluginAuth |
 		constant.CapabilityClientPluginAuthLenencClientData |
 		constant.CapabilityClientDeprecateEOF |
-		constant.CapabilityClientConnAttr
+		constant.CapabilityClientConnAttr |
+		constant.CapabilityClientSessionTrack
+	if l.conf.Compression {
+		capabilities |= constant.CapabilityClientCompress | constant.CapabilityClientZstdCompressionAlgorithm
+	}
 	if enableTLS {
 		capabilities |= constant.CapabilityClientSSL
 	}
@@ -346,43 +748,45 @@ func (l *MysqlListener) writeHandshakeV10(c *mysql.Conn, enableTLS bool, salt []
 }
 
 // parseClientHandshakePacket parses the handshake sent by the client.
-// Returns the username, auth method, auth Content, error.
+// Returns the username, auth method, auth Content, compression, error.
 // The original Content is not pointed at, and can be freed.
-func (l *MysqlListener) parseClientHandshakePacket(firstTime bool, data []byte) (string, string, []byte, error) {
+func (l *MysqlListener) parseClientHandshakePacket(c *mysql.Conn, firstTime bool, data []byte) (string, string, []byte, compression, error) {
 	pos := 0
 
 	// Client flags, 4 bytes.
 	clientFlags, pos, ok := misc.ReadUint32(data, pos)
 	if !ok {
-		return "", "", nil, errors.Errorf("parseClientHandshakePacket: can't read client flags")
+		return "", "", nil, compression{}, errors.Errorf("parseClientHandshakePacket: can't read client flags")
 	}
 	if clientFlags&constant.CapabilityClientProtocol41 == 0 {
-		return "", "", nil, errors.Errorf("parseClientHandshakePacket: only support protocol 4.1")
+		return "", "", nil, compression{}, errors.Errorf("parseClientHandshakePacket: only support protocol 4.1")
 	}
 
 	// Remember a subset of the capabilities, so we can use them
 	// later in the protocol. If we re-received the handshake packet
 	// after SSL negotiation, do not overwrite capabilities.
+	// They are kept by the connection, as every client negotiates its own.
 	if firstTime {
-		l.capabilities = clientFlags & (constant.CapabilityClientDeprecateEOF | constant.CapabilityClientFoundRows)
+		c.SetCapabilities(clientFlags & (constant.CapabilityClientDeprecateEOF | constant.CapabilityClientFoundRows |
+			constant.CapabilityClientSessionTrack))
 	}
 
 	// set connection capability for executing multi statements
 	if clientFlags&constant.CapabilityClientMultiStatements > 0 {
-		l.capabilities |= constant.CapabilityClientMultiStatements
+		c.SetCapabilities(c.Capabilities() | constant.CapabilityClientMultiStatements)
 	}
 
 	// Max packet size. Don't do anything with this now.
 	// See doc.go for more information.
 	_, pos, ok = misc.ReadUint32(data, pos)
 	if !ok {
-		return "", "", nil, errors.Errorf("parseClientHandshakePacket: can't read maxPacketSize")
+		return "", "", nil, compression{}, errors.Errorf("parseClientHandshakePacket: can't read maxPacketSize")
 	}
 
 	// Character set. Need to handle it.
 	characterSet, pos, ok := misc.ReadByte(data, pos)
 	if !ok {
-		return "", "", nil, errors.Errorf("parseClientHandshakePacket: can't read characterSet")
+		return "", "", nil, compression{}, errors.Errorf("parseClientHandshakePacket: can't read characterSet")
 	}
 	l.characterSet = characterSet
 
@@ -395,14 +799,14 @@ func (l *MysqlListener) parseClientHandshakePacket(firstTime bool, data []byte)
 	//	conn := tls.Server(c.conn, l.TLSConfig)
 	//	c.conn = conn
 	//	c.bufferedReader.Reset(conn)
-	//	l.capabilities |= CapabilityClientSSL
+	//	c.SetCapabilities(c.Capabilities() | CapabilityClientSSL)
 	//	return "", "", nil, nil
 	//}
 
 	// username
 	username, pos, ok := misc.ReadNullString(data, pos)
 	if !ok {
-		return "", "", nil, errors.Errorf("parseClientHandshakePacket: can't read username")
+		return "", "", nil, compression{}, errors.Errorf("parseClientHandshakePacket: can't read username")
 	}
 
 	// auth-response can have three forms.
@@ -411,29 +815,29 @@ func (l *MysqlListener) parseClientHandshakePacket(firstTime bool, data []byte)
 		var l uint64
 		l, pos, ok = misc.ReadLenEncInt(data, pos)
 		if !ok {
-			return "", "", nil, errors.Errorf("parseClientHandshakePacket: can't read auth-response variable length")
+			return "", "", nil, compression{}, errors.Errorf("parseClientHandshakePacket: can't read auth-response variable length")
 		}
 		authResponse, pos, ok = misc.ReadBytesCopy(data, pos, int(l))
 		if !ok {
-			return "", "", nil, errors.Errorf("parseClientHandshakePacket: can't read auth-response")
+			return "", "", nil, compression{}, errors.Errorf("parseClientHandshakePacket: can't read auth-response")
 		}
 
 	} else if clientFlags&constant.CapabilityClientSecureConnection != 0 {
 		var l byte
 		l, pos, ok = misc.ReadByte(data, pos)
 		if !ok {
-			return "", "", nil, errors.Errorf("parseClientHandshakePacket: can't read auth-response length")
+			return "", "", nil, compression{}, errors.Errorf("parseClientHandshakePacket: can't read auth-response length")
 		}
 
 		authResponse, pos, ok = misc.ReadBytesCopy(data, pos, int(l))
 		if !ok {
-			return "", "", nil, errors.Errorf("parseClientHandshakePacket: can't read auth-response")
+			return "", "", nil, compression{}, errors.Errorf("parseClientHandshakePacket: can't read auth-response")
 		}
 	} else {
 		a := ""
 		a, pos, ok = misc.ReadNullString(data, pos)
 		if !ok {
-			return "", "", nil, errors.Errorf("parseClientHandshakePacket: can't read auth-response")
+			return "", "", nil, compression{}, errors.Errorf("parseClientHandshakePacket: can't read auth-response")
 		}
 		authResponse = []byte(a)
 	}
@@ -443,9 +847,10 @@ func (l *MysqlListener) parseClientHandshakePacket(firstTime bool, data []byte)
 		dbname := ""
 		dbname, pos, ok = misc.ReadNullString(data, pos)
 		if !ok {
-			return "", "", nil, errors.Errorf("parseClientHandshakePacket: can't read dbname")
+			return "", "", nil, compression{}, errors.Errorf("parseClientHandshakePacket: can't read dbname")
 		}
 		l.schemaName = dbname
+		c.SetSchema(dbname)
 	}
 
 	// authMethod (with default)
@@ -453,7 +858,7 @@ func (l *MysqlListener) parseClientHandshakePacket(firstTime bool, data []byte)
 	if clientFlags&constant.CapabilityClientPluginAuth != 0 {
 		authMethod, pos, ok = misc.ReadNullString(data, pos)
 		if !ok {
-			return "", "", nil, errors.Errorf("parseClientHandshakePacket: can't read authMethod")
+			return "", "", nil, compression{}, errors.Errorf("parseClientHandshakePacket: can't read authMethod")
 		}
 	}
 
@@ -463,13 +868,85 @@ func (l *MysqlListener) parseClientHandshakePacket(firstTime bool, data []byte)
 	}
 
 	// Decode connection attributes send by the client
+	attrsDecoded := true
 	if clientFlags&constant.CapabilityClientConnAttr != 0 {
-		if _, _, err := parseConnAttrs(data, pos); err != nil {
+		var (
+			attrs map[string]string
+			err   error
+		)
+		if attrs, pos, err = parseConnAttrs(data, pos); err != nil {
 			log.Warnf("Decode connection attributes send by the client: %v", err)
+			attrsDecoded = false
+		} else {
+			l.setStatementTimeout(c, attrs)
+			if l.migration != nil {
+				l.migration.reclaim(c.ID(), attrs)
+			}
 		}
 	}
 
-	return username, authMethod, authResponse, nil
+	var compress compression
+	if l.conf.Compression {
+		if clientFlags&constant.CapabilityClientZstdCompressionAlgorithm != 0 {
+			compress.algorithm = mysql.CompressionZstd
+			// zstd compression level, the default is used if it can not be located.
+			if attrsDecoded {
+				if level, _, ok := misc.ReadByte(data, pos); ok {
+					compress.level = int(level)
+				}
+			}
+		} else if clientFlags&constant.CapabilityClientCompress != 0 {
+			compress.algorithm = mysql.CompressionZlib
+		}
+	}
+
+	return username, authMethod, authResponse, compress, nil
+}
+
+// writeResults writes result and the results following it, such as the result sets and the OK packet
+// of a CALL, all but the last one are flagged with SERVER_MORE_RESULTS_EXISTS.
+func (l *MysqlListener) writeResults(c *mysql.Conn, result *mysql.Result, warnings uint16, inTransaction bool) error {
+	for rlt := result; rlt != nil; rlt = rlt.Next {
+		flags := c.StatusFlags()
+		if inTransaction {
+			flags |= constant.ServerStatusInTrans
+		}
+		if rlt.Next != nil {
+			flags |= constant.ServerMoreResultsExists
+		}
+		if len(rlt.Fields) == 0 {
+			// A result with no fields means that this was a DML or other write-only
+			// operation, clients expect the affected rows and last insert id of it.
+			if err := l.writeOKPacket(c, rlt.AffectedRows, rlt.InsertId, flags, warnings, rlt.SessionState); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := c.WriteFields(c.Capabilities(), rlt.Fields); err != nil {
+			return err
+		}
+		if err := c.WriteRows(rlt); err != nil {
+			return err
+		}
+		if rlt.OutParams {
+			flags |= constant.ServerPSOutParams
+		}
+		if err := c.WriteEndResultWithFlags(c.Capabilities(), flags, 0, 0, warnings); err != nil {
+			log.Errorf("Error writing result to %s: %v", c, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// writeOKPacket writes an OK packet, forwarding the session state changes
+// if the client requested CapabilityClientSessionTrack.
+func (l *MysqlListener) writeOKPacket(c *mysql.Conn, affectedRows, lastInsertID uint64, flags uint16, warnings uint16,
+	sessionState []byte) error {
+	if c.Capabilities()&constant.CapabilityClientSessionTrack == 0 {
+		return c.WriteOKPacket(affectedRows, lastInsertID, flags, warnings)
+	}
+	return c.WriteOKPacketWithSessionState(affectedRows, lastInsertID, flags, warnings, sessionState)
 }
 
 func (l *MysqlListener) ValidateHash(user string, salt []byte, authResponse []byte) error {
@@ -515,28 +992,57 @@ func scramblePassword(scramble []byte, password string) []byte {
 }
 
 func (l *MysqlListener) ExecuteCommand(ctx context.Context, c *mysql.Conn, data []byte) error {
+	executor := l.connectionExecutor(c.ID())
 	commandType := data[0]
 	switch commandType {
 	case constant.ComQuit:
 		// https://dev.constant.Com/doc/internals/en/com-quit.html
 		c.RecycleReadPacket()
 		connectionID := proto.ConnectionID(ctx)
-		l.executor.ConnectionClose(proto.WithConnectionID(ctx, connectionID))
+		executor.ConnectionClose(proto.WithConnectionID(ctx, connectionID))
 		log.Debugf("connection closed, id: %d", connectionID)
 		return errors.New("ComQuit")
 	case constant.ComInitDB:
 		db := string(data[1:])
 		c.RecycleReadPacket()
+		if next := l.schemaExecutor(db); next != executor {
+			var switchErr error
+			if next == nil {
+				switchErr = noSchemaExecutorError(db)
+			} else if executor.InLocalTransaction(ctx) || executor.InGlobalTransaction(ctx) {
+				switchErr = err2.NewSQLError(constant.ERCantDoThisDuringAnTransaction, constant.SSCantDoThisDuringAnTransaction,
+					"Cannot switch to database '%s' served by another executor in a transaction", db)
+			}
+			if switchErr != nil {
+				if writeErr := c.WriteErrorPacketFromError(switchErr); writeErr != nil {
+					log.Errorf("Error writing ComInitDB error to %s: %v", c, writeErr)
+					return writeErr
+				}
+				return nil
+			}
+			// The connection is served by the executor of the new schema from now on.
+			executor.ConnectionClose(ctx)
+			executor = next
+			l.connectionExecutors.Store(c.ID(), executor)
+		}
 		l.schemaName = db
-		err := l.executor.ExecuteUseDB(ctx, db)
+		c.SetSchema(db)
+		err := executor.ExecuteUseDB(ctx, db)
 		if err != nil {
 			return err
 		}
-		if err := c.WriteOKPacket(0, 0, c.StatusFlags(), 0); err != nil {
+		// Report the schema change the same as mysql does for COM_INIT_DB.
+		sessionState := packet.BuildSessionStateChanges(&packet.SessionStateChange{
+			Type: constant.SessionTrackSchema,
+			Data: append(misc.AppendLengthEncodedInteger(nil, uint64(len(db))), db...),
+		})
+		if err := l.writeOKPacket(c, 0, 0, c.StatusFlags(), 0, sessionState); err != nil {
 			log.Errorf("Error writing ComInitDB result to %s: %v", c, err)
 			return err
 		}
 	case constant.ComQuery:
+		query := string(data[1:])
+		c.RecycleReadPacket()
 		err := func() error {
 			c.StartWriterBuffering()
 			defer func() {
@@ -544,65 +1050,9 @@ func (l *MysqlListener) ExecuteCommand(ctx context.Context, c *mysql.Conn, data
 					log.Errorf("conn %v: flush() failed: %v", c.ID(), err)
 				}
 			}()
-			query := string(data[1:])
-			c.RecycleReadPacket()
-			p := parser.New()
-			stmt, err := p.ParseOneStmt(query, "", "")
-			if err != nil {
-				if writeErr := c.WriteErrorPacketFromError(err); writeErr != nil {
-					log.Error("Error writing query error to client %v: %v", l.connectionID, writeErr)
-					return writeErr
-				}
-				return nil
-			}
-
-			traceCtx := tracing.BuildContextFromSQLHint(ctx, stmt)
-			spanCtx, span := tracing.GetTraceSpan(traceCtx, tracing.MySQLListenerComQuery)
-			defer span.End()
-
-			stmt.Accept(&visitor.ParamVisitor{})
-			spanCtx = proto.WithCommandType(spanCtx, commandType)
-			spanCtx = proto.WithQueryStmt(spanCtx, stmt)
-			spanCtx = proto.WithSqlText(spanCtx, query)
-			result, warn, err := l.executor.ExecutorComQuery(spanCtx, query)
-			if err != nil {
-				if writeErr := c.WriteErrorPacketFromError(err); writeErr != nil {
-					log.Error("Error writing query error to client %v: %v", l.connectionID, writeErr)
-					return writeErr
-				}
-				return nil
-			}
-			if rlt, ok := result.(*mysql.Result); ok {
-				if len(rlt.Fields) == 0 {
-					// A successful callback with no fields means that this was a
-					// DML or other write-only operation.
-					//
-					// We should not send any more packets after this, but make sure
-					// to extract the affected rows and last insert id from the result
-					// struct here since clients expect it.
-					flag := c.StatusFlags()
-					if l.executor.InLocalTransaction(ctx) {
-						flag = flag | constant.ServerStatusInTrans
-					}
-					return c.WriteOKPacket(rlt.AffectedRows, rlt.InsertId, flag, warn)
-				}
-				err = c.WriteFields(l.capabilities, rlt.Fields)
-				if err != nil {
-					tracing.RecordErrorSpan(span, err)
-					return err
-				}
-				err = c.WriteRows(rlt)
-				if err != nil {
-					tracing.RecordErrorSpan(span, err)
-					return err
-				}
-			}
-			if err = c.WriteEndResult(l.capabilities, false, 0, 0, warn); err != nil {
-				log.Errorf("Error writing result to %s: %v", c, err)
-				tracing.RecordErrorSpan(span, err)
-				return err
-			}
-			return nil
+			q := l.parseQuery(ctx, c, executor, query)
+			l.executeQuery(c, executor, q)
+			return l.writeQuery(c, q)
 		}()
 		if err != nil {
 			return err
@@ -620,7 +1070,7 @@ func (l *MysqlListener) ExecuteCommand(ctx context.Context, c *mysql.Conn, data
 		table := string(data[0:index])
 		wildcard := string(data[index+1:])
 		c.RecycleReadPacket()
-		fields, err := l.executor.ExecuteFieldList(ctx, table, wildcard)
+		fields, err := executor.ExecuteFieldList(ctx, table, wildcard)
 		if err != nil {
 			log.Errorf("Conn %v: Error write field list: %v", c, err)
 			if writeErr := c.WriteErrorPacketFromError(err); writeErr != nil {
@@ -634,7 +1084,7 @@ func (l *MysqlListener) ExecuteCommand(ctx context.Context, c *mysql.Conn, data
 			fld := field.(*mysql.Field)
 			result.Fields[i] = fld
 		}
-		err = c.WriteFields(l.capabilities, result.Fields)
+		err = c.WriteFields(c.Capabilities(), result.Fields)
 		if err != nil {
 			return err
 		}
@@ -648,8 +1098,7 @@ func (l *MysqlListener) ExecuteCommand(ctx context.Context, c *mysql.Conn, data
 			StatementID: l.statementID.Load(),
 			SqlText:     query,
 		}
-		p := parser.New()
-		act, err := p.ParseOneStmt(stmt.SqlText, "", "")
+		act, err := l.parse(stmt.SqlText)
 
 		if err != nil {
 			log.Errorf("Conn %v: Error parsing prepared statement: %v", c, err)
@@ -658,6 +1107,7 @@ func (l *MysqlListener) ExecuteCommand(ctx context.Context, c *mysql.Conn, data
 				log.Errorf("Conn %v: Error writing prepared statement error: %v", c, writeErr)
 				return writeErr
 			}
+			return nil
 		}
 		act.Accept(&visitor.ParamVisitor{})
 
@@ -672,8 +1122,11 @@ func (l *MysqlListener) ExecuteCommand(ctx context.Context, c *mysql.Conn, data
 		}
 
 		l.stmts.Store(stmt.StatementID, stmt)
+		if s := l.session(c.ID()); s != nil {
+			s.statements[stmt.StatementID] = struct{}{}
+		}
 
-		if err = c.WritePrepare(l.capabilities, stmt); err != nil {
+		if err = c.WritePrepare(c.Capabilities(), stmt); err != nil {
 			return err
 		}
 	case constant.ComStmtExecute:
@@ -699,7 +1152,7 @@ func (l *MysqlListener) ExecuteCommand(ctx context.Context, c *mysql.Conn, data
 			if err != nil {
 				if writeErr := c.WriteErrorPacketFromError(err); writeErr != nil {
 					// If we can't even write the error, we're done.
-					log.Error("Error writing query error to client %v: %v", l.connectionID, writeErr)
+					log.Error("Error writing query error to client %v: %v", c.ID(), writeErr)
 					return writeErr
 				}
 				return nil
@@ -716,42 +1169,29 @@ func (l *MysqlListener) ExecuteCommand(ctx context.Context, c *mysql.Conn, data
 			spanCtx = proto.WithCommandType(spanCtx, commandType)
 			spanCtx = proto.WithPrepareStmt(spanCtx, stmt)
 			spanCtx = proto.WithSqlText(spanCtx, stmt.SqlText)
-			result, warn, err := l.executor.ExecutorComStmtExecute(spanCtx, stmt)
+			spanCtx, cancel := l.withStatementDeadline(spanCtx, c.ID(), stmt.StmtNode)
+			defer cancel()
+			result, warn, err := l.execute(spanCtx, c, func() (proto.Result, uint16, error) {
+				return executor.ExecutorComStmtExecute(spanCtx, stmt)
+			})
 			if err != nil {
-				if writeErr := c.WriteErrorPacketFromError(err); writeErr != nil {
-					log.Error("Error writing query error to client %v: %v", l.connectionID, writeErr)
+				if writeErr := c.WriteErrorPacketFromError(diagnose(spanCtx, err)); writeErr != nil {
+					log.Error("Error writing query error to client %v: %v", c.ID(), writeErr)
 					tracing.RecordErrorSpan(span, writeErr)
 					return writeErr
 				}
 				return nil
 			}
 			if rlt, ok := result.(*mysql.Result); ok {
-				if len(rlt.Fields) == 0 {
-					// A successful callback with no fields means that this was a
-					// DML or other write-only operation.
-					//
-					// We should not send any more packets after this, but make sure
-					// to extract the affected rows and last insert id from the result
-					// struct here since clients expect it.
-					flag := c.StatusFlags()
-					if l.executor.InLocalTransaction(ctx) {
-						flag = flag | constant.ServerStatusInTrans
-					}
-					return c.WriteOKPacket(rlt.AffectedRows, rlt.InsertId, flag, warn)
-				}
-
-				err = c.WriteFields(l.capabilities, rlt.Fields)
-				if err != nil {
-					tracing.RecordErrorSpan(span, err)
-					return err
-				}
-				err = c.WriteRows(rlt)
-				if err != nil {
+				// the result is not used once written to the client
+				defer rlt.Release()
+				if err = l.writeResults(c, rlt, warn, executor.InLocalTransaction(ctx)); err != nil {
 					tracing.RecordErrorSpan(span, err)
 					return err
 				}
+				return nil
 			}
-			if err = c.WriteEndResult(l.capabilities, false, 0, 0, warn); err != nil {
+			if err = c.WriteEndResult(c.Capabilities(), false, 0, 0, warn); err != nil {
 				log.Errorf("Error writing result to %s: %v", c, err)
 				tracing.RecordErrorSpan(span, err)
 				return err
@@ -766,13 +1206,17 @@ func (l *MysqlListener) ExecuteCommand(ctx context.Context, c *mysql.Conn, data
 		c.RecycleReadPacket()
 		if ok {
 			l.stmts.Delete(stmtID)
+			if s := l.session(c.ID()); s != nil {
+				delete(s.statements, stmtID)
+			}
 		}
 	case constant.ComStmtSendLongData: // no response
-		if len(data) < 6 {
+		c.RecycleReadPacket()
+		if len(data) < 7 {
 			return err2.ErrMalformedPkt
 		}
 
-		stmtID := int(binary.LittleEndian.Uint32(data[0:4]))
+		stmtID := binary.LittleEndian.Uint32(data[1:5])
 
 		si, ok := l.stmts.Load(stmtID)
 		if !ok {
@@ -780,10 +1224,13 @@ func (l *MysqlListener) ExecuteCommand(ctx context.Context, c *mysql.Conn, data
 		}
 		stmt := si.(*proto.Stmt)
 
-		paramID := int(binary.LittleEndian.Uint16(data[4:6]))
+		paramID := int(binary.LittleEndian.Uint16(data[5:7]))
 		parameterID := fmt.Sprintf("v%d", paramID+1)
 		stmt.HasLongDataParam = true
-		stmt.BindVars[parameterID] = data[6:]
+		// A large parameter is sent in multiple chunks, they are concatenated. The chunks are copied,
+		// as the buffer of the packet is recycled and reused by the next packet read.
+		chunks, _ := stmt.BindVars[parameterID].([]byte)
+		stmt.BindVars[parameterID] = append(chunks, data[7:]...)
 	case constant.ComStmtReset:
 		stmtID, _, ok := misc.ReadUint32(data, 1)
 		c.RecycleReadPacket()
@@ -793,36 +1240,50 @@ func (l *MysqlListener) ExecuteCommand(ctx context.Context, c *mysql.Conn, data
 			stmt.BindVars = make(map[string]interface{}, 0)
 		}
 		return c.WriteOKPacket(0, 0, c.StatusFlags(), 0)
+	case constant.ComStatistics:
+		c.RecycleReadPacket()
+		if err := c.WritePacket([]byte(l.statistics())); err != nil {
+			log.Errorf("Error writing ComStatistics result to %s: %v", c, err)
+			return err
+		}
+	case constant.ComDebug:
+		// mysql dumps its debug information to its error log, there is none of the listener to dump.
+		c.RecycleReadPacket()
+		if err := c.WriteEndResult(c.Capabilities(), false, 0, 0, 0); err != nil {
+			log.Errorf("Error writing ComDebug result to %s: %v", c, err)
+			return err
+		}
 	case constant.ComSetOption:
 		operation, _, ok := misc.ReadUint16(data, 1)
 		c.RecycleReadPacket()
-		if ok {
-			switch operation {
-			case 0:
-				l.capabilities |= constant.CapabilityClientMultiStatements
-			case 1:
-				l.capabilities &^= constant.CapabilityClientMultiStatements
-			default:
-				log.Errorf("Got unhandled packet (ComSetOption default) from client %v, returning error: %v", l.connectionID, data)
-				if err := c.WriteErrorPacket(constant.ERUnknownComError, constant.SSUnknownComError, "error handling packet: %v", data); err != nil {
-					log.Errorf("Error writing error packet to client: %v", err)
-					return err
-				}
+		if ok && operation <= 1 {
+			if operation == 0 {
+				c.SetCapabilities(c.Capabilities() | constant.CapabilityClientMultiStatements)
+			} else {
+				c.SetCapabilities(c.Capabilities() &^ constant.CapabilityClientMultiStatements)
 			}
-			if err := c.WriteEndResult(l.capabilities, false, 0, 0, 0); err != nil {
+			if err := c.WriteEndResult(c.Capabilities(), false, 0, 0, 0); err != nil {
 				log.Errorf("Error writeEndResult error %v ", err)
 				return err
 			}
-		} else {
-			log.Errorf("Got unhandled packet (ComSetOption else) from client %v, returning error: %v", l.connectionID, data)
-			if err := c.WriteErrorPacket(constant.ERUnknownComError, constant.SSUnknownComError, "error handling packet: %v", data); err != nil {
-				log.Errorf("Error writing error packet to client: %v", err)
-				return err
-			}
+			return nil
+		}
+		log.Errorf("Got unhandled packet (ComSetOption) from client %v, returning error: %v", c.ID(), data)
+		if err := c.WriteErrorPacket(constant.ERUnknownComError, constant.SSUnknownComError, "error handling packet: %v", data); err != nil {
+			log.Errorf("Error writing error packet to client: %v", err)
+			return err
 		}
 	case constant.ComResetConnection:
 		c.RecycleReadPacket()
 		return c.WriteOKPacket(0, 0, c.StatusFlags(), 0)
+	default:
+		// The connection is kept, the client is told the command is not supported as mysql does.
+		c.RecycleReadPacket()
+		log.Warnf("Got unsupported command %d from client %v, returning error", commandType, c.ID())
+		if err := c.WriteErrorPacket(constant.ERUnknownComError, constant.SSUnknownComError, "Unknown command"); err != nil {
+			log.Errorf("Error writing error packet to client: %v", err)
+			return err
+		}
 	}
 	return nil
 }
@@ -889,3 +1350,230 @@ func newSalt() ([]byte, error) {
 
 	return salt, nil
 }
+
+// comQuery is a COM_QUERY served to a client, from parsing its statement to writing its response.
+type comQuery struct {
+	sql  string
+	stmt ast.StmtNode
+	// parseErr is the error parsing the statement, which is passed through if passthrough is set
+	parseErr    error
+	passthrough bool
+	xa          bool
+	// backendProcessList is set for SHOW [FULL] BACKEND PROCESSLIST, which is not parsed
+	backendProcessList, full bool
+	// blockers is set for SHOW DBPACK BLOCKERS, which is not parsed
+	blockers bool
+
+	ctx  context.Context
+	span trace.Span
+
+	result        proto.Result
+	warn          uint16
+	err           error
+	inTransaction bool
+}
+
+// parseQuery parses the statement of a COM_QUERY and binds it to the context it is executed with.
+func (l *MysqlListener) parseQuery(ctx context.Context, c *mysql.Conn, executor proto.Executor, query string) *comQuery {
+	q := &comQuery{sql: query}
+	_, isXA := executor.(proto.XAPassthroughExecutor)
+	q.xa = isXA && l.conf.XAPassthrough && misc.XACommand(query) != ""
+	q.backendProcessList, q.full = misc.BackendProcessList(query)
+	q.blockers = misc.DBPackBlockers(query)
+	if !q.xa && !q.backendProcessList && !q.blockers {
+		q.stmt, q.parseErr = l.parse(query)
+	}
+	if q.parseErr != nil {
+		_, ok := executor.(proto.PassthroughExecutor)
+		if q.passthrough = ok && l.shouldPassthrough(query, q.parseErr); !q.passthrough {
+			q.err = q.parseErr
+			return q
+		}
+	}
+
+	traceCtx := tracing.BuildContextFromSQLHint(ctx, q.stmt)
+	q.ctx, q.span = tracing.GetTraceSpan(traceCtx, tracing.MySQLListenerComQuery)
+	q.ctx = proto.WithCommandType(q.ctx, constant.ComQuery)
+	q.ctx = proto.WithSqlText(q.ctx, query)
+	if q.stmt != nil {
+		q.stmt.Accept(&visitor.ParamVisitor{})
+		q.ctx = proto.WithQueryStmt(q.ctx, q.stmt)
+	}
+	return q
+}
+
+// executeQuery executes a parsed COM_QUERY, unless parsing it failed.
+func (l *MysqlListener) executeQuery(c *mysql.Conn, executor proto.Executor, q *comQuery) {
+	if q.ctx == nil {
+		return
+	}
+	var cancel context.CancelFunc
+	q.ctx, cancel = l.withStatementDeadline(q.ctx, c.ID(), q.stmt)
+	defer cancel()
+	q.result, q.warn, q.err = l.execute(q.ctx, c, func() (proto.Result, uint16, error) {
+		if q.backendProcessList {
+			return l.showBackendProcessList(q.full)
+		} else if q.blockers {
+			return l.showBlockers()
+		} else if q.xa {
+			return executor.(proto.XAPassthroughExecutor).ExecutorXA(q.ctx, q.sql)
+		} else if q.parseErr != nil {
+			log.Warnf("conn %v: failed to parse query, pass it through to master: %v", c.ID(), q.parseErr)
+			return executor.(proto.PassthroughExecutor).ExecutorPassthrough(q.ctx, q.sql)
+		}
+		return executor.ExecutorComQuery(q.ctx, q.sql)
+	})
+	if q.err != nil {
+		return
+	}
+	if _, isDDL := q.stmt.(ast.DDLNode); isDDL {
+		meta.GetTableMetaCache().InvalidateByDDL(l.schemaName, q.stmt)
+	}
+	if _, isSet := q.stmt.(*ast.SetStmt); isSet {
+		if s := l.session(c.ID()); s != nil {
+			s.variables = append(s.variables, q.sql)
+		}
+	}
+	q.inTransaction = executor.InLocalTransaction(q.ctx)
+}
+
+// writeQuery writes the response to an executed COM_QUERY, an error is only returned if
+// writing fails.
+func (l *MysqlListener) writeQuery(c *mysql.Conn, q *comQuery) error {
+	if q.span != nil {
+		defer q.span.End()
+	}
+	if q.err != nil {
+		if writeErr := c.WriteErrorPacketFromError(diagnose(q.ctx, q.err)); writeErr != nil {
+			log.Error("Error writing query error to client %v: %v", c.ID(), writeErr)
+			return writeErr
+		}
+		return nil
+	}
+	if rlt, ok := q.result.(*mysql.Result); ok {
+		// the result is not used once written to the client
+		defer rlt.Release()
+		if len(rlt.Fields) > 0 {
+			if err := advertiseMaxAllowedPacket(q.stmt, rlt, l.conf.MaxAllowedPacket); err != nil {
+				log.Warnf("conn %v: failed to advertise max_allowed_packet: %v", c.ID(), err)
+			}
+		}
+		if err := l.writeResults(c, rlt, q.warn, q.inTransaction); err != nil {
+			tracing.RecordErrorSpan(q.span, err)
+			return err
+		}
+		return nil
+	}
+	if err := c.WriteEndResult(c.Capabilities(), false, 0, 0, q.warn); err != nil {
+		log.Errorf("Error writing result to %s: %v", c, err)
+		tracing.RecordErrorSpan(q.span, err)
+		return err
+	}
+	return nil
+}
+
+// execute runs a statement between the pre and post filters of the listener.
+func (l *MysqlListener) execute(ctx context.Context, c *mysql.Conn,
+	run func() (proto.Result, uint16, error)) (result proto.Result, warn uint16, err error) {
+	if l.capture != nil {
+		event := captureEvent(ctx, c)
+		defer func() {
+			event.Duration = time.Since(event.Time)
+			if err != nil {
+				event.Error = err.Error()
+			}
+			l.writeCapture(event)
+		}()
+	}
+	defer func() {
+		var rowsSent, rowsAffected uint64
+		if rlt, ok := result.(*mysql.Result); ok {
+			rowsSent, rowsAffected = uint64(len(rlt.Rows)), rlt.AffectedRows
+		}
+		usage.Record(ctx, rowsSent, rowsAffected, err)
+	}()
+	stmt := proto.QueryStmt(ctx)
+	if prepared := proto.PrepareStmt(ctx); stmt == nil && prepared != nil {
+		stmt = prepared.StmtNode
+	}
+	// the tables are collected before the executor, which may rewrite the statement
+	if stmt != nil {
+		tablestats.Record(proto.Schema(ctx), stmt)
+	}
+	if err = standby.CheckWrite(l.appID, stmt, proto.SqlText(ctx)); err != nil {
+		return nil, 0, err
+	}
+	if err = l.doPreFilter(ctx); err != nil {
+		return nil, 0, err
+	}
+	// the client sessions are only known to the listener
+	if show, ok := stmt.(*ast.ShowStmt); ok && show.Tp == ast.ShowProcessList {
+		binary := proto.CommandType(ctx) == constant.ComStmtExecute
+		run = func() (proto.Result, uint16, error) {
+			return l.showProcessList(show.Full, binary), 0, nil
+		}
+	}
+	result, warn, err = run()
+	if err = l.doPostFilter(ctx, result, err); err != nil {
+		if rlt, ok := result.(*mysql.Result); ok {
+			rlt.Release()
+		}
+		return nil, 0, err
+	}
+	if l.resultLimit != nil {
+		if warn, err = l.resultLimit.check(ctx, result, warn); err != nil {
+			result.(*mysql.Result).Release()
+			return nil, 0, err
+		}
+	}
+	return result, warn, nil
+}
+
+func (l *MysqlListener) doPreFilter(ctx context.Context) error {
+	for i := 0; i < len(l.preFilters); i++ {
+		f := l.preFilters[i]
+		err := f.PreHandle(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *MysqlListener) doPostFilter(ctx context.Context, result proto.Result, err error) error {
+	for i := 0; i < len(l.postFilters); i++ {
+		f := l.postFilters[i]
+		err := f.PostHandle(ctx, result, err)
+		if err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// parse parses a single statement and rejects the syntax the configured dialect does not accept.
+func (l *MysqlListener) parse(sql string) (ast.StmtNode, error) {
+	p := parser.New()
+	p.EnableWindowFunc(l.conf.Dialect != visitor.DialectMySQL57)
+	stmt, err := p.ParseOneStmt(sql, "", "")
+	if err != nil {
+		return nil, err2.NewParseError(err)
+	}
+	if l.conf.Dialect == visitor.DialectMySQL57 {
+		v := &visitor.DialectVisitor{Dialect: l.conf.Dialect}
+		stmt.Accept(v)
+		if v.Err != nil {
+			return nil, err2.NewParseError(v.Err)
+		}
+	}
+	return stmt, nil
+}
+
+// shouldPassthrough reports whether a statement that failed to parse can be sent to the master as is.
+// Statements rejected by the dialect check are not, since the backend would reject them too.
+func (l *MysqlListener) shouldPassthrough(sql string, parseErr error) bool {
+	if !l.conf.PassthroughUnparsed || errors.Cause(parseErr) == visitor.ErrUnsupportedSyntax {
+		return false
+	}
+	return misc.IsPassthroughSafe(sql)
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/filter"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+type listenerTestFilter struct {
+	rejectErr   error
+	postHandled int
+}
+
+func (f *listenerTestFilter) GetKind() string {
+	return "ListenerTestFilter"
+}
+
+func (f *listenerTestFilter) PreHandle(ctx context.Context) error {
+	return f.rejectErr
+}
+
+func (f *listenerTestFilter) PostHandle(ctx context.Context, result proto.Result, err error) error {
+	f.postHandled++
+	return err
+}
+
+type listenerTestRowsFilter struct {
+	listenerTestFilter
+}
+
+func (f *listenerTestRowsFilter) DecodeResultRows() bool {
+	return true
+}
+
+func TestMysqlListenerFilters(t *testing.T) {
+	const appID = "listener-filters-test"
+	allow, reject := &listenerTestFilter{}, &listenerTestFilter{rejectErr: errors.New("read only listener")}
+	filter.RegisterFilter(appID, "allow", allow)
+	filter.RegisterFilter(appID, "reject", reject)
+	filter.RegisterFilter(appID, "rows", &listenerTestRowsFilter{})
+
+	newListener := func(filters ...string) (*MysqlListener, error) {
+		l, err := NewMysqlListener(&config.Listener{
+			AppID:         appID,
+			ProtocolType:  config.Mysql,
+			SocketAddress: config.SocketAddress{Address: "127.0.0.1"},
+			Config:        map[string]interface{}{"server_version": "8.0.27"},
+			Filters:       filters,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return l.(*MysqlListener), nil
+	}
+
+	_, err := newListener("allow", "rows")
+	assert.Error(t, err)
+
+	oltp, err := newListener("allow")
+	assert.NoError(t, err)
+	defer oltp.Close()
+	analytics, err := newListener("allow", "reject")
+	assert.NoError(t, err)
+	defer analytics.Close()
+	assert.Equal(t, oltp.connectionID, analytics.connectionID)
+
+	executed := 0
+	run := func() (proto.Result, uint16, error) {
+		executed++
+		return &mysql.Result{AffectedRows: 1}, 0, nil
+	}
+	result, _, err := oltp.execute(context.Background(), nil, run)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), result.(*mysql.Result).AffectedRows)
+	assert.Equal(t, 1, executed)
+	assert.Equal(t, 1, allow.postHandled)
+
+	_, _, err = analytics.execute(context.Background(), nil, run)
+	assert.EqualError(t, err, "read only listener")
+	assert.Equal(t, 1, executed)
+	assert.Equal(t, 1, allow.postHandled)
+}
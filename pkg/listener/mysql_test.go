@@ -0,0 +1,55 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/packet"
+)
+
+func TestWriteOKPacketSessionTrack(t *testing.T) {
+	l := &MysqlListener{}
+	sessionState := []byte{0x00, 0x03, 0x02, 'd', 'b'}
+	for _, capabilities := range []uint32{constant.CapabilityClientSessionTrack, 0} {
+		server, client := net.Pipe()
+		conn := mysql.NewConn(server)
+		// every connection keeps the capabilities negotiated by its own handshake
+		conn.SetCapabilities(capabilities)
+
+		response := make(chan []byte)
+		go func() {
+			data, _ := mysql.NewConn(client).ReadPacket()
+			response <- data
+		}()
+		assert.NoError(t, l.writeOKPacket(conn, 1, 0, conn.StatusFlags(), 0, sessionState))
+		_, _, _, _, state, err := packet.ParseOKPacketWithSessionState(<-response)
+		assert.NoError(t, err)
+		if capabilities == 0 {
+			assert.Nil(t, state)
+		} else {
+			assert.Equal(t, sessionState, state)
+		}
+		conn.Close()
+		client.Close()
+	}
+}
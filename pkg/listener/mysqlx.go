@@ -0,0 +1,576 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/atomic"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/constant"
+	err2 "github.com/cectc/dbpack/pkg/errors"
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/mysqlx"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/tracing"
+	"github.com/cectc/dbpack/pkg/visitor"
+)
+
+// mysqlxMechanism is the only authentication mechanism of the listener, the one of the mysql_native_password
+// accounts. PLAIN needs TLS and SHA256_MEMORY the caching_sha2_password cache, neither of which the listener has.
+const mysqlxMechanism = "MYSQL41"
+
+type MysqlxConfig struct {
+	// Users maps user names to passwords, as those of the mysql listener
+	Users map[string]string `yaml:"users" json:"users"`
+	// ServerVersion and Dialect choose the syntax the statements are parsed with, as those of the mysql listener
+	ServerVersion string `yaml:"server_version" json:"server_version"`
+	Dialect       string `yaml:"dialect" json:"dialect"`
+	// MaxAllowedPacket is the max size of the messages of the clients, defaults to 64MB as mysqlx_max_allowed_packet
+	MaxAllowedPacket int `yaml:"max_allowed_packet" json:"max_allowed_packet"`
+}
+
+// MysqlxListener serves the X Protocol, the protocol of the X DevAPI connectors and mysqlsh on port 33060.
+// The SQL statements go through the filters of the listener and the executors as those of the mysql listener
+// do, with their args bound as those of the prepared statements. The CRUD messages of the document store,
+// the prepared statements and the cursors of the X Protocol are not supported, nor is TLS.
+type MysqlxListener struct {
+	conf     MysqlxConfig
+	listener net.Listener
+	closed   *atomic.Bool
+
+	listenerExecutors
+
+	runner *statementRunner
+}
+
+func NewMysqlxListener(conf *config.Listener) (proto.Listener, error) {
+	var (
+		err     error
+		content []byte
+		cfg     MysqlxConfig
+	)
+
+	if content, err = json.Marshal(conf.Config); err != nil {
+		return nil, errors.Wrap(err, "marshal mysqlx listener config failed.")
+	}
+	if err = json.Unmarshal(content, &cfg); err != nil {
+		log.Errorf("unmarshal mysqlx listener config failed, %s", err)
+		return nil, err
+	}
+	if cfg.Dialect, err = visitor.ResolveDialect(cfg.Dialect, cfg.ServerVersion); err != nil {
+		return nil, err
+	}
+	if cfg.MaxAllowedPacket < 0 {
+		return nil, errors.Errorf("max_allowed_packet must not be negative, got %d", cfg.MaxAllowedPacket)
+	}
+	if cfg.MaxAllowedPacket == 0 {
+		cfg.MaxAllowedPacket = mysqlx.DefaultMaxMessageSize
+	}
+	runner, err := newStatementRunner(conf, cfg.Dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := net.Listen("tcp", conf.SocketAddress.String())
+	if err != nil {
+		log.Errorf("listen %s error, %s", conf.SocketAddress.String(), err)
+		return nil, err
+	}
+	return &MysqlxListener{
+		conf:     cfg,
+		listener: l,
+		closed:   atomic.NewBool(false),
+		runner:   runner,
+	}, nil
+}
+
+// Addr returns the address the listener accepts connections on.
+func (l *MysqlxListener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+func (l *MysqlxListener) Listen() {
+	log.Infof("start mysqlx listener %s", l.listener.Addr())
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			if !l.closed.Load() {
+				log.Error(err)
+			}
+			return
+		}
+		go l.handle(conn, connectionIDs.Inc())
+	}
+}
+
+func (l *MysqlxListener) Close() {
+	l.closed.Store(true)
+	if err := l.listener.Close(); err != nil {
+		log.Error(err)
+	}
+}
+
+func (l *MysqlxListener) handle(conn net.Conn, connectionID uint32) {
+	defer conn.Close()
+
+	s := &mysqlxSession{
+		listener:     l,
+		connectionID: connectionID,
+		conn:         conn,
+		reader:       bufio.NewReader(conn),
+		writer:       bufio.NewWriter(conn),
+	}
+	defer s.closeSession()
+	s.serve()
+}
+
+// mysqlxSession serves a client connection.
+type mysqlxSession struct {
+	listener     *MysqlxListener
+	connectionID uint32
+	conn         net.Conn
+	reader       *bufio.Reader
+	writer       *bufio.Writer
+	buf          []byte
+
+	// salt is the challenge of the authentication in progress
+	salt []byte
+	// ctx and executor are those of the session once authenticated
+	ctx      context.Context
+	executor proto.Executor
+}
+
+func (s *mysqlxSession) serve() {
+	for {
+		typ, payload, err := mysqlx.ReadMessage(s.reader, s.listener.conf.MaxAllowedPacket)
+		if err != nil {
+			if errors.Is(err, mysqlx.ErrMessageTooBig) {
+				s.writeError(mysqlx.SeverityFatal, constant.ERNetPacketTooLarge, constant.SSNetPacketTooLarge,
+					fmt.Sprintf("Message exceeds the limit of %d bytes", s.listener.conf.MaxAllowedPacket))
+				s.flush()
+			} else if err != io.EOF {
+				log.Debugf("read mysqlx message failed, connection id: %d, error: %v", s.connectionID, err)
+			}
+			return
+		}
+		next := s.dispatch(typ, payload)
+		if !s.flush() || !next {
+			return
+		}
+	}
+}
+
+// dispatch replies a message, it returns false if the connection must be closed.
+func (s *mysqlxSession) dispatch(typ byte, payload []byte) bool {
+	switch typ {
+	case mysqlx.ClientConCapabilitiesGet:
+		s.capabilitiesGet()
+	case mysqlx.ClientConCapabilitiesSet:
+		s.capabilitiesSet(payload)
+	case mysqlx.ClientConClose:
+		s.writeOk("bye!")
+		return false
+	case mysqlx.ClientSessAuthenticateStart:
+		if s.executor != nil {
+			return s.unexpected(typ)
+		}
+		s.authenticateStart(payload)
+	case mysqlx.ClientSessAuthenticateContinue:
+		if s.executor != nil || s.salt == nil {
+			return s.unexpected(typ)
+		}
+		s.authenticateContinue(payload)
+	default:
+		if s.executor == nil {
+			return s.unexpected(typ)
+		}
+		return s.dispatchSession(typ, payload)
+	}
+	return true
+}
+
+// dispatchSession replies a message of an authenticated session.
+func (s *mysqlxSession) dispatchSession(typ byte, payload []byte) bool {
+	switch typ {
+	case mysqlx.ClientSQLStmtExecute:
+		s.stmtExecute(payload)
+	case mysqlx.ClientExpectOpen, mysqlx.ClientExpectClose:
+		// the pipelined messages are replied one by one whatever they expect
+		s.writeOk("")
+	case mysqlx.ClientSessReset:
+		// the session is kept open, only the state of its connection is reset
+		s.executor.ConnectionClose(s.ctx)
+		s.writeOk("")
+	case mysqlx.ClientSessClose:
+		s.closeSession()
+		s.writeOk("bye!")
+	case mysqlx.ClientCrudFind, mysqlx.ClientCrudInsert, mysqlx.ClientCrudUpdate, mysqlx.ClientCrudDelete,
+		mysqlx.ClientCrudCreateView, mysqlx.ClientCrudModifyView, mysqlx.ClientCrudDropView,
+		mysqlx.ClientPreparePrepare, mysqlx.ClientPrepareExecute, mysqlx.ClientPrepareDeallocate,
+		mysqlx.ClientCursorOpen, mysqlx.ClientCursorClose, mysqlx.ClientCursorFetch:
+		s.writeError(mysqlx.SeverityError, mysqlx.CodeBadMessage, constant.SSUnknownSQLState,
+			fmt.Sprintf("Message %d is not supported by dbpack, send SQL statements instead", typ))
+	default:
+		return s.unexpected(typ)
+	}
+	return true
+}
+
+// unexpected replies a message which is not expected in the state of the session, the connection is closed.
+func (s *mysqlxSession) unexpected(typ byte) bool {
+	s.writeError(mysqlx.SeverityFatal, mysqlx.CodeBadMessage, constant.SSUnknownSQLState,
+		fmt.Sprintf("Unexpected message %d received", typ))
+	return false
+}
+
+func (s *mysqlxSession) capabilitiesGet() {
+	names := []string{"authentication.mechanisms", "doc.formats", "node_type"}
+	s.write(mysqlx.ServerConnCapabilities, mysqlx.AppendCapabilities(s.buf[:0], names, map[string]interface{}{
+		"authentication.mechanisms": []string{mysqlxMechanism},
+		"doc.formats":               "text",
+		"node_type":                 "mysql",
+	}))
+}
+
+// capabilitiesSet accepts the capabilities of the clients but tls, which the listener does not support.
+func (s *mysqlxSession) capabilitiesSet(payload []byte) {
+	capabilities, err := mysqlx.ParseCapabilitiesSet(payload)
+	if err != nil {
+		s.writeError(mysqlx.SeverityError, mysqlx.CodeBadMessage, constant.SSUnknownSQLState, err.Error())
+		return
+	}
+	if tls, ok := capabilities["tls"]; ok && tls != false {
+		s.writeError(mysqlx.SeverityError, mysqlx.CodeCapabilitiesPrepareFailed, constant.SSUnknownSQLState,
+			"Capability prepare failed for 'tls'")
+		return
+	}
+	s.writeOk("")
+}
+
+func (s *mysqlxSession) authenticateStart(payload []byte) {
+	start, err := mysqlx.ParseAuthenticateStart(payload)
+	if err != nil {
+		s.writeError(mysqlx.SeverityError, mysqlx.CodeBadMessage, constant.SSUnknownSQLState, err.Error())
+		return
+	}
+	if start.MechName != mysqlxMechanism {
+		s.writeError(mysqlx.SeverityError, constant.ERNotSupportedYet, constant.SSUnknownSQLState,
+			fmt.Sprintf("Invalid authentication method %s", start.MechName))
+		return
+	}
+	if s.salt, err = newSalt(); err != nil {
+		s.writeError(mysqlx.SeverityFatal, constant.ERUnknownError, constant.SSUnknownSQLState, err.Error())
+		return
+	}
+	s.write(mysqlx.ServerSessAuthenticateContinue, mysqlx.AppendAuthenticateContinue(s.buf[:0], s.salt))
+}
+
+// authenticateContinue checks the response of the client to the salt, which is the schema, the user and
+// the scrambled password, hex encoded after a '*', separated by '\0'. The password is empty if the user has none.
+func (s *mysqlxSession) authenticateContinue(payload []byte) {
+	salt := s.salt
+	s.salt = nil
+	authData, err := mysqlx.ParseAuthenticateContinue(payload)
+	if err != nil {
+		s.writeError(mysqlx.SeverityError, mysqlx.CodeBadMessage, constant.SSUnknownSQLState, err.Error())
+		return
+	}
+	parts := strings.SplitN(string(authData), "\x00", 3)
+	if len(parts) != 3 {
+		s.writeError(mysqlx.SeverityError, constant.ERAccessDeniedError, constant.SSAccessDeniedError,
+			"Invalid authentication data")
+		return
+	}
+	schema, user, response := parts[0], parts[1], parts[2]
+	password, ok := s.listener.conf.Users[user]
+	var expected string
+	if password != "" {
+		expected = "*" + strings.ToUpper(hex.EncodeToString(scramblePassword(salt, password)))
+	}
+	if !ok || subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToUpper(response))) != 1 {
+		s.writeError(mysqlx.SeverityError, constant.ERAccessDeniedError, constant.SSAccessDeniedError,
+			fmt.Sprintf("Access denied for user '%s'", user))
+		return
+	}
+
+	executor := s.listener.schemaExecutor(schema)
+	if executor == nil {
+		s.writeSQLError(mysqlx.SeverityError, noSchemaExecutorError(schema))
+		return
+	}
+	ctx := proto.WithVariableMap(context.Background())
+	ctx = proto.WithConnectionID(ctx, s.connectionID)
+	ctx = proto.WithUserName(ctx, user)
+	ctx = proto.WithRemoteAddr(ctx, s.conn.RemoteAddr().String())
+	ctx = proto.WithSchema(ctx, schema)
+	s.ctx, s.executor = ctx, executor
+
+	s.write(mysqlx.ServerNotice,
+		mysqlx.AppendSessionStateChanged(s.buf[:0], mysqlx.ParamClientIDAssigned, uint64(s.connectionID)))
+	s.write(mysqlx.ServerSessAuthenticateOk, nil)
+}
+
+// closeSession closes the connection of the session for the executor, which rolls back the transaction left by it.
+func (s *mysqlxSession) closeSession() {
+	if s.executor != nil {
+		s.executor.ConnectionClose(s.ctx)
+		s.ctx, s.executor = nil, nil
+	}
+}
+
+// stmtExecute runs a statement of the sql namespace, the admin commands of the mysqlx namespace
+// but ping are not supported.
+func (s *mysqlxSession) stmtExecute(payload []byte) {
+	stmt, err := mysqlx.ParseStmtExecute(payload)
+	if err != nil {
+		s.writeError(mysqlx.SeverityError, mysqlx.CodeBadMessage, constant.SSUnknownSQLState, err.Error())
+		return
+	}
+	switch stmt.Namespace {
+	case "sql":
+	case "mysqlx", "xplugin":
+		if stmt.Stmt == "ping" {
+			s.write(mysqlx.ServerSQLStmtExecuteOk, nil)
+			return
+		}
+		s.writeError(mysqlx.SeverityError, mysqlx.CodeInvalidAdminCommand, constant.SSUnknownSQLState,
+			fmt.Sprintf("Invalid %s command %s", stmt.Namespace, stmt.Stmt))
+		return
+	default:
+		s.writeError(mysqlx.SeverityError, mysqlx.CodeInvalidNamespace, constant.SSUnknownSQLState,
+			fmt.Sprintf("Unknown namespace %s", stmt.Namespace))
+		return
+	}
+
+	args := make([]interface{}, 0, len(stmt.Args))
+	for i, arg := range stmt.Args {
+		bindVar, ok := mysqlxBindVar(arg)
+		if !ok {
+			s.writeError(mysqlx.SeverityError, mysqlx.CodeCmdArgumentType, constant.SSUnknownSQLState,
+				fmt.Sprintf("Invalid type of argument %d, expected a scalar", i))
+			return
+		}
+		args = append(args, bindVar)
+	}
+	node, err := s.listener.runner.parse(stmt.Stmt)
+	if err != nil {
+		s.writeSQLError(mysqlx.SeverityError, err)
+		return
+	}
+	result, err := s.listener.runner.execute(s.ctx, s.executor, tracing.MysqlxListenerStmtExecute, stmt.Stmt, node, args)
+	if err != nil {
+		s.writeSQLError(mysqlx.SeverityError, err)
+		return
+	}
+	if err = s.writeResult(result); err != nil {
+		s.writeSQLError(mysqlx.SeverityError, err)
+	}
+}
+
+// writeResult writes the columns and the rows of result if it has any, then the affected rows and the last
+// insert id. The result is released once written.
+func (s *mysqlxSession) writeResult(result proto.Result) error {
+	if rlt, ok := result.(*mysql.Result); ok {
+		defer rlt.Release()
+		if len(rlt.Fields) > 0 {
+			columns := make([]*mysqlx.Column, 0, len(rlt.Fields))
+			for _, field := range rlt.Fields {
+				column := mysqlxColumn(field)
+				columns = append(columns, column)
+				s.write(mysqlx.ServerResultsetColumnMetaData, mysqlx.AppendColumnMetaData(s.buf[:0], column))
+			}
+			fields := make([][]byte, len(columns))
+			for _, row := range rlt.Rows {
+				values, err := row.Decode()
+				if err != nil {
+					return err
+				}
+				for i, value := range values {
+					if fields[i], err = mysqlxField(columns[i], value); err != nil {
+						return err
+					}
+				}
+				s.write(mysqlx.ServerResultsetRow, mysqlx.AppendRow(s.buf[:0], fields))
+			}
+			s.write(mysqlx.ServerResultsetFetchDone, nil)
+		}
+	}
+	var affectedRows, lastInsertID uint64
+	if result != nil {
+		affectedRows, _ = result.RowsAffected()
+		lastInsertID, _ = result.LastInsertId()
+	}
+	s.write(mysqlx.ServerNotice, mysqlx.AppendSessionStateChanged(s.buf[:0], mysqlx.ParamRowsAffected, affectedRows))
+	if lastInsertID != 0 {
+		s.write(mysqlx.ServerNotice, mysqlx.AppendSessionStateChanged(s.buf[:0], mysqlx.ParamGeneratedInsertID, lastInsertID))
+	}
+	s.write(mysqlx.ServerSQLStmtExecuteOk, nil)
+	return nil
+}
+
+// mysqlxColumn converts the definition of a column, the integers and the floats are typed as such, the other
+// values are sent as bytes, the decimals and the temporal values included, as they are in the text protocol.
+func mysqlxColumn(field *mysql.Field) *mysqlx.Column {
+	column := &mysqlx.Column{
+		Type:          mysqlx.TypeBytes,
+		Name:          field.Name,
+		OriginalName:  field.OrgName,
+		Table:         field.Table,
+		OriginalTable: field.OrgTable,
+		Schema:        field.Database,
+		Length:        field.ColumnLength,
+	}
+	switch field.FieldType {
+	case constant.FieldTypeTiny, constant.FieldTypeShort, constant.FieldTypeInt24, constant.FieldTypeLong,
+		constant.FieldTypeLongLong, constant.FieldTypeYear:
+		column.Type = mysqlx.TypeSint
+		if field.Flags&constant.UnsignedFlag != 0 {
+			column.Type = mysqlx.TypeUint
+		}
+	case constant.FieldTypeFloat:
+		column.Type = mysqlx.TypeFloat
+		column.FractionalDigits = uint32(field.Decimals)
+	case constant.FieldTypeDouble:
+		column.Type = mysqlx.TypeDouble
+		column.FractionalDigits = uint32(field.Decimals)
+	case constant.FieldTypeJSON:
+		column.ContentType = mysqlx.ContentTypeJSON
+	case constant.FieldTypeGeometry:
+		column.ContentType = mysqlx.ContentTypeGeometry
+	}
+	if column.Type == mysqlx.TypeBytes {
+		column.Collation = uint64(field.CharSet)
+	}
+	return column
+}
+
+// mysqlxField encodes a value of a row as a value of column, the values of the text protocol are parsed.
+func mysqlxField(column *mysqlx.Column, value *proto.Value) ([]byte, error) {
+	if value == nil || value.Val == nil {
+		return nil, nil
+	}
+	val := value.Val
+	if b, ok := val.([]byte); ok && column.Type != mysqlx.TypeBytes {
+		var err error
+		switch column.Type {
+		case mysqlx.TypeSint:
+			val, err = strconv.ParseInt(string(b), 10, 64)
+		case mysqlx.TypeUint:
+			val, err = strconv.ParseUint(string(b), 10, 64)
+		default:
+			val, err = strconv.ParseFloat(string(b), 64)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "convert value of column %s failed", column.Name)
+		}
+	}
+	switch v := val.(type) {
+	case int64:
+		if column.Type == mysqlx.TypeUint {
+			return mysqlx.EncodeUint(uint64(v)), nil
+		}
+		return mysqlx.EncodeSint(v), nil
+	case uint64:
+		if column.Type == mysqlx.TypeSint {
+			return mysqlx.EncodeSint(int64(v)), nil
+		}
+		return mysqlx.EncodeUint(v), nil
+	case float32:
+		if column.Type == mysqlx.TypeFloat {
+			return mysqlx.EncodeFloat(v), nil
+		}
+		return mysqlx.EncodeDouble(float64(v)), nil
+	case float64:
+		if column.Type == mysqlx.TypeFloat {
+			return mysqlx.EncodeFloat(float32(v)), nil
+		}
+		return mysqlx.EncodeDouble(v), nil
+	case []byte:
+		return mysqlx.EncodeBytes(v), nil
+	case string:
+		return mysqlx.EncodeBytes([]byte(v)), nil
+	case time.Time:
+		return mysqlx.EncodeBytes([]byte(v.Format("2006-01-02 15:04:05.999999"))), nil
+	default:
+		return mysqlx.EncodeBytes([]byte(fmt.Sprint(v))), nil
+	}
+}
+
+// mysqlxBindVar converts an arg into a bind var, typed as those of the prepared statements of the mysql protocol.
+func mysqlxBindVar(arg interface{}) (interface{}, bool) {
+	switch v := arg.(type) {
+	case nil, int64, uint64, float64, []byte:
+		return v, true
+	case bool:
+		if v {
+			return int64(1), true
+		}
+		return int64(0), true
+	case string:
+		return []byte(v), true
+	default:
+		return nil, false
+	}
+}
+
+func (s *mysqlxSession) write(typ byte, payload []byte) {
+	// the buffer is reused by the following messages, WriteMessage copies it
+	s.buf = payload
+	if err := mysqlx.WriteMessage(s.writer, typ, payload); err != nil {
+		log.Debugf("write mysqlx message failed, connection id: %d, error: %v", s.connectionID, err)
+	}
+}
+
+func (s *mysqlxSession) writeOk(msg string) {
+	s.write(mysqlx.ServerOk, mysqlx.AppendOk(s.buf[:0], msg))
+}
+
+func (s *mysqlxSession) writeError(severity uint32, code uint32, sqlState, msg string) {
+	s.write(mysqlx.ServerError, mysqlx.AppendError(s.buf[:0], severity, code, sqlState, msg))
+}
+
+// writeSQLError writes an error as the mysql listener does, with its number and its state if it is a SQLError.
+func (s *mysqlxSession) writeSQLError(severity uint32, err error) {
+	if se, ok := errors.Cause(err).(*err2.SQLError); ok {
+		s.writeError(severity, uint32(se.Num), se.State, se.Message)
+		return
+	}
+	s.writeError(severity, constant.ERUnknownError, constant.SSUnknownSQLState, fmt.Sprintf("unknown error: %v", err))
+}
+
+// flush writes the buffered messages, it returns false if the connection is broken.
+func (s *mysqlxSession) flush() bool {
+	if err := s.writer.Flush(); err != nil {
+		log.Debugf("write mysqlx reply failed, connection id: %d, error: %v", s.connectionID, err)
+		return false
+	}
+	return true
+}
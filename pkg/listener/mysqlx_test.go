@@ -0,0 +1,146 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"bufio"
+	"encoding/hex"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/mysqlx"
+)
+
+type mysqlxTestClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+func (c *mysqlxTestClient) send(typ byte, payload []byte) {
+	assert.Nil(c.t, mysqlx.WriteMessage(c.writer, typ, payload))
+	assert.Nil(c.t, c.writer.Flush())
+}
+
+func (c *mysqlxTestClient) receive(expected byte) []byte {
+	typ, payload, err := mysqlx.ReadMessage(c.reader, mysqlx.DefaultMaxMessageSize)
+	assert.Nil(c.t, err)
+	assert.Equal(c.t, expected, typ)
+	return payload
+}
+
+func (c *mysqlxTestClient) receiveError(expected uint32) {
+	code, _, msg, err := mysqlx.ParseError(c.receive(mysqlx.ServerError))
+	assert.Nil(c.t, err)
+	assert.Equal(c.t, expected, code, msg)
+}
+
+func (c *mysqlxTestClient) authenticate(user, password string) {
+	c.send(mysqlx.ClientSessAuthenticateStart, mysqlx.AppendAuthenticateStart(nil, &mysqlx.AuthenticateStart{MechName: "MYSQL41"}))
+	salt, err := mysqlx.ParseAuthenticateContinue(c.receive(mysqlx.ServerSessAuthenticateContinue))
+	assert.Nil(c.t, err)
+	assert.Len(c.t, salt, 20)
+	c.send(mysqlx.ClientSessAuthenticateContinue, mysqlx.AppendAuthenticateContinue(nil,
+		[]byte("\x00"+user+"\x00*"+hex.EncodeToString(scramblePassword(salt, password)))))
+}
+
+func TestMysqlxListener(t *testing.T) {
+	l, err := NewMysqlxListener(&config.Listener{
+		ProtocolType:  config.Mysqlx,
+		SocketAddress: config.SocketAddress{Address: "127.0.0.1"},
+		Config: map[string]interface{}{
+			"users": map[string]string{"dksl": "123456"},
+		},
+	})
+	assert.Nil(t, err)
+	mysqlxListener := l.(*MysqlxListener)
+	executor := newGrpcTestExecutor()
+	mysqlxListener.SetExecutor(executor)
+	go mysqlxListener.Listen()
+	defer mysqlxListener.Close()
+
+	conn, err := net.Dial("tcp", mysqlxListener.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	c := &mysqlxTestClient{t: t, conn: conn, reader: bufio.NewReader(conn), writer: bufio.NewWriter(conn)}
+
+	c.send(mysqlx.ClientConCapabilitiesGet, nil)
+	c.receive(mysqlx.ServerConnCapabilities)
+	c.send(mysqlx.ClientConCapabilitiesSet, []byte{0x0a, 0x0f, 0x0a, 0x0d,
+		0x0a, 0x03, 't', 'l', 's', 0x12, 0x06, 0x08, 0x01, 0x12, 0x02, 0x08, 0x07})
+	c.receiveError(mysqlx.CodeCapabilitiesPrepareFailed)
+
+	c.authenticate("dksl", "654321")
+	c.receiveError(1045)
+	c.authenticate("dksl", "123456")
+	c.receive(mysqlx.ServerNotice)
+	c.receive(mysqlx.ServerSessAuthenticateOk)
+
+	c.send(mysqlx.ClientSQLStmtExecute, mysqlx.AppendStmtExecute(nil, &mysqlx.StmtExecute{
+		Stmt: "SELECT id, name FROM student WHERE id > ?",
+		Args: []interface{}{int64(0)},
+	}))
+	c.receive(mysqlx.ServerResultsetColumnMetaData)
+	c.receive(mysqlx.ServerResultsetColumnMetaData)
+	row, err := mysqlx.ParseRow(c.receive(mysqlx.ServerResultsetRow))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{mysqlx.EncodeSint(1), mysqlx.EncodeBytes([]byte("scott"))}, row)
+	row, err = mysqlx.ParseRow(c.receive(mysqlx.ServerResultsetRow))
+	assert.Nil(t, err)
+	assert.Equal(t, mysqlx.EncodeSint(2), row[0])
+	assert.Empty(t, row[1])
+	c.receive(mysqlx.ServerResultsetFetchDone)
+	c.receive(mysqlx.ServerNotice)
+	c.receive(mysqlx.ServerSQLStmtExecuteOk)
+	assert.Equal(t, []interface{}{int64(0)}, executor.bindVars)
+
+	c.send(mysqlx.ClientSQLStmtExecute, mysqlx.AppendStmtExecute(nil, &mysqlx.StmtExecute{
+		Stmt: "INSERT INTO student (name) VALUES ('a')",
+	}))
+	c.receive(mysqlx.ServerNotice)
+	c.receive(mysqlx.ServerNotice)
+	c.receive(mysqlx.ServerSQLStmtExecuteOk)
+
+	c.send(mysqlx.ClientSQLStmtExecute, mysqlx.AppendStmtExecute(nil, &mysqlx.StmtExecute{Stmt: "SELEC 1"}))
+	c.receive(mysqlx.ServerError)
+	c.send(mysqlx.ClientSQLStmtExecute, mysqlx.AppendStmtExecute(nil, &mysqlx.StmtExecute{Stmt: "ping", Namespace: "mysqlx"}))
+	c.receive(mysqlx.ServerSQLStmtExecuteOk)
+	c.send(mysqlx.ClientCrudFind, nil)
+	c.receiveError(mysqlx.CodeBadMessage)
+
+	c.send(mysqlx.ClientConClose, nil)
+	c.receive(mysqlx.ServerOk)
+	_, _, err = mysqlx.ReadMessage(c.reader, mysqlx.DefaultMaxMessageSize)
+	assert.Equal(t, io.EOF, err)
+
+	// the session is closed once the connection is
+	closed := func() bool {
+		executor.mu.Lock()
+		defer executor.mu.Unlock()
+		return len(executor.closed) == 1
+	}
+	assert.Eventually(t, closed, time.Second, 10*time.Millisecond)
+	executor.mu.Lock()
+	defer executor.mu.Unlock()
+	assert.Len(t, executor.statements, 1)
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"context"
+	"sync"
+
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+// readPipelinedQueries reads the COM_QUERY packets the client sent without waiting for the
+// response to the previous command, as long as they are buffered.
+func readPipelinedQueries(c *mysql.Conn) ([]string, error) {
+	var queries []string
+	for c.BufferedQuery() {
+		c.ResetSequence()
+		data, err := c.ReadEphemeralPacket()
+		if err != nil {
+			c.RecycleReadPacket()
+			log.Warnf("conn %v: failed to read pipelined query: %v", c.ID(), err)
+			return nil, err
+		}
+		queries = append(queries, string(data[1:]))
+		c.RecycleReadPacket()
+	}
+	return queries, nil
+}
+
+// executePipeline serves the queries a client pipelined in order, and answers them in order.
+// The consecutive reads the executor reports independent run concurrently, at most
+// PipelinedReads at once, the other statements run once the statements before them completed,
+// as the session state they depend on or change is that of the statements before them.
+func (l *MysqlListener) executePipeline(c *mysql.Conn, queries []string, sequence uint64,
+	newContext func(sequence uint64) context.Context) error {
+	c.StartWriterBuffering()
+	defer func() {
+		if err := c.EndWriterBuffering(); err != nil {
+			log.Errorf("conn %v: flush() failed: %v", c.ID(), err)
+		}
+	}()
+
+	executor := l.connectionExecutor(c.ID())
+	concurrent, _ := executor.(proto.ConcurrentReadExecutor)
+	independent := func(q *comQuery) bool {
+		return concurrent != nil && q.ctx != nil && q.stmt != nil && concurrent.IndependentRead(q.ctx)
+	}
+	write := func(q *comQuery) error {
+		c.StartResponse()
+		return l.writeQuery(c, q)
+	}
+
+	var next *comQuery
+	for i := 0; i < len(queries); {
+		if next == nil {
+			next = l.parseQuery(newContext(sequence+uint64(i)), c, executor, queries[i])
+		}
+		var reads []*comQuery
+		for next != nil && len(reads) < l.conf.PipelinedReads && independent(next) {
+			reads = append(reads, next)
+			next = nil
+			if i++; i < len(queries) {
+				next = l.parseQuery(newContext(sequence+uint64(i)), c, executor, queries[i])
+			}
+		}
+		if len(reads) == 0 {
+			l.executeQuery(c, executor, next)
+			if err := write(next); err != nil {
+				return err
+			}
+			next = nil
+			i++
+			continue
+		}
+		for _, q := range reads {
+			q.ctx = proto.WithDeferredRecord(q.ctx)
+		}
+		var wg sync.WaitGroup
+		for _, q := range reads[1:] {
+			wg.Add(1)
+			go func(q *comQuery) {
+				defer wg.Done()
+				l.executeQuery(c, executor, q)
+			}(q)
+		}
+		l.executeQuery(c, executor, reads[0])
+		wg.Wait()
+		// the reads complete in any order, the session state they changed is recorded in order
+		for _, q := range reads {
+			proto.RecordDeferred(q.ctx)
+		}
+		for _, q := range reads {
+			if err := write(q); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/misc"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+type pipelineTestExecutor struct {
+	schemaTestExecutor
+	// reads are closed once both reads started, it is only the case if they run concurrently
+	reads     chan struct{}
+	started   sync.WaitGroup
+	lock      sync.Mutex
+	sequences map[string]uint64
+	// recorded are the sequences of the statements in the order their session state is recorded
+	recorded []uint64
+}
+
+func (executor *pipelineTestExecutor) IndependentRead(ctx context.Context) bool {
+	return strings.HasPrefix(proto.SqlText(ctx), "SELECT")
+}
+
+func (executor *pipelineTestExecutor) ExecutorComQuery(ctx context.Context, sql string) (proto.Result, uint16, error) {
+	executor.lock.Lock()
+	executor.sequences[sql] = proto.StatementSequence(ctx)
+	executor.lock.Unlock()
+	var concurrent bool
+	if sql != "UPDATE t SET a = 1" && sql != "SELECT 3" {
+		executor.started.Done()
+		select {
+		case <-executor.reads:
+			concurrent = true
+		case <-time.After(5 * time.Second):
+		}
+	}
+	if concurrent && sql == "SELECT 1" {
+		// the first read completes after the second one
+		time.Sleep(50 * time.Millisecond)
+	}
+	sequence := proto.StatementSequence(ctx)
+	record := func() {
+		executor.lock.Lock()
+		defer executor.lock.Unlock()
+		executor.recorded = append(executor.recorded, sequence)
+	}
+	if !proto.DeferRecord(ctx, record) {
+		record()
+	}
+	result := &mysql.Result{AffectedRows: sequence}
+	if concurrent {
+		result.InsertId = 1
+	}
+	return result, 0, nil
+}
+
+func TestPipelinedReads(t *testing.T) {
+	executor := &pipelineTestExecutor{reads: make(chan struct{}), sequences: make(map[string]uint64)}
+	executor.started.Add(2)
+	go func() {
+		executor.started.Wait()
+		close(executor.reads)
+	}()
+	l := &MysqlListener{conf: MysqlConfig{PipelinedReads: 2}, listenerExecutors: listenerExecutors{executor: executor}, connectionExecutors: &sync.Map{}}
+
+	server, client := net.Pipe()
+	defer client.Close()
+	conn := mysql.NewConn(server)
+	defer conn.Close()
+	conn.SetConnectionID(1)
+
+	queries := []string{"SELECT 1", "SELECT 2", "UPDATE t SET a = 1", "SELECT 3"}
+	responses := make(chan []byte, len(queries))
+	go func() {
+		// the queries are sent at once, before reading any response
+		var pipeline []byte
+		for _, query := range queries {
+			pipeline = append(pipeline, byte(len(query)+1), 0, 0, 0, constant.ComQuery)
+			pipeline = append(pipeline, query...)
+		}
+		_, _ = client.Write(pipeline)
+		clientConn := mysql.NewConn(client)
+		for range queries {
+			clientConn.StartResponse()
+			packet, err := clientConn.ReadPacket()
+			if err != nil {
+				close(responses)
+				return
+			}
+			responses <- packet
+		}
+	}()
+
+	data, err := conn.ReadEphemeralPacket()
+	assert.NoError(t, err)
+	query := string(data[1:])
+	conn.RecycleReadPacket()
+	assert.True(t, conn.BufferedQuery())
+	pipelined, err := readPipelinedQueries(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, queries, append([]string{query}, pipelined...))
+	assert.False(t, conn.BufferedQuery())
+
+	err = l.executePipeline(conn, queries, 10, func(sequence uint64) context.Context {
+		return proto.WithStatementSequence(proto.WithConnectionID(context.Background(), 1), sequence)
+	})
+	assert.NoError(t, err)
+	for i := range queries {
+		packet := <-responses
+		assert.Equal(t, byte(constant.OKPacket), packet[0])
+		affectedRows, pos, _ := misc.ReadLenEncInt(packet, 1)
+		insertID, _, _ := misc.ReadLenEncInt(packet, pos)
+		assert.Equal(t, uint64(10+i), affectedRows, queries[i])
+		if i < 2 {
+			assert.Equal(t, uint64(1), insertID, "%s runs concurrently", queries[i])
+		}
+	}
+	assert.Equal(t, []uint64{10, 11, 12, 13}, executor.recorded)
+}
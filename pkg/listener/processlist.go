@@ -0,0 +1,354 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"encoding/binary"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/resource"
+)
+
+const (
+	// processInfoLength is the length the info of a process is truncated to without FULL
+	processInfoLength = 100
+	// commandSleep is the command of the idle processes
+	commandSleep = "Sleep"
+
+	showProcessList     = "SHOW PROCESSLIST"
+	showFullProcessList = "SHOW FULL PROCESSLIST"
+)
+
+// commandNames are the names of the commands as mysql lists them, indexed by the command byte.
+var commandNames = [constant.ComEnd]string{
+	commandSleep, "Quit", "Init DB", "Query", "Field List", "Create DB", "Drop DB", "Refresh",
+	"Shutdown", "Statistics", "Processlist", "Connect", "Kill", "Debug", "Ping", "Time",
+	"Delayed insert", "Change user", "Binlog Dump", "Table Dump", "Connect Out", "Register Slave",
+	"Prepare", "Execute", "Long Data", "Close stmt", "Reset stmt", "Set option", "Fetch", "Daemon",
+	"Binlog Dump GTID", "Reset Connection",
+}
+
+// sessionListeners maps the connection ids of the client sessions to the mysql listeners serving them,
+// the connection ids are unique across the listeners.
+var sessionListeners sync.Map
+
+// ClientSession is a client session as /sessions/{id} reports it, with the backend connections held for it,
+// which are not available to the other sessions until they are released.
+type ClientSession struct {
+	ID      uint32 `json:"id"`
+	User    string `json:"user"`
+	Host    string `json:"host"`
+	DB      string `json:"db,omitempty"`
+	Command string `json:"command"`
+	// Time is the seconds the session has been in its command
+	Time  int64  `json:"time"`
+	State string `json:"state,omitempty"`
+	Info  string `json:"info,omitempty"`
+
+	PinnedConnections []*PinnedConnection `json:"pinned_connections"`
+}
+
+// PinnedConnection is a backend connection held for a client session.
+type PinnedConnection struct {
+	*proto.PinnedConnection
+	// Time is the seconds the connection has been held
+	Time int64 `json:"time"`
+}
+
+// GetClientSession returns the client session of a connection id, whichever mysql listener serves it.
+func GetClientSession(connectionID uint32) (*ClientSession, bool) {
+	li, ok := sessionListeners.Load(connectionID)
+	if !ok {
+		return nil, false
+	}
+	return li.(*MysqlListener).clientSession(connectionID)
+}
+
+// process is a client session as SHOW PROCESSLIST lists it, updated by the connection as it
+// runs commands and read by the connections listing the processes.
+type process struct {
+	id   uint32
+	user string
+	host string
+
+	mu      sync.Mutex
+	db      string
+	command string
+	since   time.Time
+	state   string
+	info    string
+}
+
+// trackProcess lists the client session of c in SHOW PROCESSLIST until it is closed.
+func (l *MysqlListener) trackProcess(c *mysql.Conn) *process {
+	p := &process{
+		id:      c.ID(),
+		user:    c.UserName(),
+		host:    c.RemoteAddr().String(),
+		db:      c.Schema(),
+		command: commandSleep,
+		since:   time.Now(),
+	}
+	l.processes.Store(p.id, p)
+	sessionListeners.Store(p.id, l)
+	return p
+}
+
+// untrackProcess removes the client session of a closed connection.
+func (l *MysqlListener) untrackProcess(connectionID uint32) {
+	l.processes.Delete(connectionID)
+	sessionListeners.Delete(connectionID)
+}
+
+// beginCommand marks the process running the command of data, the info of a statement is its sql.
+func (l *MysqlListener) beginCommand(p *process, c *mysql.Conn, data []byte) {
+	command, info := data[0], ""
+	switch command {
+	case constant.ComQuery, constant.ComPrepare:
+		info = string(data[1:])
+	case constant.ComStmtExecute:
+		if len(data) >= 5 {
+			if si, ok := l.stmts.Load(binary.LittleEndian.Uint32(data[1:5])); ok {
+				info = si.(*proto.Stmt).SqlText
+			}
+		}
+	}
+	name := "Error"
+	if int(command) < len(commandNames) {
+		name = commandNames[command]
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.db, p.command, p.since, p.state, p.info = c.Schema(), name, time.Now(), "executing", info
+}
+
+// end marks the process idle once its command is done.
+func (p *process) end(c *mysql.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.db, p.command, p.since, p.state, p.info = c.Schema(), commandSleep, time.Now(), "", ""
+}
+
+// showProcessList answers SHOW [FULL] PROCESSLIST with the client sessions of the listener,
+// the info of the processes is truncated to 100 characters without FULL as mysql does.
+func (l *MysqlListener) showProcessList(full, binary bool) *mysql.Result {
+	result := &mysql.Result{Fields: processListFields()}
+	var processes []*process
+	l.processes.Range(func(_, pi interface{}) bool {
+		processes = append(processes, pi.(*process))
+		return true
+	})
+	sort.Slice(processes, func(i, j int) bool {
+		return processes[i].id < processes[j].id
+	})
+	now := time.Now()
+	for _, p := range processes {
+		p.mu.Lock()
+		db, command, since, state, info := p.db, p.command, p.since, p.state, p.info
+		p.mu.Unlock()
+		if !full {
+			info = truncate(info, processInfoLength)
+		}
+		values := []*proto.Value{
+			intValue(constant.FieldTypeLongLong, int64(p.id), binary),
+			stringValue(p.user),
+			stringValue(p.host),
+			nullableValue(db),
+			stringValue(command),
+			intValue(constant.FieldTypeLong, int64(now.Sub(since)/time.Second), binary),
+			stringValue(state),
+			nullableValue(info),
+		}
+		if binary {
+			result.Rows = append(result.Rows, mysql.NewBinaryRow(result.Fields, values))
+		} else {
+			result.Rows = append(result.Rows, mysql.NewTextRow(result.Fields, values))
+		}
+	}
+	result.AffectedRows = uint64(len(result.Rows))
+	return result
+}
+
+// clientSession returns the client session of a connection, with the backend connections its executor
+// holds for it if the executor can tell them.
+func (l *MysqlListener) clientSession(connectionID uint32) (*ClientSession, bool) {
+	pi, ok := l.processes.Load(connectionID)
+	if !ok {
+		return nil, false
+	}
+	p := pi.(*process)
+	now := time.Now()
+	p.mu.Lock()
+	session := &ClientSession{
+		ID:                p.id,
+		User:              p.user,
+		Host:              p.host,
+		DB:                p.db,
+		Command:           p.command,
+		Time:              int64(now.Sub(p.since) / time.Second),
+		State:             p.state,
+		Info:              p.info,
+		PinnedConnections: []*PinnedConnection{},
+	}
+	p.mu.Unlock()
+	if executor, ok := l.connectionExecutor(connectionID).(proto.PinnedConnectionsExecutor); ok {
+		for _, connection := range executor.PinnedConnections(connectionID) {
+			session.PinnedConnections = append(session.PinnedConnections, &PinnedConnection{
+				PinnedConnection: connection,
+				Time:             int64(now.Sub(connection.Since) / time.Second),
+			})
+		}
+	}
+	return session, true
+}
+
+// showBackendProcessList answers SHOW [FULL] BACKEND PROCESSLIST with the processes of the
+// data sources of the listener, each row followed by the name of the data source listing it.
+// The data sources failing to list their processes are left out.
+func (l *MysqlListener) showBackendProcessList(full bool) (proto.Result, uint16, error) {
+	query := showProcessList
+	if full {
+		query = showFullProcessList
+	}
+	dbs, results := l.queryBackends(query)
+
+	result := &mysql.Result{}
+	for i, rlt := range results {
+		if rlt == nil {
+			continue
+		}
+		if result.Fields == nil {
+			result.Fields = append(rlt.Fields[:len(rlt.Fields):len(rlt.Fields)], backendField())
+		}
+		for _, row := range rlt.Rows {
+			values, err := row.Decode()
+			if err != nil {
+				log.Warnf("db %s: failed to decode the backend processes: %v", dbs[i].Name(), err)
+				break
+			}
+			// the values are backed by the buffers of the result, which are released below
+			copied := make([]*proto.Value, 0, len(values)+1)
+			for _, value := range values {
+				copied = append(copied, copyValue(value))
+			}
+			copied = append(copied, stringValue(dbs[i].Name()))
+			result.Rows = append(result.Rows, mysql.NewTextRow(result.Fields, copied))
+		}
+		rlt.Release()
+	}
+	if result.Fields == nil {
+		result.Fields = append(processListFields(), backendField())
+	}
+	result.AffectedRows = uint64(len(result.Rows))
+	return result, 0, nil
+}
+
+// queryBackends runs query on the data sources of the listener concurrently, the result of a data
+// source failing to run it is nil.
+func (l *MysqlListener) queryBackends(query string) ([]proto.DB, []*mysql.Result) {
+	var dbs []proto.DB
+	if manager := resource.GetDBManager(l.appID); manager != nil {
+		dbs = manager.DBs()
+	}
+	results := make([]*mysql.Result, len(dbs))
+	var wg sync.WaitGroup
+	for i, db := range dbs {
+		wg.Add(1)
+		go func(i int, db proto.DB) {
+			defer wg.Done()
+			result, _, err := db.QueryDirectly(query)
+			if err != nil {
+				log.Warnf("db %s: failed to run %s: %v", db.Name(), query, err)
+				return
+			}
+			results[i] = result.(*mysql.Result)
+		}(i, db)
+	}
+	wg.Wait()
+	return dbs, results
+}
+
+func processListFields() []*mysql.Field {
+	return []*mysql.Field{
+		{Name: "Id", FieldType: constant.FieldTypeLongLong, CharSet: constant.CharacterSetBinary,
+			Flags: constant.NotNullFlag | constant.UnsignedFlag},
+		{Name: "User", FieldType: constant.FieldTypeVarString, CharSet: constant.CharacterSetUtf8},
+		{Name: "Host", FieldType: constant.FieldTypeVarString, CharSet: constant.CharacterSetUtf8},
+		{Name: "db", FieldType: constant.FieldTypeVarString, CharSet: constant.CharacterSetUtf8},
+		{Name: "Command", FieldType: constant.FieldTypeVarString, CharSet: constant.CharacterSetUtf8},
+		{Name: "Time", FieldType: constant.FieldTypeLong, CharSet: constant.CharacterSetBinary,
+			Flags: constant.NotNullFlag},
+		{Name: "State", FieldType: constant.FieldTypeVarString, CharSet: constant.CharacterSetUtf8},
+		{Name: "Info", FieldType: constant.FieldTypeVarString, CharSet: constant.CharacterSetUtf8},
+	}
+}
+
+// backendField is the column of the backend processes naming the data source listing them.
+func backendField() *mysql.Field {
+	return &mysql.Field{Name: "Backend", FieldType: constant.FieldTypeVarString, CharSet: constant.CharacterSetUtf8}
+}
+
+// truncate cuts s to n characters.
+func truncate(s string, n int) string {
+	for i := range s {
+		if n == 0 {
+			return s[:i]
+		}
+		n--
+	}
+	return s
+}
+
+func intValue(typ constant.FieldType, n int64, binary bool) *proto.Value {
+	text := []byte(strconv.FormatInt(n, 10))
+	value := &proto.Value{Typ: typ, Len: len(text), Val: text, Raw: text}
+	if binary {
+		value.Val = n
+	}
+	return value
+}
+
+func stringValue(s string) *proto.Value {
+	return &proto.Value{Typ: constant.FieldTypeVarString, Len: len(s), Val: []byte(s), Raw: []byte(s)}
+}
+
+// nullableValue is the value of s, NULL if it is empty.
+func nullableValue(s string) *proto.Value {
+	if s == "" {
+		return &proto.Value{Typ: constant.FieldTypeVarString}
+	}
+	return stringValue(s)
+}
+
+func copyValue(value *proto.Value) *proto.Value {
+	if value == nil {
+		return nil
+	}
+	copied := *value
+	if b, ok := value.Val.([]byte); ok {
+		copied.Val = append([]byte(nil), b...)
+	}
+	copied.Raw = append([]byte(nil), value.Raw...)
+	return &copied
+}
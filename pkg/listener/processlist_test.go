@@ -0,0 +1,121 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+func TestShowProcessList(t *testing.T) {
+	l := &MysqlListener{}
+	query := "SELECT * FROM employees WHERE name IN ('" + strings.Repeat("斯科特", 40) + "')"
+	l.processes.Store(uint32(2), &process{id: 2, user: "dksl", host: "127.0.0.1:52312", db: "employees",
+		command: "Query", since: time.Now().Add(-3 * time.Second), state: "executing", info: query})
+	l.processes.Store(uint32(1), &process{id: 1, user: "dksl", host: "127.0.0.1:52310", command: commandSleep,
+		since: time.Now()})
+
+	decode := func(result *mysql.Result) [][]interface{} {
+		var rows [][]interface{}
+		for _, row := range result.Rows {
+			values, err := row.Decode()
+			assert.NoError(t, err)
+			var decoded []interface{}
+			for _, value := range values {
+				if b, ok := value.Val.([]byte); ok {
+					decoded = append(decoded, string(b))
+				} else {
+					decoded = append(decoded, value.Val)
+				}
+			}
+			rows = append(rows, decoded)
+		}
+		return rows
+	}
+
+	result := l.showProcessList(false, false)
+	assert.Equal(t, []string{"Id", "User", "Host", "db", "Command", "Time", "State", "Info"}, mysqlFieldNames(result))
+	truncated := []rune(query)[:processInfoLength]
+	assert.Equal(t, [][]interface{}{
+		{"1", "dksl", "127.0.0.1:52310", nil, "Sleep", "0", "", nil},
+		{"2", "dksl", "127.0.0.1:52312", "employees", "Query", "3", "executing", string(truncated)},
+	}, decode(result))
+
+	result = l.showProcessList(true, true)
+	assert.Equal(t, [][]interface{}{
+		{int64(1), "dksl", "127.0.0.1:52310", nil, "Sleep", int64(0), "", nil},
+		{int64(2), "dksl", "127.0.0.1:52312", "employees", "Query", int64(3), "executing", query},
+	}, decode(result))
+}
+
+func mysqlFieldNames(result *mysql.Result) []string {
+	names := make([]string, 0, len(result.Fields))
+	for _, field := range result.Fields {
+		names = append(names, field.Name)
+	}
+	return names
+}
+
+type pinningTestExecutor struct {
+	proto.Executor
+	connections map[uint32][]*proto.PinnedConnection
+}
+
+func (executor *pinningTestExecutor) PinnedConnections(connectionID uint32) []*proto.PinnedConnection {
+	return executor.connections[connectionID]
+}
+
+func TestGetClientSession(t *testing.T) {
+	since := time.Now().Add(-90 * time.Second)
+	executor := &pinningTestExecutor{connections: map[uint32][]*proto.PinnedConnection{
+		7: {{DataSource: "employees", Reasons: []string{proto.PinReasonTransaction}, Since: since}},
+	}}
+	l := &MysqlListener{listenerExecutors: listenerExecutors{executor: executor}, connectionExecutors: &sync.Map{}}
+	for _, id := range []uint32{7, 8} {
+		l.processes.Store(id, &process{id: id, user: "dksl", host: "127.0.0.1:52312", command: commandSleep,
+			since: time.Now()})
+		sessionListeners.Store(id, l)
+	}
+
+	session, ok := GetClientSession(7)
+	assert.True(t, ok)
+	assert.Equal(t, "dksl", session.User)
+	assert.Len(t, session.PinnedConnections, 1)
+	assert.Equal(t, "employees", session.PinnedConnections[0].DataSource)
+	assert.Equal(t, int64(90), session.PinnedConnections[0].Time)
+
+	b, err := json.Marshal(session)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"pinned_connections":[{"data_source":"employees","thread_id":0,"reasons":["transaction"],"since":`)
+
+	session, ok = GetClientSession(8)
+	assert.True(t, ok)
+	assert.Empty(t, session.PinnedConnections)
+
+	l.untrackProcess(7)
+	_, ok = GetClientSession(7)
+	assert.False(t, ok)
+	l.untrackProcess(8)
+}
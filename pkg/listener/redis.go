@@ -0,0 +1,501 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uber-go/atomic"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/filter"
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/resp"
+	"github.com/cectc/dbpack/pkg/tracing"
+)
+
+const (
+	defaultRedisDialTimeout = 5 * time.Second
+	// redisDefaultUser is the user authenticated by AUTH with a password only
+	redisDefaultUser = "default"
+	// unknownRedisCommand labels the metrics of the commands the backend does not know,
+	// so that clients can not create metrics at will
+	unknownRedisCommand = "unknown"
+)
+
+var (
+	redisCommandLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "dbpack",
+		Subsystem: "redis",
+		Name:      "command_latency",
+		Help:      "The time it took to proxy redis commands, filters included",
+		Buckets:   prometheus.ExponentialBuckets(0.0001 /* 0.1 ms */, 2, 18),
+	}, []string{"listener", "command"})
+	redisCommandErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "dbpack",
+		Subsystem: "redis",
+		Name:      "command_errors",
+		Help:      "count of the redis commands replied with an error, by the backend or by a filter",
+	}, []string{"listener", "command"})
+)
+
+func init() {
+	prometheus.MustRegister(redisCommandLatency, redisCommandErrors)
+}
+
+type RedisConfig struct {
+	// BackendAddress is the address of the redis server the commands are forwarded to
+	BackendAddress string `yaml:"backend_address" json:"backend_address"`
+	// BackendUsername and BackendPassword authenticate the connections to the backend, no
+	// AUTH is sent if BackendPassword is empty, the default user is used if BackendUsername is
+	BackendUsername string `yaml:"backend_username" json:"backend_username"`
+	BackendPassword string `yaml:"backend_password" json:"backend_password"`
+	// BackendDB is the database the connections to the backend select
+	BackendDB int `yaml:"backend_db" json:"backend_db"`
+	// Users maps user names to passwords, the clients must authenticate with AUTH or HELLO before
+	// sending commands unless it is empty. AUTH with a password only authenticates the default user.
+	Users map[string]string `yaml:"users" json:"users"`
+	// DialTimeout bounds connecting and authenticating to the backend, defaults to 5s
+	DialTimeout    time.Duration `yaml:"-" json:"-"`
+	DialTimeoutStr string        `yaml:"dial_timeout" json:"dial_timeout"`
+}
+
+// RedisListener proxies redis clients to a redis server, each client connection is served by a
+// backend connection of its own, so that MULTI, WATCH, SELECT and blocking commands keep their
+// semantics. The commands go through the redis filters of the listener, metrics and tracing.
+// Once a connection subscribes or monitors, the messages are relayed as they are and the
+// following commands of the connection bypass the filters.
+type RedisListener struct {
+	conf RedisConfig
+	// name labels the metrics of the listener
+	name     string
+	listener net.Listener
+	closed   *atomic.Bool
+
+	preFilters  []proto.RedisPreFilter
+	postFilters []proto.RedisPostFilter
+}
+
+func NewRedisListener(conf *config.Listener) (proto.Listener, error) {
+	var (
+		err     error
+		content []byte
+		cfg     RedisConfig
+	)
+
+	if content, err = json.Marshal(conf.Config); err != nil {
+		return nil, errors.Wrap(err, "marshal redis listener config failed.")
+	}
+	if err = json.Unmarshal(content, &cfg); err != nil {
+		log.Errorf("unmarshal redis listener config failed, %s", err)
+		return nil, err
+	}
+	if cfg.BackendAddress == "" {
+		return nil, errors.Errorf("redis listener %s has no backend_address", conf.SocketAddress)
+	}
+	cfg.DialTimeout = defaultRedisDialTimeout
+	if cfg.DialTimeoutStr != "" {
+		if cfg.DialTimeout, err = time.ParseDuration(cfg.DialTimeoutStr); err != nil {
+			return nil, errors.Wrap(err, "parse redis listener dial_timeout failed")
+		}
+	}
+
+	l, err := net.Listen("tcp", conf.SocketAddress.String())
+	if err != nil {
+		log.Errorf("listen %s error, %s", conf.SocketAddress.String(), err)
+		return nil, err
+	}
+
+	listener := &RedisListener{
+		conf:        cfg,
+		name:        l.Addr().String(),
+		listener:    l,
+		closed:      atomic.NewBool(false),
+		preFilters:  make([]proto.RedisPreFilter, 0),
+		postFilters: make([]proto.RedisPostFilter, 0),
+	}
+	for i := 0; i < len(conf.Filters); i++ {
+		filterName := conf.Filters[i]
+		f := filter.GetFilter(conf.AppID, filterName)
+		if f != nil {
+			preFilter, ok := f.(proto.RedisPreFilter)
+			if ok {
+				listener.preFilters = append(listener.preFilters, preFilter)
+			}
+			postFilter, ok := f.(proto.RedisPostFilter)
+			if ok {
+				listener.postFilters = append(listener.postFilters, postFilter)
+			}
+		}
+	}
+	return listener, nil
+}
+
+// Addr returns the address the listener accepts connections on.
+func (l *RedisListener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+func (l *RedisListener) Listen() {
+	log.Infof("start redis listener %s, backend %s", l.listener.Addr(), l.conf.BackendAddress)
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			if !l.closed.Load() {
+				log.Error(err)
+			}
+			return
+		}
+		go l.handle(conn, connectionIDs.Inc())
+	}
+}
+
+func (l *RedisListener) Close() {
+	l.closed.Store(true)
+	if err := l.listener.Close(); err != nil {
+		log.Error(err)
+	}
+}
+
+func (l *RedisListener) handle(conn net.Conn, connectionID uint32) {
+	defer conn.Close()
+
+	ctx := proto.WithConnectionID(context.Background(), connectionID)
+	ctx = proto.WithRemoteAddr(ctx, conn.RemoteAddr().String())
+	s := &redisSession{
+		listener:      l,
+		ctx:           ctx,
+		client:        conn,
+		reader:        bufio.NewReader(conn),
+		writer:        bufio.NewWriter(conn),
+		authenticated: len(l.conf.Users) == 0,
+	}
+	backend, err := l.dialBackend()
+	if err != nil {
+		log.Errorf("connect redis backend %s failed, connection id: %d, error: %v",
+			l.conf.BackendAddress, connectionID, err)
+		s.write(resp.ErrorReply("ERR backend unavailable"))
+		s.flush()
+		return
+	}
+	defer backend.conn.Close()
+	s.backend = backend
+	s.serve()
+}
+
+// dialBackend connects to the backend, then authenticates and selects the database of the config.
+func (l *RedisListener) dialBackend() (*redisBackend, error) {
+	conn, err := net.DialTimeout("tcp", l.conf.BackendAddress, l.conf.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	backend := &redisBackend{conn: conn, reader: bufio.NewReader(conn)}
+	var setup []*proto.RedisCommand
+	if l.conf.BackendPassword != "" {
+		auth := &proto.RedisCommand{Args: [][]byte{[]byte("AUTH"), []byte(l.conf.BackendPassword)}}
+		if l.conf.BackendUsername != "" {
+			auth.Args = [][]byte{[]byte("AUTH"), []byte(l.conf.BackendUsername), []byte(l.conf.BackendPassword)}
+		}
+		setup = append(setup, auth)
+	}
+	if l.conf.BackendDB != 0 {
+		setup = append(setup, &proto.RedisCommand{
+			Args: [][]byte{[]byte("SELECT"), []byte(strconv.Itoa(l.conf.BackendDB))},
+		})
+	}
+	if err = conn.SetDeadline(time.Now().Add(l.conf.DialTimeout)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	for _, command := range setup {
+		_, reply, err := backend.do(command)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if msg := reply.ErrorMessage(); msg != "" {
+			conn.Close()
+			return nil, errors.Errorf("%s failed: %s", command.Name(), msg)
+		}
+	}
+	if err = conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return backend, nil
+}
+
+// redisBackend is the backend connection of a client connection.
+type redisBackend struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	buf    []byte
+}
+
+func (b *redisBackend) send(command *proto.RedisCommand) error {
+	b.buf = resp.AppendCommand(b.buf[:0], command)
+	_, err := b.conn.Write(b.buf)
+	return err
+}
+
+// do sends a command and reads its reply, the RESP3 push messages read before it, such as
+// the invalidations of client side caching, are returned apart.
+func (b *redisBackend) do(command *proto.RedisCommand) ([]*proto.RedisReply, *proto.RedisReply, error) {
+	if err := b.send(command); err != nil {
+		return nil, nil, err
+	}
+	var pushes []*proto.RedisReply
+	for {
+		reply, err := resp.ReadReply(b.reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		if reply.Raw[0] != '>' {
+			return pushes, reply, nil
+		}
+		pushes = append(pushes, reply)
+	}
+}
+
+// redisSession serves a client connection.
+type redisSession struct {
+	listener *RedisListener
+	// ctx holds the connection id, the remote address and the user once authenticated
+	ctx           context.Context
+	client        net.Conn
+	reader        *bufio.Reader
+	writer        *bufio.Writer
+	backend       *redisBackend
+	authenticated bool
+}
+
+func (s *redisSession) serve() {
+	for {
+		command, err := resp.ReadCommand(s.reader)
+		if err != nil {
+			if errors.Is(err, resp.ErrProtocol) {
+				s.write(resp.ErrorReply("ERR Protocol error: " + err.Error()))
+				s.flush()
+			} else if err != io.EOF {
+				log.Debugf("read redis command failed, connection id: %d, error: %v",
+					proto.ConnectionID(s.ctx), err)
+			}
+			return
+		}
+
+		switch command.Name() {
+		case "AUTH":
+			s.write(s.auth(command))
+		case "QUIT":
+			s.write(resp.StatusReply("OK"))
+			s.flush()
+			return
+		case "SUBSCRIBE", "PSUBSCRIBE", "SSUBSCRIBE", "MONITOR":
+			if !s.authenticated {
+				s.write(noAuthReply())
+				break
+			}
+			if reply := s.preHandle(command); reply != nil {
+				s.write(reply)
+				break
+			}
+			s.relay(command)
+			return
+		default:
+			if !s.authenticated {
+				s.write(noAuthReply())
+				break
+			}
+			if command.Name() == "HELLO" {
+				var reply *proto.RedisReply
+				if command, reply = s.hello(command); reply != nil {
+					s.write(reply)
+					break
+				}
+			}
+			if !s.execute(command) {
+				return
+			}
+		}
+		// pipelined commands are replied together
+		if s.reader.Buffered() == 0 && !s.flush() {
+			return
+		}
+	}
+}
+
+// execute forwards a command through the filters, it returns false if the backend connection failed.
+func (s *redisSession) execute(command *proto.RedisCommand) bool {
+	start := time.Now()
+	ctx := proto.WithVariableMap(s.ctx)
+	spanCtx, span := tracing.GetTraceSpan(ctx, tracing.RedisListenerCommand)
+	defer span.End()
+	span.SetAttributes(attribute.String("redis.command", command.Name()))
+
+	reply := s.preHandleWithContext(spanCtx, command)
+	label := command.Name()
+	if reply == nil {
+		pushes, backendReply, err := s.backend.do(command)
+		if err != nil {
+			tracing.RecordErrorSpan(span, err)
+			log.Errorf("redis backend %s failed, connection id: %d, error: %v",
+				s.listener.conf.BackendAddress, proto.ConnectionID(s.ctx), err)
+			s.write(resp.ErrorReply("ERR backend unavailable"))
+			s.flush()
+			return false
+		}
+		for _, push := range pushes {
+			s.write(push)
+		}
+		reply = backendReply
+		if strings.HasPrefix(reply.ErrorMessage(), "ERR unknown command") {
+			label = unknownRedisCommand
+		}
+		for _, f := range s.listener.postFilters {
+			if err = f.PostHandleRedis(spanCtx, command, reply); err != nil {
+				tracing.RecordErrorSpan(span, err)
+				reply = resp.ErrorReply("ERR " + err.Error())
+				break
+			}
+		}
+	}
+
+	if reply.ErrorMessage() != "" {
+		redisCommandErrors.WithLabelValues(s.listener.name, label).Inc()
+	}
+	redisCommandLatency.WithLabelValues(s.listener.name, label).Observe(time.Since(start).Seconds())
+	s.write(reply)
+	return true
+}
+
+func (s *redisSession) preHandle(command *proto.RedisCommand) *proto.RedisReply {
+	return s.preHandleWithContext(proto.WithVariableMap(s.ctx), command)
+}
+
+// preHandleWithContext applies the pre filters, it returns the error reply of the filter failing the command.
+func (s *redisSession) preHandleWithContext(ctx context.Context, command *proto.RedisCommand) *proto.RedisReply {
+	for _, f := range s.listener.preFilters {
+		if err := f.PreHandleRedis(ctx, command); err != nil {
+			log.Debugf("redis command %s rejected by filter %s: %v", command.Name(), f.GetKind(), err)
+			return resp.ErrorReply("ERR " + err.Error())
+		}
+	}
+	return nil
+}
+
+// relay forwards a command which makes the backend push messages, then relays the traffic of both
+// connections until one of them is closed.
+func (s *redisSession) relay(command *proto.RedisCommand) {
+	if !s.flush() {
+		return
+	}
+	if err := s.backend.send(command); err != nil {
+		s.write(resp.ErrorReply("ERR backend unavailable"))
+		s.flush()
+		return
+	}
+	done := make(chan struct{}, 2)
+	go func() {
+		// the commands already read from the client are followed by the rest of its traffic
+		_, _ = io.Copy(s.backend.conn, s.reader)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(s.client, s.backend.reader)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// auth authenticates the client with AUTH [username] password.
+func (s *redisSession) auth(command *proto.RedisCommand) *proto.RedisReply {
+	var user, password string
+	switch len(command.Args) {
+	case 2:
+		user, password = redisDefaultUser, string(command.Args[1])
+	case 3:
+		user, password = string(command.Args[1]), string(command.Args[2])
+	default:
+		return resp.ErrorReply("ERR wrong number of arguments for 'auth' command")
+	}
+	return s.authenticate(user, password)
+}
+
+func (s *redisSession) authenticate(user, password string) *proto.RedisReply {
+	if len(s.listener.conf.Users) == 0 {
+		return resp.ErrorReply("ERR AUTH called without any password configured for the default user. " +
+			"Are you sure your configuration is correct?")
+	}
+	expected, ok := s.listener.conf.Users[user]
+	if !ok || subtle.ConstantTimeCompare([]byte(expected), []byte(password)) != 1 {
+		return resp.ErrorReply("WRONGPASS invalid username-password pair or user is disabled.")
+	}
+	s.authenticated = true
+	s.ctx = proto.WithUserName(s.ctx, user)
+	return resp.StatusReply("OK")
+}
+
+// hello authenticates the client with the AUTH option of HELLO, which is removed from the command forwarded
+// to the backend, the backend connection is authenticated already. A reply is returned if authentication fails.
+func (s *redisSession) hello(command *proto.RedisCommand) (*proto.RedisCommand, *proto.RedisReply) {
+	for i := 2; i < len(command.Args); i++ {
+		if !strings.EqualFold(string(command.Args[i]), "AUTH") {
+			continue
+		}
+		if i+2 >= len(command.Args) {
+			return command, resp.ErrorReply("ERR Syntax error in HELLO option 'auth'")
+		}
+		if reply := s.authenticate(string(command.Args[i+1]), string(command.Args[i+2])); reply.ErrorMessage() != "" {
+			return command, reply
+		}
+		args := make([][]byte, 0, len(command.Args)-3)
+		args = append(args, command.Args[:i]...)
+		args = append(args, command.Args[i+3:]...)
+		return &proto.RedisCommand{Args: args}, nil
+	}
+	return command, nil
+}
+
+func noAuthReply() *proto.RedisReply {
+	return resp.ErrorReply("NOAUTH Authentication required.")
+}
+
+func (s *redisSession) write(reply *proto.RedisReply) {
+	if _, err := s.writer.Write(reply.Raw); err != nil {
+		log.Debugf("write redis reply failed, connection id: %d, error: %v", proto.ConnectionID(s.ctx), err)
+	}
+}
+
+func (s *redisSession) flush() bool {
+	if err := s.writer.Flush(); err != nil {
+		log.Debugf("flush redis replies failed, connection id: %d, error: %v", proto.ConnectionID(s.ctx), err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,207 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/filter"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/resp"
+)
+
+// fakeRedis is a redis server storing strings, it records the commands it receives.
+type fakeRedis struct {
+	listener net.Listener
+	mu       sync.Mutex
+	commands []string
+	values   map[string]string
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	server := &fakeRedis{listener: l, values: map[string]string{}}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (s *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		command, err := resp.ReadCommand(r)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, command.String())
+		var reply *proto.RedisReply
+		switch command.Name() {
+		case "AUTH", "SELECT", "HELLO":
+			reply = resp.StatusReply("OK")
+		case "SET":
+			s.values[string(command.Args[1])] = string(command.Args[2])
+			reply = resp.StatusReply("OK")
+		case "GET":
+			value := s.values[string(command.Args[1])]
+			reply = &proto.RedisReply{Raw: []byte("$" + string(rune('0'+len(value))) + "\r\n" + value + "\r\n")}
+		default:
+			reply = resp.ErrorReply("ERR unknown command '" + string(command.Args[0]) + "'")
+		}
+		s.mu.Unlock()
+		if _, err = conn.Write(reply.Raw); err != nil {
+			return
+		}
+	}
+}
+
+func (s *fakeRedis) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+type redisTestFilter struct {
+	reject      string
+	postHandled []string
+}
+
+func (f *redisTestFilter) GetKind() string {
+	return "RedisTestFilter"
+}
+
+func (f *redisTestFilter) PreHandleRedis(ctx context.Context, command *proto.RedisCommand) error {
+	if command.Name() == f.reject {
+		return errors.New(f.reject + " is not allowed")
+	}
+	return nil
+}
+
+func (f *redisTestFilter) PostHandleRedis(ctx context.Context, command *proto.RedisCommand, reply *proto.RedisReply) error {
+	f.postHandled = append(f.postHandled, command.Name()+" "+reply.ErrorMessage())
+	return nil
+}
+
+func TestRedisListener(t *testing.T) {
+	const appID = "redis-listener-test"
+	backend := newFakeRedis(t)
+	defer backend.listener.Close()
+	testFilter := &redisTestFilter{reject: "FLUSHALL"}
+	filter.RegisterFilter(appID, "redis", testFilter)
+
+	l, err := NewRedisListener(&config.Listener{
+		AppID:         appID,
+		ProtocolType:  config.Redis,
+		SocketAddress: config.SocketAddress{Address: "127.0.0.1"},
+		Config: map[string]interface{}{
+			"backend_address":  backend.listener.Addr().String(),
+			"backend_password": "backend",
+			"backend_db":       2,
+			"users":            map[string]string{"default": "secret", "alice": "wonderland"},
+		},
+		Filters: []string{"redis"},
+	})
+	assert.Nil(t, err)
+	redisListener := l.(*RedisListener)
+	go redisListener.Listen()
+	defer redisListener.Close()
+
+	conn, err := net.Dial("tcp", redisListener.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	roundTrip := func(commands ...string) []string {
+		var buf []byte
+		for _, command := range commands {
+			buf = append(buf, command...)
+		}
+		_, err := conn.Write(buf)
+		assert.Nil(t, err)
+		replies := make([]string, 0, len(commands))
+		for range commands {
+			reply, err := resp.ReadReply(r)
+			assert.Nil(t, err)
+			replies = append(replies, string(reply.Raw))
+		}
+		return replies
+	}
+
+	assert.Equal(t, []string{"-NOAUTH Authentication required.\r\n"}, roundTrip("GET k\r\n"))
+	assert.Equal(t, []string{"-WRONGPASS invalid username-password pair or user is disabled.\r\n"},
+		roundTrip("AUTH alice secret\r\n"))
+	assert.Equal(t, []string{"+OK\r\n"}, roundTrip("AUTH secret\r\n"))
+	// pipelined commands
+	assert.Equal(t, []string{"+OK\r\n", "$1\r\nv\r\n", "-ERR FLUSHALL is not allowed\r\n"},
+		roundTrip("*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n", "GET k\r\n", "FLUSHALL\r\n"))
+	assert.Equal(t, []string{"+OK\r\n"}, roundTrip("HELLO 2 AUTH alice wonderland SETNAME app\r\n"))
+	assert.Equal(t, []string{"-ERR unknown command 'FOO'\r\n"}, roundTrip("FOO\r\n"))
+	assert.Equal(t, []string{"+OK\r\n"}, roundTrip("QUIT\r\n"))
+	_, err = r.ReadByte()
+	assert.Error(t, err)
+
+	// the backend connection is authenticated by the listener, the client credentials are not forwarded
+	assert.Equal(t, []string{"AUTH backend", "SELECT 2", "SET k v", "GET k", "HELLO 2 SETNAME app", "FOO"},
+		backend.received())
+	assert.Equal(t, []string{"SET ", "GET ", "HELLO ", "FOO ERR unknown command 'FOO'"}, testFilter.postHandled)
+}
+
+func TestRedisListenerBackendUnavailable(t *testing.T) {
+	backend := newFakeRedis(t)
+	address := backend.listener.Addr().String()
+	backend.listener.Close()
+
+	l, err := NewRedisListener(&config.Listener{
+		AppID:         "redis-listener-unavailable-test",
+		ProtocolType:  config.Redis,
+		SocketAddress: config.SocketAddress{Address: "127.0.0.1"},
+		Config:        map[string]interface{}{"backend_address": address},
+	})
+	assert.Nil(t, err)
+	redisListener := l.(*RedisListener)
+	go redisListener.Listen()
+	defer redisListener.Close()
+
+	conn, err := net.Dial("tcp", redisListener.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	reply, err := resp.ReadReply(bufio.NewReader(conn))
+	assert.Nil(t, err)
+	assert.Equal(t, "ERR backend unavailable", reply.ErrorMessage())
+
+	_, err = NewRedisListener(&config.Listener{
+		SocketAddress: config.SocketAddress{Address: "127.0.0.1"},
+		Config:        map[string]interface{}{},
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	err2 "github.com/cectc/dbpack/pkg/errors"
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+const (
+	ResultLimitActionWarn  = "warn"
+	ResultLimitActionError = "error"
+
+	resultLimitRows  = "rows"
+	resultLimitBytes = "bytes"
+)
+
+var resultLimitExceeded = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "dbpack",
+	Subsystem: "listener",
+	Name:      "result_limit_exceeded_total",
+	Help:      "count of the statements whose result exceeded the rows or bytes limit, by the limit and the action",
+}, []string{"listener", "limit", "action"})
+
+func init() {
+	prometheus.MustRegister(resultLimitExceeded)
+}
+
+// ResultLimitConfig surfaces the statements returning large results, such as applications
+// selecting entire tables by accident. The limits of zero are not enforced.
+type ResultLimitConfig struct {
+	// Rows is the max number of rows of a result
+	Rows uint64 `yaml:"rows" json:"rows"`
+	// Bytes is the max size of the rows of a result, as they are read from the backends
+	Bytes uint64 `yaml:"bytes" json:"bytes"`
+	// Action taken on a result over a limit, warn (the default) logs the statement and adds a
+	// warning to the warning count of the result, error fails the statement instead of sending
+	// the result
+	Action string `yaml:"action" json:"action"`
+}
+
+// resultLimit checks the results of the statements of a listener against the result limits.
+type resultLimit struct {
+	listener string
+	rows     uint64
+	bytes    uint64
+	action   string
+}
+
+func newResultLimit(listener string, conf *ResultLimitConfig) (*resultLimit, error) {
+	limit := &resultLimit{listener: listener, rows: conf.Rows, bytes: conf.Bytes, action: conf.Action}
+	if limit.rows == 0 && limit.bytes == 0 {
+		return nil, errors.New("result_limit should limit rows or bytes")
+	}
+	switch limit.action {
+	case "":
+		limit.action = ResultLimitActionWarn
+	case ResultLimitActionWarn, ResultLimitActionError:
+	default:
+		return nil, errors.Errorf("unsupported result_limit action '%s'", conf.Action)
+	}
+	return limit, nil
+}
+
+// check counts the rows of the result and their bytes, it returns the warnings of the result
+// with a warning added if the result is over a limit, or the error failing the statement.
+func (limit *resultLimit) check(ctx context.Context, result proto.Result, warn uint16) (uint16, error) {
+	rlt, ok := result.(*mysql.Result)
+	if !ok {
+		return warn, nil
+	}
+	var rows, bytes uint64
+	for r := rlt; r != nil; r = r.Next {
+		rows += uint64(len(r.Rows))
+		for _, row := range r.Rows {
+			bytes += uint64(len(row.Data()))
+		}
+	}
+	var exceeded string
+	var used, max uint64
+	switch {
+	case limit.rows > 0 && rows > limit.rows:
+		exceeded, used, max = resultLimitRows, rows, limit.rows
+	case limit.bytes > 0 && bytes > limit.bytes:
+		exceeded, used, max = resultLimitBytes, bytes, limit.bytes
+	default:
+		return warn, nil
+	}
+	resultLimitExceeded.WithLabelValues(limit.listener, exceeded, limit.action).Inc()
+	log.Warnf("conn %d: the result of user %s has %d %s over the limit of %d, sql: %s", proto.ConnectionID(ctx),
+		proto.UserName(ctx), used, exceeded, max, proto.SqlText(ctx))
+	if limit.action == ResultLimitActionError {
+		return 0, err2.NewSQLError(constant.ERUnknownError, constant.SSUnknownSQLState,
+			"Result of %d %s exceeds the limit of %d %s", used, exceeded, max, exceeded)
+	}
+	if warn < ^uint16(0) {
+		warn++
+	}
+	return warn, nil
+}
@@ -0,0 +1,83 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	err2 "github.com/cectc/dbpack/pkg/errors"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+func TestResultLimit(t *testing.T) {
+	fields := []*mysql.Field{{Name: "name", FieldType: constant.FieldTypeVarString}}
+	result := &mysql.Result{Fields: fields}
+	for _, name := range []string{"alice", "bob", "carol"} {
+		assert.NoError(t, result.AppendRow(proto.WithCommandType(context.Background(), constant.ComQuery), append([]byte{byte(len(name))}, name...)))
+	}
+
+	_, err := newResultLimit("127.0.0.1:13306", &ResultLimitConfig{Action: ResultLimitActionWarn})
+	assert.Error(t, err)
+	_, err = newResultLimit("127.0.0.1:13306", &ResultLimitConfig{Rows: 1, Action: "truncate"})
+	assert.Error(t, err)
+
+	testCases := []struct {
+		name   string
+		conf   ResultLimitConfig
+		warn   uint16
+		errNum int
+	}{
+		{
+			name: "under the limits",
+			conf: ResultLimitConfig{Rows: 3, Bytes: 16},
+			warn: 1,
+		},
+		{
+			name: "over the rows",
+			conf: ResultLimitConfig{Rows: 2},
+			warn: 2,
+		},
+		{
+			name: "over the bytes",
+			conf: ResultLimitConfig{Bytes: 15},
+			warn: 2,
+		},
+		{
+			name:   "over the rows with the error action",
+			conf:   ResultLimitConfig{Rows: 2, Action: ResultLimitActionError},
+			errNum: constant.ERUnknownError,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			limit, err := newResultLimit("127.0.0.1:13306", &c.conf)
+			assert.NoError(t, err)
+			warn, err := limit.check(context.Background(), result, 1)
+			if c.errNum != 0 {
+				assert.Equal(t, c.errNum, err.(*err2.SQLError).Num)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, c.warn, warn)
+		})
+	}
+}
@@ -0,0 +1,66 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/cectc/dbpack/pkg/log"
+)
+
+var warnReusePort sync.Once
+
+// listen opens acceptors sockets listening on address. More than one socket share the address
+// with SO_REUSEPORT, the kernel then spreads the incoming connections across them, so that
+// each socket is served by an accept loop of its own.
+func listen(address string, acceptors int) ([]net.Listener, error) {
+	if acceptors > 1 && !reusePortSupported {
+		warnReusePort.Do(func() {
+			log.Warnf("SO_REUSEPORT is only supported on linux, listen with a single acceptor")
+		})
+		acceptors = 1
+	}
+	if acceptors <= 1 {
+		l, err := net.Listen("tcp", address)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{l}, nil
+	}
+
+	lc := net.ListenConfig{Control: setReusePort}
+	first, err := lc.Listen(context.Background(), "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	listeners := []net.Listener{first}
+	// the port of the first socket is the one picked by the kernel if address has port 0
+	address = first.Addr().String()
+	for i := 1; i < acceptors; i++ {
+		l, err := lc.Listen(context.Background(), "tcp", address)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
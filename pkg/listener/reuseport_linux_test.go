@@ -0,0 +1,68 @@
+//go:build linux
+
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListenReusePort(t *testing.T) {
+	testCases := []struct {
+		name      string
+		acceptors int
+		expected  int
+	}{
+		{name: "default", acceptors: 0, expected: 1},
+		{name: "single acceptor", acceptors: 1, expected: 1},
+		{name: "multiple acceptors", acceptors: 4, expected: 4},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			listeners, err := listen("127.0.0.1:0", c.acceptors)
+			assert.NoError(t, err)
+			assert.Len(t, listeners, c.expected)
+
+			accepted := make(chan net.Conn, 16)
+			for _, l := range listeners {
+				defer l.Close()
+				assert.Equal(t, listeners[0].Addr().String(), l.Addr().String())
+				go func(l net.Listener) {
+					for {
+						conn, err := l.Accept()
+						if err != nil {
+							return
+						}
+						accepted <- conn
+					}
+				}(l)
+			}
+
+			// the connections are accepted whichever socket the kernel hands them to
+			for i := 0; i < 16; i++ {
+				conn, err := net.Dial("tcp", listeners[0].Addr().String())
+				assert.NoError(t, err)
+				defer conn.Close()
+				(<-accepted).Close()
+			}
+		})
+	}
+}
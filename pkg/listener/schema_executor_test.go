@@ -0,0 +1,145 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+type schemaTestExecutor struct {
+	proto.Executor
+	inTransaction bool
+	usedDB        string
+	closed        int
+}
+
+func (executor *schemaTestExecutor) InLocalTransaction(ctx context.Context) bool {
+	return executor.inTransaction
+}
+
+func (executor *schemaTestExecutor) InGlobalTransaction(ctx context.Context) bool {
+	return false
+}
+
+func (executor *schemaTestExecutor) ExecuteUseDB(ctx context.Context, db string) error {
+	executor.usedDB = db
+	return nil
+}
+
+func (executor *schemaTestExecutor) ConnectionClose(ctx context.Context) {
+	executor.closed++
+}
+
+func TestSchemaExecutor(t *testing.T) {
+	sharding, single := &schemaTestExecutor{}, &schemaTestExecutor{}
+	l := &MysqlListener{connectionExecutors: &sync.Map{}}
+	l.SetSchemaExecutor("orders", sharding)
+	assert.Nil(t, l.schemaExecutor("employees"))
+	l.SetExecutor(single)
+	assert.Equal(t, sharding, l.schemaExecutor("orders"))
+	assert.Equal(t, single, l.schemaExecutor("employees"))
+	assert.Equal(t, single, l.schemaExecutor(""))
+}
+
+func TestComInitDBSwitchesExecutor(t *testing.T) {
+	testCases := []struct {
+		name          string
+		db            string
+		inTransaction bool
+		errNum        uint16
+		switched      bool
+	}{
+		{
+			name:     "same executor",
+			db:       "employees",
+			switched: false,
+		},
+		{
+			name:     "another executor",
+			db:       "orders",
+			switched: true,
+		},
+		{
+			name:          "another executor in transaction",
+			db:            "orders",
+			inTransaction: true,
+			errNum:        constant.ERCantDoThisDuringAnTransaction,
+		},
+		{
+			name:   "no executor",
+			db:     "unknown",
+			errNum: constant.ERBadDb,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			single := &schemaTestExecutor{inTransaction: c.inTransaction}
+			sharding := &schemaTestExecutor{}
+			l := &MysqlListener{connectionExecutors: &sync.Map{}}
+			l.SetSchemaExecutor("employees", single)
+			l.SetSchemaExecutor("orders", sharding)
+
+			server, client := net.Pipe()
+			defer client.Close()
+			conn := mysql.NewConn(server)
+			defer conn.Close()
+			conn.SetConnectionID(1)
+			l.connectionExecutors.Store(conn.ID(), proto.Executor(single))
+
+			response := make(chan []byte)
+			go func() {
+				clientConn := mysql.NewConn(client)
+				_ = clientConn.WritePacket(append([]byte{constant.ComInitDB}, c.db...))
+				packet, _ := clientConn.ReadPacket()
+				response <- packet
+			}()
+			data, err := conn.ReadEphemeralPacket()
+			assert.NoError(t, err)
+			assert.NoError(t, l.ExecuteCommand(context.Background(), conn, data))
+			packet := <-response
+
+			if c.errNum != 0 {
+				assert.Equal(t, byte(constant.ErrPacket), packet[0])
+				assert.Equal(t, c.errNum, binary.LittleEndian.Uint16(packet[1:]))
+				assert.Equal(t, single, l.connectionExecutor(conn.ID()))
+				assert.Equal(t, 0, single.closed)
+				return
+			}
+			assert.Equal(t, byte(constant.OKPacket), packet[0])
+			assert.Equal(t, c.db, conn.Schema())
+			if c.switched {
+				assert.Equal(t, sharding, l.connectionExecutor(conn.ID()))
+				assert.Equal(t, 1, single.closed)
+				assert.Equal(t, c.db, sharding.usedDB)
+			} else {
+				assert.Equal(t, single, l.connectionExecutor(conn.ID()))
+				assert.Equal(t, 0, single.closed)
+				assert.Equal(t, c.db, single.usedDB)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/constant"
+	err2 "github.com/cectc/dbpack/pkg/errors"
+	"github.com/cectc/dbpack/pkg/filter"
+	"github.com/cectc/dbpack/pkg/meta"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/standby"
+	"github.com/cectc/dbpack/pkg/tablestats"
+	"github.com/cectc/dbpack/pkg/tracing"
+	"github.com/cectc/dbpack/pkg/visitor"
+	"github.com/cectc/dbpack/third_party/parser"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+)
+
+// statementRunner runs the statements of the listeners which do not speak the mysql protocol, it parses
+// them as the mysql listener does and runs them through the filters of the listener and the executors.
+type statementRunner struct {
+	appID       string
+	dialect     string
+	preFilters  []proto.DBPreFilter
+	postFilters []proto.DBPostFilter
+}
+
+func newStatementRunner(conf *config.Listener, dialect string) (*statementRunner, error) {
+	runner := &statementRunner{
+		appID:       conf.AppID,
+		dialect:     dialect,
+		preFilters:  make([]proto.DBPreFilter, 0),
+		postFilters: make([]proto.DBPostFilter, 0),
+	}
+	for i := 0; i < len(conf.Filters); i++ {
+		filterName := conf.Filters[i]
+		f := filter.GetFilter(conf.AppID, filterName)
+		if f != nil {
+			if _, ok := f.(proto.DBResultRowsFilter); ok {
+				return nil, errors.Errorf("filter %s reads the result rows, it must be configured on an executor", filterName)
+			}
+			preFilter, ok := f.(proto.DBPreFilter)
+			if ok {
+				runner.preFilters = append(runner.preFilters, preFilter)
+			}
+			postFilter, ok := f.(proto.DBPostFilter)
+			if ok {
+				runner.postFilters = append(runner.postFilters, postFilter)
+			}
+		}
+	}
+	return runner, nil
+}
+
+// parse parses a single statement and rejects the syntax the configured dialect does not accept.
+func (r *statementRunner) parse(sql string) (ast.StmtNode, error) {
+	p := parser.New()
+	p.EnableWindowFunc(r.dialect != visitor.DialectMySQL57)
+	stmt, err := p.ParseOneStmt(sql, "", "")
+	if err != nil {
+		return nil, err2.NewParseError(err)
+	}
+	if r.dialect == visitor.DialectMySQL57 {
+		v := &visitor.DialectVisitor{Dialect: r.dialect}
+		stmt.Accept(v)
+		if v.Err != nil {
+			return nil, err2.NewParseError(v.Err)
+		}
+	}
+	return stmt, nil
+}
+
+// execute runs a parsed statement through the filters and the executor, it is prepared if it has args,
+// which are bind vars typed as those of the prepared statements of the mysql protocol.
+func (r *statementRunner) execute(ctx context.Context, executor proto.Executor, spanName string,
+	sql string, stmt ast.StmtNode, args []interface{}) (result proto.Result, err error) {
+	stmt.Accept(&visitor.ParamVisitor{})
+
+	traceCtx := tracing.BuildContextFromSQLHint(ctx, stmt)
+	spanCtx, span := tracing.GetTraceSpan(traceCtx, spanName)
+	defer span.End()
+
+	spanCtx = proto.WithSqlText(spanCtx, sql)
+	var run func() (proto.Result, uint16, error)
+	if len(args) == 0 {
+		spanCtx = proto.WithCommandType(spanCtx, constant.ComQuery)
+		spanCtx = proto.WithQueryStmt(spanCtx, stmt)
+		run = func() (proto.Result, uint16, error) {
+			return executor.ExecutorComQuery(spanCtx, sql)
+		}
+	} else {
+		prepared := &proto.Stmt{
+			SqlText:     sql,
+			StmtNode:    stmt,
+			ParamsCount: uint16(len(args)),
+			BindVars:    make(map[string]interface{}, len(args)),
+		}
+		for i, arg := range args {
+			prepared.BindVars[fmt.Sprintf("v%d", i+1)] = arg
+		}
+		spanCtx = proto.WithCommandType(spanCtx, constant.ComStmtExecute)
+		spanCtx = proto.WithPrepareStmt(spanCtx, prepared)
+		run = func() (proto.Result, uint16, error) {
+			return executor.ExecutorComStmtExecute(spanCtx, prepared)
+		}
+	}
+
+	tablestats.Record(proto.Schema(ctx), stmt)
+	if err = standby.CheckWrite(r.appID, stmt, sql); err != nil {
+		tracing.RecordErrorSpan(span, err)
+		return nil, err
+	}
+	if err = r.doPreFilter(spanCtx); err != nil {
+		tracing.RecordErrorSpan(span, err)
+		return nil, err
+	}
+	result, _, err = run()
+	if err = r.doPostFilter(spanCtx, result, err); err != nil {
+		releaseResult(result)
+		tracing.RecordErrorSpan(span, err)
+		return nil, err
+	}
+	if _, isDDL := stmt.(ast.DDLNode); isDDL {
+		meta.GetTableMetaCache().InvalidateByDDL(proto.Schema(ctx), stmt)
+	}
+	return result, nil
+}
+
+func (r *statementRunner) doPreFilter(ctx context.Context) error {
+	for i := 0; i < len(r.preFilters); i++ {
+		f := r.preFilters[i]
+		err := f.PreHandle(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *statementRunner) doPostFilter(ctx context.Context, result proto.Result, err error) error {
+	for i := 0; i < len(r.postFilters); i++ {
+		f := r.postFilters[i]
+		err := f.PostHandle(ctx, result, err)
+		if err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// releaseResult releases a result which is not sent.
+func releaseResult(result proto.Result) {
+	if rlt, ok := result.(*mysql.Result); ok {
+		rlt.Release()
+	}
+}
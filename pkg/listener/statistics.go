@@ -0,0 +1,42 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"fmt"
+	"time"
+)
+
+// statistics answers COM_STATISTICS, which mysqladmin status sends, in the format of mysql. The
+// threads are the client sessions of the listener and the questions the statements they sent,
+// the statistics of the tables are those of the backends, which the listener does not report.
+func (l *MysqlListener) statistics() string {
+	var threads int
+	l.processes.Range(func(_, _ interface{}) bool {
+		threads++
+		return true
+	})
+	uptime := uint64(time.Since(l.started) / time.Second)
+	questions := l.questions.Load()
+	// the average is that of the first second during the first second, as mysql does
+	perSecond := float64(questions)
+	if uptime > 0 {
+		perSecond /= float64(uptime)
+	}
+	return fmt.Sprintf("Uptime: %d  Threads: %d  Questions: %d  Slow queries: 0  Opens: 0  Flush tables: 0  "+
+		"Open tables: 0  Queries per second avg: %.3f", uptime, threads, questions, perSecond)
+}
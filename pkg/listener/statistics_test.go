@@ -0,0 +1,119 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package listener
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+func TestStatistics(t *testing.T) {
+	l := &MysqlListener{started: time.Now().Add(-4 * time.Second)}
+	l.processes.Store(uint32(1), &process{})
+	l.processes.Store(uint32(2), &process{})
+	l.questions.Add(10)
+	assert.Equal(t, "Uptime: 4  Threads: 2  Questions: 10  Slow queries: 0  Opens: 0  Flush tables: 0  "+
+		"Open tables: 0  Queries per second avg: 2.500", l.statistics())
+}
+
+func TestExecuteCommandOfUncommonCommands(t *testing.T) {
+	testCases := []struct {
+		name         string
+		data         []byte
+		capabilities uint32
+		header       byte
+		errNum       uint16
+	}{
+		{
+			name:   "statistics",
+			data:   []byte{constant.ComStatistics},
+			header: 'U',
+		},
+		{
+			name:   "debug",
+			data:   []byte{constant.ComDebug},
+			header: constant.EOFPacket,
+		},
+		{
+			name:         "multi statements on",
+			data:         []byte{constant.ComSetOption, 0, 0},
+			capabilities: constant.CapabilityClientMultiStatements,
+			header:       constant.EOFPacket,
+		},
+		{
+			name:   "multi statements off",
+			data:   []byte{constant.ComSetOption, 1, 0},
+			header: constant.EOFPacket,
+		},
+		{
+			name:   "unknown option",
+			data:   []byte{constant.ComSetOption, 2, 0},
+			header: constant.ErrPacket,
+			errNum: constant.ERUnknownComError,
+		},
+		{
+			name:   "unknown command",
+			data:   []byte{constant.ComTime},
+			header: constant.ErrPacket,
+			errNum: constant.ERUnknownComError,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			l := &MysqlListener{started: time.Now(), connectionExecutors: &sync.Map{}}
+
+			server, client := net.Pipe()
+			defer client.Close()
+			conn := mysql.NewConn(server)
+			defer conn.Close()
+			conn.SetConnectionID(1)
+			conn.SetCapabilities(constant.CapabilityClientMultiStatements &^ c.capabilities)
+			l.connectionExecutors.Store(conn.ID(), proto.Executor(&schemaTestExecutor{}))
+
+			response := make(chan []byte)
+			go func() {
+				clientConn := mysql.NewConn(client)
+				_ = clientConn.WritePacket(c.data)
+				packet, _ := clientConn.ReadPacket()
+				response <- packet
+			}()
+			data, err := conn.ReadEphemeralPacket()
+			assert.NoError(t, err)
+			// the connection is kept whatever the command
+			assert.NoError(t, l.ExecuteCommand(context.Background(), conn, data))
+			packet := <-response
+
+			assert.Equal(t, c.header, packet[0])
+			if c.errNum != 0 {
+				assert.Equal(t, c.errNum, binary.LittleEndian.Uint16(packet[1:]))
+			}
+			if c.data[0] == constant.ComSetOption && c.errNum == 0 {
+				assert.Equal(t, c.capabilities, conn.Capabilities())
+			}
+		})
+	}
+}
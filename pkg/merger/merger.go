@@ -0,0 +1,47 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package merger registers the result mergers of the aggregate functions dbpack does not merge
+// itself, they merge the aggregated values returned by the shards of a scatter-gather query,
+// e.g. approximate distinct counts or custom percentile aggregations.
+package merger
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+var (
+	mergersLock sync.RWMutex
+	// mergers maps the lower case names of aggregate functions to their mergers.
+	mergers = make(map[string]proto.ResultMerger)
+)
+
+// RegisterMerger registers the merger of an aggregate function, function names are case-insensitive.
+func RegisterMerger(function string, merger proto.ResultMerger) {
+	mergersLock.Lock()
+	defer mergersLock.Unlock()
+	mergers[strings.ToLower(function)] = merger
+}
+
+// GetMerger returns the merger of an aggregate function, nil if there is none.
+func GetMerger(function string) proto.ResultMerger {
+	mergersLock.RLock()
+	defer mergersLock.RUnlock()
+	return mergers[strings.ToLower(function)]
+}
@@ -32,20 +32,42 @@ import (
 	"github.com/cectc/dbpack/pkg/misc"
 	"github.com/cectc/dbpack/pkg/mysql"
 	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/third_party/parser/ast"
 )
 
 var ExpireTime = 15 * time.Minute
 
 var tableMetaCache = &MysqlTableMetaCache{
 	tableMetaCache: cache.New(ExpireTime, 10*ExpireTime),
+	ttl:            ExpireTime,
 }
 
 func GetTableMetaCache() *MysqlTableMetaCache {
 	return tableMetaCache
 }
 
+// tableMetaItem keeps the db where the table meta fetched from, so that it can be refreshed.
+type tableMetaItem struct {
+	meta schema.TableMeta
+	db   proto.DB
+}
+
 type MysqlTableMetaCache struct {
 	tableMetaCache *cache.Cache
+	ttl            time.Duration
+}
+
+// SetTTL sets how long a fetched table meta stays in the cache before it is fetched again,
+// table metas already cached keep their original expiration.
+func (cache *MysqlTableMetaCache) SetTTL(ttl time.Duration) {
+	if ttl > 0 {
+		cache.ttl = ttl
+	}
+}
+
+// TTL returns how long a fetched table meta stays in the cache.
+func (cache *MysqlTableMetaCache) TTL() time.Duration {
+	return cache.ttl
 }
 
 func (cache *MysqlTableMetaCache) GetTableMeta(ctx context.Context, db proto.DB, tableName string) (schema.TableMeta, error) {
@@ -57,39 +79,94 @@ func (cache *MysqlTableMetaCache) GetTableMeta(ctx context.Context, db proto.DB,
 		return schema.TableMeta{}, errors.New("TableMeta cannot be fetched without tableName")
 	}
 	cacheKey := cache.GetCacheKey(schemaName, tableName)
-	tMeta, found := cache.tableMetaCache.Get(cacheKey)
+	item, found := cache.tableMetaCache.Get(cacheKey)
 	if found {
-		meta := tMeta.(schema.TableMeta)
-		return meta, nil
+		return item.(*tableMetaItem).meta, nil
 	} else {
 		meta, err := cache.FetchSchema(ctx, db, tableName)
 		if err != nil {
 			return schema.TableMeta{}, errors.WithStack(err)
 		}
-		cache.tableMetaCache.Set(cacheKey, meta, ExpireTime)
+		cache.tableMetaCache.Set(cacheKey, &tableMetaItem{meta: meta, db: db}, cache.ttl)
 		return meta, nil
 	}
 }
 
-func (cache *MysqlTableMetaCache) Refresh(db proto.DB) error {
-	for k, v := range cache.tableMetaCache.Items() {
-		meta := v.Object.(schema.TableMeta)
-		key := cache.GetCacheKey(meta.SchemaName, meta.TableName)
-		if k == key {
-			tMeta, err := cache.FetchSchema(
-				proto.WithSchema(context.Background(), meta.SchemaName), db, meta.TableName)
-			if err != nil {
-				return errors.WithStack(err)
-			}
-			if !cmp.Equal(tMeta, meta) {
-				cache.tableMetaCache.Set(key, tMeta, ExpireTime)
-				log.Info("table meta change was found, update table meta cache automatically.")
-			}
+// Refresh fetches all the cached table metas again, the cached table meta will be replaced if it has been changed.
+func (cache *MysqlTableMetaCache) Refresh() error {
+	for key, v := range cache.tableMetaCache.Items() {
+		item := v.Object.(*tableMetaItem)
+		tMeta, err := cache.FetchSchema(
+			proto.WithSchema(context.Background(), item.meta.SchemaName), item.db, item.meta.TableName)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if !cmp.Equal(tMeta, item.meta) {
+			cache.tableMetaCache.Set(key, &tableMetaItem{meta: tMeta, db: item.db}, cache.ttl)
+			log.Infof("table meta change of %s was found, update table meta cache automatically.", key)
 		}
 	}
 	return nil
 }
 
+// AutoRefresh refreshes the cached table metas every interval until ctx is done.
+func (cache *MysqlTableMetaCache) AutoRefresh(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := cache.Refresh(); err != nil {
+				log.Warnf("refresh table meta cache failed, err: %v", err)
+			}
+		}
+	}
+}
+
+// Invalidate removes the cached table meta, the table meta will be fetched again when it is used next time.
+func (cache *MysqlTableMetaCache) Invalidate(schemaName, tableName string) {
+	cache.tableMetaCache.Delete(cache.GetCacheKey(schemaName, tableName))
+}
+
+// InvalidateByDDL removes the cached table metas of the tables changed by a DDL statement,
+// other statements are ignored.
+func (cache *MysqlTableMetaCache) InvalidateByDDL(schemaName string, stmt ast.StmtNode) {
+	var tables []*ast.TableName
+	switch ddl := stmt.(type) {
+	case *ast.AlterTableStmt:
+		tables = append(tables, ddl.Table)
+	case *ast.CreateTableStmt:
+		tables = append(tables, ddl.Table)
+	case *ast.DropTableStmt:
+		tables = append(tables, ddl.Tables...)
+	case *ast.TruncateTableStmt:
+		tables = append(tables, ddl.Table)
+	case *ast.CreateIndexStmt:
+		tables = append(tables, ddl.Table)
+	case *ast.DropIndexStmt:
+		tables = append(tables, ddl.Table)
+	case *ast.RenameTableStmt:
+		for _, t2t := range ddl.TableToTables {
+			tables = append(tables, t2t.OldTable, t2t.NewTable)
+		}
+	default:
+		return
+	}
+	for _, table := range tables {
+		if table == nil {
+			continue
+		}
+		dbName := schemaName
+		if table.Schema.O != "" {
+			dbName = table.Schema.O
+		}
+		cache.Invalidate(dbName, table.Name.O)
+		log.Debugf("table meta cache of %s.%s is invalidated by ddl", dbName, table.Name.O)
+	}
+}
+
 func (cache *MysqlTableMetaCache) GetCacheKey(dbName, tableName string) string {
 	var defaultTableName string
 	tableNameWithCatalog := strings.Split(strings.ReplaceAll(tableName, "`", ""), ".")
@@ -151,7 +228,7 @@ func GetColumns(ctx context.Context, db proto.DB, tableName string) ([]schema.Co
 	//`EXTRA`,	`PRIVILEGES`, `COLUMN_COMMENT`, `GENERATION_EXPRESSION`, `SRS_ID`
 	s := "SELECT `TABLE_CATALOG`, `TABLE_SCHEMA`, `TABLE_NAME`, `COLUMN_NAME`, `DATA_TYPE`, `CHARACTER_MAXIMUM_LENGTH`, " +
 		"`NUMERIC_PRECISION`, `NUMERIC_SCALE`, `IS_NULLABLE`, `COLUMN_COMMENT`, `COLUMN_DEFAULT`, `CHARACTER_OCTET_LENGTH`, " +
-		"`ORDINAL_POSITION`, `COLUMN_KEY`, `EXTRA`  FROM `INFORMATION_SCHEMA`.`COLUMNS` WHERE `TABLE_SCHEMA` = ? " +
+		"`ORDINAL_POSITION`, `COLUMN_KEY`, `EXTRA`, `GENERATION_EXPRESSION` FROM `INFORMATION_SCHEMA`.`COLUMNS` WHERE `TABLE_SCHEMA` = ? " +
 		"AND `TABLE_NAME` = ? ORDER BY ORDINAL_POSITION ASC"
 
 	if dbName == "" {
@@ -192,13 +269,13 @@ func GetColumns(ctx context.Context, db proto.DB, tableName string) ([]schema.Co
 			col.DataType = constant.GetSqlDataType(col.DataTypeName)
 		}
 		if values[5] != nil {
-			col.ColumnSize = values[5].Val.(int64)
+			col.ColumnSize = intValue(values[5])
 		}
 		if values[6] != nil {
-			col.DecimalDigits = values[6].Val.(int64)
+			col.DecimalDigits = intValue(values[6])
 		}
 		if values[7] != nil {
-			col.NumPrecRadix = values[7].Val.(int64)
+			col.NumPrecRadix = intValue(values[7])
 		}
 		if values[8] != nil {
 			col.IsNullable = fmt.Sprintf("%s", values[8].Val)
@@ -213,18 +290,22 @@ func GetColumns(ctx context.Context, db proto.DB, tableName string) ([]schema.Co
 		}
 		if values[10] != nil {
 			col.ColumnDef = fmt.Sprintf("%s", values[10].Val)
+			col.HasDefault = true
 		}
 		col.SqlDataType = 0
 		col.SqlDatetimeSub = 0
 		if values[11] != nil {
-			col.CharOctetLength = values[11].Val.(int64)
+			col.CharOctetLength = intValue(values[11])
 		}
 		if values[12] != nil {
-			col.OrdinalPosition = values[12].Val.(int64)
+			col.OrdinalPosition = intValue(values[12])
 		}
 		if values[14] != nil {
 			col.IsAutoIncrement = fmt.Sprintf("%s", values[14].Val)
 		}
+		if len(values) > 15 && values[15] != nil {
+			col.GenerationExpression = fmt.Sprintf("%s", values[15].Val)
+		}
 
 		result = append(result, col)
 	}
@@ -279,14 +360,14 @@ func GetIndexes(ctx context.Context, db proto.DB, tableName string) ([]schema.In
 			}
 		}
 		if values[4] != nil {
-			ordinalPosition := values[4].Val.(int64)
+			ordinalPosition := intValue(values[4])
 			index.OrdinalPosition = int32(ordinalPosition)
 		}
 		if values[5] != nil {
 			index.AscOrDesc = fmt.Sprintf("%s", values[5].Val)
 		}
 		if values[6] != nil {
-			cardinality := values[6].Val.(int64)
+			cardinality := intValue(values[6])
 			index.Cardinality = int32(cardinality)
 		}
 		if "primary" == strings.ToLower(index.IndexName) {
@@ -302,3 +383,12 @@ func GetIndexes(ctx context.Context, db proto.DB, tableName string) ([]schema.In
 
 	return result, nil
 }
+
+// intValue returns the value of an integer column of information_schema, which is uint64 if the
+// column is BIGINT UNSIGNED, such as NUMERIC_PRECISION of mysql 8.0.
+func intValue(value *proto.Value) int64 {
+	if val, ok := value.Val.(uint64); ok {
+		return int64(val)
+	}
+	return value.Val.(int64)
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package meta
+
+import (
+	"testing"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/dt/schema"
+	"github.com/cectc/dbpack/third_party/parser"
+	_ "github.com/cectc/dbpack/third_party/types/parser_driver"
+)
+
+func TestInvalidateByDDL(t *testing.T) {
+	testCases := []struct {
+		sql         string
+		invalidated []string
+	}{
+		{
+			sql:         "alter table student add column gender tinyint",
+			invalidated: []string{"school.student"},
+		},
+		{
+			sql:         "drop table student, school.teacher",
+			invalidated: []string{"school.student", "school.teacher"},
+		},
+		{
+			sql:         "rename table student to pupil",
+			invalidated: []string{"school.student"},
+		},
+		{
+			sql:         "create index idx_age on student(age)",
+			invalidated: []string{"school.student"},
+		},
+		{
+			sql:         "truncate table other.student",
+			invalidated: []string{},
+		},
+		{
+			sql:         "select * from student",
+			invalidated: []string{},
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.sql, func(t *testing.T) {
+			metaCache := &MysqlTableMetaCache{
+				tableMetaCache: cache.New(time.Minute, time.Minute),
+				ttl:            time.Minute,
+			}
+			for _, table := range []string{"student", "teacher"} {
+				metaCache.tableMetaCache.Set(metaCache.GetCacheKey("school", table), &tableMetaItem{
+					meta: schema.TableMeta{SchemaName: "school", TableName: table},
+				}, time.Minute)
+			}
+
+			stmt, err := parser.New().ParseOneStmt(c.sql, "", "")
+			assert.Nil(t, err)
+			metaCache.InvalidateByDDL("school", stmt)
+
+			for _, key := range []string{"school.student", "school.teacher"} {
+				_, found := metaCache.tableMetaCache.Get(key)
+				assert.Equal(t, !contains(c.invalidated, key), found, key)
+			}
+		})
+	}
+}
+
+func contains(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
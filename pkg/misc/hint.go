@@ -18,6 +18,7 @@ package misc
 
 import (
 	"strings"
+	"time"
 
 	"github.com/cectc/dbpack/third_party/parser/ast"
 	"github.com/cectc/dbpack/third_party/parser/model"
@@ -28,6 +29,8 @@ const (
 	GlobalLockHint  = "GlobalLock"
 	UseDBHint       = "UseDB"
 	TraceParentHint = "TraceParent"
+
+	MaxExecutionTimeHint = "MAX_EXECUTION_TIME"
 )
 
 func HasXIDHint(hints []*ast.TableOptimizerHint) (bool, string) {
@@ -72,6 +75,18 @@ func HasTraceParentHint(hints []*ast.TableOptimizerHint) (bool, string) {
 	return false, ""
 }
 
+// HasMaxExecutionTimeHint returns the timeout of the MAX_EXECUTION_TIME hint, which is in milliseconds.
+func HasMaxExecutionTimeHint(hints []*ast.TableOptimizerHint) (bool, time.Duration) {
+	for _, hint := range hints {
+		if strings.EqualFold(hint.HintName.String(), MaxExecutionTimeHint) {
+			if ms, ok := hint.HintData.(uint64); ok && ms > 0 {
+				return true, time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+	return false, 0
+}
+
 func NewXIDHint(xid string) *ast.TableOptimizerHint {
 	return &ast.TableOptimizerHint{
 		HintName: model.CIStr{
@@ -19,6 +19,7 @@ package misc
 import (
 	"fmt"
 	"math"
+	"net"
 	"reflect"
 	"regexp"
 	"sort"
@@ -348,3 +349,16 @@ func Wrap(sb *strings.Builder, wrap byte, origin string) {
 	sb.WriteString(origin)
 	sb.WriteByte(wrap)
 }
+
+// HostIP extracts the host of a host:port address, IPv4-mapped IPv6 addresses reported
+// for the clients of a dual-stack listener are returned in IPv4 form
+func HostIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return host
+}
@@ -19,8 +19,154 @@ package misc
 import (
 	"fmt"
 	"strings"
+	"unicode"
+
+	"github.com/cectc/dbpack/third_party/parser/ast"
 )
 
+// passthroughKeywords are the leading keywords of statements that are safe to send
+// to the master without being parsed: reads and DDL, which no filter needs to inspect.
+var passthroughKeywords = map[string]bool{
+	"SELECT":   true,
+	"WITH":     true,
+	"SHOW":     true,
+	"DESC":     true,
+	"DESCRIBE": true,
+	"EXPLAIN":  true,
+	"CREATE":   true,
+	"ALTER":    true,
+	"DROP":     true,
+	"RENAME":   true,
+	"TRUNCATE": true,
+}
+
+// writeKeywords mark a statement as DML, a CTE may be followed by UPDATE or DELETE.
+var writeKeywords = map[string]bool{
+	"INSERT":  true,
+	"REPLACE": true,
+	"UPDATE":  true,
+	"DELETE":  true,
+}
+
+// IsPassthroughSafe reports whether an unparsed statement may be sent to the master as is.
+// DML and locking reads are never safe, because distributed transaction filters must see them.
+func IsPassthroughSafe(sql string) bool {
+	words := sqlWords(sql)
+	if len(words) == 0 || !passthroughKeywords[words[0]] {
+		return false
+	}
+	switch words[0] {
+	case "SELECT", "WITH":
+		for i, word := range words {
+			if writeKeywords[word] {
+				return false
+			}
+			if word == "FOR" && i+1 < len(words) && words[i+1] == "UPDATE" {
+				return false
+			}
+			if word == "LOCK" && i+1 < len(words) && words[i+1] == "IN" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// IsReadStatement reports whether an unparsed statement only reads data, i.e. it is a read
+// IsPassthroughSafe accepts.
+func IsReadStatement(sql string) bool {
+	words := sqlWords(sql)
+	if len(words) == 0 {
+		return false
+	}
+	switch words[0] {
+	case "SELECT", "WITH", "SHOW", "DESC", "DESCRIBE", "EXPLAIN":
+		return IsPassthroughSafe(sql)
+	}
+	return false
+}
+
+// XACommand returns the upper-cased command of an XA statement, e.g. START for
+// "XA START 'xid'", or an empty string if sql is not an XA statement.
+func XACommand(sql string) string {
+	words := sqlWords(sql)
+	if len(words) < 2 || words[0] != "XA" {
+		return ""
+	}
+	return words[1]
+}
+
+// BackendProcessList reports whether sql is SHOW [FULL] BACKEND PROCESSLIST, the extension
+// listing the processes of the backends instead of the client sessions, and whether it is FULL.
+func BackendProcessList(sql string) (ok, full bool) {
+	words := sqlWords(sql)
+	if len(words) == 4 && words[1] == "FULL" {
+		full = true
+		words = append(words[:1], words[2:]...)
+	}
+	ok = len(words) == 3 && words[0] == "SHOW" && words[1] == "BACKEND" && words[2] == "PROCESSLIST"
+	return ok, ok && full
+}
+
+// DBPackBlockers reports whether sql is SHOW DBPACK BLOCKERS, the extension listing the lock waits
+// of the backends along with the client sessions waiting and blocking.
+func DBPackBlockers(sql string) bool {
+	words := sqlWords(sql)
+	return len(words) == 3 && words[0] == "SHOW" && words[1] == "DBPACK" && words[2] == "BLOCKERS"
+}
+
+// sqlWords splits sql into upper-cased words, skipping comments and quoted literals.
+func sqlWords(sql string) []string {
+	var (
+		words []string
+		word  strings.Builder
+	)
+	flush := func() {
+		if word.Len() > 0 {
+			words = append(words, strings.ToUpper(word.String()))
+			word.Reset()
+		}
+	}
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			flush()
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				return words
+			}
+			i += end + 3
+		case c == '#' || (c == '-' && i+1 < len(sql) && sql[i+1] == '-'):
+			flush()
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				return words
+			}
+			i += end
+		case c == '\'' || c == '"' || c == '`':
+			flush()
+			for i++; i < len(sql) && sql[i] != c; i++ {
+				if sql[i] == '\\' && c != '`' {
+					i++
+				}
+			}
+		case c == '_' || c == '$' || c >= 0x80 || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)):
+			word.WriteByte(c)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return words
+}
+
+// IsLockingRead reports whether the select takes row locks, i.e. it is a
+// SELECT ... FOR UPDATE, FOR SHARE or LOCK IN SHARE MODE statement.
+func IsLockingRead(stmt *ast.SelectStmt) bool {
+	return stmt.LockInfo != nil && stmt.LockInfo.LockType != ast.SelectLockNone
+}
+
 func MysqlAppendInParam(size int) string {
 	var sb strings.Builder
 	sb.WriteByte('(')
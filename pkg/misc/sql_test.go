@@ -20,6 +20,10 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/third_party/parser"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+	_ "github.com/cectc/dbpack/third_party/types/parser_driver"
 )
 
 func TestMysqlAppendInParam(t *testing.T) {
@@ -105,3 +109,121 @@ func TestMysqlAppendInParamWithValue(t *testing.T) {
 		})
 	}
 }
+
+func TestIsPassthroughSafe(t *testing.T) {
+	cases := map[string]struct {
+		in  string
+		out bool
+	}{
+		"select":              {"SELECT id FROM t", true},
+		"commented select":    {"/* hint */ select id from t -- tail", true},
+		"cte":                 {"WITH cte AS (SELECT 1) SELECT * FROM cte", true},
+		"cte update":          {"WITH cte AS (SELECT 1) UPDATE t SET a = 1", false},
+		"for update":          {"SELECT id FROM t WHERE id = 1 FOR UPDATE", false},
+		"share mode":          {"SELECT id FROM t LOCK IN SHARE MODE", false},
+		"quoted keyword":      {"SELECT 'update' FROM t", true},
+		"ddl":                 {"ALTER TABLE t ADD COLUMN c INT DEFAULT (rand())", true},
+		"insert":              {"INSERT INTO t VALUES (1)", false},
+		"delete":              {"delete from t", false},
+		"line comment insert": {"-- comment\nINSERT INTO t VALUES (1)", false},
+		"empty":               {"  ", false},
+	}
+
+	for caseTitle, tc := range cases {
+		t.Run(caseTitle, func(t *testing.T) {
+			assert.Equal(t, tc.out, IsPassthroughSafe(tc.in))
+		})
+	}
+}
+
+func TestIsReadStatement(t *testing.T) {
+	cases := map[string]struct {
+		in  string
+		out bool
+	}{
+		"select":     {"SELECT id FROM t", true},
+		"show":       {"show tables", true},
+		"cte update": {"WITH cte AS (SELECT 1) UPDATE t SET a = 1", false},
+		"for update": {"SELECT id FROM t WHERE id = 1 FOR UPDATE", false},
+		"ddl":        {"CREATE TABLE t (id INT)", false},
+		"insert":     {"INSERT INTO t VALUES (1)", false},
+		"empty":      {"  ", false},
+	}
+
+	for caseTitle, tc := range cases {
+		t.Run(caseTitle, func(t *testing.T) {
+			assert.Equal(t, tc.out, IsReadStatement(tc.in))
+		})
+	}
+}
+
+func TestXACommand(t *testing.T) {
+	cases := map[string]struct {
+		in  string
+		out string
+	}{
+		"start":     {"XA START 'xid1'", "START"},
+		"begin":     {"xa begin 'xid1', 'b1', 1", "BEGIN"},
+		"commented": {"/* app */ XA COMMIT 'xid1' ONE PHASE", "COMMIT"},
+		"recover":   {"XA RECOVER CONVERT XID", "RECOVER"},
+		"select":    {"SELECT 'XA START'", ""},
+		"xa only":   {"XA", ""},
+	}
+
+	for caseTitle, tc := range cases {
+		t.Run(caseTitle, func(t *testing.T) {
+			assert.Equal(t, tc.out, XACommand(tc.in))
+		})
+	}
+}
+
+func TestBackendProcessList(t *testing.T) {
+	cases := map[string]struct {
+		in   string
+		ok   bool
+		full bool
+	}{
+		"backend":     {"SHOW BACKEND PROCESSLIST", true, false},
+		"full":        {"show full backend processlist;", true, true},
+		"commented":   {"/* monitor */ SHOW BACKEND PROCESSLIST", true, false},
+		"processlist": {"SHOW FULL PROCESSLIST", false, false},
+		"misplaced":   {"SHOW BACKEND FULL PROCESSLIST", false, false},
+		"quoted":      {"SELECT 'SHOW BACKEND PROCESSLIST'", false, false},
+	}
+
+	for caseTitle, tc := range cases {
+		t.Run(caseTitle, func(t *testing.T) {
+			ok, full := BackendProcessList(tc.in)
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.full, full)
+		})
+	}
+}
+
+func TestDBPackBlockers(t *testing.T) {
+	assert.True(t, DBPackBlockers("SHOW DBPACK BLOCKERS"))
+	assert.True(t, DBPackBlockers("/* monitor */ show dbpack blockers;"))
+	assert.False(t, DBPackBlockers("SHOW DBPACK BLOCKERS FULL"))
+	assert.False(t, DBPackBlockers("SELECT 'SHOW DBPACK BLOCKERS'"))
+}
+
+func TestIsLockingRead(t *testing.T) {
+	cases := map[string]struct {
+		in  string
+		out bool
+	}{
+		"plain select":    {"SELECT id FROM t WHERE id = 1", false},
+		"for update":      {"SELECT id FROM t WHERE id = 1 FOR UPDATE", true},
+		"for update wait": {"SELECT id FROM t WHERE id = 1 FOR UPDATE NOWAIT", true},
+		"for share":       {"SELECT id FROM t WHERE id = 1 FOR SHARE", true},
+		"share mode":      {"SELECT id FROM t WHERE id = 1 LOCK IN SHARE MODE", true},
+	}
+
+	for caseTitle, tc := range cases {
+		t.Run(caseTitle, func(t *testing.T) {
+			stmt, err := parser.New().ParseOneStmt(tc.in, "", "")
+			assert.Nil(t, err)
+			assert.Equal(t, tc.out, IsLockingRead(stmt.(*ast.SelectStmt)))
+		})
+	}
+}
@@ -207,6 +207,46 @@ func ParseDateTime(b []byte, loc *time.Location) (time.Time, error) {
 	}
 }
 
+// ParseTime parses a TIME value [-][H]HH:MM:SS[.fraction] of the text protocol, or the binary
+// protocol once formatted by FormatBinaryTime, into the duration it stands for.
+func ParseTime(b []byte) (time.Duration, error) {
+	s := string(b)
+	negative := strings.HasPrefix(s, "-")
+	if negative {
+		s = s[1:]
+	}
+	fraction := ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		s, fraction = s[:i], s[i+1:]
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 || len(parts[1]) != 2 || len(parts[2]) != 2 || len(fraction) > 6 {
+		return 0, fmt.Errorf("invalid time bytes: %s", b)
+	}
+	hour, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time bytes: %s", b)
+	}
+	min, err := parseByte2Digits(parts[1][0], parts[1][1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid time bytes: %s", b)
+	}
+	sec, err := parseByte2Digits(parts[2][0], parts[2][1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid time bytes: %s", b)
+	}
+	nsec, err := parseByteNanoSec([]byte(fraction))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time bytes: %s", b)
+	}
+	d := time.Duration(hour)*time.Hour + time.Duration(min)*time.Minute +
+		time.Duration(sec)*time.Second + time.Duration(nsec)
+	if negative {
+		d = -d
+	}
+	return d, nil
+}
+
 func parseByteYear(b []byte) (int, error) {
 	year, n := 0, 1000
 	for i := 0; i < 4; i++ {
@@ -953,3 +953,30 @@ func float32Value(ptr interface{}) float32 {
 func timeValue(ptr interface{}) time.Time {
 	return *(ptr.(*time.Time))
 }
+
+func TestParseTime(t *testing.T) {
+	testCases := []struct {
+		value  string
+		expect time.Duration
+		err    bool
+	}{
+		{value: "00:00:00", expect: 0},
+		{value: "12:34:56", expect: 12*time.Hour + 34*time.Minute + 56*time.Second},
+		{value: "00:00:00.000001", expect: time.Microsecond},
+		{value: "-00:00:00.5", expect: -500 * time.Millisecond},
+		{value: "-838:59:59.000000", expect: -(838*time.Hour + 59*time.Minute + 59*time.Second)},
+		{value: "838:59:59.999999", expect: 838*time.Hour + 59*time.Minute + 59*time.Second + 999999*time.Microsecond},
+		{value: "12:34", err: true},
+		{value: "12:34:56.1234567", err: true},
+		{value: "12:3a:56", err: true},
+	}
+	for _, c := range testCases {
+		d, err := ParseTime([]byte(c.value))
+		if c.err {
+			assert.Error(t, err, c.value)
+			continue
+		}
+		assert.NoError(t, err, c.value)
+		assert.Equal(t, c.expect, d, c.value)
+	}
+}
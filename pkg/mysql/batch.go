@@ -0,0 +1,124 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mysql
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/misc"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+// ColumnBatch holds some columns of a batch of rows, decoded column by column into a buffer
+// per column. Rows compared by a few of their columns, e.g. while merging the sorted results
+// of the shards, are neither decoded entirely nor allocate a value per row and column, and
+// are forwarded to the client as they were read from the backend.
+type ColumnBatch struct {
+	rows    []proto.Row
+	columns []int
+	// values[i][j] is the value of columns[i] of rows[j]
+	values [][]proto.Value
+}
+
+// DecodeColumnBatch decodes the columns of rows at the given indexes. The text rows not
+// decoded yet are only scanned up to the last column needed, the other rows are decoded.
+func DecodeColumnBatch(rows []proto.Row, columns []int) (*ColumnBatch, error) {
+	batch := &ColumnBatch{
+		rows:    rows,
+		columns: columns,
+		values:  make([][]proto.Value, len(columns)),
+	}
+	last := -1
+	for i, column := range columns {
+		if column < 0 {
+			return nil, errors.Errorf("invalid column index %d", column)
+		}
+		if column > last {
+			last = column
+		}
+		batch.values[i] = make([]proto.Value, len(rows))
+	}
+	// wanted[column] are the indexes in columns of column
+	wanted := make([][]int, last+1)
+	for i, column := range columns {
+		wanted[column] = append(wanted[column], i)
+	}
+
+	for j, row := range rows {
+		if textRow, ok := row.(*TextRow); ok && !textRow.decoded {
+			if err := batch.decodeTextRow(j, textRow, wanted); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		values, err := row.Decode()
+		if err != nil {
+			return nil, err
+		}
+		for i, column := range columns {
+			if column >= len(values) {
+				return nil, errors.Errorf("column index %d out of range of %d columns", column, len(values))
+			}
+			if values[column] != nil {
+				batch.values[i][j] = *values[column]
+			}
+		}
+	}
+	return batch, nil
+}
+
+func (batch *ColumnBatch) decodeTextRow(j int, row *TextRow, wanted [][]int) error {
+	fields := row.ResultSet.Columns
+	if len(wanted) > len(fields) {
+		return errors.Errorf("column index %d out of range of %d columns", len(wanted)-1, len(fields))
+	}
+	pos := 0
+	for column, indexes := range wanted {
+		if len(indexes) == 0 {
+			n, err := misc.SkipLengthEncodedString(row.Content[pos:])
+			if err != nil {
+				return err
+			}
+			pos += n
+			continue
+		}
+		n, err := decodeTextValue(fields[column], row.Content[pos:], &batch.values[indexes[0]][j])
+		if err != nil {
+			return err
+		}
+		for _, i := range indexes[1:] {
+			batch.values[i][j] = batch.values[indexes[0]][j]
+		}
+		pos += n
+	}
+	return nil
+}
+
+// Len returns the number of rows of the batch.
+func (batch *ColumnBatch) Len() int {
+	return len(batch.rows)
+}
+
+// Row returns the j-th row of the batch.
+func (batch *ColumnBatch) Row(j int) proto.Row {
+	return batch.rows[j]
+}
+
+// Value returns the value of the i-th decoded column of the j-th row, its Val is nil if NULL.
+func (batch *ColumnBatch) Value(i, j int) *proto.Value {
+	return &batch.values[i][j]
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mysql
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+func TestDecodeColumnBatch(t *testing.T) {
+	fields := []*Field{
+		{Name: "id", FieldType: constant.FieldTypeLong},
+		{Name: "name", FieldType: constant.FieldTypeVarString},
+		{Name: "age", FieldType: constant.FieldTypeLong},
+	}
+	textCtx := proto.WithCommandType(context.Background(), constant.ComQuery)
+	binaryCtx := proto.WithCommandType(context.Background(), constant.ComStmtExecute)
+
+	text := &Result{Fields: fields}
+	assert.NoError(t, text.AppendRow(textCtx, []byte{0x01, '1', 0x03, 'a', 'b', 'c', 0x02, '1', '8'}))
+	assert.NoError(t, text.AppendRow(textCtx, []byte{0x01, '2', 0xfb, 0x02, '2', '0'}))
+	decoded := &Result{Fields: fields}
+	assert.NoError(t, decoded.AppendRow(textCtx, []byte{0x01, '3', 0x01, 'x', 0x02, '3', '0'}))
+	_, err := decoded.Rows[0].Decode()
+	assert.NoError(t, err)
+	binary := &Result{Fields: fields}
+	// header, null bitmap with name NULL, id 4, age 40
+	assert.NoError(t, binary.AppendRow(binaryCtx, []byte{0x00, 0x08, 0x04, 0x00, 0x00, 0x00, 0x28, 0x00, 0x00, 0x00}))
+
+	testCases := []struct {
+		name     string
+		rows     []proto.Row
+		columns  []int
+		expected [][]interface{}
+		hasErr   bool
+	}{
+		{
+			name:     "undecoded text rows",
+			rows:     text.Rows,
+			columns:  []int{1, 0},
+			expected: [][]interface{}{{[]byte("abc"), nil}, {[]byte("1"), []byte("2")}},
+		},
+		{
+			name:     "column decoded twice",
+			rows:     text.Rows,
+			columns:  []int{2, 2},
+			expected: [][]interface{}{{[]byte("18"), []byte("20")}, {[]byte("18"), []byte("20")}},
+		},
+		{
+			name:     "decoded text row",
+			rows:     decoded.Rows,
+			columns:  []int{1},
+			expected: [][]interface{}{{[]byte("x")}},
+		},
+		{
+			name:     "binary row",
+			rows:     binary.Rows,
+			columns:  []int{2, 1},
+			expected: [][]interface{}{{int64(40)}, {nil}},
+		},
+		{
+			name:    "column out of range",
+			rows:    text.Rows,
+			columns: []int{3},
+			hasErr:  true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			batch, err := DecodeColumnBatch(c.rows, c.columns)
+			if c.hasErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, len(c.rows), batch.Len())
+			for i, values := range c.expected {
+				for j, value := range values {
+					assert.Equal(t, value, batch.Value(i, j).Val)
+				}
+			}
+		})
+	}
+	// the text rows are left undecoded
+	assert.False(t, text.Rows[0].(*TextRow).decoded)
+}
@@ -0,0 +1,258 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mysql
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/constant"
+)
+
+const (
+	// CompressionZlib is the compression algorithm negotiated by CLIENT_COMPRESS.
+	CompressionZlib = "zlib"
+	// CompressionZstd is the compression algorithm negotiated by CLIENT_ZSTD_COMPRESSION_ALGORITHM.
+	CompressionZstd = "zstd"
+
+	// DefaultZstdCompressionLevel is the zstd compression level used by mysql by default.
+	DefaultZstdCompressionLevel = 3
+
+	// compressedHeaderSize is the size of the header of a compressed packet,
+	// 3 bytes compressed length, 1 byte sequence and 3 bytes uncompressed length.
+	compressedHeaderSize = 7
+	// minCompressLength is the payload length below which payloads are sent
+	// uncompressed, the same threshold as mysql.
+	minCompressLength = 50
+)
+
+// CompressionStats are the bytes of a compressed connection, the payloads are the bytes of the regular
+// packets, the compressed bytes are the bytes of the compressed packets sent on the wire, headers included.
+type CompressionStats struct {
+	Sent               uint64
+	SentCompressed     uint64
+	Received           uint64
+	ReceivedCompressed uint64
+}
+
+// compressor implements the mysql compressed protocol on top of the raw stream of a Conn.
+// The stream of regular packets is cut into compressed packets, each with its own header
+// and sequence, so it is transparent to the packet reading and writing of Conn.
+type compressor struct {
+	algorithm string
+	r         io.Reader
+	w         io.Writer
+
+	// sequence is the sequence of the compressed packets, it is shared by both
+	// directions and reset with the sequence of the regular packets.
+	sequence uint8
+
+	// readBuffer holds the decompressed payload not consumed yet.
+	readBuffer bytes.Buffer
+	// writeBuffer holds the payload written but not flushed yet.
+	writeBuffer []byte
+
+	// stats is only updated and read by the goroutine serving the connection.
+	stats CompressionStats
+
+	zlibWriter  *zlib.Writer
+	zlibReader  io.ReadCloser
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+}
+
+func newCompressor(algorithm string, level int, r io.Reader, w io.Writer) (*compressor, error) {
+	c := &compressor{
+		algorithm: algorithm,
+		r:         r,
+		w:         w,
+	}
+	switch algorithm {
+	case CompressionZlib:
+		c.zlibWriter = zlib.NewWriter(nil)
+	case CompressionZstd:
+		if level <= 0 {
+			level = DefaultZstdCompressionLevel
+		}
+		encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)),
+			zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		c.zstdEncoder, c.zstdDecoder = encoder, decoder
+	default:
+		return nil, errors.Errorf("unsupported compression algorithm %s", algorithm)
+	}
+	return c, nil
+}
+
+// Read reads the decompressed payload, reading the next compressed packet if needed.
+func (c *compressor) Read(p []byte) (int, error) {
+	for c.readBuffer.Len() == 0 {
+		if err := c.readCompressedPacket(); err != nil {
+			return 0, err
+		}
+	}
+	return c.readBuffer.Read(p)
+}
+
+func (c *compressor) readCompressedPacket() error {
+	var header [compressedHeaderSize]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		if err == io.EOF {
+			return err
+		}
+		return errors.Wrapf(err, "io.ReadFull(compressed header size) failed")
+	}
+	compressedLength := int(uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16)
+	sequence := header[3]
+	uncompressedLength := int(uint32(header[4]) | uint32(header[5])<<8 | uint32(header[6])<<16)
+	if sequence != c.sequence {
+		return errors.Errorf("invalid compressed Sequence, expected %v got %v", c.sequence, sequence)
+	}
+	c.sequence++
+
+	payload := make([]byte, compressedLength)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return errors.Wrapf(err, "io.ReadFull(compressed packet body of length %v) failed", compressedLength)
+	}
+	c.stats.ReceivedCompressed += uint64(compressedHeaderSize + compressedLength)
+	// An uncompressed length of 0 means the payload is not compressed.
+	if uncompressedLength == 0 {
+		c.stats.Received += uint64(compressedLength)
+		c.readBuffer.Write(payload)
+		return nil
+	}
+
+	c.readBuffer.Grow(uncompressedLength)
+	switch c.algorithm {
+	case CompressionZlib:
+		if c.zlibReader == nil {
+			reader, err := zlib.NewReader(bytes.NewReader(payload))
+			if err != nil {
+				return errors.Wrap(err, "decompress packet failed")
+			}
+			c.zlibReader = reader
+		} else if err := c.zlibReader.(zlib.Resetter).Reset(bytes.NewReader(payload), nil); err != nil {
+			return errors.Wrap(err, "decompress packet failed")
+		}
+		n, err := io.Copy(&c.readBuffer, c.zlibReader)
+		if err != nil {
+			return errors.Wrap(err, "decompress packet failed")
+		}
+		if int(n) != uncompressedLength {
+			return errors.Errorf("decompressed packet length %v, expected %v", n, uncompressedLength)
+		}
+	case CompressionZstd:
+		data, err := c.zstdDecoder.DecodeAll(payload, make([]byte, 0, uncompressedLength))
+		if err != nil {
+			return errors.Wrap(err, "decompress packet failed")
+		}
+		if len(data) != uncompressedLength {
+			return errors.Errorf("decompressed packet length %v, expected %v", len(data), uncompressedLength)
+		}
+		c.readBuffer.Write(data)
+	}
+	c.stats.Received += uint64(uncompressedLength)
+	return nil
+}
+
+// Write buffers p until Flush, payloads exceeding the max compressed packet are written at once.
+func (c *compressor) Write(p []byte) (int, error) {
+	c.writeBuffer = append(c.writeBuffer, p...)
+	for len(c.writeBuffer) > constant.MaxPacketSize {
+		if err := c.writeCompressedPacket(c.writeBuffer[:constant.MaxPacketSize]); err != nil {
+			return 0, err
+		}
+		c.writeBuffer = c.writeBuffer[constant.MaxPacketSize:]
+	}
+	return len(p), nil
+}
+
+// Flush writes the buffered payload as compressed packets.
+func (c *compressor) Flush() error {
+	if len(c.writeBuffer) == 0 {
+		return nil
+	}
+	err := c.writeCompressedPacket(c.writeBuffer)
+	if cap(c.writeBuffer) > connBufferSize {
+		// Do not hold the memory of a large result set.
+		c.writeBuffer = nil
+	} else {
+		c.writeBuffer = c.writeBuffer[:0]
+	}
+	return err
+}
+
+func (c *compressor) writeCompressedPacket(payload []byte) error {
+	var buf bytes.Buffer
+	buf.Grow(compressedHeaderSize + len(payload))
+	buf.Write(make([]byte, compressedHeaderSize))
+
+	uncompressedLength := len(payload)
+	if uncompressedLength < minCompressLength {
+		uncompressedLength = 0
+	} else {
+		switch c.algorithm {
+		case CompressionZlib:
+			c.zlibWriter.Reset(&buf)
+			if _, err := c.zlibWriter.Write(payload); err != nil {
+				return errors.Wrap(err, "compress packet failed")
+			}
+			if err := c.zlibWriter.Close(); err != nil {
+				return errors.Wrap(err, "compress packet failed")
+			}
+		case CompressionZstd:
+			buf.Write(c.zstdEncoder.EncodeAll(payload, nil))
+		}
+		// Send the payload as is if it does not shrink.
+		if buf.Len()-compressedHeaderSize >= len(payload) {
+			uncompressedLength = 0
+		}
+	}
+	if uncompressedLength == 0 {
+		buf.Truncate(compressedHeaderSize)
+		buf.Write(payload)
+	}
+
+	data := buf.Bytes()
+	compressedLength := len(data) - compressedHeaderSize
+	data[0] = byte(compressedLength)
+	data[1] = byte(compressedLength >> 8)
+	data[2] = byte(compressedLength >> 16)
+	data[3] = c.sequence
+	data[4] = byte(uncompressedLength)
+	data[5] = byte(uncompressedLength >> 8)
+	data[6] = byte(uncompressedLength >> 16)
+	if n, err := c.w.Write(data); err != nil {
+		return errors.Wrapf(err, "Write(compressed packet) failed")
+	} else if n != len(data) {
+		return errors.Errorf("Write(compressed packet) returned a short write: %v < %v", n, len(data))
+	}
+	c.sequence++
+	c.stats.Sent += uint64(len(payload))
+	c.stats.SentCompressed += uint64(len(data))
+	return nil
+}
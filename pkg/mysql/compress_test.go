@@ -0,0 +1,117 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mysql
+
+import (
+	"bytes"
+	"math/rand"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/constant"
+)
+
+func TestCompressedPackets(t *testing.T) {
+	random := make([]byte, constant.MaxPacketSize+100)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	packets := [][]byte{
+		[]byte("select 1"),
+		bytes.Repeat([]byte("compressible "), 1000),
+		random[:10000],
+		// exceeds the max payload of a compressed packet and of a packet
+		random,
+	}
+
+	testCases := []struct {
+		name      string
+		algorithm string
+		buffered  bool
+	}{
+		{name: "zlib", algorithm: CompressionZlib},
+		{name: "zstd", algorithm: CompressionZstd},
+		{name: "zlib buffered", algorithm: CompressionZlib, buffered: true},
+		{name: "zstd buffered", algorithm: CompressionZstd, buffered: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			client, server := NewConn(clientConn), NewConn(serverConn)
+			defer client.Close()
+			defer server.Close()
+			assert.NoError(t, client.EnableCompression(c.algorithm, 0))
+			assert.NoError(t, server.EnableCompression(c.algorithm, 0))
+			assert.Equal(t, c.algorithm, client.CompressionAlgorithm())
+
+			errCh := make(chan error, 1)
+			go func() {
+				if c.buffered {
+					client.StartWriterBuffering()
+				}
+				for _, data := range packets {
+					if err := client.WritePacket(data); err != nil {
+						errCh <- err
+						return
+					}
+				}
+				if c.buffered {
+					errCh <- client.EndWriterBuffering()
+					return
+				}
+				errCh <- nil
+			}()
+
+			for _, data := range packets {
+				read, err := server.ReadPacket()
+				assert.NoError(t, err)
+				assert.True(t, bytes.Equal(data, read))
+			}
+			assert.NoError(t, <-errCh)
+
+			// The regular packets have a header each, the last one is split in two packets.
+			payload := uint64(packetHeaderSize * (len(packets) + 1))
+			for _, data := range packets {
+				payload += uint64(len(data))
+			}
+			stats := server.CompressionStats()
+			assert.Equal(t, payload, stats.Received)
+			assert.Equal(t, client.CompressionStats().Sent, stats.Received)
+			assert.Equal(t, client.CompressionStats().SentCompressed, stats.ReceivedCompressed)
+			assert.Greater(t, stats.ReceivedCompressed, uint64(0))
+
+			// The sequences are reset by a new command and shared by both directions.
+			client.ResetSequence()
+			server.ResetSequence()
+			go func() {
+				errCh <- client.WritePacket([]byte("select 2"))
+			}()
+			read, err := server.ReadPacket()
+			assert.NoError(t, err)
+			assert.Equal(t, "select 2", string(read))
+			assert.NoError(t, <-errCh)
+			go func() {
+				errCh <- server.WritePacket([]byte("ok"))
+			}()
+			read, err = client.ReadPacket()
+			assert.NoError(t, err)
+			assert.Equal(t, "ok", string(read))
+			assert.NoError(t, <-errCh)
+		})
+	}
+}
@@ -46,6 +46,9 @@ const (
 	// connBufferSize is how much we buffer for reading and
 	// writing. It is also how much we allocate for ephemeral buffers.
 	connBufferSize = 16 * 1024
+
+	// packetHeaderSize is the size of the length and sequence header of the packets
+	packetHeaderSize = 4
 )
 
 // Constants for how ephemeral buffers were used for reading / writing.
@@ -90,6 +93,13 @@ type Conn struct {
 
 	userName string
 
+	// schema is the default database, set by the handshake and COM_INIT_DB.
+	schema string
+
+	// capabilities is the set of features negotiated with the client by the handshake.
+	// It is only used by the server.
+	capabilities uint32
+
 	// closed is set to true when Close() is called on the connection.
 	closed sync2.AtomicBool
 
@@ -109,6 +119,9 @@ type Conn struct {
 	bufferedWriter *bufio.Writer
 	flushTimer     *time.Timer
 
+	// compressor is set once the compressed protocol is negotiated.
+	compressor *compressor
+
 	// Keep track of how and of the buffer we allocated for an
 	// ephemeral packet on the read and write sides.
 	// These fields are used by:
@@ -121,6 +134,13 @@ type Conn struct {
 
 	ReadTimeout  time.Duration // I/O read timeout
 	WriteTimeout time.Duration // I/O write timeout
+
+	// deadline bounds the I/O of the statement in flight, along with the timeouts
+	deadline time.Time
+
+	// maxAllowedPacket is the max length of a payload read, including
+	// the payloads split into multiple packets, 0 means unlimited.
+	maxAllowedPacket int
 }
 
 // NewConn is an internal method to create a Conn. Used by client and server
@@ -141,7 +161,35 @@ func (c *Conn) StartWriterBuffering() {
 	defer c.bufMu.Unlock()
 
 	c.bufferedWriter = writersPool.Get().(*bufio.Writer)
-	c.bufferedWriter.Reset(c.conn)
+	c.bufferedWriter.Reset(c.getRawWriter())
+}
+
+// EnableCompression switches the connection to the compressed protocol, it must be
+// called by both sides right after the handshake completes. level is only used by zstd.
+func (c *Conn) EnableCompression(algorithm string, level int) error {
+	compressor, err := newCompressor(algorithm, level, c.getReader(), c.conn)
+	if err != nil {
+		return err
+	}
+	c.compressor = compressor
+	return nil
+}
+
+// CompressionAlgorithm returns the compression algorithm in use, empty if not compressed.
+func (c *Conn) CompressionAlgorithm() string {
+	if c.compressor == nil {
+		return ""
+	}
+	return c.compressor.algorithm
+}
+
+// CompressionStats returns the bytes sent and received by the compressed protocol, before and after
+// compression, all zero if not compressed.
+func (c *Conn) CompressionStats() CompressionStats {
+	if c.compressor == nil {
+		return CompressionStats{}
+	}
+	return c.compressor.stats
 }
 
 // EndWriterBuffering must be called to terminate startWriteBuffering.
@@ -160,7 +208,7 @@ func (c *Conn) EndWriterBuffering() error {
 	}()
 
 	c.stopFlushTimer()
-	return c.bufferedWriter.Flush()
+	return c.flushBufferedWriter()
 }
 
 // getWriter returns the current writer. It may be either
@@ -177,7 +225,27 @@ func (c *Conn) getWriter() (w io.Writer, unget func()) {
 		}
 	}
 	c.bufMu.Unlock()
-	return c.conn, func() {}
+	return c.getRawWriter(), func() {}
+}
+
+// getRawWriter returns the writer of the packet stream, the compressor if
+// the compressed protocol is in use, or the connection.
+func (c *Conn) getRawWriter() io.Writer {
+	if c.compressor != nil {
+		return c.compressor
+	}
+	return c.conn
+}
+
+// flushBufferedWriter must be called while holding lock on bufMu.
+func (c *Conn) flushBufferedWriter() error {
+	if err := c.bufferedWriter.Flush(); err != nil {
+		return err
+	}
+	if c.compressor != nil {
+		return c.compressor.Flush()
+	}
+	return nil
 }
 
 // startFlushTimer must be called while holding lock on bufMu.
@@ -191,7 +259,7 @@ func (c *Conn) startFlushTimer() {
 			return
 		}
 		c.stopFlushTimer()
-		c.bufferedWriter.Flush()
+		c.flushBufferedWriter()
 	})
 }
 
@@ -203,13 +271,60 @@ func (c *Conn) stopFlushTimer() {
 	}
 }
 
+// SetMaxAllowedPacket limits the length of the payloads read, reading a larger payload
+// fails with ER_NET_PACKET_TOO_LARGE, 0 means unlimited.
+func (c *Conn) SetMaxAllowedPacket(maxAllowedPacket int) {
+	c.maxAllowedPacket = maxAllowedPacket
+}
+
+// checkPacketLength returns ER_NET_PACKET_TOO_LARGE if length exceeds maxAllowedPacket.
+func (c *Conn) checkPacketLength(length int) error {
+	if c.maxAllowedPacket > 0 && length > c.maxAllowedPacket {
+		return err2.NewSQLError(constant.ERNetPacketTooLarge, constant.SSNetPacketTooLarge,
+			"Got a packet bigger than 'max_allowed_packet' bytes")
+	}
+	return nil
+}
+
 func (c *Conn) ResetSequence() {
 	c.sequence = 0
+	if c.compressor != nil {
+		c.compressor.sequence = 0
+	}
 }
 
-// getReader returns reader for connection. It can be *bufio.Reader or net.Conn
-// depending on which buffer size was passed to newServerConn.
+// StartResponse sets the sequence of the packets written next to that of the response to a
+// command, which answers a command read before the commands the client pipelined after it.
+func (c *Conn) StartResponse() {
+	c.sequence = 1
+}
+
+// BufferedQuery reports whether the next packet is a COM_QUERY the client sent without waiting
+// for the response to the previous command, and which is completely read in the buffer already.
+// It is always false on the compressed protocol.
+func (c *Conn) BufferedQuery() bool {
+	if c.compressor != nil || c.bufferedReader == nil {
+		return false
+	}
+	buffered := c.bufferedReader.Buffered()
+	if buffered < packetHeaderSize+1 {
+		return false
+	}
+	header, err := c.bufferedReader.Peek(packetHeaderSize + 1)
+	if err != nil {
+		return false
+	}
+	length := int(uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16)
+	return header[3] == 0 && header[4] == constant.ComQuery &&
+		length < constant.MaxPacketSize && buffered >= packetHeaderSize+length
+}
+
+// getReader returns reader for connection. It can be the compressor, *bufio.Reader
+// or net.Conn depending on which buffer size was passed to newServerConn.
 func (c *Conn) getReader() io.Reader {
+	if c.compressor != nil {
+		return c.compressor
+	}
 	if c.bufferedReader != nil {
 		return c.bufferedReader
 	}
@@ -238,11 +353,13 @@ func (c *Conn) readHeaderFrom(r io.Reader) (int, error) {
 	}
 
 	sequence := uint8(header[3])
-	if sequence != c.sequence {
+	// The sequence of the packets in compressed packets is not checked, the same as mysql,
+	// as it is checked on the compressed packets.
+	if sequence != c.sequence && c.compressor == nil {
 		return 0, errors.Errorf("invalid Sequence, expected %v got %v", c.sequence, sequence)
 	}
 
-	c.sequence++
+	c.sequence = sequence + 1
 
 	return int(uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16), nil
 }
@@ -273,6 +390,9 @@ func (c *Conn) ReadEphemeralPacket() ([]byte, error) {
 		// exactly size MaxPacketSize.
 		return nil, nil
 	}
+	if err := c.checkPacketLength(length); err != nil {
+		return nil, err
+	}
 
 	// Use the bufPool.
 	if length < constant.MaxPacketSize {
@@ -290,6 +410,12 @@ func (c *Conn) ReadEphemeralPacket() ([]byte, error) {
 	if _, err := io.ReadFull(r, data); err != nil {
 		return nil, errors.Wrapf(err, "io.ReadFull(packet body of length %v) failed", length)
 	}
+	return c.readRemainingPackets(data)
+}
+
+// readRemainingPackets reads the packets following a packet of exactly size
+// MaxPacketSize and appends them to data, until a shorter packet.
+func (c *Conn) readRemainingPackets(data []byte) ([]byte, error) {
 	for {
 		next, err := c.ReadOnePacket()
 		if err != nil {
@@ -301,6 +427,9 @@ func (c *Conn) ReadEphemeralPacket() ([]byte, error) {
 			break
 		}
 
+		if err := c.checkPacketLength(len(data) + len(next)); err != nil {
+			return nil, err
+		}
 		data = append(data, next...)
 		if len(next) < constant.MaxPacketSize {
 			break
@@ -360,6 +489,14 @@ func (c *Conn) RecycleReadPacket() {
 	c.currentEphemeralPolicy = ephemeralUnused
 }
 
+// DiscardReadPacket recycles the packet a failed read left, if any, as a read failing on the
+// header leaves none, so that the connection can be written to.
+func (c *Conn) DiscardReadPacket() {
+	if c.currentEphemeralPolicy == ephemeralRead {
+		c.RecycleReadPacket()
+	}
+}
+
 // ReadOnePacket reads a single packet into a newly allocated buffer.
 func (c *Conn) ReadOnePacket() ([]byte, error) {
 	r := c.getReader()
@@ -372,6 +509,9 @@ func (c *Conn) ReadOnePacket() ([]byte, error) {
 		// exactly size MaxPacketSize.
 		return nil, nil
 	}
+	if err := c.checkPacketLength(length); err != nil {
+		return nil, err
+	}
 
 	data := make([]byte, length)
 	if _, err := io.ReadFull(r, data); err != nil {
@@ -396,24 +536,7 @@ func (c *Conn) ReadPacket() ([]byte, error) {
 	}
 
 	// There is more than one packet, read them all.
-	for {
-		next, err := c.ReadOnePacket()
-		if err != nil {
-			return nil, err
-		}
-
-		if len(next) == 0 {
-			// Again, the packet after a packet of exactly size MaxPacketSize.
-			break
-		}
-
-		data = append(data, next...)
-		if len(next) < constant.MaxPacketSize {
-			break
-		}
-	}
-
-	return data, nil
+	return c.readRemainingPackets(data)
 }
 
 // ReadPacketFacade reads a packet from the underlying connection.
@@ -431,12 +554,16 @@ func (c *Conn) ReadPacketFacade() ([]byte, error) {
 // ParseRow parses an individual row.
 // Returns a SQLError.
 func (c *Conn) ParseRow(ctx context.Context, data []byte, fields []*Field) (proto.Row, error) {
-	row := &row{
+	return newRow(ctx, &row{
 		Content: data,
 		ResultSet: &ResultSet{
 			Columns: fields,
 		},
-	}
+	})
+}
+
+// newRow wraps row as a text or binary row depending on the command type of ctx.
+func newRow(ctx context.Context, row *row) (proto.Row, error) {
 	switch proto.CommandType(ctx) {
 	case constant.ComQuery:
 		return &TextRow{row: row}, nil
@@ -478,8 +605,8 @@ func (c *Conn) WritePacket(data []byte) error {
 	w, unget := c.getWriter()
 	defer unget()
 
-	if c.ReadTimeout != 0 {
-		err := c.conn.SetReadDeadline(time.Now().Add(c.ReadTimeout))
+	if c.ReadTimeout != 0 || !c.deadline.IsZero() {
+		err := c.conn.SetReadDeadline(c.ioDeadline(c.ReadTimeout))
 		if err != nil {
 			return err
 		}
@@ -503,8 +630,8 @@ func (c *Conn) WritePacket(data []byte) error {
 		header[2] = byte(packetLength >> 16)
 		header[3] = c.sequence
 
-		if c.WriteTimeout > 0 {
-			if err := c.conn.SetWriteDeadline(time.Now().Add(c.WriteTimeout)); err != nil {
+		if c.WriteTimeout > 0 || !c.deadline.IsZero() {
+			if err := c.conn.SetWriteDeadline(c.ioDeadline(c.WriteTimeout)); err != nil {
 				return err
 			}
 		}
@@ -541,6 +668,9 @@ func (c *Conn) WritePacket(data []byte) error {
 				}
 				c.sequence++
 			}
+			if compressor, ok := w.(*compressor); ok {
+				return compressor.Flush()
+			}
 			return nil
 		}
 		index += packetLength
@@ -870,14 +1000,28 @@ func (c *Conn) WriteBinaryRows(result *Result) error {
 	return nil
 }
 
+// WriteRows sends the rows of a Result. The rows never decoded are forwarded as they were
+// read from the backend, only the decoded ones are encoded again from their values.
 func (c *Conn) WriteRows(result *Result) error {
 	for _, row := range result.Rows {
 		switch r := row.(type) {
 		case *TextRow:
+			if !r.decoded {
+				if err := c.WritePacket(r.Content); err != nil {
+					return err
+				}
+				continue
+			}
 			if err := c.writeTextRow(r.Values); err != nil {
 				return err
 			}
 		case *BinaryRow:
+			if !r.decoded {
+				if err := c.WritePacket(r.Content); err != nil {
+					return err
+				}
+				continue
+			}
 			if err := c.writeBinaryRows(result.Fields, r.Values); err != nil {
 				return err
 			}
@@ -889,12 +1033,17 @@ func (c *Conn) WriteRows(result *Result) error {
 // WriteEndResult concludes the sending of a Result.
 // if more is set to true, then it means there are more results afterwords
 func (c *Conn) WriteEndResult(capabilities uint32, more bool, affectedRows, lastInsertID uint64, warnings uint16) error {
-	// Send either an EOF, or an OK packet.
-	// See doc.go.
 	flags := c.statusFlags
 	if more {
 		flags |= constant.ServerMoreResultsExists
 	}
+	return c.WriteEndResultWithFlags(capabilities, flags, affectedRows, lastInsertID, warnings)
+}
+
+// WriteEndResultWithFlags concludes the sending of a Result with the status flags given.
+func (c *Conn) WriteEndResultWithFlags(capabilities uint32, flags uint16, affectedRows, lastInsertID uint64, warnings uint16) error {
+	// Send either an EOF, or an OK packet.
+	// See doc.go.
 	if capabilities&constant.CapabilityClientDeprecateEOF == 0 {
 		if err := c.WriteEOFPacket(flags, warnings); err != nil {
 			return err
@@ -933,6 +1082,37 @@ func (c *Conn) WriteOKPacket(affectedRows, lastInsertID uint64, flags uint16, wa
 	return c.WriteEphemeralPacket()
 }
 
+// WriteOKPacketWithSessionState writes an OK packet carrying the session state changes,
+// to a client with CapabilityClientSessionTrack. It writes a plain OK packet if there
+// are no changes.
+// Server -> Client.
+// This method returns a generic error, not a SQLError.
+func (c *Conn) WriteOKPacketWithSessionState(affectedRows, lastInsertID uint64, flags uint16, warnings uint16, sessionState []byte) error {
+	if len(sessionState) == 0 {
+		return c.WriteOKPacket(affectedRows, lastInsertID, flags, warnings)
+	}
+	flags |= constant.ServerSessionStateChanged
+	length := 1 + // OKPacket
+		misc.LenEncIntSize(affectedRows) +
+		misc.LenEncIntSize(lastInsertID) +
+		2 + // flags
+		2 + // warnings
+		1 + // empty info
+		misc.LenEncIntSize(uint64(len(sessionState))) + len(sessionState)
+	data := c.StartEphemeralPacket(length)
+	pos := 0
+	pos = misc.WriteByte(data, pos, constant.OKPacket)
+	pos = misc.WriteLenEncInt(data, pos, affectedRows)
+	pos = misc.WriteLenEncInt(data, pos, lastInsertID)
+	pos = misc.WriteUint16(data, pos, flags)
+	pos = misc.WriteUint16(data, pos, warnings)
+	pos = misc.WriteLenEncInt(data, pos, 0)
+	pos = misc.WriteLenEncInt(data, pos, uint64(len(sessionState)))
+	copy(data[pos:], sessionState)
+
+	return c.WriteEphemeralPacket()
+}
+
 // WriteOKPacketWithEOFHeader writes an OK packet with an EOF header.
 // This is used at the end of a result set if
 // CapabilityClientDeprecateEOF is set.
@@ -978,14 +1158,12 @@ func (c *Conn) WriteErrorPacket(errorCode uint16, sqlState string, format string
 	return c.WriteEphemeralPacket()
 }
 
-// WriteErrorPacketFromError writes an error packet, from a regular error.
+// WriteErrorPacketFromError writes an error packet, from a regular error, which is translated
+// into the error code and SQLSTATE of its kind.
 // See WriteErrorPacket for other info.
 func (c *Conn) WriteErrorPacketFromError(err error) error {
-	if se, ok := err.(*err2.SQLError); ok {
-		return c.WriteErrorPacket(uint16(se.Num), se.State, "%v", se.Message)
-	}
-
-	return c.WriteErrorPacket(constant.ERUnknownError, constant.SSUnknownSQLState, "unknown error: %v", err)
+	se := err2.Translate(err)
+	return c.WriteErrorPacket(uint16(se.Num), se.State, "%v", se.Message)
 }
 
 // WriteEOFPacket writes an EOF packet, through the buffer, and
@@ -1009,6 +1187,15 @@ func (c *Conn) GetTLSClientCerts() []*x509.Certificate {
 	return nil
 }
 
+// TLSConnectionState returns the TLS state of the connection, nil if it is not over TLS.
+func (c *Conn) TLSConnectionState() *tls.ConnectionState {
+	if tlsConn, ok := c.conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		return &state
+	}
+	return nil
+}
+
 func (c *Conn) SetConnectionID(connectionID uint32) {
 	c.connectionID = connectionID
 }
@@ -1017,6 +1204,14 @@ func (c *Conn) SetUserName(userName string) {
 	c.userName = userName
 }
 
+func (c *Conn) SetSchema(schema string) {
+	c.schema = schema
+}
+
+func (c *Conn) SetCapabilities(capabilities uint32) {
+	c.capabilities = capabilities
+}
+
 func (c *Conn) SetReadTimeout(readTimeout time.Duration) {
 	c.ReadTimeout = readTimeout
 }
@@ -1025,6 +1220,33 @@ func (c *Conn) SetWriteTimeout(writeTimeout time.Duration) {
 	c.WriteTimeout = writeTimeout
 }
 
+// SetStatementDeadline bounds the writing of the statement in flight and the reading of its result
+// by deadline, a zero deadline clears it.
+func (c *Conn) SetStatementDeadline(deadline time.Time) error {
+	cleared := deadline.IsZero() && !c.deadline.IsZero()
+	c.deadline = deadline
+	if cleared {
+		return c.conn.SetDeadline(time.Time{})
+	}
+	return nil
+}
+
+// StatementDeadline returns the deadline of the statement in flight, zero if there is none.
+func (c *Conn) StatementDeadline() time.Time {
+	return c.deadline
+}
+
+// ioDeadline returns the earlier of the time timeout from now and the statement deadline.
+func (c *Conn) ioDeadline(timeout time.Duration) time.Time {
+	deadline := c.deadline
+	if timeout > 0 {
+		if next := time.Now().Add(timeout); deadline.IsZero() || next.Before(deadline) {
+			deadline = next
+		}
+	}
+	return deadline
+}
+
 // RemoteAddr returns the underlying socket RemoteAddr().
 func (c *Conn) RemoteAddr() net.Addr {
 	return c.conn.RemoteAddr()
@@ -1039,6 +1261,14 @@ func (c *Conn) UserName() string {
 	return c.userName
 }
 
+func (c *Conn) Schema() string {
+	return c.schema
+}
+
+func (c *Conn) Capabilities() uint32 {
+	return c.capabilities
+}
+
 func (c *Conn) StatusFlags() uint16 {
 	return c.statusFlags
 }
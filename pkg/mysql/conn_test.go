@@ -0,0 +1,133 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	err2 "github.com/cectc/dbpack/pkg/errors"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+func TestLargePackets(t *testing.T) {
+	large := bytes.Repeat([]byte{'x'}, 2*constant.MaxPacketSize+10)
+
+	testCases := []struct {
+		name             string
+		data             []byte
+		maxAllowedPacket int
+		tooLarge         bool
+	}{
+		{name: "exactly max packet size", data: large[:constant.MaxPacketSize]},
+		{name: "split into three packets", data: large},
+		{name: "within max allowed packet", data: large[:1000], maxAllowedPacket: 1000},
+		{name: "exceeds max allowed packet", data: large[:1001], maxAllowedPacket: 1000, tooLarge: true},
+		{name: "split packets exceed max allowed packet", data: large, maxAllowedPacket: 2 * constant.MaxPacketSize, tooLarge: true},
+	}
+	for _, c := range testCases {
+		for _, ephemeral := range []bool{false, true} {
+			t.Run(c.name, func(t *testing.T) {
+				clientConn, serverConn := net.Pipe()
+				client, server := NewConn(clientConn), NewConn(serverConn)
+				defer client.Close()
+				defer server.Close()
+				server.SetMaxAllowedPacket(c.maxAllowedPacket)
+
+				go client.WritePacket(c.data)
+
+				var (
+					data []byte
+					err  error
+				)
+				if ephemeral {
+					data, err = server.ReadEphemeralPacket()
+					defer server.RecycleReadPacket()
+				} else {
+					data, err = server.ReadPacket()
+				}
+				if c.tooLarge {
+					sqlErr, ok := err.(*err2.SQLError)
+					assert.True(t, ok)
+					assert.Equal(t, constant.ERNetPacketTooLarge, sqlErr.Num)
+					return
+				}
+				assert.NoError(t, err)
+				assert.True(t, bytes.Equal(c.data, data))
+			})
+		}
+	}
+}
+
+func TestWriteRows(t *testing.T) {
+	fields := []*Field{
+		{Name: "id", FieldType: constant.FieldTypeLong},
+		{Name: "name", FieldType: constant.FieldTypeVarString},
+	}
+	ctx := proto.WithCommandType(context.Background(), constant.ComQuery)
+
+	testCases := []struct {
+		name     string
+		content  []byte
+		rewrite  []byte
+		expected []byte
+	}{
+		{
+			name:     "undecoded row forwarded as is",
+			content:  []byte{0x01, '1', 0x03, 'a', 'b', 'c'},
+			expected: []byte{0x01, '1', 0x03, 'a', 'b', 'c'},
+		},
+		{
+			name:     "undecoded null value forwarded as is",
+			content:  []byte{0x01, '2', 0xfb},
+			expected: []byte{0x01, '2', 0xfb},
+		},
+		{
+			name:     "decoded row encoded from values",
+			content:  []byte{0x01, '1', 0x03, 'a', 'b', 'c'},
+			rewrite:  []byte("xyzw"),
+			expected: []byte{0x01, '1', 0x04, 'x', 'y', 'z', 'w'},
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			client, server := NewConn(clientConn), NewConn(serverConn)
+			defer client.Close()
+			defer server.Close()
+
+			row, err := server.ParseRow(ctx, c.content, fields)
+			assert.NoError(t, err)
+			if c.rewrite != nil {
+				values, err := row.Decode()
+				assert.NoError(t, err)
+				values[1].Val = c.rewrite
+			}
+
+			go server.WriteRows(&Result{Fields: fields, Rows: []proto.Row{row}})
+
+			data, err := client.ReadPacket()
+			assert.NoError(t, err)
+			assert.Equal(t, c.expected, data)
+		})
+	}
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mysql
+
+import (
+	"sync"
+
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+// rowChunkSize is the size of the chunks the contents of the result rows are copied to,
+// a row larger than a quarter of a chunk is allocated on its own.
+const rowChunkSize = 64 * 1024
+
+// rowChunkPool is used for pooling the chunks the contents of the result rows are copied to.
+var rowChunkPool = sync.Pool{New: func() interface{} {
+	chunk := make([]byte, 0, rowChunkSize)
+	return &chunk
+}}
+
+// rowValuesPool is used for pooling the values of the decoded result rows.
+var rowValuesPool = sync.Pool{New: func() interface{} { return &rowValues{} }}
+
+// rowValues are the values of a decoded row, allocated at once.
+type rowValues struct {
+	values   []proto.Value
+	pointers []*proto.Value
+}
+
+// resultBuffers tracks the pooled buffers held by the rows of a Result,
+// they are returned to the pools by Result.Release.
+type resultBuffers struct {
+	mu     sync.Mutex
+	chunks []*[]byte
+	values []*rowValues
+}
+
+// copyRow copies the content of a row to the current chunk.
+func (b *resultBuffers) copyRow(data []byte) []byte {
+	if len(data) > rowChunkSize/4 {
+		content := make([]byte, len(data))
+		copy(content, data)
+		return content
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var chunk *[]byte
+	if n := len(b.chunks); n > 0 {
+		chunk = b.chunks[n-1]
+	}
+	if chunk == nil || cap(*chunk)-len(*chunk) < len(data) {
+		chunk = rowChunkPool.Get().(*[]byte)
+		b.chunks = append(b.chunks, chunk)
+	}
+	start := len(*chunk)
+	*chunk = append(*chunk, data...)
+	// cap the content so that appending to it never overwrites the next row
+	return (*chunk)[start:len(*chunk):len(*chunk)]
+}
+
+// newValues returns n values taken from the pool.
+func (b *resultBuffers) newValues(n int) []*proto.Value {
+	rv := rowValuesPool.Get().(*rowValues)
+	if cap(rv.values) < n {
+		rv.values = make([]proto.Value, n)
+		rv.pointers = make([]*proto.Value, n)
+	}
+	rv.values = rv.values[:n]
+	rv.pointers = rv.pointers[:n]
+	for i := range rv.pointers {
+		rv.pointers[i] = &rv.values[i]
+	}
+
+	b.mu.Lock()
+	b.values = append(b.values, rv)
+	b.mu.Unlock()
+	return rv.pointers
+}
+
+func (b *resultBuffers) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, chunk := range b.chunks {
+		*chunk = (*chunk)[:0]
+		rowChunkPool.Put(chunk)
+	}
+	for _, rv := range b.values {
+		// drop the references to the contents so that the pool does not retain them
+		for i := range rv.values {
+			rv.values[i] = proto.Value{}
+		}
+		for i := range rv.pointers {
+			rv.pointers[i] = nil
+		}
+		rowValuesPool.Put(rv)
+	}
+	b.chunks = nil
+	b.values = nil
+}
+
+// newValues returns the values of a row to decode, taken from the pool if the row
+// belongs to a Result reading its rows into pooled buffers.
+func (rs *ResultSet) newValues(n int) []*proto.Value {
+	if rs.buffers != nil {
+		return rs.buffers.newValues(n)
+	}
+	values := make([]proto.Value, n)
+	pointers := make([]*proto.Value, n)
+	for i := range pointers {
+		pointers[i] = &values[i]
+	}
+	return pointers
+}
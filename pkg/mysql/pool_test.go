@@ -0,0 +1,99 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+func TestResultAppendRow(t *testing.T) {
+	fields := []*Field{
+		{Name: "id", FieldType: constant.FieldTypeLong},
+		{Name: "name", FieldType: constant.FieldTypeVarString},
+	}
+	ctx := proto.WithCommandType(context.Background(), constant.ComQuery)
+	large := append([]byte{0x01, '1', 0xfc, 0x00, 0x40}, bytes.Repeat([]byte{'x'}, 0x4000)...)
+
+	testCases := []struct {
+		name    string
+		rows    [][]byte
+		chunks  int
+		names   []string
+		release bool
+	}{
+		{
+			name:   "rows share a chunk",
+			rows:   [][]byte{{0x01, '1', 0x03, 'a', 'b', 'c'}, {0x01, '2', 0xfb}},
+			chunks: 1,
+			names:  []string{"abc", ""},
+		},
+		{
+			name:   "large row allocated on its own",
+			rows:   [][]byte{{0x01, '1', 0x03, 'a', 'b', 'c'}, large},
+			chunks: 1,
+			names:  []string{"abc", string(large[5:])},
+		},
+		{
+			name:    "released",
+			rows:    [][]byte{{0x01, '1', 0x03, 'a', 'b', 'c'}},
+			chunks:  1,
+			names:   []string{"abc"},
+			release: true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			result := &Result{Fields: fields}
+			for _, data := range c.rows {
+				data = append([]byte(nil), data...)
+				assert.NoError(t, result.AppendRow(ctx, data))
+				// the content of the row is copied
+				for i := range data {
+					data[i] = 0
+				}
+			}
+			assert.Len(t, result.Rows, len(c.rows))
+			assert.Len(t, result.buffers.chunks, c.chunks)
+
+			for i, row := range result.Rows {
+				assert.Equal(t, c.rows[i], row.Data())
+				values, err := row.Decode()
+				assert.NoError(t, err)
+				assert.Len(t, values, len(fields))
+				if c.names[i] == "" {
+					assert.Nil(t, values[1].Val)
+				} else {
+					assert.Equal(t, []byte(c.names[i]), values[1].Val)
+				}
+			}
+			assert.Len(t, result.buffers.values, len(c.rows))
+
+			if c.release {
+				result.Release()
+				assert.Nil(t, result.buffers.chunks)
+				assert.Nil(t, result.buffers.values)
+			}
+		})
+	}
+}
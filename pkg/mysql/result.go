@@ -16,13 +16,61 @@
 
 package mysql
 
-import "github.com/cectc/dbpack/pkg/proto"
+import (
+	"context"
+
+	"github.com/cectc/dbpack/pkg/proto"
+)
 
 type Result struct {
 	Fields       []*Field // Columns information
 	AffectedRows uint64
 	InsertId     uint64
 	Rows         []proto.Row
+	// SessionState is the session state changes of the OK packet, see packet.ParseSessionStateChanges
+	SessionState []byte
+	// OutParams reports whether the rows are the output parameters of a stored procedure called
+	// by COM_STMT_EXECUTE
+	OutParams bool
+	// Next is the result following this one when a statement returns several of them, such as a CALL,
+	// whose result sets are followed by the OK packet of the procedure, nil if this is the last one
+	Next *Result
+
+	// resultSet is shared by the rows appended by AppendRow
+	resultSet *ResultSet
+	buffers   *resultBuffers
+}
+
+// AppendRow copies data into the pooled buffers of the result and appends the row parsed from it,
+// the rows share the fields of the result, which must be set before.
+func (res *Result) AppendRow(ctx context.Context, data []byte) error {
+	if res.resultSet == nil {
+		res.buffers = &resultBuffers{}
+		res.resultSet = &ResultSet{
+			Columns: res.Fields,
+			buffers: res.buffers,
+		}
+	}
+	row, err := newRow(ctx, &row{
+		Content:   res.buffers.copyRow(data),
+		ResultSet: res.resultSet,
+	})
+	if err != nil {
+		return err
+	}
+	res.Rows = append(res.Rows, row)
+	return nil
+}
+
+// Release returns the pooled buffers of the rows and of the following results to the pools, neither
+// the rows nor their values must be used afterwards. It is a no-op for results not built by AppendRow.
+func (res *Result) Release() {
+	if res.buffers != nil {
+		res.buffers.release()
+	}
+	if res.Next != nil {
+		res.Next.Release()
+	}
 }
 
 func (res *Result) LastInsertId() (uint64, error) {
@@ -31,6 +31,9 @@ import (
 type ResultSet struct {
 	Columns     []*Field
 	ColumnNames []string
+
+	// buffers is set if the rows are read into the pooled buffers of a Result.
+	buffers *resultBuffers
 }
 
 type row struct {
@@ -50,6 +53,42 @@ type TextRow struct {
 	Values  []*proto.Value
 }
 
+// NewTextRow creates a decoded text row from values, it is used to build result sets produced by dbpack itself.
+func NewTextRow(fields []*Field, values []*proto.Value) *TextRow {
+	columnNames := make([]string, 0, len(fields))
+	for _, field := range fields {
+		columnNames = append(columnNames, field.Name)
+	}
+	return &TextRow{
+		row: &row{
+			ResultSet: &ResultSet{
+				Columns:     fields,
+				ColumnNames: columnNames,
+			},
+		},
+		decoded: true,
+		Values:  values,
+	}
+}
+
+// NewBinaryRow creates a decoded binary row from values, the same as NewTextRow does for text rows.
+func NewBinaryRow(fields []*Field, values []*proto.Value) *BinaryRow {
+	columnNames := make([]string, 0, len(fields))
+	for _, field := range fields {
+		columnNames = append(columnNames, field.Name)
+	}
+	return &BinaryRow{
+		row: &row{
+			ResultSet: &ResultSet{
+				Columns:     fields,
+				ColumnNames: columnNames,
+			},
+		},
+		decoded: true,
+		Values:  values,
+	}
+}
+
 func (row *row) Columns() []string {
 	if row.ResultSet.ColumnNames != nil {
 		return row.ResultSet.ColumnNames
@@ -94,61 +133,58 @@ func (row *TextRow) Decode() ([]*proto.Value, error) {
 	}
 	row.decoded = true
 
-	dest := make([]*proto.Value, len(row.ResultSet.Columns))
+	dest := row.ResultSet.newValues(len(row.ResultSet.Columns))
 
 	// RowSet Packet
-	var val []byte
-	var isNull bool
-	var n int
-	var err error
 	pos := 0
-
 	for i := 0; i < len(row.ResultSet.Columns); i++ {
-		field := row.ResultSet.Columns[i]
-
-		// Read bytes and convert to string
-		val, isNull, n, err = misc.ReadLengthEncodedString(row.Content[pos:])
-		dest[i] = &proto.Value{
-			Typ:   field.FieldType,
-			Flags: field.Flags,
-			Len:   n,
-			Val:   val,
-			Raw:   val,
+		n, err := decodeTextValue(row.ResultSet.Columns[i], row.Content[pos:], dest[i])
+		if err != nil {
+			return nil, err
 		}
 		pos += n
-		if err == nil {
-			if !isNull {
-				switch field.FieldType {
-				case constant.FieldTypeTimestamp, constant.FieldTypeDateTime,
-					constant.FieldTypeDate, constant.FieldTypeNewDate:
-					dest[i].Val, err = misc.ParseDateTime(
-						val,
-						time.Local,
-					)
-					if err == nil {
-						continue
-					}
-				default:
-					continue
-				}
-			} else {
-				dest[i].Val = nil
-				continue
-			}
-		}
-		return nil, err // err != nil
 	}
 	row.Values = dest
 	return dest, nil
 }
 
+// decodeTextValue decodes the first value of data, which is a text row or what follows
+// the values before in it, into dest and returns the length of the value.
+func decodeTextValue(field *Field, data []byte, dest *proto.Value) (int, error) {
+	// Read bytes and convert to string
+	val, isNull, n, err := misc.ReadLengthEncodedString(data)
+	*dest = proto.Value{
+		Typ:   field.FieldType,
+		Flags: field.Flags,
+		Len:   n,
+		Val:   val,
+		Raw:   val,
+	}
+	if err != nil {
+		return n, err
+	}
+	if isNull {
+		dest.Val = nil
+		return n, nil
+	}
+	switch field.FieldType {
+	case constant.FieldTypeTimestamp, constant.FieldTypeDateTime,
+		constant.FieldTypeDate, constant.FieldTypeNewDate:
+		dest.Val, err = misc.ParseDateTime(
+			val,
+			time.Local,
+		)
+	}
+	return n, err
+}
+
 func (row *BinaryRow) Decode() ([]*proto.Value, error) {
 	if row.decoded {
 		return row.Values, nil
 	}
 	row.decoded = true
 
-	dest := make([]*proto.Value, len(row.ResultSet.Columns))
+	dest := row.ResultSet.newValues(len(row.ResultSet.Columns))
 
 	if row.Content[0] != constant.OKPacket {
 		return nil, errors.NewSQLError(constant.CRMalformedPacket, constant.SSUnknownSQLState, "read binary row (%v) failed", row)
@@ -170,7 +206,7 @@ func (row *BinaryRow) Decode() ([]*proto.Value, error) {
 		// Convert to byte-coded string
 		switch field.FieldType {
 		case constant.FieldTypeNULL:
-			dest[i] = &proto.Value{
+			*dest[i] = proto.Value{
 				Typ:   field.FieldType,
 				Flags: field.Flags,
 				Len:   1,
@@ -181,7 +217,7 @@ func (row *BinaryRow) Decode() ([]*proto.Value, error) {
 
 		// Numeric Types
 		case constant.FieldTypeTiny:
-			dest[i] = &proto.Value{
+			*dest[i] = proto.Value{
 				Typ:   field.FieldType,
 				Flags: field.Flags,
 				Len:   1,
@@ -192,7 +228,7 @@ func (row *BinaryRow) Decode() ([]*proto.Value, error) {
 			continue
 
 		case constant.FieldTypeUint8:
-			dest[i] = &proto.Value{
+			*dest[i] = proto.Value{
 				Typ:   field.FieldType,
 				Flags: field.Flags,
 				Len:   1,
@@ -203,29 +239,29 @@ func (row *BinaryRow) Decode() ([]*proto.Value, error) {
 			continue
 
 		case constant.FieldTypeShort, constant.FieldTypeYear:
-			dest[i] = &proto.Value{
+			*dest[i] = proto.Value{
 				Typ:   field.FieldType,
 				Flags: field.Flags,
 				Len:   2,
 				Val:   int64(int16(binary.LittleEndian.Uint16(row.Content[pos : pos+2]))),
-				Raw:   row.Content[pos : pos+1],
+				Raw:   row.Content[pos : pos+2],
 			}
 			pos += 2
 			continue
 
 		case constant.FieldTypeUint16:
-			dest[i] = &proto.Value{
+			*dest[i] = proto.Value{
 				Typ:   field.FieldType,
 				Flags: field.Flags,
 				Len:   2,
 				Val:   int64(binary.LittleEndian.Uint16(row.Content[pos : pos+2])),
-				Raw:   row.Content[pos : pos+1],
+				Raw:   row.Content[pos : pos+2],
 			}
 			pos += 2
 			continue
 
 		case constant.FieldTypeInt24, constant.FieldTypeLong:
-			dest[i] = &proto.Value{
+			*dest[i] = proto.Value{
 				Typ:   field.FieldType,
 				Flags: field.Flags,
 				Len:   4,
@@ -236,7 +272,7 @@ func (row *BinaryRow) Decode() ([]*proto.Value, error) {
 			continue
 
 		case constant.FieldTypeUint24, constant.FieldTypeUint32:
-			dest[i] = &proto.Value{
+			*dest[i] = proto.Value{
 				Typ:   field.FieldType,
 				Flags: field.Flags,
 				Len:   4,
@@ -247,7 +283,7 @@ func (row *BinaryRow) Decode() ([]*proto.Value, error) {
 			continue
 
 		case constant.FieldTypeLongLong:
-			dest[i] = &proto.Value{
+			*dest[i] = proto.Value{
 				Typ:   field.FieldType,
 				Flags: field.Flags,
 				Len:   8,
@@ -258,29 +294,19 @@ func (row *BinaryRow) Decode() ([]*proto.Value, error) {
 			continue
 
 		case constant.FieldTypeUint64:
-			val := binary.LittleEndian.Uint64(row.Content[pos : pos+8])
-			if val > math.MaxInt64 {
-				dest[i] = &proto.Value{
-					Typ:   field.FieldType,
-					Flags: field.Flags,
-					Len:   8,
-					Val:   misc.Uint64ToString(val),
-					Raw:   row.Content[pos : pos+8],
-				}
-			} else {
-				dest[i] = &proto.Value{
-					Typ:   field.FieldType,
-					Flags: field.Flags,
-					Len:   8,
-					Val:   int64(val),
-					Raw:   row.Content[pos : pos+8],
-				}
+			// the values of BIGINT UNSIGNED are uint64, those above math.MaxInt64 included
+			*dest[i] = proto.Value{
+				Typ:   field.FieldType,
+				Flags: field.Flags,
+				Len:   8,
+				Val:   binary.LittleEndian.Uint64(row.Content[pos : pos+8]),
+				Raw:   row.Content[pos : pos+8],
 			}
 			pos += 8
 			continue
 
 		case constant.FieldTypeFloat:
-			dest[i] = &proto.Value{
+			*dest[i] = proto.Value{
 				Typ:   field.FieldType,
 				Flags: field.Flags,
 				Len:   4,
@@ -291,7 +317,7 @@ func (row *BinaryRow) Decode() ([]*proto.Value, error) {
 			continue
 
 		case constant.FieldTypeDouble:
-			dest[i] = &proto.Value{
+			*dest[i] = proto.Value{
 				Typ:   field.FieldType,
 				Flags: field.Flags,
 				Len:   8,
@@ -311,7 +337,7 @@ func (row *BinaryRow) Decode() ([]*proto.Value, error) {
 			var n int
 			var err error
 			val, isNull, n, err = misc.ReadLengthEncodedString(row.Content[pos:])
-			dest[i] = &proto.Value{
+			*dest[i] = proto.Value{
 				Typ:   field.FieldType,
 				Flags: field.Flags,
 				Len:   n,
@@ -358,21 +384,21 @@ func (row *BinaryRow) Decode() ([]*proto.Value, error) {
 					)
 				}
 				val, err = misc.FormatBinaryTime(row.Content[pos:pos+int(num)], dstLen)
-				dest[i] = &proto.Value{
+				*dest[i] = proto.Value{
 					Typ:   field.FieldType,
 					Flags: field.Flags,
 					Len:   int(num),
 					Val:   val,
-					Raw:   row.Content[pos : pos+n],
+					Raw:   row.Content[pos : pos+int(num)],
 				}
 			default:
 				val, err = misc.ParseBinaryDateTime(num, row.Content[pos:], time.Local)
-				dest[i] = &proto.Value{
+				*dest[i] = proto.Value{
 					Typ:   field.FieldType,
 					Flags: field.Flags,
 					Len:   int(num),
 					Val:   val,
-					Raw:   row.Content[pos : pos+n],
+					Raw:   row.Content[pos : pos+int(num)],
 				}
 				if err == nil {
 					break
@@ -395,12 +421,12 @@ func (row *BinaryRow) Decode() ([]*proto.Value, error) {
 					}
 				}
 				val, err = misc.FormatBinaryDateTime(row.Content[pos:pos+int(num)], dstlen)
-				dest[i] = &proto.Value{
+				*dest[i] = proto.Value{
 					Typ:   field.FieldType,
 					Flags: field.Flags,
 					Len:   int(num),
 					Val:   val,
-					Raw:   row.Content[pos : pos+n],
+					Raw:   row.Content[pos : pos+int(num)],
 				}
 			}
 
@@ -0,0 +1,589 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mysqlx
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// ColumnType is the type of the values of a column, Mysqlx.Resultset.ColumnMetaData.FieldType.
+type ColumnType uint32
+
+const (
+	TypeSint     ColumnType = 1
+	TypeUint     ColumnType = 2
+	TypeDouble   ColumnType = 5
+	TypeFloat    ColumnType = 6
+	TypeBytes    ColumnType = 7
+	TypeTime     ColumnType = 10
+	TypeDatetime ColumnType = 12
+	TypeSet      ColumnType = 15
+	TypeEnum     ColumnType = 16
+	TypeBit      ColumnType = 17
+	TypeDecimal  ColumnType = 18
+)
+
+// The content types of the bytes columns, Mysqlx.Resultset.ContentType_BYTES.
+const (
+	ContentTypeGeometry uint32 = 1
+	ContentTypeJSON     uint32 = 2
+)
+
+// The params of the session state changed notices, Mysqlx.Notice.SessionStateChanged.Parameter.
+const (
+	ParamCurrentSchema     uint32 = 1
+	ParamGeneratedInsertID uint32 = 3
+	ParamRowsAffected      uint32 = 4
+	ParamClientIDAssigned  uint32 = 11
+)
+
+const (
+	// noticeSessionStateChanged is the type of the session state changed notices, Mysqlx.Notice.Frame.Type
+	noticeSessionStateChanged uint32 = 3
+	// scopeLocal is the scope of the notices of the session, Mysqlx.Notice.Frame.Scope
+	scopeLocal uint32 = 2
+
+	// the types of Mysqlx.Datatypes.Any
+	anyScalar = 1
+	anyObject = 2
+	anyArray  = 3
+
+	// the types of Mysqlx.Datatypes.Scalar
+	scalarSint   = 1
+	scalarUint   = 2
+	scalarNull   = 3
+	scalarOctets = 4
+	scalarDouble = 5
+	scalarFloat  = 6
+	scalarBool   = 7
+	scalarString = 8
+
+	// SeverityError and SeverityFatal are the severities of the errors, an error is fatal if the
+	// connection is closed after it
+	SeverityError uint32 = 0
+	SeverityFatal uint32 = 1
+)
+
+// ErrMalformedMessage is the cause of the errors of the messages which can not be decoded.
+var ErrMalformedMessage = errors.New("malformed message")
+
+// Column is a column of a result, Mysqlx.Resultset.ColumnMetaData.
+type Column struct {
+	Type             ColumnType
+	Name             string
+	OriginalName     string
+	Table            string
+	OriginalTable    string
+	Schema           string
+	Collation        uint64
+	FractionalDigits uint32
+	Length           uint32
+	Flags            uint32
+	ContentType      uint32
+}
+
+// StmtExecute is a statement sent by the client, Mysqlx.Sql.StmtExecute. The args are scalars
+// decoded as nil, int64, uint64, float64, bool, string or []byte.
+type StmtExecute struct {
+	Namespace string
+	Stmt      string
+	Args      []interface{}
+}
+
+// AuthenticateStart starts the authentication, Mysqlx.Session.AuthenticateStart.
+type AuthenticateStart struct {
+	MechName        string
+	AuthData        []byte
+	InitialResponse []byte
+}
+
+// eachField calls f with the number and the value of each field of a message, v is set for the
+// numeric fields and data for the length delimited ones.
+func eachField(b []byte, f func(num protowire.Number, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return errors.Wrap(ErrMalformedMessage, protowire.ParseError(n).Error())
+		}
+		b = b[n:]
+		var (
+			v    uint64
+			data []byte
+		)
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed32Type:
+			var v32 uint32
+			v32, n = protowire.ConsumeFixed32(b)
+			v = uint64(v32)
+		case protowire.Fixed64Type:
+			v, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			data, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return errors.Wrap(ErrMalformedMessage, protowire.ParseError(n).Error())
+		}
+		b = b[n:]
+		if err := f(num, v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseAuthenticateStart decodes Mysqlx.Session.AuthenticateStart.
+func ParseAuthenticateStart(payload []byte) (*AuthenticateStart, error) {
+	start := &AuthenticateStart{}
+	err := eachField(payload, func(num protowire.Number, v uint64, data []byte) error {
+		switch num {
+		case 1:
+			start.MechName = string(data)
+		case 2:
+			start.AuthData = data
+		case 3:
+			start.InitialResponse = data
+		}
+		return nil
+	})
+	return start, err
+}
+
+// ParseAuthenticateContinue decodes the auth data of Mysqlx.Session.AuthenticateContinue.
+func ParseAuthenticateContinue(payload []byte) ([]byte, error) {
+	var authData []byte
+	err := eachField(payload, func(num protowire.Number, v uint64, data []byte) error {
+		if num == 1 {
+			authData = data
+		}
+		return nil
+	})
+	return authData, err
+}
+
+// ParseCapabilitiesSet decodes the capabilities of Mysqlx.Connection.CapabilitiesSet by name.
+func ParseCapabilitiesSet(payload []byte) (map[string]interface{}, error) {
+	capabilities := make(map[string]interface{})
+	err := eachField(payload, func(num protowire.Number, v uint64, data []byte) error {
+		if num != 1 {
+			return nil
+		}
+		return eachField(data, func(num protowire.Number, v uint64, data []byte) error {
+			if num != 1 {
+				return nil
+			}
+			var (
+				name  string
+				value interface{}
+			)
+			err := eachField(data, func(num protowire.Number, v uint64, data []byte) (err error) {
+				switch num {
+				case 1:
+					name = string(data)
+				case 2:
+					value, err = parseAny(data)
+				}
+				return err
+			})
+			capabilities[name] = value
+			return err
+		})
+	})
+	return capabilities, err
+}
+
+// ParseStmtExecute decodes Mysqlx.Sql.StmtExecute.
+func ParseStmtExecute(payload []byte) (*StmtExecute, error) {
+	stmt := &StmtExecute{Namespace: "sql"}
+	err := eachField(payload, func(num protowire.Number, v uint64, data []byte) error {
+		switch num {
+		case 1:
+			stmt.Stmt = string(data)
+		case 2:
+			arg, err := parseAny(data)
+			if err != nil {
+				return err
+			}
+			stmt.Args = append(stmt.Args, arg)
+		case 3:
+			stmt.Namespace = string(data)
+		}
+		return nil
+	})
+	return stmt, err
+}
+
+// parseAny decodes Mysqlx.Datatypes.Any, the arrays are decoded as []interface{} and the objects
+// as map[string]interface{}.
+func parseAny(b []byte) (interface{}, error) {
+	var (
+		typ   uint64
+		value interface{}
+	)
+	err := eachField(b, func(num protowire.Number, v uint64, data []byte) (err error) {
+		switch num {
+		case 1:
+			typ = v
+		case 2:
+			value, err = parseScalar(data)
+		case 3:
+			object := make(map[string]interface{})
+			err = eachField(data, func(num protowire.Number, v uint64, data []byte) error {
+				if num != 1 {
+					return nil
+				}
+				var key string
+				var fieldValue interface{}
+				err := eachField(data, func(num protowire.Number, v uint64, data []byte) (err error) {
+					switch num {
+					case 1:
+						key = string(data)
+					case 2:
+						fieldValue, err = parseAny(data)
+					}
+					return err
+				})
+				object[key] = fieldValue
+				return err
+			})
+			value = object
+		case 4:
+			array := make([]interface{}, 0)
+			err = eachField(data, func(num protowire.Number, v uint64, data []byte) error {
+				if num != 1 {
+					return nil
+				}
+				element, err := parseAny(data)
+				array = append(array, element)
+				return err
+			})
+			value = array
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if typ < anyScalar || typ > anyArray {
+		return nil, errors.Wrapf(ErrMalformedMessage, "unknown any type %d", typ)
+	}
+	return value, nil
+}
+
+// parseScalar decodes Mysqlx.Datatypes.Scalar.
+func parseScalar(b []byte) (interface{}, error) {
+	var (
+		typ   uint64
+		value interface{}
+	)
+	err := eachField(b, func(num protowire.Number, v uint64, data []byte) error {
+		switch num {
+		case 1:
+			typ = v
+		case 2:
+			value = protowire.DecodeZigZag(v)
+		case 3:
+			value = v
+		case 5, 9:
+			// Octets and String, whose value is the field 1
+			var bytes []byte
+			err := eachField(data, func(num protowire.Number, v uint64, data []byte) error {
+				if num == 1 {
+					bytes = data
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if bytes == nil {
+				bytes = []byte{}
+			}
+			if num == 9 {
+				value = string(bytes)
+			} else {
+				value = bytes
+			}
+		case 6:
+			value = math.Float64frombits(v)
+		case 7:
+			value = float64(math.Float32frombits(uint32(v)))
+		case 8:
+			value = protowire.DecodeBool(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case scalarNull:
+		return nil, nil
+	case scalarSint, scalarUint, scalarOctets, scalarDouble, scalarFloat, scalarBool, scalarString:
+		return value, nil
+	default:
+		return nil, errors.Wrapf(ErrMalformedMessage, "unknown scalar type %d", typ)
+	}
+}
+
+// AppendCapabilities encodes Mysqlx.Connection.Capabilities, the values are scalars as those of appendScalar or []string.
+func AppendCapabilities(b []byte, names []string, capabilities map[string]interface{}) []byte {
+	for _, name := range names {
+		var capability []byte
+		capability = protowire.AppendTag(capability, 1, protowire.BytesType)
+		capability = protowire.AppendString(capability, name)
+		capability = protowire.AppendTag(capability, 2, protowire.BytesType)
+		capability = protowire.AppendBytes(capability, appendAny(nil, capabilities[name]))
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, capability)
+	}
+	return b
+}
+
+func appendAny(b []byte, value interface{}) []byte {
+	if values, ok := value.([]string); ok {
+		var array []byte
+		for _, v := range values {
+			array = protowire.AppendTag(array, 1, protowire.BytesType)
+			array = protowire.AppendBytes(array, appendAny(nil, v))
+		}
+		b = protowire.AppendTag(b, 1, protowire.VarintType)
+		b = protowire.AppendVarint(b, anyArray)
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		return protowire.AppendBytes(b, array)
+	}
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, anyScalar)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	return protowire.AppendBytes(b, appendScalar(nil, value))
+}
+
+// appendScalar encodes Mysqlx.Datatypes.Scalar of a nil, bool, int64, uint64, float64, string or []byte value.
+func appendScalar(b []byte, value interface{}) []byte {
+	switch v := value.(type) {
+	case bool:
+		b = protowire.AppendTag(b, 1, protowire.VarintType)
+		b = protowire.AppendVarint(b, scalarBool)
+		b = protowire.AppendTag(b, 8, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(v))
+	case int64:
+		b = protowire.AppendTag(b, 1, protowire.VarintType)
+		b = protowire.AppendVarint(b, scalarSint)
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeZigZag(v))
+	case uint64:
+		b = protowire.AppendTag(b, 1, protowire.VarintType)
+		b = protowire.AppendVarint(b, scalarUint)
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, v)
+	case float64:
+		b = protowire.AppendTag(b, 1, protowire.VarintType)
+		b = protowire.AppendVarint(b, scalarDouble)
+		b = protowire.AppendTag(b, 6, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(v))
+	case []byte:
+		var octets []byte
+		octets = protowire.AppendTag(octets, 1, protowire.BytesType)
+		octets = protowire.AppendBytes(octets, v)
+		b = protowire.AppendTag(b, 1, protowire.VarintType)
+		b = protowire.AppendVarint(b, scalarOctets)
+		b = protowire.AppendTag(b, 5, protowire.BytesType)
+		b = protowire.AppendBytes(b, octets)
+	case string:
+		var str []byte
+		str = protowire.AppendTag(str, 1, protowire.BytesType)
+		str = protowire.AppendString(str, v)
+		b = protowire.AppendTag(b, 1, protowire.VarintType)
+		b = protowire.AppendVarint(b, scalarString)
+		b = protowire.AppendTag(b, 9, protowire.BytesType)
+		b = protowire.AppendBytes(b, str)
+	default:
+		b = protowire.AppendTag(b, 1, protowire.VarintType)
+		b = protowire.AppendVarint(b, scalarNull)
+	}
+	return b
+}
+
+// AppendAuthenticateContinue encodes Mysqlx.Session.AuthenticateContinue.
+func AppendAuthenticateContinue(b []byte, authData []byte) []byte {
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	return protowire.AppendBytes(b, authData)
+}
+
+// AppendOk encodes Mysqlx.Ok.
+func AppendOk(b []byte, msg string) []byte {
+	if msg == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	return protowire.AppendString(b, msg)
+}
+
+// AppendError encodes Mysqlx.Error.
+func AppendError(b []byte, severity uint32, code uint32, sqlState, msg string) []byte {
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(severity))
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(code))
+	b = protowire.AppendTag(b, 3, protowire.BytesType)
+	b = protowire.AppendString(b, msg)
+	b = protowire.AppendTag(b, 4, protowire.BytesType)
+	return protowire.AppendString(b, sqlState)
+}
+
+// AppendSessionStateChanged encodes the Mysqlx.Notice.Frame of a change of the session state, value
+// is a scalar as those of appendScalar.
+func AppendSessionStateChanged(b []byte, param uint32, value interface{}) []byte {
+	var changed []byte
+	changed = protowire.AppendTag(changed, 1, protowire.VarintType)
+	changed = protowire.AppendVarint(changed, uint64(param))
+	changed = protowire.AppendTag(changed, 2, protowire.BytesType)
+	changed = protowire.AppendBytes(changed, appendScalar(nil, value))
+
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(noticeSessionStateChanged))
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(scopeLocal))
+	b = protowire.AppendTag(b, 3, protowire.BytesType)
+	return protowire.AppendBytes(b, changed)
+}
+
+// AppendColumnMetaData encodes Mysqlx.Resultset.ColumnMetaData.
+func AppendColumnMetaData(b []byte, column *Column) []byte {
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(column.Type))
+	for i, value := range []string{column.Name, column.OriginalName, column.Table, column.OriginalTable, column.Schema, "def"} {
+		b = protowire.AppendTag(b, protowire.Number(i+2), protowire.BytesType)
+		b = protowire.AppendString(b, value)
+	}
+	if column.Collation != 0 {
+		b = protowire.AppendTag(b, 8, protowire.VarintType)
+		b = protowire.AppendVarint(b, column.Collation)
+	}
+	for i, value := range []uint32{column.FractionalDigits, column.Length, column.Flags, column.ContentType} {
+		if value != 0 {
+			b = protowire.AppendTag(b, protowire.Number(i+9), protowire.VarintType)
+			b = protowire.AppendVarint(b, uint64(value))
+		}
+	}
+	return b
+}
+
+// AppendRow encodes Mysqlx.Resultset.Row, the fields are encoded by the Encode functions, a nil field is NULL.
+func AppendRow(b []byte, fields [][]byte) []byte {
+	for _, field := range fields {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, field)
+	}
+	return b
+}
+
+// EncodeSint encodes a value of a TypeSint column.
+func EncodeSint(v int64) []byte {
+	return protowire.AppendVarint(nil, protowire.EncodeZigZag(v))
+}
+
+// EncodeUint encodes a value of a TypeUint column.
+func EncodeUint(v uint64) []byte {
+	return protowire.AppendVarint(nil, v)
+}
+
+// EncodeDouble encodes a value of a TypeDouble column.
+func EncodeDouble(v float64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, math.Float64bits(v))
+	return b
+}
+
+// EncodeFloat encodes a value of a TypeFloat column.
+func EncodeFloat(v float32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, math.Float32bits(v))
+	return b
+}
+
+// EncodeBytes encodes a value of a TypeBytes column, which is followed by a byte telling it from NULL.
+func EncodeBytes(v []byte) []byte {
+	b := make([]byte, len(v)+1)
+	copy(b, v)
+	return b
+}
+
+// AppendAuthenticateStart encodes Mysqlx.Session.AuthenticateStart.
+func AppendAuthenticateStart(b []byte, start *AuthenticateStart) []byte {
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, start.MechName)
+	if start.AuthData != nil {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, start.AuthData)
+	}
+	if start.InitialResponse != nil {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, start.InitialResponse)
+	}
+	return b
+}
+
+// AppendStmtExecute encodes Mysqlx.Sql.StmtExecute, the args are scalars as those of appendScalar.
+func AppendStmtExecute(b []byte, stmt *StmtExecute) []byte {
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, stmt.Stmt)
+	for _, arg := range stmt.Args {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, appendAny(nil, arg))
+	}
+	if stmt.Namespace != "" {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendString(b, stmt.Namespace)
+	}
+	return b
+}
+
+// ParseError decodes the code, the sql state and the message of Mysqlx.Error.
+func ParseError(payload []byte) (code uint32, sqlState string, msg string, err error) {
+	err = eachField(payload, func(num protowire.Number, v uint64, data []byte) error {
+		switch num {
+		case 2:
+			code = uint32(v)
+		case 3:
+			msg = string(data)
+		case 4:
+			sqlState = string(data)
+		}
+		return nil
+	})
+	return
+}
+
+// ParseRow decodes the fields of Mysqlx.Resultset.Row.
+func ParseRow(payload []byte) ([][]byte, error) {
+	var fields [][]byte
+	err := eachField(payload, func(num protowire.Number, v uint64, data []byte) error {
+		if num == 1 {
+			fields = append(fields, data)
+		}
+		return nil
+	})
+	return fields, err
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package mysqlx reads and writes the messages of the mysql X Protocol, the protocol of the X DevAPI
+// served on port 33060 by mysql. Only the messages of the connection, the session, the SQL statements
+// and their results are decoded, they are encoded as protobuf by hand after the .proto files of mysql.
+package mysqlx
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// The types of the client messages.
+const (
+	ClientConCapabilitiesGet       byte = 1
+	ClientConCapabilitiesSet       byte = 2
+	ClientConClose                 byte = 3
+	ClientSessAuthenticateStart    byte = 4
+	ClientSessAuthenticateContinue byte = 5
+	ClientSessReset                byte = 6
+	ClientSessClose                byte = 7
+	ClientSQLStmtExecute           byte = 12
+	ClientCrudFind                 byte = 17
+	ClientCrudInsert               byte = 18
+	ClientCrudUpdate               byte = 19
+	ClientCrudDelete               byte = 20
+	ClientExpectOpen               byte = 24
+	ClientExpectClose              byte = 25
+	ClientCrudCreateView           byte = 30
+	ClientCrudModifyView           byte = 31
+	ClientCrudDropView             byte = 32
+	ClientPreparePrepare           byte = 40
+	ClientPrepareExecute           byte = 41
+	ClientPrepareDeallocate        byte = 42
+	ClientCursorOpen               byte = 43
+	ClientCursorClose              byte = 44
+	ClientCursorFetch              byte = 45
+	ClientCompression              byte = 46
+)
+
+// The types of the server messages.
+const (
+	ServerOk                       byte = 0
+	ServerError                    byte = 1
+	ServerConnCapabilities         byte = 2
+	ServerSessAuthenticateContinue byte = 3
+	ServerSessAuthenticateOk       byte = 4
+	ServerNotice                   byte = 11
+	ServerResultsetColumnMetaData  byte = 12
+	ServerResultsetRow             byte = 13
+	ServerResultsetFetchDone       byte = 14
+	ServerSQLStmtExecuteOk         byte = 17
+)
+
+// The error codes of the X Plugin.
+const (
+	CodeBadMessage                uint32 = 5000
+	CodeCapabilitiesPrepareFailed uint32 = 5001
+	CodeCmdArgumentType           uint32 = 5015
+	CodeInvalidAdminCommand       uint32 = 5157
+	CodeInvalidNamespace          uint32 = 5162
+)
+
+// DefaultMaxMessageSize is the default of mysqlx_max_allowed_packet.
+const DefaultMaxMessageSize = 64 * 1024 * 1024
+
+// ErrMessageTooBig is returned by ReadMessage for the messages bigger than the max size.
+var ErrMessageTooBig = errors.New("message too big")
+
+// ReadMessage reads a message, which is its length, its type and its payload.
+func ReadMessage(r *bufio.Reader, maxSize int) (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:4]); err != nil {
+		return 0, nil, err
+	}
+	length := binary.LittleEndian.Uint32(header[:4])
+	if length == 0 {
+		return 0, nil, errors.New("message without type")
+	}
+	if int64(length) > int64(maxSize) {
+		return 0, nil, ErrMessageTooBig
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	return payload[0], payload[1:], nil
+}
+
+// WriteMessage writes a message, it is buffered until w is flushed.
+func WriteMessage(w *bufio.Writer, typ byte, payload []byte) error {
+	var header [5]byte
+	binary.LittleEndian.PutUint32(header[:4], uint32(len(payload)+1))
+	header[4] = typ
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
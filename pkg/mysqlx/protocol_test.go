@@ -0,0 +1,99 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mysqlx
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestMessage(t *testing.T) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	assert.Nil(t, WriteMessage(w, ServerOk, nil))
+	assert.Nil(t, WriteMessage(w, ServerError, []byte("abc")))
+	assert.Nil(t, w.Flush())
+	assert.Equal(t, []byte{1, 0, 0, 0, 0, 4, 0, 0, 0, 1, 'a', 'b', 'c'}, buf.Bytes())
+
+	r := bufio.NewReader(bytes.NewReader(buf.Bytes()))
+	typ, payload, err := ReadMessage(r, DefaultMaxMessageSize)
+	assert.Nil(t, err)
+	assert.Equal(t, ServerOk, typ)
+	assert.Empty(t, payload)
+	_, _, err = ReadMessage(r, 3)
+	assert.True(t, errors.Is(err, ErrMessageTooBig))
+}
+
+func TestStmtExecute(t *testing.T) {
+	args := []interface{}{int64(-3), uint64(4), 1.5, true, "abc", []byte{1, 2}, nil}
+	payload := AppendStmtExecute(nil, &StmtExecute{Stmt: "select ?", Args: args})
+	stmt, err := ParseStmtExecute(payload)
+	assert.Nil(t, err)
+	assert.Equal(t, "sql", stmt.Namespace)
+	assert.Equal(t, "select ?", stmt.Stmt)
+	assert.Equal(t, args, stmt.Args)
+
+	_, err = ParseStmtExecute([]byte{0x0a, 0x05, 'a'})
+	assert.True(t, errors.Is(err, ErrMalformedMessage))
+}
+
+func TestCapabilities(t *testing.T) {
+	capabilities := map[string]interface{}{"tls": true, "authentication.mechanisms": []string{"MYSQL41"}}
+	payload := AppendCapabilities(nil, []string{"tls", "authentication.mechanisms"}, capabilities)
+	// CapabilitiesSet wraps the Capabilities in its field 1
+	set := protowire.AppendTag(nil, 1, protowire.BytesType)
+	set = protowire.AppendBytes(set, payload)
+	parsed, err := ParseCapabilitiesSet(set)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"tls":                       true,
+		"authentication.mechanisms": []interface{}{"MYSQL41"},
+	}, parsed)
+}
+
+func TestAuthenticateStart(t *testing.T) {
+	payload := AppendAuthenticateStart(nil, &AuthenticateStart{MechName: "MYSQL41", AuthData: []byte("db")})
+	start, err := ParseAuthenticateStart(payload)
+	assert.Nil(t, err)
+	assert.Equal(t, "MYSQL41", start.MechName)
+	assert.Equal(t, []byte("db"), start.AuthData)
+	assert.Nil(t, start.InitialResponse)
+}
+
+func TestError(t *testing.T) {
+	code, sqlState, msg, err := ParseError(AppendError(nil, SeverityFatal, 1045, "28000", "denied"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1045), code)
+	assert.Equal(t, "28000", sqlState)
+	assert.Equal(t, "denied", msg)
+}
+
+func TestRow(t *testing.T) {
+	fields := [][]byte{EncodeSint(-1), EncodeUint(300), EncodeDouble(1.5), EncodeFloat(2.5), EncodeBytes([]byte("a")), nil}
+	assert.Equal(t, []byte{1}, fields[0])
+	assert.Equal(t, []byte{0xac, 0x02}, fields[1])
+	assert.Equal(t, []byte{'a', 0}, fields[4])
+	parsed, err := ParseRow(AppendRow(nil, fields))
+	assert.Nil(t, err)
+	assert.Equal(t, fields[:5], parsed[:5])
+	assert.Empty(t, parsed[5])
+}
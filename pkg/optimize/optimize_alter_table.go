@@ -0,0 +1,89 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package optimize
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/osc"
+	"github.com/cectc/dbpack/pkg/plan"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+	"github.com/cectc/dbpack/third_party/parser/model"
+)
+
+// optimizeAlterTable runs ALTER TABLE on every shard of the logic table, or migrates the shards
+// online when online ddl is configured.
+func (o Optimizer) optimizeAlterTable(ctx context.Context, stmt *ast.AlterTableStmt, args []interface{}) (proto.Plan, error) {
+	tableName := stmt.Table.Name.String()
+	if o.globalTables[strings.ToLower(tableName)] {
+		return &plan.DirectQueryPlan{
+			Stmt:     stmt,
+			Args:     args,
+			Executor: o.executors[0],
+		}, nil
+	}
+	if replicas, ok := o.replicasOf(tableName); ok {
+		return broadcastDDL(stmt, args, replicas), nil
+	}
+
+	topology, exists := o.topologies[tableName]
+	if !exists {
+		return nil, errors.Errorf("topology of %s should not be nil", tableName)
+	}
+
+	if o.onlineDDL != nil {
+		if err := osc.Validate(stmt); err != nil {
+			return nil, err
+		}
+		onlineDDLPlan := &plan.OnlineDDLPlan{
+			Stmt:   stmt,
+			Table:  tableName,
+			Config: o.onlineDDL,
+		}
+		for _, index := range topology.TableSlice {
+			table := topology.TableIndexMap[index]
+			onlineDDLPlan.Shards = append(onlineDDLPlan.Shards, &osc.Shard{
+				Executor: o.dbGroupExecutors[topology.Tables[table]],
+				Table:    table,
+			})
+		}
+		return onlineDDLPlan, nil
+	}
+
+	plans := &plan.MultiDirectlyQueryPlan{
+		Stmt:  stmt,
+		Plans: make([]*plan.DirectQueryPlan, 0, topology.TableSliceLen),
+	}
+	for _, index := range topology.TableSlice {
+		table := topology.TableIndexMap[index]
+		// the table name is copied, the statement is shared by the shards
+		shardTable := *stmt.Table
+		shardTable.Name = model.NewCIStr(table)
+		newStmt := *stmt
+		newStmt.Table = &shardTable
+		plans.Plans = append(plans.Plans, &plan.DirectQueryPlan{
+			Stmt:     &newStmt,
+			Args:     args,
+			Executor: o.dbGroupExecutors[topology.Tables[table]],
+		})
+	}
+	return plans, nil
+}
@@ -43,6 +43,9 @@ func (o Optimizer) optimizeCreateIndex(ctx context.Context, stmt *ast.CreateInde
 			Executor: o.executors[0],
 		}, nil
 	}
+	if replicas, ok := o.replicasOf(tableName); ok {
+		return broadcastDDL(stmt, args, replicas), nil
+	}
 
 	if topology, exists = o.topologies[tableName]; !exists {
 		return nil, errors.New(fmt.Sprintf("topology of %s should not be nil", tableName))
@@ -82,6 +85,9 @@ func (o Optimizer) optimizeDropIndex(ctx context.Context, stmt *ast.DropIndexStm
 			Executor: o.executors[0],
 		}, nil
 	}
+	if replicas, ok := o.replicasOf(tableName); ok {
+		return broadcastDDL(stmt, args, replicas), nil
+	}
 
 	if topology, exists = o.topologies[tableName]; !exists {
 		return nil, errors.New(fmt.Sprintf("topology of %s should not be nil", tableName))
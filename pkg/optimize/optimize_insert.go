@@ -47,6 +47,13 @@ func (o Optimizer) optimizeInsert(ctx context.Context, stmt *ast.InsertStmt, arg
 		err       error
 	)
 	tableName := stmt.Table.TableRefs.Left.(*ast.TableSource).Source.(*ast.TableName).Name.String()
+	if replicas, ok := o.replicasOf(tableName); ok {
+		return &plan.BroadcastPlan{
+			Stmt:     stmt,
+			Args:     args,
+			Replicas: replicas,
+		}, nil
+	}
 	for _, column := range stmt.Columns {
 		columns = append(columns, column.Name.String())
 	}
@@ -67,11 +74,40 @@ func (o Optimizer) optimizeInsert(ctx context.Context, stmt *ast.InsertStmt, arg
 			break
 		}
 	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to fetch table meta of %s", tableName)
+	}
+	if len(columns) == 0 {
+		columns = tableMeta.Columns
+	}
 	pk := tableMeta.GetPKName()
-	index := findPkIndex(stmt, pk)
-	// todo if index == -1, automatically generate a primary key
+	for _, assignment := range stmt.OnDuplicate {
+		column := assignment.Column.Name.String()
+		if strings.EqualFold(column, pk) || alg.HasShardingKey(column) {
+			return nil, errors.Errorf("sharding key %s can not be modified by ON DUPLICATE KEY UPDATE", column)
+		}
+	}
+	index := indexOfColumn(columns, pk)
+	// the rows are routed by the sharding key when it is inserted and is not the primary key
+	var shardingKey *cond.KeyCondition
+	for i, column := range columns {
+		if alg.HasShardingKey(column) && !strings.EqualFold(column, pk) && len(stmt.Lists) > 0 {
+			shardingKey = &cond.KeyCondition{
+				Key:   column,
+				Op:    opcode.EQ,
+				Value: getValue(ctx, stmt, i, args),
+			}
+			break
+		}
+	}
 	var pkValue interface{}
 	if index == -1 {
+		if len(stmt.Lists) != 1 {
+			return nil, errors.Errorf("primary key %s must be specified when inserting multiple rows", pk)
+		}
+		if pkMeta := tableMeta.AllColumns[pk]; pkMeta.IsGenerated() {
+			return nil, errors.Errorf("primary key %s is a generated column, can not be used to shard", pk)
+		}
 		pkValue, err = alg.NextID()
 		if err != nil {
 			return nil, fmt.Errorf("failed to automatically generate a primary key: %w", err)
@@ -80,11 +116,24 @@ func (o Optimizer) optimizeInsert(ctx context.Context, stmt *ast.InsertStmt, arg
 		pkValue = getPkValue(ctx, stmt, index, args)
 	}
 
+	columns, stmt, err = rewriteInsertColumns(stmt, columns, tableMeta)
+	if err != nil {
+		return nil, err
+	}
+	if index == -1 {
+		// the generated primary key must be inserted, otherwise the row will not be found in the computed shard
+		columns = append(columns, pk)
+		stmt.Lists[0] = append(stmt.Lists[0], ast.NewValueExpr(pkValue, "", ""))
+	}
+
 	cd := &cond.KeyCondition{
 		Key:   pk,
 		Op:    opcode.EQ,
 		Value: pkValue,
 	}
+	if shardingKey != nil {
+		cd = shardingKey
+	}
 
 	shards, err := cd.Shard(alg)
 	if err != nil {
@@ -95,6 +144,10 @@ func (o Optimizer) optimizeInsert(ctx context.Context, stmt *ast.InsertStmt, arg
 		return nil, errors.New("full scan not allowed")
 	}
 
+	if o.statistics != nil && len(shards) == 1 {
+		o.statistics.Observe(tableName, shards[0], cd.Value)
+	}
+
 	if len(shardMap) == 1 {
 		for k, v := range shardMap {
 			executor, exists := o.dbGroupExecutors[k]
@@ -102,28 +155,83 @@ func (o Optimizer) optimizeInsert(ctx context.Context, stmt *ast.InsertStmt, arg
 				return nil, errors.Errorf("db group %s should not be nil", k)
 			}
 
-			return &plan.InsertPlan{
+			insertPlan := &plan.InsertPlan{
 				Database: k,
 				Table:    v[0],
 				Columns:  columns,
 				Stmt:     stmt,
 				Args:     args,
 				Executor: executor,
-			}, nil
+			}
+			if index == -1 {
+				insertPlan.GeneratedID = pkValue.(int64)
+			}
+			return insertPlan, nil
 		}
 	}
 	return nil, errors.New("should never happen!")
 }
 
-func findPkIndex(stmt *ast.InsertStmt, pk string) int {
-	for i, column := range stmt.Columns {
-		if column.Name.String() == pk {
+func indexOfColumn(columns []string, column string) int {
+	for i, col := range columns {
+		if strings.EqualFold(col, column) {
 			return i
 		}
 	}
 	return -1
 }
 
+// rewriteInsertColumns removes generated columns from the insert statement, generated columns only accept
+// DEFAULT as value. The statement is copied before rewriting, since a prepared statement is reused among executions.
+func rewriteInsertColumns(stmt *ast.InsertStmt, columns []string, tableMeta schema.TableMeta) ([]string, *ast.InsertStmt, error) {
+	for i, row := range stmt.Lists {
+		if len(row) != len(columns) {
+			return nil, nil, errors.Errorf("column count doesn't match value count at row %d", i+1)
+		}
+	}
+
+	var (
+		rewroteColumns = make([]string, 0, len(columns))
+		kept           = make([]int, 0, len(columns))
+	)
+	for i, column := range columns {
+		columnMeta, ok := tableMeta.AllColumns[column]
+		if ok && columnMeta.IsGenerated() {
+			for _, row := range stmt.Lists {
+				if _, isDefault := row[i].(*ast.DefaultExpr); !isDefault {
+					return nil, nil, errors.Errorf("the value specified for generated column '%s' in table '%s' is not allowed",
+						column, tableMeta.TableName)
+				}
+			}
+			continue
+		}
+		rewroteColumns = append(rewroteColumns, column)
+		kept = append(kept, i)
+	}
+
+	insertStmt := *stmt
+	insertStmt.Lists = make([][]ast.ExprNode, 0, len(stmt.Lists))
+	for _, row := range stmt.Lists {
+		values := make([]ast.ExprNode, 0, len(kept)+1)
+		for _, i := range kept {
+			values = append(values, row[i])
+		}
+		insertStmt.Lists = append(insertStmt.Lists, values)
+	}
+	return rewroteColumns, &insertStmt, nil
+}
+
+// getValue returns the value of column index of the first row, the literal of it rather than
+// the sql text of it for a query.
+func getValue(ctx context.Context, stmt *ast.InsertStmt, index int, args []interface{}) interface{} {
+	if proto.CommandType(ctx) == constant.ComQuery {
+		if value, ok := stmt.Lists[0][index].(ast.ValueExpr); ok {
+			return value.GetValue()
+		}
+	}
+	return getPkValue(ctx, stmt, index, args)
+}
+
 func getPkValue(ctx context.Context, stmt *ast.InsertStmt, pkIndex int, args []interface{}) interface{} {
 	commandType := proto.CommandType(ctx)
 	switch commandType {
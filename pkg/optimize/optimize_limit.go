@@ -0,0 +1,175 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package optimize
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/dt/schema"
+	"github.com/cectc/dbpack/pkg/meta"
+	"github.com/cectc/dbpack/pkg/plan"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/resource"
+	"github.com/cectc/dbpack/pkg/topo"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+	"github.com/cectc/dbpack/third_party/parser/model"
+	driver "github.com/cectc/dbpack/third_party/types/parser_driver"
+)
+
+// optimizeLimit plans an UPDATE or DELETE with LIMIT whose rows may live in more than one table. MySQL only
+// applies ORDER BY and LIMIT per table, so the primary keys of the candidate rows are selected across shards
+// first, and route modifies the rows by these keys.
+func (o Optimizer) optimizeLimit(ctx context.Context, table *ast.TableSource, where ast.ExprNode,
+	order *ast.OrderByClause, limit *ast.Limit, args []interface{},
+	route func(ctx context.Context, where ast.ExprNode, args []interface{}) (proto.Plan, error)) (proto.Plan, error) {
+	tableName := table.Source.(*ast.TableName).Name.String()
+	tableMeta, err := o.getTableMeta(ctx, tableName)
+	if err != nil {
+		return nil, err
+	}
+	pk := tableMeta.GetPKName()
+
+	fields := []*ast.SelectField{{Expr: columnNameExpr(pk)}}
+	if order != nil {
+		for _, item := range order.Items {
+			column, ok := item.Expr.(*ast.ColumnNameExpr)
+			if !ok {
+				return nil, errors.Errorf("only columns are supported in ORDER BY of a sharded statement with LIMIT")
+			}
+			if column.Name.Name.L != model.NewCIStr(pk).L {
+				fields = append(fields, &ast.SelectField{Expr: columnNameExpr(column.Name.Name.O)})
+			}
+		}
+	}
+
+	count, argsWithoutLimit, err := limitCount(limit, args)
+	if err != nil {
+		return nil, err
+	}
+	selectStmt := &ast.SelectStmt{
+		SelectStmtOpts: &ast.SelectStmtOpts{SQLCache: true},
+		Kind:           ast.SelectStmtKindSelect,
+		Fields:         &ast.FieldList{Fields: fields},
+		From: &ast.TableRefsClause{TableRefs: &ast.Join{
+			Left: &ast.TableSource{Source: &ast.TableName{Name: model.NewCIStr(tableName)}, AsName: table.AsName},
+		}},
+		Where:   where,
+		OrderBy: order,
+		Limit:   &ast.Limit{Count: ast.NewValueExpr(count, "", "")},
+	}
+	selectPlan, err := o.optimizeSelect(ctx, selectStmt, args)
+	if err != nil {
+		return nil, err
+	}
+	// the conditions are parsed with all the arguments, but only the ones of the where clause are sent
+	whereArgs := paramArgs(where, args)
+	switch sp := selectPlan.(type) {
+	case *plan.QueryOnSingleDBPlan:
+		sp.Args = whereArgs
+	case *plan.QueryOnMultiDBPlan:
+		for _, p := range sp.Plans {
+			p.Args = whereArgs
+		}
+	}
+
+	return &plan.LimitPlan{
+		PK:     pk,
+		Select: selectPlan,
+		Route: func(ctx context.Context, keyCondition ast.ExprNode) (proto.Plan, error) {
+			return route(ctx, plan.AppendKeyCondition(where, keyCondition), argsWithoutLimit)
+		},
+	}, nil
+}
+
+func (o Optimizer) getTableMeta(ctx context.Context, tableName string) (schema.TableMeta, error) {
+	var (
+		topology  *topo.Topology
+		tableMeta schema.TableMeta
+		exists    bool
+		err       error
+	)
+	if topology, exists = o.topologies[tableName]; !exists {
+		return tableMeta, errors.Errorf("topology of %s should not be nil", tableName)
+	}
+	for db, tables := range topology.DBs {
+		sqlDB := resource.GetDBManager(o.appid).GetDB(db)
+		tableMeta, err = meta.GetTableMetaCache().GetTableMeta(ctx, sqlDB, tables[0])
+		if err == nil {
+			return tableMeta, nil
+		}
+	}
+	return tableMeta, errors.Wrapf(err, "failed to fetch table meta of %s", tableName)
+}
+
+// limitCount returns the row count of limit, and the arguments without the one bound to it.
+func limitCount(limit *ast.Limit, args []interface{}) (int64, []interface{}, error) {
+	if limit.Offset != nil {
+		return 0, nil, errors.New("LIMIT with offset is not supported by UPDATE and DELETE")
+	}
+	switch count := limit.Count.(type) {
+	case *driver.ParamMarkerExpr:
+		value, err := strconv.ParseInt(fmt.Sprintf("%v", args[count.Order]), 10, 64)
+		if err != nil {
+			return 0, nil, errors.Wrap(err, "invalid limit count")
+		}
+		argsWithoutLimit := make([]interface{}, 0, len(args)-1)
+		argsWithoutLimit = append(argsWithoutLimit, args[:count.Order]...)
+		argsWithoutLimit = append(argsWithoutLimit, args[count.Order+1:]...)
+		return value, argsWithoutLimit, nil
+	case *driver.ValueExpr:
+		return count.GetInt64(), args, nil
+	default:
+		return 0, nil, errors.Errorf("unsupported limit count %T", limit.Count)
+	}
+}
+
+// paramArgs returns the arguments bound to the param markers in node.
+func paramArgs(node ast.Node, args []interface{}) []interface{} {
+	if node == nil || len(args) == 0 {
+		return nil
+	}
+	v := &paramVisitor{}
+	node.Accept(v)
+	result := make([]interface{}, 0, len(v.params))
+	for _, param := range v.params {
+		result = append(result, args[param.Order])
+	}
+	return result
+}
+
+type paramVisitor struct {
+	params []*driver.ParamMarkerExpr
+}
+
+func (v *paramVisitor) Enter(in ast.Node) (out ast.Node, skipChildren bool) {
+	if param, ok := in.(*driver.ParamMarkerExpr); ok {
+		v.params = append(v.params, param)
+	}
+	return in, false
+}
+
+func (v *paramVisitor) Leave(in ast.Node) (out ast.Node, ok bool) {
+	return in, true
+}
+
+func columnNameExpr(column string) *ast.ColumnNameExpr {
+	return &ast.ColumnNameExpr{Name: &ast.ColumnName{Name: model.NewCIStr(column)}}
+}
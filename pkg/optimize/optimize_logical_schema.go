@@ -0,0 +1,222 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package optimize
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/plan"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/visitor"
+	"github.com/cectc/dbpack/third_party/parser"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+	"github.com/cectc/dbpack/third_party/parser/format"
+	"github.com/cectc/dbpack/third_party/parser/model"
+	"github.com/cectc/dbpack/third_party/parser/opcode"
+	driver "github.com/cectc/dbpack/third_party/types/parser_driver"
+)
+
+const informationSchema = "information_schema"
+
+// tableNameTables are the tables of information_schema with a TABLE_NAME column.
+var tableNameTables = map[string]bool{
+	"tables":                  true,
+	"columns":                 true,
+	"statistics":              true,
+	"key_column_usage":        true,
+	"table_constraints":       true,
+	"referential_constraints": true,
+	"partitions":              true,
+	"views":                   true,
+	"table_privileges":        true,
+	"column_privileges":       true,
+}
+
+// logicalTables maps the lower case names of the physical tables to the logical tables they are sharded from.
+func (o Optimizer) logicalTables() map[string]string {
+	tables := make(map[string]string)
+	for logicalTable, topology := range o.topologies {
+		for physicalTable := range topology.Tables {
+			tables[strings.ToLower(physicalTable)] = logicalTable
+		}
+	}
+	return tables
+}
+
+// informationSchemaTable returns the table of information_schema selected by stmt, if it selects
+// from a single table of information_schema.
+func informationSchemaTable(stmt *ast.SelectStmt) (*ast.TableSource, bool) {
+	if stmt.From == nil || stmt.From.TableRefs == nil || stmt.From.TableRefs.Right != nil {
+		return nil, false
+	}
+	source, ok := stmt.From.TableRefs.Left.(*ast.TableSource)
+	if !ok {
+		return nil, false
+	}
+	table, ok := source.Source.(*ast.TableName)
+	return source, ok && table.Schema.L == informationSchema
+}
+
+// optimizeInformationSchema plans the queries of information_schema on the logical tables. The table
+// names the query filters on are mapped from the physical tables to their logical tables, so that
+// the logical table names match the rows of their physical tables. The aggregates can't be merged
+// across the db groups, they are computed in the first db group over one physical table of each
+// logical table.
+func (o Optimizer) optimizeInformationSchema(ctx context.Context, stmt *ast.SelectStmt, args []interface{}) (proto.Plan, error) {
+	// the statement is rewritten on a copy, prepared statements and cached plans reuse it
+	var sb strings.Builder
+	if err := stmt.Restore(format.NewRestoreCtx(constant.DBPackRestoreFormat, &sb)); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	copied, err := parser.New().ParseOneStmt(sb.String(), "", "")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	copied.Accept(&visitor.ParamVisitor{})
+	selectStmt := copied.(*ast.SelectStmt)
+	source, _ := informationSchemaTable(selectStmt)
+	table := source.Source.(*ast.TableName)
+	logicalTables := o.logicalTables()
+	if !tableNameTables[table.Name.L] || len(logicalTables) == 0 {
+		return &plan.LogicalSchemaPlan{Stmt: selectStmt, Args: args, Executors: o.executors[:1],
+			LogicalTables: logicalTables}, nil
+	}
+
+	physicalTables := make([]string, 0, len(logicalTables))
+	for physicalTable := range logicalTables {
+		physicalTables = append(physicalTables, physicalTable)
+	}
+	sort.Strings(physicalTables)
+	if selectStmt.Where != nil {
+		rewriter := &tableNameRewriter{physicalTables: physicalTables, logicalTables: logicalTables}
+		where, _ := selectStmt.Where.Accept(rewriter)
+		selectStmt.Where = where.(ast.ExprNode)
+	}
+	if !hasAggregate(selectStmt) {
+		return &plan.LogicalSchemaPlan{Stmt: selectStmt, Args: args, Executors: o.executors,
+			LogicalTables: logicalTables}, nil
+	}
+
+	// only the first physical table of each logical table in the db group is aggregated
+	executor := o.executors[0]
+	var excluded []ast.ExprNode
+	for _, topology := range o.topologies {
+		tables := topology.DBs[executor.GroupName()]
+		for i := 1; i < len(tables); i++ {
+			excluded = append(excluded, ast.NewValueExpr(tables[i], "", ""))
+		}
+	}
+	if len(excluded) > 0 {
+		tableName := source.AsName
+		if tableName.L == "" {
+			tableName = table.Name
+		}
+		exclusion := &ast.PatternInExpr{
+			Expr: &ast.ColumnNameExpr{Name: &ast.ColumnName{Table: tableName, Name: model.NewCIStr("TABLE_NAME")}},
+			List: excluded,
+			Not:  true,
+		}
+		if selectStmt.Where == nil {
+			selectStmt.Where = exclusion
+		} else {
+			selectStmt.Where = &ast.BinaryOperationExpr{
+				Op: opcode.LogicAnd,
+				L:  &ast.ParenthesesExpr{Expr: selectStmt.Where},
+				R:  exclusion,
+			}
+		}
+	}
+	return &plan.LogicalSchemaPlan{Stmt: selectStmt, Args: args, Executors: []proto.DBGroupExecutor{executor},
+		LogicalTables: logicalTables}, nil
+}
+
+// hasAggregate reports whether the select groups its rows, which can't be merged across the db groups.
+func hasAggregate(stmt *ast.SelectStmt) bool {
+	if stmt.GroupBy != nil {
+		return true
+	}
+	v := &aggregateVisitor{}
+	stmt.Fields.Accept(v)
+	return v.found
+}
+
+type aggregateVisitor struct {
+	found bool
+}
+
+func (v *aggregateVisitor) Enter(in ast.Node) (out ast.Node, skipChildren bool) {
+	if _, ok := in.(*ast.AggregateFuncExpr); ok {
+		v.found = true
+	}
+	return in, v.found
+}
+
+func (v *aggregateVisitor) Leave(in ast.Node) (out ast.Node, ok bool) {
+	return in, true
+}
+
+// tableNameRewriter maps the table names compared by a where clause from the physical tables to
+// their logical tables, `TABLE_NAME = 'orders'` is rewritten to
+// `CASE TABLE_NAME WHEN 'orders_0' THEN 'orders' ... ELSE TABLE_NAME END = 'orders'`.
+type tableNameRewriter struct {
+	// physicalTables are sorted to generate the same statement each time
+	physicalTables []string
+	logicalTables  map[string]string
+}
+
+func (v *tableNameRewriter) Enter(in ast.Node) (out ast.Node, skipChildren bool) {
+	return in, false
+}
+
+func (v *tableNameRewriter) Leave(in ast.Node) (out ast.Node, ok bool) {
+	column, isColumn := in.(*ast.ColumnNameExpr)
+	if !isColumn || (column.Name.Name.L != "table_name" && column.Name.Name.L != "referenced_table_name") {
+		return in, true
+	}
+	mapped := &ast.CaseExpr{
+		Value:       column,
+		WhenClauses: make([]*ast.WhenClause, 0, len(v.physicalTables)),
+		ElseClause:  column,
+	}
+	for _, physicalTable := range v.physicalTables {
+		mapped.WhenClauses = append(mapped.WhenClauses, &ast.WhenClause{
+			Expr:   ast.NewValueExpr(physicalTable, "", ""),
+			Result: ast.NewValueExpr(v.logicalTables[physicalTable], "", ""),
+		})
+	}
+	return mapped, true
+}
+
+// showPattern takes the LIKE pattern out of a copy of SHOW TABLES or SHOW TABLE STATUS, the
+// pattern is matched against the logical tables instead of the physical tables by the plan.
+func showPattern(stmt *ast.ShowStmt) (*ast.ShowStmt, string) {
+	if stmt.Pattern == nil || stmt.Pattern.Not {
+		return stmt, ""
+	}
+	value, ok := stmt.Pattern.Pattern.(*driver.ValueExpr)
+	if !ok {
+		return stmt, ""
+	}
+	copied := *stmt
+	copied.Pattern = nil
+	return &copied, value.GetDatumString()
+}
@@ -42,6 +42,12 @@ func (o Optimizer) optimizeSelect(ctx context.Context, stmt *ast.SelectStmt, arg
 		exists    bool
 		err       error
 	)
+	if _, ok := informationSchemaTable(stmt); ok {
+		return o.optimizeInformationSchema(ctx, stmt, args)
+	}
+	if p, ok, err := o.optimizeReplicatedJoin(stmt, args); ok {
+		return p, err
+	}
 	tableName := stmt.From.TableRefs.Left.(*ast.TableSource).Source.(*ast.TableName).Name.String()
 
 	if o.globalTables[strings.ToLower(tableName)] {
@@ -51,6 +57,13 @@ func (o Optimizer) optimizeSelect(ctx context.Context, stmt *ast.SelectStmt, arg
 			Executor: o.executors[0],
 		}, nil
 	}
+	if replicas, ok := o.replicasOf(tableName); ok {
+		return &plan.ReplicaQueryPlan{
+			Stmt:     stmt,
+			Args:     args,
+			Replicas: replicas,
+		}, nil
+	}
 	if alg, exists = o.algorithms[tableName]; !exists {
 		return nil, errors.New("sharding algorithm should not be nil")
 	}
@@ -83,6 +96,14 @@ func (o Optimizer) optimizeSelect(ctx context.Context, stmt *ast.SelectStmt, arg
 	if fullScan && !alg.AllowFullScan() {
 		return nil, errors.New("full scan not allowed")
 	}
+	if pruned := o.pruneShards(tableName, condition, shards); len(pruned) < len(shards) {
+		_, shardMap = pruned.ParseTopology(topology)
+	}
+
+	if hasWindowFunc(stmt) && !isSingleTable(shardMap) {
+		return nil, errors.Errorf("window functions can not be merged across shards, the query on %s "+
+			"must be routed to a single table by the sharding key", tableName)
+	}
 
 	if len(shardMap) == 1 {
 		for k, v := range shardMap {
@@ -102,7 +123,7 @@ func (o Optimizer) optimizeSelect(ctx context.Context, stmt *ast.SelectStmt, arg
 		}
 	}
 
-	plans := make([]*plan.QueryOnSingleDBPlan, 0, len(shards))
+	plans := make([]*plan.QueryOnSingleDBPlan, 0, len(shardMap))
 
 	keys := make([]string, 0)
 	for k := range shardMap {
@@ -131,3 +152,105 @@ func (o Optimizer) optimizeSelect(ctx context.Context, stmt *ast.SelectStmt, arg
 	}
 	return multiPlan, nil
 }
+
+// optimizeReplicatedJoin routes a query joining replicated tables, and maybe global tables, to a db group
+// holding replicas of all of them, or else runs it as a federated query across the db groups. ok is false
+// if the query references other tables or a single table, which are routed as usual.
+func (o Optimizer) optimizeReplicatedJoin(stmt *ast.SelectStmt, args []interface{}) (proto.Plan, bool, error) {
+	v := &tableRefVisitor{cteNames: make(map[string]bool)}
+	stmt.Accept(v)
+	if len(v.refs) < 2 {
+		return nil, false, nil
+	}
+
+	var (
+		replicated []*plan.Replicas
+		sources    = make(map[string]*plan.Replicas, len(v.refs))
+	)
+	for _, ref := range v.refs {
+		tableName := ref.source.Source.(*ast.TableName).Name.L
+		if replicas, isReplicated := o.replicasOf(tableName); isReplicated {
+			replicated = append(replicated, replicas)
+			sources[tableName] = replicas
+			continue
+		}
+		if !o.globalTables[tableName] || len(o.executors) == 0 {
+			return nil, false, nil
+		}
+		// global tables are read from the db group DirectQueryPlan reads them from
+		sources[tableName] = plan.NewReplicas(o.executors[:1])
+	}
+	if len(replicated) == 0 {
+		return nil, false, nil
+	}
+
+	var common []proto.DBGroupExecutor
+	for _, executor := range replicated[0].Executors {
+		held := true
+		for _, replicas := range replicated[1:] {
+			held = held && replicas.Contains(executor.GroupName())
+		}
+		if held {
+			common = append(common, executor)
+		}
+	}
+	if len(common) > 0 {
+		return &plan.ReplicaQueryPlan{
+			Stmt:     stmt,
+			Args:     args,
+			Replicas: plan.NewReplicas(common),
+		}, true, nil
+	}
+	federated, err := plan.NewFederatedQueryPlan(stmt, args, sources)
+	if err != nil {
+		return nil, true, err
+	}
+	return federated, true, nil
+}
+
+// pruneShards removes shards which can not contain matched rows according to the statistics, at least one shard
+// is kept so that the result set still has fields.
+func (o Optimizer) pruneShards(tableName string, condition cond.Condition,
+	shards cond.TableIndexSliceCondition) cond.TableIndexSliceCondition {
+	if o.statistics == nil || len(shards) <= 1 {
+		return shards
+	}
+	pruned := o.statistics.Prune(tableName, condition, shards)
+	if len(pruned) == 0 {
+		return shards[:1]
+	}
+	return pruned
+}
+
+// windowFuncVisitor finds window functions, whose results are computed per shard and can not be merged.
+type windowFuncVisitor struct {
+	found bool
+}
+
+func (v *windowFuncVisitor) Enter(in ast.Node) (out ast.Node, skipChildren bool) {
+	if _, ok := in.(*ast.WindowFuncExpr); ok {
+		v.found = true
+	}
+	return in, v.found
+}
+
+func (v *windowFuncVisitor) Leave(in ast.Node) (out ast.Node, ok bool) {
+	return in, true
+}
+
+func hasWindowFunc(stmt *ast.SelectStmt) bool {
+	v := &windowFuncVisitor{}
+	stmt.Accept(v)
+	return v.found || len(stmt.WindowSpecs) > 0
+}
+
+// isSingleTable reports whether the shards resolve to exactly one physical table.
+func isSingleTable(shardMap map[string][]string) bool {
+	if len(shardMap) != 1 {
+		return false
+	}
+	for _, tables := range shardMap {
+		return len(tables) == 1
+	}
+	return false
+}
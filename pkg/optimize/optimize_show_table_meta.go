@@ -24,6 +24,7 @@ import (
 	"github.com/cectc/dbpack/pkg/plan"
 	"github.com/cectc/dbpack/pkg/proto"
 	"github.com/cectc/dbpack/third_party/parser/ast"
+	"github.com/cectc/dbpack/third_party/parser/model"
 )
 
 func (o Optimizer) optimizeShowTableMeta(ctx context.Context, stmt *ast.ShowStmt, args []interface{}) (proto.Plan, error) {
@@ -33,17 +34,33 @@ func (o Optimizer) optimizeShowTableMeta(ctx context.Context, stmt *ast.ShowStmt
 
 	executor := o.executors[0]
 	tableName := stmt.Table.Name.O
-	if topology, exists := o.topologies[tableName]; exists {
-		stmt.Table.Name.O = topology.DBs[executor.GroupName()][0]
+	if replicas, ok := o.replicasOf(tableName); ok {
+		executor = replicas.Executors[0]
+	}
+	topology, exists := o.topologies[tableName]
+	if !exists {
 		return &plan.DirectQueryPlan{
 			Stmt:     stmt,
 			Args:     args,
 			Executor: executor,
 		}, nil
 	}
-	return &plan.DirectQueryPlan{
-		Stmt:     stmt,
-		Args:     args,
-		Executor: executor,
-	}, nil
+	// the columns and indexes of a logical table are those of its first physical table, the
+	// statement is rewritten on a copy, prepared statements reuse it
+	for _, executor := range o.executors {
+		tables := topology.DBs[executor.GroupName()]
+		if len(tables) == 0 {
+			continue
+		}
+		copied, table := *stmt, *stmt.Table
+		table.Name = model.NewCIStr(tables[0])
+		copied.Table = &table
+		return &plan.LogicalSchemaPlan{
+			Stmt:          &copied,
+			Args:          args,
+			Executors:     []proto.DBGroupExecutor{executor},
+			LogicalTables: o.logicalTables(),
+		}, nil
+	}
+	return nil, errors.Errorf("topology of %s has no physical table", tableName)
 }
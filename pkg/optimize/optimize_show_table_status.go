@@ -23,34 +23,19 @@ import (
 
 	"github.com/cectc/dbpack/pkg/plan"
 	"github.com/cectc/dbpack/pkg/proto"
-	"github.com/cectc/dbpack/pkg/topo"
 	"github.com/cectc/dbpack/third_party/parser/ast"
-	driver "github.com/cectc/dbpack/third_party/types/parser_driver"
 )
 
 func (o Optimizer) optimizeShowTableStatus(ctx context.Context, stmt *ast.ShowStmt, args []interface{}) (proto.Plan, error) {
-	var (
-		topology *topo.Topology
-		exists   bool
-	)
-
 	if stmt.Tp != ast.ShowTableStatus {
 		return nil, errors.New("statement must be show table status stmt")
 	}
-
-	pattern := stmt.Pattern.Pattern.(*driver.ValueExpr)
-	tableName := pattern.GetDatumString()
-	if topology, exists = o.topologies[tableName]; !exists {
-		return &plan.DirectQueryPlan{
-			Stmt:     stmt,
-			Executor: o.executors[0],
-		}, nil
-	} else {
-		table := topology.DBs[o.executors[0].GroupName()][0]
-		pattern.SetValue(table)
-		return &plan.DirectQueryPlan{
-			Stmt:     stmt,
-			Executor: o.executors[0],
-		}, nil
-	}
+	stmt, pattern := showPattern(stmt)
+	return &plan.LogicalSchemaPlan{
+		Stmt:          stmt,
+		Args:          args,
+		Executors:     o.executors,
+		LogicalTables: o.logicalTables(),
+		Pattern:       pattern,
+	}, nil
 }
@@ -27,19 +27,15 @@ import (
 )
 
 func (o Optimizer) optimizeShowTables(ctx context.Context, stmt *ast.ShowStmt, args []interface{}) (proto.Plan, error) {
-	var logicTables []string
 	if stmt.Tp != ast.ShowTables {
 		return nil, errors.New("statement must be show tables stmt")
 	}
-
-	for logicTable := range o.topologies {
-		logicTables = append(logicTables, logicTable)
-	}
-
-	return &plan.ShowTablesPlan{
-		Stmt:        stmt,
-		Args:        args,
-		Executor:    o.executors[0],
-		LogicTables: logicTables,
+	stmt, pattern := showPattern(stmt)
+	return &plan.LogicalSchemaPlan{
+		Stmt:          stmt,
+		Args:          args,
+		Executors:     o.executors,
+		LogicalTables: o.logicalTables(),
+		Pattern:       pattern,
 	}, nil
 }
@@ -36,6 +36,13 @@ func (o Optimizer) optimizeUpdate(ctx context.Context, stmt *ast.UpdateStmt, arg
 		exists   bool
 	)
 	tableName := stmt.TableRefs.TableRefs.Left.(*ast.TableSource).Source.(*ast.TableName).Name.String()
+	if replicas, ok := o.replicasOf(tableName); ok {
+		return &plan.BroadcastPlan{
+			Stmt:     stmt,
+			Args:     args,
+			Replicas: replicas,
+		}, nil
+	}
 	if alg, exists = o.algorithms[tableName]; !exists {
 		return nil, errors.New("sharding algorithm should not be nil")
 	}
@@ -58,6 +65,18 @@ func (o Optimizer) optimizeUpdate(ctx context.Context, stmt *ast.UpdateStmt, arg
 		return nil, errors.New("full scan not allowed")
 	}
 
+	if stmt.Limit != nil && !isSingleTable(shardMap) {
+		table := stmt.TableRefs.TableRefs.Left.(*ast.TableSource)
+		return o.optimizeLimit(ctx, table, stmt.Where, stmt.Order, stmt.Limit, args,
+			func(ctx context.Context, where ast.ExprNode, args []interface{}) (proto.Plan, error) {
+				updateStmt := *stmt
+				updateStmt.Where = where
+				updateStmt.Order = nil
+				updateStmt.Limit = nil
+				return o.optimizeUpdate(ctx, &updateStmt, args)
+			})
+	}
+
 	if len(shardMap) == 1 {
 		for k, v := range shardMap {
 			executor, exists := o.dbGroupExecutors[k]
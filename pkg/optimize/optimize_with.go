@@ -0,0 +1,166 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package optimize
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/cond"
+	"github.com/cectc/dbpack/pkg/plan"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+	"github.com/cectc/dbpack/third_party/parser/model"
+)
+
+// tableRef is a physical table referenced by a statement, together with the where clause of the
+// select which references it.
+type tableRef struct {
+	source *ast.TableSource
+	where  ast.ExprNode
+}
+
+// tableRefVisitor collects the tables referenced by a statement and its common table expressions,
+// references to the common table expressions themselves are skipped.
+type tableRefVisitor struct {
+	cteNames map[string]bool
+	selects  []*ast.SelectStmt
+	refs     []*tableRef
+}
+
+func (v *tableRefVisitor) Enter(in ast.Node) (out ast.Node, skipChildren bool) {
+	switch node := in.(type) {
+	case *ast.WithClause:
+		for _, cte := range node.CTEs {
+			v.cteNames[cte.Name.L] = true
+		}
+	case *ast.SelectStmt:
+		v.selects = append(v.selects, node)
+	case *ast.TableSource:
+		table, ok := node.Source.(*ast.TableName)
+		if !ok {
+			break
+		}
+		if table.Schema.L == "" && v.cteNames[table.Name.L] {
+			break
+		}
+		ref := &tableRef{source: node}
+		if len(v.selects) > 0 {
+			ref.where = v.selects[len(v.selects)-1].Where
+		}
+		v.refs = append(v.refs, ref)
+	}
+	return in, false
+}
+
+func (v *tableRefVisitor) Leave(in ast.Node) (out ast.Node, ok bool) {
+	if _, isSelect := in.(*ast.SelectStmt); isSelect {
+		v.selects = v.selects[:len(v.selects)-1]
+	}
+	return in, true
+}
+
+// optimizeWith routes a statement with common table expressions. Every sharded table it references
+// must resolve to a single physical table, and all of them must live in the same database, the
+// statement is then sent to that database with the logic table names rewritten. The replicated
+// tables it references must have a replica in that database, one of the replicas is picked if it
+// references no sharded table. Recursive common table expressions are always executed on the master.
+func (o Optimizer) optimizeWith(ctx context.Context, stmt ast.StmtNode, with *ast.WithClause,
+	args []interface{}) (proto.Plan, error) {
+	v := &tableRefVisitor{cteNames: make(map[string]bool)}
+	stmt.Accept(v)
+
+	var (
+		database string
+		replicas []*plan.Replicas
+	)
+	for _, ref := range v.refs {
+		table := ref.source.Source.(*ast.TableName)
+		tableName := table.Name.String()
+		if o.globalTables[strings.ToLower(tableName)] {
+			continue
+		}
+		if r, ok := o.replicasOf(tableName); ok {
+			replicas = append(replicas, r)
+			continue
+		}
+		alg, exists := o.algorithms[tableName]
+		if !exists {
+			return nil, errors.Errorf("sharding algorithm of %s should not be nil", tableName)
+		}
+		topology, exists := o.topologies[tableName]
+		if !exists {
+			return nil, errors.Errorf("topology of %s should not be nil", tableName)
+		}
+
+		condition, err := cond.ParseCondition(ref.where, args...)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse condition failed")
+		}
+		shards, err := condition.(cond.ConditionShard).Shard(alg)
+		if err != nil {
+			return nil, errors.Wrap(err, "compute shards failed")
+		}
+		_, shardMap := shards.ParseTopology(topology)
+		if len(shardMap) != 1 {
+			return nil, errors.Errorf("common table expression references %s across multiple databases, "+
+				"which is not supported", tableName)
+		}
+		for db, tables := range shardMap {
+			if len(tables) != 1 {
+				return nil, errors.Errorf("common table expression references %s across multiple tables, "+
+					"which is not supported", tableName)
+			}
+			if database != "" && database != db {
+				return nil, errors.New("common table expression references tables in multiple databases, " +
+					"which is not supported")
+			}
+			database = db
+			if ref.source.AsName.L == "" {
+				ref.source.AsName = table.Name
+			}
+			table.Name = model.NewCIStr(tables[0])
+		}
+	}
+
+	if database == "" && len(replicas) > 0 {
+		database = replicas[0].Pick().GroupName()
+	}
+	var executor proto.DBGroupExecutor
+	if database == "" {
+		executor = o.executors[0]
+	} else {
+		var exists bool
+		if executor, exists = o.dbGroupExecutors[database]; !exists {
+			return nil, errors.Errorf("db group %s should not be nil", database)
+		}
+	}
+	for _, r := range replicas {
+		if !r.Contains(database) {
+			return nil, errors.Errorf("common table expression references a replicated table without "+
+				"a replica in %s, which is not supported", database)
+		}
+	}
+	return &plan.DirectQueryPlan{
+		Stmt:        stmt,
+		Args:        args,
+		Executor:    executor,
+		ForceMaster: with.IsRecursive,
+	}, nil
+}
@@ -18,10 +18,14 @@ package optimize
 
 import (
 	"context"
+	"strings"
 
 	"github.com/pkg/errors"
 
 	"github.com/cectc/dbpack/pkg/cond"
+	err2 "github.com/cectc/dbpack/pkg/errors"
+	"github.com/cectc/dbpack/pkg/osc"
+	"github.com/cectc/dbpack/pkg/plan"
 	"github.com/cectc/dbpack/pkg/proto"
 	"github.com/cectc/dbpack/pkg/topo"
 	"github.com/cectc/dbpack/third_party/parser/ast"
@@ -30,35 +34,92 @@ import (
 type Optimizer struct {
 	appid        string
 	globalTables map[string]bool
-	executors    []proto.DBGroupExecutor
+	// lower case tableName -> replicas
+	replicas  map[string]*plan.Replicas
+	executors []proto.DBGroupExecutor
 	// dbName -> DBGroupExecutor
 	dbGroupExecutors map[string]proto.DBGroupExecutor
 	// tableName -> ShardingAlgorithm
 	algorithms map[string]cond.ShardingAlgorithm
 	// tableName -> topology
 	topologies map[string]*topo.Topology
+	statistics *Statistics
+	planCache  *PlanCache
+	// onlineDDL is the config of the online ALTER TABLE, nil means it is disabled
+	onlineDDL *osc.Config
 }
 
 func NewOptimizer(appid string,
 	globalTables map[string]bool,
+	replicas map[string]*plan.Replicas,
 	executors []proto.DBGroupExecutor,
 	dbGroupExecutors map[string]proto.DBGroupExecutor,
 	algorithms map[string]cond.ShardingAlgorithm,
-	topologies map[string]*topo.Topology) proto.Optimizer {
+	topologies map[string]*topo.Topology,
+	statistics *Statistics,
+	planCache *PlanCache,
+	onlineDDL *osc.Config) proto.Optimizer {
 	return &Optimizer{
 		appid:            appid,
 		globalTables:     globalTables,
+		replicas:         replicas,
 		executors:        executors,
 		dbGroupExecutors: dbGroupExecutors,
 		algorithms:       algorithms,
 		topologies:       topologies,
+		statistics:       statistics,
+		planCache:        planCache,
+		onlineDDL:        onlineDDL,
 	}
 }
 
+// Optimize plans the statement, the errors are routing errors, which the clients get as ERRoutingFailed.
 func (o Optimizer) Optimize(ctx context.Context, stmt ast.StmtNode, args ...interface{}) (proto.Plan, error) {
+	p, err := o.optimizeCached(ctx, stmt, args...)
+	return p, err2.NewRoutingError(err)
+}
+
+func (o Optimizer) optimizeCached(ctx context.Context, stmt ast.StmtNode, args ...interface{}) (proto.Plan, error) {
+	if o.planCache == nil {
+		return o.optimize(ctx, stmt, args...)
+	}
+	switch stmt.(type) {
+	case *ast.SelectStmt, *ast.DeleteStmt, *ast.UpdateStmt:
+		key := planCacheKey(ctx, args)
+		if key == "" {
+			return o.optimize(ctx, stmt, args...)
+		}
+		var statisticsVersion int64
+		if o.statistics != nil {
+			statisticsVersion = o.statistics.Version()
+		}
+		if p, ok := o.planCache.Get(key, statisticsVersion); ok {
+			return p, nil
+		}
+		p, err := o.optimize(ctx, stmt, args...)
+		if err != nil {
+			return nil, err
+		}
+		o.planCache.Set(key, statisticsVersion, p)
+		return p, nil
+	case *ast.CreateIndexStmt, *ast.DropIndexStmt, *ast.AlterTableStmt, *ast.DropTableStmt,
+		*ast.TruncateTableStmt, *ast.RenameTableStmt:
+		o.planCache.Purge()
+	}
+	return o.optimize(ctx, stmt, args...)
+}
+
+func (o Optimizer) optimize(ctx context.Context, stmt ast.StmtNode, args ...interface{}) (proto.Plan, error) {
 	switch t := stmt.(type) {
 	case *ast.SelectStmt:
+		if t.With != nil {
+			return o.optimizeWith(ctx, t, t.With, args)
+		}
 		return o.optimizeSelect(ctx, t, args)
+	case *ast.SetOprStmt:
+		if t.With != nil {
+			return o.optimizeWith(ctx, t, t.With, args)
+		}
 	case *ast.InsertStmt:
 		return o.optimizeInsert(ctx, t, args)
 	case *ast.DeleteStmt:
@@ -78,7 +139,31 @@ func (o Optimizer) Optimize(ctx context.Context, stmt ast.StmtNode, args ...inte
 		return o.optimizeCreateIndex(ctx, t, args)
 	case *ast.DropIndexStmt:
 		return o.optimizeDropIndex(ctx, t, args)
+	case *ast.AlterTableStmt:
+		return o.optimizeAlterTable(ctx, t, args)
 	}
 	sqlText := proto.SqlText(ctx)
 	return nil, errors.Errorf("unsupported statement type, sql: %s", sqlText)
 }
+
+// replicasOf returns the replicas of a replicated table.
+func (o Optimizer) replicasOf(tableName string) (*plan.Replicas, bool) {
+	replicas, ok := o.replicas[strings.ToLower(tableName)]
+	return replicas, ok
+}
+
+// broadcastDDL runs a ddl statement of a replicated table on all of its replicas.
+func broadcastDDL(stmt ast.StmtNode, args []interface{}, replicas *plan.Replicas) proto.Plan {
+	plans := &plan.MultiDirectlyQueryPlan{
+		Stmt:  stmt,
+		Plans: make([]*plan.DirectQueryPlan, 0, len(replicas.Executors)),
+	}
+	for _, executor := range replicas.Executors {
+		plans.Plans = append(plans.Plans, &plan.DirectQueryPlan{
+			Stmt:     stmt,
+			Args:     args,
+			Executor: executor,
+		})
+	}
+	return plans
+}
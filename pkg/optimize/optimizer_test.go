@@ -18,7 +18,9 @@ package optimize
 
 import (
 	"context"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/agiledragon/gomonkey/v2"
 	"github.com/stretchr/testify/assert"
@@ -28,12 +30,16 @@ import (
 	"github.com/cectc/dbpack/pkg/dt/schema"
 	"github.com/cectc/dbpack/pkg/meta"
 	"github.com/cectc/dbpack/pkg/misc/uuid"
+	"github.com/cectc/dbpack/pkg/osc"
 	"github.com/cectc/dbpack/pkg/plan"
 	"github.com/cectc/dbpack/pkg/proto"
 	"github.com/cectc/dbpack/pkg/resource"
 	"github.com/cectc/dbpack/pkg/topo"
 	"github.com/cectc/dbpack/pkg/visitor"
 	"github.com/cectc/dbpack/third_party/parser"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+	"github.com/cectc/dbpack/third_party/parser/format"
+	"github.com/cectc/dbpack/third_party/parser/model"
 )
 
 func TestOptimizeQueryOnSingleDB(t *testing.T) {
@@ -302,6 +308,369 @@ func TestOptimizeDeleteOnMultiDB(t *testing.T) {
 	assert.Contains(t, []string{"student_1", "student_15"}, deletePlan.Plans[1].Tables[0])
 }
 
+func TestOptimizeAlterTable(t *testing.T) {
+	o := mockOptimizer()
+	stmt := parseStmt(t, "alter table student add column email varchar(64)")
+	pl, err := o.Optimize(context.Background(), stmt)
+	assert.NoError(t, err)
+	alterPlan, ok := pl.(*plan.MultiDirectlyQueryPlan)
+	assert.True(t, ok)
+	assert.Len(t, alterPlan.Plans, 100)
+	assert.Equal(t, "ALTER TABLE `student_0` ADD COLUMN `email` VARCHAR(64)", restore(t, alterPlan.Plans[0].Stmt))
+	assert.Equal(t, "ALTER TABLE `student_99` ADD COLUMN `email` VARCHAR(64)", restore(t, alterPlan.Plans[99].Stmt))
+	// the statement of the client is not changed by the plans of the shards
+	assert.Equal(t, "ALTER TABLE `student` ADD COLUMN `email` VARCHAR(64)", restore(t, stmt))
+
+	o.onlineDDL = &osc.Config{ChunkSize: 100}
+	pl, err = o.Optimize(context.Background(), stmt)
+	assert.NoError(t, err)
+	onlineDDLPlan, ok := pl.(*plan.OnlineDDLPlan)
+	assert.True(t, ok)
+	assert.Equal(t, "student", onlineDDLPlan.Table)
+	assert.Len(t, onlineDDLPlan.Shards, 100)
+	assert.Equal(t, "student_15", onlineDDLPlan.Shards[15].Table)
+
+	_, err = o.Optimize(context.Background(), parseStmt(t, "alter table student rename column name to full_name"))
+	assert.ErrorContains(t, err, "renaming columns")
+}
+
+// namedExecutor is a db group only known by its name.
+type namedExecutor struct {
+	proto.DBGroupExecutor
+	name string
+}
+
+func (e *namedExecutor) GroupName() string {
+	return e.name
+}
+
+func TestOptimizeReplicatedTable(t *testing.T) {
+	o := mockOptimizer()
+	world0, world1 := &namedExecutor{name: "school_0"}, &namedExecutor{name: "school_1"}
+	o.dbGroupExecutors["school_0"], o.dbGroupExecutors["school_1"] = world0, world1
+	replicas := plan.NewReplicas([]proto.DBGroupExecutor{world0, world1})
+	o.replicas = map[string]*plan.Replicas{"country": replicas}
+
+	pl, err := o.Optimize(context.Background(), parseStmt(t, "select * from Country where code = 'CHN'"))
+	assert.NoError(t, err)
+	assert.Equal(t, replicas, pl.(*plan.ReplicaQueryPlan).Replicas)
+
+	for _, sql := range []string{
+		"insert into country (code, name) values ('CHN', 'China')",
+		"update country set name = 'China' where code = 'CHN'",
+		"delete from country where code = 'CHN'",
+	} {
+		pl, err = o.Optimize(context.Background(), parseStmt(t, sql))
+		assert.NoError(t, err)
+		assert.Equal(t, replicas, pl.(*plan.BroadcastPlan).Replicas, sql)
+	}
+
+	pl, err = o.Optimize(context.Background(), parseStmt(t, "alter table country add column capital varchar(64)"))
+	assert.NoError(t, err)
+	ddlPlan := pl.(*plan.MultiDirectlyQueryPlan)
+	assert.Len(t, ddlPlan.Plans, 2)
+	assert.Equal(t, world1, ddlPlan.Plans[1].Executor)
+
+	// a common table expression joins the replica in the database of the sharded table
+	pl, err = o.Optimize(context.Background(), parseStmt(t,
+		"with s as (select * from student where id = 15) select * from s join country c on s.country = c.code"))
+	assert.NoError(t, err)
+	assert.Equal(t, world1, pl.(*plan.DirectQueryPlan).Executor)
+	_, err = o.Optimize(context.Background(), parseStmt(t,
+		"with s as (select * from student where id = 25) select * from s join country c on s.country = c.code"))
+	assert.ErrorContains(t, err, "without a replica in school_2")
+}
+
+func TestOptimizeReplicatedJoin(t *testing.T) {
+	o := mockOptimizer()
+	world0, world1 := &namedExecutor{name: "school_0"}, &namedExecutor{name: "school_1"}
+	o.dbGroupExecutors["school_0"], o.dbGroupExecutors["school_1"] = world0, world1
+	o.replicas = map[string]*plan.Replicas{
+		"country":  plan.NewReplicas([]proto.DBGroupExecutor{world0, world1}),
+		"city":     plan.NewReplicas([]proto.DBGroupExecutor{world1}),
+		"language": plan.NewReplicas([]proto.DBGroupExecutor{world0}),
+	}
+
+	// the tables have replicas in a common db group
+	pl, err := o.Optimize(context.Background(), parseStmt(t,
+		"select * from country c join city ci on ci.country_code = c.code"))
+	assert.NoError(t, err)
+	assert.Equal(t, []proto.DBGroupExecutor{world1}, pl.(*plan.ReplicaQueryPlan).Replicas.Executors)
+
+	// the tables are in different db groups
+	pl, err = o.Optimize(context.Background(), parseStmt(t,
+		"select ci.name, l.name from city ci join language l on l.country_code = ci.country_code "+
+			"where ci.population > 1000000"))
+	assert.NoError(t, err)
+	federated := pl.(*plan.FederatedQueryPlan)
+	assert.Len(t, federated.Sources, 2)
+	assert.Equal(t, "SELECT * FROM `city` AS `ci` WHERE (`ci`.`population`>1000000)", federated.Sources[0].SQL)
+	assert.Equal(t, world0, federated.Sources[1].Replicas.Executors[0])
+
+	_, err = o.Optimize(context.Background(), parseStmt(t,
+		"select * from city ci join language l on l.country_code > ci.country_code"))
+	assert.ErrorContains(t, err, "only equalities of columns can join tables")
+}
+
+func TestOptimizeLogicalSchema(t *testing.T) {
+	tp, _ := topo.ParseTopology("school", "student", map[int]string{0: "0-1", 1: "2"})
+	school0, school1 := &namedExecutor{name: "school_0"}, &namedExecutor{name: "school_1"}
+	o := &Optimizer{
+		executors:  []proto.DBGroupExecutor{school0, school1},
+		topologies: map[string]*topo.Topology{"student": tp},
+	}
+	logicalTables := map[string]string{"student_0": "student", "student_1": "student", "student_2": "student"}
+
+	stmt := parseStmt(t, "select table_name, table_rows from information_schema.tables "+
+		"where table_schema = 'school' and table_name = ?")
+	pl, err := o.Optimize(context.Background(), stmt, "student")
+	assert.NoError(t, err)
+	schemaPlan := pl.(*plan.LogicalSchemaPlan)
+	assert.Equal(t, []proto.DBGroupExecutor{school0, school1}, schemaPlan.Executors)
+	assert.Equal(t, logicalTables, schemaPlan.LogicalTables)
+	assert.Equal(t, "SELECT `table_name`,`table_rows` FROM `information_schema`.`tables` "+
+		"WHERE `table_schema`='school' AND CASE `table_name` WHEN 'student_0' THEN 'student' "+
+		"WHEN 'student_1' THEN 'student' WHEN 'student_2' THEN 'student' ELSE `table_name` END=?",
+		restore(t, schemaPlan.Stmt))
+	// the statement is rewritten on a copy
+	assert.Equal(t, "SELECT `table_name`,`table_rows` FROM `information_schema`.`tables` "+
+		"WHERE `table_schema`='school' AND `table_name`=?", restore(t, stmt))
+
+	// the aggregates are computed over a physical table of each logical table of the first db group
+	pl, err = o.Optimize(context.Background(), parseStmt(t,
+		"select count(*) from information_schema.tables t where t.table_schema = 'school'"))
+	assert.NoError(t, err)
+	schemaPlan = pl.(*plan.LogicalSchemaPlan)
+	assert.Equal(t, []proto.DBGroupExecutor{school0}, schemaPlan.Executors)
+	assert.Equal(t, "SELECT COUNT(1) FROM `information_schema`.`tables` AS `t` "+
+		"WHERE (`t`.`table_schema`='school') AND `t`.`TABLE_NAME` NOT IN ('student_1')", restore(t, schemaPlan.Stmt))
+
+	pl, err = o.Optimize(context.Background(), parseStmt(t, "select * from information_schema.schemata"))
+	assert.NoError(t, err)
+	assert.Equal(t, []proto.DBGroupExecutor{school0}, pl.(*plan.LogicalSchemaPlan).Executors)
+
+	show := parseStmt(t, "show full tables like 'stu%'")
+	pl, err = o.Optimize(context.Background(), show)
+	assert.NoError(t, err)
+	schemaPlan = pl.(*plan.LogicalSchemaPlan)
+	assert.Equal(t, "stu%", schemaPlan.Pattern)
+	assert.Equal(t, "SHOW FULL TABLES", restore(t, schemaPlan.Stmt))
+	assert.Equal(t, "SHOW FULL TABLES LIKE 'stu%'", restore(t, show))
+
+	pl, err = o.Optimize(context.Background(), parseStmt(t, "show table status"))
+	assert.NoError(t, err)
+	assert.Equal(t, []proto.DBGroupExecutor{school0, school1}, pl.(*plan.LogicalSchemaPlan).Executors)
+
+	show = parseStmt(t, "show index from student")
+	pl, err = o.Optimize(context.Background(), show)
+	assert.NoError(t, err)
+	schemaPlan = pl.(*plan.LogicalSchemaPlan)
+	assert.Equal(t, []proto.DBGroupExecutor{school0}, schemaPlan.Executors)
+	assert.Equal(t, "SHOW INDEX IN `student_0`", restore(t, schemaPlan.Stmt))
+	assert.Equal(t, "SHOW INDEX IN `student`", restore(t, show))
+}
+
+func TestOptimizeWindowFunction(t *testing.T) {
+	testCases := []struct {
+		sql         string
+		args        []interface{}
+		expectedErr bool
+	}{
+		{
+			sql:  "select id, row_number() over (order by age) from student where id = ?",
+			args: []interface{}{1},
+		},
+		{
+			sql:         "select id, row_number() over (order by age) from student where id in (?, ?)",
+			args:        []interface{}{1, 15},
+			expectedErr: true,
+		},
+		{
+			sql:         "select id, sum(age) over w from student window w as (order by id)",
+			expectedErr: true,
+		},
+	}
+	o := mockOptimizer()
+	resource.SetDBManager("app1", &resource.DBManager{})
+	var cache *meta.MysqlTableMetaCache
+	patches := gomonkey.ApplyMethodFunc(cache, "GetTableMeta", func(ctx context.Context, db proto.DB, tableName string) (schema.TableMeta, error) {
+		return schema.TableMeta{
+			SchemaName: "school",
+			TableName:  "student",
+			AllIndexes: map[string]schema.IndexMeta{
+				"id": {
+					Values:    []schema.ColumnMeta{{ColumnName: "id"}},
+					IndexType: schema.IndexTypePrimary,
+				},
+			},
+		}, nil
+	})
+	defer patches.Reset()
+	for _, c := range testCases {
+		t.Run(c.sql, func(t *testing.T) {
+			p := parser.New()
+			stmt, err := p.ParseOneStmt(c.sql, "", "")
+			assert.Nil(t, err)
+			stmt.Accept(&visitor.ParamVisitor{})
+			pl, err := o.Optimize(context.Background(), stmt, c.args...)
+			if c.expectedErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			_, ok := pl.(*plan.QueryOnSingleDBPlan)
+			assert.True(t, ok)
+		})
+	}
+}
+
+func TestOptimizeWith(t *testing.T) {
+	testCases := []struct {
+		sql         string
+		args        []interface{}
+		expectedSql string
+		forceMaster bool
+		expectedErr bool
+	}{
+		{
+			sql:         "with s as (select id, name from student where id = ?) select * from s",
+			args:        []interface{}{1},
+			expectedSql: "WITH `s` AS (SELECT `id`,`name` FROM `student_1` AS `student` WHERE `id`=?) SELECT * FROM `s`",
+		},
+		{
+			sql:         "with recursive seq(n) as (select 1 union all select n + 1 from seq where n < 10) select n from seq",
+			expectedSql: "WITH RECURSIVE `seq` (`n`) AS (SELECT 1 UNION ALL SELECT `n`+1 FROM `seq` WHERE `n`<10) SELECT `n` FROM `seq`",
+			forceMaster: true,
+		},
+		{
+			sql:         "with s as (select id from student where id in (?, ?)) select * from s",
+			args:        []interface{}{1, 15},
+			expectedErr: true,
+		},
+		{
+			sql:         "with s as (select id from student) select * from s",
+			expectedErr: true,
+		},
+	}
+	o := mockOptimizer()
+	o.executors = []proto.DBGroupExecutor{nil}
+	for _, c := range testCases {
+		t.Run(c.sql, func(t *testing.T) {
+			p := parser.New()
+			stmt, err := p.ParseOneStmt(c.sql, "", "")
+			assert.Nil(t, err)
+			stmt.Accept(&visitor.ParamVisitor{})
+			pl, err := o.Optimize(context.Background(), stmt, c.args...)
+			if c.expectedErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			directPlan, ok := pl.(*plan.DirectQueryPlan)
+			assert.True(t, ok)
+			assert.Equal(t, c.forceMaster, directPlan.ForceMaster)
+			var sb strings.Builder
+			err = directPlan.Stmt.Restore(format.NewRestoreCtx(constant.DBPackRestoreFormat, &sb))
+			assert.Nil(t, err)
+			assert.Equal(t, c.expectedSql, sb.String())
+		})
+	}
+}
+
+func TestOptimizeLimit(t *testing.T) {
+	o := mockOptimizer()
+	resource.SetDBManager("app1", &resource.DBManager{})
+	var cache *meta.MysqlTableMetaCache
+	patches := gomonkey.ApplyMethodFunc(cache, "GetTableMeta", func(ctx context.Context, db proto.DB, tableName string) (schema.TableMeta, error) {
+		return schema.TableMeta{
+			SchemaName: "school",
+			TableName:  "student",
+			AllIndexes: map[string]schema.IndexMeta{
+				"id": {
+					Values:    []schema.ColumnMeta{{ColumnName: "id"}},
+					IndexType: schema.IndexTypePrimary,
+				},
+			},
+		}, nil
+	})
+	defer patches.Reset()
+
+	t.Run("single table", func(t *testing.T) {
+		stmt := parseStmt(t, "delete from student where id = ? order by age limit ?")
+		pl, err := o.Optimize(context.Background(), stmt, 1, 10)
+		assert.Nil(t, err)
+		_, ok := pl.(*plan.DeletePlan)
+		assert.True(t, ok)
+	})
+
+	t.Run("delete across shards", func(t *testing.T) {
+		stmt := parseStmt(t, "delete from student where id in (?, ?) order by age desc limit ?")
+		pl, err := o.Optimize(context.Background(), stmt, 1, 15, 10)
+		assert.Nil(t, err)
+		limitPlan, ok := pl.(*plan.LimitPlan)
+		assert.True(t, ok)
+		assert.Equal(t, "id", limitPlan.PK)
+		selectPlan, ok := limitPlan.Select.(*plan.QueryOnMultiDBPlan)
+		assert.True(t, ok)
+		assert.Equal(t, []interface{}{1, 15}, selectPlan.Plans[0].Args)
+		assert.Equal(t, "SELECT `id`,`age` FROM `student` WHERE `id` IN (?,?) ORDER BY `age` DESC LIMIT 10",
+			restore(t, selectPlan.Stmt))
+
+		keys := &ast.PatternInExpr{
+			Expr: &ast.ColumnNameExpr{Name: &ast.ColumnName{Name: model.NewCIStr("id")}},
+			List: []ast.ExprNode{ast.NewValueExpr(15, "", "")},
+		}
+		routed, err := limitPlan.Route(context.Background(), keys)
+		assert.Nil(t, err)
+		var deleteStmt *ast.DeleteStmt
+		switch dp := routed.(type) {
+		case *plan.DeletePlan:
+			deleteStmt = dp.Stmt
+			assert.Equal(t, []interface{}{1, 15}, dp.Args)
+		case *plan.MultiDeletePlan:
+			deleteStmt = dp.Stmt
+			assert.Equal(t, []interface{}{1, 15}, dp.Plans[0].Args)
+		default:
+			t.Fatalf("unexpected plan %T", routed)
+		}
+		assert.Nil(t, deleteStmt.Limit)
+		assert.Nil(t, deleteStmt.Order)
+		assert.Equal(t, "(`id` IN (?,?)) AND `id` IN (15)", restore(t, deleteStmt.Where))
+	})
+
+	t.Run("update across shards", func(t *testing.T) {
+		stmt := parseStmt(t, "update student set age = ? where id in (?, ?) limit ?")
+		pl, err := o.Optimize(context.Background(), stmt, 18, 1, 15, 10)
+		assert.Nil(t, err)
+		limitPlan, ok := pl.(*plan.LimitPlan)
+		assert.True(t, ok)
+		selectPlan, ok := limitPlan.Select.(*plan.QueryOnMultiDBPlan)
+		assert.True(t, ok)
+		assert.Equal(t, []interface{}{1, 15}, selectPlan.Plans[0].Args)
+	})
+
+	t.Run("order by expression", func(t *testing.T) {
+		stmt := parseStmt(t, "delete from student where id in (?, ?) order by age + 1 limit 10")
+		_, err := o.Optimize(context.Background(), stmt, 1, 15)
+		assert.NotNil(t, err)
+	})
+}
+
+func parseStmt(t *testing.T, sql string) ast.StmtNode {
+	p := parser.New()
+	stmt, err := p.ParseOneStmt(sql, "", "")
+	assert.Nil(t, err)
+	stmt.Accept(&visitor.ParamVisitor{})
+	return stmt
+}
+
+func restore(t *testing.T, node ast.Node) string {
+	var sb strings.Builder
+	err := node.Restore(format.NewRestoreCtx(constant.DBPackRestoreFormat, &sb))
+	assert.Nil(t, err)
+	return sb.String()
+}
+
 func TestOptimizeInsert(t *testing.T) {
 	o := mockOptimizer()
 	sql := "insert into student(id, name, age) values (?, ? ,?)"
@@ -411,6 +780,225 @@ func TestOptimizeInsert(t *testing.T) {
 	assert.Equal(t, "student_18", insertPlan.Table)
 }
 
+func TestOptimizeHotCold(t *testing.T) {
+	tp, err := topo.ParseTopology("orders", "orders", map[int]string{0: "0", 1: "1"})
+	assert.NoError(t, err)
+	alg, err := cond.NewHotCold("created_at", true, tp, map[string]interface{}{"cutoff": "2022-01-01"},
+		time.UTC, nil)
+	assert.NoError(t, err)
+	o := &Optimizer{
+		appid:            "app1",
+		dbGroupExecutors: map[string]proto.DBGroupExecutor{"orders_0": nil, "orders_1": nil},
+		algorithms:       map[string]cond.ShardingAlgorithm{"orders": alg},
+		topologies:       map[string]*topo.Topology{"orders": tp},
+	}
+
+	resource.SetDBManager("app1", &resource.DBManager{})
+	var cache *meta.MysqlTableMetaCache
+	patches := gomonkey.ApplyMethodFunc(cache, "GetTableMeta", func(ctx context.Context, db proto.DB, tableName string) (schema.TableMeta, error) {
+		id := schema.ColumnMeta{TableName: tableName, ColumnName: "id", DataTypeName: "bigint"}
+		return schema.TableMeta{
+			SchemaName: "orders",
+			TableName:  tableName,
+			Columns:    []string{"id", "created_at"},
+			AllColumns: map[string]schema.ColumnMeta{
+				"id":         id,
+				"created_at": {TableName: tableName, ColumnName: "created_at", DataTypeName: "datetime"},
+			},
+			AllIndexes: map[string]schema.IndexMeta{
+				"id": {
+					Values:     []schema.ColumnMeta{id},
+					IndexName:  "PRIMARY",
+					ColumnName: "id",
+					IndexType:  schema.IndexTypePrimary,
+				},
+			},
+		}, nil
+	})
+	defer patches.Reset()
+
+	queryCtx := proto.WithCommandType(context.Background(), constant.ComQuery)
+	executeCtx := proto.WithCommandType(context.Background(), constant.ComStmtExecute)
+	insertCases := []struct {
+		ctx      context.Context
+		sql      string
+		args     []interface{}
+		expected string
+	}{
+		{ctx: queryCtx, sql: "insert into orders (id, created_at) values (1, '2022-03-01 10:00:00')", expected: "orders_0"},
+		{ctx: queryCtx, sql: "insert into orders (id, created_at) values (2, '2021-03-01 10:00:00')", expected: "orders_1"},
+		{
+			ctx:      executeCtx,
+			sql:      "insert into orders (id, created_at) values (?, ?)",
+			args:     []interface{}{3, time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)},
+			expected: "orders_1",
+		},
+	}
+	for _, c := range insertCases {
+		stmt := parseStmt(t, c.sql)
+		stmt.Accept(&visitor.ParamVisitor{})
+		pl, err := o.Optimize(c.ctx, stmt, c.args...)
+		assert.NoError(t, err)
+		insertPlan := pl.(*plan.InsertPlan)
+		assert.Equal(t, c.expected, insertPlan.Database, c.sql)
+		assert.Equal(t, c.expected, insertPlan.Table, c.sql)
+	}
+
+	stmt := parseStmt(t, "select * from orders where created_at < '2021-06-01'")
+	pl, err := o.Optimize(queryCtx, stmt)
+	assert.NoError(t, err)
+	assert.Equal(t, "orders_1", pl.(*plan.QueryOnSingleDBPlan).Database)
+
+	stmt = parseStmt(t, "select * from orders where created_at >= '2021-12-01' order by created_at desc limit 10")
+	pl, err = o.Optimize(queryCtx, stmt)
+	assert.NoError(t, err)
+	routes, err := plan.ExplainRoute(pl)
+	assert.NoError(t, err)
+	assert.Len(t, routes.Rows, 2)
+}
+
+func TestOptimizeUpsert(t *testing.T) {
+	testCases := []struct {
+		sql         string
+		args        []interface{}
+		expectedErr bool
+	}{
+		{
+			sql:  "insert into student(id, name) values (?, ?) on duplicate key update name = values(name)",
+			args: []interface{}{18, "scott"},
+		},
+		{
+			sql:  "replace into student(id, name) values (?, ?)",
+			args: []interface{}{18, "scott"},
+		},
+		{
+			sql:         "insert into student(id, name) values (?, ?) on duplicate key update id = id + 1",
+			args:        []interface{}{18, "scott"},
+			expectedErr: true,
+		},
+	}
+	o := mockOptimizer()
+	resource.SetDBManager("app1", &resource.DBManager{})
+	var cache *meta.MysqlTableMetaCache
+	patches := gomonkey.ApplyMethodFunc(cache, "GetTableMeta", func(ctx context.Context, db proto.DB, tableName string) (schema.TableMeta, error) {
+		return schema.TableMeta{
+			SchemaName: "school",
+			TableName:  "student",
+			Columns:    []string{"id", "name"},
+			AllIndexes: map[string]schema.IndexMeta{
+				"id": {
+					Values:    []schema.ColumnMeta{{ColumnName: "id"}},
+					IndexType: schema.IndexTypePrimary,
+				},
+			},
+		}, nil
+	})
+	defer patches.Reset()
+	for _, c := range testCases {
+		t.Run(c.sql, func(t *testing.T) {
+			p := parser.New()
+			stmt, err := p.ParseOneStmt(c.sql, "", "")
+			assert.Nil(t, err)
+			stmt.Accept(&visitor.ParamVisitor{})
+			ctx := proto.WithCommandType(context.Background(), constant.ComStmtExecute)
+			pl, err := o.Optimize(ctx, stmt, c.args...)
+			if c.expectedErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			insertPlan, ok := pl.(*plan.InsertPlan)
+			assert.True(t, ok)
+			assert.Equal(t, "school_1", insertPlan.Database)
+			assert.Equal(t, "student_18", insertPlan.Table)
+		})
+	}
+}
+
+func TestRewriteInsertColumns(t *testing.T) {
+	tableMeta := schema.TableMeta{
+		SchemaName: "school",
+		TableName:  "student",
+		Columns:    []string{"id", "name", "name_upper"},
+		AllColumns: map[string]schema.ColumnMeta{
+			"id":   {ColumnName: "id", IsAutoIncrement: "auto_increment"},
+			"name": {ColumnName: "name"},
+			"name_upper": {
+				ColumnName:           "name_upper",
+				IsAutoIncrement:      "VIRTUAL GENERATED",
+				GenerationExpression: "upper(`name`)",
+			},
+		},
+	}
+
+	testCases := []struct {
+		sql             string
+		expectedColumns []string
+		expectedValues  string
+		expectedErr     bool
+	}{
+		{
+			sql:             "insert into student(id, name, name_upper) values (1, 'scott', DEFAULT), (2, 'lucy', DEFAULT)",
+			expectedColumns: []string{"id", "name"},
+			expectedValues:  "(1,'scott'),(2,'lucy')",
+		},
+		{
+			sql:             "insert into student(id, name) values (1, 'scott')",
+			expectedColumns: []string{"id", "name"},
+			expectedValues:  "(1,'scott')",
+		},
+		{
+			sql:         "insert into student(id, name, name_upper) values (1, 'scott', 'SCOTT')",
+			expectedErr: true,
+		},
+		{
+			sql:         "insert into student(id, name) values (1)",
+			expectedErr: true,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.sql, func(t *testing.T) {
+			p := parser.New()
+			stmt, err := p.ParseOneStmt(c.sql, "", "")
+			assert.Nil(t, err)
+			insertStmt := stmt.(*ast.InsertStmt)
+			var columns []string
+			for _, column := range insertStmt.Columns {
+				columns = append(columns, column.Name.String())
+			}
+			listLen := len(insertStmt.Lists[0])
+
+			rewroteColumns, rewroteStmt, err := rewriteInsertColumns(insertStmt, columns, tableMeta)
+			if c.expectedErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, c.expectedColumns, rewroteColumns)
+			// the original statement must be kept intact
+			assert.Equal(t, listLen, len(insertStmt.Lists[0]))
+
+			var sb strings.Builder
+			restoreCtx := format.NewRestoreCtx(constant.DBPackRestoreFormat, &sb)
+			for i, row := range rewroteStmt.Lists {
+				if i != 0 {
+					sb.WriteString(",")
+				}
+				sb.WriteString("(")
+				for j, value := range row {
+					if j != 0 {
+						sb.WriteString(",")
+					}
+					assert.Nil(t, value.Restore(restoreCtx))
+				}
+				sb.WriteString(")")
+			}
+			assert.Equal(t, c.expectedValues, sb.String())
+		})
+	}
+}
+
 func mockOptimizer() *Optimizer {
 	tp := mockTopology()
 	generator, _ := uuid.NewWorker(123)
@@ -0,0 +1,264 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package optimize
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uber-go/atomic"
+
+	"github.com/cectc/dbpack/pkg/plan"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/third_party/cache"
+)
+
+const (
+	planCacheHit  = "hit"
+	planCacheMiss = "miss"
+)
+
+var planCacheCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "dbpack",
+	Subsystem: "plan_cache",
+	Name:      "count",
+	Help:      "plan cache lookup count",
+}, []string{"appid", "result"})
+
+func init() {
+	prometheus.MustRegister(planCacheCounter)
+}
+
+var (
+	planCachesLock sync.RWMutex
+	// planCaches maps application ids to the plan caches of their executors.
+	planCaches = make(map[string]map[string]*PlanCache)
+)
+
+// RegisterPlanCache registers the plan cache of an executor, so that it can be inspected and flushed.
+func RegisterPlanCache(appid, executor string, c *PlanCache) {
+	planCachesLock.Lock()
+	defer planCachesLock.Unlock()
+	if planCaches[appid] == nil {
+		planCaches[appid] = make(map[string]*PlanCache)
+	}
+	planCaches[appid][executor] = c
+}
+
+// GetPlanCaches returns the plan caches of an application keyed by executor name.
+func GetPlanCaches(appid string) map[string]*PlanCache {
+	planCachesLock.RLock()
+	defer planCachesLock.RUnlock()
+	caches := make(map[string]*PlanCache, len(planCaches[appid]))
+	for executor, c := range planCaches[appid] {
+		caches[executor] = c
+	}
+	return caches
+}
+
+// PlanCacheApplicationIDs returns the sorted ids of the applications having plan caches.
+func PlanCacheApplicationIDs() []string {
+	planCachesLock.RLock()
+	defer planCachesLock.RUnlock()
+	appids := make([]string, 0, len(planCaches))
+	for appid := range planCaches {
+		appids = append(appids, appid)
+	}
+	sort.Strings(appids)
+	return appids
+}
+
+// PlanCache caches the routed plans of statements keyed by schema, sql text and arguments, so that repeated
+// statements skip optimization, they are still parsed, as the executors need the statement. The sql text of
+// a prepared statement is its fingerprint, the sql text of a text query holds the literals the plan is routed
+// by. The cache is created with the optimizer, it is dropped when the sharding rules are reloaded, and purged
+// when an index is created or dropped, or a table is altered, dropped, truncated or renamed. The plans of a
+// statistics version are dropped once statistics are collected again.
+type PlanCache struct {
+	appid string
+	lru   *cache.LRUCache
+}
+
+type cachedPlan struct {
+	plan              proto.Plan
+	statisticsVersion int64
+	cachedAt          time.Time
+	hits              *atomic.Int64
+}
+
+// PlanCacheEntry describes a cached plan.
+type PlanCacheEntry struct {
+	Schema string   `json:"schema"`
+	SQL    string   `json:"sql"`
+	Args   []string `json:"args,omitempty"`
+	// Hits is the number of times the plan is used since it was cached.
+	Hits     int64     `json:"hits"`
+	CachedAt time.Time `json:"cached_at"`
+	// Statements are the physical statements the plan expands to.
+	Statements []*plan.PhysicalStatement `json:"statements,omitempty"`
+	// Error is why the physical statements could not be generated.
+	Error string `json:"error,omitempty"`
+}
+
+// Size implements cache.Value, plan cache is bounded by the count of plans.
+func (p *cachedPlan) Size() int {
+	return 1
+}
+
+// NewPlanCache creates a plan cache holding at most capacity plans.
+func NewPlanCache(appid string, capacity int64) *PlanCache {
+	return &PlanCache{
+		appid: appid,
+		lru:   cache.NewLRUCache(capacity),
+	}
+}
+
+func (c *PlanCache) Get(key string, statisticsVersion int64) (proto.Plan, bool) {
+	value, ok := c.lru.Get(key)
+	if !ok {
+		planCacheCounter.WithLabelValues(c.appid, planCacheMiss).Inc()
+		return nil, false
+	}
+	cached := value.(*cachedPlan)
+	if cached.statisticsVersion != statisticsVersion {
+		c.lru.Delete(key)
+		planCacheCounter.WithLabelValues(c.appid, planCacheMiss).Inc()
+		return nil, false
+	}
+	planCacheCounter.WithLabelValues(c.appid, planCacheHit).Inc()
+	cached.hits.Inc()
+	return clonePlan(cached.plan), true
+}
+
+func (c *PlanCache) Set(key string, statisticsVersion int64, p proto.Plan) {
+	c.lru.Set(key, &cachedPlan{
+		plan:              clonePlan(p),
+		statisticsVersion: statisticsVersion,
+		cachedAt:          time.Now(),
+		hits:              atomic.NewInt64(0),
+	})
+}
+
+// Purge removes all the cached plans.
+func (c *PlanCache) Purge() {
+	c.lru.Clear()
+}
+
+// Capacity returns the max count of cached plans.
+func (c *PlanCache) Capacity() int64 {
+	return c.lru.Capacity()
+}
+
+// Evictions returns the count of plans evicted as the cache is full.
+func (c *PlanCache) Evictions() int64 {
+	return c.lru.Evictions()
+}
+
+// Entries describes the cached plans, ordered from the most recently used to the least recently used.
+func (c *PlanCache) Entries() []*PlanCacheEntry {
+	items := c.lru.Items()
+	entries := make([]*PlanCacheEntry, 0, len(items))
+	for _, item := range items {
+		cached := item.Value.(*cachedPlan)
+		entry := parsePlanCacheKey(item.Key)
+		entry.Hits = cached.hits.Load()
+		entry.CachedAt = cached.cachedAt
+		statements, err := plan.PhysicalStatements(cached.plan)
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		entry.Statements = statements
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// Flush removes the cached plans of the sql text, or all the cached plans if it is empty,
+// it returns the count of removed plans.
+func (c *PlanCache) Flush(sqlText string) int {
+	if sqlText == "" {
+		count := int(c.lru.Length())
+		c.lru.Clear()
+		return count
+	}
+	var count int
+	for _, key := range c.lru.Keys() {
+		if parsePlanCacheKey(key).SQL == sqlText && c.lru.Delete(key) {
+			count++
+		}
+	}
+	return count
+}
+
+// planCacheKey returns the key of the plan of a statement, the plan depends on argument values, so they are part
+// of it.
+func planCacheKey(ctx context.Context, args []interface{}) string {
+	sqlText := proto.SqlText(ctx)
+	if sqlText == "" {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString(proto.Schema(ctx))
+	sb.WriteByte(0)
+	sb.WriteString(fmt.Sprintf("%d", proto.CommandType(ctx)))
+	sb.WriteByte(0)
+	sb.WriteString(sqlText)
+	for _, arg := range args {
+		sb.WriteByte(0)
+		sb.WriteString(fmt.Sprintf("%T:%v", arg, arg))
+	}
+	return sb.String()
+}
+
+// parsePlanCacheKey splits a key built by planCacheKey into the schema, sql text and arguments.
+func parsePlanCacheKey(key string) *PlanCacheEntry {
+	parts := strings.Split(key, "\x00")
+	entry := &PlanCacheEntry{Schema: parts[0]}
+	if len(parts) > 2 {
+		entry.SQL = parts[2]
+		entry.Args = parts[3:]
+	}
+	return entry
+}
+
+// clonePlan copies the plans modified during execution, so that a cached plan can be executed concurrently.
+func clonePlan(p proto.Plan) proto.Plan {
+	switch pl := p.(type) {
+	case *plan.QueryOnSingleDBPlan:
+		cloned := *pl
+		return &cloned
+	case *plan.QueryOnMultiDBPlan:
+		cloned := *pl
+		cloned.Plans = make([]*plan.QueryOnSingleDBPlan, 0, len(pl.Plans))
+		for _, sp := range pl.Plans {
+			single := *sp
+			cloned.Plans = append(cloned.Plans, &single)
+		}
+		return &cloned
+	case *plan.LimitPlan:
+		cloned := *pl
+		cloned.Select = clonePlan(pl.Select)
+		return &cloned
+	default:
+		return p
+	}
+}
@@ -0,0 +1,116 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package optimize
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/plan"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/third_party/parser"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+)
+
+func TestPlanCache(t *testing.T) {
+	planCache := NewPlanCache("app1", 2)
+	multiPlan := &plan.QueryOnMultiDBPlan{
+		Plans: []*plan.QueryOnSingleDBPlan{
+			{Database: "school_0", Tables: []string{"student_0"}},
+			{Database: "school_1", Tables: []string{"student_5"}},
+		},
+	}
+
+	ctx := proto.WithCommandType(context.Background(), constant.ComStmtExecute)
+	ctx = proto.WithSchema(ctx, "school")
+	ctx = proto.WithSqlText(ctx, "select * from student where id > ?")
+	key := planCacheKey(ctx, []interface{}{int64(1)})
+	assert.NotEqual(t, key, planCacheKey(ctx, []interface{}{int64(2)}))
+	assert.Equal(t, "", planCacheKey(context.Background(), nil))
+
+	_, ok := planCache.Get(key, 0)
+	assert.False(t, ok)
+
+	planCache.Set(key, 0, multiPlan)
+	cached, ok := planCache.Get(key, 0)
+	assert.True(t, ok)
+	assert.Equal(t, multiPlan, cached)
+	// cached plans are copied, executing them concurrently does not share the sub plans
+	assert.NotSame(t, multiPlan.Plans[0], cached.(*plan.QueryOnMultiDBPlan).Plans[0])
+
+	// statistics changed
+	_, ok = planCache.Get(key, 1)
+	assert.False(t, ok)
+
+	planCache.Set(key, 1, multiPlan)
+	planCache.Purge()
+	_, ok = planCache.Get(key, 1)
+	assert.False(t, ok)
+}
+
+func TestPlanCacheEntries(t *testing.T) {
+	planCache := NewPlanCache("app2", 10)
+	RegisterPlanCache("app2", "sharding", planCache)
+	assert.Same(t, planCache, GetPlanCaches("app2")["sharding"])
+	assert.Contains(t, PlanCacheApplicationIDs(), "app2")
+
+	stmt, err := parser.New().ParseOneStmt("delete from student where id = 9", "", "")
+	assert.NoError(t, err)
+	deletePlan := &plan.DeletePlan{Database: "school_0", Tables: []string{"student_9"}, Stmt: stmt.(*ast.DeleteStmt)}
+
+	ctx := proto.WithCommandType(context.Background(), constant.ComQuery)
+	ctx = proto.WithSchema(ctx, "school")
+	ctx = proto.WithSqlText(ctx, "delete from student where id = 9")
+	deleteKey := planCacheKey(ctx, nil)
+	planCache.Set(deleteKey, 0, deletePlan)
+	for i := 0; i < 2; i++ {
+		_, ok := planCache.Get(deleteKey, 0)
+		assert.True(t, ok)
+	}
+	ctx = proto.WithSqlText(ctx, "delete from student where id = ?")
+	planCache.Set(planCacheKey(ctx, []interface{}{int64(9)}), 0, &plan.InsertPlan{})
+
+	entries := planCache.Entries()
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "delete from student where id = ?", entries[0].SQL)
+	assert.Equal(t, []string{"int64:9"}, entries[0].Args)
+	assert.NotEmpty(t, entries[0].Error)
+	assert.Equal(t, "school", entries[1].Schema)
+	assert.Equal(t, int64(2), entries[1].Hits)
+	assert.Equal(t, []*plan.PhysicalStatement{{Database: "school_0", SQL: "DELETE FROM student_9 WHERE `id`=9"}},
+		entries[1].Statements)
+
+	assert.Equal(t, 0, planCache.Flush("select 1"))
+	assert.Equal(t, 1, planCache.Flush("delete from student where id = 9"))
+	assert.Equal(t, 1, planCache.Flush(""))
+	assert.Empty(t, planCache.Entries())
+}
+
+func TestOptimizerPurgesPlanCache(t *testing.T) {
+	planCache := NewPlanCache("app3", 10)
+	o := Optimizer{planCache: planCache}
+	for _, sql := range []string{"drop table student", "truncate table student", "rename table student to pupil"} {
+		planCache.Set("key", 0, &plan.InsertPlan{})
+		stmt, err := parser.New().ParseOneStmt(sql, "", "")
+		assert.NoError(t, err)
+		_, _ = o.optimizeCached(context.Background(), stmt)
+		assert.Empty(t, planCache.Entries(), sql)
+	}
+}
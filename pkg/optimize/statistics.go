@@ -0,0 +1,164 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package optimize
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/cond"
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/resource"
+	"github.com/cectc/dbpack/pkg/topo"
+)
+
+// Statistics holds the row counts and sharding key ranges of physical tables, the statistics are used to prune
+// shards for range predicates. Statistics may be stale between two collections, rows inserted through dbpack are
+// observed immediately, rows written bypass dbpack are only visible after next collection.
+type Statistics struct {
+	appid string
+	mu    sync.RWMutex
+	// logic table name -> statistics
+	tables map[string]*TableStatistics
+	// version increases whenever statistics change, plans computed with older statistics are stale
+	version int64
+}
+
+type TableStatistics struct {
+	ShardingKey string
+	// table index -> statistics
+	Shards      map[int]*cond.ShardStatistics
+	CollectedAt time.Time
+}
+
+func NewStatistics(appid string) *Statistics {
+	return &Statistics{
+		appid:  appid,
+		tables: make(map[string]*TableStatistics),
+	}
+}
+
+// Collect queries row count, min and max sharding key of every physical table of the logic table.
+func (s *Statistics) Collect(shardingKey string, topology *topo.Topology) error {
+	tableStatistics := &TableStatistics{
+		ShardingKey: shardingKey,
+		Shards:      make(map[int]*cond.ShardStatistics, topology.TableSliceLen),
+	}
+	for index, table := range topology.TableIndexMap {
+		db := resource.GetDBManager(s.appid).GetDB(topology.Tables[table])
+		if db == nil {
+			return errors.Errorf("db %s of table %s should not be nil", topology.Tables[table], table)
+		}
+		sql := fmt.Sprintf("SELECT COUNT(1), MIN(`%s`), MAX(`%s`) FROM `%s`", shardingKey, shardingKey, table)
+		result, _, err := db.ExecuteSqlDirectly(sql)
+		if err != nil {
+			return errors.Wrapf(err, "collect statistics of table %s failed", table)
+		}
+		shardStatistics, err := decodeShardStatistics(result.(*mysql.Result))
+		if err != nil {
+			return errors.Wrapf(err, "decode statistics of table %s failed", table)
+		}
+		tableStatistics.Shards[index] = shardStatistics
+	}
+	tableStatistics.CollectedAt = time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tables[topology.TableName] = tableStatistics
+	s.version++
+	return nil
+}
+
+// Version returns the version of the statistics.
+func (s *Statistics) Version() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.version
+}
+
+// AutoCollect collects statistics of the logic table every interval until ctx is done.
+func (s *Statistics) AutoCollect(ctx context.Context, interval time.Duration, shardingKey string, topology *topo.Topology) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Collect(shardingKey, topology); err != nil {
+				log.Warnf("collect statistics of %s failed, err: %v", topology.TableName, err)
+			}
+		}
+	}
+}
+
+// Observe records a row inserted into the physical table.
+func (s *Statistics) Observe(logicTable string, index int, key interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tableStatistics, ok := s.tables[logicTable]
+	if !ok {
+		return
+	}
+	if shardStatistics, ok := tableStatistics.Shards[index]; ok {
+		if shardStatistics.Observe(key) {
+			s.version++
+		}
+	}
+}
+
+// Prune removes the shards can not match the condition according to the statistics of the logic table.
+func (s *Statistics) Prune(logicTable string, condition cond.Condition,
+	shards cond.TableIndexSliceCondition) cond.TableIndexSliceCondition {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tableStatistics, ok := s.tables[logicTable]
+	if !ok {
+		return shards
+	}
+	return cond.PruneShards(shards, tableStatistics.ShardingKey, condition, tableStatistics.Shards)
+}
+
+func decodeShardStatistics(result *mysql.Result) (*cond.ShardStatistics, error) {
+	statistics := &cond.ShardStatistics{}
+	if len(result.Rows) == 0 {
+		return statistics, nil
+	}
+	values, err := result.Rows[0].Decode()
+	if err != nil {
+		return nil, err
+	}
+	if values[0] != nil {
+		if statistics.RowCount, err = cond.ParseInt64(values[0].Val); err != nil {
+			return nil, err
+		}
+	}
+	if values[1] == nil || values[1].Val == nil || values[2] == nil || values[2].Val == nil {
+		return statistics, nil
+	}
+	minKey, err1 := cond.ParseInt64(values[1].Val)
+	maxKey, err2 := cond.ParseInt64(values[2].Val)
+	if err1 == nil && err2 == nil {
+		statistics.MinKey, statistics.MaxKey, statistics.HasKeyRange = minKey, maxKey, true
+	}
+	return statistics, nil
+}
@@ -0,0 +1,651 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package osc alters the shards of a logic table online, the way gh-ost and pt-online-schema-change
+// do: each shard is altered on a ghost table, the rows are copied to the ghost table in chunks by
+// the primary key while triggers apply the concurrent writes to it, and once all the shards are
+// copied the ghost tables replace the shards by a RENAME TABLE on each shard.
+//
+// The writes are applied by triggers rather than by reading the binlog, so the backends need no
+// binlog access but the writes are slower during the migration.
+package osc
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/atomic"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+	"github.com/cectc/dbpack/third_party/parser/format"
+	"github.com/cectc/dbpack/third_party/parser/model"
+)
+
+const (
+	StatePending     = "pending"
+	StateCopying     = "copying"
+	StateCopied      = "copied"
+	StateCuttingOver = "cutting_over"
+	StateDone        = "done"
+	StateFailed      = "failed"
+	StateCanceled    = "canceled"
+
+	defaultChunkSize = 1000
+	// maxIdentifierLength is the max length of the table and trigger names of mysql
+	maxIdentifierLength = 64
+)
+
+var (
+	migrationsLock sync.Mutex
+	migrations     = make(map[string]*Migration)
+	sequence       = atomic.NewInt64(0)
+)
+
+// Config is the config of the migrations.
+type Config struct {
+	// ChunkSize is the count of rows copied by a statement, 1000 by default
+	ChunkSize int
+	// ChunkInterval is the pause between the chunks, it throttles the copying
+	ChunkInterval time.Duration
+	// KeepOldTable keeps the original tables after the cut-over, renamed to _<table>_del
+	KeepOldTable bool
+}
+
+// Shard is a physical table of the logic table, on the master of its db group.
+type Shard struct {
+	Executor proto.DBGroupExecutor
+	Table    string
+}
+
+// Migration is an online ALTER TABLE of a logic table.
+type Migration struct {
+	id        string
+	table     string
+	stmt      *ast.AlterTableStmt
+	conf      Config
+	shards    []*shard
+	startedAt time.Time
+	cancel    context.CancelFunc
+	done      chan struct{}
+
+	lock       sync.Mutex
+	state      string
+	finishedAt time.Time
+	err        error
+}
+
+// Status is the progress of a migration.
+type Status struct {
+	ID         string     `json:"id"`
+	Table      string     `json:"table"`
+	State      string     `json:"state"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	// Progress is the percentage of the estimated rows of all the shards copied
+	Progress float64        `json:"progress"`
+	Shards   []*ShardStatus `json:"shards"`
+}
+
+// ShardStatus is the progress of a shard of a migration.
+type ShardStatus struct {
+	Group      string `json:"group"`
+	Table      string `json:"table"`
+	State      string `json:"state"`
+	RowsCopied int64  `json:"rows_copied"`
+	// RowsEstimated is the row count of the table estimated by information_schema
+	RowsEstimated int64 `json:"rows_estimated"`
+}
+
+// Start starts migrating the shards of table by stmt, at most a migration of a logic table runs
+// at a time.
+func Start(table string, stmt *ast.AlterTableStmt, shards []*Shard, conf *Config) (*Migration, error) {
+	if err := Validate(stmt); err != nil {
+		return nil, err
+	}
+	if len(shards) == 0 {
+		return nil, errors.Errorf("logic table %s has no shards", table)
+	}
+	m := &Migration{
+		table:     table,
+		stmt:      stmt,
+		startedAt: time.Now(),
+		done:      make(chan struct{}),
+		state:     StatePending,
+	}
+	if conf != nil {
+		m.conf = *conf
+	}
+	if m.conf.ChunkSize <= 0 {
+		m.conf.ChunkSize = defaultChunkSize
+	}
+	for _, s := range shards {
+		if len(s.Table)+len("__gho") > maxIdentifierLength {
+			return nil, errors.Errorf("table name %s is too long for the ghost table", s.Table)
+		}
+		m.shards = append(m.shards, newShard(s))
+	}
+
+	migrationsLock.Lock()
+	for _, other := range migrations {
+		if other.table == table && !other.finished() {
+			migrationsLock.Unlock()
+			return nil, errors.Errorf("migration %s of table %s is running", other.id, table)
+		}
+	}
+	m.id = fmt.Sprintf("%s-%d", table, sequence.Inc())
+	migrations[m.id] = m
+	migrationsLock.Unlock()
+
+	var ctx context.Context
+	ctx, m.cancel = context.WithCancel(context.Background())
+	go m.run(ctx)
+	return m, nil
+}
+
+// Validate reports whether stmt can be applied online, the rows are copied by the column names
+// and the primary key, so renaming the table or the columns and dropping the primary key are not.
+func Validate(stmt *ast.AlterTableStmt) error {
+	for _, spec := range stmt.Specs {
+		switch spec.Tp {
+		case ast.AlterTableRenameTable:
+			return errors.New("online ddl does not support renaming the table")
+		case ast.AlterTableRenameColumn:
+			return errors.New("online ddl does not support renaming columns")
+		case ast.AlterTableChangeColumn:
+			if len(spec.NewColumns) > 0 && !strings.EqualFold(spec.OldColumnName.Name.O, spec.NewColumns[0].Name.Name.O) {
+				return errors.New("online ddl does not support renaming columns")
+			}
+		case ast.AlterTableDropPrimaryKey:
+			return errors.New("online ddl does not support dropping the primary key")
+		}
+	}
+	return nil
+}
+
+// Get returns the migration of id.
+func Get(id string) (*Migration, bool) {
+	migrationsLock.Lock()
+	defer migrationsLock.Unlock()
+	m, ok := migrations[id]
+	return m, ok
+}
+
+// List returns the status of the migrations, ordered by the start time.
+func List() []*Status {
+	migrationsLock.Lock()
+	all := make([]*Migration, 0, len(migrations))
+	for _, m := range migrations {
+		all = append(all, m)
+	}
+	migrationsLock.Unlock()
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].startedAt.Before(all[j].startedAt)
+	})
+	result := make([]*Status, 0, len(all))
+	for _, m := range all {
+		result = append(result, m.Status())
+	}
+	return result
+}
+
+// ID returns the id of the migration.
+func (m *Migration) ID() string {
+	return m.id
+}
+
+// Wait waits for the migration to finish and returns the error of it, the migration goes on if
+// ctx is done first.
+func (m *Migration) Wait(ctx context.Context) error {
+	select {
+	case <-m.done:
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		return m.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Cancel stops the migration and removes the ghost tables and the triggers, it has no effect once
+// the cut-over started.
+func (m *Migration) Cancel() {
+	m.cancel()
+}
+
+// Status returns the progress of the migration.
+func (m *Migration) Status() *Status {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	status := &Status{ID: m.id, Table: m.table, State: m.state, StartedAt: m.startedAt}
+	if !m.finishedAt.IsZero() {
+		finishedAt := m.finishedAt
+		status.FinishedAt = &finishedAt
+	}
+	if m.err != nil {
+		status.Error = m.err.Error()
+	}
+	var copied, estimated int64
+	for _, s := range m.shards {
+		shardStatus := &ShardStatus{
+			Group:         s.Executor.GroupName(),
+			Table:         s.Table,
+			State:         s.state,
+			RowsCopied:    s.rowsCopied.Load(),
+			RowsEstimated: s.rowsEstimated.Load(),
+		}
+		copied += shardStatus.RowsCopied
+		estimated += shardStatus.RowsEstimated
+		status.Shards = append(status.Shards, shardStatus)
+	}
+	switch {
+	case m.state == StateDone:
+		status.Progress = 100
+	case estimated > 0:
+		status.Progress = float64(copied) * 100 / float64(estimated)
+		if status.Progress > 100 {
+			status.Progress = 100
+		}
+	}
+	return status
+}
+
+func (m *Migration) finished() bool {
+	select {
+	case <-m.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (m *Migration) setState(s *shard, state string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if s == nil {
+		m.state = state
+	} else {
+		s.state = state
+	}
+}
+
+func (m *Migration) run(ctx context.Context) {
+	defer close(m.done)
+	err := m.migrate(ctx)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.finishedAt = time.Now()
+	switch {
+	case err == nil:
+		m.state = StateDone
+		log.Infof("migration %s of table %s is done", m.id, m.table)
+	case ctx.Err() != nil:
+		m.state, m.err = StateCanceled, errors.Errorf("migration %s of table %s is canceled", m.id, m.table)
+		log.Warnf("migration %s of table %s is canceled", m.id, m.table)
+	default:
+		m.state, m.err = StateFailed, err
+		log.Errorf("migration %s of table %s failed, %v", m.id, m.table, err)
+	}
+}
+
+func (m *Migration) migrate(ctx context.Context) error {
+	m.setState(nil, StateCopying)
+	for _, s := range m.shards {
+		m.setState(s, StateCopying)
+		err := s.prepare(proto.WithMaster(ctx), m.stmt)
+		if err == nil {
+			err = s.copy(proto.WithMaster(ctx), &m.conf)
+		}
+		if err != nil {
+			m.setState(s, StateFailed)
+			m.cleanup()
+			return errors.Wrapf(err, "migrate table %s of %s failed", s.Table, s.Executor.GroupName())
+		}
+		m.setState(s, StateCopied)
+	}
+	if ctx.Err() != nil {
+		m.cleanup()
+		return ctx.Err()
+	}
+	// the cut-over is short and not canceled, once a shard is renamed all the shards should be
+	m.setState(nil, StateCuttingOver)
+	return m.cutOver(proto.WithMaster(context.Background()))
+}
+
+// cutOver renames the ghost tables to the shards, the shards renamed are renamed back if a
+// shard fails. Each shard is renamed atomically, but the shards are renamed one after another,
+// so the shards have different schemas for the moment of the cut-over.
+func (m *Migration) cutOver(ctx context.Context) error {
+	for i, s := range m.shards {
+		m.setState(s, StateCuttingOver)
+		if _, _, err := s.Executor.Query(ctx, fmt.Sprintf("RENAME TABLE %s TO %s, %s TO %s",
+			quote(s.Table), quote(s.old), quote(s.ghost), quote(s.Table))); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				renamed := m.shards[j]
+				if _, _, rollbackErr := renamed.Executor.Query(ctx, fmt.Sprintf("RENAME TABLE %s TO %s, %s TO %s",
+					quote(renamed.Table), quote(renamed.ghost), quote(renamed.old), quote(renamed.Table))); rollbackErr != nil {
+					log.Errorf("rename back table %s of %s failed, %v", renamed.Table, renamed.Executor.GroupName(), rollbackErr)
+				}
+			}
+			m.setState(s, StateFailed)
+			m.cleanup()
+			return errors.Wrapf(err, "cut over table %s of %s failed", s.Table, s.Executor.GroupName())
+		}
+	}
+	for _, s := range m.shards {
+		// the triggers are on the original table after the rename
+		s.dropTriggers(ctx)
+		if !m.conf.KeepOldTable {
+			if _, _, err := s.Executor.Query(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", quote(s.old))); err != nil {
+				log.Warnf("drop table %s of %s failed, %v", s.old, s.Executor.GroupName(), err)
+			}
+		}
+		m.setState(s, StateDone)
+	}
+	return nil
+}
+
+// cleanup removes the triggers and the ghost tables created by the migration.
+func (m *Migration) cleanup() {
+	ctx := proto.WithMaster(context.Background())
+	for _, s := range m.shards {
+		s.dropTriggers(ctx)
+		if s.ghostCreated {
+			if _, _, err := s.Executor.Query(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", quote(s.ghost))); err != nil {
+				log.Warnf("drop ghost table %s of %s failed, %v", s.ghost, s.Executor.GroupName(), err)
+			}
+			s.ghostCreated = false
+		}
+	}
+}
+
+type shard struct {
+	*Shard
+	ghost string
+	old   string
+
+	// columns are the columns of both the table and the ghost table, which are copied
+	columns    []string
+	primaryKey string
+
+	ghostCreated bool
+	triggers     []string
+
+	// state is guarded by the lock of the migration
+	state         string
+	rowsCopied    *atomic.Int64
+	rowsEstimated *atomic.Int64
+}
+
+func newShard(s *Shard) *shard {
+	return &shard{
+		Shard:         s,
+		ghost:         fmt.Sprintf("_%s_gho", s.Table),
+		old:           fmt.Sprintf("_%s_del", s.Table),
+		state:         StatePending,
+		rowsCopied:    atomic.NewInt64(0),
+		rowsEstimated: atomic.NewInt64(0),
+	}
+}
+
+// prepare creates the altered ghost table and the triggers applying the writes to it.
+func (s *shard) prepare(ctx context.Context, stmt *ast.AlterTableStmt) error {
+	columns, primaryKeys, err := s.columnsOf(ctx, s.Table)
+	if err != nil {
+		return err
+	}
+	if len(primaryKeys) != 1 {
+		return errors.Errorf("online ddl needs a single column primary key, table %s has %d", s.Table, len(primaryKeys))
+	}
+	s.primaryKey = primaryKeys[0]
+
+	if _, _, err = s.Executor.Query(ctx, fmt.Sprintf("CREATE TABLE %s LIKE %s", quote(s.ghost), quote(s.Table))); err != nil {
+		return err
+	}
+	s.ghostCreated = true
+	alter, err := alterGhost(stmt, s.ghost)
+	if err != nil {
+		return err
+	}
+	if _, _, err = s.Executor.Query(ctx, alter); err != nil {
+		return err
+	}
+
+	ghostColumns, _, err := s.columnsOf(ctx, s.ghost)
+	if err != nil {
+		return err
+	}
+	shared := make(map[string]bool, len(ghostColumns))
+	for _, column := range ghostColumns {
+		shared[strings.ToLower(column)] = true
+	}
+	for _, column := range columns {
+		if shared[strings.ToLower(column)] {
+			s.columns = append(s.columns, column)
+		}
+	}
+	if !shared[strings.ToLower(s.primaryKey)] {
+		return errors.Errorf("online ddl can not drop the primary key column %s", s.primaryKey)
+	}
+
+	for _, trigger := range triggerStatements(s.Table, s.ghost, s.primaryKey, s.columns) {
+		if _, _, err = s.Executor.Query(ctx, trigger.sql); err != nil {
+			return err
+		}
+		s.triggers = append(s.triggers, trigger.name)
+	}
+	return nil
+}
+
+func (s *shard) columnsOf(ctx context.Context, table string) (columns, primaryKeys []string, err error) {
+	result, _, err := s.Executor.PrepareQuery(ctx, "SELECT COLUMN_NAME, COLUMN_KEY FROM information_schema.COLUMNS "+
+		"WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION", table)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows, err := decodeRows(result)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, row := range rows {
+		column := toString(row[0])
+		columns = append(columns, column)
+		if toString(row[1]) == "PRI" {
+			primaryKeys = append(primaryKeys, column)
+		}
+	}
+	if len(columns) == 0 {
+		return nil, nil, errors.Errorf("table %s does not exist", table)
+	}
+	return columns, primaryKeys, nil
+}
+
+// copy copies the rows of the table to the ghost table in chunks of the primary key, up to the
+// max primary key at the start, the rows inserted after it are copied by the triggers.
+func (s *shard) copy(ctx context.Context, conf *Config) error {
+	if result, _, err := s.Executor.PrepareQuery(ctx, "SELECT TABLE_ROWS FROM information_schema.TABLES "+
+		"WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?", s.Table); err == nil {
+		if rows, err := decodeRows(result); err == nil && len(rows) > 0 {
+			if estimated, err := strconv.ParseInt(toString(rows[0][0]), 10, 64); err == nil {
+				s.rowsEstimated.Store(estimated)
+			}
+		}
+	}
+
+	pk, table, ghost := quote(s.primaryKey), quote(s.Table), quote(s.ghost)
+	result, _, err := s.Executor.Query(ctx, fmt.Sprintf("SELECT MIN(%s), MAX(%s) FROM %s", pk, pk, table))
+	if err != nil {
+		return err
+	}
+	rows, err := decodeRows(result)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 || rows[0][0] == nil {
+		return nil
+	}
+	lower, maxKey := rows[0][0], rows[0][1]
+
+	columns := make([]string, 0, len(s.columns))
+	for _, column := range s.columns {
+		columns = append(columns, quote(column))
+	}
+	columnList := strings.Join(columns, ", ")
+	for first := true; ; first = false {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		op := ">"
+		if first {
+			op = ">="
+		}
+		result, _, err = s.Executor.PrepareQuery(ctx, fmt.Sprintf("SELECT %s FROM %s WHERE %s %s ? AND %s <= ? "+
+			"ORDER BY %s LIMIT 1 OFFSET %d", pk, table, pk, op, pk, pk, conf.ChunkSize-1), lower, maxKey)
+		if err != nil {
+			return err
+		}
+		if rows, err = decodeRows(result); err != nil {
+			return err
+		}
+		upper, last := maxKey, len(rows) == 0
+		if !last {
+			upper = rows[0][0]
+		}
+		result, _, err = s.Executor.PrepareQuery(ctx, fmt.Sprintf("INSERT IGNORE INTO %s (%s) SELECT %s FROM %s "+
+			"WHERE %s %s ? AND %s <= ? LOCK IN SHARE MODE", ghost, columnList, columnList, table, pk, op, pk), lower, upper)
+		if err != nil {
+			return err
+		}
+		affected, _ := result.RowsAffected()
+		s.rowsCopied.Add(int64(affected))
+		if last {
+			return nil
+		}
+		lower = upper
+		if conf.ChunkInterval > 0 {
+			select {
+			case <-time.After(conf.ChunkInterval):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+func (s *shard) dropTriggers(ctx context.Context) {
+	for _, trigger := range s.triggers {
+		if _, _, err := s.Executor.Query(ctx, fmt.Sprintf("DROP TRIGGER IF EXISTS %s", quote(trigger))); err != nil {
+			log.Warnf("drop trigger %s of %s failed, %v", trigger, s.Executor.GroupName(), err)
+		}
+	}
+	s.triggers = nil
+}
+
+type trigger struct {
+	name string
+	sql  string
+}
+
+// triggerStatements creates the triggers applying the writes to table to the ghost table, the
+// updates delete the old row first as they may change the primary key.
+func triggerStatements(table, ghost, primaryKey string, columns []string) []*trigger {
+	quoted := make([]string, 0, len(columns))
+	values := make([]string, 0, len(columns))
+	for _, column := range columns {
+		quoted = append(quoted, quote(column))
+		values = append(values, "NEW."+quote(column))
+	}
+	replace := fmt.Sprintf("REPLACE INTO %s (%s) VALUES (%s)", quote(ghost),
+		strings.Join(quoted, ", "), strings.Join(values, ", "))
+	deleteOld := fmt.Sprintf("DELETE IGNORE FROM %s WHERE %s = OLD.%s", quote(ghost), quote(primaryKey), quote(primaryKey))
+	names := []string{fmt.Sprintf("_%s_ins", table), fmt.Sprintf("_%s_upd", table), fmt.Sprintf("_%s_del", table)}
+	return []*trigger{
+		{name: names[0], sql: fmt.Sprintf("CREATE TRIGGER %s AFTER INSERT ON %s FOR EACH ROW %s",
+			quote(names[0]), quote(table), replace)},
+		{name: names[1], sql: fmt.Sprintf("CREATE TRIGGER %s AFTER UPDATE ON %s FOR EACH ROW BEGIN %s; %s; END",
+			quote(names[1]), quote(table), deleteOld, replace)},
+		{name: names[2], sql: fmt.Sprintf("CREATE TRIGGER %s AFTER DELETE ON %s FOR EACH ROW %s",
+			quote(names[2]), quote(table), deleteOld)},
+	}
+}
+
+// alterGhost restores stmt altering the ghost table.
+func alterGhost(stmt *ast.AlterTableStmt, ghost string) (string, error) {
+	table := *stmt.Table
+	table.Schema = model.NewCIStr("")
+	table.Name = model.NewCIStr(ghost)
+	cp := *stmt
+	cp.Table = &table
+	var sb strings.Builder
+	if err := cp.Restore(format.NewRestoreCtx(constant.DBPackRestoreFormat, &sb)); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return sb.String(), nil
+}
+
+// decodeRows decodes the rows of result, the byte slices are copied as the result may be
+// released.
+func decodeRows(result proto.Result) ([][]interface{}, error) {
+	rlt, ok := result.(*mysql.Result)
+	if !ok {
+		return nil, errors.Errorf("unexpected result %T", result)
+	}
+	var rows [][]interface{}
+	for _, row := range rlt.Rows {
+		values, err := row.Decode()
+		if err != nil {
+			return nil, err
+		}
+		decoded := make([]interface{}, len(values))
+		for i, value := range values {
+			if value == nil {
+				continue
+			}
+			if b, ok := value.Val.([]byte); ok {
+				decoded[i] = append([]byte(nil), b...)
+			} else {
+				decoded[i] = value.Val
+			}
+		}
+		rows = append(rows, decoded)
+	}
+	return rows, nil
+}
+
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func quote(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
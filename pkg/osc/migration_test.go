@@ -0,0 +1,283 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package osc
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/third_party/parser"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+	_ "github.com/cectc/dbpack/third_party/types/parser_driver"
+)
+
+// fakeExecutor is a db group answering the statements by its handler.
+type fakeExecutor struct {
+	name    string
+	handler func(sql string, args []interface{}) (proto.Result, error)
+
+	lock       sync.Mutex
+	statements []string
+}
+
+func (e *fakeExecutor) GroupName() string {
+	return e.name
+}
+
+func (e *fakeExecutor) Begin(ctx context.Context) (proto.Tx, proto.Result, error) {
+	return nil, nil, errors.New("not supported")
+}
+
+func (e *fakeExecutor) Query(ctx context.Context, query string) (proto.Result, uint16, error) {
+	return e.execute(ctx, query, nil)
+}
+
+func (e *fakeExecutor) QueryAll(ctx context.Context, query string) (proto.Result, uint16, error) {
+	return e.execute(ctx, query, nil)
+}
+
+func (e *fakeExecutor) Execute(ctx context.Context, query string) (proto.Result, uint16, error) {
+	return e.execute(ctx, query, nil)
+}
+
+func (e *fakeExecutor) PrepareQuery(ctx context.Context, query string, args ...interface{}) (proto.Result, uint16, error) {
+	return e.execute(ctx, query, args)
+}
+
+func (e *fakeExecutor) PrepareExecute(ctx context.Context, query string, args ...interface{}) (proto.Result, uint16, error) {
+	return e.execute(ctx, query, args)
+}
+
+func (e *fakeExecutor) PrepareExecuteStmt(ctx context.Context, stmt *proto.Stmt) (proto.Result, uint16, error) {
+	return nil, 0, errors.New("not supported")
+}
+
+func (e *fakeExecutor) XAStart(ctx context.Context, sql string) (proto.Tx, proto.Result, error) {
+	return nil, nil, errors.New("not supported")
+}
+
+func (e *fakeExecutor) Pin(ctx context.Context) (proto.Tx, error) {
+	return nil, errors.New("not supported")
+}
+
+func (e *fakeExecutor) execute(ctx context.Context, sql string, args []interface{}) (proto.Result, uint16, error) {
+	if !proto.IsMaster(ctx) {
+		return nil, 0, errors.Errorf("%s is not sent to the master", sql)
+	}
+	e.lock.Lock()
+	e.statements = append(e.statements, sql)
+	e.lock.Unlock()
+	result, err := e.handler(sql, args)
+	if err != nil {
+		return nil, 0, err
+	}
+	if result == nil {
+		result = &mysql.Result{}
+	}
+	return result, 0, nil
+}
+
+func (e *fakeExecutor) Statements() []string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]string(nil), e.statements...)
+}
+
+func rows(columns []string, values ...[]string) *mysql.Result {
+	fields := make([]*mysql.Field, 0, len(columns))
+	for _, column := range columns {
+		fields = append(fields, &mysql.Field{Name: column, FieldType: constant.FieldTypeVarString})
+	}
+	result := &mysql.Result{Fields: fields}
+	for _, row := range values {
+		rowValues := make([]*proto.Value, 0, len(row))
+		for _, value := range row {
+			rowValues = append(rowValues, &proto.Value{Typ: constant.FieldTypeVarString, Val: []byte(value)})
+		}
+		result.Rows = append(result.Rows, mysql.NewTextRow(fields, rowValues))
+	}
+	return result
+}
+
+// shardHandler answers the statements of migrating a shard of 3 rows by chunks of 2 rows,
+// which drops the column age and adds the column email.
+func shardHandler(table string) func(sql string, args []interface{}) (proto.Result, error) {
+	ghost := "_" + table + "_gho"
+	return func(sql string, args []interface{}) (proto.Result, error) {
+		switch {
+		case strings.Contains(sql, "information_schema.COLUMNS") && args[0] == table:
+			return rows([]string{"COLUMN_NAME", "COLUMN_KEY"},
+				[]string{"id", "PRI"}, []string{"name", ""}, []string{"age", ""}), nil
+		case strings.Contains(sql, "information_schema.COLUMNS") && args[0] == ghost:
+			return rows([]string{"COLUMN_NAME", "COLUMN_KEY"},
+				[]string{"id", "PRI"}, []string{"name", ""}, []string{"email", ""}), nil
+		case strings.Contains(sql, "information_schema.TABLES"):
+			return rows([]string{"TABLE_ROWS"}, []string{"3"}), nil
+		case strings.HasPrefix(sql, "SELECT MIN("):
+			return rows([]string{"MIN", "MAX"}, []string{"1", "3"}), nil
+		case strings.Contains(sql, "LIMIT 1 OFFSET") && strings.Contains(sql, ">= ?"):
+			return rows([]string{"id"}, []string{"2"}), nil
+		case strings.Contains(sql, "LIMIT 1 OFFSET"):
+			return rows([]string{"id"}), nil
+		case strings.HasPrefix(sql, "INSERT IGNORE") && strings.Contains(sql, ">= ?"):
+			return &mysql.Result{AffectedRows: 2}, nil
+		case strings.HasPrefix(sql, "INSERT IGNORE"):
+			return &mysql.Result{AffectedRows: 1}, nil
+		}
+		return nil, nil
+	}
+}
+
+func parseAlter(t *testing.T, sql string) *ast.AlterTableStmt {
+	stmt, err := parser.New().ParseOneStmt(sql, "", "")
+	assert.NoError(t, err)
+	return stmt.(*ast.AlterTableStmt)
+}
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		sql string
+		err string
+	}{
+		{sql: "ALTER TABLE t ADD COLUMN email varchar(64), DROP COLUMN age"},
+		{sql: "ALTER TABLE t MODIFY COLUMN name varchar(128) NOT NULL"},
+		{sql: "ALTER TABLE t CHANGE COLUMN name name varchar(128)"},
+		{sql: "ALTER TABLE t CHANGE COLUMN name full_name varchar(128)", err: "renaming columns"},
+		{sql: "ALTER TABLE t RENAME COLUMN name TO full_name", err: "renaming columns"},
+		{sql: "ALTER TABLE t RENAME TO s", err: "renaming the table"},
+		{sql: "ALTER TABLE t DROP PRIMARY KEY", err: "dropping the primary key"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.sql, func(t *testing.T) {
+			err := Validate(parseAlter(t, tc.sql))
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestMigration(t *testing.T) {
+	world0 := &fakeExecutor{name: "world_0", handler: shardHandler("t_0")}
+	world1 := &fakeExecutor{name: "world_1", handler: shardHandler("t_1")}
+	stmt := parseAlter(t, "ALTER TABLE world.t ADD COLUMN email varchar(64), DROP COLUMN age")
+	m, err := Start("t", stmt, []*Shard{{Executor: world0, Table: "t_0"}, {Executor: world1, Table: "t_1"}},
+		&Config{ChunkSize: 2})
+	assert.NoError(t, err)
+	assert.NoError(t, m.Wait(context.Background()))
+
+	assert.Equal(t, []string{
+		"SELECT COLUMN_NAME, COLUMN_KEY FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION",
+		"CREATE TABLE `_t_0_gho` LIKE `t_0`",
+		"ALTER TABLE `_t_0_gho` ADD COLUMN `email` VARCHAR(64), DROP COLUMN `age`",
+		"SELECT COLUMN_NAME, COLUMN_KEY FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION",
+		"CREATE TRIGGER `_t_0_ins` AFTER INSERT ON `t_0` FOR EACH ROW REPLACE INTO `_t_0_gho` (`id`, `name`) VALUES (NEW.`id`, NEW.`name`)",
+		"CREATE TRIGGER `_t_0_upd` AFTER UPDATE ON `t_0` FOR EACH ROW BEGIN DELETE IGNORE FROM `_t_0_gho` WHERE `id` = OLD.`id`; " +
+			"REPLACE INTO `_t_0_gho` (`id`, `name`) VALUES (NEW.`id`, NEW.`name`); END",
+		"CREATE TRIGGER `_t_0_del` AFTER DELETE ON `t_0` FOR EACH ROW DELETE IGNORE FROM `_t_0_gho` WHERE `id` = OLD.`id`",
+		"SELECT TABLE_ROWS FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?",
+		"SELECT MIN(`id`), MAX(`id`) FROM `t_0`",
+		"SELECT `id` FROM `t_0` WHERE `id` >= ? AND `id` <= ? ORDER BY `id` LIMIT 1 OFFSET 1",
+		"INSERT IGNORE INTO `_t_0_gho` (`id`, `name`) SELECT `id`, `name` FROM `t_0` WHERE `id` >= ? AND `id` <= ? LOCK IN SHARE MODE",
+		"SELECT `id` FROM `t_0` WHERE `id` > ? AND `id` <= ? ORDER BY `id` LIMIT 1 OFFSET 1",
+		"INSERT IGNORE INTO `_t_0_gho` (`id`, `name`) SELECT `id`, `name` FROM `t_0` WHERE `id` > ? AND `id` <= ? LOCK IN SHARE MODE",
+		"RENAME TABLE `t_0` TO `_t_0_del`, `_t_0_gho` TO `t_0`",
+		"DROP TRIGGER IF EXISTS `_t_0_ins`",
+		"DROP TRIGGER IF EXISTS `_t_0_upd`",
+		"DROP TRIGGER IF EXISTS `_t_0_del`",
+		"DROP TABLE IF EXISTS `_t_0_del`",
+	}, world0.Statements())
+	assert.Len(t, world1.Statements(), len(world0.Statements()))
+
+	status := m.Status()
+	assert.Equal(t, StateDone, status.State)
+	assert.Equal(t, float64(100), status.Progress)
+	assert.Equal(t, &ShardStatus{Group: "world_1", Table: "t_1", State: StateDone, RowsCopied: 3, RowsEstimated: 3},
+		status.Shards[1])
+	migration, ok := Get(m.ID())
+	assert.True(t, ok)
+	assert.Equal(t, m, migration)
+}
+
+func TestMigrationCutOverFailed(t *testing.T) {
+	world0 := &fakeExecutor{name: "world_0", handler: shardHandler("r_0")}
+	handler := shardHandler("r_1")
+	world1 := &fakeExecutor{name: "world_1", handler: func(sql string, args []interface{}) (proto.Result, error) {
+		if strings.HasPrefix(sql, "RENAME TABLE") {
+			return nil, errors.New("lock wait timeout exceeded")
+		}
+		return handler(sql, args)
+	}}
+	stmt := parseAlter(t, "ALTER TABLE r ADD COLUMN email varchar(64), DROP COLUMN age")
+	m, err := Start("r", stmt, []*Shard{{Executor: world0, Table: "r_0"}, {Executor: world1, Table: "r_1"}}, nil)
+	assert.NoError(t, err)
+	assert.ErrorContains(t, m.Wait(context.Background()), "cut over table r_1 of world_1 failed")
+	assert.Equal(t, StateFailed, m.Status().State)
+
+	// the renamed shard is renamed back, and the ghost tables and triggers are removed
+	statements := world0.Statements()
+	assert.Equal(t, []string{
+		"RENAME TABLE `r_0` TO `_r_0_del`, `_r_0_gho` TO `r_0`",
+		"RENAME TABLE `r_0` TO `_r_0_gho`, `_r_0_del` TO `r_0`",
+		"DROP TRIGGER IF EXISTS `_r_0_ins`",
+		"DROP TRIGGER IF EXISTS `_r_0_upd`",
+		"DROP TRIGGER IF EXISTS `_r_0_del`",
+		"DROP TABLE IF EXISTS `_r_0_gho`",
+	}, statements[len(statements)-6:])
+	statements = world1.Statements()
+	assert.Equal(t, "DROP TABLE IF EXISTS `_r_1_gho`", statements[len(statements)-1])
+}
+
+func TestMigrationCanceled(t *testing.T) {
+	world0 := &fakeExecutor{name: "world_0", handler: shardHandler("c_0")}
+	stmt := parseAlter(t, "ALTER TABLE c ADD COLUMN email varchar(64), DROP COLUMN age")
+	shards := []*Shard{{Executor: world0, Table: "c_0"}}
+	m, err := Start("c", stmt, shards, &Config{ChunkSize: 2, ChunkInterval: time.Hour})
+	assert.NoError(t, err)
+
+	_, err = Start("c", stmt, shards, nil)
+	assert.ErrorContains(t, err, "is running")
+
+	assert.Eventually(t, func() bool {
+		return m.Status().Shards[0].RowsCopied == 2
+	}, time.Second, time.Millisecond)
+	waitCtx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, m.Wait(waitCtx), context.DeadlineExceeded)
+
+	m.Cancel()
+	assert.ErrorContains(t, m.Wait(context.Background()), "is canceled")
+	status := m.Status()
+	assert.Equal(t, StateCanceled, status.State)
+	assert.InDelta(t, 66.7, status.Progress, 0.1)
+	statements := world0.Statements()
+	assert.Equal(t, "DROP TABLE IF EXISTS `_c_0_gho`", statements[len(statements)-1])
+	for _, sql := range statements {
+		assert.False(t, strings.HasPrefix(sql, "RENAME"))
+	}
+}
@@ -17,6 +17,7 @@
 package packet
 
 import (
+	"encoding/json"
 	"fmt"
 	"math"
 	"strconv"
@@ -86,7 +87,11 @@ func ParseComStmtExecute(stmts *sync.Map, data []byte) (uint32, byte, error) {
 			}
 
 			// convert MySQL type to internal type.
-			valType, err := constant.MySQLToType(int64(mysqlType), int64(flags))
+			var fieldFlags int64
+			if flags&constant.ParamUnsignedFlag != 0 {
+				fieldFlags = int64(constant.UnsignedFlag)
+			}
+			valType, err := constant.MySQLToType(int64(mysqlType), fieldFlags)
 			if err != nil {
 				return stmtID, 0, err2.NewSQLError(constant.CRMalformedPacket, constant.SSUnknownSQLState, "MySQLToType(%v,%v) failed: %v", mysqlType, flags, err)
 			}
@@ -128,10 +133,10 @@ func ParseStmtArgs(data []byte, typ constant.FieldType, pos int) (interface{}, i
 		return int64(int8(val)), pos, ok
 	case constant.FieldTypeUint8:
 		val, pos, ok := misc.ReadByte(data, pos)
-		return int64(int8(val)), pos, ok
+		return int64(val), pos, ok
 	case constant.FieldTypeUint16:
 		val, pos, ok := misc.ReadUint16(data, pos)
-		return int64(int16(val)), pos, ok
+		return int64(val), pos, ok
 	case constant.FieldTypeShort, constant.FieldTypeYear:
 		val, pos, ok := misc.ReadUint16(data, pos)
 		return int64(int16(val)), pos, ok
@@ -160,7 +165,7 @@ func ParseStmtArgs(data []byte, typ constant.FieldType, pos int) (interface{}, i
 		}
 		switch size {
 		case 0x00:
-			return []byte{' '}, pos, ok
+			return []byte("0000-00-00 00:00:00"), pos, ok
 		case 0x0b:
 			year, pos, ok := misc.ReadUint16(data, pos)
 			if !ok {
@@ -190,13 +195,7 @@ func ParseStmtArgs(data []byte, typ constant.FieldType, pos int) (interface{}, i
 			if !ok {
 				return nil, 0, false
 			}
-			val := strconv.Itoa(int(year)) + "-" +
-				strconv.Itoa(int(month)) + "-" +
-				strconv.Itoa(int(day)) + " " +
-				strconv.Itoa(int(hour)) + ":" +
-				strconv.Itoa(int(minute)) + ":" +
-				strconv.Itoa(int(second)) + "." +
-				fmt.Sprintf("%06d", microSecond)
+			val := fmt.Sprintf("%04d-%02d-%02d %02d:%02d:%02d.%06d", year, month, day, hour, minute, second, microSecond)
 
 			return []byte(val), pos, ok
 		case 0x07:
@@ -224,12 +223,7 @@ func ParseStmtArgs(data []byte, typ constant.FieldType, pos int) (interface{}, i
 			if !ok {
 				return nil, 0, false
 			}
-			val := strconv.Itoa(int(year)) + "-" +
-				strconv.Itoa(int(month)) + "-" +
-				strconv.Itoa(int(day)) + " " +
-				strconv.Itoa(int(hour)) + ":" +
-				strconv.Itoa(int(minute)) + ":" +
-				strconv.Itoa(int(second))
+			val := fmt.Sprintf("%04d-%02d-%02d %02d:%02d:%02d", year, month, day, hour, minute, second)
 
 			return []byte(val), pos, ok
 		case 0x04:
@@ -245,9 +239,7 @@ func ParseStmtArgs(data []byte, typ constant.FieldType, pos int) (interface{}, i
 			if !ok {
 				return nil, 0, false
 			}
-			val := strconv.Itoa(int(year)) + "-" +
-				strconv.Itoa(int(month)) + "-" +
-				strconv.Itoa(int(day))
+			val := fmt.Sprintf("%04d-%02d-%02d", year, month, day)
 
 			return []byte(val), pos, ok
 		default:
@@ -294,10 +286,7 @@ func ParseStmtArgs(data []byte, typ constant.FieldType, pos int) (interface{}, i
 			if isNegative == 0x01 {
 				val += "-"
 			}
-			val += strconv.Itoa(int(hours)) + ":" +
-				strconv.Itoa(int(minute)) + ":" +
-				strconv.Itoa(int(second)) + "." +
-				fmt.Sprintf("%06d", microSecond)
+			val += fmt.Sprintf("%02d:%02d:%02d.%06d", hours, minute, second, microSecond)
 
 			return []byte(val), pos, ok
 		case 0x08:
@@ -329,9 +318,7 @@ func ParseStmtArgs(data []byte, typ constant.FieldType, pos int) (interface{}, i
 			if isNegative == 0x01 {
 				val += "-"
 			}
-			val += strconv.Itoa(int(hours)) + ":" +
-				strconv.Itoa(int(minute)) + ":" +
-				strconv.Itoa(int(second))
+			val += fmt.Sprintf("%02d:%02d:%02d", hours, minute, second)
 
 			return []byte(val), pos, ok
 		default:
@@ -651,7 +638,7 @@ func TextVal2MySQL(v *proto.Value) ([]byte, error) {
 		constant.FieldTypeMediumBLOB, constant.FieldTypeLongBLOB, constant.FieldTypeBLOB, constant.FieldTypeVarString,
 		constant.FieldTypeString, constant.FieldTypeGeometry, constant.FieldTypeJSON, constant.FieldTypeBit,
 		constant.FieldTypeEnum, constant.FieldTypeSet:
-		val := v.Val.([]byte)
+		val := lenEncStringBytes(v)
 		l := len(val)
 		length := misc.LenEncIntSize(uint64(l)) + l
 		out = make([]byte, length)
@@ -704,7 +691,10 @@ func BinaryVal2MySQL(v *proto.Value) ([]byte, error) {
 		out = make([]byte, 4)
 		misc.WriteUint32(out, pos, bits)
 	case constant.FieldTypeUint64:
-		if val, ok := v.Val.(int64); ok {
+		if val, ok := v.Val.(uint64); ok {
+			out = make([]byte, 8)
+			misc.WriteUint64(out, pos, val)
+		} else if val, ok := v.Val.(int64); ok {
 			out = make([]byte, 8)
 			misc.WriteUint64(out, pos, uint64(val))
 		} else {
@@ -736,14 +726,18 @@ func BinaryVal2MySQL(v *proto.Value) ([]byte, error) {
 
 			copy(out[pos:], v.Raw)
 		}
-		if val, ok := v.Val.(time.Time); ok {
+		if val, ok := v.Val.(time.Time); ok && val.IsZero() {
+			// the zero value is that of the zero date 0000-00-00, which has no fields
+			out = make([]byte, 1)
+			out[pos] = 0x00
+		} else if ok {
 			year := val.Year()
 			month := val.Month()
 			day := val.Day()
 			hour := val.Hour()
 			minute := val.Minute()
 			second := val.Second()
-			microSecond := val.Nanosecond() / int(time.Millisecond)
+			microSecond := val.Nanosecond() / int(time.Microsecond)
 
 			if hour == 0 && minute == 0 && second == 0 && microSecond == 0 {
 				out = make([]byte, 1+4)
@@ -794,7 +788,7 @@ func BinaryVal2MySQL(v *proto.Value) ([]byte, error) {
 		constant.FieldTypeMediumBLOB, constant.FieldTypeLongBLOB, constant.FieldTypeBLOB, constant.FieldTypeVarString,
 		constant.FieldTypeString, constant.FieldTypeGeometry, constant.FieldTypeJSON, constant.FieldTypeBit,
 		constant.FieldTypeEnum, constant.FieldTypeSet:
-		val := v.Val.([]byte)
+		val := lenEncStringBytes(v)
 		l := len(val)
 		length := misc.LenEncIntSize(uint64(l)) + l
 		out = make([]byte, length)
@@ -807,6 +801,21 @@ func BinaryVal2MySQL(v *proto.Value) ([]byte, error) {
 	return out, nil
 }
 
+// lenEncStringBytes returns the content of length coded string types, such as
+// VARCHAR, BLOB, JSON and GEOMETRY, filters may replace the []byte value with a string.
+func lenEncStringBytes(v *proto.Value) []byte {
+	switch val := v.Val.(type) {
+	case []byte:
+		return val
+	case string:
+		return []byte(val)
+	case json.RawMessage:
+		return val
+	default:
+		return []byte(fmt.Sprintf("%v", val))
+	}
+}
+
 func TextVal2MySQLLen(v *proto.Value) (int, error) {
 	var length int
 	var err error
@@ -849,7 +858,7 @@ func TextVal2MySQLLen(v *proto.Value) (int, error) {
 		constant.FieldTypeMediumBLOB, constant.FieldTypeLongBLOB, constant.FieldTypeBLOB, constant.FieldTypeVarString,
 		constant.FieldTypeString, constant.FieldTypeGeometry, constant.FieldTypeJSON, constant.FieldTypeBit,
 		constant.FieldTypeEnum, constant.FieldTypeSet:
-		val := v.Val.([]byte)
+		val := lenEncStringBytes(v)
 		l := len(val)
 		length = misc.LenEncIntSize(uint64(l)) + l
 	default:
@@ -887,11 +896,14 @@ func BinaryVal2MySQLLen(v *proto.Value) (int, error) {
 			length = v.Len + 1
 		}
 		if val, ok := v.Val.(time.Time); ok {
-			if val.Hour() == 0 && val.Minute() == 0 &&
-				val.Second() == 0 && val.Nanosecond() == 0 {
-				length = 5
+			// the length is that BinaryVal2MySQL encodes the value with, the fraction is of microseconds
+			microSecond := val.Nanosecond() / int(time.Microsecond)
+			if val.IsZero() {
+				length = 1
 			} else if val.Hour() == 0 && val.Minute() == 0 &&
-				val.Second() == 0 {
+				val.Second() == 0 && microSecond == 0 {
+				length = 5
+			} else if microSecond == 0 {
 				length = 8
 			} else {
 				length = 12
@@ -908,7 +920,7 @@ func BinaryVal2MySQLLen(v *proto.Value) (int, error) {
 		constant.FieldTypeMediumBLOB, constant.FieldTypeLongBLOB, constant.FieldTypeBLOB, constant.FieldTypeVarString,
 		constant.FieldTypeString, constant.FieldTypeGeometry, constant.FieldTypeJSON, constant.FieldTypeBit,
 		constant.FieldTypeEnum, constant.FieldTypeSet:
-		val := v.Val.([]byte)
+		val := lenEncStringBytes(v)
 		l := len(val)
 		length = misc.LenEncIntSize(uint64(l)) + l
 	default:
@@ -948,46 +960,118 @@ func IsEOFPacket(data []byte) bool {
 // Note: This is only valid on actual EOF packets and not on OK packets with the EOF
 // type code set, i.e. should not be used if ClientDeprecateEOF is set.
 func ParseEOFPacket(data []byte) (warnings uint16, more bool, err error) {
+	warnings, statusFlags, err := ParseEOFPacketStatus(data)
+	return warnings, (statusFlags & mysql.ServerMoreResultsExists) != 0, err
+}
+
+// ParseEOFPacketStatus returns the warning count and the status flags of an EOF packet.
+func ParseEOFPacketStatus(data []byte) (warnings uint16, statusFlags uint16, err error) {
 	// The warning count is in position 2 & 3
 	warnings, _, _ = misc.ReadUint16(data, 1)
 
 	// The status flag is in position 4 & 5
 	statusFlags, _, ok := misc.ReadUint16(data, 3)
 	if !ok {
-		return 0, false, errors.Errorf("invalid EOF packet statusFlags: %v", data)
+		return 0, 0, errors.Errorf("invalid EOF packet statusFlags: %v", data)
 	}
-	return warnings, (statusFlags & mysql.ServerMoreResultsExists) != 0, nil
+	return warnings, statusFlags, nil
 }
 
 func ParseOKPacket(data []byte) (uint64, uint64, uint16, uint16, error) {
+	affectedRows, lastInsertID, statusFlags, warnings, _, err := parseOKPacket(data)
+	return affectedRows, lastInsertID, statusFlags, warnings, err
+}
+
+// ParseOKPacketWithSessionState parses an OK packet sent with CapabilityClientSessionTrack.
+// It also returns a copy of the session state changes, nil if the session state is not changed.
+func ParseOKPacketWithSessionState(data []byte) (uint64, uint64, uint16, uint16, []byte, error) {
+	affectedRows, lastInsertID, statusFlags, warnings, pos, err := parseOKPacket(data)
+	if err != nil || statusFlags&constant.ServerSessionStateChanged == 0 {
+		return affectedRows, lastInsertID, statusFlags, warnings, nil, err
+	}
+
+	// Human readable status information, ignored.
+	pos, ok := misc.SkipLenEncString(data, pos)
+	if !ok {
+		return 0, 0, 0, 0, nil, errors.Errorf("invalid OK packet info: %v", data)
+	}
+
+	sessionState, _, ok := misc.ReadLenEncStringAsBytesCopy(data, pos)
+	if !ok {
+		return 0, 0, 0, 0, nil, errors.Errorf("invalid OK packet session state changes: %v", data)
+	}
+	if _, err := ParseSessionStateChanges(sessionState); err != nil {
+		return 0, 0, 0, 0, nil, err
+	}
+	return affectedRows, lastInsertID, statusFlags, warnings, sessionState, nil
+}
+
+func parseOKPacket(data []byte) (uint64, uint64, uint16, uint16, int, error) {
 	// We already read the type.
 	pos := 1
 
 	// Affected rows.
 	affectedRows, pos, ok := misc.ReadLenEncInt(data, pos)
 	if !ok {
-		return 0, 0, 0, 0, errors.Errorf("invalid OK packet affectedRows: %v", data)
+		return 0, 0, 0, 0, 0, errors.Errorf("invalid OK packet affectedRows: %v", data)
 	}
 
 	// Last Insert ID.
 	lastInsertID, pos, ok := misc.ReadLenEncInt(data, pos)
 	if !ok {
-		return 0, 0, 0, 0, errors.Errorf("invalid OK packet lastInsertID: %v", data)
+		return 0, 0, 0, 0, 0, errors.Errorf("invalid OK packet lastInsertID: %v", data)
 	}
 
 	// Status flags.
 	statusFlags, pos, ok := misc.ReadUint16(data, pos)
 	if !ok {
-		return 0, 0, 0, 0, errors.Errorf("invalid OK packet statusFlags: %v", data)
+		return 0, 0, 0, 0, 0, errors.Errorf("invalid OK packet statusFlags: %v", data)
 	}
 
 	// Warnings.
-	warnings, _, ok := misc.ReadUint16(data, pos)
+	warnings, pos, ok := misc.ReadUint16(data, pos)
 	if !ok {
-		return 0, 0, 0, 0, errors.Errorf("invalid OK packet warnings: %v", data)
+		return 0, 0, 0, 0, 0, errors.Errorf("invalid OK packet warnings: %v", data)
+	}
+
+	return affectedRows, lastInsertID, statusFlags, warnings, pos, nil
+}
+
+// SessionStateChange is an entry of the session state changes in an OK packet,
+// Data is the type specific data, e.g. the length encoded schema name of SessionTrackSchema.
+type SessionStateChange struct {
+	Type byte
+	Data []byte
+}
+
+// ParseSessionStateChanges parses the session state changes of an OK packet.
+func ParseSessionStateChanges(data []byte) ([]*SessionStateChange, error) {
+	var changes []*SessionStateChange
+	pos := 0
+	for pos < len(data) {
+		typ, next, ok := misc.ReadByte(data, pos)
+		if !ok {
+			return nil, errors.Errorf("invalid session state change type: %v", data)
+		}
+		changeData, next, ok := misc.ReadLenEncStringAsBytes(data, next)
+		if !ok {
+			return nil, errors.Errorf("invalid session state change of type %d: %v", typ, data)
+		}
+		changes = append(changes, &SessionStateChange{Type: typ, Data: changeData})
+		pos = next
 	}
+	return changes, nil
+}
 
-	return affectedRows, lastInsertID, statusFlags, warnings, nil
+// BuildSessionStateChanges encodes session state changes to be sent in an OK packet.
+func BuildSessionStateChanges(changes ...*SessionStateChange) []byte {
+	var data []byte
+	for _, change := range changes {
+		data = append(data, change.Type)
+		data = misc.AppendLengthEncodedInteger(data, uint64(len(change.Data)))
+		data = append(data, change.Data...)
+	}
+	return data
 }
 
 // IsErrorPacket determines whether or not the packet is an error packet. Mostly here for
@@ -0,0 +1,265 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package packet
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/misc"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+func TestLengthEncodedTypes(t *testing.T) {
+	// POINT(1 1) in mysql internal geometry format: srid + wkb
+	geometry := []byte{0x00, 0x00, 0x00, 0x00, 0x01, 0x01, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf0, 0x3f, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf0, 0x3f}
+	testCases := []struct {
+		name   string
+		value  *proto.Value
+		expect []byte
+	}{
+		{
+			name:   "json bytes",
+			value:  &proto.Value{Typ: constant.FieldTypeJSON, Val: []byte(`{"a": 1}`)},
+			expect: []byte(`{"a": 1}`),
+		},
+		{
+			name:   "json string",
+			value:  &proto.Value{Typ: constant.FieldTypeJSON, Val: `{"a": 1}`},
+			expect: []byte(`{"a": 1}`),
+		},
+		{
+			name:   "geometry",
+			value:  &proto.Value{Typ: constant.FieldTypeGeometry, Val: geometry},
+			expect: geometry,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			length, err := BinaryVal2MySQLLen(c.value)
+			assert.Nil(t, err)
+			out, err := BinaryVal2MySQL(c.value)
+			assert.Nil(t, err)
+			assert.Equal(t, length, len(out))
+
+			val, pos, ok := ParseStmtArgs(out, c.value.Typ, 0)
+			assert.True(t, ok)
+			assert.Equal(t, len(out), pos)
+			assert.Equal(t, c.expect, val)
+
+			textLength, err := TextVal2MySQLLen(c.value)
+			assert.Nil(t, err)
+			text, err := TextVal2MySQL(c.value)
+			assert.Nil(t, err)
+			assert.Equal(t, textLength, len(text))
+			content, _, _, err := misc.ReadLengthEncodedString(text)
+			assert.Nil(t, err)
+			assert.Equal(t, c.expect, content)
+		})
+	}
+}
+
+func TestTemporalFractionalSeconds(t *testing.T) {
+	testCases := []struct {
+		name   string
+		value  time.Time
+		length int
+		expect string
+	}{
+		{
+			name:   "microseconds",
+			value:  time.Date(2022, 1, 5, 3, 4, 5, 123456000, time.Local),
+			length: 12,
+			expect: "2022-01-05 03:04:05.123456",
+		},
+		{
+			name:   "max microseconds",
+			value:  time.Date(9999, 12, 31, 23, 59, 59, 999999000, time.Local),
+			length: 12,
+			expect: "9999-12-31 23:59:59.999999",
+		},
+		{
+			name:   "min microseconds",
+			value:  time.Date(2022, 1, 5, 0, 0, 0, 1000, time.Local),
+			length: 12,
+			expect: "2022-01-05 00:00:00.000001",
+		},
+		{
+			name:   "seconds",
+			value:  time.Date(2022, 1, 5, 10, 0, 0, 0, time.Local),
+			length: 8,
+			expect: "2022-01-05 10:00:00",
+		},
+		{
+			name:   "below microseconds",
+			value:  time.Date(2022, 1, 5, 10, 0, 0, 999, time.Local),
+			length: 8,
+			expect: "2022-01-05 10:00:00",
+		},
+		{
+			name:   "date",
+			value:  time.Date(2022, 1, 5, 0, 0, 0, 0, time.Local),
+			length: 5,
+			expect: "2022-01-05",
+		},
+		{
+			name:   "zero date",
+			length: 1,
+			expect: "0000-00-00 00:00:00",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			value := &proto.Value{Typ: constant.FieldTypeDateTime, Val: c.value}
+			length, err := BinaryVal2MySQLLen(value)
+			assert.Nil(t, err)
+			assert.Equal(t, c.length, length)
+			out, err := BinaryVal2MySQL(value)
+			assert.Nil(t, err)
+			assert.Equal(t, length, len(out))
+
+			val, pos, ok := ParseStmtArgs(out, constant.FieldTypeDateTime, 0)
+			assert.True(t, ok)
+			assert.Equal(t, len(out), pos)
+			assert.Equal(t, []byte(c.expect), val)
+
+			decoded, err := misc.ParseBinaryDateTime(uint64(out[0]), out[1:], time.Local)
+			assert.Nil(t, err)
+			assert.True(t, c.value.Truncate(time.Microsecond).Equal(decoded.(time.Time)))
+		})
+	}
+
+	times := []struct {
+		data   []byte
+		expect string
+	}{
+		{data: []byte{0x00}, expect: "00:00:00"},
+		{data: []byte{0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x02, 0x03}, expect: "01:02:03"},
+		{data: []byte{0x0c, 0x01, 0x01, 0x00, 0x00, 0x00, 0x02, 0x03, 0x04, 0x3f, 0x42, 0x0f, 0x00}, expect: "-26:03:04.999999"},
+		{data: []byte{0x0c, 0x00, 0x22, 0x00, 0x00, 0x00, 0x16, 0x3b, 0x3b, 0x01, 0x00, 0x00, 0x00}, expect: "838:59:59.000001"},
+	}
+	for _, c := range times {
+		val, pos, ok := ParseStmtArgs(c.data, constant.FieldTypeTime, 0)
+		assert.True(t, ok)
+		assert.Equal(t, len(c.data), pos)
+		assert.Equal(t, []byte(c.expect), val)
+
+		// a TIME value is encoded with its raw bytes
+		value := &proto.Value{Typ: constant.FieldTypeTime, Val: val, Raw: c.data[1:], Len: len(c.data) - 1}
+		out, err := BinaryVal2MySQL(value)
+		assert.Nil(t, err)
+		assert.Equal(t, c.data, out)
+	}
+}
+
+func TestUnsignedBigint(t *testing.T) {
+	stmts := &sync.Map{}
+	stmts.Store(uint32(1), &proto.Stmt{
+		StatementID: 1,
+		ParamsCount: 2,
+		ParamsType:  make([]int32, 2),
+		BindVars:    make(map[string]interface{}),
+	})
+	data := []byte{constant.ComStmtExecute, 0x01, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00,
+		// NULL-bitmap and new params bound flag
+		0x00, 0x01,
+		// BIGINT UNSIGNED and BIGINT parameters
+		0x08, constant.ParamUnsignedFlag, 0x08, 0x00,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+	}
+	_, _, err := ParseComStmtExecute(stmts, data)
+	assert.Nil(t, err)
+	stmt, _ := stmts.Load(uint32(1))
+	assert.Equal(t, uint64(math.MaxUint64), stmt.(*proto.Stmt).BindVars["v1"])
+	assert.Equal(t, int64(-1), stmt.(*proto.Stmt).BindVars["v2"])
+
+	for _, value := range []*proto.Value{
+		{Typ: constant.FieldTypeUint64, Val: uint64(math.MaxUint64)},
+		{Typ: constant.FieldTypeUint64, Val: []byte("18446744073709551615")},
+	} {
+		length, err := BinaryVal2MySQLLen(value)
+		assert.Nil(t, err)
+		out, err := BinaryVal2MySQL(value)
+		assert.Nil(t, err)
+		assert.Equal(t, length, len(out))
+		assert.Equal(t, uint64(math.MaxUint64), binary.LittleEndian.Uint64(out))
+	}
+}
+
+func TestTypeToMySQL(t *testing.T) {
+	typ, _ := constant.TypeToMySQL(constant.FieldTypeJSON)
+	assert.Equal(t, int64(245), typ)
+	typ, _ = constant.TypeToMySQL(constant.FieldTypeGeometry)
+	assert.Equal(t, int64(255), typ)
+	typ, _ = constant.TypeToMySQL(constant.FieldTypeNewDecimal)
+	assert.Equal(t, int64(246), typ)
+}
+
+func TestParseOKPacketWithSessionState(t *testing.T) {
+	testCases := []struct {
+		name         string
+		data         []byte
+		statusFlags  uint16
+		sessionState []byte
+		changes      []*SessionStateChange
+	}{
+		{
+			name:        "session state not changed",
+			data:        []byte{0x00, 0x01, 0x00, 0x02, 0x00, 0x00, 0x00},
+			statusFlags: constant.ServerStatusAutocommit,
+		},
+		{
+			name: "schema and system variable changed",
+			data: []byte{0x00, 0x00, 0x00, 0x02, 0x40, 0x00, 0x00, 0x00, 0x18,
+				0x01, 0x05, 0x04, 't', 'e', 's', 't',
+				0x00, 0x0f, 0x0a, 'a', 'u', 't', 'o', 'c', 'o', 'm', 'm', 'i', 't', 0x03, 'O', 'F', 'F'},
+			statusFlags: constant.ServerStatusAutocommit | constant.ServerSessionStateChanged,
+			sessionState: []byte{0x01, 0x05, 0x04, 't', 'e', 's', 't',
+				0x00, 0x0f, 0x0a, 'a', 'u', 't', 'o', 'c', 'o', 'm', 'm', 'i', 't', 0x03, 'O', 'F', 'F'},
+			changes: []*SessionStateChange{
+				{Type: constant.SessionTrackSchema, Data: []byte{0x04, 't', 'e', 's', 't'}},
+				{Type: constant.SessionTrackSystemVariables, Data: append([]byte{0x0a}, []byte("autocommit\x03OFF")...)},
+			},
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			_, _, statusFlags, _, sessionState, err := ParseOKPacketWithSessionState(c.data)
+			assert.NoError(t, err)
+			assert.Equal(t, c.statusFlags, statusFlags)
+			assert.Equal(t, c.sessionState, sessionState)
+
+			changes, err := ParseSessionStateChanges(sessionState)
+			assert.NoError(t, err)
+			assert.Equal(t, c.changes, changes)
+			if len(changes) > 0 {
+				assert.Equal(t, sessionState, BuildSessionStateChanges(changes...))
+			}
+		})
+	}
+
+	_, _, _, _, _, err := ParseOKPacketWithSessionState([]byte{0x00, 0x00, 0x00, 0x02, 0x40, 0x00, 0x00, 0x00, 0x05, 0x01, 0x07})
+	assert.Error(t, err)
+}
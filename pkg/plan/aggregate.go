@@ -0,0 +1,358 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/merger"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/visitor"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+	parsermysql "github.com/cectc/dbpack/third_party/parser/mysql"
+	"github.com/cectc/dbpack/third_party/types"
+)
+
+// aggregateResult merges the aggregated columns of every shard into the first row.
+// DECIMAL values are accumulated with types.MyDecimal, so there is no float64 round trip.
+// The rows are left as they are if a column can not be merged.
+func aggregateResult(ctx context.Context, result *mysql.Result) error {
+	funcColumns := proto.Variable(ctx, FuncColumns)
+	if funcColumns == nil {
+		return nil
+	}
+	funcColumnList := funcColumns.([]*visitor.FuncColumn)
+	if len(funcColumnList) == 0 || len(result.Rows) == 0 {
+		return nil
+	}
+	for _, funcColumn := range funcColumnList {
+		var err error
+		switch funcColumn.FuncName {
+		case ast.AggFuncCount:
+			err = aggregateCount(result, funcColumn.ColumnIndex)
+		case ast.AggFuncSum:
+			err = aggregateSum(result, funcColumn.ColumnIndex)
+		case ast.AggFuncAvg:
+			err = aggregateAvg(result, funcColumn.ColumnIndex, funcColumn.CountColumnIndex)
+		case ast.AggFuncMin:
+			err = aggregateExtreme(result, funcColumn.ColumnIndex, false)
+		case ast.AggFuncMax:
+			err = aggregateExtreme(result, funcColumn.ColumnIndex, true)
+		default:
+			m := merger.GetMerger(funcColumn.FuncName)
+			if m == nil {
+				return errors.Errorf("aggregate function %s is not supported across shards", funcColumn.FuncName)
+			}
+			err = aggregateByMerger(ctx, result, funcColumn.ColumnIndex, m)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	result.Rows = []proto.Row{
+		result.Rows[0],
+	}
+	return nil
+}
+
+func aggregateCount(result *mysql.Result, index int) error {
+	var count int64 = 0
+	for _, row := range result.Rows {
+		val, err := castCellToInt64(row, index)
+		if err != nil {
+			return err
+		}
+		count += val
+	}
+	return writeValueToRow(result.Rows[0], result.Fields[index], index, count)
+}
+
+func aggregateSum(result *mysql.Result, index int) error {
+	field := result.Fields[index]
+	switch field.FieldType {
+	case constant.FieldTypeFloat, constant.FieldTypeDouble:
+		sum, isNull, err := sumFloatColumn(result.Rows, index)
+		if err != nil || isNull {
+			return err
+		}
+		return writeValueToRow(result.Rows[0], result.Fields[index], index, sum)
+	default:
+		sum, isNull, err := sumDecimalColumn(result.Rows, index)
+		if err != nil || isNull {
+			return err
+		}
+		return writeValueToRow(result.Rows[0], result.Fields[index], index, sum)
+	}
+}
+
+// aggregateAvg calculates avg by the sum column and the hidden count column, see rewriteAvgFields
+func aggregateAvg(result *mysql.Result, index, countIndex int) error {
+	var count int64 = 0
+	for _, row := range result.Rows {
+		val, err := castCellToInt64(row, countIndex)
+		if err != nil {
+			return err
+		}
+		count += val
+	}
+	field := result.Fields[index]
+	switch field.FieldType {
+	case constant.FieldTypeFloat, constant.FieldTypeDouble:
+		sum, isNull, err := sumFloatColumn(result.Rows, index)
+		if err != nil || isNull || count == 0 {
+			return err
+		}
+		return writeValueToRow(result.Rows[0], result.Fields[index], index, sum/float64(count))
+	default:
+		sum, isNull, err := sumDecimalColumn(result.Rows, index)
+		if err != nil || isNull || count == 0 {
+			return err
+		}
+		// sum column has the scale of the avg argument, avg increases the scale by div_precision_increment
+		frac := int(field.Decimals) + types.DivFracIncr
+		if frac > parsermysql.MaxDecimalScale {
+			frac = parsermysql.MaxDecimalScale
+		}
+		avg := new(types.MyDecimal)
+		if err = types.DecimalDiv(sum, types.NewDecFromInt(count), avg, types.DivFracIncr); err != nil {
+			return errors.Wrap(err, "calculate avg failed")
+		}
+		if err = avg.Round(avg, frac, types.ModeHalfEven); err != nil {
+			return errors.Wrap(err, "round avg failed")
+		}
+		field.Decimals = byte(frac)
+		field.ColumnLength += types.DivFracIncr
+		return writeValueToRow(result.Rows[0], result.Fields[index], index, avg)
+	}
+}
+
+// aggregateExtreme merges the MIN, or the MAX if max is true, of the shards, the values are
+// compared as the rows are ordered by them.
+func aggregateExtreme(result *mysql.Result, index int, max bool) error {
+	var extreme *proto.Value
+	for _, row := range result.Rows {
+		values, err := row.Decode()
+		if err != nil {
+			return err
+		}
+		value := values[index]
+		if value == nil || value.Val == nil {
+			continue
+		}
+		if extreme == nil {
+			extreme = value
+			continue
+		}
+		c := compare(orderValue(value), orderValue(extreme))
+		if max && c > 0 || !max && c < 0 {
+			extreme = value
+		}
+	}
+	if extreme == nil {
+		// every shard returns NULL
+		return nil
+	}
+	return writeValueToRow(result.Rows[0], result.Fields[index], index, extreme.Val)
+}
+
+// aggregateByMerger merges the column of an aggregate function dbpack does not merge itself by
+// the merger registered for the function.
+func aggregateByMerger(ctx context.Context, result *mysql.Result, index int, m proto.ResultMerger) error {
+	values := make([]*proto.Value, 0, len(result.Rows))
+	for _, row := range result.Rows {
+		decoded, err := row.Decode()
+		if err != nil {
+			return err
+		}
+		if decoded[index] == nil || decoded[index].Val == nil {
+			values = append(values, nil)
+			continue
+		}
+		values = append(values, decoded[index])
+	}
+	merged, err := m.Merge(ctx, values)
+	if err != nil {
+		return errors.Wrapf(err, "merge %s failed", result.Fields[index].Name)
+	}
+	return writeValueToRow(result.Rows[0], result.Fields[index], index, merged)
+}
+
+func sumDecimalColumn(rows []proto.Row, index int) (*types.MyDecimal, bool, error) {
+	var (
+		sum    = new(types.MyDecimal)
+		isNull = true
+	)
+	for _, row := range rows {
+		values, err := row.Decode()
+		if err != nil {
+			return nil, false, err
+		}
+		if values[index] == nil || values[index].Val == nil {
+			continue
+		}
+		dec, err := CastValueToDecimal(values[index])
+		if err != nil {
+			return nil, false, err
+		}
+		if err = types.DecimalAdd(sum, dec, sum); err != nil {
+			return nil, false, errors.Wrap(err, "calculate sum failed")
+		}
+		isNull = false
+	}
+	return sum, isNull, nil
+}
+
+func sumFloatColumn(rows []proto.Row, index int) (float64, bool, error) {
+	var (
+		sum    float64
+		isNull = true
+	)
+	for _, row := range rows {
+		values, err := row.Decode()
+		if err != nil {
+			return 0, false, err
+		}
+		if values[index] == nil || values[index].Val == nil {
+			continue
+		}
+		switch v := values[index].Val.(type) {
+		case float64:
+			sum += v
+		case float32:
+			sum += float64(v)
+		default:
+			val, err := strconv.ParseFloat(fmt.Sprintf("%s", v), 64)
+			if err != nil {
+				return 0, false, err
+			}
+			sum += val
+		}
+		isNull = false
+	}
+	return sum, isNull, nil
+}
+
+// CastValueToDecimal converts value to types.MyDecimal without precision loss,
+// text protocol and binary protocol DECIMAL values are both length coded strings.
+func CastValueToDecimal(value *proto.Value) (*types.MyDecimal, error) {
+	dec := new(types.MyDecimal)
+	switch v := value.Val.(type) {
+	case *types.MyDecimal:
+		return v, nil
+	case []byte:
+		if err := dec.FromString(v); err != nil {
+			return nil, errors.Wrapf(err, "cast %s to decimal failed", v)
+		}
+	case string:
+		if err := dec.FromString([]byte(v)); err != nil {
+			return nil, errors.Wrapf(err, "cast %s to decimal failed", v)
+		}
+	case int64:
+		dec.FromInt(v)
+	case uint64:
+		dec.FromUint(v)
+	case float32:
+		if err := dec.FromFloat64(float64(v)); err != nil {
+			return nil, err
+		}
+	case float64:
+		if err := dec.FromFloat64(v); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.Errorf("unsupported decimal value type %T", value.Val)
+	}
+	return dec, nil
+}
+
+func castCellToInt64(row proto.Row, index int) (int64, error) {
+	values, err := row.Decode()
+	if err != nil {
+		return 0, err
+	}
+	if values[index] == nil || values[index].Val == nil {
+		return 0, nil
+	}
+	switch v := values[index].Val.(type) {
+	case int64:
+		return v, nil
+	case uint64:
+		if v > math.MaxInt64 {
+			return 0, errors.Errorf("count column value %d overflows int64", v)
+		}
+		return int64(v), nil
+	case []byte:
+		return strconv.ParseInt(string(v), 10, 64)
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	default:
+		return 0, errors.Errorf("count column value must be of type int64, got %T", v)
+	}
+}
+
+// writeValueToRow writes the aggregated value, text row values are always length coded strings,
+// binary row values keep the go type of the column type.
+func writeValueToRow(row proto.Row, field *mysql.Field, index int, value interface{}) error {
+	values, err := row.Decode()
+	if err != nil {
+		return err
+	}
+	if values[index] == nil {
+		values[index] = &proto.Value{
+			Typ:   field.FieldType,
+			Flags: field.Flags,
+		}
+	}
+	switch r := row.(type) {
+	case *mysql.TextRow:
+		switch v := value.(type) {
+		case nil:
+			r.Values[index].Val = nil
+		case int64:
+			r.Values[index].Val = strconv.AppendInt(nil, v, 10)
+		case uint64:
+			r.Values[index].Val = strconv.AppendUint(nil, v, 10)
+		case float64:
+			r.Values[index].Val = strconv.AppendFloat(nil, v, 'g', -1, 64)
+		case *types.MyDecimal:
+			r.Values[index].Val = v.ToString()
+		case string:
+			r.Values[index].Val = []byte(v)
+		case []byte:
+			r.Values[index].Val = v
+		default:
+			return errors.Errorf("unsupported aggregate value type %T", value)
+		}
+	case *mysql.BinaryRow:
+		switch v := value.(type) {
+		case *types.MyDecimal:
+			r.Values[index].Val = v.ToString()
+		default:
+			r.Values[index].Val = v
+		}
+	default:
+		return errors.New("unsupported row type")
+	}
+	return nil
+}
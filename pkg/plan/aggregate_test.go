@@ -0,0 +1,189 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/merger"
+	"github.com/cectc/dbpack/pkg/misc"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/visitor"
+	"github.com/cectc/dbpack/third_party/parser"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+)
+
+func mockTextResult(t *testing.T, fields []*mysql.Field, rows ...[]string) *mysql.Result {
+	ctx := proto.WithCommandType(context.Background(), constant.ComQuery)
+	conn := &mysql.Conn{}
+	result := &mysql.Result{Fields: fields}
+	for _, r := range rows {
+		var data []byte
+		for _, cell := range r {
+			data = misc.AppendLengthEncodedInteger(data, uint64(len(cell)))
+			data = append(data, cell...)
+		}
+		row, err := conn.ParseRow(ctx, data, fields)
+		assert.Nil(t, err)
+		result.Rows = append(result.Rows, row)
+	}
+	return result
+}
+
+func TestAggregateDecimalSum(t *testing.T) {
+	fields := []*mysql.Field{{Name: "SUM(`amount`)", FieldType: constant.FieldTypeNewDecimal, Decimals: 2}}
+	result := mockTextResult(t, fields, []string{"0.10"}, []string{"0.20"}, []string{"12345678901234567890.30"})
+
+	ctx := proto.WithVariableMap(context.Background())
+	proto.WithVariable(ctx, FuncColumns, []*visitor.FuncColumn{{FuncName: ast.AggFuncSum, ColumnIndex: 0}})
+	assert.Nil(t, aggregateResult(ctx, result))
+
+	assert.Equal(t, 1, len(result.Rows))
+	values, err := result.Rows[0].Decode()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("12345678901234567890.60"), values[0].Val)
+}
+
+func TestAggregateDecimalAvg(t *testing.T) {
+	fields := []*mysql.Field{
+		{Name: "AVG(`amount`)", FieldType: constant.FieldTypeNewDecimal, Decimals: 2},
+		{Name: "COUNT(`amount`)", FieldType: constant.FieldTypeLongLong},
+	}
+	result := mockTextResult(t, fields, []string{"0.10", "1"}, []string{"0.20", "2"})
+
+	ctx := proto.WithVariableMap(context.Background())
+	proto.WithVariable(ctx, FuncColumns, []*visitor.FuncColumn{
+		{FuncName: ast.AggFuncAvg, ColumnIndex: 0, CountColumnIndex: 1},
+	})
+	assert.Nil(t, aggregateResult(ctx, result))
+	assert.Nil(t, trimHiddenColumns(result, 1))
+
+	assert.Equal(t, 1, len(result.Fields))
+	assert.Equal(t, byte(6), result.Fields[0].Decimals)
+	values, err := result.Rows[0].Decode()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(values))
+	assert.Equal(t, []byte("0.100000"), values[0].Val)
+}
+
+func TestAggregateMinMax(t *testing.T) {
+	fields := []*mysql.Field{
+		{Name: "COUNT(*)", FieldType: constant.FieldTypeLongLong},
+		{Name: "MIN(`salary`)", FieldType: constant.FieldTypeNewDecimal, Decimals: 2},
+		{Name: "MAX(`hire_date`)", FieldType: constant.FieldTypeVarString},
+	}
+	result := mockTextResult(t, fields,
+		[]string{"2", "9.50", "1999-01-02"}, []string{"3", "10.25", "2001-03-04"}, []string{"1", "8.75", "2000-05-06"})
+
+	ctx := proto.WithVariableMap(context.Background())
+	proto.WithVariable(ctx, FuncColumns, []*visitor.FuncColumn{
+		{FuncName: ast.AggFuncCount, ColumnIndex: 0},
+		{FuncName: ast.AggFuncMin, ColumnIndex: 1},
+		{FuncName: ast.AggFuncMax, ColumnIndex: 2},
+	})
+	assert.Nil(t, aggregateResult(ctx, result))
+
+	assert.Equal(t, 1, len(result.Rows))
+	values, err := result.Rows[0].Decode()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("6"), values[0].Val)
+	assert.Equal(t, []byte("8.75"), values[1].Val)
+	assert.Equal(t, []byte("2001-03-04"), values[2].Val)
+}
+
+func TestAggregateUnsupported(t *testing.T) {
+	fields := []*mysql.Field{
+		{Name: "COUNT(*)", FieldType: constant.FieldTypeLongLong},
+		{Name: "GROUP_CONCAT(`name`)", FieldType: constant.FieldTypeVarString},
+	}
+	result := mockTextResult(t, fields, []string{"2", "a,b"}, []string{"1", "c"})
+
+	ctx := proto.WithVariableMap(context.Background())
+	proto.WithVariable(ctx, FuncColumns, []*visitor.FuncColumn{
+		{FuncName: ast.AggFuncCount, ColumnIndex: 0},
+		{FuncName: ast.AggFuncGroupConcat, ColumnIndex: 1},
+	})
+	err := aggregateResult(ctx, result)
+	assert.EqualError(t, err, "aggregate function group_concat is not supported across shards")
+	assert.Equal(t, 2, len(result.Rows))
+}
+
+// maxMerger merges the approximate distinct counts of the shards into their max, a lower bound of the distinct count.
+type maxMerger struct{}
+
+func (m *maxMerger) Merge(ctx context.Context, values []*proto.Value) (interface{}, error) {
+	var max int64
+	for _, value := range values {
+		count, err := strconv.ParseInt(string(value.Val.([]byte)), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if count > max {
+			max = count
+		}
+	}
+	return max, nil
+}
+
+func TestAggregateByMerger(t *testing.T) {
+	fields := []*mysql.Field{{Name: "APPROX_COUNT_DISTINCT(`emp_no`)", FieldType: constant.FieldTypeLongLong}}
+	result := mockTextResult(t, fields, []string{"3"}, []string{"5"}, []string{"4"})
+
+	ctx := proto.WithVariableMap(context.Background())
+	proto.WithVariable(ctx, FuncColumns, []*visitor.FuncColumn{{FuncName: ast.AggFuncApproxCountDistinct, ColumnIndex: 0}})
+	merger.RegisterMerger("APPROX_COUNT_DISTINCT", &maxMerger{})
+	assert.Nil(t, aggregateResult(ctx, result))
+
+	assert.Equal(t, 1, len(result.Rows))
+	values, err := result.Rows[0].Decode()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("5"), values[0].Val)
+}
+
+func TestOrderValue(t *testing.T) {
+	v1 := orderValue(&proto.Value{Typ: constant.FieldTypeNewDecimal, Val: []byte("9.50")})
+	v2 := orderValue(&proto.Value{Typ: constant.FieldTypeNewDecimal, Val: []byte("10.25")})
+	assert.Equal(t, -1, compare(v1, v2))
+}
+
+func TestRewriteAvgFields(t *testing.T) {
+	p := parser.New()
+	stmt, err := p.ParseOneStmt("select avg(amount) from orders", "", "")
+	assert.Nil(t, err)
+	selectStmt := stmt.(*ast.SelectStmt)
+	multiPlan := &QueryOnMultiDBPlan{
+		Stmt:  selectStmt,
+		Plans: []*QueryOnSingleDBPlan{{Stmt: selectStmt}},
+	}
+	funcColumns := visitFuncColumn(selectStmt)
+	assert.Equal(t, 1, multiPlan.rewriteAvgFields(funcColumns))
+	assert.Equal(t, 1, funcColumns[0].CountColumnIndex)
+
+	var sb strings.Builder
+	err = generateSelect("orders_0", multiPlan.Plans[0].Stmt, &sb, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "SELECT SUM(`amount`) AS `avg(amount)`,COUNT(`amount`) FROM `orders_0`", sb.String())
+	// the original statement is reused by prepared statements, it should not be modified
+	assert.Equal(t, 1, len(selectStmt.Fields.Fields))
+}
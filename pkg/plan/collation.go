@@ -0,0 +1,122 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/mysql"
+)
+
+// collationNames maps the ids of the collations to their names.
+var collationNames = func() map[uint16]string {
+	names := make(map[uint16]string, len(constant.Collations))
+	for name, id := range constant.Collations {
+		names[id] = name
+	}
+	return names
+}()
+
+// sortKey turns a string of a column into a key, the keys compare bytewise as the collation of
+// the column compares the strings.
+type sortKey func(s []byte) []byte
+
+// newSortKey returns the sort key of the strings of field, or nil if they compare bytewise. The
+// collations of utf8 and utf8mb4 are supported: the _bin collations compare by code points, the
+// _general_ci ones by the upper case of the letters without their accents, and the others by
+// the unicode collation algorithm, which the collations of the languages are approximated with.
+// The strings of the other character sets compare bytewise.
+func newSortKey(field *mysql.Field) sortKey {
+	switch field.FieldType {
+	case constant.FieldTypeVarChar, constant.FieldTypeVarString, constant.FieldTypeString,
+		constant.FieldTypeTinyBLOB, constant.FieldTypeMediumBLOB, constant.FieldTypeLongBLOB, constant.FieldTypeBLOB:
+	default:
+		return nil
+	}
+	name := collationNames[field.CharSet]
+	if !strings.HasPrefix(name, "utf8_") && !strings.HasPrefix(name, "utf8mb4_") {
+		return nil
+	}
+	// the collations of mysql 8.0 are NO PAD, the others ignore the trailing spaces
+	noPad := strings.Contains(name, "_0900_")
+	switch {
+	case strings.HasSuffix(name, "_bin"):
+		if noPad {
+			return nil
+		}
+		return trimTrailingSpaces
+	case strings.HasSuffix(name, "_general_ci") || name == "utf8_general_mysql500_ci":
+		return generalKey
+	default:
+		return unicodeKey(collate.New(language.Und, collate.Loose), noPad)
+	}
+}
+
+func trimTrailingSpaces(s []byte) []byte {
+	return bytes.TrimRight(s, " ")
+}
+
+// generalKey is the sort key of the _general_ci collations, the characters weigh 2 bytes as in mysql.
+func generalKey(s []byte) []byte {
+	s = trimTrailingSpaces(s)
+	key := make([]byte, 0, 2*len(s))
+	for len(s) > 0 {
+		r, size := utf8.DecodeRune(s)
+		s = s[size:]
+		w := generalWeight(r)
+		key = append(key, byte(w>>8), byte(w))
+	}
+	return key
+}
+
+// generalWeight returns the weight of r in the _general_ci collations, the characters beyond the
+// basic multilingual plane weigh as U+FFFD.
+func generalWeight(r rune) rune {
+	switch {
+	case r > 0xffff:
+		return utf8.RuneError
+	case r == 'ß':
+		return 'S'
+	case r >= 0xc0 && r < 0x500:
+		// the letters of the latin, greek and cyrillic alphabets weigh as their base letters
+		if d := norm.NFD.PropertiesString(string(r)).Decomposition(); len(d) > 0 {
+			r, _ = utf8.DecodeRune(d)
+		}
+	}
+	return unicode.ToUpper(r)
+}
+
+// unicodeKey returns the sort key of c, which is not safe for concurrent use as c is not.
+func unicodeKey(c *collate.Collator, noPad bool) sortKey {
+	var buf collate.Buffer
+	return func(s []byte) []byte {
+		if !noPad {
+			s = trimTrailingSpaces(s)
+		}
+		key := append([]byte(nil), c.Key(&buf, s)...)
+		buf.Reset()
+		return key
+	}
+}
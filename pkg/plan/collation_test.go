@@ -0,0 +1,60 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/mysql"
+)
+
+func TestSortKey(t *testing.T) {
+	testCases := []struct {
+		collation string
+		a, b      string
+		expect    int
+	}{
+		{collation: "utf8mb4_general_ci", a: "apple", b: "Banana", expect: -1},
+		{collation: "utf8mb4_general_ci", a: "a", b: "A", expect: 0},
+		{collation: "utf8mb4_general_ci", a: "é", b: "E", expect: 0},
+		{collation: "utf8mb4_general_ci", a: "straße", b: "STRASE", expect: 0},
+		{collation: "utf8mb4_general_ci", a: "a ", b: "a", expect: 0},
+		{collation: "utf8_general_ci", a: "Zebra", b: "ábaco", expect: 1},
+		{collation: "utf8mb4_bin", a: "apple", b: "Banana", expect: 1},
+		{collation: "utf8mb4_bin", a: "a ", b: "a", expect: 0},
+		{collation: "utf8mb4_0900_ai_ci", a: "apple", b: "Banana", expect: -1},
+		{collation: "utf8mb4_0900_ai_ci", a: "résumé", b: "RESUME", expect: 0},
+		{collation: "utf8mb4_0900_ai_ci", a: "a ", b: "a", expect: 1},
+		{collation: "utf8mb4_unicode_ci", a: "a ", b: "A", expect: 0},
+		{collation: "binary", a: "apple", b: "Banana", expect: 1},
+		{collation: "latin1_swedish_ci", a: "apple", b: "Banana", expect: 1},
+	}
+	for _, c := range testCases {
+		field := &mysql.Field{FieldType: constant.FieldTypeVarString, CharSet: constant.Collations[c.collation]}
+		a, b := []byte(c.a), []byte(c.b)
+		if key := newSortKey(field); key != nil {
+			a, b = key(a), key(b)
+		}
+		assert.Equal(t, c.expect, bytes.Compare(a, b), "%s: %s, %s", c.collation, c.a, c.b)
+	}
+	// the other columns compare as they are
+	assert.Nil(t, newSortKey(&mysql.Field{FieldType: constant.FieldTypeLong, CharSet: constant.Collations["binary"]}))
+}
@@ -147,19 +147,12 @@ func (p *DeletePlan) generate(sb *strings.Builder, table string, hints ...*ast.T
 			return errors.Wrap(err, "An error occurred while restoring DeleteStmt.Order")
 		}
 	}
-	if p.Stmt.Order != nil {
+	if p.Stmt.Limit != nil {
 		ctx.WritePlain(" ")
-		if err := p.Stmt.Order.Restore(ctx); err != nil {
-			return errors.Wrap(err, "An error occurred while restore DeleteStmt.Order")
+		if err := p.Stmt.Limit.Restore(ctx); err != nil {
+			return errors.Wrap(err, "An error occurred while restoring DeleteStmt.Limit")
 		}
 	}
-
-	//if p.Stmt.Limit != nil {
-	//	ctx.WritePlain(" ")
-	//	if err := p.Stmt.Limit.Restore(ctx); err != nil {
-	//		return errors.Wrap(err, "An error occurred while restoring DeleteStmt.Limit")
-	//	}
-	//}
 	return nil
 }
 
@@ -33,6 +33,8 @@ type DirectQueryPlan struct {
 	Stmt     ast.Node
 	Args     []interface{}
 	Executor proto.DBGroupExecutor
+	// ForceMaster routes the query to the master of the db group
+	ForceMaster bool
 }
 
 func (p *DirectQueryPlan) Execute(ctx context.Context, hints ...*ast.TableOptimizerHint) (proto.Result, uint16, error) {
@@ -47,6 +49,9 @@ func (p *DirectQueryPlan) Execute(ctx context.Context, hints ...*ast.TableOptimi
 	}
 	sql = sb.String()
 	log.Debugf("directly query, db name: %s, sql: %s", p.Executor.GroupName(), sql)
+	if p.ForceMaster {
+		ctx = proto.WithMaster(ctx)
+	}
 	commandType := proto.CommandType(ctx)
 	switch commandType {
 	case constant.ComQuery:
@@ -0,0 +1,248 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/third_party/parser/format"
+)
+
+var routeColumns = []string{"plan", "database", "tables"}
+
+// ExplainRoute describes which databases and tables the plan will be routed to,
+// it is the result of `EXPLAIN ROUTE` statement.
+func ExplainRoute(p proto.Plan) (*mysql.Result, error) {
+	routes, err := explainRoutes(p)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make([]*mysql.Field, 0, len(routeColumns))
+	for _, column := range routeColumns {
+		fields = append(fields, &mysql.Field{
+			Name:      column,
+			FieldType: constant.FieldTypeVarString,
+			CharSet:   constant.CharacterSetUtf8,
+		})
+	}
+	rows := make([]proto.Row, 0, len(routes))
+	for _, route := range routes {
+		values := make([]*proto.Value, 0, len(route))
+		for _, value := range route {
+			values = append(values, &proto.Value{
+				Typ: constant.FieldTypeVarString,
+				Len: len(value),
+				Val: []byte(value),
+				Raw: []byte(value),
+			})
+		}
+		rows = append(rows, mysql.NewTextRow(fields, values))
+	}
+	return &mysql.Result{
+		Fields: fields,
+		Rows:   rows,
+	}, nil
+}
+
+func explainRoutes(p proto.Plan) ([][]string, error) {
+	var routes [][]string
+	switch pl := p.(type) {
+	case *QueryOnSingleDBPlan:
+		routes = append(routes, []string{"QueryOnSingleDB", pl.Database, strings.Join(pl.Tables, ",")})
+	case *QueryOnMultiDBPlan:
+		for _, sp := range pl.Plans {
+			routes = append(routes, []string{"QueryOnMultiDB", sp.Database, strings.Join(sp.Tables, ",")})
+		}
+	case *InsertPlan:
+		routes = append(routes, []string{"Insert", pl.Database, pl.Table})
+	case *DeletePlan:
+		routes = append(routes, []string{"Delete", pl.Database, strings.Join(pl.Tables, ",")})
+	case *MultiDeletePlan:
+		for _, sp := range pl.Plans {
+			routes = append(routes, []string{"MultiDelete", sp.Database, strings.Join(sp.Tables, ",")})
+		}
+	case *UpdatePlan:
+		routes = append(routes, []string{"Update", pl.Database, strings.Join(pl.Tables, ",")})
+	case *MultiUpdatePlan:
+		for _, sp := range pl.Plans {
+			routes = append(routes, []string{"MultiUpdate", sp.Database, strings.Join(sp.Tables, ",")})
+		}
+	case *DirectQueryPlan:
+		routes = append(routes, []string{"DirectQuery", pl.Executor.GroupName(), ""})
+	case *MultiDirectlyQueryPlan:
+		for _, sp := range pl.Plans {
+			routes = append(routes, []string{"MultiDirectlyQuery", sp.Executor.GroupName(), ""})
+		}
+	case *ReplicaQueryPlan:
+		// the query is sent to one of the replicas
+		for _, executor := range pl.Replicas.Executors {
+			routes = append(routes, []string{"ReplicaQuery", executor.GroupName(), ""})
+		}
+	case *BroadcastPlan:
+		for _, executor := range pl.Replicas.Executors {
+			routes = append(routes, []string{"Broadcast", executor.GroupName(), ""})
+		}
+	case *FederatedQueryPlan:
+		// the rows of each table are selected from one of its replicas
+		for _, source := range pl.Sources {
+			for _, executor := range source.Replicas.Executors {
+				routes = append(routes, []string{"FederatedQuery", executor.GroupName(), source.Table})
+			}
+		}
+	case *LogicalSchemaPlan:
+		for _, executor := range pl.Executors {
+			routes = append(routes, []string{"LogicalSchema", executor.GroupName(), ""})
+		}
+	case *OnlineDDLPlan:
+		for _, shard := range pl.Shards {
+			routes = append(routes, []string{"OnlineDDL", shard.Executor.GroupName(), shard.Table})
+		}
+	case *LimitPlan:
+		selectRoutes, err := explainRoutes(pl.Select)
+		if err != nil {
+			return nil, err
+		}
+		for _, route := range selectRoutes {
+			routes = append(routes, []string{"Limit" + route[0], route[1], route[2]})
+		}
+	default:
+		return nil, errors.Errorf("unsupported explain route of plan %T", p)
+	}
+	return routes, nil
+}
+
+// PhysicalStatement is a statement a plan sends to a database.
+type PhysicalStatement struct {
+	Database string `json:"database"`
+	SQL      string `json:"sql"`
+}
+
+// PhysicalStatements generates the statements the plan sends to the databases without executing it,
+// a LimitPlan only has the statements selecting the primary keys, the rest is routed at execution.
+func PhysicalStatements(p proto.Plan) ([]*PhysicalStatement, error) {
+	var (
+		statements []*PhysicalStatement
+		sb         strings.Builder
+	)
+	switch pl := p.(type) {
+	case *QueryOnSingleDBPlan:
+		// castLimit is only applied to a copy, the plan may be cached and executed concurrently
+		cloned := *pl
+		cloned.castLimit()
+		var args []interface{}
+		if err := cloned.generate(proto.WithVariableMap(context.Background()), &sb, &args); err != nil {
+			return nil, err
+		}
+		statements = append(statements, &PhysicalStatement{Database: pl.Database, SQL: sb.String()})
+	case *QueryOnMultiDBPlan:
+		for _, sp := range pl.Plans {
+			spStatements, err := PhysicalStatements(sp)
+			if err != nil {
+				return nil, err
+			}
+			statements = append(statements, spStatements...)
+		}
+	case *DeletePlan:
+		for _, table := range pl.Tables {
+			sb.Reset()
+			if err := pl.generate(&sb, table); err != nil {
+				return nil, err
+			}
+			statements = append(statements, &PhysicalStatement{Database: pl.Database, SQL: sb.String()})
+		}
+	case *MultiDeletePlan:
+		for _, sp := range pl.Plans {
+			spStatements, err := PhysicalStatements(sp)
+			if err != nil {
+				return nil, err
+			}
+			statements = append(statements, spStatements...)
+		}
+	case *UpdatePlan:
+		for _, table := range pl.Tables {
+			sb.Reset()
+			if err := pl.generate(&sb, table); err != nil {
+				return nil, err
+			}
+			statements = append(statements, &PhysicalStatement{Database: pl.Database, SQL: sb.String()})
+		}
+	case *MultiUpdatePlan:
+		for _, sp := range pl.Plans {
+			spStatements, err := PhysicalStatements(sp)
+			if err != nil {
+				return nil, err
+			}
+			statements = append(statements, spStatements...)
+		}
+	case *DirectQueryPlan:
+		if err := pl.Stmt.Restore(format.NewRestoreCtx(constant.DBPackRestoreFormat, &sb)); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		statements = append(statements, &PhysicalStatement{Database: pl.Executor.GroupName(), SQL: sb.String()})
+	case *MultiDirectlyQueryPlan:
+		for _, sp := range pl.Plans {
+			spStatements, err := PhysicalStatements(sp)
+			if err != nil {
+				return nil, err
+			}
+			statements = append(statements, spStatements...)
+		}
+	case *ReplicaQueryPlan:
+		// the query is sent to one of the replicas, reported as the first one
+		sql, err := restore(pl.Stmt)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, &PhysicalStatement{Database: pl.Replicas.Executors[0].GroupName(), SQL: sql})
+	case *BroadcastPlan:
+		sql, err := restore(pl.Stmt)
+		if err != nil {
+			return nil, err
+		}
+		for _, executor := range pl.Replicas.Executors {
+			statements = append(statements, &PhysicalStatement{Database: executor.GroupName(), SQL: sql})
+		}
+	case *FederatedQueryPlan:
+		// reported on the first replica of each table, as for ReplicaQueryPlan
+		for _, source := range pl.Sources {
+			statements = append(statements, &PhysicalStatement{
+				Database: source.Replicas.Executors[0].GroupName(),
+				SQL:      source.SQL,
+			})
+		}
+	case *LogicalSchemaPlan:
+		sql, err := restore(pl.Stmt)
+		if err != nil {
+			return nil, err
+		}
+		for _, executor := range pl.Executors {
+			statements = append(statements, &PhysicalStatement{Database: executor.GroupName(), SQL: sql})
+		}
+	case *LimitPlan:
+		return PhysicalStatements(pl.Select)
+	default:
+		return nil, errors.Errorf("unsupported physical statements of plan %T", p)
+	}
+	return statements, nil
+}
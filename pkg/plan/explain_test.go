@@ -0,0 +1,111 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/visitor"
+	"github.com/cectc/dbpack/third_party/parser"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+)
+
+func TestExplainRoute(t *testing.T) {
+	p := &QueryOnMultiDBPlan{
+		Plans: []*QueryOnSingleDBPlan{
+			{Database: "school_0", Tables: []string{"student_0", "student_1"}},
+			{Database: "school_1", Tables: []string{"student_5"}},
+		},
+	}
+	result, err := ExplainRoute(p)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(result.Fields))
+	assert.Equal(t, 2, len(result.Rows))
+
+	expected := [][]string{
+		{"QueryOnMultiDB", "school_0", "student_0,student_1"},
+		{"QueryOnMultiDB", "school_1", "student_5"},
+	}
+	for i, row := range result.Rows {
+		values, err := row.Decode()
+		assert.Nil(t, err)
+		for j, value := range values {
+			assert.Equal(t, expected[i][j], string(value.Val.([]byte)))
+		}
+	}
+
+	_, err = ExplainRoute(nil)
+	assert.NotNil(t, err)
+}
+
+func TestPhysicalStatements(t *testing.T) {
+	parse := func(sql string) ast.StmtNode {
+		stmt, err := parser.New().ParseOneStmt(sql, "", "")
+		assert.NoError(t, err)
+		stmt.Accept(&visitor.ParamVisitor{})
+		return stmt
+	}
+	selectStmt := parse("select id, name from student where id in (?, ?) limit ?").(*ast.SelectStmt)
+	deleteStmt := parse("delete from student where id = 9").(*ast.DeleteStmt)
+
+	testCases := []struct {
+		plan      proto.Plan
+		expected  []*PhysicalStatement
+		expectErr bool
+	}{
+		{
+			plan: &QueryOnMultiDBPlan{
+				Stmt: selectStmt,
+				Plans: []*QueryOnSingleDBPlan{
+					{Database: "school_0", Tables: []string{"student_1"}, Stmt: selectStmt,
+						Args: []interface{}{int64(1), int64(5), int64(10)}},
+					{Database: "school_1", Tables: []string{"student_5"}, Stmt: selectStmt,
+						Args: []interface{}{int64(1), int64(5), int64(10)}},
+				},
+			},
+			expected: []*PhysicalStatement{
+				{Database: "school_0", SQL: "SELECT `id`,`name` FROM `student_1` WHERE `id` IN (?,?) LIMIT 10"},
+				{Database: "school_1", SQL: "SELECT `id`,`name` FROM `student_5` WHERE `id` IN (?,?) LIMIT 10"},
+			},
+		},
+		{
+			plan: &DeletePlan{Database: "school_0", Tables: []string{"student_1", "student_9"}, Stmt: deleteStmt},
+			expected: []*PhysicalStatement{
+				{Database: "school_0", SQL: "DELETE FROM student_1 WHERE `id`=9"},
+				{Database: "school_0", SQL: "DELETE FROM student_9 WHERE `id`=9"},
+			},
+		},
+		{
+			plan:      &InsertPlan{},
+			expectErr: true,
+		},
+	}
+	for _, c := range testCases {
+		statements, err := PhysicalStatements(c.plan)
+		if c.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, c.expected, statements)
+	}
+	// the limit of a plan is resolved on a copy
+	assert.Nil(t, testCases[0].plan.(*QueryOnMultiDBPlan).Plans[0].Limit)
+}
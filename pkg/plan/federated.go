@@ -0,0 +1,482 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+	"github.com/cectc/dbpack/third_party/parser/format"
+	"github.com/cectc/dbpack/third_party/parser/opcode"
+	driver "github.com/cectc/dbpack/third_party/types/parser_driver"
+)
+
+// FederatedQueryPlan runs a query joining tables that no db group holds together, such as tables
+// routed to separate clusters while an application moves them from one cluster to another. The
+// rows of each table matching the conditions on that table alone are selected from one of its
+// replicas, then they are joined, aggregated, ordered and limited by dbpack.
+//
+// Only a subset of SELECT is supported: cross, inner and left joins on equalities of columns,
+// columns, wildcards and COUNT, SUM, AVG, MIN and MAX of a column as select fields, GROUP BY,
+// ORDER BY and LIMIT. The columns of WHERE and ON conditions must be qualified by their table.
+type FederatedQueryPlan struct {
+	Stmt    *ast.SelectStmt
+	Args    []interface{}
+	Sources []*FederatedSource
+}
+
+// FederatedSource is a table of a federated query, in the order of the FROM clause.
+type FederatedSource struct {
+	// Table is the lower case name the query refers to the table by, its alias if it has one.
+	Table    string
+	Replicas *Replicas
+	// Left is set if the table is the right table of a left join.
+	Left bool
+	// Keys are the equalities of columns joining the table to the tables before it.
+	Keys []*FederatedKey
+	// SQL selects the rows of the table matching the conditions on it alone.
+	SQL  string
+	Args []interface{}
+}
+
+// FederatedKey is an equality of a column of a table before a source, Outer, and a column of the source, Inner.
+type FederatedKey struct {
+	Outer *ast.ColumnName
+	Inner *ast.ColumnName
+}
+
+// NewFederatedQueryPlan splits the conditions of stmt into the conditions selecting the rows of each table and the
+// keys joining the tables, replicas are the replicas of the tables keyed by lower case table name.
+func NewFederatedQueryPlan(stmt *ast.SelectStmt, args []interface{},
+	replicas map[string]*Replicas) (*FederatedQueryPlan, error) {
+	if err := checkFederatedSelect(stmt); err != nil {
+		return nil, err
+	}
+	b := &federatedBuilder{
+		plan:     &FederatedQueryPlan{Stmt: stmt, Args: args},
+		replicas: replicas,
+	}
+	if err := b.addSources(stmt.From.TableRefs); err != nil {
+		return nil, err
+	}
+	if stmt.Where != nil {
+		for _, expr := range splitConjuncts(stmt.Where) {
+			if err := b.addCondition(expr, false); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for i, source := range b.plan.Sources {
+		if err := b.generate(source, b.tables[i], b.conditions[i]); err != nil {
+			return nil, err
+		}
+	}
+	return b.plan, nil
+}
+
+func (p *FederatedQueryPlan) Execute(ctx context.Context, _ ...*ast.TableOptimizerHint) (proto.Result, uint16, error) {
+	results, warnings, err := p.fetch(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	columns, rows, err := p.join(results)
+	if err != nil {
+		return nil, 0, err
+	}
+	result, err := p.project(columns, rows, proto.CommandType(ctx) == constant.ComStmtExecute)
+	if err != nil {
+		return nil, 0, err
+	}
+	return result, warnings, nil
+}
+
+// fetch selects the rows of the sources, from the first replica of each table in a transaction, as
+// ReplicaQueryPlan does, and concurrently from replicas picked in turn out of a transaction.
+func (p *FederatedQueryPlan) fetch(ctx context.Context) ([]*mysql.Result, uint16, error) {
+	var (
+		results   = make([]*mysql.Result, len(p.Sources))
+		warnings  = make([]uint16, len(p.Sources))
+		complexTx = proto.ExtractDBGroupTx(ctx)
+	)
+	fetchSource := func(i int) error {
+		var (
+			source   = p.Sources[i]
+			executor proto.DBGroupExecutor
+			result   proto.Result
+			err      error
+		)
+		if complexTx != nil {
+			executor = source.Replicas.Executors[0]
+			var tx proto.Tx
+			if tx, err = complexTx.Begin(ctx, executor); err != nil {
+				return errors.WithStack(err)
+			}
+			log.Debugf("federated query, db name: %s, sql: %s", executor.GroupName(), source.SQL)
+			result, warnings[i], err = executeInTx(ctx, tx, source.SQL, source.Args)
+		} else {
+			executor = source.Replicas.Pick()
+			log.Debugf("federated query, db name: %s, sql: %s", executor.GroupName(), source.SQL)
+			switch proto.CommandType(ctx) {
+			case constant.ComQuery:
+				result, warnings[i], err = executor.Query(ctx, source.SQL)
+			case constant.ComStmtExecute:
+				result, warnings[i], err = executor.PrepareQuery(ctx, source.SQL, source.Args...)
+			}
+		}
+		if err != nil {
+			return errors.Wrapf(err, "select rows of %s from %s failed", source.Table, executor.GroupName())
+		}
+		rlt, ok := result.(*mysql.Result)
+		if !ok {
+			return errors.Errorf("unexpected result type %T", result)
+		}
+		results[i] = rlt
+		return nil
+	}
+
+	if complexTx != nil {
+		// the transaction of a db group can not run statements concurrently
+		for i := range p.Sources {
+			if err := fetchSource(i); err != nil {
+				return nil, 0, err
+			}
+		}
+	} else {
+		var g errgroup.Group
+		for i := range p.Sources {
+			i := i
+			g.Go(func() error {
+				return fetchSource(i)
+			})
+		}
+		if err := g.Wait(); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	var warning uint16
+	for _, warns := range warnings {
+		warning += warns
+	}
+	return results, warning, nil
+}
+
+func checkFederatedSelect(stmt *ast.SelectStmt) error {
+	switch {
+	case stmt.Distinct:
+		return errors.New("DISTINCT is not supported in a query across datasources")
+	case stmt.Having != nil:
+		return errors.New("HAVING is not supported in a query across datasources")
+	case stmt.LockInfo != nil && stmt.LockInfo.LockType != ast.SelectLockNone:
+		return errors.New("locking reads are not supported in a query across datasources")
+	case len(stmt.WindowSpecs) > 0:
+		return errors.New("window functions are not supported in a query across datasources")
+	}
+	v := &unsupportedExprVisitor{}
+	stmt.Accept(v)
+	if v.unsupported != "" {
+		return errors.Errorf("%s are not supported in a query across datasources", v.unsupported)
+	}
+	return nil
+}
+
+// unsupportedExprVisitor finds the subqueries and window functions, which a federated query can not compute.
+type unsupportedExprVisitor struct {
+	unsupported string
+}
+
+func (v *unsupportedExprVisitor) Enter(in ast.Node) (out ast.Node, skipChildren bool) {
+	switch in.(type) {
+	case *ast.SubqueryExpr:
+		v.unsupported = "subqueries"
+	case *ast.WindowFuncExpr:
+		v.unsupported = "window functions"
+	}
+	return in, v.unsupported != ""
+}
+
+func (v *unsupportedExprVisitor) Leave(in ast.Node) (out ast.Node, ok bool) {
+	return in, true
+}
+
+type federatedBuilder struct {
+	plan     *FederatedQueryPlan
+	replicas map[string]*Replicas
+	// tables and conditions are the table sources and the conditions pushed down to them, by source
+	tables     []*ast.TableSource
+	conditions [][]ast.ExprNode
+}
+
+// addSources adds the tables of a left deep join tree, the ON conditions of a join are added with its right table.
+func (b *federatedBuilder) addSources(node ast.ResultSetNode) error {
+	switch n := node.(type) {
+	case *ast.TableSource:
+		return b.addSource(n, false)
+	case *ast.Join:
+		if err := b.addSources(n.Left); err != nil {
+			return err
+		}
+		if n.Right == nil {
+			return nil
+		}
+		if n.NaturalJoin || len(n.Using) > 0 {
+			return errors.New("NATURAL JOIN and USING are not supported in a query across datasources")
+		}
+		if n.Tp != ast.CrossJoin && n.Tp != ast.LeftJoin {
+			return errors.New("RIGHT JOIN is not supported in a query across datasources")
+		}
+		right, ok := n.Right.(*ast.TableSource)
+		if !ok {
+			return errors.New("nested joins are not supported in a query across datasources")
+		}
+		if err := b.addSource(right, n.Tp == ast.LeftJoin); err != nil {
+			return err
+		}
+		if n.On != nil {
+			for _, expr := range splitConjuncts(n.On.Expr) {
+				if err := b.addCondition(expr, n.Tp == ast.LeftJoin); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	default:
+		return errors.Errorf("unsupported table reference %T in a query across datasources", node)
+	}
+}
+
+func (b *federatedBuilder) addSource(ts *ast.TableSource, left bool) error {
+	tableName, ok := ts.Source.(*ast.TableName)
+	if !ok {
+		return errors.New("derived tables are not supported in a query across datasources")
+	}
+	table := tableName.Name.L
+	if ts.AsName.L != "" {
+		table = ts.AsName.L
+	}
+	if b.sourceIndex(table) >= 0 {
+		return errors.Errorf("not unique table/alias: '%s'", table)
+	}
+	replicas, ok := b.replicas[tableName.Name.L]
+	if !ok {
+		return errors.Errorf("table %s has no datasource", tableName.Name.O)
+	}
+	b.plan.Sources = append(b.plan.Sources, &FederatedSource{Table: table, Replicas: replicas, Left: left})
+	b.tables = append(b.tables, ts)
+	b.conditions = append(b.conditions, nil)
+	return nil
+}
+
+func (b *federatedBuilder) sourceIndex(table string) int {
+	for i, source := range b.plan.Sources {
+		if source.Table == table {
+			return i
+		}
+	}
+	return -1
+}
+
+// addCondition pushes a condition on a single table down to the table, or makes an equality of
+// columns of two tables a join key. on is set for the ON conditions of a left join, which select the
+// rows of its right table only, while the WHERE conditions can not refer to the right table of a left
+// join as its missing rows are NULL.
+func (b *federatedBuilder) addCondition(expr ast.ExprNode, on bool) error {
+	v := &columnVisitor{}
+	expr.Accept(v)
+	indexes := make(map[int]bool)
+	for _, column := range v.columns {
+		if column.Table.L == "" {
+			return errors.Errorf("column %s must be qualified by its table in a query across datasources",
+				column.Name.O)
+		}
+		i := b.sourceIndex(column.Table.L)
+		if i < 0 {
+			return errors.Errorf("unknown table %s", column.Table.O)
+		}
+		indexes[i] = true
+	}
+
+	last := len(b.plan.Sources) - 1
+	if on {
+		// the ON conditions of the join of the last source
+		if key := joinKeyOf(expr); key != nil && len(indexes) == 2 && indexes[last] {
+			b.addKey(key, last)
+			return nil
+		}
+		if len(indexes) == 0 || (len(indexes) == 1 && indexes[last]) {
+			b.conditions[last] = append(b.conditions[last], expr)
+			return nil
+		}
+		return errors.Errorf("the ON condition %s of a left join across datasources must "+
+			"be an equality of columns or refer to the joined table only", text(expr))
+	}
+
+	switch len(indexes) {
+	case 0:
+		b.conditions[0] = append(b.conditions[0], expr)
+		return nil
+	case 1:
+		for i := range indexes {
+			if b.plan.Sources[i].Left {
+				return errors.Errorf("the condition %s on the right table of a left join across "+
+					"datasources is not supported", text(expr))
+			}
+			b.conditions[i] = append(b.conditions[i], expr)
+		}
+		return nil
+	case 2:
+		if key := joinKeyOf(expr); key != nil {
+			// the key joins the later of the two tables
+			inner := b.sourceIndex(key.Inner.Table.L)
+			if outer := b.sourceIndex(key.Outer.Table.L); outer > inner {
+				inner = outer
+			}
+			if b.plan.Sources[inner].Left {
+				return errors.Errorf("the condition %s on the right table of a left join across "+
+					"datasources is not supported", text(expr))
+			}
+			b.addKey(key, inner)
+			return nil
+		}
+	}
+	return errors.Errorf("the condition %s across datasources is not supported, "+
+		"only equalities of columns can join tables", text(expr))
+}
+
+// addKey adds a join key to the source at index, the inner column of the key must be a column of the source.
+func (b *federatedBuilder) addKey(key *FederatedKey, index int) {
+	if b.sourceIndex(key.Inner.Table.L) != index {
+		key = &FederatedKey{Outer: key.Inner, Inner: key.Outer}
+	}
+	b.plan.Sources[index].Keys = append(b.plan.Sources[index].Keys, key)
+}
+
+// joinKeyOf returns the columns of an equality of columns of two tables.
+func joinKeyOf(expr ast.ExprNode) *FederatedKey {
+	for {
+		p, ok := expr.(*ast.ParenthesesExpr)
+		if !ok {
+			break
+		}
+		expr = p.Expr
+	}
+	be, ok := expr.(*ast.BinaryOperationExpr)
+	if !ok || be.Op != opcode.EQ {
+		return nil
+	}
+	left, ok := be.L.(*ast.ColumnNameExpr)
+	if !ok {
+		return nil
+	}
+	right, ok := be.R.(*ast.ColumnNameExpr)
+	if !ok || left.Name.Table.L == right.Name.Table.L {
+		return nil
+	}
+	return &FederatedKey{Outer: left.Name, Inner: right.Name}
+}
+
+// generate generates the statement selecting the rows of a source, with the args of its parameter markers.
+func (b *federatedBuilder) generate(source *FederatedSource, ts *ast.TableSource, conditions []ast.ExprNode) error {
+	var sb strings.Builder
+	restoreCtx := format.NewRestoreCtx(constant.DBPackRestoreFormat, &sb)
+	sb.WriteString("SELECT * FROM ")
+	if err := ts.Restore(restoreCtx); err != nil {
+		return errors.WithStack(err)
+	}
+	for i, condition := range conditions {
+		if i == 0 {
+			sb.WriteString(" WHERE (")
+		} else {
+			sb.WriteString(" AND (")
+		}
+		if err := condition.Restore(restoreCtx); err != nil {
+			return errors.WithStack(err)
+		}
+		sb.WriteByte(')')
+
+		v := &paramMarkerVisitor{}
+		condition.Accept(v)
+		for _, marker := range v.markers {
+			if marker.Order >= len(b.plan.Args) {
+				return errors.Errorf("missing arg of parameter marker %d", marker.Order)
+			}
+			source.Args = append(source.Args, b.plan.Args[marker.Order])
+		}
+	}
+	source.SQL = sb.String()
+	return nil
+}
+
+// columnVisitor collects the columns of an expression.
+type columnVisitor struct {
+	columns []*ast.ColumnName
+}
+
+func (v *columnVisitor) Enter(in ast.Node) (out ast.Node, skipChildren bool) {
+	if column, ok := in.(*ast.ColumnNameExpr); ok {
+		v.columns = append(v.columns, column.Name)
+	}
+	return in, false
+}
+
+func (v *columnVisitor) Leave(in ast.Node) (out ast.Node, ok bool) {
+	return in, true
+}
+
+// paramMarkerVisitor collects the parameter markers of an expression in the order they are restored.
+type paramMarkerVisitor struct {
+	markers []*driver.ParamMarkerExpr
+}
+
+func (v *paramMarkerVisitor) Enter(in ast.Node) (out ast.Node, skipChildren bool) {
+	if marker, ok := in.(*driver.ParamMarkerExpr); ok {
+		v.markers = append(v.markers, marker)
+	}
+	return in, false
+}
+
+func (v *paramMarkerVisitor) Leave(in ast.Node) (out ast.Node, ok bool) {
+	return in, true
+}
+
+func splitConjuncts(expr ast.ExprNode) []ast.ExprNode {
+	switch e := expr.(type) {
+	case *ast.BinaryOperationExpr:
+		if e.Op == opcode.LogicAnd {
+			return append(splitConjuncts(e.L), splitConjuncts(e.R)...)
+		}
+	case *ast.ParenthesesExpr:
+		return splitConjuncts(e.Expr)
+	}
+	return []ast.ExprNode{expr}
+}
+
+func text(node ast.Node) string {
+	sql, err := restore(node)
+	if err != nil {
+		return node.Text()
+	}
+	return sql
+}
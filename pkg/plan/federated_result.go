@@ -0,0 +1,695 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+	parsermysql "github.com/cectc/dbpack/third_party/parser/mysql"
+	"github.com/cectc/dbpack/third_party/types"
+	driver "github.com/cectc/dbpack/third_party/types/parser_driver"
+)
+
+// federatedColumn is a column of the joined rows of a federated query.
+type federatedColumn struct {
+	table string
+	field *mysql.Field
+}
+
+// federatedField is a select field of a federated query, either a column or an aggregate of a column.
+type federatedField struct {
+	field *mysql.Field
+	// text is the lower case expression of the field, which ORDER BY items may repeat
+	text  string
+	alias string
+	// column is the index of the joined column of the field or of its argument, -1 for COUNT(*)
+	column int
+	// agg is the lower case name of the aggregate function, empty for columns
+	agg string
+}
+
+// federatedRow is a row of the result, first is the first joined row it is computed from, nil
+// for the aggregates of no rows, the ORDER BY items which are not select fields are read from it.
+type federatedRow struct {
+	values []*proto.Value
+	first  []*proto.Value
+	keys   []interface{}
+}
+
+// join joins the rows of the sources in order, by hash joins on their keys, a source without
+// keys is cross joined.
+func (p *FederatedQueryPlan) join(results []*mysql.Result) ([]*federatedColumn, [][]*proto.Value, error) {
+	var (
+		columns []*federatedColumn
+		rows    [][]*proto.Value
+	)
+	for i, source := range p.Sources {
+		result := results[i]
+		values, err := decodeRows(result.Rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		outerColumns := columns
+		for _, field := range result.Fields {
+			columns = append(columns, &federatedColumn{table: source.Table, field: field})
+		}
+		if i == 0 {
+			rows = values
+			continue
+		}
+
+		outerKeys := make([]int, 0, len(source.Keys))
+		innerKeys := make([]int, 0, len(source.Keys))
+		for _, key := range source.Keys {
+			outer, err := resolveColumn(outerColumns, key.Outer)
+			if err != nil {
+				return nil, nil, err
+			}
+			inner, err := resolveColumn(columns[len(outerColumns):], key.Inner)
+			if err != nil {
+				return nil, nil, err
+			}
+			outerKeys = append(outerKeys, outer)
+			innerKeys = append(innerKeys, inner)
+		}
+		rows = hashJoin(rows, values, outerKeys, innerKeys, len(result.Fields), source.Left)
+	}
+	return columns, rows, nil
+}
+
+func decodeRows(rows []proto.Row) ([][]*proto.Value, error) {
+	values := make([][]*proto.Value, 0, len(rows))
+	for _, row := range rows {
+		vals, err := row.Decode()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, vals)
+	}
+	return values, nil
+}
+
+// hashJoin joins the outer rows and the inner rows, whose width is the number of columns, by the equality of
+// their keys, NULL keys never match. The outer rows without matched inner rows are kept with NULL inner
+// columns if left is set.
+func hashJoin(outer, inner [][]*proto.Value, outerKeys, innerKeys []int, width int, left bool) [][]*proto.Value {
+	build := make(map[string][][]*proto.Value)
+	for _, row := range inner {
+		if key, ok := joinKey(row, innerKeys); ok {
+			build[key] = append(build[key], row)
+		}
+	}
+	joined := make([][]*proto.Value, 0, len(outer))
+	for _, row := range outer {
+		var matched [][]*proto.Value
+		if key, ok := joinKey(row, outerKeys); ok {
+			matched = build[key]
+		}
+		if len(matched) == 0 && left {
+			matched = [][]*proto.Value{make([]*proto.Value, width)}
+		}
+		for _, match := range matched {
+			values := make([]*proto.Value, 0, len(row)+width)
+			values = append(values, row...)
+			values = append(values, match...)
+			joined = append(joined, values)
+		}
+	}
+	return joined
+}
+
+func joinKey(row []*proto.Value, keys []int) (string, bool) {
+	var sb strings.Builder
+	for _, key := range keys {
+		value := federatedValue(row[key])
+		if value == nil {
+			return "", false
+		}
+		sb.WriteString(keyString(value))
+		sb.WriteByte(0)
+	}
+	return sb.String(), true
+}
+
+// resolveColumn returns the index of the column named name, an unqualified name must be unique among the columns.
+func resolveColumn(columns []*federatedColumn, name *ast.ColumnName) (int, error) {
+	index := -1
+	for i, column := range columns {
+		if name.Table.L != "" && column.table != name.Table.L {
+			continue
+		}
+		if !strings.EqualFold(column.field.Name, name.Name.O) {
+			continue
+		}
+		if index >= 0 {
+			return -1, errors.Errorf("column '%s' is ambiguous", name.Name.O)
+		}
+		index = i
+	}
+	if index < 0 {
+		return -1, errors.Errorf("unknown column '%s'", name.String())
+	}
+	return index, nil
+}
+
+// project computes the result rows from the joined rows, grouped and aggregated, ordered and limited.
+func (p *FederatedQueryPlan) project(columns []*federatedColumn, rows [][]*proto.Value,
+	binary bool) (*mysql.Result, error) {
+	fields, err := p.selectFields(columns)
+	if err != nil {
+		return nil, err
+	}
+
+	aggregated := p.Stmt.GroupBy != nil
+	for _, field := range fields {
+		aggregated = aggregated || field.agg != ""
+	}
+	var result []*federatedRow
+	if aggregated {
+		if result, err = p.aggregate(columns, fields, rows, binary); err != nil {
+			return nil, err
+		}
+	} else {
+		result = make([]*federatedRow, 0, len(rows))
+		for _, row := range rows {
+			values := make([]*proto.Value, len(fields))
+			for i, field := range fields {
+				values[i] = row[field.column]
+			}
+			result = append(result, &federatedRow{values: values, first: row})
+		}
+	}
+
+	if err = p.orderBy(columns, fields, result); err != nil {
+		return nil, err
+	}
+	if result, err = p.limit(result); err != nil {
+		return nil, err
+	}
+
+	resultFields := make([]*mysql.Field, 0, len(fields))
+	for _, field := range fields {
+		resultFields = append(resultFields, field.field)
+	}
+	resultRows := make([]proto.Row, 0, len(result))
+	for _, row := range result {
+		if binary {
+			resultRows = append(resultRows, mysql.NewBinaryRow(resultFields, row.values))
+		} else {
+			resultRows = append(resultRows, mysql.NewTextRow(resultFields, row.values))
+		}
+	}
+	return &mysql.Result{Fields: resultFields, Rows: resultRows}, nil
+}
+
+// selectFields expands the wildcards and resolves the columns of the select fields.
+func (p *FederatedQueryPlan) selectFields(columns []*federatedColumn) ([]*federatedField, error) {
+	var fields []*federatedField
+	for _, selectField := range p.Stmt.Fields.Fields {
+		if selectField.WildCard != nil {
+			table := selectField.WildCard.Table.L
+			for i, column := range columns {
+				if table == "" || column.table == table {
+					fields = append(fields, &federatedField{
+						field:  column.field,
+						text:   column.table + "." + strings.ToLower(column.field.Name),
+						column: i,
+					})
+				}
+			}
+			continue
+		}
+
+		text, err := restore(selectField.Expr)
+		if err != nil {
+			return nil, err
+		}
+		field := &federatedField{text: strings.ToLower(text), alias: selectField.AsName.L}
+		name := selectField.AsName.O
+		switch expr := selectField.Expr.(type) {
+		case *ast.ColumnNameExpr:
+			if field.column, err = resolveColumn(columns, expr.Name); err != nil {
+				return nil, err
+			}
+			if name == "" {
+				name = columns[field.column].field.Name
+			}
+			f := *columns[field.column].field
+			f.Name = name
+			field.field = &f
+		case *ast.AggregateFuncExpr:
+			if name == "" {
+				name = selectField.Text()
+			}
+			if err = resolveAggregate(columns, field, expr, name); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, errors.Errorf("select field %s is not supported in a query across datasources, "+
+				"only columns and aggregates of a column are", text)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// resolveAggregate resolves the argument of an aggregate and the field of its result, SUM and AVG
+// return DOUBLE for approximate values and DECIMAL for the others as MySQL does.
+func resolveAggregate(columns []*federatedColumn, field *federatedField, expr *ast.AggregateFuncExpr,
+	name string) error {
+	field.agg = strings.ToLower(expr.F)
+	if expr.Distinct || len(expr.Args) != 1 {
+		return errors.Errorf("aggregate %s is not supported in a query across datasources", field.text)
+	}
+	field.column = -1
+	switch arg := expr.Args[0].(type) {
+	case *ast.ColumnNameExpr:
+		column, err := resolveColumn(columns, arg.Name)
+		if err != nil {
+			return err
+		}
+		field.column = column
+	case ast.ValueExpr:
+		// COUNT(*) and COUNT(1)
+		if field.agg != ast.AggFuncCount {
+			return errors.Errorf("aggregate %s is not supported in a query across datasources", field.text)
+		}
+	default:
+		return errors.Errorf("aggregate %s is not supported in a query across datasources", field.text)
+	}
+
+	switch field.agg {
+	case ast.AggFuncCount:
+		field.field = &mysql.Field{
+			Name:         name,
+			FieldType:    constant.FieldTypeLongLong,
+			CharSet:      constant.CharacterSetBinary,
+			ColumnLength: 21,
+		}
+	case ast.AggFuncMin, ast.AggFuncMax:
+		f := *columns[field.column].field
+		f.Name = name
+		field.field = &f
+	case ast.AggFuncSum, ast.AggFuncAvg:
+		arg := columns[field.column].field
+		f := &mysql.Field{Name: name, CharSet: constant.CharacterSetBinary, ColumnLength: arg.ColumnLength}
+		switch arg.FieldType {
+		case constant.FieldTypeFloat, constant.FieldTypeDouble:
+			f.FieldType = constant.FieldTypeDouble
+			f.Decimals = arg.Decimals
+		default:
+			f.FieldType = constant.FieldTypeNewDecimal
+			f.Decimals = arg.Decimals
+			if field.agg == ast.AggFuncAvg {
+				// avg increases the scale by div_precision_increment
+				frac := int(arg.Decimals) + types.DivFracIncr
+				if frac > parsermysql.MaxDecimalScale {
+					frac = parsermysql.MaxDecimalScale
+				}
+				f.Decimals = byte(frac)
+				f.ColumnLength += types.DivFracIncr
+			}
+		}
+		field.field = f
+	default:
+		return errors.Errorf("aggregate %s is not supported in a query across datasources", field.text)
+	}
+	return nil
+}
+
+// aggregate groups the joined rows by the GROUP BY columns, in the order the groups are met, and computes a
+// result row per group. Without GROUP BY, all the rows make a single group, even if there are none.
+func (p *FederatedQueryPlan) aggregate(columns []*federatedColumn, fields []*federatedField,
+	rows [][]*proto.Value, binary bool) ([]*federatedRow, error) {
+	var groupBy []int
+	if p.Stmt.GroupBy != nil {
+		for _, item := range p.Stmt.GroupBy.Items {
+			output, column, err := resolveByItem(item.Expr, columns, fields)
+			if err != nil {
+				return nil, err
+			}
+			if output >= 0 {
+				if fields[output].agg != "" {
+					return nil, errors.Errorf("can't group on '%s'", fields[output].field.Name)
+				}
+				column = fields[output].column
+			}
+			groupBy = append(groupBy, column)
+		}
+	}
+
+	var (
+		keys   []string
+		groups = make(map[string][][]*proto.Value)
+	)
+	for _, row := range rows {
+		var sb strings.Builder
+		for _, column := range groupBy {
+			if value := federatedValue(row[column]); value != nil {
+				sb.WriteByte(1)
+				sb.WriteString(keyString(value))
+			}
+			sb.WriteByte(0)
+		}
+		key := sb.String()
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], row)
+	}
+	if len(keys) == 0 && p.Stmt.GroupBy == nil {
+		keys = append(keys, "")
+	}
+
+	result := make([]*federatedRow, 0, len(keys))
+	for _, key := range keys {
+		group := groups[key]
+		row := &federatedRow{values: make([]*proto.Value, len(fields))}
+		if len(group) > 0 {
+			row.first = group[0]
+		}
+		for i, field := range fields {
+			if field.agg == "" {
+				if row.first != nil {
+					row.values[i] = row.first[field.column]
+				}
+				continue
+			}
+			value, err := aggregateField(field, group, binary)
+			if err != nil {
+				return nil, err
+			}
+			row.values[i] = value
+		}
+		result = append(result, row)
+	}
+	return result, nil
+}
+
+func aggregateField(field *federatedField, rows [][]*proto.Value, binary bool) (*proto.Value, error) {
+	var count int64
+	switch field.agg {
+	case ast.AggFuncCount:
+		for _, row := range rows {
+			if field.column < 0 || (row[field.column] != nil && row[field.column].Val != nil) {
+				count++
+			}
+		}
+		return newFederatedValue(field.field, count, binary), nil
+	case ast.AggFuncMin, ast.AggFuncMax:
+		var best *proto.Value
+		for _, row := range rows {
+			value := row[field.column]
+			if value == nil || value.Val == nil {
+				continue
+			}
+			if best == nil {
+				best = value
+				continue
+			}
+			c := compareValues(federatedValue(value), federatedValue(best))
+			if (field.agg == ast.AggFuncMin && c < 0) || (field.agg == ast.AggFuncMax && c > 0) {
+				best = value
+			}
+		}
+		return best, nil
+	}
+
+	// SUM and AVG
+	if field.field.FieldType == constant.FieldTypeDouble {
+		var sum float64
+		for _, row := range rows {
+			value := row[field.column]
+			if value == nil || value.Val == nil {
+				continue
+			}
+			f, err := castValueToFloat64(value)
+			if err != nil {
+				return nil, err
+			}
+			sum += f
+			count++
+		}
+		if count == 0 {
+			return nil, nil
+		}
+		if field.agg == ast.AggFuncAvg {
+			sum /= float64(count)
+		}
+		return newFederatedValue(field.field, sum, binary), nil
+	}
+
+	sum := new(types.MyDecimal)
+	for _, row := range rows {
+		value := row[field.column]
+		if value == nil || value.Val == nil {
+			continue
+		}
+		dec, err := CastValueToDecimal(value)
+		if err != nil {
+			return nil, err
+		}
+		if err = types.DecimalAdd(sum, dec, sum); err != nil {
+			return nil, errors.Wrap(err, "calculate sum failed")
+		}
+		count++
+	}
+	if count == 0 {
+		return nil, nil
+	}
+	if field.agg == ast.AggFuncAvg {
+		avg := new(types.MyDecimal)
+		if err := types.DecimalDiv(sum, types.NewDecFromInt(count), avg, types.DivFracIncr); err != nil {
+			return nil, errors.Wrap(err, "calculate avg failed")
+		}
+		if err := avg.Round(avg, int(field.field.Decimals), types.ModeHalfEven); err != nil {
+			return nil, errors.Wrap(err, "round avg failed")
+		}
+		sum = avg
+	}
+	return newFederatedValue(field.field, sum, binary), nil
+}
+
+// resolveByItem resolves an ORDER BY or GROUP BY item to the index of a select field, by position, alias or the
+// same expression, or else to the index of a joined column, the unused index is -1.
+func resolveByItem(expr ast.ExprNode, columns []*federatedColumn, fields []*federatedField) (int, int, error) {
+	switch e := expr.(type) {
+	case *ast.PositionExpr:
+		if e.P != nil || e.N < 1 || e.N > len(fields) {
+			return -1, -1, errors.Errorf("unknown column '%d'", e.N)
+		}
+		return e.N - 1, -1, nil
+	case *ast.ColumnNameExpr:
+		if e.Name.Table.L == "" {
+			for i, field := range fields {
+				if field.alias != "" && field.alias == e.Name.Name.L {
+					return i, -1, nil
+				}
+			}
+		}
+		column, err := resolveColumn(columns, e.Name)
+		return -1, column, err
+	}
+	text, err := restore(expr)
+	if err != nil {
+		return -1, -1, err
+	}
+	for i, field := range fields {
+		if field.text == strings.ToLower(text) {
+			return i, -1, nil
+		}
+	}
+	return -1, -1, errors.Errorf("%s is not supported in a query across datasources, "+
+		"only select fields and columns are", text)
+}
+
+func (p *FederatedQueryPlan) orderBy(columns []*federatedColumn, fields []*federatedField,
+	rows []*federatedRow) error {
+	if p.Stmt.OrderBy == nil {
+		return nil
+	}
+	items := p.Stmt.OrderBy.Items
+	for i, item := range items {
+		output, column, err := resolveByItem(item.Expr, columns, fields)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if i == 0 {
+				row.keys = make([]interface{}, len(items))
+			}
+			switch {
+			case output >= 0:
+				row.keys[i] = federatedValue(row.values[output])
+			case row.first != nil:
+				row.keys[i] = federatedValue(row.first[column])
+			}
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		for k, item := range items {
+			c := compareValues(rows[i].keys[k], rows[j].keys[k])
+			if item.Desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+	return nil
+}
+
+func (p *FederatedQueryPlan) limit(rows []*federatedRow) ([]*federatedRow, error) {
+	if p.Stmt.Limit == nil {
+		return rows, nil
+	}
+	offset, err := p.limitValue(p.Stmt.Limit.Offset)
+	if err != nil {
+		return nil, err
+	}
+	count, err := p.limitValue(p.Stmt.Limit.Count)
+	if err != nil {
+		return nil, err
+	}
+	if offset >= int64(len(rows)) {
+		return rows[:0], nil
+	}
+	end := int64(len(rows))
+	if offset+count < end {
+		end = offset + count
+	}
+	return rows[offset:end], nil
+}
+
+func (p *FederatedQueryPlan) limitValue(expr ast.ExprNode) (int64, error) {
+	switch e := expr.(type) {
+	case nil:
+		return 0, nil
+	case *driver.ParamMarkerExpr:
+		if e.Order >= len(p.Args) {
+			return 0, errors.Errorf("missing arg of parameter marker %d", e.Order)
+		}
+		n, err := strconv.ParseInt(fmt.Sprint(p.Args[e.Order]), 10, 64)
+		return n, errors.Wrap(err, "parse limit failed")
+	case *driver.ValueExpr:
+		return e.GetInt64(), nil
+	default:
+		return 0, errors.Errorf("unsupported limit %T", expr)
+	}
+}
+
+// federatedValue returns the value compared, grouped and joined on: integers and decimals of both protocols
+// are *types.MyDecimal, approximate values float64, temporal values time.Time if they are parsed and the others
+// strings, so that the values of columns of different types in different clusters can be compared.
+func federatedValue(value *proto.Value) interface{} {
+	if value == nil || value.Val == nil {
+		return nil
+	}
+	switch value.Typ {
+	case constant.FieldTypeTiny, constant.FieldTypeUint8, constant.FieldTypeShort, constant.FieldTypeUint16,
+		constant.FieldTypeInt24, constant.FieldTypeUint24, constant.FieldTypeLong, constant.FieldTypeUint32,
+		constant.FieldTypeLongLong, constant.FieldTypeUint64, constant.FieldTypeYear,
+		constant.FieldTypeDecimal, constant.FieldTypeNewDecimal:
+		if dec, err := CastValueToDecimal(value); err == nil {
+			return dec
+		}
+	case constant.FieldTypeFloat, constant.FieldTypeDouble:
+		if f, err := castValueToFloat64(value); err == nil {
+			return f
+		}
+	}
+	switch v := value.Val.(type) {
+	case []byte:
+		return string(v)
+	case string, time.Time:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func castValueToFloat64(value *proto.Value) (float64, error) {
+	switch v := value.Val.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	default:
+		f, err := strconv.ParseFloat(fmt.Sprintf("%s", v), 64)
+		return f, errors.Wrapf(err, "cast %s to double failed", v)
+	}
+}
+
+// keyString formats a value of federatedValue, equal values have the same key.
+func keyString(value interface{}) string {
+	switch v := value.(type) {
+	case *types.MyDecimal:
+		s := v.String()
+		if strings.Contains(s, ".") {
+			s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+		}
+		return s
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case time.Time:
+		return v.Format("2006-01-02 15:04:05.999999")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// compareValues compares values of federatedValue, values of different types are compared by their keys.
+func compareValues(a, b interface{}) int {
+	if a != nil && b != nil && reflect.TypeOf(a) != reflect.TypeOf(b) {
+		return strings.Compare(keyString(a), keyString(b))
+	}
+	return compare(a, b)
+}
+
+// newFederatedValue creates the value of an aggregate, text values are strings, as writeValueToRow writes them.
+func newFederatedValue(field *mysql.Field, value interface{}, binary bool) *proto.Value {
+	var (
+		val interface{}
+		raw []byte
+	)
+	switch v := value.(type) {
+	case int64:
+		val, raw = v, strconv.AppendInt(nil, v, 10)
+	case float64:
+		val, raw = v, strconv.AppendFloat(nil, v, 'g', -1, 64)
+	case *types.MyDecimal:
+		raw = v.ToString()
+		val = raw
+	}
+	if !binary {
+		val = raw
+	}
+	return &proto.Value{Typ: field.FieldType, Flags: field.Flags, Len: len(raw), Val: val, Raw: raw}
+}
@@ -0,0 +1,239 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/visitor"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+	_ "github.com/cectc/dbpack/third_party/types/parser_driver"
+)
+
+// sourceExecutor is a db group answering every query with result.
+type sourceExecutor struct {
+	replicaExecutor
+	result *mysql.Result
+}
+
+func (e *sourceExecutor) Query(ctx context.Context, query string) (proto.Result, uint16, error) {
+	e.queries = append(e.queries, query)
+	return e.result, 0, nil
+}
+
+func (e *sourceExecutor) PrepareQuery(ctx context.Context, query string, args ...interface{}) (proto.Result, uint16, error) {
+	e.queries = append(e.queries, query)
+	return e.result, 0, nil
+}
+
+// textResult creates a text protocol result, the columns named with an "id" suffix are integers, nil values are NULL.
+func textResult(columns []string, rows ...[]interface{}) *mysql.Result {
+	fields := make([]*mysql.Field, 0, len(columns))
+	for _, column := range columns {
+		field := &mysql.Field{Name: column, FieldType: constant.FieldTypeVarString}
+		if column == "id" || len(column) > 3 && column[len(column)-3:] == "_id" {
+			field.FieldType = constant.FieldTypeLongLong
+		}
+		if column == "amount" {
+			field.FieldType = constant.FieldTypeNewDecimal
+			field.Decimals = 2
+		}
+		fields = append(fields, field)
+	}
+	result := &mysql.Result{Fields: fields}
+	for _, row := range rows {
+		values := make([]*proto.Value, len(row))
+		for i, value := range row {
+			if value != nil {
+				raw := []byte(fmt.Sprint(value))
+				values[i] = &proto.Value{Typ: fields[i].FieldType, Len: len(raw), Val: raw, Raw: raw}
+			}
+		}
+		result.Rows = append(result.Rows, mysql.NewTextRow(fields, values))
+	}
+	return result
+}
+
+// resultStrings returns the names of the fields and the rows of result formatted as strings, NULL is "NULL".
+func resultStrings(t *testing.T, result proto.Result) ([]string, [][]string) {
+	rlt := result.(*mysql.Result)
+	names := make([]string, 0, len(rlt.Fields))
+	for _, field := range rlt.Fields {
+		names = append(names, field.Name)
+	}
+	rows := make([][]string, 0, len(rlt.Rows))
+	for _, row := range rlt.Rows {
+		values, err := row.Decode()
+		assert.NoError(t, err)
+		strs := make([]string, 0, len(values))
+		for _, value := range values {
+			switch {
+			case value == nil || value.Val == nil:
+				strs = append(strs, "NULL")
+			default:
+				if b, ok := value.Val.([]byte); ok {
+					strs = append(strs, string(b))
+				} else {
+					strs = append(strs, fmt.Sprint(value.Val))
+				}
+			}
+		}
+		rows = append(rows, strs)
+	}
+	return names, rows
+}
+
+func newFederatedTestPlan(t *testing.T, sql string, args ...interface{}) (*FederatedQueryPlan, *sourceExecutor, *sourceExecutor) {
+	orders := &sourceExecutor{
+		replicaExecutor: replicaExecutor{name: "orders_cluster"},
+		result: textResult([]string{"id", "customer_id", "amount"},
+			[]interface{}{1, 10, "5.50"},
+			[]interface{}{2, 20, "3.00"},
+			[]interface{}{3, 10, "1.25"},
+			[]interface{}{4, 30, "7.00"},
+			[]interface{}{5, nil, "2.00"}),
+	}
+	customers := &sourceExecutor{
+		replicaExecutor: replicaExecutor{name: "customers_cluster"},
+		result: textResult([]string{"id", "name"},
+			[]interface{}{10, "alice"},
+			[]interface{}{20, "bob"},
+			[]interface{}{40, "carol"}),
+	}
+	stmt := parseStmt(t, sql)
+	// the listener orders the parameter markers of prepared statements
+	stmt.Accept(&visitor.ParamVisitor{})
+	p, err := NewFederatedQueryPlan(stmt.(*ast.SelectStmt), args, map[string]*Replicas{
+		"orders":    NewReplicas([]proto.DBGroupExecutor{orders}),
+		"customers": NewReplicas([]proto.DBGroupExecutor{customers}),
+	})
+	assert.NoError(t, err)
+	return p, orders, customers
+}
+
+func TestNewFederatedQueryPlan(t *testing.T) {
+	p, _, _ := newFederatedTestPlan(t, "select o.id, c.name from orders o join customers c "+
+		"on o.customer_id = c.id where o.amount > ? and c.name like ? and (o.id < 10 or o.id > 20)", 1, "a%")
+	assert.Len(t, p.Sources, 2)
+	assert.Equal(t, "SELECT * FROM `orders` AS `o` WHERE (`o`.`amount`>?) AND "+
+		"(`o`.`id`<10 OR `o`.`id`>20)", p.Sources[0].SQL)
+	assert.Equal(t, []interface{}{1}, p.Sources[0].Args)
+	assert.Equal(t, "SELECT * FROM `customers` AS `c` WHERE (`c`.`name` LIKE ?)", p.Sources[1].SQL)
+	assert.Equal(t, []interface{}{"a%"}, p.Sources[1].Args)
+	assert.Len(t, p.Sources[1].Keys, 1)
+	assert.Equal(t, "o.customer_id", p.Sources[1].Keys[0].Outer.String())
+	assert.Equal(t, "c.id", p.Sources[1].Keys[0].Inner.String())
+
+	p, _, _ = newFederatedTestPlan(t, "select * from orders, customers where customers.id = orders.customer_id")
+	assert.Equal(t, "SELECT * FROM `orders`", p.Sources[0].SQL)
+	assert.Equal(t, "orders.customer_id", p.Sources[1].Keys[0].Outer.String())
+}
+
+func TestNewFederatedQueryPlanUnsupported(t *testing.T) {
+	replicas := map[string]*Replicas{
+		"orders":    NewReplicas([]proto.DBGroupExecutor{&replicaExecutor{name: "orders_cluster"}}),
+		"customers": NewReplicas([]proto.DBGroupExecutor{&replicaExecutor{name: "customers_cluster"}}),
+	}
+	testCases := []struct {
+		sql    string
+		expect string
+	}{
+		{
+			sql:    "select * from orders o join customers c on o.customer_id = c.id where amount > 1",
+			expect: "column amount must be qualified by its table in a query across datasources",
+		},
+		{
+			sql: "select * from orders o join customers c on o.customer_id = c.id or o.id = c.id",
+			expect: "the condition `o`.`customer_id`=`c`.`id` OR `o`.`id`=`c`.`id` across datasources is not " +
+				"supported, only equalities of columns can join tables",
+		},
+		{
+			sql: "select * from orders o left join customers c on o.customer_id = c.id where c.name = 'bob'",
+			expect: "the condition `c`.`name`='bob' on the right table of a left join across datasources " +
+				"is not supported",
+		},
+		{
+			sql:    "select * from orders o join customers c on o.customer_id = c.id where o.id in (select 1)",
+			expect: "subqueries are not supported in a query across datasources",
+		},
+		{
+			sql:    "select * from orders o right join customers c on o.customer_id = c.id",
+			expect: "RIGHT JOIN is not supported in a query across datasources",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.sql, func(t *testing.T) {
+			_, err := NewFederatedQueryPlan(parseStmt(t, tc.sql).(*ast.SelectStmt), nil, replicas)
+			assert.EqualError(t, err, tc.expect)
+		})
+	}
+}
+
+func TestFederatedQueryPlanJoin(t *testing.T) {
+	ctx := proto.WithCommandType(context.Background(), constant.ComQuery)
+	p, orders, customers := newFederatedTestPlan(t, "select o.id, c.name as customer from orders o "+
+		"left join customers c on o.customer_id = c.id order by c.name desc, o.id limit 1, 3")
+	result, _, err := p.Execute(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"SELECT * FROM `orders` AS `o`"}, orders.queries)
+	assert.Equal(t, []string{"SELECT * FROM `customers` AS `c`"}, customers.queries)
+	names, rows := resultStrings(t, result)
+	assert.Equal(t, []string{"id", "customer"}, names)
+	assert.Equal(t, [][]string{{"1", "alice"}, {"3", "alice"}, {"4", "NULL"}}, rows)
+
+	p, _, _ = newFederatedTestPlan(t, "select * from orders o join customers c on o.customer_id = c.id "+
+		"order by o.id")
+	result, _, err = p.Execute(ctx)
+	assert.NoError(t, err)
+	names, rows = resultStrings(t, result)
+	assert.Equal(t, []string{"id", "customer_id", "amount", "id", "name"}, names)
+	assert.Equal(t, [][]string{
+		{"1", "10", "5.50", "10", "alice"},
+		{"2", "20", "3.00", "20", "bob"},
+		{"3", "10", "1.25", "10", "alice"},
+	}, rows)
+}
+
+func TestFederatedQueryPlanAggregate(t *testing.T) {
+	p, _, _ := newFederatedTestPlan(t, "select c.name, count(*) as orders, sum(o.amount), avg(o.amount), "+
+		"max(o.id) from orders o join customers c on o.customer_id = c.id group by c.name order by orders desc")
+	result, _, err := p.Execute(proto.WithCommandType(context.Background(), constant.ComQuery))
+	assert.NoError(t, err)
+	names, rows := resultStrings(t, result)
+	assert.Equal(t, []string{"name", "orders", "sum(o.amount)", "avg(o.amount)", "max(o.id)"}, names)
+	assert.Equal(t, [][]string{
+		{"alice", "2", "6.75", "3.375000", "3"},
+		{"bob", "1", "3.00", "3.000000", "2"},
+	}, rows)
+
+	// the aggregates of no rows
+	p, _, _ = newFederatedTestPlan(t, "select count(o.id), sum(o.amount) from orders o "+
+		"join customers c on o.amount = c.id")
+	result, _, err = p.Execute(proto.WithCommandType(context.Background(), constant.ComStmtExecute))
+	assert.NoError(t, err)
+	_, rows = resultStrings(t, result)
+	assert.Equal(t, [][]string{{"0", "NULL"}}, rows)
+	values, _ := result.(*mysql.Result).Rows[0].Decode()
+	assert.Equal(t, int64(0), values[0].Val)
+}
@@ -24,6 +24,7 @@ import (
 
 	"github.com/cectc/dbpack/pkg/constant"
 	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/mysql"
 	"github.com/cectc/dbpack/pkg/proto"
 	"github.com/cectc/dbpack/third_party/parser/ast"
 	"github.com/cectc/dbpack/third_party/parser/format"
@@ -36,9 +37,20 @@ type InsertPlan struct {
 	Stmt     *ast.InsertStmt
 	Args     []interface{}
 	Executor proto.DBGroupExecutor
+	// GeneratedID is the primary key generated by dbpack, it's reported as the last insert id
+	GeneratedID int64
 }
 
 func (p *InsertPlan) Execute(ctx context.Context, _ ...*ast.TableOptimizerHint) (proto.Result, uint16, error) {
+	result, warns, err := p.execute(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	p.setInsertID(result)
+	return result, warns, nil
+}
+
+func (p *InsertPlan) execute(ctx context.Context) (proto.Result, uint16, error) {
 	var (
 		sb  strings.Builder
 		tx  proto.Tx
@@ -77,10 +89,33 @@ func (p *InsertPlan) Execute(ctx context.Context, _ ...*ast.TableOptimizerHint)
 	}
 }
 
+// setInsertID reports the generated primary key as the last insert id, the same as an auto increment
+// column on MySQL: a row updated by ON DUPLICATE KEY UPDATE, reported with 2 affected rows, or an
+// ignored row, reported with 0 affected rows, has no insert id.
+func (p *InsertPlan) setInsertID(result proto.Result) {
+	if p.GeneratedID == 0 {
+		return
+	}
+	rlt, ok := result.(*mysql.Result)
+	if !ok {
+		return
+	}
+	if rlt.AffectedRows == 1 || (p.Stmt.IsReplace && rlt.AffectedRows > 0) {
+		rlt.InsertId = uint64(p.GeneratedID)
+	}
+}
+
 func (p *InsertPlan) generate(sb *strings.Builder) (err error) {
 	ctx := format.NewRestoreCtx(constant.DBPackRestoreFormat, sb)
 
-	ctx.WriteKeyWord("INSERT ")
+	if p.Stmt.IsReplace {
+		ctx.WriteKeyWord("REPLACE ")
+	} else {
+		ctx.WriteKeyWord("INSERT ")
+	}
+	if p.Stmt.IgnoreErr {
+		ctx.WriteKeyWord("IGNORE ")
+	}
 	ctx.WriteKeyWord("INTO ")
 
 	ctx.WritePlain(p.Table)
@@ -113,5 +148,17 @@ func (p *InsertPlan) generate(sb *strings.Builder) (err error) {
 			ctx.WritePlain(")")
 		}
 	}
+
+	if len(p.Stmt.OnDuplicate) > 0 {
+		ctx.WriteKeyWord(" ON DUPLICATE KEY UPDATE ")
+		for i, assignment := range p.Stmt.OnDuplicate {
+			if i != 0 {
+				ctx.WritePlain(",")
+			}
+			if err := assignment.Restore(ctx); err != nil {
+				return errors.Wrapf(err, "An error occurred while restoring InsertStmt.OnDuplicate[%d]", i)
+			}
+		}
+	}
 	return nil
 }
@@ -22,6 +22,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/cectc/dbpack/pkg/mysql"
 	"github.com/cectc/dbpack/pkg/visitor"
 	"github.com/cectc/dbpack/third_party/parser"
 	"github.com/cectc/dbpack/third_party/parser/ast"
@@ -38,6 +39,21 @@ func TestInsertPlan(t *testing.T) {
 			table:               "student_5",
 			expectedGenerateSql: "INSERT INTO student_5(id,name,gender,age) VALUES (?,?,?,?)",
 		},
+		{
+			insertSql:           "replace into student(id, name, gender, age) values(?,?,?,?)",
+			table:               "student_5",
+			expectedGenerateSql: "REPLACE INTO student_5(id,name,gender,age) VALUES (?,?,?,?)",
+		},
+		{
+			insertSql:           "insert ignore into student(id, name, gender, age) values(?,?,?,?)",
+			table:               "student_5",
+			expectedGenerateSql: "INSERT IGNORE INTO student_5(id,name,gender,age) VALUES (?,?,?,?)",
+		},
+		{
+			insertSql:           "insert into student(id, name, gender, age) values(?,?,?,?) on duplicate key update age = values(age), name = ?",
+			table:               "student_5",
+			expectedGenerateSql: "INSERT INTO student_5(id,name,gender,age) VALUES (?,?,?,?) ON DUPLICATE KEY UPDATE `age`=VALUES(`age`),`name`=?",
+		},
 	}
 
 	for _, c := range testCases {
@@ -65,3 +81,31 @@ func TestInsertPlan(t *testing.T) {
 		})
 	}
 }
+
+func TestInsertPlanSetInsertID(t *testing.T) {
+	testCases := []struct {
+		insertSql        string
+		affectedRows     uint64
+		expectedInsertID uint64
+	}{
+		{"insert into student(name) values(?)", 1, 100},
+		{"insert ignore into student(name) values(?)", 0, 0},
+		{"insert into student(name) values(?) on duplicate key update name = values(name)", 2, 0},
+		{"replace into student(name) values(?)", 2, 100},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.insertSql, func(t *testing.T) {
+			p := parser.New()
+			stmt, err := p.ParseOneStmt(c.insertSql, "", "")
+			assert.Nil(t, err)
+			plan := &InsertPlan{
+				Stmt:        stmt.(*ast.InsertStmt),
+				GeneratedID: 100,
+			}
+			result := &mysql.Result{AffectedRows: c.affectedRows}
+			plan.setInsertID(result)
+			assert.Equal(t, c.expectedInsertID, result.InsertId)
+		})
+	}
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+	"github.com/cectc/dbpack/third_party/parser/model"
+	"github.com/cectc/dbpack/third_party/parser/opcode"
+)
+
+// LimitPlan executes an UPDATE or DELETE with ORDER BY and LIMIT across shards. The primary keys of the rows to
+// modify are selected first, ordered and limited globally, then the statement is routed again by these keys.
+type LimitPlan struct {
+	PK string
+	// Select selects the primary keys of the candidate rows, the primary key must be the first column
+	Select proto.Plan
+	// Route generates the plan which modifies the rows matched by the given primary key condition
+	Route func(ctx context.Context, keyCondition ast.ExprNode) (proto.Plan, error)
+}
+
+func (p *LimitPlan) Execute(ctx context.Context, _ ...*ast.TableOptimizerHint) (proto.Result, uint16, error) {
+	result, _, err := p.Select.Execute(ctx)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to select primary keys of candidate rows")
+	}
+	rlt, ok := result.(*mysql.Result)
+	if !ok {
+		return nil, 0, errors.Errorf("unexpected result type %T", result)
+	}
+	keys := make([]ast.ExprNode, 0, len(rlt.Rows))
+	for _, row := range rlt.Rows {
+		values, err := row.Decode()
+		if err != nil {
+			return nil, 0, err
+		}
+		if values[0] == nil || values[0].Val == nil {
+			continue
+		}
+		keys = append(keys, ast.NewValueExpr(values[0].Val, "", ""))
+	}
+	if len(keys) == 0 {
+		return &mysql.Result{AffectedRows: 0}, 0, nil
+	}
+
+	keyCondition := &ast.PatternInExpr{
+		Expr: &ast.ColumnNameExpr{Name: &ast.ColumnName{Name: model.NewCIStr(p.PK)}},
+		List: keys,
+	}
+	plan, err := p.Route(ctx, keyCondition)
+	if err != nil {
+		return nil, 0, err
+	}
+	return plan.Execute(ctx)
+}
+
+// AppendKeyCondition returns where AND keyCondition, the original condition is kept so that rows changed
+// after being selected are not modified.
+func AppendKeyCondition(where, keyCondition ast.ExprNode) ast.ExprNode {
+	if where == nil {
+		return keyCondition
+	}
+	return &ast.BinaryOperationExpr{
+		Op: opcode.LogicAnd,
+		L:  &ast.ParenthesesExpr{Expr: where},
+		R:  keyCondition,
+	}
+}
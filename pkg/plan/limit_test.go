@@ -0,0 +1,74 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+	"github.com/cectc/dbpack/third_party/parser/format"
+	_ "github.com/cectc/dbpack/third_party/types/parser_driver"
+)
+
+type resultPlan struct {
+	result proto.Result
+}
+
+func (p *resultPlan) Execute(ctx context.Context, _ ...*ast.TableOptimizerHint) (proto.Result, uint16, error) {
+	return p.result, 0, nil
+}
+
+func TestLimitPlan(t *testing.T) {
+	fields := []*mysql.Field{{Name: "id", FieldType: constant.FieldTypeLongLong}}
+	rows := []proto.Row{
+		mysql.NewTextRow(fields, []*proto.Value{{Typ: constant.FieldTypeLongLong, Val: int64(3)}}),
+		mysql.NewTextRow(fields, []*proto.Value{{Typ: constant.FieldTypeLongLong, Val: int64(15)}}),
+	}
+
+	var keyCondition string
+	limitPlan := &LimitPlan{
+		PK:     "id",
+		Select: &resultPlan{result: &mysql.Result{Fields: fields, Rows: rows}},
+		Route: func(ctx context.Context, condition ast.ExprNode) (proto.Plan, error) {
+			var sb strings.Builder
+			err := condition.Restore(format.NewRestoreCtx(constant.DBPackRestoreFormat, &sb))
+			assert.Nil(t, err)
+			keyCondition = sb.String()
+			return &resultPlan{result: &mysql.Result{AffectedRows: 2}}, nil
+		},
+	}
+	result, _, err := limitPlan.Execute(context.Background())
+	assert.Nil(t, err)
+	affected, _ := result.RowsAffected()
+	assert.Equal(t, uint64(2), affected)
+	assert.Equal(t, "`id` IN (3,15)", keyCondition)
+
+	limitPlan.Select = &resultPlan{result: &mysql.Result{Fields: fields}}
+	keyCondition = ""
+	result, _, err = limitPlan.Execute(context.Background())
+	assert.Nil(t, err)
+	affected, _ = result.RowsAffected()
+	assert.Equal(t, uint64(0), affected)
+	assert.Equal(t, "", keyCondition)
+}
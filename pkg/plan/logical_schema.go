@@ -0,0 +1,311 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap/tidb/util/stringutil"
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+)
+
+// summedStatistics are the statistics of the physical tables added up for their logical table.
+var summedStatistics = map[string]bool{
+	"TABLE_ROWS":   true,
+	"ROWS":         true,
+	"DATA_LENGTH":  true,
+	"INDEX_LENGTH": true,
+	"DATA_FREE":    true,
+	"CARDINALITY":  true,
+}
+
+// maxStatistics are the statistics of the physical tables whose max is that of their logical table.
+var maxStatistics = map[string]bool{
+	"AUTO_INCREMENT": true,
+	"UPDATE_TIME":    true,
+}
+
+// keptStatistics are the statistics of the first physical table kept for their logical table, the
+// average row length is computed again from the merged rows and length if they are selected.
+var keptStatistics = map[string]bool{
+	"AVG_ROW_LENGTH":  true,
+	"MAX_DATA_LENGTH": true,
+	"CREATE_TIME":     true,
+	"CHECK_TIME":      true,
+	"CHECKSUM":        true,
+}
+
+// LogicalSchemaPlan answers the statements introspecting the schema, SHOW TABLES, SHOW TABLE STATUS,
+// SHOW COLUMNS, SHOW INDEX and the queries of information_schema, with the logical tables in place
+// of the physical tables they are sharded into. The statement is run on every executor, the physical
+// table names are renamed after their logical tables in the result and the rows of the physical
+// tables of a logical table are merged, adding up their rows and lengths. The rows of the other
+// tables, such as the global tables found in every db group, are only kept once.
+type LogicalSchemaPlan struct {
+	Stmt      ast.StmtNode
+	Args      []interface{}
+	Executors []proto.DBGroupExecutor
+	// LogicalTables maps the lower case names of the physical tables to their logical tables
+	LogicalTables map[string]string
+	// Pattern filters the rows by the LIKE pattern of their logical table name, which the
+	// physical table names may not match, it is taken out of SHOW TABLES and SHOW TABLE STATUS
+	Pattern string
+}
+
+func (p *LogicalSchemaPlan) Execute(ctx context.Context, _ ...*ast.TableOptimizerHint) (proto.Result, uint16, error) {
+	sql, err := restore(p.Stmt)
+	if err != nil {
+		return nil, 0, err
+	}
+	results := make([]*mysql.Result, len(p.Executors))
+	warnings := make([]uint16, len(p.Executors))
+	errs := make([]error, len(p.Executors))
+	var wg sync.WaitGroup
+	for i, executor := range p.Executors {
+		wg.Add(1)
+		go func(i int, executor proto.DBGroupExecutor) {
+			defer wg.Done()
+			log.Debugf("logical schema query, db name: %s, sql: %s", executor.GroupName(), sql)
+			var result proto.Result
+			switch proto.CommandType(ctx) {
+			case constant.ComQuery:
+				result, warnings[i], errs[i] = executor.Query(ctx, sql)
+			case constant.ComStmtExecute:
+				result, warnings[i], errs[i] = executor.PrepareQuery(ctx, sql, p.Args...)
+			default:
+				errs[i] = errors.Errorf("unsupported command type %d", proto.CommandType(ctx))
+			}
+			if errs[i] == nil {
+				results[i] = result.(*mysql.Result)
+			}
+		}(i, executor)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	var warns uint16
+	for _, warn := range warnings {
+		warns += warn
+	}
+	result, err := p.merge(results)
+	if err != nil {
+		return nil, 0, err
+	}
+	return result, warns, nil
+}
+
+// merge renames the physical tables of the results and merges their rows.
+func (p *LogicalSchemaPlan) merge(results []*mysql.Result) (*mysql.Result, error) {
+	merged := &mysql.Result{Fields: results[0].Fields}
+	var (
+		tableColumns []int
+		columns      = make([]string, len(merged.Fields))
+	)
+	for i, field := range merged.Fields {
+		columns[i] = strings.ToUpper(field.Name)
+		if isTableNameColumn(columns[i]) {
+			tableColumns = append(tableColumns, i)
+		}
+	}
+	var (
+		patChars []rune
+		patTypes []byte
+	)
+	if p.Pattern != "" {
+		patChars, patTypes = stringutil.CompilePattern(strings.ToLower(p.Pattern), '\\')
+	}
+
+	var (
+		keys = make(map[string]int)
+		// mergedRows are the indexes of the rows merged from several physical tables
+		mergedRows = make(map[int]bool)
+	)
+	for _, result := range results {
+		for _, row := range result.Rows {
+			values, err := row.Decode()
+			if err != nil {
+				return nil, err
+			}
+			renamed := false
+			for _, i := range tableColumns {
+				if logicalTable, ok := p.LogicalTables[strings.ToLower(valueString(values[i]))]; ok {
+					setBytes(values[i], []byte(logicalTable))
+					renamed = true
+				}
+			}
+			if patChars != nil && len(tableColumns) > 0 &&
+				!stringutil.DoMatch(strings.ToLower(valueString(values[tableColumns[0]])), patChars, patTypes) {
+				continue
+			}
+			key := rowKey(columns, values)
+			index, exists := keys[key]
+			if !exists {
+				keys[key] = len(merged.Rows)
+				merged.Rows = append(merged.Rows, row)
+				continue
+			}
+			if renamed {
+				kept, _ := merged.Rows[index].Decode()
+				mergeStatistics(columns, kept, values)
+				mergedRows[index] = true
+			}
+		}
+	}
+	for index := range mergedRows {
+		values, _ := merged.Rows[index].Decode()
+		averageRowLength(columns, values)
+	}
+	merged.AffectedRows = uint64(len(merged.Rows))
+	return merged, nil
+}
+
+// isTableNameColumn reports whether the upper case column names tables, as the TABLE_NAME columns
+// of information_schema, the Tables_in_ column of SHOW TABLES, the Name column of SHOW TABLE STATUS
+// and the Table column of SHOW INDEX do.
+func isTableNameColumn(column string) bool {
+	switch column {
+	case "TABLE_NAME", "REFERENCED_TABLE_NAME", "NAME", "TABLE":
+		return true
+	}
+	return strings.HasPrefix(column, "TABLES_IN_")
+}
+
+// rowKey identifies a row by its values other than the statistics.
+func rowKey(columns []string, values []*proto.Value) string {
+	var sb strings.Builder
+	for i, value := range values {
+		if summedStatistics[columns[i]] || maxStatistics[columns[i]] || keptStatistics[columns[i]] {
+			continue
+		}
+		if value == nil || value.Val == nil {
+			sb.WriteByte(0)
+			continue
+		}
+		sb.WriteByte(1)
+		sb.WriteString(valueString(value))
+		sb.WriteByte(0)
+	}
+	return sb.String()
+}
+
+// mergeStatistics merges the statistics of the values of a physical table into those kept for its
+// logical table.
+func mergeStatistics(columns []string, kept, values []*proto.Value) {
+	for i, column := range columns {
+		if !summedStatistics[column] && !maxStatistics[column] {
+			continue
+		}
+		if kept[i] == nil || kept[i].Val == nil {
+			kept[i] = values[i]
+			continue
+		}
+		if values[i] == nil || values[i].Val == nil {
+			continue
+		}
+		if at, ok := kept[i].Val.(time.Time); ok {
+			if bt, ok := values[i].Val.(time.Time); ok && bt.After(at) {
+				kept[i] = values[i]
+			}
+			continue
+		}
+		a, aok := uintValue(kept[i])
+		b, bok := uintValue(values[i])
+		if !aok || !bok {
+			continue
+		}
+		if summedStatistics[column] {
+			setUint(kept[i], a+b)
+		} else if b > a {
+			setUint(kept[i], b)
+		}
+	}
+}
+
+// averageRowLength computes the average row length of a logical table from its merged rows and length.
+func averageRowLength(columns []string, values []*proto.Value) {
+	var rows, length, average *proto.Value
+	for i, column := range columns {
+		switch column {
+		case "TABLE_ROWS", "ROWS":
+			rows = values[i]
+		case "DATA_LENGTH":
+			length = values[i]
+		case "AVG_ROW_LENGTH":
+			average = values[i]
+		}
+	}
+	if rows == nil || length == nil || average == nil || average.Val == nil {
+		return
+	}
+	r, rok := uintValue(rows)
+	l, lok := uintValue(length)
+	if rok && lok && r > 0 {
+		setUint(average, l/r)
+	}
+}
+
+func valueString(value *proto.Value) string {
+	if value == nil || value.Val == nil {
+		return ""
+	}
+	if b, ok := value.Val.([]byte); ok {
+		return string(b)
+	}
+	return string(value.Raw)
+}
+
+func setBytes(value *proto.Value, b []byte) {
+	value.Val, value.Raw, value.Len = b, b, len(b)
+}
+
+func uintValue(value *proto.Value) (uint64, bool) {
+	switch val := value.Val.(type) {
+	case []byte:
+		n, err := strconv.ParseUint(string(val), 10, 64)
+		return n, err == nil
+	case int64:
+		return uint64(val), val >= 0
+	case uint64:
+		return val, true
+	}
+	return 0, false
+}
+
+// setUint sets the value to n, in the type of its text or binary protocol value.
+func setUint(value *proto.Value, n uint64) {
+	switch value.Val.(type) {
+	case []byte:
+		setBytes(value, strconv.AppendUint(nil, n, 10))
+	case int64:
+		value.Val = int64(n)
+	case uint64:
+		value.Val = n
+	}
+}
@@ -0,0 +1,129 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/third_party/parser"
+)
+
+// schemaExecutor is a db group answering every query with its result.
+type schemaExecutor struct {
+	proto.DBGroupExecutor
+	name   string
+	result *mysql.Result
+	sqls   []string
+}
+
+func (e *schemaExecutor) GroupName() string {
+	return e.name
+}
+
+func (e *schemaExecutor) Query(ctx context.Context, sql string) (proto.Result, uint16, error) {
+	e.sqls = append(e.sqls, sql)
+	return e.result, 1, nil
+}
+
+func newTableStatus(rows ...[]string) *mysql.Result {
+	columns := []string{"Name", "Engine", "Rows", "Avg_row_length", "Data_length", "Auto_increment", "Comment"}
+	fields := make([]*mysql.Field, 0, len(columns))
+	for _, column := range columns {
+		fields = append(fields, &mysql.Field{Name: column, FieldType: constant.FieldTypeVarString})
+	}
+	result := &mysql.Result{Fields: fields}
+	for _, row := range rows {
+		values := make([]*proto.Value, 0, len(row))
+		for _, value := range row {
+			if value == "NULL" {
+				values = append(values, nil)
+				continue
+			}
+			values = append(values, &proto.Value{Typ: constant.FieldTypeVarString, Len: len(value),
+				Val: []byte(value), Raw: []byte(value)})
+		}
+		result.Rows = append(result.Rows, mysql.NewTextRow(fields, values))
+	}
+	return result
+}
+
+func TestLogicalSchemaPlan(t *testing.T) {
+	stmt, err := parser.New().ParseOneStmt("show table status", "", "")
+	assert.NoError(t, err)
+	school0 := &schemaExecutor{name: "school_0", result: newTableStatus(
+		[]string{"country", "InnoDB", "200", "80", "16000", "NULL", "countries"},
+		[]string{"student_0", "InnoDB", "10", "100", "1000", "11", "students"},
+		[]string{"student_1", "InnoDB", "30", "50", "1500", "32", "students"},
+	)}
+	school1 := &schemaExecutor{name: "school_1", result: newTableStatus(
+		[]string{"country", "InnoDB", "200", "80", "16000", "NULL", "countries"},
+		[]string{"student_2", "InnoDB", "0", "0", "500", "NULL", "students"},
+		[]string{"teacher", "InnoDB", "5", "20", "100", "6", "teachers"},
+	)}
+	p := &LogicalSchemaPlan{
+		Stmt:      stmt,
+		Executors: []proto.DBGroupExecutor{school0, school1},
+		LogicalTables: map[string]string{
+			"student_0": "student",
+			"student_1": "student",
+			"student_2": "student",
+		},
+	}
+	rows := func(result proto.Result) [][]string {
+		var rows [][]string
+		for _, row := range result.(*mysql.Result).Rows {
+			values, err := row.Decode()
+			assert.NoError(t, err)
+			var strs []string
+			for _, value := range values {
+				if value == nil {
+					strs = append(strs, "NULL")
+				} else {
+					strs = append(strs, valueString(value))
+				}
+			}
+			rows = append(rows, strs)
+		}
+		return rows
+	}
+
+	ctx := proto.WithCommandType(context.Background(), constant.ComQuery)
+	result, warns, err := p.Execute(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(2), warns)
+	assert.Equal(t, []string{"SHOW TABLE STATUS"}, school1.sqls)
+	// the global table is kept once, the physical tables are merged into their logical table
+	assert.Equal(t, [][]string{
+		{"country", "InnoDB", "200", "80", "16000", "NULL", "countries"},
+		{"student", "InnoDB", "40", "75", "3000", "32", "students"},
+		{"teacher", "InnoDB", "5", "20", "100", "6", "teachers"},
+	}, rows(result))
+
+	school0.result = newTableStatus([]string{"student_0", "InnoDB", "10", "100", "1000", "11", "students"},
+		[]string{"teacher", "InnoDB", "5", "20", "100", "6", "teachers"})
+	school1.result = newTableStatus([]string{"student_2", "InnoDB", "0", "0", "500", "NULL", "students"})
+	p.Pattern = "STU%"
+	result, _, err = p.Execute(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"student", "InnoDB", "10", "150", "1500", "11", "students"}}, rows(result))
+}
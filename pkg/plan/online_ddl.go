@@ -0,0 +1,48 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"context"
+
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/osc"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+)
+
+// OnlineDDLPlan alters the shards of a logic table online through ghost tables, the statement
+// waits for the migration, which goes on if the client disconnects, see package osc.
+type OnlineDDLPlan struct {
+	Stmt   *ast.AlterTableStmt
+	Table  string
+	Shards []*osc.Shard
+	Config *osc.Config
+}
+
+func (p *OnlineDDLPlan) Execute(ctx context.Context, _ ...*ast.TableOptimizerHint) (proto.Result, uint16, error) {
+	migration, err := osc.Start(p.Table, p.Stmt, p.Shards, p.Config)
+	if err != nil {
+		return nil, 0, err
+	}
+	log.Infof("migration %s of table %s started", migration.ID(), p.Table)
+	if err = migration.Wait(ctx); err != nil {
+		return nil, 0, err
+	}
+	return &mysql.Result{}, 0, nil
+}
@@ -29,10 +29,12 @@ import (
 	"github.com/cectc/dbpack/pkg/constant"
 	"github.com/cectc/dbpack/pkg/log"
 	"github.com/cectc/dbpack/pkg/misc"
+	"github.com/cectc/dbpack/pkg/mysql"
 	"github.com/cectc/dbpack/pkg/proto"
 	"github.com/cectc/dbpack/pkg/visitor"
 	"github.com/cectc/dbpack/third_party/parser/ast"
 	"github.com/cectc/dbpack/third_party/parser/format"
+	"github.com/cectc/dbpack/third_party/parser/model"
 	driver "github.com/cectc/dbpack/third_party/types/parser_driver"
 )
 
@@ -196,7 +198,9 @@ type QueryOnMultiDBPlan struct {
 
 func (p *QueryOnMultiDBPlan) Execute(ctx context.Context, _ ...*ast.TableOptimizerHint) (proto.Result, uint16, error) {
 	funcColumns := visitFuncColumn(p.Stmt)
+	hiddenColumns := p.rewriteAvgFields(funcColumns)
 	proto.WithVariable(ctx, FuncColumns, funcColumns)
+	ctx = proto.WithScatter(ctx)
 	resultChan := make(chan *ResultWithErr, len(p.Plans))
 	var wg sync.WaitGroup
 	wg.Add(len(p.Plans))
@@ -225,10 +229,89 @@ func (p *QueryOnMultiDBPlan) Execute(ctx context.Context, _ ...*ast.TableOptimiz
 	}
 	sort.Sort(ResultWithErrs(resultList))
 	result, warn := mergeResult(ctx, resultList, p.Stmt.OrderBy, p.Plans[0].Limit)
-	aggregateResult(ctx, result)
+	if err := aggregateResult(ctx, result); err != nil {
+		return nil, 0, err
+	}
+	if err := trimHiddenColumns(result, hiddenColumns); err != nil {
+		return nil, 0, err
+	}
 	return result, warn, nil
 }
 
+// rewriteAvgFields rewrites `AVG(x)` to `SUM(x)` and appends a hidden `COUNT(x)` column
+// for every shard, avg is calculated after merging, returns the count of hidden columns.
+func (p *QueryOnMultiDBPlan) rewriteAvgFields(funcColumns []*visitor.FuncColumn) int {
+	var (
+		fields []*ast.SelectField
+		hidden []*ast.SelectField
+	)
+	for _, funcColumn := range funcColumns {
+		if funcColumn.FuncName != ast.AggFuncAvg {
+			continue
+		}
+		if fields == nil {
+			fields = make([]*ast.SelectField, len(p.Stmt.Fields.Fields))
+			copy(fields, p.Stmt.Fields.Fields)
+		}
+		field := fields[funcColumn.ColumnIndex]
+		avg := field.Expr.(*ast.AggregateFuncExpr)
+		if avg.Distinct {
+			log.Warnf("unsupported avg distinct across shards, sql: %s", p.Stmt.Text())
+		}
+		asName := field.AsName
+		if asName.O == "" && field.Text() != "" {
+			asName = model.NewCIStr(field.Text())
+		}
+		fields[funcColumn.ColumnIndex] = &ast.SelectField{
+			Expr: &ast.AggregateFuncExpr{
+				F:        ast.AggFuncSum,
+				Args:     avg.Args,
+				Distinct: avg.Distinct,
+			},
+			AsName: asName,
+		}
+		funcColumn.CountColumnIndex = len(fields) + len(hidden)
+		hidden = append(hidden, &ast.SelectField{
+			Expr: &ast.AggregateFuncExpr{
+				F:        ast.AggFuncCount,
+				Args:     avg.Args,
+				Distinct: avg.Distinct,
+			},
+		})
+	}
+	if fields == nil {
+		return 0
+	}
+	stmt := *p.Stmt
+	stmt.Fields = &ast.FieldList{Fields: append(fields, hidden...)}
+	for _, plan := range p.Plans {
+		plan.Stmt = &stmt
+	}
+	return len(hidden)
+}
+
+// trimHiddenColumns removes the columns appended by rewriteAvgFields
+func trimHiddenColumns(result *mysql.Result, hiddenColumns int) error {
+	if hiddenColumns == 0 {
+		return nil
+	}
+	columns := len(result.Fields) - hiddenColumns
+	result.Fields = result.Fields[:columns]
+	for _, row := range result.Rows {
+		values, err := row.Decode()
+		if err != nil {
+			return err
+		}
+		switch r := row.(type) {
+		case *mysql.TextRow:
+			r.Values = values[:columns]
+		case *mysql.BinaryRow:
+			r.Values = values[:columns]
+		}
+	}
+	return nil
+}
+
 func generateSelect(table string, stmt *ast.SelectStmt, sb *strings.Builder, limit *Limit) error {
 	ctx := format.NewRestoreCtx(constant.DBPackRestoreFormat, sb)
 	ctx.WriteKeyWord(stmt.Kind.String())
@@ -281,6 +364,18 @@ func generateSelect(table string, stmt *ast.SelectStmt, sb *strings.Builder, lim
 		}
 	}
 
+	if len(stmt.WindowSpecs) > 0 {
+		ctx.WriteKeyWord(" WINDOW ")
+		for i, spec := range stmt.WindowSpecs {
+			if i != 0 {
+				ctx.WritePlain(",")
+			}
+			if err := spec.Restore(ctx); err != nil {
+				return errors.Wrapf(err, "An error occurred while restore SelectStmt.WindowSpecs[%d]", i)
+			}
+		}
+	}
+
 	if stmt.OrderBy != nil {
 		ctx.WritePlain(" ")
 		if err := stmt.OrderBy.Restore(ctx); err != nil {
@@ -57,6 +57,13 @@ func TestQueryOnSingleDBPlan(t *testing.T) {
 			args:                []interface{}{1, 5, 1000, 20},
 			expectedGenerateSql: "SELECT * FROM ((SELECT * FROM `student_1` WHERE `id` IN (?,?) ORDER BY `id` DESC LIMIT 1020) UNION ALL (SELECT * FROM `student_5` WHERE `id` IN (?,?) ORDER BY `id` DESC LIMIT 1020)) t ORDER BY `id` DESC",
 		},
+		{
+			selectSql:           "select id, sum(age) over w from student where id = ? window w as (order by id)",
+			tables:              []string{"student_1"},
+			pk:                  "id",
+			args:                []interface{}{1},
+			expectedGenerateSql: "SELECT `id`,SUM(`age`) OVER `w` FROM `student_1` WHERE `id`=? WINDOW `w` AS (ORDER BY `id`)",
+		},
 	}
 
 	for _, c := range testCases {
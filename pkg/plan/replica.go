@@ -0,0 +1,173 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/uber-go/atomic"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+	"github.com/cectc/dbpack/third_party/parser/format"
+)
+
+// Replicas are the db groups a replicated table is fully copied to.
+type Replicas struct {
+	Executors []proto.DBGroupExecutor
+	next      *atomic.Uint32
+}
+
+func NewReplicas(executors []proto.DBGroupExecutor) *Replicas {
+	return &Replicas{Executors: executors, next: atomic.NewUint32(0)}
+}
+
+// Pick returns the replicas in turn.
+func (r *Replicas) Pick() proto.DBGroupExecutor {
+	return r.Executors[(r.next.Inc()-1)%uint32(len(r.Executors))]
+}
+
+// Contains reports whether the db group named group holds a replica.
+func (r *Replicas) Contains(group string) bool {
+	for _, executor := range r.Executors {
+		if executor.GroupName() == group {
+			return true
+		}
+	}
+	return false
+}
+
+// ReplicaQueryPlan reads a replicated table from one of its replicas, picked on each execution
+// since the plan may be cached. In a transaction it reads the first replica, which the
+// transaction writes as well, to see the writes of the transaction.
+type ReplicaQueryPlan struct {
+	Stmt     ast.StmtNode
+	Args     []interface{}
+	Replicas *Replicas
+}
+
+func (p *ReplicaQueryPlan) Execute(ctx context.Context, hints ...*ast.TableOptimizerHint) (proto.Result, uint16, error) {
+	if complexTx := proto.ExtractDBGroupTx(ctx); complexTx != nil {
+		sql, err := restore(p.Stmt)
+		if err != nil {
+			return nil, 0, err
+		}
+		tx, err := complexTx.Begin(ctx, p.Replicas.Executors[0])
+		if err != nil {
+			return nil, 0, errors.WithStack(err)
+		}
+		log.Debugf("replica query, db name: %s, sql: %s", p.Replicas.Executors[0].GroupName(), sql)
+		return executeInTx(ctx, tx, sql, p.Args)
+	}
+	direct := &DirectQueryPlan{Stmt: p.Stmt, Args: p.Args, Executor: p.Replicas.Pick()}
+	return direct.Execute(ctx)
+}
+
+// BroadcastPlan writes a replicated table on all of its replicas. Out of a transaction, the
+// writes run in a local transaction on each replica, committed once all of them succeeded; a
+// failed commit leaves the replicas committed before it diverged, as the commits of ComplexTx.
+type BroadcastPlan struct {
+	Stmt     ast.StmtNode
+	Args     []interface{}
+	Replicas *Replicas
+}
+
+func (p *BroadcastPlan) Execute(ctx context.Context, hints ...*ast.TableOptimizerHint) (proto.Result, uint16, error) {
+	sql, err := restore(p.Stmt)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	complexTx := proto.ExtractDBGroupTx(ctx)
+	var (
+		localTxs []proto.Tx
+		result   proto.Result
+		warnings uint16
+		affected uint64
+	)
+	rollback := func() {
+		for _, tx := range localTxs {
+			if _, err := tx.Rollback(ctx, nil); err != nil {
+				log.Error(err)
+			}
+		}
+	}
+	for i, executor := range p.Replicas.Executors {
+		var tx proto.Tx
+		if complexTx != nil {
+			tx, err = complexTx.Begin(ctx, executor)
+		} else {
+			tx, _, err = executor.Begin(ctx)
+		}
+		if err != nil {
+			rollback()
+			return nil, 0, errors.WithStack(err)
+		}
+		if complexTx == nil {
+			localTxs = append(localTxs, tx)
+		}
+
+		log.Debugf("broadcast, db name: %s, sql: %s", executor.GroupName(), sql)
+		replicaResult, warns, err := executeInTx(ctx, tx, sql, p.Args)
+		if err != nil {
+			rollback()
+			return nil, 0, errors.Wrapf(err, "write replica %s failed", executor.GroupName())
+		}
+		replicaAffected, err := replicaResult.RowsAffected()
+		if err != nil {
+			rollback()
+			return nil, 0, errors.WithStack(err)
+		}
+		if i == 0 {
+			result, warnings, affected = replicaResult, warns, replicaAffected
+		} else if replicaAffected != affected {
+			log.Warnf("replicas diverged, %d rows affected on %s, %d rows on %s, sql: %s",
+				affected, p.Replicas.Executors[0].GroupName(), replicaAffected, executor.GroupName(), sql)
+		}
+	}
+	for i, tx := range localTxs {
+		if _, err = tx.Commit(ctx); err != nil {
+			localTxs = localTxs[i+1:]
+			rollback()
+			return nil, 0, errors.Wrapf(err, "commit replica %s failed", p.Replicas.Executors[i].GroupName())
+		}
+	}
+	return result, warnings, nil
+}
+
+func restore(stmt ast.Node) (string, error) {
+	var sb strings.Builder
+	if err := stmt.Restore(format.NewRestoreCtx(constant.DBPackRestoreFormat, &sb)); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return sb.String(), nil
+}
+
+func executeInTx(ctx context.Context, tx proto.Tx, sql string, args []interface{}) (proto.Result, uint16, error) {
+	switch proto.CommandType(ctx) {
+	case constant.ComQuery:
+		return tx.Query(ctx, sql)
+	case constant.ComStmtExecute:
+		return tx.ExecuteSql(ctx, sql, args...)
+	default:
+		return nil, 0, nil
+	}
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/testdata"
+	"github.com/cectc/dbpack/third_party/parser"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+)
+
+// replicaExecutor is a db group recording the queries sent to it, its transactions are tx.
+type replicaExecutor struct {
+	proto.DBGroupExecutor
+	name    string
+	tx      proto.Tx
+	queries []string
+}
+
+func (e *replicaExecutor) GroupName() string {
+	return e.name
+}
+
+func (e *replicaExecutor) Begin(ctx context.Context) (proto.Tx, proto.Result, error) {
+	return e.tx, &mysql.Result{}, nil
+}
+
+func (e *replicaExecutor) Query(ctx context.Context, query string) (proto.Result, uint16, error) {
+	e.queries = append(e.queries, query)
+	return &mysql.Result{}, 0, nil
+}
+
+func parseStmt(t *testing.T, sql string) ast.StmtNode {
+	stmt, err := parser.New().ParseOneStmt(sql, "", "")
+	assert.NoError(t, err)
+	return stmt
+}
+
+func TestReplicaQueryPlan(t *testing.T) {
+	world0, world1 := &replicaExecutor{name: "world_0"}, &replicaExecutor{name: "world_1"}
+	p := &ReplicaQueryPlan{
+		Stmt:     parseStmt(t, "select * from country where code = 'CHN'"),
+		Replicas: NewReplicas([]proto.DBGroupExecutor{world0, world1}),
+	}
+	ctx := proto.WithCommandType(context.Background(), constant.ComQuery)
+	for i := 0; i < 4; i++ {
+		_, _, err := p.Execute(ctx)
+		assert.NoError(t, err)
+	}
+	assert.Len(t, world0.queries, 2)
+	assert.Len(t, world1.queries, 2)
+	assert.Equal(t, "SELECT * FROM `country` WHERE `code`='CHN'", world0.queries[0])
+}
+
+func TestBroadcastPlan(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	tx0, tx1 := testdata.NewMockTx(ctrl), testdata.NewMockTx(ctrl)
+	for _, tx := range []*testdata.MockTx{tx0, tx1} {
+		tx.EXPECT().Query(gomock.Any(), "UPDATE `country` SET `population`=1 WHERE `code`='CHN'").
+			Return(&mysql.Result{AffectedRows: 1}, uint16(0), nil)
+		tx.EXPECT().Commit(gomock.Any()).Return(&mysql.Result{}, nil)
+	}
+	p := &BroadcastPlan{
+		Stmt: parseStmt(t, "update country set population = 1 where code = 'CHN'"),
+		Replicas: NewReplicas([]proto.DBGroupExecutor{
+			&replicaExecutor{name: "world_0", tx: tx0},
+			&replicaExecutor{name: "world_1", tx: tx1},
+		}),
+	}
+	result, _, err := p.Execute(proto.WithCommandType(context.Background(), constant.ComQuery))
+	assert.NoError(t, err)
+	affected, _ := result.RowsAffected()
+	assert.Equal(t, uint64(1), affected)
+}
+
+func TestBroadcastPlanRollback(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	tx0, tx1 := testdata.NewMockTx(ctrl), testdata.NewMockTx(ctrl)
+	tx0.EXPECT().Query(gomock.Any(), gomock.Any()).Return(&mysql.Result{AffectedRows: 1}, uint16(0), nil)
+	tx0.EXPECT().Rollback(gomock.Any(), gomock.Any()).Return(&mysql.Result{}, nil)
+	tx1.EXPECT().Query(gomock.Any(), gomock.Any()).Return(nil, uint16(0), errors.New("lock wait timeout"))
+	tx1.EXPECT().Rollback(gomock.Any(), gomock.Any()).Return(&mysql.Result{}, nil)
+	p := &BroadcastPlan{
+		Stmt: parseStmt(t, "delete from country where code = 'CHN'"),
+		Replicas: NewReplicas([]proto.DBGroupExecutor{
+			&replicaExecutor{name: "world_0", tx: tx0},
+			&replicaExecutor{name: "world_1", tx: tx1},
+		}),
+	}
+	_, _, err := p.Execute(proto.WithCommandType(context.Background(), constant.ComQuery))
+	assert.EqualError(t, err, "write replica world_1 failed: lock wait timeout")
+}
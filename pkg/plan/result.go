@@ -19,13 +19,11 @@ package plan
 import (
 	"context"
 	"fmt"
-	"sort"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/pkg/errors"
-
+	"github.com/cectc/dbpack/pkg/constant"
 	"github.com/cectc/dbpack/pkg/log"
 	"github.com/cectc/dbpack/pkg/misc"
 	"github.com/cectc/dbpack/pkg/mysql"
@@ -33,6 +31,7 @@ import (
 	"github.com/cectc/dbpack/pkg/visitor"
 	"github.com/cectc/dbpack/third_party/parser/ast"
 	"github.com/cectc/dbpack/third_party/parser/format"
+	"github.com/cectc/dbpack/third_party/types"
 )
 
 type ResultWithErr struct {
@@ -60,7 +59,9 @@ func (r ResultWithErrs) Swap(i, j int) {
 type OrderField struct {
 	asc             bool
 	fieldValueIndex int
-	value           interface{}
+	// sortKey is the sort key of the collation of a string column, nil if the column compares bytewise
+	sortKey sortKey
+	value   interface{}
 }
 
 type OrderByCell struct {
@@ -74,16 +75,21 @@ type OrderByCells []*OrderByCell
 func (c OrderByCells) Len() int { return len(c) }
 
 func (c OrderByCells) Less(i, j int) bool {
+	return lessOrderByCell(c[i], c[j])
+}
+
+// lessOrderByCell reports whether the row of a comes before the row of b.
+func lessOrderByCell(a, b *OrderByCell) bool {
 	var (
 		index = 0
 		res   int
 	)
-	for index < len(c[i].orderField) {
-		isAsc := c[i].orderField[index].asc
+	for index < len(a.orderField) {
+		isAsc := a.orderField[index].asc
 		if isAsc {
-			res = compare(c[j].orderField[index].value, c[i].orderField[index].value)
+			res = compare(b.orderField[index].value, a.orderField[index].value)
 		} else {
-			res = compare(c[i].orderField[index].value, c[j].orderField[index].value)
+			res = compare(a.orderField[index].value, b.orderField[index].value)
 		}
 		if res != 0 {
 			return res > 0
@@ -93,6 +99,18 @@ func (c OrderByCells) Less(i, j int) bool {
 	return res > 0
 }
 
+// fill sets the j-th row of batch, whose decoded columns are the order by columns, to the cell.
+func (cell *OrderByCell) fill(batch *mysql.ColumnBatch, j int) {
+	cell.row = batch.Row(j)
+	for i, of := range cell.orderField {
+		of.value = orderValue(batch.Value(i, j))
+		if val, ok := of.value.([]byte); ok && of.sortKey != nil {
+			of.value = of.sortKey(val)
+		}
+	}
+	cell.next = false
+}
+
 func compare(val1, val2 interface{}) int {
 	if val1 == nil && val2 == nil {
 		return 0
@@ -150,6 +168,9 @@ func compare(val1, val2 interface{}) int {
 			return 0
 		}
 		return 1
+	case *types.MyDecimal:
+		v2 := val2.(*types.MyDecimal)
+		return v1.Compare(v2)
 	case time.Time:
 		v2 := val2.(time.Time)
 		if v1.Before(v2) {
@@ -158,12 +179,59 @@ func compare(val1, val2 interface{}) int {
 			return 0
 		}
 		return 1
+	case time.Duration:
+		v2 := val2.(time.Duration)
+		if v1 < v2 {
+			return -1
+		} else if v1 == v2 {
+			return 0
+		}
+		return 1
 	default:
 		log.Panicf("unsupported value type, val1: %s, val2: %s", val1, val2)
 	}
 	return 0
 }
 
+// orderValue returns the value used to compare rows, integer and DECIMAL values are compared
+// numerically rather than by their string representation, BIGINT UNSIGNED values as uint64,
+// and TIME values by their duration, as negative times and times of 100 hours or more do not
+// sort as strings.
+func orderValue(value *proto.Value) interface{} {
+	if value == nil || value.Val == nil {
+		return nil
+	}
+	switch value.Typ {
+	case constant.FieldTypeTiny, constant.FieldTypeUint8, constant.FieldTypeShort, constant.FieldTypeUint16,
+		constant.FieldTypeInt24, constant.FieldTypeUint24, constant.FieldTypeLong, constant.FieldTypeUint32,
+		constant.FieldTypeLongLong, constant.FieldTypeYear:
+		// the values of text rows are their digits
+		if val, ok := value.Val.([]byte); ok {
+			if i, err := strconv.ParseInt(string(val), 10, 64); err == nil {
+				return i
+			}
+		}
+	case constant.FieldTypeUint64:
+		switch val := value.Val.(type) {
+		case []byte:
+			if u, err := strconv.ParseUint(string(val), 10, 64); err == nil {
+				return u
+			}
+		case int64:
+			return uint64(val)
+		}
+	case constant.FieldTypeDecimal, constant.FieldTypeNewDecimal:
+		if dec, err := CastValueToDecimal(value); err == nil {
+			return dec
+		}
+	case constant.FieldTypeTime:
+		if d, err := misc.ParseTime([]byte(fmt.Sprintf("%s", value.Val))); err == nil {
+			return d
+		}
+	}
+	return value.Val
+}
+
 func (c OrderByCells) Swap(i, j int) {
 	c[i], c[j] = c[j], c[i]
 }
@@ -214,12 +282,8 @@ func mergeResultWithoutOrderByAndLimit(ctx context.Context,
 				continue
 			}
 
-			row := result.Rows[rowIndexes[i]]
+			rows = append(rows, result.Rows[rowIndexes[i]])
 			rowIndexes[i]++
-			if _, err := row.Decode(); err != nil {
-				log.Panic(err)
-			}
-			rows = append(rows, row)
 		}
 		if endCount == len(endResult) {
 			break
@@ -276,9 +340,6 @@ func mergeResultWithLimit(ctx context.Context,
 				if int64(len(rows)) == count {
 					break
 				}
-				if _, err := row.Decode(); err != nil {
-					log.Panic(err)
-				}
 				rows = append(rows, row)
 			}
 		}
@@ -309,42 +370,28 @@ func mergeResultWithOrderByAndLimit(ctx context.Context,
 		count         int64
 		rowCount      int64
 		rows          = make([]proto.Row, 0)
-		cells         = make([]*OrderByCell, len(results))
 		endResult     = make([]bool, len(results))
 		rowIndexes    = make([]int, len(results))
 		endCount      = 0
 	)
 	fields = results[0].Result.(*mysql.Result).Fields
 	orderByFields = castOrderByItemsToOrderField(orderBy, fields)
+	batches := decodeOrderByColumns(results, orderByFields)
+	// OrderBy compare
+	cells := newOrderByCells(len(results), orderByFields)
 	offset = limit.Offset
 	count = limit.Count
 	rowCount = 0
 	for {
-		for i, rlt := range results {
-			if (cells[i] == nil || cells[i].next) && !endResult[i] {
-				result := rlt.Result.(*mysql.Result)
-				if rowIndexes[i] == len(result.Rows) {
+		for i, batch := range batches {
+			if cells[i].next && !endResult[i] {
+				if rowIndexes[i] == batch.Len() {
 					endResult[i] = true
 					endCount += 1
 					continue
 				}
-
-				row := result.Rows[rowIndexes[i]]
+				cells[i].fill(batch, rowIndexes[i])
 				rowIndexes[i]++
-				orderFields := copyOrderFields(orderByFields)
-				values, err := row.Decode()
-				if err != nil {
-					log.Fatal(err)
-				}
-				for _, of := range orderFields {
-					of.value = values[of.fieldValueIndex].Val
-				}
-
-				cells[i] = &OrderByCell{
-					orderField: orderFields,
-					next:       false,
-					row:        row,
-				}
 			}
 		}
 		if endCount == len(endResult) {
@@ -391,8 +438,6 @@ func mergeResultWithOrderBy(ctx context.Context,
 		warning       uint16 = 0
 		// result rows
 		rows = make([]proto.Row, 0)
-		// OrderBy compare
-		cells = make([]*OrderByCell, len(results))
 		// Record whether mysql.Result has been traversed
 		endResult  = make([]bool, len(results))
 		rowIndexes = make([]int, len(results))
@@ -400,32 +445,19 @@ func mergeResultWithOrderBy(ctx context.Context,
 	)
 	fields = results[0].Result.(*mysql.Result).Fields
 	orderByFields = castOrderByItemsToOrderField(orderBy, fields)
+	batches := decodeOrderByColumns(results, orderByFields)
+	// OrderBy compare
+	cells := newOrderByCells(len(results), orderByFields)
 	for {
-		for i, rlt := range results {
-			if (cells[i] == nil || cells[i].next) && !endResult[i] {
-				result := rlt.Result.(*mysql.Result)
-				if rowIndexes[i] == len(result.Rows) {
+		for i, batch := range batches {
+			if cells[i].next && !endResult[i] {
+				if rowIndexes[i] == batch.Len() {
 					endResult[i] = true
 					endCount += 1
 					continue
 				}
-
-				row := result.Rows[rowIndexes[i]]
+				cells[i].fill(batch, rowIndexes[i])
 				rowIndexes[i]++
-				orderFields := copyOrderFields(orderByFields)
-				values, err := row.Decode()
-				if err != nil {
-					log.Fatal(err)
-				}
-				for _, of := range orderFields {
-					of.value = values[of.fieldValueIndex].Val
-				}
-
-				cells[i] = &OrderByCell{
-					orderField: orderFields,
-					next:       false,
-					row:        row,
-				}
 			}
 		}
 		if endCount == len(endResult) {
@@ -453,50 +485,6 @@ func mergeResultWithOrderBy(ctx context.Context,
 	return result, warning
 }
 
-func aggregateResult(ctx context.Context, result *mysql.Result) {
-	sqlText := proto.SqlText(ctx)
-	funcColumns := proto.Variable(ctx, FuncColumns)
-	if funcColumns == nil {
-		return
-	}
-	funcColumnList := funcColumns.([]*visitor.FuncColumn)
-	if len(funcColumnList) == 0 {
-		return
-	}
-	if len(funcColumnList) > 1 {
-		log.Warnf("unsupported multiple aggregated columns, sql: %s", sqlText)
-	}
-	switch funcColumnList[0].FuncName {
-	case ast.AggFuncCount:
-		var count int64 = 0
-		for _, row := range result.Rows {
-			val, err := castCountCellToInt64(row)
-			if err != nil {
-				log.Warn(err)
-				return
-			}
-			count += val
-		}
-		if err := writeCountToRow(result.Rows[0], count); err != nil {
-			log.Warn(err)
-			return
-		}
-		result.Rows = []proto.Row{
-			result.Rows[0],
-		}
-	case ast.AggFuncSum:
-		// todo
-	case ast.AggFuncAvg:
-		// todo
-	case ast.AggFuncMin:
-		// todo
-	case ast.AggFuncMax:
-		// todo
-	default:
-		log.Warnf("unsupported aggregate type, sql: %s", sqlText)
-	}
-}
-
 func countOrderByCells(cells []*OrderByCell) int {
 	count := 0
 	for _, cell := range cells {
@@ -508,15 +496,44 @@ func countOrderByCells(cells []*OrderByCell) int {
 }
 
 func compareOrderByCells(cells []*OrderByCell) *OrderByCell {
-	cellSlice := make([]*OrderByCell, 0)
+	var first *OrderByCell
 	for _, cell := range cells {
-		if !cell.next {
-			cellSlice = append(cellSlice, cell)
+		if !cell.next && (first == nil || lessOrderByCell(cell, first)) {
+			first = cell
+		}
+	}
+	first.next = true
+	return first
+}
+
+// newOrderByCells returns a cell per result, to be filled with the first row of the result.
+func newOrderByCells(n int, orderByFields []*OrderField) []*OrderByCell {
+	cells := make([]*OrderByCell, n)
+	for i := range cells {
+		cells[i] = &OrderByCell{
+			orderField: copyOrderFields(orderByFields),
+			next:       true,
+		}
+	}
+	return cells
+}
+
+// decodeOrderByColumns decodes the order by columns of the rows of each result, the rows
+// are not decoded entirely so that they are forwarded to the client as they are.
+func decodeOrderByColumns(results []*ResultWithErr, orderByFields []*OrderField) []*mysql.ColumnBatch {
+	columns := make([]int, 0, len(orderByFields))
+	for _, of := range orderByFields {
+		columns = append(columns, of.fieldValueIndex)
+	}
+	batches := make([]*mysql.ColumnBatch, 0, len(results))
+	for _, rlt := range results {
+		batch, err := mysql.DecodeColumnBatch(rlt.Result.(*mysql.Result).Rows, columns)
+		if err != nil {
+			log.Fatal(err)
 		}
+		batches = append(batches, batch)
 	}
-	sort.Sort(OrderByCells(cellSlice))
-	cellSlice[0].next = true
-	return cellSlice[0]
+	return batches
 }
 
 func castOrderByItemsToOrderField(orderBy *ast.OrderByClause, fields []*mysql.Field) []*OrderField {
@@ -532,7 +549,11 @@ func castOrderByItemsToOrderField(orderBy *ast.OrderByClause, fields []*mysql.Fi
 		}
 		orderByField := sb.String()
 		orderByIndex := getOrderByFieldIndex(orderByField, fields)
-		result = append(result, &OrderField{asc: !item.Desc, fieldValueIndex: orderByIndex})
+		result = append(result, &OrderField{
+			asc:             !item.Desc,
+			fieldValueIndex: orderByIndex,
+			sortKey:         newSortKey(fields[orderByIndex]),
+		})
 	}
 	return result
 }
@@ -540,7 +561,7 @@ func castOrderByItemsToOrderField(orderBy *ast.OrderByClause, fields []*mysql.Fi
 func copyOrderFields(fields []*OrderField) []*OrderField {
 	var result []*OrderField
 	for _, field := range fields {
-		result = append(result, &OrderField{asc: field.asc, fieldValueIndex: field.fieldValueIndex})
+		result = append(result, &OrderField{asc: field.asc, fieldValueIndex: field.fieldValueIndex, sortKey: field.sortKey})
 	}
 	return result
 }
@@ -574,35 +595,3 @@ func visitFuncColumn(stmt *ast.SelectStmt) []*visitor.FuncColumn {
 	stmt.Accept(funcVisitor)
 	return funcVisitor.FuncColumns
 }
-
-func castCountCellToInt64(row proto.Row) (int64, error) {
-	switch r := row.(type) {
-	case *mysql.TextRow:
-		val, err := strconv.ParseInt(fmt.Sprintf("%s", r.Values[0].Val), 10, 64)
-		if err != nil {
-			return 0, err
-		}
-		return val, nil
-	case *mysql.BinaryRow:
-		if v, ok := r.Values[0].Val.(int64); ok {
-			return v, nil
-		}
-		return 0, errors.New("count column value must be of type int64")
-	default:
-		return 0, errors.New("unsupported row type")
-	}
-}
-
-func writeCountToRow(row proto.Row, count int64) error {
-	switch r := row.(type) {
-	case *mysql.TextRow:
-		var out []byte
-		misc.WriteUint64(out, 0, uint64(count))
-		r.Values[0].Val = out
-	case *mysql.BinaryRow:
-		r.Values[0].Val = count
-	default:
-		return errors.New("unsupported row type")
-	}
-	return nil
-}
@@ -23,7 +23,9 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 
+	"github.com/cectc/dbpack/pkg/constant"
 	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
 	"github.com/cectc/dbpack/pkg/resource"
 	"github.com/cectc/dbpack/testdata"
 	"github.com/cectc/dbpack/third_party/parser/ast"
@@ -39,6 +41,138 @@ func TestMergeResult(t *testing.T) {
 	suite.Run(t, new(_MergeResultTestSuite))
 }
 
+func TestMergeSortedResults(t *testing.T) {
+	fields := []*mysql.Field{
+		{Name: "id", FieldType: constant.FieldTypeLong},
+		{Name: "name", FieldType: constant.FieldTypeVarString},
+	}
+	ctx := proto.WithCommandType(context.Background(), constant.ComQuery)
+	// the results of the shards are sorted already
+	newResults := func(desc bool) []*ResultWithErr {
+		shards := [][]string{{"1", "4", "7"}, {}, {"2", "3", "9"}}
+		if desc {
+			shards = [][]string{{"7", "4", "1"}, {}, {"9", "3", "2"}}
+		}
+		var results []*ResultWithErr
+		for _, ids := range shards {
+			result := &mysql.Result{Fields: fields}
+			for _, id := range ids {
+				assert.NoError(t, result.AppendRow(ctx, []byte{0x01, id[0], 0x01, 'n'}))
+			}
+			results = append(results, &ResultWithErr{Result: result})
+		}
+		return results
+	}
+	orderBy := func(desc bool) *ast.OrderByClause {
+		return &ast.OrderByClause{Items: []*ast.ByItem{{
+			Expr: &ast.ColumnNameExpr{Name: &ast.ColumnName{Name: model.NewCIStr("id")}},
+			Desc: desc,
+		}}}
+	}
+	ids := func(result *mysql.Result) []string {
+		var ids []string
+		for _, row := range result.Rows {
+			ids = append(ids, string(row.Data()[1:2]))
+		}
+		return ids
+	}
+
+	result, _ := mergeResultWithOrderBy(ctx, newResults(false), orderBy(false))
+	assert.Equal(t, []string{"1", "2", "3", "4", "7", "9"}, ids(result))
+	result, _ = mergeResultWithOrderBy(ctx, newResults(true), orderBy(true))
+	assert.Equal(t, []string{"9", "7", "4", "3", "2", "1"}, ids(result))
+	result, _ = mergeResultWithOrderByAndLimit(ctx, newResults(false), orderBy(false), &Limit{Offset: 1, Count: 3})
+	assert.Equal(t, []string{"2", "3", "4"}, ids(result))
+	result, _ = mergeResultWithoutOrderByAndLimit(ctx, newResults(false))
+	assert.Equal(t, []string{"1", "2", "4", "3", "7", "9"}, ids(result))
+	for _, row := range result.Rows {
+		// the merged rows are forwarded undecoded
+		assert.Nil(t, row.(*mysql.TextRow).Values)
+	}
+}
+
+func TestMergeTimeResults(t *testing.T) {
+	fields := []*mysql.Field{{Name: "elapsed", FieldType: constant.FieldTypeTime, Decimals: 6}}
+	ctx := proto.WithCommandType(context.Background(), constant.ComQuery)
+	var results []*ResultWithErr
+	for _, times := range [][]string{
+		{"-01:00:00.000000", "99:59:59.999999"},
+		{"00:00:00.000001", "100:00:00.000000"},
+	} {
+		result := &mysql.Result{Fields: fields}
+		for _, elapsed := range times {
+			assert.NoError(t, result.AppendRow(ctx, append([]byte{byte(len(elapsed))}, elapsed...)))
+		}
+		results = append(results, &ResultWithErr{Result: result})
+	}
+	orderBy := &ast.OrderByClause{Items: []*ast.ByItem{{
+		Expr: &ast.ColumnNameExpr{Name: &ast.ColumnName{Name: model.NewCIStr("elapsed")}},
+	}}}
+
+	// times of 100 hours or more sort after those of less, which they do not as strings
+	result, _ := mergeResultWithOrderBy(ctx, results, orderBy)
+	var times []string
+	for _, row := range result.Rows {
+		times = append(times, string(row.Data()[1:]))
+	}
+	assert.Equal(t, []string{"-01:00:00.000000", "00:00:00.000001", "99:59:59.999999", "100:00:00.000000"}, times)
+}
+
+func TestMergeUnsignedResults(t *testing.T) {
+	fields := []*mysql.Field{{Name: "id", FieldType: constant.FieldTypeUint64, Flags: constant.UnsignedFlag}}
+	ctx := proto.WithCommandType(context.Background(), constant.ComQuery)
+	var results []*ResultWithErr
+	for _, ids := range [][]string{
+		{"2", "18446744073709551615"},
+		{"10", "9223372036854775808"},
+	} {
+		result := &mysql.Result{Fields: fields}
+		for _, id := range ids {
+			assert.NoError(t, result.AppendRow(ctx, append([]byte{byte(len(id))}, id...)))
+		}
+		results = append(results, &ResultWithErr{Result: result})
+	}
+	orderBy := &ast.OrderByClause{Items: []*ast.ByItem{{
+		Expr: &ast.ColumnNameExpr{Name: &ast.ColumnName{Name: model.NewCIStr("id")}},
+	}}}
+
+	// the ids are compared as numbers, those above math.MaxInt64 included
+	result, _ := mergeResultWithOrderBy(ctx, results, orderBy)
+	var ids []string
+	for _, row := range result.Rows {
+		ids = append(ids, string(row.Data()[1:]))
+	}
+	assert.Equal(t, []string{"2", "10", "9223372036854775808", "18446744073709551615"}, ids)
+}
+
+func TestMergeCollatedResults(t *testing.T) {
+	fields := []*mysql.Field{{Name: "name", FieldType: constant.FieldTypeVarString,
+		CharSet: constant.Collations["utf8mb4_general_ci"]}}
+	ctx := proto.WithCommandType(context.Background(), constant.ComQuery)
+	var results []*ResultWithErr
+	for _, names := range [][]string{
+		{"apple", "Cherry"},
+		{"Banana", "date"},
+	} {
+		result := &mysql.Result{Fields: fields}
+		for _, name := range names {
+			assert.NoError(t, result.AppendRow(ctx, append([]byte{byte(len(name))}, name...)))
+		}
+		results = append(results, &ResultWithErr{Result: result})
+	}
+	orderBy := &ast.OrderByClause{Items: []*ast.ByItem{{
+		Expr: &ast.ColumnNameExpr{Name: &ast.ColumnName{Name: model.NewCIStr("name")}},
+	}}}
+
+	// the names are sorted case insensitively as by mysql, bytewise they would be Banana, Cherry, apple, date
+	result, _ := mergeResultWithOrderBy(ctx, results, orderBy)
+	var names []string
+	for _, row := range result.Rows {
+		names = append(names, string(row.Data()[1:]))
+	}
+	assert.Equal(t, []string{"apple", "Banana", "Cherry", "date"}, names)
+}
+
 func (suite *_MergeResultTestSuite) SetupSuite() {
 	environment := testdata.NewShardingTestEnvironment(suite.T())
 	environment.RegisterDBResource(suite.T())
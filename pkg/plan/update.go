@@ -164,12 +164,12 @@ func (p *UpdatePlan) generate(sb *strings.Builder, table string, hints ...*ast.T
 		}
 	}
 
-	//if p.Stmt.Limit != nil {
-	//	ctx.WritePlain(" ")
-	//	if err := p.Stmt.Limit.Restore(ctx); err != nil {
-	//		return errors.Wrap(err, "An error occur while restoring UpdateStmt.Limit")
-	//	}
-	//}
+	if p.Stmt.Limit != nil {
+		ctx.WritePlain(" ")
+		if err := p.Stmt.Limit.Restore(ctx); err != nil {
+			return errors.Wrap(err, "An error occur while restoring UpdateStmt.Limit")
+		}
+	}
 	return nil
 }
 
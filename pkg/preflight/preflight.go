@@ -0,0 +1,317 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package preflight checks that the data sources are ready to be served by dbpack: they are
+// reachable, their clocks agree with the clock of dbpack, the table metas are visible, and, if
+// distributed transactions are enabled, the masters have the undo_log table and grant the
+// privileges the transactions require.
+package preflight
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/bootstrap"
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/constant"
+	err2 "github.com/cectc/dbpack/pkg/errors"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/resource"
+)
+
+const (
+	StatusPassed  = "passed"
+	StatusWarning = "warning"
+	StatusFailed  = "failed"
+
+	CheckConnectivity = "connectivity"
+	CheckClockSkew    = "clock_skew"
+	CheckMetadata     = "metadata"
+	CheckUndoLogTable = "undo_log_table"
+	CheckUndoLogGrant = "undo_log_privileges"
+	CheckXARecover    = "xa_recover"
+
+	clockQuery    = "SELECT UNIX_TIMESTAMP(NOW(6))"
+	metadataQuery = "SELECT DATABASE(), (SELECT COUNT(*) FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE())"
+	undoLogQuery  = "SELECT id, branch_id, xid, context, rollback_info, log_status, log_created, log_modified " +
+		"FROM undo_log WHERE 1 = 0"
+	// undoLogInsert and undoLogDelete change no row, they fail if the privileges are missing
+	undoLogInsert = "INSERT INTO undo_log (xid, branch_id, context, rollback_info, log_status, log_created, log_modified) " +
+		"SELECT '', 0, '', '', 0, NOW(), NOW() FROM DUAL WHERE 1 = 0"
+	undoLogDelete = "DELETE FROM undo_log WHERE 1 = 0"
+	xaRecover     = "XA RECOVER"
+
+	// CreateUndoLogTableSql is the ddl of the undo_log table, the same as docker/scripts/init.sql
+	CreateUndoLogTableSql = bootstrap.CreateUndoLogTableSql
+)
+
+// DataSource is a data source the checks run on, proto.DB implements it.
+type DataSource interface {
+	Name() string
+	IsMaster() bool
+	QueryDirectly(query string) (proto.Result, uint16, error)
+	ExecuteSqlDirectly(sql string, args ...interface{}) (proto.Result, uint16, error)
+}
+
+// Result is the outcome of a check of a data source.
+type Result struct {
+	ApplicationID string `json:"application_id"`
+	DataSource    string `json:"data_source"`
+	Check         string `json:"check"`
+	Status        string `json:"status"`
+	Message       string `json:"message,omitempty"`
+	// Hint tells how to fix the data source if the check did not pass
+	Hint string `json:"hint,omitempty"`
+}
+
+// Failed reports whether one of the checks failed.
+func Failed(results []*Result) bool {
+	for _, result := range results {
+		if result.Status == StatusFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// Check runs the checks of the data sources of an application, which are registered to the db manager
+// of the application.
+func Check(conf *config.PreflightConfig, appConf *config.DBPackConfig) []*Result {
+	manager := resource.GetDBManager(appConf.AppID)
+	dataSources := make([]DataSource, 0, len(appConf.DataSources))
+	for _, dataSource := range appConf.DataSources {
+		if db := manager.GetDB(dataSource.Name); db != nil {
+			dataSources = append(dataSources, db)
+		}
+	}
+	return Run(conf, appConf, dataSources)
+}
+
+// checker runs the checks of the data sources of an application.
+type checker struct {
+	conf    *config.PreflightConfig
+	appConf *config.DBPackConfig
+	now     func() time.Time
+	results []*Result
+}
+
+// Run runs the checks of the data sources of an application, in the order of the data sources.
+func Run(conf *config.PreflightConfig, appConf *config.DBPackConfig, dataSources []DataSource) []*Result {
+	c := &checker{conf: conf, appConf: appConf, now: time.Now}
+	for _, ds := range dataSources {
+		c.check(ds)
+	}
+	return c.results
+}
+
+func (c *checker) check(ds DataSource) {
+	if !c.checkClock(ds) {
+		return
+	}
+	c.checkMetadata(ds)
+	if c.appConf.DistributedTransaction != nil && ds.IsMaster() {
+		if c.checkUndoLogTable(ds) {
+			c.checkUndoLogPrivileges(ds)
+		}
+		c.checkXARecover(ds)
+	}
+}
+
+func (c *checker) report(ds DataSource, check, status, message, hint string) {
+	c.results = append(c.results, &Result{
+		ApplicationID: c.appConf.AppID,
+		DataSource:    ds.Name(),
+		Check:         check,
+		Status:        status,
+		Message:       message,
+		Hint:          hint,
+	})
+}
+
+// checkClock reads the clock of the data source, which checks the connectivity as well, it
+// returns false if the data source is unreachable.
+func (c *checker) checkClock(ds DataSource) bool {
+	before := c.now()
+	row, err := queryRow(ds, clockQuery)
+	after := c.now()
+	if err != nil {
+		c.report(ds, CheckConnectivity, StatusFailed, err.Error(),
+			"check the dsn of the data source, and that the backend accepts the connections of dbpack")
+		return false
+	}
+	c.report(ds, CheckConnectivity, StatusPassed, "", "")
+
+	seconds, err := strconv.ParseFloat(row[0], 64)
+	if err != nil {
+		c.report(ds, CheckClockSkew, StatusWarning, fmt.Sprintf("unexpected timestamp %q", row[0]), "")
+		return true
+	}
+	// the backend read its clock between before and after, the skew is known up to half the round trip
+	local := before.Add(after.Sub(before) / 2)
+	remote := time.Unix(0, int64(seconds*float64(time.Second)))
+	skew := remote.Sub(local)
+	uncertainty := after.Sub(before) / 2
+	message := fmt.Sprintf("the clock of the data source is %s ahead of dbpack, ±%s", skew.Round(time.Millisecond),
+		uncertainty.Round(time.Millisecond))
+	if skew < 0 {
+		message = fmt.Sprintf("the clock of the data source is %s behind dbpack, ±%s", (-skew).Round(time.Millisecond),
+			uncertainty.Round(time.Millisecond))
+	}
+	if c.conf.MaxClockSkew > 0 && time.Duration(math.Abs(float64(skew)))-uncertainty > c.conf.MaxClockSkew {
+		c.report(ds, CheckClockSkew, StatusFailed, message,
+			fmt.Sprintf("synchronize the clocks of dbpack and of the backend with ntp, the max clock skew is %s", c.conf.MaxClockSkew))
+		return true
+	}
+	c.report(ds, CheckClockSkew, StatusPassed, message, "")
+	return true
+}
+
+// checkMetadata checks that the tables of the database of the data source are visible, their
+// metas are read from information_schema.
+func (c *checker) checkMetadata(ds DataSource) {
+	row, err := queryRow(ds, metadataQuery)
+	switch {
+	case err != nil:
+		c.report(ds, CheckMetadata, StatusFailed, err.Error(),
+			"grant the user of the data source the privilege to read information_schema")
+	case row[0] == "":
+		c.report(ds, CheckMetadata, StatusWarning, "the dsn selects no database",
+			"add the database to the dsn of the data source")
+	case row[1] == "0":
+		c.report(ds, CheckMetadata, StatusWarning, fmt.Sprintf("no table of database %s is visible", row[0]),
+			fmt.Sprintf("grant the user of the data source the SELECT privilege on %s.*, the table metas are read "+
+				"from the tables it can see", row[0]))
+	default:
+		c.report(ds, CheckMetadata, StatusPassed, fmt.Sprintf("%s tables of database %s are visible", row[1], row[0]), "")
+	}
+}
+
+// checkUndoLogTable checks the columns of the undo_log table, it is created if it is missing and
+// the config allows it, it returns false if there is no undo_log table.
+func (c *checker) checkUndoLogTable(ds DataSource) bool {
+	_, _, err := ds.QueryDirectly(undoLogQuery)
+	if err == nil {
+		c.report(ds, CheckUndoLogTable, StatusPassed, "", "")
+		return true
+	}
+	if !isSQLError(err, constant.ERNoSuchTable) {
+		c.report(ds, CheckUndoLogTable, StatusFailed, err.Error(),
+			"the undo_log table must have the columns of "+CreateUndoLogTableSql)
+		return false
+	}
+	if !c.conf.CreateUndoLogTable {
+		c.report(ds, CheckUndoLogTable, StatusFailed, "the undo_log table does not exist",
+			"create it with "+CreateUndoLogTableSql+", or set preflight.create_undo_log_table or auto_migrate.enabled to create it on start")
+		return false
+	}
+	if _, _, err = ds.ExecuteSqlDirectly(CreateUndoLogTableSql); err != nil {
+		c.report(ds, CheckUndoLogTable, StatusFailed, "create the undo_log table failed, "+err.Error(),
+			"grant the user of the data source the CREATE privilege, or create the table with "+CreateUndoLogTableSql)
+		return false
+	}
+	c.report(ds, CheckUndoLogTable, StatusPassed, "the undo_log table is created", "")
+	return true
+}
+
+// checkUndoLogPrivileges checks the privileges the branch sessions need to write and delete their
+// undo logs, by statements changing no row.
+func (c *checker) checkUndoLogPrivileges(ds DataSource) {
+	for _, statement := range []string{undoLogInsert, undoLogDelete} {
+		if _, _, err := ds.ExecuteSqlDirectly(statement); err != nil {
+			c.report(ds, CheckUndoLogGrant, StatusFailed, err.Error(),
+				"grant the user of the data source the SELECT, INSERT and DELETE privileges on the undo_log table")
+			return
+		}
+	}
+	c.report(ds, CheckUndoLogGrant, StatusPassed, "", "")
+}
+
+// checkXARecover checks that the prepared XA transactions can be listed, which MySQL 8.0 allows to
+// the users having the XA_RECOVER_ADMIN privilege only.
+func (c *checker) checkXARecover(ds DataSource) {
+	if _, _, err := ds.QueryDirectly(xaRecover); err != nil {
+		c.report(ds, CheckXARecover, StatusFailed, err.Error(),
+			"grant the user of the data source the XA_RECOVER_ADMIN privilege, the XA branch sessions are "+
+				"recovered by XA RECOVER")
+		return
+	}
+	c.report(ds, CheckXARecover, StatusPassed, "", "")
+}
+
+// queryRow returns the values of the first row of query as strings, NULL is "".
+func queryRow(ds DataSource, query string) ([]string, error) {
+	result, _, err := ds.QueryDirectly(query)
+	if err != nil {
+		return nil, err
+	}
+	rlt, ok := result.(*mysql.Result)
+	if !ok {
+		return nil, errors.Errorf("unexpected result %T", result)
+	}
+	defer rlt.Release()
+	if len(rlt.Rows) == 0 {
+		return nil, errors.Errorf("%s returns no row", query)
+	}
+	values, err := rlt.Rows[0].Decode()
+	if err != nil {
+		return nil, err
+	}
+	row := make([]string, len(rlt.Fields))
+	for i := range row {
+		if i >= len(values) || values[i] == nil || values[i].Val == nil {
+			continue
+		}
+		switch v := values[i].Val.(type) {
+		case []byte:
+			row[i] = string(v)
+		default:
+			row[i] = fmt.Sprint(v)
+		}
+	}
+	return row, nil
+}
+
+func isSQLError(err error, num int) bool {
+	sqlErr, ok := errors.Cause(err).(*err2.SQLError)
+	return ok && sqlErr.Num == num
+}
+
+// WriteReport writes the results as a table, with the hints of the checks which did not pass.
+func WriteReport(w io.Writer, results []*Result) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "APPLICATION\tDATA SOURCE\tCHECK\tSTATUS\tMESSAGE")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.ApplicationID, r.DataSource, r.Check, r.Status, r.Message)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, r := range results {
+		if r.Hint != "" {
+			if _, err := fmt.Fprintf(w, "%s %s %s: %s\n", r.ApplicationID, r.DataSource, r.Check, r.Hint); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package preflight
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/constant"
+	err2 "github.com/cectc/dbpack/pkg/errors"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+type testDataSource struct {
+	name     string
+	master   bool
+	results  map[string]*mysql.Result
+	errors   map[string]error
+	executed []string
+}
+
+func (ds *testDataSource) Name() string {
+	return ds.name
+}
+
+func (ds *testDataSource) IsMaster() bool {
+	return ds.master
+}
+
+func (ds *testDataSource) QueryDirectly(query string) (proto.Result, uint16, error) {
+	if err, ok := ds.errors[query]; ok {
+		return nil, 0, err
+	}
+	if result, ok := ds.results[query]; ok {
+		return result, 0, nil
+	}
+	return &mysql.Result{}, 0, nil
+}
+
+func (ds *testDataSource) ExecuteSqlDirectly(sql string, args ...interface{}) (proto.Result, uint16, error) {
+	if err, ok := ds.errors[sql]; ok {
+		return nil, 0, err
+	}
+	ds.executed = append(ds.executed, sql)
+	if sql == CreateUndoLogTableSql {
+		delete(ds.errors, undoLogQuery)
+	}
+	return &mysql.Result{}, 0, nil
+}
+
+// rowResult creates a text protocol result of a row, nil values are NULL.
+func rowResult(row ...interface{}) *mysql.Result {
+	fields := make([]*mysql.Field, len(row))
+	values := make([]*proto.Value, len(row))
+	for i, value := range row {
+		fields[i] = &mysql.Field{Name: fmt.Sprintf("c%d", i), FieldType: constant.FieldTypeVarString}
+		if value != nil {
+			raw := []byte(fmt.Sprint(value))
+			values[i] = &proto.Value{Typ: constant.FieldTypeVarString, Len: len(raw), Val: raw, Raw: raw}
+		}
+	}
+	return &mysql.Result{Fields: fields, Rows: []proto.Row{mysql.NewTextRow(fields, values)}}
+}
+
+func newTestDataSource(name string, master bool, clock time.Time) *testDataSource {
+	return &testDataSource{
+		name:   name,
+		master: master,
+		results: map[string]*mysql.Result{
+			clockQuery:    rowResult(fmt.Sprintf("%d.%06d", clock.Unix(), clock.Nanosecond()/1000)),
+			metadataQuery: rowResult("employees", 8),
+		},
+		errors: make(map[string]error),
+	}
+}
+
+func statuses(results []*Result) []string {
+	var s []string
+	for _, r := range results {
+		s = append(s, r.DataSource+" "+r.Check+" "+r.Status)
+	}
+	return s
+}
+
+func TestRun(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	master := newTestDataSource("employees", true, now)
+	master.errors[undoLogQuery] = err2.NewSQLError(constant.ERNoSuchTable, "42S02", "Table 'employees.undo_log' doesn't exist")
+	master.errors[xaRecover] = err2.NewSQLError(constant.ERSpecifiedAccessDenied, "42000",
+		"Access denied; you need (at least one of) the XA_RECOVER_ADMIN privilege(s) for this operation")
+	skewed := newTestDataSource("employees-slave", false, now.Add(3*time.Second))
+	skewed.results[metadataQuery] = rowResult(nil, 0)
+	unreachable := newTestDataSource("employees-slave-2", false, now)
+	unreachable.errors[clockQuery] = err2.NewSQLError(constant.ERDBAccessDenied, "42000", "Access denied for user 'dksl'")
+
+	conf := &config.PreflightConfig{MaxClockSkew: time.Second}
+	appConf := &config.DBPackConfig{AppID: "svc", DistributedTransaction: &config.DistributedTransaction{}}
+	c := &checker{conf: conf, appConf: appConf, now: func() time.Time { return now }}
+	for _, ds := range []DataSource{master, skewed, unreachable} {
+		c.check(ds)
+	}
+	assert.Equal(t, []string{
+		"employees connectivity passed",
+		"employees clock_skew passed",
+		"employees metadata passed",
+		"employees undo_log_table failed",
+		"employees xa_recover failed",
+		"employees-slave connectivity passed",
+		"employees-slave clock_skew failed",
+		"employees-slave metadata warning",
+		"employees-slave-2 connectivity failed",
+	}, statuses(c.results))
+	assert.True(t, Failed(c.results))
+	assert.Equal(t, "the clock of the data source is 3s ahead of dbpack, ±0s", c.results[6].Message)
+	assert.Empty(t, master.executed)
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteReport(&buf, c.results))
+	assert.True(t, strings.Contains(buf.String(), "svc employees undo_log_table: create it with CREATE TABLE IF NOT EXISTS `undo_log`"))
+
+	// the undo_log table is created if it is allowed
+	conf.CreateUndoLogTable = true
+	delete(master.errors, xaRecover)
+	c = &checker{conf: conf, appConf: appConf, now: func() time.Time { return now }}
+	c.check(master)
+	assert.Equal(t, []string{
+		"employees connectivity passed",
+		"employees clock_skew passed",
+		"employees metadata passed",
+		"employees undo_log_table passed",
+		"employees undo_log_privileges passed",
+		"employees xa_recover passed",
+	}, statuses(c.results))
+	assert.Equal(t, []string{CreateUndoLogTableSql, undoLogInsert, undoLogDelete}, master.executed)
+	assert.False(t, Failed(c.results))
+}
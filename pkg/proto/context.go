@@ -18,13 +18,16 @@ package proto
 
 import (
 	"context"
+	"sync"
 
+	"github.com/cectc/dbpack/pkg/misc"
 	"github.com/cectc/dbpack/third_party/parser/ast"
 )
 
 const (
 	_flagMaster cFlag = 1 << iota
 	_flagSlave
+	_flagScatter
 )
 
 type (
@@ -39,6 +42,10 @@ type (
 	keySqlText      struct{}
 	keyRemoteAddr   struct{}
 	keyComplexTx    struct{}
+	keySequence     struct{}
+	keyTargets      struct{}
+	keyWarnings     struct{}
+	keyRecord       struct{}
 )
 
 type cFlag uint8
@@ -63,6 +70,17 @@ func IsSlave(ctx context.Context) bool {
 	return hasFlag(ctx, _flagSlave)
 }
 
+// WithScatter marks the statements scattered to all the shards, such as the queries of the shards
+// of a query across them
+func WithScatter(ctx context.Context) context.Context {
+	return context.WithValue(ctx, keyFlag{}, _flagScatter|getFlag(ctx))
+}
+
+// IsScatter reports whether the statement is scattered to all the shards
+func IsScatter(ctx context.Context) bool {
+	return hasFlag(ctx, _flagScatter)
+}
+
 // WithConnectionID binds connection id
 func WithConnectionID(ctx context.Context, connectionID uint32) context.Context {
 	return context.WithValue(ctx, keyConnectionID{}, connectionID)
@@ -199,6 +217,146 @@ func RemoteAddr(ctx context.Context) string {
 	return ""
 }
 
+// RemoteIP extracts the client ip from the remote addr, clients of a dual-stack
+// listener reported as IPv4-mapped IPv6 addresses are returned in IPv4 form
+func RemoteIP(ctx context.Context) string {
+	return misc.HostIP(RemoteAddr(ctx))
+}
+
+// WithStatementSequence binds the number of the statement in the client connection
+func WithStatementSequence(ctx context.Context, sequence uint64) context.Context {
+	return context.WithValue(ctx, keySequence{}, sequence)
+}
+
+// StatementSequence extracts the number of the statement in the client connection, which orders
+// the statements a client pipelined even if they run concurrently
+func StatementSequence(ctx context.Context) uint64 {
+	sequence, ok := ctx.Value(keySequence{}).(uint64)
+	if ok {
+		return sequence
+	}
+	return 0
+}
+
+// routingTargets are the data sources a statement is sent to, which are sent to concurrently if
+// the statement is scattered
+type routingTargets struct {
+	mu    sync.Mutex
+	names []string
+}
+
+// WithRoutingTargets binds the collection of the data sources the statement is sent to
+func WithRoutingTargets(ctx context.Context) context.Context {
+	return context.WithValue(ctx, keyTargets{}, &routingTargets{})
+}
+
+// AddRoutingTarget adds the data source the statement is sent to, once
+func AddRoutingTarget(ctx context.Context, name string) {
+	targets, ok := ctx.Value(keyTargets{}).(*routingTargets)
+	if !ok {
+		return
+	}
+	targets.mu.Lock()
+	defer targets.mu.Unlock()
+	for _, target := range targets.names {
+		if target == name {
+			return
+		}
+	}
+	targets.names = append(targets.names, name)
+}
+
+// RoutingTargets extracts the data sources the statement is sent to, in the order it is sent
+func RoutingTargets(ctx context.Context) []string {
+	targets, ok := ctx.Value(keyTargets{}).(*routingTargets)
+	if !ok {
+		return nil
+	}
+	targets.mu.Lock()
+	defer targets.mu.Unlock()
+	return append([]string(nil), targets.names...)
+}
+
+// Warning is a warning a data source returns for a statement, the warnings of a statement sent
+// to several data sources are merged
+type Warning struct {
+	DataSource string
+	Level      string
+	Code       uint16
+	Message    string
+}
+
+type shardWarnings struct {
+	mu       sync.Mutex
+	warnings []*Warning
+}
+
+// WithShardWarnings binds the collection of the warnings the data sources return for the statement
+func WithShardWarnings(ctx context.Context) context.Context {
+	return context.WithValue(ctx, keyWarnings{}, &shardWarnings{})
+}
+
+// CollectsShardWarnings reports whether the warnings of the statement are collected
+func CollectsShardWarnings(ctx context.Context) bool {
+	_, ok := ctx.Value(keyWarnings{}).(*shardWarnings)
+	return ok
+}
+
+// AddShardWarnings adds the warnings a data source returns for the statement
+func AddShardWarnings(ctx context.Context, warnings ...*Warning) {
+	collected, ok := ctx.Value(keyWarnings{}).(*shardWarnings)
+	if !ok {
+		return
+	}
+	collected.mu.Lock()
+	defer collected.mu.Unlock()
+	collected.warnings = append(collected.warnings, warnings...)
+}
+
+// ShardWarnings extracts the warnings the data sources return for the statement
+func ShardWarnings(ctx context.Context) []*Warning {
+	collected, ok := ctx.Value(keyWarnings{}).(*shardWarnings)
+	if !ok {
+		return nil
+	}
+	collected.mu.Lock()
+	defer collected.mu.Unlock()
+	return append([]*Warning(nil), collected.warnings...)
+}
+
+// deferredRecord is the recording of the session state a statement changed, which the caller
+// of the executor runs once the statements before it are recorded
+type deferredRecord struct {
+	record func()
+}
+
+// WithDeferredRecord binds the slot of the recording of the session state the statement changes,
+// so that the executor leaves it to the caller, which runs it by RecordDeferred
+func WithDeferredRecord(ctx context.Context) context.Context {
+	return context.WithValue(ctx, keyRecord{}, &deferredRecord{})
+}
+
+// DeferRecord leaves the recording of the session state the statement changed to the caller, it
+// reports false if the caller did not bind a slot for it
+func DeferRecord(ctx context.Context, record func()) bool {
+	deferred, ok := ctx.Value(keyRecord{}).(*deferredRecord)
+	if !ok {
+		return false
+	}
+	deferred.record = record
+	return true
+}
+
+// RecordDeferred records the session state the statement changed, if its executor deferred it
+func RecordDeferred(ctx context.Context) {
+	deferred, ok := ctx.Value(keyRecord{}).(*deferredRecord)
+	if !ok || deferred.record == nil {
+		return
+	}
+	deferred.record()
+	deferred.record = nil
+}
+
 // WithDBGroupTx .
 func WithDBGroupTx(ctx context.Context, tx DBGroupTx) context.Context {
 	return context.WithValue(ctx, keyComplexTx{}, tx)
@@ -54,6 +54,8 @@ type (
 	DBListener interface {
 		Listener
 		SetExecutor(executor Executor)
+		// SetSchemaExecutor sets the executor serving the connections using schema
+		SetSchemaExecutor(schema string, executor Executor)
 	}
 
 	// Executor ...
@@ -81,6 +83,56 @@ type (
 		ConnectionClose(ctx context.Context)
 	}
 
+	// PassthroughExecutor is implemented by executors that can send a statement
+	// the proxy failed to parse directly to the master database.
+	PassthroughExecutor interface {
+		ExecutorPassthrough(ctx context.Context, sql string) (Result, uint16, error)
+	}
+
+	// XAPassthroughExecutor is implemented by executors that can forward client-managed
+	// XA statements verbatim, pinning a backend connection from XA START until the
+	// XA transaction is committed or rolled back.
+	XAPassthroughExecutor interface {
+		ExecutorXA(ctx context.Context, sql string) (Result, uint16, error)
+	}
+
+	// ConcurrentReadExecutor is implemented by executors that can run the reads a client
+	// pipelines concurrently, on different connections of the slaves. The reads are run with
+	// WithDeferredRecord, their session state is recorded in statement order once they completed.
+	ConcurrentReadExecutor interface {
+		// IndependentRead reports whether the query statement of ctx neither depends on nor
+		// changes the session of the client connection, so that it may run concurrently
+		// with the statements next to it.
+		IndependentRead(ctx context.Context) bool
+	}
+
+	// PinnedConnectionsExecutor is implemented by executors that can tell the backend connections
+	// held for a client connection instead of going back to the pool, and why they are held.
+	PinnedConnectionsExecutor interface {
+		PinnedConnections(connectionID uint32) []*PinnedConnection
+	}
+
+	// PinnedConnection is a backend connection held for a client connection.
+	PinnedConnection struct {
+		DataSource string `json:"data_source"`
+		// ThreadID is the CONNECTION_ID() of the session of the connection on the data source
+		ThreadID uint32 `json:"thread_id"`
+		// Reasons are the PinReason values the connection is held for
+		Reasons []string  `json:"reasons"`
+		Since   time.Time `json:"since"`
+	}
+
+	// PinnedTx is implemented by the transactions that can tell the data source of the backend
+	// connection they hold, and since when they hold it.
+	PinnedTx interface {
+		DataSourceName() string
+		// ThreadID returns the CONNECTION_ID() of the session of the connection on the data source
+		ThreadID() uint32
+		PinnedSince() time.Time
+		// XA reports whether the transaction was started by XA START
+		XA() bool
+	}
+
 	Filter interface {
 		GetKind() string
 	}
@@ -107,6 +159,21 @@ type (
 		PostHandle(ctx context.Context, result Result, err error) error
 	}
 
+	// DBResultRowsFilter is a DBPostFilter reading the values of the result rows, the executors
+	// decode the rows before the post filters only if such a filter is configured, otherwise
+	// the rows are forwarded to the client as they were read from the backend.
+	DBResultRowsFilter interface {
+		DBPostFilter
+		DecodeResultRows() bool
+	}
+
+	// DBConnectionCloseFilter is notified when a client connection of an executor is closed, so that
+	// it can release the state it keeps for the connection.
+	DBConnectionCloseFilter interface {
+		Filter
+		ConnectionClose(ctx context.Context)
+	}
+
 	DBConnectionPreFilter interface {
 		Filter
 		PreHandle(ctx context.Context, conn Connection) error
@@ -117,10 +184,33 @@ type (
 		PostHandle(ctx context.Context, result Result, conn Connection) error
 	}
 
+	// RedisPreFilter is applied to the commands of the clients of a redis listener before they are
+	// forwarded, an error fails the command without forwarding it.
+	RedisPreFilter interface {
+		Filter
+		PreHandleRedis(ctx context.Context, command *RedisCommand) error
+	}
+
+	// RedisPostFilter is applied to the replies of the backend of a redis listener, an error replaces the reply.
+	RedisPostFilter interface {
+		Filter
+		PostHandleRedis(ctx context.Context, command *RedisCommand, reply *RedisReply) error
+	}
+
 	FilterFactory interface {
 		NewFilter(appid string, config map[string]interface{}) (Filter, error)
 	}
 
+	// ResultMerger merges the values of an aggregate function column returned by the shards of a
+	// scatter-gather query into the value returned to the client. Mergers are registered by the name
+	// of the aggregate function, see package merger.
+	ResultMerger interface {
+		// Merge returns the merged value, values are the values of the column in the order of the
+		// shards, NULL values are nil. The merged value is an int64, uint64, float64, string, []byte
+		// or nil for NULL.
+		Merge(ctx context.Context, values []*Value) (interface{}, error)
+	}
+
 	Connection interface {
 		DataSourceName() string
 		Connect(ctx context.Context) error
@@ -153,6 +243,8 @@ type (
 		SetReadWeight(int)
 		WriteWeight() int
 		ReadWeight() int
+		SetPassthrough(bool)
+		IsPassthrough() bool
 
 		SetConnectionPreFilters(filters []DBConnectionPreFilter)
 		SetConnectionPostFilters(filters []DBConnectionPostFilter)
@@ -166,6 +258,8 @@ type (
 		ExecuteSqlDirectly(sql string, args ...interface{}) (Result, uint16, error)
 		Begin(ctx context.Context) (Tx, Result, error)
 		XAStart(ctx context.Context, sql string) (Tx, Result, error)
+		// Pin takes a connection which is kept until the Tx ends
+		Pin(ctx context.Context) (Tx, error)
 	}
 
 	Tx interface {
@@ -178,10 +272,13 @@ type (
 		Rollback(ctx context.Context, stmt *ast.RollbackStmt) (Result, error)
 		ReleaseSavepoint(ctx context.Context, savepoint string) (result Result, err error)
 		XAPrepare(ctx context.Context, sql string) (Result, error)
+		XAComplete(ctx context.Context, sql string) (Result, error)
 	}
 
 	DBManager interface {
 		GetDB(name string) DB
+		// DBs returns the data sources, ordered by their names
+		DBs() []DB
 	}
 
 	// DBGroupExecutor prepare a query, execute the statement, and then close the statement.
@@ -195,6 +292,7 @@ type (
 		PrepareExecute(ctx context.Context, query string, args ...interface{}) (Result, uint16, error)
 		PrepareExecuteStmt(ctx context.Context, stmt *Stmt) (Result, uint16, error)
 		XAStart(ctx context.Context, sql string) (Tx, Result, error)
+		Pin(ctx context.Context) (Tx, error)
 	}
 
 	DBGroupTx interface {
@@ -218,6 +316,19 @@ type (
 	}
 )
 
+const (
+	// PinReasonTransaction holds the connection of a transaction until it ends
+	PinReasonTransaction = "transaction"
+	// PinReasonXATransaction holds the connection of an XA transaction until it is prepared or completed
+	PinReasonXATransaction = "xa_transaction"
+	// PinReasonTemporaryTable holds the connection until the temporary tables created on it are dropped
+	PinReasonTemporaryTable = "temporary_table"
+	// PinReasonUserLock holds the connection until the user locks taken by GET_LOCK are released
+	PinReasonUserLock = "user_lock"
+	// PinReasonFoundRows holds the connection until the FOUND_ROWS() of a SELECT SQL_CALC_FOUND_ROWS is read
+	PinReasonFoundRows = "found_rows"
+)
+
 const (
 	Unknown DBStatus = iota
 	Running
@@ -0,0 +1,65 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proto
+
+import (
+	"strings"
+)
+
+// RedisCommand is a command sent by a redis client, the first arg is the name of the command.
+type RedisCommand struct {
+	Args [][]byte
+}
+
+// Name returns the upper case name of the command.
+func (c *RedisCommand) Name() string {
+	if len(c.Args) == 0 {
+		return ""
+	}
+	return strings.ToUpper(string(c.Args[0]))
+}
+
+// String returns the args of the command separated by spaces, as redis-cli shows them.
+func (c *RedisCommand) String() string {
+	args := make([]string, 0, len(c.Args))
+	for _, arg := range c.Args {
+		args = append(args, string(arg))
+	}
+	return strings.Join(args, " ")
+}
+
+// RedisReply is the reply of a redis server to a command, Raw is the reply encoded in RESP.
+type RedisReply struct {
+	Raw []byte
+}
+
+// ErrorMessage returns the message of an error reply, it is empty if the reply is not an error.
+func (r *RedisReply) ErrorMessage() string {
+	if len(r.Raw) == 0 {
+		return ""
+	}
+	switch r.Raw[0] {
+	case '-':
+		return strings.TrimRight(string(r.Raw[1:]), "\r\n")
+	case '!':
+		// RESP3 blob error, the length line is followed by the message
+		if i := strings.Index(string(r.Raw), "\r\n"); i >= 0 {
+			return strings.TrimRight(string(r.Raw[i+2:]), "\r\n")
+		}
+	}
+	return ""
+}
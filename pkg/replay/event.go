@@ -0,0 +1,141 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package replay records the statements clients send to dbpack and executes them again
+// against a target server, for regression and capacity testing.
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// CommandQuery is a statement sent by COM_QUERY.
+	CommandQuery = "query"
+	// CommandExecute is a prepared statement sent by COM_STMT_EXECUTE.
+	CommandExecute = "execute"
+	// CommandQuit marks the end of a client session.
+	CommandQuit = "quit"
+)
+
+// Event is a captured statement, or the end of a session.
+type Event struct {
+	// Time is when the statement was received.
+	Time time.Time `json:"time"`
+	// Duration is how long the statement took.
+	Duration     time.Duration `json:"duration,omitempty"`
+	ConnectionID uint32        `json:"connection_id"`
+	User         string        `json:"user,omitempty"`
+	Schema       string        `json:"schema,omitempty"`
+	Command      string        `json:"command"`
+	SQL          string        `json:"sql,omitempty"`
+	// Args are the parameters of a prepared statement, binary ones are captured as strings.
+	Args []interface{} `json:"args,omitempty"`
+	// Error is the error returned to the client, empty if the statement succeeded.
+	Error string `json:"error,omitempty"`
+}
+
+// Writer writes events as lines of json, it is safe for concurrent use.
+type Writer struct {
+	lock sync.Mutex
+	out  io.WriteCloser
+}
+
+func NewWriter(out io.WriteCloser) *Writer {
+	return &Writer{out: out}
+}
+
+func (w *Writer) Write(event *Event) error {
+	for i, arg := range event.Args {
+		if b, ok := arg.([]byte); ok {
+			event.Args[i] = string(b)
+		}
+	}
+	content, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "marshal capture event failed")
+	}
+	content = append(content, '\n')
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	_, err = w.out.Write(content)
+	return err
+}
+
+func (w *Writer) Close() error {
+	return w.out.Close()
+}
+
+// Reader reads the events written by a Writer.
+type Reader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func NewReader(in io.Reader) *Reader {
+	scanner := bufio.NewScanner(in)
+	// a line holds a whole statement
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<30)
+	return &Reader{scanner: scanner}
+}
+
+// Next returns the next event, or io.EOF when there are no more events.
+func (r *Reader) Next() (*Event, error) {
+	for r.scanner.Scan() {
+		r.line++
+		line := r.scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.UseNumber()
+		event := &Event{}
+		if err := decoder.Decode(event); err != nil {
+			return nil, errors.Wrapf(err, "invalid capture event at line %d", r.line)
+		}
+		for i, arg := range event.Args {
+			if number, ok := arg.(json.Number); ok {
+				event.Args[i] = numberArg(number)
+			}
+		}
+		return event, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// numberArg converts a json number back to the integer or float it was captured from.
+func numberArg(number json.Number) interface{} {
+	if i, err := number.Int64(); err == nil {
+		return i
+	}
+	if u, err := strconv.ParseUint(number.String(), 10, 64); err == nil {
+		return u
+	}
+	f, _ := number.Float64()
+	return f
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package replay
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type nopCloser struct {
+	*bytes.Buffer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+func TestWriteAndRead(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	buf := &bytes.Buffer{}
+	writer := NewWriter(nopCloser{buf})
+	events := []*Event{
+		{
+			Time:         now,
+			Duration:     time.Millisecond,
+			ConnectionID: 1,
+			User:         "dksl",
+			Schema:       "employees",
+			Command:      CommandExecute,
+			SQL:          "update employee set name = ?, salary = ? where id = ? and dept_id = ?",
+			Args:         []interface{}{[]byte("scott"), 1250.5, int64(-1), uint64(18446744073709551615), nil},
+		},
+		{Time: now.Add(time.Second), ConnectionID: 1, Command: CommandQuery, SQL: "commit", Error: "deadlock"},
+		{Time: now.Add(2 * time.Second), ConnectionID: 1, Command: CommandQuit},
+	}
+	for _, event := range events {
+		assert.NoError(t, writer.Write(event))
+	}
+
+	reader := NewReader(buf)
+	for _, expected := range events {
+		event, err := reader.Next()
+		assert.NoError(t, err)
+		assert.Equal(t, expected, event)
+	}
+	_, err := reader.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestReadInvalidEvent(t *testing.T) {
+	reader := NewReader(bytes.NewBufferString("\n{\"command\": \"query\"}\nnot json\n"))
+	_, err := reader.Next()
+	assert.NoError(t, err)
+	_, err = reader.Next()
+	assert.ErrorContains(t, err, "invalid capture event at line 3")
+}
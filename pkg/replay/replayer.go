@@ -0,0 +1,205 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package replay
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/log"
+)
+
+// sessionBacklog is the number of events queued for a session before reading more events waits.
+const sessionBacklog = 1024
+
+// Stats summarizes a replay.
+type Stats struct {
+	Statements int64 `json:"statements"`
+	// Errors is the number of statements failing on the target.
+	Errors int64 `json:"errors"`
+	// Diverged is the number of statements failing on either the target or the captured server only.
+	Diverged int64 `json:"diverged"`
+	// Duration is the total time the target took to execute the statements.
+	Duration time.Duration `json:"duration"`
+	// CapturedDuration is the total time the captured server took to execute the statements.
+	CapturedDuration time.Duration `json:"captured_duration"`
+	// Elapsed is the wall time of the replay.
+	Elapsed time.Duration `json:"elapsed"`
+}
+
+// Replayer executes captured events against a target, each captured session on a connection
+// of its own, keeping the order of the statements in a session.
+type Replayer struct {
+	db *sql.DB
+	// speed scales the captured intervals between statements, 2 replays twice as fast,
+	// 0 replays as fast as possible.
+	speed float64
+
+	lock  sync.Mutex
+	stats Stats
+}
+
+func NewReplayer(db *sql.DB, speed float64) (*Replayer, error) {
+	if speed < 0 {
+		return nil, errors.Errorf("replay speed must not be negative, got %v", speed)
+	}
+	return &Replayer{db: db, speed: speed}, nil
+}
+
+// Replay executes the events read from reader, it returns once all of them are executed.
+func (r *Replayer) Replay(ctx context.Context, reader *Reader) (*Stats, error) {
+	var (
+		wg       sync.WaitGroup
+		sessions = make(map[uint32]chan *Event)
+		first    time.Time
+		start    = time.Now()
+		err      error
+	)
+	r.lock.Lock()
+	r.stats = Stats{}
+	r.lock.Unlock()
+
+	for {
+		var event *Event
+		if event, err = reader.Next(); err != nil {
+			break
+		}
+		if first.IsZero() {
+			first = event.Time
+		}
+		events, ok := sessions[event.ConnectionID]
+		if event.Command == CommandQuit {
+			if ok {
+				close(events)
+				delete(sessions, event.ConnectionID)
+			}
+			continue
+		}
+		if !ok {
+			events = make(chan *Event, sessionBacklog)
+			sessions[event.ConnectionID] = events
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r.replaySession(ctx, events, start, first)
+			}()
+		}
+		select {
+		case events <- event:
+		case <-ctx.Done():
+		}
+		if err = ctx.Err(); err != nil {
+			break
+		}
+	}
+	for _, events := range sessions {
+		close(events)
+	}
+	wg.Wait()
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.stats.Elapsed = time.Since(start)
+	stats := r.stats
+	if err == io.EOF {
+		err = nil
+	}
+	return &stats, err
+}
+
+// replaySession executes the events of a captured session in order, each one no sooner than
+// its captured offset from the first event, scaled by the replay speed.
+func (r *Replayer) replaySession(ctx context.Context, events <-chan *Event, start, first time.Time) {
+	var (
+		conn   *sql.Conn
+		schema string
+		err    error
+	)
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+
+	for event := range events {
+		if r.speed > 0 {
+			due := start.Add(time.Duration(float64(event.Time.Sub(first)) / r.speed))
+			if wait := time.Until(due); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+				}
+			}
+		}
+		if ctx.Err() != nil {
+			// drain the events so that reading them does not block
+			continue
+		}
+		if conn == nil {
+			if conn, err = r.db.Conn(ctx); err != nil {
+				log.Warnf("replay session %d failed to connect, %v", event.ConnectionID, err)
+				conn = nil
+				r.record(event, 0, err)
+				continue
+			}
+		}
+		if event.Schema != "" && event.Schema != schema {
+			if _, err = conn.ExecContext(ctx, "USE `"+strings.ReplaceAll(event.Schema, "`", "``")+"`"); err != nil {
+				r.record(event, 0, err)
+				continue
+			}
+			schema = event.Schema
+		}
+		begin := time.Now()
+		err = execute(ctx, conn, event)
+		r.record(event, time.Since(begin), err)
+	}
+}
+
+func execute(ctx context.Context, conn *sql.Conn, event *Event) error {
+	rows, err := conn.QueryContext(ctx, event.SQL, event.Args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+	}
+	return rows.Err()
+}
+
+func (r *Replayer) record(event *Event, duration time.Duration, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.stats.Statements++
+	r.stats.Duration += duration
+	r.stats.CapturedDuration += event.Duration
+	if err != nil {
+		r.stats.Errors++
+		log.Debugf("replay statement of session %d failed, %s: %v", event.ConnectionID, event.SQL, err)
+	}
+	if (err != nil) != (event.Error != "") {
+		r.stats.Diverged++
+	}
+}
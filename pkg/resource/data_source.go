@@ -17,10 +17,14 @@
 package resource
 
 import (
-	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
 
 	"github.com/cectc/dbpack/pkg/config"
 	"github.com/cectc/dbpack/pkg/filter"
+	"github.com/cectc/dbpack/pkg/log"
 	"github.com/cectc/dbpack/pkg/proto"
 	"github.com/cectc/dbpack/pkg/sql"
 	"github.com/cectc/dbpack/third_party/pools"
@@ -29,50 +33,63 @@ import (
 var managers = make(map[string]proto.DBManager)
 
 type DBManager struct {
+	appid         string
+	mu            sync.RWMutex
 	dataSources   []*config.DataSource
 	resourcePools map[string]proto.DB
+	factory       func(dataSource *config.DataSource) pools.Factory
 }
 
-func RegisterDBManager(appid string, dataSources []*config.DataSource, factory func(dbName, dsn string) pools.Factory) {
-	resourcePools := make(map[string]proto.DB, 0)
-
-	initResourcePool := func(dataSourceConfig *config.DataSource) *pools.ResourcePool {
-		resourcePool := pools.NewResourcePool(factory(dataSourceConfig.Name, dataSourceConfig.DSN), dataSourceConfig.Capacity,
-			dataSourceConfig.MaxCapacity, dataSourceConfig.IdleTimeout, 0, nil)
-		return resourcePool
+func RegisterDBManager(appid string, dataSources []*config.DataSource, factory func(dataSource *config.DataSource) pools.Factory) {
+	manager := &DBManager{
+		appid:         appid,
+		dataSources:   dataSources,
+		resourcePools: make(map[string]proto.DB, 0),
+		factory:       factory,
 	}
-
 	for i := 0; i < len(dataSources); i++ {
-		var (
-			connectionPreFilters  []proto.DBConnectionPreFilter
-			connectionPostFilters []proto.DBConnectionPostFilter
-		)
 		dataSource := dataSources[i]
-		resourcePool := initResourcePool(dataSource)
-		db := sql.NewDB(dataSource.Name, dataSource.MasterName, dataSource.PingInterval, dataSource.PingTimesForChangeStatus, resourcePool)
-		for j := 0; j < len(dataSource.Filters); j++ {
-			filterName := dataSource.Filters[j]
-			f := filter.GetFilter(appid, filterName)
-			if f != nil {
-				preFilter, ok := f.(proto.DBConnectionPreFilter)
-				if ok {
-					connectionPreFilters = append(connectionPreFilters, preFilter)
-				}
-				postFilter, ok := f.(proto.DBConnectionPostFilter)
-				if ok {
-					connectionPostFilters = append(connectionPostFilters, postFilter)
-				}
+		manager.resourcePools[dataSource.Name] = manager.newDB(dataSource)
+	}
+	managers[appid] = manager
+}
+
+func (manager *DBManager) newDB(dataSource *config.DataSource) proto.DB {
+	var (
+		connectionPreFilters  []proto.DBConnectionPreFilter
+		connectionPostFilters []proto.DBConnectionPostFilter
+	)
+	resourcePool := pools.NewResourcePool(manager.factory(dataSource), dataSource.Capacity,
+		dataSource.MaxCapacity, dataSource.IdleTimeout, 0, nil)
+	db := sql.NewDB(dataSource.Name, dataSource.MasterName, dataSource.PingInterval, dataSource.PingTimesForChangeStatus, resourcePool)
+	for j := 0; j < len(dataSource.Filters); j++ {
+		filterName := dataSource.Filters[j]
+		f := filter.GetFilter(manager.appid, filterName)
+		if f != nil {
+			preFilter, ok := f.(proto.DBConnectionPreFilter)
+			if ok {
+				connectionPreFilters = append(connectionPreFilters, preFilter)
+			}
+			postFilter, ok := f.(proto.DBConnectionPostFilter)
+			if ok {
+				connectionPostFilters = append(connectionPostFilters, postFilter)
 			}
 		}
+	}
 
-		db.SetConnectionPreFilters(connectionPreFilters)
-		db.SetConnectionPostFilters(connectionPostFilters)
-		resourcePools[dataSource.Name] = db
+	db.SetConnectionPreFilters(connectionPreFilters)
+	db.SetConnectionPostFilters(connectionPostFilters)
+	db.SetPassthrough(dataSource.Passthrough)
+	if dataSource.Concurrency != nil {
+		// the concurrency config is validated when the config is loaded
+		if err := db.(*sql.DB).SetConcurrency(dataSource.Concurrency); err != nil {
+			log.Errorf("datasource %s concurrency is not capped: %v", dataSource.Name, err)
+		}
 	}
-	managers[appid] = &DBManager{
-		dataSources:   dataSources,
-		resourcePools: resourcePools,
+	if err := db.(*sql.DB).SetAnnotation(dataSource.Annotation); err != nil {
+		log.Errorf("datasource %s statements are not annotated: %v", dataSource.Name, err)
 	}
+	return db
 }
 
 func GetDBManager(appid string) proto.DBManager {
@@ -84,17 +101,88 @@ func SetDBManager(appid string, manager proto.DBManager) {
 }
 
 func (manager *DBManager) GetDB(name string) proto.DB {
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
 	return manager.resourcePools[name]
 }
 
-func DetectDBs() error {
-	for _, manager := range managers {
-		dbManager := manager.(*DBManager)
-		for _, db := range dbManager.resourcePools {
-			if err := db.Ping(); err != nil {
-				return fmt.Errorf("datasource %s is not ready, err: %+v", db.Name(), err)
-			}
+// DBs returns the data sources, ordered by their names.
+func (manager *DBManager) DBs() []proto.DB {
+	manager.mu.RLock()
+	dbs := make([]proto.DB, 0, len(manager.resourcePools))
+	for _, db := range manager.resourcePools {
+		dbs = append(dbs, db)
+	}
+	manager.mu.RUnlock()
+	sort.Slice(dbs, func(i, j int) bool {
+		return dbs[i].Name() < dbs[j].Name()
+	})
+	return dbs
+}
+
+// DataSource returns the config of the named data source, nil if it is not registered.
+func (manager *DBManager) DataSource(name string) *config.DataSource {
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
+	for _, dataSource := range manager.dataSources {
+		if dataSource.Name == name {
+			return dataSource
 		}
 	}
 	return nil
 }
+
+// AddDataSource registers a data source discovered at runtime, such as an aurora reader instance.
+func (manager *DBManager) AddDataSource(dataSource *config.DataSource) (proto.DB, error) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	if _, ok := manager.resourcePools[dataSource.Name]; ok {
+		return nil, errors.Errorf("datasource %s already exists", dataSource.Name)
+	}
+	db := manager.newDB(dataSource)
+	manager.dataSources = append(manager.dataSources, dataSource)
+	manager.resourcePools[dataSource.Name] = db
+	return db, nil
+}
+
+// ReplaceDataSource registers a data source in place of the one of the same name, whose connection
+// pool is closed once the new one is registered. It is registered as a new data source if there is
+// none of the name.
+func (manager *DBManager) ReplaceDataSource(dataSource *config.DataSource) (proto.DB, error) {
+	db := manager.newDB(dataSource)
+	manager.mu.Lock()
+	replaced := manager.resourcePools[dataSource.Name]
+	manager.resourcePools[dataSource.Name] = db
+	dataSources := make([]*config.DataSource, 0, len(manager.dataSources)+1)
+	for _, ds := range manager.dataSources {
+		if ds.Name != dataSource.Name {
+			dataSources = append(dataSources, ds)
+		}
+	}
+	manager.dataSources = append(dataSources, dataSource)
+	manager.mu.Unlock()
+	if replaced != nil {
+		replaced.Close()
+	}
+	return db, nil
+}
+
+// RemoveDataSource unregisters a data source and closes its connection pool.
+func (manager *DBManager) RemoveDataSource(name string) {
+	manager.mu.Lock()
+	db, ok := manager.resourcePools[name]
+	if !ok {
+		manager.mu.Unlock()
+		return
+	}
+	delete(manager.resourcePools, name)
+	dataSources := make([]*config.DataSource, 0, len(manager.dataSources))
+	for _, dataSource := range manager.dataSources {
+		if dataSource.Name != name {
+			dataSources = append(dataSources, dataSource)
+		}
+	}
+	manager.dataSources = dataSources
+	manager.mu.Unlock()
+	db.Close()
+}
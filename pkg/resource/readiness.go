@@ -0,0 +1,106 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resource
+
+import (
+	"sync"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+// DataSourceState is the reachability of a data source.
+type DataSourceState struct {
+	Name      string `json:"name"`
+	Reachable bool   `json:"reachable"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ApplicationReadiness is the readiness of the data sources of an application.
+type ApplicationReadiness struct {
+	Ready bool `json:"ready"`
+	// Reachable is the number of the reachable data sources, Required is the number of those
+	// required by the quorum
+	Reachable   int                `json:"reachable"`
+	Required    int                `json:"required"`
+	DataSources []*DataSourceState `json:"data_sources"`
+}
+
+// Readiness is the readiness of dbpack under a readiness policy.
+type Readiness struct {
+	Ready        bool                             `json:"ready"`
+	Policy       config.ReadinessPolicy           `json:"policy"`
+	Quorum       string                           `json:"quorum"`
+	Applications map[string]*ApplicationReadiness `json:"applications"`
+}
+
+// CheckReadiness pings the data sources of the applications. Under the wait policy dbpack is ready if
+// the quorum of the data sources of each application are reachable, under the degraded policy it is
+// ready whatever the data sources are.
+func CheckReadiness(conf *config.ReadinessConfig) *Readiness {
+	readiness := &Readiness{
+		Ready:        true,
+		Policy:       conf.Policy,
+		Quorum:       conf.Quorum,
+		Applications: make(map[string]*ApplicationReadiness, len(managers)),
+	}
+	if readiness.Policy == "" {
+		readiness.Policy = config.ReadinessWait
+	}
+	for appid, manager := range managers {
+		application := checkApplication(manager)
+		required, err := conf.RequiredDataSources(len(application.DataSources))
+		if err != nil {
+			// the quorum is validated when the config is loaded
+			required = len(application.DataSources)
+		}
+		application.Required = required
+		application.Ready = application.Reachable >= required
+		if !application.Ready && readiness.Policy == config.ReadinessWait {
+			readiness.Ready = false
+		}
+		readiness.Applications[appid] = application
+	}
+	return readiness
+}
+
+// checkApplication pings the data sources of an application concurrently, so that the unreachable
+// ones do not add up their timeouts.
+func checkApplication(manager proto.DBManager) *ApplicationReadiness {
+	dbs := manager.DBs()
+	application := &ApplicationReadiness{DataSources: make([]*DataSourceState, len(dbs))}
+	var wg sync.WaitGroup
+	for i, db := range dbs {
+		wg.Add(1)
+		go func(i int, db proto.DB) {
+			defer wg.Done()
+			state := &DataSourceState{Name: db.Name(), Reachable: true}
+			if err := db.Ping(); err != nil {
+				state.Reachable = false
+				state.Error = err.Error()
+			}
+			application.DataSources[i] = state
+		}(i, db)
+	}
+	wg.Wait()
+	for _, state := range application.DataSources {
+		if state.Reachable {
+			application.Reachable++
+		}
+	}
+	return application
+}
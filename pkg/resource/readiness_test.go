@@ -0,0 +1,75 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resource
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+type readinessDB struct {
+	proto.DB
+	name string
+	err  error
+}
+
+func (db *readinessDB) Name() string {
+	return db.name
+}
+
+func (db *readinessDB) Ping() error {
+	return db.err
+}
+
+type readinessDBManager struct {
+	proto.DBManager
+	dbs []proto.DB
+}
+
+func (manager *readinessDBManager) DBs() []proto.DB {
+	return manager.dbs
+}
+
+func TestCheckReadiness(t *testing.T) {
+	SetDBManager("readiness", &readinessDBManager{dbs: []proto.DB{
+		&readinessDB{name: "employees"},
+		&readinessDB{name: "employees-slave-1", err: errors.New("connection refused")},
+		&readinessDB{name: "employees-slave-2"},
+	}})
+	defer delete(managers, "readiness")
+
+	readiness := CheckReadiness(&config.ReadinessConfig{})
+	assert.False(t, readiness.Ready)
+	assert.Equal(t, config.ReadinessWait, readiness.Policy)
+	application := readiness.Applications["readiness"]
+	assert.Equal(t, 2, application.Reachable)
+	assert.Equal(t, 3, application.Required)
+	assert.Equal(t, &DataSourceState{Name: "employees-slave-1", Error: "connection refused"}, application.DataSources[1])
+
+	readiness = CheckReadiness(&config.ReadinessConfig{Policy: config.ReadinessWait, Quorum: "50%"})
+	assert.True(t, readiness.Ready)
+	assert.Equal(t, 2, readiness.Applications["readiness"].Required)
+
+	readiness = CheckReadiness(&config.ReadinessConfig{Policy: config.ReadinessDegraded})
+	assert.True(t, readiness.Ready)
+	assert.False(t, readiness.Applications["readiness"].Ready)
+}
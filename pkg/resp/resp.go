@@ -0,0 +1,220 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package resp reads and writes the commands and the replies of the redis serialization protocol,
+// RESP2 and RESP3. The replies are kept encoded, so that they are forwarded as they are read.
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+const (
+	// MaxBulkLength is the max length of a bulk string, the proto-max-bulk-len default of redis
+	MaxBulkLength = 512 * 1024 * 1024
+	// maxInlineLength is the max length of an inline command, as redis allows
+	maxInlineLength = 64 * 1024
+	// maxArgs is the max number of args of a command
+	maxArgs = 1024 * 1024
+)
+
+// ErrProtocol is the cause of the errors of malformed commands and replies.
+var ErrProtocol = errors.New("protocol error")
+
+// ReadCommand reads a command, either an array of bulk strings or an inline command
+// whose args are separated by spaces, as telnet clients send.
+func ReadCommand(r *bufio.Reader) (*proto.RedisCommand, error) {
+	line, err := readLine(r, maxInlineLength)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		// an empty inline command is skipped by redis
+		return ReadCommand(r)
+	}
+	if line[0] != '*' {
+		fields := bytes.Fields(line)
+		if len(fields) == 0 {
+			return ReadCommand(r)
+		}
+		return &proto.RedisCommand{Args: fields}, nil
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > maxArgs {
+		return nil, errors.Wrapf(ErrProtocol, "invalid multibulk length %q", line[1:])
+	}
+	if n <= 0 {
+		return ReadCommand(r)
+	}
+	command := &proto.RedisCommand{Args: make([][]byte, 0, n)}
+	for i := 0; i < n; i++ {
+		line, err = readLine(r, maxInlineLength)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, errors.Wrapf(ErrProtocol, "expected '$', got %q", line)
+		}
+		length, err := strconv.Atoi(string(line[1:]))
+		if err != nil || length < 0 || length > MaxBulkLength {
+			return nil, errors.Wrapf(ErrProtocol, "invalid bulk length %q", line[1:])
+		}
+		arg := make([]byte, length+2)
+		if _, err = io.ReadFull(r, arg); err != nil {
+			return nil, err
+		}
+		if arg[length] != '\r' || arg[length+1] != '\n' {
+			return nil, errors.Wrap(ErrProtocol, "bulk string is not terminated by CRLF")
+		}
+		command.Args = append(command.Args, arg[:length:length])
+	}
+	return command, nil
+}
+
+// AppendCommand appends a command encoded as an array of bulk strings to buf.
+func AppendCommand(buf []byte, command *proto.RedisCommand) []byte {
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(command.Args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range command.Args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	return buf
+}
+
+// ReadReply reads a reply with its nested replies, the attributes of RESP3 preceding
+// the reply are part of it.
+func ReadReply(r *bufio.Reader) (*proto.RedisReply, error) {
+	raw, err := appendReply(nil, r)
+	if err != nil {
+		return nil, err
+	}
+	return &proto.RedisReply{Raw: raw}, nil
+}
+
+func appendReply(buf []byte, r *bufio.Reader) ([]byte, error) {
+	line, err := readLine(r, MaxBulkLength)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.Wrap(ErrProtocol, "empty reply")
+	}
+	buf = append(buf, line...)
+	buf = append(buf, '\r', '\n')
+
+	switch line[0] {
+	case '+', '-', ':', '_', ',', '#', '(':
+		// simple string, error, integer, null, double, boolean and big number
+		return buf, nil
+	case '$', '!', '=':
+		// bulk string, blob error and verbatim string
+		length, err := strconv.Atoi(string(line[1:]))
+		if err != nil || length > MaxBulkLength {
+			return nil, errors.Wrapf(ErrProtocol, "invalid bulk length %q", line[1:])
+		}
+		if length < 0 {
+			// RESP2 null bulk string
+			return buf, nil
+		}
+		start := len(buf)
+		buf = append(buf, make([]byte, length+2)...)
+		if _, err = io.ReadFull(r, buf[start:]); err != nil {
+			return nil, err
+		}
+		return buf, nil
+	case '*', '~', '>', '%', '|':
+		// array, set, push, map and attribute
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n > maxArgs {
+			return nil, errors.Wrapf(ErrProtocol, "invalid aggregate length %q", line[1:])
+		}
+		if line[0] == '%' || line[0] == '|' {
+			n *= 2
+		}
+		for i := 0; i < n; i++ {
+			if buf, err = appendReply(buf, r); err != nil {
+				return nil, err
+			}
+		}
+		if line[0] == '|' {
+			// the attributes are followed by the reply they describe
+			return appendReply(buf, r)
+		}
+		return buf, nil
+	default:
+		return nil, errors.Wrapf(ErrProtocol, "unknown reply type %q", line[0])
+	}
+}
+
+// ErrorReply creates an error reply, the message starts with an error code such as ERR.
+func ErrorReply(message string) *proto.RedisReply {
+	raw := make([]byte, 0, len(message)+3)
+	raw = append(raw, '-')
+	for _, c := range []byte(message) {
+		// the message of a simple error can not contain line breaks
+		if c == '\r' || c == '\n' {
+			c = ' '
+		}
+		raw = append(raw, c)
+	}
+	raw = append(raw, '\r', '\n')
+	return &proto.RedisReply{Raw: raw}
+}
+
+// StatusReply creates a simple string reply, such as OK.
+func StatusReply(status string) *proto.RedisReply {
+	return &proto.RedisReply{Raw: []byte("+" + status + "\r\n")}
+}
+
+// readLine reads a line terminated by CRLF, without the CRLF.
+func readLine(r *bufio.Reader, max int) ([]byte, error) {
+	var line []byte
+	for {
+		fragment, err := r.ReadSlice('\n')
+		if err == nil {
+			line = append(line, fragment...)
+			break
+		}
+		if err != bufio.ErrBufferFull {
+			if err == io.EOF && len(line)+len(fragment) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		line = append(line, fragment...)
+		if len(line) > max {
+			return nil, errors.Wrap(ErrProtocol, "too big line")
+		}
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		// inline commands may be terminated by LF only
+		return bytes.TrimRight(line, "\r\n"), nil
+	}
+	return line[:len(line)-2], nil
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+func TestReadCommand(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected []string
+	}{
+		{input: "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$5\r\na b c\r\n", expected: []string{"SET", "k", "a b c"}},
+		{input: "*1\r\n$4\r\nPING\r\n", expected: []string{"PING"}},
+		{input: "*2\r\n$3\r\nGET\r\n$0\r\n\r\n", expected: []string{"GET", ""}},
+		{input: "GET  k\r\n", expected: []string{"GET", "k"}},
+		{input: "\r\nPING\n", expected: []string{"PING"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			command, err := ReadCommand(bufio.NewReader(strings.NewReader(tc.input)))
+			assert.Nil(t, err)
+			args := make([]string, 0, len(command.Args))
+			for _, arg := range command.Args {
+				args = append(args, string(arg))
+			}
+			assert.Equal(t, tc.expected, args)
+		})
+	}
+
+	for _, input := range []string{"*1\r\n:1\r\n", "*x\r\n", "*1\r\n$-1\r\n", "*1\r\n$3\r\nGETT\r\n"} {
+		_, err := ReadCommand(bufio.NewReader(strings.NewReader(input)))
+		assert.True(t, errors.Is(err, ErrProtocol), input)
+	}
+}
+
+func TestAppendCommand(t *testing.T) {
+	command := &proto.RedisCommand{Args: [][]byte{[]byte("SET"), []byte("k"), []byte("")}}
+	buf := AppendCommand(nil, command)
+	assert.Equal(t, "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$0\r\n\r\n", string(buf))
+
+	read, err := ReadCommand(bufio.NewReader(strings.NewReader(string(buf))))
+	assert.Nil(t, err)
+	assert.Equal(t, command, read)
+}
+
+func TestReadReply(t *testing.T) {
+	replies := []string{
+		"+OK\r\n",
+		"-ERR unknown command 'FOO'\r\n",
+		":42\r\n",
+		"$5\r\nhello\r\n",
+		"$-1\r\n",
+		"*2\r\n$1\r\na\r\n*1\r\n:1\r\n",
+		"*-1\r\n",
+		"_\r\n",
+		",3.14\r\n",
+		"#t\r\n",
+		"(3492890328409238509324850943850943825024385\r\n",
+		"!21\r\nSYNTAX invalid syntax\r\n",
+		"=15\r\ntxt:Some string\r\n",
+		"%1\r\n+key\r\n:1\r\n",
+		"~2\r\n+a\r\n+b\r\n",
+		">3\r\n$10\r\ninvalidate\r\n*1\r\n$1\r\nk\r\n_\r\n",
+		"|1\r\n+ttl\r\n:3600\r\n:2039123\r\n",
+	}
+	// the replies are read one after another from the same reader
+	r := bufio.NewReader(strings.NewReader(strings.Join(replies, "")))
+	for _, expected := range replies {
+		reply, err := ReadReply(r)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, string(reply.Raw))
+	}
+
+	_, err := ReadReply(bufio.NewReader(strings.NewReader("?\r\n")))
+	assert.True(t, errors.Is(err, ErrProtocol))
+}
+
+func TestErrorMessage(t *testing.T) {
+	assert.Equal(t, "ERR boom", ErrorReply("ERR boom").ErrorMessage())
+	assert.Equal(t, "ERR a  b", ErrorReply("ERR a\r\nb").ErrorMessage())
+	assert.Equal(t, "", StatusReply("OK").ErrorMessage())
+
+	reply, err := ReadReply(bufio.NewReader(strings.NewReader("!21\r\nSYNTAX invalid syntax\r\n")))
+	assert.Nil(t, err)
+	assert.Equal(t, "SYNTAX invalid syntax", reply.ErrorMessage())
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sql
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/driver"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+// annotationPart is either a literal text of the template, or the field replacing a placeholder.
+type annotationPart struct {
+	text  string
+	field string
+}
+
+// SetAnnotation prepends the comment of conf to the statements of the client sessions, nil disables it.
+func (db *DB) SetAnnotation(conf *config.AnnotationConfig) error {
+	if conf == nil {
+		db.annotation = nil
+		return nil
+	}
+	template, err := conf.Validate()
+	if err != nil {
+		return err
+	}
+	db.annotation = parseAnnotation(template)
+	return nil
+}
+
+func parseAnnotation(template string) []*annotationPart {
+	var parts []*annotationPart
+	for template != "" {
+		start := strings.IndexByte(template, '{')
+		if start < 0 {
+			parts = append(parts, &annotationPart{text: template})
+			break
+		}
+		end := strings.IndexByte(template[start:], '}')
+		if end < 0 || !annotationField(template[start+1:start+end]) {
+			parts = append(parts, &annotationPart{text: template[:start+1]})
+			template = template[start+1:]
+			continue
+		}
+		if start > 0 {
+			parts = append(parts, &annotationPart{text: template[:start]})
+		}
+		parts = append(parts, &annotationPart{field: template[start+1 : start+end]})
+		template = template[start+end+1:]
+	}
+	return parts
+}
+
+func annotationField(name string) bool {
+	for _, field := range config.AnnotationFields {
+		if name == field {
+			return true
+		}
+	}
+	return false
+}
+
+// annotate prepends the comment to query run on conn for the client session of ctx, the values
+// closing the comment are broken, as the user of a session may be any string.
+func (db *DB) annotate(ctx context.Context, conn *driver.BackendConnection, query string) string {
+	if db.annotation == nil {
+		return query
+	}
+	var sb strings.Builder
+	sb.WriteString("/* ")
+	for _, part := range db.annotation {
+		if part.field == "" {
+			sb.WriteString(part.text)
+			continue
+		}
+		sb.WriteString(strings.ReplaceAll(db.annotationValue(ctx, conn, part.field), "*/", "* /"))
+	}
+	sb.WriteString(" */ ")
+	sb.WriteString(query)
+	return sb.String()
+}
+
+func (db *DB) annotationValue(ctx context.Context, conn *driver.BackendConnection, field string) string {
+	switch field {
+	case "session":
+		if connectionID := proto.ConnectionID(ctx); connectionID != 0 {
+			return strconv.FormatUint(uint64(connectionID), 10)
+		}
+	case "user":
+		return proto.UserName(ctx)
+	case "route":
+		return db.name
+	case "trace":
+		if spanContext := trace.SpanContextFromContext(ctx); spanContext.HasTraceID() {
+			return spanContext.TraceID().String()
+		}
+	case "xid":
+		return threads.xid(db.name, conn.ID())
+	}
+	return ""
+}
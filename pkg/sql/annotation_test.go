@@ -0,0 +1,61 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sql
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/driver"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+func TestAnnotationValidate(t *testing.T) {
+	template, err := (&config.AnnotationConfig{}).Validate()
+	assert.NoError(t, err)
+	assert.Equal(t, config.DefaultAnnotationTemplate, template)
+	_, err = (&config.AnnotationConfig{Template: "app=orders {session} {host}"}).Validate()
+	assert.EqualError(t, err, "annotation template has unknown field {host}")
+	_, err = (&config.AnnotationConfig{Template: "{session} */ DROP TABLE t"}).Validate()
+	assert.Error(t, err)
+}
+
+func TestAnnotate(t *testing.T) {
+	db := &DB{name: "employees_0"}
+	conn := &driver.BackendConnection{Conn: mysql.NewConn(nil)}
+	ctx := proto.WithUserName(proto.WithConnectionID(context.Background(), 7), "dksl*/")
+	assert.Equal(t, "SELECT 1", db.annotate(ctx, conn, "SELECT 1"))
+
+	assert.Error(t, db.SetAnnotation(&config.AnnotationConfig{Template: "{app}"}))
+	assert.NoError(t, db.SetAnnotation(&config.AnnotationConfig{Template: "app={ {session}/{user}@{route} xid={xid}"}))
+	threads.assign(db.name, conn.ID(), 7, "")
+	threads.bindXID(db.name, conn.ID(), "gs/svc/1")
+	assert.Equal(t, "/* app={ 7/dksl* /@employees_0 xid=gs/svc/1 */ SELECT 1",
+		db.annotate(ctx, conn, "SELECT 1"))
+	threads.release(db.name, conn.ID())
+
+	assert.NoError(t, db.SetAnnotation(&config.AnnotationConfig{}))
+	assert.Equal(t, "/* session= user= route=employees_0 trace= xid= */ SELECT 1",
+		db.annotate(context.Background(), conn, "SELECT 1"))
+
+	assert.NoError(t, db.SetAnnotation(nil))
+	assert.Equal(t, "SELECT 1", db.annotate(ctx, conn, "SELECT 1"))
+}
@@ -0,0 +1,166 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sql
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/constant"
+	err2 "github.com/cectc/dbpack/pkg/errors"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+const (
+	queueRejectedFull    = "full"
+	queueRejectedTimeout = "timeout"
+)
+
+var (
+	queueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dbpack",
+		Subsystem: "db",
+		Name:      "queue_depth",
+		Help:      "number of the statements waiting for the concurrency cap of a data source",
+	}, []string{"db"})
+	runningStatements = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dbpack",
+		Subsystem: "db",
+		Name:      "running_statements",
+		Help:      "number of the statements running under the concurrency cap of a data source",
+	}, []string{"db"})
+	queueRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "dbpack",
+		Subsystem: "db",
+		Name:      "queue_rejected_total",
+		Help:      "count of the statements failed by the concurrency cap of a data source, as the queue is full or they timed out",
+	}, []string{"db", "reason"})
+)
+
+func init() {
+	prometheus.MustRegister(queueDepth, runningStatements, queueRejected)
+}
+
+// concurrencyLimiter caps the statements running on a db, the statements over the cap wait in
+// the queue of their priority, the statements of a single shard are ahead of the scattered ones
+// if the queueing is by priority.
+type concurrencyLimiter struct {
+	db           string
+	max          int
+	maxQueueSize int
+	timeout      time.Duration
+	priority     bool
+
+	mu      sync.Mutex
+	running int
+	// queues are the waiting statements of a single shard and the scattered ones, each one waits
+	// for its channel to be closed
+	queues [2]*list.List
+}
+
+func newConcurrencyLimiter(db string, conf *config.ConcurrencyConfig) (*concurrencyLimiter, error) {
+	timeout, err := conf.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return &concurrencyLimiter{
+		db:           db,
+		max:          conf.MaxConcurrency,
+		maxQueueSize: conf.MaxQueueSize,
+		timeout:      timeout,
+		priority:     conf.Queueing == config.QueueingPriority,
+		queues:       [2]*list.List{list.New(), list.New()},
+	}, nil
+}
+
+// acquire waits for the statement of ctx to run under the cap, it fails if the queue is full or
+// the statement waits over the queue timeout.
+func (limiter *concurrencyLimiter) acquire(ctx context.Context) error {
+	limiter.mu.Lock()
+	queued := limiter.queues[0].Len() + limiter.queues[1].Len()
+	if limiter.running < limiter.max && queued == 0 {
+		limiter.running++
+		limiter.mu.Unlock()
+		runningStatements.WithLabelValues(limiter.db).Inc()
+		return nil
+	}
+	if limiter.maxQueueSize > 0 && queued >= limiter.maxQueueSize {
+		limiter.mu.Unlock()
+		queueRejected.WithLabelValues(limiter.db, queueRejectedFull).Inc()
+		return err2.NewSQLError(constant.ERUnknownError, constant.SSUnknownSQLState,
+			"Too many statements queued for data source %s", limiter.db)
+	}
+	queue := limiter.queues[0]
+	if limiter.priority && proto.IsScatter(ctx) {
+		queue = limiter.queues[1]
+	}
+	ready := make(chan struct{})
+	element := queue.PushBack(ready)
+	limiter.mu.Unlock()
+	queueDepth.WithLabelValues(limiter.db).Inc()
+
+	timer := time.NewTimer(limiter.timeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-ready:
+		return nil
+	case <-timer.C:
+		queueRejected.WithLabelValues(limiter.db, queueRejectedTimeout).Inc()
+		err = err2.NewSQLError(constant.ERUnknownError, constant.SSUnknownSQLState,
+			"Statement waited over %s for data source %s", limiter.timeout, limiter.db)
+	case <-ctx.Done():
+		err = ctx.Err()
+		if err == context.DeadlineExceeded {
+			err = err2.NewSQLError(constant.ERPoolTimeout, constant.SSUnknownSQLState,
+				"statement timed out queued for data source %s", limiter.db)
+		}
+	}
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	select {
+	case <-ready:
+		// the slot is handed over while the statement gives up, it takes it anyway
+		return nil
+	default:
+		queue.Remove(element)
+		queueDepth.WithLabelValues(limiter.db).Dec()
+		return err
+	}
+}
+
+// release hands the slot of a finished statement over to the first statement waiting.
+func (limiter *concurrencyLimiter) release() {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	for _, queue := range limiter.queues {
+		if element := queue.Front(); element != nil {
+			queue.Remove(element)
+			queueDepth.WithLabelValues(limiter.db).Dec()
+			close(element.Value.(chan struct{}))
+			return
+		}
+	}
+	limiter.running--
+	runningStatements.WithLabelValues(limiter.db).Dec()
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	limiter, err := newConcurrencyLimiter("limiter-test", &config.ConcurrencyConfig{
+		MaxConcurrency: 1,
+		MaxQueueSize:   2,
+		QueueTimeout:   "100ms",
+		Queueing:       config.QueueingPriority,
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, limiter.acquire(context.Background()))
+	order := make(chan string, 2)
+	wait := func(ctx context.Context, name string) {
+		go func() {
+			if limiter.acquire(ctx) == nil {
+				order <- name
+			}
+		}()
+	}
+	wait(proto.WithScatter(context.Background()), "scatter")
+	time.Sleep(10 * time.Millisecond)
+	wait(context.Background(), "single")
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, float64(2), testutil.ToFloat64(queueDepth.WithLabelValues("limiter-test")))
+
+	// the queue is full
+	assert.Error(t, limiter.acquire(context.Background()))
+	assert.Equal(t, float64(1), testutil.ToFloat64(queueRejected.WithLabelValues("limiter-test", queueRejectedFull)))
+
+	// the statement of a single shard runs before the scattered one queued earlier
+	limiter.release()
+	assert.Equal(t, "single", <-order)
+	limiter.release()
+	assert.Equal(t, "scatter", <-order)
+	assert.Equal(t, float64(0), testutil.ToFloat64(queueDepth.WithLabelValues("limiter-test")))
+
+	start := time.Now()
+	assert.Error(t, limiter.acquire(context.Background()))
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	assert.Equal(t, float64(1), testutil.ToFloat64(queueRejected.WithLabelValues("limiter-test", queueRejectedTimeout)))
+
+	limiter.release()
+	assert.Equal(t, 0, limiter.running)
+	assert.Equal(t, float64(0), testutil.ToFloat64(runningStatements.WithLabelValues("limiter-test")))
+}
+
+func TestConcurrencyConfig(t *testing.T) {
+	_, err := newConcurrencyLimiter("limiter-test", &config.ConcurrencyConfig{})
+	assert.Error(t, err)
+	_, err = newConcurrencyLimiter("limiter-test", &config.ConcurrencyConfig{MaxConcurrency: 1, Queueing: "lifo"})
+	assert.Error(t, err)
+	limiter, err := newConcurrencyLimiter("limiter-test", &config.ConcurrencyConfig{MaxConcurrency: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Second, limiter.timeout)
+	assert.False(t, limiter.priority)
+}
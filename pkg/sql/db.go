@@ -22,17 +22,33 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/uber-go/atomic"
 	"go.opentelemetry.io/otel/attribute"
 
+	"github.com/cectc/dbpack/pkg/config"
 	"github.com/cectc/dbpack/pkg/constant"
 	"github.com/cectc/dbpack/pkg/driver"
+	err2 "github.com/cectc/dbpack/pkg/errors"
 	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/misc"
 	"github.com/cectc/dbpack/pkg/proto"
 	"github.com/cectc/dbpack/pkg/tracing"
+	"github.com/cectc/dbpack/third_party/parser/ast"
 	"github.com/cectc/dbpack/third_party/pools"
 )
 
+var connectionFailovers = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "dbpack",
+	Subsystem: "db",
+	Name:      "connection_failover_total",
+	Help:      "count of the statements run on another connection because their connection was lost",
+}, []string{"db"})
+
+func init() {
+	prometheus.MustRegister(connectionFailovers)
+}
+
 type DB struct {
 	name                     string
 	status                   proto.DBStatus
@@ -44,12 +60,17 @@ type DB struct {
 	masterName  string
 	writeWeight int
 	readWeight  int
+	passthrough bool
 
 	connectionPreFilters  []proto.DBConnectionPreFilter
 	connectionPostFilters []proto.DBConnectionPostFilter
 
 	inflightRequests *atomic.Int64
 	pingCount        *atomic.Int64
+
+	limiter *concurrencyLimiter
+	// annotation is the comment prepended to the statements, nil if they are sent as they are
+	annotation []*annotationPart
 }
 
 func NewDB(name string,
@@ -160,6 +181,9 @@ func (db *DB) ping() {
 	timer := time.NewTimer(db.pingInterval)
 	for {
 		<-timer.C
+		if db.IsClosed() {
+			return
+		}
 		err := db._ping()
 		if err != nil {
 			log.Errorf("db %s ping failed, err: %v", db.name, err)
@@ -201,10 +225,9 @@ func (db *DB) _ping() (err error) {
 	return
 }
 
+// Close closes the pool, it waits for the connections in use to be returned.
 func (db *DB) Close() {
-	for db.inflightRequests.Load() == 0 {
-		db.pool.Close()
-	}
+	db.pool.Close()
 }
 
 // IsClosed returns true if the db is closed.
@@ -248,6 +271,25 @@ func (db *DB) ReadWeight() int {
 	return db.readWeight
 }
 
+// SetConcurrency caps the statements running on the db outside of transactions.
+func (db *DB) SetConcurrency(conf *config.ConcurrencyConfig) error {
+	limiter, err := newConcurrencyLimiter(db.name, conf)
+	if err != nil {
+		return err
+	}
+	db.limiter = limiter
+	return nil
+}
+
+// SetPassthrough marks the db as a proxy, which is sent the statements as the client sent them.
+func (db *DB) SetPassthrough(passthrough bool) {
+	db.passthrough = passthrough
+}
+
+func (db *DB) IsPassthrough() bool {
+	return db.passthrough
+}
+
 func (db *DB) UseDB(ctx context.Context, schema string) error {
 	spanCtx, span := tracing.GetTraceSpan(ctx, tracing.DBUse)
 	span.SetAttributes(attribute.KeyValue{Key: "db", Value: attribute.StringValue(db.name)})
@@ -304,26 +346,33 @@ func (db *DB) Query(ctx context.Context, query string) (proto.Result, uint16, er
 	span.SetAttributes(attribute.KeyValue{Key: "db", Value: attribute.StringValue(db.name)},
 		attribute.KeyValue{Key: "sql", Value: attribute.StringValue(query)})
 	defer span.End()
+	proto.AddRoutingTarget(spanCtx, db.name)
 
 	db.inflightRequests.Inc()
 	defer db.inflightRequests.Dec()
-
-	r, err := db.pool.Get(spanCtx)
-	if err != nil {
-		err = errors.WithStack(err)
-		return nil, 0, err
-	}
-	defer db.pool.Put(r)
-
-	conn := r.(*driver.BackendConnection)
-	if err := db.doConnectionPreFilter(spanCtx, conn); err != nil {
-		return nil, 0, err
+	if db.limiter != nil {
+		if err := db.limiter.acquire(spanCtx); err != nil {
+			return nil, 0, err
+		}
+		defer db.limiter.release()
 	}
 
-	result, warn, err := conn.ExecuteWithWarningCount(spanCtx, query, true)
+	var (
+		result proto.Result
+		warn   uint16
+	)
+	conn, err := db.withConnection(spanCtx, idempotent(ctx), func(conn *driver.BackendConnection) (err error) {
+		if err = db.doConnectionPreFilter(spanCtx, conn); err != nil {
+			return err
+		}
+		result, warn, err = conn.ExecuteWithWarningCount(spanCtx, db.annotate(spanCtx, conn, query), true)
+		return err
+	})
 	if err != nil {
 		return result, warn, err
 	}
+	defer db.release(conn)
+	db.collectWarnings(spanCtx, conn, warn)
 	if err := db.doConnectionPostFilter(spanCtx, result, conn); err != nil {
 		return nil, 0, err
 	}
@@ -334,17 +383,20 @@ func (db *DB) QueryDirectly(query string) (proto.Result, uint16, error) {
 	db.inflightRequests.Inc()
 	defer db.inflightRequests.Dec()
 
-	r, err := db.pool.Get(context.Background())
+	var (
+		result proto.Result
+		warn   uint16
+	)
+	ctx := proto.WithCommandType(context.Background(), constant.ComQuery)
+	conn, err := db.withConnection(ctx, false, func(conn *driver.BackendConnection) (err error) {
+		result, warn, err = conn.ExecuteWithWarningCount(ctx, query, true)
+		return err
+	})
 	if err != nil {
-		err = errors.WithStack(err)
-		return nil, 0, err
+		return result, warn, err
 	}
-	defer db.pool.Put(r)
-
-	conn := r.(*driver.BackendConnection)
-	ctx := proto.WithCommandType(context.Background(), constant.ComQuery)
-	result, warn, err := conn.ExecuteWithWarningCount(ctx, query, true)
-	return result, warn, err
+	db.release(conn)
+	return result, warn, nil
 }
 
 func (db *DB) ExecuteStmt(ctx context.Context, stmt *proto.Stmt) (proto.Result, uint16, error) {
@@ -353,36 +405,39 @@ func (db *DB) ExecuteStmt(ctx context.Context, stmt *proto.Stmt) (proto.Result,
 	span.SetAttributes(attribute.KeyValue{Key: "db", Value: attribute.StringValue(db.name)},
 		attribute.KeyValue{Key: "sql", Value: attribute.StringValue(query)})
 	defer span.End()
+	proto.AddRoutingTarget(spanCtx, db.name)
 
 	db.inflightRequests.Inc()
 	defer db.inflightRequests.Dec()
+	if db.limiter != nil {
+		if err := db.limiter.acquire(spanCtx); err != nil {
+			return nil, 0, err
+		}
+		defer db.limiter.release()
+	}
 
 	var (
 		result proto.Result
 		args   []interface{}
 		warn   uint16
-		err    error
 	)
 
-	r, err := db.pool.Get(ctx)
-	if err != nil {
-		err = errors.WithStack(err)
-		return nil, 0, err
-	}
-	defer db.pool.Put(r)
-
-	conn := r.(*driver.BackendConnection)
-	if err := db.doConnectionPreFilter(spanCtx, conn); err != nil {
-		return nil, 0, err
-	}
 	for i := 0; i < len(stmt.BindVars); i++ {
 		parameterID := fmt.Sprintf("v%d", i+1)
 		args = append(args, stmt.BindVars[parameterID])
 	}
-	result, warn, err = conn.PrepareQueryArgs(spanCtx, query, args)
+	conn, err := db.withConnection(spanCtx, idempotent(ctx), func(conn *driver.BackendConnection) (err error) {
+		if err = db.doConnectionPreFilter(spanCtx, conn); err != nil {
+			return err
+		}
+		result, warn, err = conn.PrepareQueryArgs(spanCtx, db.annotate(spanCtx, conn, query), args)
+		return err
+	})
 	if err != nil {
 		return result, warn, err
 	}
+	defer db.release(conn)
+	db.collectWarnings(spanCtx, conn, warn)
 	if err := db.doConnectionPostFilter(spanCtx, result, conn); err != nil {
 		return nil, 0, err
 	}
@@ -394,24 +449,33 @@ func (db *DB) ExecuteSql(ctx context.Context, sql string, args ...interface{}) (
 	span.SetAttributes(attribute.KeyValue{Key: "db", Value: attribute.StringValue(db.name)},
 		attribute.KeyValue{Key: "sql", Value: attribute.StringValue(sql)})
 	defer span.End()
+	proto.AddRoutingTarget(spanCtx, db.name)
 
 	db.inflightRequests.Inc()
 	defer db.inflightRequests.Dec()
-
-	r, err := db.pool.Get(spanCtx)
-	if err != nil {
-		err = errors.WithStack(err)
-		return nil, 0, err
-	}
-	defer db.pool.Put(r)
-	conn := r.(*driver.BackendConnection)
-	if err := db.doConnectionPreFilter(spanCtx, conn); err != nil {
-		return nil, 0, err
+	if db.limiter != nil {
+		if err := db.limiter.acquire(spanCtx); err != nil {
+			return nil, 0, err
+		}
+		defer db.limiter.release()
 	}
-	result, warn, err := conn.PrepareQueryArgs(spanCtx, sql, args)
+
+	var (
+		result proto.Result
+		warn   uint16
+	)
+	conn, err := db.withConnection(spanCtx, idempotent(ctx), func(conn *driver.BackendConnection) (err error) {
+		if err = db.doConnectionPreFilter(spanCtx, conn); err != nil {
+			return err
+		}
+		result, warn, err = conn.PrepareQueryArgs(spanCtx, db.annotate(spanCtx, conn, sql), args)
+		return err
+	})
 	if err != nil {
 		return result, warn, err
 	}
+	defer db.release(conn)
+	db.collectWarnings(spanCtx, conn, warn)
 	if err := db.doConnectionPostFilter(spanCtx, result, conn); err != nil {
 		return nil, 0, err
 	}
@@ -422,16 +486,20 @@ func (db *DB) ExecuteSqlDirectly(sql string, args ...interface{}) (proto.Result,
 	db.inflightRequests.Inc()
 	defer db.inflightRequests.Dec()
 
-	r, err := db.pool.Get(context.Background())
+	var (
+		result proto.Result
+		warn   uint16
+	)
+	ctx := proto.WithCommandType(context.Background(), constant.ComStmtExecute)
+	conn, err := db.withConnection(ctx, false, func(conn *driver.BackendConnection) (err error) {
+		result, warn, err = conn.PrepareQueryArgs(ctx, sql, args)
+		return err
+	})
 	if err != nil {
-		err = errors.WithStack(err)
-		return nil, 0, err
+		return result, warn, err
 	}
-	defer db.pool.Put(r)
-	conn := r.(*driver.BackendConnection)
-	ctx := proto.WithCommandType(context.Background(), constant.ComStmtExecute)
-	result, warn, err := conn.PrepareQueryArgs(ctx, sql, args)
-	return result, warn, err
+	db.release(conn)
+	return result, warn, nil
 }
 
 func (db *DB) Begin(ctx context.Context) (proto.Tx, proto.Result, error) {
@@ -444,23 +512,24 @@ func (db *DB) Begin(ctx context.Context) (proto.Tx, proto.Result, error) {
 	spanCtx, span := tracing.GetTraceSpan(ctx, tracing.DBLocalTransactionBegin)
 	span.SetAttributes(attribute.KeyValue{Key: "db", Value: attribute.StringValue(db.name)})
 	defer span.End()
+	proto.AddRoutingTarget(spanCtx, db.name)
 
-	r, err := db.pool.Get(spanCtx)
+	// the transaction is not started on a lost connection, starting it again is safe
+	conn, err = db.withConnection(spanCtx, true, func(conn *driver.BackendConnection) (err error) {
+		result, err = conn.Execute(ctx, "START TRANSACTION", false)
+		return err
+	})
 	if err != nil {
-		err = errors.WithStack(err)
-		return nil, nil, err
-	}
-	conn = r.(*driver.BackendConnection)
-
-	if result, err = conn.Execute(ctx, "START TRANSACTION", false); err != nil {
-		db.pool.Put(r)
 		return nil, nil, err
 	}
 
 	return &Tx{
-		closed: atomic.NewBool(false),
-		db:     db,
-		conn:   conn,
+		closed:   atomic.NewBool(false),
+		db:       db,
+		conn:     conn,
+		name:     db.name,
+		threadID: conn.ID(),
+		since:    time.Now(),
 	}, result, nil
 }
 
@@ -474,26 +543,51 @@ func (db *DB) XAStart(ctx context.Context, sql string) (proto.Tx, proto.Result,
 	spanCtx, span := tracing.GetTraceSpan(ctx, tracing.DBXAStart)
 	span.SetAttributes(attribute.KeyValue{Key: "db", Value: attribute.StringValue(db.name)})
 	defer span.End()
+	proto.AddRoutingTarget(spanCtx, db.name)
 
-	r, err := db.pool.Get(spanCtx)
+	conn, err = db.withConnection(spanCtx, true, func(conn *driver.BackendConnection) (err error) {
+		result, err = conn.Execute(ctx, sql, false)
+		return err
+	})
 	if err != nil {
-		err = errors.WithStack(err)
-		return nil, nil, err
-	}
-	conn = r.(*driver.BackendConnection)
-
-	if result, err = conn.Execute(ctx, sql, false); err != nil {
-		db.pool.Put(r)
 		return nil, nil, err
 	}
+	threads.bindXID(db.name, conn.ID(), xaID(sql))
 
 	return &Tx{
-		closed: atomic.NewBool(false),
-		db:     db,
-		conn:   conn,
+		closed:   atomic.NewBool(false),
+		db:       db,
+		conn:     conn,
+		name:     db.name,
+		threadID: conn.ID(),
+		since:    time.Now(),
+		xa:       true,
 	}, result, nil
 }
 
+// Pin takes a connection from the pool and keeps it for the statements that depend on the state
+// of its session, such as a temporary table. Commit or Rollback of the returned Tx puts it back.
+func (db *DB) Pin(ctx context.Context) (proto.Tx, error) {
+	spanCtx, span := tracing.GetTraceSpan(ctx, tracing.DBPin)
+	span.SetAttributes(attribute.KeyValue{Key: "db", Value: attribute.StringValue(db.name)})
+	defer span.End()
+
+	r, err := db.pool.Get(spanCtx)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	conn := r.(*driver.BackendConnection)
+	threads.assign(db.name, conn.ID(), proto.ConnectionID(ctx), "")
+	return &Tx{
+		closed:   atomic.NewBool(false),
+		db:       db,
+		conn:     conn,
+		name:     db.name,
+		threadID: conn.ID(),
+		since:    time.Now(),
+	}, nil
+}
+
 func (db *DB) SetConnectionPreFilters(filters []proto.DBConnectionPreFilter) {
 	db.connectionPreFilters = filters
 }
@@ -502,6 +596,121 @@ func (db *DB) SetConnectionPostFilters(filters []proto.DBConnectionPostFilter) {
 	db.connectionPostFilters = filters
 }
 
+// withConnection takes a connection from the pool and runs fn on it, the connection is kept by the
+// caller if fn succeeds, and put back otherwise. A connection found lost is closed, so that the pool
+// dials a new one in its place, and fn runs again on another connection, outside of a transaction
+// and with the session state the connection pre filters set, if the statement was not sent, as a
+// dead connection is detected before writing to it, or if resend is true, i.e. the statement is
+// idempotent, in which case it is sent again once at most.
+func (db *DB) withConnection(ctx context.Context, resend bool, fn func(conn *driver.BackendConnection) error) (
+	*driver.BackendConnection, error) {
+	// the idle connections may all be dead once the backend restarted
+	attempts := int(db.pool.Capacity()) + 1
+	for attempt := 1; ; attempt++ {
+		r, err := db.pool.Get(ctx)
+		if err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, err2.NewSQLError(constant.ERPoolTimeout, constant.SSUnknownSQLState,
+					"statement timed out waiting for a connection of data source %s", db.name)
+			}
+			return nil, errors.WithStack(err)
+		}
+		conn := r.(*driver.BackendConnection)
+		threads.assign(db.name, conn.ID(), proto.ConnectionID(ctx), "")
+		if err = fn(conn); err == nil {
+			return conn, nil
+		}
+		lost, sent := connectionLost(err)
+		if !lost {
+			db.release(conn)
+			return nil, err
+		}
+		conn.Close()
+		db.release(conn)
+		if attempt >= attempts || (sent && !resend) {
+			return nil, err
+		}
+		if sent {
+			resend = false
+		}
+		connectionFailovers.WithLabelValues(db.name).Inc()
+		log.Warnf("db %s: connection lost, run the statement on another connection, err: %v", db.name, err)
+	}
+}
+
+// connectionLost reports whether err means the connection is lost, and whether the statement was sent
+// before, CRServerGone is returned if the connection is found dead when the statement is written.
+func connectionLost(err error) (lost bool, sent bool) {
+	if sqlErr, ok := errors.Cause(err).(*err2.SQLError); ok {
+		switch sqlErr.Num {
+		case constant.CRServerGone:
+			return true, false
+		case constant.CRServerLost:
+			return true, true
+		}
+	}
+	return false, false
+}
+
+// idempotent reports whether the statement of ctx may be sent again once its connection is lost,
+// i.e. it is a read taking no lock and having no side effect.
+func idempotent(ctx context.Context) bool {
+	stmt := proto.QueryStmt(ctx)
+	if prepared := proto.PrepareStmt(ctx); stmt == nil && prepared != nil {
+		stmt = prepared.StmtNode
+	}
+	switch stmt.(type) {
+	case *ast.SelectStmt, *ast.SetOprStmt:
+		v := &sideEffectVisitor{}
+		stmt.Accept(v)
+		return !v.found
+	case *ast.ShowStmt, *ast.ExplainStmt:
+		return true
+	}
+	return false
+}
+
+// sideEffectVisitor finds what a read may change when it runs: the row locks it takes, the
+// functions it calls changing the state of the session or of the server, the user variables it
+// assigns and the files or variables it selects into.
+type sideEffectVisitor struct {
+	found bool
+}
+
+func (v *sideEffectVisitor) Enter(n ast.Node) (ast.Node, bool) {
+	switch node := n.(type) {
+	case *ast.SelectStmt:
+		if misc.IsLockingRead(node) || node.SelectIntoOpt != nil {
+			v.found = true
+		}
+	case *ast.FuncCallExpr:
+		switch node.FnName.L {
+		case ast.GetLock, ast.ReleaseLock, ast.ReleaseAllLocks, ast.Sleep, ast.Benchmark,
+			ast.MasterPosWait, ast.NextVal, ast.LastVal, ast.SetVal:
+			v.found = true
+		}
+	case *ast.VariableExpr:
+		if !node.IsSystem && node.Value != nil {
+			v.found = true
+		}
+	}
+	return n, v.found
+}
+
+func (v *sideEffectVisitor) Leave(n ast.Node) (ast.Node, bool) {
+	return n, !v.found
+}
+
+// release returns a backend connection to the pool, a closed one is replaced by a new connection.
+func (db *DB) release(conn *driver.BackendConnection) {
+	threads.release(db.name, conn.ID())
+	if conn.IsClosed() {
+		db.pool.Put(nil)
+		return
+	}
+	db.pool.Put(conn)
+}
+
 func (db *DB) doConnectionPreFilter(ctx context.Context, conn proto.Connection) error {
 	for i := 0; i < len(db.connectionPreFilters); i++ {
 		f := db.connectionPreFilters[i]
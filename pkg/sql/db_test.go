@@ -0,0 +1,167 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sql
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/atomic"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/driver"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/third_party/parser"
+	"github.com/cectc/dbpack/third_party/pools"
+	_ "github.com/cectc/dbpack/third_party/types/parser_driver"
+)
+
+const (
+	// backendOK answers a statement with an OK packet.
+	backendOK = "ok"
+	// backendLost reads a statement and closes the connection without answering it.
+	backendLost = "lost"
+	// backendGone closes the connection before a statement is sent.
+	backendGone = "gone"
+)
+
+// testBackends dials the connections of a pool to backends answering the statements as scripted,
+// one script per connection in the order they are dialed, and records the statements they read.
+type testBackends struct {
+	mu         sync.Mutex
+	scripts    [][]string
+	statements []string
+}
+
+func (b *testBackends) dial(ctx context.Context) (pools.Resource, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.scripts) == 0 {
+		return nil, errors.New("no backend left")
+	}
+	script := b.scripts[0]
+	b.scripts = b.scripts[1:]
+	client, server := net.Pipe()
+	go b.serve(mysql.NewConn(server), script)
+	return &driver.BackendConnection{Conn: mysql.NewConn(client)}, nil
+}
+
+func (b *testBackends) serve(c *mysql.Conn, script []string) {
+	defer c.Close()
+	for _, step := range script {
+		if step == backendGone {
+			return
+		}
+		c.ResetSequence()
+		data, err := c.ReadPacket()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.statements = append(b.statements, string(data[1:]))
+		b.mu.Unlock()
+		if step == backendLost {
+			return
+		}
+		if err = c.WriteOKPacket(0, 0, 0, 0); err != nil {
+			return
+		}
+	}
+	// the connection stays open until the pool closes it
+	_, _ = c.ReadPacket()
+}
+
+func (b *testBackends) sent() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.statements
+}
+
+// newTestDB makes a DB with a pool of a single connection, a lost connection is replaced as soon
+// as it is put back, so the backends are dialed in turn.
+func newTestDB(t *testing.T, name string, scripts ...[]string) (*DB, *testBackends) {
+	backends := &testBackends{scripts: scripts}
+	pool := pools.NewResourcePool(backends.dial, 1, 1, 0, 0, nil)
+	t.Cleanup(pool.Close)
+	return &DB{
+		name:             name,
+		status:           proto.Running,
+		pool:             pool,
+		inflightRequests: atomic.NewInt64(0),
+		pingCount:        atomic.NewInt64(0),
+	}, backends
+}
+
+func queryContext(t *testing.T, sql string) context.Context {
+	stmt, err := parser.New().ParseOneStmt(sql, "", "")
+	assert.NoError(t, err)
+	ctx := proto.WithCommandType(context.Background(), constant.ComQuery)
+	return proto.WithQueryStmt(ctx, stmt)
+}
+
+func TestQueryRetriesOnServerGone(t *testing.T) {
+	db, backends := newTestDB(t, "gone-test", []string{backendGone}, []string{backendOK})
+	// the statement was not sent, it runs on a new connection even if it is not idempotent
+	sql := "UPDATE employees SET first_name = 'dksl' WHERE emp_no = 1"
+	_, _, err := db.Query(queryContext(t, sql), sql)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{sql}, backends.sent())
+	assert.Equal(t, float64(1), testutil.ToFloat64(connectionFailovers.WithLabelValues("gone-test")))
+}
+
+func TestQueryResendsIdempotentReadOnServerLost(t *testing.T) {
+	sql := "SELECT * FROM employees WHERE emp_no = 1"
+	db, backends := newTestDB(t, "lost-test", []string{backendLost}, []string{backendOK})
+	_, _, err := db.Query(queryContext(t, sql), sql)
+	assert.NoError(t, err)
+	// it is sent again once
+	assert.Equal(t, []string{sql, sql}, backends.sent())
+	assert.Equal(t, float64(1), testutil.ToFloat64(connectionFailovers.WithLabelValues("lost-test")))
+}
+
+func TestQueryDoesNotResendOnServerLost(t *testing.T) {
+	for _, sql := range []string{
+		"UPDATE employees SET first_name = 'dksl' WHERE emp_no = 1",
+		"SELECT * FROM employees WHERE emp_no = 1 FOR UPDATE",
+		"SELECT GET_LOCK('employees', 10)",
+		"SELECT SLEEP(1)",
+		"SELECT NEXTVAL(seq)",
+		"SELECT @id := emp_no FROM employees WHERE emp_no = 1",
+		"SELECT emp_no FROM employees WHERE emp_no = 1 UNION SELECT RELEASE_LOCK('employees')",
+	} {
+		db, backends := newTestDB(t, "no-resend-test", []string{backendLost}, []string{backendOK})
+		_, _, err := db.Query(queryContext(t, sql), sql)
+		assert.Error(t, err, sql)
+		assert.Equal(t, []string{sql}, backends.sent(), sql)
+	}
+
+	// the statements in a transaction are not failed over
+	db, backends := newTestDB(t, "tx-test", []string{backendOK, backendLost}, []string{backendOK})
+	tx, _, err := db.Begin(context.Background())
+	assert.NoError(t, err)
+	sql := "SELECT * FROM employees WHERE emp_no = 1"
+	_, _, err = tx.Query(queryContext(t, sql), sql)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"START TRANSACTION", sql}, backends.sent())
+	_, _ = tx.Rollback(context.Background(), nil)
+}
@@ -0,0 +1,148 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sql
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRecentThreads is the number of released thread assignments kept, so that the entries of the
+// backend slow query log written after the statement completed can still be traced back.
+const maxRecentThreads = 1000
+
+// ThreadAssignment is a backend connection, identified by its thread id on the data source, serving
+// a client session, and the global transaction it runs a branch of, if any.
+type ThreadAssignment struct {
+	DataSource string `json:"data_source"`
+	ThreadID   uint32 `json:"thread_id"`
+	// ConnectionID is the id of the client session, 0 for the statements dbpack runs by itself
+	ConnectionID uint32     `json:"connection_id"`
+	XID          string     `json:"xid,omitempty"`
+	Since        time.Time  `json:"since"`
+	Until        *time.Time `json:"until,omitempty"`
+}
+
+type threadKey struct {
+	dataSource string
+	threadID   uint32
+}
+
+type threadRegistry struct {
+	mu     sync.Mutex
+	active map[threadKey]*ThreadAssignment
+	// recent is a ring of the released assignments, next is where the next one is written
+	recent []*ThreadAssignment
+	next   int
+}
+
+var threads = &threadRegistry{active: make(map[threadKey]*ThreadAssignment)}
+
+func (r *threadRegistry) assign(dataSource string, threadID, connectionID uint32, xid string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.active[threadKey{dataSource, threadID}] = &ThreadAssignment{
+		DataSource:   dataSource,
+		ThreadID:     threadID,
+		ConnectionID: connectionID,
+		XID:          xid,
+		Since:        time.Now(),
+	}
+}
+
+func (r *threadRegistry) bindXID(dataSource string, threadID uint32, xid string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if assignment, ok := r.active[threadKey{dataSource, threadID}]; ok {
+		assignment.XID = xid
+	}
+}
+
+func (r *threadRegistry) xid(dataSource string, threadID uint32) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if assignment, ok := r.active[threadKey{dataSource, threadID}]; ok {
+		return assignment.XID
+	}
+	return ""
+}
+
+func (r *threadRegistry) release(dataSource string, threadID uint32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := threadKey{dataSource, threadID}
+	assignment, ok := r.active[key]
+	if !ok {
+		return
+	}
+	delete(r.active, key)
+	until := time.Now()
+	assignment.Until = &until
+	if len(r.recent) < maxRecentThreads {
+		r.recent = append(r.recent, assignment)
+		return
+	}
+	r.recent[r.next] = assignment
+	r.next = (r.next + 1) % maxRecentThreads
+}
+
+func (r *threadRegistry) list(active bool) []*ThreadAssignment {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := make([]*ThreadAssignment, 0)
+	if active {
+		for _, assignment := range r.active {
+			copied := *assignment
+			result = append(result, &copied)
+		}
+		return result
+	}
+	for i := range r.recent {
+		copied := *r.recent[(r.next+i)%len(r.recent)]
+		result = append(result, &copied)
+	}
+	return result
+}
+
+// ActiveThreads lists the backend connections taken from the pools and the client sessions they
+// serve.
+func ActiveThreads() []*ThreadAssignment {
+	return threads.list(true)
+}
+
+// RecentThreads lists the last backend connections put back to the pools, oldest first, with
+// the client sessions they served until then.
+func RecentThreads() []*ThreadAssignment {
+	return threads.list(false)
+}
+
+// BindXID records that the backend connection of threadID on dataSource runs a branch of the global
+// transaction xid, until it is put back to the pool.
+func BindXID(dataSource string, threadID uint32, xid string) {
+	threads.bindXID(dataSource, threadID, xid)
+}
+
+// xaID returns the xid of an XA START or XA BEGIN statement, as written in it.
+func xaID(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) < 3 {
+		return ""
+	}
+	xid := strings.TrimSpace(strings.Join(fields[2:], " "))
+	return strings.TrimSpace(strings.TrimSuffix(xid, ";"))
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sql
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThreadRegistry(t *testing.T) {
+	r := &threadRegistry{active: make(map[threadKey]*ThreadAssignment)}
+	r.assign("employees_0", 11, 1, "")
+	r.assign("employees_1", 11, 2, "")
+	r.bindXID("employees_0", 11, "gs/svc/1")
+	// a connection not taken from the pool is ignored
+	r.bindXID("employees_0", 12, "gs/svc/2")
+	r.release("employees_0", 12)
+
+	active := r.list(true)
+	assert.Len(t, active, 2)
+	for _, assignment := range active {
+		assert.Nil(t, assignment.Until)
+		if assignment.DataSource == "employees_0" {
+			assert.Equal(t, uint32(1), assignment.ConnectionID)
+			assert.Equal(t, "gs/svc/1", assignment.XID)
+		} else {
+			assert.Equal(t, uint32(2), assignment.ConnectionID)
+			assert.Empty(t, assignment.XID)
+		}
+	}
+	assert.Empty(t, r.list(false))
+
+	r.release("employees_0", 11)
+	assert.Len(t, r.list(true), 1)
+	recent := r.list(false)
+	assert.Len(t, recent, 1)
+	assert.Equal(t, uint32(11), recent[0].ThreadID)
+	assert.Equal(t, "gs/svc/1", recent[0].XID)
+	assert.NotNil(t, recent[0].Until)
+
+	// the oldest released assignments are dropped first
+	for i := 0; i < maxRecentThreads; i++ {
+		r.assign("employees_1", uint32(100+i), 3, "")
+		r.release("employees_1", uint32(100+i))
+	}
+	recent = r.list(false)
+	assert.Len(t, recent, maxRecentThreads)
+	assert.Equal(t, uint32(100), recent[0].ThreadID)
+	assert.Equal(t, uint32(100+maxRecentThreads-1), recent[maxRecentThreads-1].ThreadID)
+}
+
+func TestXAID(t *testing.T) {
+	assert.Equal(t, "'gs/svc/1'", xaID("XA START 'gs/svc/1'"))
+	assert.Equal(t, "'gs/svc/1','b1',1", xaID("xa begin 'gs/svc/1','b1',1;"))
+	assert.Equal(t, "", xaID("XA START"))
+}
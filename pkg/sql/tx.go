@@ -19,6 +19,7 @@ package sql
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/uber-go/atomic"
 	"go.opentelemetry.io/otel/attribute"
@@ -35,6 +36,34 @@ type Tx struct {
 	closed *atomic.Bool
 	db     *DB
 	conn   *driver.BackendConnection
+	// name is the name of the data source and threadID the id of the connection on it, kept once
+	// the transaction is closed
+	name     string
+	threadID uint32
+	// since is when the connection was taken from the pool
+	since time.Time
+	// xa is true when the transaction was started by XA START
+	xa bool
+}
+
+// DataSourceName returns the name of the data source of the connection.
+func (tx *Tx) DataSourceName() string {
+	return tx.name
+}
+
+// ThreadID returns the id of the connection on the data source.
+func (tx *Tx) ThreadID() uint32 {
+	return tx.threadID
+}
+
+// PinnedSince returns when the connection was taken from the pool.
+func (tx *Tx) PinnedSince() time.Time {
+	return tx.since
+}
+
+// XA reports whether the transaction was started by XA START.
+func (tx *Tx) XA() bool {
+	return tx.xa
 }
 
 func (tx *Tx) Query(ctx context.Context, query string) (proto.Result, uint16, error) {
@@ -42,6 +71,7 @@ func (tx *Tx) Query(ctx context.Context, query string) (proto.Result, uint16, er
 	span.SetAttributes(attribute.KeyValue{Key: "db", Value: attribute.StringValue(tx.db.name)},
 		attribute.KeyValue{Key: "sql", Value: attribute.StringValue(query)})
 	defer span.End()
+	proto.AddRoutingTarget(spanCtx, tx.db.name)
 
 	tx.db.inflightRequests.Inc()
 	defer tx.db.inflightRequests.Dec()
@@ -49,10 +79,11 @@ func (tx *Tx) Query(ctx context.Context, query string) (proto.Result, uint16, er
 	if err := tx.db.doConnectionPreFilter(spanCtx, tx.conn); err != nil {
 		return nil, 0, err
 	}
-	result, warn, err := tx.conn.ExecuteWithWarningCount(spanCtx, query, true)
+	result, warn, err := tx.conn.ExecuteWithWarningCount(spanCtx, tx.db.annotate(spanCtx, tx.conn, query), true)
 	if err != nil {
 		return result, warn, err
 	}
+	tx.db.collectWarnings(spanCtx, tx.conn, warn)
 	if err := tx.db.doConnectionPostFilter(spanCtx, result, tx.conn); err != nil {
 		return nil, 0, err
 	}
@@ -74,6 +105,7 @@ func (tx *Tx) ExecuteStmt(ctx context.Context, stmt *proto.Stmt) (proto.Result,
 	span.SetAttributes(attribute.KeyValue{Key: "db", Value: attribute.StringValue(tx.db.name)},
 		attribute.KeyValue{Key: "sql", Value: attribute.StringValue(query)})
 	defer span.End()
+	proto.AddRoutingTarget(spanCtx, tx.db.name)
 
 	tx.db.inflightRequests.Inc()
 	defer tx.db.inflightRequests.Dec()
@@ -92,10 +124,11 @@ func (tx *Tx) ExecuteStmt(ctx context.Context, stmt *proto.Stmt) (proto.Result,
 		parameterID := fmt.Sprintf("v%d", i+1)
 		args = append(args, stmt.BindVars[parameterID])
 	}
-	result, warn, err = tx.conn.PrepareQueryArgs(spanCtx, query, args)
+	result, warn, err = tx.conn.PrepareQueryArgs(spanCtx, tx.db.annotate(spanCtx, tx.conn, query), args)
 	if err != nil {
 		return result, warn, err
 	}
+	tx.db.collectWarnings(spanCtx, tx.conn, warn)
 	if err := tx.db.doConnectionPostFilter(spanCtx, result, tx.conn); err != nil {
 		return nil, 0, err
 	}
@@ -107,6 +140,7 @@ func (tx *Tx) ExecuteSql(ctx context.Context, sql string, args ...interface{}) (
 	span.SetAttributes(attribute.KeyValue{Key: "db", Value: attribute.StringValue(tx.db.name)},
 		attribute.KeyValue{Key: "sql", Value: attribute.StringValue(sql)})
 	defer span.End()
+	proto.AddRoutingTarget(spanCtx, tx.db.name)
 
 	tx.db.inflightRequests.Inc()
 	defer tx.db.inflightRequests.Dec()
@@ -114,10 +148,11 @@ func (tx *Tx) ExecuteSql(ctx context.Context, sql string, args ...interface{}) (
 	if err := tx.db.doConnectionPreFilter(spanCtx, tx.conn); err != nil {
 		return nil, 0, err
 	}
-	result, warn, err := tx.conn.PrepareQueryArgs(spanCtx, sql, args)
+	result, warn, err := tx.conn.PrepareQueryArgs(spanCtx, tx.db.annotate(spanCtx, tx.conn, sql), args)
 	if err != nil {
 		return result, warn, err
 	}
+	tx.db.collectWarnings(spanCtx, tx.conn, warn)
 	if err := tx.db.doConnectionPostFilter(spanCtx, result, tx.conn); err != nil {
 		return nil, 0, err
 	}
@@ -145,7 +180,7 @@ func (tx *Tx) Commit(ctx context.Context) (result proto.Result, err error) {
 		return nil, err2.ErrInvalidConn
 	}
 	result, err = tx.conn.Execute(ctx, "COMMIT", false)
-	tx.db.pool.Put(tx.conn)
+	tx.db.release(tx.conn)
 	tx.Close()
 	return
 }
@@ -163,9 +198,16 @@ func (tx *Tx) Rollback(ctx context.Context, stmt *ast.RollbackStmt) (result prot
 	}
 	if stmt != nil && stmt.SavepointName != "" {
 		result, err = tx.conn.Execute(ctx, fmt.Sprintf("ROLLBACK TO %s", stmt.SavepointName), false)
+	} else if tx.xa {
+		// a plain ROLLBACK is rejected inside an XA transaction, closing the session
+		// rolls back an active one and leaves a prepared one to XA RECOVER
+		tx.conn.Close()
+		threads.release(tx.name, tx.threadID)
+		tx.db.pool.Put(nil)
+		tx.Close()
 	} else {
 		result, err = tx.conn.Execute(ctx, "ROLLBACK", false)
-		tx.db.pool.Put(tx.conn)
+		tx.db.release(tx.conn)
 		tx.Close()
 	}
 	return
@@ -183,7 +225,26 @@ func (tx *Tx) XAPrepare(ctx context.Context, sql string) (result proto.Result, e
 		return nil, err2.ErrInvalidConn
 	}
 	result, err = tx.conn.Execute(ctx, sql, false)
-	tx.db.pool.Put(tx.conn)
+	tx.db.release(tx.conn)
+	tx.Close()
+	return
+}
+
+// XAComplete executes XA COMMIT or XA ROLLBACK on the connection pinned by XA START,
+// then returns the connection to the pool.
+func (tx *Tx) XAComplete(ctx context.Context, sql string) (result proto.Result, err error) {
+	_, span := tracing.GetTraceSpan(ctx, tracing.TxXAComplete)
+	span.SetAttributes(attribute.KeyValue{Key: "db", Value: attribute.StringValue(tx.db.name)})
+	defer span.End()
+
+	if tx.closed.Load() {
+		return nil, err2.ErrTransactionClosed
+	}
+	if tx.db == nil || tx.db.IsClosed() {
+		return nil, err2.ErrInvalidConn
+	}
+	result, err = tx.conn.Execute(ctx, sql, false)
+	tx.db.release(tx.conn)
 	tx.Close()
 	return
 }
@@ -0,0 +1,68 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sql
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/driver"
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+const showWarnings = "SHOW WARNINGS"
+
+// collectWarnings reads the warnings of the statement just run on conn if they are collected,
+// the warnings are only kept by the backend connection, which serves other statements once
+// it is released.
+func (db *DB) collectWarnings(ctx context.Context, conn *driver.BackendConnection, warn uint16) {
+	if warn == 0 || !proto.CollectsShardWarnings(ctx) {
+		return
+	}
+	result, err := conn.Execute(proto.WithCommandType(ctx, constant.ComQuery), showWarnings, true)
+	if err != nil {
+		log.Warnf("db %s: failed to read the warnings of the statement: %v", db.name, err)
+		return
+	}
+	defer result.Release()
+	warnings := make([]*proto.Warning, 0, len(result.Rows))
+	for _, row := range result.Rows {
+		values, err := row.Decode()
+		if err != nil || len(values) < 3 {
+			log.Warnf("db %s: failed to decode the warnings of the statement: %v", db.name, err)
+			return
+		}
+		code, _ := strconv.ParseUint(string(valueBytes(values[1])), 10, 16)
+		warnings = append(warnings, &proto.Warning{
+			DataSource: db.name,
+			Level:      string(valueBytes(values[0])),
+			Code:       uint16(code),
+			Message:    string(valueBytes(values[2])),
+		})
+	}
+	proto.AddShardWarnings(ctx, warnings...)
+}
+
+func valueBytes(value *proto.Value) []byte {
+	if value == nil {
+		return nil
+	}
+	b, _ := value.Val.([]byte)
+	return b
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sql
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/driver"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+func TestCollectWarnings(t *testing.T) {
+	db := &DB{name: "employees_0"}
+	// the warnings are neither read if the statement has none nor if they are not collected
+	ctx := proto.WithShardWarnings(context.Background())
+	db.collectWarnings(ctx, nil, 0)
+	db.collectWarnings(context.Background(), nil, 1)
+	assert.Empty(t, proto.ShardWarnings(ctx))
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	queries := make(chan string, 1)
+	go func() {
+		c := mysql.NewConn(server)
+		data, err := c.ReadPacket()
+		if !assert.NoError(t, err) {
+			return
+		}
+		queries <- string(data[1:])
+		assert.NoError(t, c.WriteFields(0, []*mysql.Field{
+			{Name: "Level", FieldType: constant.FieldTypeVarString},
+			{Name: "Code", FieldType: constant.FieldTypeLong},
+			{Name: "Message", FieldType: constant.FieldTypeVarString},
+		}))
+		for _, row := range [][]string{
+			{"Warning", "1265", "Data truncated for column 'name' at row 1"},
+			{"Note", "1051", "Unknown table 'employees.tmp'"},
+		} {
+			var data []byte
+			for _, value := range row {
+				data = append(data, byte(len(value)))
+				data = append(data, value...)
+			}
+			assert.NoError(t, c.WritePacket(data))
+		}
+		assert.NoError(t, c.WriteEndResult(0, false, 0, 0, 0))
+	}()
+
+	conn := &driver.BackendConnection{Conn: mysql.NewConn(client)}
+	db.collectWarnings(proto.WithCommandType(ctx, constant.ComStmtExecute), conn, 2)
+	assert.Equal(t, showWarnings, <-queries)
+	assert.Equal(t, []*proto.Warning{
+		{DataSource: "employees_0", Level: "Warning", Code: 1265, Message: "Data truncated for column 'name' at row 1"},
+		{DataSource: "employees_0", Level: "Note", Code: 1051, Message: "Unknown table 'employees.tmp'"},
+	}, proto.ShardWarnings(ctx))
+}
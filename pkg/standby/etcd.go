@@ -0,0 +1,163 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package standby
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+	"google.golang.org/grpc"
+
+	"github.com/cectc/dbpack/pkg/log"
+)
+
+const (
+	stateKeyFormat   = "%s/active-standby/state"
+	reportKeyFormat  = "%s/active-standby/instances/"
+	watchKeyFormat   = "%s/active-standby/"
+	stateKeyNotFound = 0
+)
+
+type etcdStore struct {
+	client    *clientv3.Client
+	stateKey  string
+	reportKey string
+	watchKey  string
+	leaseTTL  int
+	// session holds the lease of the report of this instance
+	session *concurrency.Session
+}
+
+func NewEtcdStore(config clientv3.Config, appID string, leaseTTL int) Store {
+	if config.DialTimeout == 0 {
+		config.DialTimeout = 5 * time.Second
+	}
+	config.DialOptions = append(config.DialOptions, []grpc.DialOption{grpc.WithBlock()}...)
+	client, err := clientv3.New(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return &etcdStore{
+		client:    client,
+		stateKey:  fmt.Sprintf(stateKeyFormat, appID),
+		reportKey: fmt.Sprintf(reportKeyFormat, appID),
+		watchKey:  fmt.Sprintf(watchKeyFormat, appID),
+		leaseTTL:  leaseTTL,
+	}
+}
+
+func (s *etcdStore) GetState(ctx context.Context) (*State, int64, error) {
+	resp, err := s.client.Get(ctx, s.stateKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, stateKeyNotFound, nil
+	}
+	state := &State{}
+	if err = json.Unmarshal(resp.Kvs[0].Value, state); err != nil {
+		return nil, 0, err
+	}
+	return state, resp.Kvs[0].ModRevision, nil
+}
+
+func (s *etcdStore) PutState(ctx context.Context, state *State, revision int64) (bool, error) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return false, err
+	}
+	resp, err := s.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(s.stateKey), "=", revision)).
+		Then(clientv3.OpPut(s.stateKey, string(data))).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	return resp.Succeeded, nil
+}
+
+func (s *etcdStore) PutReport(ctx context.Context, report *Report) (<-chan struct{}, error) {
+	if s.session != nil {
+		select {
+		case <-s.session.Done():
+			// a session can not be reused once its lease expired
+			s.session = nil
+		default:
+		}
+	}
+	if s.session == nil {
+		session, err := concurrency.NewSession(s.client, concurrency.WithTTL(s.leaseTTL))
+		if err != nil {
+			return nil, err
+		}
+		s.session = session
+	}
+	data, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = s.client.Put(ctx, s.reportKey+report.Instance, string(data),
+		clientv3.WithLease(s.session.Lease())); err != nil {
+		return nil, err
+	}
+	return s.session.Done(), nil
+}
+
+func (s *etcdStore) ListReports(ctx context.Context) ([]*Report, error) {
+	resp, err := s.client.Get(ctx, s.reportKey, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	reports := make([]*Report, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		report := &Report{}
+		if err = json.Unmarshal(kv.Value, report); err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+func (s *etcdStore) Watch(ctx context.Context) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+	go func() {
+		for {
+			for resp := range s.client.Watch(ctx, s.watchKey, clientv3.WithPrefix()) {
+				if resp.Err() != nil {
+					log.Warnf("watch %s failed, %v", s.watchKey, resp.Err())
+					continue
+				}
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+				// the watch channel is closed if the watch is canceled, e.g. compacted, watch again
+			}
+		}
+	}()
+	return changes
+}
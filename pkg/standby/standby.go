@@ -0,0 +1,414 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package standby coordinates the dbpack deployments of several datacenters, so that the
+// deployments of one datacenter, the active one, accept writes at a time. The deployments of the
+// other datacenters are standby, they reject the writes of their clients and keep their data
+// sources read only, while the reads are served as usual.
+//
+// The active datacenter is stored in etcd with an epoch, which a switchover increments. The
+// instances report the epoch they applied and whether they accept writes. Once a switchover is
+// stored, the instances of the former active datacenter stop accepting writes and set their data
+// sources read only, then the instances of the new active datacenter wait for all of them to
+// report so before they set their data sources writable and accept writes. A forced switchover
+// does not wait, it is for the drills where the former active datacenter is lost.
+package standby
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/atomic"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/constant"
+	err2 "github.com/cectc/dbpack/pkg/errors"
+	"github.com/cectc/dbpack/pkg/log"
+	"github.com/cectc/dbpack/pkg/misc"
+	"github.com/cectc/dbpack/pkg/resource"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+)
+
+const (
+	DefaultLeaseTTL = 10
+
+	// resyncInterval is the interval of applying the stored state even though no change is watched,
+	// so that a failed attempt is retried
+	resyncInterval = 5 * time.Second
+)
+
+var writableGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "dbpack",
+	Subsystem: "active_standby",
+	Name:      "writable",
+	Help:      "1 if the instance accepts writes, 0 if it is standby",
+}, []string{"appid", "datacenter"})
+
+func init() {
+	prometheus.MustRegister(writableGauge)
+}
+
+var (
+	coordinators = make(map[string]*Coordinator)
+
+	// ErrSameDatacenter is returned by a switchover to the datacenter which is active already
+	ErrSameDatacenter = errors.New("the datacenter is active already")
+)
+
+// State is the active datacenter stored in etcd.
+type State struct {
+	Active string `json:"active"`
+	// Epoch is incremented by every switchover
+	Epoch int64 `json:"epoch"`
+	// Force means the switchover did not wait for the former active datacenter to be standby
+	Force bool      `json:"force,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// Report is the role an instance reports, it is removed once the instance is gone.
+type Report struct {
+	Instance   string `json:"instance"`
+	Datacenter string `json:"datacenter"`
+	// Epoch is the epoch of the state the instance applied
+	Epoch    int64     `json:"epoch"`
+	Writable bool      `json:"writable"`
+	Time     time.Time `json:"time"`
+}
+
+// Store keeps the state and the reports of an application.
+type Store interface {
+	// GetState returns the state and its revision, nil and 0 if it is not stored yet.
+	GetState(ctx context.Context) (*State, int64, error)
+	// PutState stores state if the revision of the stored one is still revision, 0 meaning it is
+	// not stored, and reports whether it did.
+	PutState(ctx context.Context, state *State, revision int64) (bool, error)
+	// PutReport stores the report of this instance, it is bound to a lease, the returned channel
+	// is closed when the lease is lost.
+	PutReport(ctx context.Context, report *Report) (<-chan struct{}, error)
+	ListReports(ctx context.Context) ([]*Report, error)
+	// Watch notifies the changes of the state and the reports, until ctx is done.
+	Watch(ctx context.Context) <-chan struct{}
+}
+
+// Status is the status of an instance, served by the admin api.
+type Status struct {
+	Instance   string    `json:"instance"`
+	Datacenter string    `json:"datacenter"`
+	Writable   bool      `json:"writable"`
+	State      *State    `json:"state"`
+	Reports    []*Report `json:"reports"`
+	// Waiting lists the instances the datacenter waits for to be standby before it accepts writes
+	Waiting []string `json:"waiting,omitempty"`
+}
+
+// Coordinator applies the stored state to the instance.
+type Coordinator struct {
+	appID         string
+	datacenter    string
+	initialActive string
+	instance      string
+	dataSources   []string
+	superReadOnly bool
+	store         Store
+
+	writable *atomic.Bool
+
+	mu sync.Mutex
+	// epoch is the epoch of the state applied
+	epoch int64
+	// readOnly is true once the data sources are set read only, and false once they are set writable
+	readOnly *bool
+	waiting  []string
+	// leaseDone is closed when the lease of the report is lost
+	leaseDone <-chan struct{}
+}
+
+// RegisterCoordinator creates the coordinator of an application and runs it until ctx is done.
+// The instance rejects writes until it has applied the stored state.
+func RegisterCoordinator(ctx context.Context, conf *config.ActiveStandby, dataSources []*config.DataSource) {
+	if conf.LeaseTTL <= 0 {
+		conf.LeaseTTL = DefaultLeaseTTL
+	}
+	coordinator := newCoordinator(conf, dataSources, NewEtcdStore(*conf.EtcdConfig, conf.AppID, conf.LeaseTTL))
+	coordinators[conf.AppID] = coordinator
+	go coordinator.run(ctx)
+}
+
+func newCoordinator(conf *config.ActiveStandby, dataSources []*config.DataSource, store Store) *Coordinator {
+	names := conf.DataSources
+	if len(names) == 0 {
+		for _, dataSource := range dataSources {
+			if dataSource.MasterName == "" {
+				names = append(names, dataSource.Name)
+			}
+		}
+	}
+	hostname, _ := os.Hostname()
+	coordinator := &Coordinator{
+		appID:         conf.AppID,
+		datacenter:    conf.Datacenter,
+		initialActive: conf.InitialActive,
+		instance:      fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		dataSources:   names,
+		superReadOnly: conf.SuperReadOnly,
+		store:         store,
+		writable:      atomic.NewBool(false),
+	}
+	writableGauge.WithLabelValues(conf.AppID, conf.Datacenter).Set(0)
+	return coordinator
+}
+
+// GetCoordinator returns the coordinator of an application, nil if it is not coordinated.
+func GetCoordinator(appID string) *Coordinator {
+	return coordinators[appID]
+}
+
+// CheckWrite fails the statements writing data or changing the schema while the datacenter of the
+// application is standby, stmt is nil if the statement is not parsed.
+func CheckWrite(appID string, stmt ast.StmtNode, sql string) error {
+	coordinator, ok := coordinators[appID]
+	if !ok || coordinator.writable.Load() || !isWrite(stmt, sql) {
+		return nil
+	}
+	return err2.NewSQLError(constant.EROptionPreventsStatement, constant.SSUnknownSQLState,
+		"datacenter %s is standby, the statement must be sent to the active datacenter", coordinator.datacenter)
+}
+
+func isWrite(stmt ast.StmtNode, sql string) bool {
+	switch stmt.(type) {
+	case nil:
+		// XA statements end the transactions in progress, their writes were checked
+		return misc.XACommand(sql) == "" && !misc.IsReadStatement(sql)
+	case *ast.InsertStmt, *ast.UpdateStmt, *ast.DeleteStmt, *ast.LoadDataStmt, *ast.CallStmt:
+		return true
+	case ast.DDLNode:
+		return true
+	}
+	return false
+}
+
+// Writable reports whether the instance accepts writes.
+func (c *Coordinator) Writable() bool {
+	return c.writable.Load()
+}
+
+// Switchover makes datacenter the active one. The instances apply it asynchronously, its progress
+// is reported by Status.
+func (c *Coordinator) Switchover(ctx context.Context, datacenter string, force bool) (*State, error) {
+	state, revision, err := c.store.GetState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, errors.New("the active datacenter is not stored yet")
+	}
+	if state.Active == datacenter {
+		return nil, ErrSameDatacenter
+	}
+	next := &State{Active: datacenter, Epoch: state.Epoch + 1, Force: force, Time: time.Now()}
+	ok, err := c.store.PutState(ctx, next, revision)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("the active datacenter was changed concurrently, retry the switchover")
+	}
+	log.Infof("active standby of %s: switch over from datacenter %s to %s, epoch %d, force %v",
+		c.appID, state.Active, datacenter, next.Epoch, force)
+	return next, nil
+}
+
+// Status returns the status of the instance, and the state and the reports stored.
+func (c *Coordinator) Status(ctx context.Context) (*Status, error) {
+	state, _, err := c.store.GetState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reports, err := c.store.ListReports(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	waiting := c.waiting
+	c.mu.Unlock()
+	return &Status{
+		Instance:   c.instance,
+		Datacenter: c.datacenter,
+		Writable:   c.writable.Load(),
+		State:      state,
+		Reports:    reports,
+		Waiting:    waiting,
+	}, nil
+}
+
+func (c *Coordinator) run(ctx context.Context) {
+	changes := c.store.Watch(ctx)
+	ticker := time.NewTicker(resyncInterval)
+	defer ticker.Stop()
+	for {
+		if err := c.reconcile(ctx); err != nil {
+			log.Errorf("active standby of %s: apply state failed, %v", c.appID, err)
+		}
+		c.mu.Lock()
+		leaseDone := c.leaseDone
+		c.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-changes:
+		case <-ticker.C:
+		case <-leaseDone:
+			// the other instances may not wait for this instance anymore
+			log.Warnf("active standby of %s: lease lost, reject writes until it is renewed", c.appID)
+			c.setWritable(false)
+			c.mu.Lock()
+			c.leaseDone = nil
+			c.mu.Unlock()
+		}
+	}
+}
+
+// reconcile applies the stored state to the instance and reports it.
+func (c *Coordinator) reconcile(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state, revision, err := c.store.GetState(ctx)
+	if err != nil {
+		c.setWritable(false)
+		return err
+	}
+	if state == nil {
+		state = &State{Active: c.initialActive, Epoch: 1, Time: time.Now()}
+		if _, err = c.store.PutState(ctx, state, revision); err != nil {
+			return err
+		}
+		// the state is applied once watched, whoever stored it
+		return nil
+	}
+
+	c.waiting = nil
+	if state.Active != c.datacenter {
+		// writes are rejected before the data sources are set read only, so that the writes in
+		// progress are the only ones setting read only waits for
+		c.setWritable(false)
+		if err = c.setReadOnly(true); err != nil {
+			return err
+		}
+	} else if !c.writable.Load() {
+		if !state.Force {
+			if c.waiting, err = c.writers(ctx, state); err != nil {
+				return err
+			}
+			if len(c.waiting) > 0 {
+				log.Infof("active standby of %s: wait for %v to be standby before accepting writes", c.appID, c.waiting)
+				return c.report(ctx, state.Epoch)
+			}
+		}
+		if err = c.setReadOnly(false); err != nil {
+			return err
+		}
+		c.setWritable(true)
+	}
+	if c.epoch != state.Epoch {
+		log.Infof("active standby of %s: applied epoch %d, active datacenter %s, writable %v",
+			c.appID, state.Epoch, state.Active, c.writable.Load())
+		c.epoch = state.Epoch
+	}
+	return c.report(ctx, state.Epoch)
+}
+
+// writers returns the instances of the other datacenters which may still accept writes, because
+// they did not apply the state yet.
+func (c *Coordinator) writers(ctx context.Context, state *State) ([]string, error) {
+	reports, err := c.store.ListReports(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var writers []string
+	for _, report := range reports {
+		if report.Datacenter != c.datacenter && (report.Writable || report.Epoch < state.Epoch) {
+			writers = append(writers, report.Instance)
+		}
+	}
+	return writers, nil
+}
+
+func (c *Coordinator) report(ctx context.Context, epoch int64) error {
+	leaseDone, err := c.store.PutReport(ctx, &Report{
+		Instance:   c.instance,
+		Datacenter: c.datacenter,
+		Epoch:      epoch,
+		Writable:   c.writable.Load(),
+		Time:       time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	c.leaseDone = leaseDone
+	return nil
+}
+
+func (c *Coordinator) setWritable(writable bool) {
+	if c.writable.Swap(writable) == writable {
+		return
+	}
+	if writable {
+		writableGauge.WithLabelValues(c.appID, c.datacenter).Set(1)
+	} else {
+		writableGauge.WithLabelValues(c.appID, c.datacenter).Set(0)
+	}
+}
+
+// setReadOnly sets read_only, and super_read_only if configured, of the data sources.
+func (c *Coordinator) setReadOnly(readOnly bool) error {
+	if c.readOnly != nil && *c.readOnly == readOnly {
+		return nil
+	}
+	value := "OFF"
+	statements := []string{"SET GLOBAL read_only = OFF"}
+	if c.superReadOnly {
+		// super_read_only is turned off before read_only, which can not be turned off while it is on
+		statements = []string{"SET GLOBAL super_read_only = OFF", "SET GLOBAL read_only = OFF"}
+	}
+	if readOnly {
+		value = "ON"
+		statements = []string{"SET GLOBAL read_only = ON"}
+		if c.superReadOnly {
+			statements = append(statements, "SET GLOBAL super_read_only = ON")
+		}
+	}
+	dbManager := resource.GetDBManager(c.appID)
+	for _, name := range c.dataSources {
+		db := dbManager.GetDB(name)
+		if db == nil {
+			return errors.Errorf("DB resource is not exist, db name: %s", name)
+		}
+		for _, statement := range statements {
+			if _, _, err := db.ExecuteSqlDirectly(statement); err != nil {
+				return errors.Wrapf(err, "set read only %s of data source %s failed", value, name)
+			}
+		}
+	}
+	c.readOnly = &readOnly
+	log.Infof("active standby of %s: set read only %s of data sources %v", c.appID, value, c.dataSources)
+	return nil
+}
@@ -0,0 +1,178 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package standby
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/resource"
+	"github.com/cectc/dbpack/testdata"
+	"github.com/cectc/dbpack/third_party/parser"
+	_ "github.com/cectc/dbpack/third_party/types/parser_driver"
+)
+
+// memoryStore is a Store shared by the coordinators of a test, the revision of the state is the
+// number of times it was stored.
+type memoryStore struct {
+	mu       sync.Mutex
+	state    *State
+	revision int64
+	reports  map[string]*Report
+}
+
+func (s *memoryStore) GetState(ctx context.Context) (*State, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state, s.revision, nil
+}
+
+func (s *memoryStore) PutState(ctx context.Context, state *State, revision int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.revision != revision {
+		return false, nil
+	}
+	s.state = state
+	s.revision++
+	return true, nil
+}
+
+func (s *memoryStore) PutReport(ctx context.Context, report *Report) (<-chan struct{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reports[report.Instance] = report
+	return nil, nil
+}
+
+func (s *memoryStore) ListReports(ctx context.Context) ([]*Report, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reports := make([]*Report, 0, len(s.reports))
+	for _, report := range s.reports {
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+func (s *memoryStore) Watch(ctx context.Context) <-chan struct{} {
+	return nil
+}
+
+func newTestCoordinator(t *testing.T, ctrl *gomock.Controller, store Store, datacenter string,
+	superReadOnly bool) (*Coordinator, *testdata.MockDB) {
+	appID := "standby_" + datacenter
+	db := testdata.NewMockDB(ctrl)
+	dbManager := testdata.NewMockDBManager(ctrl)
+	dbManager.EXPECT().GetDB("employees").Return(db).AnyTimes()
+	resource.SetDBManager(appID, dbManager)
+
+	c := newCoordinator(&config.ActiveStandby{
+		AppID:         appID,
+		Datacenter:    datacenter,
+		InitialActive: "dc1",
+		SuperReadOnly: superReadOnly,
+	}, []*config.DataSource{
+		{Name: "employees"},
+		{Name: "employees-slave", MasterName: "employees"},
+	}, store)
+	c.instance = datacenter + "-1"
+	coordinators[appID] = c
+	t.Cleanup(func() {
+		delete(coordinators, appID)
+	})
+	return c, db
+}
+
+func expectStatements(db *testdata.MockDB, statements ...string) {
+	for _, statement := range statements {
+		db.EXPECT().ExecuteSqlDirectly(statement).Return(&mysql.Result{}, uint16(0), nil)
+	}
+}
+
+func TestSwitchover(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+	store := &memoryStore{reports: make(map[string]*Report)}
+	dc1, db1 := newTestCoordinator(t, ctrl, store, "dc1", false)
+	dc2, db2 := newTestCoordinator(t, ctrl, store, "dc2", true)
+
+	insert, err := parser.New().ParseOneStmt("insert into employees values (1)", "", "")
+	assert.NoError(t, err)
+	sel, err := parser.New().ParseOneStmt("select * from employees", "", "")
+	assert.NoError(t, err)
+	// writes are rejected until the state is applied
+	assert.Error(t, CheckWrite(dc1.appID, insert, ""))
+	assert.NoError(t, CheckWrite("other_app", insert, ""))
+
+	// the initial state is stored, then applied
+	assert.NoError(t, dc1.reconcile(ctx))
+	assert.False(t, dc1.Writable())
+	expectStatements(db1, "SET GLOBAL read_only = OFF")
+	assert.NoError(t, dc1.reconcile(ctx))
+	assert.True(t, dc1.Writable())
+	expectStatements(db2, "SET GLOBAL read_only = ON", "SET GLOBAL super_read_only = ON")
+	assert.NoError(t, dc2.reconcile(ctx))
+	assert.False(t, dc2.Writable())
+	// applied already, the data sources are not set again
+	assert.NoError(t, dc2.reconcile(ctx))
+
+	assert.NoError(t, CheckWrite(dc1.appID, insert, ""))
+	assert.Error(t, CheckWrite(dc2.appID, insert, ""))
+	assert.NoError(t, CheckWrite(dc2.appID, sel, ""))
+	assert.Error(t, CheckWrite(dc2.appID, nil, "CREATE TABLE t (id INT)"))
+	assert.NoError(t, CheckWrite(dc2.appID, nil, "XA COMMIT 'xid1'"))
+
+	_, err = dc1.Switchover(ctx, "dc1", false)
+	assert.ErrorIs(t, err, ErrSameDatacenter)
+	state, err := dc1.Switchover(ctx, "dc2", false)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), state.Epoch)
+
+	// dc2 waits for dc1 to be standby
+	assert.NoError(t, dc2.reconcile(ctx))
+	assert.False(t, dc2.Writable())
+	status, err := dc2.Status(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dc1-1"}, status.Waiting)
+
+	expectStatements(db1, "SET GLOBAL read_only = ON")
+	assert.NoError(t, dc1.reconcile(ctx))
+	assert.False(t, dc1.Writable())
+	expectStatements(db2, "SET GLOBAL super_read_only = OFF", "SET GLOBAL read_only = OFF")
+	assert.NoError(t, dc2.reconcile(ctx))
+	assert.True(t, dc2.Writable())
+
+	// a forced switchover does not wait for the former active datacenter
+	_, err = dc2.Switchover(ctx, "dc1", true)
+	assert.NoError(t, err)
+	expectStatements(db1, "SET GLOBAL read_only = OFF")
+	assert.NoError(t, dc1.reconcile(ctx))
+	assert.True(t, dc1.Writable())
+	status, err = dc1.Status(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "dc1", status.State.Active)
+	assert.Equal(t, int64(3), status.State.Epoch)
+}
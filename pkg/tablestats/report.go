@@ -0,0 +1,183 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tablestats
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+// shardSuffix is the suffix of the physical tables of a logic table, such as student_0.
+var shardSuffix = regexp.MustCompile(`_\d+$`)
+
+// UnusedReport is the tables and the indexes of a database not touched since the statistics
+// are collected.
+type UnusedReport struct {
+	Since   time.Time      `json:"since"`
+	Tables  []string       `json:"tables"`
+	Indexes []*UnusedIndex `json:"indexes"`
+}
+
+// UnusedIndex is an index of a used table, which is neither hinted nor has its leading column
+// in the where, join or order by clauses of the statements. It is a candidate for the cleanup
+// rather than certainly unused, as only the clauses of the statements are known.
+type UnusedIndex struct {
+	Table   string   `json:"table"`
+	Index   string   `json:"index"`
+	Columns []string `json:"columns"`
+}
+
+type inventoryIndex struct {
+	name    string
+	unique  bool
+	columns []string
+}
+
+// Unused reports the tables and the indexes of the database of db which are not used. The tables
+// are matched by their names regardless of the schema, and the physical tables of a sharded table,
+// named as the logic table with the suffix of the shard, are matched to the logic table.
+func Unused(db proto.DB) (*UnusedReport, error) {
+	tableNames, err := queryStrings(db, "SELECT TABLE_NAME FROM information_schema.TABLES "+
+		"WHERE TABLE_SCHEMA = DATABASE() AND TABLE_TYPE = 'BASE TABLE' ORDER BY TABLE_NAME")
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := queryIndexes(db)
+	if err != nil {
+		return nil, err
+	}
+
+	used := make(map[string]*TableStats)
+	for _, stats := range Tables(0) {
+		name := strings.ToLower(stats.Table)
+		if merged, ok := used[name]; ok {
+			for column, count := range stats.Columns {
+				merged.Columns[column] += count
+			}
+			for index, count := range stats.Indexes {
+				merged.Indexes[index] += count
+			}
+			continue
+		}
+		used[name] = stats
+	}
+
+	report := &UnusedReport{Since: Since(), Tables: make([]string, 0), Indexes: make([]*UnusedIndex, 0)}
+	for _, tableName := range tableNames {
+		name := strings.ToLower(tableName)
+		stats, ok := used[name]
+		if !ok {
+			stats, ok = used[shardSuffix.ReplaceAllString(name, "")]
+		}
+		if !ok {
+			report.Tables = append(report.Tables, tableName)
+			continue
+		}
+		for _, index := range indexes[tableName] {
+			// the unique indexes enforce constraints even if no statement uses them
+			if index.unique {
+				continue
+			}
+			if stats.Indexes[strings.ToLower(index.name)] > 0 || stats.Columns[strings.ToLower(index.columns[0])] > 0 {
+				continue
+			}
+			report.Indexes = append(report.Indexes, &UnusedIndex{Table: tableName, Index: index.name, Columns: index.columns})
+		}
+	}
+	return report, nil
+}
+
+func queryIndexes(db proto.DB) (map[string][]*inventoryIndex, error) {
+	result, _, err := db.ExecuteSqlDirectly("SELECT TABLE_NAME, INDEX_NAME, NON_UNIQUE, COLUMN_NAME " +
+		"FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = DATABASE() " +
+		"ORDER BY TABLE_NAME, INDEX_NAME, SEQ_IN_INDEX")
+	if err != nil {
+		return nil, errors.Wrap(err, "query indexes failed")
+	}
+	rows, err := decodeRows(result)
+	if err != nil {
+		return nil, err
+	}
+	indexes := make(map[string][]*inventoryIndex)
+	for _, row := range rows {
+		tableName, indexName := row[0], row[1]
+		tableIndexes := indexes[tableName]
+		if n := len(tableIndexes); n > 0 && tableIndexes[n-1].name == indexName {
+			tableIndexes[n-1].columns = append(tableIndexes[n-1].columns, row[3])
+			continue
+		}
+		indexes[tableName] = append(tableIndexes, &inventoryIndex{
+			name:    indexName,
+			unique:  row[2] == "0",
+			columns: []string{row[3]},
+		})
+	}
+	return indexes, nil
+}
+
+func queryStrings(db proto.DB, sql string) ([]string, error) {
+	result, _, err := db.ExecuteSqlDirectly(sql)
+	if err != nil {
+		return nil, errors.Wrap(err, "query tables failed")
+	}
+	rows, err := decodeRows(result)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]string, 0, len(rows))
+	for _, row := range rows {
+		values = append(values, row[0])
+	}
+	return values, nil
+}
+
+func decodeRows(result proto.Result) ([][]string, error) {
+	rlt, ok := result.(*mysql.Result)
+	if !ok {
+		return nil, errors.Errorf("unexpected result %T", result)
+	}
+	rows := make([][]string, 0, len(rlt.Rows))
+	for _, row := range rlt.Rows {
+		values, err := row.Decode()
+		if err != nil {
+			return nil, err
+		}
+		decoded := make([]string, len(values))
+		for i, value := range values {
+			if value == nil || value.Val == nil {
+				continue
+			}
+			switch val := value.Val.(type) {
+			case []byte:
+				decoded[i] = string(val)
+			case string:
+				decoded[i] = val
+			default:
+				decoded[i] = fmt.Sprint(val)
+			}
+		}
+		rows = append(rows, decoded)
+	}
+	return rows, nil
+}
@@ -0,0 +1,375 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package tablestats collects the tables, columns and indexes the statements of the clients touch,
+// for reporting the hot and the unused tables and indexes, which help to choose the sharding keys
+// and to clean up the indexes.
+package tablestats
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cectc/dbpack/third_party/parser/ast"
+)
+
+var (
+	lock   sync.Mutex
+	since  = time.Now()
+	tables = make(map[tableKey]*table)
+)
+
+type tableKey struct {
+	schema string
+	table  string
+}
+
+type table struct {
+	reads      uint64
+	writes     uint64
+	lastAccess time.Time
+	columns    map[string]uint64
+	indexes    map[string]uint64
+}
+
+// TableStats is the usage of a table since the statistics are collected.
+type TableStats struct {
+	Schema     string    `json:"schema"`
+	Table      string    `json:"table"`
+	Reads      uint64    `json:"reads"`
+	Writes     uint64    `json:"writes"`
+	LastAccess time.Time `json:"last_access"`
+	// Columns are the counts of the statements filtering, joining or ordering by the columns
+	Columns map[string]uint64 `json:"columns,omitempty"`
+	// Indexes are the counts of the statements hinting to use the indexes
+	Indexes map[string]uint64 `json:"indexes,omitempty"`
+}
+
+// Record collects the tables touched by stmt, the tables without a schema are of schema. Only
+// the queries and the DML statements are collected.
+func Record(schema string, stmt ast.StmtNode) {
+	switch stmt.(type) {
+	case *ast.SelectStmt, *ast.SetOprStmt, *ast.InsertStmt, *ast.UpdateStmt, *ast.DeleteStmt:
+	default:
+		return
+	}
+	v := newTableRefVisitor(stmt)
+	stmt.Accept(v)
+	v.resolveColumns()
+	if len(v.refs) == 0 {
+		return
+	}
+
+	now := time.Now()
+	lock.Lock()
+	defer lock.Unlock()
+	for _, ref := range v.refs {
+		key := tableKey{schema: schema, table: ref.table}
+		if ref.schema != "" {
+			key.schema = ref.schema
+		}
+		t, ok := tables[key]
+		if !ok {
+			t = &table{columns: make(map[string]uint64), indexes: make(map[string]uint64)}
+			tables[key] = t
+		}
+		if ref.write {
+			t.writes++
+		} else {
+			t.reads++
+		}
+		t.lastAccess = now
+		for column := range ref.columns {
+			t.columns[column]++
+		}
+		for index := range ref.indexes {
+			t.indexes[index]++
+		}
+	}
+}
+
+// Since returns the time the statistics are collected since.
+func Since() time.Time {
+	lock.Lock()
+	defer lock.Unlock()
+	return since
+}
+
+// Tables returns the usage of the tables, the hottest first, at most limit tables if limit is
+// positive.
+func Tables(limit int) []*TableStats {
+	lock.Lock()
+	result := make([]*TableStats, 0, len(tables))
+	for key, t := range tables {
+		stats := &TableStats{
+			Schema:     key.schema,
+			Table:      key.table,
+			Reads:      t.reads,
+			Writes:     t.writes,
+			LastAccess: t.lastAccess,
+			Columns:    make(map[string]uint64, len(t.columns)),
+			Indexes:    make(map[string]uint64, len(t.indexes)),
+		}
+		for column, count := range t.columns {
+			stats.Columns[column] = count
+		}
+		for index, count := range t.indexes {
+			stats.Indexes[index] = count
+		}
+		result = append(result, stats)
+	}
+	lock.Unlock()
+	sort.Slice(result, func(i, j int) bool {
+		ci, cj := result[i].Reads+result[i].Writes, result[j].Reads+result[j].Writes
+		if ci != cj {
+			return ci > cj
+		}
+		if result[i].Schema != result[j].Schema {
+			return result[i].Schema < result[j].Schema
+		}
+		return result[i].Table < result[j].Table
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
+
+// Reset clears the statistics and collects them from now on.
+func Reset() {
+	lock.Lock()
+	defer lock.Unlock()
+	since = time.Now()
+	tables = make(map[tableKey]*table)
+}
+
+// tableRef is a table touched by a statement, with the columns and the indexes of it used.
+type tableRef struct {
+	schema  string
+	table   string
+	write   bool
+	columns map[string]struct{}
+	indexes map[string]struct{}
+}
+
+// tableRefVisitor collects the tables of a statement, each table once even if it is referenced
+// more than once, and the columns of the where, join and order by clauses resolved to them.
+type tableRefVisitor struct {
+	// targets are the tables written by the statement
+	targets map[*ast.TableName]bool
+	// targetNames are the names and aliases of the tables written by a multiple table delete,
+	// whose table names in skip are references to the tables of its table refs
+	targetNames map[string]bool
+	skip        map[*ast.TableName]bool
+
+	refs []*tableRef
+	// names are the tables by the names and the aliases referencing them
+	names map[string]*tableRef
+	// columns are the qualified columns of the clauses, resolved once the tables are known
+	columns []*ast.ColumnName
+	// clauseNodes are the where clauses, clauses counts the clauses being visited, which are
+	// counted from zero in the subqueries
+	clauseNodes map[ast.Node]bool
+	clauses     int
+	scopes      []*scope
+}
+
+// scope is a query block, its unqualified columns are resolved if it references a single table.
+type scope struct {
+	clauses int
+	tables  []*tableRef
+	columns []*ast.ColumnName
+}
+
+func newTableRefVisitor(stmt ast.StmtNode) *tableRefVisitor {
+	v := &tableRefVisitor{
+		targets:     make(map[*ast.TableName]bool),
+		targetNames: make(map[string]bool),
+		skip:        make(map[*ast.TableName]bool),
+		names:       make(map[string]*tableRef),
+		clauseNodes: make(map[ast.Node]bool),
+	}
+	switch s := stmt.(type) {
+	case *ast.InsertStmt:
+		v.addTargets(s.Table)
+	case *ast.UpdateStmt:
+		v.addTargets(s.TableRefs)
+	case *ast.DeleteStmt:
+		if s.IsMultiTable && s.Tables != nil {
+			for _, t := range s.Tables.Tables {
+				v.targetNames[t.Name.L] = true
+				v.skip[t] = true
+			}
+		} else {
+			v.addTargets(s.TableRefs)
+		}
+	}
+	return v
+}
+
+func (v *tableRefVisitor) addTargets(refs *ast.TableRefsClause) {
+	if refs == nil || refs.TableRefs == nil {
+		return
+	}
+	var collect func(node ast.ResultSetNode)
+	collect = func(node ast.ResultSetNode) {
+		switch n := node.(type) {
+		case *ast.Join:
+			collect(n.Left)
+			if n.Right != nil {
+				collect(n.Right)
+			}
+		case *ast.TableSource:
+			if t, ok := n.Source.(*ast.TableName); ok {
+				v.targets[t] = true
+			}
+		}
+	}
+	collect(refs.TableRefs)
+}
+
+func (v *tableRefVisitor) Enter(in ast.Node) (out ast.Node, skipChildren bool) {
+	switch node := in.(type) {
+	case *ast.TableSource:
+		t, ok := node.Source.(*ast.TableName)
+		if !ok {
+			break
+		}
+		ref := v.ref(t)
+		if node.AsName.L != "" {
+			v.names[node.AsName.L] = ref
+		}
+		if v.targetNames[t.Name.L] || v.targetNames[node.AsName.L] {
+			ref.write = true
+		}
+	case *ast.TableName:
+		if !v.skip[node] {
+			v.addToScope(v.ref(node))
+		}
+	case *ast.ColumnName:
+		if v.clauses == 0 {
+			break
+		}
+		if node.Table.L != "" || len(v.scopes) == 0 {
+			v.columns = append(v.columns, node)
+		} else {
+			current := v.scopes[len(v.scopes)-1]
+			current.columns = append(current.columns, node)
+		}
+	case *ast.SelectStmt:
+		v.enterStmt(node.Where)
+	case *ast.UpdateStmt:
+		v.enterStmt(node.Where)
+	case *ast.DeleteStmt:
+		v.enterStmt(node.Where)
+	}
+	if v.isClause(in) {
+		v.clauses++
+	}
+	return in, false
+}
+
+func (v *tableRefVisitor) Leave(in ast.Node) (out ast.Node, ok bool) {
+	if v.isClause(in) {
+		v.clauses--
+	}
+	switch in.(type) {
+	case *ast.SelectStmt, *ast.UpdateStmt, *ast.DeleteStmt:
+		current := v.scopes[len(v.scopes)-1]
+		v.scopes = v.scopes[:len(v.scopes)-1]
+		v.clauses = current.clauses
+		if len(current.tables) == 1 {
+			for _, column := range current.columns {
+				current.tables[0].columns[column.Name.L] = struct{}{}
+			}
+		}
+	}
+	return in, true
+}
+
+func (v *tableRefVisitor) enterStmt(where ast.ExprNode) {
+	v.scopes = append(v.scopes, &scope{clauses: v.clauses})
+	v.clauses = 0
+	if where != nil {
+		v.clauseNodes[where] = true
+	}
+}
+
+func (v *tableRefVisitor) isClause(in ast.Node) bool {
+	switch in.(type) {
+	case *ast.OnCondition, *ast.OrderByClause:
+		return true
+	}
+	return v.clauseNodes[in]
+}
+
+func (v *tableRefVisitor) ref(t *ast.TableName) *tableRef {
+	for _, ref := range v.refs {
+		if strings.EqualFold(ref.schema, t.Schema.O) && strings.EqualFold(ref.table, t.Name.O) {
+			if v.targets[t] {
+				ref.write = true
+			}
+			v.addIndexHints(ref, t)
+			return ref
+		}
+	}
+	ref := &tableRef{
+		schema:  t.Schema.O,
+		table:   t.Name.O,
+		write:   v.targets[t],
+		columns: make(map[string]struct{}),
+		indexes: make(map[string]struct{}),
+	}
+	v.addIndexHints(ref, t)
+	v.refs = append(v.refs, ref)
+	v.names[t.Name.L] = ref
+	return ref
+}
+
+func (v *tableRefVisitor) addToScope(ref *tableRef) {
+	if len(v.scopes) == 0 {
+		return
+	}
+	current := v.scopes[len(v.scopes)-1]
+	for _, t := range current.tables {
+		if t == ref {
+			return
+		}
+	}
+	current.tables = append(current.tables, ref)
+}
+
+func (v *tableRefVisitor) addIndexHints(ref *tableRef, t *ast.TableName) {
+	for _, hint := range t.IndexHints {
+		if hint.HintType == ast.HintIgnore {
+			continue
+		}
+		for _, index := range hint.IndexNames {
+			ref.indexes[index.L] = struct{}{}
+		}
+	}
+}
+
+// resolveColumns adds the qualified columns collected to the tables they reference.
+func (v *tableRefVisitor) resolveColumns() {
+	for _, column := range v.columns {
+		if ref := v.names[column.Table.L]; ref != nil {
+			ref.columns[column.Name.L] = struct{}{}
+		}
+	}
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tablestats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/third_party/parser"
+	_ "github.com/cectc/dbpack/third_party/types/parser_driver"
+)
+
+func TestRecord(t *testing.T) {
+	testCases := []struct {
+		sql      string
+		expected []*TableStats
+	}{
+		{
+			sql: "select name from employees where emp_no = 1 order by hire_date",
+			expected: []*TableStats{
+				{Schema: "db", Table: "employees", Reads: 1,
+					Columns: map[string]uint64{"emp_no": 1, "hire_date": 1}, Indexes: map[string]uint64{}},
+			},
+		},
+		{
+			sql: "select e.name from employees e join salaries s on e.emp_no = s.emp_no where s.salary > 100",
+			expected: []*TableStats{
+				{Schema: "db", Table: "employees", Reads: 1,
+					Columns: map[string]uint64{"emp_no": 1}, Indexes: map[string]uint64{}},
+				{Schema: "db", Table: "salaries", Reads: 1,
+					Columns: map[string]uint64{"emp_no": 1, "salary": 1}, Indexes: map[string]uint64{}},
+			},
+		},
+		{
+			sql: "select name from hr.employees use index (idx_name) where name = 'a'",
+			expected: []*TableStats{
+				{Schema: "hr", Table: "employees", Reads: 1,
+					Columns: map[string]uint64{"name": 1}, Indexes: map[string]uint64{"idx_name": 1}},
+			},
+		},
+		{
+			sql: "insert into archive select * from employees where emp_no < 10",
+			expected: []*TableStats{
+				{Schema: "db", Table: "archive", Writes: 1,
+					Columns: map[string]uint64{}, Indexes: map[string]uint64{}},
+				{Schema: "db", Table: "employees", Reads: 1,
+					Columns: map[string]uint64{"emp_no": 1}, Indexes: map[string]uint64{}},
+			},
+		},
+		{
+			sql: "select * from employees where emp_no in (select emp_no from salaries where salary > 100)",
+			expected: []*TableStats{
+				{Schema: "db", Table: "employees", Reads: 1,
+					Columns: map[string]uint64{"emp_no": 1}, Indexes: map[string]uint64{}},
+				{Schema: "db", Table: "salaries", Reads: 1,
+					Columns: map[string]uint64{"salary": 1}, Indexes: map[string]uint64{}},
+			},
+		},
+		{
+			sql: "delete e from employees e join salaries s on e.emp_no = s.emp_no",
+			expected: []*TableStats{
+				{Schema: "db", Table: "employees", Writes: 1,
+					Columns: map[string]uint64{"emp_no": 1}, Indexes: map[string]uint64{}},
+				{Schema: "db", Table: "salaries", Reads: 1,
+					Columns: map[string]uint64{"emp_no": 1}, Indexes: map[string]uint64{}},
+			},
+		},
+	}
+	p := parser.New()
+	for _, c := range testCases {
+		t.Run(c.sql, func(t *testing.T) {
+			Reset()
+			stmt, err := p.ParseOneStmt(c.sql, "", "")
+			assert.NoError(t, err)
+			Record("db", stmt)
+			result := Tables(0)
+			for _, stats := range result {
+				assert.False(t, stats.LastAccess.IsZero())
+				stats.LastAccess = c.expected[0].LastAccess
+			}
+			assert.Equal(t, c.expected, result)
+		})
+	}
+}
+
+func TestRecordSkipsDDL(t *testing.T) {
+	Reset()
+	stmt, err := parser.New().ParseOneStmt("create table employees (id int)", "", "")
+	assert.NoError(t, err)
+	Record("db", stmt)
+	assert.Empty(t, Tables(0))
+}
+
+func TestTablesLimit(t *testing.T) {
+	Reset()
+	p := parser.New()
+	for _, sql := range []string{
+		"select * from employees", "select * from employees", "select * from salaries",
+	} {
+		stmt, err := p.ParseOneStmt(sql, "", "")
+		assert.NoError(t, err)
+		Record("db", stmt)
+	}
+	result := Tables(1)
+	assert.Len(t, result, 1)
+	assert.Equal(t, "employees", result[0].Table)
+	assert.Equal(t, uint64(2), result[0].Reads)
+}
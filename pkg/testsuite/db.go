@@ -0,0 +1,400 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package testsuite
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cectc/dbpack/pkg/errors"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/usage"
+	"github.com/cectc/dbpack/third_party/parser/ast"
+)
+
+// Statement is a statement sent to a mock data source.
+type Statement struct {
+	SQL  string
+	Args []interface{}
+	// InTransaction reports whether the statement is sent in a transaction
+	InTransaction bool
+}
+
+// Handler answers the statements sent to a mock data source, a nil result is answered as an OK packet.
+type Handler func(statement *Statement) (*Result, error)
+
+// DB is a mock data source implementing proto.DB, it records the statements sent to it and
+// answers them with its handler. The connection filters of the data source are not run.
+type DB struct {
+	name       string
+	masterName string
+
+	mu          sync.Mutex
+	handler     Handler
+	statements  []*Statement
+	writeWeight int
+	readWeight  int
+	passthrough bool
+	closed      bool
+
+	connectionPreFilters  []proto.DBConnectionPreFilter
+	connectionPostFilters []proto.DBConnectionPostFilter
+}
+
+// NewDB creates a mock data source, it is a master if masterName is empty.
+func NewDB(name, masterName string) *DB {
+	return &DB{name: name, masterName: masterName}
+}
+
+// Handle sets the handler answering the statements, statements are answered as OK packets if it is not set.
+func (db *DB) Handle(handler Handler) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.handler = handler
+}
+
+// Statements returns the statements sent to the data source, in the order they were sent.
+func (db *DB) Statements() []*Statement {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	statements := make([]*Statement, len(db.statements))
+	copy(statements, db.statements)
+	return statements
+}
+
+// SQLs returns the sql texts of the statements sent to the data source.
+func (db *DB) SQLs() []string {
+	statements := db.Statements()
+	sqls := make([]string, 0, len(statements))
+	for _, statement := range statements {
+		sqls = append(sqls, statement.SQL)
+	}
+	return sqls
+}
+
+// Reset forgets the statements sent to the data source.
+func (db *DB) Reset() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.statements = nil
+}
+
+func (db *DB) execute(ctx context.Context, sql string, args []interface{}, inTransaction, binary bool) (proto.Result, uint16, error) {
+	statement := &Statement{SQL: sql, Args: args, InTransaction: inTransaction}
+	db.mu.Lock()
+	if db.closed {
+		db.mu.Unlock()
+		return nil, 0, errors.ErrInvalidConn
+	}
+	db.statements = append(db.statements, statement)
+	handler := db.handler
+	db.mu.Unlock()
+
+	if handler == nil {
+		return &mysql.Result{}, 0, nil
+	}
+	// the handler stands for the backend, its answers are accounted as the driver does
+	start := time.Now()
+	result, err := handler(statement)
+	if err != nil {
+		usage.AddBackend(ctx, time.Since(start), 0)
+		return nil, 0, err
+	}
+	res := &mysql.Result{}
+	if result != nil {
+		res = result.toMysqlResult(binary)
+	}
+	usage.AddBackend(ctx, time.Since(start), len(res.Rows))
+	return res, 0, nil
+}
+
+func (db *DB) Name() string {
+	return db.name
+}
+
+func (db *DB) Status() proto.DBStatus {
+	return proto.Running
+}
+
+func (db *DB) SetCapacity(capacity int) error {
+	return nil
+}
+
+func (db *DB) SetIdleTimeout(idleTimeout time.Duration) {
+}
+
+func (db *DB) Capacity() int64 {
+	return 0
+}
+
+func (db *DB) Available() int64 {
+	return 0
+}
+
+func (db *DB) Active() int64 {
+	return 0
+}
+
+func (db *DB) InUse() int64 {
+	return 0
+}
+
+func (db *DB) MaxCap() int64 {
+	return 0
+}
+
+func (db *DB) WaitCount() int64 {
+	return 0
+}
+
+func (db *DB) WaitTime() time.Duration {
+	return 0
+}
+
+func (db *DB) IdleTimeout() time.Duration {
+	return 0
+}
+
+func (db *DB) IdleClosed() int64 {
+	return 0
+}
+
+func (db *DB) Exhausted() int64 {
+	return 0
+}
+
+func (db *DB) StatsJSON() string {
+	return "{}"
+}
+
+func (db *DB) Ping() error {
+	return nil
+}
+
+func (db *DB) Close() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.closed = true
+}
+
+func (db *DB) IsClosed() bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.closed
+}
+
+func (db *DB) IsMaster() bool {
+	return db.masterName == ""
+}
+
+func (db *DB) MasterName() string {
+	return db.masterName
+}
+
+func (db *DB) SetWriteWeight(weight int) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.writeWeight = weight
+}
+
+func (db *DB) SetReadWeight(weight int) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.readWeight = weight
+}
+
+func (db *DB) WriteWeight() int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.writeWeight
+}
+
+func (db *DB) ReadWeight() int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.readWeight
+}
+
+func (db *DB) SetPassthrough(passthrough bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.passthrough = passthrough
+}
+
+func (db *DB) IsPassthrough() bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.passthrough
+}
+
+func (db *DB) SetConnectionPreFilters(filters []proto.DBConnectionPreFilter) {
+	db.connectionPreFilters = filters
+}
+
+func (db *DB) SetConnectionPostFilters(filters []proto.DBConnectionPostFilter) {
+	db.connectionPostFilters = filters
+}
+
+func (db *DB) UseDB(ctx context.Context, schema string) error {
+	_, _, err := db.execute(ctx, fmt.Sprintf("USE `%s`", schema), nil, false, false)
+	return err
+}
+
+func (db *DB) ExecuteFieldList(ctx context.Context, table, wildcard string) ([]proto.Field, error) {
+	return nil, nil
+}
+
+func (db *DB) Query(ctx context.Context, query string) (proto.Result, uint16, error) {
+	return db.execute(ctx, query, nil, false, false)
+}
+
+func (db *DB) QueryDirectly(query string) (proto.Result, uint16, error) {
+	return db.execute(context.Background(), query, nil, false, false)
+}
+
+func (db *DB) ExecuteStmt(ctx context.Context, stmt *proto.Stmt) (proto.Result, uint16, error) {
+	return db.execute(ctx, stmt.SqlText, stmtArgs(stmt), false, true)
+}
+
+func (db *DB) ExecuteSql(ctx context.Context, sql string, args ...interface{}) (proto.Result, uint16, error) {
+	return db.execute(ctx, sql, args, false, true)
+}
+
+func (db *DB) ExecuteSqlDirectly(sql string, args ...interface{}) (proto.Result, uint16, error) {
+	return db.execute(context.Background(), sql, args, false, true)
+}
+
+func (db *DB) Begin(ctx context.Context) (proto.Tx, proto.Result, error) {
+	result, _, err := db.execute(ctx, "START TRANSACTION", nil, true, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &Tx{db: db}, result, nil
+}
+
+func (db *DB) XAStart(ctx context.Context, sql string) (proto.Tx, proto.Result, error) {
+	result, _, err := db.execute(ctx, sql, nil, true, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &Tx{db: db}, result, nil
+}
+
+func (db *DB) Pin(ctx context.Context) (proto.Tx, error) {
+	return &Tx{db: db}, nil
+}
+
+// Tx is a transaction of a mock data source, its statements are recorded by the data source.
+type Tx struct {
+	db     *DB
+	closed bool
+}
+
+func (tx *Tx) execute(ctx context.Context, sql string, args []interface{}, binary bool) (proto.Result, uint16, error) {
+	if tx.closed {
+		return nil, 0, errors.ErrTransactionClosed
+	}
+	return tx.db.execute(ctx, sql, args, true, binary)
+}
+
+func (tx *Tx) Query(ctx context.Context, query string) (proto.Result, uint16, error) {
+	return tx.execute(ctx, query, nil, false)
+}
+
+func (tx *Tx) QueryDirectly(query string) (proto.Result, uint16, error) {
+	return tx.execute(context.Background(), query, nil, false)
+}
+
+func (tx *Tx) ExecuteStmt(ctx context.Context, stmt *proto.Stmt) (proto.Result, uint16, error) {
+	return tx.execute(ctx, stmt.SqlText, stmtArgs(stmt), true)
+}
+
+func (tx *Tx) ExecuteSql(ctx context.Context, sql string, args ...interface{}) (proto.Result, uint16, error) {
+	return tx.execute(ctx, sql, args, true)
+}
+
+func (tx *Tx) ExecuteSqlDirectly(sql string, args ...interface{}) (proto.Result, uint16, error) {
+	return tx.execute(context.Background(), sql, args, true)
+}
+
+func (tx *Tx) Commit(ctx context.Context) (proto.Result, error) {
+	result, _, err := tx.execute(ctx, "COMMIT", nil, false)
+	tx.closed = true
+	return result, err
+}
+
+func (tx *Tx) Rollback(ctx context.Context, stmt *ast.RollbackStmt) (proto.Result, error) {
+	if stmt != nil && stmt.SavepointName != "" {
+		result, _, err := tx.execute(ctx, fmt.Sprintf("ROLLBACK TO %s", stmt.SavepointName), nil, false)
+		return result, err
+	}
+	result, _, err := tx.execute(ctx, "ROLLBACK", nil, false)
+	tx.closed = true
+	return result, err
+}
+
+func (tx *Tx) ReleaseSavepoint(ctx context.Context, savepoint string) (proto.Result, error) {
+	result, _, err := tx.execute(ctx, fmt.Sprintf("RELEASE SAVEPOINT %s", savepoint), nil, false)
+	return result, err
+}
+
+func (tx *Tx) XAPrepare(ctx context.Context, sql string) (proto.Result, error) {
+	result, _, err := tx.execute(ctx, sql, nil, false)
+	return result, err
+}
+
+func (tx *Tx) XAComplete(ctx context.Context, sql string) (proto.Result, error) {
+	result, _, err := tx.execute(ctx, sql, nil, false)
+	tx.closed = true
+	return result, err
+}
+
+// stmtArgs returns the bind variables of stmt in the order of the placeholders.
+func stmtArgs(stmt *proto.Stmt) []interface{} {
+	args := make([]interface{}, 0, len(stmt.BindVars))
+	for i := 0; i < len(stmt.BindVars); i++ {
+		args = append(args, stmt.BindVars[fmt.Sprintf("v%d", i+1)])
+	}
+	return args
+}
+
+// DBManager is the proto.DBManager of the mock data sources.
+type DBManager struct {
+	dbs map[string]*DB
+}
+
+func (manager *DBManager) GetDB(name string) proto.DB {
+	if db, ok := manager.dbs[name]; ok {
+		return db
+	}
+	return nil
+}
+
+func (manager *DBManager) DBs() []proto.DB {
+	dbs := make([]proto.DB, 0, len(manager.dbs))
+	for _, db := range manager.dbs {
+		dbs = append(dbs, db)
+	}
+	sort.Slice(dbs, func(i, j int) bool {
+		return dbs[i].Name() < dbs[j].Name()
+	})
+	return dbs
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package testsuite
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/discovery"
+)
+
+func TestDiscovery(t *testing.T) {
+	suite, err := NewFromYAML("discovery", []byte(suiteConfig))
+	assert.NoError(t, err)
+	defer suite.Close()
+	suite.DB("employees").Handle(func(statement *Statement) (*Result, error) {
+		switch {
+		case strings.Contains(statement.SQL, "information_schema"):
+			return Rows([]string{"TABLE_SCHEMA", "TABLE_NAME", "COLUMN_NAME", "DATA_TYPE"},
+				[]interface{}{"employees", "employee", "id", "int"},
+				[]interface{}{"employees", "employee", "contact", "varchar"},
+				[]interface{}{"employees", "employee", "phone", "varchar"},
+				[]interface{}{"employees", "employee", "birth_date", "date"},
+				[]interface{}{"employees", "employee", "note", "text"},
+			), nil
+		case strings.HasPrefix(statement.SQL, "SELECT `contact`, `phone`, `note` FROM `employees`.`employee`"):
+			return Rows([]string{"contact", "phone", "note"},
+				[]interface{}{"scott@example.com", "13800138000", "likes tea"},
+				[]interface{}{"tiger@example.com", "+86 138-0013-8001", nil},
+				[]interface{}{"tiger", "13800138002", "4111 1111 1111 1111"},
+			), nil
+		}
+		return Affected(0, 0), nil
+	})
+
+	db, err := sql.Open("mysql", suite.DSN(0, "dksl", "123456", "employees"))
+	assert.NoError(t, err)
+	defer db.Close()
+	scanner, err := discovery.NewScanner(db, 10, 0.5)
+	assert.NoError(t, err)
+	findings, err := scanner.Scan(context.Background(), []string{"employees"})
+	assert.NoError(t, err)
+	assert.Equal(t, []*discovery.Finding{
+		{Schema: "employees", Table: "employee", Column: "contact", DataType: "varchar",
+			Category: discovery.CategoryEmail, Confidence: discovery.ConfidenceMedium, Sampled: 3, Matched: 2},
+		{Schema: "employees", Table: "employee", Column: "phone", DataType: "varchar",
+			Category: discovery.CategoryPhone, Confidence: discovery.ConfidenceHigh, Sampled: 3, Matched: 3},
+		{Schema: "employees", Table: "employee", Column: "birth_date", DataType: "date",
+			Category: discovery.CategoryBirthday, Confidence: discovery.ConfidenceLow},
+		{Schema: "employees", Table: "employee", Column: "note", DataType: "text",
+			Category: discovery.CategoryCardNumber, Confidence: discovery.ConfidenceMedium, Sampled: 2, Matched: 1},
+	}, findings)
+
+	var stub bytes.Buffer
+	assert.NoError(t, discovery.WriteCryptoFilterStub(&stub, findings))
+	assert.Contains(t, stub.String(), `columns: [ "contact", "phone", "note" ]`)
+	assert.Contains(t, stub.String(), `"birth_date" (birthday)`)
+}
@@ -0,0 +1,140 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package testsuite
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const dualWriteConfig = `
+listeners:
+  - protocol_type: mysql
+    socket_address:
+      address: 127.0.0.1
+      port: 0
+    config:
+      users:
+        dksl: "123456"
+      server_version: "8.0.27"
+    executor: redirect
+
+executors:
+  - name: redirect
+    mode: sdb
+    config:
+      data_source_ref: employees
+    filters:
+      - dualWrite
+
+data_source_cluster:
+  - name: employees
+  - name: employees_new
+
+filters:
+  - name: dualWrite
+    kind: DualWriteFilter
+    conf:
+      target: employees_new
+      tables:
+        - name: employees
+          read_from_target: true
+        - name: salaries
+`
+
+func TestDualWrite(t *testing.T) {
+	suite, err := NewFromYAML("dual_write", []byte(dualWriteConfig))
+	assert.NoError(t, err)
+	defer suite.Close()
+
+	source, target := suite.DB("employees"), suite.DB("employees_new")
+	affected := func(statement *Statement) (*Result, error) {
+		if strings.HasPrefix(strings.ToLower(statement.SQL), "insert") {
+			return Affected(1, 0), nil
+		}
+		return nil, nil
+	}
+	source.Handle(affected)
+	target.Handle(affected)
+	db, err := sql.Open("mysql", suite.DSN(0, "dksl", "123456", "employees"))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// the writes to the migrated tables are mirrored as they are sent to the source, the writes
+	// to the other tables are not
+	_, err = db.Exec("insert into employees (emp_no, name) values (1, 'scott')")
+	assert.NoError(t, err)
+	_, err = db.Exec("insert into salaries (emp_no, salary) values (?, ?)", 1, 1000)
+	assert.NoError(t, err)
+	_, err = db.Exec("delete from departments where dept_no = 'd001'")
+	assert.NoError(t, err)
+	assert.Len(t, source.Statements(), 3)
+	assert.Equal(t, []*Statement{
+		{SQL: "INSERT INTO `employees` (`emp_no`,`name`) VALUES (1,'scott')"},
+		{SQL: "insert into salaries (emp_no, salary) values (?, ?)", Args: []interface{}{int64(1), int64(1000)}},
+	}, target.Statements())
+	source.Reset()
+	target.Reset()
+
+	// the reads of employees are switched to the target, salaries are still read from the source
+	_, err = db.Exec("select name from employees where emp_no = 1")
+	assert.NoError(t, err)
+	_, err = db.Exec("select salary from salaries where emp_no = 1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"SELECT `name` FROM `employees` WHERE `emp_no`=1"}, target.SQLs())
+	assert.Equal(t, []string{"SELECT `salary` FROM `salaries` WHERE `emp_no`=1"}, source.SQLs())
+	source.Reset()
+	target.Reset()
+
+	// the writes of a transaction are mirrored in a transaction when it commits, and the reads
+	// in it stay on the source
+	tx, err := db.Begin()
+	assert.NoError(t, err)
+	_, err = tx.Exec("update employees set name = 'dksl' where emp_no = 1")
+	assert.NoError(t, err)
+	_, err = tx.Exec("select name from employees where emp_no = 1")
+	assert.NoError(t, err)
+	assert.Empty(t, target.Statements())
+	assert.NoError(t, tx.Commit())
+	assert.Equal(t, []*Statement{
+		{SQL: "START TRANSACTION", InTransaction: true},
+		{SQL: "UPDATE `employees` SET `name`='dksl' WHERE `emp_no`=1", InTransaction: true},
+		{SQL: "COMMIT", InTransaction: true},
+	}, target.Statements())
+	assert.Len(t, source.Statements(), 4)
+	target.Reset()
+
+	// the writes of a transaction rolled back are discarded
+	tx, err = db.Begin()
+	assert.NoError(t, err)
+	_, err = tx.Exec("delete from employees where emp_no = 1")
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Rollback())
+	assert.Empty(t, target.Statements())
+
+	// a failed mirror is logged as a divergence, the statement succeeded on the source still succeeds
+	target.Handle(func(statement *Statement) (*Result, error) {
+		return nil, errors.New("Table 'employees.employees' doesn't exist")
+	})
+	_, err = db.Exec("delete from employees where emp_no = 1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"DELETE FROM `employees` WHERE `emp_no`=1"}, target.SQLs())
+}
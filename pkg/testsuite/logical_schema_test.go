@@ -0,0 +1,131 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package testsuite
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const logicalSchemaConfig = `
+listeners:
+  - protocol_type: mysql
+    socket_address:
+      address: 127.0.0.1
+      port: 0
+    config:
+      users:
+        dksl: "123456"
+      server_version: "8.0.27"
+    executor: sharding
+
+executors:
+  - name: sharding
+    mode: shd
+    config:
+      db_groups:
+        - name: world_0
+          load_balance_algorithm: RandomWeight
+          data_sources:
+            - name: world_0
+              weight: r10w10
+        - name: world_1
+          load_balance_algorithm: RandomWeight
+          data_sources:
+            - name: world_1
+              weight: r10w10
+      global_tables:
+        - country
+      logic_tables:
+        - db_name: world
+          table_name: city
+          allow_full_scan: true
+          sharding_rule:
+            column: id
+            sharding_algorithm: NumberMod
+          sharding_key_generator:
+            type: snowflake
+            worker: 123
+          topology:
+            "0": 0-1
+            "1": 2-3
+
+data_source_cluster:
+  - name: world_0
+  - name: world_1
+`
+
+func TestLogicalSchema(t *testing.T) {
+	suite, err := NewFromYAML("logical_schema", []byte(logicalSchemaConfig))
+	assert.NoError(t, err)
+	defer suite.Close()
+
+	columns := []string{"TABLE_NAME", "TABLE_ROWS"}
+	suite.DB("world_0").Handle(func(statement *Statement) (*Result, error) {
+		switch statement.SQL {
+		case "SHOW TABLES":
+			return Rows([]string{"Tables_in_world"}, []interface{}{"city_0"}, []interface{}{"city_1"},
+				[]interface{}{"country"}), nil
+		case "SELECT `TABLE_NAME`,`TABLE_ROWS` FROM `information_schema`.`TABLES` WHERE `TABLE_SCHEMA`='world'":
+			return Rows(columns, []interface{}{"city_0", 100}, []interface{}{"city_1", 120},
+				[]interface{}{"country", 239}), nil
+		}
+		return nil, nil
+	})
+	suite.DB("world_1").Handle(func(statement *Statement) (*Result, error) {
+		switch statement.SQL {
+		case "SHOW TABLES":
+			return Rows([]string{"Tables_in_world"}, []interface{}{"city_2"}, []interface{}{"city_3"},
+				[]interface{}{"country"}), nil
+		case "SELECT `TABLE_NAME`,`TABLE_ROWS` FROM `information_schema`.`TABLES` WHERE `TABLE_SCHEMA`='world'":
+			return Rows(columns, []interface{}{"city_2", 90}, []interface{}{"city_3", 110},
+				[]interface{}{"country", 239}), nil
+		}
+		return nil, nil
+	})
+
+	db, err := sql.Open("mysql", suite.DSN(0, "dksl", "123456", "world"))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	rows, err := db.Query("SHOW TABLES")
+	assert.NoError(t, err)
+	var tables []string
+	for rows.Next() {
+		var table string
+		assert.NoError(t, rows.Scan(&table))
+		tables = append(tables, table)
+	}
+	assert.NoError(t, rows.Close())
+	assert.Equal(t, []string{"city", "country"}, tables)
+
+	rows, err = db.Query("SELECT TABLE_NAME, TABLE_ROWS FROM information_schema.TABLES WHERE TABLE_SCHEMA = 'world'")
+	assert.NoError(t, err)
+	tableRows := make(map[string]int64)
+	for rows.Next() {
+		var (
+			table string
+			count int64
+		)
+		assert.NoError(t, rows.Scan(&table, &count))
+		tableRows[table] = count
+	}
+	assert.NoError(t, rows.Close())
+	assert.Equal(t, map[string]int64{"city": 420, "country": 239}, tableRows)
+}
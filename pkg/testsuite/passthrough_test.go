@@ -0,0 +1,117 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package testsuite
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const passthroughConfig = `
+listeners:
+  - protocol_type: mysql
+    socket_address:
+      address: 127.0.0.1
+      port: 0
+    config:
+      users:
+        dksl: "123456"
+      server_version: "8.0.27"
+    executor: redirect
+  - protocol_type: mysql
+    socket_address:
+      address: 127.0.0.1
+      port: 0
+    config:
+      users:
+        dksl: "123456"
+      server_version: "8.0.27"
+    executor: sharding
+
+executors:
+  - name: redirect
+    mode: sdb
+    config:
+      data_source_ref: vtgate
+  - name: sharding
+    mode: shd
+    config:
+      db_groups:
+        - name: world_0
+          load_balance_algorithm: RandomWeight
+          data_sources:
+            - name: world_0
+              weight: r10w10
+      global_tables:
+        - country
+      fallback:
+        name: vtgate
+        load_balance_algorithm: RandomWeight
+        data_sources:
+          - name: vtgate
+            weight: r10w10
+
+data_source_cluster:
+  - name: world_0
+  - name: vtgate
+    passthrough: true
+`
+
+func TestPassthrough(t *testing.T) {
+	suite, err := NewFromYAML("passthrough", []byte(passthroughConfig))
+	assert.NoError(t, err)
+	defer suite.Close()
+
+	vtgate, world := suite.DB("vtgate"), suite.DB("world_0")
+	db, err := sql.Open("mysql", suite.DSN(0, "dksl", "123456", "customer"))
+	assert.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec("update /*vt+ QUERY_TIMEOUT_MS=100 */ customer set name = 'scott' where id = 1")
+	assert.NoError(t, err)
+	assert.Equal(t, []*Statement{
+		{SQL: "update /*vt+ QUERY_TIMEOUT_MS=100 */ customer set name = 'scott' where id = 1"},
+	}, vtgate.Statements())
+	vtgate.Reset()
+
+	db, err = sql.Open("mysql", suite.DSN(1, "dksl", "123456", "world"))
+	assert.NoError(t, err)
+	defer db.Close()
+	// the tables unknown to the sharding executor fall back to vtgate as they are
+	_, err = db.Exec("delete from customer where id = 1")
+	assert.NoError(t, err)
+	_, err = db.Exec("select code from country")
+	assert.NoError(t, err)
+	assert.Equal(t, []*Statement{{SQL: "delete from customer where id = 1"}}, vtgate.Statements())
+	assert.Equal(t, []*Statement{{SQL: "SELECT `code` FROM `country`"}}, world.Statements())
+	vtgate.Reset()
+	world.Reset()
+
+	// the local transactions of the sharding executor span the fallback db group
+	tx, err := db.Begin()
+	assert.NoError(t, err)
+	_, err = tx.Exec("delete from customer where id = 1")
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Commit())
+	assert.Equal(t, []*Statement{
+		{SQL: "START TRANSACTION", InTransaction: true},
+		{SQL: "delete from customer where id = 1", InTransaction: true},
+		{SQL: "COMMIT", InTransaction: true},
+	}, vtgate.Statements())
+	assert.Empty(t, world.Statements())
+}
@@ -0,0 +1,194 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package testsuite
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const pinnedSessionConfig = `
+listeners:
+  - protocol_type: mysql
+    socket_address:
+      address: 127.0.0.1
+      port: 0
+    config:
+      users:
+        dksl: "123456"
+      server_version: "8.0.27"
+    executor: redirect
+
+executors:
+  - name: redirect
+    mode: rws
+    config:
+      load_balance_algorithm: RandomWeight
+      data_sources:
+        - name: employees-master
+          weight: r0w10
+        - name: employees-slave
+          weight: r10w0
+
+data_source_cluster:
+  - name: employees-master
+  - name: employees-slave
+    master_name: employees-master
+`
+
+func TestTemporaryTable(t *testing.T) {
+	suite, err := NewFromYAML("pinned_session", []byte(pinnedSessionConfig))
+	assert.NoError(t, err)
+	defer suite.Close()
+
+	master, slave := suite.DB("employees-master"), suite.DB("employees-slave")
+	db, err := sql.Open("mysql", suite.DSN(0, "dksl", "123456", "employees"))
+	assert.NoError(t, err)
+	defer db.Close()
+	db.SetMaxIdleConns(0)
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	_, err = conn.ExecContext(ctx, "create temporary table tmp (id int)")
+	assert.NoError(t, err)
+	// the reads of the session are pinned to the connection holding the temporary table
+	_, err = conn.ExecContext(ctx, "select id from tmp")
+	assert.NoError(t, err)
+	tx, err := conn.BeginTx(ctx, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Commit())
+	_, err = conn.ExecContext(ctx, "drop temporary table tmp")
+	assert.NoError(t, err)
+	_, err = conn.ExecContext(ctx, "select id from employee")
+	assert.NoError(t, err)
+	assert.NoError(t, conn.Close())
+	assert.Equal(t, []*Statement{
+		{SQL: "CREATE TEMPORARY TABLE `tmp` (`id` INT)", InTransaction: true},
+		{SQL: "SELECT `id` FROM `tmp`", InTransaction: true},
+		{SQL: "START TRANSACTION", InTransaction: true},
+		{SQL: "COMMIT", InTransaction: true},
+		{SQL: "DROP TEMPORARY TABLE `tmp`", InTransaction: true},
+		{SQL: "COMMIT", InTransaction: true},
+	}, master.Statements())
+	assert.Equal(t, []*Statement{{SQL: "SELECT `id` FROM `employee`"}}, slave.Statements())
+	master.Reset()
+
+	// the temporary tables left by a closing session are dropped before its connection is released
+	conn, err = db.Conn(ctx)
+	assert.NoError(t, err)
+	_, err = conn.ExecContext(ctx, "create temporary table tmp (id int)")
+	assert.NoError(t, err)
+	assert.NoError(t, conn.Close())
+	assert.Eventually(t, func() bool {
+		return len(master.Statements()) == 3
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []*Statement{
+		{SQL: "CREATE TEMPORARY TABLE `tmp` (`id` INT)", InTransaction: true},
+		{SQL: "DROP TEMPORARY TABLE IF EXISTS `tmp`", InTransaction: true},
+		{SQL: "ROLLBACK", InTransaction: true},
+	}, master.Statements())
+}
+
+func TestSessionFunctions(t *testing.T) {
+	suite, err := NewFromYAML("session_functions", []byte(pinnedSessionConfig))
+	assert.NoError(t, err)
+	defer suite.Close()
+
+	master, slave := suite.DB("employees-master"), suite.DB("employees-slave")
+	master.Handle(func(statement *Statement) (*Result, error) {
+		if statement.SQL == "INSERT INTO `employee` (`name`) VALUES ('scott')" {
+			return Affected(1, 42), nil
+		}
+		return nil, nil
+	})
+	db, err := sql.Open("mysql", suite.DSN(0, "dksl", "123456", "employees"))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Close()
+	exec := func(sql string) {
+		_, err := conn.ExecContext(ctx, sql)
+		assert.NoError(t, err)
+	}
+
+	// the user locks belong to the master session pinned until they are released
+	exec("select get_lock('dbpack', 10)")
+	exec("select is_free_lock('dbpack')")
+	exec("select id from employee")
+	exec("select release_lock('dbpack')")
+	exec("select id from employee")
+	assert.Equal(t, []*Statement{
+		{SQL: "SELECT GET_LOCK('dbpack', 10)", InTransaction: true},
+		{SQL: "SELECT IS_FREE_LOCK('dbpack')", InTransaction: true},
+		{SQL: "SELECT `id` FROM `employee`", InTransaction: true},
+		{SQL: "SELECT RELEASE_LOCK('dbpack')", InTransaction: true},
+		{SQL: "COMMIT", InTransaction: true},
+	}, master.Statements())
+	assert.Equal(t, []*Statement{{SQL: "SELECT `id` FROM `employee`"}}, slave.Statements())
+	master.Reset()
+	slave.Reset()
+
+	// FOUND_ROWS() runs on the session of the previous SELECT SQL_CALC_FOUND_ROWS
+	exec("select sql_calc_found_rows id from employee limit 1")
+	exec("select found_rows()")
+	assert.Equal(t, []*Statement{
+		{SQL: "SELECT SQL_CALC_FOUND_ROWS `id` FROM `employee` LIMIT 1", InTransaction: true},
+		{SQL: "SELECT FOUND_ROWS()", InTransaction: true},
+		{SQL: "COMMIT", InTransaction: true},
+	}, master.Statements())
+	assert.Empty(t, slave.Statements())
+	master.Reset()
+
+	// LAST_INSERT_ID() and FOUND_ROWS() are answered from the session state, keeping the column names
+	slave.Handle(func(statement *Statement) (*Result, error) {
+		return Rows([]string{"id"}, []interface{}{1}, []interface{}{2}), nil
+	})
+	exec("insert into employee (name) values ('scott')")
+	exec("select id from employee")
+	var id, foundRows uint64
+	rows, err := conn.QueryContext(ctx, "select last_insert_id(), found_rows()")
+	assert.NoError(t, err)
+	columns, err := rows.Columns()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"last_insert_id()", "found_rows()"}, columns)
+	assert.True(t, rows.Next())
+	assert.NoError(t, rows.Scan(&id, &foundRows))
+	assert.NoError(t, rows.Close())
+	assert.Equal(t, uint64(42), id)
+	assert.Equal(t, uint64(2), foundRows)
+	stmt, err := conn.PrepareContext(ctx, "select found_rows()")
+	assert.NoError(t, err)
+	assert.NoError(t, stmt.QueryRowContext(ctx).Scan(&foundRows))
+	assert.NoError(t, stmt.Close())
+	// the previous SELECT found a single row
+	assert.Equal(t, uint64(1), foundRows)
+	// the other statements get the values in place of the calls
+	exec("update employee set manager = last_insert_id() where id = 1")
+	assert.Equal(t, []*Statement{
+		{SQL: "INSERT INTO `employee` (`name`) VALUES ('scott')"},
+		{SQL: "UPDATE `employee` SET `manager`=42 WHERE `id`=1"},
+	}, master.Statements())
+	assert.Equal(t, []*Statement{{SQL: "SELECT `id` FROM `employee`"}}, slave.Statements())
+}
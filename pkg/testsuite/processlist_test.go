@@ -0,0 +1,197 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package testsuite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const processListConfig = `
+listeners:
+  - protocol_type: mysql
+    socket_address:
+      address: 127.0.0.1
+      port: 0
+    config:
+      users:
+        dksl: "123456"
+      server_version: "8.0.27"
+    executor: redirect
+
+executors:
+  - name: redirect
+    mode: sdb
+    config:
+      data_source_ref: employees
+
+data_source_cluster:
+  - name: employees
+  - name: employees_archive
+  - name: employees_replica
+`
+
+type processListRow struct {
+	id      uint64
+	user    string
+	host    string
+	db      sql.NullString
+	command string
+	time    int64
+	state   string
+	info    sql.NullString
+}
+
+func scanProcessList(t *testing.T, rows *sql.Rows) map[uint64]processListRow {
+	defer rows.Close()
+	processes := make(map[uint64]processListRow)
+	for rows.Next() {
+		var row processListRow
+		assert.NoError(t, rows.Scan(&row.id, &row.user, &row.host, &row.db, &row.command, &row.time, &row.state, &row.info))
+		processes[row.id] = row
+	}
+	assert.NoError(t, rows.Err())
+	return processes
+}
+
+func TestShowProcessList(t *testing.T) {
+	suite, err := NewFromYAML("processlist", []byte(processListConfig))
+	assert.NoError(t, err)
+	defer suite.Close()
+
+	db, err := sql.Open("mysql", suite.DSN(0, "dksl", "123456", "employees"))
+	assert.NoError(t, err)
+	defer db.Close()
+	ctx := context.Background()
+	idle, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer idle.Close()
+	busy, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer busy.Close()
+	assert.NoError(t, idle.PingContext(ctx))
+
+	rows, err := busy.QueryContext(ctx, "SHOW PROCESSLIST")
+	assert.NoError(t, err)
+	processes := scanProcessList(t, rows)
+	assert.Len(t, processes, 2)
+	var sleeping, querying int
+	for _, process := range processes {
+		assert.Equal(t, "dksl", process.user)
+		assert.Contains(t, process.host, "127.0.0.1:")
+		assert.Equal(t, sql.NullString{String: "employees", Valid: true}, process.db)
+		switch process.command {
+		case "Sleep":
+			sleeping++
+			assert.Empty(t, process.state)
+			assert.False(t, process.info.Valid)
+		case "Query":
+			querying++
+			assert.Equal(t, "executing", process.state)
+			assert.Equal(t, sql.NullString{String: "SHOW PROCESSLIST", Valid: true}, process.info)
+		}
+	}
+	assert.Equal(t, 1, sleeping)
+	assert.Equal(t, 1, querying)
+
+	// the prepared statements are listed by their sql
+	stmt, err := busy.PrepareContext(ctx, "SHOW FULL PROCESSLIST")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	rows, err = stmt.QueryContext(ctx)
+	assert.NoError(t, err)
+	for _, process := range scanProcessList(t, rows) {
+		if process.command != "Sleep" {
+			assert.Equal(t, "Execute", process.command)
+			assert.Equal(t, sql.NullString{String: "SHOW FULL PROCESSLIST", Valid: true}, process.info)
+		}
+	}
+}
+
+func TestShowBackendProcessList(t *testing.T) {
+	suite, err := NewFromYAML("backend_processlist", []byte(processListConfig))
+	assert.NoError(t, err)
+	defer suite.Close()
+
+	columns := []string{"Id", "User", "Host", "db", "Command", "Time", "State", "Info"}
+	suite.DB("employees").Handle(func(statement *Statement) (*Result, error) {
+		switch statement.SQL {
+		case "SHOW PROCESSLIST":
+			return Rows(columns,
+				[]interface{}{11, "dksl", "10.0.0.1:52310", "employees", "Sleep", 3, "", nil},
+				[]interface{}{12, "dksl", "10.0.0.1:52312", "employees", "Query", 0, "init", "SHOW PROCESSLIST"}), nil
+		case "SHOW FULL PROCESSLIST":
+			return Rows(columns,
+				[]interface{}{12, "dksl", "10.0.0.1:52312", "employees", "Query", 0, "init", "SHOW FULL PROCESSLIST"}), nil
+		}
+		return nil, nil
+	})
+	suite.DB("employees_replica").Handle(func(statement *Statement) (*Result, error) {
+		return Rows(columns, []interface{}{21, "repl", "10.0.0.2:41022", nil, "Binlog Dump", 86400, "", nil}), nil
+	})
+	suite.DB("employees_archive").Handle(func(statement *Statement) (*Result, error) {
+		return nil, errors.New("connection refused")
+	})
+
+	db, err := sql.Open("mysql", suite.DSN(0, "dksl", "123456", "employees"))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	type backendProcess struct {
+		id      int64
+		user    string
+		command string
+		info    sql.NullString
+		backend string
+	}
+	query := func(sql string) []backendProcess {
+		rows, err := db.Query(sql)
+		assert.NoError(t, err)
+		defer rows.Close()
+		columns, err := rows.Columns()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"Id", "User", "Host", "db", "Command", "Time", "State", "Info", "Backend"}, columns)
+		var processes []backendProcess
+		for rows.Next() {
+			var (
+				process         backendProcess
+				host, db, state interface{}
+				time            int64
+			)
+			assert.NoError(t, rows.Scan(&process.id, &process.user, &host, &db, &process.command, &time, &state,
+				&process.info, &process.backend))
+			processes = append(processes, process)
+		}
+		assert.NoError(t, rows.Err())
+		return processes
+	}
+
+	// the data sources failing to list their processes are left out
+	assert.Equal(t, []backendProcess{
+		{id: 11, user: "dksl", command: "Sleep", backend: "employees"},
+		{id: 12, user: "dksl", command: "Query", info: sql.NullString{String: "SHOW PROCESSLIST", Valid: true}, backend: "employees"},
+		{id: 21, user: "repl", command: "Binlog Dump", backend: "employees_replica"},
+	}, query("SHOW BACKEND PROCESSLIST"))
+	assert.Equal(t, []backendProcess{
+		{id: 12, user: "dksl", command: "Query", info: sql.NullString{String: "SHOW FULL PROCESSLIST", Valid: true}, backend: "employees"},
+		{id: 21, user: "repl", command: "Binlog Dump", backend: "employees_replica"},
+	}, query("show full backend processlist"))
+}
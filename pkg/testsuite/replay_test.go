@@ -0,0 +1,123 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package testsuite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/replay"
+)
+
+const captureConfig = `
+listeners:
+  - protocol_type: mysql
+    socket_address:
+      address: 127.0.0.1
+      port: 0
+    config:
+      users:
+        dksl: "123456"
+      server_version: "8.0.27"
+      capture:
+        capture_dir: %s
+    executor: redirect
+
+executors:
+  - name: redirect
+    mode: sdb
+    config:
+      data_source_ref: employees
+
+data_source_cluster:
+  - name: employees
+`
+
+func TestCaptureAndReplay(t *testing.T) {
+	captureDir := t.TempDir()
+	captured, err := NewFromYAML("capture", []byte(fmt.Sprintf(captureConfig, captureDir)))
+	assert.NoError(t, err)
+	handler := func(statement *Statement) (*Result, error) {
+		switch statement.SQL {
+		case "select name from employee where id = ?":
+			return Rows([]string{"name"}, []interface{}{"scott"}), nil
+		case "DELETE FROM `employee`":
+			return nil, errors.New("table is locked")
+		}
+		return Affected(1, 0), nil
+	}
+	captured.DB("employees").Handle(handler)
+
+	db, err := sql.Open("mysql", captured.DSN(0, "dksl", "123456", "employees"))
+	assert.NoError(t, err)
+	var name string
+	assert.NoError(t, db.QueryRow("select name from employee where id = ?", 1).Scan(&name))
+	_, err = db.Exec("update employee set name = 'tiger' where id = 2")
+	assert.NoError(t, err)
+	_, err = db.Exec("delete from employee")
+	assert.Error(t, err)
+	assert.NoError(t, db.Close())
+	capturedStatements := captured.DB("employees").Statements()
+	captured.Close()
+
+	file, err := os.Open(filepath.Join(captureDir, "capture.jsonl"))
+	assert.NoError(t, err)
+	defer file.Close()
+	var events []*replay.Event
+	reader := replay.NewReader(file)
+	for event, err := reader.Next(); err == nil; event, err = reader.Next() {
+		if event.Command != replay.CommandQuit {
+			events = append(events, event)
+		}
+	}
+	assert.Len(t, events, 3)
+	assert.Equal(t, replay.CommandExecute, events[0].Command)
+	assert.Equal(t, []interface{}{int64(1)}, events[0].Args)
+	assert.Equal(t, "employees", events[0].Schema)
+	assert.Equal(t, "dksl", events[0].User)
+	assert.Equal(t, replay.CommandQuery, events[1].Command)
+	assert.Equal(t, "update employee set name = 'tiger' where id = 2", events[1].SQL)
+	assert.Contains(t, events[2].Error, "table is locked")
+
+	target, err := NewFromYAML("replay", []byte(suiteConfig))
+	assert.NoError(t, err)
+	defer target.Close()
+	target.DB("employees").Handle(handler)
+	targetDB, err := sql.Open("mysql", target.DSN(0, "dksl", "123456", "employees"))
+	assert.NoError(t, err)
+	defer targetDB.Close()
+
+	_, err = file.Seek(0, 0)
+	assert.NoError(t, err)
+	replayer, err := replay.NewReplayer(targetDB, 0)
+	assert.NoError(t, err)
+	stats, err := replayer.Replay(context.Background(), replay.NewReader(file))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), stats.Statements)
+	assert.Equal(t, int64(1), stats.Errors)
+	assert.Equal(t, int64(0), stats.Diverged)
+	// the replayer selects the captured schema before the first statement of a session
+	assert.Equal(t, append([]*Statement{{SQL: "USE `employees`"}}, capturedStatements...),
+		target.DB("employees").Statements())
+}
@@ -0,0 +1,186 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package testsuite
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/cectc/dbpack/pkg/constant"
+	"github.com/cectc/dbpack/pkg/mysql"
+	"github.com/cectc/dbpack/pkg/proto"
+)
+
+// Result is the result of a statement answered by a mock data source, it is converted to
+// the text or the binary protocol result the statement expects.
+//
+// The values of a row may be nil, integers, floats, bools, strings, byte slices or times,
+// the type of a column is that of its first non nil value, times are formatted as
+// "2006-01-02 15:04:05" strings and the values of other types are formatted with fmt.Sprint.
+type Result struct {
+	Columns      []string
+	Rows         [][]interface{}
+	AffectedRows uint64
+	LastInsertID uint64
+}
+
+// Rows creates the result of a query.
+func Rows(columns []string, rows ...[]interface{}) *Result {
+	return &Result{Columns: columns, Rows: rows}
+}
+
+// Affected creates the result of a write statement.
+func Affected(affectedRows, lastInsertID uint64) *Result {
+	return &Result{AffectedRows: affectedRows, LastInsertID: lastInsertID}
+}
+
+func (r *Result) toMysqlResult(binary bool) *mysql.Result {
+	result := &mysql.Result{
+		AffectedRows: r.AffectedRows,
+		InsertId:     r.LastInsertID,
+	}
+	if len(r.Columns) == 0 {
+		return result
+	}
+
+	result.Fields = make([]*mysql.Field, len(r.Columns))
+	for i, column := range r.Columns {
+		fieldType := constant.FieldTypeVarString
+		for _, row := range r.Rows {
+			if i < len(row) && row[i] != nil {
+				fieldType = valueFieldType(row[i])
+				break
+			}
+		}
+		field := &mysql.Field{Name: column, OrgName: column, FieldType: fieldType, CharSet: constant.CharacterSetBinary}
+		if fieldType == constant.FieldTypeVarString {
+			field.CharSet = constant.CharacterSetUtf8
+		}
+		result.Fields[i] = field
+	}
+
+	result.Rows = make([]proto.Row, 0, len(r.Rows))
+	for _, row := range r.Rows {
+		values := make([]*proto.Value, len(r.Columns))
+		for i, field := range result.Fields {
+			if i >= len(row) || row[i] == nil {
+				continue
+			}
+			values[i] = newValue(field.FieldType, row[i], binary)
+		}
+		if binary {
+			result.Rows = append(result.Rows, mysql.NewBinaryRow(result.Fields, values))
+		} else {
+			result.Rows = append(result.Rows, mysql.NewTextRow(result.Fields, values))
+		}
+	}
+	return result
+}
+
+func valueFieldType(value interface{}) constant.FieldType {
+	switch value.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, bool:
+		return constant.FieldTypeLongLong
+	case float32, float64:
+		return constant.FieldTypeDouble
+	default:
+		return constant.FieldTypeVarString
+	}
+}
+
+// newValue creates a value of a column of fieldType, values of the text protocol hold the
+// text as read from a backend, while values of the binary protocol hold the typed value.
+func newValue(fieldType constant.FieldType, value interface{}, binary bool) *proto.Value {
+	var (
+		val interface{}
+		raw []byte
+	)
+	switch fieldType {
+	case constant.FieldTypeLongLong:
+		n := toInt64(value)
+		val, raw = n, strconv.AppendInt(nil, n, 10)
+	case constant.FieldTypeDouble:
+		f := toFloat64(value)
+		val, raw = f, strconv.AppendFloat(nil, f, 'g', -1, 64)
+	default:
+		raw = toBytes(value)
+		val = raw
+	}
+	if !binary {
+		val = raw
+	}
+	return &proto.Value{Typ: fieldType, Len: len(raw), Val: val, Raw: raw}
+}
+
+func toInt64(value interface{}) int64 {
+	switch v := value.(type) {
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case uint:
+		return int64(v)
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		return int64(v)
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	default:
+		n, _ := strconv.ParseInt(fmt.Sprint(v), 10, 64)
+		return n
+	}
+}
+
+func toFloat64(value interface{}) float64 {
+	switch v := value.(type) {
+	case float32:
+		return float64(v)
+	case float64:
+		return v
+	default:
+		f, _ := strconv.ParseFloat(fmt.Sprint(v), 64)
+		return f
+	}
+}
+
+func toBytes(value interface{}) []byte {
+	switch v := value.(type) {
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	case time.Time:
+		return []byte(v.Format("2006-01-02 15:04:05"))
+	default:
+		return []byte(fmt.Sprint(v))
+	}
+}
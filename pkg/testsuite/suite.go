@@ -0,0 +1,176 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package testsuite runs dbpack in process on mock data sources, so that the sharding, read
+// write splitting and transaction configs of an application can be tested with go tests
+// and a mysql client, without real databases.
+//
+//	suite, err := testsuite.NewFromYAML("svc", content)
+//	...
+//	defer suite.Close()
+//	suite.DB("employees").Handle(func(statement *testsuite.Statement) (*testsuite.Result, error) {
+//		return testsuite.Rows([]string{"id", "name"}, []interface{}{1, "scott"}), nil
+//	})
+//	db, err := sql.Open("mysql", suite.DSN(0, "dksl", "123456", "employees"))
+//
+// The filters, data sources and executors are registered by the app id of the config, so
+// suites running at the same time must use different app ids.
+package testsuite
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/cectc/dbpack/pkg/config"
+	"github.com/cectc/dbpack/pkg/executor"
+	"github.com/cectc/dbpack/pkg/filter"
+	_ "github.com/cectc/dbpack/pkg/filter/audit_log"
+	_ "github.com/cectc/dbpack/pkg/filter/breaker"
+	_ "github.com/cectc/dbpack/pkg/filter/chaos"
+	_ "github.com/cectc/dbpack/pkg/filter/crypto"
+	_ "github.com/cectc/dbpack/pkg/filter/dt"
+	_ "github.com/cectc/dbpack/pkg/filter/dualwrite"
+	_ "github.com/cectc/dbpack/pkg/filter/metrics"
+	_ "github.com/cectc/dbpack/pkg/filter/quota"
+	_ "github.com/cectc/dbpack/pkg/filter/rate"
+	_ "github.com/cectc/dbpack/pkg/filter/script"
+	"github.com/cectc/dbpack/pkg/listener"
+	"github.com/cectc/dbpack/pkg/proto"
+	"github.com/cectc/dbpack/pkg/resource"
+	_ "github.com/cectc/dbpack/third_party/types/parser_driver"
+)
+
+// Suite is a dbpack application served in process, whose data sources are mock DBs.
+type Suite struct {
+	conf      *config.DBPackConfig
+	dbs       map[string]*DB
+	listeners []*listener.MysqlListener
+	wg        sync.WaitGroup
+}
+
+// NewFromYAML starts the application configured by content, which is the config of a single
+// application, the same as a value of app_config in the dbpack config file.
+func NewFromYAML(appID string, content []byte) (*Suite, error) {
+	conf := &config.DBPackConfig{}
+	if err := yaml.Unmarshal(content, conf); err != nil {
+		return nil, errors.Wrap(err, "unmarshal dbpack config failed")
+	}
+	conf.AppID = appID
+	return New(conf)
+}
+
+// New starts the mysql listeners of conf, listening on port 0 picks a free port, which is
+// reported by Addr. Only mysql listeners are supported.
+func New(conf *config.DBPackConfig) (*Suite, error) {
+	if err := conf.Normalize(); err != nil {
+		return nil, err
+	}
+	for _, filterConf := range conf.Filters {
+		f, err := filter.NewFilter(conf.AppID, filterConf)
+		if err != nil {
+			return nil, err
+		}
+		filter.RegisterFilter(conf.AppID, filterConf.Name, f)
+	}
+
+	suite := &Suite{
+		conf: conf,
+		dbs:  make(map[string]*DB, len(conf.DataSources)),
+	}
+	for _, dataSource := range conf.DataSources {
+		db := NewDB(dataSource.Name, dataSource.MasterName)
+		var (
+			connectionPreFilters  []proto.DBConnectionPreFilter
+			connectionPostFilters []proto.DBConnectionPostFilter
+		)
+		for _, filterName := range dataSource.Filters {
+			f := filter.GetFilter(conf.AppID, filterName)
+			if preFilter, ok := f.(proto.DBConnectionPreFilter); ok {
+				connectionPreFilters = append(connectionPreFilters, preFilter)
+			}
+			if postFilter, ok := f.(proto.DBConnectionPostFilter); ok {
+				connectionPostFilters = append(connectionPostFilters, postFilter)
+			}
+		}
+		db.SetConnectionPreFilters(connectionPreFilters)
+		db.SetConnectionPostFilters(connectionPostFilters)
+		db.SetPassthrough(dataSource.Passthrough)
+		suite.dbs[dataSource.Name] = db
+	}
+	resource.SetDBManager(conf.AppID, &DBManager{dbs: suite.dbs})
+
+	executors := make(map[string]proto.Executor, len(conf.Executors))
+	for _, executorConf := range conf.Executors {
+		e, err := executor.NewExecutor(executorConf)
+		if err != nil {
+			return nil, err
+		}
+		executors[executorConf.Name] = e
+	}
+
+	for _, listenerConf := range conf.Listeners {
+		if listenerConf.ProtocolType != config.Mysql {
+			suite.Close()
+			return nil, errors.Errorf("listener %s is not a mysql listener", listenerConf.SocketAddress)
+		}
+		l, err := listener.NewMysqlListener(listenerConf)
+		if err != nil {
+			suite.Close()
+			return nil, err
+		}
+		mysqlListener := l.(*listener.MysqlListener)
+		suite.listeners = append(suite.listeners, mysqlListener)
+		if err := listener.SetExecutors(mysqlListener, listenerConf, executors); err != nil {
+			suite.Close()
+			return nil, err
+		}
+	}
+	for _, l := range suite.listeners {
+		suite.wg.Add(1)
+		go func(l *listener.MysqlListener) {
+			defer suite.wg.Done()
+			l.Listen()
+		}(l)
+	}
+	return suite, nil
+}
+
+// DB returns the mock DB of the data source named name.
+func (suite *Suite) DB(name string) *DB {
+	return suite.dbs[name]
+}
+
+// Addr returns the address of the i-th listener of the config.
+func (suite *Suite) Addr(i int) net.Addr {
+	return suite.listeners[i].Addr()
+}
+
+// DSN returns the go-sql-driver/mysql dsn connecting to the i-th listener of the config.
+func (suite *Suite) DSN(i int, user, password, database string) string {
+	return fmt.Sprintf("%s:%s@tcp(%s)/%s", user, password, suite.Addr(i), database)
+}
+
+// Close closes the listeners and waits for their accept loops to return.
+func (suite *Suite) Close() {
+	for _, l := range suite.listeners {
+		l.Close()
+	}
+	suite.wg.Wait()
+}
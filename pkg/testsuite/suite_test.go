@@ -0,0 +1,115 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package testsuite
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+const suiteConfig = `
+listeners:
+  - protocol_type: mysql
+    socket_address:
+      address: 127.0.0.1
+      port: 0
+    config:
+      users:
+        dksl: "123456"
+      server_version: "8.0.27"
+    executor: redirect
+
+executors:
+  - name: redirect
+    mode: sdb
+    config:
+      data_source_ref: employees
+
+data_source_cluster:
+  - name: employees
+`
+
+func TestSuite(t *testing.T) {
+	suite, err := NewFromYAML("testsuite", []byte(suiteConfig))
+	assert.NoError(t, err)
+	defer suite.Close()
+
+	employees := suite.DB("employees")
+	employees.Handle(func(statement *Statement) (*Result, error) {
+		switch statement.SQL {
+		case "SELECT `id`,`name`,`salary` FROM `employee`":
+			return Rows([]string{"id", "name", "salary"},
+				[]interface{}{1, "scott", 1250.5}, []interface{}{2, "tiger", nil}), nil
+		case "select name from employee where id = ?":
+			return Rows([]string{"name"}, []interface{}{"scott"}), nil
+		case "update employee set name = ? where id = ?":
+			return Affected(1, 0), nil
+		case "DELETE FROM `employee`":
+			return nil, errors.New("table is locked")
+		}
+		return nil, nil
+	})
+
+	db, err := sql.Open("mysql", suite.DSN(0, "dksl", "123456", "employees"))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	type employee struct {
+		id     int64
+		name   string
+		salary sql.NullFloat64
+	}
+	rows, err := db.Query("select id, name, salary from employee")
+	assert.NoError(t, err)
+	var employeeRows []employee
+	for rows.Next() {
+		var e employee
+		assert.NoError(t, rows.Scan(&e.id, &e.name, &e.salary))
+		employeeRows = append(employeeRows, e)
+	}
+	assert.NoError(t, rows.Close())
+	assert.Equal(t, []employee{
+		{id: 1, name: "scott", salary: sql.NullFloat64{Float64: 1250.5, Valid: true}},
+		{id: 2, name: "tiger"},
+	}, employeeRows)
+
+	var name string
+	assert.NoError(t, db.QueryRow("select name from employee where id = ?", 1).Scan(&name))
+	assert.Equal(t, "scott", name)
+
+	employees.Reset()
+	tx, err := db.Begin()
+	assert.NoError(t, err)
+	result, err := tx.Exec("update employee set name = ? where id = ?", "scott", 1)
+	assert.NoError(t, err)
+	affected, err := result.RowsAffected()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), affected)
+	assert.NoError(t, tx.Commit())
+	assert.Equal(t, []*Statement{
+		{SQL: "START TRANSACTION", InTransaction: true},
+		{SQL: "update employee set name = ? where id = ?", Args: []interface{}{[]byte("scott"), int64(1)}, InTransaction: true},
+		{SQL: "COMMIT", InTransaction: true},
+	}, employees.Statements())
+
+	_, err = db.Exec("delete from employee")
+	assert.ErrorContains(t, err, "table is locked")
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package testsuite
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/pkg/usage"
+)
+
+func TestUsage(t *testing.T) {
+	suite, err := NewFromYAML("usage", []byte(suiteConfig))
+	assert.NoError(t, err)
+	defer suite.Close()
+	suite.DB("employees").Handle(func(statement *Statement) (*Result, error) {
+		return Rows([]string{"id", "name"},
+			[]interface{}{1, "scott"},
+			[]interface{}{2, "tiger"},
+		), nil
+	})
+
+	var before usage.Usage
+	if u, ok := usage.GetUser("dksl"); ok {
+		before = u.Usage
+	}
+	db, err := sql.Open("mysql", suite.DSN(0, "dksl", "123456", "employees"))
+	assert.NoError(t, err)
+	defer db.Close()
+	rows, err := db.Query("SELECT id, name FROM employee")
+	assert.NoError(t, err)
+	var count int
+	for rows.Next() {
+		count++
+	}
+	assert.NoError(t, rows.Close())
+	assert.Equal(t, 2, count)
+
+	// the bytes of a command are accounted after its response is written
+	assert.Eventually(t, func() bool {
+		u, ok := usage.GetUser("dksl")
+		return ok && u.BytesSent > before.BytesSent && u.Statements > before.Statements
+	}, time.Second, 10*time.Millisecond)
+	u, _ := usage.GetUser("dksl")
+	assert.Equal(t, before.Statements+1, u.Statements)
+	assert.Equal(t, before.RowsRead+2, u.RowsRead)
+	assert.Equal(t, before.RowsSent+2, u.RowsSent)
+	assert.Greater(t, u.BytesReceived, before.BytesReceived)
+	assert.Greater(t, u.BackendSeconds, before.BackendSeconds)
+	assert.NotEmpty(t, u.Sessions)
+}
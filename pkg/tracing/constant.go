@@ -37,12 +37,34 @@ const (
 	// branch transaction.
 	BranchTransactionRegister = "branch_transaction_register"
 	BranchTransactionEnd      = "branch_transaction_end"
+	BranchTransactionCommit   = "branch_transaction_commit"
+	BranchTransactionRollback = "branch_transaction_rollback"
+	// BranchTransactionBatchCommit deletes the undo logs of a batch of committed branches
+	BranchTransactionBatchCommit = "branch_transaction_batch_commit"
+
+	// distributed transaction coordinator
+	CoordinatorBegin           = "coordinator_begin"
+	CoordinatorCommit          = "coordinator_commit"
+	CoordinatorRollback        = "coordinator_rollback"
+	CoordinatorBranchRegister  = "coordinator_branch_register"
+	CoordinatorBranchReport    = "coordinator_branch_report"
+	CoordinatorIsLockable      = "coordinator_is_lockable"
+	CoordinatorReleaseLockKeys = "coordinator_release_lock_keys"
 
 	// executor
 	ExecutorFetchBeforeImage = "executor_fetch_before_image"
 	ExecutorFetchAfterImage  = "executor_fetch_after_image"
 	Executable               = "executable"
 
+	// redis command
+	RedisListenerCommand = "redis_listener_command"
+
+	// grpc data api
+	GrpcListenerExecute = "grpc_listener_execute"
+
+	// mysql x protocol
+	MysqlxListenerStmtExecute = "mysqlx_listener_stmt_execute"
+
 	// mysql command
 	MySQLListenerComQuery       = "mysql_listener_com_query"
 	MySQLListenerComStmtExecute = "mysql_listener_com_stmt_execute"
@@ -67,6 +89,7 @@ const (
 	DBExecFieldList         = "db_exec_field_list"
 	DBLocalTransactionBegin = "db_tx_begin"
 	DBXAStart               = "db_xa_start"
+	DBPin                   = "db_pin"
 
 	// group
 	GroupQuery            = "group_query"
@@ -81,6 +104,7 @@ const (
 	TxRollback         = "db_tx_rollback"
 	TxReleaseSavePoint = "db_tx_release_savepoint"
 	TxXAPrepare        = "db_xa_prepare"
+	TxXAComplete       = "db_xa_complete"
 
 	// group tx
 	GroupTxQuery    = "group_tx_query"
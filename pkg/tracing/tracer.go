@@ -125,6 +125,18 @@ func GetTraceSpan(ctx context.Context, spanName string) (context.Context, trace.
 	return otel.Tracer(serviceName).Start(ctx, spanName)
 }
 
+// GetLinkedTraceSpan starts a span for work done asynchronously on behalf of an earlier
+// request, linking it to the spans of that request.
+func GetLinkedTraceSpan(ctx context.Context, spanName string, links ...trace.SpanContext) (context.Context, trace.Span) {
+	var opts []trace.SpanStartOption
+	for _, link := range links {
+		if link.IsValid() {
+			opts = append(opts, trace.WithLinks(trace.Link{SpanContext: link}))
+		}
+	}
+	return otel.Tracer(serviceName).Start(ctx, spanName, opts...)
+}
+
 func BuildContextFromSQLHint(ctx context.Context, stmt ast.Node) context.Context {
 	var traceParent string
 	var flag bool
@@ -0,0 +1,289 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package usage accounts the resources used by the frontend users, for the chargeback of
+// the database platforms shared through dbpack.
+package usage
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uber-go/atomic"
+)
+
+var (
+	userStatements   = newUserCounter("statements_total", "count of the statements executed by the user")
+	userErrors       = newUserCounter("errors_total", "count of the statements of the user which failed")
+	userRowsRead     = newUserCounter("rows_read_total", "count of the rows read from the backends for the user")
+	userRowsSent     = newUserCounter("rows_sent_total", "count of the rows sent to the user")
+	userRowsAffected = newUserCounter("rows_affected_total",
+		"count of the rows affected by the statements of the user")
+	userBytesReceived  = newUserCounter("bytes_received_total", "bytes received from the connections of the user")
+	userBytesSent      = newUserCounter("bytes_sent_total", "bytes sent to the connections of the user")
+	userBackendSeconds = newUserCounter("backend_seconds_total",
+		"time the backends spent executing the statements of the user")
+
+	usersLock sync.RWMutex
+	users     = make(map[string]*user)
+)
+
+func newUserCounter(name, help string) *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "dbpack",
+		Subsystem: "user",
+		Name:      name,
+		Help:      help,
+	}, []string{"user"})
+}
+
+func init() {
+	prometheus.MustRegister(userStatements, userErrors, userRowsRead, userRowsSent, userRowsAffected,
+		userBytesReceived, userBytesSent, userBackendSeconds)
+}
+
+// Usage is the cumulative resource usage of a user or a session.
+type Usage struct {
+	Statements    uint64 `json:"statements"`
+	Errors        uint64 `json:"errors"`
+	RowsRead      uint64 `json:"rows_read"`
+	RowsSent      uint64 `json:"rows_sent"`
+	RowsAffected  uint64 `json:"rows_affected"`
+	BytesReceived uint64 `json:"bytes_received"`
+	BytesSent     uint64 `json:"bytes_sent"`
+	// BackendSeconds is the time the backends spent executing the statements, the backends
+	// of a sharded statement are accounted in full even if they run in parallel
+	BackendSeconds float64 `json:"backend_seconds"`
+}
+
+func (u *Usage) add(other *Usage) {
+	u.Statements += other.Statements
+	u.Errors += other.Errors
+	u.RowsRead += other.RowsRead
+	u.RowsSent += other.RowsSent
+	u.RowsAffected += other.RowsAffected
+	u.BytesReceived += other.BytesReceived
+	u.BytesSent += other.BytesSent
+	u.BackendSeconds += other.BackendSeconds
+}
+
+type keyStatement struct{}
+
+// statement collects the usage of a statement, the backend connections executing the
+// statement add to it, concurrently for the sharded statements.
+type statement struct {
+	session     *Session
+	backendTime *atomic.Int64
+	rowsRead    *atomic.Int64
+}
+
+// WithSession binds the session and a new statement of it to ctx, the usage of the
+// statement is collected by AddBackend and accounted by Record.
+func WithSession(ctx context.Context, session *Session) context.Context {
+	return context.WithValue(ctx, keyStatement{}, &statement{
+		session:     session,
+		backendTime: atomic.NewInt64(0),
+		rowsRead:    atomic.NewInt64(0),
+	})
+}
+
+// AddBackend adds the time a backend spent executing the statement bound to ctx, and the
+// rows read from it.
+func AddBackend(ctx context.Context, duration time.Duration, rowsRead int) {
+	stmt, ok := ctx.Value(keyStatement{}).(*statement)
+	if !ok {
+		return
+	}
+	stmt.backendTime.Add(int64(duration))
+	stmt.rowsRead.Add(int64(rowsRead))
+}
+
+// Record accounts the statement bound to ctx to its session, err is the error returned to
+// the client.
+func Record(ctx context.Context, rowsSent, rowsAffected uint64, err error) {
+	stmt, ok := ctx.Value(keyStatement{}).(*statement)
+	if !ok {
+		return
+	}
+	stmt.session.record(stmt, rowsSent, rowsAffected, err)
+}
+
+// Session accounts the usage of a client connection to the usage of its user.
+type Session struct {
+	connectionID uint32
+	remoteAddr   string
+	since        time.Time
+	user         *user
+
+	lock  sync.Mutex
+	usage Usage
+}
+
+// SessionUsage is the usage of an open session.
+type SessionUsage struct {
+	ConnectionID uint32    `json:"connection_id"`
+	RemoteAddr   string    `json:"remote_addr"`
+	Since        time.Time `json:"since"`
+	Usage
+}
+
+// UserUsage is the cumulative usage of a user, since dbpack started.
+type UserUsage struct {
+	User           string `json:"user"`
+	ActiveSessions int    `json:"active_sessions"`
+	Usage
+	// Sessions are the open sessions of the user, only returned by GetUser
+	Sessions []*SessionUsage `json:"sessions,omitempty"`
+}
+
+type user struct {
+	name string
+
+	lock     sync.Mutex
+	usage    Usage
+	sessions map[*Session]struct{}
+}
+
+// OpenSession starts accounting the usage of a client connection of userName.
+func OpenSession(connectionID uint32, userName, remoteAddr string) *Session {
+	usersLock.Lock()
+	u, ok := users[userName]
+	if !ok {
+		u = &user{name: userName, sessions: make(map[*Session]struct{})}
+		users[userName] = u
+	}
+	usersLock.Unlock()
+
+	s := &Session{connectionID: connectionID, remoteAddr: remoteAddr, since: time.Now(), user: u}
+	u.lock.Lock()
+	u.sessions[s] = struct{}{}
+	u.lock.Unlock()
+	return s
+}
+
+// Close stops accounting the session, its usage stays in the usage of its user.
+func (s *Session) Close() {
+	s.user.lock.Lock()
+	delete(s.user.sessions, s)
+	s.user.lock.Unlock()
+}
+
+func (s *Session) record(stmt *statement, rowsSent, rowsAffected uint64, err error) {
+	usage := &Usage{
+		Statements:     1,
+		RowsRead:       uint64(stmt.rowsRead.Load()),
+		RowsSent:       rowsSent,
+		RowsAffected:   rowsAffected,
+		BackendSeconds: time.Duration(stmt.backendTime.Load()).Seconds(),
+	}
+	if err != nil {
+		usage.Errors = 1
+	}
+	s.add(usage)
+
+	name := s.user.name
+	userStatements.WithLabelValues(name).Inc()
+	if err != nil {
+		userErrors.WithLabelValues(name).Inc()
+	}
+	userRowsRead.WithLabelValues(name).Add(float64(usage.RowsRead))
+	userRowsSent.WithLabelValues(name).Add(float64(rowsSent))
+	userRowsAffected.WithLabelValues(name).Add(float64(rowsAffected))
+	userBackendSeconds.WithLabelValues(name).Add(usage.BackendSeconds)
+}
+
+// AddBytes accounts the bytes received from and sent to the client of the session.
+func (s *Session) AddBytes(received, sent uint64) {
+	if received == 0 && sent == 0 {
+		return
+	}
+	s.add(&Usage{BytesReceived: received, BytesSent: sent})
+	userBytesReceived.WithLabelValues(s.user.name).Add(float64(received))
+	userBytesSent.WithLabelValues(s.user.name).Add(float64(sent))
+}
+
+func (s *Session) add(usage *Usage) {
+	s.lock.Lock()
+	s.usage.add(usage)
+	s.lock.Unlock()
+	s.user.lock.Lock()
+	s.user.usage.add(usage)
+	s.user.lock.Unlock()
+}
+
+// Usage returns the usage of the session.
+func (s *Session) Usage() *SessionUsage {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return &SessionUsage{ConnectionID: s.connectionID, RemoteAddr: s.remoteAddr, Since: s.since, Usage: s.usage}
+}
+
+// Users returns the usage of all the users, ordered by user name.
+func Users() []*UserUsage {
+	usersLock.RLock()
+	result := make([]*UserUsage, 0, len(users))
+	for _, u := range users {
+		u.lock.Lock()
+		result = append(result, &UserUsage{User: u.name, ActiveSessions: len(u.sessions), Usage: u.usage})
+		u.lock.Unlock()
+	}
+	usersLock.RUnlock()
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].User < result[j].User
+	})
+	return result
+}
+
+// Cumulative returns the cumulative usage of a user, it is cheaper than GetUser for checking
+// the usage on each statement.
+func Cumulative(name string) (Usage, bool) {
+	usersLock.RLock()
+	u, ok := users[name]
+	usersLock.RUnlock()
+	if !ok {
+		return Usage{}, false
+	}
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return u.usage, true
+}
+
+// GetUser returns the usage of the user with its open sessions.
+func GetUser(name string) (*UserUsage, bool) {
+	usersLock.RLock()
+	u, ok := users[name]
+	usersLock.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	u.lock.Lock()
+	result := &UserUsage{User: u.name, ActiveSessions: len(u.sessions), Usage: u.usage}
+	sessions := make([]*Session, 0, len(u.sessions))
+	for s := range u.sessions {
+		sessions = append(sessions, s)
+	}
+	u.lock.Unlock()
+	for _, s := range sessions {
+		result.Sessions = append(result.Sessions, s.Usage())
+	}
+	sort.Slice(result.Sessions, func(i, j int) bool {
+		return result.Sessions[i].Since.Before(result.Sessions[j].Since)
+	})
+	return result, true
+}
@@ -0,0 +1,83 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package usage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSession(t *testing.T) {
+	first := OpenSession(1, "usage_test", "127.0.0.1:3306")
+	second := OpenSession(2, "usage_test", "127.0.0.1:3307")
+
+	ctx := WithSession(context.Background(), first)
+	AddBackend(ctx, time.Second, 3)
+	AddBackend(ctx, 500*time.Millisecond, 2)
+	Record(ctx, 5, 0, nil)
+	first.AddBytes(100, 1000)
+
+	ctx = WithSession(context.Background(), second)
+	Record(ctx, 0, 2, errors.New("duplicate entry"))
+	second.AddBytes(50, 10)
+
+	// a statement without a session, such as one of a backend health check, is not accounted
+	AddBackend(context.Background(), time.Second, 1)
+	Record(context.Background(), 1, 0, nil)
+
+	result, ok := GetUser("usage_test")
+	assert.True(t, ok)
+	assert.Equal(t, 2, result.ActiveSessions)
+	assert.Equal(t, Usage{
+		Statements:     2,
+		Errors:         1,
+		RowsRead:       5,
+		RowsSent:       5,
+		RowsAffected:   2,
+		BytesReceived:  150,
+		BytesSent:      1010,
+		BackendSeconds: 1.5,
+	}, result.Usage)
+	assert.Len(t, result.Sessions, 2)
+	assert.Equal(t, uint32(1), result.Sessions[0].ConnectionID)
+	assert.Equal(t, uint64(1000), result.Sessions[0].BytesSent)
+	assert.Equal(t, uint64(1), result.Sessions[1].Errors)
+
+	first.Close()
+	second.Close()
+	result, ok = GetUser("usage_test")
+	assert.True(t, ok)
+	assert.Equal(t, 0, result.ActiveSessions)
+	assert.Empty(t, result.Sessions)
+	assert.Equal(t, uint64(2), result.Statements)
+
+	var found bool
+	for _, u := range Users() {
+		if u.User == "usage_test" {
+			found = true
+			assert.Nil(t, u.Sessions)
+		}
+	}
+	assert.True(t, found)
+
+	_, ok = GetUser("usage_test_unknown")
+	assert.False(t, ok)
+}
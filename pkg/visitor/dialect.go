@@ -0,0 +1,96 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package visitor
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/cectc/dbpack/third_party/parser/ast"
+)
+
+const (
+	// DialectMySQL57 accepts the syntax supported by MySQL 5.7
+	DialectMySQL57 = "5.7"
+	// DialectMySQL80 accepts the syntax supported by MySQL 8.0
+	DialectMySQL80 = "8.0"
+)
+
+// ErrUnsupportedSyntax is the cause of the errors reported by DialectVisitor.
+var ErrUnsupportedSyntax = errors.New("syntax not supported by the sql dialect")
+
+// ResolveDialect returns the configured dialect, falling back to the one implied by the server version.
+func ResolveDialect(dialect, serverVersion string) (string, error) {
+	switch dialect {
+	case DialectMySQL57, DialectMySQL80:
+		return dialect, nil
+	case "":
+		if strings.HasPrefix(serverVersion, "5.") {
+			return DialectMySQL57, nil
+		}
+		return DialectMySQL80, nil
+	default:
+		return "", errors.Errorf("unsupported sql dialect '%s', must be one of %s, %s",
+			dialect, DialectMySQL57, DialectMySQL80)
+	}
+}
+
+// DialectVisitor rejects syntax that the backend of the given dialect does not accept,
+// such as CTEs, window functions and expression defaults on MySQL 5.7.
+type DialectVisitor struct {
+	Dialect string
+	Err     error
+}
+
+func (v *DialectVisitor) Enter(in ast.Node) (out ast.Node, skipChildren bool) {
+	if v.Dialect != DialectMySQL57 || v.Err != nil {
+		return in, true
+	}
+	switch node := in.(type) {
+	case *ast.WithClause:
+		v.Err = errors.Wrapf(ErrUnsupportedSyntax, "common table expressions are not supported by mysql %s", v.Dialect)
+	case *ast.WindowFuncExpr:
+		v.Err = errors.Wrapf(ErrUnsupportedSyntax, "window function %s is not supported by mysql %s", node.F, v.Dialect)
+	case *ast.ColumnOption:
+		if node.Tp == ast.ColumnOptionDefaultValue && !isLiteralDefault(node.Expr) {
+			v.Err = errors.Wrapf(ErrUnsupportedSyntax, "expression default values are not supported by mysql %s", v.Dialect)
+		}
+	}
+	return in, v.Err != nil
+}
+
+func (v *DialectVisitor) Leave(in ast.Node) (out ast.Node, ok bool) {
+	return in, true
+}
+
+// isLiteralDefault reports whether expr is a default value accepted before MySQL 8.0.13,
+// which only allows literals and CURRENT_TIMESTAMP.
+func isLiteralDefault(expr ast.ExprNode) bool {
+	switch e := expr.(type) {
+	case ast.ValueExpr:
+		return true
+	case *ast.UnaryOperationExpr:
+		return isLiteralDefault(e.V)
+	case *ast.FuncCallExpr:
+		switch e.FnName.L {
+		case ast.CurrentTimestamp, ast.Now, ast.LocalTime, ast.LocalTimestamp:
+			return true
+		}
+	}
+	return false
+}
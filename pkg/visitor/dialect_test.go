@@ -0,0 +1,69 @@
+/*
+ * Copyright 2022 CECTC, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package visitor
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cectc/dbpack/third_party/parser"
+	_ "github.com/cectc/dbpack/third_party/types/parser_driver"
+)
+
+func TestResolveDialect(t *testing.T) {
+	dialect, err := ResolveDialect("", "5.7.36-log")
+	assert.Nil(t, err)
+	assert.Equal(t, DialectMySQL57, dialect)
+	dialect, err = ResolveDialect("", "8.0.27")
+	assert.Nil(t, err)
+	assert.Equal(t, DialectMySQL80, dialect)
+	dialect, err = ResolveDialect(DialectMySQL57, "8.0.27")
+	assert.Nil(t, err)
+	assert.Equal(t, DialectMySQL57, dialect)
+	_, err = ResolveDialect("5.6", "")
+	assert.NotNil(t, err)
+}
+
+func TestDialectVisitor(t *testing.T) {
+	testCases := []struct {
+		sql         string
+		unsupported bool
+	}{
+		{"SELECT id, name FROM t WHERE id = 1", false},
+		{"WITH cte AS (SELECT id FROM t) SELECT * FROM cte", true},
+		{"SELECT id, ROW_NUMBER() OVER (ORDER BY id) FROM t", true},
+		{"CREATE TABLE t (id INT, c INT DEFAULT -1, d DATETIME DEFAULT CURRENT_TIMESTAMP)", false},
+		{"CREATE TABLE t (id INT, c DOUBLE DEFAULT (RAND() * RAND()))", true},
+	}
+	for _, c := range testCases {
+		t.Run(c.sql, func(t *testing.T) {
+			p := parser.New()
+			stmt, err := p.ParseOneStmt(c.sql, "", "")
+			assert.Nil(t, err)
+
+			v := &DialectVisitor{Dialect: DialectMySQL80}
+			stmt.Accept(v)
+			assert.Nil(t, v.Err)
+
+			v = &DialectVisitor{Dialect: DialectMySQL57}
+			stmt.Accept(v)
+			assert.Equal(t, c.unsupported, errors.Cause(v.Err) == ErrUnsupportedSyntax)
+		})
+	}
+}
@@ -40,6 +40,8 @@ func (v *ParamVisitor) Leave(in ast.Node) (out ast.Node, ok bool) {
 type FuncColumn struct {
 	FuncName    string
 	ColumnIndex int
+	// CountColumnIndex is the index of the hidden COUNT column appended to calculate AVG across shards
+	CountColumnIndex int
 }
 
 type FuncVisitor struct {
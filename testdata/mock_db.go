@@ -280,6 +280,20 @@ func (mr *MockDBMockRecorder) IsMaster() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsMaster", reflect.TypeOf((*MockDB)(nil).IsMaster))
 }
 
+// IsPassthrough mocks base method.
+func (m *MockDB) IsPassthrough() bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsPassthrough")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// IsPassthrough indicates an expected call of IsPassthrough.
+func (mr *MockDBMockRecorder) IsPassthrough() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsPassthrough", reflect.TypeOf((*MockDB)(nil).IsPassthrough))
+}
+
 // MasterName mocks base method.
 func (m *MockDB) MasterName() string {
 	m.ctrl.T.Helper()
@@ -322,6 +336,21 @@ func (mr *MockDBMockRecorder) Name() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Name", reflect.TypeOf((*MockDB)(nil).Name))
 }
 
+// Pin mocks base method.
+func (m *MockDB) Pin(arg0 context.Context) (proto.Tx, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Pin", arg0)
+	ret0, _ := ret[0].(proto.Tx)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Pin indicates an expected call of Pin.
+func (mr *MockDBMockRecorder) Pin(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Pin", reflect.TypeOf((*MockDB)(nil).Pin), arg0)
+}
+
 // Ping mocks base method.
 func (m *MockDB) Ping() error {
 	m.ctrl.T.Helper()
@@ -432,6 +461,18 @@ func (mr *MockDBMockRecorder) SetIdleTimeout(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetIdleTimeout", reflect.TypeOf((*MockDB)(nil).SetIdleTimeout), arg0)
 }
 
+// SetPassthrough mocks base method.
+func (m *MockDB) SetPassthrough(arg0 bool) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SetPassthrough", arg0)
+}
+
+// SetPassthrough indicates an expected call of SetPassthrough.
+func (mr *MockDBMockRecorder) SetPassthrough(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetPassthrough", reflect.TypeOf((*MockDB)(nil).SetPassthrough), arg0)
+}
+
 // SetReadWeight mocks base method.
 func (m *MockDB) SetReadWeight(arg0 int) {
 	m.ctrl.T.Helper()
@@ -51,6 +51,20 @@ func (m *MockDBManager) EXPECT() *MockDBManagerMockRecorder {
 	return m.recorder
 }
 
+// DBs mocks base method.
+func (m *MockDBManager) DBs() []proto.DB {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DBs")
+	ret0, _ := ret[0].([]proto.DB)
+	return ret0
+}
+
+// DBs indicates an expected call of DBs.
+func (mr *MockDBManagerMockRecorder) DBs() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DBs", reflect.TypeOf((*MockDBManager)(nil).DBs))
+}
+
 // GetDB mocks base method.
 func (m *MockDBManager) GetDB(arg0 string) proto.DB {
 	m.ctrl.T.Helper()
@@ -188,6 +188,21 @@ func (mr *MockTxMockRecorder) Rollback(arg0, arg1 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Rollback", reflect.TypeOf((*MockTx)(nil).Rollback), arg0, arg1)
 }
 
+// XAComplete mocks base method.
+func (m *MockTx) XAComplete(arg0 context.Context, arg1 string) (proto.Result, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "XAComplete", arg0, arg1)
+	ret0, _ := ret[0].(proto.Result)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// XAComplete indicates an expected call of XAComplete.
+func (mr *MockTxMockRecorder) XAComplete(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "XAComplete", reflect.TypeOf((*MockTx)(nil).XAComplete), arg0, arg1)
+}
+
 // XAPrepare mocks base method.
 func (m *MockTx) XAPrepare(arg0 context.Context, arg1 string) (proto.Result, error) {
 	m.ctrl.T.Helper()
@@ -133,8 +133,8 @@ func (environment *ShardingTestEnvironment) RegisterDBResource(t *testing.T) {
 			PingTimesForChangeStatus: 3,
 			Filters:                  nil,
 		},
-	}, func(dbName, dsn string) pools.Factory {
-		collector, err := driver.NewConnector(dbName, dsn)
+	}, func(dataSource *config.DataSource) pools.Factory {
+		collector, err := driver.NewConnector(dataSource.Name, dataSource.DSN, dataSource.TCP)
 		if err != nil {
 			t.Fatal(err)
 		}
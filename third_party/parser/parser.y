@@ -668,6 +668,7 @@ import (
 	primaryRegion         "PRIMARY_REGION"
 	recent                "RECENT"
 	recreator             "RECREATOR"
+	route                 "ROUTE"
 	running               "RUNNING"
 	s3                    "S3"
 	schedule              "SCHEDULE"
@@ -3399,6 +3400,10 @@ DefaultValueExpr:
 	NowSymOptionFraction
 |	SignedLiteral
 |	NextValueForSequence
+|	'(' Expression ')'
+	{
+		$$ = &ast.ParenthesesExpr{Expr: $2}
+	}
 
 NowSymOptionFraction:
 	NowSym
@@ -4682,6 +4687,13 @@ ExplainStmt:
 			Analyze: true,
 		}
 	}
+|	ExplainSym "ROUTE" ExplainableStmt
+	{
+		$$ = &ast.ExplainStmt{
+			Stmt:   $3,
+			Format: "route",
+		}
+	}
 
 ExplainFormatType:
 	"TRADITIONAL"
@@ -6175,6 +6187,7 @@ NotKeywordToken:
 |	"NOW"
 |	"RECENT"
 |	"RECREATOR"
+|	"ROUTE"
 |	"RUNNING"
 |	"PLACEMENT"
 |	"PLAN"